@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleListInteractionFlags returns anomaly flags raised against users'
+// interaction patterns, most recently seen first. ?status= filters by open
+// (default), dismissed, confirmed, or all.
+func (h *Handler) HandleListInteractionFlags(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	query := `
+		SELECT f.id, f.user_id, u.username, f.reason, f.details, f.hits, f.status,
+		       f.created_at, f.last_seen_at, f.resolved_at
+		FROM interaction_flags f JOIN users u ON f.user_id = u.id`
+	var args []interface{}
+	switch status {
+	case "all":
+	case "open", "dismissed", "confirmed":
+		query += ` WHERE f.status = ?`
+		args = append(args, status)
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "status must be open, dismissed, confirmed, or all"})
+		return
+	}
+	query += ` ORDER BY f.last_seen_at DESC LIMIT 200`
+
+	rows, err := h.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list interaction flags"})
+		return
+	}
+	defer rows.Close()
+
+	flags := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, userID, username, reason, details, flagStatus string
+		var hits int
+		var createdAt, lastSeenAt, resolvedAt *string
+		if err := rows.Scan(&id, &userID, &username, &reason, &details, &hits, &flagStatus,
+			&createdAt, &lastSeenAt, &resolvedAt); err != nil {
+			continue
+		}
+		flags = append(flags, map[string]interface{}{
+			"id": id, "user_id": userID, "username": username, "reason": reason,
+			"details": details, "hits": hits, "status": flagStatus,
+			"created_at": createdAt, "last_seen_at": lastSeenAt, "resolved_at": resolvedAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"flags": flags})
+}
+
+// HandleResolveInteractionFlag dismisses or confirms a flag. Confirmed users'
+// interactions are excluded from content score recomputation; reopening a
+// flag clears its resolution.
+func (h *Handler) HandleResolveInteractionFlag(w http.ResponseWriter, r *http.Request) {
+	flagID := chi.URLParam(r, "id")
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	resolvedAt := h.DB.NowUTC()
+	switch req.Status {
+	case "dismissed", "confirmed":
+	case "open":
+		resolvedAt = "NULL"
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "status must be open, dismissed, or confirmed"})
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(
+		`UPDATE interaction_flags SET status = ?, resolved_at = %s WHERE id = ?`, resolvedAt),
+		req.Status, flagID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update interaction flag"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "interaction flag not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"id": flagID, "status": req.Status})
+}
@@ -16,17 +16,25 @@ import (
 	"clipfeed/httputil"
 
 	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
 )
 
+const maxPasswordLen = 72 // bcrypt truncates at 72 bytes
+
 // Handler holds dependencies for admin endpoints.
 type Handler struct {
 	DB             *db.CompatDB
 	AdminUsername   string
 	AdminPassword  string
 	AdminJWTSecret string
+
+	// RankingStatus reports ranking-subsystem health for the status page;
+	// when nil the section is omitted.
+	RankingStatus func(ctx context.Context) map[string]interface{}
 }
 
-// HandleAdminLogin authenticates an admin user and returns a JWT.
+// HandleAdminLogin authenticates the configured admin account, or a user
+// holding an admin role with their own password, and returns a JWT.
 func (h *Handler) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
@@ -39,14 +47,20 @@ func (h *Handler) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
 
 	usernameOK := subtle.ConstantTimeCompare([]byte(req.Username), []byte(h.AdminUsername)) == 1
 	passwordOK := subtle.ConstantTimeCompare([]byte(req.Password), []byte(h.AdminPassword)) == 1
+	subject, role := "admin", RoleOwner
 	if !usernameOK || !passwordOK {
-		httputil.WriteJSON(w, 401, map[string]string{"error": "invalid credentials"})
-		return
+		var ok bool
+		subject, role, ok = h.roleLogin(r.Context(), req.Username, req.Password)
+		if !ok {
+			httputil.WriteJSON(w, 401, map[string]string{"error": "invalid credentials"})
+			return
+		}
 	}
 
 	claims := jwt.MapClaims{
-		"sub":   "admin",
+		"sub":   subject,
 		"admin": true,
+		"role":  string(role),
 		"exp":   time.Now().Add(24 * time.Hour).Unix(),
 		"iat":   time.Now().Unix(),
 	}
@@ -57,14 +71,43 @@ func (h *Handler) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	httputil.WriteJSON(w, 200, map[string]string{"token": tokenStr})
+	httputil.WriteJSON(w, 200, map[string]string{"token": tokenStr, "role": string(role)})
 }
 
-// IsAdminToken validates the Bearer JWT and checks the admin:true claim.
+// roleLogin checks the credentials of a user holding an admin role and
+// returns their ID and role.
+func (h *Handler) roleLogin(ctx context.Context, username, password string) (string, Role, bool) {
+	if len(password) > maxPasswordLen {
+		return "", "", false
+	}
+	var userID, hash string
+	var role *string
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT id, password_hash, admin_role FROM users WHERE username = ? OR email = ?`,
+		username, username,
+	).Scan(&userID, &hash, &role); err != nil || role == nil {
+		return "", "", false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", "", false
+	}
+	return userID, Role(*role), true
+}
+
+// IsAdminToken validates the Bearer JWT, checks the admin:true claim, and
+// that a user it was issued to still holds an admin role.
 func (h *Handler) IsAdminToken(r *http.Request) bool {
+	_, _, ok := h.adminFromToken(r)
+	return ok
+}
+
+// adminFromToken returns who the request's admin token belongs to, "admin"
+// for the configured account or a user ID, and their role. A user's role is
+// read fresh on every request, so taking it away locks them out at once.
+func (h *Handler) adminFromToken(r *http.Request) (string, Role, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return false
+		return "", "", false
 	}
 	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
@@ -74,29 +117,45 @@ func (h *Handler) IsAdminToken(r *http.Request) bool {
 		return []byte(h.AdminJWTSecret), nil
 	})
 	if err != nil || !token.Valid {
-		return false
+		return "", "", false
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return false
+		return "", "", false
+	}
+	if isAdmin, _ := claims["admin"].(bool); !isAdmin {
+		return "", "", false
 	}
-	isAdmin, _ := claims["admin"].(bool)
-	return isAdmin
+	subject, _ := claims["sub"].(string)
+	if subject == "admin" {
+		return subject, RoleOwner, true
+	}
+	var role *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT admin_role FROM users WHERE id = ?`, subject).Scan(&role); err != nil || role == nil {
+		return "", "", false
+	}
+	return subject, Role(*role), true
 }
 
-// AdminAuthMiddleware protects admin endpoints.
+// AdminAuthMiddleware protects admin endpoints. Handlers see the admin as
+// the request's user: "admin" for the configured account, or the user ID of
+// a user holding a role. Use Require to limit a route to a permission.
 func (h *Handler) AdminAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !h.IsAdminToken(r) {
+		actor, role, ok := h.adminFromToken(r)
+		if !ok {
 			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
 			return
 		}
-		ctx := context.WithValue(r.Context(), auth.UserIDKey, "admin")
+		ctx := context.WithValue(r.Context(), auth.UserIDKey, actor)
+		ctx = context.WithValue(ctx, roleKey, role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// HandleAdminStatus returns system, database, content, queue, and AI stats.
+// HandleAdminStatus returns system, database, content, queue, AI, and
+// ranking stats.
 func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
@@ -110,31 +169,33 @@ func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	var totalUsers, totalInteractions int
+	var totalUsers, totalInteractions, openFlags int
 	var dbSizeMB float64
-	var readyClips, processingClips, failedClips, expiredClips, evictedClips int
+	var readyClips, processingClips, failedClips, expiredClips, evictedClips, brokenClips int
 	var totalBytes int64
 	var queuedJobs, runningJobs, completeJobs, failedJobs int
 	var rejectedJobs int
 
-	if err := h.DB.QueryRow(fmt.Sprintf(`
+	if err := h.DB.QueryRowContext(r.Context(), fmt.Sprintf(`
 		SELECT
 			(SELECT COUNT(*) FROM users),
 			(SELECT COUNT(*) FROM interactions),
+			(SELECT COUNT(*) FROM interaction_flags WHERE status = 'open'),
 			%s,
 			(SELECT COUNT(*) FROM clips WHERE status = 'ready'),
 			(SELECT COUNT(*) FROM clips WHERE status = 'processing'),
 			(SELECT COUNT(*) FROM clips WHERE status = 'failed'),
 			(SELECT COUNT(*) FROM clips WHERE status = 'expired'),
 			(SELECT COUNT(*) FROM clips WHERE status = 'evicted'),
+			(SELECT COUNT(*) FROM clips WHERE status = 'broken'),
 			(SELECT COALESCE(SUM(file_size_bytes), 0) FROM clips WHERE status = 'ready'),
 			(SELECT COUNT(*) FROM jobs WHERE status = 'queued'),
 			(SELECT COUNT(*) FROM jobs WHERE status = 'running'),
 			(SELECT COUNT(*) FROM jobs WHERE status = 'complete'),
 			(SELECT COUNT(*) FROM jobs WHERE status = 'failed'),
 			(SELECT COUNT(*) FROM jobs WHERE status = 'rejected')
-	`, h.DB.DBSizeExpr())).Scan(&totalUsers, &totalInteractions, &dbSizeMB,
-		&readyClips, &processingClips, &failedClips, &expiredClips, &evictedClips, &totalBytes,
+	`, h.DB.DBSizeExpr())).Scan(&totalUsers, &totalInteractions, &openFlags, &dbSizeMB,
+		&readyClips, &processingClips, &failedClips, &expiredClips, &evictedClips, &brokenClips, &totalBytes,
 		&queuedJobs, &runningJobs, &completeJobs, &failedJobs, &rejectedJobs); err != nil {
 		log.Printf("admin status: stats query failed: %v", err)
 	}
@@ -142,11 +203,13 @@ func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 	stats["database"] = map[string]interface{}{
 		"total_users":        totalUsers,
 		"total_interactions": totalInteractions,
+		"open_flags":         openFlags,
 		"size_mb":            dbSizeMB,
 	}
 	stats["content"] = map[string]interface{}{
 		"ready": readyClips, "processing": processingClips,
 		"failed": failedClips, "expired": expiredClips, "evicted": evictedClips,
+		"broken": brokenClips,
 		"storage_gb": float64(totalBytes) / (1024 * 1024 * 1024),
 	}
 	stats["queue"] = map[string]interface{}{
@@ -159,7 +222,7 @@ func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 		Count int    `json:"count"`
 	}
 	fetchDailyStats := func(query string) []DailyStat {
-		rows, err := h.DB.Query(query)
+		rows, err := h.DB.QueryContext(r.Context(), query)
 		if err != nil {
 			return []DailyStat{}
 		}
@@ -188,7 +251,7 @@ func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 
 	var totalSummaries, evaluatedCandidates, approvedCandidates int
 	var avgScore float64
-	if err := h.DB.QueryRow(`
+	if err := h.DB.QueryRowContext(r.Context(), `
 		SELECT
 			(SELECT COUNT(*) FROM clip_summaries),
 			(SELECT COUNT(*) FROM scout_candidates WHERE llm_score IS NOT NULL),
@@ -212,7 +275,7 @@ func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 		Attempts int     `json:"attempts"`
 		FailedAt *string `json:"failed_at"`
 	}
-	failedRows, err := h.DB.Query(`
+	failedRows, err := h.DB.QueryContext(r.Context(), `
 		SELECT j.id, s.title, s.url, j.error, j.attempts, j.completed_at
 		FROM jobs j LEFT JOIN sources s ON j.source_id = s.id
 		WHERE j.status = 'failed'
@@ -230,12 +293,16 @@ func (h *Handler) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	stats["recent_failures"] = recentFailed
 
+	if h.RankingStatus != nil {
+		stats["ranking"] = h.RankingStatus(r.Context())
+	}
+
 	httputil.WriteJSON(w, 200, stats)
 }
 
 // HandleClearFailedJobs purges stale failed/rejected jobs and clears remaining.
 func (h *Handler) HandleClearFailedJobs(w http.ResponseWriter, r *http.Request) {
-	purged, _ := h.DB.Exec(fmt.Sprintf(`
+	purged, _ := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
 		DELETE FROM jobs
 		WHERE (status = 'failed' AND attempts >= max_attempts AND %s)
 		   OR (status = 'rejected' AND %s)
@@ -247,7 +314,7 @@ func (h *Handler) HandleClearFailedJobs(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	_, err := h.DB.Exec(`
+	_, err := h.DB.ExecContext(r.Context(), `
 		UPDATE sources SET status = 'pending'
 		WHERE id IN (SELECT source_id FROM jobs WHERE status = 'failed' AND source_id IS NOT NULL)
 	`)
@@ -255,7 +322,7 @@ func (h *Handler) HandleClearFailedJobs(w http.ResponseWriter, r *http.Request)
 		log.Printf("admin clear-failed: source reset error: %v", err)
 	}
 
-	result, err := h.DB.Exec(`DELETE FROM jobs WHERE status = 'failed'`)
+	result, err := h.DB.ExecContext(r.Context(), `DELETE FROM jobs WHERE status = 'failed'`)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to clear jobs"})
 		return
@@ -267,7 +334,7 @@ func (h *Handler) HandleClearFailedJobs(w http.ResponseWriter, r *http.Request)
 
 // HandleAdminLLMLogs returns recent LLM log entries.
 func (h *Handler) HandleAdminLLMLogs(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.DB.Query(`
+	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT id, system, model, prompt, COALESCE(response, ''), COALESCE(error, ''), duration_ms, created_at
 		FROM llm_logs ORDER BY created_at DESC LIMIT 100
 	`)
@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"clipfeed/httputil"
+	"clipfeed/invites"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleListInvites lists invite codes with who issued and who redeemed
+// each, newest first. ?status=unused|used|expired narrows the list.
+func (h *Handler) HandleListInvites(w http.ResponseWriter, r *http.Request) {
+	var where string
+	switch r.URL.Query().Get("status") {
+	case "", "all":
+	case "unused":
+		where = fmt.Sprintf(`WHERE i.used_at IS NULL AND (i.expires_at IS NULL OR i.expires_at > %s)`, h.DB.NowUTC())
+	case "used":
+		where = `WHERE i.used_at IS NOT NULL`
+	case "expired":
+		where = fmt.Sprintf(`WHERE i.used_at IS NULL AND i.expires_at <= %s`, h.DB.NowUTC())
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "status must be unused, used, expired, or all"})
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT i.code, COALESCE(i.note, ''), COALESCE(i.created_at, ''), i.expires_at,
+		       issuer.username, i.invite_quota, i.daily_ingest_quota, i.used_at, invitee.username
+		FROM invite_codes i
+		LEFT JOIN users issuer ON issuer.id = i.created_by
+		LEFT JOIN users invitee ON invitee.id = i.used_by
+		`+where+`
+		ORDER BY i.created_at DESC, i.code
+	`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list invites"})
+		return
+	}
+	defer rows.Close()
+
+	list := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var code, note, createdAt string
+		var expiresAt, createdBy, usedAt, usedBy *string
+		var inviteQuota int
+		var dailyIngestQuota *int
+		if err := rows.Scan(&code, &note, &createdAt, &expiresAt, &createdBy,
+			&inviteQuota, &dailyIngestQuota, &usedAt, &usedBy); err != nil {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"code": code, "note": note, "created_at": createdAt, "expires_at": expiresAt,
+			// created_by is null for invites issued by an admin.
+			"created_by":         createdBy,
+			"invite_quota":       inviteQuota,
+			"daily_ingest_quota": dailyIngestQuota,
+			"used_at":            usedAt,
+			"used_by":            usedBy,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"invites": list})
+}
+
+// HandleCreateInvite issues a single-use invite code. The optional body sets
+// a note, expires_in_hours (never expires without it), and the quotas the
+// invited account starts with: invite_quota and daily_ingest_quota.
+func (h *Handler) HandleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	req, err := invites.DecodeRequest(r)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	code, expiresAt, err := invites.Create(r.Context(), h.DB, "", req.Options())
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create invite"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"code": code, "note": req.Note, "expires_at": expiresAt,
+		"invite_quota": req.InviteQuota, "daily_ingest_quota": req.DailyIngestQuota,
+	})
+}
+
+// HandleDeleteInvite revokes an invite code. Used codes are kept so the
+// record of who they admitted survives; only unused codes can be deleted.
+func (h *Handler) HandleDeleteInvite(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM invite_codes WHERE code = ? AND used_at IS NULL`, chi.URLParam(r, "code"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete invite"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "unused invite not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
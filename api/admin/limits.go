@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleListPlatformLimits returns per-platform concurrency caps alongside
+// current running and queued job counts.
+func (h *Handler) HandleListPlatformLimits(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT p.platform,
+		       (SELECT max_concurrent FROM platform_limits WHERE platform = p.platform),
+		       (SELECT COUNT(*) FROM jobs j JOIN sources s ON j.source_id = s.id
+		        WHERE j.status = 'running' AND s.platform = p.platform),
+		       (SELECT COUNT(*) FROM jobs j JOIN sources s ON j.source_id = s.id
+		        WHERE j.status = 'queued' AND s.platform = p.platform)
+		FROM (
+			SELECT platform FROM platform_limits
+			UNION
+			SELECT DISTINCT s.platform FROM jobs j JOIN sources s ON j.source_id = s.id
+			WHERE j.status IN ('queued', 'running') AND s.platform IS NOT NULL
+		) p
+		ORDER BY p.platform
+	`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list platform limits"})
+		return
+	}
+	defer rows.Close()
+
+	limits := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var platform string
+		var maxConcurrent *int
+		var running, queued int
+		if err := rows.Scan(&platform, &maxConcurrent, &running, &queued); err != nil {
+			continue
+		}
+		limits = append(limits, map[string]interface{}{
+			"platform":       platform,
+			"max_concurrent": maxConcurrent,
+			"running":        running,
+			"queued":         queued,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"limits": limits})
+}
+
+// HandleSetPlatformLimit sets the concurrency cap for a platform.
+func (h *Handler) HandleSetPlatformLimit(w http.ResponseWriter, r *http.Request) {
+	platform := strings.ToLower(chi.URLParam(r, "platform"))
+
+	var req struct {
+		MaxConcurrent int `json:"max_concurrent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxConcurrent < 1 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "max_concurrent must be a positive integer"})
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO platform_limits (platform, max_concurrent, updated_at) VALUES (?, ?, %s)
+		ON CONFLICT(platform) DO UPDATE SET
+			max_concurrent = excluded.max_concurrent,
+			updated_at     = excluded.updated_at
+	`, h.DB.NowUTC()), platform, req.MaxConcurrent); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save platform limit"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"platform": platform, "max_concurrent": req.MaxConcurrent})
+}
+
+// HandleDeletePlatformLimit removes a platform's cap, leaving it unlimited.
+func (h *Handler) HandleDeletePlatformLimit(w http.ResponseWriter, r *http.Request) {
+	platform := strings.ToLower(chi.URLParam(r, "platform"))
+	if _, err := h.DB.ExecContext(r.Context(), `DELETE FROM platform_limits WHERE platform = ?`, platform); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove platform limit"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "removed", "platform": platform})
+}
+
+// HandleSetStreamLimit caps how many devices a user may stream on at once,
+// for accounts shared between several people.
+func (h *Handler) HandleSetStreamLimit(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	var req struct {
+		MaxConcurrentStreams int `json:"max_concurrent_streams"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxConcurrentStreams < 1 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "max_concurrent_streams must be a positive integer"})
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		`UPDATE users SET max_concurrent_streams = ? WHERE id = ?`, req.MaxConcurrentStreams, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save stream limit"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"user_id": userID, "max_concurrent_streams": req.MaxConcurrentStreams})
+}
+
+// HandleDeleteStreamLimit removes a user's stream cap, leaving them
+// unlimited.
+func (h *Handler) HandleDeleteStreamLimit(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	res, err := h.DB.ExecContext(r.Context(), `UPDATE users SET max_concurrent_streams = NULL WHERE id = ?`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove stream limit"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "removed", "user_id": userID})
+}
+
+// HandleSetStorageQuota sets a user's soft storage quota. Nearing it warns
+// the user and suggests clips to delete; ingest is not refused.
+func (h *Handler) HandleSetStorageQuota(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	var req struct {
+		StorageQuotaBytes int64 `json:"storage_quota_bytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.StorageQuotaBytes < 1 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "storage_quota_bytes must be a positive integer"})
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		`UPDATE users SET storage_quota_bytes = ? WHERE id = ?`, req.StorageQuotaBytes, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save storage quota"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"user_id": userID, "storage_quota_bytes": req.StorageQuotaBytes})
+}
+
+// HandleDeleteStorageQuota removes a user's storage quota.
+func (h *Handler) HandleDeleteStorageQuota(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	res, err := h.DB.ExecContext(r.Context(), `UPDATE users SET storage_quota_bytes = NULL WHERE id = ?`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove storage quota"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "removed", "user_id": userID})
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleListStaffPicks returns the editorial staff picks in display order.
+func (h *Handler) HandleListStaffPicks(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT sp.clip_id, c.title, c.status, sp.note, sp.position, sp.picked_at
+		FROM staff_picks sp JOIN clips c ON sp.clip_id = c.id
+		ORDER BY sp.position ASC, sp.picked_at DESC
+	`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list staff picks"})
+		return
+	}
+	defer rows.Close()
+
+	picks := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var clipID, status, pickedAt string
+		var title, note *string
+		var position int
+		if err := rows.Scan(&clipID, &title, &status, &note, &position, &pickedAt); err != nil {
+			continue
+		}
+		picks = append(picks, map[string]interface{}{
+			"clip_id": clipID, "title": title, "status": status,
+			"note": note, "position": position, "picked_at": pickedAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"picks": picks})
+}
+
+// HandleSetStaffPick adds a clip to the staff picks or updates its note and
+// position.
+func (h *Handler) HandleSetStaffPick(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "clipId")
+
+	var req struct {
+		Note     *string `json:"note"`
+		Position int     `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil || exists == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO staff_picks (clip_id, note, position, picked_at) VALUES (?, ?, ?, %s)
+		ON CONFLICT(clip_id) DO UPDATE SET note = excluded.note, position = excluded.position
+	`, h.DB.NowUTC()), clipID, req.Note, req.Position); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save staff pick"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"clip_id": clipID, "note": req.Note, "position": req.Position})
+}
+
+// HandleDeleteStaffPick removes a clip from the staff picks.
+func (h *Handler) HandleDeleteStaffPick(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "clipId")
+	res, err := h.DB.ExecContext(r.Context(), `DELETE FROM staff_picks WHERE clip_id = ?`, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove staff pick"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "staff pick not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "removed"})
+}
@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"clipfeed/audit"
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Role is an admin role. Roles nest: a moderator can do everything a
+// curator can, and an owner everything a moderator can.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleModerator Role = "moderator"
+	RoleCurator   Role = "curator"
+)
+
+// Permission is a group of admin endpoints a role may use.
+type Permission string
+
+const (
+	// PermContent covers curating the catalog: staff picks, topic
+	// suggestions, merges, feed snapshots, and broken clips.
+	PermContent Permission = "content"
+	// PermModeration covers content filters, flags, takedowns, and
+	// restricting users.
+	PermModeration Permission = "moderation"
+	// PermUsers covers per-user limits, invites, and impersonation.
+	PermUsers Permission = "users"
+	// PermSettings covers instance settings and operations: ingest,
+	// ranking, storage, backups, keys, and federation.
+	PermSettings Permission = "settings"
+	// PermRoles covers granting and removing admin roles.
+	PermRoles Permission = "roles"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:     {PermContent, PermModeration, PermUsers, PermSettings, PermRoles},
+	RoleModerator: {PermContent, PermModeration},
+	RoleCurator:   {PermContent},
+}
+
+type contextKey string
+
+const roleKey contextKey = "admin_role"
+
+// RoleFromContext returns the role of the admin making the request, or ""
+// outside AdminAuthMiddleware.
+func RoleFromContext(ctx context.Context) Role {
+	role, _ := ctx.Value(roleKey).(Role)
+	return role
+}
+
+// Can reports whether the role grants the permission.
+func (role Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Require limits the routes it wraps to admins whose role grants perm. It
+// must run after AdminAuthMiddleware.
+func (h *Handler) Require(perm Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !RoleFromContext(r.Context()).Can(perm) {
+				httputil.WriteJSON(w, 403, map[string]string{"error": "your admin role lacks the " + string(perm) + " permission"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HandleAdminMe reports the signed-in admin's role and permissions, so the
+// admin UI can hide what they cannot use.
+func (h *Handler) HandleAdminMe(w http.ResponseWriter, r *http.Request) {
+	actor, _ := auth.ExtractUserID(r)
+	role := RoleFromContext(r.Context())
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"actor":       actor,
+		"role":        role,
+		"permissions": rolePermissions[role],
+	})
+}
+
+// HandleListRoles lists the users holding an admin role, with what each
+// role may do.
+func (h *Handler) HandleListRoles(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, username, admin_role FROM users
+		WHERE admin_role IS NOT NULL
+		ORDER BY username
+	`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list roles"})
+		return
+	}
+	defer rows.Close()
+
+	users := make([]map[string]string, 0)
+	for rows.Next() {
+		var id, username, role string
+		if err := rows.Scan(&id, &username, &role); err != nil {
+			continue
+		}
+		users = append(users, map[string]string{"user_id": id, "username": username, "role": role})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"users": users, "roles": rolePermissions})
+}
+
+// HandleSetRole grants a user an admin role, `{role}`, replacing any they
+// held. The change is audit logged.
+func (h *Handler) HandleSetRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || rolePermissions[req.Role] == nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": `role must be "owner", "moderator", or "curator"`})
+		return
+	}
+	h.changeRole(w, r, req.Role)
+}
+
+// HandleDeleteRole takes a user's admin role away. Their admin tokens stop
+// working at once. The change is audit logged.
+func (h *Handler) HandleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	h.changeRole(w, r, "")
+}
+
+var errOwnRole = errors.New("cannot change own role")
+
+// changeRole sets the user's role, or removes it when role is "", and
+// records the change.
+func (h *Handler) changeRole(w http.ResponseWriter, r *http.Request, role Role) {
+	userID := chi.URLParam(r, "id")
+	actor, _ := auth.ExtractUserID(r)
+	var previous *string
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if userID == actor {
+			return errOwnRole
+		}
+		if err := conn.QueryRowContext(r.Context(),
+			`SELECT admin_role FROM users WHERE id = ?`, userID).Scan(&previous); err != nil {
+			return err
+		}
+		var value interface{}
+		action := "admin.role_remove"
+		if role != "" {
+			value, action = string(role), "admin.role_set"
+		}
+		if _, err := conn.ExecContext(r.Context(),
+			`UPDATE users SET admin_role = ? WHERE id = ?`, value, userID); err != nil {
+			return err
+		}
+		return audit.Record(r.Context(), conn, actor, action, userID, map[string]interface{}{
+			"role": value, "previous_role": previous,
+		})
+	})
+	switch {
+	case errors.Is(err, errOwnRole):
+		httputil.WriteJSON(w, 400, map[string]string{"error": "you cannot change your own admin role"})
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	case err != nil:
+		log.Printf("change admin role of %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to change role"})
+		return
+	}
+	log.Printf("admin %s changed role of user %s from %q to %q", actor, userID, deref(previous), role)
+	if role == "" {
+		httputil.WriteJSON(w, 200, map[string]string{"status": "removed", "user_id": userID})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"user_id": userID, "role": string(role)})
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"clipfeed/httputil"
+	"clipfeed/settings"
+)
+
+// HandleGetSettings returns the instance settings, including the default
+// preferences new users start with.
+func (h *Handler) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	s, err := settings.Load(r.Context(), h.DB)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load settings"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"settings":           s,
+		"registration_modes": settings.RegistrationModes,
+	})
+}
+
+// HandleUpdateSettings changes the fields present in the body and leaves the
+// rest as they are. default_preferences is replaced as a whole.
+func (h *Handler) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(httputil.LimitedBodyReader(r))
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	s, err := settings.Update(r.Context(), h.DB, body)
+	var invalid *settings.ValidationError
+	if errors.As(err, &invalid) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("update settings: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save settings"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"settings": s})
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"clipfeed/httputil"
+	"clipfeed/jobs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleListClipStrategies returns the available clip extraction strategies
+// and the per-platform defaults used when a submission does not pick one.
+func (h *Handler) HandleListClipStrategies(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT platform, strategy, COALESCE(updated_at, '') FROM platform_clip_strategies ORDER BY platform`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list clip strategies"})
+		return
+	}
+	defer rows.Close()
+
+	platforms := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var platform, strategy, updatedAt string
+		if err := rows.Scan(&platform, &strategy, &updatedAt); err != nil {
+			continue
+		}
+		platforms = append(platforms, map[string]interface{}{
+			"platform":   platform,
+			"strategy":   strategy,
+			"updated_at": updatedAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"strategies": jobs.ClipStrategies,
+		"default":    jobs.DefaultClipStrategy,
+		"platforms":  platforms,
+	})
+}
+
+// HandleSetClipStrategy sets the default clip strategy for a platform.
+func (h *Handler) HandleSetClipStrategy(w http.ResponseWriter, r *http.Request) {
+	platform := strings.ToLower(chi.URLParam(r, "platform"))
+
+	var req struct {
+		Strategy string `json:"strategy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !jobs.ValidClipStrategy(req.Strategy) {
+		httputil.WriteJSON(w, 400, map[string]string{
+			"error": "strategy must be one of: " + strings.Join(jobs.ClipStrategies, ", "),
+		})
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO platform_clip_strategies (platform, strategy, updated_at) VALUES (?, ?, %s)
+		ON CONFLICT(platform) DO UPDATE SET
+			strategy   = excluded.strategy,
+			updated_at = excluded.updated_at
+	`, h.DB.NowUTC()), platform, req.Strategy); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save clip strategy"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"platform": platform, "strategy": req.Strategy})
+}
+
+// HandleDeleteClipStrategy removes a platform's default, so its submissions
+// fall back to the built-in default strategy.
+func (h *Handler) HandleDeleteClipStrategy(w http.ResponseWriter, r *http.Request) {
+	platform := strings.ToLower(chi.URLParam(r, "platform"))
+	if _, err := h.DB.ExecContext(r.Context(), `DELETE FROM platform_clip_strategies WHERE platform = ?`, platform); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove clip strategy"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "removed", "platform": platform})
+}
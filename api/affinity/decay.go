@@ -0,0 +1,222 @@
+// Package affinity decays learned topic affinities so interests a user
+// showed months ago fade instead of dominating today's feed. Weights halve
+// every HalfLife since they were last reinforced or decayed, and rows that
+// fall below MinWeight are removed. Users who lock their preferences are
+// left alone.
+package affinity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+const (
+	// decayInterval is how often the background loop decays.
+	decayInterval = 24 * time.Hour
+
+	// DefaultMinWeight is the weight below which affinities are pruned.
+	DefaultMinWeight = 0.05
+)
+
+// Handler decays topic affinities with the given half-life. A zero HalfLife
+// disables decay.
+type Handler struct {
+	DB        *db.CompatDB
+	HalfLife  time.Duration
+	MinWeight float64
+
+	mu      sync.Mutex
+	lastRun *Result
+}
+
+// Result reports what one decay pass did.
+type Result struct {
+	RanAt   string `json:"ran_at"`
+	Users   int    `json:"users"`
+	Decayed int    `json:"decayed"`
+	Pruned  int    `json:"pruned"`
+}
+
+type affinityRow struct {
+	topicID string
+	weight  float64
+	since   time.Time
+}
+
+// Factor returns how much a weight keeps after elapsed with halfLife.
+func Factor(elapsed, halfLife time.Duration) float64 {
+	if elapsed <= 0 || halfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(elapsed)/float64(halfLife))
+}
+
+// Decay runs one pass over every unlocked user's affinities, one user per
+// transaction.
+func (h *Handler) Decay(ctx context.Context) (Result, error) {
+	now := time.Now().UTC()
+	res := Result{RanAt: now.Format("2006-01-02T15:04:05Z")}
+	if h.HalfLife <= 0 {
+		return res, nil
+	}
+
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT a.user_id, a.topic_id, a.weight, COALESCE(a.updated_at, ''), COALESCE(a.decayed_at, '')
+		FROM user_topic_affinities a
+		LEFT JOIN user_preferences p ON p.user_id = a.user_id
+		WHERE COALESCE(p.lock_topic_affinities, 0) = 0
+	`)
+	if err != nil {
+		return res, fmt.Errorf("load affinities: %w", err)
+	}
+	byUser := make(map[string][]affinityRow)
+	for rows.Next() {
+		var userID, updatedAt, decayedAt string
+		var a affinityRow
+		if err := rows.Scan(&userID, &a.topicID, &a.weight, &updatedAt, &decayedAt); err != nil {
+			continue
+		}
+		// Decay runs from whichever came last: the last reinforcement or the
+		// last decay.
+		last := updatedAt
+		if decayedAt > last {
+			last = decayedAt
+		}
+		if a.since, err = time.Parse("2006-01-02T15:04:05Z", last); err != nil {
+			continue
+		}
+		byUser[userID] = append(byUser[userID], a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, fmt.Errorf("load affinities: %w", err)
+	}
+
+	for userID, affinities := range byUser {
+		decayed, pruned, err := h.decayUser(ctx, userID, affinities, now)
+		if err != nil {
+			return res, fmt.Errorf("decay user %s: %w", userID, err)
+		}
+		if decayed+pruned > 0 {
+			res.Users++
+		}
+		res.Decayed += decayed
+		res.Pruned += pruned
+	}
+
+	h.mu.Lock()
+	h.lastRun = &res
+	h.mu.Unlock()
+	return res, nil
+}
+
+func (h *Handler) decayUser(ctx context.Context, userID string, affinities []affinityRow, now time.Time) (decayed, pruned int, err error) {
+	stamp := now.Format("2006-01-02T15:04:05Z")
+	err = db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		for _, a := range affinities {
+			elapsed := now.Sub(a.since)
+			if elapsed <= 0 {
+				continue
+			}
+			weight := a.weight * Factor(elapsed, h.HalfLife)
+			if weight < h.minWeight() {
+				if _, err := conn.ExecContext(ctx,
+					`DELETE FROM user_topic_affinities WHERE user_id = ? AND topic_id = ?`, userID, a.topicID); err != nil {
+					return err
+				}
+				pruned++
+				continue
+			}
+			if _, err := conn.ExecContext(ctx,
+				`UPDATE user_topic_affinities SET weight = ?, decayed_at = ? WHERE user_id = ? AND topic_id = ?`,
+				weight, stamp, userID, a.topicID); err != nil {
+				return err
+			}
+			decayed++
+		}
+		return nil
+	})
+	return decayed, pruned, err
+}
+
+func (h *Handler) minWeight() float64 {
+	if h.MinWeight > 0 {
+		return h.MinWeight
+	}
+	return DefaultMinWeight
+}
+
+// DecayLoop decays shortly after startup and then every decayInterval.
+func (h *Handler) DecayLoop() {
+	time.Sleep(3 * time.Minute)
+	h.decayAndLog()
+	ticker := time.NewTicker(decayInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.decayAndLog()
+	}
+}
+
+func (h *Handler) decayAndLog() {
+	res, err := h.Decay(context.Background())
+	if err != nil {
+		log.Printf("affinity decay: %v", err)
+	}
+	if res.Decayed+res.Pruned > 0 {
+		log.Printf("affinity decay: decayed %d and pruned %d topic affinities for %d users", res.Decayed, res.Pruned, res.Users)
+	}
+}
+
+// HandleStatus reports the decay settings, the last pass, and how many
+// affinities and locked users there are.
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	var total, lockedUsers int64
+	var oldestDecay, newestDecay string
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT
+			(SELECT COUNT(*) FROM user_topic_affinities),
+			(SELECT COUNT(*) FROM user_preferences WHERE lock_topic_affinities = 1),
+			(SELECT COALESCE(MIN(decayed_at), '') FROM user_topic_affinities),
+			(SELECT COALESCE(MAX(decayed_at), '') FROM user_topic_affinities)
+	`).Scan(&total, &lockedUsers, &oldestDecay, &newestDecay); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load affinity decay status"})
+		return
+	}
+	h.mu.Lock()
+	last := h.lastRun
+	h.mu.Unlock()
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"enabled":         h.HalfLife > 0,
+		"half_life_days":  h.HalfLife.Hours() / 24,
+		"min_weight":      h.minWeight(),
+		"interval_hours":  decayInterval.Hours(),
+		"affinities":      total,
+		"locked_users":    lockedUsers,
+		"oldest_decay_at": oldestDecay,
+		"newest_decay_at": newestDecay,
+		"last_run":        last,
+	})
+}
+
+// HandleDecay runs a decay pass immediately.
+func (h *Handler) HandleDecay(w http.ResponseWriter, r *http.Request) {
+	if h.HalfLife <= 0 {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "topic affinity decay is disabled"})
+		return
+	}
+	res, err := h.Decay(r.Context())
+	if err != nil {
+		log.Printf("affinity decay: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to decay topic affinities"})
+		return
+	}
+	httputil.WriteJSON(w, 200, res)
+}
@@ -0,0 +1,101 @@
+package affinity
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestDecay_HalvesPrunesAndSkipsLockedUsers(t *testing.T) {
+	cdb := newTestDB(t)
+	for _, id := range []string{"u1", "u2"} {
+		cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES (?, ?, ?, 'x')`, id, id, id+"@test.com")
+	}
+	for _, id := range []string{"t1", "t2"} {
+		cdb.Exec(`INSERT INTO topics (id, name, slug) VALUES (?, ?, ?)`, id, id, id)
+	}
+	cdb.Exec(`INSERT INTO user_preferences (user_id, lock_topic_affinities) VALUES ('u2', 1)`)
+
+	daysAgo := func(n int) string {
+		return time.Now().UTC().AddDate(0, 0, -n).Format("2006-01-02T15:04:05Z")
+	}
+	insert := func(user, topic string, weight float64, updated string) {
+		if _, err := cdb.Exec(`INSERT INTO user_topic_affinities (user_id, topic_id, weight, updated_at) VALUES (?, ?, ?, ?)`,
+			user, topic, weight, updated); err != nil {
+			t.Fatalf("insert affinity: %v", err)
+		}
+	}
+	insert("u1", "t1", 1.0, daysAgo(60)) // two half-lives: 0.25
+	insert("u1", "t2", 0.1, daysAgo(60)) // 0.025, below the threshold
+	insert("u2", "t1", 1.0, daysAgo(60)) // locked
+
+	h := &Handler{DB: cdb, HalfLife: 30 * 24 * time.Hour}
+	res, err := h.Decay(context.Background())
+	if err != nil {
+		t.Fatalf("decay: %v", err)
+	}
+	if res.Users != 1 || res.Decayed != 1 || res.Pruned != 1 {
+		t.Fatalf("result = %+v, want 1 user, 1 decayed, 1 pruned", res)
+	}
+
+	weight := func(user, topic string) (float64, string) {
+		var w float64
+		var decayedAt sql.NullString
+		if err := cdb.QueryRow(`SELECT weight, decayed_at FROM user_topic_affinities WHERE user_id = ? AND topic_id = ?`,
+			user, topic).Scan(&w, &decayedAt); err != nil {
+			return -1, ""
+		}
+		return w, decayedAt.String
+	}
+	if w, at := weight("u1", "t1"); math.Abs(w-0.25) > 0.001 || at == "" {
+		t.Errorf("u1/t1 = %v (decayed_at %q), want 0.25 with a decay time", w, at)
+	}
+	if w, _ := weight("u1", "t2"); w != -1 {
+		t.Errorf("u1/t2 = %v, want pruned", w)
+	}
+	if w, at := weight("u2", "t1"); w != 1.0 || at != "" {
+		t.Errorf("locked u2/t1 = %v (decayed_at %q), want untouched", w, at)
+	}
+
+	// Decay runs from the last pass, so an immediate second pass barely
+	// changes anything.
+	if _, err := h.Decay(context.Background()); err != nil {
+		t.Fatalf("second decay: %v", err)
+	}
+	if w, _ := weight("u1", "t1"); math.Abs(w-0.25) > 0.001 {
+		t.Errorf("u1/t1 after second pass = %v, want still 0.25", w)
+	}
+}
+
+func TestFactor(t *testing.T) {
+	day := 24 * time.Hour
+	if f := Factor(30*day, 30*day); math.Abs(f-0.5) > 1e-9 {
+		t.Errorf("one half-life = %v, want 0.5", f)
+	}
+	if f := Factor(-day, 30*day); f != 1 {
+		t.Errorf("negative elapsed = %v, want 1", f)
+	}
+	if f := Factor(day, 0); f != 1 {
+		t.Errorf("no half-life = %v, want 1", f)
+	}
+}
@@ -0,0 +1,72 @@
+// Package audit records sensitive admin actions. Entries that concern a
+// user are shown to that user, so the log doubles as a disclosure record.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// Entry is one audit log record.
+type Entry struct {
+	ID           string                 `json:"id"`
+	Actor        string                 `json:"actor"`
+	Action       string                 `json:"action"`
+	TargetUserID string                 `json:"target_user_id,omitempty"`
+	Details      map[string]interface{} `json:"details"`
+	CreatedAt    string                 `json:"created_at"`
+}
+
+// Record appends an entry. targetUserID is empty for actions that concern
+// no particular user.
+func Record(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, actor, action, targetUserID string, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	var target interface{}
+	if targetUserID != "" {
+		target = targetUserID
+	}
+	_, err = ex.ExecContext(ctx, `
+		INSERT INTO audit_log (id, actor, action, target_user_id, details)
+		VALUES (?, ?, ?, ?, ?)
+	`, uuid.New().String(), actor, action, target, string(raw))
+	return err
+}
+
+// ForUser returns the newest entries about userID, at most limit of them.
+func ForUser(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}, userID string, limit int) ([]Entry, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, actor, action, COALESCE(target_user_id, ''), details, created_at
+		FROM audit_log
+		WHERE target_user_id = ?
+		ORDER BY created_at DESC, id
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var e Entry
+		var details string
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.TargetUserID, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(details), &e.Details)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
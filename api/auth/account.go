@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"clipfeed/audit"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxDisplayNameLen = 64
+	// EmailVerificationTTL is how long an email change waits to be confirmed.
+	EmailVerificationTTL = 24 * time.Hour
+)
+
+var errEmailTaken = errors.New("email already taken")
+
+// validEmail reports whether s is a bare email address.
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && len(s) <= 254
+}
+
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// refuseImpersonated rejects account changes made through an impersonation
+// token: an admin debugging an account must not be able to take it over. It
+// reports whether the request was refused.
+func refuseImpersonated(w http.ResponseWriter, r *http.Request) bool {
+	if ImpersonationFromContext(r.Context()) == nil {
+		return false
+	}
+	httputil.WriteJSON(w, 403, map[string]string{"error": "account details cannot be changed while impersonating"})
+	return true
+}
+
+// HandleUpdateAccount changes the user's display name and/or email. A new
+// email needs the current password and, when SendEmail is set, is only
+// applied once confirmed through HandleVerifyEmail; until then login keeps
+// using the old address. Without SendEmail there is no way to confirm it,
+// so it is applied right away.
+func (h *Handler) HandleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey).(string)
+	if refuseImpersonated(w, r) {
+		return
+	}
+	var req struct {
+		DisplayName     *string `json:"display_name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.DisplayName == nil && req.Email == nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "display_name or email required"})
+		return
+	}
+
+	var displayName, email, hash string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT COALESCE(display_name, ''), email, password_hash FROM users WHERE id = ?`, userID,
+	).Scan(&displayName, &email, &hash); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	resp := map[string]interface{}{}
+
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if name == "" || utf8.RuneCountInString(name) > maxDisplayNameLen ||
+			strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			httputil.WriteJSON(w, 400, map[string]string{
+				"error": fmt.Sprintf("display_name must be 1-%d characters", maxDisplayNameLen),
+			})
+			return
+		}
+		if name != displayName {
+			if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+				if _, err := conn.ExecContext(r.Context(),
+					fmt.Sprintf(`UPDATE users SET display_name = ?, updated_at = %s WHERE id = ?`, h.DB.NowUTC()),
+					name, userID); err != nil {
+					return err
+				}
+				return audit.Record(r.Context(), conn, userID, "account.display_name", userID,
+					map[string]interface{}{"from": displayName, "to": name})
+			}); err != nil {
+				httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update display name"})
+				return
+			}
+		}
+		resp["display_name"] = name
+	}
+
+	if req.Email != nil {
+		newEmail := strings.TrimSpace(*req.Email)
+		if !validEmail(newEmail) {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "a valid email address is required"})
+			return
+		}
+		if len(req.CurrentPassword) > maxPasswordLen ||
+			bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)) != nil {
+			httputil.WriteJSON(w, 403, map[string]string{"error": "current_password is incorrect"})
+			return
+		}
+		if strings.EqualFold(newEmail, email) {
+			resp["email"] = email
+		} else if h.SendEmail == nil {
+			if err := h.changeEmail(r, userID, email, newEmail); err != nil {
+				writeEmailError(w, err)
+				return
+			}
+			resp["email"] = newEmail
+		} else {
+			if err := h.requestEmailChange(r, userID, newEmail); err != nil {
+				writeEmailError(w, err)
+				return
+			}
+			resp["email"] = email
+			resp["pending_email"] = newEmail
+		}
+	}
+
+	httputil.WriteJSON(w, 200, resp)
+}
+
+func writeEmailError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errEmailTaken) {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "email already taken"})
+		return
+	}
+	log.Printf("change email: %v", err)
+	httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update email"})
+}
+
+// emailTaken reports whether another user has email.
+func (h *Handler) emailTaken(r *http.Request, userID, email string) bool {
+	var exists int
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT 1 FROM users WHERE LOWER(email) = LOWER(?) AND id != ?`, email, userID).Scan(&exists)
+	return err == nil
+}
+
+// requestEmailChange records newEmail as pending and mails it a token that
+// confirms it.
+func (h *Handler) requestEmailChange(r *http.Request, userID, newEmail string) error {
+	if h.emailTaken(r, userID, newEmail) {
+		return errEmailTaken
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().UTC().Add(EmailVerificationTTL).Format("2006-01-02T15:04:05Z")
+	if _, err := h.DB.ExecContext(r.Context(), `
+		UPDATE users SET pending_email = ?, email_token_hash = ?, email_token_expires_at = ?
+		WHERE id = ?
+	`, newEmail, hashEmailToken(token), expiresAt, userID); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Someone asked to use this address for a ClipFeed account.\n\n"+
+		"To confirm it, enter this code in ClipFeed within %d hours:\n\n%s\n\n"+
+		"If this wasn't you, ignore this email and the address won't be used.",
+		int(EmailVerificationTTL.Hours()), token)
+	return h.SendEmail(r.Context(), newEmail, "Confirm your new ClipFeed email address", body)
+}
+
+// changeEmail replaces the user's email with newEmail and clears any
+// pending change.
+func (h *Handler) changeEmail(r *http.Request, userID, oldEmail, newEmail string) error {
+	if h.emailTaken(r, userID, newEmail) {
+		return errEmailTaken
+	}
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(), fmt.Sprintf(`
+			UPDATE users SET email = ?, pending_email = NULL, email_token_hash = NULL,
+			       email_token_expires_at = NULL, updated_at = %s
+			WHERE id = ?
+		`, h.DB.NowUTC()), newEmail, userID); err != nil {
+			return err
+		}
+		return audit.Record(r.Context(), conn, userID, "account.email", userID,
+			map[string]interface{}{"from": oldEmail, "to": newEmail})
+	})
+	if err != nil && (strings.Contains(err.Error(), "UNIQUE") || strings.Contains(err.Error(), "duplicate key")) {
+		return errEmailTaken
+	}
+	return err
+}
+
+// HandleVerifyEmail applies a pending email change given the token that was
+// mailed to the new address.
+func (h *Handler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey).(string)
+	if refuseImpersonated(w, r) {
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || req.Token == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "token required"})
+		return
+	}
+
+	var email string
+	var pending, tokenHash, expiresAt *string
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT email, pending_email, email_token_hash, email_token_expires_at FROM users WHERE id = ?`,
+		userID).Scan(&email, &pending, &tokenHash, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load user"})
+		return
+	}
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	if pending == nil || tokenHash == nil || expiresAt == nil || *expiresAt <= now ||
+		*tokenHash != hashEmailToken(strings.TrimSpace(req.Token)) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "verification code is invalid or expired"})
+		return
+	}
+	if err := h.changeEmail(r, userID, email, *pending); err != nil {
+		writeEmailError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"email": *pending})
+}
+
+// HandleChangePassword replaces the user's password given the current one,
+// signs the user out everywhere, and returns a token for a new session.
+func (h *Handler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey).(string)
+	if refuseImpersonated(w, r) {
+		return
+	}
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if len(req.NewPassword) < 8 || len(req.NewPassword) > maxPasswordLen {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "new_password must be 8-72 characters"})
+		return
+	}
+
+	var hash string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT password_hash FROM users WHERE id = ?`, userID).Scan(&hash); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	if len(req.CurrentPassword) > maxPasswordLen ||
+		bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)) != nil {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "current_password is incorrect"})
+		return
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "internal error"})
+		return
+	}
+	var sessionID string
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(),
+			fmt.Sprintf(`UPDATE users SET password_hash = ?, updated_at = %s WHERE id = ?`, h.DB.NowUTC()),
+			string(newHash), userID); err != nil {
+			return err
+		}
+		if _, err := h.revokeSessions(r.Context(), conn, userID, ""); err != nil {
+			return err
+		}
+		var err error
+		if sessionID, err = h.recordSession(r, conn, userID); err != nil {
+			return err
+		}
+		return audit.Record(r.Context(), conn, userID, "account.password", userID, nil)
+	}); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to change password"})
+		return
+	}
+
+	token, err := h.generateToken(r.Context(), userID, sessionID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate token"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"token": token, "user_id": userID})
+}
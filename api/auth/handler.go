@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,6 +11,7 @@ import (
 
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/settings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -18,6 +20,12 @@ import (
 
 const maxPasswordLen = 72 // bcrypt truncates at 72 bytes
 
+var errInitPreferences = errors.New("initialize preferences")
+
+// ErrInvalidInvite is returned by RedeemInvite for a code that cannot be
+// used to register.
+var ErrInvalidInvite = errors.New("invalid invite code")
+
 type contextKey string
 
 // UserIDKey is the context key used to store the authenticated user ID.
@@ -33,6 +41,15 @@ func ExtractUserID(r *http.Request) (string, bool) {
 type Handler struct {
 	DB        *db.CompatDB
 	JWTSecret string
+	// Keys, when set, signs and verifies tokens in place of JWTSecret.
+	Keys *KeyRing
+	// RedeemInvite redeems an invite code for a user being registered,
+	// refusing invites issued by users when adminOnly is set. Without it,
+	// no invite is accepted.
+	RedeemInvite func(ctx context.Context, conn *db.CompatConn, code, userID string, adminOnly bool) error
+	// SendEmail delivers account emails, such as the code confirming a new
+	// address. Without it, email changes are applied unconfirmed.
+	SendEmail func(ctx context.Context, to, subject, body string) error
 }
 
 // RegisterRequest is the JSON body for POST /api/auth/register.
@@ -40,15 +57,31 @@ type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// InviteCode is required while registration is invite-only.
+	InviteCode string `json:"invite_code"`
 }
 
-// HandleRegister creates a new user account.
+// HandleRegister creates a new user account, honouring the instance's
+// registration mode and seeding the user's preferences from its defaults.
 func (h *Handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
 		return
 	}
+	instance, err := settings.Load(r.Context(), h.DB)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "internal error"})
+		return
+	}
+	if instance.Registration != settings.RegistrationOpen && req.InviteCode == "" {
+		msg := "an invite code is required"
+		if instance.Registration == settings.RegistrationClosed {
+			msg = "registration is closed; an invite from an admin is required"
+		}
+		httputil.WriteJSON(w, 403, map[string]string{"error": msg})
+		return
+	}
 	if len(req.Username) < 3 || len(req.Password) < 8 {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "username must be 3+ chars, password 8+ chars"})
 		return
@@ -57,7 +90,7 @@ func (h *Handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "password must not exceed 72 characters"})
 		return
 	}
-	if !strings.Contains(req.Email, "@") || len(req.Email) < 5 {
+	if !validEmail(req.Email) {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "a valid email address is required"})
 		return
 	}
@@ -69,24 +102,45 @@ func (h *Handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := uuid.New().String()
-	_, err = h.DB.ExecContext(r.Context(),
-		`INSERT INTO users (id, username, email, password_hash, display_name) VALUES (?, ?, ?, ?, ?)`,
-		userID, req.Username, req.Email, string(hash), req.Username)
-	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE") {
-			httputil.WriteJSON(w, 409, map[string]string{"error": "username or email already taken"})
-			return
+	err = db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(),
+			`INSERT INTO users (id, username, email, password_hash, display_name) VALUES (?, ?, ?, ?, ?)`,
+			userID, req.Username, req.Email, string(hash), req.Username); err != nil {
+			return err
 		}
-		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create user"})
+		// Invites are optional while registration is open, but still
+		// record who invited whom.
+		if req.InviteCode != "" {
+			if h.RedeemInvite == nil {
+				return ErrInvalidInvite
+			}
+			adminOnly := instance.Registration == settings.RegistrationClosed
+			if err := h.RedeemInvite(r.Context(), conn, req.InviteCode, userID, adminOnly); err != nil {
+				return err
+			}
+		}
+		if err := settings.InsertUserPreferences(r.Context(), conn, userID, instance.DefaultPreferences); err != nil {
+			return errInitPreferences
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrInvalidInvite):
+		httputil.WriteJSON(w, 403, map[string]string{"error": "invite code is invalid, used, or expired"})
 		return
-	}
-
-	if _, err := h.DB.ExecContext(r.Context(), `INSERT INTO user_preferences (user_id) VALUES (?) ON CONFLICT DO NOTHING`, userID); err != nil {
+	case errors.Is(err, errInitPreferences):
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to initialize preferences"})
 		return
+	case strings.Contains(err.Error(), "UNIQUE"), strings.Contains(err.Error(), "duplicate key"):
+		httputil.WriteJSON(w, 409, map[string]string{"error": "username or email already taken"})
+		return
+	default:
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create user"})
+		return
 	}
 
-	token, err := h.generateToken(userID)
+	token, err := h.startSession(r, userID)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate token"})
 		return
@@ -127,7 +181,7 @@ func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteJSON(w, 401, map[string]string{"error": "invalid credentials"})
 		return
 	}
-	token, err := h.generateToken(userID)
+	token, err := h.startSession(r, userID)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate token"})
 		return
@@ -135,27 +189,75 @@ func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]string{"token": token, "user_id": userID})
 }
 
-func (h *Handler) generateToken(userID string) (string, error) {
-	return GenerateToken(userID, h.JWTSecret), nil
+func (h *Handler) generateToken(ctx context.Context, userID, sessionID string) (string, error) {
+	if h.Keys != nil {
+		return h.Keys.SignSession(ctx, userID, sessionID)
+	}
+	return signToken(userID, sessionID, h.JWTSecret), nil
 }
 
-// GenerateToken creates a signed JWT for the given user ID and secret.
-func GenerateToken(userID, secret string) string {
+// UserIDFromRequest returns the user ID of the request's Bearer token, or ""
+// if there is no valid token or its session was revoked.
+func (h *Handler) UserIDFromRequest(r *http.Request) string {
+	userID, _ := h.userFromToken(r)
+	return userID
+}
+
+// userFromToken returns the user and session of the request's Bearer
+// token. userID is "" when the token is invalid or its session was revoked.
+func (h *Handler) userFromToken(r *http.Request) (userID, sessionID string) {
+	var claims jwt.MapClaims
+	if h.Keys == nil {
+		claims = parseToken(r, h.JWTSecret)
+	} else if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		claims = h.Keys.claims(r.Context(), strings.TrimPrefix(authHeader, "Bearer "))
+	}
+	userID, _ = claims["sub"].(string)
+	if userID == "" {
+		return "", ""
+	}
+	sessionID, _ = claims["sid"].(string)
+	if !h.sessionActive(r.Context(), userID, sessionID, claims) {
+		return "", ""
+	}
+	return userID, sessionID
+}
+
+func tokenClaims(userID, sessionID string) jwt.MapClaims {
 	claims := jwt.MapClaims{
 		"sub": userID,
-		"exp": time.Now().Add(7 * 24 * time.Hour).Unix(),
+		"exp": time.Now().Add(TokenLifetime).Unix(),
 		"iat": time.Now().Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
+	return claims
+}
+
+func signToken(userID, sessionID, secret string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims(userID, sessionID))
 	s, _ := token.SignedString([]byte(secret))
 	return s
 }
 
+// GenerateToken creates a signed JWT for the given user ID and secret.
+func GenerateToken(userID, secret string) string {
+	return signToken(userID, "", secret)
+}
+
 // ExtractUserIDFromToken parses the Bearer JWT from a request using the given secret.
 func ExtractUserIDFromToken(r *http.Request, secret string) string {
+	sub, _ := parseToken(r, secret)["sub"].(string)
+	return sub
+}
+
+// parseToken returns the claims of the request's Bearer JWT, or nil if
+// there is no valid one.
+func parseToken(r *http.Request, secret string) jwt.MapClaims {
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return ""
+		return nil
 	}
 
 	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
@@ -167,30 +269,32 @@ func ExtractUserIDFromToken(r *http.Request, secret string) string {
 	})
 
 	if err != nil || !token.Valid {
-		return ""
+		return nil
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return ""
-	}
-
-	sub, ok := claims["sub"].(string)
-	if !ok {
-		return ""
-	}
-	return sub
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims
 }
 
-// AuthMiddleware requires a valid JWT and puts the user ID into the context.
+// AuthMiddleware requires a valid JWT or impersonation token and puts the
+// user ID into the context.
 func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID := ExtractUserIDFromToken(r, h.JWTSecret)
+		userID, sessionID, imp := h.authenticate(r)
 		if userID == "" {
 			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
 			return
 		}
 		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		if sessionID != "" {
+			ctx = context.WithValue(ctx, SessionIDKey, sessionID)
+		}
+		if imp != nil {
+			if !h.authorizeImpersonation(w, r, imp) {
+				return
+			}
+			ctx = context.WithValue(ctx, ImpersonationKey, imp)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -199,9 +303,18 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 // but does not reject unauthenticated requests.
 func (h *Handler) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := ExtractUserIDFromToken(r, h.JWTSecret)
+		userID, sessionID, imp := h.authenticate(r)
 		if userID != "" {
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			if sessionID != "" {
+				ctx = context.WithValue(ctx, SessionIDKey, sessionID)
+			}
+			if imp != nil {
+				if !h.authorizeImpersonation(w, r, imp) {
+					return
+				}
+				ctx = context.WithValue(ctx, ImpersonationKey, imp)
+			}
 			r = r.WithContext(ctx)
 		}
 		next(w, r)
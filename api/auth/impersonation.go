@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/audit"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// ImpersonationTokenPrefix marks impersonation tokens, which are opaque
+	// and looked up in impersonation_sessions rather than verified as JWTs.
+	ImpersonationTokenPrefix = "imp_"
+
+	// DefaultImpersonationTTL and MaxImpersonationTTL bound how long an
+	// impersonation token lives.
+	DefaultImpersonationTTL = 15 * time.Minute
+	MaxImpersonationTTL     = time.Hour
+
+	maxImpersonationReasonLen = 500
+	auditLogLimit             = 100
+)
+
+// ImpersonationKey is the context key holding the *Impersonation of a
+// request made with an impersonation token.
+const ImpersonationKey contextKey = "impersonation"
+
+// Impersonation describes the session behind an impersonation token.
+type Impersonation struct {
+	SessionID string
+	UserID    string
+	ReadOnly  bool
+	// Actor is the admin who started the session.
+	Actor string
+}
+
+// ImpersonationFromContext returns the impersonation a request runs under,
+// or nil for a request made by the user themself.
+func ImpersonationFromContext(ctx context.Context) *Impersonation {
+	imp, _ := ctx.Value(ImpersonationKey).(*Impersonation)
+	return imp
+}
+
+func hashImpersonationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isSafeMethod reports whether a request method only reads.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// authenticate returns the user a request acts as and either the session
+// of its token or, for an impersonation token, the impersonation. userID
+// is "" when the request carries no valid token.
+func (h *Handler) authenticate(r *http.Request) (string, string, *Impersonation) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !strings.HasPrefix(token, ImpersonationTokenPrefix) {
+		userID, sessionID := h.userFromToken(r)
+		return userID, sessionID, nil
+	}
+	imp := h.lookupImpersonation(r.Context(), token)
+	if imp == nil {
+		return "", "", nil
+	}
+	return imp.UserID, "", imp
+}
+
+// lookupImpersonation finds the live session of token and counts the
+// request against it.
+func (h *Handler) lookupImpersonation(ctx context.Context, token string) *Impersonation {
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	var imp Impersonation
+	var readOnly int
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT id, user_id, read_only, actor FROM impersonation_sessions
+		WHERE token_hash = ? AND ended_at IS NULL AND expires_at > ?
+	`, hashImpersonationToken(token), now).Scan(&imp.SessionID, &imp.UserID, &readOnly, &imp.Actor)
+	if err != nil {
+		return nil
+	}
+	imp.ReadOnly = readOnly == 1
+	h.DB.ExecContext(ctx, `
+		UPDATE impersonation_sessions SET request_count = request_count + 1, last_used_at = ?
+		WHERE id = ?
+	`, now, imp.SessionID)
+	return &imp
+}
+
+// authorizeImpersonation refuses writes through a read-only impersonation
+// token and audits every write through a writable one as made by the admin
+// who started the session. It reports whether the request may proceed.
+func (h *Handler) authorizeImpersonation(w http.ResponseWriter, r *http.Request, imp *Impersonation) bool {
+	if isSafeMethod(r.Method) {
+		return true
+	}
+	if imp.ReadOnly {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "impersonation token is read-only"})
+		return false
+	}
+	if err := audit.Record(r.Context(), h.DB, imp.Actor, "impersonation.write", imp.UserID, map[string]interface{}{
+		"session_id": imp.SessionID, "method": r.Method, "path": r.URL.Path,
+	}); err != nil {
+		log.Printf("audit impersonated write: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to audit request"})
+		return false
+	}
+	return true
+}
+
+// HandleStartImpersonation mints a short-lived token that acts as the user,
+// read-only unless read_only is false. A reason is required; the session is
+// recorded in the audit log, which the user can read.
+func (h *Handler) HandleStartImpersonation(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	var req struct {
+		Reason   string `json:"reason"`
+		ReadOnly *bool  `json:"read_only"`
+		Minutes  int    `json:"minutes"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil && err != io.EOF {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > maxImpersonationReasonLen {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "reason is required and must be at most 500 characters"})
+		return
+	}
+	ttl := DefaultImpersonationTTL
+	if req.Minutes != 0 {
+		ttl = time.Duration(req.Minutes) * time.Minute
+	}
+	if ttl <= 0 || ttl > MaxImpersonationTTL {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "minutes must be between 1 and 60"})
+		return
+	}
+	readOnly := req.ReadOnly == nil || *req.ReadOnly
+
+	var username string
+	err := h.DB.QueryRowContext(r.Context(), `SELECT username FROM users WHERE id = ?`, userID).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load user"})
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate token"})
+		return
+	}
+	token := ImpersonationTokenPrefix + hex.EncodeToString(raw)
+	sessionID := uuid.New().String()
+	expiresAt := time.Now().UTC().Add(ttl).Format("2006-01-02T15:04:05Z")
+	ro := 0
+	if readOnly {
+		ro = 1
+	}
+	actor, _ := ExtractUserID(r)
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(), `
+			INSERT INTO impersonation_sessions (id, user_id, token_hash, reason, read_only, expires_at, actor)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, sessionID, userID, hashImpersonationToken(token), req.Reason, ro, expiresAt, actor); err != nil {
+			return err
+		}
+		return audit.Record(r.Context(), conn, actor, "impersonation.start", userID, map[string]interface{}{
+			"session_id": sessionID, "reason": req.Reason, "read_only": readOnly, "expires_at": expiresAt,
+		})
+	}); err != nil {
+		log.Printf("start impersonation of %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to start impersonation"})
+		return
+	}
+	log.Printf("admin impersonating user %s (%s), session %s, read_only=%v", username, userID, sessionID, readOnly)
+
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"token": token, "session_id": sessionID, "user_id": userID,
+		"read_only": readOnly, "expires_at": expiresAt,
+	})
+}
+
+// HandleEndImpersonation revokes an impersonation session before it expires.
+func (h *Handler) HandleEndImpersonation(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "id")
+	actor, _ := ExtractUserID(r)
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	var ended bool
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var userID string
+		if err := conn.QueryRowContext(r.Context(),
+			`SELECT user_id FROM impersonation_sessions WHERE id = ? AND ended_at IS NULL AND expires_at > ?`,
+			sessionID, now).Scan(&userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		if _, err := conn.ExecContext(r.Context(),
+			`UPDATE impersonation_sessions SET ended_at = ? WHERE id = ?`, now, sessionID); err != nil {
+			return err
+		}
+		ended = true
+		return audit.Record(r.Context(), conn, actor, "impersonation.end", userID,
+			map[string]interface{}{"session_id": sessionID})
+	})
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to end impersonation"})
+		return
+	}
+	if !ended {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "no active impersonation session"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "ended"})
+}
+
+// HandleListImpersonations lists impersonation sessions, newest first,
+// optionally for one ?user_id.
+func (h *Handler) HandleListImpersonations(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT s.id, s.user_id, u.username, s.actor, s.reason, s.read_only, s.request_count,
+		       s.created_at, s.expires_at, s.last_used_at, s.ended_at
+		FROM impersonation_sessions s
+		JOIN users u ON u.id = s.user_id`
+	var args []interface{}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		query += ` WHERE s.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY s.created_at DESC LIMIT 100`
+	rows, err := h.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list impersonations"})
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	sessions := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, userID, username, actor, reason, createdAt, expiresAt string
+		var readOnly, requests int
+		var lastUsed, endedAt *string
+		if err := rows.Scan(&id, &userID, &username, &actor, &reason, &readOnly, &requests,
+			&createdAt, &expiresAt, &lastUsed, &endedAt); err != nil {
+			continue
+		}
+		sessions = append(sessions, map[string]interface{}{
+			"id": id, "user_id": userID, "username": username, "actor": actor, "reason": reason,
+			"read_only": readOnly == 1, "request_count": requests,
+			"created_at": createdAt, "expires_at": expiresAt,
+			"last_used_at": lastUsed, "ended_at": endedAt,
+			"active": endedAt == nil && expiresAt > now,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"impersonations": sessions})
+}
+
+// HandleMyAuditLog lists the audit log entries about the current user,
+// such as admins impersonating them.
+func (h *Handler) HandleMyAuditLog(w http.ResponseWriter, r *http.Request) {
+	userID, _ := ExtractUserID(r)
+	entries, err := audit.ForUser(r.Context(), h.DB, userID, auditLogLimit)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load audit log"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"entries": entries})
+}
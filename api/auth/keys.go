@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"clipfeed/crypto"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// TokenLifetime is how long user tokens stay valid. A rotated-out key
+	// keeps verifying tokens for this long, so no issued token outlives it.
+	TokenLifetime = 7 * 24 * time.Hour
+
+	// legacyKeyID stands for the configured JWT_SECRET. Tokens signed before
+	// the first rotation carry no kid and are verified against it.
+	legacyKeyID = "legacy"
+
+	// keyCacheTTL bounds how stale another instance's view of the keys can
+	// be after a rotation.
+	keyCacheTTL       = time.Minute
+	keyReloadInterval = 5 * time.Second
+	retireInterval    = time.Hour
+)
+
+type signingKey struct {
+	ID        string
+	Secret    []byte
+	CreatedAt time.Time
+	RotatedAt time.Time // zero for the current key
+}
+
+// retired reports whether the key was rotated out longer ago than tokens live.
+func (k signingKey) retired(now time.Time) bool {
+	return !k.RotatedAt.IsZero() && now.Sub(k.RotatedAt) > TokenLifetime
+}
+
+// KeyRing holds the user-token signing keys. Tokens are signed with the
+// newest key and carry its ID in the kid header; any key that has not been
+// retired verifies them. Until the first rotation the ring signs and
+// verifies with LegacySecret alone.
+type KeyRing struct {
+	DB           *db.CompatDB
+	LegacySecret string
+	// EncryptionSecret encrypts key secrets at rest.
+	EncryptionSecret string
+
+	mu     sync.Mutex
+	keys   []signingKey // current key first
+	loaded time.Time
+}
+
+func parseKeyTime(s string) time.Time {
+	t, _ := time.Parse("2006-01-02T15:04:05Z", s)
+	return t
+}
+
+func (k *KeyRing) load(ctx context.Context) error {
+	rows, err := k.DB.QueryContext(ctx,
+		`SELECT kid, secret, created_at, COALESCE(rotated_at, '') FROM jwt_keys ORDER BY COALESCE(rotated_at, '9999') DESC, kid`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var keys []signingKey
+	for rows.Next() {
+		var kid, encrypted, createdAt, rotatedAt string
+		if err := rows.Scan(&kid, &encrypted, &createdAt, &rotatedAt); err != nil {
+			return err
+		}
+		key := signingKey{ID: kid, CreatedAt: parseKeyTime(createdAt), RotatedAt: parseKeyTime(rotatedAt)}
+		if kid == legacyKeyID {
+			key.Secret = []byte(k.LegacySecret)
+		} else {
+			secret, err := crypto.DecryptCookie(encrypted, k.EncryptionSecret)
+			if err != nil {
+				log.Printf("jwt keys: cannot decrypt key %s: %v", kid, err)
+				continue
+			}
+			key.Secret = []byte(secret)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	k.keys = keys
+	k.loaded = time.Now()
+	return nil
+}
+
+// snapshot returns the cached keys, reloading them when the cache has
+// expired or when force is set and the last load is not too recent.
+func (k *KeyRing) snapshot(ctx context.Context, force bool) []signingKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	age := time.Since(k.loaded)
+	if age > keyCacheTTL || (force && age > keyReloadInterval) {
+		if err := k.load(ctx); err != nil {
+			log.Printf("jwt keys: load: %v", err)
+		}
+	}
+	return k.keys
+}
+
+// Sign issues a user token signed with the current key.
+func (k *KeyRing) Sign(ctx context.Context, userID string) (string, error) {
+	return k.SignSession(ctx, userID, "")
+}
+
+// SignSession issues a user token for a session; an empty sessionID
+// issues one without a session.
+func (k *KeyRing) SignSession(ctx context.Context, userID, sessionID string) (string, error) {
+	keys := k.snapshot(ctx, false)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims(userID, sessionID))
+	for _, key := range keys {
+		if key.RotatedAt.IsZero() {
+			token.Header["kid"] = key.ID
+			return token.SignedString(key.Secret)
+		}
+	}
+	return token.SignedString([]byte(k.LegacySecret))
+}
+
+// verificationKey finds the secret for a token's kid, or nil when the key
+// is unknown or retired.
+func (k *KeyRing) verificationKey(ctx context.Context, kid string) []byte {
+	if kid == "" {
+		kid = legacyKeyID
+	}
+	find := func(keys []signingKey) ([]byte, bool) {
+		if len(keys) == 0 {
+			// Never rotated: only the legacy secret exists.
+			if kid == legacyKeyID {
+				return []byte(k.LegacySecret), true
+			}
+			return nil, false
+		}
+		for _, key := range keys {
+			if key.ID == kid {
+				if key.retired(time.Now()) {
+					return nil, true
+				}
+				return key.Secret, true
+			}
+		}
+		return nil, false
+	}
+	if secret, found := find(k.snapshot(ctx, false)); found {
+		return secret
+	}
+	// A kid we have not seen may come from a rotation on another instance.
+	secret, _ := find(k.snapshot(ctx, true))
+	return secret
+}
+
+// Verify returns the user ID of a valid token, or "" if it is invalid.
+func (k *KeyRing) Verify(ctx context.Context, tokenStr string) string {
+	sub, _ := k.claims(ctx, tokenStr)["sub"].(string)
+	return sub
+}
+
+// claims returns the claims of a valid token, or nil if it is invalid.
+func (k *KeyRing) claims(ctx context.Context, tokenStr string) jwt.MapClaims {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		kid, _ := token.Header["kid"].(string)
+		secret := k.verificationKey(ctx, kid)
+		if secret == nil {
+			return nil, fmt.Errorf("unknown or retired key %q", kid)
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims
+}
+
+// Rotate makes a new random key current. The previous key, including the
+// legacy secret on the first rotation, keeps verifying until it retires.
+func (k *KeyRing) Rotate(ctx context.Context) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	encrypted, err := crypto.EncryptCookie(hex.EncodeToString(b), k.EncryptionSecret)
+	if err != nil {
+		return "", fmt.Errorf("encrypt key: %w", err)
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("generate key id: %w", err)
+	}
+	kid := hex.EncodeToString(idBytes)
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	if err := db.WithTx(ctx, k.DB, func(conn *db.CompatConn) error {
+		var count int
+		if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM jwt_keys`).Scan(&count); err != nil {
+			return fmt.Errorf("count keys: %w", err)
+		}
+		if count == 0 {
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO jwt_keys (kid, secret, created_at, rotated_at) VALUES (?, '', ?, ?)`,
+				legacyKeyID, now, now); err != nil {
+				return fmt.Errorf("record legacy key: %w", err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx,
+			`UPDATE jwt_keys SET rotated_at = ? WHERE rotated_at IS NULL`, now); err != nil {
+			return fmt.Errorf("rotate out current key: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO jwt_keys (kid, secret, created_at) VALUES (?, ?, ?)`, kid, encrypted, now); err != nil {
+			return fmt.Errorf("insert key: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if _, err := k.Retire(ctx); err != nil {
+		log.Printf("jwt keys: retire: %v", err)
+	}
+	k.mu.Lock()
+	if err := k.load(ctx); err != nil {
+		log.Printf("jwt keys: load: %v", err)
+	}
+	k.mu.Unlock()
+	return kid, nil
+}
+
+// Retire deletes keys rotated out longer ago than TokenLifetime; every
+// token they signed has expired.
+func (k *KeyRing) Retire(ctx context.Context) (int64, error) {
+	cutoff := time.Now().UTC().Add(-TokenLifetime).Format("2006-01-02T15:04:05Z")
+	res, err := k.DB.ExecContext(ctx,
+		`DELETE FROM jwt_keys WHERE rotated_at IS NOT NULL AND rotated_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RetireLoop retires expired keys every retireInterval.
+func (k *KeyRing) RetireLoop() {
+	ticker := time.NewTicker(retireInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := k.Retire(context.Background()); err != nil {
+			log.Printf("jwt keys: retire: %v", err)
+		} else if n > 0 {
+			log.Printf("jwt keys: retired %d keys", n)
+		}
+	}
+}
+
+func (k *KeyRing) describe(ctx context.Context) []map[string]interface{} {
+	now := time.Now()
+	keys := k.snapshot(ctx, true)
+	out := make([]map[string]interface{}, 0, len(keys)+1)
+	if len(keys) == 0 {
+		return append(out, map[string]interface{}{"kid": legacyKeyID, "current": true})
+	}
+	for _, key := range keys {
+		if key.retired(now) {
+			continue
+		}
+		entry := map[string]interface{}{
+			"kid":     key.ID,
+			"current": key.RotatedAt.IsZero(),
+		}
+		if key.ID != legacyKeyID {
+			entry["created_at"] = key.CreatedAt.Format("2006-01-02T15:04:05Z")
+		}
+		if !key.RotatedAt.IsZero() {
+			entry["rotated_at"] = key.RotatedAt.Format("2006-01-02T15:04:05Z")
+			entry["retires_at"] = key.RotatedAt.Add(TokenLifetime).Format("2006-01-02T15:04:05Z")
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// HandleListKeys lists the signing keys that still verify tokens. Secrets
+// are never returned.
+func (k *KeyRing) HandleListKeys(w http.ResponseWriter, r *http.Request) {
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"token_lifetime_hours": int(TokenLifetime.Hours()),
+		"keys":                 k.describe(r.Context()),
+	})
+}
+
+// HandleRotateKey makes a new signing key current.
+func (k *KeyRing) HandleRotateKey(w http.ResponseWriter, r *http.Request) {
+	kid, err := k.Rotate(r.Context())
+	if err != nil {
+		log.Printf("jwt keys: rotate: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to rotate signing key"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"kid":  kid,
+		"keys": k.describe(r.Context()),
+	})
+}
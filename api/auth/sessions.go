@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/audit"
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/ratelimit"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// SessionIDKey is the context key holding the session of the request's
+	// token, when it has one.
+	SessionIDKey contextKey = "session_id"
+
+	// sessionTouchInterval is how stale a session's last_used_at may get
+	// before a request updates it, so that not every request writes.
+	sessionTouchInterval = 5 * time.Minute
+	sessionPruneInterval = time.Hour
+	maxUserAgentLen      = 512
+)
+
+// SessionIDFromContext returns the session a request's token belongs to, or
+// "" for tokens without one.
+func SessionIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(SessionIDKey).(string)
+	return id
+}
+
+// startSession records a sign-in from the request's client and issues its
+// token.
+func (h *Handler) startSession(r *http.Request, userID string) (string, error) {
+	sessionID, err := h.recordSession(r, h.DB, userID)
+	if err != nil {
+		return "", err
+	}
+	return h.generateToken(r.Context(), userID, sessionID)
+}
+
+// recordSession inserts a session for a sign-in from the request's client
+// and returns its ID.
+func (h *Handler) recordSession(r *http.Request, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, userID string) (string, error) {
+	now := time.Now().UTC()
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+	sessionID := uuid.New().String()
+	if _, err := ex.ExecContext(r.Context(), `
+		INSERT INTO user_sessions (id, user_id, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, userID, userAgent, ratelimit.ClientIP(r),
+		now.Format("2006-01-02T15:04:05Z"), now.Format("2006-01-02T15:04:05Z"),
+		now.Add(TokenLifetime).Format("2006-01-02T15:04:05Z")); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// sessionActive reports whether a token of the user may still be used and
+// notes the use. A token without a session predates session tracking; it
+// stays valid unless the user signed out everywhere after it was issued.
+func (h *Handler) sessionActive(ctx context.Context, userID, sessionID string, claims jwt.MapClaims) bool {
+	if sessionID == "" {
+		var revokedAt sql.NullString
+		err := h.DB.QueryRowContext(ctx,
+			`SELECT sessions_revoked_at FROM users WHERE id = ?`, userID).Scan(&revokedAt)
+		if err != nil || !revokedAt.Valid {
+			return err == nil || err == sql.ErrNoRows
+		}
+		cutoff, err := time.Parse("2006-01-02T15:04:05Z", revokedAt.String)
+		if err != nil {
+			return false
+		}
+		iat, err := claims.GetIssuedAt()
+		return err == nil && iat != nil && iat.Time.After(cutoff)
+	}
+
+	var lastUsed sql.NullString
+	var revoked bool
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT last_used_at, revoked_at IS NOT NULL FROM user_sessions WHERE id = ? AND user_id = ?
+	`, sessionID, userID).Scan(&lastUsed, &revoked)
+	if err != nil || revoked {
+		return false
+	}
+	now := time.Now().UTC()
+	if t, err := time.Parse("2006-01-02T15:04:05Z", lastUsed.String); err != nil || now.Sub(t) > sessionTouchInterval {
+		h.DB.ExecContext(ctx, `UPDATE user_sessions SET last_used_at = ? WHERE id = ?`,
+			now.Format("2006-01-02T15:04:05Z"), sessionID)
+	}
+	return true
+}
+
+// revokeSessions signs the user out of every session but keep, which may
+// be "", including tokens issued before sessions were tracked. It returns
+// the number of sessions revoked.
+func (h *Handler) revokeSessions(ctx context.Context, conn *db.CompatConn, userID, keep string) (int64, error) {
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	res, err := conn.ExecContext(ctx, `
+		UPDATE user_sessions SET revoked_at = ?
+		WHERE user_id = ? AND id <> ? AND revoked_at IS NULL AND expires_at > ?
+	`, now, userID, keep, now)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := conn.ExecContext(ctx,
+		`UPDATE users SET sessions_revoked_at = ? WHERE id = ?`, now, userID); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// HandleListSessions lists where the user is signed in: each session's
+// device, the user agent and IP it was issued to, and when it was last
+// used, most recent first. The session making the request is marked
+// current.
+func (h *Handler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, _ := ExtractUserID(r)
+	current := SessionIDFromContext(r.Context())
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, user_agent, ip, created_at, last_used_at, expires_at FROM user_sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+	`, userID, time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list sessions"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := []map[string]interface{}{}
+	for rows.Next() {
+		var id, userAgent, ip, createdAt, expiresAt string
+		var lastUsed *string
+		if rows.Scan(&id, &userAgent, &ip, &createdAt, &lastUsed, &expiresAt) != nil {
+			continue
+		}
+		sessions = append(sessions, map[string]interface{}{
+			"id": id, "device": describeUserAgent(userAgent), "user_agent": userAgent, "ip": ip,
+			"created_at": createdAt, "last_used_at": lastUsed, "expires_at": expiresAt,
+			"current": id == current,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"sessions": sessions})
+}
+
+// HandleRevokeSession signs the user out of one session. Revoking the
+// current one signs this client out.
+func (h *Handler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, _ := ExtractUserID(r)
+	if refuseImpersonated(w, r) {
+		return
+	}
+	sessionID := chi.URLParam(r, "id")
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	var found bool
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		res, err := conn.ExecContext(r.Context(), `
+			UPDATE user_sessions SET revoked_at = ?
+			WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		`, now, sessionID, userID, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		found = true
+		return audit.Record(r.Context(), conn, userID, "account.session_revoke", userID,
+			map[string]interface{}{"session_id": sessionID})
+	}); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to revoke session"})
+		return
+	}
+	if !found {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "session not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "revoked"})
+}
+
+// HandleRevokeAllSessions signs the user out everywhere, including tokens
+// issued before sessions were tracked. With ?keep_current=true the session
+// making the request stays signed in.
+func (h *Handler) HandleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, _ := ExtractUserID(r)
+	if refuseImpersonated(w, r) {
+		return
+	}
+	keep := ""
+	if v := r.URL.Query().Get("keep_current"); v == "true" || v == "1" {
+		keep = SessionIDFromContext(r.Context())
+	}
+	var revoked int64
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var err error
+		if revoked, err = h.revokeSessions(r.Context(), conn, userID, keep); err != nil {
+			return err
+		}
+		return audit.Record(r.Context(), conn, userID, "account.sessions_revoke_all", userID,
+			map[string]interface{}{"revoked": revoked, "kept_current": keep != ""})
+	}); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"revoked": revoked})
+}
+
+// PruneSessions deletes sessions whose tokens have expired.
+func (h *Handler) PruneSessions(ctx context.Context) (int64, error) {
+	res, err := h.DB.ExecContext(ctx, `DELETE FROM user_sessions WHERE expires_at <= ?`,
+		time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SessionPruneLoop prunes expired sessions hourly.
+func (h *Handler) SessionPruneLoop() {
+	ticker := time.NewTicker(sessionPruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := h.PruneSessions(context.Background()); err != nil {
+			log.Printf("sessions: prune: %v", err)
+		} else if n > 0 {
+			log.Printf("sessions: pruned %d expired sessions", n)
+		}
+	}
+}
+
+// describeUserAgent names the browser and platform of a user agent, such
+// as "Firefox on Windows", for people telling their sessions apart.
+func describeUserAgent(ua string) string {
+	var browser, platform string
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+	switch {
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	case ua != "":
+		// Not a browser: API clients and scripts usually name themselves
+		// first, e.g. "curl/8.5.0".
+		if name, _, _ := strings.Cut(ua, " "); len(name) <= 64 {
+			return name
+		}
+	}
+	return "Unknown device"
+}
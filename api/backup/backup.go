@@ -0,0 +1,245 @@
+// Package backup snapshots the database to object storage and restores it.
+// SQLite databases are copied with VACUUM INTO, which gives a consistent
+// snapshot while the server keeps running; Postgres databases are dumped
+// with pg_dump in its custom format.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Prefix is the object storage prefix backups are written under.
+	Prefix = "backups/"
+
+	// DefaultKeep is how many backups are kept when Manager.Keep is unset.
+	DefaultKeep = 7
+
+	sqliteExt   = ".sqlite"
+	postgresExt = ".pgdump"
+	// uploadPartSize bounds the memory pg_dump uploads buffer, since their
+	// size is unknown up front.
+	uploadPartSize = 16 << 20
+)
+
+var (
+	// ErrInProgress is returned when a backup is requested while another
+	// is still running.
+	ErrInProgress = errors.New("a backup is already running")
+	// ErrNoStorage is returned when no object storage is configured.
+	ErrNoStorage = errors.New("object storage is not configured")
+)
+
+// sqliteHeader starts every SQLite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Info describes one stored backup.
+type Info struct {
+	Key       string `json:"key"`
+	SizeBytes int64  `json:"size_bytes"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Manager creates, lists, and rotates backups.
+type Manager struct {
+	DB *db.CompatDB
+	// DBURL is the Postgres connection string handed to pg_dump.
+	DBURL  string
+	Minio  *minio.Client
+	Bucket string
+	// Keep is how many of the newest backups rotation leaves; defaults to
+	// DefaultKeep.
+	Keep int
+	// TempDir holds SQLite snapshots until they are uploaded; defaults to
+	// the system temporary directory. Put it on the database's volume so
+	// there is room for a full copy.
+	TempDir string
+
+	mu sync.Mutex
+}
+
+func (m *Manager) keep() int {
+	if m.Keep > 0 {
+		return m.Keep
+	}
+	return DefaultKeep
+}
+
+// Create snapshots the database, uploads it under Prefix, and rotates out
+// backups beyond Keep. It returns the new backup and the keys it removed.
+func (m *Manager) Create(ctx context.Context) (Info, []string, error) {
+	if m.Minio == nil {
+		return Info{}, nil, ErrNoStorage
+	}
+	if !m.mu.TryLock() {
+		return Info{}, nil, ErrInProgress
+	}
+	defer m.mu.Unlock()
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	var info Info
+	var err error
+	if m.DB.IsPostgres() {
+		info, err = m.dumpPostgres(ctx, Prefix+"clipfeed-"+stamp+postgresExt)
+	} else {
+		info, err = m.snapshotSQLite(ctx, Prefix+"clipfeed-"+stamp+sqliteExt)
+	}
+	if err != nil {
+		return Info{}, nil, err
+	}
+	removed, err := m.rotate(ctx)
+	if err != nil {
+		// The backup itself succeeded; rotation retries on the next run.
+		log.Printf("backup rotation: %v", err)
+	}
+	return info, removed, nil
+}
+
+// SnapshotSQLite writes a consistent copy of the SQLite database to path,
+// which must not exist yet.
+func SnapshotSQLite(ctx context.Context, cdb *db.CompatDB, path string) error {
+	if _, err := cdb.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("vacuum into %s: %w", path, err)
+	}
+	return nil
+}
+
+func (m *Manager) snapshotSQLite(ctx context.Context, key string) (Info, error) {
+	dir, err := os.MkdirTemp(m.TempDir, "clipfeed-backup-")
+	if err != nil {
+		return Info{}, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot"+sqliteExt)
+	if err := SnapshotSQLite(ctx, m.DB, path); err != nil {
+		return Info{}, err
+	}
+	up, err := m.Minio.FPutObject(ctx, m.Bucket, key, path, minio.PutObjectOptions{ContentType: "application/vnd.sqlite3"})
+	if err != nil {
+		return Info{}, fmt.Errorf("upload %s: %w", key, err)
+	}
+	return Info{Key: key, SizeBytes: up.Size, CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z")}, nil
+}
+
+// dumpPostgres streams pg_dump output straight to object storage.
+func (m *Manager) dumpPostgres(ctx context.Context, key string) (Info, error) {
+	cmd := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--no-owner", "--dbname="+m.DBURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return Info{}, fmt.Errorf("pg_dump pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return Info{}, fmt.Errorf("start pg_dump: %w", err)
+	}
+	up, upErr := m.Minio.PutObject(ctx, m.Bucket, key, out, -1,
+		minio.PutObjectOptions{ContentType: "application/octet-stream", PartSize: uploadPartSize})
+	if upErr != nil {
+		// Drain so pg_dump is not left blocked on a full pipe.
+		io.Copy(io.Discard, out)
+	}
+	waitErr := cmd.Wait()
+	if upErr != nil || waitErr != nil {
+		m.Minio.RemoveObject(ctx, m.Bucket, key, minio.RemoveObjectOptions{})
+		if waitErr != nil {
+			return Info{}, fmt.Errorf("pg_dump: %v: %s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+		return Info{}, fmt.Errorf("upload %s: %w", key, upErr)
+	}
+	return Info{Key: key, SizeBytes: up.Size, CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z")}, nil
+}
+
+// List returns the stored backups, newest first.
+func (m *Manager) List(ctx context.Context) ([]Info, error) {
+	if m.Minio == nil {
+		return nil, ErrNoStorage
+	}
+	list := make([]Info, 0)
+	for obj := range m.Minio.ListObjects(ctx, m.Bucket, minio.ListObjectsOptions{Prefix: Prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if !strings.HasSuffix(obj.Key, sqliteExt) && !strings.HasSuffix(obj.Key, postgresExt) {
+			continue
+		}
+		list = append(list, Info{
+			Key: obj.Key, SizeBytes: obj.Size,
+			CreatedAt: obj.LastModified.UTC().Format("2006-01-02T15:04:05Z"),
+		})
+	}
+	// Keys embed their creation time, so they sort chronologically.
+	sort.Slice(list, func(i, j int) bool { return list[i].Key > list[j].Key })
+	return list, nil
+}
+
+// rotate deletes every backup beyond the newest Keep.
+func (m *Manager) rotate(ctx context.Context) ([]string, error) {
+	list, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, b := range list[min(m.keep(), len(list)):] {
+		if err := m.Minio.RemoveObject(ctx, m.Bucket, b.Key, minio.RemoveObjectOptions{}); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", b.Key, err)
+		}
+		removed = append(removed, b.Key)
+	}
+	return removed, nil
+}
+
+// HandleCreateBackup takes a backup now and reports it with the older
+// backups rotation removed.
+func (m *Manager) HandleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	info, removed, err := m.Create(r.Context())
+	switch {
+	case errors.Is(err, ErrNoStorage):
+		httputil.WriteJSON(w, 503, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, ErrInProgress):
+		httputil.WriteJSON(w, 409, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("backup failed: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "backup failed"})
+		return
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"backup": info, "rotated": removed, "keep": m.keep()})
+}
+
+// HandleListBackups lists stored backups, newest first.
+func (m *Manager) HandleListBackups(w http.ResponseWriter, r *http.Request) {
+	list, err := m.List(r.Context())
+	if errors.Is(err, ErrNoStorage) {
+		httputil.WriteJSON(w, 503, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list backups"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"backups": list, "keep": m.keep()})
+}
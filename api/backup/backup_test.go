@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T, path string) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestSnapshotAndRestoreSQLite(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	live := openTestDB(t, filepath.Join(dir, "live.db"))
+	live.Exec(`CREATE TABLE notes (body TEXT)`)
+	live.Exec(`INSERT INTO notes VALUES ('before backup')`)
+
+	snap := filepath.Join(dir, "snap.sqlite")
+	if err := SnapshotSQLite(ctx, live, snap); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	live.Exec(`INSERT INTO notes VALUES ('after backup')`)
+
+	// Restore over a database that already has other contents.
+	target := filepath.Join(dir, "target.db")
+	os.WriteFile(target, []byte("old database"), 0o644)
+	os.WriteFile(target+"-wal", []byte("old wal"), 0o644)
+	if err := Restore(ctx, nil, "", snap, Target{DBPath: target}); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	restored := openTestDB(t, target)
+	var n int
+	restored.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&n)
+	if n != 1 {
+		t.Fatalf("expected only the row from before the backup, got %d rows", n)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var kept, keptWAL bool
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), "target.db.restore-"):
+			t.Errorf("temp file %s left behind", e.Name())
+		case strings.HasPrefix(e.Name(), "target.db-wal.pre-restore-"):
+			keptWAL = true
+		case strings.HasPrefix(e.Name(), "target.db.pre-restore-"):
+			kept = true
+		}
+	}
+	if !kept || !keptWAL {
+		t.Fatal("expected the replaced database and its WAL to be kept beside the restored one")
+	}
+}
+
+func TestRestoreRejectsNonSQLiteFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bogus.sqlite")
+	os.WriteFile(src, []byte("definitely not sqlite"), 0o644)
+	target := filepath.Join(dir, "target.db")
+	os.WriteFile(target, []byte("keep me"), 0o644)
+
+	if err := Restore(context.Background(), nil, "", src, Target{DBPath: target}); err == nil {
+		t.Fatal("expected an error for a non-SQLite backup")
+	}
+	if got, _ := os.ReadFile(target); string(got) != "keep me" {
+		t.Fatalf("target was modified: %q", got)
+	}
+}
+
+func TestRestoreMissingSourceWithoutStorage(t *testing.T) {
+	err := Restore(context.Background(), nil, "", "backups/missing.sqlite", Target{DBPath: filepath.Join(t.TempDir(), "x.db")})
+	if err == nil || !strings.Contains(err.Error(), ErrNoStorage.Error()) {
+		t.Fatalf("expected a no-storage error, got %v", err)
+	}
+}
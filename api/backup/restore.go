@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Target is the database a backup is restored into.
+type Target struct {
+	Postgres bool
+	// DBPath is the SQLite database file.
+	DBPath string
+	// DBURL is the Postgres connection string handed to pg_restore.
+	DBURL string
+}
+
+// Restore loads the backup at source into target. source is a local file
+// path or, when client is set and no such file exists, an object key such
+// as "backups/clipfeed-20260101T000000Z.sqlite". The server must not be
+// running against target while it is restored.
+func Restore(ctx context.Context, client *minio.Client, bucket, source string, target Target) error {
+	path, cleanup, err := fetch(ctx, client, bucket, source)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if target.Postgres {
+		return restorePostgres(ctx, path, target.DBURL)
+	}
+	return restoreSQLite(path, target.DBPath)
+}
+
+// fetch resolves source to a local file, downloading it from object storage
+// when it is not already on disk.
+func fetch(ctx context.Context, client *minio.Client, bucket, source string) (string, func(), error) {
+	if _, err := os.Stat(source); err == nil {
+		return source, func() {}, nil
+	}
+	if client == nil {
+		return "", nil, fmt.Errorf("%s: no such file and %w", source, ErrNoStorage)
+	}
+	key := source
+	if !strings.HasPrefix(key, Prefix) {
+		key = Prefix + key
+	}
+	f, err := os.CreateTemp("", "clipfeed-restore-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create temp file: %w", err)
+	}
+	f.Close()
+	cleanup := func() { os.Remove(f.Name()) }
+	if err := client.FGetObject(ctx, bucket, key, f.Name(), minio.GetObjectOptions{}); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("download %s: %w", key, err)
+	}
+	return f.Name(), cleanup, nil
+}
+
+// restoreSQLite copies the snapshot over dbPath. The copy is written and
+// synced beside dbPath first, so a failed restore leaves the current
+// database in place. The current database and its WAL files are kept beside
+// it with a ".pre-restore-<time>" suffix, and moved back if the copy cannot
+// be put in place.
+func restoreSQLite(src, dbPath string) error {
+	header := make([]byte, len(sqliteHeader))
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, sqliteHeader) {
+		return fmt.Errorf("%s is not a SQLite backup", src)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-")
+	if err != nil {
+		return fmt.Errorf("create temp file beside %s: %w", dbPath, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	suffix := ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+	var moved []string
+	moveBack := func() {
+		for _, p := range moved {
+			if err := os.Rename(p+suffix, p); err != nil {
+				log.Printf("restore: move back %s: %v", p, err)
+			}
+		}
+	}
+	for _, p := range []string{dbPath, dbPath + "-wal", dbPath + "-shm"} {
+		if err := os.Rename(p, p+suffix); err != nil && !os.IsNotExist(err) {
+			moveBack()
+			return fmt.Errorf("move aside %s: %w", p, err)
+		} else if err == nil {
+			moved = append(moved, p)
+		}
+	}
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		moveBack()
+		return fmt.Errorf("move restored database to %s: %w", dbPath, err)
+	}
+	return nil
+}
+
+// restorePostgres replaces the database's objects with those in the dump.
+func restorePostgres(ctx context.Context, src, dbURL string) error {
+	cmd := exec.CommandContext(ctx, "pg_restore", "--clean", "--if-exists", "--no-owner", "--single-transaction",
+		"--dbname="+dbURL, src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/devdata"
+)
+
+// benchDatasetOptions sizes the seeded database. BENCH_CLIPS and BENCH_USERS
+// override the defaults, e.g. BENCH_CLIPS=10000 for production-like runs.
+func benchDatasetOptions() devdata.Options {
+	opts := devdata.DefaultOptions()
+	opts.Clips = 2000
+	opts.Users = 20
+	if n, err := strconv.Atoi(getEnv("BENCH_CLIPS", "")); err == nil && n > 0 {
+		opts.Clips = n
+	}
+	if n, err := strconv.Atoi(getEnv("BENCH_USERS", "")); err == nil && n > 0 {
+		opts.Users = n
+	}
+	return opts
+}
+
+// benchLatency runs fn b.N times and reports p50/p95/p99 latency alongside
+// the usual ns/op.
+func benchLatency(b *testing.B, fn func(i int) int) {
+	b.Helper()
+	samples := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		began := time.Now()
+		if code := fn(i); code != http.StatusOK {
+			b.Fatalf("request %d: status %d", i, code)
+		}
+		samples = append(samples, time.Since(began))
+	}
+	b.StopTimer()
+	st := summarizeLatencies("", samples, 0, 0)
+	b.ReportMetric(st.P50ms, "p50-ms")
+	b.ReportMetric(st.P95ms, "p95-ms")
+	b.ReportMetric(st.P99ms, "p99-ms")
+}
+
+// BenchmarkReadPaths measures the hot read endpoints against a seeded
+// synthetic dataset. Run with: go test -run '^$' -bench ReadPaths .
+func BenchmarkReadPaths(b *testing.B) {
+	h := newTestHandlers(b)
+	opts := benchDatasetOptions()
+	if _, err := devdata.Generate(context.Background(), h.db, opts); err != nil {
+		b.Fatalf("seed dataset: %v", err)
+	}
+	terms := devdata.SearchTerms()
+
+	b.Run("feed/anonymous", func(b *testing.B) {
+		benchLatency(b, func(int) int {
+			rec := httptest.NewRecorder()
+			h.feedH.HandleFeed(rec, httptest.NewRequest("GET", "/api/feed", nil))
+			return rec.Code
+		})
+	})
+
+	b.Run("feed/personalized", func(b *testing.B) {
+		benchLatency(b, func(i int) int {
+			req := httptest.NewRequest("GET", "/api/feed", nil)
+			req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, devdata.UserID(i%opts.Users)))
+			rec := httptest.NewRecorder()
+			h.feedH.HandleFeed(rec, req)
+			return rec.Code
+		})
+	})
+
+	b.Run("search", func(b *testing.B) {
+		benchLatency(b, func(i int) int {
+			rec := httptest.NewRecorder()
+			h.feedH.HandleSearch(rec, httptest.NewRequest("GET", "/api/search?q="+url.QueryEscape(terms[i%len(terms)]), nil))
+			return rec.Code
+		})
+	})
+
+	b.Run("similar", func(b *testing.B) {
+		rng := rand.New(rand.NewSource(opts.Seed))
+		benchLatency(b, func(int) int {
+			id := devdata.ClipID(rng.Intn(opts.Clips))
+			req := withChiParam(httptest.NewRequest("GET", "/api/clips/"+id+"/similar", nil), "id", id)
+			rec := httptest.NewRecorder()
+			h.feedH.HandleSimilarClips(rec, req)
+			return rec.Code
+		})
+	})
+}
+
+func TestPercentile_NearestRank(t *testing.T) {
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(100-i) * time.Millisecond
+	}
+	st := summarizeLatencies("x", samples, 0, 2*time.Second)
+	if st.P50ms != 50 || st.P95ms != 95 || st.P99ms != 99 || st.MaxMs != 100 {
+		t.Errorf("got p50=%v p95=%v p99=%v max=%v", st.P50ms, st.P95ms, st.P99ms, st.MaxMs)
+	}
+	if st.RPS != 50 {
+		t.Errorf("rps = %v, want 50", st.RPS)
+	}
+	if percentile(nil, 99) != 0 {
+		t.Error("percentile of no samples should be 0")
+	}
+}
@@ -1,23 +1,32 @@
 package clips
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"clipfeed/auth"
+	"clipfeed/contentfilter"
 	"clipfeed/db"
+	"clipfeed/feed"
 	"clipfeed/httputil"
+	"clipfeed/llmpool"
+	"clipfeed/tracing"
+	"clipfeed/transcripts"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Handler holds dependencies for clip-related endpoints.
@@ -25,6 +34,16 @@ type Handler struct {
 	DB          *db.CompatDB
 	Minio       *minio.Client
 	MinioBucket string
+	// StreamMode is StreamModePresign (the default) or StreamModeProxy.
+	StreamMode string
+	// StreamTTL is how long URLs from the stream endpoint stay valid;
+	// defaults to two hours.
+	StreamTTL time.Duration
+	// StreamSecret signs proxy stream URLs.
+	StreamSecret string
+	// IsAdmin reports whether a request carries an admin token, which may
+	// read any clip's retention curve.
+	IsAdmin func(r *http.Request) bool
 }
 
 // HandleGetClip returns a single clip's metadata.
@@ -34,23 +53,27 @@ func (h *Handler) HandleGetClip(w http.ResponseWriter, r *http.Request) {
 	var id, title, description, thumbnailKey, topicsJSON, tagsJSON, status, createdAt string
 	var duration, score float64
 	var width, height, fileSize *int64
-	var channelName, platform, sourceURL *string
+	var channelName, platform, sourceURL, uploader, license *string
+	var startTime, endTime *float64
+	var rating RatingSummary
 
 	err := h.DB.QueryRowContext(r.Context(), `
 		SELECT c.id, c.title, c.description, c.duration_seconds,
 		       c.thumbnail_key, c.topics, c.tags, c.content_score,
 		       c.status, c.created_at, c.width, c.height, c.file_size_bytes,
-		       s.channel_name, s.platform, s.url
+		       c.start_time, c.end_time, c.rating_avg, c.rating_count,
+		       s.channel_name, s.platform, s.url, s.uploader, s.license
 		FROM clips c
 		LEFT JOIN sources s ON c.source_id = s.id
 		WHERE c.id = ?
 	`, clipID).Scan(&id, &title, &description, &duration,
 		&thumbnailKey, &topicsJSON, &tagsJSON, &score,
 		&status, &createdAt, &width, &height, &fileSize,
-		&channelName, &platform, &sourceURL)
+		&startTime, &endTime, &rating.Average, &rating.Count,
+		&channelName, &platform, &sourceURL, &uploader, &license)
 
-	if err != nil {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+	if err != nil || status == TombstoneExpired || status == TombstoneEvicted {
+		h.writeMissing(w, r, clipID)
 		return
 	}
 
@@ -58,46 +81,96 @@ func (h *Handler) HandleGetClip(w http.ResponseWriter, r *http.Request) {
 	json.Unmarshal([]byte(topicsJSON), &topics)
 	json.Unmarshal([]byte(tagsJSON), &tags)
 
+	// The caller's own rating, when signed in and they rated the clip.
+	var myRating *int
+	if userID, ok := auth.ExtractUserID(r); ok {
+		var stars int
+		if h.DB.QueryRowContext(r.Context(),
+			`SELECT rating FROM clip_ratings WHERE user_id = ? AND clip_id = ?`, userID, clipID,
+		).Scan(&stars) == nil {
+			myRating = &stars
+		}
+	}
+
 	httputil.WriteJSON(w, 200, map[string]interface{}{
 		"id": id, "title": title, "description": description,
-		"duration_seconds": duration, "thumbnail_key": thumbnailKey,
+		"duration_seconds": duration, "duration_bucket": httputil.DurationBucket(duration),
+		"thumbnail_key": thumbnailKey,
 		"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumbnailKey),
 		"topics": topics, "tags": tags, "content_score": score,
 		"status": status, "created_at": createdAt,
 		"width": width, "height": height, "file_size_bytes": fileSize,
 		"channel_name": channelName, "platform": platform,
-		"source_url": sourceURL,
+		"source_url": sourceURL, "uploader": uploader, "license": license,
+		"attribution": httputil.Attribution(platform, sourceURL, channelName, startTime, endTime),
+		"content_filters": contentfilter.Tags(r.Context(), h.DB, clipID),
+		"rating": rating, "my_rating": myRating,
 	})
 }
 
-// HandleStreamClip returns a presigned stream URL for a ready clip.
+// HandleStreamClip returns a stream URL for a ready clip, with when it
+// expires and when clients should refresh it, and which rendition it
+// plays: low in data saver mode when the clip has one, else standard. For
+// a signed-in user it starts playback on their device, refused with 429
+// when the account's concurrent stream limit is taken by other devices.
+// With format=hls it serves a signed HLS master playlist instead.
 func (h *Handler) HandleStreamClip(w http.ResponseWriter, r *http.Request) {
 	clipID := chi.URLParam(r, "id")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != FormatMP4 && format != FormatHLS {
+		httputil.WriteJSON(w, 400, map[string]string{"error": `format must be "mp4" or "hls"`})
+		return
+	}
 
-	var storageKey string
+	var storageKey, lowKey string
+	var generation int64
 	err := h.DB.QueryRowContext(r.Context(),
-		`SELECT storage_key FROM clips WHERE id = ? AND status = 'ready'`,
-		clipID).Scan(&storageKey)
+		`SELECT storage_key, COALESCE(low_storage_key, ''), stream_generation FROM clips WHERE id = ? AND status = 'ready'`,
+		clipID).Scan(&storageKey, &lowKey, &generation)
 
 	if err != nil {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		h.writeMissing(w, r, clipID)
 		return
 	}
 
-	presignedURL, err := h.Minio.PresignedGetObject(r.Context(),
-		h.MinioBucket, storageKey, 2*time.Hour, nil)
+	if userID := streamUser(r.Context()); userID != "" {
+		limit, active, err := h.claimStream(r.Context(), r, userID, clipID)
+		if errors.Is(err, errStreamLimit) {
+			writeStreamLimit(w, *limit, active)
+			return
+		}
+		if err != nil {
+			log.Printf("claim stream %s for %s: %v", clipID, userID, err)
+		}
+	}
 
-	if err != nil {
-		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate stream URL"})
+	if format == FormatHLS {
+		h.serveHLSMaster(w, r, clipID, generation)
 		return
 	}
-	streamURL, err := BuildBrowserStreamURL(presignedURL.String())
+
+	key, quality := h.streamRendition(r.Context(), storageKey, lowKey)
+	link, err := h.streamLink(r.Context(), key, generation)
 	if err != nil {
-		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build stream URL"})
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate stream URL"})
 		return
 	}
+	link["quality"] = quality
 
-	httputil.WriteJSON(w, 200, map[string]string{"url": streamURL})
+	httputil.WriteJSON(w, 200, link)
+}
+
+// PresignStreamURL issues a stream URL for storageKey that stays valid for
+// expiry: a presigned MinIO path, or a signed proxy URL in proxy mode.
+func (h *Handler) PresignStreamURL(ctx context.Context, storageKey string, expiry time.Duration) (string, error) {
+	var generation int64
+	if h.StreamMode == StreamModeProxy {
+		if err := h.DB.QueryRowContext(ctx,
+			`SELECT stream_generation FROM clips WHERE storage_key = ? OR low_storage_key = ?`, storageKey, storageKey).Scan(&generation); err != nil {
+			return "", fmt.Errorf("load stream generation: %w", err)
+		}
+	}
+	return h.streamURL(ctx, storageKey, generation, expiry)
 }
 
 // BuildBrowserStreamURL converts a presigned MinIO URL into a browser-facing
@@ -122,6 +195,11 @@ type InteractionRequest struct {
 	Action          string  `json:"action"`
 	WatchDuration   float64 `json:"watch_duration_seconds"`
 	WatchPercentage float64 `json:"watch_percentage"`
+	// Segments are the stretches of the clip that were played, which feed
+	// its retention curve.
+	Segments []WatchSegment `json:"segments"`
+	// Rating is the number of stars, from 1 to 5, of a rate action.
+	Rating int `json:"rating"`
 }
 
 // HandleInteraction records a user interaction with a clip.
@@ -137,35 +215,135 @@ func (h *Handler) HandleInteraction(w http.ResponseWriter, r *http.Request) {
 
 	validActions := map[string]bool{
 		"view": true, "like": true, "dislike": true,
-		"save": true, "share": true, "skip": true, "watch_full": true,
+		"save": true, "share": true, "skip": true, "watch_full": true, "rate": true,
 	}
 	if !validActions[req.Action] {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid action"})
 		return
 	}
+	if req.Action == "rate" && (req.Rating < minRating || req.Rating > maxRating) {
+		httputil.WriteJSON(w, 400, map[string]string{
+			"error": fmt.Sprintf("rating must be a whole number from %d to %d", minRating, maxRating),
+		})
+		return
+	}
+	if !validSegments(req.Segments) {
+		httputil.WriteJSON(w, 400, map[string]string{
+			"error": fmt.Sprintf("segments must be at most %d {start, end} offsets with 0 <= start < end", maxWatchSegments),
+		})
+		return
+	}
 
-	var exists int
-	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+	var duration float64
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT duration_seconds FROM clips WHERE id = ?`, clipID).Scan(&duration); err != nil {
+		h.writeMissing(w, r, clipID)
 		return
 	}
 
+	// A rating always replaces the user's last one, so rate is never
+	// deduplicated.
+	var rating interface{}
+	var ratingSummary RatingSummary
+	outcomeAction := req.Action
+	if req.Action == "rate" {
+		var err error
+		if ratingSummary, err = h.recordRating(r.Context(), userID, clipID, req.Rating); err != nil {
+			log.Printf("rate clip %s: %v", clipID, err)
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to record rating"})
+			return
+		}
+		rating, outcomeAction = req.Rating, ratingAction(req.Rating)
+	}
+
+	if h.isDuplicateInteraction(r.Context(), userID, clipID, req.Action) {
+		httputil.WriteJSON(w, 200, map[string]string{"status": "deduplicated"})
+		return
+	}
+
+	var repeats int
+	h.DB.QueryRowContext(r.Context(), fmt.Sprintf(`
+		SELECT COUNT(*) FROM interactions
+		WHERE user_id = ? AND clip_id = ? AND action = ? AND created_at > %s
+	`, h.DB.DatetimeModifier("-24 hours")), userID, clipID, req.Action).Scan(&repeats)
+
 	interactionID := uuid.New().String()
 	_, err := h.DB.ExecContext(r.Context(), `
-		INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, watch_percentage)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, interactionID, userID, clipID, req.Action, req.WatchDuration, req.WatchPercentage)
+		INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, watch_percentage, rating)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, interactionID, userID, clipID, req.Action, req.WatchDuration, req.WatchPercentage, rating)
 
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to record interaction"})
 		return
 	}
+	if len(req.Segments) > 0 {
+		if err := h.recordRetention(r.Context(), clipID, duration, req.Segments); err != nil {
+			log.Printf("record retention for clip %s: %v", clipID, err)
+		}
+	}
+	h.detectInteractionAnomalies(r.Context(), userID)
+	h.applyScoreDelta(r.Context(), clipID, outcomeAction, req.WatchPercentage,
+		repeats > 0 || h.isFlaggedUser(r.Context(), userID))
+	feed.RecordBanditOutcome(r.Context(), h.DB, userID, clipID, outcomeAction, req.WatchPercentage)
+	if req.Action == "view" {
+		feed.RecordPacingView(r.Context(), h.DB, userID)
+	}
 
+	if req.Action == "rate" {
+		httputil.WriteJSON(w, 200, map[string]interface{}{"status": "recorded", "rating": ratingSummary})
+		return
+	}
 	httputil.WriteJSON(w, 200, map[string]string{"status": "recorded"})
 }
 
+// --- Transcript ---
+
+// HandleGetTranscript returns a clip's transcript. With ?chunk=N it returns
+// only that chunk and the number of chunks, so long transcripts can be read
+// a piece at a time.
+func (h *Handler) HandleGetTranscript(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var length int
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT transcript_length FROM clips WHERE id = ?`, clipID).Scan(&length); err != nil {
+		h.writeMissing(w, r, clipID)
+		return
+	}
+
+	if raw := r.URL.Query().Get("chunk"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "chunk must be a number"})
+			return
+		}
+		text, chunks, err := transcripts.LoadChunk(r.Context(), h.DB, clipID, n)
+		if errors.Is(err, transcripts.ErrNoChunk) {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "chunk not found"})
+			return
+		}
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load transcript"})
+			return
+		}
+		httputil.WriteJSON(w, 200, map[string]interface{}{
+			"clip_id": clipID, "chunk": n, "chunks": chunks, "text": text, "length": length,
+		})
+		return
+	}
+
+	text, err := transcripts.Load(r.Context(), h.DB, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load transcript"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"clip_id": clipID, "transcript": text, "length": length})
+}
+
 // --- Clip Summary (LLM) ---
 
+// summaryPromptRunes caps the length of a summary prompt.
+const summaryPromptRunes = 4000
+
 // HandleClipSummary generates or retrieves a cached LLM summary for a clip.
 func (h *Handler) HandleClipSummary(w http.ResponseWriter, r *http.Request) {
 	clipID := chi.URLParam(r, "id")
@@ -180,12 +358,15 @@ func (h *Handler) HandleClipSummary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var transcript string
-	err = h.DB.QueryRowContext(r.Context(),
-		`SELECT COALESCE(transcript, '') FROM clips WHERE id = ?`, clipID,
-	).Scan(&transcript)
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
+		h.writeMissing(w, r, clipID)
+		return
+	}
+	// The prompt is cut to summaryPromptRunes, so later chunks are never read.
+	transcript, err := transcripts.LoadPrefix(r.Context(), h.DB, clipID, summaryPromptRunes)
 	if err != nil {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load transcript"})
 		return
 	}
 	if transcript == "" {
@@ -195,20 +376,25 @@ func (h *Handler) HandleClipSummary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	prompt := fmt.Sprintf("Summarize this video transcript in 1-2 sentences:\n\n%s", transcript)
-	if runes := []rune(prompt); len(runes) > 4000 {
-		prompt = string(runes[:4000])
+	if runes := []rune(prompt); len(runes) > summaryPromptRunes {
+		prompt = string(runes[:summaryPromptRunes])
 	}
 
 	log.Printf("[LLM] Generating summary for clip %s (transcript_len=%d)", clipID, len(transcript))
 	start := time.Now()
-	summaryText, modelName, err := GenerateSummaryWithLLM(prompt)
+	summaryText, modelName, err := GenerateSummaryWithLLM(r.Context(), prompt)
 	durationMs := time.Since(start).Milliseconds()
 
 	if err != nil {
 		log.Printf("[LLM] Summary generation FAILED for clip %s: %v", clipID, err)
-		h.DB.ExecContext(r.Context(),
+		// Log the failure even when the request ran out of time.
+		h.DB.ExecContext(context.WithoutCancel(r.Context()),
 			`INSERT INTO llm_logs (system, model, prompt, error, duration_ms) VALUES (?, ?, ?, ?, ?)`,
 			"summary", modelName, prompt, err.Error(), durationMs)
+		if errors.Is(err, context.DeadlineExceeded) {
+			httputil.WriteJSON(w, 504, map[string]string{"error": "LLM timed out"})
+			return
+		}
 		httputil.WriteJSON(w, 200, map[string]interface{}{"clip_id": clipID, "summary": "", "error": "LLM unavailable"})
 		return
 	}
@@ -231,8 +417,13 @@ func (h *Handler) HandleClipSummary(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]interface{}{"clip_id": clipID, "summary": summaryText, "cached": false})
 }
 
+// LLMPool, when set, spreads Ollama requests over several endpoints with
+// health checks and circuit breaking, in place of LLM_BASE_URL/LLM_URL.
+var LLMPool *llmpool.Pool
+
 // GenerateSummaryWithLLM calls the configured LLM provider to generate text.
-func GenerateSummaryWithLLM(prompt string) (string, string, error) {
+// The call is abandoned when ctx is done.
+func GenerateSummaryWithLLM(ctx context.Context, prompt string) (_, _ string, err error) {
 	provider := strings.ToLower(strings.TrimSpace(getEnv("LLM_PROVIDER", "ollama")))
 	model := strings.TrimSpace(getEnv("LLM_MODEL", ""))
 	if model == "" {
@@ -251,45 +442,24 @@ func GenerateSummaryWithLLM(prompt string) (string, string, error) {
 	}
 
 	log.Printf("[LLM] Summary request: provider=%s model=%s base_url=%s prompt_len=%d", provider, model, baseURL, len(prompt))
+	ctx, span := tracing.Start(ctx, "llm.generate",
+		attribute.String("llm.provider", provider), attribute.String("llm.model", model))
+	defer func() { tracing.End(span, err) }()
 
 	start := time.Now()
 	client := &http.Client{Timeout: 60 * time.Second}
 	if provider == "" || provider == "ollama" {
-		reqBody, _ := json.Marshal(map[string]interface{}{
-			"model":  model,
-			"prompt": prompt,
-			"stream": false,
-		})
-
-		endpoint := baseURL + "/api/generate"
-		log.Printf("[LLM] POST %s (model=%s)", endpoint, model)
-		resp, err := client.Post(endpoint, "application/json", strings.NewReader(string(reqBody)))
-		if err != nil {
-			log.Printf("[LLM] Request FAILED: %v (elapsed=%v)", err, time.Since(start))
-			return "", model, err
+		if LLMPool == nil {
+			text, err := generateWithOllama(ctx, client, baseURL, model, prompt)
+			return text, model, err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode >= 400 {
-			log.Printf("[LLM] Request returned error status=%d (elapsed=%v)", resp.StatusCode, time.Since(start))
-			return "", model, fmt.Errorf("llm request failed: status=%d", resp.StatusCode)
-		}
-
-		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
-		if err != nil {
-			log.Printf("[LLM] Response read FAILED: %v", err)
-			return "", model, err
-		}
-		var result struct {
-			Response string `json:"response"`
-		}
-		if err := json.Unmarshal(body, &result); err != nil {
-			log.Printf("[LLM] Response parse FAILED: %v", err)
-			return "", model, err
-		}
-		text := strings.TrimSpace(result.Response)
-		log.Printf("[LLM] Ollama response: model=%s elapsed=%v response_len=%d", model, time.Since(start), len(text))
-		return text, model, nil
+		var text string
+		err := LLMPool.Do(ctx, llmpool.TaskSummary, func(ctx context.Context, baseURL string) error {
+			var err error
+			text, err = generateWithOllama(ctx, client, baseURL, model, prompt)
+			return err
+		})
+		return text, model, err
 	}
 
 	apiKey := strings.TrimSpace(getEnv("LLM_API_KEY", ""))
@@ -313,7 +483,7 @@ func GenerateSummaryWithLLM(prompt string) (string, string, error) {
 
 		endpoint := baseURL + "/messages"
 		log.Printf("[LLM] POST %s (model=%s, anthropic_version=%s)", endpoint, model, anthropicVersion)
-		req, err := http.NewRequest("POST", endpoint, strings.NewReader(string(reqBody)))
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(string(reqBody)))
 		if err != nil {
 			return "", model, err
 		}
@@ -371,7 +541,7 @@ func GenerateSummaryWithLLM(prompt string) (string, string, error) {
 
 	endpoint := baseURL + "/chat/completions"
 	log.Printf("[LLM] POST %s (model=%s)", endpoint, model)
-	req, err := http.NewRequest("POST", endpoint, strings.NewReader(string(reqBody)))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(string(reqBody)))
 	if err != nil {
 		return "", model, err
 	}
@@ -434,6 +604,55 @@ func GenerateSummaryWithLLM(prompt string) (string, string, error) {
 	return strings.Join(parts, " "), model, nil
 }
 
+// generateWithOllama asks the Ollama server at baseURL to complete the
+// prompt.
+func generateWithOllama(ctx context.Context, client *http.Client, baseURL, model, prompt string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "ollama.generate", attribute.String("server.address", baseURL))
+	defer func() { tracing.End(span, err) }()
+	start := time.Now()
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"model":  model,
+		"prompt": prompt,
+		"stream": false,
+	})
+
+	endpoint := baseURL + "/api/generate"
+	log.Printf("[LLM] POST %s (model=%s)", endpoint, model)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(string(reqBody)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[LLM] Request FAILED: %v (elapsed=%v)", err, time.Since(start))
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		log.Printf("[LLM] Request returned error status=%d (elapsed=%v)", resp.StatusCode, time.Since(start))
+		return "", fmt.Errorf("llm request failed: status=%d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		log.Printf("[LLM] Response read FAILED: %v", err)
+		return "", err
+	}
+	var result struct {
+		Response string `json:"response"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Printf("[LLM] Response parse FAILED: %v", err)
+		return "", err
+	}
+	text := strings.TrimSpace(result.Response)
+	log.Printf("[LLM] Ollama response: model=%s elapsed=%v response_len=%d", model, time.Since(start), len(text))
+	return text, nil
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -0,0 +1,171 @@
+package clips
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/datasaver"
+	"clipfeed/hls"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Stream formats. Clips without HLS renditions only stream as MP4.
+const (
+	FormatMP4 = "mp4"
+	FormatHLS = "hls"
+)
+
+const hlsContentType = "application/vnd.apple.mpegurl"
+
+// hlsCacheControl keeps cached playlists from outliving the URLs inside
+// them.
+func hlsCacheControl(ttl time.Duration) string {
+	return fmt.Sprintf("private, max-age=%d", int(time.Duration(float64(ttl)*streamRefreshFraction).Seconds()))
+}
+
+// signHLSPlaylistURL returns the URL of one of a clip's HLS media
+// playlists, signed over its playlist key like a proxy stream URL, so it
+// is valid until expiry passes or the clip's stream generation changes.
+func (h *Handler) signHLSPlaylistURL(clipID, variant, playlistKey, userID string, generation int64, expiry time.Duration) string {
+	expires := time.Now().Add(expiry).Unix()
+	q := url.Values{}
+	q.Set("gen", strconv.FormatInt(generation, 10))
+	q.Set("exp", strconv.FormatInt(expires, 10))
+	if userID != "" {
+		q.Set("uid", userID)
+	}
+	q.Set("sig", streamSignature(h.StreamSecret, playlistKey, userID, generation, expires))
+	return fmt.Sprintf("/api/clips/%s/hls/%s.m3u8?%s", url.PathEscape(clipID), variant, q.Encode())
+}
+
+// serveHLSMaster writes the master playlist of a ready clip's HLS
+// renditions, lowest bandwidth first. Data saver mode offers only the
+// lowest.
+func (h *Handler) serveHLSMaster(w http.ResponseWriter, r *http.Request, clipID string, generation int64) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT name, bandwidth, width, height, codecs, playlist_key FROM clip_hls_variants
+		WHERE clip_id = ? ORDER BY bandwidth, name
+	`, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load HLS renditions"})
+		return
+	}
+	var variants []hls.Variant
+	playlistKeys := make(map[string]string)
+	for rows.Next() {
+		var v hls.Variant
+		var key string
+		if rows.Scan(&v.Name, &v.Bandwidth, &v.Width, &v.Height, &v.Codecs, &key) == nil {
+			variants = append(variants, v)
+			playlistKeys[v.Name] = key
+		}
+	}
+	rows.Close()
+	if len(variants) == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip has no HLS renditions; stream it as MP4"})
+		return
+	}
+	if datasaver.Enabled(r.Context(), h.DB) {
+		variants = variants[:1]
+	}
+
+	ttl := h.streamTTL()
+	userID := streamUser(r.Context())
+	out, err := hls.Master(variants, func(v hls.Variant) (string, error) {
+		return h.signHLSPlaylistURL(clipID, v.Name, playlistKeys[v.Name], userID, generation, ttl), nil
+	})
+	if err != nil {
+		log.Printf("hls master %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate stream URL"})
+		return
+	}
+	w.Header().Set("Content-Type", hlsContentType)
+	w.Header().Set("Cache-Control", hlsCacheControl(ttl))
+	io.WriteString(w, out)
+}
+
+// HandleHLSPlaylist serves one of a ready clip's HLS media playlists for a
+// URL from its master playlist, with each segment replaced by a stream URL
+// that expires with the playlist URL and is attributed to the same user.
+func (h *Handler) HandleHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	clipID, variant := chi.URLParam(r, "id"), chi.URLParam(r, "variant")
+	var playlistKey, playlist string
+	var current int64
+	err := h.DB.QueryRowContext(r.Context(), `
+		SELECT v.playlist_key, v.playlist, c.stream_generation FROM clip_hls_variants v
+		JOIN clips c ON c.id = v.clip_id
+		WHERE v.clip_id = ? AND v.name = ? AND c.status = 'ready'
+	`, clipID, variant).Scan(&playlistKey, &playlist, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "playlist not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load playlist"})
+		return
+	}
+
+	q := r.URL.Query()
+	generation, genErr := strconv.ParseInt(q.Get("gen"), 10, 64)
+	expires, expErr := strconv.ParseInt(q.Get("exp"), 10, 64)
+	userID := q.Get("uid")
+	if genErr != nil || expErr != nil ||
+		!hmac.Equal([]byte(q.Get("sig")), []byte(streamSignature(h.StreamSecret, playlistKey, userID, generation, expires))) {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "invalid stream signature"})
+		return
+	}
+	expiry := time.Until(time.Unix(expires, 0))
+	if expiry <= 0 {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "stream URL expired"})
+		return
+	}
+	if current != generation {
+		httputil.WriteJSON(w, 410, map[string]string{"error": "stream revoked"})
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT segment_key FROM clip_hls_segments WHERE clip_id = ? AND variant = ? ORDER BY segment_index`, clipID, variant)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load playlist"})
+		return
+	}
+	var segmentKeys []string
+	for rows.Next() {
+		var key string
+		if rows.Scan(&key) == nil {
+			segmentKeys = append(segmentKeys, key)
+		}
+	}
+	rows.Close()
+
+	// Players fetch media playlists without credentials, so segment usage
+	// goes to the user the master playlist was issued to.
+	ctx := r.Context()
+	if userID != "" {
+		ctx = context.WithValue(ctx, auth.UserIDKey, userID)
+	}
+	out, err := hls.Rewrite(playlist, segmentKeys, func(key string) (string, error) {
+		return h.streamURL(ctx, key, generation, expiry)
+	})
+	if err != nil {
+		log.Printf("hls playlist %s/%s: %v", clipID, variant, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to sign playlist"})
+		return
+	}
+	w.Header().Set("Content-Type", hlsContentType)
+	w.Header().Set("Cache-Control", hlsCacheControl(expiry))
+	io.WriteString(w, out)
+}
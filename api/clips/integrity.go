@@ -0,0 +1,115 @@
+package clips
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// interactionDedupWindows is how long after recording an action the same
+// (user, clip, action) is dropped as a duplicate instead of being stored.
+// Players that re-send view events on every seek or replay would otherwise
+// inflate view counts and the score inputs derived from them.
+var interactionDedupWindows = map[string]time.Duration{
+	"view":       30 * time.Second,
+	"watch_full": 30 * time.Second,
+	"skip":       30 * time.Second,
+	"like":       10 * time.Second,
+	"dislike":    10 * time.Second,
+	"save":       10 * time.Second,
+	"share":      10 * time.Second,
+}
+
+// Thresholds for flagging interaction patterns that look automated. Bursts
+// are measured over one minute; low-watch views over ten minutes.
+const (
+	burstWindow         = time.Minute
+	burstThreshold      = 30
+	lowWatchWindow      = 10 * time.Minute
+	lowWatchMinViews    = 20
+	lowWatchMaxAvgWatch = 0.05
+	flagReasonBurst     = "burst_rate"
+	flagReasonLowWatch  = "low_watch_views"
+)
+
+func isoCutoff(ago time.Duration) string {
+	return time.Now().UTC().Add(-ago).Format("2006-01-02T15:04:05Z")
+}
+
+// isDuplicateInteraction reports whether the user already recorded action
+// on the clip within its dedup window.
+func (h *Handler) isDuplicateInteraction(ctx context.Context, userID, clipID, action string) bool {
+	window, ok := interactionDedupWindows[action]
+	if !ok {
+		return false
+	}
+	var n int
+	h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM interactions
+		WHERE user_id = ? AND clip_id = ? AND action = ? AND created_at >= ?
+	`, userID, clipID, action, isoCutoff(window)).Scan(&n)
+	return n > 0
+}
+
+// isFlaggedUser reports whether the user has an open or confirmed anomaly
+// flag. Their interactions are still recorded but no longer nudge scores.
+func (h *Handler) isFlaggedUser(ctx context.Context, userID string) bool {
+	var n int
+	h.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM interaction_flags WHERE user_id = ? AND status IN ('open', 'confirmed')`,
+		userID).Scan(&n)
+	return n > 0
+}
+
+// detectInteractionAnomalies flags the user when their recent interactions
+// arrive faster than a person could produce them, or when they view many
+// clips while watching almost none of each.
+func (h *Handler) detectInteractionAnomalies(ctx context.Context, userID string) {
+	var burst, views int
+	var avgWatch float64
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN action = 'view' THEN 1 ELSE 0 END), 0),
+			COALESCE(AVG(CASE WHEN action = 'view' THEN COALESCE(watch_percentage, 0) END), 0)
+		FROM interactions
+		WHERE user_id = ? AND created_at >= ?
+	`, isoCutoff(burstWindow), userID, isoCutoff(lowWatchWindow)).Scan(&burst, &views, &avgWatch); err != nil {
+		return
+	}
+
+	if burst >= burstThreshold {
+		h.raiseInteractionFlag(ctx, userID, flagReasonBurst,
+			fmt.Sprintf("%d interactions in the last minute", burst))
+	}
+	if views >= lowWatchMinViews && avgWatch < lowWatchMaxAvgWatch {
+		h.raiseInteractionFlag(ctx, userID, flagReasonLowWatch,
+			fmt.Sprintf("%d views in 10 minutes averaging %.1f%% watched", views, avgWatch*100))
+	}
+}
+
+// raiseInteractionFlag records a hit on the user's open flag for reason,
+// opening one if none is open.
+func (h *Handler) raiseInteractionFlag(ctx context.Context, userID, reason, details string) {
+	res, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE interaction_flags SET hits = hits + 1, details = ?, last_seen_at = %s
+		WHERE user_id = ? AND reason = ? AND status = 'open'
+	`, h.DB.NowUTC()), details, userID, reason)
+	if err != nil {
+		log.Printf("raise interaction flag %s for %s: %v", reason, userID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return
+	}
+	if _, err := h.DB.ExecContext(ctx,
+		`INSERT INTO interaction_flags (id, user_id, reason, details) VALUES (?, ?, ?, ?)`,
+		uuid.New().String(), userID, reason, details); err != nil {
+		log.Printf("raise interaction flag %s for %s: %v", reason, userID, err)
+		return
+	}
+	log.Printf("flagged user %s for %s: %s", userID, reason, details)
+}
@@ -1,11 +1,16 @@
 package clips
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"clipfeed/llmpool"
 )
 
 // ---------------------------------------------------------------------------
@@ -27,7 +32,7 @@ func TestGenerateSummaryWithLLM_Ollama_OK(t *testing.T) {
 	t.Setenv("LLM_BASE_URL", srv.URL)
 	t.Setenv("LLM_MODEL", "test-model")
 
-	text, model, err := GenerateSummaryWithLLM("summarise this")
+	text, model, err := GenerateSummaryWithLLM(context.Background(), "summarise this")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -50,7 +55,7 @@ func TestGenerateSummaryWithLLM_Ollama_LeadingTrailingSpaceTrimmed(t *testing.T)
 	t.Setenv("LLM_BASE_URL", srv.URL)
 	t.Setenv("LLM_MODEL", "m")
 
-	text, _, err := GenerateSummaryWithLLM("p")
+	text, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -69,12 +74,66 @@ func TestGenerateSummaryWithLLM_Ollama_HTTPError(t *testing.T) {
 	t.Setenv("LLM_BASE_URL", srv.URL)
 	t.Setenv("LLM_MODEL", "m")
 
-	_, _, err := GenerateSummaryWithLLM("p")
+	_, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err == nil {
 		t.Fatal("expected error on HTTP 503, got nil")
 	}
 }
 
+func TestGenerateSummaryWithLLM_Ollama_ContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	t.Setenv("LLM_PROVIDER", "ollama")
+	t.Setenv("LLM_BASE_URL", srv.URL)
+	t.Setenv("LLM_MODEL", "m")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := GenerateSummaryWithLLM(ctx, "p")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("call returned after %v, want it abandoned at the deadline", elapsed)
+	}
+}
+
+func TestGenerateSummaryWithLLM_Ollama_PoolFailsOver(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"response": "From the pool."})
+	}))
+	defer up.Close()
+
+	t.Setenv("LLM_PROVIDER", "ollama")
+	t.Setenv("LLM_MODEL", "m")
+	endpoints, err := llmpool.ParseEndpoints(down.URL + "," + up.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LLMPool = &llmpool.Pool{Endpoints: endpoints}
+	defer func() { LLMPool = nil }()
+
+	for i := 0; i < 2; i++ {
+		text, _, err := GenerateSummaryWithLLM(context.Background(), "p")
+		if err != nil || text != "From the pool." {
+			t.Fatalf("call %d: text = %q, err = %v; want the healthy endpoint's answer", i, text, err)
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Anthropic provider
 // ---------------------------------------------------------------------------
@@ -103,7 +162,7 @@ func TestGenerateSummaryWithLLM_Anthropic_OK(t *testing.T) {
 	t.Setenv("LLM_MODEL", "claude-haiku")
 	t.Setenv("LLM_API_KEY", "testkey")
 
-	text, model, err := GenerateSummaryWithLLM("summarise this")
+	text, model, err := GenerateSummaryWithLLM(context.Background(), "summarise this")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -133,7 +192,7 @@ func TestGenerateSummaryWithLLM_Anthropic_MultipleContentBlocks(t *testing.T) {
 	t.Setenv("LLM_MODEL", "m")
 	t.Setenv("LLM_API_KEY", "k")
 
-	text, _, err := GenerateSummaryWithLLM("p")
+	text, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -153,7 +212,7 @@ func TestGenerateSummaryWithLLM_Anthropic_HTTPError(t *testing.T) {
 	t.Setenv("LLM_MODEL", "m")
 	t.Setenv("LLM_API_KEY", "k")
 
-	_, _, err := GenerateSummaryWithLLM("p")
+	_, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err == nil {
 		t.Fatal("expected error on HTTP 429, got nil")
 	}
@@ -165,7 +224,7 @@ func TestGenerateSummaryWithLLM_Anthropic_MissingKey(t *testing.T) {
 	t.Setenv("LLM_MODEL", "m")
 	t.Setenv("LLM_API_KEY", "")
 
-	_, _, err := GenerateSummaryWithLLM("p")
+	_, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err == nil {
 		t.Fatal("expected error for missing API key, got nil")
 	}
@@ -203,7 +262,7 @@ func TestGenerateSummaryWithLLM_OpenAI_OK(t *testing.T) {
 	t.Setenv("LLM_MODEL", "gpt-4o-mini")
 	t.Setenv("LLM_API_KEY", "openai-key")
 
-	text, model, err := GenerateSummaryWithLLM("summarise this")
+	text, model, err := GenerateSummaryWithLLM(context.Background(), "summarise this")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -227,7 +286,7 @@ func TestGenerateSummaryWithLLM_OpenAI_EmptyChoices(t *testing.T) {
 	t.Setenv("LLM_MODEL", "m")
 	t.Setenv("LLM_API_KEY", "k")
 
-	text, _, err := GenerateSummaryWithLLM("p")
+	text, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err != nil {
 		t.Fatalf("unexpected error for 0 choices: %v", err)
 	}
@@ -247,7 +306,7 @@ func TestGenerateSummaryWithLLM_OpenAI_HTTPError(t *testing.T) {
 	t.Setenv("LLM_MODEL", "m")
 	t.Setenv("LLM_API_KEY", "k")
 
-	_, _, err := GenerateSummaryWithLLM("p")
+	_, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err == nil {
 		t.Fatal("expected error on HTTP 500, got nil")
 	}
@@ -259,7 +318,7 @@ func TestGenerateSummaryWithLLM_OpenAI_MissingKey(t *testing.T) {
 	t.Setenv("LLM_MODEL", "m")
 	t.Setenv("LLM_API_KEY", "")
 
-	_, _, err := GenerateSummaryWithLLM("p")
+	_, _, err := GenerateSummaryWithLLM(context.Background(), "p")
 	if err == nil {
 		t.Fatal("expected error for missing API key, got nil")
 	}
@@ -0,0 +1,365 @@
+package clips
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/httputil"
+	"clipfeed/transcripts"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// transcriptSampleWords caps how many words unique to each transcript the
+// comparison lists.
+const transcriptSampleWords = 10
+
+// errClipNotFound reports a clip named in a compare or merge request that
+// does not exist.
+var errClipNotFound = errors.New("clip not found")
+
+// comparedClip is one side of a clip comparison.
+type comparedClip struct {
+	info       map[string]interface{}
+	transcript string
+	sourceID   string
+	duration   float64
+}
+
+// compareClip loads the metadata shown side by side for one clip.
+func (h *Handler) compareClip(ctx context.Context, clipID string) (comparedClip, error) {
+	var title, status, createdAt, thumbnailKey string
+	var sourceID, channelName, platform, sourceURL *string
+	var duration, score float64
+	var width, height, fileSize *int64
+	var startTime, endTime *float64
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT c.title, COALESCE(c.status, ''), COALESCE(c.created_at, ''), COALESCE(c.thumbnail_key, ''),
+		       c.source_id, c.duration_seconds, c.content_score,
+		       c.width, c.height, c.file_size_bytes, c.start_time, c.end_time,
+		       s.channel_name, s.platform, s.url
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.id = ?
+	`, clipID).Scan(&title, &status, &createdAt, &thumbnailKey, &sourceID, &duration, &score,
+		&width, &height, &fileSize, &startTime, &endTime, &channelName, &platform, &sourceURL); err != nil {
+		return comparedClip{}, errClipNotFound
+	}
+	transcript, err := transcripts.Load(ctx, h.DB, clipID)
+	if err != nil {
+		return comparedClip{}, fmt.Errorf("load transcript: %w", err)
+	}
+
+	// Lifetime counts: recent events plus those the retention job rolled up.
+	actions := make(map[string]int)
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT action, SUM(n) FROM (
+			SELECT action, COUNT(*) AS n FROM interactions WHERE clip_id = ? GROUP BY action
+			UNION ALL
+			SELECT action, SUM(events) AS n FROM interaction_rollups WHERE clip_id = ? GROUP BY action
+		) counts GROUP BY action
+	`, clipID, clipID)
+	if err != nil {
+		return comparedClip{}, fmt.Errorf("count interactions: %w", err)
+	}
+	for rows.Next() {
+		var action string
+		var n int
+		if rows.Scan(&action, &n) == nil {
+			actions[action] = n
+		}
+	}
+	rows.Close()
+	var saves, collections int
+	h.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM saved_clips WHERE clip_id = ?`, clipID).Scan(&saves)
+	h.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM collection_clips WHERE clip_id = ?`, clipID).Scan(&collections)
+
+	info := map[string]interface{}{
+		"id": clipID, "title": title, "status": status, "created_at": createdAt,
+		"duration_seconds": duration, "content_score": score,
+		"width": width, "height": height, "file_size_bytes": fileSize,
+		"start_time": startTime, "end_time": endTime, "source_id": sourceID,
+		"channel_name": channelName, "platform": platform, "source_url": sourceURL,
+		"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumbnailKey),
+		"stats": map[string]interface{}{
+			"interactions": actions, "saves": saves, "collections": collections,
+		},
+	}
+	c := comparedClip{info: info, transcript: transcript, duration: duration}
+	if sourceID != nil {
+		c.sourceID = *sourceID
+	}
+	return c, nil
+}
+
+// embeddingSimilarity returns the cosine similarity of two clips' text and
+// visual embeddings; a kind is nil when either clip lacks that embedding.
+func (h *Handler) embeddingSimilarity(ctx context.Context, a, b string) map[string]interface{} {
+	load := func(clipID string) (text, visual []float32) {
+		var textBlob, visualBlob []byte
+		h.DB.QueryRowContext(ctx,
+			`SELECT text_embedding, visual_embedding FROM clip_embeddings WHERE clip_id = ?`, clipID,
+		).Scan(&textBlob, &visualBlob)
+		return feed.BlobToFloat32(textBlob), feed.BlobToFloat32(visualBlob)
+	}
+	similarity := func(x, y []float32) interface{} {
+		if len(x) == 0 || len(x) != len(y) {
+			return nil
+		}
+		return feed.CosineSimilarity(x, y)
+	}
+	textA, visualA := load(a)
+	textB, visualB := load(b)
+	return map[string]interface{}{
+		"text":   similarity(textA, textB),
+		"visual": similarity(visualA, visualB),
+	}
+}
+
+// transcriptWords returns the distinct lower-cased words of a transcript.
+func transcriptWords(transcript string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(transcript), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		words[w] = true
+	}
+	return words
+}
+
+// transcriptDiff summarises how two transcripts differ by vocabulary: how
+// many distinct words they share, their Jaccard overlap, and a sample of
+// the words only one of them uses.
+func transcriptDiff(a, b string) map[string]interface{} {
+	wordsA, wordsB := transcriptWords(a), transcriptWords(b)
+	shared := 0
+	var onlyA, onlyB []string
+	for w := range wordsA {
+		if wordsB[w] {
+			shared++
+		} else {
+			onlyA = append(onlyA, w)
+		}
+	}
+	for w := range wordsB {
+		if !wordsA[w] {
+			onlyB = append(onlyB, w)
+		}
+	}
+	sample := func(words []string) []string {
+		sort.Strings(words)
+		if len(words) > transcriptSampleWords {
+			words = words[:transcriptSampleWords]
+		}
+		if words == nil {
+			words = []string{}
+		}
+		return words
+	}
+	var overlap interface{}
+	if union := len(wordsA) + len(wordsB) - shared; union > 0 {
+		overlap = float64(shared) / float64(union)
+	}
+	return map[string]interface{}{
+		"identical":    a == b,
+		"a_words":      len(wordsA),
+		"b_words":      len(wordsB),
+		"shared_words": shared,
+		"overlap":      overlap,
+		"only_in_a":    sample(onlyA),
+		"only_in_b":    sample(onlyB),
+	}
+}
+
+// HandleCompareClips returns two possibly duplicate clips side by side:
+// metadata and interaction stats for each, their embedding similarity, and
+// a summary of how their transcripts differ.
+func (h *Handler) HandleCompareClips(w http.ResponseWriter, r *http.Request) {
+	a, b := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+	if a == "" || b == "" || a == b {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "a and b must name two different clips"})
+		return
+	}
+	var sides [2]comparedClip
+	for i, id := range []string{a, b} {
+		c, err := h.compareClip(r.Context(), id)
+		if errors.Is(err, errClipNotFound) {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found: " + id})
+			return
+		}
+		if err != nil {
+			log.Printf("compare clips %s %s: %v", a, b, err)
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to compare clips"})
+			return
+		}
+		sides[i] = c
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"a": sides[0].info, "b": sides[1].info,
+		"same_source":            sides[0].sourceID != "" && sides[0].sourceID == sides[1].sourceID,
+		"duration_delta_seconds": sides[1].duration - sides[0].duration,
+		"similarity":             h.embeddingSimilarity(r.Context(), a, b),
+		"transcript":             transcriptDiff(sides[0].transcript, sides[1].transcript),
+	})
+}
+
+// HandleMergeClips folds a duplicate clip into the clip being kept and
+// deletes the duplicate. Interactions, interaction rollups, saves (with
+// their notes and tags), collection memberships, bandit impressions, and
+// staff picks move to the kept clip; where a user already has the kept clip
+// saved or in a collection, the existing entry wins.
+func (h *Handler) HandleMergeClips(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Keep      string `json:"keep"`
+		Duplicate string `json:"duplicate"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil ||
+		req.Keep == "" || req.Duplicate == "" || req.Keep == req.Duplicate {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "keep and duplicate must name two different clips"})
+		return
+	}
+	ctx := r.Context()
+	keep, dup := req.Keep, req.Duplicate
+
+	var objectKeys []string
+	moved := make(map[string]int64)
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		var exists int
+		var storageKey, thumbnailKey string
+		if err := conn.QueryRowContext(ctx, `SELECT 1 FROM clips WHERE id = ?`, keep).Scan(&exists); err != nil {
+			return errClipNotFound
+		}
+		if err := conn.QueryRowContext(ctx,
+			`SELECT COALESCE(storage_key, ''), COALESCE(thumbnail_key, '') FROM clips WHERE id = ?`, dup,
+		).Scan(&storageKey, &thumbnailKey); err != nil {
+			return errClipNotFound
+		}
+		objectKeys = append(objectKeys, storageKey, thumbnailKey)
+		rows, err := conn.QueryContext(ctx, `
+			SELECT thumbnail_key FROM clip_thumbnails WHERE clip_id = ?
+			UNION ALL
+			SELECT sprite_key FROM clip_storyboard_sprites WHERE clip_id = ?
+			UNION ALL
+			SELECT low_storage_key FROM clips WHERE id = ? AND low_storage_key IS NOT NULL
+			UNION ALL
+			SELECT small_thumbnail_key FROM clips WHERE id = ? AND small_thumbnail_key IS NOT NULL
+			UNION ALL
+			SELECT large_thumbnail_key FROM clips WHERE id = ? AND large_thumbnail_key IS NOT NULL
+			UNION ALL
+			SELECT storage_key FROM clip_previews WHERE clip_id = ? AND storage_key IS NOT NULL
+			UNION ALL
+			SELECT hls_key FROM clips WHERE id = ? AND hls_key IS NOT NULL
+			UNION ALL
+			SELECT playlist_key FROM clip_hls_variants WHERE clip_id = ?
+			UNION ALL
+			SELECT segment_key FROM clip_hls_segments WHERE clip_id = ?
+		`, dup, dup, dup, dup, dup, dup, dup, dup, dup)
+		if err != nil {
+			return fmt.Errorf("load media: %w", err)
+		}
+		for rows.Next() {
+			var key string
+			if rows.Scan(&key) == nil {
+				objectKeys = append(objectKeys, key)
+			}
+		}
+		rows.Close()
+
+		steps := []struct {
+			name, query string
+			args        []interface{}
+		}{
+			{"interactions", `UPDATE interactions SET clip_id = ? WHERE clip_id = ?`, []interface{}{keep, dup}},
+			// Summing users may count someone who interacted with both clips
+			// twice; rollups keep no per-user detail to avoid it.
+			{"rollups", `
+				INSERT INTO interaction_rollups (day, clip_id, action, events, users, watch_percentage_sum)
+				SELECT day, ?, action, events, users, watch_percentage_sum FROM interaction_rollups WHERE clip_id = ?
+				ON CONFLICT(day, clip_id, action) DO UPDATE SET
+					events               = interaction_rollups.events + excluded.events,
+					users                = interaction_rollups.users + excluded.users,
+					watch_percentage_sum = interaction_rollups.watch_percentage_sum + excluded.watch_percentage_sum
+			`, []interface{}{keep, dup}},
+			{"saves", `
+				INSERT INTO saved_clips (user_id, clip_id, created_at, note, updated_at)
+				SELECT user_id, ?, created_at, note, updated_at FROM saved_clips WHERE clip_id = ?
+				ON CONFLICT(user_id, clip_id) DO UPDATE SET
+					note = CASE WHEN saved_clips.note = '' THEN excluded.note ELSE saved_clips.note END
+			`, []interface{}{keep, dup}},
+			{"tags", `
+				INSERT INTO saved_clip_tags (user_id, clip_id, tag)
+				SELECT user_id, ?, tag FROM saved_clip_tags WHERE clip_id = ?
+				ON CONFLICT DO NOTHING
+			`, []interface{}{keep, dup}},
+			{"collections", `
+				INSERT INTO collection_clips (collection_id, clip_id, position, added_at)
+				SELECT collection_id, ?, position, added_at FROM collection_clips WHERE clip_id = ?
+				ON CONFLICT DO NOTHING
+			`, []interface{}{keep, dup}},
+			{"impressions", `
+				INSERT INTO bandit_impressions (user_id, clip_id, topic_id, outcome, created_at)
+				SELECT user_id, ?, topic_id, outcome, created_at FROM bandit_impressions WHERE clip_id = ?
+				ON CONFLICT DO NOTHING
+			`, []interface{}{keep, dup}},
+			{"staff_picks", `
+				INSERT INTO staff_picks (clip_id, note, position, picked_at)
+				SELECT ?, note, position, picked_at FROM staff_picks WHERE clip_id = ?
+				ON CONFLICT DO NOTHING
+			`, []interface{}{keep, dup}},
+			{"search", `DELETE FROM clips_fts WHERE clip_id = ?`, []interface{}{dup}},
+			{"clip", `DELETE FROM clips WHERE id = ?`, []interface{}{dup}},
+		}
+		if err := Bury(ctx, conn, TombstoneMerged, keep, dup); err != nil {
+			return fmt.Errorf("merge tombstone: %w", err)
+		}
+		for _, step := range steps {
+			res, err := conn.ExecContext(ctx, step.query, step.args...)
+			if err != nil {
+				return fmt.Errorf("merge %s: %w", step.name, err)
+			}
+			moved[step.name], _ = res.RowsAffected()
+		}
+		return nil
+	})
+	if errors.Is(err, errClipNotFound) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("merge clip %s into %s: %v", dup, keep, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to merge clips"})
+		return
+	}
+
+	// Media goes after the rows, as when deleting a source.
+	if h.Minio != nil {
+		seen := make(map[string]bool, len(objectKeys))
+		for _, key := range objectKeys {
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if err := h.Minio.RemoveObject(ctx, h.MinioBucket, key, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("merge clip %s: remove %s: %v", dup, key, err)
+			}
+		}
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"status": "merged", "kept": keep, "removed": dup,
+		"moved": map[string]int64{
+			"interactions": moved["interactions"],
+			"saves":        moved["saves"],
+			"collections":  moved["collections"],
+		},
+	})
+}
@@ -0,0 +1,219 @@
+package clips
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/jobs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxPreviewMs is the longest range a preview may cover.
+	maxPreviewMs = 10_000
+	// maxPendingPreviews is how many previews one user may have waiting to
+	// be rendered at once.
+	maxPendingPreviews = 3
+	// previewJobPriority puts previews ahead of normal downloads, since
+	// someone is waiting on each and they render in seconds.
+	previewJobPriority = 7
+)
+
+// errTooManyPreviews is returned when the user already has
+// maxPendingPreviews previews waiting.
+var errTooManyPreviews = errors.New("too many pending previews")
+
+// clipPreview is a preview of a range of a clip and where its rendering
+// stands.
+type clipPreview struct {
+	ID     string  `json:"id"`
+	ClipID string  `json:"clip_id"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Format string  `json:"format"`
+	// Status is "ready" once the preview is uploaded, "queued" or
+	// "running" while its job is, and "failed" otherwise.
+	Status        string  `json:"status"`
+	URL           string  `json:"url,omitempty"`
+	FileSizeBytes *int64  `json:"file_size_bytes,omitempty"`
+	JobID         *string `json:"job_id"`
+	Error         string  `json:"error,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+	CompletedAt   *string `json:"completed_at"`
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const previewColumns = `
+	SELECT p.id, p.clip_id, p.start_ms, p.end_ms, p.format, p.job_id, p.storage_key, p.file_size_bytes,
+	       p.created_at, p.completed_at, COALESCE(j.status, ''), COALESCE(j.error, '')
+	FROM clip_previews p
+	LEFT JOIN jobs j ON j.id = p.job_id
+`
+
+// loadPreview scans the one preview the query selects.
+func (h *Handler) loadPreview(ctx context.Context, q rowQuerier, where string, args ...interface{}) (*clipPreview, error) {
+	var p clipPreview
+	var startMs, endMs int64
+	var storageKey *string
+	var jobStatus string
+	if err := q.QueryRowContext(ctx, previewColumns+where, args...).Scan(
+		&p.ID, &p.ClipID, &startMs, &endMs, &p.Format, &p.JobID, &storageKey, &p.FileSizeBytes,
+		&p.CreatedAt, &p.CompletedAt, &jobStatus, &p.Error); err != nil {
+		return nil, err
+	}
+	p.Start, p.End = float64(startMs)/1000, float64(endMs)/1000
+	switch {
+	case storageKey != nil:
+		p.Status = "ready"
+		p.URL = httputil.ThumbnailURL(h.MinioBucket, *storageKey)
+		p.Error = ""
+	case jobStatus == "queued" || jobStatus == "running":
+		p.Status = jobStatus
+	default:
+		p.Status = "failed"
+		if p.Error == "" {
+			p.Error = "preview job did not finish"
+		}
+	}
+	return &p, nil
+}
+
+// HandleCreatePreview queues a short GIF (format=gif, the default) or WebM
+// (format=webm) preview of the start-end range of a ready clip, in seconds
+// and at most ten long. Previews are shared: asking for a range that is
+// already rendered returns it with its url (200), and one being rendered
+// returns it as is (202). Poll HandleGetPreview until it is ready.
+func (h *Handler) HandleCreatePreview(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	clipID := chi.URLParam(r, "id")
+	q := r.URL.Query()
+	start, startErr := strconv.ParseFloat(q.Get("start"), 64)
+	end, endErr := strconv.ParseFloat(q.Get("end"), 64)
+	if startErr != nil || endErr != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "start and end are required, in seconds"})
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "gif"
+	}
+	if format != "gif" && format != "webm" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": `format must be "gif" or "webm"`})
+		return
+	}
+
+	var storageKey string
+	var duration float64
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT storage_key, duration_seconds FROM clips WHERE id = ? AND status = 'ready'`, clipID,
+	).Scan(&storageKey, &duration); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	startMs, endMs := int64(math.Round(start*1000)), int64(math.Round(end*1000))
+	if startMs < 0 || endMs <= startMs || endMs > int64(math.Round(duration*1000)) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "start and end must lie within the clip, with start first"})
+		return
+	}
+	if endMs-startMs > maxPreviewMs {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("previews are at most %d seconds long", maxPreviewMs/1000)})
+		return
+	}
+
+	var p *clipPreview
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		existing, err := h.loadPreview(r.Context(), conn,
+			`WHERE p.clip_id = ? AND p.start_ms = ? AND p.end_ms = ? AND p.format = ?`, clipID, startMs, endMs, format)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if existing != nil && existing.Status != "failed" {
+			p = existing
+			return nil
+		}
+
+		var pending int
+		if err := conn.QueryRowContext(r.Context(), `
+			SELECT COUNT(*) FROM clip_previews p JOIN jobs j ON j.id = p.job_id
+			WHERE p.created_by = ? AND p.storage_key IS NULL AND j.status IN ('queued', 'running')
+		`, userID).Scan(&pending); err != nil {
+			return err
+		}
+		if pending >= maxPendingPreviews {
+			return errTooManyPreviews
+		}
+
+		previewID := uuid.New().String()
+		if existing != nil {
+			previewID = existing.ID
+		}
+		payload, err := jobs.EncodePayload("preview", &jobs.PreviewPayload{
+			PreviewID: previewID, ClipID: clipID, StorageKey: storageKey,
+			Start: float64(startMs) / 1000, End: float64(endMs) / 1000, Format: format,
+			OutputKey: fmt.Sprintf("clips/%s/previews/%s.%s", clipID, previewID, format),
+		})
+		if err != nil {
+			return err
+		}
+		jobID := uuid.New().String()
+		if _, err := conn.ExecContext(r.Context(),
+			`INSERT INTO jobs (id, job_type, priority, payload) VALUES (?, 'preview', ?, ?)`,
+			jobID, previewJobPriority, jobs.WithTraceparent(r.Context(), payload)); err != nil {
+			return err
+		}
+		if existing != nil {
+			_, err = conn.ExecContext(r.Context(),
+				`UPDATE clip_previews SET job_id = ?, created_by = ? WHERE id = ?`, jobID, userID, previewID)
+		} else {
+			_, err = conn.ExecContext(r.Context(), `
+				INSERT INTO clip_previews (id, clip_id, start_ms, end_ms, format, job_id, created_by)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, previewID, clipID, startMs, endMs, format, jobID, userID)
+		}
+		if err != nil {
+			return err
+		}
+		p, err = h.loadPreview(r.Context(), conn, `WHERE p.id = ?`, previewID)
+		return err
+	})
+	switch {
+	case errors.Is(err, errTooManyPreviews):
+		httputil.WriteJSON(w, 429, map[string]string{
+			"error": fmt.Sprintf("you already have %d previews rendering; wait for one to finish", maxPendingPreviews)})
+		return
+	case err != nil:
+		log.Printf("create preview of clip %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to queue preview"})
+		return
+	}
+	code := 202
+	if p.Status == "ready" {
+		code = 200
+	}
+	httputil.WriteJSON(w, code, p)
+}
+
+// HandleGetPreview reports a preview's status, and its url once ready.
+func (h *Handler) HandleGetPreview(w http.ResponseWriter, r *http.Request) {
+	p, err := h.loadPreview(r.Context(), h.DB, `WHERE p.id = ? AND p.clip_id = ?`,
+		chi.URLParam(r, "previewId"), chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "preview not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, p)
+}
@@ -0,0 +1,68 @@
+package clips
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"clipfeed/db"
+)
+
+// Star ratings run from minRating to maxRating.
+const (
+	minRating = 1
+	maxRating = 5
+)
+
+// RatingSummary is the aggregate of a clip's star ratings. Average is nil
+// until someone rates the clip.
+type RatingSummary struct {
+	Average *float64 `json:"average"`
+	Count   int      `json:"count"`
+}
+
+// recordRating stores userID's rating of clipID, replacing any earlier one,
+// and refreshes the clip's aggregate.
+func (h *Handler) recordRating(ctx context.Context, userID, clipID string, rating int) (RatingSummary, error) {
+	var summary RatingSummary
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO clip_ratings (user_id, clip_id, rating) VALUES (?, ?, ?)
+			ON CONFLICT (user_id, clip_id) DO UPDATE SET rating = excluded.rating, updated_at = %s
+		`, h.DB.NowUTC()), userID, clipID, rating); err != nil {
+			return fmt.Errorf("store rating: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, `
+			UPDATE clips SET
+				rating_count = (SELECT COUNT(*) FROM clip_ratings WHERE clip_id = ?),
+				rating_avg = (SELECT AVG(rating) FROM clip_ratings WHERE clip_id = ?)
+			WHERE id = ?
+		`, clipID, clipID, clipID); err != nil {
+			return fmt.Errorf("refresh clip rating: %w", err)
+		}
+		var avg sql.NullFloat64
+		if err := conn.QueryRowContext(ctx,
+			`SELECT rating_count, rating_avg FROM clips WHERE id = ?`, clipID,
+		).Scan(&summary.Count, &avg); err != nil {
+			return err
+		}
+		if avg.Valid {
+			summary.Average = &avg.Float64
+		}
+		return nil
+	})
+	return summary, err
+}
+
+// ratingAction is the like-style action a rating counts as for score
+// nudges and topic exploration: four or five stars as a like, one or two as
+// a dislike, and three as neither.
+func ratingAction(rating int) string {
+	switch {
+	case rating >= 4:
+		return "like"
+	case rating <= 2:
+		return "dislike"
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package clips
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// RetentionBuckets is how many equal slices a clip's retention curve
+	// has.
+	RetentionBuckets = 50
+	// maxWatchSegments caps the segments one interaction may report.
+	maxWatchSegments = 100
+)
+
+// WatchSegment is a stretch of a clip that was played, in seconds from the
+// clip's start.
+type WatchSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// validSegments reports whether segs is a usable list of watch segments.
+func validSegments(segs []WatchSegment) bool {
+	if len(segs) > maxWatchSegments {
+		return false
+	}
+	for _, s := range segs {
+		if math.IsNaN(s.Start) || math.IsNaN(s.End) || s.Start < 0 || s.End <= s.Start {
+			return false
+		}
+	}
+	return true
+}
+
+// coveredBuckets returns the retention buckets of a clip of duration
+// seconds whose midpoint one of segs played.
+func coveredBuckets(segs []WatchSegment, duration float64) []int {
+	if duration <= 0 {
+		return nil
+	}
+	var buckets []int
+	for b := 0; b < RetentionBuckets; b++ {
+		mid := (float64(b) + 0.5) * duration / RetentionBuckets
+		for _, s := range segs {
+			if s.Start <= mid && mid < s.End {
+				buckets = append(buckets, b)
+				break
+			}
+		}
+	}
+	return buckets
+}
+
+// recordRetention adds one viewing session of clipID that played segs to
+// its retention curve.
+func (h *Handler) recordRetention(ctx context.Context, clipID string, duration float64, segs []WatchSegment) error {
+	buckets := coveredBuckets(segs, duration)
+	if len(buckets) == 0 {
+		return nil
+	}
+	return db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO clip_retention (clip_id, sessions) VALUES (?, 1)
+			ON CONFLICT (clip_id) DO UPDATE SET sessions = clip_retention.sessions + 1
+		`, clipID); err != nil {
+			return err
+		}
+		values := make([]string, len(buckets))
+		args := make([]interface{}, 0, 2*len(buckets))
+		for i, b := range buckets {
+			values[i] = "(?, ?, 1)"
+			args = append(args, clipID, b)
+		}
+		_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO clip_retention_buckets (clip_id, bucket, viewers) VALUES %s
+			ON CONFLICT (clip_id, bucket) DO UPDATE SET viewers = clip_retention_buckets.viewers + 1
+		`, strings.Join(values, ", ")), args...)
+		return err
+	})
+}
+
+// HandleRetention returns a clip's retention curve: for each bucket, the
+// share of viewing sessions that played it. Only the user who submitted the
+// clip's source and admins may see it.
+func (h *Handler) HandleRetention(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var duration float64
+	var submittedBy *string
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT c.duration_seconds, s.submitted_by
+		FROM clips c
+		LEFT JOIN sources s ON s.id = c.source_id
+		WHERE c.id = ?
+	`, clipID).Scan(&duration, &submittedBy); err != nil {
+		h.writeMissing(w, r, clipID)
+		return
+	}
+	if h.IsAdmin == nil || !h.IsAdmin(r) {
+		userID, ok := auth.ExtractUserID(r)
+		if !ok {
+			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if submittedBy == nil || *submittedBy != userID {
+			httputil.WriteJSON(w, 403, map[string]string{"error": "only the submitter or an admin can see a clip's retention"})
+			return
+		}
+	}
+
+	var sessions int64
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT sessions FROM clip_retention WHERE clip_id = ?`, clipID).Scan(&sessions)
+	viewers := make([]int64, RetentionBuckets)
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT bucket, viewers FROM clip_retention_buckets WHERE clip_id = ?`, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load retention"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b int
+		var n int64
+		if err := rows.Scan(&b, &n); err == nil && b >= 0 && b < RetentionBuckets {
+			viewers[b] = n
+		}
+	}
+
+	width := duration / RetentionBuckets
+	curve := make([]map[string]interface{}, RetentionBuckets)
+	var steepest map[string]interface{}
+	prev, maxDrop := 1.0, 0.0
+	for b, n := range viewers {
+		retention := 0.0
+		if sessions > 0 {
+			retention = float64(n) / float64(sessions)
+		}
+		start, end := float64(b)*width, float64(b+1)*width
+		curve[b] = map[string]interface{}{
+			"start": start, "end": end, "viewers": n, "retention": retention,
+		}
+		// The steepest drop is where the most viewers leave between one
+		// bucket and the next.
+		if sessions > 0 && prev-retention > maxDrop {
+			maxDrop = prev - retention
+			steepest = map[string]interface{}{"start": start, "end": end, "drop": maxDrop}
+		}
+		prev = retention
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"clip_id": clipID, "duration_seconds": duration, "sessions": sessions,
+		"buckets": curve, "steepest_drop": steepest,
+	})
+}
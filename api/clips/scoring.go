@@ -0,0 +1,48 @@
+package clips
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Per-interaction content_score nudges applied between batch score updates.
+// They are deliberately small: the periodic /api/internal/scores/update job
+// recomputes scores from the full interaction history and is the source of
+// truth, so these only let freshly popular clips move within minutes.
+var interactionScoreDeltas = map[string]float64{
+	"like":       0.02,
+	"save":       0.02,
+	"share":      0.015,
+	"watch_full": 0.01,
+	"skip":       -0.015,
+	"dislike":    -0.02,
+}
+
+// scoreDelta returns the content_score adjustment for one interaction.
+// Views count in proportion to how far past the halfway mark they got.
+func scoreDelta(action string, watchPercentage float64) float64 {
+	if action == "view" {
+		if watchPercentage <= 0 {
+			return 0
+		}
+		return (watchPercentage - 0.5) * 0.01
+	}
+	return interactionScoreDeltas[action]
+}
+
+// applyScoreDelta nudges a clip's content_score for a fresh interaction.
+// Callers suppress repeats of the same action by the same user within 24
+// hours, and interactions from flagged users, so one account cannot ratchet
+// a score up or down.
+func (h *Handler) applyScoreDelta(ctx context.Context, clipID, action string, watchPercentage float64, suppressed bool) {
+	delta := scoreDelta(action, watchPercentage)
+	if delta == 0 || suppressed {
+		return
+	}
+	if _, err := h.DB.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE clips SET content_score = %s WHERE id = ? AND status = 'ready'`,
+		h.DB.ClampExpr("COALESCE(content_score, 0.5) + ?", 0, 1)), delta, clipID); err != nil {
+		log.Printf("applyScoreDelta %s: %v", clipID, err)
+	}
+}
@@ -0,0 +1,347 @@
+package clips
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipfeed/datasaver"
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/storyboard"
+	"clipfeed/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// StreamModePresign hands out presigned MinIO URLs that nginx serves.
+	// They stay valid until they expire and cannot be revoked.
+	StreamModePresign = "presign"
+	// StreamModeProxy hands out URLs signed by the API, which serves the
+	// media itself and checks every request against the clip, so taking a
+	// clip down or revoking its streams takes effect immediately.
+	StreamModeProxy = "proxy"
+
+	defaultStreamTTL = 2 * time.Hour
+	// streamRefreshFraction is how much of a URL's lifetime passes before
+	// clients are told to refresh it.
+	streamRefreshFraction = 0.8
+	maxStreamRefreshIDs   = 20
+)
+
+func (h *Handler) streamTTL() time.Duration {
+	if h.StreamTTL > 0 {
+		return h.StreamTTL
+	}
+	return defaultStreamTTL
+}
+
+func streamSignature(secret, storageKey, userID string, generation, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%d\n%d", storageKey, generation, expires)
+	// URLs issued to no one keep the signature they had before usage was
+	// attributed.
+	if userID != "" {
+		fmt.Fprintf(mac, "\n%s", userID)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signProxyURL returns a /api/media URL for storageKey that is valid until
+// expiry passes or the clip's stream generation changes. The bytes it
+// serves are counted against userID when set.
+func (h *Handler) signProxyURL(storageKey, userID string, generation int64, expiry time.Duration) string {
+	expires := time.Now().Add(expiry).Unix()
+	q := url.Values{}
+	q.Set("key", storageKey)
+	q.Set("gen", strconv.FormatInt(generation, 10))
+	q.Set("exp", strconv.FormatInt(expires, 10))
+	if userID != "" {
+		q.Set("uid", userID)
+	}
+	q.Set("sig", streamSignature(h.StreamSecret, storageKey, userID, generation, expires))
+	return "/api/media?" + q.Encode()
+}
+
+// streamURL issues a playable URL for storageKey in the configured mode,
+// attributed to the signed-in user in ctx.
+func (h *Handler) streamURL(ctx context.Context, storageKey string, generation int64, expiry time.Duration) (string, error) {
+	if h.StreamMode == StreamModeProxy {
+		return h.signProxyURL(storageKey, streamUser(ctx), generation, expiry), nil
+	}
+	ctx, span := tracing.Start(ctx, "minio.presign", attribute.String("storage.key", storageKey))
+	presignedURL, err := h.Minio.PresignedGetObject(ctx, h.MinioBucket, storageKey, expiry, nil)
+	tracing.End(span, err)
+	if err != nil {
+		return "", err
+	}
+	return BuildBrowserStreamURL(presignedURL.String())
+}
+
+// Stream renditions. Clips without a low-bitrate rendition always stream
+// the standard one.
+const (
+	QualityStandard = "standard"
+	QualityLow      = "low"
+)
+
+// streamRendition picks the media to stream for a clip: its low-bitrate
+// rendition when the request is in data saver mode and the clip has one.
+func (h *Handler) streamRendition(ctx context.Context, storageKey, lowKey string) (string, string) {
+	if lowKey != "" && datasaver.Enabled(ctx, h.DB) {
+		return lowKey, QualityLow
+	}
+	return storageKey, QualityStandard
+}
+
+// streamLink is a stream URL with the times clients should refresh it by.
+func (h *Handler) streamLink(ctx context.Context, storageKey string, generation int64) (map[string]string, error) {
+	ttl := h.streamTTL()
+	now := time.Now().UTC()
+	streamURL, err := h.streamURL(ctx, storageKey, generation, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"url":           streamURL,
+		"expires_at":    now.Add(ttl).Format("2006-01-02T15:04:05Z"),
+		"refresh_after": now.Add(time.Duration(float64(ttl) * streamRefreshFraction)).Format("2006-01-02T15:04:05Z"),
+	}, nil
+}
+
+// HandleRefreshStreams reissues stream URLs for up to maxStreamRefreshIDs
+// clips in one query, so clients can renew the URLs of queued clips before
+// they expire. Clips that are missing or no longer ready are left out. Like
+// HandleStreamClip, data saver mode picks low-bitrate renditions.
+func (h *Handler) HandleRefreshStreams(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClipIDs []string `json:"clip_ids"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || len(req.ClipIDs) == 0 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "clip_ids required"})
+		return
+	}
+	if len(req.ClipIDs) > maxStreamRefreshIDs {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d clips per request", maxStreamRefreshIDs)})
+		return
+	}
+	args := make([]interface{}, len(req.ClipIDs))
+	for i, id := range req.ClipIDs {
+		args[i] = id
+	}
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id, storage_key, COALESCE(low_storage_key, ''), stream_generation FROM clips WHERE status = 'ready' AND id IN (?`+strings.Repeat(", ?", len(args)-1)+`)`, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to refresh streams"})
+		return
+	}
+	type clipStream struct {
+		id, key, lowKey string
+		generation      int64
+	}
+	var found []clipStream
+	for rows.Next() {
+		var c clipStream
+		if rows.Scan(&c.id, &c.key, &c.lowKey, &c.generation) == nil && c.key != "" {
+			found = append(found, c)
+		}
+	}
+	rows.Close()
+	if userID := streamUser(r.Context()); userID != "" {
+		h.touchStream(r.Context(), r, userID)
+	}
+
+	streams := make(map[string]map[string]string, len(found))
+	for _, c := range found {
+		key, quality := h.streamRendition(r.Context(), c.key, c.lowKey)
+		link, err := h.streamLink(r.Context(), key, c.generation)
+		if err != nil {
+			log.Printf("refresh stream %s: %v", c.id, err)
+			continue
+		}
+		link["quality"] = quality
+		streams[c.id] = link
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"streams": streams})
+}
+
+// HandleMedia serves a clip's media for a URL signed by signProxyURL. Only
+// available in proxy mode. Range requests are supported so players can seek.
+// Bytes served are added to the stream usage of the user the URL was
+// issued to.
+func (h *Handler) HandleMedia(w http.ResponseWriter, r *http.Request) {
+	if h.StreamMode != StreamModeProxy {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "not found"})
+		return
+	}
+	q := r.URL.Query()
+	storageKey := q.Get("key")
+	generation, genErr := strconv.ParseInt(q.Get("gen"), 10, 64)
+	expires, expErr := strconv.ParseInt(q.Get("exp"), 10, 64)
+	userID := q.Get("uid")
+	if storageKey == "" || genErr != nil || expErr != nil ||
+		!hmac.Equal([]byte(q.Get("sig")), []byte(streamSignature(h.StreamSecret, storageKey, userID, generation, expires))) {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "invalid stream signature"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "stream URL expired"})
+		return
+	}
+	// Storyboard sprite sheets and HLS segments are served under their
+	// clip's generation, so revoking a clip's streams revokes them too.
+	var current int64
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT stream_generation FROM clips
+		WHERE (storage_key = ? OR low_storage_key = ?
+		       OR id IN (SELECT clip_id FROM clip_storyboard_sprites WHERE sprite_key = ?)
+		       OR id IN (SELECT clip_id FROM clip_hls_segments WHERE segment_key = ?))
+		  AND status = 'ready'
+	`, storageKey, storageKey, storageKey, storageKey).Scan(&current); err != nil || current != generation {
+		httputil.WriteJSON(w, 410, map[string]string{"error": "stream revoked"})
+		return
+	}
+	if h.Minio == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "storage unavailable"})
+		return
+	}
+
+	obj, err := h.Minio.GetObject(r.Context(), h.MinioBucket, storageKey, minio.GetObjectOptions{})
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to open media"})
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "media not found"})
+		return
+	}
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, path.Base(storageKey), info.LastModified, obj)
+	if userID != "" && cw.n > 0 {
+		// Players often hang up mid-transfer; what was sent still counts.
+		h.recordStreamBytes(context.WithoutCancel(r.Context()), userID, cw.n)
+	}
+}
+
+// HandleStoryboard serves a ready clip's seek-preview storyboard as WebVTT,
+// with each sprite sheet replaced by a stream URL valid as long as the
+// clip's own, so players can show thumbnails while scrubbing.
+func (h *Handler) HandleStoryboard(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var vtt string
+	var generation int64
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT s.vtt, c.stream_generation FROM clip_storyboards s
+		JOIN clips c ON c.id = s.clip_id
+		WHERE s.clip_id = ? AND c.status = 'ready'
+	`, clipID).Scan(&vtt, &generation); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "storyboard not found"})
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT sprite_key FROM clip_storyboard_sprites WHERE clip_id = ? ORDER BY sprite_index`, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load storyboard"})
+		return
+	}
+	var spriteKeys []string
+	for rows.Next() {
+		var key string
+		if rows.Scan(&key) == nil {
+			spriteKeys = append(spriteKeys, key)
+		}
+	}
+	rows.Close()
+
+	ttl := h.streamTTL()
+	out, err := storyboard.Rewrite(vtt, spriteKeys, func(key string) (string, error) {
+		return h.streamURL(r.Context(), key, generation, ttl)
+	})
+	if err != nil {
+		log.Printf("storyboard %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to sign storyboard"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	// Cached copies must not outlive the sprite URLs inside them.
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d",
+		int(time.Duration(float64(ttl)*streamRefreshFraction).Seconds())))
+	io.WriteString(w, out)
+}
+
+// HandleRevokeStreams invalidates every outstanding proxy stream URL for a
+// clip by bumping its stream generation. With take_down set, the clip is
+// also marked removed so no new URLs are issued for it. Presigned URLs
+// cannot be revoked and stay valid until they expire.
+func (h *Handler) HandleRevokeStreams(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		TakeDown bool `json:"take_down"`
+	}
+	// The body is optional.
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil && err != io.EOF {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+
+	var generation int64
+	var status string
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(),
+			`UPDATE clips SET stream_generation = stream_generation + 1 WHERE id = ?`, clipID); err != nil {
+			return err
+		}
+		if req.TakeDown {
+			if _, err := conn.ExecContext(r.Context(),
+				`UPDATE clips SET status = 'removed' WHERE id = ?`, clipID); err != nil {
+				return err
+			}
+		}
+		return conn.QueryRowContext(r.Context(),
+			`SELECT stream_generation, COALESCE(status, '') FROM clips WHERE id = ?`, clipID).Scan(&generation, &status)
+	}); err != nil {
+		log.Printf("revoke streams %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to revoke streams"})
+		return
+	}
+	mode := h.StreamMode
+	if mode == "" {
+		mode = StreamModePresign
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"status":            "revoked",
+		"clip_id":           clipID,
+		"clip_status":       status,
+		"stream_generation": generation,
+		"stream_mode":       mode,
+		// Presigned URLs already handed out keep working until they expire.
+		"immediate": mode == StreamModeProxy,
+	})
+}
@@ -0,0 +1,128 @@
+package clips
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"clipfeed/httputil"
+)
+
+// Reasons a clip is tombstoned.
+const (
+	TombstoneExpired = "expired"
+	TombstoneEvicted = "evicted"
+	TombstoneDeleted = "deleted"
+	TombstoneMerged  = "merged"
+)
+
+// goneSuggestionLimit caps the similar clips offered in place of a gone one.
+const goneSuggestionLimit = 5
+
+// Bury records tombstones for clipIDs before their rows are deleted, so
+// later requests for them get 410 Gone. It must run in the deleting
+// transaction while the rows still exist. replacedBy names the clip that
+// took over a merged one and is empty otherwise.
+func Bury(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, reason, replacedBy string, clipIDs ...interface{}) error {
+	if len(clipIDs) == 0 {
+		return nil
+	}
+	var replaced interface{}
+	if replacedBy != "" {
+		replaced = replacedBy
+	}
+	in := "(?" + strings.Repeat(", ?", len(clipIDs)-1) + ")"
+	args := append([]interface{}{reason, replaced}, clipIDs...)
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO clip_tombstones (clip_id, title, topics, reason, replaced_by)
+		SELECT id, COALESCE(title, ''), COALESCE(topics, '[]'), ?, ?
+		FROM clips WHERE id IN `+in+`
+		ON CONFLICT (clip_id) DO UPDATE SET reason = excluded.reason, replaced_by = excluded.replaced_by
+	`, args...)
+	return err
+}
+
+// writeMissing answers a request for a clip that is not available: 410
+// Gone with the reason and similar clips when the clip has a tombstone,
+// 404 otherwise.
+func (h *Handler) writeMissing(w http.ResponseWriter, r *http.Request, clipID string) {
+	ctx := r.Context()
+	var title, topicsJSON, reason string
+	var replacedBy, deletedAt *string
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT title, topics, reason, replaced_by, deleted_at
+		FROM clip_tombstones WHERE clip_id = ?
+	`, clipID).Scan(&title, &topicsJSON, &reason, &replacedBy, &deletedAt)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	var topics []string
+	json.Unmarshal([]byte(topicsJSON), &topics)
+
+	httputil.WriteJSON(w, 410, map[string]interface{}{
+		"error":       "clip is gone",
+		"clip_id":     clipID,
+		"title":       title,
+		"reason":      reason,
+		"replaced_by": replacedBy,
+		"deleted_at":  deletedAt,
+		"similar":     h.goneSuggestions(ctx, clipID, replacedBy, topics),
+	})
+}
+
+// goneSuggestions lists ready clips to offer instead of a gone one: the
+// clip it was merged into, then the best scored clips sharing its topics.
+func (h *Handler) goneSuggestions(ctx context.Context, clipID string, replacedBy *string, topics []string) []map[string]interface{} {
+	suggestions := make([]map[string]interface{}, 0, goneSuggestionLimit)
+	seen := map[string]bool{clipID: true}
+	add := func(query string, args ...interface{}) {
+		rows, err := h.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return
+		}
+		defer rows.Close()
+		for rows.Next() && len(suggestions) < goneSuggestionLimit {
+			var id, title, thumbnailKey string
+			var duration float64
+			if rows.Scan(&id, &title, &thumbnailKey, &duration) != nil || seen[id] {
+				continue
+			}
+			seen[id] = true
+			suggestions = append(suggestions, map[string]interface{}{
+				"id": id, "title": title,
+				"thumbnail_url":    httputil.ThumbnailURL(h.MinioBucket, thumbnailKey),
+				"duration_seconds": duration, "duration_bucket": httputil.DurationBucket(duration),
+			})
+		}
+	}
+
+	if replacedBy != nil {
+		add(`SELECT id, title, COALESCE(thumbnail_key, ''), duration_seconds
+			FROM clips WHERE id = ? AND status = 'ready'`, *replacedBy)
+	}
+	if len(topics) > 0 {
+		in := "(?" + strings.Repeat(", ?", len(topics)-1) + ")"
+		args := make([]interface{}, 0, len(topics)+1)
+		for _, t := range topics {
+			args = append(args, t)
+		}
+		args = append(args, goneSuggestionLimit+1)
+		add(`
+			SELECT c.id, c.title, COALESCE(c.thumbnail_key, ''), c.duration_seconds
+			FROM clips c
+			WHERE c.status = 'ready' AND c.id IN (
+				SELECT ct.clip_id FROM clip_topics ct
+				JOIN topics t ON t.id = ct.topic_id
+				WHERE t.name IN `+in+`
+			)
+			ORDER BY c.content_score DESC
+			LIMIT ?
+		`, args...)
+	}
+	return suggestions
+}
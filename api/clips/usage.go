@@ -0,0 +1,181 @@
+package clips
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+	"clipfeed/ratelimit"
+)
+
+const (
+	// streamSessionWindow is how long after its last stream request a
+	// device still counts as streaming.
+	streamSessionWindow = 3 * time.Minute
+	usageHistoryDays    = 30
+	maxDeviceIDLen      = 128
+)
+
+var errStreamLimit = errors.New("concurrent stream limit reached")
+
+// streamUser returns the signed-in user a stream URL is issued for, or "".
+func streamUser(ctx context.Context) string {
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+	return userID
+}
+
+// deviceID identifies the device a stream request comes from: the
+// X-Device-ID header clients send, or else a hash of the client address
+// and user agent.
+func deviceID(r *http.Request) string {
+	if id := r.Header.Get("X-Device-ID"); id != "" && len(id) <= maxDeviceIDLen {
+		return id
+	}
+	sum := sha256.Sum256([]byte(ratelimit.ClientIP(r) + "\n" + r.UserAgent()))
+	return "anon-" + hex.EncodeToString(sum[:8])
+}
+
+// claimStream records that the user's device is playing clipID. A device
+// that is already streaming may switch clips freely; a new one is refused
+// with errStreamLimit while the account's max_concurrent_streams other
+// devices are streaming. It returns the limit (nil for none) and how many
+// other devices are streaming.
+func (h *Handler) claimStream(ctx context.Context, r *http.Request, userID, clipID string) (*int, int, error) {
+	var limit *int
+	h.DB.QueryRowContext(ctx, `SELECT max_concurrent_streams FROM users WHERE id = ?`, userID).Scan(&limit)
+	device := deviceID(r)
+	now := time.Now().UTC()
+	var active int
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM stream_sessions
+		WHERE user_id = ? AND device_id <> ? AND last_seen_at > ?
+	`, userID, device, now.Add(-streamSessionWindow).Format("2006-01-02T15:04:05Z")).Scan(&active); err != nil {
+		return limit, 0, err
+	}
+	if limit != nil && active >= *limit {
+		return limit, active, errStreamLimit
+	}
+	_, err := h.DB.ExecContext(ctx, `
+		INSERT INTO stream_sessions (user_id, device_id, clip_id, last_seen_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET
+			clip_id      = excluded.clip_id,
+			last_seen_at = excluded.last_seen_at
+	`, userID, device, clipID, now.Format("2006-01-02T15:04:05Z"))
+	return limit, active, err
+}
+
+// touchStream keeps the device's stream session alive while its client
+// renews stream URLs.
+func (h *Handler) touchStream(ctx context.Context, r *http.Request, userID string) {
+	h.DB.ExecContext(ctx, `UPDATE stream_sessions SET last_seen_at = ? WHERE user_id = ? AND device_id = ?`,
+		time.Now().UTC().Format("2006-01-02T15:04:05Z"), userID, deviceID(r))
+}
+
+// recordStreamBytes adds n bytes served to userID's usage for today.
+func (h *Handler) recordStreamBytes(ctx context.Context, userID string, n int64) {
+	if _, err := h.DB.ExecContext(ctx, `
+		INSERT INTO stream_usage (user_id, day, bytes_served, requests) VALUES (?, ?, ?, 1)
+		ON CONFLICT (user_id, day) DO UPDATE SET
+			bytes_served = stream_usage.bytes_served + excluded.bytes_served,
+			requests     = stream_usage.requests + 1
+	`, userID, time.Now().UTC().Format("2006-01-02"), n); err != nil {
+		log.Printf("record stream usage for %s: %v", userID, err)
+	}
+}
+
+// countingWriter counts the body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// HandleMyUsage reports the media bytes served to the user per day over the
+// last 30 days, and the devices they are streaming on against their
+// concurrent stream limit. Bytes are only counted in proxy stream mode;
+// presigned URLs are served by nginx without the API seeing them.
+func (h *Handler) HandleMyUsage(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.ExtractUserID(r)
+	ctx := r.Context()
+	now := time.Now().UTC()
+
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT day, bytes_served, requests FROM stream_usage
+		WHERE user_id = ? AND day > ?
+		ORDER BY day DESC
+	`, userID, now.AddDate(0, 0, -usageHistoryDays).Format("2006-01-02"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load usage"})
+		return
+	}
+	days := make([]map[string]interface{}, 0)
+	var total int64
+	for rows.Next() {
+		var day string
+		var bytes int64
+		var requests int
+		if rows.Scan(&day, &bytes, &requests) != nil {
+			continue
+		}
+		total += bytes
+		days = append(days, map[string]interface{}{"day": day, "bytes_served": bytes, "requests": requests})
+	}
+	rows.Close()
+
+	var limit *int
+	h.DB.QueryRowContext(ctx, `SELECT max_concurrent_streams FROM users WHERE id = ?`, userID).Scan(&limit)
+	sessions, err := h.DB.QueryContext(ctx, `
+		SELECT device_id, clip_id, last_seen_at FROM stream_sessions
+		WHERE user_id = ? AND last_seen_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, now.Add(-streamSessionWindow).Format("2006-01-02T15:04:05Z"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load streams"})
+		return
+	}
+	streams := make([]map[string]interface{}, 0)
+	for sessions.Next() {
+		var device, clipID, lastSeen string
+		if sessions.Scan(&device, &clipID, &lastSeen) != nil {
+			continue
+		}
+		streams = append(streams, map[string]interface{}{
+			"device_id": device, "clip_id": clipID, "last_seen_at": lastSeen,
+		})
+	}
+	sessions.Close()
+
+	mode := h.StreamMode
+	if mode == "" {
+		mode = StreamModePresign
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"stream_mode":            mode,
+		"bytes_tracked":          mode == StreamModeProxy,
+		"days":                   days,
+		"total_bytes":            total,
+		"streams":                streams,
+		"max_concurrent_streams": limit,
+	})
+}
+
+// writeStreamLimit answers a stream request refused by claimStream.
+func writeStreamLimit(w http.ResponseWriter, limit, active int) {
+	httputil.WriteJSON(w, 429, map[string]interface{}{
+		"error":                  fmt.Sprintf("concurrent stream limit of %d reached; stop playback on another device", limit),
+		"max_concurrent_streams": limit,
+		"active_streams":         active,
+	})
+}
@@ -0,0 +1,390 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+	"clipfeed/images"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxCoverBytes caps the size of an uploaded cover image.
+	MaxCoverBytes = 5 << 20
+	// CoverSize is the width and height covers are stored at.
+	CoverSize = 512
+	// maxCoverPixels caps an image's dimensions before it is decoded.
+	maxCoverPixels = 4096 * 4096
+	// mosaicTiles is how many member thumbnails a mosaic shows.
+	mosaicTiles = 4
+
+	coverPrefix = "covers/"
+
+	coverUpload = "upload"
+	coverMosaic = "mosaic"
+)
+
+// coverTypes are the image types accepted for upload.
+var coverTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// coverVersion is the part of a cover key that changes with every image.
+func coverVersion(key string) string {
+	return strings.TrimSuffix(key[strings.LastIndex(key, "/")+1:], ".jpg")
+}
+
+// CoverURL is where the cover stored at key is served. The version in the
+// URL is what lets a caller fetch the cover of a private collection, and
+// means a new cover is never served from a stale cache.
+func CoverURL(collectionID, key string) string {
+	return "/api/collections/" + collectionID + "/cover?v=" + coverVersion(key)
+}
+
+// coverURL is CoverURL for a listing, nil without a cover.
+func coverURL(collectionID string, key *string) interface{} {
+	if key == nil || *key == "" {
+		return nil
+	}
+	return CoverURL(collectionID, *key)
+}
+
+// ownedCover loads the cover key of a collection the user owns, answering
+// 404 when they do not.
+func (h *Handler) ownedCover(w http.ResponseWriter, r *http.Request, id, userID string) (*string, bool) {
+	var key *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT cover_key FROM collections WHERE id = ? AND user_id = ?`, id, userID,
+	).Scan(&key); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+		return nil, false
+	}
+	return key, true
+}
+
+// removeCover deletes a cover object that is no longer used.
+func (h *Handler) removeCover(ctx context.Context, key *string) {
+	if key == nil || *key == "" || h.Covers == nil {
+		return
+	}
+	if err := h.Covers.Remove(ctx, *key); err != nil {
+		log.Printf("collections: remove cover %s: %v", *key, err)
+	}
+}
+
+// HandleUploadCover replaces a collection's cover with the image in the
+// "cover" field of a multipart form, cropped to a square of CoverSize. An
+// uploaded cover stays until it is deleted, however the clips change.
+func (h *Handler) HandleUploadCover(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	collectionID := chi.URLParam(r, "id")
+	if h.Covers == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "cover storage unavailable"})
+		return
+	}
+	oldKey, ok := h.ownedCover(w, r, collectionID, userID)
+	if !ok {
+		return
+	}
+
+	httputil.MaxBody(r, MaxCoverBytes+64<<10)
+	file, _, err := r.FormFile("cover")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httputil.WriteJSON(w, 413, map[string]string{"error": fmt.Sprintf("cover must be at most %d MB", MaxCoverBytes>>20)})
+			return
+		}
+		httputil.WriteJSON(w, 400, map[string]string{"error": "cover file required"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, MaxCoverBytes+1))
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "failed to read cover"})
+		return
+	}
+	if len(data) > MaxCoverBytes {
+		httputil.WriteJSON(w, 413, map[string]string{"error": fmt.Sprintf("cover must be at most %d MB", MaxCoverBytes>>20)})
+		return
+	}
+	if !coverTypes[http.DetectContentType(data)] {
+		httputil.WriteJSON(w, 415, map[string]string{"error": "cover must be a JPEG, PNG, or GIF image"})
+		return
+	}
+	src, err := images.Decode(data, maxCoverPixels)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "cover is not a readable image"})
+		return
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, CoverSize, CoverSize))
+	images.Fill(dst, dst.Bounds(), src)
+	cover, err := images.EncodeJPEG(dst)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to encode cover"})
+		return
+	}
+
+	key := coverPrefix + collectionID + "/" + uuid.New().String() + ".jpg"
+	if err := h.Covers.Put(r.Context(), key, cover, "image/jpeg"); err != nil {
+		log.Printf("collections: store cover for %s: %v", collectionID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store cover"})
+		return
+	}
+	if _, err := h.DB.ExecContext(r.Context(),
+		`UPDATE collections SET cover_key = ?, cover_source = ?, cover_thumbs = NULL WHERE id = ?`,
+		key, coverUpload, collectionID); err != nil {
+		h.Covers.Remove(r.Context(), key)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save cover"})
+		return
+	}
+	h.removeCover(r.Context(), oldKey)
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"cover_url": coverURL(collectionID, &key), "cover_source": coverUpload,
+	})
+}
+
+// HandleDeleteCover removes a collection's uploaded cover and goes back to
+// a mosaic of its clips.
+func (h *Handler) HandleDeleteCover(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	collectionID := chi.URLParam(r, "id")
+	key, ok := h.ownedCover(w, r, collectionID, userID)
+	if !ok {
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		`UPDATE collections SET cover_key = NULL, cover_source = NULL, cover_thumbs = NULL WHERE id = ? AND cover_source = ?`,
+		collectionID, coverUpload)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove cover"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		h.removeCover(r.Context(), key)
+	}
+	h.touchMosaic(r.Context(), collectionID)
+
+	var source *string
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT cover_key, cover_source FROM collections WHERE id = ?`, collectionID).Scan(&key, &source)
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"cover_url": coverURL(collectionID, key), "cover_source": source,
+	})
+}
+
+// HandleGetCover serves a collection's cover. The request must name the
+// current cover's version, as the cover_url in listings does.
+func (h *Handler) HandleGetCover(w http.ResponseWriter, r *http.Request) {
+	var key *string
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT cover_key FROM collections WHERE id = ?`, chi.URLParam(r, "id")).Scan(&key)
+	if key == nil || *key == "" || r.URL.Query().Get("v") != coverVersion(*key) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "no cover"})
+		return
+	}
+	if h.Covers == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "cover storage unavailable"})
+		return
+	}
+	obj, err := h.Covers.Get(r.Context(), *key)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "no cover"})
+		return
+	}
+	defer obj.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	// Cover URLs change with every image, so one never goes stale.
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	io.Copy(w, obj)
+}
+
+// mosaicThumbs returns the thumbnail keys of the first clips in a
+// collection, as many as a mosaic shows.
+func (h *Handler) mosaicThumbs(ctx context.Context, id string) ([]string, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT c.thumbnail_key
+		FROM collection_clips cc
+		JOIN clips c ON cc.clip_id = c.id
+		WHERE cc.collection_id = ? AND c.status = 'ready' AND COALESCE(c.thumbnail_key, '') != ''
+		  AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id)
+		ORDER BY cc.position ASC, cc.added_at DESC
+		LIMIT ?
+	`, id, mosaicTiles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// updateMosaic rebuilds the mosaic cover of collection id when the
+// thumbnails it shows have changed, and reports whether it did. A
+// collection with an uploaded cover is left alone, and one without
+// readable thumbnails loses its mosaic.
+func (h *Handler) updateMosaic(ctx context.Context, id string) (bool, error) {
+	if h.Covers == nil {
+		return false, nil
+	}
+	var oldKey, source, built *string
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT cover_key, cover_source, cover_thumbs FROM collections WHERE id = ?`, id,
+	).Scan(&oldKey, &source, &built); err != nil {
+		return false, err
+	}
+	if source != nil && *source == coverUpload {
+		return false, nil
+	}
+	thumbs, err := h.mosaicThumbs(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	joined := strings.Join(thumbs, "\n")
+	if built != nil && *built == joined || built == nil && oldKey == nil && len(thumbs) == 0 {
+		return false, nil
+	}
+
+	var tiles []image.Image
+	for _, key := range thumbs {
+		img, err := h.readThumb(ctx, key)
+		if err != nil {
+			log.Printf("collections: mosaic %s: thumbnail %s: %v", id, key, err)
+			continue
+		}
+		tiles = append(tiles, img)
+	}
+
+	var key interface{}
+	var sourceArg interface{}
+	if len(tiles) > 0 {
+		dst := image.NewRGBA(image.Rect(0, 0, CoverSize, CoverSize))
+		for i, rect := range mosaicLayout(len(tiles)) {
+			images.Fill(dst, rect, tiles[i])
+		}
+		data, err := images.EncodeJPEG(dst)
+		if err != nil {
+			return false, err
+		}
+		newKey := coverPrefix + id + "/" + uuid.New().String() + ".jpg"
+		if err := h.Covers.Put(ctx, newKey, data, "image/jpeg"); err != nil {
+			return false, fmt.Errorf("store mosaic: %w", err)
+		}
+		key, sourceArg = newKey, coverMosaic
+	}
+
+	// An upload that lands while the mosaic is built wins.
+	res, err := h.DB.ExecContext(ctx, `
+		UPDATE collections SET cover_key = ?, cover_source = ?, cover_thumbs = ?
+		WHERE id = ? AND (cover_source IS NULL OR cover_source = ?)
+	`, key, sourceArg, joined, id, coverMosaic)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			err = errors.New("cover changed while the mosaic was built")
+		}
+	}
+	if err != nil {
+		if newKey, ok := key.(string); ok {
+			h.Covers.Remove(ctx, newKey)
+		}
+		return false, err
+	}
+	h.removeCover(ctx, oldKey)
+	return true, nil
+}
+
+// touchMosaic updates a collection's mosaic after its clips changed,
+// logging rather than failing the change.
+func (h *Handler) touchMosaic(ctx context.Context, id string) {
+	if _, err := h.updateMosaic(ctx, id); err != nil {
+		log.Printf("collections: mosaic %s: %v", id, err)
+	}
+}
+
+// RefreshMosaics updates the mosaic of every collection without an
+// uploaded cover, catching clips that expired or were merged away, and
+// returns how many it rebuilt.
+func (h *Handler) RefreshMosaics(ctx context.Context) (int, error) {
+	if h.Covers == nil {
+		return 0, nil
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id FROM collections WHERE cover_source IS NULL OR cover_source = ?`, coverMosaic)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	rebuilt := 0
+	for _, id := range ids {
+		changed, err := h.updateMosaic(ctx, id)
+		if err != nil {
+			log.Printf("collections: mosaic %s: %v", id, err)
+			continue
+		}
+		if changed {
+			rebuilt++
+		}
+	}
+	return rebuilt, nil
+}
+
+func (h *Handler) readThumb(ctx context.Context, key string) (image.Image, error) {
+	obj, err := h.Covers.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(io.LimitReader(obj, MaxCoverBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxCoverBytes {
+		return nil, errors.New("thumbnail too large")
+	}
+	return images.Decode(data, maxCoverPixels)
+}
+
+// mosaicLayout splits the cover square into n tiles, for n of 1 to
+// mosaicTiles: one fills it, two sit side by side, three put one on the
+// left and two stacked on the right, and four take a quadrant each.
+func mosaicLayout(n int) []image.Rectangle {
+	s, half := CoverSize, CoverSize/2
+	switch n {
+	case 1:
+		return []image.Rectangle{image.Rect(0, 0, s, s)}
+	case 2:
+		return []image.Rectangle{image.Rect(0, 0, half, s), image.Rect(half, 0, s, s)}
+	case 3:
+		return []image.Rectangle{
+			image.Rect(0, 0, half, s), image.Rect(half, 0, s, half), image.Rect(half, half, s, s),
+		}
+	}
+	return []image.Rectangle{
+		image.Rect(0, 0, half, half), image.Rect(half, 0, s, half),
+		image.Rect(0, half, half, s), image.Rect(half, half, s, s),
+	}
+}
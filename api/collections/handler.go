@@ -1,13 +1,16 @@
 package collections
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
 
 	"clipfeed/auth"
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/images"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -17,16 +20,24 @@ import (
 type Handler struct {
 	DB          *db.CompatDB
 	MinioBucket string
+	// Match returns the IDs of clips matching a search query, best first;
+	// smart collections are unavailable without it.
+	Match func(ctx context.Context, query string, limit int) ([]string, error)
+	// Covers stores collection covers and holds the clip thumbnails mosaics
+	// are built from; without it collections have none.
+	Covers images.Store
 }
 
-// HandleCreateCollection creates a new collection.
+// HandleCreateCollection creates a new collection. Given filters, it is a
+// smart collection holding the clips those search queries match.
 func (h *Handler) HandleCreateCollection(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	httputil.MaxBody(r, httputil.DefaultBodyLimit)
 
 	var req struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		Filters     []string `json:"filters"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
@@ -42,23 +53,46 @@ func (h *Handler) HandleCreateCollection(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	var filtersJSON interface{}
+	if req.Filters != nil {
+		if h.Match == nil {
+			httputil.WriteJSON(w, 503, map[string]string{"error": "smart collections are not available"})
+			return
+		}
+		filters, ok := checkFilters(w, req.Filters)
+		if !ok {
+			return
+		}
+		req.Filters = filters
+		b, _ := json.Marshal(filters)
+		filtersJSON = string(b)
+	}
+
 	id := uuid.New().String()
 	_, err := h.DB.ExecContext(r.Context(),
-		`INSERT INTO collections (id, user_id, title, description) VALUES (?, ?, ?, ?)`,
-		id, userID, req.Title, req.Description)
+		`INSERT INTO collections (id, user_id, title, description, filters) VALUES (?, ?, ?, ?, ?)`,
+		id, userID, req.Title, req.Description, filtersJSON)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create collection"})
 		return
 	}
-	httputil.WriteJSON(w, 201, map[string]string{"id": id})
+	if req.Filters == nil {
+		httputil.WriteJSON(w, 201, map[string]string{"id": id})
+		return
+	}
+	if err := h.refresh(r.Context(), id, req.Filters); err != nil {
+		log.Printf("collections: refresh %s: %v", id, err)
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id, "filters": req.Filters})
 }
 
-// HandleListCollections lists the user's collections with clip counts.
+// HandleListCollections lists the user's collections with clip counts and
+// covers.
 func (h *Handler) HandleListCollections(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	rows, err := h.DB.QueryContext(r.Context(), `
-		SELECT c.id, c.title, c.description, c.is_public, c.created_at,
-		       COUNT(cc.clip_id) as clip_count
+		SELECT c.id, c.title, c.description, c.is_public, c.created_at, c.filters,
+		       c.cover_key, c.cover_source, COUNT(cc.clip_id) as clip_count
 		FROM collections c
 		LEFT JOIN collection_clips cc ON c.id = cc.collection_id
 		WHERE c.user_id = ?
@@ -75,16 +109,24 @@ func (h *Handler) HandleListCollections(w http.ResponseWriter, r *http.Request)
 	var cols []map[string]interface{}
 	for rows.Next() {
 		var id, title, createdAt string
-		var description *string
+		var description, filtersJSON, coverKey, coverSource *string
 		var isPublic int
 		var clipCount int
-		if err := rows.Scan(&id, &title, &description, &isPublic, &createdAt, &clipCount); err != nil {
+		if err := rows.Scan(&id, &title, &description, &isPublic, &createdAt, &filtersJSON,
+			&coverKey, &coverSource, &clipCount); err != nil {
 			continue
 		}
-		cols = append(cols, map[string]interface{}{
+		col := map[string]interface{}{
 			"id": id, "title": title, "description": description,
 			"is_public": isPublic == 1, "clip_count": clipCount, "created_at": createdAt,
-		})
+			"smart": filtersJSON != nil, "cover_url": coverURL(id, coverKey), "cover_source": coverSource,
+		}
+		if filtersJSON != nil {
+			var filters []string
+			json.Unmarshal([]byte(*filtersJSON), &filters)
+			col["filters"] = filters
+		}
+		cols = append(cols, col)
 	}
 	if cols == nil {
 		cols = make([]map[string]interface{}, 0)
@@ -92,6 +134,23 @@ func (h *Handler) HandleListCollections(w http.ResponseWriter, r *http.Request)
 	httputil.WriteJSON(w, 200, map[string]interface{}{"collections": cols})
 }
 
+// ownsManual reports whether the user owns manual collection id, answering
+// 404 or 409 when not.
+func (h *Handler) ownsManual(w http.ResponseWriter, r *http.Request, id, userID string) bool {
+	var filters *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT filters FROM collections WHERE id = ? AND user_id = ?`, id, userID,
+	).Scan(&filters); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+		return false
+	}
+	if filters != nil {
+		httputil.WriteJSON(w, 409, map[string]string{"error": errSmartCollection.Error()})
+		return false
+	}
+	return true
+}
+
 // HandleAddToCollection adds a clip to a collection.
 func (h *Handler) HandleAddToCollection(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
@@ -104,11 +163,7 @@ func (h *Handler) HandleAddToCollection(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var count int
-	if err := h.DB.QueryRowContext(r.Context(),
-		`SELECT COUNT(*) FROM collections WHERE id = ? AND user_id = ?`, collectionID, userID,
-	).Scan(&count); err != nil || count == 0 {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+	if !h.ownsManual(w, r, collectionID, userID) {
 		return
 	}
 
@@ -121,6 +176,7 @@ func (h *Handler) HandleAddToCollection(w http.ResponseWriter, r *http.Request)
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to add to collection"})
 		return
 	}
+	h.touchMosaic(r.Context(), collectionID)
 	httputil.WriteJSON(w, 200, map[string]string{"status": "added"})
 }
 
@@ -130,11 +186,7 @@ func (h *Handler) HandleRemoveFromCollection(w http.ResponseWriter, r *http.Requ
 	collectionID := chi.URLParam(r, "id")
 	clipID := chi.URLParam(r, "clipId")
 
-	var count int
-	if err := h.DB.QueryRowContext(r.Context(),
-		`SELECT COUNT(*) FROM collections WHERE id = ? AND user_id = ?`, collectionID, userID,
-	).Scan(&count); err != nil || count == 0 {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+	if !h.ownsManual(w, r, collectionID, userID) {
 		return
 	}
 
@@ -144,30 +196,55 @@ func (h *Handler) HandleRemoveFromCollection(w http.ResponseWriter, r *http.Requ
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove from collection"})
 		return
 	}
+	h.touchMosaic(r.Context(), collectionID)
 	httputil.WriteJSON(w, 200, map[string]string{"status": "removed"})
 }
 
-// HandleGetCollectionClips returns clips in a collection.
+// HandleGetCollectionClips returns clips in a collection. A smart
+// collection is rebuilt first when it is due, and each clip names the
+// filter that matched it.
 func (h *Handler) HandleGetCollectionClips(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	collectionID := chi.URLParam(r, "id")
 
 	var colTitle string
-	var colDesc *string
-	if err := h.DB.QueryRowContext(r.Context(),
-		`SELECT title, description FROM collections WHERE id = ? AND user_id = ?`, collectionID, userID,
-	).Scan(&colTitle, &colDesc); err != nil {
+	var colDesc, filtersJSON, refreshedAt, coverKey, coverSource *string
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT title, description, filters, refreshed_at, cover_key, cover_source
+		FROM collections WHERE id = ? AND user_id = ?
+	`, collectionID, userID).Scan(&colTitle, &colDesc, &filtersJSON, &refreshedAt, &coverKey, &coverSource); err != nil {
 		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
 		return
 	}
+	collection := map[string]interface{}{
+		"id": collectionID, "title": colTitle, "description": colDesc,
+		"cover_url": coverURL(collectionID, coverKey), "cover_source": coverSource,
+	}
+	if filtersJSON != nil {
+		var filters []string
+		json.Unmarshal([]byte(*filtersJSON), &filters)
+		if smartStale(refreshedAt) {
+			if err := h.refresh(r.Context(), collectionID, filters); err != nil {
+				// Serve the clips from the last refresh.
+				log.Printf("collections: refresh %s: %v", collectionID, err)
+			} else {
+				h.DB.QueryRowContext(r.Context(),
+					`SELECT refreshed_at FROM collections WHERE id = ?`, collectionID).Scan(&refreshedAt)
+			}
+		}
+		collection["smart"] = true
+		collection["filters"] = filters
+		collection["refreshed_at"] = refreshedAt
+	}
 
 	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
-		       c.topics, c.created_at, s.platform, s.channel_name, s.url
+		       c.topics, c.created_at, c.start_time, c.end_time,
+		       s.platform, s.channel_name, s.url, cc.matched_filter
 		FROM collection_clips cc
 		JOIN clips c ON cc.clip_id = c.id
 		LEFT JOIN sources s ON c.source_id = s.id
-		WHERE cc.collection_id = ?
+		WHERE cc.collection_id = ? AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id)
 		ORDER BY cc.position ASC, cc.added_at DESC
 		LIMIT 200
 	`, collectionID)
@@ -181,23 +258,30 @@ func (h *Handler) HandleGetCollectionClips(w http.ResponseWriter, r *http.Reques
 	for rows.Next() {
 		var id, title, thumbnailKey, topicsJSON, createdAt string
 		var duration float64
-		var platform, channelName, sourceURL *string
+		var platform, channelName, sourceURL, matchedFilter *string
+		var startTime, endTime *float64
 		if err := rows.Scan(&id, &title, &duration, &thumbnailKey, &topicsJSON, &createdAt,
-			&platform, &channelName, &sourceURL); err != nil {
+			&startTime, &endTime, &platform, &channelName, &sourceURL, &matchedFilter); err != nil {
 			continue
 		}
 		var topics []string
 		json.Unmarshal([]byte(topicsJSON), &topics)
-		clips = append(clips, map[string]interface{}{
+		clip := map[string]interface{}{
 			"id": id, "title": title, "duration_seconds": duration,
+			"duration_bucket": httputil.DurationBucket(duration),
 			"thumbnail_key": thumbnailKey,
 			"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumbnailKey),
 			"topics": topics, "created_at": createdAt,
 			"platform": platform, "channel_name": channelName, "source_url": sourceURL,
-		})
+			"attribution": httputil.Attribution(platform, sourceURL, channelName, startTime, endTime),
+		}
+		if filtersJSON != nil {
+			clip["matched_filter"] = matchedFilter
+		}
+		clips = append(clips, clip)
 	}
 	httputil.WriteJSON(w, 200, map[string]interface{}{
-		"collection": map[string]interface{}{"id": collectionID, "title": colTitle, "description": colDesc},
+		"collection": collection,
 		"clips":      clips,
 	})
 }
@@ -207,6 +291,9 @@ func (h *Handler) HandleDeleteCollection(w http.ResponseWriter, r *http.Request)
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	collectionID := chi.URLParam(r, "id")
 
+	var coverKey *string
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT cover_key FROM collections WHERE id = ? AND user_id = ?`, collectionID, userID).Scan(&coverKey)
 	res, err := h.DB.ExecContext(r.Context(),
 		`DELETE FROM collections WHERE id = ? AND user_id = ?`, collectionID, userID)
 	if err != nil {
@@ -218,5 +305,6 @@ func (h *Handler) HandleDeleteCollection(w http.ResponseWriter, r *http.Request)
 		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
 		return
 	}
+	h.removeCover(r.Context(), coverKey)
 	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
 }
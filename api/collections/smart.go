@@ -0,0 +1,240 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxSmartFilters = 10
+	maxFilterLen    = 500
+	// maxSmartClips caps the clips a smart collection holds, as many as a
+	// collection lists.
+	maxSmartClips = 200
+	// SmartRefreshInterval is how often smart collections are rebuilt.
+	// Reading one that is older rebuilds it first.
+	SmartRefreshInterval = 15 * time.Minute
+)
+
+var errSmartCollection = errors.New("smart collections are filled by their filters")
+
+// checkFilters trims filters and checks each is a valid search query. It
+// answers 400 and reports false when they are not.
+func checkFilters(w http.ResponseWriter, filters []string) ([]string, bool) {
+	if len(filters) == 0 || len(filters) > maxSmartFilters {
+		httputil.WriteJSON(w, 400, map[string]string{
+			"error": fmt.Sprintf("filters must list 1-%d search queries", maxSmartFilters),
+		})
+		return nil, false
+	}
+	out := make([]string, len(filters))
+	for i, f := range filters {
+		f = strings.TrimSpace(f)
+		if f == "" || len(f) > maxFilterLen {
+			httputil.WriteJSON(w, 400, map[string]interface{}{
+				"error":  fmt.Sprintf("filters[%d] must be 1-%d characters", i, maxFilterLen),
+				"filter": i,
+			})
+			return nil, false
+		}
+		nodes, err := feed.ParseSearchQuery(f)
+		var parseErr *feed.SearchParseError
+		if errors.As(err, &parseErr) {
+			httputil.WriteJSON(w, 400, map[string]interface{}{
+				"error":  fmt.Sprintf("filters[%d]: invalid query: %s", i, parseErr.Msg),
+				"filter": i, "position": parseErr.Pos,
+			})
+			return nil, false
+		}
+		if err != nil || len(nodes) == 0 {
+			httputil.WriteJSON(w, 400, map[string]interface{}{
+				"error": fmt.Sprintf("filters[%d] is not a search query", i), "filter": i,
+			})
+			return nil, false
+		}
+		out[i] = f
+	}
+	return out, true
+}
+
+// refresh rebuilds smart collection id from filters. Clips keep the
+// position search gives them under the first filter that matches; clips
+// still matching keep when they were added.
+func (h *Handler) refresh(ctx context.Context, id string, filters []string) error {
+	if h.Match == nil {
+		return errors.New("smart collections are not available")
+	}
+	seen := map[string]bool{}
+	var clipIDs, matched []string
+	for _, f := range filters {
+		ids, err := h.Match(ctx, f, maxSmartClips)
+		if err != nil {
+			return fmt.Errorf("filter %q: %w", f, err)
+		}
+		for _, clipID := range ids {
+			if !seen[clipID] && len(clipIDs) < maxSmartClips {
+				seen[clipID] = true
+				clipIDs = append(clipIDs, clipID)
+				matched = append(matched, f)
+			}
+		}
+	}
+
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		stale := `DELETE FROM collection_clips WHERE collection_id = ?`
+		args := []interface{}{id}
+		if len(clipIDs) > 0 {
+			stale += ` AND clip_id NOT IN (?` + strings.Repeat(", ?", len(clipIDs)-1) + `)`
+			for _, clipID := range clipIDs {
+				args = append(args, clipID)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, stale, args...); err != nil {
+			return err
+		}
+		for i, clipID := range clipIDs {
+			if _, err := conn.ExecContext(ctx, `
+				INSERT INTO collection_clips (collection_id, clip_id, position, matched_filter)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT (collection_id, clip_id) DO UPDATE
+				SET position = excluded.position, matched_filter = excluded.matched_filter
+			`, id, clipID, i, matched[i]); err != nil {
+				return err
+			}
+		}
+		_, err := conn.ExecContext(ctx, fmt.Sprintf(
+			`UPDATE collections SET refreshed_at = %s WHERE id = ?`, h.DB.NowUTC()), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	h.touchMosaic(ctx, id)
+	return nil
+}
+
+// smartStale reports whether a smart collection last refreshed at
+// refreshedAt is due for a rebuild.
+func smartStale(refreshedAt *string) bool {
+	if refreshedAt == nil {
+		return true
+	}
+	t, err := time.Parse("2006-01-02T15:04:05Z", *refreshedAt)
+	return err != nil || time.Since(t) >= SmartRefreshInterval
+}
+
+// RefreshLoop rebuilds smart collections every SmartRefreshInterval, so
+// playlists, search, and federation see their current clips, brings mosaic
+// covers up to date, and regroups saved clips into collection suggestions
+// for users due them.
+func (h *Handler) RefreshLoop() {
+	ticker := time.NewTicker(SmartRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := h.RefreshStale(context.Background()); err != nil {
+			log.Printf("collections: refresh: %v", err)
+		} else if n > 0 {
+			log.Printf("collections: refreshed %d smart collections", n)
+		}
+		if n, err := h.RefreshMosaics(context.Background()); err != nil {
+			log.Printf("collections: mosaics: %v", err)
+		} else if n > 0 {
+			log.Printf("collections: rebuilt %d cover mosaics", n)
+		}
+		if n, err := h.SuggestDue(context.Background()); err != nil {
+			log.Printf("collections: suggestions: %v", err)
+		} else if n > 0 {
+			log.Printf("collections: regrouped saved clips for %d users", n)
+		}
+	}
+}
+
+// RefreshStale rebuilds the smart collections not refreshed within
+// SmartRefreshInterval and returns how many it rebuilt.
+func (h *Handler) RefreshStale(ctx context.Context) (int, error) {
+	cutoff := time.Now().UTC().Add(-SmartRefreshInterval).Format("2006-01-02T15:04:05Z")
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id, filters FROM collections
+		WHERE filters IS NOT NULL AND (refreshed_at IS NULL OR refreshed_at <= ?)
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	due := map[string][]string{}
+	for rows.Next() {
+		var id, raw string
+		if rows.Scan(&id, &raw) != nil {
+			continue
+		}
+		var filters []string
+		json.Unmarshal([]byte(raw), &filters)
+		due[id] = filters
+	}
+	rows.Close()
+
+	refreshed := 0
+	for id, filters := range due {
+		if err := h.refresh(ctx, id, filters); err != nil {
+			log.Printf("collections: refresh %s: %v", id, err)
+			continue
+		}
+		refreshed++
+	}
+	return refreshed, nil
+}
+
+// HandleSetFilters replaces a smart collection's filters and rebuilds it.
+// A manual collection cannot be turned into a smart one.
+func (h *Handler) HandleSetFilters(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	collectionID := chi.URLParam(r, "id")
+	var req struct {
+		Filters []string `json:"filters"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	var current *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT filters FROM collections WHERE id = ? AND user_id = ?`, collectionID, userID,
+	).Scan(&current); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+		return
+	}
+	if current == nil {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "only smart collections have filters"})
+		return
+	}
+	filters, ok := checkFilters(w, req.Filters)
+	if !ok {
+		return
+	}
+
+	filtersJSON, _ := json.Marshal(filters)
+	if _, err := h.DB.ExecContext(r.Context(),
+		`UPDATE collections SET filters = ? WHERE id = ?`, string(filtersJSON), collectionID); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update filters"})
+		return
+	}
+	if err := h.refresh(r.Context(), collectionID, filters); err != nil {
+		log.Printf("collections: refresh %s: %v", collectionID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to refresh collection"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"id": collectionID, "filters": filters})
+}
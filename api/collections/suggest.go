@@ -0,0 +1,496 @@
+package collections
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// SuggestInterval is how often a user's saved clips are regrouped into
+	// collection suggestions.
+	SuggestInterval = 6 * time.Hour
+	// suggestMinClips is the fewest saved clips a suggestion groups.
+	suggestMinClips = 5
+	// suggestMinSimilarity is how close a clip's text embedding must be to a
+	// group's centre to join it.
+	suggestMinSimilarity = 0.6
+	// suggestMaxSaved caps the saved clips grouped per user, newest first.
+	suggestMaxSaved = 500
+	// maxSuggestions caps the suggestions a user has open at once.
+	maxSuggestions = 5
+	// suggestPreviewClips is how many clips a suggestion shows.
+	suggestPreviewClips = 4
+	// suggestDismissedOverlap is the share of a group's clips that, when a
+	// dismissed suggestion already held them, keeps it from being suggested.
+	suggestDismissedOverlap = 0.5
+)
+
+// savedEmbedding is a saved clip and its text embedding.
+type savedEmbedding struct {
+	id  string
+	vec []float32
+}
+
+// clusterSaved groups clips by text embedding. Each clip joins the group
+// whose centre it is most similar to, given suggestMinSimilarity, or starts
+// a new one. Groups of at least suggestMinClips are returned largest first,
+// each with its clips closest to the centre first.
+func clusterSaved(clips []savedEmbedding) [][]string {
+	type group struct {
+		sum     []float64
+		members []savedEmbedding
+	}
+	var groups []*group
+	centre := func(g *group) []float32 {
+		c := make([]float32, len(g.sum))
+		for i, v := range g.sum {
+			c[i] = float32(v / float64(len(g.members)))
+		}
+		return c
+	}
+	for _, clip := range clips {
+		var best *group
+		bestSim := suggestMinSimilarity
+		for _, g := range groups {
+			if sim := feed.CosineSimilarity(clip.vec, centre(g)); sim >= bestSim {
+				best, bestSim = g, sim
+			}
+		}
+		if best == nil {
+			best = &group{sum: make([]float64, len(clip.vec))}
+			groups = append(groups, best)
+		}
+		for i, v := range clip.vec {
+			best.sum[i] += float64(v)
+		}
+		best.members = append(best.members, clip)
+	}
+
+	var out [][]string
+	for _, g := range groups {
+		if len(g.members) < suggestMinClips {
+			continue
+		}
+		c := centre(g)
+		sims := make(map[string]float64, len(g.members))
+		for _, m := range g.members {
+			sims[m.id] = feed.CosineSimilarity(m.vec, c)
+		}
+		sort.SliceStable(g.members, func(i, j int) bool { return sims[g.members[i].id] > sims[g.members[j].id] })
+		ids := make([]string, len(g.members))
+		for i, m := range g.members {
+			ids[i] = m.id
+		}
+		out = append(out, ids)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i]) > len(out[j]) })
+	return out
+}
+
+// overlapsDismissed reports whether a dismissed suggestion already held
+// suggestDismissedOverlap of ids.
+func overlapsDismissed(ids []string, dismissed []map[string]bool) bool {
+	for _, d := range dismissed {
+		n := 0
+		for _, id := range ids {
+			if d[id] {
+				n++
+			}
+		}
+		if float64(n) >= suggestDismissedOverlap*float64(len(ids)) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest regroups the user's saved clips that are in none of their
+// collections and replaces their open suggestions with the groups.
+func (h *Handler) suggest(ctx context.Context, userID string) error {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT sc.clip_id, e.text_embedding
+		FROM saved_clips sc
+		JOIN clips c ON c.id = sc.clip_id
+		JOIN clip_embeddings e ON e.clip_id = sc.clip_id
+		WHERE sc.user_id = ? AND c.status = 'ready' AND e.text_embedding IS NOT NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM collection_clips cc JOIN collections col ON col.id = cc.collection_id
+			WHERE cc.clip_id = sc.clip_id AND col.user_id = sc.user_id)
+		ORDER BY sc.created_at DESC
+		LIMIT ?
+	`, userID, suggestMaxSaved)
+	if err != nil {
+		return err
+	}
+	var clips []savedEmbedding
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if rows.Scan(&id, &blob) != nil {
+			continue
+		}
+		if vec := feed.BlobToFloat32(blob); len(vec) > 0 {
+			clips = append(clips, savedEmbedding{id: id, vec: vec})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = h.DB.QueryContext(ctx,
+		`SELECT clip_ids FROM collection_suggestions WHERE user_id = ? AND status = 'dismissed'`, userID)
+	if err != nil {
+		return err
+	}
+	var dismissed []map[string]bool
+	for rows.Next() {
+		var raw string
+		var ids []string
+		if rows.Scan(&raw) != nil || json.Unmarshal([]byte(raw), &ids) != nil {
+			continue
+		}
+		set := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			set[id] = true
+		}
+		dismissed = append(dismissed, set)
+	}
+	rows.Close()
+
+	type suggestion struct {
+		title string
+		ids   []string
+	}
+	var suggestions []suggestion
+	for _, ids := range clusterSaved(clips) {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		if overlapsDismissed(ids, dismissed) {
+			continue
+		}
+		suggestions = append(suggestions, suggestion{title: h.suggestionTitle(ctx, ids), ids: ids})
+	}
+
+	return db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx,
+			`DELETE FROM collection_suggestions WHERE user_id = ? AND status = 'open'`, userID); err != nil {
+			return err
+		}
+		for _, s := range suggestions {
+			idsJSON, _ := json.Marshal(s.ids)
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO collection_suggestions (id, user_id, title, clip_ids) VALUES (?, ?, ?, ?)`,
+				uuid.New().String(), userID, s.title, string(idsJSON)); err != nil {
+				return err
+			}
+		}
+		_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO collection_suggestion_runs (user_id, ran_at) VALUES (?, %s)
+			ON CONFLICT (user_id) DO UPDATE SET ran_at = excluded.ran_at
+		`, h.DB.NowUTC()), userID)
+		return err
+	})
+}
+
+// suggestionTitle names a group of clips after the topic most of them
+// share, or else after the title of its most central clip.
+func (h *Handler) suggestionTitle(ctx context.Context, ids []string) string {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	var topic string
+	var n int
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT t.name, COUNT(*) AS n FROM clip_topics ct JOIN topics t ON t.id = ct.topic_id
+		WHERE ct.clip_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+		GROUP BY t.id, t.name
+		ORDER BY n DESC, SUM(ct.confidence) DESC, t.name
+		LIMIT 1
+	`, args...).Scan(&topic, &n)
+	if err == nil && 2*n >= len(ids) {
+		return topic
+	}
+	var title string
+	h.DB.QueryRowContext(ctx, `SELECT title FROM clips WHERE id = ?`, ids[0]).Scan(&title)
+	if len(title) > 200 {
+		title = strings.ToValidUTF8(title[:200], "")
+	}
+	if title == "" {
+		title = "Saved clips"
+	}
+	return title
+}
+
+// suggestStale reports whether the user's saved clips are due regrouping.
+func (h *Handler) suggestStale(ctx context.Context, userID string) bool {
+	var ranAt string
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT ran_at FROM collection_suggestion_runs WHERE user_id = ?`, userID).Scan(&ranAt); err != nil {
+		return true
+	}
+	t, err := time.Parse("2006-01-02T15:04:05Z", ranAt)
+	return err != nil || time.Since(t) >= SuggestInterval
+}
+
+// SuggestDue regroups the saved clips of users not grouped within
+// SuggestInterval who have saved enough clips for a suggestion, and
+// returns how many it regrouped.
+func (h *Handler) SuggestDue(ctx context.Context) (int, error) {
+	cutoff := time.Now().UTC().Add(-SuggestInterval).Format("2006-01-02T15:04:05Z")
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT sc.user_id FROM saved_clips sc
+		LEFT JOIN collection_suggestion_runs r ON r.user_id = sc.user_id
+		WHERE r.ran_at IS NULL OR r.ran_at <= ?
+		GROUP BY sc.user_id
+		HAVING COUNT(*) >= ?
+	`, cutoff, suggestMinClips)
+	if err != nil {
+		return 0, err
+	}
+	var due []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+
+	n := 0
+	for _, userID := range due {
+		if err := h.suggest(ctx, userID); err != nil {
+			log.Printf("collections: suggest for %s: %v", userID, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// suggestionClips returns those of ids the user still has saved, in
+// order, with their titles and thumbnail keys.
+func (h *Handler) suggestionClips(ctx context.Context, userID string, ids []string) ([]map[string]interface{}, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT c.id, c.title, c.thumbnail_key FROM saved_clips sc JOIN clips c ON c.id = sc.clip_id
+		WHERE sc.user_id = ? AND c.status = 'ready' AND c.id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byID := make(map[string]map[string]interface{}, len(ids))
+	for rows.Next() {
+		var id, title string
+		var thumbnailKey *string
+		if rows.Scan(&id, &title, &thumbnailKey) != nil {
+			continue
+		}
+		clip := map[string]interface{}{"id": id, "title": title, "thumbnail_url": nil}
+		if thumbnailKey != nil && *thumbnailKey != "" {
+			clip["thumbnail_url"] = httputil.ThumbnailURL(h.MinioBucket, *thumbnailKey)
+		}
+		byID[id] = clip
+	}
+	clips := make([]map[string]interface{}, 0, len(byID))
+	for _, id := range ids {
+		if c, ok := byID[id]; ok {
+			clips = append(clips, c)
+		}
+	}
+	return clips, rows.Err()
+}
+
+// loadSuggestion returns the title and clip IDs of the user's open
+// suggestion id.
+func (h *Handler) loadSuggestion(ctx context.Context, id, userID string) (string, []string, error) {
+	var title, raw string
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT title, clip_ids FROM collection_suggestions WHERE id = ? AND user_id = ? AND status = 'open'
+	`, id, userID).Scan(&title, &raw); err != nil {
+		return "", nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+		return "", nil, err
+	}
+	return title, ids, nil
+}
+
+// HandleListSuggestions lists suggested collections, largest first: groups
+// of at least suggestMinClips saved clips that are alike and in none of the
+// user's collections. Saved clips are regrouped first when due.
+func (h *Handler) HandleListSuggestions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	ctx := r.Context()
+	if h.suggestStale(ctx, userID) {
+		if err := h.suggest(ctx, userID); err != nil {
+			// Serve the suggestions from the last run.
+			log.Printf("collections: suggest for %s: %v", userID, err)
+		}
+	}
+
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id, title, clip_ids, created_at FROM collection_suggestions
+		WHERE user_id = ? AND status = 'open'
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list suggestions"})
+		return
+	}
+	type row struct {
+		id, title, createdAt string
+		ids                  []string
+	}
+	var found []row
+	for rows.Next() {
+		var s row
+		var raw string
+		if rows.Scan(&s.id, &s.title, &raw, &s.createdAt) != nil || json.Unmarshal([]byte(raw), &s.ids) != nil {
+			continue
+		}
+		found = append(found, s)
+	}
+	rows.Close()
+
+	suggestions := make([]map[string]interface{}, 0, len(found))
+	for _, s := range found {
+		clips, err := h.suggestionClips(ctx, userID, s.ids)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list suggestions"})
+			return
+		}
+		// Clips unsaved since the last run drop out; so does a group left
+		// too small.
+		if len(clips) < suggestMinClips {
+			continue
+		}
+		ids := make([]string, len(clips))
+		for i, c := range clips {
+			ids[i] = c["id"].(string)
+		}
+		preview := clips
+		if len(preview) > suggestPreviewClips {
+			preview = preview[:suggestPreviewClips]
+		}
+		suggestions = append(suggestions, map[string]interface{}{
+			"id": s.id, "title": s.title, "clip_count": len(clips), "clip_ids": ids,
+			"clips": preview, "created_at": s.createdAt,
+		})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i]["clip_count"].(int) > suggestions[j]["clip_count"].(int)
+	})
+	httputil.WriteJSON(w, 200, map[string]interface{}{"suggestions": suggestions})
+}
+
+// HandleAcceptSuggestion creates a collection from a suggestion, holding
+// its clips in order. The title defaults to the suggestion's.
+func (h *Handler) HandleAcceptSuggestion(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	suggestionID := chi.URLParam(r, "id")
+	var req struct {
+		Title       *string `json:"title"`
+		Description string  `json:"description"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+			return
+		}
+	}
+
+	title, ids, err := h.loadSuggestion(r.Context(), suggestionID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "suggestion not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load suggestion"})
+		return
+	}
+	if req.Title != nil {
+		title = strings.TrimSpace(*req.Title)
+	}
+	if title == "" || len(title) > 200 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "title is required and must be under 200 characters"})
+		return
+	}
+	if len(req.Description) > 2000 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "description must be under 2000 characters"})
+		return
+	}
+	clips, err := h.suggestionClips(r.Context(), userID, ids)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load suggestion"})
+		return
+	}
+
+	id := uuid.New().String()
+	err = db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(),
+			`INSERT INTO collections (id, user_id, title, description) VALUES (?, ?, ?, ?)`,
+			id, userID, title, req.Description); err != nil {
+			return err
+		}
+		for i, c := range clips {
+			if _, err := conn.ExecContext(r.Context(),
+				`INSERT INTO collection_clips (collection_id, clip_id, position) VALUES (?, ?, ?)`,
+				id, c["id"], i); err != nil {
+				return err
+			}
+		}
+		_, err := conn.ExecContext(r.Context(), `DELETE FROM collection_suggestions WHERE id = ?`, suggestionID)
+		return err
+	})
+	if err != nil {
+		log.Printf("collections: accept suggestion %s: %v", suggestionID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create collection"})
+		return
+	}
+	h.touchMosaic(r.Context(), id)
+	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id, "title": title, "clip_count": len(clips)})
+}
+
+// HandleDismissSuggestion dismisses a suggestion. Groups mostly made of
+// its clips are not suggested again.
+func (h *Handler) HandleDismissSuggestion(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	res, err := h.DB.ExecContext(r.Context(), `
+		UPDATE collection_suggestions SET status = 'dismissed' WHERE id = ? AND user_id = ? AND status = 'open'
+	`, chi.URLParam(r, "id"), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to dismiss suggestion"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "suggestion not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "dismissed"})
+}
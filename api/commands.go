@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"clipfeed/backup"
+	"clipfeed/devdata"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// runCommand dispatches CLI subcommands and returns the process exit code.
+func runCommand(cfg Config, name string, args []string) int {
+	if src, ok := strings.CutPrefix(name, "--restore-from="); ok {
+		return cmdRestore(cfg, src)
+	}
+	switch name {
+	case "--restore-from":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: clipfeed --restore-from <backup key or file>")
+			return 2
+		}
+		return cmdRestore(cfg, args[0])
+	case "gen-dataset":
+		return cmdGenDataset(cfg, args)
+	case "loadtest":
+		return cmdLoadTest(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n  gen-dataset  generate a synthetic dataset for ranking benchmarks\n  loadtest     measure feed, search, and similar-clip latency against a running server\n\nflags:\n  --restore-from <backup>  restore the database from a backup key or file, then exit\n", name)
+		return 2
+	}
+}
+
+// cmdGenDataset seeds the configured database with synthetic users, clips,
+// topics, embeddings, and interactions.
+func cmdGenDataset(cfg Config, args []string) int {
+	opts := devdata.DefaultOptions()
+	fs := flag.NewFlagSet("gen-dataset", flag.ContinueOnError)
+	fs.IntVar(&opts.Users, "users", opts.Users, "number of synthetic users")
+	fs.IntVar(&opts.Clips, "clips", opts.Clips, "number of synthetic clips")
+	fs.IntVar(&opts.Topics, "topics", opts.Topics, "number of topics")
+	fs.IntVar(&opts.InteractionsPerUser, "interactions", opts.InteractionsPerUser, "interactions per user")
+	fs.IntVar(&opts.EmbeddingDim, "dim", opts.EmbeddingDim, "embedding dimensions")
+	fs.IntVar(&opts.HistoryDays, "days", opts.HistoryDays, "days of history to spread clips and interactions over")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed (same seed, same dataset)")
+	purge := fs.Bool("purge", false, "remove previously generated synthetic rows first")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cdb := openDatabase(cfg)
+	defer cdb.Close()
+	ctx := context.Background()
+
+	if *purge {
+		if err := devdata.Purge(ctx, cdb); err != nil {
+			log.Printf("purge failed: %v", err)
+			return 1
+		}
+		log.Println("purged existing synthetic data")
+	}
+
+	start := time.Now()
+	summary, err := devdata.Generate(ctx, cdb, opts)
+	if err != nil {
+		log.Printf("generate failed: %v", err)
+		return 1
+	}
+	log.Printf("generated synthetic dataset in %s (login with any synth_user_NNN / %s)",
+		time.Since(start).Round(time.Millisecond), devdata.Password)
+	json.NewEncoder(os.Stdout).Encode(summary)
+	return 0
+}
+
+// cmdRestore replaces the configured database with a backup taken by
+// POST /api/admin/backup. src is a key under backups/ in the MinIO bucket
+// or a local file. Stop the server first; migrations run on its next start.
+func cmdRestore(cfg Config, src string) int {
+	client, err := minio.New(cfg.MinioEndpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.MinioAccess, cfg.MinioSecret, ""),
+		Secure: cfg.MinioSSL,
+	})
+	if err != nil {
+		log.Printf("minio client: %v", err)
+		return 1
+	}
+	driver := strings.ToLower(cfg.DBDriver)
+	target := backup.Target{
+		Postgres: driver == "postgres" || driver == "postgresql",
+		DBPath:   cfg.DBPath,
+		DBURL:    cfg.DBURL,
+	}
+	if target.Postgres && target.DBURL == "" {
+		log.Print("DB_URL is required when DB_DRIVER=postgres")
+		return 2
+	}
+
+	start := time.Now()
+	if err := backup.Restore(context.Background(), client, cfg.MinioBucket, src, target); err != nil {
+		log.Printf("restore failed: %v", err)
+		return 1
+	}
+	log.Printf("restored %s in %s", src, time.Since(start).Round(time.Millisecond))
+	return 0
+}
@@ -0,0 +1,309 @@
+// Package contentfilter matches keyword and regex filters against clip
+// transcripts. Instance-wide filters hide matching clips from restricted
+// profiles and each user's own filters hide them from that user. A match
+// with fewer hits than its filter's threshold is borderline: it hides the
+// clip too, but waits in an admin review queue to be confirmed or
+// dismissed.
+package contentfilter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"clipfeed/transcripts"
+
+	"github.com/google/uuid"
+)
+
+// Filter kinds.
+const (
+	KindKeyword = "keyword"
+	KindRegex   = "regex"
+)
+
+// Match statuses.
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusDismissed = "dismissed"
+)
+
+const (
+	maxPatternLen = 200
+	maxNameLen    = 100
+	maxThreshold  = 100
+	// excerptRadius is how many bytes of context around the first hit are
+	// kept for reviewers.
+	excerptRadius = 60
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Filter is one keyword or regex filter. UserID is empty for instance
+// filters.
+type Filter struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id,omitempty"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Pattern   string `json:"pattern"`
+	Threshold int    `json:"threshold"`
+	CreatedAt string `json:"created_at"`
+
+	re *regexp.Regexp
+}
+
+// Compile validates f and prepares its matcher. Keywords match whole words
+// case-insensitively; regexes are case-insensitive unless they set flags.
+func (f *Filter) Compile() error {
+	f.Name = strings.TrimSpace(f.Name)
+	f.Pattern = strings.TrimSpace(f.Pattern)
+	if f.Kind == "" {
+		f.Kind = KindKeyword
+	}
+	if f.Threshold == 0 {
+		f.Threshold = 1
+	}
+	switch {
+	case f.Pattern == "" || len(f.Pattern) > maxPatternLen:
+		return fmt.Errorf("pattern is required and must be at most %d characters", maxPatternLen)
+	case len(f.Name) > maxNameLen:
+		return fmt.Errorf("name must be at most %d characters", maxNameLen)
+	case f.Threshold < 1 || f.Threshold > maxThreshold:
+		return fmt.Errorf("threshold must be between 1 and %d", maxThreshold)
+	}
+	if f.Name == "" {
+		f.Name = f.Pattern
+	}
+	expr := f.Pattern
+	switch f.Kind {
+	case KindKeyword:
+		expr = `(?i)\b` + regexp.QuoteMeta(f.Pattern) + `\b`
+	case KindRegex:
+		if !strings.HasPrefix(expr, "(?") {
+			expr = "(?i)" + expr
+		}
+	default:
+		return errors.New("kind must be keyword or regex")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	f.re = re
+	return nil
+}
+
+// Match counts f's hits in text and returns an excerpt around the first.
+func (f *Filter) Match(text string) (int, string) {
+	hits := f.re.FindAllStringIndex(text, -1)
+	if len(hits) == 0 {
+		return 0, ""
+	}
+	return len(hits), excerpt(text, hits[0][0], hits[0][1])
+}
+
+func excerpt(text string, start, end int) string {
+	from, to := start-excerptRadius, end+excerptRadius
+	if from < 0 {
+		from = 0
+	}
+	if to > len(text) {
+		to = len(text)
+	}
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+	return strings.TrimSpace(text[from:to])
+}
+
+// Load returns the filters of userID, or the instance filters when userID
+// is empty, oldest first. With all set it returns every filter.
+func Load(ctx context.Context, q querier, userID string, all bool) ([]Filter, error) {
+	query := `SELECT id, COALESCE(user_id, ''), name, kind, pattern, threshold, created_at FROM content_filters`
+	var args []interface{}
+	switch {
+	case all:
+	case userID == "":
+		query += ` WHERE user_id IS NULL`
+	default:
+		query += ` WHERE user_id = ?`
+		args = append(args, userID)
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	filters := make([]Filter, 0)
+	for rows.Next() {
+		var f Filter
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Name, &f.Kind, &f.Pattern, &f.Threshold, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		// Stored filters compiled when they were created.
+		if f.Compile() == nil {
+			filters = append(filters, f)
+		}
+	}
+	return filters, rows.Err()
+}
+
+// Create validates f, stores it, and matches it against every ready clip's
+// transcript. It returns how many clips matched.
+func Create(ctx context.Context, conn interface {
+	execer
+	querier
+}, f *Filter) (int, error) {
+	if err := f.Compile(); err != nil {
+		return 0, err
+	}
+	f.ID = uuid.New().String()
+	var owner interface{}
+	if f.UserID != "" {
+		owner = f.UserID
+	}
+	if _, err := conn.ExecContext(ctx, `
+		INSERT INTO content_filters (id, user_id, name, kind, pattern, threshold) VALUES (?, ?, ?, ?, ?, ?)
+	`, f.ID, owner, f.Name, f.Kind, f.Pattern, f.Threshold); err != nil {
+		return 0, fmt.Errorf("insert filter: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT id, COALESCE(title, '') FROM clips WHERE status = 'ready'`)
+	if err != nil {
+		return 0, fmt.Errorf("load clips: %w", err)
+	}
+	type clip struct{ id, title string }
+	var clips []clip
+	for rows.Next() {
+		var c clip
+		if rows.Scan(&c.id, &c.title) == nil {
+			clips = append(clips, c)
+		}
+	}
+	rows.Close()
+
+	matched := 0
+	for _, c := range clips {
+		text, err := transcripts.Load(ctx, conn, c.id)
+		if err != nil {
+			return matched, fmt.Errorf("load transcript of %s: %w", c.id, err)
+		}
+		hit, err := record(ctx, conn, c.id, c.title+"\n"+text, []Filter{*f})
+		if err != nil {
+			return matched, err
+		}
+		if hit {
+			matched++
+		}
+	}
+	return matched, nil
+}
+
+// ScanClip matches every filter against a new clip's title and transcript
+// and tags the clip with those that hit. Run it in the transaction that
+// writes the clip.
+func ScanClip(ctx context.Context, conn interface {
+	execer
+	querier
+}, clipID, title, transcript string) error {
+	filters, err := Load(ctx, conn, "", true)
+	if err != nil {
+		return fmt.Errorf("load content filters: %w", err)
+	}
+	_, err = record(ctx, conn, clipID, title+"\n"+transcript, filters)
+	return err
+}
+
+// record stores the matches of filters in text, reporting whether any hit.
+func record(ctx context.Context, ex execer, clipID, text string, filters []Filter) (bool, error) {
+	hit := false
+	for i := range filters {
+		f := &filters[i]
+		n, snippet := f.Match(text)
+		if n == 0 {
+			continue
+		}
+		hit = true
+		status := StatusConfirmed
+		if n < f.Threshold {
+			status = StatusPending
+		}
+		if _, err := ex.ExecContext(ctx, `
+			INSERT INTO clip_filter_matches (clip_id, filter_id, match_count, excerpt, status) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (clip_id, filter_id) DO NOTHING
+		`, clipID, f.ID, n, snippet, status); err != nil {
+			return hit, fmt.Errorf("record filter match: %w", err)
+		}
+	}
+	return hit, nil
+}
+
+// Blocked returns which of clipIDs userID must not see: those matched by
+// one of their own filters, and for a restricted profile those matched by
+// an instance filter. Dismissed matches do not count.
+func Blocked(ctx context.Context, q querier, userID string, clipIDs []interface{}) (map[string]bool, error) {
+	blocked := make(map[string]bool)
+	if userID == "" || len(clipIDs) == 0 {
+		return blocked, nil
+	}
+	var restricted int
+	q.QueryRowContext(ctx, `SELECT restricted FROM users WHERE id = ?`, userID).Scan(&restricted)
+	args := append([]interface{}{userID, restricted}, clipIDs...)
+	rows, err := q.QueryContext(ctx, `
+		SELECT DISTINCT m.clip_id FROM clip_filter_matches m
+		JOIN content_filters f ON f.id = m.filter_id
+		WHERE m.status <> 'dismissed'
+		  AND (f.user_id = ? OR (f.user_id IS NULL AND ? = 1))
+		  AND m.clip_id IN (?`+strings.Repeat(", ?", len(clipIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			blocked[id] = true
+		}
+	}
+	return blocked, rows.Err()
+}
+
+// Tags returns the names of the instance filters clipID triggered, leaving
+// out dismissed matches. Users' own filters stay private.
+func Tags(ctx context.Context, q querier, clipID string) []string {
+	tags := make([]string, 0)
+	rows, err := q.QueryContext(ctx, `
+		SELECT f.name FROM clip_filter_matches m
+		JOIN content_filters f ON f.id = m.filter_id
+		WHERE m.clip_id = ? AND f.user_id IS NULL AND m.status <> 'dismissed'
+		ORDER BY f.name
+	`, clipID)
+	if err != nil {
+		return tags
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			tags = append(tags, name)
+		}
+	}
+	return tags
+}
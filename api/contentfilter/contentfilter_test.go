@@ -0,0 +1,75 @@
+package contentfilter
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFilterMatch_KeywordsAreWholeWordsIgnoringCase(t *testing.T) {
+	f := Filter{Kind: KindKeyword, Pattern: "Heck"}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	n, excerpt := f.Match("What the heck. HECK! Checkers are fine.")
+	if n != 2 {
+		t.Errorf("hits = %d, want 2 (checkers is not a hit)", n)
+	}
+	if excerpt == "" {
+		t.Error("excerpt is empty")
+	}
+	if f.Name != "Heck" || f.Threshold != 1 {
+		t.Errorf("defaults: name %q threshold %d, want pattern and 1", f.Name, f.Threshold)
+	}
+}
+
+func TestFilterMatch_KeywordMetacharactersAreLiteral(t *testing.T) {
+	f := Filter{Kind: KindKeyword, Pattern: "a.b"}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if n, _ := f.Match("axb a.b"); n != 1 {
+		t.Errorf("hits = %d, want 1", n)
+	}
+}
+
+func TestFilterMatch_RegexFlags(t *testing.T) {
+	f := Filter{Kind: KindRegex, Pattern: `gr[ae]y`}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if n, _ := f.Match("GREY and gray"); n != 2 {
+		t.Errorf("case-insensitive hits = %d, want 2", n)
+	}
+	sensitive := Filter{Kind: KindRegex, Pattern: `(?-i)gr[ae]y`}
+	if err := sensitive.Compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if n, _ := sensitive.Match("GREY and gray"); n != 1 {
+		t.Errorf("case-sensitive hits = %d, want 1", n)
+	}
+}
+
+func TestFilterCompile_Rejects(t *testing.T) {
+	for _, f := range []Filter{
+		{Kind: KindKeyword, Pattern: "  "},
+		{Kind: KindRegex, Pattern: "("},
+		{Kind: "glob", Pattern: "x"},
+		{Kind: KindKeyword, Pattern: "x", Threshold: -1},
+	} {
+		if err := f.Compile(); err == nil {
+			t.Errorf("Compile(%+v) succeeded, want an error", f)
+		}
+	}
+}
+
+func TestExcerpt_KeepsRunesWhole(t *testing.T) {
+	text := "ééééééééééééééééééééééééééééééééééééééééé bad ééééééééééééééééééééééééééééééééééééééé"
+	f := Filter{Kind: KindKeyword, Pattern: "bad"}
+	if err := f.Compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	_, excerpt := f.Match(text)
+	if !utf8.ValidString(excerpt) {
+		t.Fatalf("excerpt %q splits a rune", excerpt)
+	}
+}
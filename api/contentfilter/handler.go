@@ -0,0 +1,232 @@
+package contentfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// MaxUserFilters caps the filters one user may keep.
+	MaxUserFilters    = 50
+	defaultQueueLimit = 50
+	maxQueueLimit     = 200
+)
+
+var errNotFound = errors.New("filter not found")
+
+// Handler holds dependencies for the content filter endpoints.
+type Handler struct {
+	DB *db.CompatDB
+}
+
+// HandleListMyFilters lists the user's own filters.
+func (h *Handler) HandleListMyFilters(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.ExtractUserID(r)
+	h.list(w, r, userID)
+}
+
+// HandleCreateMyFilter adds a filter that hides matching clips from the
+// user's feed: `{name, kind, pattern, threshold}`.
+func (h *Handler) HandleCreateMyFilter(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.ExtractUserID(r)
+	var n int
+	h.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM content_filters WHERE user_id = ?`, userID).Scan(&n)
+	if n >= MaxUserFilters {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d filters", MaxUserFilters)})
+		return
+	}
+	h.create(w, r, userID)
+}
+
+// HandleDeleteMyFilter removes one of the user's filters.
+func (h *Handler) HandleDeleteMyFilter(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.ExtractUserID(r)
+	h.delete(w, r, `DELETE FROM content_filters WHERE id = ? AND user_id = ?`, chi.URLParam(r, "id"), userID)
+}
+
+// HandleListInstanceFilters lists the instance-wide filters.
+func (h *Handler) HandleListInstanceFilters(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, "")
+}
+
+// HandleCreateInstanceFilter adds an instance-wide filter that hides
+// matching clips from restricted profiles.
+func (h *Handler) HandleCreateInstanceFilter(w http.ResponseWriter, r *http.Request) {
+	h.create(w, r, "")
+}
+
+// HandleDeleteInstanceFilter removes an instance-wide filter with its
+// matches.
+func (h *Handler) HandleDeleteInstanceFilter(w http.ResponseWriter, r *http.Request) {
+	h.delete(w, r, `DELETE FROM content_filters WHERE id = ? AND user_id IS NULL`, chi.URLParam(r, "id"))
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, userID string) {
+	filters, err := Load(r.Context(), h.DB, userID, false)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list filters"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"filters": filters})
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, userID string) {
+	var f Filter
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&f); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	f.UserID = userID
+	if err := f.Compile(); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	matched, err := Create(r.Context(), h.DB, &f)
+	if err != nil {
+		log.Printf("create content filter: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create filter"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"filter": f, "matched_clips": matched})
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, query string, args ...interface{}) {
+	// Matches are deleted explicitly for databases that do not enforce the
+	// cascade.
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		res, err := conn.ExecContext(r.Context(), query, args...)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errNotFound
+		}
+		_, err = conn.ExecContext(r.Context(), `DELETE FROM clip_filter_matches WHERE filter_id = ?`, args[0])
+		return err
+	})
+	if errors.Is(err, errNotFound) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "filter not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete filter"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// HandleReviewQueue lists borderline matches of instance filters awaiting
+// review, oldest first; ?status= lists confirmed or dismissed ones instead.
+func (h *Handler) HandleReviewQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = StatusPending
+	}
+	if status != StatusPending && status != StatusConfirmed && status != StatusDismissed {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "status must be pending, confirmed, or dismissed"})
+		return
+	}
+	limit := defaultQueueLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= maxQueueLimit {
+		limit = n
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT m.clip_id, COALESCE(c.title, ''), m.filter_id, f.name, f.kind, f.pattern, f.threshold,
+		       m.match_count, m.excerpt, m.status, m.created_at, m.reviewed_at
+		FROM clip_filter_matches m
+		JOIN content_filters f ON f.id = m.filter_id
+		JOIN clips c ON c.id = m.clip_id
+		WHERE f.user_id IS NULL AND m.status = ?
+		ORDER BY m.created_at, m.clip_id
+		LIMIT ?
+	`, status, limit)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load review queue"})
+		return
+	}
+	defer rows.Close()
+	matches := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var clipID, title, filterID, name, kind, pattern, excerpt, matchStatus, createdAt string
+		var threshold, count int
+		var reviewedAt *string
+		if err := rows.Scan(&clipID, &title, &filterID, &name, &kind, &pattern, &threshold,
+			&count, &excerpt, &matchStatus, &createdAt, &reviewedAt); err != nil {
+			continue
+		}
+		matches = append(matches, map[string]interface{}{
+			"clip_id": clipID, "clip_title": title,
+			"filter": map[string]interface{}{
+				"id": filterID, "name": name, "kind": kind, "pattern": pattern, "threshold": threshold,
+			},
+			"match_count": count, "excerpt": excerpt, "status": matchStatus,
+			"created_at": createdAt, "reviewed_at": reviewedAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"matches": matches})
+}
+
+// HandleReviewMatch settles a match: `{"status": "confirmed"}` keeps the
+// clip hidden from restricted profiles, `"dismissed"` shows it again.
+func (h *Handler) HandleReviewMatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil ||
+		(req.Status != StatusConfirmed && req.Status != StatusDismissed) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "status must be confirmed or dismissed"})
+		return
+	}
+	res, err := h.DB.ExecContext(r.Context(), `
+		UPDATE clip_filter_matches SET status = ?, reviewed_at = ?
+		WHERE clip_id = ? AND filter_id = ?
+	`, req.Status, time.Now().UTC().Format("2006-01-02T15:04:05Z"), chi.URLParam(r, "clipId"), chi.URLParam(r, "filterId"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to review match"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "match not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": req.Status})
+}
+
+// HandleSetRestricted marks a user's profile restricted, hiding clips that
+// match instance filters from their feed, or lifts the restriction.
+func (h *Handler) HandleSetRestricted(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Restricted *bool `json:"restricted"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || req.Restricted == nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "restricted must be true or false"})
+		return
+	}
+	restricted := 0
+	if *req.Restricted {
+		restricted = 1
+	}
+	userID := chi.URLParam(r, "id")
+	res, err := h.DB.ExecContext(r.Context(), `UPDATE users SET restricted = ? WHERE id = ?`, restricted, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update user"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"user_id": userID, "restricted": *req.Restricted})
+}
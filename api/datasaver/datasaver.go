@@ -0,0 +1,82 @@
+// Package datasaver decides whether a request is served in data saver
+// mode, where stream endpoints hand out each clip's lower-bitrate rendition
+// and feeds return smaller thumbnails, for clients on metered connections.
+//
+// A request's X-Data-Saver header (on or off) overrides the signed-in
+// user's data_saver preference; browsers' Save-Data: on hint turns it on
+// too.
+package datasaver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+)
+
+// Header overrides the user's preference for one request.
+const Header = "X-Data-Saver"
+
+type ctxKey struct{}
+
+// state is one request's decision, resolved the first time it is needed.
+type state struct {
+	override *bool
+	once     sync.Once
+	on       bool
+}
+
+// Middleware records the request's override header so handlers further
+// down can call Enabled with only a context.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &state{override: override(r)}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, st)))
+	})
+}
+
+// override reads the request's explicit choice, if it made one.
+func override(r *http.Request) *bool {
+	on, off := true, false
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get(Header))) {
+	case "on", "1", "true":
+		return &on
+	case "off", "0", "false":
+		return &off
+	}
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get("Save-Data")), "on") {
+		return &on
+	}
+	return nil
+}
+
+// Enabled reports whether the request in ctx is in data saver mode: its
+// override header if it sent one, otherwise the signed-in user's
+// preference. Anonymous requests without the header are not.
+func Enabled(ctx context.Context, q *db.CompatDB) bool {
+	st, _ := ctx.Value(ctxKey{}).(*state)
+	if st == nil {
+		return preference(ctx, q)
+	}
+	st.once.Do(func() {
+		if st.override != nil {
+			st.on = *st.override
+			return
+		}
+		st.on = preference(ctx, q)
+	})
+	return st.on
+}
+
+func preference(ctx context.Context, q *db.CompatDB) bool {
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+	if userID == "" {
+		return false
+	}
+	var on int
+	q.QueryRowContext(ctx, `SELECT data_saver FROM user_preferences WHERE user_id = ?`, userID).Scan(&on)
+	return on == 1
+}
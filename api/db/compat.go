@@ -22,6 +22,9 @@ const (
 type CompatDB struct {
 	DB      *sql.DB
 	Dialect Dialect
+	// SlowQueryThreshold logs queries that take at least this long; zero
+	// disables the slow query log.
+	SlowQueryThreshold time.Duration
 }
 
 func NewCompatDB(rawDB *sql.DB, dialect Dialect) *CompatDB {
@@ -42,26 +45,32 @@ func (d *CompatDB) rewrite(query string) string {
 }
 
 func (d *CompatDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer d.observe(context.Background(), query, args, time.Now())
 	return d.DB.Exec(d.rewrite(query), args...)
 }
 
 func (d *CompatDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer d.observe(ctx, query, args, time.Now())
 	return d.DB.ExecContext(ctx, d.rewrite(query), args...)
 }
 
 func (d *CompatDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer d.observe(context.Background(), query, args, time.Now())
 	return d.DB.Query(d.rewrite(query), args...)
 }
 
 func (d *CompatDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer d.observe(ctx, query, args, time.Now())
 	return d.DB.QueryContext(ctx, d.rewrite(query), args...)
 }
 
 func (d *CompatDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer d.observe(context.Background(), query, args, time.Now())
 	return d.DB.QueryRow(d.rewrite(query), args...)
 }
 
 func (d *CompatDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer d.observe(ctx, query, args, time.Now())
 	return d.DB.QueryRowContext(ctx, d.rewrite(query), args...)
 }
 
@@ -70,13 +79,14 @@ func (d *CompatDB) Conn(ctx context.Context) (*CompatConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &CompatConn{Conn: conn, dialect: d.Dialect}, nil
+	return &CompatConn{Conn: conn, dialect: d.Dialect, db: d}, nil
 }
 
 // CompatConn wraps *sql.Conn with automatic placeholder conversion.
 type CompatConn struct {
 	Conn    *sql.Conn
 	dialect Dialect
+	db      *CompatDB
 }
 
 func (c *CompatConn) Close() error { return c.Conn.Close() }
@@ -89,14 +99,23 @@ func (c *CompatConn) rewrite(query string) string {
 }
 
 func (c *CompatConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if c.db != nil {
+		defer c.db.observe(ctx, query, args, time.Now())
+	}
 	return c.Conn.ExecContext(ctx, c.rewrite(query), args...)
 }
 
 func (c *CompatConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if c.db != nil {
+		defer c.db.observe(ctx, query, args, time.Now())
+	}
 	return c.Conn.QueryContext(ctx, c.rewrite(query), args...)
 }
 
 func (c *CompatConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if c.db != nil {
+		defer c.db.observe(ctx, query, args, time.Now())
+	}
 	return c.Conn.QueryRowContext(ctx, c.rewrite(query), args...)
 }
 
@@ -214,6 +233,14 @@ func (d *CompatDB) PurgeDatetimeComparison(coalesced string, modifier string) st
 	return fmt.Sprintf("datetime(%s) <= datetime('now', '%s')", coalesced, modifier)
 }
 
+// ClampExpr returns a SQL expression clamping expr to [lo, hi].
+func (d *CompatDB) ClampExpr(expr string, lo, hi float64) string {
+	if d.IsPostgres() {
+		return fmt.Sprintf("GREATEST(%g, LEAST(%g, %s))", lo, hi, expr)
+	}
+	return fmt.Sprintf("MAX(%g, MIN(%g, %s))", lo, hi, expr)
+}
+
 // BeginTxSQL returns the SQL statement to begin a write transaction.
 func (d *CompatDB) BeginTxSQL() string {
 	if d.IsPostgres() {
@@ -99,6 +99,15 @@ func TestNowUTC(t *testing.T) {
 	}
 }
 
+func TestClampExpr(t *testing.T) {
+	if got := sqliteDB().ClampExpr("x", 0, 1); got != "MAX(0, MIN(1, x))" {
+		t.Errorf("SQLite ClampExpr = %q", got)
+	}
+	if got := pgDB().ClampExpr("x", 0, 1); got != "GREATEST(0, LEAST(1, x))" {
+		t.Errorf("Postgres ClampExpr = %q", got)
+	}
+}
+
 func TestRandomFloat(t *testing.T) {
 	if got := sqliteDB().RandomFloat(); !strings.Contains(got, "RANDOM") {
 		t.Errorf("SQLite RandomFloat = %q", got)
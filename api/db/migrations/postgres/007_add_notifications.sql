@@ -0,0 +1,35 @@
+-- Per-user notification preferences, web push subscriptions, and a log of
+-- notifications raised for finished ingests.
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id         TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email_enabled   INTEGER DEFAULT 0,
+    push_enabled    INTEGER DEFAULT 0,
+    notify_on       TEXT DEFAULT 'all',
+    updated_at      TEXT DEFAULT (iso_now())
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    endpoint    TEXT NOT NULL UNIQUE,
+    p256dh      TEXT NOT NULL,
+    auth        TEXT NOT NULL,
+    created_at  TEXT DEFAULT (iso_now())
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user ON push_subscriptions(user_id);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id            TEXT PRIMARY KEY,
+    user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind          TEXT NOT NULL,
+    title         TEXT NOT NULL,
+    body          TEXT NOT NULL,
+    payload       TEXT DEFAULT '{}',
+    email_status  TEXT,
+    push_status   TEXT,
+    created_at    TEXT DEFAULT (iso_now())
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, created_at DESC);
@@ -0,0 +1,15 @@
+-- Time-boxed focus sessions: a finite clip selection fitted to a watch budget.
+
+CREATE TABLE IF NOT EXISTS focus_sessions (
+    id               TEXT PRIMARY KEY,
+    user_id          TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    budget_seconds   INTEGER NOT NULL,
+    planned_seconds  REAL NOT NULL,
+    clip_ids         TEXT NOT NULL DEFAULT '[]',
+    status           TEXT NOT NULL DEFAULT 'active',
+    watched_seconds  REAL,
+    created_at       TEXT DEFAULT (iso_now()),
+    ended_at         TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_focus_sessions_user ON focus_sessions(user_id, created_at DESC);
@@ -0,0 +1,23 @@
+-- Per-user Thompson-sampling arms over topics, replacing random exploration.
+-- alpha/beta are Beta posterior parameters updated from interactions;
+-- bandit_impressions tracks clips served as exploration and their outcome.
+
+CREATE TABLE IF NOT EXISTS user_topic_arms (
+    user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    topic_id    TEXT NOT NULL REFERENCES topics(id) ON DELETE CASCADE,
+    alpha       REAL NOT NULL DEFAULT 1.0,
+    beta        REAL NOT NULL DEFAULT 1.0,
+    explored    INTEGER NOT NULL DEFAULT 0,
+    converted   INTEGER NOT NULL DEFAULT 0,
+    updated_at  TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (user_id, topic_id)
+);
+
+CREATE TABLE IF NOT EXISTS bandit_impressions (
+    user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    clip_id     TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    topic_id    TEXT NOT NULL REFERENCES topics(id) ON DELETE CASCADE,
+    outcome     TEXT,
+    created_at  TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (user_id, clip_id)
+);
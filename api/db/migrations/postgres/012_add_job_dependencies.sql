@@ -0,0 +1,11 @@
+-- Job dependency graph: a job is only claimable once every job it depends on
+-- has completed. Failure or cancellation cascades to queued dependents.
+
+CREATE TABLE IF NOT EXISTS job_dependencies (
+    job_id             TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    depends_on_job_id  TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    created_at         TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (job_id, depends_on_job_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_dependencies_parent ON job_dependencies(depends_on_job_id);
@@ -0,0 +1,12 @@
+-- Per-user feed candidate lists precomputed in the background for recently
+-- active users. The feed serves from an unexpired list and only re-ranks
+-- at request time, falling back to live candidate generation otherwise.
+
+CREATE TABLE IF NOT EXISTS feed_candidates (
+    user_id      TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    clip_ids     TEXT NOT NULL DEFAULT '[]',
+    computed_at  TEXT DEFAULT (iso_now()),
+    expires_at   TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_feed_candidates_expires ON feed_candidates(expires_at);
@@ -0,0 +1,11 @@
+-- Editorially curated clips surfaced in the discovery page's staff picks
+-- section, managed from the admin API.
+
+CREATE TABLE IF NOT EXISTS staff_picks (
+    clip_id    TEXT PRIMARY KEY REFERENCES clips(id) ON DELETE CASCADE,
+    note       TEXT,
+    position   INTEGER NOT NULL DEFAULT 0,
+    picked_at  TEXT DEFAULT (iso_now())
+);
+
+CREATE INDEX IF NOT EXISTS idx_staff_picks_order ON staff_picks(position, picked_at DESC);
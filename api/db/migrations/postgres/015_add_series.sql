@@ -0,0 +1,21 @@
+-- Multi-part uploads ("Part 1", "Pt. 2", "3/5") grouped into series by a
+-- background clustering pass over channel, title pattern, and embedding
+-- similarity. A clip belongs to at most one series.
+
+CREATE TABLE IF NOT EXISTS series (
+    id            TEXT PRIMARY KEY,
+    title         TEXT NOT NULL,
+    channel_name  TEXT,
+    platform      TEXT,
+    clip_count    INTEGER NOT NULL DEFAULT 0,
+    created_at    TEXT DEFAULT (iso_now()),
+    updated_at    TEXT DEFAULT (iso_now())
+);
+
+CREATE TABLE IF NOT EXISTS series_clips (
+    clip_id      TEXT PRIMARY KEY REFERENCES clips(id) ON DELETE CASCADE,
+    series_id    TEXT NOT NULL REFERENCES series(id) ON DELETE CASCADE,
+    part_number  INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_series_clips_series ON series_clips(series_id, part_number);
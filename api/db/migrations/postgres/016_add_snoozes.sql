@@ -0,0 +1,16 @@
+-- Temporary per-user mutes of a topic or channel. Active snoozes exclude
+-- matching clips from the feed until expires_at; re-snoozing the same
+-- target replaces its expiry.
+
+CREATE TABLE IF NOT EXISTS user_snoozes (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_type  TEXT NOT NULL CHECK (target_type IN ('topic', 'channel')),
+    target_id    TEXT NOT NULL,
+    label        TEXT NOT NULL DEFAULT '',
+    expires_at   TEXT NOT NULL,
+    created_at   TEXT DEFAULT (iso_now()),
+    UNIQUE (user_id, target_type, target_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_snoozes_user_expires ON user_snoozes(user_id, expires_at);
@@ -0,0 +1,19 @@
+-- Anomaly flags raised when a user's interaction pattern looks automated.
+-- One open flag per (user, reason) accumulates hits until an admin
+-- dismisses or confirms it; confirmed users no longer count toward
+-- content scores.
+
+CREATE TABLE IF NOT EXISTS interaction_flags (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason       TEXT NOT NULL,
+    details      TEXT NOT NULL DEFAULT '',
+    hits         INTEGER NOT NULL DEFAULT 1,
+    status       TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'confirmed')),
+    created_at   TEXT DEFAULT (iso_now()),
+    last_seen_at TEXT DEFAULT (iso_now()),
+    resolved_at  TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_interaction_flags_status ON interaction_flags(status, last_seen_at DESC);
+CREATE INDEX IF NOT EXISTS idx_interaction_flags_user ON interaction_flags(user_id, status);
@@ -0,0 +1,19 @@
+-- Versioned weights for the batch content score formula. Every change adds
+-- a row; the highest version is in effect. Version 1 holds the weights that
+-- were previously hardcoded in the score update.
+
+CREATE TABLE IF NOT EXISTS scoring_weights (
+    version           INTEGER PRIMARY KEY,
+    watch_weight      REAL NOT NULL,
+    like_weight       REAL NOT NULL,
+    save_weight       REAL NOT NULL,
+    watch_full_weight REAL NOT NULL,
+    skip_penalty      REAL NOT NULL,
+    dislike_penalty   REAL NOT NULL,
+    note              TEXT NOT NULL DEFAULT '',
+    created_at        TEXT DEFAULT (iso_now())
+);
+
+INSERT INTO scoring_weights (version, watch_weight, like_weight, save_weight, watch_full_weight, skip_penalty, dislike_penalty, note)
+VALUES (1, 0.35, 0.25, 0.20, 0.15, 0.30, 0.15, 'defaults')
+ON CONFLICT (version) DO NOTHING;
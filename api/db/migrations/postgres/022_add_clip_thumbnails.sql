@@ -0,0 +1,13 @@
+-- Candidate thumbnails supplied by the worker. The feed picks one per
+-- impression by Thompson sampling over click-through, and once a clip has
+-- enough impressions its best variant becomes clips.thumbnail_key.
+
+CREATE TABLE IF NOT EXISTS clip_thumbnails (
+    clip_id       TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    variant       INTEGER NOT NULL,
+    thumbnail_key TEXT NOT NULL,
+    impressions   INTEGER NOT NULL DEFAULT 0,
+    clicks        INTEGER NOT NULL DEFAULT 0,
+    created_at    TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (clip_id, variant)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE scout_candidates ADD COLUMN IF NOT EXISTS duplicate_clip_id TEXT REFERENCES clips(id) ON DELETE SET NULL;
+ALTER TABLE scout_candidates ADD COLUMN IF NOT EXISTS duplicate_similarity REAL;
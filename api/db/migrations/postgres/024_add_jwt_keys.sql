@@ -0,0 +1,10 @@
+-- User-token signing keys. Secrets are encrypted with COOKIE_SECRET; the
+-- 'legacy' row stands for JWT_SECRET once the first rotation supersedes it.
+-- A key with rotated_at set only verifies tokens until it retires.
+
+CREATE TABLE IF NOT EXISTS jwt_keys (
+    kid         TEXT PRIMARY KEY,
+    secret      TEXT NOT NULL,
+    created_at  TEXT NOT NULL,
+    rotated_at  TEXT
+);
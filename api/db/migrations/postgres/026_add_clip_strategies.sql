@@ -0,0 +1,11 @@
+-- How the worker cuts a source into clips. sources.clip_strategy records the
+-- strategy a source was ingested with; platform_clip_strategies holds the
+-- instance default per platform for submissions that do not pick one.
+
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS clip_strategy TEXT;
+
+CREATE TABLE IF NOT EXISTS platform_clip_strategies (
+    platform    TEXT PRIMARY KEY,
+    strategy    TEXT NOT NULL,
+    updated_at  TEXT DEFAULT (iso_now())
+);
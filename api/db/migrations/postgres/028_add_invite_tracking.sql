@@ -0,0 +1,13 @@
+-- Who issued each invite (NULL for admins) and the quotas it presets for
+-- the account it admits: how many invites that user may hand out in turn,
+-- and how many sources they may ingest per day (NULL for no limit).
+
+ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS created_by TEXT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS invite_quota INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS daily_ingest_quota INTEGER;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invited_by TEXT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_quota INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS daily_ingest_quota INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_invite_codes_created_by ON invite_codes(created_by);
@@ -0,0 +1,19 @@
+-- Transcripts move out of the clips row into ordered chunks so feed queries
+-- no longer scan them; clips keeps only the length the rankers use. Existing
+-- transcripts are moved as a single chunk. clips.transcript stays, empty.
+
+CREATE TABLE IF NOT EXISTS clip_transcripts (
+    clip_id  TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    chunk    INTEGER NOT NULL,
+    text     TEXT NOT NULL,
+    PRIMARY KEY (clip_id, chunk)
+);
+
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS transcript_length INTEGER NOT NULL DEFAULT 0;
+
+INSERT INTO clip_transcripts (clip_id, chunk, text)
+SELECT id, 0, transcript FROM clips WHERE transcript IS NOT NULL AND transcript <> ''
+ON CONFLICT DO NOTHING;
+
+UPDATE clips SET transcript_length = LENGTH(transcript), transcript = NULL
+WHERE transcript IS NOT NULL;
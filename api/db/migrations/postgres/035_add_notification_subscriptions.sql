@@ -0,0 +1,22 @@
+-- Subscriptions to new clips in a topic or from a channel. Clips matching a
+-- subscription are queued as they are created and sent in periodic digests,
+-- which clear the queue.
+
+CREATE TABLE IF NOT EXISTS notification_subscriptions (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_type  TEXT NOT NULL CHECK (target_type IN ('topic', 'channel')),
+    target_id    TEXT NOT NULL,
+    label        TEXT NOT NULL,
+    created_at   TEXT DEFAULT (iso_now()),
+    UNIQUE(user_id, target_type, target_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_subscriptions_target ON notification_subscriptions(target_type, target_id);
+
+CREATE TABLE IF NOT EXISTS notification_subscription_matches (
+    subscription_id  TEXT NOT NULL REFERENCES notification_subscriptions(id) ON DELETE CASCADE,
+    clip_id          TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    created_at       TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (subscription_id, clip_id)
+);
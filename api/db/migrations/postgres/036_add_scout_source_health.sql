@@ -0,0 +1,9 @@
+-- Scout source check results, reported by the scout worker after every
+-- check. Failing sources are retried with backoff and paused, with the
+-- reason recorded, after repeated consecutive failures.
+
+ALTER TABLE scout_sources ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE scout_sources ADD COLUMN IF NOT EXISTS consecutive_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scout_sources ADD COLUMN IF NOT EXISTS last_success_at TEXT;
+ALTER TABLE scout_sources ADD COLUMN IF NOT EXISTS next_check_at TEXT;
+ALTER TABLE scout_sources ADD COLUMN IF NOT EXISTS paused_reason TEXT;
@@ -0,0 +1,19 @@
+-- Tombstones outlive the clips they stand for, so requests for a clip that
+-- was purged, expired, evicted, or merged away get 410 Gone with the reason
+-- rather than a bare 404. title and topics (JSON names) are copied from the
+-- clip to suggest similar clips; replaced_by names the clip a merge kept.
+
+CREATE TABLE IF NOT EXISTS clip_tombstones (
+    clip_id      TEXT PRIMARY KEY,
+    title        TEXT NOT NULL DEFAULT '',
+    topics       TEXT NOT NULL DEFAULT '[]',
+    reason       TEXT NOT NULL CHECK (reason IN ('expired', 'evicted', 'deleted', 'merged')),
+    replaced_by  TEXT,
+    deleted_at   TEXT DEFAULT (iso_now())
+);
+
+INSERT INTO clip_tombstones (clip_id, title, topics, reason)
+SELECT id, COALESCE(title, ''), COALESCE(topics, '[]'), status
+FROM clips
+WHERE status IN ('expired', 'evicted')
+ON CONFLICT (clip_id) DO NOTHING;
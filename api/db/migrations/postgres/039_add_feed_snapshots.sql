@@ -0,0 +1,11 @@
+-- Golden feed rankings captured for a set of users, compared against fresh
+-- rankings to catch regressions from ranking code or parameter changes.
+-- feeds maps each user ID to the top k clip IDs in rank order.
+
+CREATE TABLE IF NOT EXISTS feed_snapshots (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    k           INTEGER NOT NULL,
+    feeds       TEXT NOT NULL DEFAULT '{}',
+    created_at  TEXT DEFAULT (iso_now())
+);
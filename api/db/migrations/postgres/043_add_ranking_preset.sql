@@ -0,0 +1,4 @@
+-- The ranking preset a user saved as their feed default. When set it
+-- overrides diversity_mix, trending_boost, freshness_bias and
+-- exploration_rate; changing any of those clears it.
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS ranking_preset TEXT;
@@ -0,0 +1,29 @@
+-- Licensing and attribution of ingested content. The worker records who
+-- uploaded a source and the license its platform reports; the submitter's
+-- consent flags say whether they are the uploader or have the rights
+-- holder's permission.
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS uploader TEXT;
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS uploader_url TEXT;
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS license TEXT;
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS consent_is_uploader INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sources ADD COLUMN IF NOT EXISTS consent_has_permission INTEGER NOT NULL DEFAULT 0;
+
+-- DMCA-style takedown requests against sources. Actioning one removes the
+-- source's clips and blocks its URL from being ingested again.
+CREATE TABLE IF NOT EXISTS takedown_requests (
+    id              TEXT PRIMARY KEY,
+    source_id       TEXT REFERENCES sources(id) ON DELETE SET NULL,
+    source_url      TEXT NOT NULL,
+    claimant_name   TEXT NOT NULL,
+    claimant_email  TEXT NOT NULL,
+    work            TEXT NOT NULL,
+    notes           TEXT NOT NULL DEFAULT '',
+    status          TEXT NOT NULL DEFAULT 'received' CHECK (status IN ('received', 'actioned', 'rejected')),
+    clips_removed   INTEGER NOT NULL DEFAULT 0,
+    resolution_note TEXT,
+    received_at     TEXT DEFAULT (iso_now()),
+    resolved_at     TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_takedown_requests_status ON takedown_requests(status, received_at);
+CREATE INDEX IF NOT EXISTS idx_takedown_requests_url ON takedown_requests(source_url);
@@ -0,0 +1,3 @@
+-- Object storage key of the avatar a user uploaded; avatar_url points at
+-- the API endpoint that serves it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key TEXT;
@@ -0,0 +1,32 @@
+-- Star ratings (1-5), one per user and clip; rating again replaces it.
+-- clips.rating_count and rating_avg aggregate them for display, filters,
+-- and ranking, and are refreshed whenever a rating changes. Each rating
+-- is also logged as a 'rate' interaction carrying its value.
+
+CREATE TABLE IF NOT EXISTS clip_ratings (
+    user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    clip_id     TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    rating      INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    created_at  TEXT DEFAULT (iso_now()),
+    updated_at  TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (user_id, clip_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_clip_ratings_clip ON clip_ratings(clip_id);
+
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS rating_avg REAL;
+ALTER TABLE interactions ADD COLUMN IF NOT EXISTS rating INTEGER;
+
+-- The batch score gains a rating term. Instances still on the default
+-- weights move to the new defaults; customised weights keep rating at 0
+-- until an admin sets it.
+ALTER TABLE scoring_weights ADD COLUMN IF NOT EXISTS rating_weight REAL NOT NULL DEFAULT 0;
+
+INSERT INTO scoring_weights (version, watch_weight, like_weight, save_weight, watch_full_weight, skip_penalty, dislike_penalty, rating_weight, note)
+SELECT version + 1, 0.30, 0.25, 0.20, 0.15, 0.30, 0.15, 0.10, 'defaults with rating'
+FROM scoring_weights
+WHERE version = (SELECT MAX(version) FROM scoring_weights)
+  AND ABS(watch_weight - 0.35) < 1e-6 AND ABS(like_weight - 0.25) < 1e-6
+  AND ABS(save_weight - 0.20) < 1e-6 AND ABS(watch_full_weight - 0.15) < 1e-6
+  AND ABS(skip_penalty - 0.30) < 1e-6 AND ABS(dislike_penalty - 0.15) < 1e-6;
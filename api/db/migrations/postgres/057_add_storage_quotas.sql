@@ -0,0 +1,4 @@
+-- Soft storage quota per user, on the bytes of ready clips from the sources
+-- they submitted. Nearing it warns them and suggests clips to delete; ingest
+-- is not refused over it. NULL means no quota.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_quota_bytes BIGINT;
@@ -0,0 +1,22 @@
+-- Short GIF or WebM previews of a range of a clip, rendered on request by
+-- a preview job. The range is in milliseconds so equal requests match
+-- exactly. storage_key is set once the worker has uploaded the preview;
+-- until then its state is its job's. One preview per clip, range, and
+-- format is kept and shared by everyone who asks for it.
+
+CREATE TABLE IF NOT EXISTS clip_previews (
+    id              TEXT PRIMARY KEY,
+    clip_id         TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    start_ms        INTEGER NOT NULL,
+    end_ms          INTEGER NOT NULL,
+    format          TEXT NOT NULL CHECK (format IN ('gif', 'webm')),
+    job_id          TEXT,
+    storage_key     TEXT,
+    file_size_bytes INTEGER,
+    created_by      TEXT REFERENCES users(id) ON DELETE SET NULL,
+    created_at      TEXT DEFAULT (iso_now()),
+    completed_at    TEXT,
+    UNIQUE (clip_id, start_ms, end_ms, format)
+);
+
+CREATE INDEX IF NOT EXISTS idx_clip_previews_created_by ON clip_previews(created_by);
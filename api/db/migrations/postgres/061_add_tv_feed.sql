@@ -0,0 +1,15 @@
+-- The TV feed shows a larger thumbnail, where the worker made one, and
+-- pages through a session so going back shows the same clips and going
+-- forward never repeats them. pages is a JSON array holding the clip IDs
+-- of each page served so far. Anonymous sessions have an empty user_id.
+ALTER TABLE clips ADD COLUMN IF NOT EXISTS large_thumbnail_key TEXT;
+
+CREATE TABLE IF NOT EXISTS tv_feed_sessions (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL DEFAULT '',
+    pages       TEXT NOT NULL DEFAULT '[]',
+    created_at  TEXT DEFAULT (iso_now()),
+    expires_at  TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tv_feed_sessions_expires ON tv_feed_sessions(expires_at);
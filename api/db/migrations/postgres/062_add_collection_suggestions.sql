@@ -0,0 +1,20 @@
+-- Collection suggestions group a user's saved clips whose text embeddings
+-- are alike. clip_ids is a JSON array of the group's clips, closest to its
+-- centre first. Dismissed suggestions are kept so the same group is not
+-- suggested again; accepted ones are deleted once their collection exists.
+CREATE TABLE IF NOT EXISTS collection_suggestions (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title       TEXT NOT NULL,
+    clip_ids    TEXT NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed')),
+    created_at  TEXT DEFAULT (iso_now())
+);
+
+CREATE INDEX IF NOT EXISTS idx_collection_suggestions_user ON collection_suggestions(user_id, status);
+
+-- When each user's saved clips were last grouped.
+CREATE TABLE IF NOT EXISTS collection_suggestion_runs (
+    user_id     TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    ran_at      TEXT NOT NULL
+);
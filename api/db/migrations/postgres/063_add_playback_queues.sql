@@ -0,0 +1,16 @@
+-- A playback queue holds the clips a client plays through, ranked ahead of
+-- its position so every swipe does not rank a fresh feed page. clip_ids is
+-- a JSON array of every clip queued so far; position is the index of the
+-- next clip to play. Anonymous queues have an empty user_id.
+CREATE TABLE IF NOT EXISTS playback_queues (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL DEFAULT '',
+    clip_ids    TEXT NOT NULL DEFAULT '[]',
+    position    INTEGER NOT NULL DEFAULT 0,
+    created_at  TEXT DEFAULT (iso_now()),
+    updated_at  TEXT DEFAULT (iso_now()),
+    expires_at  TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_playback_queues_user ON playback_queues(user_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_playback_queues_expires ON playback_queues(expires_at);
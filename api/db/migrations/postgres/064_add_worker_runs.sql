@@ -0,0 +1,17 @@
+-- The worker process run that claimed each job, as named by its
+-- X-Worker-Run-ID header. A new run ID means the worker restarted.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS worker_run_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_worker_run ON jobs(worker_run_id) WHERE status = 'running';
+
+-- Worker process runs the API has heard from, so claims held by runs that
+-- are gone can be handed back to the queue.
+CREATE TABLE IF NOT EXISTS worker_runs (
+    run_id TEXT PRIMARY KEY,
+    worker_id TEXT NOT NULL DEFAULT '',
+    started_at TEXT NOT NULL DEFAULT iso_now(),
+    last_seen_at TEXT NOT NULL DEFAULT iso_now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_worker_runs_worker ON worker_runs(worker_id);
+CREATE INDEX IF NOT EXISTS idx_worker_runs_last_seen ON worker_runs(last_seen_at);
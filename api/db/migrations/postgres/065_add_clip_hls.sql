@@ -0,0 +1,28 @@
+-- HLS renditions made by transcode_hls jobs. Each variant is one bitrate
+-- rung: a media playlist whose segment URIs name objects listed in
+-- clip_hls_segments. The API builds the master playlist from the variants
+-- and rewrites segment names to signed URLs when serving a playlist.
+-- clips.hls_key holds the master playlist the worker uploaded with them.
+
+CREATE TABLE IF NOT EXISTS clip_hls_variants (
+    clip_id      TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    bandwidth    INTEGER NOT NULL,
+    width        INTEGER NOT NULL DEFAULT 0,
+    height       INTEGER NOT NULL DEFAULT 0,
+    codecs       TEXT NOT NULL DEFAULT '',
+    playlist_key TEXT NOT NULL,
+    playlist     TEXT NOT NULL,
+    created_at   TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (clip_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS clip_hls_segments (
+    clip_id       TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    variant       TEXT NOT NULL,
+    segment_index INTEGER NOT NULL,
+    segment_key   TEXT NOT NULL,
+    PRIMARY KEY (clip_id, variant, segment_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_clip_hls_segments_key ON clip_hls_segments(segment_key);
@@ -0,0 +1,4 @@
+-- The admin who started an impersonation session, recorded as the actor of
+-- writes made through it. Sessions started before this was kept fall back
+-- to "admin", as their writes were audited.
+ALTER TABLE impersonation_sessions ADD COLUMN IF NOT EXISTS actor TEXT NOT NULL DEFAULT 'admin';
@@ -0,0 +1,14 @@
+-- The thumbnail variants each signed-in user was shown. A variant's
+-- impressions and clicks count each user once, and a click is only
+-- credited to a variant the user was shown.
+
+CREATE TABLE IF NOT EXISTS thumbnail_views (
+    user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    clip_id    TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    variant    INTEGER NOT NULL,
+    clicked_at TEXT,
+    created_at TEXT DEFAULT (iso_now()),
+    PRIMARY KEY (user_id, clip_id, variant)
+);
+
+CREATE INDEX IF NOT EXISTS idx_thumbnail_views_clip ON thumbnail_views(clip_id);
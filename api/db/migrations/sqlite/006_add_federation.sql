@@ -0,0 +1,53 @@
+-- Federation: inbound peers allowed to read this instance, outbound remotes
+-- this instance subscribes to, and clip metadata synced from those remotes.
+
+CREATE TABLE IF NOT EXISTS federation_peers (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    secret      TEXT NOT NULL,
+    is_active   INTEGER DEFAULT 1,
+    last_seen   TEXT,
+    created_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS federation_remotes (
+    id             TEXT PRIMARY KEY,
+    name           TEXT NOT NULL,
+    base_url       TEXT NOT NULL UNIQUE,
+    peer_id        TEXT NOT NULL,
+    secret         TEXT NOT NULL,
+    pull_media     INTEGER DEFAULT 0,
+    is_active      INTEGER DEFAULT 1,
+    last_synced_at TEXT,
+    last_error     TEXT,
+    created_at     TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS federation_subscriptions (
+    id          TEXT PRIMARY KEY,
+    remote_id   TEXT NOT NULL REFERENCES federation_remotes(id) ON DELETE CASCADE,
+    kind        TEXT NOT NULL,
+    ref         TEXT NOT NULL,
+    sync_cursor TEXT,
+    created_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    UNIQUE(remote_id, kind, ref)
+);
+
+CREATE TABLE IF NOT EXISTS federated_clips (
+    remote_id        TEXT NOT NULL REFERENCES federation_remotes(id) ON DELETE CASCADE,
+    remote_clip_id   TEXT NOT NULL,
+    title            TEXT,
+    description      TEXT,
+    duration_seconds REAL,
+    thumbnail_url    TEXT,
+    topics           TEXT DEFAULT '[]',
+    channel_name     TEXT,
+    platform         TEXT,
+    source_url       TEXT,
+    storage_key      TEXT,
+    remote_created_at TEXT,
+    synced_at        TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    PRIMARY KEY (remote_id, remote_clip_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federated_clips_synced ON federated_clips(synced_at DESC);
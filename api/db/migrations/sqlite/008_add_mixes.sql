@@ -0,0 +1,22 @@
+-- Daily "mix for you" playlists, persisted so a day's mix is stable across
+-- refreshes and past mixes can be revisited.
+
+CREATE TABLE IF NOT EXISTS mixes (
+    id                TEXT PRIMARY KEY,
+    user_id           TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mix_date          TEXT NOT NULL,
+    title             TEXT NOT NULL,
+    serendipity_topic TEXT,
+    created_at        TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    UNIQUE(user_id, mix_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mixes_user_date ON mixes(user_id, mix_date DESC);
+
+CREATE TABLE IF NOT EXISTS mix_clips (
+    mix_id      TEXT NOT NULL REFERENCES mixes(id) ON DELETE CASCADE,
+    clip_id     TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    position    INTEGER NOT NULL,
+    reason      TEXT NOT NULL,
+    PRIMARY KEY (mix_id, clip_id)
+);
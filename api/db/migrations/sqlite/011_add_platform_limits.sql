@@ -0,0 +1,11 @@
+-- Per-platform ingest concurrency caps enforced when workers claim jobs.
+-- platform_limits holds instance-wide caps; platform_cookies.max_concurrent
+-- optionally caps concurrent jobs that run under a single user's cookie.
+
+CREATE TABLE IF NOT EXISTS platform_limits (
+    platform        TEXT PRIMARY KEY,
+    max_concurrent  INTEGER NOT NULL,
+    updated_at      TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+ALTER TABLE platform_cookies ADD COLUMN max_concurrent INTEGER;
@@ -0,0 +1,16 @@
+-- Private annotations on saved clips: a free-text note on the saved row and
+-- any number of user-chosen tags. Tags are lower-cased on write so filtering
+-- is case-insensitive.
+
+ALTER TABLE saved_clips ADD COLUMN note TEXT NOT NULL DEFAULT '';
+ALTER TABLE saved_clips ADD COLUMN updated_at TEXT;
+
+CREATE TABLE IF NOT EXISTS saved_clip_tags (
+    user_id  TEXT NOT NULL,
+    clip_id  TEXT NOT NULL,
+    tag      TEXT NOT NULL,
+    PRIMARY KEY (user_id, clip_id, tag),
+    FOREIGN KEY (user_id, clip_id) REFERENCES saved_clips(user_id, clip_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_clip_tags_user_tag ON saved_clip_tags(user_id, tag);
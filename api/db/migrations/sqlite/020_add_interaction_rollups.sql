@@ -0,0 +1,18 @@
+-- Daily per-clip aggregates of interactions that have aged out of the raw
+-- interactions table. The retention job folds expired events in here before
+-- deleting them, so lifetime counts survive pruning.
+
+CREATE TABLE IF NOT EXISTS interaction_rollups (
+    day                  TEXT NOT NULL,
+    clip_id              TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    action               TEXT NOT NULL,
+    events               INTEGER NOT NULL DEFAULT 0,
+    users                INTEGER NOT NULL DEFAULT 0,
+    watch_percentage_sum REAL NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, clip_id, action)
+);
+
+CREATE INDEX IF NOT EXISTS idx_interaction_rollups_clip ON interaction_rollups(clip_id, action);
+
+-- The retention job and the time-windowed ranking queries scan by age.
+CREATE INDEX IF NOT EXISTS idx_interactions_created ON interactions(created_at);
@@ -0,0 +1,10 @@
+-- Per-user tokens that let non-browser players (VLC, Kodi) fetch M3U
+-- playlists without a session. Only a SHA-256 hash of the token is stored;
+-- issuing a new token replaces the old one.
+
+CREATE TABLE IF NOT EXISTS playlist_tokens (
+    user_id      TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash   TEXT NOT NULL UNIQUE,
+    created_at   TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    last_used_at TEXT
+);
@@ -0,0 +1,2 @@
+ALTER TABLE clips ADD COLUMN stream_generation INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_clips_storage_key ON clips(storage_key);
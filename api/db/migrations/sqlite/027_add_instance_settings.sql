@@ -0,0 +1,18 @@
+-- Instance-wide settings edited by admins (branding, registration mode,
+-- defaults for new users), one JSON-encoded value per key, and the invite
+-- codes that admit users while registration is invite-only.
+
+CREATE TABLE IF NOT EXISTS instance_settings (
+    key         TEXT PRIMARY KEY,
+    value       TEXT NOT NULL,
+    updated_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS invite_codes (
+    code        TEXT PRIMARY KEY,
+    note        TEXT,
+    created_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    expires_at  TEXT,
+    used_by     TEXT REFERENCES users(id) ON DELETE SET NULL,
+    used_at     TEXT
+);
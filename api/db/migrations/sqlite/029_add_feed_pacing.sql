@@ -0,0 +1,27 @@
+-- Feed pacing: optional limits a user sets against doomscrolling. NULL
+-- disables each limit. Quiet hours are "HH:MM" in the user's timezone and
+-- may wrap past midnight.
+
+ALTER TABLE user_preferences ADD COLUMN max_clips_per_day INTEGER;
+ALTER TABLE user_preferences ADD COLUMN quiet_hours_start TEXT;
+ALTER TABLE user_preferences ADD COLUMN quiet_hours_end TEXT;
+ALTER TABLE user_preferences ADD COLUMN break_after_clips INTEGER;
+ALTER TABLE user_preferences ADD COLUMN break_minutes INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE user_preferences ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+
+-- Clips viewed per user per local day.
+CREATE TABLE IF NOT EXISTS user_daily_usage (
+    user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day           TEXT NOT NULL,
+    clips_viewed  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
+
+-- The current run of consecutive views and any break it has triggered.
+CREATE TABLE IF NOT EXISTS user_pacing_state (
+    user_id       TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    streak        INTEGER NOT NULL DEFAULT 0,
+    last_view_at  TEXT,
+    break_until   TEXT,
+    updated_at    TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
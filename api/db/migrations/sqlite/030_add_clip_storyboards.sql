@@ -0,0 +1,18 @@
+-- Seek-preview storyboards supplied by the worker: a WebVTT index whose
+-- cues point at tiles of one or more sprite sheets in object storage. The
+-- API rewrites sprite names to signed URLs when serving the index.
+
+CREATE TABLE IF NOT EXISTS clip_storyboards (
+    clip_id     TEXT PRIMARY KEY REFERENCES clips(id) ON DELETE CASCADE,
+    vtt         TEXT NOT NULL,
+    created_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS clip_storyboard_sprites (
+    clip_id      TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    sprite_index INTEGER NOT NULL,
+    sprite_key   TEXT NOT NULL,
+    PRIMARY KEY (clip_id, sprite_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_clip_storyboard_sprites_key ON clip_storyboard_sprites(sprite_key);
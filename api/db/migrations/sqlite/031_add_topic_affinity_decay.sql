@@ -0,0 +1,5 @@
+-- Learned topic affinities decay over time. decayed_at is when a row's
+-- weight was last decayed; a user can lock their affinities to opt out.
+
+ALTER TABLE user_topic_affinities ADD COLUMN decayed_at TEXT;
+ALTER TABLE user_preferences ADD COLUMN lock_topic_affinities INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- "More/less like this" feedback on a clip's channel or format. Weights
+-- multiply ranking scores; topic feedback lives in user_topic_affinities.
+
+CREATE TABLE IF NOT EXISTS user_feedback_weights (
+    user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    dimension   TEXT NOT NULL CHECK (dimension IN ('channel', 'format')),
+    target      TEXT NOT NULL,
+    weight      REAL NOT NULL DEFAULT 1.0,
+    updated_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    PRIMARY KEY (user_id, dimension, target)
+);
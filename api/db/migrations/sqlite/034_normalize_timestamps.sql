@@ -0,0 +1,76 @@
+-- Rewrites timestamps stored in SQLite's datetime() format
+-- ("2006-01-02 15:04:05") by older releases into the ISO 8601 form
+-- ("2006-01-02T15:04:05Z") every writer now uses, so the API returns one
+-- format everywhere and text comparisons between timestamps stay correct.
+
+UPDATE users SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE users SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE user_preferences SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE sources SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE clips SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', expires_at) WHERE expires_at LIKE '____-__-__ __:__:__%';
+UPDATE clips SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE interactions SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE saved_clips SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE saved_clips SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE collections SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE collection_clips SET added_at = strftime('%Y-%m-%dT%H:%M:%SZ', added_at) WHERE added_at LIKE '____-__-__ __:__:__%';
+UPDATE jobs SET locked_at = strftime('%Y-%m-%dT%H:%M:%SZ', locked_at) WHERE locked_at LIKE '____-__-__ __:__:__%';
+UPDATE jobs SET started_at = strftime('%Y-%m-%dT%H:%M:%SZ', started_at) WHERE started_at LIKE '____-__-__ __:__:__%';
+UPDATE jobs SET completed_at = strftime('%Y-%m-%dT%H:%M:%SZ', completed_at) WHERE completed_at LIKE '____-__-__ __:__:__%';
+UPDATE jobs SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE jobs SET heartbeat_at = strftime('%Y-%m-%dT%H:%M:%SZ', heartbeat_at) WHERE heartbeat_at LIKE '____-__-__ __:__:__%';
+UPDATE platform_cookies SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE platform_cookies SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE topics SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE topic_edges SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE clip_topics SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE user_topic_affinities SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE user_topic_affinities SET decayed_at = strftime('%Y-%m-%dT%H:%M:%SZ', decayed_at) WHERE decayed_at LIKE '____-__-__ __:__:__%';
+UPDATE clip_embeddings SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE user_embeddings SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE saved_filters SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE scout_sources SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE scout_candidates SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE clip_summaries SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE llm_logs SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE federation_peers SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE federation_remotes SET last_synced_at = strftime('%Y-%m-%dT%H:%M:%SZ', last_synced_at) WHERE last_synced_at LIKE '____-__-__ __:__:__%';
+UPDATE federation_remotes SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE federation_subscriptions SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE federated_clips SET remote_created_at = strftime('%Y-%m-%dT%H:%M:%SZ', remote_created_at) WHERE remote_created_at LIKE '____-__-__ __:__:__%';
+UPDATE federated_clips SET synced_at = strftime('%Y-%m-%dT%H:%M:%SZ', synced_at) WHERE synced_at LIKE '____-__-__ __:__:__%';
+UPDATE notification_preferences SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE push_subscriptions SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE notifications SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE mixes SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE focus_sessions SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE focus_sessions SET ended_at = strftime('%Y-%m-%dT%H:%M:%SZ', ended_at) WHERE ended_at LIKE '____-__-__ __:__:__%';
+UPDATE user_topic_arms SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE bandit_impressions SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE platform_limits SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE job_dependencies SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE feed_candidates SET computed_at = strftime('%Y-%m-%dT%H:%M:%SZ', computed_at) WHERE computed_at LIKE '____-__-__ __:__:__%';
+UPDATE feed_candidates SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', expires_at) WHERE expires_at LIKE '____-__-__ __:__:__%';
+UPDATE staff_picks SET picked_at = strftime('%Y-%m-%dT%H:%M:%SZ', picked_at) WHERE picked_at LIKE '____-__-__ __:__:__%';
+UPDATE series SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE series SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE user_snoozes SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', expires_at) WHERE expires_at LIKE '____-__-__ __:__:__%';
+UPDATE user_snoozes SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE interaction_flags SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE interaction_flags SET last_seen_at = strftime('%Y-%m-%dT%H:%M:%SZ', last_seen_at) WHERE last_seen_at LIKE '____-__-__ __:__:__%';
+UPDATE interaction_flags SET resolved_at = strftime('%Y-%m-%dT%H:%M:%SZ', resolved_at) WHERE resolved_at LIKE '____-__-__ __:__:__%';
+UPDATE scoring_weights SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE playlist_tokens SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE playlist_tokens SET last_used_at = strftime('%Y-%m-%dT%H:%M:%SZ', last_used_at) WHERE last_used_at LIKE '____-__-__ __:__:__%';
+UPDATE clip_thumbnails SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE jwt_keys SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE jwt_keys SET rotated_at = strftime('%Y-%m-%dT%H:%M:%SZ', rotated_at) WHERE rotated_at LIKE '____-__-__ __:__:__%';
+UPDATE platform_clip_strategies SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE instance_settings SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE invite_codes SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE invite_codes SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', expires_at) WHERE expires_at LIKE '____-__-__ __:__:__%';
+UPDATE invite_codes SET used_at = strftime('%Y-%m-%dT%H:%M:%SZ', used_at) WHERE used_at LIKE '____-__-__ __:__:__%';
+UPDATE user_pacing_state SET last_view_at = strftime('%Y-%m-%dT%H:%M:%SZ', last_view_at) WHERE last_view_at LIKE '____-__-__ __:__:__%';
+UPDATE user_pacing_state SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
+UPDATE clip_storyboards SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at) WHERE created_at LIKE '____-__-__ __:__:__%';
+UPDATE user_feedback_weights SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) WHERE updated_at LIKE '____-__-__ __:__:__%';
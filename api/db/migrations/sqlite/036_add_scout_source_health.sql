@@ -0,0 +1,9 @@
+-- Scout source check results, reported by the scout worker after every
+-- check. Failing sources are retried with backoff and paused, with the
+-- reason recorded, after repeated consecutive failures.
+
+ALTER TABLE scout_sources ADD COLUMN last_error TEXT;
+ALTER TABLE scout_sources ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scout_sources ADD COLUMN last_success_at TEXT;
+ALTER TABLE scout_sources ADD COLUMN next_check_at TEXT;
+ALTER TABLE scout_sources ADD COLUMN paused_reason TEXT;
@@ -0,0 +1,31 @@
+-- Audit log of sensitive admin actions. Entries about a user are shown to
+-- that user.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id              TEXT PRIMARY KEY,
+    actor           TEXT NOT NULL,
+    action          TEXT NOT NULL,
+    target_user_id  TEXT REFERENCES users(id) ON DELETE CASCADE,
+    details         TEXT NOT NULL DEFAULT '{}',
+    created_at      TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_user_id, created_at);
+
+-- Short-lived tokens an admin mints to act as a user while debugging.
+-- Only the SHA-256 of the token is stored.
+
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+    id             TEXT PRIMARY KEY,
+    user_id        TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash     TEXT NOT NULL UNIQUE,
+    reason         TEXT NOT NULL,
+    read_only      INTEGER NOT NULL DEFAULT 1,
+    request_count  INTEGER NOT NULL DEFAULT 0,
+    created_at     TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    expires_at     TEXT NOT NULL,
+    last_used_at   TEXT,
+    ended_at       TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_user ON impersonation_sessions(user_id, created_at);
@@ -0,0 +1,23 @@
+-- Bytes of media served per user per day through the proxy stream path.
+
+CREATE TABLE IF NOT EXISTS stream_usage (
+    user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day           TEXT NOT NULL,
+    bytes_served  INTEGER NOT NULL DEFAULT 0,
+    requests      INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
+
+-- The device each account is playing on, for concurrent stream limits.
+-- A device counts as streaming while last_seen_at is recent.
+
+CREATE TABLE IF NOT EXISTS stream_sessions (
+    user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id     TEXT NOT NULL,
+    clip_id       TEXT NOT NULL,
+    last_seen_at  TEXT NOT NULL,
+    PRIMARY KEY (user_id, device_id)
+);
+
+-- How many devices an account may stream on at once (NULL for no limit).
+ALTER TABLE users ADD COLUMN max_concurrent_streams INTEGER;
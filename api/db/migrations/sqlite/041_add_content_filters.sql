@@ -0,0 +1,32 @@
+-- Keyword and regex filters matched against clip transcripts. Instance
+-- filters (user_id NULL) hide matching clips from restricted profiles;
+-- a user's own filters hide them from that user. Matches below a filter's
+-- threshold are borderline and wait in the admin review queue.
+
+CREATE TABLE IF NOT EXISTS content_filters (
+    id          TEXT PRIMARY KEY,
+    user_id     TEXT REFERENCES users(id) ON DELETE CASCADE,
+    name        TEXT NOT NULL,
+    kind        TEXT NOT NULL CHECK (kind IN ('keyword', 'regex')),
+    pattern     TEXT NOT NULL,
+    threshold   INTEGER NOT NULL DEFAULT 1,
+    created_at  TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_filters_user ON content_filters(user_id);
+
+CREATE TABLE IF NOT EXISTS clip_filter_matches (
+    clip_id      TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    filter_id    TEXT NOT NULL REFERENCES content_filters(id) ON DELETE CASCADE,
+    match_count  INTEGER NOT NULL,
+    excerpt      TEXT NOT NULL DEFAULT '',
+    status       TEXT NOT NULL CHECK (status IN ('pending', 'confirmed', 'dismissed')),
+    reviewed_at  TEXT,
+    created_at   TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    PRIMARY KEY (clip_id, filter_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_clip_filter_matches_status ON clip_filter_matches(status, created_at);
+
+-- Restricted profiles never see clips matched by an instance filter.
+ALTER TABLE users ADD COLUMN restricted INTEGER NOT NULL DEFAULT 0;
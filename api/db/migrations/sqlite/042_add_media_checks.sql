@@ -0,0 +1,10 @@
+-- Post-creation media validation. A ready clip whose storage objects are
+-- missing or empty, or whose duration disagrees with its segment, is set to
+-- status 'broken' with the reason; repair_attempts counts the re-download
+-- jobs queued for it.
+ALTER TABLE clips ADD COLUMN media_checked_at TEXT;
+ALTER TABLE clips ADD COLUMN broken_reason TEXT;
+ALTER TABLE clips ADD COLUMN repair_attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_clips_media_unchecked ON clips(created_at)
+    WHERE media_checked_at IS NULL;
@@ -0,0 +1,11 @@
+-- Cached activity recaps (GET /api/me/recap). data holds the recap JSON for
+-- the period starting at period_start; recaps of periods still in progress
+-- are recomputed once they are an hour old.
+CREATE TABLE IF NOT EXISTS user_recaps (
+    user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    period       TEXT NOT NULL,
+    period_start TEXT NOT NULL,
+    data         TEXT NOT NULL,
+    computed_at  TEXT NOT NULL,
+    PRIMARY KEY (user_id, period, period_start)
+);
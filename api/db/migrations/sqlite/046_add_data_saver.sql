@@ -0,0 +1,5 @@
+-- Data saver mode serves a lower-bitrate rendition of each clip and a
+-- smaller thumbnail, where the worker produced them.
+ALTER TABLE clips ADD COLUMN low_storage_key TEXT;
+ALTER TABLE clips ADD COLUMN small_thumbnail_key TEXT;
+ALTER TABLE user_preferences ADD COLUMN data_saver INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Pending scout candidates nobody evaluated expire after a while. A
+-- resurfaced candidate's age counts from when it was put back in the queue.
+ALTER TABLE scout_candidates ADD COLUMN expired_at TEXT;
+ALTER TABLE scout_candidates ADD COLUMN resurfaced_at TEXT;
+ALTER TABLE user_preferences ADD COLUMN scout_resurface_expired INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,33 @@
+-- One-way sync of saved clips to outside services. Each new save is queued
+-- once per enabled integration; deliveries are retried with backoff until
+-- they are sent, skipped, or fail for good.
+
+CREATE TABLE IF NOT EXISTS user_integrations (
+    id                    TEXT PRIMARY KEY,
+    user_id               TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind                  TEXT NOT NULL CHECK (kind IN ('webhook', 'readwise', 'youtube_playlist')),
+    -- The webhook URL or YouTube playlist id; empty for Readwise.
+    target                TEXT NOT NULL DEFAULT '',
+    -- The webhook signing secret or Readwise token, encrypted.
+    secret                TEXT,
+    enabled               INTEGER NOT NULL DEFAULT 1,
+    consecutive_failures  INTEGER NOT NULL DEFAULT 0,
+    last_error            TEXT,
+    last_success_at       TEXT,
+    created_at            TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    UNIQUE(user_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS integration_deliveries (
+    integration_id   TEXT NOT NULL REFERENCES user_integrations(id) ON DELETE CASCADE,
+    clip_id          TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    status           TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'skipped', 'failed')),
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TEXT,
+    last_error       TEXT,
+    sent_at          TEXT,
+    created_at       TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    PRIMARY KEY (integration_id, clip_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_integration_deliveries_due ON integration_deliveries(status, next_attempt_at);
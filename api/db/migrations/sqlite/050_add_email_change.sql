@@ -0,0 +1,5 @@
+-- An email change waits here until the new address is confirmed. Only the
+-- SHA-256 of the verification token is stored.
+ALTER TABLE users ADD COLUMN pending_email TEXT;
+ALTER TABLE users ADD COLUMN email_token_hash TEXT;
+ALTER TABLE users ADD COLUMN email_token_expires_at TEXT;
@@ -0,0 +1,16 @@
+-- Within-clip retention. Each clip is split into equal buckets; every view
+-- that reports its watched segments counts once in clip_retention.sessions
+-- and once in each bucket it covered. Aggregated as views arrive, so the
+-- curves survive interaction pruning.
+
+CREATE TABLE IF NOT EXISTS clip_retention (
+    clip_id   TEXT PRIMARY KEY REFERENCES clips(id) ON DELETE CASCADE,
+    sessions  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS clip_retention_buckets (
+    clip_id   TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    bucket    INTEGER NOT NULL,
+    viewers   INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (clip_id, bucket)
+);
@@ -0,0 +1,34 @@
+-- Migrations of object storage to a new backend. Every object key the
+-- database references becomes an item, copied and verified one by one so
+-- an interrupted migration resumes where it stopped. The target's secret
+-- key is encrypted with COOKIE_SECRET.
+
+CREATE TABLE IF NOT EXISTS storage_migrations (
+    id             TEXT PRIMARY KEY,
+    status         TEXT NOT NULL DEFAULT 'running'
+                   CHECK (status IN ('running', 'paused', 'completed', 'failed')),
+    dry_run        INTEGER NOT NULL DEFAULT 0,
+    target         TEXT NOT NULL,
+    target_secret  TEXT,
+    prefix_from    TEXT NOT NULL DEFAULT '',
+    prefix_to      TEXT NOT NULL DEFAULT '',
+    rows_rewritten INTEGER NOT NULL DEFAULT 0,
+    last_error     TEXT,
+    created_at     TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at     TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    finished_at    TEXT
+);
+
+CREATE TABLE IF NOT EXISTS storage_migration_items (
+    migration_id  TEXT NOT NULL REFERENCES storage_migrations(id) ON DELETE CASCADE,
+    key           TEXT NOT NULL,
+    new_key       TEXT NOT NULL,
+    status        TEXT NOT NULL DEFAULT 'pending'
+                  CHECK (status IN ('pending', 'copied', 'planned', 'missing', 'failed')),
+    size_bytes    INTEGER,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    error         TEXT,
+    PRIMARY KEY (migration_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_storage_migration_items_status ON storage_migration_items(migration_id, status);
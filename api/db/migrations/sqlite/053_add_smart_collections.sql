@@ -0,0 +1,6 @@
+-- A smart collection lists search filters (a JSON array of queries) instead
+-- of hand-picked clips. Its collection_clips rows are rebuilt from them,
+-- each noting the filter that matched it.
+ALTER TABLE collections ADD COLUMN filters TEXT;
+ALTER TABLE collections ADD COLUMN refreshed_at TEXT;
+ALTER TABLE collection_clips ADD COLUMN matched_filter TEXT;
@@ -0,0 +1,7 @@
+-- A collection's cover is an image in object storage: one its owner
+-- uploaded (cover_source 'upload') or a mosaic of member clip thumbnails
+-- ('mosaic'). cover_thumbs lists the thumbnail keys a mosaic was built
+-- from, so it is only rebuilt when they change.
+ALTER TABLE collections ADD COLUMN cover_key TEXT;
+ALTER TABLE collections ADD COLUMN cover_source TEXT;
+ALTER TABLE collections ADD COLUMN cover_thumbs TEXT;
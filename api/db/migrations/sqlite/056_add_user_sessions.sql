@@ -0,0 +1,20 @@
+-- A row per sign-in, so users can see where their account is signed in
+-- and revoke access. Tokens carry the session id in their "sid" claim; the
+-- user agent and IP are the ones the token was issued to.
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id            TEXT PRIMARY KEY,
+    user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent    TEXT NOT NULL DEFAULT '',
+    ip            TEXT NOT NULL DEFAULT '',
+    created_at    TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    last_used_at  TEXT,
+    expires_at    TEXT NOT NULL,
+    revoked_at    TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id, created_at);
+
+-- Tokens issued before sessions were tracked have no session; signing out
+-- everywhere rejects the ones issued before this time.
+ALTER TABLE users ADD COLUMN sessions_revoked_at TEXT;
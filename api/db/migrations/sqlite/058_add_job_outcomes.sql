@@ -0,0 +1,17 @@
+-- The worker that claimed each job, as named by its X-Worker-ID header.
+ALTER TABLE jobs ADD COLUMN worker_id TEXT;
+
+-- One row per finished job attempt, kept apart from jobs so that ingest
+-- analytics survive job retention.
+CREATE TABLE IF NOT EXISTS job_outcomes (
+    job_id TEXT NOT NULL,
+    job_type TEXT NOT NULL,
+    platform TEXT NOT NULL DEFAULT '',
+    worker_id TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    error_code TEXT NOT NULL DEFAULT '',
+    duration_seconds REAL,
+    finished_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_outcomes_finished ON job_outcomes(finished_at);
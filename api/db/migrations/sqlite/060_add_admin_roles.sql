@@ -0,0 +1,7 @@
+-- Admin role of a user: owner, moderator, or curator. Users with a role sign
+-- in to the admin API with their own password and get that role's
+-- permissions; the configured admin account is always an owner. NULL means
+-- no admin access.
+ALTER TABLE users ADD COLUMN admin_role TEXT CHECK (admin_role IN ('owner', 'moderator', 'curator'));
+
+CREATE INDEX IF NOT EXISTS idx_users_admin_role ON users(admin_role);
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestNormalizeTimestampsMigration_RewritesLegacyFormat(t *testing.T) {
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	defer rawDB.Close()
+	if err := RunMigrations(rawDB, DialectSQLite); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	// Rows written by an older release, before the migration ran.
+	rawDB.Exec(`INSERT INTO users (id, username, email, password_hash, created_at, updated_at)
+		VALUES ('legacy', 'legacy', 'legacy@test.com', 'x', '2024-03-01 12:34:56', '2024-03-02T08:00:00Z')`)
+	rawDB.Exec(`INSERT INTO sources (id, url, platform, created_at) VALUES ('src', 'https://example.com', 'direct', '2024-03-01 12:34:56.789')`)
+	rawDB.Exec(`DELETE FROM schema_migrations WHERE version = '034_normalize_timestamps.sql'`)
+	if err := RunMigrations(rawDB, DialectSQLite); err != nil {
+		t.Fatalf("re-run migration: %v", err)
+	}
+
+	var created, updated, sourceCreated string
+	rawDB.QueryRow(`SELECT created_at, updated_at FROM users WHERE id = 'legacy'`).Scan(&created, &updated)
+	rawDB.QueryRow(`SELECT created_at FROM sources WHERE id = 'src'`).Scan(&sourceCreated)
+	if created != "2024-03-01T12:34:56Z" {
+		t.Errorf("users.created_at = %q, want ISO 8601", created)
+	}
+	if updated != "2024-03-02T08:00:00Z" {
+		t.Errorf("users.updated_at = %q, want the ISO value untouched", updated)
+	}
+	if sourceCreated != "2024-03-01T12:34:56Z" {
+		t.Errorf("sources.created_at = %q, want ISO 8601 without fractional seconds", sourceCreated)
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryStats counts and times the queries run on behalf of one request.
+// Queries are timed until they return, so the time spent reading rows
+// afterwards is not included.
+type QueryStats struct {
+	// Route names the request in slow query logs, e.g. "GET /api/feed".
+	Route string
+
+	mu    sync.Mutex
+	count int
+	total time.Duration
+}
+
+func (s *QueryStats) add(dur time.Duration) {
+	s.mu.Lock()
+	s.count++
+	s.total += dur
+	s.mu.Unlock()
+}
+
+// Count returns how many queries have run.
+func (s *QueryStats) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Duration returns the total time spent in queries.
+func (s *QueryStats) Duration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+type queryStatsKey struct{}
+
+// WithQueryStats returns a context whose queries are counted into a new
+// QueryStats for route.
+func WithQueryStats(ctx context.Context, route string) (context.Context, *QueryStats) {
+	s := &QueryStats{Route: route}
+	return context.WithValue(ctx, queryStatsKey{}, s), s
+}
+
+// QueryStatsFrom returns the stats attached to ctx, or nil.
+func QueryStatsFrom(ctx context.Context) *QueryStats {
+	s, _ := ctx.Value(queryStatsKey{}).(*QueryStats)
+	return s
+}
+
+// observe records a query that started at start in ctx's stats and as a
+// span of ctx's trace, and logs it if it ran longer than the slow query
+// threshold.
+func (d *CompatDB) observe(ctx context.Context, query string, args []interface{}, start time.Time) {
+	dur := time.Since(start)
+	d.traceQuery(ctx, query, start, dur)
+	stats := QueryStatsFrom(ctx)
+	if stats != nil {
+		stats.add(dur)
+	}
+	if d.SlowQueryThreshold <= 0 || dur < d.SlowQueryThreshold {
+		return
+	}
+	route := "background"
+	if stats != nil {
+		route = stats.Route
+	}
+	log.Printf("slow query (%s) %s: %s args=%s", dur.Round(time.Millisecond), route, compactQuery(query), sanitizeArgs(query, args))
+}
+
+// traceQuery adds a client span for a query to ctx's trace, if it is being
+// recorded. The span carries the query text but never its arguments.
+func (d *CompatDB) traceQuery(ctx context.Context, query string, start time.Time, dur time.Duration) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return
+	}
+	statement := compactQuery(query)
+	operation, _, _ := strings.Cut(statement, " ")
+	system := "sqlite"
+	if d.IsPostgres() {
+		system = "postgresql"
+	}
+	_, span := parent.TracerProvider().Tracer("clipfeed/db").Start(ctx, "db "+strings.ToUpper(operation),
+		trace.WithTimestamp(start), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.statement", statement),
+		))
+	span.End(trace.WithTimestamp(start.Add(dur)))
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+const maxLoggedQuery = 500
+
+// compactQuery collapses a query onto one line for logging.
+func compactQuery(query string) string {
+	q := strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+	if len(q) > maxLoggedQuery {
+		q = q[:maxLoggedQuery] + "..."
+	}
+	return q
+}
+
+// sensitiveColumns mark queries whose text arguments are not logged.
+var sensitiveColumns = []string{"password", "token", "secret", "cookie", "email", "key_hash"}
+
+const maxLoggedArg = 40
+
+// sanitizeArgs renders query arguments for logging. Text is truncated, blobs
+// are replaced by their size, and text is withheld entirely for queries
+// touching credentials or personal data.
+func sanitizeArgs(query string, args []interface{}) string {
+	lower := strings.ToLower(query)
+	redact := false
+	for _, col := range sensitiveColumns {
+		redact = redact || strings.Contains(lower, col)
+	}
+	parts := make([]string, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case nil:
+			parts[i] = "NULL"
+		case []byte:
+			parts[i] = fmt.Sprintf("<%d bytes>", len(v))
+		case string:
+			switch {
+			case redact:
+				parts[i] = "<redacted>"
+			case len(v) > maxLoggedArg:
+				parts[i] = fmt.Sprintf("%q...", v[:maxLoggedArg])
+			default:
+				parts[i] = fmt.Sprintf("%q", v)
+			}
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
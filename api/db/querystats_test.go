@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestQueryStats_CountsContextQueries(t *testing.T) {
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	defer rawDB.Close()
+	cdb := NewCompatDB(rawDB, DialectSQLite)
+
+	ctx, stats := WithQueryStats(context.Background(), "GET /api/feed")
+	cdb.ExecContext(ctx, `CREATE TABLE t (id INTEGER)`)
+	cdb.ExecContext(ctx, `INSERT INTO t VALUES (?)`, 1)
+	var n int
+	cdb.QueryRowContext(ctx, `SELECT COUNT(*) FROM t`).Scan(&n)
+	err = WithTx(ctx, cdb, func(conn *CompatConn) error {
+		_, err := conn.ExecContext(ctx, `INSERT INTO t VALUES (?)`, 2)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("tx: %v", err)
+	}
+	// Queries outside the request are not counted.
+	cdb.ExecContext(context.Background(), `SELECT 1`)
+
+	// 3 direct queries, then BEGIN, INSERT, COMMIT.
+	if got := stats.Count(); got != 6 {
+		t.Errorf("count = %d, want 6", got)
+	}
+	if stats.Duration() <= 0 {
+		t.Error("expected a positive total duration")
+	}
+	if QueryStatsFrom(context.Background()) != nil {
+		t.Error("expected no stats on a plain context")
+	}
+}
+
+func TestSanitizeArgs(t *testing.T) {
+	got := sanitizeArgs(`SELECT * FROM clips WHERE id = ? AND score > ?`,
+		[]interface{}{"c1", 0.5, nil, []byte{1, 2, 3}, strings.Repeat("x", 100)})
+	want := `["c1", 0.5, NULL, <3 bytes>, "` + strings.Repeat("x", maxLoggedArg) + `"...]`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	got = sanitizeArgs(`SELECT id FROM users WHERE email = ? AND password_hash = ?`, []interface{}{"a@b.c", "hash", 7})
+	if want := `[<redacted>, <redacted>, 7]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCompactQuery(t *testing.T) {
+	got := compactQuery("\n\t\tSELECT id\n\t\tFROM clips\n\t\tWHERE id = ?\n")
+	if want := "SELECT id FROM clips WHERE id = ?"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
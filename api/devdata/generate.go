@@ -0,0 +1,420 @@
+// Package devdata generates synthetic users, clips, topics, embeddings, and
+// interaction histories for benchmarking and regression-testing ranking
+// changes without production data.
+package devdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/transcripts"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// IDPrefix marks every row created by the generator so it can be purged.
+const IDPrefix = "synth-"
+
+// Password is the login password shared by all synthetic users.
+const Password = "synthetic-password"
+
+// insertBatch is how many rows are written per transaction.
+const insertBatch = 500
+
+// Options controls the size and shape of a generated dataset.
+type Options struct {
+	Users               int
+	Clips               int
+	Topics              int
+	InteractionsPerUser int
+	EmbeddingDim        int
+	HistoryDays         int
+	Seed                int64
+}
+
+// DefaultOptions returns a dataset large enough to exercise ranking at a
+// realistic scale: 10k clips and 100 users.
+func DefaultOptions() Options {
+	return Options{
+		Users:               100,
+		Clips:               10000,
+		Topics:              50,
+		InteractionsPerUser: 200,
+		EmbeddingDim:        64,
+		HistoryDays:         30,
+		Seed:                1,
+	}
+}
+
+// Summary reports what was generated.
+type Summary struct {
+	Users        int `json:"users"`
+	Clips        int `json:"clips"`
+	Topics       int `json:"topics"`
+	Interactions int `json:"interactions"`
+}
+
+var topicWords = []string{
+	"cooking", "chess", "astronomy", "woodworking", "guitar", "running", "gardening", "robotics",
+	"history", "photography", "skateboarding", "baking", "climbing", "painting", "physics", "poetry",
+	"surfing", "coffee", "cycling", "origami", "pottery", "drumming", "birding", "sailing",
+	"knitting", "fishing", "yoga", "magic", "economics", "linguistics", "architecture", "gaming",
+	"animation", "chemistry", "dance", "film", "geology", "hiking", "jazz", "math",
+}
+
+var titleWords = []string{
+	"beginner", "advanced", "quick", "deep dive", "mistakes", "tips", "explained", "challenge",
+	"review", "highlights", "tutorial", "story", "myths", "secrets", "basics", "live",
+}
+
+// ClipID returns the ID of the i-th generated clip.
+func ClipID(i int) string { return fmt.Sprintf("%sclip-%05d", IDPrefix, i) }
+
+// UserID returns the ID of the i-th generated user.
+func UserID(i int) string { return fmt.Sprintf("%suser-%03d", IDPrefix, i) }
+
+// Username returns the login name of the i-th generated user.
+func Username(i int) string { return fmt.Sprintf("synth_user_%03d", i) }
+
+// SearchTerms returns words that appear in generated clip titles, for
+// driving search load.
+func SearchTerms() []string {
+	return append(append([]string{}, topicWords...), titleWords...)
+}
+
+// synthClip is the generator's ground truth for one clip.
+type synthClip struct {
+	id        string
+	primary   int
+	secondary int // -1 when the clip has a single topic
+	quality   float64
+}
+
+// Generate writes a synthetic dataset. Users prefer a few topics with
+// Zipf-distributed popularity; clip embeddings cluster around per-topic
+// centroids; interactions are sampled from each user's true affinity for a
+// clip's topics and its latent quality, so a good ranker should recover them.
+func Generate(ctx context.Context, cdb *db.CompatDB, opts Options) (Summary, error) {
+	if opts.Users <= 0 || opts.Clips <= 0 || opts.Topics <= 0 || opts.EmbeddingDim <= 0 {
+		return Summary{}, fmt.Errorf("users, clips, topics, and embedding dim must be positive")
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	now := time.Now().UTC()
+	historySecs := int64(opts.HistoryDays) * 86400
+	ts := func(secsAgo int64) string {
+		return now.Add(-time.Duration(secsAgo) * time.Second).Format("2006-01-02T15:04:05Z")
+	}
+
+	// Topics get random unit centroids; names carry the prefix so they never
+	// collide with real topics.
+	topicIDs := make([]string, opts.Topics)
+	topicWordsUsed := make([]string, opts.Topics)
+	topicNames := make([]string, opts.Topics)
+	centroids := make([][]float64, opts.Topics)
+	for t := range topicIDs {
+		topicIDs[t] = fmt.Sprintf("%stopic-%03d", IDPrefix, t)
+		word := topicWords[t%len(topicWords)]
+		if t >= len(topicWords) {
+			word = fmt.Sprintf("%s %d", word, t/len(topicWords)+1)
+		}
+		topicWordsUsed[t] = word
+		topicNames[t] = IDPrefix + word
+		centroids[t] = unitVector(rng, opts.EmbeddingDim)
+	}
+	if err := insertRows(ctx, cdb, opts.Topics, func(conn *db.CompatConn, i int) error {
+		slug := strings.ReplaceAll(topicNames[i], " ", "-")
+		_, err := conn.ExecContext(ctx,
+			`INSERT INTO topics (id, name, slug, path) VALUES (?, ?, ?, ?)`,
+			topicIDs[i], topicNames[i], slug, slug)
+		return err
+	}); err != nil {
+		return Summary{}, fmt.Errorf("topics: %w", err)
+	}
+
+	// One synthetic channel per topic keeps channel affinity meaningful.
+	if err := insertRows(ctx, cdb, opts.Topics, func(conn *db.CompatConn, i int) error {
+		_, err := conn.ExecContext(ctx, `
+			INSERT INTO sources (id, url, platform, channel_name, status, created_at)
+			VALUES (?, ?, 'youtube', ?, 'complete', ?)
+		`, fmt.Sprintf("%ssource-%03d", IDPrefix, i), fmt.Sprintf("https://www.youtube.com/watch?v=synth%03d", i),
+			fmt.Sprintf("Synthetic %s channel", topicWordsUsed[i]), ts(historySecs))
+		return err
+	}); err != nil {
+		return Summary{}, fmt.Errorf("sources: %w", err)
+	}
+
+	topicZipf := rand.NewZipf(rng, 1.2, 1, uint64(opts.Topics-1))
+	clips := make([]synthClip, opts.Clips)
+	if err := insertRows(ctx, cdb, opts.Clips, func(conn *db.CompatConn, i int) error {
+		c := synthClip{id: ClipID(i), primary: int(topicZipf.Uint64()), secondary: -1}
+		if rng.Float64() < 0.3 {
+			if s := rng.Intn(opts.Topics); s != c.primary {
+				c.secondary = s
+			}
+		}
+		c.quality = betaSample(rng, 2, 2)
+		clips[i] = c
+
+		emb := make([]float32, opts.EmbeddingDim)
+		noise := unitVector(rng, opts.EmbeddingDim)
+		var norm float64
+		vals := make([]float64, opts.EmbeddingDim)
+		for d := range vals {
+			vals[d] = 0.8*centroids[c.primary][d] + 0.4*noise[d]
+			if c.secondary >= 0 {
+				vals[d] += 0.3 * centroids[c.secondary][d]
+			}
+			norm += vals[d] * vals[d]
+		}
+		norm = math.Sqrt(norm)
+		for d := range vals {
+			emb[d] = float32(vals[d] / norm)
+		}
+
+		topics := []string{topicNames[c.primary]}
+		if c.secondary >= 0 {
+			topics = append(topics, topicNames[c.secondary])
+		}
+		word := topicWordsUsed[c.primary]
+		title := fmt.Sprintf("%s%s %s #%d", strings.ToUpper(word[:1]), word[1:], titleWords[rng.Intn(len(titleWords))], i)
+		transcript := fmt.Sprintf("a synthetic clip about %s. %s", word, titleWords[rng.Intn(len(titleWords))])
+		if c.secondary >= 0 {
+			transcript += " with some " + topicWordsUsed[c.secondary]
+		}
+		topicsJSON, _ := json.Marshal(topics)
+		sourceID := fmt.Sprintf("%ssource-%03d", IDPrefix, c.primary)
+		createdAt := ts(rng.Int63n(historySecs + 1))
+		duration := 15 + rng.Float64()*75
+		start := float64(rng.Intn(600))
+
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO clips (id, source_id, title, description, duration_seconds, start_time, end_time,
+			                   storage_key, thumbnail_key, file_size_bytes, topics, tags,
+			                   content_score, status, created_at)
+			VALUES (?, ?, ?, '', ?, ?, ?, ?, '', ?, ?, '[]', ?, 'ready', ?)
+		`, c.id, sourceID, title, duration, start, start+duration,
+			"synthetic/"+c.id+".mp4", 1_000_000+rng.Intn(20_000_000),
+			string(topicsJSON), 0.2+0.6*c.quality+0.1*rng.NormFloat64(), createdAt); err != nil {
+			return err
+		}
+		if err := transcripts.Save(ctx, conn, c.id, transcript); err != nil {
+			return err
+		}
+		for _, t := range []int{c.primary, c.secondary} {
+			if t < 0 {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO clip_topics (clip_id, topic_id, confidence, source) VALUES (?, ?, 1.0, 'synthetic')`,
+				c.id, topicIDs[t]); err != nil {
+				return err
+			}
+		}
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO clips_fts(clip_id, title, transcript, platform, channel_name) VALUES (?, ?, ?, 'youtube', ?)`,
+			c.id, title, transcript, fmt.Sprintf("Synthetic %s channel", topicWordsUsed[c.primary])); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx,
+			`INSERT INTO clip_embeddings (clip_id, text_embedding, model_version) VALUES (?, ?, 'synthetic')`,
+			c.id, feed.Float32ToBlob(emb))
+		return err
+	}); err != nil {
+		return Summary{}, fmt.Errorf("clips: %w", err)
+	}
+
+	byTopic := make([][]int, opts.Topics)
+	for i, c := range clips {
+		byTopic[c.primary] = append(byTopic[c.primary], i)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	// Each user's true affinity is a handful of Zipf-drawn favourite topics
+	// with gamma-distributed weights, normalised so the strongest is 1.
+	affinities := make([][]float64, opts.Users)
+	if err := insertRows(ctx, cdb, opts.Users, func(conn *db.CompatConn, u int) error {
+		aff := make([]float64, opts.Topics)
+		var max float64
+		for k := 0; k < 2+rng.Intn(4); k++ {
+			t := int(topicZipf.Uint64())
+			aff[t] += gammaSample(rng, 2)
+			max = math.Max(max, aff[t])
+		}
+		for t := range aff {
+			aff[t] /= max
+		}
+		affinities[u] = aff
+
+		userID := UserID(u)
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO users (id, username, email, password_hash) VALUES (?, ?, ?, ?)`,
+			userID, Username(u), fmt.Sprintf("synth_user_%03d@synthetic.invalid", u), string(hash)); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, `INSERT INTO user_preferences (user_id) VALUES (?)`, userID)
+		return err
+	}); err != nil {
+		return Summary{}, fmt.Errorf("users: %w", err)
+	}
+
+	type interaction struct {
+		userID, clipID, action string
+		watchSecs, watchPct    float64
+		createdAt              string
+	}
+	var interactions []interaction
+	for u, aff := range affinities {
+		userID := UserID(u)
+		favs := favouriteTopics(aff)
+		for k := 0; k < opts.InteractionsPerUser; k++ {
+			var idx int
+			if len(favs) > 0 && rng.Float64() < 0.7 {
+				pool := byTopic[favs[rng.Intn(len(favs))]]
+				if len(pool) == 0 {
+					continue
+				}
+				idx = pool[rng.Intn(len(pool))]
+			} else {
+				idx = rng.Intn(len(clips))
+			}
+			c := clips[idx]
+			a := aff[c.primary]
+			if c.secondary >= 0 {
+				a = math.Max(a, 0.5*aff[c.secondary])
+			}
+			p := 1 / (1 + math.Exp(-(4*a + 2*c.quality - 2.5)))
+			pct := math.Max(0.01, math.Min(1, p+0.15*rng.NormFloat64()))
+
+			action := "view"
+			switch r := rng.Float64(); {
+			case pct < 0.2:
+				action = "skip"
+			case r < 0.08*p:
+				action = "save"
+			case r < 0.3*p:
+				action = "like"
+			case pct > 0.95:
+				action = "watch_full"
+			}
+			interactions = append(interactions, interaction{
+				userID: userID, clipID: c.id, action: action,
+				watchSecs: pct * 45, watchPct: pct,
+				createdAt: ts(rng.Int63n(historySecs + 1)),
+			})
+		}
+	}
+	if err := insertRows(ctx, cdb, len(interactions), func(conn *db.CompatConn, i int) error {
+		it := interactions[i]
+		_, err := conn.ExecContext(ctx, `
+			INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, watch_percentage, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, fmt.Sprintf("%sint-%07d", IDPrefix, i), it.userID, it.clipID, it.action, it.watchSecs, it.watchPct, it.createdAt)
+		return err
+	}); err != nil {
+		return Summary{}, fmt.Errorf("interactions: %w", err)
+	}
+
+	return Summary{Users: opts.Users, Clips: opts.Clips, Topics: opts.Topics, Interactions: len(interactions)}, nil
+}
+
+// Purge removes every row created by Generate. Dependent rows are deleted
+// explicitly so it does not rely on foreign key enforcement being enabled.
+func Purge(ctx context.Context, cdb *db.CompatDB) error {
+	return db.WithTx(ctx, cdb, func(conn *db.CompatConn) error {
+		for _, stmt := range []string{
+			`DELETE FROM clips_fts WHERE clip_id LIKE ?`,
+			`DELETE FROM interactions WHERE id LIKE ?`,
+			`DELETE FROM clip_embeddings WHERE clip_id LIKE ?`,
+			`DELETE FROM clip_topics WHERE clip_id LIKE ?`,
+			`DELETE FROM clip_transcripts WHERE clip_id LIKE ?`,
+			`DELETE FROM user_preferences WHERE user_id LIKE ?`,
+			`DELETE FROM users WHERE id LIKE ?`,
+			`DELETE FROM clips WHERE id LIKE ?`,
+			`DELETE FROM sources WHERE id LIKE ?`,
+			`DELETE FROM topics WHERE id LIKE ?`,
+		} {
+			if _, err := conn.ExecContext(ctx, stmt, IDPrefix+"%"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insertRows calls insert for 0..n-1 in batched transactions.
+func insertRows(ctx context.Context, cdb *db.CompatDB, n int, insert func(conn *db.CompatConn, i int) error) error {
+	for start := 0; start < n; start += insertBatch {
+		end := min(start+insertBatch, n)
+		if err := db.WithTx(ctx, cdb, func(conn *db.CompatConn) error {
+			for i := start; i < end; i++ {
+				if err := insert(conn, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// favouriteTopics returns the topics with non-zero affinity, strongest first.
+func favouriteTopics(aff []float64) []int {
+	var favs []int
+	for t, a := range aff {
+		if a > 0 {
+			favs = append(favs, t)
+		}
+	}
+	sort.Slice(favs, func(i, j int) bool { return aff[favs[i]] > aff[favs[j]] })
+	return favs
+}
+
+func unitVector(rng *rand.Rand, dim int) []float64 {
+	v := make([]float64, dim)
+	var norm float64
+	for i := range v {
+		v[i] = rng.NormFloat64()
+		norm += v[i] * v[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// gammaSample draws from Gamma(shape, 1) for shape >= 1 (Marsaglia–Tsang).
+func gammaSample(rng *rand.Rand, shape float64) float64 {
+	d := shape - 1.0/3.0
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		if math.Log(rng.Float64()) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+func betaSample(rng *rand.Rand, a, b float64) float64 {
+	x, y := gammaSample(rng, a), gammaSample(rng, b)
+	return x / (x + y)
+}
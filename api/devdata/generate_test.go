@@ -0,0 +1,86 @@
+package devdata
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func countRows(t *testing.T, cdb *db.CompatDB, query string) int {
+	t.Helper()
+	var n int
+	if err := cdb.QueryRow(query).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+func TestGenerate_CountsAndPurge(t *testing.T) {
+	cdb := newTestDB(t)
+	ctx := context.Background()
+	opts := Options{Users: 5, Clips: 120, Topics: 8, InteractionsPerUser: 20, EmbeddingDim: 16, HistoryDays: 7, Seed: 42}
+
+	summary, err := Generate(ctx, cdb, opts)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if summary.Users != 5 || summary.Clips != 120 || summary.Topics != 8 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if got := countRows(t, cdb, `SELECT COUNT(*) FROM clips WHERE status = 'ready'`); got != 120 {
+		t.Errorf("ready clips = %d, want 120", got)
+	}
+	if got := countRows(t, cdb, `SELECT COUNT(*) FROM clip_embeddings`); got != 120 {
+		t.Errorf("embeddings = %d, want 120", got)
+	}
+	if got := countRows(t, cdb, `SELECT COUNT(*) FROM interactions`); got != summary.Interactions || got == 0 {
+		t.Errorf("interactions = %d, summary says %d", got, summary.Interactions)
+	}
+
+	if err := Purge(ctx, cdb); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	for _, table := range []string{"clips", "users", "topics", "interactions", "clip_embeddings"} {
+		if got := countRows(t, cdb, `SELECT COUNT(*) FROM `+table); got != 0 {
+			t.Errorf("%s has %d rows after purge", table, got)
+		}
+	}
+}
+
+func TestGenerate_SeedIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{Users: 3, Clips: 40, Topics: 4, InteractionsPerUser: 10, EmbeddingDim: 8, HistoryDays: 3, Seed: 7}
+
+	titles := func() string {
+		cdb := newTestDB(t)
+		if _, err := Generate(ctx, cdb, opts); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		var s string
+		if err := cdb.QueryRow(`SELECT GROUP_CONCAT(title, '|') FROM (SELECT title FROM clips ORDER BY id)`).Scan(&s); err != nil {
+			t.Fatalf("titles: %v", err)
+		}
+		return s
+	}
+	if a, b := titles(), titles(); a != b {
+		t.Errorf("same seed produced different datasets")
+	}
+}
@@ -0,0 +1,545 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipfeed/clips"
+	"clipfeed/crypto"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// Handler holds dependencies for federation endpoints and the sync loop.
+type Handler struct {
+	DB           *db.CompatDB
+	Minio        *minio.Client
+	MinioBucket  string
+	CookieSecret string
+	SyncInterval time.Duration
+}
+
+type peerIDKey struct{}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// --- Signed peer API ---
+
+// PeerAuthMiddleware verifies HMAC-signed requests from registered peers.
+func (h *Handler) PeerAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerID := r.Header.Get(HeaderPeerID)
+		if peerID == "" {
+			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
+			return
+		}
+		var encrypted string
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT secret FROM federation_peers WHERE id = ? AND is_active = 1`, peerID,
+		).Scan(&encrypted); err != nil {
+			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
+			return
+		}
+		secret, err := crypto.DecryptCookie(encrypted, h.CookieSecret)
+		if err != nil || !Verify(secret, r.Method, r.URL.RequestURI(),
+			r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), time.Now()) {
+			httputil.WriteJSON(w, 401, map[string]string{"error": "invalid signature"})
+			return
+		}
+		h.DB.ExecContext(r.Context(),
+			fmt.Sprintf(`UPDATE federation_peers SET last_seen = %s WHERE id = ?`, h.DB.NowUTC()), peerID)
+		ctx := context.WithValue(r.Context(), peerIDKey{}, peerID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// HandlePeerCatalog lists the topics and public collections a peer may subscribe to.
+func (h *Handler) HandlePeerCatalog(w http.ResponseWriter, r *http.Request) {
+	topics := make([]map[string]interface{}, 0)
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT slug, name, clip_count FROM topics WHERE clip_count > 0 ORDER BY clip_count DESC LIMIT 100`)
+	if err == nil {
+		for rows.Next() {
+			var slug, name string
+			var count int
+			if rows.Scan(&slug, &name, &count) == nil {
+				topics = append(topics, map[string]interface{}{"slug": slug, "name": name, "clip_count": count})
+			}
+		}
+		rows.Close()
+	}
+
+	collections := make([]map[string]interface{}, 0)
+	rows, err = h.DB.QueryContext(r.Context(), `
+		SELECT c.id, c.title, c.description, COUNT(cc.clip_id)
+		FROM collections c
+		LEFT JOIN collection_clips cc ON c.id = cc.collection_id
+		WHERE c.is_public = 1
+		GROUP BY c.id, c.title, c.description
+		ORDER BY c.created_at DESC LIMIT 100`)
+	if err == nil {
+		for rows.Next() {
+			var id, title string
+			var description *string
+			var count int
+			if rows.Scan(&id, &title, &description, &count) == nil {
+				collections = append(collections, map[string]interface{}{
+					"id": id, "title": title, "description": description, "clip_count": count,
+				})
+			}
+		}
+		rows.Close()
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{"topics": topics, "collections": collections})
+}
+
+// HandlePeerClips returns clip metadata for one subscribed topic or public
+// collection, oldest first. A page continues after since (a created_at) and,
+// to tell apart clips created in the same second, since_id.
+func (h *Handler) HandlePeerClips(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	collectionID := q.Get("collection")
+	since, sinceID := q.Get("since"), q.Get("since_id")
+	withMedia := q.Get("media") == "1"
+
+	where := []string{"c.status = 'ready'"}
+	var args []interface{}
+	switch {
+	case topic != "":
+		where = append(where, "c.id IN (SELECT ct.clip_id FROM clip_topics ct JOIN topics t ON t.id = ct.topic_id WHERE t.slug = ?)")
+		args = append(args, topic)
+	case collectionID != "":
+		var isPublic int
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT is_public FROM collections WHERE id = ?`, collectionID).Scan(&isPublic); err != nil || isPublic != 1 {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+			return
+		}
+		where = append(where, "c.id IN (SELECT clip_id FROM collection_clips WHERE collection_id = ?)")
+		args = append(args, collectionID)
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "topic or collection required"})
+		return
+	}
+	switch {
+	case since != "" && sinceID != "":
+		where = append(where, "(c.created_at > ? OR (c.created_at = ? AND c.id > ?))")
+		args = append(args, since, since, sinceID)
+	case since != "":
+		where = append(where, "c.created_at > ?")
+		args = append(args, since)
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT c.id, COALESCE(c.title, ''), COALESCE(c.description, ''), c.duration_seconds,
+		       COALESCE(c.thumbnail_key, ''), COALESCE(c.topics, '[]'), c.storage_key, c.created_at,
+		       s.channel_name, s.platform, s.url
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY c.created_at ASC, c.id ASC LIMIT 200`, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, title, description, thumbKey, topicsJSON, storageKey, createdAt string
+		var duration float64
+		var channelName, platform, sourceURL *string
+		if err := rows.Scan(&id, &title, &description, &duration, &thumbKey, &topicsJSON,
+			&storageKey, &createdAt, &channelName, &platform, &sourceURL); err != nil {
+			continue
+		}
+		var topics []string
+		json.Unmarshal([]byte(topicsJSON), &topics)
+		clip := map[string]interface{}{
+			"id": id, "title": title, "description": description,
+			"duration_seconds": duration,
+			"thumbnail_url":    httputil.ThumbnailURL(h.MinioBucket, thumbKey),
+			"topics":           topics, "created_at": createdAt,
+			"channel_name": channelName, "platform": platform, "source_url": sourceURL,
+		}
+		if withMedia && h.Minio != nil {
+			if u, err := h.Minio.PresignedGetObject(r.Context(), h.MinioBucket, storageKey, 30*time.Minute, nil); err == nil {
+				if mediaURL, err := clips.BuildBrowserStreamURL(u.String()); err == nil {
+					clip["media_url"] = mediaURL
+				}
+			}
+		}
+		out = append(out, clip)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("federation peer clips: rows iteration error: %v", err)
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"clips": out, "count": len(out)})
+}
+
+// --- Admin: inbound peers ---
+
+// HandleCreatePeer registers a remote instance allowed to read this one.
+// The generated secret is returned once and must be shared with the peer's operator.
+func (h *Handler) HandleCreatePeer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "name required"})
+		return
+	}
+	secret, err := newSecret()
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate secret"})
+		return
+	}
+	encrypted, err := crypto.EncryptCookie(secret, h.CookieSecret)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store secret"})
+		return
+	}
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO federation_peers (id, name, secret) VALUES (?, ?, ?)`,
+		id, strings.TrimSpace(req.Name), encrypted); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create peer"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id, "name": req.Name, "secret": secret})
+}
+
+// HandleListPeers lists registered inbound peers.
+func (h *Handler) HandleListPeers(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id, name, is_active, last_seen, created_at FROM federation_peers ORDER BY created_at DESC`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list peers"})
+		return
+	}
+	defer rows.Close()
+
+	peers := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, createdAt string
+		var isActive int
+		var lastSeen *string
+		if err := rows.Scan(&id, &name, &isActive, &lastSeen, &createdAt); err != nil {
+			continue
+		}
+		peers = append(peers, map[string]interface{}{
+			"id": id, "name": name, "is_active": isActive == 1,
+			"last_seen": lastSeen, "created_at": createdAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"peers": peers})
+}
+
+// HandleDeletePeer revokes an inbound peer's access.
+func (h *Handler) HandleDeletePeer(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(), `DELETE FROM federation_peers WHERE id = ?`, chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete peer"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "peer not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// --- Admin: outbound remotes ---
+
+// HandleCreateRemote registers a remote instance to subscribe to, using the
+// peer credentials its operator issued for us.
+func (h *Handler) HandleCreateRemote(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string `json:"name"`
+		BaseURL   string `json:"base_url"`
+		PeerID    string `json:"peer_id"`
+		Secret    string `json:"secret"`
+		PullMedia bool   `json:"pull_media"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.BaseURL = strings.TrimRight(strings.TrimSpace(req.BaseURL), "/")
+	parsed, err := url.Parse(req.BaseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "base_url must be a valid http or https URL"})
+		return
+	}
+	if req.Name == "" || req.PeerID == "" || req.Secret == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "name, peer_id, and secret required"})
+		return
+	}
+	encrypted, err := crypto.EncryptCookie(req.Secret, h.CookieSecret)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store secret"})
+		return
+	}
+	pullMedia := 0
+	if req.PullMedia {
+		pullMedia = 1
+	}
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO federation_remotes (id, name, base_url, peer_id, secret, pull_media) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, req.Name, req.BaseURL, req.PeerID, encrypted, pullMedia); err != nil {
+		errMsg := err.Error()
+		if strings.Contains(errMsg, "UNIQUE") || strings.Contains(errMsg, "duplicate key") {
+			httputil.WriteJSON(w, 409, map[string]string{"error": "remote already registered"})
+			return
+		}
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create remote"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id})
+}
+
+// HandleListRemotes lists subscribed remotes with their subscriptions and sync status.
+func (h *Handler) HandleListRemotes(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, name, base_url, pull_media, is_active, last_synced_at, last_error, created_at,
+		       (SELECT COUNT(*) FROM federated_clips fc WHERE fc.remote_id = federation_remotes.id)
+		FROM federation_remotes ORDER BY created_at DESC`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list remotes"})
+		return
+	}
+	var remotes []map[string]interface{}
+	for rows.Next() {
+		var id, name, baseURL, createdAt string
+		var pullMedia, isActive, clipCount int
+		var lastSynced, lastError *string
+		if err := rows.Scan(&id, &name, &baseURL, &pullMedia, &isActive, &lastSynced, &lastError, &createdAt, &clipCount); err != nil {
+			continue
+		}
+		remotes = append(remotes, map[string]interface{}{
+			"id": id, "name": name, "base_url": baseURL,
+			"pull_media": pullMedia == 1, "is_active": isActive == 1,
+			"last_synced_at": lastSynced, "last_error": lastError,
+			"created_at": createdAt, "clip_count": clipCount,
+		})
+	}
+	rows.Close()
+	if remotes == nil {
+		remotes = make([]map[string]interface{}, 0)
+	}
+
+	for _, rem := range remotes {
+		subs := make([]map[string]interface{}, 0)
+		subRows, err := h.DB.QueryContext(r.Context(),
+			`SELECT id, kind, ref, created_at FROM federation_subscriptions WHERE remote_id = ? ORDER BY created_at`, rem["id"])
+		if err == nil {
+			for subRows.Next() {
+				var id, kind, ref, createdAt string
+				if subRows.Scan(&id, &kind, &ref, &createdAt) == nil {
+					subs = append(subs, map[string]interface{}{"id": id, "kind": kind, "ref": ref, "created_at": createdAt})
+				}
+			}
+			subRows.Close()
+		}
+		rem["subscriptions"] = subs
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"remotes": remotes})
+}
+
+// HandleDeleteRemote unsubscribes from a remote and drops its synced clips.
+func (h *Handler) HandleDeleteRemote(w http.ResponseWriter, r *http.Request) {
+	remoteID := chi.URLParam(r, "id")
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM federated_clips WHERE remote_id = ?`, remoteID); err != nil {
+			return fmt.Errorf("delete federated clips: %w", err)
+		}
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM federation_subscriptions WHERE remote_id = ?`, remoteID); err != nil {
+			return fmt.Errorf("delete subscriptions: %w", err)
+		}
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM federation_remotes WHERE id = ?`, remoteID); err != nil {
+			return fmt.Errorf("delete remote: %w", err)
+		}
+		return nil
+	}); err != nil {
+		log.Printf("delete federation remote %s: %v", remoteID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete remote"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// HandleRemoteCatalog proxies the remote's catalog so admins can pick subscriptions.
+func (h *Handler) HandleRemoteCatalog(w http.ResponseWriter, r *http.Request) {
+	rem, err := h.loadRemote(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "remote not found"})
+		return
+	}
+	var catalog map[string]interface{}
+	if err := h.peerGet(r.Context(), rem, "/api/federation/v1/catalog", nil, &catalog); err != nil {
+		httputil.WriteJSON(w, 502, map[string]string{"error": "remote unavailable: " + err.Error()})
+		return
+	}
+	httputil.WriteJSON(w, 200, catalog)
+}
+
+// HandleCreateSubscription subscribes to a remote topic (by slug) or public collection (by id).
+func (h *Handler) HandleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	remoteID := chi.URLParam(r, "id")
+	var req struct {
+		Kind string `json:"kind"`
+		Ref  string `json:"ref"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if (req.Kind != "topic" && req.Kind != "collection") || req.Ref == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "kind must be topic or collection, and ref is required"})
+		return
+	}
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM federation_remotes WHERE id = ?`, remoteID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "remote not found"})
+		return
+	}
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO federation_subscriptions (id, remote_id, kind, ref) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		id, remoteID, req.Kind, req.Ref); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create subscription"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id, "kind": req.Kind, "ref": req.Ref})
+}
+
+// HandleDeleteSubscription removes a subscription from a remote.
+func (h *Handler) HandleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM federation_subscriptions WHERE id = ? AND remote_id = ?`,
+		chi.URLParam(r, "subId"), chi.URLParam(r, "id")); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete subscription"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// HandleTriggerSync runs a sync pass for one remote immediately.
+func (h *Handler) HandleTriggerSync(w http.ResponseWriter, r *http.Request) {
+	rem, err := h.loadRemote(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "remote not found"})
+		return
+	}
+	synced, err := h.syncRemote(r.Context(), rem)
+	if err != nil {
+		httputil.WriteJSON(w, 502, map[string]interface{}{"error": err.Error(), "synced": synced})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"synced": synced})
+}
+
+// --- User-facing ---
+
+// HandleListFederatedClips lists clips synced from subscribed remotes.
+func (h *Handler) HandleListFederatedClips(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+	where := "fr.is_active = 1"
+	args := []interface{}{}
+	if remoteID := r.URL.Query().Get("remote_id"); remoteID != "" {
+		where += " AND fc.remote_id = ?"
+		args = append(args, remoteID)
+	}
+	args = append(args, limit)
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT fc.remote_id, fr.name, fr.base_url, fc.remote_clip_id, COALESCE(fc.title, ''),
+		       COALESCE(fc.description, ''), COALESCE(fc.duration_seconds, 0), COALESCE(fc.thumbnail_url, ''),
+		       COALESCE(fc.topics, '[]'), fc.channel_name, fc.platform, fc.source_url,
+		       COALESCE(fc.storage_key, ''), fc.remote_created_at, fc.synced_at
+		FROM federated_clips fc
+		JOIN federation_remotes fr ON fr.id = fc.remote_id
+		WHERE `+where+`
+		ORDER BY fc.synced_at DESC, fc.remote_created_at DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list federated clips"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var remoteID, remoteName, baseURL, clipID, title, description, thumbURL, topicsJSON, storageKey, syncedAt string
+		var duration float64
+		var channelName, platform, sourceURL, remoteCreatedAt *string
+		if err := rows.Scan(&remoteID, &remoteName, &baseURL, &clipID, &title, &description, &duration,
+			&thumbURL, &topicsJSON, &channelName, &platform, &sourceURL, &storageKey, &remoteCreatedAt, &syncedAt); err != nil {
+			continue
+		}
+		var topics []string
+		json.Unmarshal([]byte(topicsJSON), &topics)
+		clip := map[string]interface{}{
+			"id": clipID, "remote_id": remoteID, "remote_name": remoteName,
+			"title": title, "description": description, "duration_seconds": duration,
+			"thumbnail_url": thumbURL, "topics": topics,
+			"channel_name": channelName, "platform": platform, "source_url": sourceURL,
+			"created_at": remoteCreatedAt, "synced_at": syncedAt,
+			"media_cached": storageKey != "",
+		}
+		if storageKey == "" {
+			clip["remote_stream_endpoint"] = baseURL + "/api/clips/" + url.PathEscape(clipID) + "/stream"
+		}
+		out = append(out, clip)
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"clips": out, "count": len(out)})
+}
+
+// HandleStreamFederatedClip returns a presigned URL for a federated clip whose
+// media was pulled through into local storage.
+func (h *Handler) HandleStreamFederatedClip(w http.ResponseWriter, r *http.Request) {
+	var storageKey string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT COALESCE(storage_key, '') FROM federated_clips WHERE remote_id = ? AND remote_clip_id = ?`,
+		chi.URLParam(r, "remoteId"), chi.URLParam(r, "clipId"),
+	).Scan(&storageKey); err != nil || storageKey == "" {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not cached locally"})
+		return
+	}
+	presignedURL, err := h.Minio.PresignedGetObject(r.Context(), h.MinioBucket, storageKey, 2*time.Hour, nil)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to generate stream URL"})
+		return
+	}
+	streamURL, err := clips.BuildBrowserStreamURL(presignedURL.String())
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build stream URL"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"url": streamURL})
+}
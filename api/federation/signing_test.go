@@ -0,0 +1,48 @@
+package federation
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify_RoundTrip(t *testing.T) {
+	now := time.Now()
+	ts := now.Unix()
+	sig := Sign("s3cret", "GET", "/api/federation/v1/clips?topic=go", ts)
+	if !Verify("s3cret", "GET", "/api/federation/v1/clips?topic=go", strconv.FormatInt(ts, 10), sig, now) {
+		t.Fatal("expected valid signature to verify")
+	}
+}
+
+func TestVerify_RejectsTampering(t *testing.T) {
+	now := time.Now()
+	ts := now.Unix()
+	tsHeader := strconv.FormatInt(ts, 10)
+	sig := Sign("s3cret", "GET", "/api/federation/v1/clips?topic=go", ts)
+
+	cases := []struct {
+		name, secret, method, uri string
+	}{
+		{"wrong secret", "other", "GET", "/api/federation/v1/clips?topic=go"},
+		{"wrong method", "s3cret", "POST", "/api/federation/v1/clips?topic=go"},
+		{"altered query", "s3cret", "GET", "/api/federation/v1/clips?topic=rust"},
+	}
+	for _, tc := range cases {
+		if Verify(tc.secret, tc.method, tc.uri, tsHeader, sig, now) {
+			t.Errorf("%s: expected verification failure", tc.name)
+		}
+	}
+}
+
+func TestVerify_RejectsStaleTimestamp(t *testing.T) {
+	now := time.Now()
+	ts := now.Add(-10 * time.Minute).Unix()
+	sig := Sign("s3cret", "GET", "/api/federation/v1/catalog", ts)
+	if Verify("s3cret", "GET", "/api/federation/v1/catalog", strconv.FormatInt(ts, 10), sig, now) {
+		t.Fatal("expected stale timestamp to be rejected")
+	}
+	if Verify("s3cret", "GET", "/api/federation/v1/catalog", "not-a-number", sig, now) {
+		t.Fatal("expected malformed timestamp to be rejected")
+	}
+}
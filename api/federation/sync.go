@@ -0,0 +1,340 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"clipfeed/crypto"
+	"clipfeed/httputil"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// maxPulledMediaBytes caps the size of a single clip pulled from a remote.
+const maxPulledMediaBytes = 512 << 20
+
+var peerClient = &http.Client{Timeout: 30 * time.Second}
+
+type remote struct {
+	ID        string
+	BaseURL   string
+	PeerID    string
+	Secret    string
+	PullMedia bool
+}
+
+type remoteClip struct {
+	ID              string   `json:"id"`
+	Title           string   `json:"title"`
+	Description     string   `json:"description"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	ThumbnailURL    string   `json:"thumbnail_url"`
+	Topics          []string `json:"topics"`
+	ChannelName     *string  `json:"channel_name"`
+	Platform        *string  `json:"platform"`
+	SourceURL       *string  `json:"source_url"`
+	CreatedAt       string   `json:"created_at"`
+	MediaURL        string   `json:"media_url"`
+}
+
+// validate rejects a clip that could never be stored, however often it
+// is retried.
+func (c remoteClip) validate() error {
+	switch {
+	case c.ID == "":
+		return errors.New("missing clip id")
+	case c.CreatedAt == "":
+		return errors.New("missing created_at")
+	}
+	return nil
+}
+
+func (h *Handler) loadRemote(ctx context.Context, id string) (*remote, error) {
+	var rem remote
+	var encrypted string
+	var pullMedia int
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT id, base_url, peer_id, secret, pull_media FROM federation_remotes WHERE id = ?`, id,
+	).Scan(&rem.ID, &rem.BaseURL, &rem.PeerID, &encrypted, &pullMedia); err != nil {
+		return nil, err
+	}
+	secret, err := crypto.DecryptCookie(encrypted, h.CookieSecret)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt remote secret: %w", err)
+	}
+	rem.Secret = secret
+	rem.PullMedia = pullMedia == 1
+	return &rem, nil
+}
+
+// peerGet performs a signed GET against a remote's federation API and decodes the JSON body.
+func (h *Handler) peerGet(ctx context.Context, rem *remote, path string, query url.Values, out interface{}) error {
+	target := rem.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	SignRequest(req, rem.PeerID, rem.Secret)
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote returned %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(out)
+}
+
+// SyncLoop periodically pulls subscribed clips from every active remote.
+func (h *Handler) SyncLoop() {
+	interval := h.SyncInterval
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.syncAll()
+	}
+}
+
+func (h *Handler) syncAll() {
+	ctx := context.Background()
+	rows, err := h.DB.QueryContext(ctx, `SELECT id FROM federation_remotes WHERE is_active = 1`)
+	if err != nil {
+		log.Printf("federation sync: list remotes: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		rem, err := h.loadRemote(ctx, id)
+		if err != nil {
+			log.Printf("federation sync: load remote %s: %v", id, err)
+			continue
+		}
+		if n, err := h.syncRemote(ctx, rem); err != nil {
+			log.Printf("federation sync: remote %s: %v", rem.BaseURL, err)
+		} else if n > 0 {
+			log.Printf("federation sync: %d clips from %s", n, rem.BaseURL)
+		}
+	}
+}
+
+// syncCursor is where a subscription's sync continues: the created_at and
+// ID of the last clip stored, kept in sync_cursor as "created_at|id".
+// Cursors from before IDs were kept hold only the created_at.
+type syncCursor struct {
+	CreatedAt, ID string
+}
+
+func parseSyncCursor(s string) syncCursor {
+	createdAt, id, _ := strings.Cut(s, "|")
+	return syncCursor{CreatedAt: createdAt, ID: id}
+}
+
+func (c syncCursor) String() string {
+	if c.ID == "" {
+		return c.CreatedAt
+	}
+	return c.CreatedAt + "|" + c.ID
+}
+
+// syncRemote pulls new clips for each of a remote's subscriptions, advancing
+// each subscription's cursor, and records the outcome on the remote row. A
+// page is ordered by created_at and ID, so the cursor stops short of the
+// first clip that fails to store, and that clip is retried on the next sync.
+// An invalid clip would fail every time, so it is skipped and reported in
+// the remote's last_error instead.
+func (h *Handler) syncRemote(ctx context.Context, rem *remote) (int, error) {
+	type subscription struct {
+		id, kind, ref, cursor string
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, kind, ref, COALESCE(sync_cursor, '') FROM federation_subscriptions WHERE remote_id = ?`, rem.ID)
+	if err != nil {
+		return 0, err
+	}
+	var subs []subscription
+	for rows.Next() {
+		var s subscription
+		if rows.Scan(&s.id, &s.kind, &s.ref, &s.cursor) == nil {
+			subs = append(subs, s)
+		}
+	}
+	rows.Close()
+
+	synced := 0
+	var syncErr error
+	for _, s := range subs {
+		q := url.Values{}
+		q.Set(s.kind, s.ref)
+		cursor := parseSyncCursor(s.cursor)
+		if cursor.CreatedAt != "" {
+			q.Set("since", cursor.CreatedAt)
+		}
+		if cursor.ID != "" {
+			q.Set("since_id", cursor.ID)
+		}
+		if rem.PullMedia {
+			q.Set("media", "1")
+		}
+		var page struct {
+			Clips []remoteClip `json:"clips"`
+		}
+		if err := h.peerGet(ctx, rem, "/api/federation/v1/clips", q, &page); err != nil {
+			syncErr = fmt.Errorf("%s %s: %w", s.kind, s.ref, err)
+			continue
+		}
+
+		for _, c := range page.Clips {
+			if err := c.validate(); err != nil {
+				log.Printf("federation sync: skip clip %q from %s: %v", c.ID, rem.BaseURL, err)
+				syncErr = fmt.Errorf("%s %s: skipped clip %q: %w", s.kind, s.ref, c.ID, err)
+				continue
+			}
+			if err := h.storeRemoteClip(ctx, rem, c); err != nil {
+				log.Printf("federation sync: store clip %s from %s: %v", c.ID, rem.BaseURL, err)
+				syncErr = fmt.Errorf("%s %s: store clip %s: %w", s.kind, s.ref, c.ID, err)
+				break
+			}
+			synced++
+			cursor = syncCursor{CreatedAt: c.CreatedAt, ID: c.ID}
+		}
+		if next := cursor.String(); next != s.cursor {
+			h.DB.ExecContext(ctx, `UPDATE federation_subscriptions SET sync_cursor = ? WHERE id = ?`, next, s.id)
+		}
+	}
+
+	var lastError interface{}
+	if syncErr != nil {
+		lastError = syncErr.Error()
+	}
+	h.DB.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE federation_remotes SET last_synced_at = %s, last_error = ? WHERE id = ?`, h.DB.NowUTC()),
+		lastError, rem.ID)
+	return synced, syncErr
+}
+
+func (h *Handler) storeRemoteClip(ctx context.Context, rem *remote, c remoteClip) error {
+	topicsJSON, _ := json.Marshal(c.Topics)
+	thumbURL := c.ThumbnailURL
+	if strings.HasPrefix(thumbURL, "/") {
+		thumbURL = rem.BaseURL + thumbURL
+	}
+
+	var storageKey interface{}
+	if rem.PullMedia && c.MediaURL != "" && h.Minio != nil {
+		key, err := h.pullMedia(ctx, rem, c)
+		if ctx.Err() != nil {
+			// A cancelled sync stops here, so the clip is retried with its
+			// media rather than stored without it.
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("federation sync: pull media for %s: %v", c.ID, err)
+		} else {
+			storageKey = key
+		}
+	}
+
+	_, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO federated_clips
+			(remote_id, remote_clip_id, title, description, duration_seconds, thumbnail_url, topics,
+			 channel_name, platform, source_url, storage_key, remote_created_at, synced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, %s)
+		ON CONFLICT (remote_id, remote_clip_id) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			duration_seconds = EXCLUDED.duration_seconds,
+			thumbnail_url = EXCLUDED.thumbnail_url,
+			topics = EXCLUDED.topics,
+			channel_name = EXCLUDED.channel_name,
+			platform = EXCLUDED.platform,
+			source_url = EXCLUDED.source_url,
+			storage_key = COALESCE(EXCLUDED.storage_key, federated_clips.storage_key),
+			synced_at = EXCLUDED.synced_at`, h.DB.NowUTC()),
+		rem.ID, c.ID, c.Title, c.Description, c.DurationSeconds, thumbURL, string(topicsJSON),
+		c.ChannelName, c.Platform, c.SourceURL, storageKey, c.CreatedAt)
+	return err
+}
+
+// sameOrigin reports whether u has the scheme and host of base.
+func sameOrigin(u, base *url.URL) bool {
+	return strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
+
+// pullMedia copies a remote clip's media into local storage under federated/.
+// The media must be served from the remote's own origin, so a peer cannot
+// point this instance at other hosts, such as internal services.
+func (h *Handler) pullMedia(ctx context.Context, rem *remote, c remoteClip) (string, error) {
+	mediaURL := c.MediaURL
+	if strings.HasPrefix(mediaURL, "/") && !strings.HasPrefix(mediaURL, "//") {
+		mediaURL = rem.BaseURL + mediaURL
+	}
+	base, err := url.Parse(rem.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote base url: %w", err)
+	}
+	target, err := url.Parse(mediaURL)
+	if err != nil || !sameOrigin(target, base) {
+		return "", fmt.Errorf("media url is not on the remote's origin %s://%s", base.Scheme, base.Host)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !sameOrigin(req.URL, base) {
+				return fmt.Errorf("media redirected off the remote's origin to %s", req.URL.Host)
+			}
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("media fetch returned %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxPulledMediaBytes {
+		return "", fmt.Errorf("media too large (%d bytes)", resp.ContentLength)
+	}
+
+	key := fmt.Sprintf("federated/%s/%s.mp4", rem.ID, url.PathEscape(c.ID))
+	_, err = h.Minio.PutObject(ctx, h.MinioBucket, key,
+		httputil.CappedReader(resp.Body, maxPulledMediaBytes), resp.ContentLength,
+		minio.PutObjectOptions{ContentType: "video/mp4"})
+	if err != nil {
+		// Do not leave a partial upload behind, even if ctx is done.
+		h.Minio.RemoveObject(context.WithoutCancel(ctx), h.MinioBucket, key, minio.RemoveObjectOptions{})
+		return "", fmt.Errorf("store media: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,93 @@
+package federation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestSyncRemote_SkipsInvalidClipsAndStopsAtFailedStore(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The second clip has no ID, so it can never be stored.
+		json.NewEncoder(w).Encode(map[string]interface{}{"clips": []remoteClip{
+			{ID: "r1", Title: "First", CreatedAt: "2026-01-01T00:00:01Z"},
+			{Title: "Broken", CreatedAt: "2026-01-01T00:00:02Z"},
+			{ID: "r3", Title: "Third", CreatedAt: "2026-01-01T00:00:03Z"},
+			{ID: "r4", Title: "Fourth", CreatedAt: "2026-01-01T00:00:04Z"},
+			{ID: "r5", Title: "Fifth", CreatedAt: "2026-01-01T00:00:05Z"},
+		}})
+	}))
+	defer peer.Close()
+
+	cdb := newTestDB(t)
+	for _, stmt := range []string{
+		`INSERT INTO federation_remotes (id, name, base_url, peer_id, secret) VALUES ('rem1', 'Peer', 'http://peer', 'p', 'x')`,
+		`INSERT INTO federation_subscriptions (id, remote_id, kind, ref) VALUES ('sub1', 'rem1', 'topic', 'go')`,
+		// Storing r4 fails as a database error would.
+		`CREATE TRIGGER fail_r4 BEFORE INSERT ON federated_clips WHEN NEW.remote_clip_id = 'r4'
+			BEGIN SELECT RAISE(ABORT, 'database unavailable'); END`,
+	} {
+		if _, err := cdb.Exec(stmt); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	h := &Handler{DB: cdb}
+	n, err := h.syncRemote(context.Background(), &remote{ID: "rem1", BaseURL: peer.URL, PeerID: "p", Secret: "s"})
+	if err == nil || n != 2 {
+		t.Fatalf("syncRemote = %d, %v; want r1 and r3 and an error", n, err)
+	}
+	var cursor, lastError string
+	cdb.QueryRow(`SELECT COALESCE(sync_cursor, '') FROM federation_subscriptions WHERE id = 'sub1'`).Scan(&cursor)
+	if cursor != "2026-01-01T00:00:03Z|r3" {
+		t.Errorf("cursor = %q, want r3, past the invalid clip and short of the failed one", cursor)
+	}
+	cdb.QueryRow(`SELECT COALESCE(last_error, '') FROM federation_remotes WHERE id = 'rem1'`).Scan(&lastError)
+	if lastError == "" {
+		t.Error("last_error not recorded")
+	}
+}
+
+func TestPullMedia_RefusesOtherOrigins(t *testing.T) {
+	hit := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer internal.Close()
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/secret", http.StatusFound)
+	}))
+	defer peer.Close()
+
+	h := &Handler{DB: newTestDB(t)}
+	rem := &remote{ID: "rem1", BaseURL: peer.URL}
+	for _, mediaURL := range []string{internal.URL + "/secret", "//" + internal.Listener.Addr().String() + "/secret", "/storage/redirect.mp4"} {
+		if _, err := h.pullMedia(context.Background(), rem, remoteClip{ID: "r1", MediaURL: mediaURL}); err == nil {
+			t.Errorf("pullMedia(%q) succeeded", mediaURL)
+		}
+	}
+	if hit {
+		t.Error("media fetched from a host other than the remote")
+	}
+}
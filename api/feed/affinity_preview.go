@@ -0,0 +1,174 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+)
+
+const (
+	// maxPreviewChanges caps the affinity changes one preview applies.
+	maxPreviewChanges = 50
+	// maxPreviewWeight is the highest affinity a preview accepts, the same
+	// bound settings bundle imports enforce.
+	maxPreviewWeight = 10
+)
+
+// affinityOverridesKey carries hypothetical topic affinities, by topic ID,
+// through ranking.
+type affinityOverridesKey struct{}
+
+// withAffinityOverrides returns ctx with overrides ranked in place of the
+// user's stored affinities for those topics.
+func withAffinityOverrides(ctx context.Context, overrides map[string]float64) context.Context {
+	return context.WithValue(ctx, affinityOverridesKey{}, overrides)
+}
+
+func affinityOverrides(ctx context.Context) map[string]float64 {
+	o, _ := ctx.Value(affinityOverridesKey{}).(map[string]float64)
+	return o
+}
+
+// affinityChange is one hypothetical topic affinity in a preview.
+type affinityChange struct {
+	Topic   string  `json:"topic"`
+	Weight  float64 `json:"weight"`
+	TopicID string  `json:"topic_id"`
+	Label   string  `json:"label"`
+	Before  float64 `json:"before"`
+}
+
+// resolveAffinityChanges looks up each change's topic by ID, slug, or
+// name, and the user's current affinity for it, 1 when they have none.
+func (h *Handler) resolveAffinityChanges(ctx context.Context, userID string, changes []affinityChange) error {
+	seen := make(map[string]bool, len(changes))
+	for i := range changes {
+		c := &changes[i]
+		if c.Topic == "" {
+			return fmt.Errorf("changes[%d]: topic is required", i)
+		}
+		if c.Weight < 0 || c.Weight > maxPreviewWeight {
+			return fmt.Errorf("changes[%d]: weight must be between 0 and %d", i, maxPreviewWeight)
+		}
+		err := h.DB.QueryRowContext(ctx, `
+			SELECT id, name FROM topics WHERE id = ? OR slug = ? OR LOWER(name) = LOWER(?)
+			ORDER BY id = ? DESC, slug = ? DESC LIMIT 1
+		`, c.Topic, c.Topic, c.Topic, c.Topic, c.Topic).Scan(&c.TopicID, &c.Label)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("changes[%d]: unknown topic %q", i, c.Topic)
+		}
+		if err != nil {
+			return err
+		}
+		if seen[c.TopicID] {
+			return fmt.Errorf("changes[%d]: topic %q is changed twice", i, c.Topic)
+		}
+		seen[c.TopicID] = true
+		c.Before = 1.0
+		err = h.DB.QueryRowContext(ctx,
+			`SELECT weight FROM user_topic_affinities WHERE user_id = ? AND topic_id = ?`, userID, c.TopicID).Scan(&c.Before)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandlePreviewAffinities shows how the user's current feed candidates
+// would re-rank under hypothetical topic affinities, without saving them.
+// Both orders come from the built-in ranking pipeline, run in full on the
+// same candidates, so only the changes move clips. Every clip in the top
+// limit of either order is listed in its new order, with its 1-based
+// position before and after and how many places it moved up.
+func (h *Handler) HandlePreviewAffinities(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	ctx := r.Context()
+	var req struct {
+		Changes []affinityChange `json:"changes"`
+		Limit   int              `json:"limit"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if len(req.Changes) == 0 || len(req.Changes) > maxPreviewChanges {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("changes must list 1-%d topic affinities", maxPreviewChanges)})
+		return
+	}
+	if req.Limit <= 0 || req.Limit > 50 {
+		req.Limit = FeedLimit
+	}
+	if err := h.resolveAffinityChanges(ctx, userID, req.Changes); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	topicWeights, dedupeSeen24h, feedPrefs := h.loadFeedPrefs(ctx, userID)
+	fetchLimit := req.Limit * 3
+	candidates := h.precomputedCandidates(ctx, userID, dedupeSeen24h)
+	if len(candidates) < req.Limit || feedPrefs.Chronological {
+		rows, err := h.queryPersonalCandidates(ctx, userID, feedPrefs, fetchLimit)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load feed candidates"})
+			return
+		}
+		candidates = httputil.ScanClips(rows)
+		rows.Close()
+	}
+	if len(candidates) > fetchLimit {
+		candidates = candidates[:fetchLimit]
+	}
+
+	overrides := make(map[string]float64, len(req.Changes))
+	for _, c := range req.Changes {
+		overrides[c.TopicID] = c.Weight
+	}
+	rank := func(ctx context.Context) []map[string]interface{} {
+		clips := make([]map[string]interface{}, len(candidates))
+		for i, c := range candidates {
+			clips[i] = make(map[string]interface{}, len(c))
+			for k, v := range c {
+				clips[i][k] = v
+			}
+		}
+		if feedPrefs.Chronological {
+			sortChronological(clips)
+		} else {
+			h.rankWithin(ctx, clips, userID, topicWeights, feedPrefs, time.Time{})
+		}
+		stripRankingFields(clips)
+		return h.dropBlocked(ctx, userID, clips)
+	}
+	before := rank(ctx)
+	after := rank(withAffinityOverrides(ctx, overrides))
+
+	beforePos := make(map[string]int, len(before))
+	for i, c := range before {
+		beforePos[c["id"].(string)] = i + 1
+	}
+	listed := make([]map[string]interface{}, 0, req.Limit)
+	for i, c := range after {
+		id := c["id"].(string)
+		if i >= req.Limit && beforePos[id] > req.Limit {
+			continue
+		}
+		clip := map[string]interface{}{
+			"id": id, "title": c["title"], "channel_name": c["channel_name"], "topics": c["topics"],
+			"thumbnail_url": nil, "before": beforePos[id], "after": i + 1, "moved": beforePos[id] - (i + 1),
+		}
+		if key, ok := c["thumbnail_key"].(string); ok && key != "" {
+			clip["thumbnail_url"] = httputil.ThumbnailURL(h.MinioBucket, key)
+		}
+		listed = append(listed, clip)
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"changes": req.Changes, "clips": listed, "candidates": len(candidates), "limit": req.Limit,
+	})
+}
@@ -0,0 +1,229 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+)
+
+const (
+	maxAskLen = 300
+	// askTopicHints is how many of the busiest topics the prompt offers the
+	// model to pick from.
+	askTopicHints = 60
+	maxAskTerms   = 10
+	maxRecency    = 3650
+)
+
+var errNoFilter = errors.New("no filter in model output")
+
+// askPrompt asks the model to turn request into a FilterQuery.
+func askPrompt(request string, topics []string) string {
+	return fmt.Sprintf(`Turn a request for short videos into a JSON filter. Reply with one JSON object and nothing else:
+{"topics": {"include": [], "exclude": []}, "channels": [], "duration": {"min": 0, "max": 0}, "recency_days": 0, "min_rating": 0}
+- topics: pick only from this list: %s
+- channels: only channel names the request spells out
+- duration: seconds; 0 means no bound
+- recency_days: how many days back, e.g. "this week" is 7; 0 means any time
+- min_rating: lowest average star rating, 1-5, e.g. "highly rated" is 4; 0 means any rating
+Leave out anything the request does not ask for.
+
+Request: %s`, strings.Join(topics, ", "), request)
+}
+
+// askTopics lists the names of the topics with the most clips.
+func (h *Handler) askTopics() []string {
+	g := h.GetTopicGraph()
+	if g == nil {
+		return nil
+	}
+	nodes := make([]*TopicNode, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		if n.ClipCount > 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].ClipCount != nodes[j].ClipCount {
+			return nodes[i].ClipCount > nodes[j].ClipCount
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	if len(nodes) > askTopicHints {
+		nodes = nodes[:askTopicHints]
+	}
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = strings.ToLower(n.Name)
+	}
+	return names
+}
+
+// parseAskFilter reads the FilterQuery from the model's reply, which may
+// wrap the JSON object in prose or a code fence.
+func parseAskFilter(text string) (*FilterQuery, error) {
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, errNoFilter
+	}
+	var fq FilterQuery
+	if err := json.Unmarshal([]byte(text[start:end+1]), &fq); err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoFilter, err)
+	}
+	return &fq, nil
+}
+
+// validateAskFilter keeps the parts of a model-written filter the feed can
+// apply: known topics (matched with their subtopics), a sane duration and
+// recency, and no more than maxAskTerms topics or channels. It returns a
+// note for each part it dropped or changed.
+func (h *Handler) validateAskFilter(fq *FilterQuery) []string {
+	var notes []string
+	g := h.GetTopicGraph()
+	known := func(names []string, kind string) []string {
+		var kept []string
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if g.ResolveByName(name) == nil {
+				notes = append(notes, fmt.Sprintf("ignored unknown topic %q", name))
+				continue
+			}
+			if len(kept) == maxAskTerms {
+				notes = append(notes, fmt.Sprintf("kept the first %d %s topics", maxAskTerms, kind))
+				break
+			}
+			kept = append(kept, strings.ToLower(name))
+		}
+		return kept
+	}
+	if fq.Topics != nil {
+		fq.Topics.Include = known(fq.Topics.Include, "included")
+		fq.Topics.Exclude = known(fq.Topics.Exclude, "excluded")
+		fq.Topics.Mode = "descendants"
+		if len(fq.Topics.Include) == 0 && len(fq.Topics.Exclude) == 0 {
+			fq.Topics = nil
+		}
+	}
+
+	var channels []string
+	for _, ch := range fq.Channels {
+		if ch = strings.TrimSpace(ch); ch != "" && len(channels) < maxAskTerms {
+			channels = append(channels, ch)
+		}
+	}
+	fq.Channels = channels
+
+	if d := fq.Duration; d != nil {
+		if d.Min < 0 || d.Max < 0 {
+			notes = append(notes, "ignored a negative duration")
+			d.Min, d.Max = max(d.Min, 0), max(d.Max, 0)
+		}
+		if d.Max > 0 && d.Min > d.Max {
+			d.Min, d.Max = d.Max, d.Min
+			notes = append(notes, "swapped the duration bounds")
+		}
+		if d.Min == 0 && d.Max == 0 {
+			fq.Duration = nil
+		}
+	}
+	if fq.RecencyDays < 0 || fq.RecencyDays > maxRecency {
+		notes = append(notes, fmt.Sprintf("ignored recency_days %d", fq.RecencyDays))
+		fq.RecencyDays = 0
+	}
+	if fq.MinScore < 0 || fq.MinScore > 1 {
+		fq.MinScore = 0
+	}
+	if fq.MinRating < 0 || fq.MinRating > 5 {
+		notes = append(notes, fmt.Sprintf("ignored min_rating %g", fq.MinRating))
+		fq.MinRating = 0
+	}
+	// Similarity search is not something a request can ask for by name.
+	fq.SimilarToClip = ""
+	return notes
+}
+
+// emptyFilter reports whether fq selects every clip.
+func emptyFilter(fq *FilterQuery) bool {
+	return fq.Topics == nil && len(fq.Channels) == 0 && fq.Duration == nil && fq.RecencyDays == 0 && fq.MinScore == 0 && fq.MinRating == 0
+}
+
+// HandleAsk serves a feed for a request in plain language, such as "relaxing
+// woodworking videos under 2 minutes from this week". The LLM turns it into
+// a FilterQuery, which is validated, echoed back as filter, and applied like
+// an unranked saved filter.
+func (h *Handler) HandleAsk(w http.ResponseWriter, r *http.Request) {
+	if h.LLM == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "no language model is configured"})
+		return
+	}
+	var req struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" || utf8.RuneCountInString(req.Query) > maxAskLen {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("query must be 1-%d characters", maxAskLen)})
+		return
+	}
+
+	prompt := askPrompt(req.Query, h.askTopics())
+	start := time.Now()
+	text, model, err := h.LLM(r.Context(), prompt)
+	// Log the call even when the request ran out of time.
+	h.DB.ExecContext(context.WithoutCancel(r.Context()),
+		`INSERT INTO llm_logs (system, model, prompt, response, duration_ms) VALUES (?, ?, ?, ?, ?)`,
+		"feed_ask", model, prompt, text, time.Since(start).Milliseconds())
+	if err != nil {
+		log.Printf("feed ask: %v", err)
+		httputil.WriteJSON(w, 502, map[string]string{"error": "the language model is unavailable"})
+		return
+	}
+	fq, err := parseAskFilter(text)
+	if err != nil {
+		log.Printf("feed ask: %v", err)
+		httputil.WriteJSON(w, 422, map[string]string{"error": "could not turn the request into a filter; try rephrasing it"})
+		return
+	}
+	notes := h.validateAskFilter(fq)
+	if emptyFilter(fq) {
+		httputil.WriteJSON(w, 422, map[string]interface{}{
+			"error":  "the request did not name anything to filter by; try a topic, channel, length, or time",
+			"filter": fq, "notes": notes,
+		})
+		return
+	}
+
+	userID, _ := auth.ExtractUserID(r)
+	_, dedupeSeen24h, _ := h.loadFeedPrefs(r.Context(), userID)
+	warmup := streamWarmupCount(r)
+	if warmup > 0 && h.streamLimited(r.Context(), userID) {
+		warmup = 0
+	}
+	clips, err := h.filteredClips(r, fq, userID, dedupeSeen24h, httputil.RequestedClipFields(r), warmup)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
+		return
+	}
+	if notes == nil {
+		notes = []string{}
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"clips": clips, "count": len(clips), "query": req.Query, "filter": fq, "notes": notes, "model": model,
+	})
+}
@@ -0,0 +1,339 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+// banditCandidateTopics caps how many never-tried topics are considered as
+// arms alongside those the user already has posteriors for.
+const banditCandidateTopics = 200
+
+// banditProbesPerSlot caps how many arms exploreClips queries for each clip
+// it is asked for, since every arm probed costs a query and most candidate
+// arms may have nothing left to show.
+const banditProbesPerSlot = 3
+
+// topicArm is a Beta(alpha, beta) posterior over the probability that a clip
+// from this topic converts to engagement for the user.
+type topicArm struct {
+	TopicID   string
+	Name      string
+	Alpha     float64
+	Beta      float64
+	Explored  int
+	Converted int
+}
+
+// sampleGamma draws from Gamma(shape, 1) using Marsaglia and Tsang's method.
+func sampleGamma(rng *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(rng, shape+1) * math.Pow(rng.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3.0
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// sampleBeta draws from Beta(a, b).
+func sampleBeta(rng *rand.Rand, a, b float64) float64 {
+	x := sampleGamma(rng, a)
+	y := sampleGamma(rng, b)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// thompsonOrder samples each arm's posterior and returns arms sorted by
+// their sampled conversion probability, highest first.
+func thompsonOrder(rng *rand.Rand, arms []topicArm) []topicArm {
+	sampled := make([]float64, len(arms))
+	idx := make([]int, len(arms))
+	for i, a := range arms {
+		sampled[i] = sampleBeta(rng, a.Alpha, a.Beta)
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return sampled[idx[i]] > sampled[idx[j]] })
+	out := make([]topicArm, len(arms))
+	for i, j := range idx {
+		out[i] = arms[j]
+	}
+	return out
+}
+
+// loadTopicArms returns the user's existing arms plus uniform-prior arms for
+// populated topics they have not been exposed to yet.
+func (h *Handler) loadTopicArms(ctx context.Context, userID string) []topicArm {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT t.id, t.name,
+		       COALESCE(a.alpha, 1.0), COALESCE(a.beta, 1.0),
+		       COALESCE(a.explored, 0), COALESCE(a.converted, 0)
+		FROM topics t
+		LEFT JOIN user_topic_arms a ON a.topic_id = t.id AND a.user_id = ?
+		WHERE t.clip_count > 0
+		ORDER BY (a.topic_id IS NOT NULL) DESC, t.clip_count DESC
+		LIMIT ?
+	`, userID, banditCandidateTopics)
+	if err != nil {
+		log.Printf("loadTopicArms: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var arms []topicArm
+	for rows.Next() {
+		var a topicArm
+		if err := rows.Scan(&a.TopicID, &a.Name, &a.Alpha, &a.Beta, &a.Explored, &a.Converted); err == nil {
+			arms = append(arms, a)
+		}
+	}
+	return arms
+}
+
+// exploreClips picks up to n exploration clips by Thompson sampling over the
+// user's topic arms, one clip per winning arm, and records the impressions so
+// later interactions can be credited back to the arm. Only the top
+// banditProbesPerSlot*n arms are probed, so a page may get fewer than n.
+func (h *Handler) exploreClips(ctx context.Context, userID string, n int, exclude map[string]bool) []map[string]interface{} {
+	if n <= 0 {
+		return nil
+	}
+	arms := thompsonOrder(rand.New(rand.NewSource(time.Now().UnixNano())), h.loadTopicArms(ctx, userID))
+	if len(arms) > banditProbesPerSlot*n {
+		arms = arms[:banditProbesPerSlot*n]
+	}
+
+	var picked []map[string]interface{}
+	for _, arm := range arms {
+		if len(picked) >= n {
+			break
+		}
+		rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+			WITH prefs AS (
+				SELECT min_clip_seconds, max_clip_seconds FROM user_preferences WHERE user_id = ?
+			)
+			SELECT c.id, c.title, c.description, c.duration_seconds,
+			       c.thumbnail_key, c.topics, c.tags, c.content_score,
+			       c.created_at, s.channel_name, s.platform, s.url,
+			       COALESCE(c.source_id, ''),
+			       CAST(c.transcript_length AS REAL),
+			       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+			       COALESCE(%s, 0)
+			FROM clip_topics ct
+			JOIN clips c ON ct.clip_id = c.id
+			LEFT JOIN sources s ON c.source_id = s.id
+			WHERE ct.topic_id = ? AND c.status = 'ready'
+			  AND c.id NOT IN (SELECT clip_id FROM interactions WHERE user_id = ? AND created_at > %s)
+			  AND c.duration_seconds >= COALESCE((SELECT min_clip_seconds FROM prefs), 5)
+			  AND c.duration_seconds <= COALESCE((SELECT max_clip_seconds FROM prefs), 120)
+			  %s
+			ORDER BY c.content_score DESC
+			LIMIT 5
+		`, h.DB.AgeHoursExpr("c.created_at"), h.DB.DatetimeModifier("-24 hours"), h.snoozeFilter()), userID, arm.TopicID, userID, userID, userID)
+		if err != nil {
+			log.Printf("exploreClips: %v", err)
+			return picked
+		}
+		candidates := httputil.ScanClips(rows)
+		rows.Close()
+
+		for _, c := range candidates {
+			id, _ := c["id"].(string)
+			if exclude[id] {
+				continue
+			}
+			exclude[id] = true
+			c["_explore_topic"] = arm.TopicID
+			c["exploration"] = map[string]interface{}{"strategy": "thompson_sampling", "topic": arm.Name}
+			picked = append(picked, c)
+			break
+		}
+	}
+
+	for _, c := range picked {
+		if !h.Maintenance.Active() {
+			h.recordBanditImpression(ctx, userID, c["id"].(string), c["_explore_topic"].(string))
+		}
+		delete(c, "_explore_topic")
+	}
+	return picked
+}
+
+func (h *Handler) recordBanditImpression(ctx context.Context, userID, clipID, topicID string) {
+	res, err := h.DB.ExecContext(ctx, `
+		INSERT INTO bandit_impressions (user_id, clip_id, topic_id) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, clip_id) DO NOTHING
+	`, userID, clipID, topicID)
+	if err != nil {
+		log.Printf("recordBanditImpression: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	h.DB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO user_topic_arms (user_id, topic_id, explored) VALUES (?, ?, 1)
+		ON CONFLICT(user_id, topic_id) DO UPDATE SET
+			explored = user_topic_arms.explored + 1, updated_at = %s
+	`, h.DB.NowUTC()), userID, topicID)
+}
+
+// interleaveExploration spreads explore clips evenly through ranked clips.
+func interleaveExploration(ranked, explore []map[string]interface{}) []map[string]interface{} {
+	if len(explore) == 0 {
+		return ranked
+	}
+	out := make([]map[string]interface{}, 0, len(ranked)+len(explore))
+	step := (len(ranked) + len(explore)) / len(explore)
+	e := 0
+	for _, c := range ranked {
+		if e < len(explore) && len(out)%step == step-1 {
+			out = append(out, explore[e])
+			e++
+		}
+		out = append(out, c)
+	}
+	return append(out, explore[e:]...)
+}
+
+// banditReward classifies an interaction as a conversion (+1), a rejection
+// (-1), or neutral (0) for arm updates.
+func banditReward(action string, watchPercentage float64) int {
+	switch action {
+	case "like", "save", "share", "watch_full":
+		return 1
+	case "dislike", "skip":
+		return -1
+	case "view":
+		if watchPercentage >= 0.5 {
+			return 1
+		}
+		if watchPercentage > 0 && watchPercentage < 0.2 {
+			return -1
+		}
+	}
+	return 0
+}
+
+// RecordBanditOutcome updates the user's topic arm posteriors for the clip's
+// topics and resolves any pending exploration impression for the clip.
+func RecordBanditOutcome(ctx context.Context, cdb *db.CompatDB, userID, clipID, action string, watchPercentage float64) {
+	reward := banditReward(action, watchPercentage)
+	if userID == "" || reward == 0 {
+		return
+	}
+	alphaInc, betaInc, outcome := 0.0, 1.0, "rejected"
+	if reward > 0 {
+		alphaInc, betaInc, outcome = 1.0, 0.0, "converted"
+	}
+
+	if _, err := cdb.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO user_topic_arms (user_id, topic_id, alpha, beta)
+		SELECT ?, topic_id, 1.0 + ?, 1.0 + ? FROM clip_topics WHERE clip_id = ?
+		ON CONFLICT(user_id, topic_id) DO UPDATE SET
+			alpha = user_topic_arms.alpha + excluded.alpha - 1.0,
+			beta  = user_topic_arms.beta + excluded.beta - 1.0,
+			updated_at = %s
+	`, cdb.NowUTC()), userID, alphaInc, betaInc, clipID); err != nil {
+		log.Printf("RecordBanditOutcome: arms: %v", err)
+		return
+	}
+
+	var topicID string
+	if err := cdb.QueryRowContext(ctx, `
+		UPDATE bandit_impressions SET outcome = ?
+		WHERE user_id = ? AND clip_id = ? AND outcome IS NULL
+		RETURNING topic_id
+	`, outcome, userID, clipID).Scan(&topicID); err != nil {
+		return
+	}
+	if reward > 0 {
+		cdb.ExecContext(ctx,
+			`UPDATE user_topic_arms SET converted = converted + 1 WHERE user_id = ? AND topic_id = ?`,
+			userID, topicID)
+	}
+}
+
+// HandleFeedExplain reports how the user's feed is assembled, including the
+// exploration bandit's per-topic posteriors.
+func (h *Handler) HandleFeedExplain(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	_, _, feedPrefs := h.loadFeedPrefs(r.Context(), userID)
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT a.topic_id, t.name, a.alpha, a.beta, a.explored, a.converted, a.updated_at
+		FROM user_topic_arms a
+		JOIN topics t ON a.topic_id = t.id
+		WHERE a.user_id = ?
+		ORDER BY a.alpha / (a.alpha + a.beta) DESC, a.explored DESC
+		LIMIT 100
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load exploration stats"})
+		return
+	}
+	defer rows.Close()
+
+	arms := make([]map[string]interface{}, 0)
+	var totalExplored, totalConverted int
+	for rows.Next() {
+		var a topicArm
+		var updatedAt string
+		if err := rows.Scan(&a.TopicID, &a.Name, &a.Alpha, &a.Beta, &a.Explored, &a.Converted, &updatedAt); err != nil {
+			continue
+		}
+		totalExplored += a.Explored
+		totalConverted += a.Converted
+		var conversionRate float64
+		if a.Explored > 0 {
+			conversionRate = float64(a.Converted) / float64(a.Explored)
+		}
+		arms = append(arms, map[string]interface{}{
+			"topic_id": a.TopicID, "topic": a.Name,
+			"alpha": a.Alpha, "beta": a.Beta,
+			"expected_conversion": a.Alpha / (a.Alpha + a.Beta),
+			"explored":            a.Explored,
+			"converted":           a.Converted,
+			"conversion_rate":     conversionRate,
+			"updated_at":          updatedAt,
+		})
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"exploration": map[string]interface{}{
+			"strategy":         "thompson_sampling",
+			"exploration_rate": feedPrefs.ExplorationRate,
+			"explored":         totalExplored,
+			"converted":        totalConverted,
+			"arms":             arms,
+		},
+		"ranking": map[string]interface{}{
+			"ltr_model":      h.GetLTRModel() != nil,
+			"diversity_mix":  feedPrefs.DiversityMix,
+			"trending_boost": feedPrefs.TrendingBoost,
+			"freshness_bias": feedPrefs.FreshnessBias,
+		},
+	})
+}
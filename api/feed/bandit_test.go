@@ -0,0 +1,72 @@
+package feed
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSampleBeta_Mean(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 20000
+	var sum float64
+	for i := 0; i < n; i++ {
+		v := sampleBeta(rng, 8, 2)
+		if v < 0 || v > 1 {
+			t.Fatalf("sample %v out of [0,1]", v)
+		}
+		sum += v
+	}
+	if mean := sum / n; math.Abs(mean-0.8) > 0.01 {
+		t.Errorf("mean = %.3f, want ~0.8", mean)
+	}
+}
+
+func TestThompsonOrder_PrefersConvertingArm(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	arms := []topicArm{
+		{TopicID: "cold", Alpha: 1, Beta: 30},
+		{TopicID: "hot", Alpha: 30, Beta: 1},
+	}
+	wins := 0
+	for i := 0; i < 100; i++ {
+		if thompsonOrder(rng, arms)[0].TopicID == "hot" {
+			wins++
+		}
+	}
+	if wins < 95 {
+		t.Errorf("hot arm ranked first %d/100 times", wins)
+	}
+}
+
+func TestInterleaveExploration_SpreadsClips(t *testing.T) {
+	mk := func(id string) map[string]interface{} { return map[string]interface{}{"id": id} }
+	ranked := []map[string]interface{}{mk("r1"), mk("r2"), mk("r3"), mk("r4")}
+	explore := []map[string]interface{}{mk("e1"), mk("e2")}
+	out := interleaveExploration(ranked, explore)
+	var ids []string
+	for _, c := range out {
+		ids = append(ids, c["id"].(string))
+	}
+	want := []string{"r1", "r2", "e1", "r3", "r4", "e2"}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("order = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestBanditReward(t *testing.T) {
+	cases := []struct {
+		action string
+		pct    float64
+		want   int
+	}{
+		{"like", 0, 1}, {"skip", 0, -1}, {"view", 0.9, 1}, {"view", 0.1, -1}, {"view", 0, 0}, {"view", 0.3, 0},
+	}
+	for _, c := range cases {
+		if got := banditReward(c.action, c.pct); got != c.want {
+			t.Errorf("banditReward(%q, %v) = %d, want %d", c.action, c.pct, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package feed
+
+import (
+	"context"
+	"log"
+
+	"clipfeed/contentfilter"
+)
+
+// dropBlocked removes clips the user's content filters hide from them:
+// matches of their own filters and, for restricted profiles, of instance
+// filters. It runs on the final candidates so every feed path honours
+// filters added after the clips were ranked or precomputed.
+func (h *Handler) dropBlocked(ctx context.Context, userID string, clips []map[string]interface{}) []map[string]interface{} {
+	if userID == "" || len(clips) == 0 {
+		return clips
+	}
+	ids := make([]interface{}, 0, len(clips))
+	for _, c := range clips {
+		ids = append(ids, c["id"])
+	}
+	blocked, err := contentfilter.Blocked(ctx, h.DB, userID, ids)
+	if err != nil {
+		// Fail closed: a restricted profile must not see unchecked clips.
+		log.Printf("content filters for %s: %v", userID, err)
+		return clips[:0]
+	}
+	if len(blocked) == 0 {
+		return clips
+	}
+	kept := clips[:0]
+	for _, c := range clips {
+		if id, _ := c["id"].(string); !blocked[id] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
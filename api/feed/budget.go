@@ -0,0 +1,122 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"clipfeed/httputil"
+)
+
+// DefaultRankBudget is how long a feed request may spend before ranking
+// starts giving up stages.
+const DefaultRankBudget = 150 * time.Millisecond
+
+// Ranking levels, from the full pipeline down the degradation ladder.
+const (
+	levelFull         = iota // base ranker, trending, and diversity
+	levelNoDiversity         // diversity reranking skipped
+	levelNoTrending          // trending and diversity skipped
+	levelTopicBoost          // LTR abandoned for topic boosting only
+	levelContentScore        // no ranking, content_score order
+	numRankLevels
+)
+
+// rankLevelNames are the names levels are reported under.
+var rankLevelNames = [numRankLevels]string{"full", "no_diversity", "no_trending", "topic_boost", "content_score"}
+
+// rankWithin ranks clips like rankClips with the auto ranker, dropping
+// stages once deadline passes: diversity, then trending, then LTR for topic
+// boosting, and with no time left at all it only sorts by content_score.
+// Stages run with the deadline, so a slow one is cut short rather than
+// finished late. A zero deadline ranks fully. It returns the level served.
+func (h *Handler) rankWithin(ctx context.Context, clips []map[string]interface{}, userID string, topicWeights map[string]float64, fp FeedPrefs, deadline time.Time) int {
+	if len(clips) == 0 {
+		return levelFull
+	}
+	over := func() bool { return !deadline.IsZero() && !time.Now().Before(deadline) }
+	stageCtx := ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		stageCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	if over() {
+		sortByContentScore(clips)
+		return levelContentScore
+	}
+
+	model := h.GetLTRModel()
+	if model != nil && len(model.Trees) > 0 {
+		h.applyLTRRanking(stageCtx, clips, userID, model)
+		if over() {
+			// LTR features loaded after the deadline are missing, so its
+			// order cannot be trusted; topic boosting is much cheaper.
+			for _, clip := range clips {
+				delete(clip, "_l2r_score")
+			}
+			sortByContentScore(clips)
+			h.applyTopicBoost(ctx, clips, userID, topicWeights)
+			return levelTopicBoost
+		}
+	} else {
+		h.applyTopicBoost(stageCtx, clips, userID, topicWeights)
+	}
+	h.applyFeedbackWeights(stageCtx, clips, userID)
+
+	if over() {
+		return levelNoTrending
+	}
+	if fp.TrendingBoost {
+		h.applyTrendingBoost(stageCtx, clips)
+	}
+
+	if over() {
+		return levelNoDiversity
+	}
+	if fp.DiversityMix > 0 {
+		h.applyDiversityPenalty(clips, fp.DiversityMix)
+	}
+	return levelFull
+}
+
+func sortByContentScore(clips []map[string]interface{}) {
+	sort.SliceStable(clips, func(i, j int) bool {
+		si, _ := clips[i]["content_score"].(float64)
+		sj, _ := clips[j]["content_score"].(float64)
+		return si > sj
+	})
+}
+
+// recordRankLevel counts a feed served at level.
+func (h *Handler) recordRankLevel(level int) {
+	h.rankLevels[level].Add(1)
+}
+
+// HandleRankDegradation reports the feed latency budget and how many feeds
+// were served at each ranking level since the server started.
+func (h *Handler) HandleRankDegradation(w http.ResponseWriter, r *http.Request) {
+	counts := make(map[string]int64, numRankLevels)
+	var total, degraded int64
+	for level, name := range rankLevelNames {
+		n := h.rankLevels[level].Load()
+		counts[name] = n
+		total += n
+		if level != levelFull {
+			degraded += n
+		}
+	}
+	var rate float64
+	if total > 0 {
+		rate = float64(degraded) / float64(total)
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"budget_ms":     h.RankBudget.Milliseconds(),
+		"levels":        rankLevelNames,
+		"served":        counts,
+		"total":         total,
+		"degraded_rate": rate,
+	})
+}
@@ -0,0 +1,244 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	discoverDefaultLimit = 10
+	discoverMaxLimit     = 50
+)
+
+// discoverSections lists the discovery page sections in display order.
+var discoverSections = []string{"trending", "topics", "channels", "staff_picks"}
+
+// discoverClipColumns matches the column order expected by ScanClips.
+const discoverClipColumns = `
+	c.id, c.title, c.description, c.duration_seconds,
+	c.thumbnail_key, c.topics, c.tags, c.content_score,
+	c.created_at, s.channel_name, s.platform, s.url,
+	COALESCE(c.source_id, ''),
+	CAST(c.transcript_length AS REAL),
+	CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+	COALESCE(%s, 0)`
+
+// discoverTrending returns clips with the most interactions in the last day.
+func (h *Handler) discoverTrending(ctx context.Context, limit, offset int) ([]map[string]interface{}, error) {
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+discoverClipColumns+`
+		FROM (
+			SELECT clip_id, COUNT(*) AS n FROM interactions
+			WHERE created_at > %s
+			GROUP BY clip_id
+		) t
+		JOIN clips c ON t.clip_id = c.id
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready'
+		ORDER BY t.n DESC, c.content_score DESC, c.id
+		LIMIT ? OFFSET ?
+	`, h.DB.AgeHoursExpr("c.created_at"), h.DB.DatetimeModifier("-24 hours")), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clips := httputil.ScanClips(rows)
+	stripRankingFields(clips)
+	thumbnails := h.selectThumbnails(ctx, clips)
+	httputil.AddThumbnailURLs(clips, h.MinioBucket)
+	addThumbnailVariants(clips, thumbnails)
+	httputil.AddAttributions(ctx, h.DB, clips)
+	return clips, nil
+}
+
+// discoverTopics returns topics ranked by interactions on their clips over
+// the last week.
+func (h *Handler) discoverTopics(ctx context.Context, limit, offset int) ([]map[string]interface{}, error) {
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.id, t.name, t.slug, t.clip_count, COUNT(i.id) AS weekly
+		FROM topics t
+		JOIN clip_topics ct ON ct.topic_id = t.id
+		JOIN interactions i ON i.clip_id = ct.clip_id AND i.created_at > %s
+		GROUP BY t.id, t.name, t.slug, t.clip_count
+		ORDER BY weekly DESC, t.clip_count DESC, t.name
+		LIMIT ? OFFSET ?
+	`, h.DB.DatetimeModifier("-7 days")), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, slug string
+		var clipCount, weekly int
+		if rows.Scan(&id, &name, &slug, &clipCount, &weekly) != nil {
+			continue
+		}
+		items = append(items, map[string]interface{}{
+			"id": id, "name": name, "slug": slug,
+			"clip_count": clipCount, "interactions_7d": weekly,
+		})
+	}
+	return items, nil
+}
+
+// discoverChannels returns channels whose first ready clip arrived most
+// recently.
+func (h *Handler) discoverChannels(ctx context.Context, limit, offset int) ([]map[string]interface{}, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT s.channel_name, s.platform, MIN(c.created_at) AS first_seen, COUNT(c.id)
+		FROM clips c
+		JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready' AND s.channel_name IS NOT NULL AND s.channel_name != ''
+		GROUP BY s.channel_name, s.platform
+		ORDER BY first_seen DESC, s.channel_name
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var channel, platform, firstSeen string
+		var clipCount int
+		if rows.Scan(&channel, &platform, &firstSeen, &clipCount) != nil {
+			continue
+		}
+		items = append(items, map[string]interface{}{
+			"channel_name": channel, "platform": platform,
+			"first_seen": firstSeen, "clip_count": clipCount,
+		})
+	}
+	return items, nil
+}
+
+// discoverStaffPicks returns editorially picked clips in curated order.
+func (h *Handler) discoverStaffPicks(ctx context.Context, limit, offset int) ([]map[string]interface{}, error) {
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+discoverClipColumns+`
+		FROM staff_picks sp
+		JOIN clips c ON sp.clip_id = c.id
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready'
+		ORDER BY sp.position ASC, sp.picked_at DESC
+		LIMIT ? OFFSET ?
+	`, h.DB.AgeHoursExpr("c.created_at")), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clips := httputil.ScanClips(rows)
+	stripRankingFields(clips)
+
+	notes := make(map[string]*string, len(clips))
+	noteRows, err := h.DB.QueryContext(ctx, `SELECT clip_id, note FROM staff_picks`)
+	if err == nil {
+		for noteRows.Next() {
+			var id string
+			var note *string
+			if noteRows.Scan(&id, &note) == nil {
+				notes[id] = note
+			}
+		}
+		noteRows.Close()
+	}
+	for _, c := range clips {
+		c["staff_note"] = notes[c["id"].(string)]
+	}
+	httputil.AddThumbnailURLs(clips, h.MinioBucket)
+	httputil.AddAttributions(ctx, h.DB, clips)
+	return clips, nil
+}
+
+// discoverSection loads one page of a section. It fetches one extra row to
+// tell whether another page exists.
+func (h *Handler) discoverSection(ctx context.Context, section string, limit, offset int) (map[string]interface{}, error) {
+	var load func(context.Context, int, int) ([]map[string]interface{}, error)
+	switch section {
+	case "trending":
+		load = h.discoverTrending
+	case "topics":
+		load = h.discoverTopics
+	case "channels":
+		load = h.discoverChannels
+	case "staff_picks":
+		load = h.discoverStaffPicks
+	default:
+		return nil, fmt.Errorf("unknown section %q", section)
+	}
+
+	items, err := load(ctx, limit+1, offset)
+	if err != nil {
+		return nil, err
+	}
+	var nextOffset interface{}
+	if len(items) > limit {
+		items = items[:limit]
+		nextOffset = offset + limit
+	}
+	if items == nil {
+		items = make([]map[string]interface{}, 0)
+	}
+	return map[string]interface{}{
+		"section": section, "items": items, "count": len(items),
+		"offset": offset, "limit": limit, "next_offset": nextOffset,
+	}, nil
+}
+
+// discoverPaging reads limit and offset query parameters.
+func discoverPaging(r *http.Request) (int, int) {
+	limit, offset := discoverDefaultLimit, 0
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= discoverMaxLimit {
+		limit = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+	return limit, offset
+}
+
+// HandleDiscover returns the first page of every discovery section.
+func (h *Handler) HandleDiscover(w http.ResponseWriter, r *http.Request) {
+	limit, _ := discoverPaging(r)
+	sections := make(map[string]interface{}, len(discoverSections))
+	for _, name := range discoverSections {
+		section, err := h.discoverSection(r.Context(), name, limit, 0)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load discovery page"})
+			return
+		}
+		sections[name] = section
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	httputil.WriteJSON(w, 200, map[string]interface{}{"sections": sections, "order": discoverSections})
+}
+
+// HandleDiscoverSection pages through a single discovery section.
+func (h *Handler) HandleDiscoverSection(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "section")
+	valid := false
+	for _, s := range discoverSections {
+		valid = valid || s == name
+	}
+	if !valid {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "unknown discovery section"})
+		return
+	}
+	limit, offset := discoverPaging(r)
+	section, err := h.discoverSection(r.Context(), name, limit, offset)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load discovery section"})
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	httputil.WriteJSON(w, 200, section)
+}
@@ -0,0 +1,408 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"clipfeed/httputil"
+	"clipfeed/tracing"
+)
+
+const (
+	// experimentBuckets is how many buckets users are hashed into; an
+	// experiment claims a contiguous range of them.
+	experimentBuckets = 100
+
+	rankExperimentsKey = "rank_experiments"
+
+	defaultExternalRankTimeout = 100 * time.Millisecond
+	maxExternalRankTimeout     = 2 * time.Second
+	maxRankExperiments         = 20
+	maxExternalRankResponse    = 1 << 20
+)
+
+// RankProvider orders feed candidates for a user. The built-in pipeline is
+// the fallback whenever a provider fails.
+type RankProvider interface {
+	// Rank returns candidate clip IDs, best first. Candidates it leaves out
+	// are served after the ones it returns.
+	Rank(ctx context.Context, req RankRequest) ([]string, error)
+}
+
+// RankRequest is what a RankProvider is given: the user's features and the
+// candidates to order.
+type RankRequest struct {
+	Experiment string           `json:"experiment"`
+	Bucket     int              `json:"bucket"`
+	UserID     string           `json:"user_id"`
+	User       RankUserFeatures `json:"user"`
+	Candidates []RankCandidate  `json:"candidates"`
+}
+
+// RankUserFeatures are the user signals the built-in rankers use.
+type RankUserFeatures struct {
+	TopicWeights          map[string]float64 `json:"topic_weights"`
+	DiversityMix          float64            `json:"diversity_mix"`
+	TrendingBoost         bool               `json:"trending_boost"`
+	FreshnessBias         float64            `json:"freshness_bias"`
+	TotalViews            float64            `json:"total_views"`
+	AvgWatchPercentage    float64            `json:"avg_watch_percentage"`
+	LikeRate              float64            `json:"like_rate"`
+	SaveRate              float64            `json:"save_rate"`
+	HoursSinceLastSession float64            `json:"hours_since_last_session"`
+	ChannelAffinity       map[string]float64 `json:"channel_affinity"`
+	TopicAffinities       []string           `json:"topic_affinities"`
+}
+
+// RankCandidate is one clip offered to a RankProvider.
+type RankCandidate struct {
+	ID               string   `json:"id"`
+	Title            string   `json:"title"`
+	DurationSeconds  float64  `json:"duration_seconds"`
+	ContentScore     float64  `json:"content_score"`
+	Topics           []string `json:"topics"`
+	Tags             []string `json:"tags"`
+	ChannelName      string   `json:"channel_name,omitempty"`
+	Platform         string   `json:"platform,omitempty"`
+	CreatedAt        string   `json:"created_at"`
+	AgeHours         float64  `json:"age_hours"`
+	TranscriptLength float64  `json:"transcript_length"`
+}
+
+// HTTPRankProvider is a RankProvider backed by an external HTTP endpoint. It
+// POSTs the RankRequest as JSON and expects {"clip_ids": [...]} back.
+type HTTPRankProvider struct {
+	URL    string
+	Client *http.Client
+}
+
+// Rank implements RankProvider.
+func (p *HTTPRankProvider) Rank(ctx context.Context, req RankRequest) ([]string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, httpReq.Header)
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("ranker returned %s", resp.Status)
+	}
+	var out struct {
+		ClipIDs []string `json:"clip_ids"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxExternalRankResponse)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode ranker response: %w", err)
+	}
+	return out.ClipIDs, nil
+}
+
+// RankExperiment routes the users hashed into buckets BucketFrom through
+// BucketTo to an external ranker.
+type RankExperiment struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	BucketFrom int    `json:"bucket_from"`
+	BucketTo   int    `json:"bucket_to"`
+	// TimeoutMS bounds each ranker call; 0 means the default of 100ms.
+	TimeoutMS int  `json:"timeout_ms"`
+	Enabled   bool `json:"enabled"`
+}
+
+func (e RankExperiment) timeout() time.Duration {
+	if e.TimeoutMS <= 0 {
+		return defaultExternalRankTimeout
+	}
+	return time.Duration(e.TimeoutMS) * time.Millisecond
+}
+
+// experimentStats counts how an experiment's feeds were served.
+type experimentStats struct {
+	Served    int64   `json:"served"`
+	Fallbacks int64   `json:"fallbacks"`
+	LastError string  `json:"last_error,omitempty"`
+	AvgMS     float64 `json:"avg_ms"`
+	totalMS   float64
+}
+
+// experimentBucket hashes userID into one of experimentBuckets buckets.
+func experimentBucket(userID string) int {
+	f := fnv.New32a()
+	f.Write([]byte(userID))
+	return int(f.Sum32() % experimentBuckets)
+}
+
+// LoadRankExperiments reads the stored experiments; with none stored every
+// user gets the built-in pipeline.
+func (h *Handler) LoadRankExperiments(ctx context.Context) error {
+	var value string
+	err := h.DB.QueryRowContext(ctx, `SELECT value FROM instance_settings WHERE key = ?`, rankExperimentsKey).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var experiments []RankExperiment
+	if err := json.Unmarshal([]byte(value), &experiments); err != nil {
+		return fmt.Errorf("decode rank experiments: %w", err)
+	}
+	h.setRankExperiments(experiments)
+	return nil
+}
+
+func (h *Handler) setRankExperiments(experiments []RankExperiment) {
+	h.experimentsMu.Lock()
+	h.experiments = experiments
+	h.experimentsMu.Unlock()
+}
+
+// rankExperimentFor returns the enabled experiment userID is bucketed into,
+// if any. Anonymous users are never in an experiment.
+func (h *Handler) rankExperimentFor(userID string) (RankExperiment, int, bool) {
+	if userID == "" {
+		return RankExperiment{}, 0, false
+	}
+	bucket := experimentBucket(userID)
+	h.experimentsMu.RLock()
+	defer h.experimentsMu.RUnlock()
+	for _, e := range h.experiments {
+		if e.Enabled && bucket >= e.BucketFrom && bucket <= e.BucketTo {
+			return e, bucket, true
+		}
+	}
+	return RankExperiment{}, bucket, false
+}
+
+// rankExternal orders clips in place with the experiment's ranker. On any
+// error, including a timeout or a response naming none of the candidates,
+// clips are left untouched for the built-in pipeline.
+func (h *Handler) rankExternal(ctx context.Context, exp RankExperiment, bucket int, clips []map[string]interface{}, userID string, topicWeights map[string]float64, fp FeedPrefs) error {
+	req := RankRequest{Experiment: exp.Name, Bucket: bucket, UserID: userID, User: h.rankUserFeatures(ctx, userID, topicWeights, fp)}
+	for _, c := range clips {
+		req.Candidates = append(req.Candidates, rankCandidate(c))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exp.timeout())
+	defer cancel()
+	start := time.Now()
+	var provider RankProvider = &HTTPRankProvider{URL: exp.URL}
+	if h.NewRankProvider != nil {
+		provider = h.NewRankProvider(exp)
+	}
+	ids, err := provider.Rank(ctx, req)
+	if err == nil {
+		err = reorderClips(clips, ids)
+	}
+	h.recordExperiment(exp.Name, time.Since(start), err)
+	return err
+}
+
+func (h *Handler) rankUserFeatures(ctx context.Context, userID string, topicWeights map[string]float64, fp FeedPrefs) RankUserFeatures {
+	stats := h.loadLTRUserStats(ctx, userID)
+	f := RankUserFeatures{
+		TopicWeights:          topicWeights,
+		DiversityMix:          fp.DiversityMix,
+		TrendingBoost:         fp.TrendingBoost,
+		FreshnessBias:         fp.FreshnessBias,
+		TotalViews:            stats.TotalViews,
+		AvgWatchPercentage:    stats.AvgWatchPercentage,
+		LikeRate:              stats.LikeRate,
+		SaveRate:              stats.SaveRate,
+		HoursSinceLastSession: stats.HoursSinceLastSession,
+		ChannelAffinity:       stats.ChannelAffinity,
+		TopicAffinities:       make([]string, 0, len(stats.TopicAffinities)),
+	}
+	if f.TopicWeights == nil {
+		f.TopicWeights = map[string]float64{}
+	}
+	for topic := range stats.TopicAffinities {
+		f.TopicAffinities = append(f.TopicAffinities, topic)
+	}
+	return f
+}
+
+func rankCandidate(clip map[string]interface{}) RankCandidate {
+	c := RankCandidate{}
+	c.ID, _ = clip["id"].(string)
+	c.Title, _ = clip["title"].(string)
+	c.DurationSeconds, _ = clip["duration_seconds"].(float64)
+	c.ContentScore, _ = clip["content_score"].(float64)
+	c.Topics, _ = clip["topics"].([]string)
+	c.Tags, _ = clip["tags"].([]string)
+	c.CreatedAt, _ = clip["created_at"].(string)
+	c.AgeHours, _ = clip["_age_hours"].(float64)
+	c.TranscriptLength, _ = clip["_transcript_length"].(float64)
+	if ch, ok := clip["channel_name"].(*string); ok && ch != nil {
+		c.ChannelName = *ch
+	}
+	if p, ok := clip["platform"].(*string); ok && p != nil {
+		c.Platform = *p
+	}
+	return c
+}
+
+// reorderClips puts clips in the order of ids, followed by any clips ids
+// left out in their current order. Unknown and repeated IDs are ignored.
+func reorderClips(clips []map[string]interface{}, ids []string) error {
+	byID := make(map[string]map[string]interface{}, len(clips))
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		byID[id] = c
+	}
+	ordered := make([]map[string]interface{}, 0, len(clips))
+	for _, id := range ids {
+		if c, ok := byID[id]; ok {
+			ordered = append(ordered, c)
+			delete(byID, id)
+		}
+	}
+	if len(ordered) == 0 && len(clips) > 0 {
+		return errors.New("ranker returned none of the candidates")
+	}
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		if _, ok := byID[id]; ok {
+			ordered = append(ordered, c)
+		}
+	}
+	copy(clips, ordered)
+	return nil
+}
+
+func (h *Handler) recordExperiment(name string, took time.Duration, err error) {
+	h.experimentsMu.Lock()
+	defer h.experimentsMu.Unlock()
+	if h.experimentStats == nil {
+		h.experimentStats = make(map[string]*experimentStats)
+	}
+	s := h.experimentStats[name]
+	if s == nil {
+		s = &experimentStats{}
+		h.experimentStats[name] = s
+	}
+	if err != nil {
+		s.Fallbacks++
+		s.LastError = err.Error()
+		return
+	}
+	s.Served++
+	s.totalMS += float64(took) / float64(time.Millisecond)
+	s.AvgMS = s.totalMS / float64(s.Served)
+}
+
+// validateRankExperiments checks an experiment list an admin submitted,
+// returning an error that can be shown to them.
+func validateRankExperiments(experiments []RankExperiment) error {
+	if len(experiments) > maxRankExperiments {
+		return fmt.Errorf("at most %d experiments", maxRankExperiments)
+	}
+	names := make(map[string]bool, len(experiments))
+	var claimed [experimentBuckets]string
+	for _, e := range experiments {
+		if strings.TrimSpace(e.Name) == "" {
+			return errors.New("every experiment needs a name")
+		}
+		if names[e.Name] {
+			return fmt.Errorf("duplicate experiment name %q", e.Name)
+		}
+		names[e.Name] = true
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: url must be an http or https URL", e.Name)
+		}
+		if e.BucketFrom < 0 || e.BucketTo >= experimentBuckets || e.BucketFrom > e.BucketTo {
+			return fmt.Errorf("%s: buckets must satisfy 0 <= bucket_from <= bucket_to <= %d", e.Name, experimentBuckets-1)
+		}
+		if e.TimeoutMS < 0 || time.Duration(e.TimeoutMS)*time.Millisecond > maxExternalRankTimeout {
+			return fmt.Errorf("%s: timeout_ms must be between 0 and %d", e.Name, maxExternalRankTimeout.Milliseconds())
+		}
+		if !e.Enabled {
+			continue
+		}
+		for b := e.BucketFrom; b <= e.BucketTo; b++ {
+			if claimed[b] != "" {
+				return fmt.Errorf("%s: bucket %d is already used by %s", e.Name, b, claimed[b])
+			}
+			claimed[b] = e.Name
+		}
+	}
+	return nil
+}
+
+// HandleGetRankExperiments lists the external ranker experiments with how
+// each has served since the server started.
+func (h *Handler) HandleGetRankExperiments(w http.ResponseWriter, r *http.Request) {
+	h.experimentsMu.RLock()
+	experiments := make([]map[string]interface{}, 0, len(h.experiments))
+	for _, e := range h.experiments {
+		stats := experimentStats{}
+		if s := h.experimentStats[e.Name]; s != nil {
+			stats = *s
+		}
+		experiments = append(experiments, map[string]interface{}{
+			"name": e.Name, "url": e.URL, "bucket_from": e.BucketFrom, "bucket_to": e.BucketTo,
+			"timeout_ms": e.timeout().Milliseconds(), "enabled": e.Enabled, "stats": stats,
+		})
+	}
+	h.experimentsMu.RUnlock()
+	httputil.WriteJSON(w, 200, map[string]interface{}{"buckets": experimentBuckets, "experiments": experiments})
+}
+
+// HandleSetRankExperiments replaces the experiment list. Enabled
+// experiments may not share buckets.
+func (h *Handler) HandleSetRankExperiments(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Experiments []RankExperiment `json:"experiments"`
+	}
+	dec := json.NewDecoder(httputil.LimitedBodyReader(r))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Experiments == nil {
+		req.Experiments = []RankExperiment{}
+	}
+	for i := range req.Experiments {
+		req.Experiments[i].Name = strings.TrimSpace(req.Experiments[i].Name)
+	}
+	if err := validateRankExperiments(req.Experiments); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	b, _ := json.Marshal(req.Experiments)
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO instance_settings (key, value, updated_at) VALUES (?, ?, %s)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, h.DB.NowUTC()), rankExperimentsKey, string(b)); err != nil {
+		log.Printf("save rank experiments: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save experiments"})
+		return
+	}
+	h.setRankExperiments(req.Experiments)
+	h.HandleGetRankExperiments(w, r)
+}
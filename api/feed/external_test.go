@@ -0,0 +1,62 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReorderClips_AppendsLeftOutCandidates(t *testing.T) {
+	clips := []map[string]interface{}{{"id": "a"}, {"id": "b"}, {"id": "c"}, {"id": "d"}}
+	if err := reorderClips(clips, []string{"c", "zzz", "a", "c"}); err != nil {
+		t.Fatalf("reorder: %v", err)
+	}
+	var ids []string
+	for _, c := range clips {
+		ids = append(ids, c["id"].(string))
+	}
+	if got := strings.Join(ids, ","); got != "c,a,b,d" {
+		t.Errorf("order = %s, want c,a,b,d", got)
+	}
+
+	if err := reorderClips(clips, []string{"zzz"}); err == nil {
+		t.Error("expected an error when no candidate is named")
+	}
+}
+
+func TestValidateRankExperiments(t *testing.T) {
+	ok := RankExperiment{Name: "a", URL: "http://ranker:8000/rank", BucketFrom: 0, BucketTo: 9, Enabled: true}
+	if err := validateRankExperiments([]RankExperiment{ok}); err != nil {
+		t.Errorf("valid experiment rejected: %v", err)
+	}
+
+	overlap := ok
+	overlap.Name, overlap.BucketFrom, overlap.BucketTo = "b", 5, 20
+	if err := validateRankExperiments([]RankExperiment{ok, overlap}); err == nil {
+		t.Error("expected overlapping buckets to be rejected")
+	}
+	overlap.Enabled = false
+	if err := validateRankExperiments([]RankExperiment{ok, overlap}); err != nil {
+		t.Errorf("disabled experiment may overlap: %v", err)
+	}
+
+	for _, bad := range []RankExperiment{
+		{Name: "", URL: ok.URL},
+		{Name: "x", URL: "ftp://ranker"},
+		{Name: "x", URL: ok.URL, BucketFrom: 5, BucketTo: 2},
+		{Name: "x", URL: ok.URL, BucketTo: experimentBuckets},
+		{Name: "x", URL: ok.URL, TimeoutMS: 5000},
+	} {
+		if err := validateRankExperiments([]RankExperiment{bad}); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestExperimentBucket_StableAndInRange(t *testing.T) {
+	for _, id := range []string{"u1", "u2", "a-much-longer-user-id"} {
+		b := experimentBucket(id)
+		if b < 0 || b >= experimentBuckets || b != experimentBucket(id) {
+			t.Errorf("bucket(%s) = %d", id, b)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// feedbackStep is how far one "more" or "less" moves a weight.
+	feedbackStep = 0.25
+
+	// Weights stay within these bounds. Topic weights may sink lower than
+	// channel and format weights because topic boosts are averaged across a
+	// clip's topics, which already dilutes them.
+	feedbackMaxWeight      = 2.0
+	feedbackMinWeight      = 0.25
+	feedbackMinTopicWeight = 0.1
+
+	// feedbackBlockDays is how long a topic or channel is snoozed when
+	// "less" is asked for at its floor weight.
+	feedbackBlockDays = 30
+
+	// maxFeedbackTopics caps how many of a clip's topics one topic
+	// feedback adjusts, most confident first.
+	maxFeedbackTopics = 3
+)
+
+// feedbackChange reports one weight a feedback action moved.
+type feedbackChange struct {
+	Dimension    string  `json:"dimension"`
+	Target       string  `json:"target"`
+	Label        string  `json:"label"`
+	Before       float64 `json:"before"`
+	After        float64 `json:"after"`
+	BlockedUntil string  `json:"blocked_until,omitempty"`
+}
+
+// stepWeight moves weight one step in direction, clamped to [min, max].
+func stepWeight(weight float64, direction string, min float64) float64 {
+	if direction == "more" {
+		weight += feedbackStep
+	} else {
+		weight -= feedbackStep
+	}
+	return math.Round(math.Max(min, math.Min(feedbackMaxWeight, weight))*100) / 100
+}
+
+// HandleClipFeedback applies a "more like this" or "less like this" action
+// to the topics, channel, or format of a clip. Each action moves the
+// affected weights by one bounded step; asking for less of a topic or
+// channel already at its floor snoozes it for feedbackBlockDays. The
+// response lists every weight that changed.
+func (h *Handler) HandleClipFeedback(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	clipID := chi.URLParam(r, "id")
+
+	var req struct {
+		Direction string `json:"direction"`
+		Dimension string `json:"dimension"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Direction != "more" && req.Direction != "less" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "direction must be more or less"})
+		return
+	}
+	if req.Dimension != "topic" && req.Dimension != "channel" && req.Dimension != "format" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "dimension must be topic, channel, or format"})
+		return
+	}
+
+	var channel string
+	var duration float64
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT COALESCE(s.channel_name, ''), COALESCE(c.duration_seconds, 0)
+		FROM clips c
+		LEFT JOIN sources s ON s.id = c.source_id
+		WHERE c.id = ?
+	`, clipID).Scan(&channel, &duration); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	if req.Dimension == "channel" && channel == "" {
+		httputil.WriteJSON(w, 422, map[string]string{"error": "clip has no channel"})
+		return
+	}
+
+	changes := make([]feedbackChange, 0)
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var err error
+		switch req.Dimension {
+		case "topic":
+			changes, err = h.topicFeedback(r.Context(), conn, userID, clipID, req.Direction)
+		case "channel":
+			var c feedbackChange
+			if c, err = h.weightFeedback(r.Context(), conn, userID, "channel", channel, req.Direction); err == nil {
+				changes = append(changes, c)
+			}
+		case "format":
+			var c feedbackChange
+			if c, err = h.weightFeedback(r.Context(), conn, userID, "format", httputil.DurationBucket(duration), req.Direction); err == nil {
+				changes = append(changes, c)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("clip feedback %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to apply feedback"})
+		return
+	}
+	if req.Dimension == "topic" && len(changes) == 0 {
+		httputil.WriteJSON(w, 422, map[string]string{"error": "clip has no topics"})
+		return
+	}
+
+	h.DB.ExecContext(r.Context(), `DELETE FROM feed_candidates WHERE user_id = ?`, userID)
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"clip_id":   clipID,
+		"direction": req.Direction,
+		"dimension": req.Dimension,
+		"changes":   changes,
+	})
+}
+
+// topicFeedback steps the user's affinity for the clip's most confident
+// topics. New affinities start from the neutral weight of 1.
+func (h *Handler) topicFeedback(ctx context.Context, conn *db.CompatConn, userID, clipID, direction string) ([]feedbackChange, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT t.id, t.name, COALESCE(a.weight, 1.0)
+		FROM clip_topics ct
+		JOIN topics t ON t.id = ct.topic_id
+		LEFT JOIN user_topic_affinities a ON a.topic_id = t.id AND a.user_id = ?
+		WHERE ct.clip_id = ?
+		ORDER BY ct.confidence DESC, t.name
+		LIMIT ?
+	`, userID, clipID, maxFeedbackTopics)
+	if err != nil {
+		return nil, fmt.Errorf("load clip topics: %w", err)
+	}
+	var changes []feedbackChange
+	for rows.Next() {
+		c := feedbackChange{Dimension: "topic"}
+		if err := rows.Scan(&c.Target, &c.Label, &c.Before); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range changes {
+		c := &changes[i]
+		c.After = stepWeight(c.Before, direction, feedbackMinTopicWeight)
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO user_topic_affinities (user_id, topic_id, weight, source, updated_at)
+			VALUES (?, ?, ?, 'feedback', %s)
+			ON CONFLICT(user_id, topic_id) DO UPDATE SET weight = excluded.weight, updated_at = excluded.updated_at
+		`, h.DB.NowUTC()), userID, c.Target, c.After); err != nil {
+			return nil, fmt.Errorf("update topic affinity: %w", err)
+		}
+		if direction == "less" && c.Before <= feedbackMinTopicWeight {
+			if c.BlockedUntil, err = h.feedbackBlock(ctx, conn, userID, "topic", c.Target, c.Label); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// weightFeedback steps the user's channel or format weight for target.
+func (h *Handler) weightFeedback(ctx context.Context, conn *db.CompatConn, userID, dimension, target, direction string) (feedbackChange, error) {
+	c := feedbackChange{Dimension: dimension, Target: target, Label: target, Before: 1.0}
+	err := conn.QueryRowContext(ctx,
+		`SELECT weight FROM user_feedback_weights WHERE user_id = ? AND dimension = ? AND target = ?`,
+		userID, dimension, target).Scan(&c.Before)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("load %s weight: %w", dimension, err)
+	}
+	c.After = stepWeight(c.Before, direction, feedbackMinWeight)
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO user_feedback_weights (user_id, dimension, target, weight, updated_at)
+		VALUES (?, ?, ?, ?, %s)
+		ON CONFLICT(user_id, dimension, target) DO UPDATE SET weight = excluded.weight, updated_at = excluded.updated_at
+	`, h.DB.NowUTC()), userID, dimension, target, c.After); err != nil {
+		return c, fmt.Errorf("update %s weight: %w", dimension, err)
+	}
+	// Formats cannot be snoozed; the floor weight is as far as they go.
+	if dimension == "channel" && direction == "less" && c.Before <= feedbackMinWeight {
+		if c.BlockedUntil, err = h.feedbackBlock(ctx, conn, userID, "channel", target, target); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// feedbackBlock snoozes a topic or channel like POST /api/me/snooze and
+// returns when the snooze expires.
+func (h *Handler) feedbackBlock(ctx context.Context, conn *db.CompatConn, userID, targetType, targetID, label string) (string, error) {
+	expiresAt := time.Now().UTC().AddDate(0, 0, feedbackBlockDays).Format("2006-01-02T15:04:05Z")
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO user_snoozes (id, user_id, target_type, target_id, label, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, %s)
+		ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET
+			label = excluded.label, expires_at = excluded.expires_at, created_at = excluded.created_at
+	`, h.DB.NowUTC()), uuid.New().String(), userID, targetType, targetID, label, expiresAt); err != nil {
+		return "", fmt.Errorf("snooze %s: %w", targetType, err)
+	}
+	return expiresAt, nil
+}
+
+// applyFeedbackWeights multiplies each clip's ranking score by the user's
+// channel and format weights and re-sorts. Clips the base ranker left
+// unscored start from their content_score. Non-positive scores are left
+// alone, since scaling them would move them the wrong way.
+func (h *Handler) applyFeedbackWeights(ctx context.Context, clips []map[string]interface{}, userID string) {
+	if userID == "" || len(clips) == 0 {
+		return
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT dimension, target, weight FROM user_feedback_weights WHERE user_id = ?`, userID)
+	if err != nil {
+		return
+	}
+	weights := map[string]map[string]float64{"channel": {}, "format": {}}
+	for rows.Next() {
+		var dimension, target string
+		var weight float64
+		if err := rows.Scan(&dimension, &target, &weight); err != nil {
+			continue
+		}
+		if m, ok := weights[dimension]; ok {
+			m[target] = weight
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("applyFeedbackWeights: rows iteration error: %v", err)
+	}
+	rows.Close()
+	if len(weights["channel"]) == 0 && len(weights["format"]) == 0 {
+		return
+	}
+
+	key := "_score"
+	if _, ok := clips[0]["_l2r_score"].(float64); ok {
+		key = "_l2r_score"
+	}
+	for _, clip := range clips {
+		score, ok := clip[key].(float64)
+		if !ok {
+			score, _ = clip["content_score"].(float64)
+		}
+		multiplier := 1.0
+		if ch, ok := clip["channel_name"].(*string); ok && ch != nil {
+			if w, ok := weights["channel"][*ch]; ok {
+				multiplier *= w
+			}
+		}
+		duration, _ := clip["duration_seconds"].(float64)
+		if w, ok := weights["format"][httputil.DurationBucket(duration)]; ok {
+			multiplier *= w
+		}
+		if score > 0 {
+			score *= multiplier
+		}
+		clip[key] = score
+	}
+	sort.SliceStable(clips, func(i, j int) bool {
+		si, _ := clips[i][key].(float64)
+		sj, _ := clips[j][key].(float64)
+		return si > sj
+	})
+}
@@ -0,0 +1,93 @@
+// Package feedtest provides go test helpers that compare feed rankings with
+// golden snapshots, so ranking changes that reshuffle feeds fail a test
+// before release. Set UPDATE_GOLDEN=1 to rewrite the golden files after an
+// intended change.
+package feedtest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"clipfeed/feed"
+)
+
+// Thresholds bound how far a user's current ranking may drift from the
+// golden one.
+type Thresholds struct {
+	MinOverlap     float64
+	MinCorrelation float64
+}
+
+// DefaultThresholds are the ones the admin diff endpoint applies by default.
+var DefaultThresholds = Thresholds{MinOverlap: feed.DefaultMinOverlap, MinCorrelation: feed.DefaultMinCorrelation}
+
+// Golden is a snapshot of top-k feeds keyed by user ID.
+type Golden struct {
+	K     int                 `json:"k"`
+	Feeds map[string][]string `json:"feeds"`
+}
+
+// ReadGolden loads the golden snapshot at path.
+func ReadGolden(tb testing.TB, path string) Golden {
+	tb.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("read golden feeds: %v (run with UPDATE_GOLDEN=1 to create it)", err)
+	}
+	var g Golden
+	if err := json.Unmarshal(raw, &g); err != nil {
+		tb.Fatalf("parse golden feeds %s: %v", path, err)
+	}
+	return g
+}
+
+// WriteGolden stores feeds as the golden snapshot at path.
+func WriteGolden(tb testing.TB, path string, k int, feeds map[string][]string) {
+	tb.Helper()
+	raw, err := json.MarshalIndent(Golden{K: k, Feeds: feeds}, "", "  ")
+	if err != nil {
+		tb.Fatalf("encode golden feeds: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		tb.Fatalf("create golden dir: %v", err)
+	}
+	if err := os.WriteFile(path, append(raw, '\n'), 0o644); err != nil {
+		tb.Fatalf("write golden feeds: %v", err)
+	}
+}
+
+// AssertGolden compares current with the golden snapshot at path and fails
+// for every user whose ranking drifted past th. With UPDATE_GOLDEN=1 it
+// rewrites the snapshot instead. It returns the comparison for further
+// checks.
+func AssertGolden(tb testing.TB, path string, current map[string][]string, th Thresholds) feed.FeedComparison {
+	tb.Helper()
+	if os.Getenv("UPDATE_GOLDEN") == "1" {
+		k := 0
+		for _, ids := range current {
+			if len(ids) > k {
+				k = len(ids)
+			}
+		}
+		WriteGolden(tb, path, k, current)
+		return feed.CompareFeeds(current, current, k)
+	}
+	g := ReadGolden(tb, path)
+	return AssertFeeds(tb, g.Feeds, current, g.K, th)
+}
+
+// AssertFeeds compares current with golden at k and fails for every user
+// whose ranking drifted past th.
+func AssertFeeds(tb testing.TB, golden, current map[string][]string, k int, th Thresholds) feed.FeedComparison {
+	tb.Helper()
+	cmp := feed.CompareFeeds(golden, current, k)
+	for _, d := range cmp.Users {
+		if d.OverlapAtK < th.MinOverlap || d.RankCorrelation < th.MinCorrelation {
+			tb.Errorf("user %s: overlap@%d = %.2f (min %.2f), rank correlation = %.2f (min %.2f); added %v, removed %v",
+				d.UserID, k, d.OverlapAtK, th.MinOverlap, d.RankCorrelation, th.MinCorrelation, d.Added, d.Removed)
+		}
+	}
+	return cmp
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
@@ -14,13 +15,15 @@ import (
 	"github.com/google/uuid"
 )
 
-// FilterQuery describes a saved filter's criteria.
+// FilterQuery describes a saved filter's criteria. MinRating keeps clips
+// whose average star rating is at least that; unrated clips never match it.
 type FilterQuery struct {
 	Topics        *FilterTopics `json:"topics,omitempty"`
 	Channels      []string      `json:"channels,omitempty"`
 	Duration      *FilterRange  `json:"duration,omitempty"`
 	RecencyDays   int           `json:"recency_days,omitempty"`
 	MinScore      float64       `json:"min_score,omitempty"`
+	MinRating     float64       `json:"min_rating,omitempty"`
 	SimilarToClip string        `json:"similar_to_clip,omitempty"`
 }
 
@@ -60,6 +63,10 @@ func (h *Handler) HandleCreateFilter(w http.ResponseWriter, r *http.Request) {
 	isDefault := 0
 	if req.IsDefault {
 		isDefault = 1
+		if err := h.clearDefaultFilter(r.Context(), userID); err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create filter"})
+			return
+		}
 	}
 
 	_, err := h.DB.ExecContext(r.Context(),
@@ -131,6 +138,10 @@ func (h *Handler) HandleUpdateFilter(w http.ResponseWriter, r *http.Request) {
 		def := 0
 		if *req.IsDefault {
 			def = 1
+			if err := h.clearDefaultFilter(r.Context(), userID); err != nil {
+				httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update filter"})
+				return
+			}
 		}
 		if _, err := h.DB.ExecContext(r.Context(), `UPDATE saved_filters SET is_default = ? WHERE id = ? AND user_id = ?`, def, filterID, userID); err != nil {
 			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update filter"})
@@ -140,6 +151,47 @@ func (h *Handler) HandleUpdateFilter(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
 }
 
+// clearDefaultFilter unmarks the user's default filter, so that marking
+// another one leaves a single default.
+func (h *Handler) clearDefaultFilter(ctx context.Context, userID string) error {
+	_, err := h.DB.ExecContext(ctx, `UPDATE saved_filters SET is_default = 0 WHERE user_id = ? AND is_default = 1`, userID)
+	return err
+}
+
+// feedFilter resolves the saved filter a feed request applies: ?filter=<id>,
+// or the user's default filter when no filter is given (filter=none skips
+// it). A filter given explicitly returns its matches best-scored first
+// unless ranked=true; the default filter narrows the ranked feed unless
+// ranked=false. fq is nil when no filter applies.
+func (h *Handler) feedFilter(r *http.Request, userID string) (fq *FilterQuery, filterID string, ranked bool) {
+	q := r.URL.Query()
+	filterID = q.Get("filter")
+	if filterID == "none" {
+		return nil, "", false
+	}
+	var queryStr string
+	var err error
+	if filterID != "" {
+		ranked = q.Get("ranked") == "true"
+		err = h.DB.QueryRowContext(r.Context(),
+			`SELECT query FROM saved_filters WHERE id = ? AND user_id = ?`, filterID, userID,
+		).Scan(&queryStr)
+	} else {
+		ranked = q.Get("ranked") != "false"
+		err = h.DB.QueryRowContext(r.Context(),
+			`SELECT id, query FROM saved_filters WHERE user_id = ? AND is_default = 1 ORDER BY created_at DESC LIMIT 1`, userID,
+		).Scan(&filterID, &queryStr)
+	}
+	if err != nil {
+		return nil, "", false
+	}
+	fq = &FilterQuery{}
+	if json.Unmarshal([]byte(queryStr), fq) != nil {
+		return nil, "", false
+	}
+	return fq, filterID, ranked
+}
+
 // HandleDeleteFilter deletes a saved filter.
 func (h *Handler) HandleDeleteFilter(w http.ResponseWriter, r *http.Request) {
 	userID, _ := auth.ExtractUserID(r)
@@ -151,8 +203,78 @@ func (h *Handler) HandleDeleteFilter(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
 }
 
-// ApplyFilterToFeed executes a filter query and returns matching clips.
+// ApplyFilterToFeed executes a filter query and returns matching clips,
+// best content score first. Snoozed topics and channels are left out.
 func (h *Handler) ApplyFilterToFeed(ctx context.Context, fq *FilterQuery, userID string, dedupeSeen24h bool) ([]map[string]interface{}, error) {
+	where, args := h.filterConditions(fq, userID, dedupeSeen24h)
+	return h.queryClipsWhere(ctx, where, args, userID, "c.content_score DESC", 60, 0)
+}
+
+// queryClipsWhere returns one page of clips matching all of where, in the
+// given order, leaving out the user's snoozed topics and channels.
+func (h *Handler) queryClipsWhere(ctx context.Context, where []string, args []interface{}, userID, order string, limit, offset int) ([]map[string]interface{}, error) {
+	snoozed := ""
+	if userID != "" {
+		snoozed = h.snoozeFilter()
+		args = append(args, userID, userID)
+	}
+
+	query := fmt.Sprintf(`SELECT c.id, c.title, c.description, c.duration_seconds,
+	       c.thumbnail_key, c.topics, c.tags, c.content_score,
+	       c.created_at, s.channel_name, s.platform, s.url,
+	       COALESCE(c.source_id, ''),
+	       CAST(c.transcript_length AS REAL),
+	       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+	       COALESCE(%s, 0)
+	FROM clips c LEFT JOIN sources s ON c.source_id = s.id
+	WHERE `, h.DB.AgeHoursExpr("c.created_at")) + strings.Join(where, " AND ") + snoozed + `
+	ORDER BY ` + order + ` LIMIT ? OFFSET ?`
+
+	rows, err := h.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return httputil.ScanClips(rows), nil
+}
+
+// keepFilterMatches returns the clips fq matches, in their original order.
+// It narrows clips the feed adds on top of its candidates, such as bandit
+// exploration picks and pinned series parts.
+func (h *Handler) keepFilterMatches(ctx context.Context, fq *FilterQuery, clips []map[string]interface{}) []map[string]interface{} {
+	if len(clips) == 0 {
+		return clips
+	}
+	where, args := h.filterConditions(fq, "", false)
+	for _, c := range clips {
+		args = append(args, c["id"])
+	}
+	rows, err := h.DB.QueryContext(ctx, `SELECT c.id FROM clips c LEFT JOIN sources s ON c.source_id = s.id WHERE `+
+		strings.Join(where, " AND ")+` AND c.id IN (?`+strings.Repeat(", ?", len(clips)-1)+`)`, args...)
+	if err != nil {
+		log.Printf("keepFilterMatches: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	matched := make(map[string]bool, len(clips))
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			matched[id] = true
+		}
+	}
+	kept := clips[:0]
+	for _, c := range clips {
+		if matched[c["id"].(string)] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// filterConditions builds the WHERE conditions, over clips c joined to
+// sources s, that select the clips fq matches.
+func (h *Handler) filterConditions(fq *FilterQuery, userID string, dedupeSeen24h bool) ([]string, []interface{}) {
 	where := []string{"c.status = 'ready'"}
 	var args []interface{}
 
@@ -174,6 +296,10 @@ func (h *Handler) ApplyFilterToFeed(ctx context.Context, fq *FilterQuery, userID
 		where = append(where, "c.content_score >= ?")
 		args = append(args, fq.MinScore)
 	}
+	if fq.MinRating > 0 {
+		where = append(where, "c.rating_count > 0 AND c.rating_avg >= ?")
+		args = append(args, fq.MinRating)
+	}
 	if len(fq.Channels) > 0 {
 		ph := make([]string, len(fq.Channels))
 		for i, ch := range fq.Channels {
@@ -237,22 +363,5 @@ func (h *Handler) ApplyFilterToFeed(ctx context.Context, fq *FilterQuery, userID
 		where = append(where, fmt.Sprintf("c.id NOT IN (SELECT clip_id FROM interactions WHERE user_id = ? AND created_at > %s)", h.DB.DatetimeModifier("-24 hours")))
 		args = append(args, userID)
 	}
-
-	query := fmt.Sprintf(`SELECT c.id, c.title, c.description, c.duration_seconds,
-	       c.thumbnail_key, c.topics, c.tags, c.content_score,
-	       c.created_at, s.channel_name, s.platform, s.url,
-	       COALESCE(c.source_id, ''),
-	       CAST(LENGTH(COALESCE(c.transcript, '')) AS REAL),
-	       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
-	       COALESCE(%s, 0)
-	FROM clips c LEFT JOIN sources s ON c.source_id = s.id
-	WHERE `, h.DB.AgeHoursExpr("c.created_at")) + strings.Join(where, " AND ") + `
-	ORDER BY c.content_score DESC LIMIT 60`
-
-	rows, err := h.DB.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return httputil.ScanClips(rows), nil
+	return where, args
 }
@@ -0,0 +1,277 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	focusMaxBudgetMinutes = 180
+	focusCandidateLimit   = 100
+)
+
+// selectWithinBudget solves a 0/1 knapsack over clip durations (rounded up
+// to whole seconds) and returns the indices, in input order, of the subset
+// with the highest total relevance whose duration fits within budget.
+func selectWithinBudget(durations, relevance []float64, budget int) []int {
+	n := len(durations)
+	if n == 0 || budget <= 0 {
+		return nil
+	}
+	weights := make([]int, n)
+	for i, d := range durations {
+		weights[i] = int(math.Ceil(d))
+	}
+
+	best := make([]float64, budget+1)
+	keep := make([][]bool, n)
+	for i := 0; i < n; i++ {
+		keep[i] = make([]bool, budget+1)
+		w := weights[i]
+		if w <= 0 || w > budget {
+			continue
+		}
+		for c := budget; c >= w; c-- {
+			if v := best[c-w] + relevance[i]; v > best[c] {
+				best[c] = v
+				keep[i][c] = true
+			}
+		}
+	}
+
+	var picked []int
+	for i, c := n-1, budget; i >= 0; i-- {
+		if keep[i][c] {
+			picked = append(picked, i)
+			c -= weights[i]
+		}
+	}
+	for l, r := 0, len(picked)-1; l < r; l, r = l+1, r-1 {
+		picked[l], picked[r] = picked[r], picked[l]
+	}
+	return picked
+}
+
+// focusWatched sums the furthest watch position per clip recorded since the
+// session started.
+func (h *Handler) focusWatched(ctx context.Context, userID, since string, clipIDs []string) (float64, int) {
+	if len(clipIDs) == 0 {
+		return 0, 0
+	}
+	ph := make([]string, len(clipIDs))
+	args := []interface{}{userID, since}
+	for i, id := range clipIDs {
+		ph[i] = "?"
+		args = append(args, id)
+	}
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT clip_id, MAX(COALESCE(watch_duration_seconds, 0)) FROM interactions
+		WHERE user_id = ? AND created_at >= ? AND clip_id IN (`+strings.Join(ph, ",")+`)
+		GROUP BY clip_id
+	`, args...)
+	if err != nil {
+		return 0, 0
+	}
+	defer rows.Close()
+
+	var total float64
+	var clipsWatched int
+	for rows.Next() {
+		var clipID string
+		var watched float64
+		if rows.Scan(&clipID, &watched) == nil && watched > 0 {
+			total += watched
+			clipsWatched++
+		}
+	}
+	return total, clipsWatched
+}
+
+// HandleStartFocus builds a finite, relevance-maximising clip session whose
+// total duration fits within the requested watch budget.
+func (h *Handler) HandleStartFocus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+
+	var req struct {
+		BudgetMinutes float64 `json:"budget_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.BudgetMinutes < 1 || req.BudgetMinutes > focusMaxBudgetMinutes {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("budget_minutes must be between 1 and %d", focusMaxBudgetMinutes)})
+		return
+	}
+	budget := int(req.BudgetMinutes * 60)
+
+	topicWeights, dedupeSeen24h, feedPrefs := h.loadFeedPrefs(r.Context(), userID)
+	halfLife := 24.0 + (1.0-feedPrefs.FreshnessBias)*648.0
+	ageHours := h.DB.AgeHoursExpr("c.created_at")
+
+	seenFilter := ""
+	args := []interface{}{userID}
+	if dedupeSeen24h {
+		seenFilter = fmt.Sprintf("AND c.id NOT IN (SELECT clip_id FROM interactions WHERE user_id = ? AND created_at > %s)", h.DB.DatetimeModifier("-24 hours"))
+		args = append(args, userID)
+	}
+	args = append(args, budget, halfLife, focusCandidateLimit)
+
+	rows, err := h.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		WITH prefs AS (
+			SELECT min_clip_seconds, max_clip_seconds FROM user_preferences WHERE user_id = ?
+		)
+		SELECT c.id, c.title, c.description, c.duration_seconds,
+		       c.thumbnail_key, c.topics, c.tags, c.content_score,
+		       c.created_at, s.channel_name, s.platform, s.url,
+		       COALESCE(c.source_id, ''),
+		       CAST(c.transcript_length AS REAL),
+		       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+		       COALESCE(%s, 0)
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready'
+		  %s
+		  AND c.duration_seconds >= COALESCE((SELECT min_clip_seconds FROM prefs), 5)
+		  AND c.duration_seconds <= COALESCE((SELECT max_clip_seconds FROM prefs), 120)
+		  AND c.duration_seconds <= ?
+		ORDER BY c.content_score * EXP(-%s / ?) DESC
+		LIMIT ?
+	`, ageHours, seenFilter, ageHours), args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch focus candidates"})
+		return
+	}
+	candidates := httputil.ScanClips(rows)
+	rows.Close()
+
+	// RankFeed orders candidates by personalised relevance; convert rank into
+	// a DCG-style gain so earlier clips are worth more to the solver.
+	h.RankFeed(r.Context(), candidates, userID, topicWeights, feedPrefs)
+	durations := make([]float64, len(candidates))
+	relevance := make([]float64, len(candidates))
+	for i, c := range candidates {
+		durations[i], _ = c["duration_seconds"].(float64)
+		relevance[i] = 1.0 / math.Log2(float64(i)+2)
+	}
+
+	picked := selectWithinBudget(durations, relevance, budget)
+	clips := make([]map[string]interface{}, 0, len(picked))
+	clipIDs := make([]string, 0, len(picked))
+	var planned float64
+	for _, i := range picked {
+		clips = append(clips, candidates[i])
+		clipIDs = append(clipIDs, candidates[i]["id"].(string))
+		planned += durations[i]
+	}
+	httputil.AddThumbnailURLs(clips, h.MinioBucket)
+	httputil.AddAttributions(r.Context(), h.DB, clips)
+
+	sessionID := uuid.New().String()
+	idsJSON, _ := json.Marshal(clipIDs)
+	if _, err := h.DB.ExecContext(r.Context(), `
+		INSERT INTO focus_sessions (id, user_id, budget_seconds, planned_seconds, clip_ids)
+		VALUES (?, ?, ?, ?, ?)
+	`, sessionID, userID, budget, planned, string(idsJSON)); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create focus session"})
+		return
+	}
+
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"session_id":      sessionID,
+		"budget_seconds":  budget,
+		"planned_seconds": planned,
+		"clips":           clips,
+		"count":           len(clips),
+		"finite":          true,
+	})
+}
+
+// loadFocusSession returns the stored fields of one of the user's sessions.
+func (h *Handler) loadFocusSession(ctx context.Context, userID, sessionID string) (map[string]interface{}, []string, error) {
+	var budget int
+	var planned float64
+	var idsJSON, status, createdAt string
+	var watched *float64
+	var endedAt *string
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT budget_seconds, planned_seconds, clip_ids, status, watched_seconds, created_at, ended_at
+		FROM focus_sessions WHERE id = ? AND user_id = ?
+	`, sessionID, userID).Scan(&budget, &planned, &idsJSON, &status, &watched, &createdAt, &endedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	var clipIDs []string
+	json.Unmarshal([]byte(idsJSON), &clipIDs)
+
+	session := map[string]interface{}{
+		"session_id": sessionID, "budget_seconds": budget, "planned_seconds": planned,
+		"clip_ids": clipIDs, "status": status, "created_at": createdAt, "ended_at": endedAt,
+	}
+	if watched != nil {
+		session["watched_seconds"] = *watched
+	}
+	return session, clipIDs, nil
+}
+
+// HandleGetFocus reports a focus session's progress against its budget.
+func (h *Handler) HandleGetFocus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	session, clipIDs, err := h.loadFocusSession(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "focus session not found"})
+		return
+	}
+	if session["status"] == "active" {
+		watched, clipsWatched := h.focusWatched(r.Context(), userID, session["created_at"].(string), clipIDs)
+		session["watched_seconds"] = watched
+		session["clips_watched"] = clipsWatched
+	}
+	httputil.WriteJSON(w, 200, session)
+}
+
+// HandleEndFocus closes a focus session and reports actual watch time
+// against the budget.
+func (h *Handler) HandleEndFocus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	sessionID := chi.URLParam(r, "id")
+	session, clipIDs, err := h.loadFocusSession(r.Context(), userID, sessionID)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "focus session not found"})
+		return
+	}
+	if session["status"] != "active" {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "focus session already ended"})
+		return
+	}
+
+	watched, clipsWatched := h.focusWatched(r.Context(), userID, session["created_at"].(string), clipIDs)
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		UPDATE focus_sessions SET status = 'ended', watched_seconds = ?, ended_at = %s WHERE id = ?
+	`, h.DB.NowUTC()), watched, sessionID); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to end focus session"})
+		return
+	}
+
+	budget := float64(session["budget_seconds"].(int))
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"session_id":          sessionID,
+		"budget_seconds":      session["budget_seconds"],
+		"planned_seconds":     session["planned_seconds"],
+		"watched_seconds":     watched,
+		"clips_planned":       len(clipIDs),
+		"clips_watched":       clipsWatched,
+		"over_budget_seconds": math.Max(watched-budget, 0),
+		"budget_used":         watched / budget,
+	})
+}
@@ -0,0 +1,38 @@
+package feed
+
+import "testing"
+
+func TestSelectWithinBudget_MaximisesRelevance(t *testing.T) {
+	// Budget 100s: the two 50s clips (0.6+0.6) beat the single 90s clip (1.0).
+	durations := []float64{90, 50, 50, 30}
+	relevance := []float64{1.0, 0.6, 0.6, 0.1}
+	picked := selectWithinBudget(durations, relevance, 100)
+	if len(picked) != 2 || picked[0] != 1 || picked[1] != 2 {
+		t.Fatalf("picked = %v, want [1 2]", picked)
+	}
+}
+
+func TestSelectWithinBudget_RespectsBudget(t *testing.T) {
+	durations := []float64{45.5, 45.5, 10}
+	relevance := []float64{1, 1, 1}
+	picked := selectWithinBudget(durations, relevance, 60)
+	var total float64
+	for _, i := range picked {
+		total += durations[i]
+	}
+	if total > 60 {
+		t.Fatalf("total duration %.1f exceeds budget", total)
+	}
+	if len(picked) != 2 {
+		t.Fatalf("picked = %v, want two clips", picked)
+	}
+}
+
+func TestSelectWithinBudget_Empty(t *testing.T) {
+	if got := selectWithinBudget(nil, nil, 60); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+	if got := selectWithinBudget([]float64{120}, []float64{1}, 60); len(got) != 0 {
+		t.Fatalf("expected no selection for oversized clip, got %v", got)
+	}
+}
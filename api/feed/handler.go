@@ -1,17 +1,26 @@
 package feed
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"clipfeed/auth"
+	"clipfeed/datasaver"
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/maintenance"
+	"clipfeed/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Handler holds dependencies for all feed-related endpoints.
@@ -25,33 +34,63 @@ type Handler struct {
 	ltrMu    sync.RWMutex
 	ltrModel *LTRModel
 
+	lastFeedAt atomic.Int64
+
 	LTRModelPath string
+
+	// RankBudget is how long a feed request may take before ranking falls
+	// down the degradation ladder; zero always ranks fully.
+	RankBudget time.Duration
+	rankLevels [numRankLevels]atomic.Int64
+
+	// NewRankProvider builds the ranker for an experiment; nil uses an
+	// HTTPRankProvider for the experiment's URL.
+	NewRankProvider func(RankExperiment) RankProvider
+	experimentsMu   sync.RWMutex
+	experiments     []RankExperiment
+	experimentStats map[string]*experimentStats
+
+	// PresignStream issues a playable URL for a clip's storage key. When nil,
+	// include_stream is ignored.
+	PresignStream func(ctx context.Context, storageKey string, expiry time.Duration) (string, error)
+
+	// LLM completes a prompt with the configured language model, returning
+	// the text and the model's name, abandoning the call when ctx is done.
+	// When nil, /api/feed/ask is unavailable.
+	LLM func(ctx context.Context, prompt string) (text, model string, err error)
+
+	// Maintenance pauses the writes made while serving reads, such as
+	// impressions and playback queues, and the background jobs. When nil
+	// they always run.
+	Maintenance *maintenance.Guard
 }
 
-// HandleFeed serves the personalised clip feed.
-func (h *Handler) HandleFeed(w http.ResponseWriter, r *http.Request) {
-	userID, _ := auth.ExtractUserID(r)
-	limit := 20
-	fetchLimit := limit * 3
+// loadFeedPrefs returns the user's topic weights, seen-dedupe setting, and
+// ranking preferences, falling back to defaults for anonymous users. A saved
+// ranking preset replaces the individual ranking preferences.
+func (h *Handler) loadFeedPrefs(ctx context.Context, userID string) (map[string]float64, bool, FeedPrefs) {
 	dedupeSeen24h := true
 	var topicWeights map[string]float64
 	feedPrefs := FeedPrefs{
-		DiversityMix:  0.5,
-		TrendingBoost: true,
-		FreshnessBias: 0.5,
+		DiversityMix:    0.5,
+		TrendingBoost:   true,
+		FreshnessBias:   0.5,
+		ExplorationRate: 0.3,
 	}
 
 	if userID != "" {
 		var topicWeightsJSON string
 		var dedupeSeen24hRaw int
-		var diversityMix, freshnessBias float64
+		var diversityMix, freshnessBias, explorationRate float64
 		var trendingBoost int
-		if err := h.DB.QueryRowContext(r.Context(),
+		var preset string
+		if err := h.DB.QueryRowContext(ctx,
 			`SELECT COALESCE(topic_weights, '{}'), COALESCE(dedupe_seen_24h, 1),
-			        COALESCE(diversity_mix, 0.5), COALESCE(trending_boost, 1), COALESCE(freshness_bias, 0.5)
+			        COALESCE(diversity_mix, 0.5), COALESCE(trending_boost, 1), COALESCE(freshness_bias, 0.5),
+			        COALESCE(exploration_rate, 0.3), COALESCE(ranking_preset, '')
 			 FROM user_preferences WHERE user_id = ?`,
 			userID,
-		).Scan(&topicWeightsJSON, &dedupeSeen24hRaw, &diversityMix, &trendingBoost, &freshnessBias); err == nil {
+		).Scan(&topicWeightsJSON, &dedupeSeen24hRaw, &diversityMix, &trendingBoost, &freshnessBias, &explorationRate, &preset); err == nil {
 			if err := json.Unmarshal([]byte(topicWeightsJSON), &topicWeights); err != nil {
 				topicWeights = nil
 			}
@@ -59,140 +98,352 @@ func (h *Handler) HandleFeed(w http.ResponseWriter, r *http.Request) {
 			feedPrefs.DiversityMix = diversityMix
 			feedPrefs.TrendingBoost = trendingBoost == 1
 			feedPrefs.FreshnessBias = freshnessBias
+			feedPrefs.ExplorationRate = explorationRate
+			if p, ok := LookupPreset(preset); ok {
+				feedPrefs = p.FeedPrefs()
+			}
 		}
 	}
+	return topicWeights, dedupeSeen24h, feedPrefs
+}
 
-	// Check for saved filter
-	if filterID := r.URL.Query().Get("filter"); filterID != "" && userID != "" {
-		var queryStr string
-		err := h.DB.QueryRowContext(r.Context(),
-			`SELECT query FROM saved_filters WHERE id = ? AND user_id = ?`, filterID, userID,
-		).Scan(&queryStr)
-		if err == nil {
-			var fq FilterQuery
-			if json.Unmarshal([]byte(queryStr), &fq) == nil {
-				clips, err := h.ApplyFilterToFeed(r.Context(), &fq, userID, dedupeSeen24h)
-				if err == nil {
-					h.RankFeed(r.Context(), clips, userID, topicWeights, feedPrefs)
-					if len(clips) > limit {
-						clips = clips[:limit]
-					}
-					httputil.AddThumbnailURLs(clips, h.MinioBucket)
-					httputil.WriteJSON(w, 200, map[string]interface{}{"clips": clips, "count": len(clips), "filter_id": filterID})
-					return
-				}
-			}
+// FeedLimit is the number of clips in one feed response.
+const FeedLimit = 20
+
+// HandleFeed serves the personalised clip feed. preset picks a ranking
+// preset for this request in place of the user's saved preferences, and
+// mode switches to a timeline (see serveTimeline).
+func (h *Handler) HandleFeed(w http.ResponseWriter, r *http.Request) {
+	var deadline time.Time
+	if h.RankBudget > 0 {
+		deadline = time.Now().Add(h.RankBudget)
+	}
+	userID, _ := auth.ExtractUserID(r)
+	limit := FeedLimit
+	topicWeights, dedupeSeen24h, feedPrefs := h.loadFeedPrefs(r.Context(), userID)
+	savedPreset := feedPrefs.Preset
+	if name := r.URL.Query().Get("preset"); name != "" {
+		p, ok := LookupPreset(name)
+		if !ok {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "unknown preset; presets are " + PresetNames()})
+			return
 		}
+		feedPrefs = p.FeedPrefs()
+	}
+	fields := httputil.RequestedClipFields(r)
+	warmup := streamWarmupCount(r)
+	if warmup > 0 && h.streamLimited(r.Context(), userID) {
+		warmup = 0
 	}
 
-	var rows *sql.Rows
-	var err error
+	// Hold back clips while one of the user's pacing limits applies.
+	if userID != "" {
+		now := time.Now()
+		pacing := LoadPacingPrefs(r.Context(), h.DB, userID)
+		if pause := pacingPause(pacing, loadPacingUsage(r.Context(), h.DB, userID, pacing, now), now); pause != nil {
+			httputil.WriteJSON(w, 200, map[string]interface{}{
+				"clips": make([]map[string]interface{}, 0), "count": 0, "pacing": pause,
+			})
+			return
+		}
+	}
 
+	if mode := r.URL.Query().Get("mode"); mode != "" && mode != modeRanked {
+		h.serveTimeline(w, r, mode, userID, dedupeSeen24h, fields, warmup)
+		return
+	}
+
+	// A saved filter either narrows the ranked feed or, unranked, replaces
+	// it with its matches in score order.
+	var filter *FilterQuery
+	var filterID string
+	var ranked bool
 	if userID != "" {
-		halfLife := 24.0 + (1.0-feedPrefs.FreshnessBias)*648.0
-		ageHours := h.DB.AgeHoursExpr("c.created_at")
-		randFloat := h.DB.RandomFloat()
-		seenCutoff := h.DB.DatetimeModifier("-24 hours")
-
-		rows, err = h.DB.QueryContext(r.Context(), fmt.Sprintf(`
-			WITH prefs AS (
-				SELECT exploration_rate, min_clip_seconds, max_clip_seconds, dedupe_seen_24h
-				FROM user_preferences WHERE user_id = ?
-			),
-			seen AS (
-				SELECT clip_id FROM interactions
-				WHERE user_id = ? AND created_at > %s
-			)
-			SELECT c.id, c.title, c.description, c.duration_seconds,
-			       c.thumbnail_key, c.topics, c.tags, c.content_score,
-			       c.created_at, s.channel_name, s.platform, s.url,
-			       COALESCE(c.source_id, ''),
-			       CAST(LENGTH(COALESCE(c.transcript, '')) AS REAL),
-			       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
-			       COALESCE(%s, 0)
-			FROM clips c
-			LEFT JOIN sources s ON c.source_id = s.id
-			WHERE c.status = 'ready'
-			  AND (COALESCE((SELECT dedupe_seen_24h FROM prefs), 1) = 0 OR c.id NOT IN (SELECT clip_id FROM seen))
-			  AND c.duration_seconds >= COALESCE((SELECT min_clip_seconds FROM prefs), 5)
-			  AND c.duration_seconds <= COALESCE((SELECT max_clip_seconds FROM prefs), 120)
-			ORDER BY
-			    (c.content_score * EXP(-%s / ?) * (1.0 - COALESCE((SELECT exploration_rate FROM prefs), 0.3)))
-			    + (%s * COALESCE((SELECT exploration_rate FROM prefs), 0.3))
-			    DESC
-			LIMIT ?
-		`, seenCutoff, ageHours, ageHours, randFloat), userID, userID, halfLife, fetchLimit)
-	} else {
-		ageHours := h.DB.AgeHoursExpr("c.created_at")
-		randFloat := h.DB.RandomFloat()
-
-		rows, err = h.DB.QueryContext(r.Context(), fmt.Sprintf(`
-			SELECT c.id, c.title, c.description, c.duration_seconds,
-			       c.thumbnail_key, c.topics, c.tags, c.content_score,
-			       c.created_at, s.channel_name, s.platform, s.url,
-			       COALESCE(c.source_id, ''),
-			       CAST(LENGTH(COALESCE(c.transcript, '')) AS REAL),
-			       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
-			       COALESCE(%s, 0)
-			FROM clips c
-			LEFT JOIN sources s ON c.source_id = s.id
-			WHERE c.status = 'ready'
-			ORDER BY (c.content_score * EXP(-%s / 168.0) * 0.7)
-			    + (%s * 0.3) DESC
-			LIMIT ?
-		`, ageHours, ageHours, randFloat), fetchLimit)
+		filter, filterID, ranked = h.feedFilter(r, userID)
+	}
+	if filter != nil && !ranked {
+		clips, err := h.filteredClips(r, filter, userID, dedupeSeen24h, fields, warmup)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
+			return
+		}
+		httputil.WriteJSON(w, 200, map[string]interface{}{"clips": clips, "count": len(clips), "filter_id": filterID, "ranked": false})
+		return
 	}
 
+	page, err := h.rankPage(r.Context(), userID, limit, topicWeights, dedupeSeen24h, feedPrefs, savedPreset, filter, nil, deadline)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
 		return
 	}
-	defer rows.Close()
+	clips := page.clips
+	h.shapeFeedClips(r.Context(), clips, fields)
+	h.addStreamURLs(r.Context(), clips, warmup)
+	result := map[string]interface{}{
+		"clips": clips, "count": len(clips), "precomputed": page.precomputed, "rank_level": page.rankLevel,
+	}
+	if page.experiment != "" {
+		result["experiment"] = page.experiment
+	}
+	if feedPrefs.Preset != "" {
+		result["preset"] = feedPrefs.Preset
+	}
+	if filter != nil {
+		result["filter_id"], result["ranked"] = filterID, true
+	}
+	httputil.WriteJSON(w, 200, result)
+}
+
+// rankedPage is one page of the ranked feed before it is shaped for a
+// client.
+type rankedPage struct {
+	clips       []map[string]interface{}
+	precomputed bool
+	rankLevel   string
+	experiment  string
+}
+
+// rankPage is the ranking core the feed and its variants share: it
+// generates candidates, ranks them, drops blocked clips, then mixes in
+// bandit exploration and the next parts of series the user is following.
+// filter, when set, narrows the candidates; clips in exclude are left out.
+// Ranking degrades once deadline passes, unless it is zero.
+func (h *Handler) rankPage(ctx context.Context, userID string, limit int, topicWeights map[string]float64, dedupeSeen24h bool, feedPrefs FeedPrefs, savedPreset string, filter *FilterQuery, exclude map[string]bool, deadline time.Time) (_ *rankedPage, err error) {
+	fetchLimit := limit*3 + len(exclude)
+	page := &rankedPage{}
+	ctx, span := tracing.Start(ctx, "feed.rank", attribute.Int("feed.limit", limit))
+	defer func() {
+		span.SetAttributes(attribute.Bool("feed.precomputed", page.precomputed), attribute.String("feed.rank_level", page.rankLevel))
+		tracing.End(span, err)
+	}()
+
+	// Serve signed-in users from their precomputed candidate list when one is
+	// fresh and still deep enough; only re-ranking happens at request time.
+	// The list was generated under the saved preferences, so a per-request
+	// preset or a chronological feed queries afresh.
+	var clips []map[string]interface{}
+	if userID != "" && filter == nil && feedPrefs.Preset == savedPreset && !feedPrefs.Chronological {
+		h.markFeedRequest()
+		if pre := dropExcluded(h.precomputedCandidates(ctx, userID, dedupeSeen24h), exclude); len(pre) >= limit {
+			if len(pre) > fetchLimit {
+				pre = pre[:fetchLimit]
+			}
+			clips, page.precomputed = pre, true
+		}
+	}
+
+	if filter != nil {
+		var err error
+		if clips, err = h.ApplyFilterToFeed(ctx, filter, userID, dedupeSeen24h); err != nil {
+			return nil, err
+		}
+	} else if !page.precomputed {
+		var rows *sql.Rows
+		var err error
+		if userID != "" {
+			rows, err = h.queryPersonalCandidates(ctx, userID, feedPrefs, fetchLimit)
+		} else {
+			ageHours := h.DB.AgeHoursExpr("c.created_at")
+			order := fmt.Sprintf("(c.content_score * EXP(-%s / 168.0) * 0.7) + (%s * 0.3) DESC", ageHours, h.DB.RandomFloat())
+			if feedPrefs.Chronological {
+				order = "c.created_at DESC"
+			}
+
+			rows, err = h.DB.QueryContext(ctx, fmt.Sprintf(`
+				SELECT c.id, c.title, c.description, c.duration_seconds,
+				       c.thumbnail_key, c.topics, c.tags, c.content_score,
+				       c.created_at, s.channel_name, s.platform, s.url,
+				       COALESCE(c.source_id, ''),
+				       CAST(c.transcript_length AS REAL),
+				       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+				       COALESCE(%s, 0)
+				FROM clips c
+				LEFT JOIN sources s ON c.source_id = s.id
+				WHERE c.status = 'ready'
+				ORDER BY %s
+				LIMIT ?
+			`, ageHours, order), fetchLimit)
+		}
+		if err != nil {
+			return nil, err
+		}
+		clips = httputil.ScanClips(rows)
+		rows.Close()
+	}
+	clips = dropExcluded(clips, exclude)
+
+	// Users bucketed into a ranking experiment are ranked by its external
+	// ranker, falling back to the built-in pipeline if it fails.
+	var exp RankExperiment
+	var bucket int
+	var inExperiment bool
+	if feedPrefs.Chronological {
+		sortChronological(clips)
+		page.rankLevel = "chronological"
+	} else {
+		exp, bucket, inExperiment = h.rankExperimentFor(userID)
+	}
+	if inExperiment {
+		if err := h.rankExternal(ctx, exp, bucket, clips, userID, topicWeights, feedPrefs); err != nil {
+			log.Printf("rank experiment %s: %v", exp.Name, err)
+		} else {
+			page.rankLevel = "external"
+		}
+		page.experiment = exp.Name
+	}
+	if page.rankLevel == "" {
+		level := h.rankWithin(ctx, clips, userID, topicWeights, feedPrefs, deadline)
+		h.recordRankLevel(level)
+		page.rankLevel = rankLevelNames[level]
+	}
+	stripRankingFields(clips)
+	clips = h.dropBlocked(ctx, userID, clips)
+
+	// Signed-in users reserve exploration_rate of the page for clips chosen by
+	// the per-user topic bandit rather than random noise in the ranking.
+	exploreSlots := 0
+	if userID != "" {
+		exploreSlots = int(math.Round(float64(limit) * feedPrefs.ExplorationRate))
+	}
+	if len(clips) > limit-exploreSlots {
+		clips = clips[:limit-exploreSlots]
+	}
+	if exploreSlots > 0 {
+		skip := make(map[string]bool, len(clips)+len(exclude))
+		for id := range exclude {
+			skip[id] = true
+		}
+		for _, c := range clips {
+			skip[c["id"].(string)] = true
+		}
+		explore := h.exploreClips(ctx, userID, exploreSlots, skip)
+		if filter != nil {
+			explore = h.keepFilterMatches(ctx, filter, explore)
+		}
+		stripRankingFields(explore)
+		clips = interleaveExploration(clips, h.dropBlocked(ctx, userID, explore))
+	}
+	if userID != "" {
+		next := dropExcluded(h.nextSeriesParts(ctx, userID, seriesFeedSlots), exclude)
+		if filter != nil {
+			next = h.keepFilterMatches(ctx, filter, next)
+		}
+		clips = pinSeriesParts(clips, h.dropBlocked(ctx, userID, next), limit)
+	}
+	page.clips = clips
+	return page, nil
+}
 
-	clips := httputil.ScanClips(rows)
-	h.RankFeed(r.Context(), clips, userID, topicWeights, feedPrefs)
-	if len(clips) > limit {
-		clips = clips[:limit]
+// dropExcluded returns clips without those whose IDs are in exclude.
+func dropExcluded(clips []map[string]interface{}, exclude map[string]bool) []map[string]interface{} {
+	if len(exclude) == 0 {
+		return clips
+	}
+	kept := clips[:0]
+	for _, c := range clips {
+		if id, _ := c["id"].(string); !exclude[id] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// filteredClips returns one feed page of the clips fq matches, best content
+// score first, shaped like the ranked feed.
+func (h *Handler) filteredClips(r *http.Request, fq *FilterQuery, userID string, dedupeSeen24h bool, fields []string, warmup int) ([]map[string]interface{}, error) {
+	clips, err := h.ApplyFilterToFeed(r.Context(), fq, userID, dedupeSeen24h)
+	if err != nil {
+		return nil, err
+	}
+	stripRankingFields(clips)
+	clips = h.dropBlocked(r.Context(), userID, clips)
+	if len(clips) > FeedLimit {
+		clips = clips[:FeedLimit]
+	}
+	h.shapeFeedClips(r.Context(), clips, fields)
+	h.addStreamURLs(r.Context(), clips, warmup)
+	return clips, nil
+}
+
+// shapeFeedClips picks thumbnail variants, adds thumbnail URLs and
+// attributions, then trims clips to the requested fields. Attribution and
+// thumbnail lookups are skipped when not selected.
+func (h *Handler) shapeFeedClips(ctx context.Context, clips []map[string]interface{}, fields []string) {
+	// Data saver mode serves each clip's small thumbnail in place of the
+	// sampled variants.
+	saver := httputil.WantsField(fields, "thumbnail_url") && datasaver.Enabled(ctx, h.DB)
+	var thumbnails map[string]int
+	if httputil.WantsField(fields, "thumbnail_url") && !saver {
+		thumbnails = h.selectThumbnails(ctx, clips)
 	}
 	httputil.AddThumbnailURLs(clips, h.MinioBucket)
-	httputil.WriteJSON(w, 200, map[string]interface{}{"clips": clips, "count": len(clips)})
+	if saver {
+		h.useSmallThumbnails(ctx, clips)
+	}
+	if httputil.WantsField(fields, "attribution") {
+		httputil.AddAttributions(ctx, h.DB, clips)
+	}
+	httputil.SelectClipFields(clips, fields)
+	addThumbnailVariants(clips, thumbnails)
 }
 
-// HandleSearch handles full-text search across clips.
+// HandleSearch handles full-text search across clips. q accepts the
+// structured syntax ParseSearchQuery describes; a malformed query answers
+// 400 with the column of the problem, and debug=true echoes the parsed
+// query. collection_id limits matches to a collection the caller owns or
+// that is public, and channel to clips from one channel.
+//
+// Hits are collapsed to the best one per channel, each noting how many
+// more matches its channel has; searching again with channel set expands
+// them. The list is then diversified by topic. collapse=false turns both
+// off, returning matches in plain relevance order; a channel search is
+// diversified but not collapsed.
 func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	if q == "" {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "q required"})
 		return
 	}
+	nodes, err := ParseSearchQuery(q)
+	var parseErr *SearchParseError
+	if errors.As(err, &parseErr) {
+		httputil.WriteJSON(w, 400, map[string]interface{}{
+			"error": "invalid query: " + parseErr.Msg, "position": parseErr.Pos,
+		})
+		return
+	}
+	if len(nodes) == 0 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "q required"})
+		return
+	}
+	plan := h.planSearch(nodes)
 
-	var rows *sql.Rows
-	var err error
-
-	if h.DB.IsPostgres() {
-		rows, err = h.DB.QueryContext(r.Context(), `
-			SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
-			       c.topics, c.content_score, s.platform, s.channel_name, s.url
-			FROM clips_fts
-			JOIN clips c ON clips_fts.clip_id = c.id
-			LEFT JOIN sources s ON c.source_id = s.id
-			WHERE clips_fts.tsv @@ plainto_tsquery('english', ?) AND c.status = 'ready'
-			ORDER BY ts_rank(clips_fts.tsv, plainto_tsquery('english', ?)) DESC, c.content_score DESC
-			LIMIT 20
-		`, q, q)
-	} else {
-		ftsQ := `"` + strings.ReplaceAll(q, `"`, `""`) + `"`
-		rows, err = h.DB.QueryContext(r.Context(), `
-			SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
-			       c.topics, c.content_score, s.platform, s.channel_name, s.url
-			FROM clips_fts
-			JOIN clips c ON clips_fts.clip_id = c.id
-			LEFT JOIN sources s ON c.source_id = s.id
-			WHERE clips_fts MATCH ? AND c.status = 'ready'
-			ORDER BY bm25(clips_fts), c.content_score DESC
-			LIMIT 20
-		`, ftsQ)
+	var scope string
+	var scopeArgs []interface{}
+	collectionID := r.URL.Query().Get("collection_id")
+	if collectionID != "" {
+		userID, _ := auth.ExtractUserID(r)
+		var visible int
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT 1 FROM collections WHERE id = ? AND (is_public = 1 OR user_id = ?)`, collectionID, userID,
+		).Scan(&visible); err != nil {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+			return
+		}
+		scope += " AND c.id IN (SELECT clip_id FROM collection_clips WHERE collection_id = ?)"
+		scopeArgs = append(scopeArgs, collectionID)
+	}
+	channel := r.URL.Query().Get("channel")
+	if channel != "" {
+		scope += " AND s.channel_name = ?"
+		scopeArgs = append(scopeArgs, channel)
+	}
+	collapse := r.URL.Query().Get("collapse") != "false"
+	limit := searchLimit
+	if collapse && channel == "" {
+		limit = searchPoolSize
 	}
+	rows, err := h.querySearch(r.Context(), plan, scope, scopeArgs, limit)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "search failed"})
 		return
@@ -212,6 +463,7 @@ func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		json.Unmarshal([]byte(topicsJSON), &topics)
 		hits = append(hits, map[string]interface{}{
 			"id": id, "title": title, "duration_seconds": duration,
+			"duration_bucket": httputil.DurationBucket(duration),
 			"thumbnail_key": thumbnailKey, "topics": topics,
 			"content_score": score, "platform": platform, "channel_name": channelName,
 			"source_url": sourceURL,
@@ -220,7 +472,74 @@ func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 	if err := rows.Err(); err != nil {
 		log.Printf("HandleSearch: rows iteration error: %v", err)
 	}
-	httputil.WriteJSON(w, 200, map[string]interface{}{"hits": hits, "query": q, "total": len(hits)})
+	if collapse {
+		if channel == "" {
+			hits = collapseChannels(hits)
+		}
+		h.diversifySearchHits(hits)
+		if len(hits) > searchLimit {
+			hits = hits[:searchLimit]
+		}
+	}
+	result := map[string]interface{}{"hits": hits, "query": q, "total": len(hits), "collapsed": collapse && channel == ""}
+	if collectionID != "" {
+		result["collection_id"] = collectionID
+	}
+	if channel != "" {
+		result["channel"] = channel
+	}
+	if r.URL.Query().Get("debug") == "true" {
+		result["query_ast"] = nodes
+	}
+	httputil.WriteJSON(w, 200, result)
+}
+
+// querySearch runs plan over ready clips, adding the conditions in scope,
+// and returns up to limit hits best first: by full-text rank when the query
+// has terms, by content score otherwise.
+func (h *Handler) querySearch(ctx context.Context, plan searchPlan, scope string, scopeArgs []interface{}, limit int) (*sql.Rows, error) {
+	for _, cond := range plan.where {
+		scope += " AND " + cond
+	}
+	scopeArgs = append(append([]interface{}{}, scopeArgs...), plan.whereArgs...)
+
+	switch {
+	case len(plan.match) == 0:
+		// Only exclusions and filters: nothing to rank by relevance.
+		return h.DB.QueryContext(ctx, `
+			SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
+			       c.topics, c.content_score, s.platform, s.channel_name, s.url
+			FROM clips c
+			LEFT JOIN sources s ON c.source_id = s.id
+			WHERE c.status = 'ready'`+scope+`
+			ORDER BY c.content_score DESC, c.created_at DESC
+			LIMIT ?
+		`, append(scopeArgs, limit)...)
+	case h.DB.IsPostgres():
+		ftsArgs := h.ftsArgs(plan.match)
+		args := append(append(append([]interface{}{}, ftsArgs...), scopeArgs...), ftsArgs...)
+		return h.DB.QueryContext(ctx, `
+			SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
+			       c.topics, c.content_score, s.platform, s.channel_name, s.url
+			FROM clips_fts
+			JOIN clips c ON clips_fts.clip_id = c.id
+			LEFT JOIN sources s ON c.source_id = s.id
+			WHERE `+h.ftsCondition(len(plan.match))+` AND c.status = 'ready'`+scope+`
+			ORDER BY ts_rank(clips_fts.tsv, `+ftsTSQuery(len(plan.match))+`) DESC, c.content_score DESC
+			LIMIT ?
+		`, append(args, limit)...)
+	default:
+		return h.DB.QueryContext(ctx, `
+			SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
+			       c.topics, c.content_score, s.platform, s.channel_name, s.url
+			FROM clips_fts
+			JOIN clips c ON clips_fts.clip_id = c.id
+			LEFT JOIN sources s ON c.source_id = s.id
+			WHERE `+h.ftsCondition(len(plan.match))+` AND c.status = 'ready'`+scope+`
+			ORDER BY bm25(clips_fts), c.content_score DESC
+			LIMIT ?
+		`, append(append(h.ftsArgs(plan.match), scopeArgs...), limit)...)
+	}
 }
 
 // ComputeTopicBoost computes a simple weighted average boost for clip topics.
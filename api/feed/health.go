@@ -0,0 +1,89 @@
+package feed
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+// RankingStatus reports the health of the ranking subsystem for the admin
+// status page: the in-memory topic graph and LTR model, and how much of the
+// ready library has embeddings for similarity search.
+func (h *Handler) RankingStatus(ctx context.Context) map[string]interface{} {
+	now := time.Now().UTC()
+	status := make(map[string]interface{})
+
+	topicGraph := map[string]interface{}{"loaded": false}
+	if g := h.GetTopicGraph(); g != nil {
+		topicGraph = map[string]interface{}{
+			"loaded":           true,
+			"nodes":            len(g.Nodes),
+			"edges":            g.EdgeCount,
+			"canonical_merges": len(g.Canonical),
+			"refreshed_at":     g.LoadedAt.Format("2006-01-02T15:04:05Z"),
+			"age_seconds":      int(now.Sub(g.LoadedAt).Seconds()),
+		}
+	}
+	status["topic_graph"] = topicGraph
+
+	ltr := map[string]interface{}{"loaded": false}
+	if m := h.GetLTRModel(); m != nil {
+		// Models exported before trained_at was recorded fall back to the
+		// file's modification time.
+		trainedAt := m.ModifiedAt
+		if t, err := time.Parse(time.RFC3339Nano, m.TrainedAt); err == nil {
+			trainedAt = t.UTC()
+		}
+		ltr = map[string]interface{}{
+			"loaded":     true,
+			"version":    m.Version,
+			"trees":      len(m.Trees),
+			"features":   m.NumFeatures,
+			"ndcg_at_10": m.NDCGAt10,
+			"loaded_at":  m.LoadedAt.Format("2006-01-02T15:04:05Z"),
+		}
+		if !trainedAt.IsZero() {
+			ltr["trained_at"] = trainedAt.Format("2006-01-02T15:04:05Z")
+			ltr["age_hours"] = math.Round(now.Sub(trainedAt).Hours()*10) / 10
+		}
+	}
+	status["ltr_model"] = ltr
+
+	var readyClips, embedded int
+	var latest *string
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM clips WHERE status = 'ready'),
+			(SELECT COUNT(*) FROM clip_embeddings e JOIN clips c ON c.id = e.clip_id
+			 WHERE c.status = 'ready' AND (e.text_embedding IS NOT NULL OR e.visual_embedding IS NOT NULL)),
+			(SELECT MAX(created_at) FROM clip_embeddings)
+	`).Scan(&readyClips, &embedded, &latest); err != nil {
+		log.Printf("ranking status: embedding stats query failed: %v", err)
+	}
+	coverage := 0.0
+	if readyClips > 0 {
+		coverage = math.Round(float64(embedded)/float64(readyClips)*1000) / 10
+	}
+	status["embeddings"] = map[string]interface{}{
+		"ready_clips":  readyClips,
+		"embedded":     embedded,
+		"coverage_pct": coverage,
+	}
+
+	// Similarity search scans clip_embeddings directly rather than keeping a
+	// separate ANN index, so the index is as fresh as the newest embedding.
+	index := map[string]interface{}{
+		"kind":                "exact_scan",
+		"latest_embedding_at": latest,
+		"missing":             readyClips - embedded,
+	}
+	if latest != nil {
+		if t, err := time.Parse("2006-01-02T15:04:05Z", *latest); err == nil {
+			index["age_seconds"] = int(now.Sub(t).Seconds())
+		}
+	}
+	status["ann_index"] = index
+
+	return status
+}
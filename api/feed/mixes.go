@@ -0,0 +1,335 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	mixMinClips       = 20
+	mixMaxClips       = 30
+	mixAffinityTopics = 3
+	mixPerTopic       = 5
+	mixTrendingClips  = 6
+	mixSerendipity    = 4
+)
+
+// mixBucket is one source of clips for a mix (an affinity topic, trending,
+// the serendipity topic) along with how many clips it may contribute.
+type mixBucket struct {
+	reason string
+	quota  int
+	clips  []map[string]interface{}
+}
+
+type mixEntry struct {
+	clipID string
+	reason string
+}
+
+// mixCandidates returns ready clips matching extra, excluding anything the
+// user has seen in the last day or that appeared in their mixes this week.
+func (h *Handler) mixCandidates(ctx context.Context, userID, extra, orderBy string, limit int, args ...interface{}) []map[string]interface{} {
+	query := fmt.Sprintf(`
+		SELECT c.id, c.title, c.description, c.duration_seconds,
+		       c.thumbnail_key, c.topics, c.tags, c.content_score,
+		       c.created_at, s.channel_name, s.platform, s.url,
+		       COALESCE(c.source_id, ''),
+		       CAST(c.transcript_length AS REAL),
+		       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+		       COALESCE(%s, 0)
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready'
+		  AND c.id NOT IN (SELECT clip_id FROM interactions WHERE user_id = ? AND created_at > %s)
+		  AND c.id NOT IN (
+		      SELECT mc.clip_id FROM mix_clips mc JOIN mixes m ON mc.mix_id = m.id
+		      WHERE m.user_id = ? AND m.created_at > %s)
+		  %s
+		ORDER BY %s
+		LIMIT ?
+	`, h.DB.AgeHoursExpr("c.created_at"), h.DB.DatetimeModifier("-24 hours"), h.DB.DatetimeModifier("-7 days"), extra, orderBy)
+
+	fullArgs := append([]interface{}{userID, userID}, args...)
+	fullArgs = append(fullArgs, limit)
+	rows, err := h.DB.QueryContext(ctx, query, fullArgs...)
+	if err != nil {
+		log.Printf("mixCandidates: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	return httputil.ScanClips(rows)
+}
+
+// generateDailyMix picks an ordered clip list from the user's top topic
+// affinities, currently trending clips, and one topic the user has never
+// shown interest in. Buckets are interleaved so no single source dominates.
+func (h *Handler) generateDailyMix(ctx context.Context, userID string) ([]mixEntry, string) {
+	var buckets []*mixBucket
+	byTopic := "AND c.id IN (SELECT clip_id FROM clip_topics WHERE topic_id = ?)"
+
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT t.id, t.name FROM user_topic_affinities a
+		JOIN topics t ON a.topic_id = t.id
+		WHERE a.user_id = ? AND a.weight > 0
+		ORDER BY a.weight DESC LIMIT ?
+	`, userID, mixAffinityTopics)
+	if err == nil {
+		type topicRef struct{ id, name string }
+		var topics []topicRef
+		for rows.Next() {
+			var t topicRef
+			if rows.Scan(&t.id, &t.name) == nil {
+				topics = append(topics, t)
+			}
+		}
+		rows.Close()
+		for _, t := range topics {
+			buckets = append(buckets, &mixBucket{
+				reason: "affinity:" + t.name,
+				quota:  mixPerTopic,
+				clips:  h.mixCandidates(ctx, userID, byTopic, "c.content_score DESC", mixPerTopic*2, t.id),
+			})
+		}
+	}
+
+	trendingOrder := fmt.Sprintf(`(SELECT COUNT(*) FROM interactions i WHERE i.clip_id = c.id AND i.created_at > %s) DESC, c.content_score DESC`,
+		h.DB.DatetimeModifier("-24 hours"))
+	buckets = append(buckets, &mixBucket{
+		reason: "trending",
+		quota:  mixTrendingClips,
+		clips:  h.mixCandidates(ctx, userID, "", trendingOrder, mixTrendingClips*2),
+	})
+
+	var serendipityID, serendipityName string
+	err = h.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT id, name FROM topics
+		WHERE clip_count >= 3
+		  AND id NOT IN (SELECT topic_id FROM user_topic_affinities WHERE user_id = ?)
+		ORDER BY %s LIMIT 1
+	`, h.DB.RandomFloat()), userID).Scan(&serendipityID, &serendipityName)
+	if err == nil {
+		buckets = append(buckets, &mixBucket{
+			reason: "serendipity:" + serendipityName,
+			quota:  mixSerendipity,
+			clips:  h.mixCandidates(ctx, userID, byTopic, "c.content_score DESC", mixSerendipity*2, serendipityID),
+		})
+	}
+
+	seen := make(map[string]bool)
+	var entries []mixEntry
+	take := func(b *mixBucket) bool {
+		for len(b.clips) > 0 && b.quota > 0 {
+			id, _ := b.clips[0]["id"].(string)
+			b.clips = b.clips[1:]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			b.quota--
+			entries = append(entries, mixEntry{clipID: id, reason: b.reason})
+			return true
+		}
+		return false
+	}
+	for progress := true; progress && len(entries) < mixMaxClips; {
+		progress = false
+		for _, b := range buckets {
+			if len(entries) >= mixMaxClips {
+				break
+			}
+			if take(b) {
+				progress = true
+			}
+		}
+	}
+
+	// Thin libraries or new users: top up with generally strong, fresh clips.
+	if len(entries) < mixMinClips {
+		filler := &mixBucket{
+			reason: "top",
+			quota:  mixMinClips - len(entries),
+			clips:  h.mixCandidates(ctx, userID, "", "c.content_score * EXP(-COALESCE("+h.DB.AgeHoursExpr("c.created_at")+", 0) / 168.0) DESC", mixMaxClips*2),
+		}
+		for take(filler) {
+		}
+	}
+
+	return entries, serendipityName
+}
+
+// dailyMix returns today's mix for the user, generating and persisting it on
+// first request.
+func (h *Handler) dailyMix(ctx context.Context, userID string) (string, error) {
+	date := time.Now().UTC().Format("2006-01-02")
+
+	var mixID string
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT id FROM mixes WHERE user_id = ? AND mix_date = ?`, userID, date,
+	).Scan(&mixID); err == nil {
+		return mixID, nil
+	}
+
+	entries, serendipity := h.generateDailyMix(ctx, userID)
+	mixID = uuid.New().String()
+	var serendipityArg interface{}
+	if serendipity != "" {
+		serendipityArg = serendipity
+	}
+
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO mixes (id, user_id, mix_date, title, serendipity_topic) VALUES (?, ?, ?, ?, ?)`,
+			mixID, userID, date, "Your mix for "+date, serendipityArg); err != nil {
+			return err
+		}
+		for i, e := range entries {
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO mix_clips (mix_id, clip_id, position, reason) VALUES (?, ?, ?, ?)`,
+				mixID, e.clipID, i, e.reason); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// A concurrent request may have created today's mix first.
+		errMsg := err.Error()
+		if strings.Contains(errMsg, "UNIQUE") || strings.Contains(errMsg, "duplicate key") {
+			if qErr := h.DB.QueryRowContext(ctx,
+				`SELECT id FROM mixes WHERE user_id = ? AND mix_date = ?`, userID, date,
+			).Scan(&mixID); qErr == nil {
+				return mixID, nil
+			}
+		}
+		return "", err
+	}
+	return mixID, nil
+}
+
+// writeMix responds with a persisted mix and its clips in playlist order.
+func (h *Handler) writeMix(w http.ResponseWriter, r *http.Request, userID, mixID string) {
+	var date, title, createdAt string
+	var serendipity *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT mix_date, title, serendipity_topic, created_at FROM mixes WHERE id = ? AND user_id = ?`, mixID, userID,
+	).Scan(&date, &title, &serendipity, &createdAt); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "mix not found"})
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT c.id, c.title, c.description, c.duration_seconds,
+		       c.thumbnail_key, c.topics, c.tags, c.content_score,
+		       c.created_at, s.channel_name, s.platform, s.url,
+		       COALESCE(c.source_id, ''),
+		       CAST(c.transcript_length AS REAL),
+		       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+		       COALESCE(%s, 0)
+		FROM mix_clips mc
+		JOIN clips c ON mc.clip_id = c.id
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE mc.mix_id = ? AND c.status = 'ready'
+		ORDER BY mc.position
+	`, h.DB.AgeHoursExpr("c.created_at")), mixID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load mix"})
+		return
+	}
+	clips := httputil.ScanClips(rows)
+	rows.Close()
+
+	reasons := make(map[string]string)
+	if rrows, err := h.DB.QueryContext(r.Context(),
+		`SELECT clip_id, reason FROM mix_clips WHERE mix_id = ?`, mixID); err == nil {
+		for rrows.Next() {
+			var clipID, reason string
+			if rrows.Scan(&clipID, &reason) == nil {
+				reasons[clipID] = reason
+			}
+		}
+		rrows.Close()
+	}
+
+	var totalDuration float64
+	for _, clip := range clips {
+		id, _ := clip["id"].(string)
+		clip["mix_reason"] = reasons[id]
+		if d, ok := clip["duration_seconds"].(float64); ok {
+			totalDuration += d
+		}
+	}
+	stripRankingFields(clips)
+	httputil.AddThumbnailURLs(clips, h.MinioBucket)
+	httputil.AddAttributions(r.Context(), h.DB, clips)
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"id": mixID, "date": date, "title": title,
+		"serendipity_topic": serendipity, "created_at": createdAt,
+		"clips": clips, "count": len(clips), "total_duration_seconds": totalDuration,
+	})
+}
+
+// HandleDailyMix returns today's mix for the user, generating it on first
+// request of the day. The mix is stable across refreshes.
+func (h *Handler) HandleDailyMix(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	mixID, err := h.dailyMix(r.Context(), userID)
+	if err != nil {
+		log.Printf("daily mix for %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build daily mix"})
+		return
+	}
+	h.writeMix(w, r, userID, mixID)
+}
+
+// HandleGetMix returns one of the user's past mixes.
+func (h *Handler) HandleGetMix(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	h.writeMix(w, r, userID, chi.URLParam(r, "id"))
+}
+
+// HandleListMixes lists the user's past mixes, newest first.
+func (h *Handler) HandleListMixes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT m.id, m.mix_date, m.title, m.serendipity_topic, m.created_at, COUNT(mc.clip_id)
+		FROM mixes m
+		LEFT JOIN mix_clips mc ON mc.mix_id = m.id
+		WHERE m.user_id = ?
+		GROUP BY m.id, m.mix_date, m.title, m.serendipity_topic, m.created_at
+		ORDER BY m.mix_date DESC
+		LIMIT 60
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list mixes"})
+		return
+	}
+	defer rows.Close()
+
+	mixes := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, date, title, createdAt string
+		var serendipity *string
+		var clipCount int
+		if err := rows.Scan(&id, &date, &title, &serendipity, &createdAt, &clipCount); err != nil {
+			continue
+		}
+		mixes = append(mixes, map[string]interface{}{
+			"id": id, "date": date, "title": title, "serendipity_topic": serendipity,
+			"created_at": createdAt, "clip_count": clipCount,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"mixes": mixes})
+}
@@ -0,0 +1,88 @@
+package feed
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"clipfeed/httputil"
+)
+
+// Feed modes. The ranked feed is the default; the others are timelines,
+// newest clip first, without ranking or exploration.
+const (
+	modeRanked  = "ranked"
+	modeLatest  = "latest"
+	modeChannel = "channel"
+	modeTopic   = "topic"
+)
+
+// timelineMaxLimit caps the page size a timeline request may ask for.
+const timelineMaxLimit = 50
+
+// serveTimeline answers a feed request in one of the timeline modes: latest
+// (every clip), channel (clips from channel=X), or topic (clips tagged
+// topic=Y or one of its descendants). Timelines share the ranked feed's
+// seen-dedupe, snoozes, content filters, and saved filter, and page with
+// before=<clip id>, the next_before of the previous page, so clips seen in
+// the meantime never shift a page.
+func (h *Handler) serveTimeline(w http.ResponseWriter, r *http.Request, mode, userID string, dedupeSeen24h bool, fields []string, warmup int) {
+	q := r.URL.Query()
+	where, args := h.filterConditions(&FilterQuery{}, userID, dedupeSeen24h)
+	result := map[string]interface{}{"mode": mode}
+	switch mode {
+	case modeLatest:
+	case modeChannel, modeTopic:
+		value := strings.TrimSpace(q.Get(mode))
+		if value == "" {
+			httputil.WriteJSON(w, 400, map[string]string{"error": mode + " mode needs " + mode + "="})
+			return
+		}
+		cond, condArgs := h.fieldCondition(SearchNode{Field: mode, Value: value})
+		where = append(where, cond)
+		args = append(args, condArgs...)
+		result[mode] = value
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "unknown mode; modes are ranked, latest, channel, topic"})
+		return
+	}
+
+	if userID != "" {
+		if fq, filterID, _ := h.feedFilter(r, userID); fq != nil {
+			filterWhere, filterArgs := h.filterConditions(fq, "", false)
+			where = append(where, filterWhere...)
+			args = append(args, filterArgs...)
+			result["filter_id"] = filterID
+		}
+	}
+	if before := q.Get("before"); before != "" {
+		var createdAt string
+		if err := h.DB.QueryRowContext(r.Context(), `SELECT created_at FROM clips WHERE id = ?`, before).Scan(&createdAt); err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "before must be a clip id from a previous page"})
+			return
+		}
+		where = append(where, "(c.created_at < ? OR (c.created_at = ? AND c.id < ?))")
+		args = append(args, createdAt, createdAt, before)
+	}
+
+	limit := FeedLimit
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n <= timelineMaxLimit {
+		limit = n
+	}
+	clips, err := h.queryClipsWhere(r.Context(), where, args, userID, "c.created_at DESC, c.id DESC", limit+1, 0)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
+		return
+	}
+	var nextBefore interface{}
+	if len(clips) > limit {
+		clips = clips[:limit]
+		nextBefore = clips[limit-1]["id"]
+	}
+	stripRankingFields(clips)
+	clips = h.dropBlocked(r.Context(), userID, clips)
+	h.shapeFeedClips(r.Context(), clips, fields)
+	h.addStreamURLs(r.Context(), clips, warmup)
+	result["clips"], result["count"], result["next_before"] = clips, len(clips), nextBefore
+	httputil.WriteJSON(w, 200, result)
+}
@@ -0,0 +1,298 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+const (
+	maxDailyClipLimit    = 1000
+	maxBreakAfterClips   = 500
+	maxBreakMinutes      = 120
+	defaultBreakMinutes  = 5
+	dailyUsageRetainDays = 7
+)
+
+// Pacing states reported when the feed holds back clips.
+const (
+	PacingQuietHours = "quiet_hours"
+	PacingDailyLimit = "daily_limit"
+	PacingBreak      = "break"
+)
+
+// PacingPrefs are the user's limits against doomscrolling. Nil pointers
+// disable a limit.
+type PacingPrefs struct {
+	MaxClipsPerDay  *int    `json:"max_clips_per_day"`
+	QuietHoursStart *string `json:"quiet_hours_start"`
+	QuietHoursEnd   *string `json:"quiet_hours_end"`
+	BreakAfterClips *int    `json:"break_after_clips"`
+	BreakMinutes    int     `json:"break_minutes"`
+	Timezone        string  `json:"timezone"`
+}
+
+func (p PacingPrefs) location() *time.Location {
+	if loc, err := time.LoadLocation(p.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// quietUntil reports whether now falls in the user's quiet hours and, if
+// so, when they end. Quiet hours may wrap past midnight.
+func (p PacingPrefs) quietUntil(now time.Time) (time.Time, bool) {
+	if p.QuietHoursStart == nil || p.QuietHoursEnd == nil {
+		return time.Time{}, false
+	}
+	start, ok1 := parseClock(*p.QuietHoursStart)
+	end, ok2 := parseClock(*p.QuietHoursEnd)
+	if !ok1 || !ok2 || start == end {
+		return time.Time{}, false
+	}
+	local := now.In(p.location())
+	minute := local.Hour()*60 + local.Minute()
+	inside := (start < end && minute >= start && minute < end) ||
+		(start > end && (minute >= start || minute < end))
+	if !inside {
+		return time.Time{}, false
+	}
+	resume := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, local.Location())
+	if !resume.After(local) {
+		resume = resume.AddDate(0, 0, 1)
+	}
+	return resume, true
+}
+
+// LoadPacingPrefs reads the user's pacing preferences, with defaults for
+// users who have none.
+func LoadPacingPrefs(ctx context.Context, cdb *db.CompatDB, userID string) PacingPrefs {
+	p := PacingPrefs{BreakMinutes: defaultBreakMinutes, Timezone: "UTC"}
+	if err := cdb.QueryRowContext(ctx, `
+		SELECT max_clips_per_day, quiet_hours_start, quiet_hours_end, break_after_clips,
+		       COALESCE(break_minutes, 5), COALESCE(timezone, 'UTC')
+		FROM user_preferences WHERE user_id = ?
+	`, userID).Scan(&p.MaxClipsPerDay, &p.QuietHoursStart, &p.QuietHoursEnd, &p.BreakAfterClips,
+		&p.BreakMinutes, &p.Timezone); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("pacing prefs %s: %v", userID, err)
+	}
+	return p
+}
+
+type pacingUsage struct {
+	ClipsToday int
+	Streak     int
+	BreakUntil time.Time
+}
+
+func loadPacingUsage(ctx context.Context, cdb *db.CompatDB, userID string, p PacingPrefs, now time.Time) pacingUsage {
+	var u pacingUsage
+	cdb.QueryRowContext(ctx, `SELECT clips_viewed FROM user_daily_usage WHERE user_id = ? AND day = ?`,
+		userID, now.In(p.location()).Format("2006-01-02")).Scan(&u.ClipsToday)
+	var breakUntil *string
+	cdb.QueryRowContext(ctx, `SELECT streak, break_until FROM user_pacing_state WHERE user_id = ?`,
+		userID).Scan(&u.Streak, &breakUntil)
+	if breakUntil != nil {
+		u.BreakUntil, _ = time.Parse("2006-01-02T15:04:05Z", *breakUntil)
+	}
+	return u
+}
+
+// pacingPause returns the state the feed should show instead of clips when
+// one of the user's pacing limits applies, or nil when the feed is open.
+func pacingPause(p PacingPrefs, u pacingUsage, now time.Time) map[string]string {
+	pause := func(state, message string, resume time.Time) map[string]string {
+		return map[string]string{
+			"state":     state,
+			"message":   message,
+			"resume_at": resume.UTC().Format("2006-01-02T15:04:05Z"),
+		}
+	}
+	if resume, quiet := p.quietUntil(now); quiet {
+		return pause(PacingQuietHours,
+			fmt.Sprintf("It's quiet hours. Your feed will be back at %s.", *p.QuietHoursEnd), resume)
+	}
+	if p.MaxClipsPerDay != nil && u.ClipsToday >= *p.MaxClipsPerDay {
+		local := now.In(p.location())
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location()).AddDate(0, 0, 1)
+		return pause(PacingDailyLimit,
+			fmt.Sprintf("You've watched your %d clips for today. Come back tomorrow.", *p.MaxClipsPerDay), midnight)
+	}
+	if u.BreakUntil.After(now) {
+		clips := "a lot of clips"
+		if p.BreakAfterClips != nil {
+			clips = fmt.Sprintf("%d clips", *p.BreakAfterClips)
+		}
+		return pause(PacingBreak,
+			fmt.Sprintf("You've watched %s in a row. Time for a %d-minute break.", clips, p.BreakMinutes), u.BreakUntil)
+	}
+	return nil
+}
+
+// RecordPacingView counts a clip view towards the user's daily total and
+// consecutive-view streak, starting a break when the streak reaches the
+// user's break_after_clips. A pause of break_minutes between views counts
+// as a break and resets the streak.
+func RecordPacingView(ctx context.Context, cdb *db.CompatDB, userID string) {
+	if userID == "" {
+		return
+	}
+	now := time.Now().UTC()
+	p := LoadPacingPrefs(ctx, cdb, userID)
+	local := now.In(p.location())
+	breakLen := time.Duration(p.BreakMinutes) * time.Minute
+
+	if err := db.WithTx(ctx, cdb, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO user_daily_usage (user_id, day, clips_viewed) VALUES (?, ?, 1)
+			ON CONFLICT(user_id, day) DO UPDATE SET clips_viewed = user_daily_usage.clips_viewed + 1
+		`, userID, local.Format("2006-01-02")); err != nil {
+			return fmt.Errorf("count view: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, `DELETE FROM user_daily_usage WHERE user_id = ? AND day < ?`,
+			userID, local.AddDate(0, 0, -dailyUsageRetainDays).Format("2006-01-02")); err != nil {
+			return fmt.Errorf("prune usage: %w", err)
+		}
+
+		var streak int
+		var lastView *string
+		conn.QueryRowContext(ctx, `SELECT streak, last_view_at FROM user_pacing_state WHERE user_id = ?`,
+			userID).Scan(&streak, &lastView)
+		if lastView != nil {
+			if t, err := time.Parse("2006-01-02T15:04:05Z", *lastView); err == nil && now.Sub(t) >= breakLen {
+				streak = 0
+			}
+		}
+		streak++
+		var breakUntil interface{}
+		if p.BreakAfterClips != nil && streak >= *p.BreakAfterClips {
+			breakUntil = now.Add(breakLen).Format("2006-01-02T15:04:05Z")
+			streak = 0
+		}
+		nowStr := now.Format("2006-01-02T15:04:05Z")
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO user_pacing_state (user_id, streak, last_view_at, break_until, updated_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id) DO UPDATE SET
+				streak       = excluded.streak,
+				last_view_at = excluded.last_view_at,
+				break_until  = COALESCE(excluded.break_until, user_pacing_state.break_until),
+				updated_at   = excluded.updated_at
+		`, userID, streak, nowStr, breakUntil, nowStr); err != nil {
+			return fmt.Errorf("update streak: %w", err)
+		}
+		return nil
+	}); err != nil {
+		log.Printf("RecordPacingView %s: %v", userID, err)
+	}
+}
+
+// HandleGetPacing returns the user's pacing preferences, today's usage, and
+// the pause the feed is currently in, if any.
+func (h *Handler) HandleGetPacing(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	now := time.Now()
+	p := LoadPacingPrefs(r.Context(), h.DB, userID)
+	u := loadPacingUsage(r.Context(), h.DB, userID, p, now)
+	usage := map[string]interface{}{
+		"clips_today": u.ClipsToday,
+		"streak":      u.Streak,
+	}
+	if u.BreakUntil.After(now) {
+		usage["break_until"] = u.BreakUntil.Format("2006-01-02T15:04:05Z")
+	}
+	result := map[string]interface{}{"preferences": p, "usage": usage, "pacing": nil}
+	if pause := pacingPause(p, u, now); pause != nil {
+		result["pacing"] = pause
+	}
+	httputil.WriteJSON(w, 200, result)
+}
+
+// HandleUpdatePacing replaces the user's pacing preferences. Omitted or null
+// limits are turned off; break_minutes defaults to 5 and timezone to UTC.
+func (h *Handler) HandleUpdatePacing(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	var p PacingPrefs
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&p); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if err := p.Validate(); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := SavePacingPrefs(r.Context(), h.DB, userID, p); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save pacing preferences"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"preferences": p})
+}
+
+// Validate fills in the defaults of unset fields and checks p, returning
+// an error that can be shown to the user.
+func (p *PacingPrefs) Validate() error {
+	if p.BreakMinutes == 0 {
+		p.BreakMinutes = defaultBreakMinutes
+	}
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
+	switch {
+	case p.MaxClipsPerDay != nil && (*p.MaxClipsPerDay < 1 || *p.MaxClipsPerDay > maxDailyClipLimit):
+		return fmt.Errorf("max_clips_per_day must be between 1 and %d", maxDailyClipLimit)
+	case p.BreakAfterClips != nil && (*p.BreakAfterClips < 1 || *p.BreakAfterClips > maxBreakAfterClips):
+		return fmt.Errorf("break_after_clips must be between 1 and %d", maxBreakAfterClips)
+	case p.BreakMinutes < 1 || p.BreakMinutes > maxBreakMinutes:
+		return fmt.Errorf("break_minutes must be between 1 and %d", maxBreakMinutes)
+	case (p.QuietHoursStart == nil) != (p.QuietHoursEnd == nil):
+		return errors.New("quiet_hours_start and quiet_hours_end must be set together")
+	}
+	if p.QuietHoursStart != nil {
+		start, ok1 := parseClock(*p.QuietHoursStart)
+		end, ok2 := parseClock(*p.QuietHoursEnd)
+		if !ok1 || !ok2 || start == end {
+			return errors.New("quiet hours must be two different HH:MM times")
+		}
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return errors.New("unknown timezone")
+	}
+	return nil
+}
+
+// SavePacingPrefs stores validated pacing preferences for the user.
+func SavePacingPrefs(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, userID string, p PacingPrefs) error {
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, max_clips_per_day, quiet_hours_start, quiet_hours_end, break_after_clips, break_minutes, timezone, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			max_clips_per_day = excluded.max_clips_per_day,
+			quiet_hours_start = excluded.quiet_hours_start,
+			quiet_hours_end   = excluded.quiet_hours_end,
+			break_after_clips = excluded.break_after_clips,
+			break_minutes     = excluded.break_minutes,
+			timezone          = excluded.timezone,
+			updated_at        = excluded.updated_at
+	`, userID, p.MaxClipsPerDay, p.QuietHoursStart, p.QuietHoursEnd,
+		p.BreakAfterClips, p.BreakMinutes, p.Timezone, time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	return err
+}
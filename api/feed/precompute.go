@@ -0,0 +1,222 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"clipfeed/httputil"
+)
+
+const (
+	// precomputeCandidates is how many candidate clip IDs are stored per user;
+	// enough for several pages once seen clips are filtered out at serve time.
+	precomputeCandidates = 300
+	// precomputeTTL bounds how stale a stored candidate list may be.
+	precomputeTTL = 30 * time.Minute
+	// precomputeIdleAfter is how long the feed must go without requests
+	// before background precomputation runs.
+	precomputeIdleAfter = 15 * time.Second
+	// precomputeActiveWindow selects users whose recent activity makes them
+	// likely to request a feed soon.
+	precomputeActiveWindow = "-24 hours"
+	// precomputeBatch caps how many users are refreshed per idle tick.
+	precomputeBatch = 50
+)
+
+// markFeedRequest records that a feed request is being served so background
+// precomputation can yield to live traffic.
+func (h *Handler) markFeedRequest() {
+	h.lastFeedAt.Store(time.Now().UnixNano())
+}
+
+// feedIdle reports whether no feed request has been served recently.
+func (h *Handler) feedIdle() bool {
+	return time.Since(time.Unix(0, h.lastFeedAt.Load())) >= precomputeIdleAfter
+}
+
+// queryPersonalCandidates runs personalised candidate generation: ready clips
+// within the user's duration bounds, minus recently seen clips, ordered by
+// content score with freshness decay, or newest first for a chronological
+// feed.
+func (h *Handler) queryPersonalCandidates(ctx context.Context, userID string, fp FeedPrefs, limit int) (*sql.Rows, error) {
+	halfLife := 24.0 + (1.0-fp.FreshnessBias)*648.0
+	ageHours := h.DB.AgeHoursExpr("c.created_at")
+	seenCutoff := h.DB.DatetimeModifier("-24 hours")
+	args := []interface{}{userID, userID, userID, userID}
+	order := "c.content_score * EXP(-" + ageHours + " / ?) DESC"
+	if fp.Chronological {
+		order = "c.created_at DESC"
+	} else {
+		args = append(args, halfLife)
+	}
+
+	return h.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH prefs AS (
+			SELECT min_clip_seconds, max_clip_seconds, dedupe_seen_24h
+			FROM user_preferences WHERE user_id = ?
+		),
+		seen AS (
+			SELECT clip_id FROM interactions
+			WHERE user_id = ? AND created_at > %s
+		)
+		SELECT c.id, c.title, c.description, c.duration_seconds,
+		       c.thumbnail_key, c.topics, c.tags, c.content_score,
+		       c.created_at, s.channel_name, s.platform, s.url,
+		       COALESCE(c.source_id, ''),
+		       CAST(c.transcript_length AS REAL),
+		       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+		       COALESCE(%s, 0)
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready'
+		  AND (COALESCE((SELECT dedupe_seen_24h FROM prefs), 1) = 0 OR c.id NOT IN (SELECT clip_id FROM seen))
+		  AND c.duration_seconds >= COALESCE((SELECT min_clip_seconds FROM prefs), 5)
+		  AND c.duration_seconds <= COALESCE((SELECT max_clip_seconds FROM prefs), 120)
+		  %s
+		ORDER BY %s
+		LIMIT ?
+	`, seenCutoff, ageHours, h.snoozeFilter(), order), append(args, limit)...)
+}
+
+// PrecomputeCandidates generates and stores the candidate list for one user.
+func (h *Handler) PrecomputeCandidates(ctx context.Context, userID string) error {
+	_, _, feedPrefs := h.loadFeedPrefs(ctx, userID)
+	rows, err := h.queryPersonalCandidates(ctx, userID, feedPrefs, precomputeCandidates)
+	if err != nil {
+		return err
+	}
+	candidates := httputil.ScanClips(rows)
+	rows.Close()
+	ids := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c["id"].(string))
+	}
+
+	idsJSON, _ := json.Marshal(ids)
+	expiresAt := time.Now().UTC().Add(precomputeTTL).Format("2006-01-02T15:04:05Z")
+	_, err = h.DB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO feed_candidates (user_id, clip_ids, computed_at, expires_at) VALUES (?, ?, %s, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			clip_ids = excluded.clip_ids, computed_at = excluded.computed_at, expires_at = excluded.expires_at
+	`, h.DB.NowUTC()), userID, string(idsJSON), expiresAt)
+	return err
+}
+
+// precomputeActiveUsers refreshes candidate lists for recently active users
+// whose stored list is missing or close to expiry, oldest first.
+func (h *Handler) precomputeActiveUsers(ctx context.Context) int {
+	refreshBefore := time.Now().UTC().Add(precomputeTTL / 3).Format("2006-01-02T15:04:05Z")
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT i.user_id FROM interactions i
+		LEFT JOIN feed_candidates fc ON fc.user_id = i.user_id
+		WHERE i.created_at > %s
+		  AND (fc.user_id IS NULL OR fc.expires_at < ?)
+		GROUP BY i.user_id, fc.expires_at
+		ORDER BY COALESCE(fc.expires_at, '') ASC, MAX(i.created_at) DESC
+		LIMIT ?
+	`, h.DB.DatetimeModifier(precomputeActiveWindow)), refreshBefore, precomputeBatch)
+	if err != nil {
+		log.Printf("precompute: list active users: %v", err)
+		return 0
+	}
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			userIDs = append(userIDs, id)
+		}
+	}
+	rows.Close()
+
+	done := 0
+	for _, id := range userIDs {
+		if !h.feedIdle() {
+			break
+		}
+		if err := h.PrecomputeCandidates(ctx, id); err != nil {
+			log.Printf("precompute: user %s: %v", id, err)
+			continue
+		}
+		done++
+	}
+	return done
+}
+
+// CandidatePrecomputeLoop periodically precomputes feed candidates for active
+// users while the feed is idle, and prunes expired lists.
+func (h *Handler) CandidatePrecomputeLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !h.feedIdle() || h.Maintenance.Active() {
+			continue
+		}
+		ctx := context.Background()
+		if n := h.precomputeActiveUsers(ctx); n > 0 {
+			log.Printf("precompute: refreshed feed candidates for %d users", n)
+		}
+		h.DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM feed_candidates WHERE expires_at < %s`, h.DB.NowUTC()))
+	}
+}
+
+// precomputedCandidates loads the user's unexpired precomputed candidates,
+// dropping clips that are no longer ready or were seen since computation.
+// It returns nil when no usable list exists.
+func (h *Handler) precomputedCandidates(ctx context.Context, userID string, dedupeSeen24h bool) []map[string]interface{} {
+	var idsJSON string
+	if err := h.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT clip_ids FROM feed_candidates WHERE user_id = ? AND expires_at > %s
+	`, h.DB.NowUTC()), userID).Scan(&idsJSON); err != nil {
+		return nil
+	}
+	var ids []string
+	if json.Unmarshal([]byte(idsJSON), &ids) != nil || len(ids) == 0 {
+		return nil
+	}
+
+	ph := make([]string, len(ids))
+	args := make([]interface{}, 0, len(ids)+1)
+	order := make(map[string]int, len(ids))
+	for i, id := range ids {
+		ph[i] = "?"
+		args = append(args, id)
+		order[id] = i
+	}
+	seenFilter := ""
+	if dedupeSeen24h {
+		seenFilter = fmt.Sprintf("AND c.id NOT IN (SELECT clip_id FROM interactions WHERE user_id = ? AND created_at > %s)", h.DB.DatetimeModifier("-24 hours"))
+		args = append(args, userID)
+	}
+
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT c.id, c.title, c.description, c.duration_seconds,
+		       c.thumbnail_key, c.topics, c.tags, c.content_score,
+		       c.created_at, s.channel_name, s.platform, s.url,
+		       COALESCE(c.source_id, ''),
+		       CAST(c.transcript_length AS REAL),
+		       CAST(COALESCE(c.file_size_bytes, 0) AS REAL),
+		       COALESCE(%s, 0)
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.id IN (%s) AND c.status = 'ready'
+		  %s
+		  %s
+	`, h.DB.AgeHoursExpr("c.created_at"), strings.Join(ph, ","), seenFilter, h.snoozeFilter()), append(args, userID, userID)...)
+	if err != nil {
+		log.Printf("precomputedCandidates: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	clips := httputil.ScanClips(rows)
+	sort.SliceStable(clips, func(i, j int) bool {
+		return order[clips[i]["id"].(string)] < order[clips[j]["id"].(string)]
+	})
+	return clips
+}
@@ -0,0 +1,106 @@
+package feed
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+)
+
+// RankingPreset is a named bundle of ranking preferences a user can pick
+// for one feed request (?preset=) or save as their default through the
+// ranking_preset preference.
+type RankingPreset struct {
+	Name            string  `json:"name"`
+	Label           string  `json:"label"`
+	Description     string  `json:"description"`
+	DiversityMix    float64 `json:"diversity_mix"`
+	TrendingBoost   bool    `json:"trending_boost"`
+	FreshnessBias   float64 `json:"freshness_bias"`
+	ExplorationRate float64 `json:"exploration_rate"`
+	// Chronological skips ranking and serves the newest clips first.
+	Chronological bool `json:"chronological"`
+}
+
+// rankingPresets are listed in the order clients should offer them.
+// Balanced matches the preferences of a user who has changed nothing.
+var rankingPresets = []RankingPreset{
+	{
+		Name: "balanced", Label: "Balanced",
+		Description:  "The default mix of relevance, variety, and a little exploration.",
+		DiversityMix: 0.5, TrendingBoost: true, FreshnessBias: 0.5, ExplorationRate: 0.3,
+	},
+	{
+		Name: "deep_dive", Label: "Deep dive",
+		Description:  "Stays on the topics and channels you already watch, old or new.",
+		DiversityMix: 0.1, TrendingBoost: false, FreshnessBias: 0.2, ExplorationRate: 0.05,
+	},
+	{
+		Name: "discovery", Label: "Discovery",
+		Description:  "Mixes topics and channels hard and explores beyond your interests.",
+		DiversityMix: 0.9, TrendingBoost: true, FreshnessBias: 0.6, ExplorationRate: 0.5,
+	},
+	{
+		Name: "chronological", Label: "Chronological",
+		Description:  "The newest clips first, with no ranking or exploration.",
+		DiversityMix: 0, TrendingBoost: false, FreshnessBias: 1, ExplorationRate: 0,
+		Chronological: true,
+	},
+}
+
+// LookupPreset returns the ranking preset called name.
+func LookupPreset(name string) (RankingPreset, bool) {
+	for _, p := range rankingPresets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return RankingPreset{}, false
+}
+
+// PresetNames lists the ranking preset names, comma separated.
+func PresetNames() string {
+	names := make([]string, len(rankingPresets))
+	for i, p := range rankingPresets {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// FeedPrefs returns the preset's ranking preferences.
+func (p RankingPreset) FeedPrefs() FeedPrefs {
+	return FeedPrefs{
+		DiversityMix:    p.DiversityMix,
+		TrendingBoost:   p.TrendingBoost,
+		FreshnessBias:   p.FreshnessBias,
+		ExplorationRate: p.ExplorationRate,
+		Chronological:   p.Chronological,
+		Preset:          p.Name,
+	}
+}
+
+// sortChronological orders clips newest first.
+func sortChronological(clips []map[string]interface{}) {
+	sort.SliceStable(clips, func(i, j int) bool {
+		a, _ := clips[i]["created_at"].(string)
+		b, _ := clips[j]["created_at"].(string)
+		return a > b
+	})
+}
+
+// HandleListPresets lists the ranking presets with their parameter values
+// and, for a signed-in user, the one saved as their default.
+func (h *Handler) HandleListPresets(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"presets": rankingPresets}
+	if userID, _ := auth.ExtractUserID(r); userID != "" {
+		_, _, fp := h.loadFeedPrefs(r.Context(), userID)
+		if fp.Preset != "" {
+			resp["default"] = fp.Preset
+		} else {
+			resp["default"] = nil
+		}
+	}
+	httputil.WriteJSON(w, 200, resp)
+}
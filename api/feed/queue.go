@@ -0,0 +1,322 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// QueueAhead is how many upcoming clips a playback queue keeps ranked
+	// ahead of its position, and how many a response lists.
+	QueueAhead = 20
+	// queueMaxClips caps the clips one queue holds. A full queue plays out
+	// and clients then start a fresh one.
+	queueMaxClips = 500
+	// queueFillRounds caps the pages ranked to top one queue up.
+	queueFillRounds = 3
+	// queueTTL is how long a queue lives after it was last used.
+	queueTTL = 24 * time.Hour
+	// queueMaxPerUser caps the queues one user keeps; starting another
+	// drops the least recently used.
+	queueMaxPerUser = 10
+)
+
+// errQueueNotFound is returned for a queue that does not exist, has
+// expired, or is another user's.
+var errQueueNotFound = errors.New("queue not found")
+
+// playbackQueue is a client's playback queue: every clip queued so far and
+// the index of the next one to play.
+type playbackQueue struct {
+	id       string
+	ids      []string
+	position int
+}
+
+// currentPause returns the pacing pause that applies to userID now, or nil.
+func (h *Handler) currentPause(ctx context.Context, userID string) map[string]string {
+	now := time.Now()
+	pacing := LoadPacingPrefs(ctx, h.DB, userID)
+	return pacingPause(pacing, loadPacingUsage(ctx, h.DB, userID, pacing, now), now)
+}
+
+// HandleGetQueue serves the user's playback queue: the clips from its
+// position on, ranked like the feed and never repeating a clip the queue
+// already holds. queue names a queue to resume; without it the user
+// resumes their latest one, unless fresh=true. Queues are topped up to
+// QueueAhead clips ahead on every read, and include_stream prefetches
+// stream URLs as on /api/feed.
+func (h *Handler) HandleGetQueue(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	ctx := r.Context()
+	if pause := h.currentPause(ctx, userID); pause != nil {
+		httputil.WriteJSON(w, 200, map[string]interface{}{
+			"clips": make([]map[string]interface{}, 0), "count": 0, "pacing": pause,
+		})
+		return
+	}
+
+	q := r.URL.Query()
+	var queue *playbackQueue
+	var err error
+	switch {
+	case q.Get("queue") != "":
+		queue, err = h.loadQueue(ctx, q.Get("queue"), userID)
+		if errors.Is(err, errQueueNotFound) {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "queue not found or expired; start again without queue"})
+			return
+		}
+	case q.Get("fresh") != "true":
+		if queue, err = h.latestQueue(ctx, userID); errors.Is(err, errQueueNotFound) {
+			err = nil
+		}
+	}
+	if err == nil && queue == nil {
+		if h.Maintenance.Active() {
+			httputil.WriteJSON(w, 503, map[string]string{"error": "new playback queues cannot be started during maintenance"})
+			return
+		}
+		queue, err = h.newQueue(ctx, userID)
+	}
+	if err != nil {
+		log.Printf("playback queue: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load queue"})
+		return
+	}
+	h.serveQueue(w, r, queue, userID)
+}
+
+// HandleAdvanceQueue moves a queue's position past clip_id, the clip the
+// client just finished or skipped, or forward by steps (default 1) when no
+// clip is named. The position never moves back, so retried calls are
+// harmless. It answers like HandleGetQueue, with the queue topped up.
+func (h *Handler) HandleAdvanceQueue(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	ctx := r.Context()
+	var req struct {
+		QueueID string `json:"queue_id"`
+		ClipID  string `json:"clip_id"`
+		Steps   int    `json:"steps"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || req.QueueID == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "queue_id is required"})
+		return
+	}
+	if req.Steps < 0 || req.Steps > QueueAhead {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("steps must be 1-%d", QueueAhead)})
+		return
+	}
+	queue, err := h.loadQueue(ctx, req.QueueID, userID)
+	if errors.Is(err, errQueueNotFound) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "queue not found or expired; start again without queue"})
+		return
+	}
+	if err != nil {
+		log.Printf("playback queue %s: %v", req.QueueID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load queue"})
+		return
+	}
+
+	position := queue.position
+	if req.ClipID != "" {
+		index := -1
+		for i, id := range queue.ids {
+			if id == req.ClipID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			httputil.WriteJSON(w, 409, map[string]string{"error": "clip is not in this queue"})
+			return
+		}
+		position = index + 1
+	} else {
+		if req.Steps == 0 {
+			req.Steps = 1
+		}
+		position += req.Steps
+	}
+	if position > len(queue.ids) {
+		position = len(queue.ids)
+	}
+	if position > queue.position {
+		if _, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE playback_queues SET position = ?, updated_at = %s WHERE id = ? AND position < ?
+		`, h.DB.NowUTC()), position, queue.id, position); err != nil {
+			log.Printf("playback queue %s: advance: %v", queue.id, err)
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to advance queue"})
+			return
+		}
+		if queue, err = h.loadQueue(ctx, queue.id, userID); err != nil {
+			log.Printf("playback queue %s: %v", req.QueueID, err)
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load queue"})
+			return
+		}
+	}
+	if pause := h.currentPause(ctx, userID); pause != nil {
+		httputil.WriteJSON(w, 200, map[string]interface{}{
+			"queue_id": queue.id, "position": queue.position,
+			"clips": make([]map[string]interface{}, 0), "count": 0, "pacing": pause,
+		})
+		return
+	}
+	h.serveQueue(w, r, queue, userID)
+}
+
+// serveQueue tops queue up and writes the clips from its position on.
+func (h *Handler) serveQueue(w http.ResponseWriter, r *http.Request, queue *playbackQueue, userID string) {
+	ctx := r.Context()
+	var deadline time.Time
+	if h.RankBudget > 0 {
+		deadline = time.Now().Add(h.RankBudget)
+	}
+	if err := h.fillQueue(ctx, queue, userID, deadline); err != nil {
+		// Serve what the queue holds already.
+		log.Printf("playback queue %s: fill: %v", queue.id, err)
+	}
+
+	upcoming := queue.ids[queue.position:]
+	if len(upcoming) > QueueAhead {
+		upcoming = upcoming[:QueueAhead]
+	}
+	clips, err := h.clipsInOrder(ctx, userID, upcoming)
+	if err != nil {
+		log.Printf("playback queue %s: %v", queue.id, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load queue"})
+		return
+	}
+	warmup := streamWarmupCount(r)
+	if warmup > 0 && h.streamLimited(ctx, userID) {
+		warmup = 0
+	}
+	h.shapeFeedClips(ctx, clips, httputil.RequestedClipFields(r))
+	h.addStreamURLs(ctx, clips, warmup)
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"queue_id": queue.id, "position": queue.position, "clips": clips, "count": len(clips),
+		"remaining": len(queue.ids) - queue.position,
+	})
+}
+
+// fillQueue ranks clips onto the end of queue until QueueAhead are ahead
+// of its position, leaving out every clip it already holds. When another
+// request topped the queue up first, its clips are kept instead. During
+// maintenance the queue is left as it is.
+func (h *Handler) fillQueue(ctx context.Context, queue *playbackQueue, userID string, deadline time.Time) error {
+	ahead := len(queue.ids) - queue.position
+	if ahead >= QueueAhead || len(queue.ids) >= queueMaxClips || h.Maintenance.Active() {
+		return nil
+	}
+	exclude := make(map[string]bool, len(queue.ids))
+	for _, id := range queue.ids {
+		exclude[id] = true
+	}
+	topicWeights, dedupeSeen24h, feedPrefs := h.loadFeedPrefs(ctx, userID)
+	ids := append([]string(nil), queue.ids...)
+	// A page can come up short, e.g. when exploration finds too few clips,
+	// so rank another while each one still adds clips.
+	for round := 0; round < queueFillRounds && len(ids)-queue.position < QueueAhead && len(ids) < queueMaxClips; round++ {
+		page, err := h.rankPage(ctx, userID, QueueAhead, topicWeights, dedupeSeen24h, feedPrefs, feedPrefs.Preset, nil, exclude, deadline)
+		if err != nil {
+			return err
+		}
+		added := 0
+		for _, c := range page.clips {
+			if id := c["id"].(string); !exclude[id] && len(ids) < queueMaxClips {
+				exclude[id] = true
+				ids = append(ids, id)
+				added++
+			}
+		}
+		if added == 0 {
+			break
+		}
+	}
+	if len(ids) == len(queue.ids) {
+		return nil
+	}
+	oldJSON, _ := json.Marshal(queue.ids)
+	newJSON, _ := json.Marshal(ids)
+	expiresAt := time.Now().UTC().Add(queueTTL).Format("2006-01-02T15:04:05Z")
+	res, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE playback_queues SET clip_ids = ?, expires_at = ?, updated_at = %s WHERE id = ? AND clip_ids = ?
+	`, h.DB.NowUTC()), string(newJSON), expiresAt, queue.id, string(oldJSON))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		stored, err := h.loadQueue(ctx, queue.id, userID)
+		if err != nil {
+			return err
+		}
+		*queue = *stored
+		return nil
+	}
+	queue.ids = ids
+	return nil
+}
+
+// newQueue starts an empty queue for userID, clearing out expired ones and
+// the user's least recently used beyond queueMaxPerUser.
+func (h *Handler) newQueue(ctx context.Context, userID string) (*playbackQueue, error) {
+	h.DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM playback_queues WHERE expires_at <= %s`, h.DB.NowUTC()))
+	h.DB.ExecContext(ctx, `
+		DELETE FROM playback_queues WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM playback_queues WHERE user_id = ?
+			ORDER BY updated_at DESC, created_at DESC LIMIT ?
+		)
+	`, userID, userID, queueMaxPerUser-1)
+	id := uuid.New().String()
+	expiresAt := time.Now().UTC().Add(queueTTL).Format("2006-01-02T15:04:05Z")
+	if _, err := h.DB.ExecContext(ctx,
+		`INSERT INTO playback_queues (id, user_id, expires_at) VALUES (?, ?, ?)`, id, userID, expiresAt); err != nil {
+		return nil, err
+	}
+	return &playbackQueue{id: id, ids: []string{}}, nil
+}
+
+// loadQueue loads the unexpired queue id of userID.
+func (h *Handler) loadQueue(ctx context.Context, id, userID string) (*playbackQueue, error) {
+	return scanQueue(h.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT id, clip_ids, position FROM playback_queues WHERE id = ? AND user_id = ? AND expires_at > %s
+	`, h.DB.NowUTC()), id, userID))
+}
+
+// latestQueue loads the signed-in user's most recently used queue that
+// has not expired.
+func (h *Handler) latestQueue(ctx context.Context, userID string) (*playbackQueue, error) {
+	return scanQueue(h.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT id, clip_ids, position FROM playback_queues WHERE user_id = ? AND expires_at > %s
+		ORDER BY updated_at DESC, created_at DESC LIMIT 1
+	`, h.DB.NowUTC()), userID))
+}
+
+func scanQueue(row *sql.Row) (*playbackQueue, error) {
+	var q playbackQueue
+	var idsJSON string
+	err := row.Scan(&q.id, &idsJSON, &q.position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errQueueNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(idsJSON), &q.ids); err != nil {
+		return nil, err
+	}
+	if q.position > len(q.ids) {
+		q.position = len(q.ids)
+	}
+	return &q, nil
+}
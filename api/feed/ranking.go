@@ -2,7 +2,9 @@ package feed
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"log"
@@ -30,6 +32,14 @@ type LTRModel struct {
 	Trees        [][]LTRTree `json:"trees"`
 	FeatureNames []string    `json:"feature_names"`
 	NumFeatures  int         `json:"num_features"`
+	TrainedAt    string      `json:"trained_at,omitempty"`
+	NDCGAt10     float64     `json:"ndcg_at_10,omitempty"`
+
+	// Version identifies the model file's contents; LoadedAt and ModifiedAt
+	// are when it was read and last written.
+	Version    string    `json:"-"`
+	LoadedAt   time.Time `json:"-"`
+	ModifiedAt time.Time `json:"-"`
 }
 
 // Score returns the model's prediction for the given feature vector.
@@ -103,6 +113,12 @@ func (h *Handler) LoadLTRModel() *LTRModel {
 		log.Printf("LTR model parse error: %v", err)
 		return nil
 	}
+	sum := sha256.Sum256(data)
+	model.Version = hex.EncodeToString(sum[:6])
+	model.LoadedAt = time.Now().UTC()
+	if fi, err := f.Stat(); err == nil {
+		model.ModifiedAt = fi.ModTime().UTC()
+	}
 	log.Printf("LTR model loaded: %d trees, %d features", len(model.Trees), model.NumFeatures)
 	return &model
 }
@@ -139,6 +155,8 @@ var ltrFeatureNames = []string{
 	"user_like_rate",
 	"user_save_rate",
 	"hours_since_last_session",
+	"clip_avg_rating",
+	"clip_rating_count",
 }
 
 type ltrUserStats struct {
@@ -153,23 +171,51 @@ type ltrUserStats struct {
 
 // FeedPrefs holds per-user algorithm tuning preferences.
 type FeedPrefs struct {
-	DiversityMix  float64 // 0 = no diversity reranking, 1 = maximum diversity
-	TrendingBoost bool    // whether to boost trending clips
-	FreshnessBias float64 // 0 = old content ok, 1 = strongly prefer fresh
+	DiversityMix    float64 // 0 = no diversity reranking, 1 = maximum diversity
+	TrendingBoost   bool    // whether to boost trending clips
+	FreshnessBias   float64 // 0 = old content ok, 1 = strongly prefer fresh
+	ExplorationRate float64 // share of the feed reserved for bandit exploration
+	Chronological   bool    // serve newest first instead of ranking
+	Preset          string  // the ranking preset these came from, if any
 }
 
+// Rankers selectable by rankClips. rankerAuto is production behaviour: LTR
+// when a model is loaded, otherwise topic boosting.
+const (
+	rankerAuto       = "auto"
+	rankerLTR        = "ltr"
+	rankerTopicBoost = "topic_boost"
+	rankerNone       = "none"
+)
+
 // RankFeed post-processes the candidate clip list with LTR, topic boosts,
 // trending signals, and diversity reranking.
 func (h *Handler) RankFeed(ctx context.Context, clips []map[string]interface{}, userID string, topicWeights map[string]float64, fp FeedPrefs) {
+	h.rankClips(ctx, clips, userID, topicWeights, rankerAuto, fp)
+	stripRankingFields(clips)
+}
+
+// rankClips orders clips in place with the given base ranker, adjusted by the
+// user's channel and format feedback, followed by the trending and diversity
+// stages enabled in fp. Ranking fields are left on the clips for callers
+// that report scores.
+func (h *Handler) rankClips(ctx context.Context, clips []map[string]interface{}, userID string, topicWeights map[string]float64, ranker string, fp FeedPrefs) {
 	if len(clips) == 0 {
 		return
 	}
 
-	if model := h.GetLTRModel(); model != nil && len(model.Trees) > 0 {
+	model := h.GetLTRModel()
+	hasModel := model != nil && len(model.Trees) > 0
+	switch {
+	case ranker == rankerLTR && hasModel, ranker == rankerAuto && hasModel:
 		h.applyLTRRanking(ctx, clips, userID, model)
-	} else {
+	case ranker == rankerNone:
+	default:
 		h.applyTopicBoost(ctx, clips, userID, topicWeights)
 	}
+	if ranker != rankerNone {
+		h.applyFeedbackWeights(ctx, clips, userID)
+	}
 
 	if fp.TrendingBoost {
 		h.applyTrendingBoost(ctx, clips)
@@ -178,7 +224,11 @@ func (h *Handler) RankFeed(ctx context.Context, clips []map[string]interface{},
 	if fp.DiversityMix > 0 {
 		h.applyDiversityPenalty(clips, fp.DiversityMix)
 	}
+}
 
+// stripRankingFields removes the internal underscore-prefixed fields that
+// ScanClips and the rankers attach to clip maps.
+func stripRankingFields(clips []map[string]interface{}) {
 	for _, clip := range clips {
 		delete(clip, "_source_id")
 		delete(clip, "_transcript_length")
@@ -368,6 +418,7 @@ func (h *Handler) applyLTRRanking(ctx context.Context, clips []map[string]interf
 	}
 
 	topicCount, topicOverlap := h.loadClipTopicStats(ctx, clipIDs, stats.TopicAffinities)
+	ratings := h.loadClipRatings(ctx, clipIDs)
 
 	for i := range clips {
 		clip := clips[i]
@@ -402,6 +453,8 @@ func (h *Handler) applyLTRRanking(ctx context.Context, clips []map[string]interf
 		set(10, stats.LikeRate)
 		set(11, stats.SaveRate)
 		set(12, stats.HoursSinceLastSession)
+		set(13, ratings[clipID].avg)
+		set(14, ratings[clipID].count)
 
 		clip["_l2r_score"] = model.Score(features)
 	}
@@ -418,6 +471,10 @@ func (h *Handler) applyLTRRanking(ctx context.Context, clips []map[string]interf
 	})
 }
 
+// ltrStatsWindow bounds the interactions the LTR user features are built
+// from. It stays inside the default interaction retention.
+const ltrStatsWindow = "-90 days"
+
 func (h *Handler) loadLTRUserStats(ctx context.Context, userID string) ltrUserStats {
 	stats := ltrUserStats{
 		HoursSinceLastSession: 24.0 * 7,
@@ -428,6 +485,9 @@ func (h *Handler) loadLTRUserStats(ctx context.Context, userID string) ltrUserSt
 		return stats
 	}
 
+	// User features describe recent behaviour; older history is pruned
+	// into rollups and would only slow these scans down.
+	statsSince := h.DB.DatetimeModifier(ltrStatsWindow)
 	var totalViews int
 	var avgWatch float64
 	var likeCount, saveCount int
@@ -438,7 +498,7 @@ func (h *Handler) loadLTRUserStats(ctx context.Context, userID string) ltrUserSt
 			COALESCE(SUM(CASE WHEN action IN ('like','watch_full','share') THEN 1 ELSE 0 END), 0),
 			COALESCE(SUM(CASE WHEN action = 'save' THEN 1 ELSE 0 END), 0)
 		FROM interactions
-		WHERE user_id = ?
+		WHERE user_id = ? AND created_at > `+statsSince+`
 	`, userID).Scan(&totalViews, &avgWatch, &likeCount, &saveCount); err != nil {
 		log.Printf("loadLTRUserStats: user stats query failed: %v", err)
 	}
@@ -474,7 +534,7 @@ func (h *Handler) loadLTRUserStats(ctx context.Context, userID string) ltrUserSt
 		       END)
 		FROM interactions i
 		JOIN clips c ON c.id = i.clip_id
-		WHERE i.user_id = ?
+		WHERE i.user_id = ? AND i.created_at > `+statsSince+`
 		GROUP BY c.source_id
 	`, userID)
 	if err == nil {
@@ -504,10 +564,46 @@ func (h *Handler) loadLTRUserStats(ctx context.Context, userID string) ltrUserSt
 		}
 		topicRows.Close()
 	}
+	for topicID := range affinityOverrides(ctx) {
+		stats.TopicAffinities[topicID] = struct{}{}
+	}
 
 	return stats
 }
 
+// clipRating is a clip's average star rating and how many users rated it;
+// both are 0 for an unrated clip.
+type clipRating struct {
+	avg, count float64
+}
+
+func (h *Handler) loadClipRatings(ctx context.Context, clipIDs []string) map[string]clipRating {
+	ratings := make(map[string]clipRating, len(clipIDs))
+	if len(clipIDs) == 0 {
+		return ratings
+	}
+	args := make([]interface{}, len(clipIDs))
+	for i, id := range clipIDs {
+		args[i] = id
+	}
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, COALESCE(rating_avg, 0), rating_count FROM clips
+		 WHERE rating_count > 0 AND id IN (?`+strings.Repeat(", ?", len(clipIDs)-1)+`)`, args...)
+	if err != nil {
+		log.Printf("loadClipRatings: %v", err)
+		return ratings
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var r clipRating
+		if rows.Scan(&id, &r.avg, &r.count) == nil {
+			ratings[id] = r
+		}
+	}
+	return ratings
+}
+
 func (h *Handler) loadClipTopicStats(ctx context.Context, clipIDs []string, userTopics map[string]struct{}) (map[string]int, map[string]int) {
 	topicCount := make(map[string]int, len(clipIDs))
 	topicOverlap := make(map[string]int, len(clipIDs))
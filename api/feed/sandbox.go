@@ -0,0 +1,237 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"clipfeed/httputil"
+)
+
+const maxSandboxCandidates = 200
+
+// SandboxConfig is one ranking configuration to evaluate in the sandbox.
+// Unset trending_boost and diversity_mix fall back to the user's (or
+// synthetic profile's) preferences.
+type SandboxConfig struct {
+	Name          string   `json:"name"`
+	Ranker        string   `json:"ranker"`
+	TrendingBoost *bool    `json:"trending_boost"`
+	DiversityMix  *float64 `json:"diversity_mix"`
+}
+
+// SandboxProfile stands in for a real user when evaluating rankings for a
+// hypothetical audience.
+type SandboxProfile struct {
+	TopicWeights  map[string]float64 `json:"topic_weights"`
+	DiversityMix  *float64           `json:"diversity_mix"`
+	TrendingBoost *bool              `json:"trending_boost"`
+}
+
+// defaultSandboxConfigs compares production ranking against topic boosting
+// alone and against maximum diversity reranking.
+func defaultSandboxConfigs() []SandboxConfig {
+	off, zero, maxMix := false, 0.0, 1.0
+	return []SandboxConfig{
+		{Name: "current", Ranker: rankerAuto},
+		{Name: "topic_boost_only", Ranker: rankerTopicBoost, TrendingBoost: &off, DiversityMix: &zero},
+		{Name: "max_diversity", Ranker: rankerAuto, DiversityMix: &maxMix},
+	}
+}
+
+// HandleRankSandbox ranks one candidate list under several configurations
+// side by side so operators can compare them before changing defaults. The
+// first configuration is the baseline that rank deltas are reported against.
+func (h *Handler) HandleRankSandbox(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID  string          `json:"user_id"`
+		Profile *SandboxProfile `json:"profile"`
+		ClipIDs []string        `json:"clip_ids"`
+		Limit   int             `json:"limit"`
+		Configs []SandboxConfig `json:"configs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.UserID != "" && req.Profile != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "give user_id or profile, not both"})
+		return
+	}
+	if len(req.ClipIDs) > maxSandboxCandidates {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d clip_ids", maxSandboxCandidates)})
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxSandboxCandidates {
+		req.Limit = 60
+	}
+	if len(req.Configs) == 0 {
+		req.Configs = defaultSandboxConfigs()
+	}
+
+	model := h.GetLTRModel()
+	hasModel := model != nil && len(model.Trees) > 0
+	for i, c := range req.Configs {
+		if c.Name == "" {
+			req.Configs[i].Name = fmt.Sprintf("config_%d", i+1)
+		}
+		switch c.Ranker {
+		case "":
+			req.Configs[i].Ranker = rankerAuto
+		case rankerAuto, rankerTopicBoost, rankerNone:
+		case rankerLTR:
+			if !hasModel {
+				httputil.WriteJSON(w, 400, map[string]string{"error": "ranker ltr requested but no LTR model is loaded"})
+				return
+			}
+		default:
+			httputil.WriteJSON(w, 400, map[string]string{"error": "ranker must be auto, ltr, topic_boost, or none"})
+			return
+		}
+		if c.DiversityMix != nil && (*c.DiversityMix < 0 || *c.DiversityMix > 1) {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "diversity_mix must be between 0 and 1"})
+			return
+		}
+	}
+
+	var topicWeights map[string]float64
+	var prefs FeedPrefs
+	if req.UserID != "" {
+		var exists int
+		if err := h.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM users WHERE id = ?`, req.UserID).Scan(&exists); err != nil || exists == 0 {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+			return
+		}
+		topicWeights, _, prefs = h.loadFeedPrefs(r.Context(), req.UserID)
+	} else {
+		_, _, prefs = h.loadFeedPrefs(r.Context(), "")
+		if req.Profile != nil {
+			topicWeights = req.Profile.TopicWeights
+			if req.Profile.DiversityMix != nil {
+				prefs.DiversityMix = *req.Profile.DiversityMix
+			}
+			if req.Profile.TrendingBoost != nil {
+				prefs.TrendingBoost = *req.Profile.TrendingBoost
+			}
+		}
+	}
+
+	candidates, err := h.sandboxCandidates(r.Context(), req.UserID, prefs, req.ClipIDs, req.Limit)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load candidates"})
+		return
+	}
+
+	var baseline map[string]int
+	rankings := make([]map[string]interface{}, 0, len(req.Configs))
+	for _, c := range req.Configs {
+		fp := prefs
+		if c.TrendingBoost != nil {
+			fp.TrendingBoost = *c.TrendingBoost
+		}
+		if c.DiversityMix != nil {
+			fp.DiversityMix = *c.DiversityMix
+		}
+
+		// Rankers reorder and annotate clip maps in place, so each
+		// configuration gets its own copies.
+		clips := make([]map[string]interface{}, len(candidates))
+		for i, clip := range candidates {
+			clips[i] = make(map[string]interface{}, len(clip))
+			for k, v := range clip {
+				clips[i][k] = v
+			}
+		}
+		h.rankClips(r.Context(), clips, req.UserID, topicWeights, c.Ranker, fp)
+
+		positions := make(map[string]int, len(clips))
+		ranked := make([]map[string]interface{}, 0, len(clips))
+		for i, clip := range clips {
+			id, _ := clip["id"].(string)
+			positions[id] = i + 1
+			entry := map[string]interface{}{
+				"rank": i + 1, "id": id, "title": clip["title"],
+				"channel_name": clip["channel_name"], "topics": clip["topics"],
+				"content_score": clip["content_score"],
+			}
+			if s, ok := clip["_l2r_score"].(float64); ok {
+				entry["score"] = s
+			} else if s, ok := clip["_score"].(float64); ok {
+				entry["score"] = s
+			}
+			if baseline != nil {
+				entry["rank_change"] = baseline[id] - (i + 1)
+			}
+			ranked = append(ranked, entry)
+		}
+		if baseline == nil {
+			baseline = positions
+		}
+
+		rankings = append(rankings, map[string]interface{}{
+			"name": c.Name, "ranker": c.Ranker,
+			"trending_boost": fp.TrendingBoost, "diversity_mix": fp.DiversityMix,
+			"clips": ranked,
+		})
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"user_id":          req.UserID,
+		"candidates":       len(candidates),
+		"ltr_model_loaded": hasModel,
+		"baseline":         req.Configs[0].Name,
+		"rankings":         rankings,
+	})
+}
+
+// sandboxCandidates loads the given clips, or when none are given, the
+// candidates the feed would rank for the user (or the anonymous feed).
+func (h *Handler) sandboxCandidates(ctx context.Context, userID string, prefs FeedPrefs, clipIDs []string, limit int) ([]map[string]interface{}, error) {
+	if len(clipIDs) == 0 && userID != "" {
+		rows, err := h.queryPersonalCandidates(ctx, userID, prefs, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		return httputil.ScanClips(rows), nil
+	}
+
+	query := `SELECT ` + discoverClipColumns + `
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready'`
+	var args []interface{}
+	if len(clipIDs) > 0 {
+		query += ` AND c.id IN (?` + strings.Repeat(", ?", len(clipIDs)-1) + `)`
+		for _, id := range clipIDs {
+			args = append(args, id)
+		}
+	} else {
+		query += ` ORDER BY c.content_score DESC, c.id LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(query, h.DB.AgeHoursExpr("c.created_at")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clips := httputil.ScanClips(rows)
+
+	// Keep the caller's order as the pre-ranking order.
+	if len(clipIDs) > 0 {
+		byID := make(map[string]map[string]interface{}, len(clips))
+		for _, c := range clips {
+			byID[c["id"].(string)] = c
+		}
+		clips = clips[:0]
+		for _, id := range clipIDs {
+			if c, ok := byID[id]; ok {
+				clips = append(clips, c)
+				delete(byID, id)
+			}
+		}
+	}
+	return clips, nil
+}
@@ -0,0 +1,441 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search query node kinds.
+const (
+	nodeTerm   = "term"
+	nodePhrase = "phrase"
+	nodeField  = "field"
+)
+
+// searchFields are the field: prefixes the search syntax understands.
+var searchFields = []string{"topic", "channel", "platform", "dur", "after", "before"}
+
+// SearchNode is one element of a parsed search query: a word, a quoted
+// phrase, or a field filter such as dur:<60. Negate marks a leading '-'.
+type SearchNode struct {
+	Kind       string  `json:"kind"`
+	Field      string  `json:"field,omitempty"`
+	Op         string  `json:"op,omitempty"`
+	Value      string  `json:"value"`
+	Seconds    float64 `json:"seconds,omitempty"`
+	MaxSeconds float64 `json:"max_seconds,omitempty"`
+	Negate     bool    `json:"negate,omitempty"`
+	Pos        int     `json:"pos"`
+}
+
+// SearchParseError reports a malformed search query. Pos is the 1-based
+// character column the problem starts at.
+type SearchParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SearchParseError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos, e.Msg)
+}
+
+// ParseSearchQuery parses the advanced search syntax: bare words and
+// "quoted phrases" match the full-text index, a leading '-' excludes a word,
+// phrase, or topic/channel/platform filter, and field filters narrow the
+// results:
+//
+//	topic:cooking channel:"Babish" platform:youtube
+//	dur:<60 dur:>=30 dur:30..90 dur:<2m
+//	after:2024-06-01 before:2024-07-01
+func ParseSearchQuery(q string) ([]SearchNode, error) {
+	runes := []rune(q)
+	var nodes []SearchNode
+	i := 0
+	for i < len(runes) {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		negate := false
+		if runes[i] == '-' {
+			negate = true
+			i++
+			if i == len(runes) || unicode.IsSpace(runes[i]) {
+				return nil, &SearchParseError{Pos: start + 1, Msg: "nothing to exclude after '-'"}
+			}
+		}
+
+		if runes[i] == '"' {
+			phrase, next, err := readQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			if strings.TrimSpace(phrase) == "" {
+				return nil, &SearchParseError{Pos: start + 1, Msg: "empty phrase"}
+			}
+			nodes = append(nodes, SearchNode{Kind: nodePhrase, Value: phrase, Negate: negate, Pos: start + 1})
+			continue
+		}
+
+		wordStart := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != ':' && runes[i] != '"' {
+			i++
+		}
+		word := string(runes[wordStart:i])
+		if i == len(runes) || runes[i] != ':' || !isFieldName(word) {
+			// A stray quote or colon inside a word is part of the word.
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			word = string(runes[wordStart:i])
+			nodes = append(nodes, SearchNode{Kind: nodeTerm, Value: word, Negate: negate, Pos: start + 1})
+			continue
+		}
+
+		field := strings.ToLower(word)
+		if !isKnownField(field) {
+			msg := fmt.Sprintf("unknown field %q; fields are %s", field, strings.Join(searchFields, ", "))
+			if guess := closestField(field); guess != "" {
+				msg = fmt.Sprintf("unknown field %q; did you mean %q?", field, guess)
+			}
+			return nil, &SearchParseError{Pos: wordStart + 1, Msg: msg}
+		}
+		i++ // the colon
+		var value string
+		if i < len(runes) && runes[i] == '"' {
+			v, next, err := readQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			value, i = v, next
+		} else {
+			valueStart := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			value = string(runes[valueStart:i])
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, &SearchParseError{Pos: start + 1, Msg: fmt.Sprintf("%s: needs a value", field)}
+		}
+
+		n := SearchNode{Kind: nodeField, Field: field, Value: value, Negate: negate, Pos: start + 1}
+		if err := n.parseValue(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// readQuoted reads the quoted string opening at runes[open], returning its
+// contents and the index after the closing quote.
+func readQuoted(runes []rune, open int) (string, int, error) {
+	for j := open + 1; j < len(runes); j++ {
+		if runes[j] == '"' {
+			return string(runes[open+1 : j]), j + 1, nil
+		}
+	}
+	return "", 0, &SearchParseError{Pos: open + 1, Msg: "unterminated quote"}
+}
+
+func isFieldName(word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, r := range word {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isKnownField(field string) bool {
+	for _, f := range searchFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// closestField suggests the known field within two edits of field.
+func closestField(field string) string {
+	best, bestDist := "", 3
+	for _, f := range searchFields {
+		if d := editDistance(field, f); d < bestDist {
+			best, bestDist = f, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// parseValue validates a field node's value and fills in its operator.
+func (n *SearchNode) parseValue() error {
+	fail := func(format string, args ...interface{}) error {
+		return &SearchParseError{Pos: n.Pos, Msg: fmt.Sprintf(format, args...)}
+	}
+	switch n.Field {
+	case "topic", "channel", "platform":
+		n.Op = "="
+		return nil
+	case "dur":
+		if n.Negate {
+			return fail("dur: cannot be excluded; flip the comparison instead, e.g. dur:>60")
+		}
+		if lo, hi, ok := strings.Cut(n.Value, ".."); ok {
+			from, err1 := parseDurationValue(lo)
+			to, err2 := parseDurationValue(hi)
+			if err1 != nil || err2 != nil || from > to {
+				return fail("dur: range %q must look like 30..90 with the smaller bound first", n.Value)
+			}
+			n.Op, n.Seconds, n.MaxSeconds = "..", from, to
+			return nil
+		}
+		op := ""
+		for _, candidate := range []string{"<=", ">=", "<", ">", "="} {
+			if strings.HasPrefix(n.Value, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return fail("dur: needs a comparison such as dur:<60 or a range such as dur:30..90")
+		}
+		secs, err := parseDurationValue(n.Value[len(op):])
+		if err != nil {
+			return fail("dur: %q is not a duration; use seconds (60) or units (90s, 2m)", n.Value[len(op):])
+		}
+		n.Op, n.Seconds = op, secs
+		return nil
+	case "after", "before":
+		if n.Negate {
+			other := map[string]string{"after": "before", "before": "after"}[n.Field]
+			return fail("%s: cannot be excluded; use %s: instead", n.Field, other)
+		}
+		if _, err := time.Parse("2006-01-02", n.Value); err != nil {
+			return fail("%s: date %q must be YYYY-MM-DD", n.Field, n.Value)
+		}
+		n.Op = map[string]string{"after": ">=", "before": "<"}[n.Field]
+		return nil
+	}
+	return fail("unknown field %q", n.Field)
+}
+
+// parseDurationValue reads seconds, either a bare number or a Go duration
+// such as 90s or 2m.
+func parseDurationValue(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs >= 0 {
+		return secs, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d.Seconds(), nil
+}
+
+// searchPlan is a parsed query lowered to SQL over clips c joined to
+// sources s: the full-text terms to match and rank by, and the conditions
+// the other nodes add.
+type searchPlan struct {
+	match     []string
+	where     []string
+	whereArgs []interface{}
+}
+
+// planSearch lowers nodes to SQL. Words and phrases become full-text
+// matches, excluded ones NOT IN subqueries on the index; topic filters
+// include the topic's descendants in the topic graph.
+func (h *Handler) planSearch(nodes []SearchNode) searchPlan {
+	var p searchPlan
+	for _, n := range nodes {
+		switch n.Kind {
+		case nodeTerm, nodePhrase:
+			if !n.Negate {
+				p.match = append(p.match, n.Value)
+				continue
+			}
+			p.where = append(p.where, "c.id NOT IN (SELECT clip_id FROM clips_fts WHERE "+h.ftsCondition(1)+")")
+			p.whereArgs = append(p.whereArgs, h.ftsArgs([]string{n.Value})...)
+		case nodeField:
+			cond, args := h.fieldCondition(n)
+			if n.Negate {
+				cond = "NOT " + cond
+			}
+			p.where = append(p.where, cond)
+			p.whereArgs = append(p.whereArgs, args...)
+		}
+	}
+	return p
+}
+
+// ftsCondition matches n terms against clips_fts, all of which must occur.
+func (h *Handler) ftsCondition(n int) string {
+	if h.DB.IsPostgres() {
+		return "clips_fts.tsv @@ " + ftsTSQuery(n)
+	}
+	return "clips_fts MATCH ?"
+}
+
+// ftsTSQuery ANDs n phrase queries together.
+func ftsTSQuery(n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = "phraseto_tsquery('english', ?)"
+	}
+	return "(" + strings.Join(parts, " && ") + ")"
+}
+
+// ftsArgs binds terms for ftsCondition. FTS5 gets one expression of quoted
+// phrases, which it ANDs; Postgres gets one argument per phrase query.
+func (h *Handler) ftsArgs(terms []string) []interface{} {
+	if h.DB.IsPostgres() {
+		args := make([]interface{}, len(terms))
+		for i, t := range terms {
+			args[i] = t
+		}
+		return args
+	}
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return []interface{}{strings.Join(quoted, " ")}
+}
+
+func (h *Handler) fieldCondition(n SearchNode) (string, []interface{}) {
+	switch n.Field {
+	case "topic":
+		// Clips tagged before the topic graph knew the topic only carry it in
+		// their topics JSON.
+		cond := "(LOWER(COALESCE(c.topics, '')) LIKE ?"
+		args := []interface{}{`%"` + strings.ToLower(n.Value) + `"%`}
+		if ids := h.ExpandTopicDescendants([]string{n.Value}); len(ids) > 0 {
+			cond += " OR c.id IN (SELECT clip_id FROM clip_topics WHERE topic_id IN (?" + strings.Repeat(", ?", len(ids)-1) + "))"
+			for _, id := range ids {
+				args = append(args, id)
+			}
+		}
+		return cond + ")", args
+	case "channel":
+		return "(LOWER(COALESCE(s.channel_name, '')) = ?)", []interface{}{strings.ToLower(n.Value)}
+	case "platform":
+		return "(LOWER(COALESCE(s.platform, '')) = ?)", []interface{}{strings.ToLower(n.Value)}
+	case "dur":
+		if n.Op == ".." {
+			return "c.duration_seconds BETWEEN ? AND ?", []interface{}{n.Seconds, n.MaxSeconds}
+		}
+		return "c.duration_seconds " + n.Op + " ?", []interface{}{n.Seconds}
+	default: // after, before
+		return "c.created_at " + n.Op + " ?", []interface{}{n.Value}
+	}
+}
+
+// MatchClips returns the IDs of up to limit ready clips matching query, in
+// the order search ranks them. It is how smart collections fill themselves.
+func (h *Handler) MatchClips(ctx context.Context, query string, limit int) ([]string, error) {
+	nodes, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	rows, err := h.querySearch(ctx, h.planSearch(nodes), "", nil, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id, title, topicsJSON string
+		var thumbnailKey, platform, channelName, sourceURL *string
+		var duration, score float64
+		if err := rows.Scan(&id, &title, &duration, &thumbnailKey, &topicsJSON, &score, &platform, &channelName, &sourceURL); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const (
+	// searchLimit is how many hits a search returns.
+	searchLimit = 20
+
+	// searchPoolSize is how many of the best matches a collapsed search
+	// draws its hits from, so a page is still filled after a dominant
+	// channel collapses to one hit.
+	searchPoolSize = 200
+
+	// searchDiversityMix is how strongly search results are diversified
+	// by topic, on the DiversityMix scale.
+	searchDiversityMix = 0.5
+)
+
+// collapseChannels keeps the best hit of each channel, in order, and sets
+// more_from_channel on it to how many of the other hits came from that
+// channel. Hits without a channel are all kept.
+func collapseChannels(hits []map[string]interface{}) []map[string]interface{} {
+	kept := make([]map[string]interface{}, 0, len(hits))
+	first := make(map[string]map[string]interface{})
+	for _, hit := range hits {
+		ch, _ := hit["channel_name"].(*string)
+		if ch == nil || *ch == "" {
+			kept = append(kept, hit)
+			continue
+		}
+		if top, ok := first[*ch]; ok {
+			top["more_from_channel"] = top["more_from_channel"].(int) + 1
+			continue
+		}
+		hit["more_from_channel"] = 0
+		first[*ch] = hit
+		kept = append(kept, hit)
+	}
+	return kept
+}
+
+// diversifySearchHits reorders hits so that ones repeating the topics of
+// hits above them give way to nearby hits on other topics. Relevance is
+// taken from rank and falls off slowly, so a hit only moves past others
+// of similar relevance.
+func (h *Handler) diversifySearchHits(hits []map[string]interface{}) {
+	for i, hit := range hits {
+		hit["_score"] = 1 / (1 + float64(i)/searchLimit)
+	}
+	h.applyDiversityPenalty(hits, searchDiversityMix)
+	for _, hit := range hits {
+		delete(hit, "_score")
+	}
+}
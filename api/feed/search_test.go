@@ -0,0 +1,78 @@
+package feed
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	nodes, err := ParseSearchQuery(`topic:cooking channel:"Babish Culinary" dur:<60 after:2024-06-01 pasta -vegan "al dente" -"store bought" dur:30..90 dur:<2m`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery: %v", err)
+	}
+	want := []SearchNode{
+		{Kind: nodeField, Field: "topic", Op: "=", Value: "cooking", Pos: 1},
+		{Kind: nodeField, Field: "channel", Op: "=", Value: "Babish Culinary", Pos: 15},
+		{Kind: nodeField, Field: "dur", Op: "<", Value: "<60", Seconds: 60, Pos: 41},
+		{Kind: nodeField, Field: "after", Op: ">=", Value: "2024-06-01", Pos: 49},
+		{Kind: nodeTerm, Value: "pasta", Pos: 66},
+		{Kind: nodeTerm, Value: "vegan", Negate: true, Pos: 72},
+		{Kind: nodePhrase, Value: "al dente", Pos: 79},
+		{Kind: nodePhrase, Value: "store bought", Negate: true, Pos: 90},
+		{Kind: nodeField, Field: "dur", Op: "..", Value: "30..90", Seconds: 30, MaxSeconds: 90, Pos: 106},
+		{Kind: nodeField, Field: "dur", Op: "<", Value: "<2m", Seconds: 120, Pos: 117},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes =\n%+v\nwant\n%+v", nodes, want)
+	}
+}
+
+func TestParseSearchQuery_PlainWordsAndColons(t *testing.T) {
+	nodes, err := ParseSearchQuery(`sourdough 10:30 it's`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery: %v", err)
+	}
+	var values []string
+	for _, n := range nodes {
+		if n.Kind != nodeTerm {
+			t.Errorf("node %+v is not a term", n)
+		}
+		values = append(values, n.Value)
+	}
+	if strings.Join(values, "|") != "sourdough|10:30|it's" {
+		t.Errorf("terms = %v", values)
+	}
+}
+
+func TestParseSearchQuery_Errors(t *testing.T) {
+	cases := []struct {
+		q    string
+		pos  int
+		want string
+	}{
+		{`pasta "al dente`, 7, "unterminated quote"},
+		{`topc:cooking`, 1, `did you mean "topic"`},
+		{`colour:red`, 1, "fields are topic"},
+		{`dur:60`, 1, "needs a comparison"},
+		{`dur:<soon`, 1, "not a duration"},
+		{`dur:90..30`, 1, "smaller bound first"},
+		{`x after:June`, 3, "must be YYYY-MM-DD"},
+		{`-dur:<60`, 1, "cannot be excluded"},
+		{`channel:`, 1, "needs a value"},
+		{`pasta -`, 7, "nothing to exclude"},
+		{`""`, 1, "empty phrase"},
+	}
+	for _, c := range cases {
+		_, err := ParseSearchQuery(c.q)
+		var pe *SearchParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("ParseSearchQuery(%q) err = %v, want a parse error", c.q, err)
+			continue
+		}
+		if pe.Pos != c.pos || !strings.Contains(pe.Msg, c.want) {
+			t.Errorf("ParseSearchQuery(%q) = column %d %q, want column %d containing %q", c.q, pe.Pos, pe.Msg, c.pos, c.want)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// seriesMinSimilarity is the mean text-embedding similarity a part must
+	// have to the rest of its series to stay in it; titles alone can collide.
+	seriesMinSimilarity = 0.5
+	// seriesFeedSlots caps how many next-part clips are pinned to a feed page.
+	seriesFeedSlots = 2
+	// seriesWatchWindow is how far back a watched part counts as progress.
+	seriesWatchWindow = "-30 days"
+)
+
+// seriesPartPattern matches part markers such as "Part 2", "pt. 3", "Ep 4",
+// "Chapter 5", and "2/3". Bare "#N" is ignored because ranked lists and
+// numbered uploads use it for unrelated clips.
+var seriesPartPattern = regexp.MustCompile(`(?i)\b(?:part|pt\.?|ep(?:isode)?\.?|chapter|ch\.?)\s*#?\s*(\d{1,3})\b|\b(\d{1,3})\s*(?:/|of)\s*\d{1,3}\b`)
+
+var nonAlnum = regexp.MustCompile(`[^\pL\pN]+`)
+
+// parseSeriesTitle splits a clip title into a normalised series stem, a
+// display title, and a part number. The stem is the text before the part
+// marker, or after it when the marker leads the title.
+func parseSeriesTitle(title string) (stem, display string, part int, ok bool) {
+	m := seriesPartPattern.FindStringSubmatchIndex(title)
+	if m == nil {
+		return "", "", 0, false
+	}
+	if m[2] >= 0 {
+		part, _ = strconv.Atoi(title[m[2]:m[3]])
+	} else {
+		part, _ = strconv.Atoi(title[m[4]:m[5]])
+	}
+	if part < 1 {
+		return "", "", 0, false
+	}
+
+	display = strings.Trim(title[:m[0]], " -–—:|([")
+	if display == "" {
+		display = strings.Trim(title[m[1]:], " -–—:|)]")
+	}
+	stem = strings.TrimSpace(nonAlnum.ReplaceAllString(strings.ToLower(display), " "))
+	if len(stem) < 3 {
+		return "", "", 0, false
+	}
+	return stem, display, part, true
+}
+
+// seriesCandidate is a clip considered for series clustering.
+type seriesCandidate struct {
+	ID        string
+	Title     string
+	Channel   string
+	Platform  string
+	CreatedAt string
+	Embedding []float32
+
+	stem    string
+	display string
+	part    int
+}
+
+// seriesGroup is a detected series with its parts in order.
+type seriesGroup struct {
+	Title    string
+	Channel  string
+	Platform string
+	Parts    []seriesCandidate
+}
+
+// clusterSeries groups candidates by channel and title stem, keeps the
+// earliest upload of each part number, and drops parts whose embeddings
+// disagree with the rest of the group. Groups need two or more parts.
+func clusterSeries(candidates []seriesCandidate) []seriesGroup {
+	byKey := make(map[string][]seriesCandidate)
+	var keys []string
+	for _, c := range candidates {
+		stem, display, part, ok := parseSeriesTitle(c.Title)
+		if !ok || c.Channel == "" {
+			continue
+		}
+		c.stem, c.display, c.part = stem, display, part
+		key := c.Platform + "\x00" + c.Channel + "\x00" + stem
+		if _, seen := byKey[key]; !seen {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], c)
+	}
+	sort.Strings(keys)
+
+	var groups []seriesGroup
+	for _, key := range keys {
+		members := byKey[key]
+		sort.SliceStable(members, func(i, j int) bool {
+			if members[i].part != members[j].part {
+				return members[i].part < members[j].part
+			}
+			return members[i].CreatedAt < members[j].CreatedAt
+		})
+		parts := members[:0:0]
+		for _, m := range members {
+			if len(parts) > 0 && parts[len(parts)-1].part == m.part {
+				continue
+			}
+			parts = append(parts, m)
+		}
+
+		parts = dropDissimilarParts(parts)
+		if len(parts) < 2 {
+			continue
+		}
+		groups = append(groups, seriesGroup{
+			Title: parts[0].display, Channel: parts[0].Channel, Platform: parts[0].Platform, Parts: parts,
+		})
+	}
+	return groups
+}
+
+// dropDissimilarParts removes parts whose mean embedding similarity to the
+// other embedded parts is below seriesMinSimilarity. Parts without
+// embeddings are kept on title evidence alone.
+func dropDissimilarParts(parts []seriesCandidate) []seriesCandidate {
+	kept := parts[:0:0]
+	for i, p := range parts {
+		if len(p.Embedding) == 0 {
+			kept = append(kept, p)
+			continue
+		}
+		sum, n := 0.0, 0
+		for j, q := range parts {
+			if i == j || len(q.Embedding) == 0 {
+				continue
+			}
+			sum += CosineSimilarity(p.Embedding, q.Embedding)
+			n++
+		}
+		if n == 0 || sum/float64(n) >= seriesMinSimilarity {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// ClusterSeries rebuilds series from ready clips. Existing series keep their
+// IDs when any of their clips is still grouped, so links stay stable across
+// runs. It returns the number of series written.
+func (h *Handler) ClusterSeries(ctx context.Context) (int, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT c.id, c.title, c.created_at, COALESCE(s.channel_name, ''), COALESCE(s.platform, '')
+		FROM clips c
+		JOIN sources s ON c.source_id = s.id
+		WHERE c.status = 'ready' AND s.channel_name IS NOT NULL AND s.channel_name != ''
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var candidates []seriesCandidate
+	for rows.Next() {
+		var c seriesCandidate
+		if rows.Scan(&c.ID, &c.Title, &c.CreatedAt, &c.Channel, &c.Platform) != nil {
+			continue
+		}
+		if seriesPartPattern.MatchString(c.Title) {
+			candidates = append(candidates, c)
+		}
+	}
+	rows.Close()
+
+	for i := range candidates {
+		var blob []byte
+		if h.DB.QueryRowContext(ctx, `SELECT text_embedding FROM clip_embeddings WHERE clip_id = ?`, candidates[i].ID).Scan(&blob) == nil {
+			candidates[i].Embedding = BlobToFloat32(blob)
+		}
+	}
+	groups := clusterSeries(candidates)
+
+	existing := make(map[string]string)
+	rows, err = h.DB.QueryContext(ctx, `SELECT clip_id, series_id FROM series_clips`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var clipID, seriesID string
+		if rows.Scan(&clipID, &seriesID) == nil {
+			existing[clipID] = seriesID
+		}
+	}
+	rows.Close()
+
+	now := h.DB.NowUTC()
+	err = db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		kept := make(map[string]bool, len(groups))
+		for _, g := range groups {
+			seriesID := ""
+			for _, p := range g.Parts {
+				if id := existing[p.ID]; id != "" && !kept[id] {
+					seriesID = id
+					break
+				}
+			}
+			if seriesID == "" {
+				seriesID = uuid.New().String()
+			}
+			kept[seriesID] = true
+
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+				INSERT INTO series (id, title, channel_name, platform, clip_count, updated_at) VALUES (?, ?, ?, ?, ?, %s)
+				ON CONFLICT(id) DO UPDATE SET
+					title = excluded.title, channel_name = excluded.channel_name, platform = excluded.platform,
+					clip_count = excluded.clip_count, updated_at = excluded.updated_at
+			`, now), seriesID, g.Title, g.Channel, g.Platform, len(g.Parts)); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, `DELETE FROM series_clips WHERE series_id = ?`, seriesID); err != nil {
+				return err
+			}
+			for _, p := range g.Parts {
+				if _, err := conn.ExecContext(ctx, `
+					INSERT INTO series_clips (clip_id, series_id, part_number) VALUES (?, ?, ?)
+					ON CONFLICT(clip_id) DO UPDATE SET series_id = excluded.series_id, part_number = excluded.part_number
+				`, p.ID, seriesID, p.part); err != nil {
+					return err
+				}
+			}
+		}
+
+		rows, err := conn.QueryContext(ctx, `SELECT id FROM series`)
+		if err != nil {
+			return err
+		}
+		var stale []string
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil && !kept[id] {
+				stale = append(stale, id)
+			}
+		}
+		rows.Close()
+		for _, id := range stale {
+			if _, err := conn.ExecContext(ctx, `DELETE FROM series_clips WHERE series_id = ?`, id); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, `DELETE FROM series WHERE id = ?`, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(groups), nil
+}
+
+// SeriesClusterLoop periodically regroups multi-part clips into series.
+func (h *Handler) SeriesClusterLoop() {
+	run := func() {
+		if h.Maintenance.Active() {
+			return
+		}
+		if n, err := h.ClusterSeries(context.Background()); err != nil {
+			log.Printf("series clustering failed: %v", err)
+		} else if n > 0 {
+			log.Printf("series clustering: %d series", n)
+		}
+	}
+	run()
+	ticker := time.NewTicker(30 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		run()
+	}
+}
+
+// nextSeriesParts returns, for series the user has recently watched part of,
+// the lowest-numbered later part they have not interacted with yet. Series
+// watched most recently come first.
+func (h *Handler) nextSeriesParts(ctx context.Context, userID string, n int) []map[string]interface{} {
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH watched AS (
+			SELECT sc.series_id, MAX(sc.part_number) AS part, MAX(i.created_at) AS last_at
+			FROM interactions i
+			JOIN series_clips sc ON sc.clip_id = i.clip_id
+			WHERE i.user_id = ? AND i.action IN ('view', 'watch_full', 'like', 'save', 'share')
+			  AND i.created_at > %s
+			GROUP BY sc.series_id
+		)
+		SELECT sc.series_id, sc.clip_id, sc.part_number
+		FROM watched w
+		JOIN series_clips sc ON sc.series_id = w.series_id AND sc.part_number > w.part
+		JOIN clips c ON c.id = sc.clip_id AND c.status = 'ready'
+		WHERE sc.clip_id NOT IN (SELECT clip_id FROM interactions WHERE user_id = ?)
+		ORDER BY w.last_at DESC, sc.series_id, sc.part_number ASC
+	`, h.DB.DatetimeModifier(seriesWatchWindow)), userID, userID)
+	if err != nil {
+		log.Printf("nextSeriesParts: %v", err)
+		return nil
+	}
+	type nextPart struct {
+		seriesID string
+		part     int
+	}
+	next := make(map[string]nextPart)
+	seenSeries := make(map[string]bool)
+	var ids []string
+	for rows.Next() {
+		var seriesID, clipID string
+		var part int
+		if rows.Scan(&seriesID, &clipID, &part) != nil {
+			continue
+		}
+		if seenSeries[seriesID] || len(ids) >= n {
+			continue
+		}
+		seenSeries[seriesID] = true
+		next[clipID] = nextPart{seriesID, part}
+		ids = append(ids, clipID)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ph := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		ph[i], args[i] = "?", id
+	}
+	rows, err = h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+discoverClipColumns+`
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.id IN (%s)
+	`, h.DB.AgeHoursExpr("c.created_at"), strings.Join(ph, ",")), args...)
+	if err != nil {
+		log.Printf("nextSeriesParts: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	clips := httputil.ScanClips(rows)
+	stripRankingFields(clips)
+
+	order := make(map[string]int, len(ids))
+	for i, id := range ids {
+		order[id] = i
+	}
+	sort.SliceStable(clips, func(i, j int) bool {
+		return order[clips[i]["id"].(string)] < order[clips[j]["id"].(string)]
+	})
+	for _, c := range clips {
+		p := next[c["id"].(string)]
+		c["series_id"] = p.seriesID
+		c["series_part"] = p.part
+		c["series_next"] = true
+	}
+	return clips
+}
+
+// pinSeriesParts puts next-part clips at the top of the page, dropping any
+// duplicates further down, and keeps the page at limit clips.
+func pinSeriesParts(clips, next []map[string]interface{}, limit int) []map[string]interface{} {
+	if len(next) == 0 {
+		return clips
+	}
+	pinned := make(map[string]bool, len(next))
+	for _, c := range next {
+		pinned[c["id"].(string)] = true
+	}
+	out := append(make([]map[string]interface{}, 0, len(clips)+len(next)), next...)
+	for _, c := range clips {
+		if !pinned[c["id"].(string)] {
+			out = append(out, c)
+		}
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// HandleGetSeries returns a series with its parts in order. Signed-in users
+// also get which parts they have watched and the next part to play.
+func (h *Handler) HandleGetSeries(w http.ResponseWriter, r *http.Request) {
+	seriesID := chi.URLParam(r, "id")
+	userID, _ := auth.ExtractUserID(r)
+
+	var title, createdAt, updatedAt string
+	var channel, platform *string
+	var clipCount int
+	err := h.DB.QueryRowContext(r.Context(), `
+		SELECT title, channel_name, platform, clip_count, created_at, updated_at FROM series WHERE id = ?
+	`, seriesID).Scan(&title, &channel, &platform, &clipCount, &createdAt, &updatedAt)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "series not found"})
+		return
+	}
+
+	parts := make(map[string]int)
+	pr, err := h.DB.QueryContext(r.Context(), `SELECT clip_id, part_number FROM series_clips WHERE series_id = ?`, seriesID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load series"})
+		return
+	}
+	for pr.Next() {
+		var id string
+		var part int
+		if pr.Scan(&id, &part) == nil {
+			parts[id] = part
+		}
+	}
+	pr.Close()
+
+	rows, err := h.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT `+discoverClipColumns+`
+		FROM series_clips sc
+		JOIN clips c ON sc.clip_id = c.id
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE sc.series_id = ? AND c.status = 'ready'
+		ORDER BY sc.part_number ASC
+	`, h.DB.AgeHoursExpr("c.created_at")), seriesID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load series"})
+		return
+	}
+	defer rows.Close()
+	clips := httputil.ScanClips(rows)
+	stripRankingFields(clips)
+
+	watched := make(map[string]bool)
+	if userID != "" {
+		wr, err := h.DB.QueryContext(r.Context(), `
+			SELECT DISTINCT i.clip_id FROM interactions i
+			JOIN series_clips sc ON sc.clip_id = i.clip_id
+			WHERE i.user_id = ? AND sc.series_id = ? AND i.action IN ('view', 'watch_full', 'like', 'save', 'share')
+		`, userID, seriesID)
+		if err == nil {
+			for wr.Next() {
+				var id string
+				if wr.Scan(&id) == nil {
+					watched[id] = true
+				}
+			}
+			wr.Close()
+		}
+	}
+
+	var nextClipID interface{}
+	lastWatched := 0
+	for _, c := range clips {
+		id := c["id"].(string)
+		c["part_number"] = parts[id]
+		if userID != "" {
+			c["watched"] = watched[id]
+			if watched[id] {
+				lastWatched = parts[id]
+			}
+		}
+	}
+	if userID != "" {
+		for _, c := range clips {
+			if id := c["id"].(string); parts[id] > lastWatched && !watched[id] {
+				nextClipID = id
+				break
+			}
+		}
+	}
+	httputil.AddThumbnailURLs(clips, h.MinioBucket)
+	httputil.AddAttributions(r.Context(), h.DB, clips)
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"id": seriesID, "title": title, "channel_name": channel, "platform": platform,
+		"clip_count": clipCount, "created_at": createdAt, "updated_at": updatedAt,
+		"clips": clips, "next_clip_id": nextClipID,
+	})
+}
@@ -0,0 +1,54 @@
+package feed
+
+import "testing"
+
+func TestParseSeriesTitle(t *testing.T) {
+	cases := []struct {
+		title string
+		stem  string
+		part  int
+		ok    bool
+	}{
+		{"My Trip to Japan - Part 2", "my trip to japan", 2, true},
+		{"My Trip to Japan (pt. 3)", "my trip to japan", 3, true},
+		{"Building a Shed Ep 4: Roofing", "building a shed", 4, true},
+		{"Part 1: The Beginning", "the beginning", 1, true},
+		{"Chess openings 2/5", "chess openings", 2, true},
+		{"Top 10 plays #1", "", 0, false},
+		{"Part 0 of nothing", "", 0, false},
+		{"Just a clip", "", 0, false},
+	}
+	for _, c := range cases {
+		stem, _, part, ok := parseSeriesTitle(c.title)
+		if ok != c.ok || stem != c.stem || part != c.part {
+			t.Errorf("parseSeriesTitle(%q) = (%q, %d, %v), want (%q, %d, %v)", c.title, stem, part, ok, c.stem, c.part, c.ok)
+		}
+	}
+}
+
+func TestClusterSeries_GroupsByChannelAndDropsOutliers(t *testing.T) {
+	near := []float32{1, 0.1, 0}
+	far := []float32{0, 0, 1}
+	candidates := []seriesCandidate{
+		{ID: "a1", Title: "Shed build part 1", Channel: "Woody", Embedding: near, CreatedAt: "2024-01-01"},
+		{ID: "a2", Title: "Shed build part 2", Channel: "Woody", Embedding: near, CreatedAt: "2024-01-02"},
+		{ID: "a2b", Title: "Shed Build - Part 2 (reupload)", Channel: "Woody", Embedding: near, CreatedAt: "2024-01-05"},
+		{ID: "a3", Title: "Shed build part 3", Channel: "Woody", Embedding: far, CreatedAt: "2024-01-03"},
+		{ID: "a4", Title: "Shed build part 4", Channel: "Woody", CreatedAt: "2024-01-04"},
+		{ID: "b1", Title: "Shed build part 1", Channel: "Other", Embedding: near},
+	}
+	groups := clusterSeries(candidates)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	var ids []string
+	for _, p := range groups[0].Parts {
+		ids = append(ids, p.ID)
+	}
+	if len(ids) != 3 || ids[0] != "a1" || ids[1] != "a2" || ids[2] != "a4" {
+		t.Errorf("parts = %v, want [a1 a2 a4]", ids)
+	}
+	if groups[0].Title != "Shed build" {
+		t.Errorf("title = %q", groups[0].Title)
+	}
+}
@@ -93,7 +93,7 @@ func (h *Handler) HandleSimilarClips(w http.ResponseWriter, r *http.Request) {
 			data: map[string]interface{}{
 				"id": cid, "title": title, "thumbnail_key": thumbKey,
 				"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumbKey),
-				"duration_seconds": dur, "content_score": cs, "similarity": math.Round(sim*1000) / 1000,
+				"duration_seconds": dur, "duration_bucket": httputil.DurationBucket(dur), "content_score": cs, "similarity": math.Round(sim*1000) / 1000,
 			},
 			score: sim,
 		})
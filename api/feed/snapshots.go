@@ -0,0 +1,385 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSnapshotK = FeedLimit
+	maxSnapshotK     = 100
+	// maxSnapshotUsers caps the users one snapshot ranks for; it is also how
+	// many users are sampled when none are named.
+	maxSnapshotUsers = 200
+
+	// DefaultMinOverlap and DefaultMinCorrelation are the thresholds below
+	// which a user's ranking counts as regressed.
+	DefaultMinOverlap     = 0.8
+	DefaultMinCorrelation = 0.5
+)
+
+// CaptureFeeds ranks the feed candidates of each user with their current
+// preferences and returns the top k clip IDs per user. It runs the ranking
+// stage of the feed without pacing, filters, or bandit exploration, so the
+// output only changes with the data, the ranking code, or its parameters.
+func (h *Handler) CaptureFeeds(ctx context.Context, userIDs []string, k int) (map[string][]string, error) {
+	feeds := make(map[string][]string, len(userIDs))
+	for _, userID := range userIDs {
+		topicWeights, _, prefs := h.loadFeedPrefs(ctx, userID)
+		candidates, err := h.sandboxCandidates(ctx, userID, prefs, nil, k*3)
+		if err != nil {
+			return nil, fmt.Errorf("load candidates for %s: %w", userID, err)
+		}
+		h.rankClips(ctx, candidates, userID, topicWeights, rankerAuto, prefs)
+		ids := make([]string, 0, k)
+		for _, c := range candidates {
+			if len(ids) == k {
+				break
+			}
+			if id, ok := c["id"].(string); ok {
+				ids = append(ids, id)
+			}
+		}
+		feeds[userID] = ids
+	}
+	return feeds, nil
+}
+
+// OverlapAtK is the share of golden's top k that is also in current's top
+// k. Two empty rankings overlap fully.
+func OverlapAtK(golden, current []string, k int) float64 {
+	golden, current = topK(golden, k), topK(current, k)
+	if len(golden) == 0 {
+		if len(current) == 0 {
+			return 1
+		}
+		return 0
+	}
+	in := make(map[string]bool, len(current))
+	for _, id := range current {
+		in[id] = true
+	}
+	shared := 0
+	for _, id := range golden {
+		if in[id] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(golden))
+}
+
+// RankCorrelation is Kendall's tau between the orders golden and current
+// give the clips both rank: 1 when they agree on every pair, -1 when they
+// disagree on every pair. With fewer than two shared clips there is no
+// order to disagree on and it is 1.
+func RankCorrelation(golden, current []string) float64 {
+	pos := make(map[string]int, len(current))
+	for i, id := range current {
+		pos[id] = i
+	}
+	var shared []int // current positions, in golden order
+	for _, id := range golden {
+		if p, ok := pos[id]; ok {
+			shared = append(shared, p)
+		}
+	}
+	if len(shared) < 2 {
+		return 1
+	}
+	concordant, discordant := 0, 0
+	for i := 0; i < len(shared); i++ {
+		for j := i + 1; j < len(shared); j++ {
+			if shared[i] < shared[j] {
+				concordant++
+			} else {
+				discordant++
+			}
+		}
+	}
+	return float64(concordant-discordant) / float64(concordant+discordant)
+}
+
+func topK(ids []string, k int) []string {
+	if k > 0 && len(ids) > k {
+		return ids[:k]
+	}
+	return ids
+}
+
+// FeedDiff compares one user's golden and current rankings.
+type FeedDiff struct {
+	UserID          string   `json:"user_id"`
+	OverlapAtK      float64  `json:"overlap_at_k"`
+	RankCorrelation float64  `json:"rank_correlation"`
+	Added           []string `json:"added"`
+	Removed         []string `json:"removed"`
+}
+
+// FeedComparison compares golden rankings with current ones across users.
+// Users is ordered worst overlap first.
+type FeedComparison struct {
+	K                   int        `json:"k"`
+	MeanOverlapAtK      float64    `json:"mean_overlap_at_k"`
+	MinOverlapAtK       float64    `json:"min_overlap_at_k"`
+	MeanRankCorrelation float64    `json:"mean_rank_correlation"`
+	Users               []FeedDiff `json:"users"`
+}
+
+// CompareFeeds compares each golden user's top k with their current one.
+// A user missing from current is compared against an empty ranking.
+func CompareFeeds(golden, current map[string][]string, k int) FeedComparison {
+	cmp := FeedComparison{K: k, MinOverlapAtK: 1, Users: make([]FeedDiff, 0, len(golden))}
+	for userID, g := range golden {
+		g, c := topK(g, k), topK(current[userID], k)
+		d := FeedDiff{
+			UserID:          userID,
+			OverlapAtK:      OverlapAtK(g, c, k),
+			RankCorrelation: RankCorrelation(g, c),
+			Added:           missingFrom(c, g),
+			Removed:         missingFrom(g, c),
+		}
+		cmp.MeanOverlapAtK += d.OverlapAtK
+		cmp.MeanRankCorrelation += d.RankCorrelation
+		if d.OverlapAtK < cmp.MinOverlapAtK {
+			cmp.MinOverlapAtK = d.OverlapAtK
+		}
+		cmp.Users = append(cmp.Users, d)
+	}
+	if n := float64(len(cmp.Users)); n > 0 {
+		cmp.MeanOverlapAtK /= n
+		cmp.MeanRankCorrelation /= n
+	}
+	sort.Slice(cmp.Users, func(i, j int) bool {
+		if cmp.Users[i].OverlapAtK != cmp.Users[j].OverlapAtK {
+			return cmp.Users[i].OverlapAtK < cmp.Users[j].OverlapAtK
+		}
+		return cmp.Users[i].UserID < cmp.Users[j].UserID
+	})
+	return cmp
+}
+
+// missingFrom returns the IDs of a that are not in b, in a's order.
+func missingFrom(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, id := range b {
+		in[id] = true
+	}
+	out := make([]string, 0)
+	for _, id := range a {
+		if !in[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// HandleCreateFeedSnapshot captures golden rankings for user_ids, or for a
+// sample of users when none are given, and stores them under name.
+func (h *Handler) HandleCreateFeedSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string   `json:"name"`
+		UserIDs []string `json:"user_ids"`
+		K       int      `json:"k"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "name is required"})
+		return
+	}
+	if req.K == 0 {
+		req.K = defaultSnapshotK
+	}
+	if req.K < 1 || req.K > maxSnapshotK {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("k must be between 1 and %d", maxSnapshotK)})
+		return
+	}
+	if len(req.UserIDs) > maxSnapshotUsers {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d user_ids", maxSnapshotUsers)})
+		return
+	}
+
+	userIDs, err := h.snapshotUsers(r.Context(), req.UserIDs)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	feeds, err := h.CaptureFeeds(r.Context(), userIDs, req.K)
+	if err != nil {
+		log.Printf("feed snapshot: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to rank feeds"})
+		return
+	}
+	raw, _ := json.Marshal(feeds)
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO feed_snapshots (id, name, k, feeds) VALUES (?, ?, ?, ?)`,
+		id, req.Name, req.K, string(raw)); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save snapshot"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"id": id, "name": req.Name, "k": req.K, "users": len(feeds),
+	})
+}
+
+// snapshotUsers checks that the named users exist, or samples users when
+// none are named.
+func (h *Handler) snapshotUsers(ctx context.Context, userIDs []string) ([]string, error) {
+	if len(userIDs) == 0 {
+		rows, err := h.DB.QueryContext(ctx, `SELECT id FROM users ORDER BY id LIMIT ?`, maxSnapshotUsers)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil {
+				userIDs = append(userIDs, id)
+			}
+		}
+		if len(userIDs) == 0 {
+			return nil, errors.New("no users to snapshot")
+		}
+		return userIDs, rows.Err()
+	}
+	for _, id := range userIDs {
+		var n int
+		if err := h.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id = ?`, id).Scan(&n); err != nil || n == 0 {
+			return nil, fmt.Errorf("user %s not found", id)
+		}
+	}
+	return userIDs, nil
+}
+
+// HandleListFeedSnapshots lists stored snapshots, newest first.
+func (h *Handler) HandleListFeedSnapshots(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id, name, k, feeds, created_at FROM feed_snapshots ORDER BY created_at DESC, id`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list snapshots"})
+		return
+	}
+	defer rows.Close()
+	snapshots := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, name, feedsJSON, createdAt string
+		var k int
+		if err := rows.Scan(&id, &name, &k, &feedsJSON, &createdAt); err != nil {
+			continue
+		}
+		var feeds map[string][]string
+		json.Unmarshal([]byte(feedsJSON), &feeds)
+		snapshots = append(snapshots, map[string]interface{}{
+			"id": id, "name": name, "k": k, "users": len(feeds), "created_at": createdAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"snapshots": snapshots})
+}
+
+// HandleDiffFeedSnapshot re-ranks a snapshot's users and compares the
+// result with its golden rankings. Users whose overlap@k falls below
+// ?min_overlap (default 0.8) or whose rank correlation falls below
+// ?min_correlation (default 0.5) are listed as regressions.
+func (h *Handler) HandleDiffFeedSnapshot(w http.ResponseWriter, r *http.Request) {
+	minOverlap, err := thresholdParam(r, "min_overlap", DefaultMinOverlap)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	minCorrelation, err := thresholdParam(r, "min_correlation", DefaultMinCorrelation)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var name, feedsJSON, createdAt string
+	var k int
+	err = h.DB.QueryRowContext(r.Context(),
+		`SELECT name, k, feeds, created_at FROM feed_snapshots WHERE id = ?`, chi.URLParam(r, "id"),
+	).Scan(&name, &k, &feedsJSON, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "snapshot not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load snapshot"})
+		return
+	}
+	var golden map[string][]string
+	if err := json.Unmarshal([]byte(feedsJSON), &golden); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "snapshot is corrupt"})
+		return
+	}
+
+	userIDs := make([]string, 0, len(golden))
+	for id := range golden {
+		userIDs = append(userIDs, id)
+	}
+	sort.Strings(userIDs)
+	current, err := h.CaptureFeeds(r.Context(), userIDs, k)
+	if err != nil {
+		log.Printf("feed snapshot diff: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to rank feeds"})
+		return
+	}
+
+	cmp := CompareFeeds(golden, current, k)
+	regressions := make([]FeedDiff, 0)
+	for _, d := range cmp.Users {
+		if d.OverlapAtK < minOverlap || d.RankCorrelation < minCorrelation {
+			regressions = append(regressions, d)
+		}
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"snapshot":        map[string]interface{}{"name": name, "k": k, "created_at": createdAt},
+		"min_overlap":     minOverlap,
+		"min_correlation": minCorrelation,
+		"passed":          len(regressions) == 0,
+		"regressions":     regressions,
+		"comparison":      cmp,
+	})
+}
+
+// thresholdParam reads a threshold query parameter between -1 and 1.
+func thresholdParam(r *http.Request, name string, fallback float64) (float64, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < -1 || v > 1 {
+		return 0, fmt.Errorf("%s must be a number between -1 and 1", name)
+	}
+	return v, nil
+}
+
+// HandleDeleteFeedSnapshot removes a stored snapshot.
+func (h *Handler) HandleDeleteFeedSnapshot(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(), `DELETE FROM feed_snapshots WHERE id = ?`, chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete snapshot"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "snapshot not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
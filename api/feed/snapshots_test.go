@@ -0,0 +1,71 @@
+package feed
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOverlapAtK(t *testing.T) {
+	cases := []struct {
+		golden, current []string
+		k               int
+		want            float64
+	}{
+		{[]string{"a", "b", "c"}, []string{"c", "b", "a"}, 3, 1},
+		{[]string{"a", "b", "c", "d"}, []string{"a", "x", "b", "y"}, 4, 0.5},
+		{[]string{"a", "b", "c"}, []string{"a", "b", "z"}, 2, 1},
+		{nil, nil, 5, 1},
+		{[]string{"a"}, nil, 5, 0},
+	}
+	for _, c := range cases {
+		if got := OverlapAtK(c.golden, c.current, c.k); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("OverlapAtK(%v, %v, %d) = %v, want %v", c.golden, c.current, c.k, got, c.want)
+		}
+	}
+}
+
+func TestRankCorrelation(t *testing.T) {
+	cases := []struct {
+		golden, current []string
+		want            float64
+	}{
+		{[]string{"a", "b", "c"}, []string{"a", "b", "c"}, 1},
+		{[]string{"a", "b", "c"}, []string{"c", "b", "a"}, -1},
+		// One of three pairs swapped: (2-1)/3.
+		{[]string{"a", "b", "c"}, []string{"b", "a", "c"}, 1.0 / 3},
+		// Only shared clips count; x and y are ignored.
+		{[]string{"a", "x", "b"}, []string{"a", "y", "b"}, 1},
+		{[]string{"a"}, []string{"a"}, 1},
+	}
+	for _, c := range cases {
+		if got := RankCorrelation(c.golden, c.current); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("RankCorrelation(%v, %v) = %v, want %v", c.golden, c.current, got, c.want)
+		}
+	}
+}
+
+func TestCompareFeeds_WorstUserFirst(t *testing.T) {
+	golden := map[string][]string{
+		"u1": {"a", "b", "c", "d"},
+		"u2": {"a", "b", "c", "d"},
+		"u3": {"a", "b"},
+	}
+	current := map[string][]string{
+		"u1": {"a", "b", "c", "d"},
+		"u2": {"a", "e", "c", "f"},
+	}
+	cmp := CompareFeeds(golden, current, 4)
+	if len(cmp.Users) != 3 || cmp.Users[0].UserID != "u3" || cmp.Users[1].UserID != "u2" {
+		t.Fatalf("users = %+v, want u3, u2, u1", cmp.Users)
+	}
+	if cmp.MinOverlapAtK != 0 {
+		t.Errorf("min overlap = %v, want 0 for the user missing from current", cmp.MinOverlapAtK)
+	}
+	u2 := cmp.Users[1]
+	if u2.OverlapAtK != 0.5 || len(u2.Added) != 2 || u2.Added[0] != "e" || len(u2.Removed) != 2 || u2.Removed[0] != "b" {
+		t.Errorf("u2 = %+v, want overlap 0.5, added [e f], removed [b d]", u2)
+	}
+	if want := 0.5; math.Abs(cmp.MeanOverlapAtK-want) > 1e-9 {
+		t.Errorf("mean overlap = %v, want %v", cmp.MeanOverlapAtK, want)
+	}
+}
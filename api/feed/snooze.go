@@ -0,0 +1,26 @@
+package feed
+
+import "fmt"
+
+// snoozeFilter returns SnoozeFilter for the handler's database.
+func (h *Handler) snoozeFilter() string {
+	return SnoozeFilter(h.DB.NowUTC())
+}
+
+// SnoozeFilter returns a WHERE fragment that drops clips in a topic (or a
+// direct child of one) or from a channel the user has snoozed, given the
+// dialect's SQL expression for the current time. It expects clips aliased
+// c and sources s, and binds the user ID twice.
+func SnoozeFilter(now string) string {
+	return fmt.Sprintf(`
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_snoozes sn
+			JOIN topics t ON t.id = sn.target_id OR t.parent_id = sn.target_id
+			JOIN clip_topics ct ON ct.topic_id = t.id
+			WHERE sn.user_id = ? AND sn.target_type = 'topic' AND sn.expires_at > %s AND ct.clip_id = c.id
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_snoozes sn
+			WHERE sn.user_id = ? AND sn.target_type = 'channel' AND sn.expires_at > %s AND sn.target_id = s.channel_name
+		  )`, now, now)
+}
@@ -0,0 +1,343 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// thumbnailConvergeImpressions is how many impressions a clip's variants
+	// need in total before the best one becomes the clip's default
+	// thumbnail on surfaces that do not sample.
+	thumbnailConvergeImpressions = 500
+	thumbnailStatsLimit          = 50
+)
+
+// thumbnailVariant is one candidate thumbnail with a Beta posterior over
+// its click-through rate.
+type thumbnailVariant struct {
+	Variant     int
+	Key         string
+	Impressions int
+	Clicks      int
+}
+
+func (v thumbnailVariant) posterior() (float64, float64) {
+	misses := v.Impressions - v.Clicks
+	if misses < 0 {
+		misses = 0
+	}
+	return 1 + float64(v.Clicks), 1 + float64(misses)
+}
+
+// mean is the posterior mean click-through rate.
+func (v thumbnailVariant) mean() float64 {
+	a, b := v.posterior()
+	return a / (a + b)
+}
+
+// lowerBound is two standard deviations below the posterior mean, so a
+// variant that has barely been shown cannot win on its prior alone.
+func (v thumbnailVariant) lowerBound() float64 {
+	a, b := v.posterior()
+	n := a + b
+	return a/n - 2*math.Sqrt(a*b/(n*n*(n+1)))
+}
+
+// pickThumbnail Thompson-samples one variant.
+func pickThumbnail(rng *rand.Rand, variants []thumbnailVariant) thumbnailVariant {
+	best, bestSample := variants[0], -1.0
+	for _, v := range variants {
+		a, b := v.posterior()
+		if s := sampleBeta(rng, a, b); s > bestSample {
+			best, bestSample = v, s
+		}
+	}
+	return best
+}
+
+// selectThumbnails swaps in a sampled thumbnail for every clip that has
+// more than one variant. For a signed-in user it records the variant as
+// shown, counting an impression the first time they see it; anonymous
+// views are not counted, as their clicks cannot be credited. It returns the
+// chosen variant per clip ID so callers can report it after field
+// selection.
+func (h *Handler) selectThumbnails(ctx context.Context, clips []map[string]interface{}) map[string]int {
+	if len(clips) == 0 {
+		return nil
+	}
+	ph := make([]string, 0, len(clips))
+	args := make([]interface{}, 0, len(clips))
+	for _, c := range clips {
+		if id, ok := c["id"].(string); ok {
+			ph = append(ph, "?")
+			args = append(args, id)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT clip_id, variant, thumbnail_key, impressions, clicks
+		FROM clip_thumbnails
+		WHERE clip_id IN (`+strings.Join(ph, ",")+`)
+		ORDER BY clip_id, variant
+	`, args...)
+	if err != nil {
+		log.Printf("selectThumbnails: %v", err)
+		return nil
+	}
+	variants := make(map[string][]thumbnailVariant)
+	for rows.Next() {
+		var clipID string
+		var v thumbnailVariant
+		if rows.Scan(&clipID, &v.Variant, &v.Key, &v.Impressions, &v.Clicks) == nil {
+			variants[clipID] = append(variants[clipID], v)
+		}
+	}
+	rows.Close()
+
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	chosen := make(map[string]int)
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		if len(variants[id]) < 2 {
+			continue
+		}
+		v := pickThumbnail(rng, variants[id])
+		c["thumbnail_key"] = v.Key
+		chosen[id] = v.Variant
+		if userID != "" && !h.Maintenance.Active() {
+			h.recordThumbnailView(ctx, userID, id, v.Variant)
+		}
+	}
+	return chosen
+}
+
+// recordThumbnailView notes that a user was shown a variant, counting an
+// impression if they had not seen it before.
+func (h *Handler) recordThumbnailView(ctx context.Context, userID, clipID string, variant int) {
+	res, err := h.DB.ExecContext(ctx, `
+		INSERT INTO thumbnail_views (user_id, clip_id, variant) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, clip_id, variant) DO NOTHING
+	`, userID, clipID, variant)
+	if err != nil {
+		log.Printf("recordThumbnailView: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	h.DB.ExecContext(ctx,
+		`UPDATE clip_thumbnails SET impressions = impressions + 1 WHERE clip_id = ? AND variant = ?`, clipID, variant)
+}
+
+// useSmallThumbnails points thumbnail_url at each clip's small thumbnail,
+// for clips the worker made one for.
+func (h *Handler) useSmallThumbnails(ctx context.Context, clips []map[string]interface{}) {
+	ph := make([]string, 0, len(clips))
+	args := make([]interface{}, 0, len(clips))
+	for _, c := range clips {
+		if id, ok := c["id"].(string); ok {
+			ph = append(ph, "?")
+			args = append(args, id)
+		}
+	}
+	if len(args) == 0 {
+		return
+	}
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id, small_thumbnail_key FROM clips
+		WHERE id IN (`+strings.Join(ph, ",")+`) AND COALESCE(small_thumbnail_key, '') <> ''
+	`, args...)
+	if err != nil {
+		log.Printf("useSmallThumbnails: %v", err)
+		return
+	}
+	small := make(map[string]string)
+	for rows.Next() {
+		var id, key string
+		if rows.Scan(&id, &key) == nil {
+			small[id] = key
+		}
+	}
+	rows.Close()
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		if key, ok := small[id]; ok {
+			c["thumbnail_url"] = httputil.ThumbnailURL(h.MinioBucket, key)
+		}
+	}
+}
+
+// addThumbnailVariants labels clips whose thumbnail was sampled, so clients
+// can report which variant was clicked.
+func addThumbnailVariants(clips []map[string]interface{}, chosen map[string]int) {
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		if v, ok := chosen[id]; ok {
+			if _, shown := c["thumbnail_url"]; shown {
+				c["thumbnail_variant"] = v
+			}
+		}
+	}
+}
+
+// convergeThumbnail makes the variant with the best lower bound the clip's
+// default thumbnail once the variants have enough impressions between them.
+func (h *Handler) convergeThumbnail(ctx context.Context, clipID string) {
+	variants, err := h.loadThumbnailVariants(ctx, clipID)
+	if err != nil || len(variants) < 2 {
+		return
+	}
+	total := 0
+	best := variants[0]
+	for _, v := range variants {
+		total += v.Impressions
+		if v.lowerBound() > best.lowerBound() {
+			best = v
+		}
+	}
+	if total < thumbnailConvergeImpressions {
+		return
+	}
+	h.DB.ExecContext(ctx,
+		`UPDATE clips SET thumbnail_key = ? WHERE id = ? AND COALESCE(thumbnail_key, '') <> ?`, best.Key, clipID, best.Key)
+}
+
+func (h *Handler) loadThumbnailVariants(ctx context.Context, clipID string) ([]thumbnailVariant, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT variant, thumbnail_key, impressions, clicks
+		FROM clip_thumbnails WHERE clip_id = ? ORDER BY variant
+	`, clipID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var variants []thumbnailVariant
+	for rows.Next() {
+		var v thumbnailVariant
+		if err := rows.Scan(&v.Variant, &v.Key, &v.Impressions, &v.Clicks); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+// HandleThumbnailClick credits a click to the thumbnail variant the user
+// was shown (the thumbnail_variant field of a feed clip). Each user's click
+// on a variant counts once, and only for a variant they were shown.
+func (h *Handler) HandleThumbnailClick(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		Variant *int `json:"variant"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || req.Variant == nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "variant is required"})
+		return
+	}
+	var clickedAt *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT clicked_at FROM thumbnail_views WHERE user_id = ? AND clip_id = ? AND variant = ?`,
+		userID, clipID, *req.Variant).Scan(&clickedAt); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "thumbnail variant not shown to you"})
+		return
+	}
+	if clickedAt != nil {
+		httputil.WriteJSON(w, 200, map[string]string{"status": "recorded"})
+		return
+	}
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		res, err := conn.ExecContext(r.Context(), fmt.Sprintf(
+			`UPDATE thumbnail_views SET clicked_at = %s WHERE user_id = ? AND clip_id = ? AND variant = ? AND clicked_at IS NULL`,
+			h.DB.NowUTC()), userID, clipID, *req.Variant)
+		if err != nil {
+			return err
+		}
+		// A concurrent click already counted.
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		_, err = conn.ExecContext(r.Context(),
+			`UPDATE clip_thumbnails SET clicks = clicks + 1 WHERE clip_id = ? AND variant = ?`, clipID, *req.Variant)
+		return err
+	})
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to record click"})
+		return
+	}
+	h.convergeThumbnail(r.Context(), clipID)
+	httputil.WriteJSON(w, 200, map[string]string{"status": "recorded"})
+}
+
+// HandleThumbnailStats lists clips with several thumbnail variants, most
+// shown first, with each variant's impressions, clicks, and click-through.
+func (h *Handler) HandleThumbnailStats(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT t.clip_id, COALESCE(c.title, ''), COALESCE(c.thumbnail_key, ''), SUM(t.impressions), SUM(t.clicks)
+		FROM clip_thumbnails t
+		JOIN clips c ON c.id = t.clip_id
+		GROUP BY t.clip_id, c.title, c.thumbnail_key
+		HAVING COUNT(*) > 1
+		ORDER BY SUM(t.impressions) DESC, t.clip_id
+		LIMIT ?
+	`, thumbnailStatsLimit)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load thumbnail stats"})
+		return
+	}
+	clips := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, title, currentKey string
+		var impressions, clicks int
+		if rows.Scan(&id, &title, &currentKey, &impressions, &clicks) == nil {
+			clips = append(clips, map[string]interface{}{
+				"id": id, "title": title, "thumbnail_key": currentKey,
+				"impressions": impressions, "clicks": clicks,
+			})
+		}
+	}
+	rows.Close()
+
+	for _, c := range clips {
+		variants, err := h.loadThumbnailVariants(r.Context(), c["id"].(string))
+		if err != nil {
+			continue
+		}
+		out := make([]map[string]interface{}, 0, len(variants))
+		for _, v := range variants {
+			ctr := 0.0
+			if v.Impressions > 0 {
+				ctr = float64(v.Clicks) / float64(v.Impressions)
+			}
+			out = append(out, map[string]interface{}{
+				"variant": v.Variant, "thumbnail_key": v.Key,
+				"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, v.Key),
+				"impressions":   v.Impressions, "clicks": v.Clicks,
+				"ctr": ctr, "expected_ctr": v.mean(),
+				"current": v.Key == c["thumbnail_key"],
+			})
+		}
+		c["variants"] = out
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"converge_after_impressions": thumbnailConvergeImpressions,
+		"clips":                      clips,
+	})
+}
@@ -0,0 +1,309 @@
+package feed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/transcripts"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// topicSuggestionEmbeddingWeight and topicSuggestionKeywordWeight blend a
+	// topic's embedding similarity to the clip with how much of its name
+	// appears in the clip's title, description, and transcript.
+	topicSuggestionEmbeddingWeight = 0.7
+	topicSuggestionKeywordWeight   = 0.3
+	maxTopicSuggestionIDs          = 50
+)
+
+// clipTopic is a topic currently attached to a clip.
+type clipTopic struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source"`
+}
+
+// textWords splits text into the set of its lowercase words, adding each
+// word's topic stem so "cats" in a transcript matches the topic "cat".
+func textWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[w] = true
+		words[normalizeTopicStem(w)] = true
+	}
+	return words
+}
+
+// keywordMatch is the fraction of a topic name's words found in the clip
+// text, or 1 when the whole name appears as a phrase.
+func keywordMatch(name, text string, words map[string]bool) float64 {
+	nameWords := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(nameWords) == 0 {
+		return 0
+	}
+	if len(nameWords) > 1 && strings.Contains(text, strings.Join(nameWords, " ")) {
+		return 1
+	}
+	found := 0
+	for _, w := range nameWords {
+		if words[w] || words[normalizeTopicStem(w)] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(nameWords))
+}
+
+// topicCentroids sums the text embeddings of every other clip tagged with
+// each topic. Only the direction matters for cosine similarity, so the sums
+// stand in for the mean embeddings.
+func (h *Handler) topicCentroids(ctx context.Context, clipID string, dims int) (map[string][]float32, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT ct.topic_id, e.text_embedding
+		FROM clip_topics ct
+		JOIN clip_embeddings e ON e.clip_id = ct.clip_id
+		WHERE ct.clip_id <> ? AND e.text_embedding IS NOT NULL
+	`, clipID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	centroids := make(map[string][]float32)
+	for rows.Next() {
+		var topicID string
+		var blob []byte
+		if rows.Scan(&topicID, &blob) != nil {
+			continue
+		}
+		vec := BlobToFloat32(blob)
+		if len(vec) != dims {
+			continue
+		}
+		var norm float64
+		for _, v := range vec {
+			norm += float64(v) * float64(v)
+		}
+		if norm == 0 {
+			continue
+		}
+		scale := float32(1 / math.Sqrt(norm))
+		sum, ok := centroids[topicID]
+		if !ok {
+			sum = make([]float32, dims)
+			centroids[topicID] = sum
+		}
+		for i, v := range vec {
+			sum[i] += v * scale
+		}
+	}
+	return centroids, rows.Err()
+}
+
+func (h *Handler) loadClipTopics(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}, clipID string) ([]clipTopic, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT t.id, t.name, ct.confidence, ct.source
+		FROM clip_topics ct
+		JOIN topics t ON t.id = ct.topic_id
+		WHERE ct.clip_id = ?
+		ORDER BY ct.confidence DESC, t.name
+	`, clipID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	topics := make([]clipTopic, 0)
+	for rows.Next() {
+		var t clipTopic
+		if err := rows.Scan(&t.ID, &t.Name, &t.Confidence, &t.Source); err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+	return topics, rows.Err()
+}
+
+// HandleTopicSuggestions ranks topics from the graph that the clip is not
+// tagged with by embedding similarity to the clips already carrying them
+// and by keyword match against the clip's title, description, and
+// transcript.
+func (h *Handler) HandleTopicSuggestions(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	limit := 10
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 50 {
+		limit = n
+	}
+
+	var title, description string
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT COALESCE(title, ''), COALESCE(description, '')
+		FROM clips WHERE id = ?
+	`, clipID).Scan(&title, &description); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	transcript, err := transcripts.Load(r.Context(), h.DB, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load transcript"})
+		return
+	}
+	current, err := h.loadClipTopics(r.Context(), h.DB, clipID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load clip topics"})
+		return
+	}
+	tagged := make(map[string]bool, len(current))
+	for _, t := range current {
+		tagged[t.ID] = true
+	}
+
+	var blob []byte
+	h.DB.QueryRowContext(r.Context(), `SELECT text_embedding FROM clip_embeddings WHERE clip_id = ?`, clipID).Scan(&blob)
+	clipVec := BlobToFloat32(blob)
+	var centroids map[string][]float32
+	if clipVec != nil {
+		if centroids, err = h.topicCentroids(r.Context(), clipID, len(clipVec)); err != nil {
+			log.Printf("topic suggestions %s: %v", clipID, err)
+		}
+	}
+
+	g := h.GetTopicGraph()
+	if g == nil {
+		g = h.LoadTopicGraph()
+	}
+	text := strings.Join(strings.Fields(strings.ToLower(title+" "+description+" "+transcript)), " ")
+	words := textWords(text)
+
+	suggestions := make([]map[string]interface{}, 0)
+	for id, node := range g.Nodes {
+		if tagged[id] {
+			continue
+		}
+		if canonical, ok := g.Canonical[id]; ok && canonical != id {
+			continue
+		}
+		similarity := 0.0
+		if c, ok := centroids[id]; ok {
+			similarity = math.Max(0, CosineSimilarity(clipVec, c))
+		}
+		keyword := keywordMatch(node.Name, text, words)
+		score := topicSuggestionEmbeddingWeight*similarity + topicSuggestionKeywordWeight*keyword
+		if score <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, map[string]interface{}{
+			"id": id, "name": node.Name, "slug": node.Slug, "path": node.Path,
+			"clip_count":    node.ClipCount,
+			"score":         math.Round(score*1000) / 1000,
+			"similarity":    math.Round(similarity*1000) / 1000,
+			"keyword_match": math.Round(keyword*1000) / 1000,
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		si, sj := suggestions[i]["score"].(float64), suggestions[j]["score"].(float64)
+		if si != sj {
+			return si > sj
+		}
+		return suggestions[i]["name"].(string) < suggestions[j]["name"].(string)
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"clip_id":     clipID,
+		"topics":      current,
+		"suggestions": suggestions,
+	})
+}
+
+// HandleAcceptTopicSuggestions tags the clip with topic_ids and untags
+// remove_topic_ids in one transaction. Accepted topics are recorded with
+// source "curator" and full confidence, and the clip's topics column is
+// rebuilt to match.
+func (h *Handler) HandleAcceptTopicSuggestions(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		TopicIDs       []string `json:"topic_ids"`
+		RemoveTopicIDs []string `json:"remove_topic_ids"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if len(req.TopicIDs) == 0 && len(req.RemoveTopicIDs) == 0 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "topic_ids or remove_topic_ids required"})
+		return
+	}
+	if len(req.TopicIDs)+len(req.RemoveTopicIDs) > maxTopicSuggestionIDs {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d topics per request", maxTopicSuggestionIDs)})
+		return
+	}
+
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	for _, id := range req.TopicIDs {
+		if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM topics WHERE id = ?`, id).Scan(&exists); err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "unknown topic: " + id})
+			return
+		}
+	}
+
+	var topics []clipTopic
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		for _, id := range req.RemoveTopicIDs {
+			if _, err := conn.ExecContext(r.Context(),
+				`DELETE FROM clip_topics WHERE clip_id = ? AND topic_id = ?`, clipID, id); err != nil {
+				return fmt.Errorf("remove topic: %w", err)
+			}
+		}
+		for _, id := range req.TopicIDs {
+			if _, err := conn.ExecContext(r.Context(), `
+				INSERT INTO clip_topics (clip_id, topic_id, confidence, source) VALUES (?, ?, 1.0, 'curator')
+				ON CONFLICT (clip_id, topic_id) DO UPDATE SET confidence = 1.0, source = 'curator'
+			`, clipID, id); err != nil {
+				return fmt.Errorf("add topic: %w", err)
+			}
+		}
+		var err error
+		if topics, err = h.loadClipTopics(r.Context(), conn, clipID); err != nil {
+			return fmt.Errorf("load topics: %w", err)
+		}
+		names := make([]string, len(topics))
+		for i, t := range topics {
+			names[i] = t.Name
+		}
+		namesJSON, _ := json.Marshal(names)
+		if _, err := conn.ExecContext(r.Context(),
+			`UPDATE clips SET topics = ? WHERE id = ?`, string(namesJSON), clipID); err != nil {
+			return fmt.Errorf("update clip topics: %w", err)
+		}
+		return nil
+	}); err != nil {
+		log.Printf("accept topic suggestions %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update clip topics"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"status": "updated", "topics": topics})
+}
@@ -56,6 +56,8 @@ type TopicGraph struct {
 	Children  map[string][]string
 	Edges     map[string][]TopicEdge
 	Canonical map[string]string // topic_id → canonical topic_id for consolidated topics
+	EdgeCount int
+	LoadedAt  time.Time
 }
 
 // ResolveByName finds a topic node by its lowercase name.
@@ -194,6 +196,7 @@ func (h *Handler) LoadTopicGraph() *TopicGraph {
 		Children:  make(map[string][]string),
 		Edges:     make(map[string][]TopicEdge),
 		Canonical: make(map[string]string),
+		LoadedAt:  time.Now().UTC(),
 	}
 
 	rows, err := h.DB.Query("SELECT id, name, slug, path, parent_id, depth, clip_count FROM topics")
@@ -248,6 +251,7 @@ func (h *Handler) LoadTopicGraph() *TopicGraph {
 		log.Printf("topic graph edge iteration error: %v", err)
 	}
 
+	g.EdgeCount = edgeCount
 	log.Printf("Topic graph loaded: %d nodes, %d edges", len(g.Nodes), edgeCount)
 
 	// Topic consolidation
@@ -329,6 +333,10 @@ func (h *Handler) applyTopicBoost(ctx context.Context, clips []map[string]interf
 				rows.Close()
 			}
 		}
+		// A preview ranks hypothetical affinities in place of stored ones.
+		for tid, w := range affinityOverrides(ctx) {
+			userAffinities[tid] = w
+		}
 		if len(g.Canonical) > 0 {
 			for tid, w := range userAffinities {
 				if canonID, ok := g.Canonical[tid]; ok {
@@ -0,0 +1,314 @@
+package feed
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/datasaver"
+	"clipfeed/httputil"
+)
+
+const (
+	// TVFeedLimit is the number of clips in one TV feed page; TV apps
+	// show wide grids, so pages are longer than the phone feed's.
+	TVFeedLimit = 40
+	// tvStreamWarmup is how many clips at the top of each TV page carry a
+	// presigned stream URL, so the first ones play at once.
+	tvStreamWarmup = 3
+	// tvMaxPages caps the pages one TV session can hold.
+	tvMaxPages = 25
+	// tvSessionTTL is how long a TV session's page tokens stay valid.
+	tvSessionTTL = 6 * time.Hour
+	// tvSessionIDLen is the length of a session ID, in Crockford base32.
+	tvSessionIDLen = 8
+)
+
+// tvFields are the clip fields a TV page carries: a card without
+// description, tags, or topics.
+var tvFields = httputil.CardFields
+
+// errPageToken is returned for a page token that is malformed, expired,
+// another user's, or skips ahead of the pages served so far.
+var errPageToken = errors.New("invalid page token")
+
+// tvSession is a TV feed session: the clip IDs of each page served so far.
+type tvSession struct {
+	id    string
+	pages [][]string
+}
+
+// HandleTVFeed serves the feed to TV apps. It ranks like HandleFeed, under
+// the user's saved preferences, but shapes a lean page: card fields only,
+// larger thumbnails where the worker made them, and stream URLs for the
+// first clips. Pages come from a session; page takes the next_page_token
+// or prev_page_token of a previous response. A page that was served
+// already is served again as it was, less clips that have since gone, and
+// new pages never repeat a clip from the session.
+func (h *Handler) HandleTVFeed(w http.ResponseWriter, r *http.Request) {
+	var deadline time.Time
+	if h.RankBudget > 0 {
+		deadline = time.Now().Add(h.RankBudget)
+	}
+	userID, _ := auth.ExtractUserID(r)
+	ctx := r.Context()
+
+	if userID != "" {
+		now := time.Now()
+		pacing := LoadPacingPrefs(ctx, h.DB, userID)
+		if pause := pacingPause(pacing, loadPacingUsage(ctx, h.DB, userID, pacing, now), now); pause != nil {
+			httputil.WriteJSON(w, 200, map[string]interface{}{
+				"clips": make([]map[string]interface{}, 0), "count": 0, "pacing": pause,
+			})
+			return
+		}
+	}
+
+	session, pageNum, err := h.loadTVSession(ctx, r.URL.Query().Get("page"), userID)
+	if errors.Is(err, errPageToken) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "page token is invalid or has expired; start again without page"})
+		return
+	}
+	if err != nil {
+		log.Printf("tv feed session: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
+		return
+	}
+
+	var clips []map[string]interface{}
+	if pageNum <= len(session.pages) {
+		clips, err = h.clipsInOrder(ctx, userID, session.pages[pageNum-1])
+	} else {
+		clips, err = h.rankTVPage(ctx, session, userID, deadline)
+	}
+	if err != nil {
+		log.Printf("tv feed page %d of %s: %v", pageNum, session.id, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
+		return
+	}
+
+	h.shapeTVClips(ctx, clips)
+	warmup := tvStreamWarmup
+	if h.streamLimited(ctx, userID) {
+		warmup = 0
+	}
+	h.addStreamURLs(ctx, clips, warmup)
+
+	result := map[string]interface{}{
+		"clips": clips, "count": len(clips), "page": pageNum,
+		"next_page_token": nil, "prev_page_token": nil,
+	}
+	if pageNum < len(session.pages) || (len(clips) > 0 && pageNum < tvMaxPages) {
+		result["next_page_token"] = tvPageToken(session.id, pageNum+1)
+	}
+	if pageNum > 1 {
+		result["prev_page_token"] = tvPageToken(session.id, pageNum-1)
+	}
+	httputil.WriteJSON(w, 200, result)
+}
+
+// loadTVSession resolves a page token to its session and page number. An
+// empty token starts a new session at page 1.
+func (h *Handler) loadTVSession(ctx context.Context, token, userID string) (*tvSession, int, error) {
+	if token == "" {
+		id, err := newTVSessionID()
+		if err != nil {
+			return nil, 0, err
+		}
+		h.DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM tv_feed_sessions WHERE expires_at <= %s`, h.DB.NowUTC()))
+		expiresAt := time.Now().UTC().Add(tvSessionTTL).Format("2006-01-02T15:04:05Z")
+		if _, err := h.DB.ExecContext(ctx,
+			`INSERT INTO tv_feed_sessions (id, user_id, expires_at) VALUES (?, ?, ?)`, id, userID, expiresAt); err != nil {
+			return nil, 0, err
+		}
+		return &tvSession{id: id}, 1, nil
+	}
+
+	id, page, ok := parseTVPageToken(token)
+	if !ok {
+		return nil, 0, errPageToken
+	}
+	var pagesJSON string
+	err := h.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT pages FROM tv_feed_sessions WHERE id = ? AND user_id = ? AND expires_at > %s
+	`, h.DB.NowUTC()), id, userID).Scan(&pagesJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, errPageToken
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	s := &tvSession{id: id}
+	if err := json.Unmarshal([]byte(pagesJSON), &s.pages); err != nil {
+		return nil, 0, err
+	}
+	if page > len(s.pages)+1 || page > tvMaxPages {
+		return nil, 0, errPageToken
+	}
+	return s, page, nil
+}
+
+// rankTVPage ranks the session's next page, leaving out every clip it has
+// served, and records the page. When another request recorded the page
+// first, that one is served instead.
+func (h *Handler) rankTVPage(ctx context.Context, s *tvSession, userID string, deadline time.Time) ([]map[string]interface{}, error) {
+	served := make(map[string]bool)
+	for _, page := range s.pages {
+		for _, id := range page {
+			served[id] = true
+		}
+	}
+	topicWeights, dedupeSeen24h, feedPrefs := h.loadFeedPrefs(ctx, userID)
+	page, err := h.rankPage(ctx, userID, TVFeedLimit, topicWeights, dedupeSeen24h, feedPrefs, feedPrefs.Preset, nil, served, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(page.clips))
+	for _, c := range page.clips {
+		ids = append(ids, c["id"].(string))
+	}
+	oldJSON, _ := json.Marshal(s.pages)
+	if s.pages == nil {
+		oldJSON = []byte("[]")
+	}
+	newJSON, _ := json.Marshal(append(s.pages, ids))
+	res, err := h.DB.ExecContext(ctx,
+		`UPDATE tv_feed_sessions SET pages = ? WHERE id = ? AND pages = ?`, string(newJSON), s.id, string(oldJSON))
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var pagesJSON string
+		var pages [][]string
+		if err := h.DB.QueryRowContext(ctx, `SELECT pages FROM tv_feed_sessions WHERE id = ?`, s.id).Scan(&pagesJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(pagesJSON), &pages); err != nil {
+			return nil, err
+		}
+		if len(pages) <= len(s.pages) {
+			return nil, errors.New("session changed while ranking")
+		}
+		return h.clipsInOrder(ctx, userID, pages[len(s.pages)])
+	}
+	s.pages = append(s.pages, ids)
+	return page.clips, nil
+}
+
+// clipsInOrder loads clips by ID, in the order given, leaving out those no
+// longer ready, snoozed, or blocked. TV pages and playback queues serve
+// clips ranked earlier through it.
+func (h *Handler) clipsInOrder(ctx context.Context, userID string, ids []string) ([]map[string]interface{}, error) {
+	if len(ids) == 0 {
+		return make([]map[string]interface{}, 0), nil
+	}
+	args := make([]interface{}, len(ids))
+	order := make(map[string]int, len(ids))
+	for i, id := range ids {
+		args[i] = id
+		order[id] = i
+	}
+	where := []string{"c.status = 'ready'", "c.id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"}
+	clips, err := h.queryClipsWhere(ctx, where, args, userID, "c.id", len(ids), 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(clips, func(i, j int) bool {
+		return order[clips[i]["id"].(string)] < order[clips[j]["id"].(string)]
+	})
+	stripRankingFields(clips)
+	return h.dropBlocked(ctx, userID, clips), nil
+}
+
+// shapeTVClips is the TV feed's response shaper: it points thumbnail_url
+// at each clip's large thumbnail, falling back to its default one, and
+// trims clips to tvFields. Data saver mode keeps the default thumbnails.
+func (h *Handler) shapeTVClips(ctx context.Context, clips []map[string]interface{}) {
+	httputil.AddThumbnailURLs(clips, h.MinioBucket)
+	if !datasaver.Enabled(ctx, h.DB) {
+		h.useLargeThumbnails(ctx, clips)
+	}
+	httputil.SelectClipFields(clips, tvFields)
+}
+
+// useLargeThumbnails points thumbnail_url at each clip's large thumbnail,
+// for clips the worker made one for.
+func (h *Handler) useLargeThumbnails(ctx context.Context, clips []map[string]interface{}) {
+	args := make([]interface{}, 0, len(clips))
+	for _, c := range clips {
+		if id, ok := c["id"].(string); ok {
+			args = append(args, id)
+		}
+	}
+	if len(args) == 0 {
+		return
+	}
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id, large_thumbnail_key FROM clips
+		WHERE id IN (?`+strings.Repeat(", ?", len(args)-1)+`) AND COALESCE(large_thumbnail_key, '') <> ''
+	`, args...)
+	if err != nil {
+		log.Printf("useLargeThumbnails: %v", err)
+		return
+	}
+	large := make(map[string]string)
+	for rows.Next() {
+		var id, key string
+		if rows.Scan(&id, &key) == nil {
+			large[id] = key
+		}
+	}
+	rows.Close()
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		if key, ok := large[id]; ok {
+			c["thumbnail_url"] = httputil.ThumbnailURL(h.MinioBucket, key)
+		}
+	}
+}
+
+// crockford is the Crockford base32 alphabet, which leaves out I, L, O,
+// and U so tokens read back unambiguously off a TV screen.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func newTVSessionID() (string, error) {
+	b := make([]byte, tvSessionIDLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = crockford[b[i]&31]
+	}
+	return string(b), nil
+}
+
+// tvPageToken is the token of a session's page: the session ID, a dash,
+// and the page number, like "7K3M9QX2-2".
+func tvPageToken(sessionID string, page int) string {
+	return sessionID + "-" + strconv.Itoa(page)
+}
+
+// parseTVPageToken splits a page token into its session ID and page
+// number, accepting lower case.
+func parseTVPageToken(token string) (string, int, bool) {
+	id, pageStr, ok := strings.Cut(strings.ToUpper(strings.TrimSpace(token)), "-")
+	if !ok || len(id) != tvSessionIDLen || strings.Trim(id, crockford) != "" {
+		return "", 0, false
+	}
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		return "", 0, false
+	}
+	return id, page, true
+}
@@ -0,0 +1,110 @@
+package feed
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipfeed/datasaver"
+)
+
+const (
+	// defaultStreamWarmup and maxStreamWarmup bound how many clips at the
+	// top of a feed page get a presigned stream URL with include_stream.
+	defaultStreamWarmup = 3
+	maxStreamWarmup     = 5
+	// warmupStreamExpiry is kept short: the URLs are meant for the first
+	// clips a client plays, not for caching.
+	warmupStreamExpiry = 10 * time.Minute
+)
+
+// streamWarmupCount returns how many clips should carry a stream URL, or 0
+// unless the request sets include_stream=true. stream_count overrides the
+// default and is capped at maxStreamWarmup.
+func streamWarmupCount(r *http.Request) int {
+	q := r.URL.Query()
+	if v := q.Get("include_stream"); v != "true" && v != "1" {
+		return 0
+	}
+	n := defaultStreamWarmup
+	if v, err := strconv.Atoi(q.Get("stream_count")); err == nil && v >= 0 {
+		n = v
+	}
+	if n > maxStreamWarmup {
+		n = maxStreamWarmup
+	}
+	return n
+}
+
+// streamLimited reports whether userID has a concurrent stream limit. Their
+// feeds carry no stream URLs, so playback goes through the stream endpoint,
+// which enforces the limit.
+func (h *Handler) streamLimited(ctx context.Context, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	var limit *int
+	h.DB.QueryRowContext(ctx, `SELECT max_concurrent_streams FROM users WHERE id = ?`, userID).Scan(&limit)
+	return limit != nil
+}
+
+// addStreamURLs sets stream_url and stream_expires_at on the first n clips
+// so clients can start playback without a round trip per clip. Clips whose
+// URL cannot be presigned are left without one; clients fall back to the
+// stream endpoint. In data saver mode they point at low-bitrate renditions
+// where clips have one.
+func (h *Handler) addStreamURLs(ctx context.Context, clips []map[string]interface{}, n int) {
+	if h.PresignStream == nil || n <= 0 || len(clips) == 0 {
+		return
+	}
+	if n > len(clips) {
+		n = len(clips)
+	}
+	ids := make([]interface{}, 0, n)
+	for _, c := range clips[:n] {
+		if id, ok := c["id"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, storage_key, COALESCE(low_storage_key, '') FROM clips WHERE status = 'ready' AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		log.Printf("feed stream warmup: %v", err)
+		return
+	}
+	saver := datasaver.Enabled(ctx, h.DB)
+	keys := make(map[string]string, len(ids))
+	for rows.Next() {
+		var id, key, lowKey string
+		if rows.Scan(&id, &key, &lowKey) == nil && key != "" {
+			if saver && lowKey != "" {
+				key = lowKey
+			}
+			keys[id] = key
+		}
+	}
+	rows.Close()
+
+	expiresAt := time.Now().UTC().Add(warmupStreamExpiry).Format("2006-01-02T15:04:05Z")
+	for _, c := range clips[:n] {
+		id, _ := c["id"].(string)
+		key, ok := keys[id]
+		if !ok {
+			continue
+		}
+		url, err := h.PresignStream(ctx, key, warmupStreamExpiry)
+		if err != nil {
+			log.Printf("feed stream warmup %s: %v", id, err)
+			continue
+		}
+		c["stream_url"] = url
+		c["stream_expires_at"] = expiresAt
+	}
+}
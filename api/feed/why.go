@@ -0,0 +1,346 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// whySummaryReasons is how many reasons the one-line summary combines.
+	whySummaryReasons = 2
+	// similarChannelMinOverlap is the topic overlap (Jaccard) above which a
+	// channel counts as similar to one the user watches.
+	similarChannelMinOverlap = 0.25
+	// trendingMinInteractions is how many interactions in the trending
+	// window make a clip worth calling trending.
+	trendingMinInteractions = 3
+	popularContentScore     = 0.7
+)
+
+// whyReason is one ranking signal that put a clip in the user's feed,
+// phrased as a clause that completes "Because ...".
+type whyReason struct {
+	Kind   string `json:"kind"`
+	Text   string `json:"text"`
+	weight float64
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// whySummary joins the strongest reasons into one sentence.
+func whySummary(reasons []whyReason) string {
+	clauses := make([]string, 0, whySummaryReasons)
+	for _, r := range reasons {
+		if len(clauses) == whySummaryReasons {
+			break
+		}
+		clauses = append(clauses, r.Text)
+	}
+	return "Because " + strings.Join(clauses, " and ") + "."
+}
+
+type whyClip struct {
+	id, channel            string
+	contentScore, ageHours float64
+	topicIDs               []string
+	topicNames             map[string]string
+}
+
+// HandleWhyClip explains in plain words why a clip shows up in the user's
+// feed. The reasons are built from the same signals the ranker uses (topic
+// engagement and preferences, channel affinity, series progress, the
+// exploration bandit, trending, freshness) and phrased with fixed
+// templates, so it is cheap enough to call from a tap in the UI.
+func (h *Handler) HandleWhyClip(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.ExtractUserID(r)
+	ctx := r.Context()
+	clip := whyClip{id: chi.URLParam(r, "id"), topicNames: map[string]string{}}
+
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(s.channel_name, ''), COALESCE(c.content_score, 0), COALESCE(`+h.DB.AgeHoursExpr("c.created_at")+`, -1)
+		FROM clips c
+		LEFT JOIN sources s ON s.id = c.source_id
+		WHERE c.id = ?
+	`, clip.id).Scan(&clip.channel, &clip.contentScore, &clip.ageHours); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	if rows, err := h.DB.QueryContext(ctx, `
+		SELECT t.id, t.name FROM clip_topics ct JOIN topics t ON t.id = ct.topic_id
+		WHERE ct.clip_id = ? ORDER BY ct.confidence DESC
+	`, clip.id); err == nil {
+		for rows.Next() {
+			var id, name string
+			if rows.Scan(&id, &name) == nil {
+				clip.topicIDs = append(clip.topicIDs, id)
+				clip.topicNames[id] = name
+			}
+		}
+		rows.Close()
+	}
+
+	topicWeights, _, prefs := h.loadFeedPrefs(ctx, userID)
+	var reasons []whyReason
+	if userID != "" {
+		reasons = append(reasons, h.whySeries(ctx, userID, clip)...)
+		reasons = append(reasons, h.whyTopics(ctx, userID, clip, topicWeights)...)
+		reasons = append(reasons, h.whyChannel(ctx, userID, clip)...)
+		reasons = append(reasons, h.whyExploring(ctx, userID, clip)...)
+	}
+	if prefs.TrendingBoost {
+		reasons = append(reasons, h.whyTrending(ctx, clip)...)
+	}
+	if clip.ageHours >= 0 && clip.ageHours < 24 && prefs.FreshnessBias >= 0.5 {
+		reasons = append(reasons, whyReason{Kind: "fresh", Text: "it was added in the last day", weight: 0.5})
+	}
+	if len(reasons) == 0 {
+		if clip.contentScore >= popularContentScore {
+			reasons = append(reasons, whyReason{Kind: "popular", Text: "it's popular with other viewers", weight: 0.1})
+		} else {
+			reasons = append(reasons, whyReason{Kind: "discovery", Text: "we're mixing in clips you haven't seen yet", weight: 0.1})
+		}
+	}
+	sort.SliceStable(reasons, func(i, j int) bool { return reasons[i].weight > reasons[j].weight })
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"clip_id": clip.id,
+		"summary": whySummary(reasons),
+		"reasons": reasons,
+	})
+}
+
+// whySeries reports an earlier part of the clip's series the user watched.
+func (h *Handler) whySeries(ctx context.Context, userID string, clip whyClip) []whyReason {
+	var title string
+	var watchedPart int
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT se.title, MAX(prev.part_number)
+		FROM series_clips sc
+		JOIN series se ON se.id = sc.series_id
+		JOIN series_clips prev ON prev.series_id = sc.series_id AND prev.part_number < sc.part_number
+		JOIN interactions i ON i.clip_id = prev.clip_id AND i.user_id = ?
+		WHERE sc.clip_id = ? AND i.action IN ('view', 'watch_full', 'like', 'save', 'share')
+		GROUP BY se.title
+	`, userID, clip.id).Scan(&title, &watchedPart); err != nil {
+		return nil
+	}
+	return []whyReason{{
+		Kind:   "series",
+		Text:   fmt.Sprintf("you watched part %d of %q", watchedPart, title),
+		weight: 10,
+	}}
+}
+
+// whyTopics reports the clip's topics the user engaged with recently,
+// follows, or boosted in their preferences. Only the strongest is kept so
+// a clip with many topics does not crowd out the other reasons.
+func (h *Handler) whyTopics(ctx context.Context, userID string, clip whyClip, topicWeights map[string]float64) []whyReason {
+	if len(clip.topicIDs) == 0 {
+		return nil
+	}
+	ph := "?" + strings.Repeat(", ?", len(clip.topicIDs)-1)
+	args := []interface{}{userID, clip.id}
+	for _, id := range clip.topicIDs {
+		args = append(args, id)
+	}
+
+	var best whyReason
+	weekAgo := h.DB.DatetimeModifier("-7 days")
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT ct.topic_id,
+		       COUNT(DISTINCT CASE WHEN i.action IN ('like', 'save', 'share') AND i.created_at > `+weekAgo+` THEN i.clip_id END),
+		       COUNT(DISTINCT CASE WHEN i.action IN ('like', 'save', 'share') THEN i.clip_id END),
+		       COUNT(DISTINCT CASE WHEN i.action = 'watch_full' THEN i.clip_id END)
+		FROM interactions i
+		JOIN clip_topics ct ON ct.clip_id = i.clip_id
+		WHERE i.user_id = ? AND i.clip_id <> ? AND ct.topic_id IN (`+ph+`)
+		  AND i.created_at > `+h.DB.DatetimeModifier("-30 days")+`
+		GROUP BY ct.topic_id
+	`, args...)
+	if err != nil {
+		log.Printf("why %s: topic engagement: %v", clip.id, err)
+	} else {
+		for rows.Next() {
+			var topicID string
+			var likedWeek, likedMonth, watchedFull int
+			if rows.Scan(&topicID, &likedWeek, &likedMonth, &watchedFull) != nil {
+				continue
+			}
+			name := clip.topicNames[topicID]
+			var candidate whyReason
+			switch {
+			case likedWeek > 0:
+				candidate = whyReason{Kind: "liked_topic", weight: 4 + float64(likedWeek),
+					Text: fmt.Sprintf("you liked %s about %s last week", plural(likedWeek, "clip", "clips"), name)}
+			case likedMonth > 0:
+				candidate = whyReason{Kind: "liked_topic", weight: 3 + float64(likedMonth)/2,
+					Text: fmt.Sprintf("you liked %s about %s this month", plural(likedMonth, "clip", "clips"), name)}
+			case watchedFull > 0:
+				candidate = whyReason{Kind: "watched_topic", weight: 2 + float64(watchedFull)/2,
+					Text: fmt.Sprintf("you watched %s about %s all the way through", plural(watchedFull, "clip", "clips"), name)}
+			}
+			if candidate.weight > best.weight {
+				best = candidate
+			}
+		}
+		rows.Close()
+	}
+
+	if best.weight < 3 {
+		var followed string
+		h.DB.QueryRowContext(ctx, `
+			SELECT t.name FROM user_topic_affinities a JOIN topics t ON t.id = a.topic_id
+			WHERE a.user_id = ? AND a.topic_id IN (`+ph+`)
+			ORDER BY a.weight DESC LIMIT 1
+		`, append([]interface{}{userID}, args[2:]...)...).Scan(&followed)
+		if followed != "" {
+			best = whyReason{Kind: "followed_topic", Text: "you follow " + followed, weight: 3}
+		}
+	}
+	if best.weight < 2.5 {
+		for _, id := range clip.topicIDs {
+			if w := topicWeights[clip.topicNames[id]]; w > 1 {
+				best = whyReason{Kind: "boosted_topic", Text: "you boosted " + clip.topicNames[id] + " in your topic preferences", weight: 2.5}
+				break
+			}
+		}
+	}
+	if best.Kind == "" {
+		return nil
+	}
+	return []whyReason{best}
+}
+
+// whyChannel reports that the user watches the clip's channel or, failing
+// that, a channel covering the same topics.
+func (h *Handler) whyChannel(ctx context.Context, userID string, clip whyClip) []whyReason {
+	if clip.channel == "" {
+		return nil
+	}
+	monthAgo := h.DB.DatetimeModifier("-30 days")
+	var watched int
+	h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT i.clip_id)
+		FROM interactions i
+		JOIN clips c ON c.id = i.clip_id
+		JOIN sources s ON s.id = c.source_id
+		WHERE i.user_id = ? AND s.channel_name = ? AND i.clip_id <> ?
+		  AND i.action IN ('watch_full', 'like', 'save', 'share') AND i.created_at > `+monthAgo+`
+	`, userID, clip.channel, clip.id).Scan(&watched)
+	if watched > 0 {
+		return []whyReason{{
+			Kind:   "channel",
+			Text:   fmt.Sprintf("you enjoyed %s from %s", plural(watched, "clip", "clips"), clip.channel),
+			weight: 3 + float64(watched)/2,
+		}}
+	}
+
+	channelTopics := func(query string, args ...interface{}) map[string]map[string]bool {
+		out := make(map[string]map[string]bool)
+		rows, err := h.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return out
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var channel, topicID string
+			if rows.Scan(&channel, &topicID) != nil {
+				continue
+			}
+			if out[channel] == nil {
+				out[channel] = make(map[string]bool)
+			}
+			out[channel][topicID] = true
+		}
+		return out
+	}
+	mine := channelTopics(`
+		SELECT DISTINCT s.channel_name, ct.topic_id
+		FROM clips c
+		JOIN sources s ON s.id = c.source_id
+		JOIN clip_topics ct ON ct.clip_id = c.id
+		WHERE s.channel_name = ?
+	`, clip.channel)[clip.channel]
+	if len(mine) == 0 {
+		return nil
+	}
+	watchedChannels := channelTopics(`
+		SELECT DISTINCT s.channel_name, ct.topic_id
+		FROM interactions i
+		JOIN clips c ON c.id = i.clip_id
+		JOIN sources s ON s.id = c.source_id
+		JOIN clip_topics ct ON ct.clip_id = c.id
+		WHERE i.user_id = ? AND s.channel_name IS NOT NULL AND s.channel_name <> '' AND s.channel_name <> ?
+		  AND i.action IN ('watch_full', 'like', 'save', 'share') AND i.created_at > `+monthAgo+`
+	`, userID, clip.channel)
+
+	bestChannel, bestOverlap := "", 0.0
+	for channel, topics := range watchedChannels {
+		shared := 0
+		for t := range topics {
+			if mine[t] {
+				shared++
+			}
+		}
+		overlap := float64(shared) / float64(len(topics)+len(mine)-shared)
+		if overlap > bestOverlap || (overlap == bestOverlap && channel < bestChannel) {
+			bestChannel, bestOverlap = channel, overlap
+		}
+	}
+	if bestOverlap < similarChannelMinOverlap {
+		return nil
+	}
+	return []whyReason{{
+		Kind:   "similar_channel",
+		Text:   fmt.Sprintf("%s is similar to %s, which you watch", clip.channel, bestChannel),
+		weight: 1.5 + bestOverlap,
+	}}
+}
+
+// whyExploring reports that the exploration bandit picked the clip to test
+// one of its topics on the user.
+func (h *Handler) whyExploring(ctx context.Context, userID string, clip whyClip) []whyReason {
+	var topic string
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT t.name FROM bandit_impressions b JOIN topics t ON t.id = b.topic_id
+		WHERE b.user_id = ? AND b.clip_id = ? AND b.outcome IS NULL
+	`, userID, clip.id).Scan(&topic); err != nil {
+		return nil
+	}
+	return []whyReason{{
+		Kind:   "exploring",
+		Text:   fmt.Sprintf("we're trying out %s to see if it's for you", topic),
+		weight: 2,
+	}}
+}
+
+// whyTrending reports recent interaction velocity, the signal the trending
+// boost ranks on.
+func (h *Handler) whyTrending(ctx context.Context, clip whyClip) []whyReason {
+	var recent int
+	h.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM interactions WHERE clip_id = ? AND created_at > `+h.DB.DatetimeModifier("-6 hours"),
+		clip.id).Scan(&recent)
+	if recent < trendingMinInteractions {
+		return nil
+	}
+	return []whyReason{{
+		Kind:   "trending",
+		Text:   fmt.Sprintf("it's trending, with %s in the last 6 hours", plural(recent, "interaction", "interactions")),
+		weight: 1 + float64(recent)/100,
+	}}
+}
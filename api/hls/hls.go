@@ -0,0 +1,181 @@
+// Package hls checks and rewrites the HLS media playlists workers upload
+// with a clip's transcoded renditions, and writes the master playlist that
+// offers them to adaptive-bitrate players. Segment URIs in a media
+// playlist name a segment object by its full key or its file name, as in
+// "segment_000.ts".
+package hls
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxVariants caps how many bitrate rungs a clip can offer.
+	MaxVariants = 6
+	// MaxSegments caps how many segments one variant can have.
+	MaxSegments = 1000
+)
+
+// Variant is one bitrate rung of a clip's HLS renditions.
+type Variant struct {
+	Name      string
+	Bandwidth int
+	Width     int
+	Height    int
+	Codecs    string
+}
+
+var (
+	validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	// uriAttr is the URI attribute of a tag such as EXT-X-MAP.
+	uriAttr = regexp.MustCompile(`URI="([^"]*)"`)
+)
+
+// ValidName reports whether name can name a variant: up to 32 lowercase
+// letters, digits, dashes, and underscores, as in "720p".
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// segmentIndex maps the names a playlist may use for a segment, its full
+// key or its file name, to the key.
+func segmentIndex(segmentKeys []string) map[string]string {
+	index := make(map[string]string, len(segmentKeys)*2)
+	for _, key := range segmentKeys {
+		index[key] = key
+		index[path.Base(key)] = key
+	}
+	return index
+}
+
+// Validate checks that playlist is an HLS media playlist whose segments,
+// and initialization section if any, each name one of segmentKeys.
+func Validate(playlist string, segmentKeys []string) error {
+	if len(segmentKeys) == 0 || len(segmentKeys) > MaxSegments {
+		return fmt.Errorf("segment_keys must have 1 to %d entries", MaxSegments)
+	}
+	for _, key := range segmentKeys {
+		if strings.TrimSpace(key) == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "..") {
+			return errors.New("segment_keys must be relative storage keys")
+		}
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(playlist, "\ufeff"), "#EXTM3U") {
+		return errors.New("playlist must start with #EXTM3U")
+	}
+
+	index := segmentIndex(segmentKeys)
+	segments := 0
+	sc := bufio.NewScanner(strings.NewReader(playlist))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			return errors.New("playlist must be a media playlist, not a master playlist")
+		case strings.HasPrefix(line, "#EXT-X-KEY") && !strings.Contains(line, "METHOD=NONE"):
+			return errors.New("encrypted playlists are not supported")
+		case strings.HasPrefix(line, "#"):
+			if m := uriAttr.FindStringSubmatch(line); m != nil {
+				if _, known := index[m[1]]; !known {
+					return fmt.Errorf("unknown segment %q", m[1])
+				}
+			}
+		default:
+			if _, known := index[line]; !known {
+				return fmt.Errorf("segment %d: unknown segment %q", segments, line)
+			}
+			segments++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read playlist: %w", err)
+	}
+	if segments == 0 {
+		return errors.New("playlist has no segments")
+	}
+	return nil
+}
+
+// Rewrite replaces each segment name, and the URI of tags such as
+// EXT-X-MAP, with the URL urlFor returns for its key. urlFor is called once
+// per segment.
+func Rewrite(playlist string, segmentKeys []string, urlFor func(key string) (string, error)) (string, error) {
+	index := segmentIndex(segmentKeys)
+	urls := make(map[string]string, len(segmentKeys))
+	signed := func(name string) (string, bool, error) {
+		key, known := index[name]
+		if !known {
+			return "", false, nil
+		}
+		if u, done := urls[key]; done {
+			return u, true, nil
+		}
+		u, err := urlFor(key)
+		if err != nil {
+			return "", false, fmt.Errorf("segment %s: %w", key, err)
+		}
+		urls[key] = u
+		return u, true, nil
+	}
+
+	var out strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(playlist))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			if m := uriAttr.FindStringSubmatchIndex(line); m != nil {
+				u, ok, err := signed(line[m[2]:m[3]])
+				if err != nil {
+					return "", err
+				}
+				if ok {
+					line = line[:m[2]] + u + line[m[3]:]
+				}
+			}
+		default:
+			u, ok, err := signed(trimmed)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				line = u
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("read playlist: %w", err)
+	}
+	return out.String(), nil
+}
+
+// Master writes a master playlist offering variants, each at the URL
+// urlFor returns for it.
+func Master(variants []Variant, urlFor func(v Variant) (string, error)) (string, error) {
+	var out strings.Builder
+	out.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		u, err := urlFor(v)
+		if err != nil {
+			return "", fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+		fmt.Fprintf(&out, "#EXT-X-STREAM-INF:BANDWIDTH=%d", v.Bandwidth)
+		if v.Width > 0 && v.Height > 0 {
+			fmt.Fprintf(&out, ",RESOLUTION=%dx%d", v.Width, v.Height)
+		}
+		if v.Codecs != "" {
+			fmt.Fprintf(&out, ",CODECS=\"%s\"", v.Codecs)
+		}
+		fmt.Fprintf(&out, "\n%s\n", u)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,126 @@
+package httputil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Querier is the subset of *db.CompatDB used by the enrichment helpers.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// DeepLink returns a URL that opens the original video at startSeconds, and
+// whether the platform honours the timestamp. Platforms without timestamp
+// support get the plain source URL back.
+func DeepLink(platform, sourceURL string, startSeconds float64) (string, bool) {
+	u, err := url.Parse(sourceURL)
+	if err != nil || u.Host == "" {
+		return sourceURL, false
+	}
+	secs := int(startSeconds)
+	if secs < 0 {
+		secs = 0
+	}
+
+	switch platform {
+	case "youtube":
+		// Shorts and live permalinks ignore t=, so rewrite them to /watch.
+		for _, prefix := range []string{"/shorts/", "/live/"} {
+			if strings.HasPrefix(u.Path, prefix) {
+				id := strings.Trim(strings.TrimPrefix(u.Path, prefix), "/")
+				u = &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/watch", RawQuery: url.Values{"v": {id}}.Encode()}
+				break
+			}
+		}
+		q := u.Query()
+		q.Set("t", fmt.Sprintf("%ds", secs))
+		u.RawQuery = q.Encode()
+		return u.String(), true
+	case "vimeo":
+		u.Fragment = fmt.Sprintf("t=%ds", secs)
+		return u.String(), true
+	case "direct":
+		// W3C media fragment; honoured by browsers for raw media files.
+		u.Fragment = fmt.Sprintf("t=%d", secs)
+		return u.String(), true
+	default:
+		return sourceURL, false
+	}
+}
+
+// Attribution builds the per-clip attribution object pointing back to the
+// moment in the original video the clip was cut from.
+func Attribution(platform, sourceURL, channelName *string, startTime, endTime *float64) map[string]interface{} {
+	if sourceURL == nil || *sourceURL == "" {
+		return nil
+	}
+	p := ""
+	if platform != nil {
+		p = *platform
+	}
+	deepLink, supported := *sourceURL, false
+	if startTime != nil {
+		deepLink, supported = DeepLink(p, *sourceURL, *startTime)
+	}
+	return map[string]interface{}{
+		"platform":            platform,
+		"channel_name":        channelName,
+		"source_url":          *sourceURL,
+		"start_seconds":       startTime,
+		"end_seconds":         endTime,
+		"deep_link":           deepLink,
+		"timestamp_supported": supported,
+	}
+}
+
+// AddAttributions enriches clip maps (as produced by ScanClips) with an
+// attribution object, loading clip offsets in a single query.
+func AddAttributions(ctx context.Context, q Querier, clips []map[string]interface{}) {
+	if len(clips) == 0 {
+		return
+	}
+	ph := make([]string, 0, len(clips))
+	args := make([]interface{}, 0, len(clips))
+	for _, c := range clips {
+		if id, ok := c["id"].(string); ok {
+			ph = append(ph, "?")
+			args = append(args, id)
+		}
+	}
+	if len(args) == 0 {
+		return
+	}
+
+	type offsets struct{ start, end *float64 }
+	byID := make(map[string]offsets, len(args))
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, start_time, end_time FROM clips WHERE id IN (`+strings.Join(ph, ",")+`)`, args...)
+	if err != nil {
+		log.Printf("AddAttributions: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var o offsets
+		if rows.Scan(&id, &o.start, &o.end) == nil {
+			byID[id] = o
+		}
+	}
+
+	for _, c := range clips {
+		id, _ := c["id"].(string)
+		platform, _ := c["platform"].(*string)
+		sourceURL, _ := c["source_url"].(*string)
+		channelName, _ := c["channel_name"].(*string)
+		o := byID[id]
+		if a := Attribution(platform, sourceURL, channelName, o.start, o.end); a != nil {
+			c["attribution"] = a
+		}
+	}
+}
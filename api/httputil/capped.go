@@ -0,0 +1,30 @@
+package httputil
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTooLarge is returned by a CappedReader that has read past its cap.
+var ErrTooLarge = errors.New("body larger than allowed")
+
+type cappedReader struct {
+	r io.Reader
+	n int64
+}
+
+// CappedReader reads r but fails with ErrTooLarge once more than n bytes
+// have come from it, so a body without a declared length is rejected
+// rather than silently cut short.
+func CappedReader(r io.Reader, n int64) io.Reader {
+	return &cappedReader{r: r, n: n}
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	if c.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,17 @@
+package httputil
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCappedReader_FailsPastLimit(t *testing.T) {
+	if b, err := io.ReadAll(CappedReader(strings.NewReader("12345"), 5)); err != nil || string(b) != "12345" {
+		t.Errorf("read at limit = %q, %v", b, err)
+	}
+	if _, err := io.ReadAll(CappedReader(strings.NewReader("123456"), 5)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("read past limit err = %v, want ErrTooLarge", err)
+	}
+}
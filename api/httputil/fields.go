@@ -0,0 +1,71 @@
+package httputil
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CardFields are the clip fields a feed card needs to render and start
+// playback; lightweight=true responses carry only these.
+var CardFields = []string{"id", "title", "thumbnail_url", "duration_seconds", "duration_bucket", "channel_name", "platform", "stream_path"}
+
+// RequestedClipFields reads the fields and lightweight query parameters.
+// fields is a comma-separated list and takes precedence over lightweight.
+// It returns nil when the full clip payload should be sent.
+func RequestedClipFields(r *http.Request) []string {
+	if raw := r.URL.Query().Get("fields"); raw != "" {
+		var fields []string
+		for _, f := range strings.Split(raw, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		if len(fields) > 0 {
+			return fields
+		}
+	}
+	if v := r.URL.Query().Get("lightweight"); v == "true" || v == "1" {
+		return CardFields
+	}
+	return nil
+}
+
+// WantsField reports whether name is among the selected fields; a nil
+// selection wants everything. Handlers use it to skip enrichment queries
+// whose output would be dropped.
+func WantsField(fields []string, name string) bool {
+	if fields == nil {
+		return true
+	}
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectClipFields trims each clip to the selected fields, always keeping
+// id. stream_path, the endpoint that issues a playable URL, is derived on
+// request. Unknown field names are ignored. A nil selection is a no-op.
+func SelectClipFields(clips []map[string]interface{}, fields []string) {
+	if fields == nil {
+		return
+	}
+	keep := map[string]bool{"id": true}
+	for _, f := range fields {
+		keep[f] = true
+	}
+	for _, clip := range clips {
+		if keep["stream_path"] {
+			if id, ok := clip["id"].(string); ok {
+				clip["stream_path"] = "/api/clips/" + id + "/stream"
+			}
+		}
+		for k := range clip {
+			if !keep[k] {
+				delete(clip, k)
+			}
+		}
+	}
+}
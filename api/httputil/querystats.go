@@ -0,0 +1,67 @@
+package httputil
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"clipfeed/db"
+)
+
+// QueryStats counts the database queries each request runs. A request that
+// runs more than budget queries is logged (budget 0 disables this). With
+// headers set, responses report the count and total query time so far in
+// X-DB-Queries and X-DB-Time (milliseconds), to help track down N+1
+// patterns.
+func QueryStats(budget int, headers bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, stats := db.WithQueryStats(r.Context(), r.Method+" "+r.URL.Path)
+			if headers {
+				w = &queryStatsWriter{ResponseWriter: w, stats: stats}
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+			if n := stats.Count(); budget > 0 && n > budget {
+				log.Printf("query budget exceeded: %s ran %d queries (budget %d) in %s",
+					stats.Route, n, budget, stats.Duration())
+			}
+		})
+	}
+}
+
+// queryStatsWriter adds the query headers just before the response starts.
+type queryStatsWriter struct {
+	http.ResponseWriter
+	stats   *db.QueryStats
+	started bool
+}
+
+func (w *queryStatsWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	h := w.ResponseWriter.Header()
+	h.Set("X-DB-Queries", strconv.Itoa(w.stats.Count()))
+	h.Set("X-DB-Time", strconv.FormatFloat(float64(w.stats.Duration().Microseconds())/1000, 'f', 2, 64))
+}
+
+func (w *queryStatsWriter) WriteHeader(code int) {
+	w.start()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *queryStatsWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *queryStatsWriter) Flush() {
+	w.start()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *queryStatsWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
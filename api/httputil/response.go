@@ -11,6 +11,25 @@ import (
 // DefaultBodyLimit is the default maximum request body size (1 MB).
 const DefaultBodyLimit int64 = 1 << 20
 
+// Upper bounds, in seconds, of the short and medium duration buckets.
+const (
+	ShortClipSeconds  = 30
+	MediumClipSeconds = 90
+)
+
+// DurationBucket classifies a clip length as "short", "medium", or "long",
+// so clients can label and group clips without their own thresholds.
+func DurationBucket(seconds float64) string {
+	switch {
+	case seconds < ShortClipSeconds:
+		return "short"
+	case seconds < MediumClipSeconds:
+		return "medium"
+	default:
+		return "long"
+	}
+}
+
 // ScanClips scans rows into a slice of clip maps with standard fields.
 func ScanClips(rows *sql.Rows) []map[string]interface{} {
 	clips := make([]map[string]interface{}, 0)
@@ -42,8 +61,8 @@ func ScanClips(rows *sql.Rows) []map[string]interface{} {
 
 		clips = append(clips, map[string]interface{}{
 			"id": id, "title": title, "description": description.String,
-			"duration_seconds": duration, "thumbnail_key": thumbnailKey.String,
-			"topics": topics, "tags": tags, "content_score": score,
+			"duration_seconds": duration, "duration_bucket": DurationBucket(duration),
+			"thumbnail_key": thumbnailKey.String, "topics": topics, "tags": tags, "content_score": score,
 			"created_at": createdAt, "channel_name": channelName,
 			"platform": platform, "source_url": sourceURL,
 			"_source_id":          sourceID,
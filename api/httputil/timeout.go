@@ -0,0 +1,110 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"time"
+)
+
+// RouteBudget is the time budget of requests whose path matches Pattern, a
+// path.Match pattern such as "/api/clips/*/summary". A zero Budget leaves
+// matching requests unbounded, for streams and bulk transfers.
+type RouteBudget struct {
+	Pattern string
+	Budget  time.Duration
+}
+
+// Timeout gives each request a deadline: the budget of the first route in
+// routes whose pattern matches its path, or def otherwise. Handlers see it
+// on r.Context(), so database queries and outbound calls made with that
+// context are cancelled once it passes. A request that fails after its
+// deadline passed is answered 504 with a structured error in place of the
+// handler's own error, as is one that returns without answering.
+//
+// Handlers that ignore their context run on past the deadline; the 504 is
+// sent when they return.
+func Timeout(def time.Duration, routes []RouteBudget) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			budget := def
+			for _, rb := range routes {
+				if ok, _ := path.Match(rb.Pattern, r.URL.Path); ok {
+					budget = rb.Budget
+					break
+				}
+			}
+			if budget <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), budget)
+			defer cancel()
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, budget: budget}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			if !tw.started && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.WriteHeader(http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+// writeTimeout answers a request that ran out of budget.
+func writeTimeout(w http.ResponseWriter, budget time.Duration) {
+	seconds := math.Round(budget.Seconds()*1000) / 1000
+	WriteJSON(w, http.StatusGatewayTimeout, map[string]interface{}{
+		"error":           fmt.Sprintf("the request took longer than its %s budget", budget),
+		"code":            "timeout",
+		"timeout_seconds": seconds,
+	})
+}
+
+// timeoutWriter swaps a server error written after the deadline passed for
+// the timeout response, and drops whatever the handler writes after it.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	budget   time.Duration
+	started  bool
+	timedOut bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.started {
+		return
+	}
+	w.started = true
+	if code >= 500 && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+		w.ResponseWriter.Header().Del("Content-Length")
+		writeTimeout(w.ResponseWriter, w.budget)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.timedOut {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Flush() {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.timedOut {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *timeoutWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,133 @@
+// Package images stores the images users supply, such as avatars and
+// collection covers, in object storage, and crops and scales them into the
+// JPEGs that are served.
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrTooLarge is returned by Decode for an image with too many pixels.
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// Store holds images by object key.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Remove(ctx context.Context, key string) error
+}
+
+// MinioStore is a Store over a MinIO bucket.
+type MinioStore struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// Put uploads data under key.
+func (s MinioStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Get opens the object at key.
+func (s MinioStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Remove deletes the object at key.
+func (s MinioStore) Remove(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+// Decode decodes a JPEG, PNG, or GIF image of at most maxPixels, checking
+// its dimensions before decoding it.
+func Decode(data []byte, maxPixels int) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Fill draws the central part of src with r's aspect ratio into r of dst,
+// scaled to fit.
+func Fill(dst *image.RGBA, r image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	// Crop src to r's aspect ratio around its center.
+	cw, ch := b.Dx(), b.Dx()*r.Dy()/r.Dx()
+	if ch > b.Dy() {
+		cw, ch = b.Dy()*r.Dx()/r.Dy(), b.Dy()
+	}
+	if cw < 1 || ch < 1 {
+		return
+	}
+	crop := image.Rect(0, 0, cw, ch).Add(image.Pt(b.Min.X+(b.Dx()-cw)/2, b.Min.Y+(b.Dy()-ch)/2))
+
+	// Each output pixel averages a grid of at most 8x8 samples from the
+	// source area it covers.
+	sx := float64(cw) / float64(r.Dx())
+	sy := float64(ch) / float64(r.Dy())
+	samples := int(max(sx, sy) + 0.999)
+	if samples > 8 {
+		samples = 8
+	}
+	if samples < 1 {
+		samples = 1
+	}
+	n := uint32(samples * samples)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			var red, green, blue, alpha uint32
+			for j := 0; j < samples; j++ {
+				for i := 0; i < samples; i++ {
+					px := crop.Min.X + int((float64(x)+(float64(i)+0.5)/float64(samples))*sx)
+					py := crop.Min.Y + int((float64(y)+(float64(j)+0.5)/float64(samples))*sy)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					red, green, blue, alpha = red+cr, green+cg, blue+cb, alpha+ca
+				}
+			}
+			// Transparent areas are flattened onto white, since JPEG has no
+			// alpha channel.
+			a := alpha / n
+			dst.Set(r.Min.X+x, r.Min.Y+y, color.RGBA{
+				R: uint8((red/n + 0xffff - a) >> 8),
+				G: uint8((green/n + 0xffff - a) >> 8),
+				B: uint8((blue/n + 0xffff - a) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+}
+
+// EncodeJPEG encodes img as the JPEG that is stored and served.
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("encode jpeg: %w", err)
+	}
+	return out.Bytes(), nil
+}
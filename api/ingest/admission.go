@@ -0,0 +1,200 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"clipfeed/httputil"
+)
+
+// Ingest priorities a submission may ask for.
+const (
+	PriorityNormal = "normal"
+	// PriorityBulk is for batch submissions that can wait: their downloads
+	// run after normal ones and are refused while the backlog is critical.
+	PriorityBulk = "bulk"
+)
+
+// Priorities lists the accepted ingest priorities.
+var Priorities = []string{PriorityNormal, PriorityBulk}
+
+// jobPriorities maps ingest priorities to the priority of the queued
+// download job; workers claim higher priorities first.
+var jobPriorities = map[string]int{PriorityNormal: 5, PriorityBulk: 1}
+
+const timeLayout = "2006-01-02T15:04:05Z"
+
+// JobTypeDepth is the queue depth and recent throughput of one job type.
+type JobTypeDepth struct {
+	JobType             string `json:"job_type"`
+	Queued              int64  `json:"queued"`
+	Running             int64  `json:"running"`
+	OldestQueuedSeconds *int64 `json:"oldest_queued_seconds"`
+	FinishedLastHour    int64  `json:"finished_last_hour"`
+	// EstimatedDrainSeconds is how long the queued jobs take at the recent
+	// rate; nil when none finished in the last day.
+	EstimatedDrainSeconds *int64 `json:"estimated_drain_seconds"`
+
+	perHour float64
+}
+
+// queueDepths returns the depth of every job type with queued, running, or
+// recently finished jobs.
+func (h *Handler) queueDepths(ctx context.Context) ([]JobTypeDepth, error) {
+	now := time.Now().UTC()
+	hourAgo := now.Add(-time.Hour).Format(timeLayout)
+	dayAgo := now.Add(-24 * time.Hour).Format(timeLayout)
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT job_type,
+		       COALESCE(SUM(CASE WHEN status = 'queued' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN status = 'running' THEN 1 ELSE 0 END), 0),
+		       MIN(CASE WHEN status = 'queued' THEN created_at END),
+		       COALESCE(SUM(CASE WHEN status IN ('complete', 'failed', 'rejected') AND completed_at > ? THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN status IN ('complete', 'failed', 'rejected') AND completed_at > ? THEN 1 ELSE 0 END), 0)
+		FROM jobs
+		WHERE status IN ('queued', 'running') OR completed_at > ?
+		GROUP BY job_type
+		ORDER BY job_type
+	`, hourAgo, dayAgo, dayAgo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	depths := []JobTypeDepth{}
+	for rows.Next() {
+		var d JobTypeDepth
+		var oldest *string
+		var finishedDay int64
+		if err := rows.Scan(&d.JobType, &d.Queued, &d.Running, &oldest, &d.FinishedLastHour, &finishedDay); err != nil {
+			return nil, err
+		}
+		if oldest != nil {
+			if t, err := time.Parse(timeLayout, *oldest); err == nil {
+				age := int64(now.Sub(t).Seconds())
+				d.OldestQueuedSeconds = &age
+			}
+		}
+		// The last hour reflects the current worker pool best; a quiet hour
+		// falls back to the day's average.
+		d.perHour = float64(d.FinishedLastHour)
+		if d.perHour == 0 {
+			d.perHour = float64(finishedDay) / 24
+		}
+		d.EstimatedDrainSeconds = estimateSeconds(d.Queued, d.perHour)
+		depths = append(depths, d)
+	}
+	return depths, rows.Err()
+}
+
+// estimateSeconds is how long n jobs take at perHour jobs an hour, or nil
+// when the rate is unknown.
+func estimateSeconds(n int64, perHour float64) *int64 {
+	var s int64
+	if n > 0 {
+		if perHour <= 0 {
+			return nil
+		}
+		s = int64(math.Ceil(float64(n) / perHour * 3600))
+	}
+	return &s
+}
+
+// admission describes the download queue a new ingest joins.
+type admission struct {
+	// Queued is the number of queued download jobs.
+	Queued int64
+	// Ahead is the number of queued downloads that run before a new one of
+	// the given priority.
+	Ahead int64
+	// Wait estimates how long until the new download starts.
+	Wait     *int64
+	Critical bool
+	perHour  float64
+}
+
+// admit measures the download queue for a new ingest whose job has
+// jobPriority.
+func (h *Handler) admit(ctx context.Context, jobPriority int) (admission, error) {
+	var a admission
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN priority >= ? THEN 1 ELSE 0 END), 0)
+		FROM jobs WHERE job_type = 'download' AND status = 'queued'
+	`, jobPriority).Scan(&a.Queued, &a.Ahead); err != nil {
+		return a, err
+	}
+	depths, err := h.queueDepths(ctx)
+	if err != nil {
+		return a, err
+	}
+	for _, d := range depths {
+		if d.JobType == "download" {
+			a.perHour = d.perHour
+		}
+	}
+	a.Wait = estimateSeconds(a.Ahead, a.perHour)
+	a.Critical = h.BacklogCritical > 0 && a.Queued >= int64(h.BacklogCritical)
+	return a, nil
+}
+
+// backlogLevel names how backed up the download queue is.
+func (a admission) backlogLevel() string {
+	if a.Critical {
+		return "critical"
+	}
+	return "ok"
+}
+
+// refuseBulk answers a bulk ingest turned away by a critical backlog. It
+// asks the client to retry once the queue should have dropped below the
+// threshold.
+func (h *Handler) refuseBulk(w http.ResponseWriter, a admission) {
+	retry := int64(300)
+	if wait := estimateSeconds(a.Queued-int64(h.BacklogCritical)+1, a.perHour); wait != nil {
+		retry = max(*wait, 60)
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+	httputil.WriteJSON(w, 503, map[string]interface{}{
+		"error":               fmt.Sprintf("the ingest queue is backlogged (%d queued); bulk submissions are paused, try again later", a.Queued),
+		"queued":              a.Queued,
+		"retry_after_seconds": retry,
+	})
+}
+
+// HandleQueue reports the job queue's depth per job type and how long a new
+// ingest would wait at each priority.
+func (h *Handler) HandleQueue(w http.ResponseWriter, r *http.Request) {
+	depths, err := h.queueDepths(r.Context())
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load queue"})
+		return
+	}
+	waits := map[string]*int64{}
+	var normal admission
+	for _, p := range Priorities {
+		a, err := h.admit(r.Context(), jobPriorities[p])
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load queue"})
+			return
+		}
+		waits[p] = a.Wait
+		if p == PriorityNormal {
+			normal = a
+		}
+	}
+	var criticalAt *int
+	if h.BacklogCritical > 0 {
+		criticalAt = &h.BacklogCritical
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"job_types": depths,
+		"backlog": map[string]interface{}{
+			"queued_downloads": normal.Queued, "critical_at": criticalAt, "level": normal.backlogLevel(),
+		},
+		"estimated_wait_seconds": waits,
+	})
+}
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"clipfeed/auth"
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/jobs"
 
 	"github.com/google/uuid"
 )
@@ -18,14 +20,34 @@ import (
 // Handler holds dependencies for the ingestion endpoints.
 type Handler struct {
 	DB *db.CompatDB
+	// BacklogCritical is the number of queued downloads at which bulk
+	// submissions are refused; 0 never refuses them.
+	BacklogCritical int
 }
 
 // IngestRequest is the body for URL submission.
 type IngestRequest struct {
 	URL string `json:"url"`
+	// Strategy picks how the source is cut into clips; empty uses the
+	// platform's instance default.
+	Strategy string `json:"strategy"`
+	// Consent records the submitter's rights to the content.
+	Consent IngestConsent `json:"consent"`
+	// Priority is "normal" (the default) or "bulk".
+	Priority string `json:"priority"`
 }
 
-// HandleIngest queues a URL for ingestion.
+// IngestConsent flags are stored on the source for licensing reports.
+type IngestConsent struct {
+	// IsUploader is set when the submitter uploaded the content.
+	IsUploader bool `json:"is_uploader"`
+	// HasPermission is set when the rights holder allowed the ingest.
+	HasPermission bool `json:"has_permission"`
+}
+
+// HandleIngest queues a URL for ingestion. The response estimates how long
+// the download waits in the queue; bulk submissions are refused while the
+// backlog is critical.
 func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	httputil.MaxBody(r, httputil.DefaultBodyLimit)
@@ -47,10 +69,70 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Strategy != "" && !jobs.ValidClipStrategy(req.Strategy) {
+		httputil.WriteJSON(w, 400, map[string]string{
+			"error": "strategy must be one of: " + strings.Join(jobs.ClipStrategies, ", "),
+		})
+		return
+	}
+
+	priority := req.Priority
+	if priority == "" {
+		priority = PriorityNormal
+	}
+	jobPriority, ok := jobPriorities[priority]
+	if !ok {
+		httputil.WriteJSON(w, 400, map[string]string{
+			"error": "priority must be one of: " + strings.Join(Priorities, ", "),
+		})
+		return
+	}
+
+	// Content removed after a takedown request stays out.
+	var takenDown int
+	if h.DB.QueryRowContext(r.Context(),
+		`SELECT 1 FROM takedown_requests WHERE source_url = ? AND status = 'actioned' LIMIT 1`, req.URL,
+	).Scan(&takenDown) == nil {
+		httputil.WriteJSON(w, 451, map[string]string{"error": "this URL was taken down at the rights holder's request"})
+		return
+	}
+
+	// Accounts admitted by an invite may carry a daily ingest quota.
+	var quota *int
+	h.DB.QueryRowContext(r.Context(), `SELECT daily_ingest_quota FROM users WHERE id = ?`, userID).Scan(&quota)
+	if quota != nil {
+		var today int
+		h.DB.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM sources WHERE submitted_by = ? AND created_at > ?`,
+			userID, time.Now().UTC().Add(-24*time.Hour).Format("2006-01-02T15:04:05Z")).Scan(&today)
+		if today >= *quota {
+			httputil.WriteJSON(w, 429, map[string]string{
+				"error": fmt.Sprintf("daily ingest quota of %d reached; try again later", *quota),
+			})
+			return
+		}
+	}
+
+	adm, err := h.admit(r.Context(), jobPriority)
+	if err != nil {
+		log.Printf("ingest admission: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to queue ingestion"})
+		return
+	}
+	if priority == PriorityBulk && adm.Critical {
+		h.refuseBulk(w, adm)
+		return
+	}
+
 	platform := DetectPlatform(req.URL)
+	strategy := jobs.ResolveClipStrategy(r.Context(), h.DB, platform, req.Strategy)
 	sourceID := uuid.New().String()
 	jobID := uuid.New().String()
-	payload := fmt.Sprintf(`{"url":%q,"source_id":%q,"platform":%q}`, req.URL, sourceID, platform)
+	payload, err := jobs.EncodePayload("download", &jobs.DownloadPayload{URL: req.URL, SourceID: sourceID, Platform: platform, Strategy: strategy})
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
 
 	// Check for existing source with the same URL
 	var existingSourceID, existingStatus string
@@ -64,13 +146,14 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 
 	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
 		if _, err := conn.ExecContext(r.Context(),
-			`INSERT INTO sources (id, url, platform, submitted_by, status) VALUES (?, ?, ?, ?, 'pending')`,
-			sourceID, req.URL, platform, userID); err != nil {
+			`INSERT INTO sources (id, url, platform, submitted_by, status, clip_strategy, consent_is_uploader, consent_has_permission)
+			 VALUES (?, ?, ?, ?, 'pending', ?, ?, ?)`,
+			sourceID, req.URL, platform, userID, strategy, boolInt(req.Consent.IsUploader), boolInt(req.Consent.HasPermission)); err != nil {
 			return fmt.Errorf("create source: %w", err)
 		}
 		if _, err := conn.ExecContext(r.Context(),
-			`INSERT INTO jobs (id, source_id, job_type, payload) VALUES (?, ?, 'download', ?)`,
-			jobID, sourceID, payload); err != nil {
+			`INSERT INTO jobs (id, source_id, job_type, priority, payload) VALUES (?, ?, 'download', ?, ?)`,
+			jobID, sourceID, jobPriority, jobs.WithTraceparent(r.Context(), payload)); err != nil {
 			return fmt.Errorf("queue job: %w", err)
 		}
 		return nil
@@ -84,6 +167,13 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 		"source_id": sourceID,
 		"job_id":    jobID,
 		"status":    "queued",
+		"strategy":  strategy,
+		"priority":  priority,
+		// Downloads queued ahead of this one, and how long until it starts
+		// at the recent rate (null while the rate is unknown).
+		"queue_position":         adm.Ahead + 1,
+		"estimated_wait_seconds": adm.Wait,
+		"backlog":                adm.backlogLevel(),
 	}
 	if warning != "" {
 		result["warning"] = warning
@@ -92,6 +182,10 @@ func (h *Handler) HandleIngest(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 202, result)
 }
 
+// SupportedPlatforms lists every platform DetectPlatform can return. URLs
+// from other hosts are ingested as "direct" downloads.
+var SupportedPlatforms = []string{"youtube", "vimeo", "tiktok", "instagram", "twitter", "direct"}
+
 // DetectPlatform identifies a video platform from its URL.
 func DetectPlatform(rawURL string) string {
 	parsed, err := url.Parse(rawURL)
@@ -114,3 +208,10 @@ func DetectPlatform(rawURL string) string {
 		return "direct"
 	}
 }
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
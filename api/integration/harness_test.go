@@ -0,0 +1,152 @@
+package integration
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/clips"
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/ingest"
+	"clipfeed/invites"
+	"clipfeed/notify"
+	"clipfeed/retention"
+	"clipfeed/worker"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/minio/minio-go/v7"
+
+	_ "modernc.org/sqlite"
+)
+
+const workerSecret = "integration-worker-secret"
+
+// env is a running API: the handlers main wires up, served over HTTP on a
+// shared in-memory database and object store.
+type env struct {
+	t      *testing.T
+	db     *db.CompatDB
+	store  *minio.Client
+	bucket string
+	url    string
+
+	retentionH *retention.Handler
+}
+
+// newEnv starts an API with a fresh database and bucket. Clips stream in
+// proxy mode, so media is read back through the API from the object store.
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	// Every connection to :memory: is a separate database.
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	compatDB := db.NewCompatDB(rawDB, db.DialectSQLite)
+	store, bucket := newObjectStore(t)
+
+	authH := &auth.Handler{DB: compatDB, JWTSecret: "integration-secret", RedeemInvite: invites.Consume}
+	feedH := &feed.Handler{DB: compatDB, MinioBucket: bucket}
+	clipsH := &clips.Handler{
+		DB: compatDB, Minio: store, MinioBucket: bucket,
+		StreamMode: clips.StreamModeProxy, StreamSecret: "integration-cookie-secret",
+	}
+	feedH.PresignStream = clipsH.PresignStreamURL
+	ingestH := &ingest.Handler{DB: compatDB}
+	workerH := &worker.Handler{
+		DB: compatDB, WorkerSecret: workerSecret, CookieSecret: "integration-cookie-secret",
+		Notifier: &notify.Handler{DB: compatDB},
+	}
+	e := &env{
+		t: t, db: compatDB, store: store, bucket: bucket,
+		retentionH: &retention.Handler{DB: compatDB, Retention: 24 * time.Hour},
+	}
+
+	r := chi.NewRouter()
+	r.Post("/api/auth/register", authH.HandleRegister)
+	r.Get("/api/feed", authH.OptionalAuth(feedH.HandleFeed))
+	r.Get("/api/clips/{id}", clipsH.HandleGetClip)
+	r.Get("/api/clips/{id}/stream", authH.OptionalAuth(clipsH.HandleStreamClip))
+	r.Get("/api/media", clipsH.HandleMedia)
+	r.Get("/api/search", authH.OptionalAuth(feedH.HandleSearch))
+	r.Group(func(r chi.Router) {
+		r.Use(authH.AuthMiddleware)
+		r.Post("/api/clips/{id}/interact", clipsH.HandleInteraction)
+		r.Post("/api/ingest", ingestH.HandleIngest)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(workerH.WorkerAuthMiddleware)
+		r.Post("/api/internal/jobs/claim", workerH.HandleClaimJob)
+		r.Put("/api/internal/jobs/{id}", workerH.HandleUpdateJob)
+		r.Put("/api/internal/sources/{id}", workerH.HandleUpdateSource)
+		r.Post("/api/internal/clips", workerH.HandleCreateClip)
+		r.Post("/api/internal/scores/update", workerH.HandleScoreUpdate)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	e.url = srv.URL
+	return e
+}
+
+// do sends a JSON request as the holder of token and decodes the JSON
+// reply, if any.
+func (e *env) do(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	e.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, e.url+path, r)
+	if err != nil {
+		e.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		e.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// register signs up username and returns their token.
+func (e *env) register(username string) string {
+	e.t.Helper()
+	code, resp := e.do("POST", "/api/auth/register", "", map[string]string{
+		"username": username, "email": username + "@example.com", "password": "integration-pw",
+	})
+	if code != 201 {
+		e.t.Fatalf("register %s: %d %v", username, code, resp)
+	}
+	return resp["token"].(string)
+}
+
+// get fetches path from the API and returns the status and body.
+func (e *env) get(path string) (int, []byte) {
+	e.t.Helper()
+	resp, err := http.Get(e.url + path)
+	if err != nil {
+		e.t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
@@ -0,0 +1,175 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"clipfeed/clips"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// purgeExpired expires clips past their expires_at the way lifecycle.py
+// does: each is marked expired and buried before its objects are removed.
+func purgeExpired(e *env) int {
+	e.t.Helper()
+	ctx := context.Background()
+	rows, err := e.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, storage_key, thumbnail_key FROM clips
+		WHERE expires_at < %s AND is_protected = 0 AND status = 'ready'
+	`, e.db.NowUTC()))
+	if err != nil {
+		e.t.Fatalf("find expired clips: %v", err)
+	}
+	var expired [][3]string
+	for rows.Next() {
+		var c [3]string
+		if err := rows.Scan(&c[0], &c[1], &c[2]); err != nil {
+			e.t.Fatalf("scan expired clip: %v", err)
+		}
+		expired = append(expired, c)
+	}
+	rows.Close()
+
+	for _, c := range expired {
+		if _, err := e.db.ExecContext(ctx, `UPDATE clips SET status = 'expired' WHERE id = ?`, c[0]); err != nil {
+			e.t.Fatalf("expire %s: %v", c[0], err)
+		}
+		if err := clips.Bury(ctx, e.db, "expired", "", c[0]); err != nil {
+			e.t.Fatalf("bury %s: %v", c[0], err)
+		}
+		for _, key := range c[1:] {
+			if err := e.store.RemoveObject(ctx, e.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+				e.t.Fatalf("remove %s: %v", key, err)
+			}
+		}
+	}
+	return len(expired)
+}
+
+// feedHas reports whether the feed served to token includes clipID.
+func feedHas(e *env, token, clipID string) bool {
+	e.t.Helper()
+	code, resp := e.do("GET", "/api/feed", token, nil)
+	if code != 200 {
+		e.t.Fatalf("feed: %d %v", code, resp)
+	}
+	items, _ := resp["clips"].([]interface{})
+	for _, item := range items {
+		if c, _ := item.(map[string]interface{}); c["id"] == clipID {
+			return true
+		}
+	}
+	return false
+}
+
+func contentScore(e *env, clipID string) float64 {
+	e.t.Helper()
+	var score float64
+	if err := e.db.QueryRow(`SELECT content_score FROM clips WHERE id = ?`, clipID).Scan(&score); err != nil {
+		e.t.Fatalf("load content score: %v", err)
+	}
+	return score
+}
+
+// TestClipLifecycle drives one clip through every module it passes
+// through: a user ingests a URL, the worker claims the download and
+// creates the clip, the feed serves it and its media streams from the
+// object store, interactions raise its score, and retention rolls up the
+// interactions and purges the clip once it expires.
+func TestClipLifecycle(t *testing.T) {
+	e := newEnv(t)
+	fw := &fakeWorker{e: e, ttl: time.Hour}
+	submitter := e.register("submitter")
+	viewer := e.register("viewer")
+
+	// Ingest queues a download the worker can claim.
+	code, resp := e.do("POST", "/api/ingest", submitter, map[string]string{"url": "https://example.com/videos/dovetails.mp4"})
+	if code != 202 {
+		t.Fatalf("ingest: %d %v", code, resp)
+	}
+	c := fw.runDownload("Hand-cut dovetails", "woodworking")
+	if jobID, _ := fw.claim("download"); jobID != "" {
+		t.Fatalf("download job %s claimable after completing", jobID)
+	}
+	var jobStatus, sourceStatus string
+	e.db.QueryRow(`SELECT status FROM jobs WHERE source_id = ?`, c.SourceID).Scan(&jobStatus)
+	e.db.QueryRow(`SELECT status FROM sources WHERE id = ?`, c.SourceID).Scan(&sourceStatus)
+	if jobStatus != "complete" || sourceStatus != "complete" {
+		t.Errorf("job %q, source %q after the worker finished, want complete", jobStatus, sourceStatus)
+	}
+
+	// The feed and search serve the clip, and its stream reads the
+	// uploaded media back from the object store.
+	if !feedHas(e, viewer, c.ID) {
+		t.Fatal("feed does not serve the new clip")
+	}
+	if code, resp := e.do("GET", "/api/search?q=dovetails", viewer, nil); code != 200 || resp["total"] == float64(0) {
+		t.Errorf("search for the clip: %d %v", code, resp)
+	}
+	code, resp = e.do("GET", "/api/clips/"+c.ID+"/stream", viewer, nil)
+	if code != 200 {
+		t.Fatalf("stream: %d %v", code, resp)
+	}
+	streamURL := resp["url"].(string)
+	if code, media := e.get(streamURL); code != 200 || !bytes.Equal(media, c.Media) {
+		t.Fatalf("media = %d %q, want the uploaded %q", code, media, c.Media)
+	}
+
+	// Interactions nudge the score as they land, and the score updater's
+	// recompute keeps an engaged clip above where it started.
+	before := contentScore(e, c.ID)
+	for _, action := range []string{"view", "like", "watch_full"} {
+		if code, resp := e.do("POST", "/api/clips/"+c.ID+"/interact", viewer, map[string]interface{}{
+			"action": action, "watch_duration_seconds": 30, "watch_percentage": 1,
+		}); code != 200 {
+			t.Fatalf("%s: %d %v", action, code, resp)
+		}
+	}
+	if after := contentScore(e, c.ID); after <= before {
+		t.Errorf("content score %v after a like and a full watch, want above %v", after, before)
+	}
+	fw.updateScores()
+	if after := contentScore(e, c.ID); after <= before {
+		t.Errorf("content score %v after recompute, want above %v", after, before)
+	}
+
+	// Retention folds interactions older than the window into rollups.
+	e.db.Exec(`UPDATE interactions SET created_at = ? WHERE clip_id = ?`,
+		time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02T15:04:05Z"), c.ID)
+	res, err := e.retentionH.Prune(context.Background())
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	var events int
+	e.db.QueryRow(`SELECT COALESCE(SUM(events), 0) FROM interaction_rollups WHERE clip_id = ?`, c.ID).Scan(&events)
+	if res.Interactions != 3 || events != 3 {
+		t.Errorf("pruned %d interactions into %d rolled-up events, want 3 and 3", res.Interactions, events)
+	}
+
+	// Once the clip expires, lifecycle purges it: it leaves the feed, its
+	// page and stream answer 410, and its objects are gone.
+	if n := purgeExpired(e); n != 0 {
+		t.Fatalf("purged %d clips before any expired", n)
+	}
+	e.db.Exec(`UPDATE clips SET expires_at = ? WHERE id = ?`,
+		time.Now().UTC().Add(-time.Minute).Format("2006-01-02T15:04:05Z"), c.ID)
+	if n := purgeExpired(e); n != 1 {
+		t.Fatalf("purged %d clips, want 1", n)
+	}
+	if feedHas(e, viewer, c.ID) {
+		t.Error("feed still serves the expired clip")
+	}
+	if code, resp := e.do("GET", "/api/clips/"+c.ID, viewer, nil); code != 410 || resp["reason"] != "expired" {
+		t.Errorf("expired clip = %d %v, want 410 expired", code, resp)
+	}
+	if code, _ := e.get(streamURL); code != 410 {
+		t.Errorf("stream URL issued before expiry = %d, want 410", code)
+	}
+	if _, err := e.store.StatObject(context.Background(), e.bucket, c.Key, minio.StatObjectOptions{}); err == nil {
+		t.Error("expired clip's media is still stored")
+	}
+}
@@ -0,0 +1,177 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// memObject is an object held by memS3.
+type memObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// memS3 is an in-memory stand-in for MinIO. It speaks enough of the S3 API
+// for minio-go's bucket checks and single-part object reads, writes, stats,
+// and deletes, which is all the API and worker use.
+type memS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]memObject
+}
+
+func newMemS3() *memS3 {
+	return &memS3{buckets: map[string]map[string]memObject{}}
+}
+
+func (s *memS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.buckets[bucket]
+	if key == "" {
+		switch {
+		case r.Method == http.MethodPut:
+			if !ok {
+				s.buckets[bucket] = map[string]memObject{}
+			}
+		case !ok:
+			s3Error(w, 404, "NoSuchBucket", r.URL.Path)
+		case r.Method == http.MethodGet && r.URL.Query().Has("location"):
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+		case r.Method != http.MethodHead:
+			s3Error(w, 501, "NotImplemented", r.URL.Path)
+		}
+		return
+	}
+	if !ok {
+		s3Error(w, 404, "NoSuchBucket", r.URL.Path)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readPayload(r)
+		if err != nil {
+			s3Error(w, 400, "IncompleteBody", r.URL.Path)
+			return
+		}
+		objects[key] = memObject{data: data, contentType: r.Header.Get("Content-Type"), modified: time.Now().UTC()}
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			s3Error(w, 404, "NoSuchKey", r.URL.Path)
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		http.ServeContent(w, r, key, obj.modified, bytes.NewReader(obj.data))
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(204)
+	default:
+		s3Error(w, 501, "NotImplemented", r.URL.Path)
+	}
+}
+
+// readPayload reads an upload's body, decoding the aws-chunked framing
+// minio-go uses for streaming signatures over plain HTTP.
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func s3Error(w http.ResponseWriter, status int, code, resource string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource></Error>`,
+		code, code, resource)
+}
+
+// newObjectStore returns a client for an empty bucket. It uses the MinIO at
+// INTEGRATION_MINIO_ENDPOINT when set, such as the docker compose one, and
+// an in-memory memS3 otherwise.
+func newObjectStore(t *testing.T) (*minio.Client, string) {
+	t.Helper()
+	endpoint := os.Getenv("INTEGRATION_MINIO_ENDPOINT")
+	access, secret := os.Getenv("INTEGRATION_MINIO_ACCESS_KEY"), os.Getenv("INTEGRATION_MINIO_SECRET_KEY")
+	external := endpoint != ""
+	if !external {
+		srv := httptest.NewServer(newMemS3())
+		t.Cleanup(srv.Close)
+		u, _ := url.Parse(srv.URL)
+		endpoint, access, secret = u.Host, "integration", "integration-secret"
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(access, secret, ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatalf("object store client: %v", err)
+	}
+
+	ctx := context.Background()
+	bucket := fmt.Sprintf("integration-%d", time.Now().UnixNano())
+	if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+		t.Fatalf("make bucket: %v", err)
+	}
+	if !external {
+		return client, bucket
+	}
+	t.Cleanup(func() {
+		for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+			client.RemoveObject(ctx, bucket, obj.Key, minio.RemoveObjectOptions{})
+		}
+		client.RemoveBucket(ctx, bucket)
+	})
+	return client, bucket
+}
@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// fakeWorker stands in for the Python ingestion worker: it claims jobs and
+// reports back over the internal API and uploads media to the object store
+// the way worker.py does, but makes up the media instead of downloading and
+// cutting a video.
+type fakeWorker struct {
+	e *env
+	// ttl is how long the clips it creates live before lifecycle expires
+	// them.
+	ttl time.Duration
+}
+
+// fakeClip describes a clip the worker cut, with the media it uploaded.
+type fakeClip struct {
+	ID       string
+	SourceID string
+	Key      string
+	Media    []byte
+}
+
+// claim claims the next job of jobType. It returns an empty ID when the
+// queue has none.
+func (fw *fakeWorker) claim(jobType string) (string, map[string]interface{}) {
+	e := fw.e
+	e.t.Helper()
+	code, resp := e.do("POST", "/api/internal/jobs/claim", workerSecret, map[string][]string{"job_types": {jobType}})
+	switch code {
+	case 204:
+		return "", nil
+	case 200:
+		payload, _ := resp["payload"].(map[string]interface{})
+		return resp["id"].(string), payload
+	}
+	e.t.Fatalf("claim %s job: %d %v", jobType, code, resp)
+	return "", nil
+}
+
+// runDownload claims a download job and turns its source into one clip
+// titled title on topics, following worker.py: the source goes processing,
+// the media is uploaded, the clip is created, and then the source and job
+// complete.
+func (fw *fakeWorker) runDownload(title string, topics ...string) fakeClip {
+	e := fw.e
+	e.t.Helper()
+	jobID, payload := fw.claim("download")
+	if jobID == "" {
+		e.t.Fatal("no download job to claim")
+	}
+	sourceID, _ := payload["source_id"].(string)
+	if sourceID == "" {
+		e.t.Fatalf("download payload has no source_id: %v", payload)
+	}
+	fw.put("/api/internal/sources/"+sourceID, map[string]string{"status": "processing"})
+
+	c := fakeClip{
+		ID:       fmt.Sprintf("clip-%s", jobID),
+		SourceID: sourceID,
+		Media:    []byte("fake mp4 cut from " + payload["url"].(string)),
+	}
+	c.Key = "clips/" + c.ID + "/clip.mp4"
+	thumbKey := "clips/" + c.ID + "/thumbnail.jpg"
+	fw.upload(c.Key, c.Media, "video/mp4")
+	fw.upload(thumbKey, []byte("fake jpeg"), "image/jpeg")
+
+	code, resp := e.do("POST", "/api/internal/clips", workerSecret, map[string]interface{}{
+		"id": c.ID, "source_id": sourceID, "title": title,
+		"duration_seconds": 30, "start_time": 0, "end_time": 30,
+		"storage_key": c.Key, "thumbnail_key": thumbKey,
+		"width": 1080, "height": 1920, "file_size_bytes": len(c.Media),
+		"transcript": title + " transcript", "topics": topics, "content_score": 0.5,
+		"platform": payload["platform"], "channel_name": "Integration Channel",
+		"expires_at": time.Now().UTC().Add(fw.ttl).Format("2006-01-02T15:04:05Z"),
+	})
+	if code != 201 {
+		e.t.Fatalf("create clip: %d %v", code, resp)
+	}
+
+	fw.put("/api/internal/sources/"+sourceID, map[string]string{"status": "complete"})
+	result, _ := json.Marshal(map[string]interface{}{"clip_ids": []string{c.ID}, "segments": 1})
+	fw.put("/api/internal/jobs/"+jobID, map[string]interface{}{"status": "complete", "result": json.RawMessage(result)})
+	return c
+}
+
+func (fw *fakeWorker) put(path string, body interface{}) {
+	fw.e.t.Helper()
+	if code, resp := fw.e.do("PUT", path, workerSecret, body); code != 200 {
+		fw.e.t.Fatalf("PUT %s: %d %v", path, code, resp)
+	}
+}
+
+func (fw *fakeWorker) upload(key string, data []byte, contentType string) {
+	fw.e.t.Helper()
+	if _, err := fw.e.store.PutObject(context.Background(), fw.e.bucket, key,
+		bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: contentType}); err != nil {
+		fw.e.t.Fatalf("upload %s: %v", key, err)
+	}
+}
+
+// updateScores runs the score updater's pass, as score_updater.py does on
+// its schedule.
+func (fw *fakeWorker) updateScores() {
+	fw.e.t.Helper()
+	if code, resp := fw.e.do("POST", "/api/internal/scores/update", workerSecret, nil); code != 200 {
+		fw.e.t.Fatalf("score update: %d %v", code, resp)
+	}
+}
@@ -0,0 +1,274 @@
+// Package integrations pushes the clips a user saves to services outside
+// ClipFeed: a webhook of their own, Readwise, or a YouTube playlist. Sync is
+// one way. Each new save is queued once per enabled integration and a
+// background loop delivers the queue, retrying failures with backoff.
+package integrations
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/crypto"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Integration kinds.
+const (
+	// KindWebhook POSTs each saved clip as JSON, signed with a secret.
+	KindWebhook = "webhook"
+	// KindReadwise adds each saved clip to Readwise as a highlight.
+	KindReadwise = "readwise"
+	// KindYouTubePlaylist adds saved YouTube clips' videos to a playlist,
+	// signed in with the user's YouTube cookie.
+	KindYouTubePlaylist = "youtube_playlist"
+)
+
+// Kinds lists every integration kind.
+var Kinds = []string{KindWebhook, KindReadwise, KindYouTubePlaylist}
+
+var playlistIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{10,64}$`)
+
+// Handler holds dependencies for integration endpoints and the sync loop.
+type Handler struct {
+	DB           *db.CompatDB
+	CookieSecret string
+	// AllowPrivateURLs lets webhooks target loopback and private network
+	// addresses, which are refused by default.
+	AllowPrivateURLs bool
+	// ReadwiseURL and YouTubeURL override the services' endpoints.
+	ReadwiseURL string
+	YouTubeURL  string
+}
+
+// QueueSaved queues clipID for every integration userID has enabled. It is
+// called when the user saves a clip; a clip already queued for an
+// integration is not queued again, so re-saving it does not resend it.
+func QueueSaved(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, userID, clipID string) error {
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO integration_deliveries (integration_id, clip_id)
+		SELECT id, ? FROM user_integrations WHERE user_id = ? AND enabled = 1
+		ON CONFLICT DO NOTHING
+	`, clipID, userID)
+	return err
+}
+
+// HandleList lists the user's integrations with their sync status and
+// delivery counts.
+func (h *Handler) HandleList(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT i.id, i.kind, i.target, i.enabled, i.consecutive_failures, i.last_error,
+		       i.last_success_at, i.created_at,
+		       COALESCE(SUM(CASE WHEN d.status = 'pending' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN d.status = 'sent'    THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN d.status = 'skipped' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN d.status = 'failed'  THEN 1 ELSE 0 END), 0)
+		FROM user_integrations i
+		LEFT JOIN integration_deliveries d ON d.integration_id = i.id
+		WHERE i.user_id = ?
+		GROUP BY i.id
+		ORDER BY i.created_at`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list integrations"})
+		return
+	}
+	defer rows.Close()
+
+	integrations := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, kind, target, createdAt string
+		var enabled, failures, pending, sent, skipped, failed int
+		var lastError, lastSuccess *string
+		if err := rows.Scan(&id, &kind, &target, &enabled, &failures, &lastError,
+			&lastSuccess, &createdAt, &pending, &sent, &skipped, &failed); err != nil {
+			continue
+		}
+		integrations = append(integrations, map[string]interface{}{
+			"id": id, "kind": kind, "target": target, "enabled": enabled == 1,
+			"status":               status(enabled == 1, failures, lastSuccess),
+			"last_error":           lastError,
+			"consecutive_failures": failures,
+			"last_success_at":      lastSuccess,
+			"created_at":           createdAt,
+			"deliveries": map[string]int{
+				"pending": pending, "sent": sent, "skipped": skipped, "failed": failed,
+			},
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"integrations": integrations})
+}
+
+// status summarises an integration's health: disabled, failing (its last
+// delivery attempt failed), ok, or waiting (nothing delivered yet).
+func status(enabled bool, failures int, lastSuccess *string) string {
+	switch {
+	case !enabled:
+		return "disabled"
+	case failures > 0:
+		return "failing"
+	case lastSuccess != nil:
+		return "ok"
+	}
+	return "waiting"
+}
+
+// HandleCreate sets up an integration of a kind the user has none of yet.
+// A webhook's signing secret is generated here and returned only once.
+func (h *Handler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	var req struct {
+		Kind       string `json:"kind"`
+		URL        string `json:"url"`
+		Token      string `json:"token"`
+		PlaylistID string `json:"playlist_id"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	var target, secret string
+	switch req.Kind {
+	case KindWebhook:
+		u, err := url.Parse(strings.TrimSpace(req.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "url must be an http or https URL"})
+			return
+		}
+		target = u.String()
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		secret = hex.EncodeToString(buf)
+	case KindReadwise:
+		secret = strings.TrimSpace(req.Token)
+		if secret == "" {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "token required"})
+			return
+		}
+	case KindYouTubePlaylist:
+		target = strings.TrimSpace(req.PlaylistID)
+		if !playlistIDPattern.MatchString(target) {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "playlist_id must be a YouTube playlist id"})
+			return
+		}
+		if _, err := h.youtubeCookie(r.Context(), userID); err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "save a youtube cookie before syncing to a playlist"})
+			return
+		}
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "kind must be one of: " + strings.Join(Kinds, ", ")})
+		return
+	}
+
+	var encrypted interface{}
+	if secret != "" {
+		enc, err := crypto.EncryptCookie(secret, h.CookieSecret)
+		if err != nil {
+			log.Printf("integrations: encrypt secret: %v", err)
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create integration"})
+			return
+		}
+		encrypted = enc
+	}
+
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO user_integrations (id, user_id, kind, target, secret) VALUES (?, ?, ?, ?, ?)`,
+		id, userID, req.Kind, target, encrypted); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			httputil.WriteJSON(w, 409, map[string]string{"error": "you already have a " + req.Kind + " integration"})
+			return
+		}
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create integration"})
+		return
+	}
+
+	resp := map[string]interface{}{"id": id, "kind": req.Kind, "target": target}
+	if req.Kind == KindWebhook {
+		resp["secret"] = secret
+	}
+	httputil.WriteJSON(w, 201, resp)
+}
+
+// HandleUpdate turns an integration on or off. Saves made while it is off
+// are not synced later.
+func (h *Handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || req.Enabled == nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "enabled required"})
+		return
+	}
+	enabled := 0
+	if *req.Enabled {
+		enabled = 1
+	}
+	res, err := h.DB.ExecContext(r.Context(),
+		`UPDATE user_integrations SET enabled = ? WHERE id = ? AND user_id = ?`,
+		enabled, chi.URLParam(r, "id"), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update integration"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "integration not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
+}
+
+// HandleDelete removes an integration and its delivery queue.
+func (h *Handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM user_integrations WHERE id = ? AND user_id = ?`, chi.URLParam(r, "id"), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete integration"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "integration not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// HandleRetry puts an integration's failed deliveries back in the queue,
+// for after the user fixed what made them fail.
+func (h *Handler) HandleRetry(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	id := chi.URLParam(r, "id")
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT 1 FROM user_integrations WHERE id = ? AND user_id = ?`, id, userID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "integration not found"})
+		return
+	}
+	res, err := h.DB.ExecContext(r.Context(), `
+		UPDATE integration_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NULL, last_error = NULL
+		WHERE integration_id = ? AND status = 'failed'`, id)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to retry deliveries"})
+		return
+	}
+	n, _ := res.RowsAffected()
+	httputil.WriteJSON(w, 200, map[string]interface{}{"status": "queued", "retried": n})
+}
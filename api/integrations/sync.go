@@ -0,0 +1,450 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"clipfeed/crypto"
+	"clipfeed/httputil"
+	"clipfeed/transcripts"
+	"clipfeed/worker"
+)
+
+const (
+	// DefaultReadwiseURL is Readwise's highlight creation endpoint.
+	DefaultReadwiseURL = "https://readwise.io/api/v2/highlights/"
+	// DefaultYouTubeURL is where playlist edits are sent.
+	DefaultYouTubeURL = "https://www.youtube.com"
+
+	// SignatureHeader carries a webhook body's HMAC-SHA256 under the
+	// integration's secret, as "sha256=<hex>".
+	SignatureHeader = "X-ClipFeed-Signature"
+
+	// maxAttempts is how many times a delivery is tried before it is
+	// marked failed; the wait doubles from retryBase up to retryMax.
+	maxAttempts = 8
+	retryBase   = time.Minute
+	retryMax    = 6 * time.Hour
+	// syncInterval is how often the loop looks for due deliveries, and
+	// syncBatch caps how many it sends per pass.
+	syncInterval = 30 * time.Second
+	syncBatch    = 50
+	// maxHighlightLen is Readwise's limit on a highlight's text.
+	maxHighlightLen = 8191
+	maxErrorLen     = 500
+
+	timeLayout = "2006-01-02T15:04:05Z"
+)
+
+// errSkip marks a clip an integration cannot take, such as a clip not from
+// YouTube for a playlist; it is recorded as skipped, not failed.
+var errSkip = errors.New("skipped")
+
+// permanentError is a failure retrying will not fix, like a rejected token.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+var serviceClient = &http.Client{Timeout: 30 * time.Second}
+
+// webhookClient refuses to connect to loopback, private, and link-local
+// addresses, checked after DNS resolution so a hostname cannot point a
+// webhook at the internal network.
+var webhookClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("webhook address %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// delivery is one saved clip due to be sent to one integration.
+type delivery struct {
+	integrationID string
+	userID        string
+	kind          string
+	target        string
+	secret        *string
+	attempts      int
+
+	clipID      string
+	title       string
+	duration    float64
+	platform    *string
+	channelName *string
+	sourceURL   *string
+	externalID  *string
+	startTime   *float64
+	endTime     *float64
+	savedAt     *string
+	note        *string
+}
+
+// SyncLoop delivers queued saves every syncInterval.
+func (h *Handler) SyncLoop() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := h.SyncPending(context.Background()); err != nil {
+			log.Printf("integrations: sync: %v", err)
+		}
+	}
+}
+
+// SyncPending sends deliveries that are due, recording each outcome on the
+// delivery and the integration. It returns how many were sent.
+func (h *Handler) SyncPending(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT i.id, i.user_id, i.kind, i.target, i.secret, d.attempts,
+		       c.id, COALESCE(c.title, ''), c.duration_seconds,
+		       s.platform, s.channel_name, s.url, s.external_id, c.start_time, c.end_time,
+		       sc.created_at, sc.note
+		FROM integration_deliveries d
+		JOIN user_integrations i ON i.id = d.integration_id
+		JOIN clips c ON c.id = d.clip_id
+		LEFT JOIN sources s ON s.id = c.source_id
+		LEFT JOIN saved_clips sc ON sc.user_id = i.user_id AND sc.clip_id = c.id
+		WHERE d.status = 'pending' AND i.enabled = 1
+		  AND (d.next_attempt_at IS NULL OR d.next_attempt_at <= ?)
+		ORDER BY d.created_at
+		LIMIT ?`, now.Format(timeLayout), syncBatch)
+	if err != nil {
+		return 0, fmt.Errorf("load due deliveries: %w", err)
+	}
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.integrationID, &d.userID, &d.kind, &d.target, &d.secret, &d.attempts,
+			&d.clipID, &d.title, &d.duration,
+			&d.platform, &d.channelName, &d.sourceURL, &d.externalID, &d.startTime, &d.endTime,
+			&d.savedAt, &d.note); err != nil {
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	sent := 0
+	for _, d := range due {
+		err := h.deliver(ctx, &d)
+		if err == nil {
+			sent++
+		}
+		h.record(ctx, &d, err, now)
+	}
+	return sent, nil
+}
+
+// record stores the outcome of one delivery attempt.
+func (h *Handler) record(ctx context.Context, d *delivery, err error, now time.Time) {
+	stamp := now.Format(timeLayout)
+	if err == nil || errors.Is(err, errSkip) {
+		status, lastErr := "sent", interface{}(nil)
+		if err != nil {
+			status, lastErr = "skipped", err.Error()
+		}
+		h.DB.ExecContext(ctx, `
+			UPDATE integration_deliveries SET status = ?, attempts = attempts + 1, last_error = ?, sent_at = ?
+			WHERE integration_id = ? AND clip_id = ?`, status, lastErr, stamp, d.integrationID, d.clipID)
+		if status == "sent" {
+			h.DB.ExecContext(ctx, `
+				UPDATE user_integrations SET consecutive_failures = 0, last_error = NULL, last_success_at = ?
+				WHERE id = ?`, stamp, d.integrationID)
+		}
+		return
+	}
+
+	msg := err.Error()
+	if len(msg) > maxErrorLen {
+		msg = msg[:maxErrorLen]
+	}
+	attempts := d.attempts + 1
+	var permanent permanentError
+	if errors.As(err, &permanent) || attempts >= maxAttempts {
+		h.DB.ExecContext(ctx, `
+			UPDATE integration_deliveries SET status = 'failed', attempts = ?, last_error = ?, next_attempt_at = NULL
+			WHERE integration_id = ? AND clip_id = ?`, attempts, msg, d.integrationID, d.clipID)
+	} else {
+		wait := retryBase << (attempts - 1)
+		if wait > retryMax {
+			wait = retryMax
+		}
+		h.DB.ExecContext(ctx, `
+			UPDATE integration_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ?
+			WHERE integration_id = ? AND clip_id = ?`,
+			attempts, msg, now.Add(wait).Format(timeLayout), d.integrationID, d.clipID)
+	}
+	h.DB.ExecContext(ctx, `
+		UPDATE user_integrations SET consecutive_failures = consecutive_failures + 1, last_error = ?
+		WHERE id = ?`, msg, d.integrationID)
+	log.Printf("integrations: deliver clip %s to %s %s (attempt %d): %v", d.clipID, d.kind, d.integrationID, attempts, err)
+}
+
+func (h *Handler) deliver(ctx context.Context, d *delivery) error {
+	if d.savedAt == nil {
+		return fmt.Errorf("%w: clip was unsaved before it was sent", errSkip)
+	}
+	var secret string
+	if d.secret != nil {
+		s, err := crypto.DecryptCookie(*d.secret, h.CookieSecret)
+		if err != nil {
+			return permanentError{fmt.Errorf("decrypt secret: %w", err)}
+		}
+		secret = s
+	}
+	switch d.kind {
+	case KindWebhook:
+		return h.sendWebhook(ctx, d, secret)
+	case KindReadwise:
+		return h.sendReadwise(ctx, d, secret)
+	case KindYouTubePlaylist:
+		return h.addToPlaylist(ctx, d)
+	}
+	return permanentError{fmt.Errorf("unknown integration kind %q", d.kind)}
+}
+
+// deepLink is the link back to the clip's moment in its source video.
+func (d *delivery) deepLink() string {
+	attr := httputil.Attribution(d.platform, d.sourceURL, d.channelName, d.startTime, d.endTime)
+	if attr == nil {
+		return ""
+	}
+	return attr["deep_link"].(string)
+}
+
+func (h *Handler) sendWebhook(ctx context.Context, d *delivery, secret string) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"event": "clip.saved",
+		"clip": map[string]interface{}{
+			"id": d.clipID, "title": d.title, "duration_seconds": d.duration,
+			"platform": d.platform, "channel_name": d.channelName,
+			"source_url": d.sourceURL, "deep_link": d.deepLink(),
+			"start_seconds": d.startTime, "end_seconds": d.endTime,
+			"saved_at": d.savedAt, "note": d.note,
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.target, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ClipFeed")
+	req.Header.Set("X-ClipFeed-Event", "clip.saved")
+	req.Header.Set("X-ClipFeed-Delivery", d.integrationID+":"+d.clipID)
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	client := webhookClient
+	if h.AllowPrivateURLs {
+		client = serviceClient
+	}
+	return do(client, req, nil)
+}
+
+func (h *Handler) sendReadwise(ctx context.Context, d *delivery, token string) error {
+	transcript, err := transcripts.LoadPrefix(ctx, h.DB, d.clipID, maxHighlightLen)
+	if err != nil {
+		return fmt.Errorf("load transcript: %w", err)
+	}
+	text := strings.TrimSpace(transcript)
+	if text == "" {
+		text = d.title
+	}
+	text = worker.Truncate(text, maxHighlightLen)
+	highlight := map[string]interface{}{
+		"text": text, "title": d.title, "source_type": "clipfeed", "category": "articles",
+		"highlighted_at": d.savedAt,
+	}
+	if d.channelName != nil {
+		highlight["author"] = *d.channelName
+	}
+	if link := d.deepLink(); link != "" {
+		highlight["source_url"] = link
+	}
+	if d.note != nil && *d.note != "" {
+		highlight["note"] = *d.note
+	}
+	body, _ := json.Marshal(map[string]interface{}{"highlights": []interface{}{highlight}})
+
+	endpoint := h.ReadwiseURL
+	if endpoint == "" {
+		endpoint = DefaultReadwiseURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+token)
+	return do(serviceClient, req, nil)
+}
+
+// addToPlaylist adds the clip's source video to the playlist through
+// YouTube's web client API, signed in with the user's saved cookie.
+func (h *Handler) addToPlaylist(ctx context.Context, d *delivery) error {
+	if d.platform == nil || *d.platform != "youtube" || d.externalID == nil || *d.externalID == "" {
+		return fmt.Errorf("%w: clip is not from a YouTube video", errSkip)
+	}
+	cookie, err := h.youtubeCookie(ctx, d.userID)
+	if err != nil {
+		return permanentError{err}
+	}
+	header, sapisid := cookieHeader(cookie)
+	if sapisid == "" {
+		return permanentError{errors.New("youtube cookie has no SAPISID; export it again while signed in")}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"context": map[string]interface{}{
+			"client": map[string]string{"clientName": "WEB", "clientVersion": "2.20240101.00.00"},
+		},
+		"playlistId": d.target,
+		"actions":    []map[string]string{{"action": "ACTION_ADD_VIDEO", "addedVideoId": *d.externalID}},
+	})
+	base := h.YouTubeURL
+	if base == "" {
+		base = DefaultYouTubeURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		base+"/youtubei/v1/browse/edit_playlist?prettyPrint=false", bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cookie", header)
+	req.Header.Set("Origin", DefaultYouTubeURL)
+	req.Header.Set("X-Origin", DefaultYouTubeURL)
+	req.Header.Set("Authorization", sapisidHash(sapisid, time.Now()))
+
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := do(serviceClient, req, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "STATUS_SUCCEEDED" {
+		return fmt.Errorf("youtube did not add the video (status %q)", resp.Status)
+	}
+	return nil
+}
+
+// youtubeCookie returns the user's decrypted YouTube cookie.
+func (h *Handler) youtubeCookie(ctx context.Context, userID string) (string, error) {
+	var encrypted string
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT cookie_str FROM platform_cookies WHERE user_id = ? AND platform = 'youtube' AND is_active = 1`,
+		userID).Scan(&encrypted); err != nil {
+		return "", errors.New("no youtube cookie saved")
+	}
+	cookie, err := crypto.DecryptCookie(encrypted, h.CookieSecret)
+	if err != nil {
+		return "", fmt.Errorf("decrypt youtube cookie: %w", err)
+	}
+	return cookie, nil
+}
+
+// cookieHeader turns a saved cookie, either a Netscape cookies.txt export
+// or a "name=value; ..." header, into a Cookie header for youtube.com and
+// returns the SAPISID the request must be signed with.
+func cookieHeader(raw string) (string, string) {
+	pairs := map[string]string{}
+	var order []string
+	add := func(name, value string) {
+		if _, seen := pairs[name]; !seen {
+			order = append(order, name)
+		}
+		pairs[name] = value
+	}
+	if strings.Contains(raw, "\t") {
+		for _, line := range strings.Split(raw, "\n") {
+			line = strings.TrimPrefix(strings.TrimSpace(line), "#HttpOnly_")
+			fields := strings.Split(line, "\t")
+			if strings.HasPrefix(line, "#") || len(fields) < 7 || !strings.HasSuffix(fields[0], "youtube.com") {
+				continue
+			}
+			add(fields[5], fields[6])
+		}
+	} else {
+		for _, part := range strings.Split(raw, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(part), "="); ok && name != "" {
+				add(name, value)
+			}
+		}
+	}
+	parts := make([]string, 0, len(order))
+	for _, name := range order {
+		parts = append(parts, name+"="+pairs[name])
+	}
+	sapisid := pairs["SAPISID"]
+	if sapisid == "" {
+		sapisid = pairs["__Secure-3PAPISID"]
+	}
+	return strings.Join(parts, "; "), sapisid
+}
+
+// sapisidHash is the Authorization header YouTube's web client signs its
+// API requests with.
+func sapisidHash(sapisid string, now time.Time) string {
+	ts := fmt.Sprint(now.Unix())
+	sum := sha1.Sum([]byte(ts + " " + sapisid + " " + DefaultYouTubeURL))
+	return "SAPISIDHASH " + ts + "_" + hex.EncodeToString(sum[:])
+}
+
+// do sends req, decoding a JSON response into out when it is not nil. Client
+// errors other than timeouts and rate limits are permanent.
+func do(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		err := fmt.Errorf("%s responded %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(snippet)))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return permanentError{err}
+		}
+		return err
+	}
+	if out != nil {
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+			return fmt.Errorf("decode %s response: %w", req.URL.Host, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package integrations
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"clipfeed/crypto"
+	"clipfeed/db"
+	"clipfeed/transcripts"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestCookieHeader_Formats(t *testing.T) {
+	netscape := "# Netscape HTTP Cookie File\n" +
+		".youtube.com\tTRUE\t/\tTRUE\t1999999999\tSID\ts1\n" +
+		"#HttpOnly_.youtube.com\tTRUE\t/\tTRUE\t1999999999\tHSID\th1\n" +
+		".youtube.com\tTRUE\t/\tTRUE\t1999999999\tSAPISID\tsap1\n" +
+		".google.com\tTRUE\t/\tTRUE\t1999999999\tNID\tother\n"
+	cases := []struct {
+		name, raw, header, sapisid string
+	}{
+		{"netscape", netscape, "SID=s1; HSID=h1; SAPISID=sap1", "sap1"},
+		{"header", "SID=s1; __Secure-3PAPISID=sap3", "SID=s1; __Secure-3PAPISID=sap3", "sap3"},
+		{"no sapisid", "SID=s1", "SID=s1", ""},
+	}
+	for _, c := range cases {
+		header, sapisid := cookieHeader(c.raw)
+		if header != c.header || sapisid != c.sapisid {
+			t.Errorf("%s: got %q / %q, want %q / %q", c.name, header, sapisid, c.header, c.sapisid)
+		}
+	}
+}
+
+func TestSapisidHash(t *testing.T) {
+	// sha1("1700000000 sap1 https://www.youtube.com")
+	want := "SAPISIDHASH 1700000000_ffa2ec1c16bb019ba245b403323e0db84b1d8624"
+	if got := sapisidHash("sap1", time.Unix(1700000000, 0)); got != want {
+		t.Errorf("sapisidHash = %q, want %q", got, want)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8": true, "2606:4700::1111": true,
+		"127.0.0.1": false, "10.1.2.3": false, "192.168.0.10": false,
+		"169.254.169.254": false, "::1": false, "fd00::1": false, "0.0.0.0": false,
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("publicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestSyncPending_ReadwiseHighlightCarriesTranscript(t *testing.T) {
+	cdb := newTestDB(t)
+	ctx := context.Background()
+	var highlights []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Highlights []map[string]interface{} `json:"highlights"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		highlights = append(highlights, body.Highlights...)
+	}))
+	defer srv.Close()
+	h := &Handler{DB: cdb, CookieSecret: "cookie-secret", ReadwiseURL: srv.URL}
+
+	token, _ := crypto.EncryptCookie("rw-token", h.CookieSecret)
+	cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES ('u1', 'u1', 'u1@x.com', 'x')`)
+	// Longer than a highlight, in multi-byte runes, so truncation must
+	// not split one.
+	transcript := strings.Repeat("héllo wörld ", 1000)
+	for _, id := range []string{"c1", "c2"} {
+		cdb.Exec(`INSERT INTO clips (id, title, duration_seconds, storage_key, status) VALUES (?, ?, 30.0, 'k', 'ready')`, id, "Title "+id)
+		cdb.Exec(`INSERT INTO saved_clips (user_id, clip_id) VALUES ('u1', ?)`, id)
+	}
+	if err := transcripts.Save(ctx, cdb, "c1", transcript); err != nil {
+		t.Fatalf("save transcript: %v", err)
+	}
+	cdb.Exec(`INSERT INTO user_integrations (id, user_id, kind, secret) VALUES ('i1', 'u1', 'readwise', ?)`, token)
+	cdb.Exec(`INSERT INTO integration_deliveries (integration_id, clip_id) VALUES ('i1', 'c1')`)
+	cdb.Exec(`INSERT INTO integration_deliveries (integration_id, clip_id, created_at) VALUES ('i1', 'c2', '2099-01-01T00:00:00Z')`)
+
+	if sent, err := h.SyncPending(ctx); err != nil || sent != 2 {
+		t.Fatalf("SyncPending = %d, %v; want 2 sent", sent, err)
+	}
+	if len(highlights) != 2 {
+		t.Fatalf("got %d highlights, want 2", len(highlights))
+	}
+	text := highlights[0]["text"].(string)
+	if !strings.HasPrefix(text, "héllo wörld") || len([]rune(text)) != maxHighlightLen || !utf8.ValidString(text) {
+		t.Errorf("highlight text = %q..., %d runes; want the transcript cut to %d runes", text[:20], len([]rune(text)), maxHighlightLen)
+	}
+	// Clips without a transcript fall back to their title.
+	if text := highlights[1]["text"]; text != "Title c2" {
+		t.Errorf("highlight text without transcript = %v, want the title", text)
+	}
+}
@@ -0,0 +1,236 @@
+// Package invites issues and redeems the single-use codes that admit new
+// users while registration is restricted. Admins issue invites with preset
+// quotas for the account they admit; users hand out invites from their own
+// invite quota. Every redemption records who invited whom.
+package invites
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+const maxNoteLen = 200
+
+// ErrQuotaExhausted is returned when a user has no invites left.
+var ErrQuotaExhausted = errors.New("no invites left")
+
+// Options describe a new invite. The quotas are applied to the account
+// that redeems it.
+type Options struct {
+	Note      string
+	ExpiresIn time.Duration // zero for an invite that never expires
+	// InviteQuota is how many invites the new user may issue.
+	InviteQuota int
+	// DailyIngestQuota caps the new user's ingests per day; nil for none.
+	DailyIngestQuota *int
+}
+
+// Request is the JSON body for creating an invite.
+type Request struct {
+	Note             string `json:"note"`
+	ExpiresInHours   int    `json:"expires_in_hours"`
+	InviteQuota      int    `json:"invite_quota"`
+	DailyIngestQuota *int   `json:"daily_ingest_quota"`
+}
+
+// DecodeRequest reads an optional invite request body and validates it.
+func DecodeRequest(r *http.Request) (Request, error) {
+	var req Request
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil && err != io.EOF {
+		return req, errors.New("invalid request body")
+	}
+	if len(req.Note) > maxNoteLen {
+		return req, fmt.Errorf("note must be at most %d characters", maxNoteLen)
+	}
+	if req.ExpiresInHours < 0 || req.InviteQuota < 0 || (req.DailyIngestQuota != nil && *req.DailyIngestQuota < 0) {
+		return req, errors.New("expires_in_hours and quotas must not be negative")
+	}
+	return req, nil
+}
+
+// Options converts the request to invite options.
+func (req Request) Options() Options {
+	return Options{
+		Note:             req.Note,
+		ExpiresIn:        time.Duration(req.ExpiresInHours) * time.Hour,
+		InviteQuota:      req.InviteQuota,
+		DailyIngestQuota: req.DailyIngestQuota,
+	}
+}
+
+// Create stores a new invite code issued by createdBy, or by an admin when
+// createdBy is empty, and returns it with its expiry.
+func Create(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, createdBy string, opts Options) (string, *string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("generate code: %w", err)
+	}
+	code := hex.EncodeToString(b)
+	var expiresAt *string
+	if opts.ExpiresIn > 0 {
+		t := time.Now().UTC().Add(opts.ExpiresIn).Format("2006-01-02T15:04:05Z")
+		expiresAt = &t
+	}
+	var issuer interface{}
+	if createdBy != "" {
+		issuer = createdBy
+	}
+	if _, err := ex.ExecContext(ctx, `
+		INSERT INTO invite_codes (code, note, expires_at, created_by, invite_quota, daily_ingest_quota)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, code, opts.Note, expiresAt, issuer, opts.InviteQuota, opts.DailyIngestQuota); err != nil {
+		return "", nil, err
+	}
+	return code, expiresAt, nil
+}
+
+// Consume redeems code for the newly created userID, recording who invited
+// them and applying the invite's quotas. With adminOnly set, invites issued
+// by users are refused. It returns auth.ErrInvalidInvite for a code that does
+// not exist, has been used, or has expired.
+func Consume(ctx context.Context, conn *db.CompatConn, code, userID string, adminOnly bool) error {
+	var createdBy *string
+	var inviteQuota int
+	var dailyIngestQuota *int
+	if err := conn.QueryRowContext(ctx,
+		`SELECT created_by, invite_quota, daily_ingest_quota FROM invite_codes WHERE code = ?`, code,
+	).Scan(&createdBy, &inviteQuota, &dailyIngestQuota); err != nil {
+		return auth.ErrInvalidInvite
+	}
+	if adminOnly && createdBy != nil {
+		return auth.ErrInvalidInvite
+	}
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	res, err := conn.ExecContext(ctx, `
+		UPDATE invite_codes SET used_by = ?, used_at = ?
+		WHERE code = ? AND used_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+	`, userID, now, code, now)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return auth.ErrInvalidInvite
+	}
+	_, err = conn.ExecContext(ctx,
+		`UPDATE users SET invited_by = ?, invite_quota = ?, daily_ingest_quota = ? WHERE id = ?`,
+		createdBy, inviteQuota, dailyIngestQuota, userID)
+	return err
+}
+
+// Handler serves the invites users issue themselves.
+type Handler struct {
+	DB *db.CompatDB
+}
+
+// remaining is how many more invites userID may issue. Deleted invites are
+// not counted, so revoking an unused invite returns it to the quota.
+func remaining(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, userID string) (int, error) {
+	var quota, issued int
+	err := q.QueryRowContext(ctx, `
+		SELECT u.invite_quota, (SELECT COUNT(*) FROM invite_codes WHERE created_by = u.id)
+		FROM users u WHERE u.id = ?
+	`, userID).Scan(&quota, &issued)
+	if quota < issued {
+		return 0, err
+	}
+	return quota - issued, err
+}
+
+// HandleListMyInvites lists the invites the user has issued, with who
+// redeemed them, and how many they have left.
+func (h *Handler) HandleListMyInvites(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	left, err := remaining(r.Context(), h.DB, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list invites"})
+		return
+	}
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT i.code, COALESCE(i.note, ''), COALESCE(i.created_at, ''), i.expires_at, i.used_at, u.username
+		FROM invite_codes i
+		LEFT JOIN users u ON u.id = i.used_by
+		WHERE i.created_by = ?
+		ORDER BY i.created_at DESC
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list invites"})
+		return
+	}
+	defer rows.Close()
+
+	invites := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var code, note, createdAt string
+		var expiresAt, usedAt, usedBy *string
+		if err := rows.Scan(&code, &note, &createdAt, &expiresAt, &usedAt, &usedBy); err != nil {
+			continue
+		}
+		invites = append(invites, map[string]interface{}{
+			"code": code, "note": note, "created_at": createdAt,
+			"expires_at": expiresAt, "used_at": usedAt, "used_by": usedBy,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"invites": invites, "remaining": left})
+}
+
+// HandleCreateMyInvite issues an invite from the user's quota. The invited
+// account gets no invites of its own and inherits the issuer's daily ingest
+// quota, so invites cannot be used to shed a limit.
+func (h *Handler) HandleCreateMyInvite(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	req, err := DecodeRequest(r)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var code string
+	var expiresAt *string
+	var left int
+	err = db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var err error
+		if left, err = remaining(r.Context(), conn, userID); err != nil {
+			return err
+		}
+		if left == 0 {
+			return ErrQuotaExhausted
+		}
+		opts := Options{Note: req.Note, ExpiresIn: req.Options().ExpiresIn}
+		if err := conn.QueryRowContext(r.Context(),
+			`SELECT daily_ingest_quota FROM users WHERE id = ?`, userID).Scan(&opts.DailyIngestQuota); err != nil {
+			return err
+		}
+		code, expiresAt, err = Create(r.Context(), conn, userID, opts)
+		return err
+	})
+	if errors.Is(err, ErrQuotaExhausted) {
+		httputil.WriteJSON(w, 403, map[string]string{"error": "you have no invites left"})
+		return
+	}
+	if err != nil {
+		log.Printf("create invite for %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create invite"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"code": code, "note": req.Note, "expires_at": expiresAt, "remaining": left - 1,
+	})
+}
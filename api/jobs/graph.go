@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"clipfeed/db"
+)
+
+// UpstreamErrorPrefix marks errors set on jobs that were failed or cancelled
+// because a job they depend on did not complete.
+const UpstreamErrorPrefix = "upstream job "
+
+// downstreamCTE selects every job that transitively depends on the bound job.
+const downstreamCTE = `
+	WITH RECURSIVE downstream(id) AS (
+		SELECT job_id FROM job_dependencies WHERE depends_on_job_id = ?
+		UNION
+		SELECT d.job_id FROM job_dependencies d JOIN downstream ds ON d.depends_on_job_id = ds.id
+	)`
+
+// CascadeJobStatus moves every queued job downstream of jobID to status
+// ("failed" or "cancelled"), recording which upstream job caused it.
+func CascadeJobStatus(ctx context.Context, cdb *db.CompatDB, jobID, status string) (int64, error) {
+	res, err := cdb.ExecContext(ctx, fmt.Sprintf(`%s
+		UPDATE jobs SET status = ?, error = ?, completed_at = %s
+		WHERE id IN (SELECT id FROM downstream) AND status = 'queued'
+	`, downstreamCTE, cdb.NowUTC()), jobID, status, fmt.Sprintf("%s%s %s", UpstreamErrorPrefix, jobID, status))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FailBlockedJobs fails queued jobs that depend on a failed or rejected job,
+// repeating until the failure has propagated through the whole graph. It is
+// used after bulk status changes that bypass CascadeJobStatus.
+func FailBlockedJobs(ctx context.Context, cdb *db.CompatDB) (int64, error) {
+	var total int64
+	for {
+		res, err := cdb.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE jobs SET status = 'failed', completed_at = %s,
+			    error = (
+			        SELECT '%s' || d.depends_on_job_id || ' failed' FROM job_dependencies d
+			        JOIN jobs dj ON d.depends_on_job_id = dj.id
+			        WHERE d.job_id = jobs.id AND dj.status IN ('failed', 'rejected') LIMIT 1
+			    )
+			WHERE status = 'queued' AND EXISTS (
+				SELECT 1 FROM job_dependencies d JOIN jobs dj ON d.depends_on_job_id = dj.id
+				WHERE d.job_id = jobs.id AND dj.status IN ('failed', 'rejected')
+			)
+		`, cdb.NowUTC(), UpstreamErrorPrefix))
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			return total, nil
+		}
+		total += n
+	}
+}
+
+// requeueDownstream re-queues jobs downstream of jobID that were failed or
+// cancelled by a cascade, so retrying a job resumes its whole pipeline.
+func requeueDownstream(ctx context.Context, cdb *db.CompatDB, jobID string) (int64, error) {
+	res, err := cdb.ExecContext(ctx, downstreamCTE+`
+		UPDATE jobs SET status = 'queued', error = NULL, run_after = NULL,
+		       attempts = 0, started_at = NULL, completed_at = NULL
+		WHERE id IN (SELECT id FROM downstream)
+		  AND status IN ('failed', 'cancelled') AND error LIKE ?
+	`, jobID, UpstreamErrorPrefix+"%")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// pipelineNode is one job in a source's pipeline view.
+type pipelineNode struct {
+	ID          string   `json:"id"`
+	JobType     string   `json:"job_type"`
+	Status      string   `json:"status"`
+	Error       *string  `json:"error"`
+	DependsOn   []string `json:"depends_on"`
+	StartedAt   *string  `json:"started_at"`
+	CompletedAt *string  `json:"completed_at"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// topoSort orders nodes so every job appears after the jobs it depends on,
+// breaking ties by creation time. Dependencies outside the set are ignored.
+func topoSort(nodes []pipelineNode) []pipelineNode {
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].CreatedAt < nodes[j].CreatedAt })
+	index := make(map[string]int, len(nodes))
+	for i, n := range nodes {
+		index[n.ID] = i
+	}
+	indegree := make([]int, len(nodes))
+	children := make([][]int, len(nodes))
+	for i, n := range nodes {
+		for _, dep := range n.DependsOn {
+			if p, ok := index[dep]; ok {
+				indegree[i]++
+				children[p] = append(children[p], i)
+			}
+		}
+	}
+
+	var ready []int
+	for i := range nodes {
+		if indegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	out := make([]pipelineNode, 0, len(nodes))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		out = append(out, nodes[i])
+		for _, c := range children[i] {
+			if indegree[c]--; indegree[c] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+	return out
+}
+
+// loadPipeline returns every job for a source with its dependencies, in
+// dependency order.
+func (h *Handler) loadPipeline(ctx context.Context, sourceID string) ([]pipelineNode, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id, job_type, status, error, started_at, completed_at, created_at
+		FROM jobs WHERE source_id = ?
+	`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []pipelineNode
+	for rows.Next() {
+		var n pipelineNode
+		if err := rows.Scan(&n.ID, &n.JobType, &n.Status, &n.Error, &n.StartedAt, &n.CompletedAt, &n.CreatedAt); err == nil {
+			n.DependsOn = []string{}
+			nodes = append(nodes, n)
+		}
+	}
+	rows.Close()
+
+	deps, err := h.DB.QueryContext(ctx, `
+		SELECT d.job_id, d.depends_on_job_id FROM job_dependencies d
+		JOIN jobs j ON d.job_id = j.id
+		WHERE j.source_id = ?
+		ORDER BY d.created_at
+	`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer deps.Close()
+	byID := make(map[string]*pipelineNode, len(nodes))
+	for i := range nodes {
+		byID[nodes[i].ID] = &nodes[i]
+	}
+	for deps.Next() {
+		var jobID, parentID string
+		if deps.Scan(&jobID, &parentID) == nil {
+			if n, ok := byID[jobID]; ok {
+				n.DependsOn = append(n.DependsOn, parentID)
+			}
+		}
+	}
+	return topoSort(nodes), nil
+}
@@ -3,6 +3,7 @@ package jobs
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
@@ -66,7 +67,8 @@ func (h *Handler) HandleListJobs(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]interface{}{"jobs": jobList})
 }
 
-// HandleGetJob returns a single job by ID (owned by the authenticated user).
+// HandleGetJob returns a single job by ID (owned by the authenticated user)
+// along with the dependency-ordered pipeline of jobs for its source.
 func (h *Handler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	jobID := chi.URLParam(r, "id")
@@ -97,14 +99,30 @@ func (h *Handler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
 		result = resultStr
 	}
 
-	httputil.WriteJSON(w, 200, map[string]interface{}{
+	resp := map[string]interface{}{
 		"id": id, "source_id": sourceID, "job_type": jobType,
 		"status": status, "payload": payload,
 		"result": result, "error": errMsg, "created_at": createdAt,
-	})
+		"depends_on": []string{},
+	}
+	if sourceID != nil {
+		pipeline, err := h.loadPipeline(r.Context(), *sourceID)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load job pipeline"})
+			return
+		}
+		for _, n := range pipeline {
+			if n.ID == id {
+				resp["depends_on"] = n.DependsOn
+			}
+		}
+		resp["pipeline"] = pipeline
+	}
+	httputil.WriteJSON(w, 200, resp)
 }
 
-// HandleCancelJob cancels a queued or running job.
+// HandleCancelJob cancels a queued or running job and every queued job that
+// depends on it.
 func (h *Handler) HandleCancelJob(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	jobID := chi.URLParam(r, "id")
@@ -124,12 +142,16 @@ func (h *Handler) HandleCancelJob(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteJSON(w, 404, map[string]string{"error": "job not found or not cancellable"})
 		return
 	}
+	if _, err := CascadeJobStatus(r.Context(), h.DB, jobID, "cancelled"); err != nil {
+		log.Printf("cancel job %s: cascade failed: %v", jobID, err)
+	}
 	h.DB.ExecContext(r.Context(),
 		`UPDATE sources SET status = 'cancelled' WHERE id = (SELECT source_id FROM jobs WHERE id = ?)`, jobID)
 	httputil.WriteJSON(w, 200, map[string]string{"status": "cancelled"})
 }
 
-// HandleRetryJob re-queues a failed/cancelled/rejected job.
+// HandleRetryJob re-queues a failed/cancelled/rejected job, along with any
+// dependents that were failed or cancelled because of it.
 func (h *Handler) HandleRetryJob(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	jobID := chi.URLParam(r, "id")
@@ -149,6 +171,9 @@ func (h *Handler) HandleRetryJob(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteJSON(w, 404, map[string]string{"error": "job not found or not retryable"})
 		return
 	}
+	if _, err := requeueDownstream(r.Context(), h.DB, jobID); err != nil {
+		log.Printf("retry job %s: requeue dependents failed: %v", jobID, err)
+	}
 	h.DB.ExecContext(r.Context(),
 		`UPDATE sources SET status = 'pending' WHERE id = (SELECT source_id FROM jobs WHERE id = ?)`, jobID)
 	httputil.WriteJSON(w, 200, map[string]string{"status": "queued"})
@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"clipfeed/tracing"
+)
+
+// ErrInvalidPayload is returned when a job payload does not match the schema
+// for its job type.
+var ErrInvalidPayload = errors.New("invalid job payload")
+
+// Payload is the typed payload of one job type.
+type Payload interface {
+	Validate() error
+}
+
+// DownloadPayload is the payload of a download job: fetch url for source_id.
+type DownloadPayload struct {
+	SchemaVersion int    `json:"schema_version"`
+	URL           string `json:"url"`
+	SourceID      string `json:"source_id"`
+	Platform      string `json:"platform"`
+	// Strategy is the clip extraction strategy. Older payloads lack it; the
+	// source's strategy is filled in when the job is claimed.
+	Strategy string `json:"strategy,omitempty"`
+	// RepairSegments, when set, makes the worker cut only these segments
+	// instead of planning them, to replace clips whose media was broken.
+	RepairSegments []Segment `json:"repair_segments,omitempty"`
+}
+
+// Segment is a span of a source in seconds.
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Validate checks the fields the worker needs to run the download.
+func (p *DownloadPayload) Validate() error {
+	if p.SourceID == "" {
+		return fmt.Errorf("%w: source_id is required", ErrInvalidPayload)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be a valid http or https URL", ErrInvalidPayload)
+	}
+	if p.Strategy != "" && !ValidClipStrategy(p.Strategy) {
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidPayload, p.Strategy)
+	}
+	for _, seg := range p.RepairSegments {
+		if seg.Start < 0 || seg.End <= seg.Start {
+			return fmt.Errorf("%w: repair segment %g-%g must end after it starts", ErrInvalidPayload, seg.Start, seg.End)
+		}
+	}
+	return nil
+}
+
+// PreviewPayload is the payload of a preview job: render the Start-End
+// range of a clip's media at StorageKey as a short GIF or WebM, upload it
+// to OutputKey, and register it as preview PreviewID.
+type PreviewPayload struct {
+	SchemaVersion int     `json:"schema_version"`
+	PreviewID     string  `json:"preview_id"`
+	ClipID        string  `json:"clip_id"`
+	StorageKey    string  `json:"storage_key"`
+	Start         float64 `json:"start"`
+	End           float64 `json:"end"`
+	Format        string  `json:"format"`
+	OutputKey     string  `json:"output_key"`
+}
+
+// Validate checks the fields the worker needs to render the preview.
+func (p *PreviewPayload) Validate() error {
+	switch {
+	case p.PreviewID == "" || p.ClipID == "":
+		return fmt.Errorf("%w: preview_id and clip_id are required", ErrInvalidPayload)
+	case p.StorageKey == "" || p.OutputKey == "":
+		return fmt.Errorf("%w: storage_key and output_key are required", ErrInvalidPayload)
+	case p.Start < 0 || p.End <= p.Start:
+		return fmt.Errorf("%w: preview range %g-%g must end after it starts", ErrInvalidPayload, p.Start, p.End)
+	case p.Format != "gif" && p.Format != "webm":
+		return fmt.Errorf("%w: unknown preview format %q", ErrInvalidPayload, p.Format)
+	}
+	return nil
+}
+
+// TranscodeHLSPayload is the payload of a transcode_hls job: transcode the
+// clip's media at StorageKey into an HLS bitrate ladder, upload the
+// segments and playlists under OutputPrefix, and register them with the
+// clip.
+type TranscodeHLSPayload struct {
+	SchemaVersion int    `json:"schema_version"`
+	ClipID        string `json:"clip_id"`
+	StorageKey    string `json:"storage_key"`
+	OutputPrefix  string `json:"output_prefix"`
+}
+
+// Validate checks the fields the worker needs to transcode the clip.
+func (p *TranscodeHLSPayload) Validate() error {
+	switch {
+	case p.ClipID == "":
+		return fmt.Errorf("%w: clip_id is required", ErrInvalidPayload)
+	case p.StorageKey == "" || p.OutputPrefix == "":
+		return fmt.Errorf("%w: storage_key and output_prefix are required", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// payloadSchema describes how to decode and upgrade one job type's payload.
+// upgrades[v] rewrites a version v payload into version v+1 in place, so the
+// current version is len(upgrades).
+type payloadSchema struct {
+	decode   func() Payload
+	upgrades []func(fields map[string]json.RawMessage) error
+}
+
+// payloadSchemas lists the job types with a typed payload. Job types not
+// listed here carry an opaque JSON object.
+var payloadSchemas = map[string]payloadSchema{
+	"download": {
+		decode: func() Payload { return &DownloadPayload{} },
+		upgrades: []func(map[string]json.RawMessage) error{
+			// v0 payloads predate schema_version; the fields are unchanged.
+			func(map[string]json.RawMessage) error { return nil },
+		},
+	},
+	"preview": {
+		decode: func() Payload { return &PreviewPayload{} },
+	},
+	"transcode_hls": {
+		decode: func() Payload { return &TranscodeHLSPayload{} },
+	},
+}
+
+// PayloadVersion returns the current schema version for jobType, or 0 if the
+// job type has no typed payload.
+func PayloadVersion(jobType string) int {
+	return len(payloadSchemas[jobType].upgrades)
+}
+
+// EncodePayload stamps p with the current schema version for jobType,
+// validates it, and returns its JSON encoding.
+func EncodePayload(jobType string, p Payload) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return "", err
+	}
+	fields["schema_version"] = json.RawMessage(fmt.Sprint(PayloadVersion(jobType)))
+	out, err := json.Marshal(fields)
+	return string(out), err
+}
+
+// WithTraceparent adds the W3C traceparent of ctx's span to a JSON object
+// payload. The worker sends it back on the API calls it makes for the job,
+// so they join the trace of the request that queued it. Payloads queued
+// outside a trace are returned unchanged.
+func WithTraceparent(ctx context.Context, payload string) string {
+	tp := tracing.Traceparent(ctx)
+	if tp == "" {
+		return payload
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil || fields == nil {
+		return payload
+	}
+	fields["traceparent"], _ = json.Marshal(tp)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+	return string(out)
+}
+
+// NormalizePayload parses raw as a payload of jobType, upgrading payloads
+// written under older schema versions, and re-encodes it at the current
+// version. Payloads from a newer schema than this server knows are rejected.
+// Job types without a typed payload must still be a JSON object.
+func NormalizePayload(jobType, raw string) (string, error) {
+	if raw == "" {
+		raw = "{}"
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil || fields == nil {
+		return "", fmt.Errorf("%w: must be a JSON object", ErrInvalidPayload)
+	}
+	schema, ok := payloadSchemas[jobType]
+	if !ok {
+		return raw, nil
+	}
+
+	version := 0
+	if v, ok := fields["schema_version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return "", fmt.Errorf("%w: schema_version must be an integer", ErrInvalidPayload)
+		}
+	}
+	current := len(schema.upgrades)
+	if version < 0 || version > current {
+		return "", fmt.Errorf("%w: unsupported %s schema_version %d (current %d)", ErrInvalidPayload, jobType, version, current)
+	}
+	for ; version < current; version++ {
+		if err := schema.upgrades[version](fields); err != nil {
+			return "", fmt.Errorf("%w: upgrade %s payload from v%d: %v", ErrInvalidPayload, jobType, version, err)
+		}
+	}
+	fields["schema_version"] = json.RawMessage(fmt.Sprint(current))
+
+	upgraded, _ := json.Marshal(fields)
+	p := schema.decode()
+	if err := json.Unmarshal(upgraded, p); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	return string(upgraded), nil
+}
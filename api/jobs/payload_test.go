@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEncodePayload_StampsCurrentVersion(t *testing.T) {
+	got, err := EncodePayload("download", &DownloadPayload{URL: "https://x.com/v", SourceID: "s1", Platform: "x"})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	want := `{"platform":"x","schema_version":1,"source_id":"s1","url":"https://x.com/v"}`
+	if got != want {
+		t.Errorf("payload = %s, want %s", got, want)
+	}
+
+	if _, err := EncodePayload("download", &DownloadPayload{URL: "ftp://x.com/v", SourceID: "s1"}); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("ftp url: err = %v, want ErrInvalidPayload", err)
+	}
+}
+
+func TestNormalizePayload_UpgradesLegacyAndRejectsInvalid(t *testing.T) {
+	// Payloads queued before schema_version existed still parse.
+	got, err := NormalizePayload("download", `{"url":"http://x.com/a","source_id":"s1","platform":"direct"}`)
+	if err != nil {
+		t.Fatalf("legacy payload: %v", err)
+	}
+	if want := `{"platform":"direct","schema_version":1,"source_id":"s1","url":"http://x.com/a"}`; got != want {
+		t.Errorf("legacy payload = %s, want %s", got, want)
+	}
+
+	// Unknown fields survive the round trip.
+	got, err = NormalizePayload("download", `{"schema_version":1,"url":"http://x.com/a","source_id":"s1","hint":"keep"}`)
+	if err != nil || got != `{"hint":"keep","schema_version":1,"source_id":"s1","url":"http://x.com/a"}` {
+		t.Errorf("extra field: payload = %s err = %v", got, err)
+	}
+
+	for name, raw := range map[string]string{
+		"not an object":  `["x"]`,
+		"missing source": `{"url":"http://x.com/a"}`,
+		"bad url":        `{"url":"not a url","source_id":"s1"}`,
+		"future version": `{"schema_version":2,"url":"http://x.com/a","source_id":"s1"}`,
+		"string version": `{"schema_version":"1","url":"http://x.com/a","source_id":"s1"}`,
+		"bad strategy":   `{"url":"http://x.com/a","source_id":"s1","strategy":"sideways"}`,
+	} {
+		if _, err := NormalizePayload("download", raw); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("%s: err = %v, want ErrInvalidPayload", name, err)
+		}
+	}
+
+	if _, err := NormalizePayload("preview", `{"preview_id":"p1","clip_id":"c1","storage_key":"clips/c1/clip.mp4",
+		"output_key":"clips/c1/previews/p1.gif","start":2,"end":1,"format":"gif"}`); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("backwards preview range: err = %v, want ErrInvalidPayload", err)
+	}
+	if _, err := NormalizePayload("transcode_hls", `{"clip_id":"c1","storage_key":"clips/c1/clip.mp4"}`); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("transcode_hls without output_prefix: err = %v, want ErrInvalidPayload", err)
+	}
+
+	// Job types without a typed payload pass through, but must be objects.
+	if got, err := NormalizePayload("transcode", ""); err != nil || got != "{}" {
+		t.Errorf("untyped empty payload = %q err = %v, want {}", got, err)
+	}
+	if _, err := NormalizePayload("transcode", `"x"`); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("untyped string payload: err = %v, want ErrInvalidPayload", err)
+	}
+}
+
+func TestWithTraceparent_StampsTracedPayloads(t *testing.T) {
+	payload := `{"source_id":"s1"}`
+	if got := WithTraceparent(context.Background(), payload); got != payload {
+		t.Errorf("untraced payload = %s, want it unchanged", got)
+	}
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	want := `{"source_id":"s1","traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}`
+	if got := WithTraceparent(ctx, payload); got != want {
+		t.Errorf("traced payload = %s, want %s", got, want)
+	}
+	if got := WithTraceparent(ctx, "not json"); got != "not json" {
+		t.Errorf("invalid payload = %s, want it unchanged", got)
+	}
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidRejection is returned when a rejected job's result carries a
+// rejection that does not match the schema.
+var ErrInvalidRejection = errors.New("invalid rejection")
+
+// Rejection reason codes say why the worker decided a source was not worth
+// clipping.
+const (
+	// RejectTooLong: the source runs past the worker's duration limit.
+	RejectTooLong = "too_long"
+	// RejectNoSpeech: the source is almost entirely silent.
+	RejectNoSpeech = "no_speech"
+	// RejectDuplicate: the same video was already clipped from another
+	// source; DuplicateOf names one of its clips.
+	RejectDuplicate = "duplicate"
+	// RejectBlockedURL: the URL points somewhere the worker may not fetch.
+	RejectBlockedURL = "blocked_url"
+	// RejectOther covers rejections without a specific code, including
+	// those recorded before rejections were structured.
+	RejectOther = "other"
+)
+
+// RejectionCodes lists every reason code a worker may report.
+var RejectionCodes = []string{RejectTooLong, RejectNoSpeech, RejectDuplicate, RejectBlockedURL, RejectOther}
+
+// Rejection is the structured reason a job was rejected. Workers report it
+// under "rejection" in the job result, with the measurements behind the
+// decision in Metrics (e.g. duration_seconds, silence_ratio).
+type Rejection struct {
+	Code        string             `json:"code"`
+	Message     string             `json:"message,omitempty"`
+	Metrics     map[string]float64 `json:"metrics,omitempty"`
+	DuplicateOf string             `json:"duplicate_of,omitempty"`
+}
+
+// Validate checks the code is known and only duplicates name a clip.
+func (r *Rejection) Validate() error {
+	known := false
+	for _, c := range RejectionCodes {
+		known = known || r.Code == c
+	}
+	if !known {
+		return fmt.Errorf("%w: unknown code %q", ErrInvalidRejection, r.Code)
+	}
+	if r.DuplicateOf != "" && r.Code != RejectDuplicate {
+		return fmt.Errorf("%w: duplicate_of is only valid with code %s", ErrInvalidRejection, RejectDuplicate)
+	}
+	return nil
+}
+
+// ValidateRejectionResult checks the rejection in a job result, if it has
+// one.
+func ValidateRejectionResult(result string) error {
+	var r struct {
+		Rejection *Rejection `json:"rejection"`
+	}
+	if err := json.Unmarshal([]byte(result), &r); err != nil {
+		return fmt.Errorf("%w: result must be a JSON object", ErrInvalidRejection)
+	}
+	if r.Rejection == nil {
+		return nil
+	}
+	return r.Rejection.Validate()
+}
+
+// RejectionFromJob returns why a rejected job was rejected: the rejection
+// in its result, or for jobs rejected with only an error string, that
+// string under RejectOther.
+func RejectionFromJob(result, errMsg string) *Rejection {
+	var r struct {
+		Rejection *Rejection `json:"rejection"`
+	}
+	if json.Unmarshal([]byte(result), &r) == nil && r.Rejection != nil && r.Rejection.Code != "" {
+		if r.Rejection.Message == "" {
+			r.Rejection.Message = errMsg
+		}
+		return r.Rejection
+	}
+	return &Rejection{Code: RejectOther, Message: errMsg}
+}
@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Clip extraction strategies tell the worker how to cut a source into clips.
+const (
+	// StrategyAutoHighlight splits at natural pauses and, for long sources,
+	// keeps only the liveliest segments.
+	StrategyAutoHighlight = "auto-highlight"
+	// StrategyFull keeps the whole source as one clip, for sources that are
+	// already clip-sized such as shorts.
+	StrategyFull = "full"
+	// StrategyFixedInterval cuts back-to-back clips of the target length.
+	StrategyFixedInterval = "fixed-interval"
+	// StrategyChapters cuts along the source's chapter markers, falling back
+	// to auto-highlight when it has none.
+	StrategyChapters = "chapter-based"
+
+	DefaultClipStrategy = StrategyAutoHighlight
+)
+
+// ClipStrategies lists every strategy the worker understands.
+var ClipStrategies = []string{StrategyAutoHighlight, StrategyFull, StrategyFixedInterval, StrategyChapters}
+
+// ValidClipStrategy reports whether s is one of ClipStrategies.
+func ValidClipStrategy(s string) bool {
+	for _, v := range ClipStrategies {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClipStrategy returns requested when set, otherwise the instance
+// default for platform, otherwise DefaultClipStrategy.
+func ResolveClipStrategy(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, platform, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	var strategy string
+	if err := q.QueryRowContext(ctx,
+		`SELECT strategy FROM platform_clip_strategies WHERE platform = ?`, strings.ToLower(platform),
+	).Scan(&strategy); err == nil && ValidClipStrategy(strategy) {
+		return strategy
+	}
+	return DefaultClipStrategy
+}
@@ -0,0 +1,138 @@
+// Package library moves a clip library between instances. An export bundle
+// describes an instance's ready clips with their sources, topics,
+// transcripts, and embeddings, plus where to fetch each clip's media; an
+// import recreates them under fresh IDs, so communities can merge or
+// migrate libraries.
+package library
+
+import (
+	"context"
+	"io"
+
+	"clipfeed/db"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// BundleVersion is the bundle format this package writes and reads.
+const BundleVersion = 1
+
+// Bundle is an exported clip library.
+type Bundle struct {
+	Version  int    `json:"version"`
+	Instance string `json:"instance,omitempty"`
+	// BaseURL resolves relative object URLs, e.g. "/storage/clips/...".
+	BaseURL    string   `json:"base_url,omitempty"`
+	ExportedAt string   `json:"exported_at"`
+	Topics     []Topic  `json:"topics"`
+	Sources    []Source `json:"sources"`
+	Clips      []Clip   `json:"clips"`
+}
+
+// Topic is an exported topic. ParentID refers to another bundle topic.
+type Topic struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// Source is an exported source video.
+type Source struct {
+	ID              string  `json:"id"`
+	URL             string  `json:"url"`
+	Platform        string  `json:"platform"`
+	ExternalID      string  `json:"external_id,omitempty"`
+	Title           string  `json:"title,omitempty"`
+	ChannelName     string  `json:"channel_name,omitempty"`
+	ChannelID       string  `json:"channel_id,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// Clip is an exported clip. SourceID and the topic IDs refer to bundle
+// sources and topics.
+type Clip struct {
+	ID              string      `json:"id"`
+	SourceID        string      `json:"source_id,omitempty"`
+	Title           string      `json:"title"`
+	Description     string      `json:"description,omitempty"`
+	DurationSeconds float64     `json:"duration_seconds"`
+	StartTime       float64     `json:"start_time"`
+	EndTime         float64     `json:"end_time"`
+	Width           int         `json:"width,omitempty"`
+	Height          int         `json:"height,omitempty"`
+	FileSizeBytes   int64       `json:"file_size_bytes,omitempty"`
+	Language        string      `json:"language,omitempty"`
+	Tags            []string    `json:"tags,omitempty"`
+	ContentScore    float64     `json:"content_score"`
+	ExpiresAt       string      `json:"expires_at,omitempty"`
+	CreatedAt       string      `json:"created_at"`
+	Topics          []ClipTopic `json:"topics,omitempty"`
+	Transcript      string      `json:"transcript,omitempty"`
+	Embedding       *Embedding  `json:"embedding,omitempty"`
+	Media           Object      `json:"media"`
+	Thumbnail       *Object     `json:"thumbnail,omitempty"`
+}
+
+// ClipTopic links a clip to a bundle topic.
+type ClipTopic struct {
+	TopicID    string  `json:"topic_id"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Embedding carries a clip's embeddings as stored, base64 encoded in JSON.
+type Embedding struct {
+	Text         []byte `json:"text,omitempty"`
+	Visual       []byte `json:"visual,omitempty"`
+	ModelVersion string `json:"model_version,omitempty"`
+}
+
+// Object says where to get a stored object: either a URL to pull, possibly
+// presigned, or a bucket and key to copy from when both instances share
+// object storage.
+type Object struct {
+	URL    string `json:"url,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// ObjectStore is the storage imported media is written to.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Copy(ctx context.Context, srcBucket, srcKey, key string) error
+	Remove(ctx context.Context, key string) error
+}
+
+// MinioStore stores objects in a MinIO bucket.
+type MinioStore struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// Put uploads r under key.
+func (s MinioStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Copy copies srcBucket/srcKey to key server-side.
+func (s MinioStore) Copy(ctx context.Context, srcBucket, srcKey, key string) error {
+	_, err := s.Client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.Bucket, Object: key},
+		minio.CopySrcOptions{Bucket: srcBucket, Object: srcKey})
+	return err
+}
+
+// Remove deletes the object at key.
+func (s MinioStore) Remove(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+// Handler serves library export and import.
+type Handler struct {
+	DB          *db.CompatDB
+	Minio       *minio.Client
+	MinioBucket string
+	// Store receives imported media; import is unavailable without one.
+	Store ObjectStore
+}
@@ -0,0 +1,239 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/clips"
+	"clipfeed/httputil"
+	"clipfeed/settings"
+	"clipfeed/transcripts"
+)
+
+// mediaURLTTL is how long presigned media URLs in a bundle stay valid, which
+// bounds how long after an export its import can run.
+const mediaURLTTL = 24 * time.Hour
+
+// Media modes of an export.
+const (
+	// MediaURL points each object at a URL on this instance; clip media
+	// URLs are presigned.
+	MediaURL = "url"
+	// MediaCopy names each object's bucket and key, for importing into an
+	// instance that shares this one's object storage.
+	MediaCopy = "copy"
+)
+
+// Export builds a bundle of every ready clip. baseURL is recorded so the
+// importer can resolve the relative URLs of media mode MediaURL.
+func (h *Handler) Export(ctx context.Context, media, baseURL string) (*Bundle, error) {
+	b := &Bundle{
+		Version:    BundleVersion,
+		BaseURL:    baseURL,
+		ExportedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Topics:     make([]Topic, 0),
+		Sources:    make([]Source, 0),
+		Clips:      make([]Clip, 0),
+	}
+	if s, err := settings.Load(ctx, h.DB); err == nil {
+		b.Instance = s.InstanceName
+	}
+
+	if err := h.exportTopics(ctx, b); err != nil {
+		return nil, fmt.Errorf("export topics: %w", err)
+	}
+	if err := h.exportSources(ctx, b); err != nil {
+		return nil, fmt.Errorf("export sources: %w", err)
+	}
+	if err := h.exportClips(ctx, b, media); err != nil {
+		return nil, fmt.Errorf("export clips: %w", err)
+	}
+	return b, nil
+}
+
+func (h *Handler) exportTopics(ctx context.Context, b *Bundle) error {
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, name, slug, COALESCE(parent_id, '') FROM topics ORDER BY depth, name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.ParentID); err != nil {
+			return err
+		}
+		b.Topics = append(b.Topics, t)
+	}
+	return rows.Err()
+}
+
+func (h *Handler) exportSources(ctx context.Context, b *Bundle) error {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id, url, platform, COALESCE(external_id, ''), COALESCE(title, ''),
+		       COALESCE(channel_name, ''), COALESCE(channel_id, ''), COALESCE(duration_seconds, 0)
+		FROM sources
+		WHERE id IN (SELECT source_id FROM clips WHERE status = 'ready')
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Source
+		if err := rows.Scan(&s.ID, &s.URL, &s.Platform, &s.ExternalID, &s.Title,
+			&s.ChannelName, &s.ChannelID, &s.DurationSeconds); err != nil {
+			return err
+		}
+		b.Sources = append(b.Sources, s)
+	}
+	return rows.Err()
+}
+
+func (h *Handler) exportClips(ctx context.Context, b *Bundle, media string) error {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT c.id, COALESCE(c.source_id, ''), COALESCE(c.title, ''), COALESCE(c.description, ''),
+		       c.duration_seconds, COALESCE(c.start_time, 0), COALESCE(c.end_time, 0),
+		       COALESCE(c.width, 0), COALESCE(c.height, 0), COALESCE(c.file_size_bytes, 0),
+		       COALESCE(c.language, ''), COALESCE(c.tags, '[]'), COALESCE(c.content_score, 0.5),
+		       COALESCE(c.expires_at, ''), c.created_at, c.storage_key, COALESCE(c.thumbnail_key, ''),
+		       e.text_embedding, e.visual_embedding, e.model_version
+		FROM clips c
+		LEFT JOIN clip_embeddings e ON e.clip_id = c.id
+		WHERE c.status = 'ready'
+		ORDER BY c.created_at
+	`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c Clip
+		var tagsJSON, storageKey, thumbnailKey string
+		var textEmb, visualEmb []byte
+		var modelVersion sql.NullString
+		if err := rows.Scan(&c.ID, &c.SourceID, &c.Title, &c.Description,
+			&c.DurationSeconds, &c.StartTime, &c.EndTime,
+			&c.Width, &c.Height, &c.FileSizeBytes,
+			&c.Language, &tagsJSON, &c.ContentScore,
+			&c.ExpiresAt, &c.CreatedAt, &storageKey, &thumbnailKey,
+			&textEmb, &visualEmb, &modelVersion); err != nil {
+			rows.Close()
+			return err
+		}
+		json.Unmarshal([]byte(tagsJSON), &c.Tags)
+		if len(textEmb) > 0 || len(visualEmb) > 0 {
+			c.Embedding = &Embedding{Text: textEmb, Visual: visualEmb, ModelVersion: modelVersion.String}
+		}
+		c.Media = h.exportObject(ctx, media, storageKey, true)
+		if thumbnailKey != "" {
+			thumb := h.exportObject(ctx, media, thumbnailKey, false)
+			c.Thumbnail = &thumb
+		}
+		b.Clips = append(b.Clips, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Topics and transcripts are read once the clip query is closed, since
+	// SQLite runs on a single connection.
+	topics, err := h.clipTopics(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range b.Clips {
+		c := &b.Clips[i]
+		c.Topics = topics[c.ID]
+		if c.Transcript, err = transcripts.Load(ctx, h.DB, c.ID); err != nil {
+			return fmt.Errorf("load transcript of %s: %w", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// clipTopics returns the topics of every ready clip, keyed by clip ID.
+func (h *Handler) clipTopics(ctx context.Context) (map[string][]ClipTopic, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT ct.clip_id, ct.topic_id, ct.confidence
+		FROM clip_topics ct
+		JOIN clips c ON c.id = ct.clip_id
+		WHERE c.status = 'ready'
+		ORDER BY ct.clip_id, ct.confidence DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	topics := make(map[string][]ClipTopic)
+	for rows.Next() {
+		var clipID string
+		var t ClipTopic
+		if err := rows.Scan(&clipID, &t.TopicID, &t.Confidence); err != nil {
+			return nil, err
+		}
+		topics[clipID] = append(topics[clipID], t)
+	}
+	return topics, rows.Err()
+}
+
+// exportObject describes where an importer gets the object stored at key.
+// Media URLs are presigned; thumbnails are publicly served already.
+func (h *Handler) exportObject(ctx context.Context, media, key string, presign bool) Object {
+	if media == MediaCopy {
+		return Object{Bucket: h.MinioBucket, Key: key}
+	}
+	if !presign || h.Minio == nil {
+		return Object{URL: httputil.ThumbnailURL(h.MinioBucket, key)}
+	}
+	u, err := h.Minio.PresignedGetObject(ctx, h.MinioBucket, key, mediaURLTTL, nil)
+	if err != nil {
+		log.Printf("library export: presign %s: %v", key, err)
+		return Object{URL: httputil.ThumbnailURL(h.MinioBucket, key)}
+	}
+	path, err := clips.BuildBrowserStreamURL(u.String())
+	if err != nil {
+		return Object{URL: httputil.ThumbnailURL(h.MinioBucket, key)}
+	}
+	return Object{URL: path}
+}
+
+// HandleExport downloads a bundle of the instance's ready clips.
+// ?media=url (the default) references media by presigned URL, valid for
+// 24 hours; ?media=copy by bucket and key, for instances sharing storage.
+func (h *Handler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	media := r.URL.Query().Get("media")
+	if media == "" {
+		media = MediaURL
+	}
+	if media != MediaURL && media != MediaCopy {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "media must be url or copy"})
+		return
+	}
+
+	b, err := h.Export(r.Context(), media, requestBaseURL(r))
+	if err != nil {
+		log.Printf("library export: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to export library"})
+		return
+	}
+	filename := "clipfeed-library-" + time.Now().UTC().Format("20060102")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	httputil.WriteJSON(w, 200, b)
+}
+
+// requestBaseURL is the scheme and host the request reached this instance
+// at, honouring a TLS-terminating proxy.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
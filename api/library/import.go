@@ -0,0 +1,394 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"clipfeed/contentfilter"
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/transcripts"
+	"clipfeed/worker"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxBundleBytes caps the size of an uploaded bundle.
+	maxBundleBytes = 256 << 20
+
+	// maxImportMediaBytes caps the size of one pulled media object.
+	maxImportMediaBytes = 512 << 20
+)
+
+var mediaClient = &http.Client{Timeout: 10 * time.Minute}
+
+// Result summarises an import.
+type Result struct {
+	TopicsCreated  int         `json:"topics_created"`
+	TopicsMatched  int         `json:"topics_matched"`
+	SourcesCreated int         `json:"sources_created"`
+	SourcesMatched int         `json:"sources_matched"`
+	ClipsImported  int         `json:"clips_imported"`
+	ClipsDuplicate int         `json:"clips_duplicate"`
+	ClipsFailed    int         `json:"clips_failed"`
+	Errors         []ClipError `json:"errors"`
+	// ClipIDs maps bundle clip IDs to local ones, including duplicates of
+	// clips this instance already had.
+	ClipIDs map[string]string `json:"clip_ids"`
+}
+
+// ClipError reports a clip that could not be imported.
+type ClipError struct {
+	ClipID string `json:"clip_id"`
+	Error  string `json:"error"`
+}
+
+// Import recreates a bundle's clips under fresh IDs. Topics are matched to
+// existing ones by slug or name and sources by platform and external ID or
+// URL; anything unmatched is created. A clip already present, from the same
+// source with the same start and end, is mapped rather than copied. Each
+// clip's media is fetched before the clip is written, so a clip whose media
+// cannot be fetched is reported and skipped.
+func (h *Handler) Import(ctx context.Context, b *Bundle) (*Result, error) {
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	res := &Result{Errors: make([]ClipError, 0), ClipIDs: make(map[string]string)}
+
+	topicIDs, topicNames, err := h.importTopics(ctx, b.Topics, res)
+	if err != nil {
+		return nil, fmt.Errorf("import topics: %w", err)
+	}
+	sourceIDs, err := h.importSources(ctx, b.Sources, res)
+	if err != nil {
+		return nil, fmt.Errorf("import sources: %w", err)
+	}
+
+	for _, c := range b.Clips {
+		id, duplicate, err := h.importClip(ctx, b.BaseURL, c, topicIDs, topicNames, sourceIDs)
+		switch {
+		case err != nil:
+			res.ClipsFailed++
+			res.Errors = append(res.Errors, ClipError{ClipID: c.ID, Error: err.Error()})
+		case duplicate:
+			res.ClipsDuplicate++
+			res.ClipIDs[c.ID] = id
+		default:
+			res.ClipsImported++
+			res.ClipIDs[c.ID] = id
+		}
+	}
+	return res, nil
+}
+
+// importTopics maps every bundle topic to a local topic, creating missing
+// ones under their mapped parent. It returns the local IDs and names, keyed
+// by bundle topic ID.
+func (h *Handler) importTopics(ctx context.Context, topics []Topic, res *Result) (map[string]string, map[string]string, error) {
+	byID := make(map[string]Topic, len(topics))
+	for _, t := range topics {
+		byID[t.ID] = t
+	}
+	ids := make(map[string]string, len(topics))
+	names := make(map[string]string, len(topics))
+	visiting := make(map[string]bool)
+
+	var resolve func(t Topic) (string, error)
+	resolve = func(t Topic) (string, error) {
+		if id, ok := ids[t.ID]; ok {
+			return id, nil
+		}
+		if strings.TrimSpace(t.Name) == "" {
+			return "", nil
+		}
+		slug := t.Slug
+		if slug == "" {
+			slug = worker.Slugify(t.Name)
+		}
+
+		var id string
+		err := h.DB.QueryRowContext(ctx,
+			`SELECT id FROM topics WHERE slug = ? OR LOWER(name) = LOWER(?)`, slug, t.Name).Scan(&id)
+		if err == nil {
+			res.TopicsMatched++
+		} else {
+			// Parents are created first so the hierarchy survives. A
+			// cyclic parent chain is cut where it loops.
+			var parentID, path interface{} = nil, slug
+			depth := 0
+			if parent, ok := byID[t.ParentID]; ok && !visiting[t.ID] {
+				visiting[t.ID] = true
+				pid, err := resolve(parent)
+				delete(visiting, t.ID)
+				if err != nil {
+					return "", err
+				}
+				if pid != "" {
+					var parentPath string
+					var parentDepth int
+					if h.DB.QueryRowContext(ctx,
+						`SELECT path, depth FROM topics WHERE id = ?`, pid).Scan(&parentPath, &parentDepth) == nil {
+						parentID, path, depth = pid, parentPath+"/"+slug, parentDepth+1
+					}
+				}
+			}
+			id = uuid.New().String()
+			if _, err := h.DB.ExecContext(ctx,
+				`INSERT INTO topics (id, name, slug, path, depth, parent_id) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+				id, t.Name, slug, path, depth, parentID); err != nil {
+				return "", fmt.Errorf("create topic %s: %w", t.Name, err)
+			}
+			if err := h.DB.QueryRowContext(ctx, `SELECT id FROM topics WHERE slug = ?`, slug).Scan(&id); err != nil {
+				return "", fmt.Errorf("create topic %s: %w", t.Name, err)
+			}
+			res.TopicsCreated++
+		}
+		ids[t.ID] = id
+		names[t.ID] = t.Name
+		return id, nil
+	}
+
+	for _, t := range topics {
+		if _, err := resolve(t); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ids, names, nil
+}
+
+// importSources maps every bundle source to a local source, creating
+// missing ones as already processed.
+func (h *Handler) importSources(ctx context.Context, sources []Source, res *Result) (map[string]string, error) {
+	ids := make(map[string]string, len(sources))
+	for _, s := range sources {
+		if s.URL == "" || s.Platform == "" {
+			continue
+		}
+		var id string
+		var err error
+		if s.ExternalID != "" {
+			err = h.DB.QueryRowContext(ctx,
+				`SELECT id FROM sources WHERE platform = ? AND external_id = ?`, s.Platform, s.ExternalID).Scan(&id)
+		} else {
+			err = h.DB.QueryRowContext(ctx,
+				`SELECT id FROM sources WHERE url = ? ORDER BY created_at LIMIT 1`, s.URL).Scan(&id)
+		}
+		if err == nil {
+			res.SourcesMatched++
+			ids[s.ID] = id
+			continue
+		}
+
+		id = uuid.New().String()
+		if _, err := h.DB.ExecContext(ctx, `
+			INSERT INTO sources (id, url, platform, external_id, title, channel_name, channel_id, duration_seconds, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'complete')
+		`, id, s.URL, s.Platform, nullIfEmpty(s.ExternalID), nullIfEmpty(s.Title),
+			nullIfEmpty(s.ChannelName), nullIfEmpty(s.ChannelID), s.DurationSeconds); err != nil {
+			return nil, fmt.Errorf("create source %s: %w", s.URL, err)
+		}
+		res.SourcesCreated++
+		ids[s.ID] = id
+	}
+	return ids, nil
+}
+
+// importClip copies one clip's media and writes the clip. It returns the
+// local clip ID and whether the clip was already present.
+func (h *Handler) importClip(ctx context.Context, baseURL string, c Clip,
+	topicIDs, topicNames, sourceIDs map[string]string) (string, bool, error) {
+	sourceID, ok := sourceIDs[c.SourceID]
+	if c.SourceID != "" && !ok {
+		return "", false, errors.New("source missing from bundle")
+	}
+	if ok {
+		var existing string
+		if h.DB.QueryRowContext(ctx,
+			`SELECT id FROM clips WHERE source_id = ? AND start_time = ? AND end_time = ?`,
+			sourceID, c.StartTime, c.EndTime).Scan(&existing) == nil {
+			return existing, true, nil
+		}
+	}
+
+	id := uuid.New().String()
+	storageKey := "clips/" + id + "/clip.mp4"
+	if err := h.copyObject(ctx, baseURL, c.Media, storageKey, "video/mp4"); err != nil {
+		return "", false, fmt.Errorf("copy media: %w", err)
+	}
+	var thumbnailKey string
+	if c.Thumbnail != nil {
+		thumbnailKey = "clips/" + id + "/thumbnail.jpg"
+		if err := h.copyObject(ctx, baseURL, *c.Thumbnail, thumbnailKey, "image/jpeg"); err != nil {
+			log.Printf("library import: thumbnail of %s: %v", c.ID, err)
+			thumbnailKey = ""
+		}
+	}
+
+	var names []string
+	for _, t := range c.Topics {
+		if name, ok := topicNames[t.TopicID]; ok {
+			names = append(names, name)
+		}
+	}
+	topicsJSON, _ := json.Marshal(names)
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	tagsJSON, _ := json.Marshal(c.Tags)
+	if c.CreatedAt == "" {
+		c.CreatedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	}
+
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO clips (
+				id, source_id, title, description, duration_seconds, start_time, end_time,
+				storage_key, thumbnail_key, width, height, file_size_bytes, language,
+				topics, tags, content_score, expires_at, status, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'ready', ?)
+		`, id, nullIfEmpty(sourceID), c.Title, c.Description, c.DurationSeconds, c.StartTime, c.EndTime,
+			storageKey, nullIfEmpty(thumbnailKey), c.Width, c.Height, c.FileSizeBytes, nullIfEmpty(c.Language),
+			string(topicsJSON), string(tagsJSON), c.ContentScore, nullIfEmpty(c.ExpiresAt), c.CreatedAt,
+		); err != nil {
+			return fmt.Errorf("insert clip: %w", err)
+		}
+		if err := transcripts.Save(ctx, conn, id, c.Transcript); err != nil {
+			return err
+		}
+		if err := contentfilter.ScanClip(ctx, conn, id, c.Title, c.Transcript); err != nil {
+			return err
+		}
+		for _, t := range c.Topics {
+			topicID, ok := topicIDs[t.TopicID]
+			if !ok || topicID == "" {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO clip_topics (clip_id, topic_id, confidence, source) VALUES (?, ?, ?, 'import') ON CONFLICT DO NOTHING`,
+				id, topicID, t.Confidence); err != nil {
+				return fmt.Errorf("insert clip_topics: %w", err)
+			}
+		}
+
+		var platform, channelName string
+		if sourceID != "" {
+			conn.QueryRowContext(ctx,
+				`SELECT platform, COALESCE(channel_name, '') FROM sources WHERE id = ?`, sourceID).Scan(&platform, &channelName)
+		}
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO clips_fts(clip_id, title, transcript, platform, channel_name) VALUES (?, ?, ?, ?, ?)`,
+			id, c.Title, worker.Truncate(c.Transcript, 2000), platform, channelName); err != nil {
+			return fmt.Errorf("insert clips_fts: %w", err)
+		}
+
+		if e := c.Embedding; e != nil && (len(e.Text) > 0 || len(e.Visual) > 0) {
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO clip_embeddings (clip_id, text_embedding, visual_embedding, model_version) VALUES (?, ?, ?, ?)`,
+				id, e.Text, e.Visual, e.ModelVersion); err != nil {
+				return fmt.Errorf("insert clip_embeddings: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.removeObject(ctx, storageKey)
+		if thumbnailKey != "" {
+			h.removeObject(ctx, thumbnailKey)
+		}
+		return "", false, err
+	}
+	return id, false, nil
+}
+
+// removeObject deletes an object copied for a clip that was not written.
+func (h *Handler) removeObject(ctx context.Context, key string) {
+	if err := h.Store.Remove(ctx, key); err != nil {
+		log.Printf("library import: remove %s: %v", key, err)
+	}
+}
+
+// copyObject stores the object obj describes under key, pulling it by URL
+// or copying it from a shared bucket.
+func (h *Handler) copyObject(ctx context.Context, baseURL string, obj Object, key, contentType string) error {
+	if obj.Bucket != "" && obj.Key != "" {
+		return h.Store.Copy(ctx, obj.Bucket, obj.Key, key)
+	}
+	if obj.URL == "" {
+		return errors.New("no url or bucket and key")
+	}
+
+	target, err := url.Parse(obj.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if !target.IsAbs() {
+		base, err := url.Parse(baseURL)
+		if err != nil || !base.IsAbs() {
+			return errors.New("relative url without a bundle base_url")
+		}
+		target = base.ResolveReference(target)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q", target.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := mediaClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch returned %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxImportMediaBytes {
+		return fmt.Errorf("object too large (%d bytes)", resp.ContentLength)
+	}
+	return h.Store.Put(ctx, key, httputil.CappedReader(resp.Body, maxImportMediaBytes), resp.ContentLength, contentType)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// HandleImport imports an export bundle posted as the request body and
+// reports what was created, matched, and skipped.
+func (h *Handler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	if h.Store == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "object storage unavailable"})
+		return
+	}
+	var b Bundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleBytes)).Decode(&b); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid bundle"})
+		return
+	}
+	if b.Version != BundleVersion {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("unsupported bundle version %d", b.Version)})
+		return
+	}
+
+	res, err := h.Import(r.Context(), &b)
+	if err != nil {
+		log.Printf("library import: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to import library"})
+		return
+	}
+	log.Printf("library import: %d clips imported, %d duplicates, %d failed",
+		res.ClipsImported, res.ClipsDuplicate, res.ClipsFailed)
+	httputil.WriteJSON(w, 200, res)
+}
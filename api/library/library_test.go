@@ -0,0 +1,196 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clipfeed/db"
+	"clipfeed/transcripts"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+// memStore records the objects an import writes.
+type memStore struct {
+	objects map[string]string
+}
+
+func (s *memStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	b, err := io.ReadAll(r)
+	s.objects[key] = string(b)
+	return err
+}
+
+func (s *memStore) Copy(ctx context.Context, srcBucket, srcKey, key string) error {
+	s.objects[key] = srcBucket + "/" + srcKey
+	return nil
+}
+
+func (s *memStore) Remove(ctx context.Context, key string) error {
+	delete(s.objects, key)
+	return nil
+}
+
+// seedLibrary gives cdb a source with one ready clip under a child topic.
+func seedLibrary(t *testing.T, cdb *db.CompatDB) {
+	t.Helper()
+	for _, stmt := range []string{
+		`INSERT INTO topics (id, name, slug, path, depth) VALUES ('t-sci', 'Science', 'science', 'science', 0)`,
+		`INSERT INTO topics (id, name, slug, path, depth, parent_id) VALUES ('t-phys', 'Physics', 'physics', 'science/physics', 1, 't-sci')`,
+		`INSERT INTO sources (id, url, platform, external_id, channel_name, status) VALUES ('s1', 'https://youtu.be/abc', 'youtube', 'abc', 'Lab', 'complete')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, start_time, end_time, storage_key, thumbnail_key, topics, status)
+			VALUES ('c1', 's1', 'Pendulums', 42, 10, 52, 'clips/c1/clip.mp4', 'clips/c1/thumbnail.jpg', '["Physics"]', 'ready')`,
+		`INSERT INTO clip_topics (clip_id, topic_id, confidence) VALUES ('c1', 't-phys', 0.8)`,
+		`INSERT INTO clip_embeddings (clip_id, text_embedding, model_version) VALUES ('c1', X'0000803F', 'test')`,
+	} {
+		if _, err := cdb.Exec(stmt); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	if err := transcripts.Save(context.Background(), cdb, "c1", "a pendulum swings"); err != nil {
+		t.Fatalf("seed transcript: %v", err)
+	}
+}
+
+func TestExportImport_RemapsIDsAndSkipsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	src := newTestDB(t)
+	seedLibrary(t, src)
+	bundle, err := (&Handler{DB: src, MinioBucket: "clips"}).Export(ctx, MediaCopy, "")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(bundle.Clips) != 1 || len(bundle.Sources) != 1 || len(bundle.Topics) != 2 {
+		t.Fatalf("bundle = %d clips, %d sources, %d topics", len(bundle.Clips), len(bundle.Sources), len(bundle.Topics))
+	}
+
+	// Round-trip through JSON, as an upload would.
+	raw, _ := json.Marshal(bundle)
+	var b Bundle
+	if err := json.Unmarshal(raw, &b); err != nil {
+		t.Fatalf("decode bundle: %v", err)
+	}
+
+	dst := newTestDB(t)
+	dst.Exec(`INSERT INTO topics (id, name, slug, path, depth) VALUES ('local-sci', 'Science', 'science', 'science', 0)`)
+	store := &memStore{objects: map[string]string{}}
+	h := &Handler{DB: dst, MinioBucket: "clips", Store: store}
+	res, err := h.Import(ctx, &b)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res.ClipsImported != 1 || res.TopicsMatched != 1 || res.TopicsCreated != 1 || res.SourcesCreated != 1 {
+		t.Fatalf("result = %+v", res)
+	}
+	id := res.ClipIDs["c1"]
+	if id == "" || id == "c1" {
+		t.Fatalf("clip c1 mapped to %q, want a fresh ID", id)
+	}
+	if got := store.objects["clips/"+id+"/clip.mp4"]; got != "clips/clips/c1/clip.mp4" {
+		t.Errorf("media copied from %q", got)
+	}
+
+	var title, channel, storageKey string
+	dst.QueryRow(`SELECT c.title, s.channel_name, c.storage_key FROM clips c JOIN sources s ON s.id = c.source_id WHERE c.id = ?`, id).
+		Scan(&title, &channel, &storageKey)
+	if title != "Pendulums" || channel != "Lab" || storageKey != "clips/"+id+"/clip.mp4" {
+		t.Errorf("clip = %q %q %q", title, channel, storageKey)
+	}
+	var parentID string
+	dst.QueryRow(`SELECT t.parent_id FROM clip_topics ct JOIN topics t ON t.id = ct.topic_id WHERE ct.clip_id = ?`, id).Scan(&parentID)
+	if parentID != "local-sci" {
+		t.Errorf("imported topic parent = %q, want the existing Science topic", parentID)
+	}
+	if text, _ := transcripts.Load(ctx, dst, id); text != "a pendulum swings" {
+		t.Errorf("transcript = %q", text)
+	}
+	var emb []byte
+	dst.QueryRow(`SELECT text_embedding FROM clip_embeddings WHERE clip_id = ?`, id).Scan(&emb)
+	if len(emb) != 4 {
+		t.Errorf("embedding = %v", emb)
+	}
+
+	again, err := h.Import(ctx, &b)
+	if err != nil {
+		t.Fatalf("second import: %v", err)
+	}
+	if again.ClipsImported != 0 || again.ClipsDuplicate != 1 || again.ClipIDs["c1"] != id || again.SourcesMatched != 1 {
+		t.Errorf("second import = %+v, want c1 mapped to %s as a duplicate", again, id)
+	}
+}
+
+func TestImport_PullsMediaByURL(t *testing.T) {
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/storage/clips/missing.mp4" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "bytes of "+r.URL.Path)
+	}))
+	defer media.Close()
+
+	store := &memStore{objects: map[string]string{}}
+	h := &Handler{DB: newTestDB(t), Store: store}
+	res, err := h.Import(context.Background(), &Bundle{
+		Version: BundleVersion,
+		BaseURL: media.URL,
+		Clips: []Clip{
+			{ID: "ok", Title: "Pulled", DurationSeconds: 5, Media: Object{URL: "/storage/clips/ok.mp4"}},
+			{ID: "gone", Title: "Missing", DurationSeconds: 5, Media: Object{URL: "/storage/clips/missing.mp4"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res.ClipsImported != 1 || res.ClipsFailed != 1 || len(res.Errors) != 1 || res.Errors[0].ClipID != "gone" {
+		t.Fatalf("result = %+v", res)
+	}
+	if got := store.objects["clips/"+res.ClipIDs["ok"]+"/clip.mp4"]; got != "bytes of /storage/clips/ok.mp4" {
+		t.Errorf("stored media = %q", got)
+	}
+}
+
+func TestImport_RemovesMediaOfClipThatFailsToInsert(t *testing.T) {
+	cdb := newTestDB(t)
+	if _, err := cdb.Exec(`CREATE TRIGGER reject_clips BEFORE INSERT ON clips BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	store := &memStore{objects: map[string]string{}}
+	h := &Handler{DB: cdb, Store: store}
+	res, err := h.Import(context.Background(), &Bundle{
+		Version: BundleVersion,
+		Clips: []Clip{{
+			ID: "c1", Title: "Rejected", DurationSeconds: 5,
+			Media:     Object{Bucket: "clips", Key: "clips/c1/clip.mp4"},
+			Thumbnail: &Object{Bucket: "clips", Key: "clips/c1/thumbnail.jpg"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res.ClipsFailed != 1 {
+		t.Fatalf("result = %+v, want the clip to fail", res)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("objects left behind: %v", store.objects)
+	}
+}
@@ -0,0 +1,265 @@
+// Package licensing reports the licenses of ingested content and runs the
+// takedown workflow. Sources carry the uploader and license string their
+// platform reports and the submitter's consent flags; a takedown request
+// records a rights holder's claim against a source URL, and actioning it
+// removes every clip cut from that URL and blocks it from being ingested
+// again.
+package licensing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// UnknownLicense groups sources whose platform reported no license.
+const UnknownLicense = "unknown"
+
+// Handler serves the admin licensing endpoints.
+type Handler struct {
+	DB *db.CompatDB
+}
+
+// LicenseCount is how many ready clips, cut from how many sources, carry
+// one license.
+type LicenseCount struct {
+	License string `json:"license"`
+	Clips   int    `json:"clips"`
+	Sources int    `json:"sources"`
+}
+
+// HandleLicenseReport counts ready clips by their source's license, and
+// sources with ready clips by the consent their submitter gave.
+func (h *Handler) HandleLicenseReport(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT COALESCE(NULLIF(TRIM(s.license), ''), ?), COUNT(c.id), COUNT(DISTINCT s.id)
+		FROM clips c JOIN sources s ON s.id = c.source_id
+		WHERE c.status = 'ready'
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+	`, UnknownLicense)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build license report"})
+		return
+	}
+	licenses := make([]LicenseCount, 0)
+	total := 0
+	for rows.Next() {
+		var lc LicenseCount
+		if rows.Scan(&lc.License, &lc.Clips, &lc.Sources) == nil {
+			licenses = append(licenses, lc)
+			total += lc.Clips
+		}
+	}
+	rows.Close()
+
+	var uploader, permission, neither int
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT COALESCE(SUM(consent_is_uploader), 0),
+		       COALESCE(SUM(CASE WHEN consent_is_uploader = 0 AND consent_has_permission = 1 THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN consent_is_uploader = 0 AND consent_has_permission = 0 THEN 1 ELSE 0 END), 0)
+		FROM sources s
+		WHERE EXISTS (SELECT 1 FROM clips c WHERE c.source_id = s.id AND c.status = 'ready')
+	`).Scan(&uploader, &permission, &neither); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build license report"})
+		return
+	}
+
+	var pending int
+	h.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM takedown_requests WHERE status = 'received'`).Scan(&pending)
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"licenses":    licenses,
+		"total_clips": total,
+		"consent": map[string]int{
+			"uploader": uploader, "permission": permission, "none": neither,
+		},
+		"pending_takedowns": pending,
+	})
+}
+
+// HandleListTakedowns lists takedown requests, newest first, optionally
+// only those with ?status=.
+func (h *Handler) HandleListTakedowns(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT t.id, t.source_id, t.source_url, t.claimant_name, t.claimant_email, t.work, t.notes,
+		       t.status, t.clips_removed, t.resolution_note, t.received_at, t.resolved_at,
+		       s.uploader, s.license
+		FROM takedown_requests t LEFT JOIN sources s ON s.id = t.source_id`
+	var args []interface{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += ` WHERE t.status = ?`
+		args = append(args, status)
+	}
+	rows, err := h.DB.QueryContext(r.Context(), query+` ORDER BY t.received_at DESC, t.id LIMIT 200`, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list takedowns"})
+		return
+	}
+	defer rows.Close()
+	list := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, sourceURL, name, email, work, notes, status, receivedAt string
+		var sourceID, note, resolvedAt, uploader, license *string
+		var removed int
+		if err := rows.Scan(&id, &sourceID, &sourceURL, &name, &email, &work, &notes,
+			&status, &removed, &note, &receivedAt, &resolvedAt, &uploader, &license); err != nil {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"id": id, "source_id": sourceID, "source_url": sourceURL,
+			"claimant_name": name, "claimant_email": email, "work": work, "notes": notes,
+			"status": status, "clips_removed": removed, "resolution_note": note,
+			"received_at": receivedAt, "resolved_at": resolvedAt,
+			"uploader": uploader, "license": license,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"takedowns": list})
+}
+
+// HandleCreateTakedown records a takedown request against a source, given
+// by source_id or source_url. It takes nothing down until it is actioned.
+func (h *Handler) HandleCreateTakedown(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SourceID      string `json:"source_id"`
+		SourceURL     string `json:"source_url"`
+		ClaimantName  string `json:"claimant_name"`
+		ClaimantEmail string `json:"claimant_email"`
+		Work          string `json:"work"`
+		Notes         string `json:"notes"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.ClaimantName = strings.TrimSpace(req.ClaimantName)
+	req.Work = strings.TrimSpace(req.Work)
+	switch {
+	case req.ClaimantName == "" || req.Work == "":
+		httputil.WriteJSON(w, 400, map[string]string{"error": "claimant_name and work are required"})
+		return
+	case !strings.Contains(req.ClaimantEmail, "@"):
+		httputil.WriteJSON(w, 400, map[string]string{"error": "claimant_email must be an email address"})
+		return
+	case req.SourceID == "" && req.SourceURL == "":
+		httputil.WriteJSON(w, 400, map[string]string{"error": "source_id or source_url is required"})
+		return
+	}
+
+	var sourceID interface{}
+	sourceURL := req.SourceURL
+	if req.SourceID != "" {
+		if err := h.DB.QueryRowContext(r.Context(), `SELECT url FROM sources WHERE id = ?`, req.SourceID).Scan(&sourceURL); err != nil {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "source not found"})
+			return
+		}
+		sourceID = req.SourceID
+	} else {
+		var id string
+		if h.DB.QueryRowContext(r.Context(),
+			`SELECT id FROM sources WHERE url = ? ORDER BY created_at DESC LIMIT 1`, sourceURL).Scan(&id) == nil {
+			sourceID = id
+		}
+	}
+
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(r.Context(), `
+		INSERT INTO takedown_requests (id, source_id, source_url, claimant_name, claimant_email, work, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, sourceID, sourceURL, req.ClaimantName, req.ClaimantEmail, req.Work, req.Notes); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to record takedown"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id, "source_id": sourceID, "source_url": sourceURL, "status": "received"})
+}
+
+// HandleResolveTakedown resolves a received takedown request. action
+// take_down removes every clip cut from the request's URL, revoking their
+// outstanding proxy stream URLs; reject closes it with no change.
+func (h *Handler) HandleResolveTakedown(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Action != "take_down" && req.Action != "reject" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "action must be take_down or reject"})
+		return
+	}
+
+	removed, err := h.resolve(r.Context(), id, req.Action == "take_down", req.Note)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		httputil.WriteJSON(w, 404, map[string]string{"error": "takedown not found"})
+		return
+	case errors.Is(err, errResolved):
+		httputil.WriteJSON(w, 409, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("licensing: resolve takedown %s: %v", id, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to resolve takedown"})
+		return
+	}
+	status := "rejected"
+	if req.Action == "take_down" {
+		status = "actioned"
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"id": id, "status": status, "clips_removed": removed})
+}
+
+var errResolved = errors.New("takedown is already resolved")
+
+// resolve closes takedown id, taking its URL's clips down first when
+// takeDown is set, and returns how many clips were removed.
+func (h *Handler) resolve(ctx context.Context, id string, takeDown bool, note string) (int, error) {
+	removed := 0
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		var sourceURL, status string
+		if err := conn.QueryRowContext(ctx,
+			`SELECT source_url, status FROM takedown_requests WHERE id = ?`, id).Scan(&sourceURL, &status); err != nil {
+			return err
+		}
+		if status != "received" {
+			return fmt.Errorf("%w (%s)", errResolved, status)
+		}
+		newStatus := "rejected"
+		if takeDown {
+			newStatus = "actioned"
+			res, err := conn.ExecContext(ctx, `
+				UPDATE clips SET status = 'removed', stream_generation = stream_generation + 1
+				WHERE status != 'removed' AND source_id IN (SELECT id FROM sources WHERE url = ?)
+			`, sourceURL)
+			if err != nil {
+				return fmt.Errorf("remove clips: %w", err)
+			}
+			n, _ := res.RowsAffected()
+			removed = int(n)
+			if _, err := conn.ExecContext(ctx,
+				`UPDATE sources SET status = 'taken_down' WHERE url = ?`, sourceURL); err != nil {
+				return fmt.Errorf("mark sources: %w", err)
+			}
+		}
+		_, err := conn.ExecContext(ctx, `
+			UPDATE takedown_requests SET status = ?, clips_removed = ?, resolution_note = ?, resolved_at = ?
+			WHERE id = ?
+		`, newStatus, removed, note, time.Now().UTC().Format("2006-01-02T15:04:05Z"), id)
+		return err
+	})
+	return removed, err
+}
@@ -0,0 +1,383 @@
+// Package live pushes feed updates to signed-in clients over a WebSocket,
+// so they hear about new clips they will like without polling.
+package live
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/contentfilter"
+	"clipfeed/db"
+	"clipfeed/feed"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultBatchWindow is how long new clips are collected before they
+	// are pushed together.
+	DefaultBatchWindow = 30 * time.Second
+	// DefaultMinInterval is the least time between two pushes to a user.
+	DefaultMinInterval = 10 * time.Minute
+
+	authTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	scoreTimeout = 30 * time.Second
+
+	// maxPushClips is the most clip IDs one push lists; the count covers
+	// the rest.
+	maxPushClips = 20
+
+	// A clip suits a user when its topics score at least the user's
+	// thresholdPercentile topic affinity weight, and never less than
+	// minThreshold; 1 is the neutral weight.
+	thresholdPercentile = 0.75
+	minThreshold        = 1.2
+)
+
+// Message is one message on the sync socket. The server sends "ready"
+// once the client is signed in, "new_clips" with clips that suit the
+// user, "ping" to keep the connection open, and "pong" to answer a
+// client's "ping". The client signs in with an "auth" message carrying
+// its token, unless it sent an Authorization header with the upgrade.
+type Message struct {
+	Type    string   `json:"type"`
+	Token   string   `json:"token,omitempty"`
+	Count   int      `json:"count,omitempty"`
+	ClipIDs []string `json:"clip_ids,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+// Handler holds the sync sockets of signed-in users and batches the clips
+// pushed to them.
+type Handler struct {
+	DB   *db.CompatDB
+	Auth *auth.Handler
+	// BatchWindow is how long new clips are collected before a push; zero
+	// means DefaultBatchWindow.
+	BatchWindow time.Duration
+	// MinInterval is the least time between pushes to one user; zero
+	// means DefaultMinInterval.
+	MinInterval time.Duration
+
+	mu    sync.Mutex
+	users map[string]*userState
+}
+
+// userState is a user's open sockets and the clips waiting to be pushed
+// to them.
+type userState struct {
+	conns    map[*websocket.Conn]bool
+	pending  []string
+	count    int
+	timer    *time.Timer
+	lastSent time.Time
+}
+
+func (h *Handler) batchWindow() time.Duration {
+	if h.BatchWindow > 0 {
+		return h.BatchWindow
+	}
+	return DefaultBatchWindow
+}
+
+func (h *Handler) minInterval() time.Duration {
+	if h.MinInterval > 0 {
+		return h.MinInterval
+	}
+	return DefaultMinInterval
+}
+
+// HandleSync upgrades the request to the sync socket. Browsers cannot set
+// headers on a WebSocket, so without an Authorization header the first
+// message must be {"type": "auth", "token": ...}; the token never goes in
+// the URL, where it would be logged.
+func (h *Handler) HandleSync(w http.ResponseWriter, r *http.Request) {
+	websocket.Server{Handler: h.serve}.ServeHTTP(hijacker(w), r)
+}
+
+// hijacker unwraps middleware response writers down to one that can hand
+// over the connection.
+func hijacker(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		if _, ok := w.(http.Hijacker); ok {
+			return w
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return w
+		}
+		w = u.Unwrap()
+	}
+}
+
+func (h *Handler) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	userID := h.Auth.UserIDFromRequest(ws.Request())
+	if userID == "" {
+		userID = h.authenticate(ws)
+	}
+	if userID == "" {
+		send(ws, Message{Type: "error", Message: "unauthorized"})
+		return
+	}
+
+	h.register(userID, ws)
+	defer h.unregister(userID, ws)
+	if send(ws, Message{Type: "ready"}) != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if send(ws, Message{Type: "ping"}) != nil {
+					ws.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var msg Message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		if msg.Type == "ping" {
+			send(ws, Message{Type: "pong"})
+		}
+	}
+}
+
+// authenticate reads the client's auth message and returns the user its
+// token belongs to, or "".
+func (h *Handler) authenticate(ws *websocket.Conn) string {
+	ws.SetReadDeadline(time.Now().Add(authTimeout))
+	defer ws.SetReadDeadline(time.Time{})
+	var msg Message
+	if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Type != "auth" || msg.Token == "" {
+		return ""
+	}
+	r := ws.Request().Clone(ws.Request().Context())
+	r.Header.Set("Authorization", "Bearer "+msg.Token)
+	return h.Auth.UserIDFromRequest(r)
+}
+
+func send(ws *websocket.Conn, msg Message) error {
+	ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return websocket.JSON.Send(ws, msg)
+}
+
+func (h *Handler) register(userID string, ws *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.users == nil {
+		h.users = make(map[string]*userState)
+	}
+	u := h.users[userID]
+	if u == nil {
+		u = &userState{conns: make(map[*websocket.Conn]bool)}
+		h.users[userID] = u
+	}
+	u.conns[ws] = true
+}
+
+// unregister drops a closed socket. Once the user has none left, their
+// pending clips are dropped; their state is kept while a recent push
+// still rate limits them, so reconnecting does not skip the interval.
+func (h *Handler) unregister(userID string, ws *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u := h.users[userID]
+	if u == nil {
+		return
+	}
+	delete(u.conns, ws)
+	if len(u.conns) > 0 {
+		return
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+		u.timer = nil
+	}
+	u.pending, u.count = nil, 0
+	if time.Since(u.lastSent) >= h.minInterval() {
+		delete(h.users, userID)
+	}
+}
+
+// connectedUsers returns the users with an open socket.
+func (h *Handler) connectedUsers() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var ids []string
+	for id, u := range h.users {
+		if len(u.conns) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ClipCreated scores a new clip for every connected user in the
+// background and queues it for those it suits. It is meant to be called
+// from worker.Handler.OnClipCreated.
+func (h *Handler) ClipCreated(clipID string) {
+	users := h.connectedUsers()
+	if len(users) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), scoreTimeout)
+		defer cancel()
+		for _, userID := range users {
+			ok, err := h.suits(ctx, userID, clipID)
+			if err != nil {
+				log.Printf("live: score clip %s for %s: %v", clipID, userID, err)
+				continue
+			}
+			if ok {
+				h.queue(userID, clipID)
+			}
+		}
+	}()
+}
+
+// suits reports whether the user will like a new clip: its topics must
+// score at least the user's threshold, and it must pass their content
+// filters and snoozes. Users without topic affinities are never pushed to.
+func (h *Handler) suits(ctx context.Context, userID, clipID string) (bool, error) {
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT weight FROM user_topic_affinities WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, err
+	}
+	var weights []float64
+	for rows.Next() {
+		var w float64
+		if err := rows.Scan(&w); err != nil {
+			rows.Close()
+			return false, err
+		}
+		weights = append(weights, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(weights) == 0 {
+		return false, err
+	}
+
+	// The clip's score is the confidence-weighted mean of the user's
+	// weights for its topics, counting topics they have no affinity for
+	// as neutral.
+	var score float64
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(ct.confidence * COALESCE(a.weight, 1.0)) / NULLIF(SUM(ct.confidence), 0), 0)
+		FROM clip_topics ct
+		LEFT JOIN user_topic_affinities a ON a.topic_id = ct.topic_id AND a.user_id = ?
+		WHERE ct.clip_id = ?
+	`, userID, clipID).Scan(&score); err != nil {
+		return false, err
+	}
+	if score < threshold(weights) {
+		return false, nil
+	}
+
+	blocked, err := contentfilter.Blocked(ctx, h.DB, userID, []interface{}{clipID})
+	if err != nil || blocked[clipID] {
+		return false, err
+	}
+	var visible int
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM clips c
+		JOIN sources s ON c.source_id = s.id
+		WHERE c.id = ? AND c.status = 'ready'`+feed.SnoozeFilter(h.DB.NowUTC()),
+		clipID, userID, userID).Scan(&visible); err != nil {
+		return false, err
+	}
+	return visible > 0, nil
+}
+
+// threshold is the score a clip needs to suit a user with the given topic
+// affinity weights.
+func threshold(weights []float64) float64 {
+	sorted := append([]float64(nil), weights...)
+	sort.Float64s(sorted)
+	i := int(math.Ceil(thresholdPercentile*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return math.Max(minThreshold, sorted[i])
+}
+
+// queue adds a clip to the user's next push, scheduling it after the
+// batch window, or once the minimum interval since the last push has
+// passed if that is later.
+func (h *Handler) queue(userID, clipID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u := h.users[userID]
+	if u == nil || len(u.conns) == 0 {
+		return
+	}
+	for _, id := range u.pending {
+		if id == clipID {
+			return
+		}
+	}
+	if len(u.pending) < maxPushClips {
+		u.pending = append(u.pending, clipID)
+	}
+	u.count++
+	if u.timer == nil {
+		delay := h.batchWindow()
+		if wait := time.Until(u.lastSent.Add(h.minInterval())); wait > delay {
+			delay = wait
+		}
+		u.timer = time.AfterFunc(delay, func() { h.flush(userID) })
+	}
+}
+
+// flush pushes the user's pending clips to every socket they have open.
+func (h *Handler) flush(userID string) {
+	h.mu.Lock()
+	u := h.users[userID]
+	if u == nil || u.count == 0 {
+		h.mu.Unlock()
+		return
+	}
+	msg := Message{Type: "new_clips", Count: u.count, ClipIDs: u.pending}
+	if u.count == 1 {
+		msg.Message = "1 new clip you'll like"
+	} else {
+		msg.Message = fmt.Sprintf("%d new clips you'll like", u.count)
+	}
+	u.pending, u.count, u.timer = nil, 0, nil
+	u.lastSent = time.Now()
+	conns := make([]*websocket.Conn, 0, len(u.conns))
+	for ws := range u.conns {
+		conns = append(conns, ws)
+	}
+	h.mu.Unlock()
+
+	for _, ws := range conns {
+		if err := send(ws, msg); err != nil {
+			ws.Close()
+		}
+	}
+}
@@ -0,0 +1,422 @@
+// Package llmpool spreads Ollama requests over a pool of endpoints. Each
+// endpoint is health checked in the background, which also discovers the
+// models it has pulled; requests for a task go to the least busy endpoint
+// that serves the task and has its model, failing over to the next one.
+// An endpoint that fails repeatedly is skipped for a cooldown (its circuit
+// opens) and then trusted with a single trial request.
+package llmpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clipfeed/httputil"
+)
+
+// Task is a kind of LLM request, routed to the endpoints that serve it.
+type Task string
+
+const (
+	TaskSummary   Task = "summary"
+	TaskScoring   Task = "scoring"
+	TaskEmbedding Task = "embedding"
+)
+
+var tasks = []Task{TaskSummary, TaskScoring, TaskEmbedding}
+
+const (
+	// DefaultFailureThreshold is how many failures in a row open an
+	// endpoint's circuit.
+	DefaultFailureThreshold = 3
+	// DefaultCooldown is how long a circuit first stays open. It doubles
+	// each time a trial request fails, up to maxCooldown.
+	DefaultCooldown = 30 * time.Second
+	// DefaultHealthInterval is how often endpoints are health checked.
+	DefaultHealthInterval = 30 * time.Second
+
+	maxCooldown   = 10 * time.Minute
+	healthTimeout = 5 * time.Second
+)
+
+// ErrNoEndpoint is returned when no endpoint can take a task's request.
+var ErrNoEndpoint = errors.New("no LLM endpoint available")
+
+// Endpoint is one Ollama server in the pool.
+type Endpoint struct {
+	URL string
+	// Tasks limits the endpoint to some tasks; empty means all of them.
+	Tasks []Task
+
+	mu         sync.Mutex
+	checked    bool
+	healthy    bool
+	models     map[string]bool
+	checkedAt  time.Time
+	lastError  string
+	failures   int
+	cooldown   time.Duration
+	openUntil  time.Time
+	trial      bool
+	inFlight   int
+	requests   int64
+	errorCount int64
+}
+
+// serves reports whether the endpoint takes requests for the task.
+func (e *Endpoint) serves(task Task) bool {
+	if len(e.Tasks) == 0 {
+		return true
+	}
+	for _, t := range e.Tasks {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
+
+// hasModel reports whether the endpoint has the model pulled. Before the
+// first health check every model is assumed present.
+func (e *Endpoint) hasModel(model string) bool {
+	return !e.checked || model == "" || e.models[normalizeModel(model)]
+}
+
+// normalizeModel drops Ollama's implicit ":latest" tag so "all-minilm"
+// matches "all-minilm:latest".
+func normalizeModel(name string) string {
+	return strings.TrimSuffix(strings.TrimSpace(name), ":latest")
+}
+
+// circuit is "closed" while the endpoint takes requests, "open" while it
+// is skipped after failing, and "half_open" once its cooldown is over and
+// a trial request may go through.
+func (e *Endpoint) circuit(now time.Time) string {
+	switch {
+	case e.openUntil.IsZero():
+		return "closed"
+	case now.Before(e.openUntil):
+		return "open"
+	default:
+		return "half_open"
+	}
+}
+
+// Pool routes requests over its endpoints.
+type Pool struct {
+	Endpoints []*Endpoint
+	// Models names the model each task uses; endpoints without it are
+	// not sent the task.
+	Models map[Task]string
+	Client *http.Client
+	// FailureThreshold and Cooldown tune circuit breaking; zero means
+	// DefaultFailureThreshold and DefaultCooldown.
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mu   sync.Mutex
+	next int
+}
+
+// ParseEndpoints reads a comma-separated list of endpoint URLs, each
+// optionally followed by "=" and the "+"-separated tasks it serves:
+// "http://gpu:11434=summary+scoring, http://cpu:11434=embedding".
+func ParseEndpoints(spec string) ([]*Endpoint, error) {
+	var endpoints []*Endpoint
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		url, taskList, _ := strings.Cut(item, "=")
+		e := &Endpoint{URL: strings.TrimRight(strings.TrimSpace(url), "/")}
+		if !strings.HasPrefix(e.URL, "http://") && !strings.HasPrefix(e.URL, "https://") {
+			return nil, fmt.Errorf("endpoint %q: url must start with http:// or https://", item)
+		}
+		for _, name := range strings.Split(taskList, "+") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			task := Task(name)
+			if !validTask(task) {
+				return nil, fmt.Errorf("endpoint %q: unknown task %q (want summary, scoring, or embedding)", item, name)
+			}
+			e.Tasks = append(e.Tasks, task)
+		}
+		endpoints = append(endpoints, e)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoints given")
+	}
+	return endpoints, nil
+}
+
+func validTask(task Task) bool {
+	for _, t := range tasks {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+func (p *Pool) failureThreshold() int {
+	if p.FailureThreshold > 0 {
+		return p.FailureThreshold
+	}
+	return DefaultFailureThreshold
+}
+
+func (p *Pool) cooldown() time.Duration {
+	if p.Cooldown > 0 {
+		return p.Cooldown
+	}
+	return DefaultCooldown
+}
+
+// Do runs fn against the endpoints that can take the task, least busy
+// first, until one succeeds. fn gets the endpoint's base URL. Each failure
+// counts against its endpoint's circuit, except when ctx was canceled by
+// the caller. It returns ErrNoEndpoint when no endpoint could be tried.
+func (p *Pool) Do(ctx context.Context, task Task, fn func(ctx context.Context, baseURL string) error) error {
+	tried := make(map[*Endpoint]bool)
+	var lastErr error
+	for {
+		e, trial := p.acquire(task, tried)
+		if e == nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return ErrNoEndpoint
+		}
+		tried[e] = true
+		err := fn(ctx, e.URL)
+		p.release(e, trial, err, errors.Is(ctx.Err(), context.Canceled))
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		log.Printf("[LLM] endpoint %s failed %s request: %v", e.URL, task, err)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// acquire picks the least busy endpoint for the task that was not tried
+// yet, rotating among equally busy ones, and marks a request in flight.
+// trial is set when the request is the one let through a half-open
+// circuit.
+func (p *Pool) acquire(task Task, tried map[*Endpoint]bool) (e *Endpoint, trial bool) {
+	p.mu.Lock()
+	start := p.next
+	p.next++
+	p.mu.Unlock()
+
+	now := time.Now()
+	model := p.Models[task]
+	var best *Endpoint
+	bestLoad := 0
+	for i := range p.Endpoints {
+		e = p.Endpoints[(start+i)%len(p.Endpoints)]
+		if tried[e] || !e.serves(task) {
+			continue
+		}
+		e.mu.Lock()
+		usable := (!e.checked || e.healthy) && e.hasModel(model)
+		switch e.circuit(now) {
+		case "open":
+			usable = false
+		case "half_open":
+			usable = usable && !e.trial
+		}
+		load := e.inFlight
+		e.mu.Unlock()
+		if usable && (best == nil || load < bestLoad) {
+			best, bestLoad = e, load
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	best.mu.Lock()
+	defer best.mu.Unlock()
+	if best.circuit(now) == "half_open" {
+		if best.trial {
+			return nil, false
+		}
+		best.trial, trial = true, true
+	}
+	best.inFlight++
+	best.requests++
+	return best, trial
+}
+
+// release records how a request went. Enough failures in a row open the
+// circuit; a failed trial reopens it for twice as long.
+func (p *Pool) release(e *Endpoint, trial bool, err error, canceled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inFlight--
+	if trial {
+		e.trial = false
+	}
+	if err == nil {
+		e.failures, e.cooldown, e.openUntil = 0, 0, time.Time{}
+		return
+	}
+	if canceled {
+		return
+	}
+	e.errorCount++
+	e.failures++
+	e.lastError = err.Error()
+	if trial || e.failures >= p.failureThreshold() {
+		if e.cooldown == 0 {
+			e.cooldown = p.cooldown()
+		} else if trial {
+			e.cooldown = min(2*e.cooldown, maxCooldown)
+		}
+		e.openUntil = time.Now().Add(e.cooldown)
+		log.Printf("[LLM] endpoint %s circuit open for %v after %d failures", e.URL, e.cooldown, e.failures)
+	}
+}
+
+// CheckHealth lists the models of every endpoint with Ollama's /api/tags.
+// An endpoint that does not answer is skipped until it does.
+func (p *Pool) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range p.Endpoints {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			models, err := p.listModels(ctx, e.URL)
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if e.healthy && err != nil {
+				log.Printf("[LLM] endpoint %s unhealthy: %v", e.URL, err)
+			}
+			e.checked, e.checkedAt = true, time.Now()
+			e.healthy = err == nil
+			if err != nil {
+				e.lastError = err.Error()
+				return
+			}
+			e.models = models
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) listModels(ctx context.Context, baseURL string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("status=%d", resp.StatusCode)
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tags); err != nil {
+		return nil, err
+	}
+	models := make(map[string]bool, len(tags.Models))
+	for _, m := range tags.Models {
+		models[normalizeModel(m.Name)] = true
+	}
+	return models, nil
+}
+
+// HealthLoop checks the endpoints now and then every interval; zero means
+// DefaultHealthInterval.
+func (p *Pool) HealthLoop(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	for {
+		p.CheckHealth(context.Background())
+		time.Sleep(interval)
+	}
+}
+
+// EndpointStatus is an endpoint's state as reported to admins.
+type EndpointStatus struct {
+	URL       string   `json:"url"`
+	Tasks     []Task   `json:"tasks"`
+	Healthy   *bool    `json:"healthy"`
+	Models    []string `json:"models"`
+	Circuit   string   `json:"circuit"`
+	OpenUntil *string  `json:"open_until"`
+	InFlight  int      `json:"in_flight"`
+	Requests  int64    `json:"requests"`
+	Errors    int64    `json:"errors"`
+	LastError string   `json:"last_error,omitempty"`
+	CheckedAt *string  `json:"checked_at"`
+}
+
+// Status reports every endpoint's health, models, and circuit.
+func (p *Pool) Status() []EndpointStatus {
+	now := time.Now()
+	out := make([]EndpointStatus, 0, len(p.Endpoints))
+	for _, e := range p.Endpoints {
+		e.mu.Lock()
+		s := EndpointStatus{
+			URL: e.URL, Tasks: e.Tasks, Circuit: e.circuit(now), Models: make([]string, 0, len(e.models)),
+			InFlight: e.inFlight, Requests: e.requests, Errors: e.errorCount, LastError: e.lastError,
+		}
+		if len(s.Tasks) == 0 {
+			s.Tasks = tasks
+		}
+		if e.checked {
+			healthy := e.healthy
+			checkedAt := e.checkedAt.UTC().Format("2006-01-02T15:04:05Z")
+			s.Healthy, s.CheckedAt = &healthy, &checkedAt
+		}
+		if s.Circuit == "open" {
+			until := e.openUntil.UTC().Format("2006-01-02T15:04:05Z")
+			s.OpenUntil = &until
+		}
+		for m := range e.models {
+			s.Models = append(s.Models, m)
+		}
+		e.mu.Unlock()
+		sort.Strings(s.Models)
+		out = append(out, s)
+	}
+	return out
+}
+
+// HandleStatus lists the pool's endpoints and the model each task uses.
+func (p *Pool) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"endpoints": p.Status(),
+		"models":    p.Models,
+	})
+}
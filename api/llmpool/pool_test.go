@@ -0,0 +1,150 @@
+package llmpool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ollamaServer answers /api/tags with the given models and counts the
+// other requests it gets; fail makes those fail.
+func ollamaServer(t *testing.T, models []string, fail *atomic.Bool) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			body := `{"models":[`
+			for i, m := range models {
+				if i > 0 {
+					body += ","
+				}
+				body += `{"name":"` + m + `"}`
+			}
+			w.Write([]byte(body + `]}`))
+			return
+		}
+		hits.Add(1)
+		if fail != nil && fail.Load() {
+			http.Error(w, "boom", 500)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func call(ctx context.Context, baseURL string) error {
+	resp, err := http.Get(baseURL + "/api/generate")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := ParseEndpoints(" http://gpu:11434/=summary+scoring , http://cpu:11434 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 2 || endpoints[0].URL != "http://gpu:11434" || len(endpoints[0].Tasks) != 2 || len(endpoints[1].Tasks) != 0 {
+		t.Errorf("endpoints = %+v, want gpu for summary and scoring and cpu for all", endpoints)
+	}
+	for _, spec := range []string{"", "gpu:11434", "http://gpu:11434=chat"} {
+		if _, err := ParseEndpoints(spec); err == nil {
+			t.Errorf("ParseEndpoints(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestPool_RoutesByTaskAndModel(t *testing.T) {
+	chat, chatHits := ollamaServer(t, []string{"llama3.2:3b"}, nil)
+	embed, embedHits := ollamaServer(t, []string{"all-minilm:latest"}, nil)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	endpoints, _ := ParseEndpoints(chat.URL + "," + embed.URL + "=embedding," + down.URL)
+	p := &Pool{Endpoints: endpoints, Models: map[Task]string{
+		TaskSummary: "llama3.2:3b", TaskEmbedding: "all-minilm",
+	}}
+	p.CheckHealth(context.Background())
+
+	for i := 0; i < 4; i++ {
+		if err := p.Do(context.Background(), TaskSummary, call); err != nil {
+			t.Fatalf("summary: %v", err)
+		}
+		if err := p.Do(context.Background(), TaskEmbedding, call); err != nil {
+			t.Fatalf("embedding: %v", err)
+		}
+	}
+	if chatHits.Load() != 4 || embedHits.Load() != 4 {
+		t.Errorf("hits = %d chat, %d embed; want each task on the endpoint with its model", chatHits.Load(), embedHits.Load())
+	}
+
+	status := p.Status()
+	if *status[2].Healthy || !*status[0].Healthy || status[1].Models[0] != "all-minilm" {
+		t.Errorf("status = %+v, want the closed server unhealthy and models discovered", status)
+	}
+
+	// No endpoint has the scoring model.
+	p.Models[TaskScoring] = "mistral"
+	if err := p.Do(context.Background(), TaskScoring, call); !errors.Is(err, ErrNoEndpoint) {
+		t.Errorf("scoring without its model: err = %v, want ErrNoEndpoint", err)
+	}
+}
+
+func TestPool_CircuitBreaksAndRecovers(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	flaky, flakyHits := ollamaServer(t, nil, &failing)
+	good, goodHits := ollamaServer(t, nil, nil)
+	endpoints, _ := ParseEndpoints(flaky.URL + "," + good.URL)
+	p := &Pool{Endpoints: endpoints, FailureThreshold: 2, Cooldown: 50 * time.Millisecond}
+
+	// Requests fail over to the good endpoint until the flaky one's circuit
+	// opens, and then skip it.
+	for i := 0; i < 10; i++ {
+		if err := p.Do(context.Background(), TaskSummary, call); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if flakyHits.Load() != 2 || goodHits.Load() != 10 {
+		t.Errorf("hits = %d flaky, %d good; want the flaky endpoint tried twice", flakyHits.Load(), goodHits.Load())
+	}
+	if s := p.Status()[0]; s.Circuit != "open" || s.Errors != 2 || s.OpenUntil == nil {
+		t.Errorf("flaky status = %+v, want an open circuit after 2 errors", s)
+	}
+
+	// After the cooldown one trial goes through; it fails and the circuit
+	// reopens for longer.
+	time.Sleep(60 * time.Millisecond)
+	if p.Status()[0].Circuit != "half_open" {
+		t.Fatalf("circuit = %s after cooldown, want half_open", p.Status()[0].Circuit)
+	}
+	for i := 0; i < 3; i++ {
+		p.Do(context.Background(), TaskSummary, call)
+	}
+	if flakyHits.Load() != 3 {
+		t.Errorf("flaky hits = %d, want one trial", flakyHits.Load())
+	}
+	if e := p.Endpoints[0]; e.cooldown != 100*time.Millisecond {
+		t.Errorf("cooldown = %v, want it doubled", e.cooldown)
+	}
+
+	// Once the endpoint recovers, a successful trial closes the circuit.
+	failing.Store(false)
+	time.Sleep(110 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		if err := p.Do(context.Background(), TaskSummary, call); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := p.Status()[0]; s.Circuit != "closed" || flakyHits.Load() < 5 {
+		t.Errorf("flaky status = %+v with %d hits, want closed and in rotation again", s, flakyHits.Load())
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"clipfeed/devdata"
+)
+
+// latencyStats summarises request latencies for one endpoint.
+type latencyStats struct {
+	Endpoint string  `json:"endpoint"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	RPS      float64 `json:"rps"`
+	P50ms    float64 `json:"p50_ms"`
+	P95ms    float64 `json:"p95_ms"`
+	P99ms    float64 `json:"p99_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+// percentile returns the nearest-rank percentile of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+// summarizeLatencies computes latency percentiles over samples, sorting them
+// in place.
+func summarizeLatencies(endpoint string, samples []time.Duration, errors int, elapsed time.Duration) latencyStats {
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	st := latencyStats{Endpoint: endpoint, Requests: len(samples), Errors: errors}
+	if elapsed > 0 {
+		st.RPS = float64(len(samples)) / elapsed.Seconds()
+	}
+	if len(samples) > 0 {
+		st.P50ms = ms(percentile(samples, 50))
+		st.P95ms = ms(percentile(samples, 95))
+		st.P99ms = ms(percentile(samples, 99))
+		st.MaxMs = ms(samples[len(samples)-1])
+	}
+	return st
+}
+
+// loadTarget builds request URLs for one endpoint under test.
+type loadTarget struct {
+	name string
+	url  func(rng *rand.Rand) string
+}
+
+// loadResult is one completed request.
+type loadResult struct {
+	target  int
+	latency time.Duration
+	failed  bool
+}
+
+// cmdLoadTest drives a running server with a fixed request rate or
+// concurrency and reports latency percentiles per endpoint.
+func cmdLoadTest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the API under test")
+	duration := fs.Duration("duration", 30*time.Second, "how long to generate load")
+	concurrency := fs.Int("concurrency", 8, "number of concurrent workers")
+	rate := fs.Int("rate", 0, "total requests per second across workers (0 = as fast as possible)")
+	endpoints := fs.String("endpoints", "feed,search,similar", "comma-separated endpoints: feed, search, similar")
+	users := fs.Int("users", 10, "number of synthetic users to log in as for personalised feeds (0 = anonymous)")
+	seed := fs.Int64("seed", 1, "random seed for query and clip selection")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *concurrency <= 0 || *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "concurrency and duration must be positive")
+		return 2
+	}
+	base := strings.TrimRight(*target, "/")
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	resp, err := client.Get(base + "/health")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "target not reachable: %v\n", err)
+		return 1
+	}
+	resp.Body.Close()
+
+	tokens := loginSyntheticUsers(client, base, *users)
+	if *users > 0 && len(tokens) == 0 {
+		log.Printf("loadtest: no synthetic users could log in; feed requests will be anonymous (run gen-dataset first)")
+	}
+	clipIDs := sampleClipIDs(client, base)
+
+	terms := devdata.SearchTerms()
+	var targets []loadTarget
+	for _, name := range strings.Split(*endpoints, ",") {
+		switch strings.TrimSpace(name) {
+		case "feed":
+			targets = append(targets, loadTarget{name: "/api/feed", url: func(*rand.Rand) string { return base + "/api/feed" }})
+		case "search":
+			targets = append(targets, loadTarget{name: "/api/search", url: func(rng *rand.Rand) string {
+				return base + "/api/search?q=" + url.QueryEscape(terms[rng.Intn(len(terms))])
+			}})
+		case "similar":
+			if len(clipIDs) == 0 {
+				log.Printf("loadtest: no clips found; skipping /api/clips/{id}/similar")
+				continue
+			}
+			targets = append(targets, loadTarget{name: "/api/clips/{id}/similar", url: func(rng *rand.Rand) string {
+				return base + "/api/clips/" + clipIDs[rng.Intn(len(clipIDs))] + "/similar"
+			}})
+		default:
+			fmt.Fprintf(os.Stderr, "unknown endpoint %q\n", name)
+			return 2
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "no endpoints to test")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	// With a fixed rate, a single pacer hands out request slots so the
+	// offered load does not depend on how fast the server responds.
+	var pace <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(*rate))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	results := make(chan loadResult, 1024)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(*seed + int64(w)))
+			for i := w; ; i++ {
+				if pace != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-pace:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+				t := i % len(targets)
+				req, _ := http.NewRequestWithContext(ctx, http.MethodGet, targets[t].url(rng), nil)
+				if targets[t].name == "/api/feed" && len(tokens) > 0 {
+					req.Header.Set("Authorization", "Bearer "+tokens[rng.Intn(len(tokens))])
+				}
+				began := time.Now()
+				resp, err := client.Do(req)
+				if ctx.Err() != nil {
+					return // cut off by the deadline; not a server error
+				}
+				failed := err != nil
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					failed = resp.StatusCode >= 400
+				}
+				results <- loadResult{target: t, latency: time.Since(began), failed: failed}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	samples := make([][]time.Duration, len(targets))
+	errors := make([]int, len(targets))
+	for res := range results {
+		samples[res.target] = append(samples[res.target], res.latency)
+		if res.failed {
+			errors[res.target]++
+		}
+	}
+	elapsed := time.Since(start)
+
+	stats := make([]latencyStats, len(targets))
+	for i, t := range targets {
+		stats[i] = summarizeLatencies(t.name, samples[i], errors[i], elapsed)
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"target": base, "duration_s": elapsed.Seconds(), "concurrency": *concurrency,
+			"rate": *rate, "endpoints": stats,
+		})
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "endpoint\trequests\terrors\trps\tp50 ms\tp95 ms\tp99 ms\tmax ms\t")
+	for _, st := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			st.Endpoint, st.Requests, st.Errors, st.RPS, st.P50ms, st.P95ms, st.P99ms, st.MaxMs)
+	}
+	tw.Flush()
+	return 0
+}
+
+// loginSyntheticUsers logs in as the first n gen-dataset users and returns
+// their tokens.
+func loginSyntheticUsers(client *http.Client, base string, n int) []string {
+	var tokens []string
+	for i := 0; i < n; i++ {
+		body, _ := json.Marshal(map[string]string{"username": devdata.Username(i), "password": devdata.Password})
+		resp, err := client.Post(base+"/api/auth/login", "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("loadtest: login: %v", err)
+			return tokens
+		}
+		var out struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || out.Token == "" {
+			break
+		}
+		tokens = append(tokens, out.Token)
+	}
+	return tokens
+}
+
+// sampleClipIDs collects clip IDs from a few search pages to use as seeds for
+// similar-clip requests.
+func sampleClipIDs(client *http.Client, base string) []string {
+	var ids []string
+	for _, term := range devdata.SearchTerms() {
+		resp, err := client.Get(base + "/api/search?q=" + url.QueryEscape(term))
+		if err != nil {
+			return ids
+		}
+		var out struct {
+			Hits []struct {
+				ID string `json:"id"`
+			} `json:"hits"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		for _, hit := range out.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if len(ids) >= 200 {
+			break
+		}
+	}
+	return ids
+}
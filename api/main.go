@@ -5,26 +5,53 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"clipfeed/admin"
+	"clipfeed/affinity"
 	"clipfeed/auth"
+	"clipfeed/backup"
 	"clipfeed/clips"
 	"clipfeed/collections"
+	"clipfeed/contentfilter"
+	"clipfeed/datasaver"
 	"clipfeed/db"
+	"clipfeed/federation"
 	"clipfeed/feed"
 	"clipfeed/httputil"
+	"clipfeed/images"
 	"clipfeed/ingest"
+	"clipfeed/integrations"
+	"clipfeed/invites"
 	"clipfeed/jobs"
+	"clipfeed/library"
+	"clipfeed/licensing"
+	"clipfeed/live"
+	"clipfeed/llmpool"
+	"clipfeed/maintenance"
+	"clipfeed/mediacheck"
+	"clipfeed/notify"
+	"clipfeed/playlist"
 	"clipfeed/profile"
 	"clipfeed/ratelimit"
+	"clipfeed/recap"
+	"clipfeed/retention"
 	"clipfeed/saved"
+	"clipfeed/scoring"
 	"clipfeed/scout"
+	"clipfeed/share"
+	"clipfeed/sources"
+	"clipfeed/storagemigrate"
+	"clipfeed/telemetry"
+	"clipfeed/tracing"
 	"clipfeed/worker"
 
 	"github.com/go-chi/chi/v5"
@@ -33,6 +60,7 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"google.golang.org/grpc"
 	_ "modernc.org/sqlite"
 )
 
@@ -55,8 +83,48 @@ type Config struct {
 	Port           string
 	AllowedOrigins string
 	WorkerSecret   string
+	WorkerGRPCPort string
+	Federation     bool
+	PublicPages    bool
+	PublicBaseURL  string
+	SMTPHost       string
+	SMTPPort       string
+	SMTPUser       string
+	SMTPPassword   string
+	SMTPFrom       string
+	VAPIDPublic    string
+	VAPIDPrivate   string
+	VAPIDSubject   string
+	PlatformLimits string
+	Telemetry      bool
+	TelemetryURL   string
+	TelemetryHours int
+	MaxDownloadMB  int
+	MaxVideoSecs   int
+	RetentionDays  int
+	BackupKeep     int
+	StreamMode     string
+	StreamTTLMins  int
+	HLS            bool
+	Debug          bool
+	SlowQueryMS    int
+	QueryBudget    int
+	FeedBudgetMS   int
+	AffinityDays   int
+	AffinityMin    float64
+	DigestMinutes  int
+	IngestCritical int
+	WorkerRunGrace int
+	RequestTimeout int
+	LLMTimeout     int
+	LLMEndpoints   string
+	IntegrationsPrivateURLs bool
 }
 
+// version is the server version reported by /api/meta and telemetry. Release
+// builds set it with -ldflags "-X main.version=<version>".
+var version = "dev"
+
 // defaultSecrets lists the baked-in placeholder values that MUST be changed
 // before running in production.
 var defaultSecrets = map[string]string{
@@ -71,6 +139,10 @@ func loadConfig() Config {
 	if adminJWT == "" {
 		adminJWT = getEnv("JWT_SECRET", "supersecretkey")
 	}
+	telemetryHours := getEnvInt("TELEMETRY_INTERVAL_HOURS", 24)
+	if telemetryHours <= 0 {
+		telemetryHours = 24
+	}
 	return Config{
 		DBDriver:       getEnv("DB_DRIVER", "sqlite"),
 		DBPath:         getEnv("DB_PATH", "/data/clipfeed.db"),
@@ -89,6 +161,42 @@ func loadConfig() Config {
 		Port:           getEnv("PORT", "8080"),
 		AllowedOrigins: getEnv("ALLOWED_ORIGINS", "*"),
 		WorkerSecret:   getEnv("WORKER_SECRET", ""),
+		WorkerGRPCPort: getEnv("WORKER_GRPC_PORT", "9090"),
+		Federation:     getEnv("FEDERATION_ENABLED", "false") == "true",
+		PublicPages:    getEnv("PUBLIC_PAGES_ENABLED", "false") == "true",
+		PublicBaseURL:  getEnv("PUBLIC_BASE_URL", ""),
+		SMTPHost:       getEnv("SMTP_HOST", ""),
+		SMTPPort:       getEnv("SMTP_PORT", "587"),
+		SMTPUser:       getEnv("SMTP_USER", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:       getEnv("SMTP_FROM", ""),
+		VAPIDPublic:    getEnv("VAPID_PUBLIC_KEY", ""),
+		VAPIDPrivate:   getEnv("VAPID_PRIVATE_KEY", ""),
+		VAPIDSubject:   getEnv("VAPID_SUBJECT", "mailto:admin@localhost"),
+		PlatformLimits: getEnv("PLATFORM_CONCURRENCY", ""),
+		Telemetry:      getEnv("TELEMETRY_ENABLED", "false") == "true",
+		TelemetryURL:   getEnv("TELEMETRY_ENDPOINT", ""),
+		TelemetryHours: telemetryHours,
+		MaxDownloadMB:  getEnvInt("MAX_DOWNLOAD_SIZE_MB", 2048),
+		MaxVideoSecs:   getEnvInt("MAX_VIDEO_DURATION", 3600),
+		RetentionDays:  getEnvInt("INTERACTION_RETENTION_DAYS", 180),
+		BackupKeep:     getEnvInt("BACKUP_KEEP", backup.DefaultKeep),
+		StreamMode:     getEnv("STREAM_MODE", clips.StreamModePresign),
+		StreamTTLMins:  getEnvInt("STREAM_URL_TTL_MINUTES", 120),
+		HLS:            getEnv("HLS_TRANSCODING", "false") == "true",
+		Debug:          getEnv("DEBUG", "false") == "true",
+		SlowQueryMS:    getEnvInt("DB_SLOW_QUERY_MS", 200),
+		QueryBudget:    getEnvInt("DB_QUERY_BUDGET", 25),
+		FeedBudgetMS:   getEnvInt("FEED_RANK_BUDGET_MS", int(feed.DefaultRankBudget/time.Millisecond)),
+		AffinityDays:   getEnvInt("AFFINITY_HALF_LIFE_DAYS", 30),
+		AffinityMin:    getEnvFloat("AFFINITY_MIN_WEIGHT", affinity.DefaultMinWeight),
+		DigestMinutes:  getEnvInt("NOTIFY_DIGEST_MINUTES", int(notify.DefaultDigestInterval/time.Minute)),
+		IngestCritical: getEnvInt("INGEST_BACKLOG_CRITICAL", 0),
+		WorkerRunGrace: getEnvInt("WORKER_RUN_GRACE_SECS", int(worker.DefaultRunGrace/time.Second)),
+		RequestTimeout: getEnvInt("REQUEST_TIMEOUT_SECS", 30),
+		LLMTimeout:     getEnvInt("LLM_REQUEST_TIMEOUT_SECS", 60),
+		LLMEndpoints:   getEnv("LLM_ENDPOINTS", getEnv("LLM_BASE_URL", getEnv("LLM_URL", "http://llm:11434"))),
+		IntegrationsPrivateURLs: getEnv("INTEGRATIONS_ALLOW_PRIVATE_URLS", "false") == "true",
 	}
 }
 
@@ -99,32 +207,27 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return f
+	}
+	return fallback
+}
+
 func isInsecureDefaultsAllowed() bool {
 	v := strings.ToLower(os.Getenv("ALLOW_INSECURE_DEFAULTS"))
 	return v == "true" || v == "1" || v == "yes"
 }
 
-func main() {
-	cfg := loadConfig()
-
-	// Refuse to start with known default secrets unless explicitly overridden.
-	if !isInsecureDefaultsAllowed() {
-		var insecure []string
-		for envKey, placeholder := range defaultSecrets {
-			if getEnv(envKey, placeholder) == placeholder {
-				insecure = append(insecure, envKey)
-			}
-		}
-		if len(insecure) > 0 {
-			log.Fatalf("FATAL: the following secrets still use insecure defaults: %v\n"+
-				"Set them in your .env file or pass ALLOW_INSECURE_DEFAULTS=true for local development.",
-				insecure)
-		}
-	} else {
-		log.Println("WARNING: ALLOW_INSECURE_DEFAULTS=true -- running with default secrets (development mode)")
-	}
-
-	// --- Database ---
+// openDatabase opens the configured database and applies migrations.
+func openDatabase(cfg Config) *db.CompatDB {
 	var dialect db.Dialect
 	var rawDB *sql.DB
 
@@ -176,8 +279,51 @@ func main() {
 		log.Println("Using SQLite database")
 	}
 
-	compatDB := db.NewCompatDB(rawDB, dialect)
+	return db.NewCompatDB(rawDB, dialect)
+}
+
+func main() {
+	cfg := loadConfig()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1], os.Args[2:]))
+	}
+
+	// Refuse to start with known default secrets unless explicitly overridden.
+	if !isInsecureDefaultsAllowed() {
+		var insecure []string
+		for envKey, placeholder := range defaultSecrets {
+			if getEnv(envKey, placeholder) == placeholder {
+				insecure = append(insecure, envKey)
+			}
+		}
+		if len(insecure) > 0 {
+			log.Fatalf("FATAL: the following secrets still use insecure defaults: %v\n"+
+				"Set them in your .env file or pass ALLOW_INSECURE_DEFAULTS=true for local development.",
+				insecure)
+		}
+	} else {
+		log.Println("WARNING: ALLOW_INSECURE_DEFAULTS=true -- running with default secrets (development mode)")
+	}
+	if cfg.PublicPages && !share.ValidBaseURL(cfg.PublicBaseURL) {
+		log.Fatalf("PUBLIC_BASE_URL must be the http or https URL of the instance when PUBLIC_PAGES_ENABLED=true, got %q", cfg.PublicBaseURL)
+	}
+	if cfg.StreamMode != clips.StreamModePresign && cfg.StreamMode != clips.StreamModeProxy {
+		log.Fatalf("STREAM_MODE must be %q or %q, got %q", clips.StreamModePresign, clips.StreamModeProxy, cfg.StreamMode)
+	}
+
+	// --- Tracing ---
+	shutdownTracing, err := tracing.Setup(context.Background(), version)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	if tracing.Enabled() {
+		log.Println("Exporting traces over OTLP")
+	}
+
+	// --- Database ---
+	compatDB := openDatabase(cfg)
 	defer compatDB.Close()
+	compatDB.SlowQueryThreshold = time.Duration(cfg.SlowQueryMS) * time.Millisecond
 
 	// --- MinIO ---
 	minioClient, err := minio.New(cfg.MinioEndpoint, &minio.Options{
@@ -200,28 +346,149 @@ func main() {
 		log.Printf("created bucket: %s", cfg.MinioBucket)
 	}
 
-	publicPolicy := fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/clips/*/thumbnail.jpg"]}]}`, cfg.MinioBucket)
+	publicPolicy := fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/clips/*/thumbnail.jpg","arn:aws:s3:::%s/clips/*/previews/*"]}]}`, cfg.MinioBucket, cfg.MinioBucket)
 	if err := minioClient.SetBucketPolicy(ctx, cfg.MinioBucket, publicPolicy); err != nil {
 		log.Printf("warning: failed to set public-read policy on bucket: %v", err)
 	}
 
 	// --- Handlers ---
-	authH := &auth.Handler{DB: compatDB, JWTSecret: cfg.JWTSecret}
-	feedH := &feed.Handler{DB: compatDB, MinioBucket: cfg.MinioBucket, LTRModelPath: cfg.L2RModelPath}
+	jwtKeys := &auth.KeyRing{DB: compatDB, LegacySecret: cfg.JWTSecret, EncryptionSecret: cfg.CookieSecret}
+	go jwtKeys.RetireLoop()
+	authH := &auth.Handler{DB: compatDB, JWTSecret: cfg.JWTSecret, Keys: jwtKeys, RedeemInvite: invites.Consume}
+	go authH.SessionPruneLoop()
+	maintenanceG := &maintenance.Guard{DB: compatDB}
+	if err := maintenanceG.Load(ctx); err != nil {
+		log.Printf("warning: failed to load maintenance state: %v", err)
+	}
+	if m := maintenanceG.State(); m.Mode != maintenance.ModeOff {
+		log.Printf("maintenance mode %q is on since %s", m.Mode, m.Since)
+	}
+
+	feedH := &feed.Handler{
+		DB: compatDB, MinioBucket: cfg.MinioBucket, LTRModelPath: cfg.L2RModelPath,
+		RankBudget: time.Duration(cfg.FeedBudgetMS) * time.Millisecond, Maintenance: maintenanceG,
+	}
+	if err := feedH.LoadRankExperiments(ctx); err != nil {
+		log.Printf("warning: failed to load rank experiments: %v", err)
+	}
 	feedH.RefreshTopicGraph()
 	go feedH.TopicGraphRefreshLoop()
 	feedH.SetLTRModel(feedH.LoadLTRModel())
 	go feedH.LTRModelRefreshLoop()
+	go feedH.CandidatePrecomputeLoop()
+	go feedH.SeriesClusterLoop()
 
-	clipsH := &clips.Handler{DB: compatDB, Minio: minioClient, MinioBucket: cfg.MinioBucket}
-	adminH := &admin.Handler{DB: compatDB, AdminUsername: cfg.AdminUsername, AdminPassword: cfg.AdminPassword, AdminJWTSecret: cfg.AdminJWTSecret}
-	workerH := &worker.Handler{DB: compatDB, WorkerSecret: cfg.WorkerSecret, CookieSecret: cfg.CookieSecret}
-	ingestH := &ingest.Handler{DB: compatDB}
+	clipsH := &clips.Handler{
+		DB: compatDB, Minio: minioClient, MinioBucket: cfg.MinioBucket,
+		StreamMode: cfg.StreamMode, StreamTTL: time.Duration(cfg.StreamTTLMins) * time.Minute,
+		StreamSecret: cfg.CookieSecret,
+	}
+	feedH.PresignStream = clipsH.PresignStreamURL
+	if aiEnabled() {
+		feedH.LLM = clips.GenerateSummaryWithLLM
+	}
+	var llmPool *llmpool.Pool
+	if aiEnabled() && getEnv("LLM_PROVIDER", "") == "ollama" {
+		endpoints, err := llmpool.ParseEndpoints(cfg.LLMEndpoints)
+		if err != nil {
+			log.Fatalf("LLM_ENDPOINTS: %v", err)
+		}
+		model := getEnv("LLM_MODEL", getEnv("OLLAMA_MODEL", "llama3.2:3b"))
+		llmPool = &llmpool.Pool{Endpoints: endpoints, Models: map[llmpool.Task]string{
+			llmpool.TaskSummary:   model,
+			llmpool.TaskScoring:   getEnv("LLM_SCORING_MODEL", model),
+			llmpool.TaskEmbedding: getEnv("LLM_EMBED_MODEL", "all-minilm"),
+		}}
+		clips.LLMPool = llmPool
+		go llmPool.HealthLoop(0)
+	}
+	adminH := &admin.Handler{DB: compatDB, AdminUsername: cfg.AdminUsername, AdminPassword: cfg.AdminPassword, AdminJWTSecret: cfg.AdminJWTSecret, RankingStatus: feedH.RankingStatus}
+	clipsH.IsAdmin = adminH.IsAdminToken
+	notifyH := &notify.Handler{
+		DB: compatDB, SMTPHost: cfg.SMTPHost, SMTPPort: cfg.SMTPPort, SMTPUser: cfg.SMTPUser,
+		SMTPPassword: cfg.SMTPPassword, SMTPFrom: cfg.SMTPFrom,
+		VAPIDPublicKey: cfg.VAPIDPublic, VAPIDPrivateKey: cfg.VAPIDPrivate, VAPIDSubject: cfg.VAPIDSubject,
+		DigestInterval: time.Duration(cfg.DigestMinutes) * time.Minute,
+	}
+	if cfg.DigestMinutes > 0 {
+		go notifyH.DigestLoop()
+	}
+	if notifyH.EmailEnabled() {
+		authH.SendEmail = notifyH.SendEmail
+	}
+	recapH := &recap.Handler{DB: compatDB, Notify: notifyH.Send}
+	go recapH.SendLoop()
+	licensingH := &licensing.Handler{DB: compatDB}
+	workerH := &worker.Handler{DB: compatDB, WorkerSecret: cfg.WorkerSecret, CookieSecret: cfg.CookieSecret, Notifier: notifyH, HLS: cfg.HLS}
+	mediaH := &mediacheck.Handler{DB: compatDB, Store: mediacheck.MinioStore{Client: minioClient, Bucket: cfg.MinioBucket}}
+	liveH := &live.Handler{DB: compatDB, Auth: authH}
+	workerH.OnClipCreated = func(clipID string) {
+		mediaH.Notify(clipID)
+		liveH.ClipCreated(clipID)
+	}
+	go mediaH.CheckLoop()
+	worker.SeedPlatformLimits(context.Background(), compatDB, worker.ParsePlatformLimits(cfg.PlatformLimits))
+	go workerH.OutcomePruneLoop()
+	go workerH.StartupRunSweep(time.Duration(cfg.WorkerRunGrace) * time.Second)
+	ingestH := &ingest.Handler{DB: compatDB, BacklogCritical: cfg.IngestCritical}
 	savedH := &saved.Handler{DB: compatDB, MinioBucket: cfg.MinioBucket}
-	collectionsH := &collections.Handler{DB: compatDB, MinioBucket: cfg.MinioBucket}
+	integrationsH := &integrations.Handler{DB: compatDB, CookieSecret: cfg.CookieSecret, AllowPrivateURLs: cfg.IntegrationsPrivateURLs}
+	go integrationsH.SyncLoop()
+	collectionsH := &collections.Handler{DB: compatDB, MinioBucket: cfg.MinioBucket, Match: feedH.MatchClips,
+		Covers: images.MinioStore{Client: minioClient, Bucket: cfg.MinioBucket}}
+	go collectionsH.RefreshLoop()
+	sourcesH := &sources.Handler{DB: compatDB, Minio: minioClient, MinioBucket: cfg.MinioBucket}
+	contentFilterH := &contentfilter.Handler{DB: compatDB}
+	invitesH := &invites.Handler{DB: compatDB}
+	playlistH := &playlist.Handler{DB: compatDB, Auth: authH, PresignStream: clipsH.PresignStreamURL}
+	shareH := &share.Handler{DB: compatDB, MinioBucket: cfg.MinioBucket, BaseURL: cfg.PublicBaseURL,
+		Secret: cfg.CookieSecret, PresignStream: clipsH.PresignStreamURL}
 	jobsH := &jobs.Handler{DB: compatDB}
-	profileH := &profile.Handler{DB: compatDB, CookieSecret: cfg.CookieSecret}
+	profileH := &profile.Handler{DB: compatDB, CookieSecret: cfg.CookieSecret,
+		Avatars: images.MinioStore{Client: minioClient, Bucket: cfg.MinioBucket}}
 	scoutH := &scout.Handler{DB: compatDB}
+	scoringH := &scoring.Handler{DB: compatDB}
+	federationH := &federation.Handler{DB: compatDB, Minio: minioClient, MinioBucket: cfg.MinioBucket, CookieSecret: cfg.CookieSecret}
+	if cfg.Federation {
+		go federationH.SyncLoop()
+	}
+	telemetryH := &telemetry.Handler{
+		DB: compatDB, Version: version, Enabled: cfg.Telemetry, Endpoint: cfg.TelemetryURL,
+		Interval: time.Duration(cfg.TelemetryHours) * time.Hour,
+		Features: serverFeatures(cfg),
+	}
+	if cfg.Telemetry {
+		if cfg.TelemetryURL == "" {
+			log.Println("TELEMETRY_ENABLED is set but TELEMETRY_ENDPOINT is empty; no reports will be sent")
+		} else {
+			go telemetryH.ReportLoop()
+		}
+	}
+
+	retentionH := &retention.Handler{DB: compatDB, Retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour}
+	go retentionH.PruneLoop()
+
+	backupM := &backup.Manager{
+		DB: compatDB, DBURL: cfg.DBURL, Minio: minioClient, Bucket: cfg.MinioBucket,
+		Keep: cfg.BackupKeep, TempDir: filepath.Dir(cfg.DBPath),
+	}
+	storageMigrateH := &storagemigrate.Handler{
+		DB: compatDB, CookieSecret: cfg.CookieSecret, SourceBucket: cfg.MinioBucket,
+		Source:     storagemigrate.MinioStore{Client: minioClient, Bucket: cfg.MinioBucket},
+		OpenTarget: storagemigrate.MinioOpener(minioClient),
+	}
+	go storageMigrateH.ResumeRunning()
+	libraryH := &library.Handler{
+		DB: compatDB, Minio: minioClient, MinioBucket: cfg.MinioBucket,
+		Store: library.MinioStore{Client: minioClient, Bucket: cfg.MinioBucket},
+	}
+
+	affinityH := &affinity.Handler{
+		DB: compatDB, HalfLife: time.Duration(cfg.AffinityDays) * 24 * time.Hour, MinWeight: cfg.AffinityMin,
+	}
+	if cfg.AffinityDays > 0 {
+		go affinityH.DecayLoop()
+	}
 
 	// --- Rate limiters ---
 	authRL := ratelimit.New(10, 1*time.Minute)
@@ -230,6 +497,7 @@ func main() {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(tracing.Middleware)
 	r.Use(middleware.Compress(5))
 
 	// Global request body size limit (1 MB).
@@ -240,6 +508,9 @@ func main() {
 		})
 	})
 
+	// Per-request query counting; X-DB-* headers only in debug mode.
+	r.Use(httputil.QueryStats(cfg.QueryBudget, cfg.Debug))
+
 	// Security headers
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -259,23 +530,42 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Device-ID", datasaver.Header, "Save-Data"},
+		ExposedHeaders:   []string{"Link", "X-DB-Queries", "X-DB-Time"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 
+	// Maintenance mode, after CORS so refusals still reach browsers.
+	r.Use(maintenanceG.Middleware)
+
+	// Data saver override header, read by stream and feed handlers.
+	r.Use(datasaver.Middleware)
+
+	// Request time budgets, after CORS so 504s still reach browsers. Media
+	// streams and bulk transfers are unbounded; LLM calls get longer.
+	llmBudget := time.Duration(cfg.LLMTimeout) * time.Second
+	r.Use(httputil.Timeout(time.Duration(cfg.RequestTimeout)*time.Second, []httputil.RouteBudget{
+		{Pattern: "/api/media"},
+		{Pattern: "/api/me/sync"},
+		{Pattern: "/api/admin/backup"},
+		{Pattern: "/api/admin/export"},
+		{Pattern: "/api/admin/import"},
+		{Pattern: "/api/admin/federation/remotes/*/sync"},
+		{Pattern: "/api/clips/*/summary", Budget: llmBudget},
+		{Pattern: "/api/feed/ask", Budget: llmBudget},
+	}))
+
 	// Health / config
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteJSON(w, 200, map[string]string{"status": "ok"})
 	})
 	r.Get("/api/config", func(w http.ResponseWriter, r *http.Request) {
-		provider := os.Getenv("LLM_PROVIDER")
-		apiKey := os.Getenv("LLM_API_KEY")
-		aiEnabled := provider != "" && (provider == "ollama" || apiKey != "")
 		w.Header().Set("Cache-Control", "public, max-age=300")
-		httputil.WriteJSON(w, 200, map[string]interface{}{"ai_enabled": aiEnabled})
+		httputil.WriteJSON(w, 200, map[string]interface{}{"ai_enabled": aiEnabled()})
 	})
+	r.Get("/api/meta", handleMeta(cfg, compatDB))
+	r.Get("/api/maintenance", maintenanceG.HandleStatus)
 
 	// Auth routes (rate limited)
 	r.Group(func(r chi.Router) {
@@ -287,48 +577,282 @@ func main() {
 
 	// Public routes
 	r.Get("/api/feed", authH.OptionalAuth(feedH.HandleFeed))
-	r.Get("/api/clips/{id}", clipsH.HandleGetClip)
-	r.Get("/api/clips/{id}/stream", clipsH.HandleStreamClip)
+	r.Get("/api/feed/tv", authH.OptionalAuth(feedH.HandleTVFeed))
+	r.Get("/api/feed/presets", authH.OptionalAuth(feedH.HandleListPresets))
+	r.Get("/api/clips/{id}", authH.OptionalAuth(clipsH.HandleGetClip))
+	r.Get("/api/clips/{id}/transcript", clipsH.HandleGetTranscript)
+	r.Get("/api/clips/{id}/stream", authH.OptionalAuth(clipsH.HandleStreamClip))
+	r.Get("/api/clips/{id}/storyboard.vtt", authH.OptionalAuth(clipsH.HandleStoryboard))
+	// Signed by the master playlist from /stream?format=hls; players fetch
+	// them without credentials.
+	r.Get("/api/clips/{id}/hls/{variant}.m3u8", clipsH.HandleHLSPlaylist)
+	r.Get("/api/clips/{id}/gif/{previewId}", clipsH.HandleGetPreview)
+	r.Get("/api/clips/{id}/retention", authH.OptionalAuth(clipsH.HandleRetention))
+	r.Post("/api/streams/refresh", authH.OptionalAuth(clipsH.HandleRefreshStreams))
+	r.Get("/api/media", clipsH.HandleMedia)
 	r.Get("/api/clips/{id}/similar", feedH.HandleSimilarClips)
-	r.Get("/api/search", feedH.HandleSearch)
+	r.Get("/api/clips/{id}/why", authH.OptionalAuth(feedH.HandleWhyClip))
+	r.Get("/api/search", authH.OptionalAuth(feedH.HandleSearch))
+	r.Get("/api/discover", feedH.HandleDiscover)
+	r.Get("/api/discover/{section}", feedH.HandleDiscoverSection)
+	r.Get("/api/series/{id}", authH.OptionalAuth(feedH.HandleGetSeries))
 	r.Get("/api/topics", feedH.HandleGetTopics)
 	r.Get("/api/topics/tree", feedH.HandleGetTopicTree)
+	r.Get("/api/users/{id}/avatar", profileH.HandleGetAvatar)
+	r.Get("/api/collections/{id}/cover", collectionsH.HandleGetCover)
+
+	// Public pages, the embed player, oEmbed, and the sitemap
+	if cfg.PublicPages {
+		r.Get("/sitemap.xml", shareH.HandleSitemap)
+		r.Get("/clips/{id}", shareH.HandleClipPage)
+		r.Get("/collections/{id}", shareH.HandleCollectionPage)
+		r.Get("/embed/{id}", shareH.HandleEmbed)
+		r.Get("/api/oembed", shareH.HandleOEmbed)
+	}
 
-	// Admin routes
+	// Admin routes, grouped by the permission they need (see admin.Role)
 	r.Group(func(r chi.Router) {
 		r.Use(adminH.AdminAuthMiddleware)
 		r.Get("/api/admin/status", adminH.HandleAdminStatus)
-		r.Get("/api/admin/llm_logs", adminH.HandleAdminLLMLogs)
-		r.Post("/api/admin/clear-failed", adminH.HandleClearFailedJobs)
+		r.Get("/api/admin/me", adminH.HandleAdminMe)
+
+		// Curation
+		r.Group(func(r chi.Router) {
+			r.Use(adminH.Require(admin.PermContent))
+			r.Get("/api/admin/staff-picks", adminH.HandleListStaffPicks)
+			r.Put("/api/admin/staff-picks/{clipId}", adminH.HandleSetStaffPick)
+			r.Delete("/api/admin/staff-picks/{clipId}", adminH.HandleDeleteStaffPick)
+			r.Get("/api/admin/thumbnails", feedH.HandleThumbnailStats)
+			r.Get("/api/admin/feed/snapshots", feedH.HandleListFeedSnapshots)
+			r.Post("/api/admin/feed/snapshots", feedH.HandleCreateFeedSnapshot)
+			r.Get("/api/admin/feed/snapshots/{id}/diff", feedH.HandleDiffFeedSnapshot)
+			r.Delete("/api/admin/feed/snapshots/{id}", feedH.HandleDeleteFeedSnapshot)
+			r.Get("/api/clips/{id}/topic-suggestions", feedH.HandleTopicSuggestions)
+			r.Post("/api/clips/{id}/topic-suggestions", feedH.HandleAcceptTopicSuggestions)
+			r.Get("/api/admin/clips/broken", mediaH.HandleListBroken)
+			r.Post("/api/admin/clips/{id}/recheck", mediaH.HandleRecheck)
+			r.Get("/api/clips/compare", clipsH.HandleCompareClips)
+			r.Post("/api/clips/merge", clipsH.HandleMergeClips)
+		})
+
+		// Moderation
+		r.Group(func(r chi.Router) {
+			r.Use(adminH.Require(admin.PermModeration))
+			r.Get("/api/admin/licenses", licensingH.HandleLicenseReport)
+			r.Put("/api/admin/users/{id}/restricted", contentFilterH.HandleSetRestricted)
+			r.Get("/api/admin/content-filters", contentFilterH.HandleListInstanceFilters)
+			r.Post("/api/admin/content-filters", contentFilterH.HandleCreateInstanceFilter)
+			r.Delete("/api/admin/content-filters/{id}", contentFilterH.HandleDeleteInstanceFilter)
+			r.Get("/api/admin/content-filters/review", contentFilterH.HandleReviewQueue)
+			r.Put("/api/admin/content-filters/review/{clipId}/{filterId}", contentFilterH.HandleReviewMatch)
+			r.Get("/api/admin/interaction-flags", adminH.HandleListInteractionFlags)
+			r.Put("/api/admin/interaction-flags/{id}", adminH.HandleResolveInteractionFlag)
+			r.Get("/api/admin/takedowns", licensingH.HandleListTakedowns)
+			r.Post("/api/admin/takedowns", licensingH.HandleCreateTakedown)
+			r.Put("/api/admin/takedowns/{id}", licensingH.HandleResolveTakedown)
+			r.Post("/api/admin/clips/{id}/revoke-streams", clipsH.HandleRevokeStreams)
+		})
+
+		// User management
+		r.Group(func(r chi.Router) {
+			r.Use(adminH.Require(admin.PermUsers))
+			r.Put("/api/admin/users/{id}/stream-limit", adminH.HandleSetStreamLimit)
+			r.Delete("/api/admin/users/{id}/stream-limit", adminH.HandleDeleteStreamLimit)
+			r.Put("/api/admin/users/{id}/storage-quota", adminH.HandleSetStorageQuota)
+			r.Delete("/api/admin/users/{id}/storage-quota", adminH.HandleDeleteStorageQuota)
+			r.Get("/api/admin/invites", adminH.HandleListInvites)
+			r.Post("/api/admin/invites", adminH.HandleCreateInvite)
+			r.Delete("/api/admin/invites/{code}", adminH.HandleDeleteInvite)
+			r.Post("/api/admin/users/{id}/impersonate", authH.HandleStartImpersonation)
+			r.Get("/api/admin/impersonations", authH.HandleListImpersonations)
+			r.Delete("/api/admin/impersonations/{id}", authH.HandleEndImpersonation)
+		})
+
+		// Instance settings and operations
+		r.Group(func(r chi.Router) {
+			r.Use(adminH.Require(admin.PermSettings))
+			r.Get("/api/admin/llm_logs", adminH.HandleAdminLLMLogs)
+			if llmPool != nil {
+				r.Get("/api/admin/llm/endpoints", llmPool.HandleStatus)
+			}
+			r.Post("/api/admin/clear-failed", adminH.HandleClearFailedJobs)
+			r.Get("/api/admin/ingest/analytics", workerH.HandleIngestAnalytics)
+			r.Get("/api/admin/jobs/snapshot", workerH.HandleQueueSnapshot)
+			r.Get("/api/admin/platform-limits", adminH.HandleListPlatformLimits)
+			r.Put("/api/admin/platform-limits/{platform}", adminH.HandleSetPlatformLimit)
+			r.Delete("/api/admin/platform-limits/{platform}", adminH.HandleDeletePlatformLimit)
+			r.Get("/api/admin/clip-strategies", adminH.HandleListClipStrategies)
+			r.Put("/api/admin/clip-strategies/{platform}", adminH.HandleSetClipStrategy)
+			r.Delete("/api/admin/clip-strategies/{platform}", adminH.HandleDeleteClipStrategy)
+			r.Get("/api/admin/settings", adminH.HandleGetSettings)
+			r.Put("/api/admin/settings", adminH.HandleUpdateSettings)
+			r.Post("/api/admin/rank/sandbox", feedH.HandleRankSandbox)
+			r.Get("/api/admin/feed/degradation", feedH.HandleRankDegradation)
+			r.Get("/api/admin/rank/experiments", feedH.HandleGetRankExperiments)
+			r.Put("/api/admin/rank/experiments", feedH.HandleSetRankExperiments)
+			r.Post("/api/admin/backup", backupM.HandleCreateBackup)
+			r.Get("/api/admin/backups", backupM.HandleListBackups)
+			r.Get("/api/admin/storage/migrations", storageMigrateH.HandleList)
+			r.Post("/api/admin/storage/migrations", storageMigrateH.HandleStart)
+			r.Get("/api/admin/storage/migrations/{id}", storageMigrateH.HandleGet)
+			r.Post("/api/admin/storage/migrations/{id}/pause", storageMigrateH.HandlePause)
+			r.Post("/api/admin/storage/migrations/{id}/resume", storageMigrateH.HandleResume)
+			r.Get("/api/admin/export", libraryH.HandleExport)
+			r.Post("/api/admin/import", libraryH.HandleImport)
+			r.Get("/api/admin/maintenance", maintenanceG.HandleGet)
+			r.Put("/api/admin/maintenance", maintenanceG.HandleSet)
+			r.Get("/api/admin/scoring/weights", scoringH.HandleGetWeights)
+			r.Put("/api/admin/scoring/weights", scoringH.HandleSetWeights)
+			r.Post("/api/admin/scoring/weights/preview", scoringH.HandlePreviewWeights)
+			r.Get("/api/admin/telemetry/preview", telemetryH.HandlePreview)
+			r.Get("/api/admin/interactions/retention", retentionH.HandleStatus)
+			r.Post("/api/admin/interactions/prune", retentionH.HandlePrune)
+			r.Get("/api/admin/retention", retentionH.HandleReport)
+			r.Get("/api/admin/affinities/decay", affinityH.HandleStatus)
+			r.Post("/api/admin/affinities/decay", affinityH.HandleDecay)
+			r.Get("/api/admin/auth/keys", jwtKeys.HandleListKeys)
+			r.Post("/api/admin/auth/keys/rotate", jwtKeys.HandleRotateKey)
+
+			// Federation
+			if cfg.Federation {
+				r.Post("/api/admin/federation/peers", federationH.HandleCreatePeer)
+				r.Get("/api/admin/federation/peers", federationH.HandleListPeers)
+				r.Delete("/api/admin/federation/peers/{id}", federationH.HandleDeletePeer)
+				r.Post("/api/admin/federation/remotes", federationH.HandleCreateRemote)
+				r.Get("/api/admin/federation/remotes", federationH.HandleListRemotes)
+				r.Delete("/api/admin/federation/remotes/{id}", federationH.HandleDeleteRemote)
+				r.Get("/api/admin/federation/remotes/{id}/catalog", federationH.HandleRemoteCatalog)
+				r.Post("/api/admin/federation/remotes/{id}/sync", federationH.HandleTriggerSync)
+				r.Post("/api/admin/federation/remotes/{id}/subscriptions", federationH.HandleCreateSubscription)
+				r.Delete("/api/admin/federation/remotes/{id}/subscriptions/{subId}", federationH.HandleDeleteSubscription)
+			}
+		})
+
+		// Admin roles
+		r.Group(func(r chi.Router) {
+			r.Use(adminH.Require(admin.PermRoles))
+			r.Get("/api/admin/roles", adminH.HandleListRoles)
+			r.Put("/api/admin/users/{id}/role", adminH.HandleSetRole)
+			r.Delete("/api/admin/users/{id}/role", adminH.HandleDeleteRole)
+		})
 	})
 
+	// Signed peer-to-peer federation API
+	if cfg.Federation {
+		r.Group(func(r chi.Router) {
+			r.Use(federationH.PeerAuthMiddleware)
+			r.Get("/api/federation/v1/catalog", federationH.HandlePeerCatalog)
+			r.Get("/api/federation/v1/clips", federationH.HandlePeerClips)
+		})
+	}
+
+	// Playlists also accept a playlist token in ?token= for media players
+	r.Get("/api/me/saved/playlist.m3u", playlistH.TokenAuth(playlistH.HandleSavedPlaylist))
+	r.Get("/api/collections/{id}/playlist.m3u8", playlistH.TokenAuth(playlistH.HandleCollectionPlaylist))
+
+	// The sync socket signs in with its first message, since browsers
+	// cannot send headers on WebSockets.
+	r.Get("/api/me/sync", liveH.HandleSync)
+
 	// Authenticated user routes
 	r.Group(func(r chi.Router) {
 		r.Use(authH.AuthMiddleware)
 		r.Post("/api/clips/{id}/summary", clipsH.HandleClipSummary)
+		r.Post("/api/clips/{id}/gif", clipsH.HandleCreatePreview)
 		r.Post("/api/clips/{id}/interact", clipsH.HandleInteraction)
+		r.Get("/api/feed/queue", feedH.HandleGetQueue)
+		r.Post("/api/queue/advance", feedH.HandleAdvanceQueue)
+		r.Post("/api/clips/{id}/thumbnail-click", feedH.HandleThumbnailClick)
+		r.Post("/api/clips/{id}/feedback", feedH.HandleClipFeedback)
 		r.Post("/api/clips/{id}/save", savedH.HandleSaveClip)
 		r.Delete("/api/clips/{id}/save", savedH.HandleUnsaveClip)
 		r.Post("/api/ingest", ingestH.HandleIngest)
+		r.Get("/api/ingest/queue", ingestH.HandleQueue)
 		r.Get("/api/jobs", jobsH.HandleListJobs)
 		r.Get("/api/jobs/{id}", jobsH.HandleGetJob)
 		r.Post("/api/jobs/{id}/cancel", jobsH.HandleCancelJob)
 		r.Post("/api/jobs/{id}/retry", jobsH.HandleRetryJob)
 		r.Delete("/api/jobs/{id}", jobsH.HandleDismissJob)
+		r.Get("/api/me/sources", sourcesH.HandleListMySources)
+		r.Get("/api/sources/{id}/clips", sourcesH.HandleListSourceClips)
+		r.Delete("/api/sources/{id}", sourcesH.HandleDeleteSource)
+		r.Get("/api/me/storage", sourcesH.HandleGetStorage)
+		r.Get("/api/me/storage/suggestions", sourcesH.HandleCleanupSuggestions)
+		r.Post("/api/me/clips/bulk", sourcesH.HandleBulkClips)
+		r.Get("/api/me/invites", invitesH.HandleListMyInvites)
+		r.Post("/api/me/invites", invitesH.HandleCreateMyInvite)
 		r.Get("/api/me", profileH.HandleGetProfile)
+		r.Put("/api/me", authH.HandleUpdateAccount)
+		r.Put("/api/me/password", authH.HandleChangePassword)
+		r.Get("/api/me/sessions", authH.HandleListSessions)
+		r.Delete("/api/me/sessions", authH.HandleRevokeAllSessions)
+		r.Delete("/api/me/sessions/{id}", authH.HandleRevokeSession)
+		r.Post("/api/me/email/verify", authH.HandleVerifyEmail)
+		r.Post("/api/me/avatar", profileH.HandleUploadAvatar)
+		r.Delete("/api/me/avatar", profileH.HandleDeleteAvatar)
+		r.Get("/api/me/audit-log", authH.HandleMyAuditLog)
+		r.Get("/api/me/usage", clipsH.HandleMyUsage)
+		r.Get("/api/me/recap", recapH.HandleGetRecap)
+		r.Get("/api/me/content-filters", contentFilterH.HandleListMyFilters)
+		r.Post("/api/me/content-filters", contentFilterH.HandleCreateMyFilter)
+		r.Delete("/api/me/content-filters/{id}", contentFilterH.HandleDeleteMyFilter)
 		r.Put("/api/me/preferences", profileH.HandleUpdatePreferences)
+		r.Get("/api/me/settings-bundle", profileH.HandleExportSettings)
+		r.Put("/api/me/settings-bundle", profileH.HandleImportSettings)
+		r.Get("/api/me/pacing", feedH.HandleGetPacing)
+		r.Put("/api/me/pacing", feedH.HandleUpdatePacing)
+		r.Post("/api/me/affinities/preview", feedH.HandlePreviewAffinities)
+		r.Post("/api/me/snooze", profileH.HandleSnooze)
+		r.Get("/api/me/snoozes", profileH.HandleListSnoozes)
+		r.Delete("/api/me/snoozes/{id}", profileH.HandleCancelSnooze)
 		r.Get("/api/me/saved", savedH.HandleListSaved)
+		r.Get("/api/me/saved/export", savedH.HandleExportSaved)
+		r.Put("/api/me/saved/{clipId}", savedH.HandleUpdateSaved)
+		r.Post("/api/me/playlist-token", playlistH.HandleCreateToken)
+		r.Delete("/api/me/playlist-token", playlistH.HandleRevokeToken)
 		r.Get("/api/me/history", savedH.HandleListHistory)
+		r.Get("/api/me/integrations", integrationsH.HandleList)
+		r.Post("/api/me/integrations", integrationsH.HandleCreate)
+		r.Patch("/api/me/integrations/{id}", integrationsH.HandleUpdate)
+		r.Delete("/api/me/integrations/{id}", integrationsH.HandleDelete)
+		r.Post("/api/me/integrations/{id}/retry", integrationsH.HandleRetry)
 		r.Get("/api/me/cookies", profileH.HandleListCookieStatus)
 		r.Put("/api/me/cookies/{platform}", profileH.HandleSetCookie)
 		r.Delete("/api/me/cookies/{platform}", profileH.HandleDeleteCookie)
+		r.Get("/api/me/notifications", notifyH.HandleListNotifications)
+		r.Get("/api/me/notifications/preferences", notifyH.HandleGetPreferences)
+		r.Put("/api/me/notifications/preferences", notifyH.HandleUpdatePreferences)
+		r.Post("/api/me/notifications/push-subscriptions", notifyH.HandleCreatePushSubscription)
+		r.Delete("/api/me/notifications/push-subscriptions/{id}", notifyH.HandleDeletePushSubscription)
+		r.Get("/api/me/notifications/subscriptions", notifyH.HandleListSubscriptions)
+		r.Post("/api/me/notifications/subscriptions", notifyH.HandleCreateSubscription)
+		r.Delete("/api/me/notifications/subscriptions/{id}", notifyH.HandleDeleteSubscription)
 		r.Post("/api/collections", collectionsH.HandleCreateCollection)
 		r.Get("/api/collections", collectionsH.HandleListCollections)
+		r.Get("/api/collections/suggestions", collectionsH.HandleListSuggestions)
+		r.Post("/api/collections/suggestions/{id}/accept", collectionsH.HandleAcceptSuggestion)
+		r.Delete("/api/collections/suggestions/{id}", collectionsH.HandleDismissSuggestion)
 		r.Get("/api/collections/{id}/clips", collectionsH.HandleGetCollectionClips)
 		r.Post("/api/collections/{id}/clips", collectionsH.HandleAddToCollection)
 		r.Delete("/api/collections/{id}/clips/{clipId}", collectionsH.HandleRemoveFromCollection)
+		r.Put("/api/collections/{id}/filters", collectionsH.HandleSetFilters)
+		r.Put("/api/collections/{id}/cover", collectionsH.HandleUploadCover)
+		r.Delete("/api/collections/{id}/cover", collectionsH.HandleDeleteCover)
 		r.Delete("/api/collections/{id}", collectionsH.HandleDeleteCollection)
 
+		r.Get("/api/feed/explain", feedH.HandleFeedExplain)
+		r.Post("/api/feed/ask", feedH.HandleAsk)
+
+		// Focus sessions
+		r.Post("/api/feed/focus", feedH.HandleStartFocus)
+		r.Get("/api/feed/focus/{id}", feedH.HandleGetFocus)
+		r.Post("/api/feed/focus/{id}/end", feedH.HandleEndFocus)
+
+		// Daily mixes
+		r.Get("/api/mixes", feedH.HandleListMixes)
+		r.Get("/api/mixes/daily", feedH.HandleDailyMix)
+		r.Get("/api/mixes/{id}", feedH.HandleGetMix)
+
 		// Saved filters
 		r.Post("/api/filters", feedH.HandleCreateFilter)
 		r.Get("/api/filters", feedH.HandleListFilters)
@@ -344,22 +868,36 @@ func main() {
 		r.Get("/api/scout/candidates", scoutH.HandleListScoutCandidates)
 		r.Post("/api/scout/candidates/{id}/approve", scoutH.HandleApproveCandidate)
 		r.Get("/api/scout/profile", scoutH.HandleGetScoutProfile)
+
+		// Federated clips
+		if cfg.Federation {
+			r.Get("/api/federation/clips", federationH.HandleListFederatedClips)
+			r.Get("/api/federation/clips/{remoteId}/{clipId}/stream", federationH.HandleStreamFederatedClip)
+		}
 	})
 
 	// Internal worker API
 	r.Group(func(r chi.Router) {
 		r.Use(workerH.WorkerAuthMiddleware)
 		r.Post("/api/internal/jobs/claim", workerH.HandleClaimJob)
+		r.Post("/api/internal/jobs", workerH.HandleCreateJob)
 		r.Put("/api/internal/jobs/{id}", workerH.HandleUpdateJob)
 		r.Get("/api/internal/jobs/{id}", workerH.HandleGetJob)
 		r.Post("/api/internal/jobs/{id}/heartbeat", workerH.HandleHeartbeat)
 		r.Post("/api/internal/jobs/reclaim", workerH.HandleReclaimStale)
+		r.Post("/api/internal/workers/register", workerH.HandleRegisterRun)
 		r.Put("/api/internal/sources/{id}", workerH.HandleUpdateSource)
 		r.Get("/api/internal/sources/{id}/cookie", workerH.HandleGetCookie)
 		r.Post("/api/internal/clips", workerH.HandleCreateClip)
+		r.Put("/api/internal/clips/{id}/thumbnails", workerH.HandleSetThumbnails)
+		r.Put("/api/internal/clips/{id}/renditions", workerH.HandleSetRenditions)
+		r.Put("/api/internal/clips/{id}/storyboard", workerH.HandleSetStoryboard)
+		r.Put("/api/internal/clips/{id}/hls", workerH.HandleSetHLS)
+		r.Put("/api/internal/clips/{id}/previews/{previewId}", workerH.HandleSetPreview)
 		r.Post("/api/internal/topics/resolve", workerH.HandleResolveTopic)
 		r.Post("/api/internal/scores/update", workerH.HandleScoreUpdate)
 		r.Post("/api/internal/llm-logs", workerH.HandleCreateLLMLog)
+		r.Post("/api/internal/scout/sources/{id}/check", scoutH.HandleReportCheck)
 	})
 
 	// --- Start server ---
@@ -371,6 +909,23 @@ func main() {
 		}
 	}()
 
+	// The worker protocol is also served over gRPC on its own port; the
+	// /api/internal HTTP routes remain for workers that have not switched.
+	var grpcSrv *grpc.Server
+	if cfg.WorkerSecret != "" && cfg.WorkerGRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.WorkerGRPCPort)
+		if err != nil {
+			log.Fatalf("worker gRPC listen: %v", err)
+		}
+		grpcSrv = workerH.NewGRPCServer()
+		go func() {
+			log.Printf("Worker gRPC listening on :%s", cfg.WorkerGRPCPort)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Fatalf("worker gRPC error: %v", err)
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -378,5 +933,11 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	srv.Shutdown(shutdownCtx)
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("tracing shutdown: %v", err)
+	}
 	log.Println("server shut down")
 }
@@ -3,27 +3,73 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"clipfeed/admin"
 	"clipfeed/auth"
 	"clipfeed/clips"
 	"clipfeed/collections"
+	"clipfeed/contentfilter"
+	"clipfeed/datasaver"
 	"clipfeed/db"
+	"clipfeed/devdata"
+	"clipfeed/federation"
 	"clipfeed/feed"
+	"clipfeed/feed/feedtest"
 	"clipfeed/httputil"
 	"clipfeed/ingest"
+	"clipfeed/integrations"
+	"clipfeed/maintenance"
+	"clipfeed/invites"
 	"clipfeed/jobs"
+	"clipfeed/licensing"
+	"clipfeed/live"
+	"clipfeed/mediacheck"
+	"clipfeed/notify"
+	"clipfeed/playlist"
 	"clipfeed/profile"
 	"clipfeed/saved"
 	"clipfeed/scout"
+	"clipfeed/settings"
+	"clipfeed/share"
+	"clipfeed/sources"
+	"clipfeed/transcripts"
 	"clipfeed/worker"
+	"clipfeed/workerpb"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	_ "modernc.org/sqlite"
 )
 
@@ -41,9 +87,11 @@ type testHandlers struct {
 	jobsH       *jobs.Handler
 	profileH    *profile.Handler
 	scoutH      *scout.Handler
+	federationH *federation.Handler
+	notifyH     *notify.Handler
 }
 
-func newTestHandlers(t *testing.T) *testHandlers {
+func newTestHandlers(t testing.TB) *testHandlers {
 	t.Helper()
 	rawDB, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
@@ -64,20 +112,23 @@ func newTestHandlers(t *testing.T) *testHandlers {
 	t.Cleanup(func() { rawDB.Close() })
 
 	compatDB := db.NewCompatDB(rawDB, db.DialectSQLite)
+	notifyH := &notify.Handler{DB: compatDB}
 
 	return &testHandlers{
 		db:           compatDB,
-		authH:        &auth.Handler{DB: compatDB, JWTSecret: "test-secret"},
+		authH:        &auth.Handler{DB: compatDB, JWTSecret: "test-secret", RedeemInvite: invites.Consume},
 		feedH:        &feed.Handler{DB: compatDB, MinioBucket: "test-bucket", LTRModelPath: ""},
 		clipsH:       &clips.Handler{DB: compatDB, Minio: nil, MinioBucket: "test-bucket"},
 		adminH:       &admin.Handler{DB: compatDB, AdminUsername: "admin", AdminPassword: "admin-pw", AdminJWTSecret: "test-admin-secret"},
-		workerH:      &worker.Handler{DB: compatDB, WorkerSecret: "test-worker-secret", CookieSecret: "test-cookie-secret"},
+		workerH:      &worker.Handler{DB: compatDB, WorkerSecret: "test-worker-secret", CookieSecret: "test-cookie-secret", Notifier: notifyH},
 		ingestH:      &ingest.Handler{DB: compatDB},
 		savedH:       &saved.Handler{DB: compatDB, MinioBucket: "test-bucket"},
 		collectionsH: &collections.Handler{DB: compatDB, MinioBucket: "test-bucket"},
 		jobsH:        &jobs.Handler{DB: compatDB},
 		profileH:     &profile.Handler{DB: compatDB, CookieSecret: "test-cookie-secret"},
 		scoutH:       &scout.Handler{DB: compatDB},
+		federationH:  &federation.Handler{DB: compatDB, MinioBucket: "test-bucket", CookieSecret: "test-cookie-secret"},
+		notifyH:      notifyH,
 	}
 }
 
@@ -127,6 +178,65 @@ func withChiParam(r *http.Request, key, value string) *http.Request {
 	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
 }
 
+// --- Server meta ---
+
+func TestHandleMeta_ReportsFeaturesLimitsAndPlatforms(t *testing.T) {
+	h := newTestHandlers(t)
+	rec := httptest.NewRecorder()
+	handleMeta(Config{Federation: true, MaxDownloadMB: 5, MaxVideoSecs: 600}, h.db)(rec, httptest.NewRequest("GET", "/api/meta", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	meta := decodeJSON(t, rec)
+	instance := meta["instance"].(map[string]interface{})
+	if instance["name"] != settings.DefaultInstanceName || instance["registration"] != settings.RegistrationOpen {
+		t.Errorf("instance = %v, want defaults", instance)
+	}
+	if meta["version"] != version {
+		t.Errorf("version = %v, want %q", meta["version"], version)
+	}
+	features := meta["features"].(map[string]interface{})
+	for _, name := range []string{"hls", "semantic_search", "profiles", "federation"} {
+		if _, ok := features[name].(bool); !ok {
+			t.Errorf("feature %q missing or not a bool: %v", name, features[name])
+		}
+	}
+	if features["federation"] != true {
+		t.Errorf("federation = %v, want true", features["federation"])
+	}
+	limits := meta["limits"].(map[string]interface{})
+	if limits["max_upload_bytes"] != float64(5<<20) || limits["max_video_duration_seconds"] != 600.0 || limits["feed_limit"] != float64(feed.FeedLimit) {
+		t.Errorf("limits = %v", limits)
+	}
+	tm := meta["time"].(map[string]interface{})
+	if _, ok := tm["timezone"].(string); !ok {
+		t.Errorf("time.timezone missing: %v", tm)
+	}
+	if _, ok := tm["utc_offset_seconds"].(float64); !ok {
+		t.Errorf("time.utc_offset_seconds missing: %v", tm)
+	}
+	if tm["timestamp_timezone"] != "UTC" || tm["timestamp_layout"] != "2006-01-02T15:04:05Z" {
+		t.Errorf("time = %v, want UTC ISO 8601 timestamps", tm)
+	}
+	if buckets := tm["duration_buckets"].([]interface{}); len(buckets) != 3 {
+		t.Errorf("duration_buckets = %v, want short, medium, long", buckets)
+	}
+	platforms := meta["platforms"].([]interface{})
+	if len(platforms) != len(ingest.SupportedPlatforms) {
+		t.Errorf("platforms = %v, want %v", platforms, ingest.SupportedPlatforms)
+	}
+	for _, u := range []string{"https://youtu.be/x", "https://vimeo.com/1", "https://www.tiktok.com/@a/video/1", "https://x.com/a/status/1", "https://example.com/v.mp4"} {
+		p := ingest.DetectPlatform(u)
+		found := false
+		for _, sp := range platforms {
+			found = found || sp == p
+		}
+		if !found {
+			t.Errorf("DetectPlatform(%q) = %q, not in supported platforms", u, p)
+		}
+	}
+}
+
 // --- buildBrowserStreamURL ---
 
 func TestBuildBrowserStreamURL(t *testing.T) {
@@ -146,6 +256,645 @@ func TestBuildBrowserStreamURL_InvalidURL(t *testing.T) {
 	}
 }
 
+func TestProxyStreams_RefreshAndRevoke(t *testing.T) {
+	h := newTestHandlers(t)
+	h.clipsH.StreamMode = clips.StreamModeProxy
+	h.clipsH.StreamTTL = 10 * time.Minute
+	h.clipsH.StreamSecret = "stream-secret"
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('clip1', 'src1', 'Clip', 30.0, 'clips/clip1.mp4', 'ready')`)
+
+	stream := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleStreamClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/clip1/stream", nil), "id", "clip1"))
+		if rec.Code != 200 {
+			t.Fatalf("stream: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+	// Minio is nil in tests, so a URL that passes every check ends in 503.
+	media := func(url string) int {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleMedia(rec, httptest.NewRequest("GET", url, nil))
+		return rec.Code
+	}
+
+	link := stream()
+	url := link["url"].(string)
+	if !strings.HasPrefix(url, "/api/media?") {
+		t.Fatalf("url = %q, want a proxy URL", url)
+	}
+	expires, _ := time.Parse(time.RFC3339, link["expires_at"].(string))
+	refresh, _ := time.Parse(time.RFC3339, link["refresh_after"].(string))
+	if d := time.Until(expires); d < 9*time.Minute || d > 11*time.Minute {
+		t.Errorf("expires_at = %v, want about 10 minutes out", link["expires_at"])
+	}
+	if !refresh.Before(expires) {
+		t.Errorf("refresh_after %v is not before expires_at %v", link["refresh_after"], link["expires_at"])
+	}
+	if code := media(url); code != 503 {
+		t.Errorf("media with valid URL: status = %d, want 503", code)
+	}
+	if code := media(strings.Replace(url, "gen=0", "gen=1", 1)); code != 403 {
+		t.Errorf("media with tampered URL: status = %d, want 403", code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.clipsH.HandleRefreshStreams(rec, httptest.NewRequest("POST", "/api/streams/refresh",
+		strings.NewReader(`{"clip_ids": ["clip1", "missing"]}`)))
+	if rec.Code != 200 {
+		t.Fatalf("refresh: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	streams := decodeJSON(t, rec)["streams"].(map[string]interface{})
+	if len(streams) != 1 || streams["clip1"] == nil {
+		t.Errorf("refresh streams = %v, want only clip1", streams)
+	}
+
+	// Revoking breaks outstanding URLs; newly issued ones work.
+	rec = httptest.NewRecorder()
+	h.clipsH.HandleRevokeStreams(rec, withChiParam(httptest.NewRequest("POST", "/api/admin/clips/clip1/revoke-streams", nil), "id", "clip1"))
+	if rec.Code != 200 {
+		t.Fatalf("revoke: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	if resp := decodeJSON(t, rec); resp["immediate"] != true || resp["clip_status"] != "ready" {
+		t.Errorf("revoke response = %v, want immediate and still ready", resp)
+	}
+	if code := media(url); code != 410 {
+		t.Errorf("media with revoked URL: status = %d, want 410", code)
+	}
+	url = stream()["url"].(string)
+	if code := media(url); code != 503 {
+		t.Errorf("media with reissued URL: status = %d, want 503", code)
+	}
+
+	// Taking the clip down revokes its URLs and stops new ones.
+	rec = httptest.NewRecorder()
+	h.clipsH.HandleRevokeStreams(rec, withChiParam(httptest.NewRequest("POST", "/api/admin/clips/clip1/revoke-streams",
+		strings.NewReader(`{"take_down": true}`)), "id", "clip1"))
+	if rec.Code != 200 {
+		t.Fatalf("take down: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	if code := media(url); code != 410 {
+		t.Errorf("media after take down: status = %d, want 410", code)
+	}
+	rec = httptest.NewRecorder()
+	h.clipsH.HandleStreamClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/clip1/stream", nil), "id", "clip1"))
+	if rec.Code != 404 {
+		t.Errorf("stream after take down: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestDataSaver_RenditionsPreferenceAndOverride(t *testing.T) {
+	h := newTestHandlers(t)
+	h.clipsH.StreamMode = clips.StreamModeProxy
+	h.clipsH.StreamSecret = "stream-secret"
+	token := registerUser(t, h, "metered", "password123")
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status) VALUES ('clip1', 'src1', 'Clip', 30.0, 'clips/clip1/clip.mp4', 'clips/clip1/thumbnail.jpg', 'ready')`)
+
+	setRenditions := func(clipID, body string) int {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleSetRenditions(rec, withChiParam(httptest.NewRequest("PUT", "/api/internal/clips/"+clipID+"/renditions",
+			strings.NewReader(body)), "id", clipID))
+		return rec.Code
+	}
+	if code := setRenditions("missing", `{"low_storage_key": "x"}`); code != 404 {
+		t.Errorf("missing clip: status = %d, want 404", code)
+	}
+	if code := setRenditions("clip1", `{}`); code != 400 {
+		t.Errorf("no renditions: status = %d, want 400", code)
+	}
+	if code := setRenditions("clip1", `{"low_storage_key": "clips/clip1/low.mp4", "small_thumbnail_key": "clips/clip1/thumbnail_small.jpg"}`); code != 200 {
+		t.Fatalf("set renditions: status = %d", code)
+	}
+
+	// Requests go through the middleware that reads the override header.
+	serve := func(hf http.HandlerFunc, req *http.Request, saver string) *httptest.ResponseRecorder {
+		if saver != "" {
+			req.Header.Set(datasaver.Header, saver)
+		}
+		rec := httptest.NewRecorder()
+		datasaver.Middleware(hf).ServeHTTP(rec, req)
+		return rec
+	}
+	stream := func(token, saver string) (string, string) {
+		t.Helper()
+		rec := serve(h.clipsH.HandleStreamClip, withChiParam(authRequest(t, h, "GET", "/api/clips/clip1/stream", nil, token), "id", "clip1"), saver)
+		if rec.Code != 200 {
+			t.Fatalf("stream: status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		link := decodeJSON(t, rec)
+		return link["quality"].(string), link["url"].(string)
+	}
+
+	if quality, _ := stream("", ""); quality != "standard" {
+		t.Errorf("default quality = %s, want standard", quality)
+	}
+	quality, url := stream("", "on")
+	if quality != "low" || !strings.Contains(url, "low.mp4") {
+		t.Errorf("header override: quality %s, url %s; want the low rendition", quality, url)
+	}
+	rec := httptest.NewRecorder()
+	h.clipsH.HandleMedia(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != 503 { // passes the signature and generation checks; Minio is nil
+		t.Errorf("low rendition media: status = %d, want 503", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences", map[string]interface{}{"data_saver": true}, token))
+	if rec.Code != 200 {
+		t.Fatalf("save preference: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if quality, _ := stream(token, ""); quality != "low" {
+		t.Errorf("with preference: quality = %s, want low", quality)
+	}
+	if quality, _ := stream(token, "off"); quality != "standard" {
+		t.Errorf("preference overridden off: quality = %s, want standard", quality)
+	}
+
+	feedThumb := func(saver string) string {
+		rec := serve(h.feedH.HandleFeed, httptest.NewRequest("GET", "/api/feed", nil), saver)
+		clips := decodeJSON(t, rec)["clips"].([]interface{})
+		if len(clips) != 1 {
+			t.Fatalf("feed clips = %v", clips)
+		}
+		return clips[0].(map[string]interface{})["thumbnail_url"].(string)
+	}
+	if thumb := feedThumb(""); !strings.HasSuffix(thumb, "/thumbnail.jpg") {
+		t.Errorf("feed thumbnail = %s, want the full-size one", thumb)
+	}
+	if thumb := feedThumb("on"); !strings.HasSuffix(thumb, "/thumbnail_small.jpg") {
+		t.Errorf("data saver feed thumbnail = %s, want the small one", thumb)
+	}
+}
+
+func TestClipPreviews_QueueShareRenderAndLimit(t *testing.T) {
+	h := newTestHandlers(t)
+	alice := registerUser(t, h, "gifalice", "password123")
+	bob := registerUser(t, h, "gifbob", "password123")
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('clip1', 'src1', 'Clip', 30.0, 'clips/clip1/clip.mp4', 'ready')`)
+
+	create := func(clipID, query, token string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleCreatePreview(rec, withChiParam(authRequest(t, h, "POST", "/api/clips/"+clipID+"/gif?"+query, nil, token), "id", clipID))
+		return rec.Code, decodeJSON(t, rec)
+	}
+	for query, want := range map[string]int{
+		"start=2":                  400,
+		"start=0&end=12":           400, // longer than 10s
+		"start=25&end=31":          400, // past the end of the clip
+		"start=5&end=2":            400,
+		"start=1&end=2&format=mp4": 400,
+	} {
+		if code, _ := create("clip1", query, alice); code != want {
+			t.Errorf("%s: status = %d, want %d", query, code, want)
+		}
+	}
+	if code, _ := create("missing", "start=1&end=2", alice); code != 404 {
+		t.Errorf("missing clip: status = %d, want 404", code)
+	}
+
+	code, p := create("clip1", "start=2&end=5.5", alice)
+	if code != 202 || p["status"] != "queued" || p["job_id"] == nil {
+		t.Fatalf("queue preview: status = %d, preview = %v", code, p)
+	}
+	previewID := p["id"].(string)
+	// The same range is shared rather than rendered twice.
+	if code, again := create("clip1", "start=2.0&end=5.50", bob); code != 202 || again["id"] != previewID {
+		t.Errorf("same range: status = %d, preview = %v, want %s", code, again, previewID)
+	}
+
+	rec := httptest.NewRecorder()
+	h.workerH.HandleClaimJob(rec, httptest.NewRequest("POST", "/api/internal/jobs/claim", strings.NewReader(`{"job_types": ["preview"]}`)))
+	job := decodeJSON(t, rec)
+	payload, _ := job["payload"].(map[string]interface{})
+	if job["job_type"] != "preview" || payload["preview_id"] != previewID || payload["start"] != 2.0 || payload["end"] != 5.5 ||
+		payload["output_key"] != "clips/clip1/previews/"+previewID+".gif" {
+		t.Fatalf("claimed job = %v", job)
+	}
+	previewRequest := func(method, url string, body io.Reader) *http.Request {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "clip1")
+		rctx.URLParams.Add("previewId", previewID)
+		req := httptest.NewRequest(method, url, body)
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+	get := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleGetPreview(rec, previewRequest("GET", "/api/clips/clip1/gif/"+previewID, nil))
+		if rec.Code != 200 {
+			t.Fatalf("get preview: status = %d", rec.Code)
+		}
+		return decodeJSON(t, rec)
+	}
+	if got := get(); got["status"] != "running" || got["url"] != nil {
+		t.Errorf("while rendering: %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.workerH.HandleSetPreview(rec, previewRequest("PUT", "/api/internal/clips/clip1/previews/"+previewID,
+		strings.NewReader(`{"storage_key": "`+payload["output_key"].(string)+`", "file_size_bytes": 48000}`)))
+	if rec.Code != 200 {
+		t.Fatalf("set preview: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	wantURL := "/storage/test-bucket/clips/clip1/previews/" + previewID + ".gif"
+	if got := get(); got["status"] != "ready" || got["url"] != wantURL || got["file_size_bytes"] != 48000.0 {
+		t.Errorf("after upload: %v", got)
+	}
+	if code, again := create("clip1", "start=2&end=5.5", bob); code != 200 || again["url"] != wantURL {
+		t.Errorf("rendered range: status = %d, preview = %v", code, again)
+	}
+
+	// Each user may only have a few previews rendering at once.
+	for i, query := range []string{"start=0&end=1", "start=1&end=2", "start=2&end=3"} {
+		if code, _ := create("clip1", query, bob); code != 202 {
+			t.Fatalf("preview %d: status = %d", i, code)
+		}
+	}
+	if code, _ := create("clip1", "start=3&end=4", bob); code != 429 {
+		t.Errorf("past the pending limit: status = %d, want 429", code)
+	}
+	if code, _ := create("clip1", "start=3&end=4&format=webm", alice); code != 202 {
+		t.Errorf("another user: status = %d, want 202", code)
+	}
+}
+
+func TestStreamUsage_AttributedAndConcurrentLimit(t *testing.T) {
+	h := newTestHandlers(t)
+	h.clipsH.StreamMode = clips.StreamModeProxy
+	h.clipsH.StreamSecret = "stream-secret"
+	token := registerUser(t, h, "streamer", "password123")
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	for _, id := range []string{"clip1", "clip2"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES (?, 'src1', 'Clip', 30.0, ?, 'ready')`,
+			id, "clips/"+id+".mp4")
+	}
+
+	stream := func(clipID, device string) *httptest.ResponseRecorder {
+		req := authRequest(t, h, "GET", "/api/clips/"+clipID+"/stream", nil, token)
+		req.Header.Set("X-Device-ID", device)
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleStreamClip(rec, withChiParam(req, "id", clipID))
+		return rec
+	}
+	media := func(url string) int {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleMedia(rec, httptest.NewRequest("GET", url, nil))
+		return rec.Code
+	}
+
+	// URLs issued to a user name them, and the name cannot be swapped.
+	rec := stream("clip1", "phone")
+	if rec.Code != 200 {
+		t.Fatalf("stream: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	url := decodeJSON(t, rec)["url"].(string)
+	if !strings.Contains(url, "uid="+userID) {
+		t.Fatalf("url = %q, want it attributed to %s", url, userID)
+	}
+	if code := media(url); code != 503 {
+		t.Errorf("media with valid URL: status = %d, want 503", code)
+	}
+	if code := media(strings.Replace(url, "uid="+userID, "uid=someone-else", 1)); code != 403 {
+		t.Errorf("media with swapped uid: status = %d, want 403", code)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	h.db.Exec(`INSERT INTO stream_usage (user_id, day, bytes_served, requests) VALUES (?, ?, 1500, 3), (?, '2000-01-01', 99, 1)`,
+		userID, today, userID)
+	rec = httptest.NewRecorder()
+	h.clipsH.HandleMyUsage(rec, authRequest(t, h, "GET", "/api/me/usage", nil, token))
+	if rec.Code != 200 {
+		t.Fatalf("usage: status = %d", rec.Code)
+	}
+	usage := decodeJSON(t, rec)
+	if usage["total_bytes"].(float64) != 1500 || len(usage["days"].([]interface{})) != 1 || usage["bytes_tracked"] != true {
+		t.Errorf("usage = %v, want today's 1500 bytes only", usage)
+	}
+	if streams := usage["streams"].([]interface{}); len(streams) != 1 || streams[0].(map[string]interface{})["device_id"] != "phone" {
+		t.Errorf("streams = %v, want the phone", streams)
+	}
+
+	// With a limit of one, the phone may switch clips but a tablet must wait.
+	rec = httptest.NewRecorder()
+	h.adminH.HandleSetStreamLimit(rec, withChiParam(httptest.NewRequest("PUT", "/api/admin/users/"+userID+"/stream-limit",
+		strings.NewReader(`{"max_concurrent_streams": 1}`)), "id", userID))
+	if rec.Code != 200 {
+		t.Fatalf("set stream limit: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := stream("clip2", "phone"); rec.Code != 200 {
+		t.Errorf("same device switching clips: status = %d, want 200", rec.Code)
+	}
+	rec = stream("clip1", "tablet")
+	if rec.Code != 429 {
+		t.Fatalf("second device: status = %d, want 429", rec.Code)
+	}
+	if resp := decodeJSON(t, rec); resp["active_streams"].(float64) != 1 {
+		t.Errorf("limit response = %v, want one active stream", resp)
+	}
+
+	// Once the phone goes quiet the tablet may start.
+	h.db.Exec(`UPDATE stream_sessions SET last_seen_at = '2000-01-01T00:00:00Z' WHERE device_id = 'phone'`)
+	if rec := stream("clip1", "tablet"); rec.Code != 200 {
+		t.Errorf("after phone stopped: status = %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.adminH.HandleDeleteStreamLimit(rec, withChiParam(httptest.NewRequest("DELETE", "/api/admin/users/"+userID+"/stream-limit", nil), "id", userID))
+	if rec.Code != 200 {
+		t.Fatalf("delete stream limit: status = %d", rec.Code)
+	}
+	if rec := stream("clip2", "phone"); rec.Code != 200 {
+		t.Errorf("without a limit: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestAdminRoles_LoginPermissionsAndAudit(t *testing.T) {
+	h := newTestHandlers(t)
+	registerUser(t, h, "modsam", "password123")
+	var modID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'modsam'`).Scan(&modID)
+
+	r := chi.NewRouter()
+	r.Post("/api/admin/login", h.adminH.HandleAdminLogin)
+	r.Group(func(r chi.Router) {
+		r.Use(h.adminH.AdminAuthMiddleware)
+		r.Get("/api/admin/me", h.adminH.HandleAdminMe)
+		r.With(h.adminH.Require(admin.PermModeration)).Get("/api/admin/interaction-flags", h.adminH.HandleListInteractionFlags)
+		r.With(h.adminH.Require(admin.PermSettings)).Get("/api/admin/settings", h.adminH.HandleGetSettings)
+		r.Group(func(r chi.Router) {
+			r.Use(h.adminH.Require(admin.PermRoles))
+			r.Put("/api/admin/users/{id}/role", h.adminH.HandleSetRole)
+			r.Delete("/api/admin/users/{id}/role", h.adminH.HandleDeleteRole)
+		})
+	})
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(username, password string) *httptest.ResponseRecorder {
+		return do("POST", "/api/admin/login", `{"username":"`+username+`","password":"`+password+`"}`, "")
+	}
+
+	// Without a role, a user's own credentials do not open the admin API.
+	if rec := login("modsam", "password123"); rec.Code != 401 {
+		t.Fatalf("login without role: status = %d, want 401", rec.Code)
+	}
+
+	rec := login("admin", "admin-pw")
+	if rec.Code != 200 {
+		t.Fatalf("owner login: status = %d", rec.Code)
+	}
+	ownerToken := decodeJSON(t, rec)["token"].(string)
+	if rec := do("PUT", "/api/admin/users/"+modID+"/role", `{"role":"janitor"}`, ownerToken); rec.Code != 400 {
+		t.Errorf("unknown role: status = %d, want 400", rec.Code)
+	}
+	if rec := do("PUT", "/api/admin/users/nobody/role", `{"role":"curator"}`, ownerToken); rec.Code != 404 {
+		t.Errorf("unknown user: status = %d, want 404", rec.Code)
+	}
+	if rec := do("PUT", "/api/admin/users/"+modID+"/role", `{"role":"moderator"}`, ownerToken); rec.Code != 200 {
+		t.Fatalf("set role: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var actor, details string
+	h.db.QueryRow(`SELECT actor, details FROM audit_log WHERE action = 'admin.role_set' AND target_user_id = ?`, modID).Scan(&actor, &details)
+	if actor != "admin" || !strings.Contains(details, `"role":"moderator"`) {
+		t.Errorf("audit entry = %q %s, want the owner granting moderator", actor, details)
+	}
+
+	rec = login("modsam", "password123")
+	if rec.Code != 200 {
+		t.Fatalf("moderator login: status = %d", rec.Code)
+	}
+	modLogin := decodeJSON(t, rec)
+	if modLogin["role"] != "moderator" {
+		t.Errorf("login role = %v, want moderator", modLogin["role"])
+	}
+	modToken := modLogin["token"].(string)
+
+	rec = do("GET", "/api/admin/me", "", modToken)
+	me := decodeJSON(t, rec)
+	if me["actor"] != modID || me["role"] != "moderator" || len(me["permissions"].([]interface{})) != 2 {
+		t.Errorf("me = %v, want the moderator with content and moderation", me)
+	}
+	if rec := do("GET", "/api/admin/interaction-flags", "", modToken); rec.Code != 200 {
+		t.Errorf("moderator on flags: status = %d, want 200", rec.Code)
+	}
+	if rec := do("GET", "/api/admin/settings", "", modToken); rec.Code != 403 {
+		t.Errorf("moderator on settings: status = %d, want 403", rec.Code)
+	}
+	if rec := do("PUT", "/api/admin/users/"+modID+"/role", `{"role":"owner"}`, modToken); rec.Code != 403 {
+		t.Errorf("moderator promoting themselves: status = %d, want 403", rec.Code)
+	}
+
+	// Taking the role away locks the moderator out at once.
+	if rec := do("DELETE", "/api/admin/users/"+modID+"/role", "", ownerToken); rec.Code != 200 {
+		t.Fatalf("delete role: status = %d", rec.Code)
+	}
+	if rec := do("GET", "/api/admin/me", "", modToken); rec.Code != 401 {
+		t.Errorf("after removal: status = %d, want 401", rec.Code)
+	}
+	var removals int
+	h.db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action = 'admin.role_remove' AND target_user_id = ?`, modID).Scan(&removals)
+	if removals != 1 {
+		t.Errorf("removal audit entries = %d, want 1", removals)
+	}
+}
+
+func TestStoryboard_UploadAndServeSignedSprites(t *testing.T) {
+	h := newTestHandlers(t)
+	h.clipsH.StreamMode = clips.StreamModeProxy
+	h.clipsH.StreamSecret = "stream-secret"
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('clip1', 'src1', 'Clip', 30.0, 'clips/clip1.mp4', 'ready')`)
+
+	upload := func(body map[string]interface{}) int {
+		rec := httptest.NewRecorder()
+		b, _ := json.Marshal(body)
+		req := withChiParam(httptest.NewRequest("PUT", "/api/internal/clips/clip1/storyboard", bytes.NewReader(b)), "id", "clip1")
+		h.workerH.HandleSetStoryboard(rec, req)
+		return rec.Code
+	}
+	vtt := "WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nstoryboard_0.jpg#xywh=0,0,160,90\n\n" +
+		"00:00:05.000 --> 00:00:10.000\nclips/clip1/storyboard_0.jpg#xywh=160,0,160,90\n"
+	sprites := []string{"clips/clip1/storyboard_0.jpg"}
+	for _, bad := range []map[string]interface{}{
+		{"vtt": vtt},
+		{"vtt": "00:00:00.000 --> 00:00:05.000\nstoryboard_0.jpg#xywh=0,0,160,90\n", "sprite_keys": sprites},
+		{"vtt": "WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nother.jpg#xywh=0,0,160,90\n", "sprite_keys": sprites},
+		{"vtt": vtt, "sprite_keys": []string{"../secrets.jpg"}},
+	} {
+		if code := upload(bad); code != 400 {
+			t.Errorf("upload %v: status = %d, want 400", bad, code)
+		}
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleStoryboard(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/clip1/storyboard.vtt", nil), "id", "clip1"))
+		return rec
+	}
+	if rec := serve(); rec.Code != 404 {
+		t.Errorf("storyboard before upload: status = %d, want 404", rec.Code)
+	}
+	if code := upload(map[string]interface{}{"vtt": vtt, "sprite_keys": sprites}); code != 200 {
+		t.Fatalf("upload storyboard: status = %d", code)
+	}
+
+	rec := serve()
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/vtt") {
+		t.Fatalf("storyboard: status = %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var spriteURLs []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if url, fragment, ok := strings.Cut(line, "#xywh="); ok {
+			if !strings.HasPrefix(url, "/api/media?") || fragment == "" {
+				t.Errorf("cue payload %q, want a signed sprite URL with its tile", line)
+			}
+			spriteURLs = append(spriteURLs, url)
+		}
+	}
+	if len(spriteURLs) != 2 || spriteURLs[0] != spriteURLs[1] {
+		t.Fatalf("sprite URLs = %v, want the same signed URL for both cues", spriteURLs)
+	}
+
+	// Minio is nil in tests, so a sprite URL that passes every check ends in
+	// 503; revoking the clip's streams revokes its sprites too.
+	media := func() int {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleMedia(rec, httptest.NewRequest("GET", spriteURLs[0], nil))
+		return rec.Code
+	}
+	if code := media(); code != 503 {
+		t.Errorf("sprite media: status = %d, want 503", code)
+	}
+	h.clipsH.HandleRevokeStreams(httptest.NewRecorder(), withChiParam(httptest.NewRequest("POST", "/", nil), "id", "clip1"))
+	if code := media(); code != 410 {
+		t.Errorf("sprite media after revoke: status = %d, want 410", code)
+	}
+}
+
+func TestHLS_UploadAndServeSignedPlaylists(t *testing.T) {
+	h := newTestHandlers(t)
+	h.clipsH.StreamMode = clips.StreamModeProxy
+	h.clipsH.StreamSecret = "stream-secret"
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('clip1', 'src1', 'Clip', 30.0, 'clips/clip1.mp4', 'ready')`)
+
+	upload := func(body map[string]interface{}) int {
+		rec := httptest.NewRecorder()
+		b, _ := json.Marshal(body)
+		req := withChiParam(httptest.NewRequest("PUT", "/api/internal/clips/clip1/hls", bytes.NewReader(b)), "id", "clip1")
+		h.workerH.HandleSetHLS(rec, req)
+		return rec.Code
+	}
+	playlist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:4.000,\nsegment_000.ts\n#EXTINF:2.000,\nclips/clip1/hls/%[1]s/segment_001.ts\n#EXT-X-ENDLIST\n"
+	variant := func(name string, bandwidth, height int) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name, "bandwidth": bandwidth, "width": height * 16 / 9, "height": height,
+			"codecs": "avc1.64001f,mp4a.40.2", "playlist_key": "clips/clip1/hls/" + name + "/index.m3u8",
+			"playlist": fmt.Sprintf(playlist, name),
+			"segment_keys": []string{
+				"clips/clip1/hls/" + name + "/segment_000.ts", "clips/clip1/hls/" + name + "/segment_001.ts",
+			},
+		}
+	}
+	unknownSegment := variant("360p", 800000, 360)
+	unknownSegment["playlist"] = "#EXTM3U\n#EXTINF:4.000,\nother.ts\n"
+	for _, bad := range []map[string]interface{}{
+		{"variants": []interface{}{variant("360p", 800000, 360)}},
+		{"master_key": "clips/clip1/hls/master.m3u8", "variants": []interface{}{variant("360P", 800000, 360)}},
+		{"master_key": "clips/clip1/hls/master.m3u8", "variants": []interface{}{unknownSegment}},
+		{"master_key": "clips/clip1/hls/master.m3u8", "variants": []interface{}{variant("360p", 800000, 360), variant("360p", 900000, 360)}},
+	} {
+		if code := upload(bad); code != 400 {
+			t.Errorf("upload %v: status = %d, want 400", bad, code)
+		}
+	}
+
+	stream := func(format string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleStreamClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/clip1/stream?format="+format, nil), "id", "clip1"))
+		return rec
+	}
+	if rec := stream("webm"); rec.Code != 400 {
+		t.Errorf("stream format=webm: status = %d, want 400", rec.Code)
+	}
+	if rec := stream("hls"); rec.Code != 404 {
+		t.Errorf("stream format=hls before transcode: status = %d, want 404", rec.Code)
+	}
+	if code := upload(map[string]interface{}{
+		"master_key": "clips/clip1/hls/master.m3u8",
+		"variants":   []interface{}{variant("720p", 2500000, 720), variant("360p", 800000, 360)},
+	}); code != 200 {
+		t.Fatalf("upload HLS renditions: status = %d", code)
+	}
+
+	rec := stream("hls")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/vnd.apple.mpegurl" {
+		t.Fatalf("master playlist: status = %d, content type %q; body: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 6 || lines[0] != "#EXTM3U" ||
+		lines[2] != `#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.64001f,mp4a.40.2"` ||
+		!strings.HasPrefix(lines[3], "/api/clips/clip1/hls/360p.m3u8?") ||
+		!strings.HasPrefix(lines[5], "/api/clips/clip1/hls/720p.m3u8?") {
+		t.Fatalf("master playlist = %q, want 360p then 720p", rec.Body.String())
+	}
+	if rec := stream("mp4"); rec.Code != 200 || !strings.HasPrefix(decodeJSON(t, rec)["url"].(string), "/api/media?") {
+		t.Errorf("stream format=mp4 after transcode: status = %d, want an MP4 stream URL", rec.Code)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/clips/{id}/hls/{variant}.m3u8", h.clipsH.HandleHLSPlaylist)
+	media := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		if strings.HasPrefix(url, "/api/media?") {
+			h.clipsH.HandleMedia(rec, httptest.NewRequest("GET", url, nil))
+		} else {
+			router.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		}
+		return rec
+	}
+	rec = media(lines[3])
+	if rec.Code != 200 {
+		t.Fatalf("360p playlist: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	var segmentURLs []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			segmentURLs = append(segmentURLs, line)
+		}
+	}
+	if len(segmentURLs) != 2 || !strings.HasPrefix(segmentURLs[0], "/api/media?") || segmentURLs[0] == segmentURLs[1] {
+		t.Fatalf("segment URLs = %v, want two signed media URLs", segmentURLs)
+	}
+	if rec := media(strings.Replace(lines[3], "360p", "720p", 1)); rec.Code != 403 {
+		t.Errorf("playlist signed for another variant: status = %d, want 403", rec.Code)
+	}
+
+	// Minio is nil in tests, so a segment URL that passes every check ends
+	// in 503; revoking the clip's streams revokes its playlists and segments.
+	if rec := media(segmentURLs[1]); rec.Code != 503 {
+		t.Errorf("segment media: status = %d, want 503", rec.Code)
+	}
+	h.clipsH.HandleRevokeStreams(httptest.NewRecorder(), withChiParam(httptest.NewRequest("POST", "/", nil), "id", "clip1"))
+	if rec := media(lines[3]); rec.Code != 410 {
+		t.Errorf("playlist after revoke: status = %d, want 410", rec.Code)
+	}
+	if rec := media(segmentURLs[1]); rec.Code != 410 {
+		t.Errorf("segment media after revoke: status = %d, want 410", rec.Code)
+	}
+}
+
 // --- detectPlatform ---
 
 func TestDetectPlatform(t *testing.T) {
@@ -174,6 +923,30 @@ func TestDetectPlatform(t *testing.T) {
 	}
 }
 
+// --- Attribution deep links ---
+
+func TestDeepLink(t *testing.T) {
+	tests := []struct {
+		platform, url string
+		want          string
+		supported     bool
+	}{
+		{"youtube", "https://www.youtube.com/watch?v=Aq5WXmQQooo", "https://www.youtube.com/watch?t=123s&v=Aq5WXmQQooo", true},
+		{"youtube", "https://youtu.be/UtdGSaJNb-g", "https://youtu.be/UtdGSaJNb-g?t=123s", true},
+		{"youtube", "https://www.youtube.com/shorts/abc123", "https://www.youtube.com/watch?t=123s&v=abc123", true},
+		{"vimeo", "https://vimeo.com/85923309", "https://vimeo.com/85923309#t=123s", true},
+		{"tiktok", "https://www.tiktok.com/@u/video/1", "https://www.tiktok.com/@u/video/1", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			got, supported := httputil.DeepLink(tc.platform, tc.url, 123.7)
+			if got != tc.want || supported != tc.supported {
+				t.Errorf("DeepLink(%q) = %q, %v; want %q, %v", tc.url, got, supported, tc.want, tc.supported)
+			}
+		})
+	}
+}
+
 // --- getEnv ---
 
 func TestGetEnv(t *testing.T) {
@@ -284,15 +1057,207 @@ func TestRegister_InvalidJSON(t *testing.T) {
 	}
 }
 
-// --- Auth: Login ---
-
-func TestLogin_Success(t *testing.T) {
+func TestInstanceSettings_BrandingRegistrationAndDefaults(t *testing.T) {
 	h := newTestHandlers(t)
-	registerUser(t, h, "loginuser", "password123")
-
-	body := `{"username":"loginuser","password":"password123"}`
-	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(body))
-	rec := httptest.NewRecorder()
+	updateSettings := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.adminH.HandleUpdateSettings(rec, httptest.NewRequest("PUT", "/api/admin/settings", bytes.NewBufferString(body)))
+		return rec
+	}
+	register := func(username, invite string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(map[string]string{
+			"username": username, "email": username + "@test.com", "password": "password123", "invite_code": invite,
+		})
+		rec := httptest.NewRecorder()
+		h.authH.HandleRegister(rec, httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(b)))
+		return rec
+	}
+
+	for _, bad := range []string{
+		`{"registration":"maybe"}`,
+		`{"instance_name":""}`,
+		`{"default_preferences":{"exploration_rate":2}}`,
+		`{"default_preferences":{"nope":1}}`,
+		`{"theme":"dark"}`,
+	} {
+		if rec := updateSettings(bad); rec.Code != 400 {
+			t.Errorf("update %s: status = %d, want 400", bad, rec.Code)
+		}
+	}
+
+	rec := updateSettings(`{"instance_name":"Clip Club","description":"Friends only","logo_key":"branding/logo.png",
+		"registration":"invite-only","default_preferences":{"exploration_rate":0.6,"autoplay":false}}`)
+	if rec.Code != 200 {
+		t.Fatalf("update settings: %d %s", rec.Code, rec.Body.String())
+	}
+	// A partial update keeps the other fields.
+	if rec := updateSettings(`{"description":"Friends and family"}`); rec.Code != 200 {
+		t.Fatalf("partial update: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleMeta(Config{MinioBucket: "test-bucket"}, h.db)(rec, httptest.NewRequest("GET", "/api/meta", nil))
+	instance := decodeJSON(t, rec)["instance"].(map[string]interface{})
+	if instance["name"] != "Clip Club" || instance["description"] != "Friends and family" ||
+		instance["logo_url"] != "/storage/test-bucket/branding/logo.png" || instance["registration"] != "invite-only" {
+		t.Errorf("meta instance = %v", instance)
+	}
+
+	if rec := register("noinvite", ""); rec.Code != 403 {
+		t.Errorf("register without invite: status = %d, want 403", rec.Code)
+	}
+	if rec := register("badinvite", "not-a-code"); rec.Code != 403 {
+		t.Errorf("register with unknown invite: status = %d, want 403", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.adminH.HandleCreateInvite(rec, httptest.NewRequest("POST", "/api/admin/invites", bytes.NewBufferString(`{"note":"for sam"}`)))
+	if rec.Code != 201 {
+		t.Fatalf("create invite: %d %s", rec.Code, rec.Body.String())
+	}
+	code := decodeJSON(t, rec)["code"].(string)
+
+	rec = register("invited", code)
+	if rec.Code != 201 {
+		t.Fatalf("register with invite: %d %s", rec.Code, rec.Body.String())
+	}
+	userID := decodeJSON(t, rec)["user_id"].(string)
+	var exploration float64
+	var autoplay, trending int
+	h.db.QueryRow(`SELECT exploration_rate, autoplay, trending_boost FROM user_preferences WHERE user_id = ?`, userID).
+		Scan(&exploration, &autoplay, &trending)
+	if exploration != 0.6 || autoplay != 0 || trending != 1 {
+		t.Errorf("preferences = %v/%d/%d, want instance defaults with schema defaults elsewhere", exploration, autoplay, trending)
+	}
+
+	if rec := register("again", code); rec.Code != 403 {
+		t.Errorf("reused invite: status = %d, want 403", rec.Code)
+	}
+	var users int
+	h.db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = 'again'`).Scan(&users)
+	if users != 0 {
+		t.Error("user created with a used invite")
+	}
+
+	rec = httptest.NewRecorder()
+	h.adminH.HandleDeleteInvite(rec, withChiParam(httptest.NewRequest("DELETE", "/api/admin/invites/"+code, nil), "code", code))
+	if rec.Code != 404 {
+		t.Errorf("delete used invite: status = %d, want 404", rec.Code)
+	}
+
+	updateSettings(`{"registration":"closed"}`)
+	if rec := register("closedout", ""); rec.Code != 403 {
+		t.Errorf("register while closed: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestInvites_QuotasAndTracking(t *testing.T) {
+	h := newTestHandlers(t)
+	invitesH := &invites.Handler{DB: h.db}
+	setRegistration := func(mode string) {
+		rec := httptest.NewRecorder()
+		h.adminH.HandleUpdateSettings(rec, httptest.NewRequest("PUT", "/api/admin/settings",
+			bytes.NewBufferString(`{"registration":"`+mode+`"}`)))
+		if rec.Code != 200 {
+			t.Fatalf("set registration %s: %d %s", mode, rec.Code, rec.Body.String())
+		}
+	}
+	register := func(username, invite string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(map[string]string{
+			"username": username, "email": username + "@test.com", "password": "password123", "invite_code": invite,
+		})
+		rec := httptest.NewRecorder()
+		h.authH.HandleRegister(rec, httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(b)))
+		return rec
+	}
+
+	setRegistration("closed")
+	rec := httptest.NewRecorder()
+	h.adminH.HandleCreateInvite(rec, httptest.NewRequest("POST", "/api/admin/invites",
+		bytes.NewBufferString(`{"invite_quota":1,"daily_ingest_quota":1,"expires_in_hours":24}`)))
+	if rec.Code != 201 {
+		t.Fatalf("create invite: %d %s", rec.Code, rec.Body.String())
+	}
+	adminCode := decodeJSON(t, rec)["code"].(string)
+
+	rec = register("alice", adminCode)
+	if rec.Code != 201 {
+		t.Fatalf("register alice: %d %s", rec.Code, rec.Body.String())
+	}
+	alice := decodeJSON(t, rec)["token"].(string)
+
+	rec = httptest.NewRecorder()
+	invitesH.HandleCreateMyInvite(rec, authRequest(t, h, "POST", "/api/me/invites", map[string]string{"note": "for bob"}, alice))
+	if rec.Code != 201 {
+		t.Fatalf("alice create invite: %d %s", rec.Code, rec.Body.String())
+	}
+	aliceCode := decodeJSON(t, rec)["code"].(string)
+	rec = httptest.NewRecorder()
+	invitesH.HandleCreateMyInvite(rec, authRequest(t, h, "POST", "/api/me/invites", nil, alice))
+	if rec.Code != 403 {
+		t.Errorf("invite beyond quota: status = %d, want 403", rec.Code)
+	}
+
+	// Closed registration only admits invites from admins.
+	if rec := register("bob", aliceCode); rec.Code != 403 {
+		t.Errorf("user invite while closed: status = %d, want 403", rec.Code)
+	}
+	setRegistration("invite-only")
+	if rec := register("bob", aliceCode); rec.Code != 201 {
+		t.Fatalf("register bob: %d %s", rec.Code, rec.Body.String())
+	}
+	var invitedBy string
+	var inviteQuota int
+	var ingestQuota *int
+	h.db.QueryRow(`SELECT COALESCE(i.username, ''), u.invite_quota, u.daily_ingest_quota
+		FROM users u LEFT JOIN users i ON i.id = u.invited_by WHERE u.username = 'bob'`).Scan(&invitedBy, &inviteQuota, &ingestQuota)
+	if invitedBy != "alice" || inviteQuota != 0 || ingestQuota == nil || *ingestQuota != 1 {
+		t.Errorf("bob invited_by=%q invite_quota=%d daily_ingest_quota=%v, want alice/0/1", invitedBy, inviteQuota, ingestQuota)
+	}
+
+	rec = httptest.NewRecorder()
+	invitesH.HandleListMyInvites(rec, authRequest(t, h, "GET", "/api/me/invites", nil, alice))
+	mine := decodeJSON(t, rec)
+	if mine["remaining"] != 0.0 || len(mine["invites"].([]interface{})) != 1 ||
+		mine["invites"].([]interface{})[0].(map[string]interface{})["used_by"] != "bob" {
+		t.Errorf("alice's invites = %v", mine)
+	}
+
+	rec = httptest.NewRecorder()
+	h.adminH.HandleListInvites(rec, httptest.NewRequest("GET", "/api/admin/invites?status=used", nil))
+	list := decodeJSON(t, rec)["invites"].([]interface{})
+	if len(list) != 2 {
+		t.Fatalf("used invites = %v, want 2", list)
+	}
+	issuers := map[interface{}]interface{}{}
+	for _, inv := range list {
+		m := inv.(map[string]interface{})
+		issuers[m["used_by"]] = m["created_by"]
+	}
+	if issuers["alice"] != nil || issuers["bob"] != "alice" {
+		t.Errorf("who invited whom = %v", issuers)
+	}
+
+	// Alice's daily ingest quota is 1.
+	for i, want := range []int{202, 429} {
+		rec := httptest.NewRecorder()
+		h.ingestH.HandleIngest(rec, authRequest(t, h, "POST", "/api/ingest",
+			map[string]string{"url": fmt.Sprintf("https://youtube.com/watch?v=quota%d", i)}, alice))
+		if rec.Code != want {
+			t.Errorf("ingest %d: status = %d, want %d", i, rec.Code, want)
+		}
+	}
+}
+
+// --- Auth: Login ---
+
+func TestLogin_Success(t *testing.T) {
+	h := newTestHandlers(t)
+	registerUser(t, h, "loginuser", "password123")
+
+	body := `{"username":"loginuser","password":"password123"}`
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
 
 	h.authH.HandleLogin(rec, req)
 
@@ -335,6 +1300,128 @@ func TestLogin_WrongPassword(t *testing.T) {
 	}
 }
 
+func loginStatus(t *testing.T, h *testHandlers, username, password string) int {
+	t.Helper()
+	b, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	rec := httptest.NewRecorder()
+	h.authH.HandleLogin(rec, httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(b)))
+	return rec.Code
+}
+
+func TestAccount_DisplayNameEmailAndPassword(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "acctuser", "password123")
+	registerUser(t, h, "otheracct", "password123")
+
+	update := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.authH.HandleUpdateAccount(rec, authRequest(t, h, "PUT", "/api/me", body, token))
+		return rec
+	}
+
+	if rec := update(map[string]interface{}{"display_name": "   "}); rec.Code != 400 {
+		t.Errorf("blank display_name status = %d, want 400", rec.Code)
+	}
+	if rec := update(map[string]interface{}{"display_name": "  Ada L.  "}); rec.Code != 200 || decodeJSON(t, rec)["display_name"] != "Ada L." {
+		t.Fatalf("display_name update status = %d", rec.Code)
+	}
+
+	// Email changes need the current password and a free, valid address.
+	if rec := update(map[string]interface{}{"email": "ada@example.com", "current_password": "wrong"}); rec.Code != 403 {
+		t.Errorf("wrong password status = %d, want 403", rec.Code)
+	}
+	if rec := update(map[string]interface{}{"email": "not an email", "current_password": "password123"}); rec.Code != 400 {
+		t.Errorf("invalid email status = %d, want 400", rec.Code)
+	}
+	if rec := update(map[string]interface{}{"email": "OtherAcct@test.com", "current_password": "password123"}); rec.Code != 409 {
+		t.Errorf("taken email status = %d, want 409", rec.Code)
+	}
+
+	// With email delivery, the new address waits for its code.
+	var sentTo, sentBody string
+	h.authH.SendEmail = func(_ context.Context, to, _, body string) error {
+		sentTo, sentBody = to, body
+		return nil
+	}
+	rec := update(map[string]interface{}{"email": "ada@example.com", "current_password": "password123"})
+	if resp := decodeJSON(t, rec); rec.Code != 200 || resp["pending_email"] != "ada@example.com" || resp["email"] != "acctuser@test.com" {
+		t.Fatalf("email change = %d %v, want pending", rec.Code, resp)
+	}
+	if sentTo != "ada@example.com" {
+		t.Fatalf("verification sent to %q", sentTo)
+	}
+	if loginStatus(t, h, "ada@example.com", "password123") != 401 {
+		t.Error("unconfirmed email accepted for login")
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetProfile(rec, authRequest(t, h, "GET", "/api/me", nil, token))
+	if got := decodeJSON(t, rec)["pending_email"]; got != "ada@example.com" {
+		t.Errorf("profile pending_email = %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.authH.HandleVerifyEmail(rec, authRequest(t, h, "POST", "/api/me/email/verify", map[string]string{"token": "deadbeef"}, token))
+	if rec.Code != 400 {
+		t.Errorf("wrong code status = %d, want 400", rec.Code)
+	}
+	code := strings.Fields(sentBody[strings.Index(sentBody, "hours:"):])[1]
+	rec = httptest.NewRecorder()
+	h.authH.HandleVerifyEmail(rec, authRequest(t, h, "POST", "/api/me/email/verify", map[string]string{"token": code}, token))
+	if rec.Code != 200 {
+		t.Fatalf("verify status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if loginStatus(t, h, "ada@example.com", "password123") != 200 {
+		t.Error("confirmed email rejected for login")
+	}
+	rec = httptest.NewRecorder()
+	h.authH.HandleVerifyEmail(rec, authRequest(t, h, "POST", "/api/me/email/verify", map[string]string{"token": code}, token))
+	if rec.Code != 400 {
+		t.Errorf("reused code status = %d, want 400", rec.Code)
+	}
+
+	// Password changes need the current password and hand back a new token.
+	change := func(current, next string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.authH.HandleChangePassword(rec, authRequest(t, h, "PUT", "/api/me/password",
+			map[string]string{"current_password": current, "new_password": next}, token))
+		return rec
+	}
+	if rec := change("wrong", "newpassword456"); rec.Code != 403 {
+		t.Errorf("wrong current password status = %d, want 403", rec.Code)
+	}
+	if rec := change("password123", "short"); rec.Code != 400 {
+		t.Errorf("short password status = %d, want 400", rec.Code)
+	}
+	rec = change("password123", "newpassword456")
+	if rec.Code != 200 || decodeJSON(t, rec)["token"] == "" {
+		t.Fatalf("change password status = %d", rec.Code)
+	}
+	if loginStatus(t, h, "acctuser", "password123") != 401 || loginStatus(t, h, "acctuser", "newpassword456") != 200 {
+		t.Error("login does not reflect the new password")
+	}
+
+	// Each change is in the user's audit log.
+	rec = httptest.NewRecorder()
+	h.authH.HandleMyAuditLog(rec, authRequest(t, h, "GET", "/api/me/audit-log", nil, token))
+	actions := map[string]int{}
+	for _, e := range decodeJSON(t, rec)["entries"].([]interface{}) {
+		actions[e.(map[string]interface{})["action"].(string)]++
+	}
+	if actions["account.display_name"] != 1 || actions["account.email"] != 1 || actions["account.password"] != 1 {
+		t.Errorf("audit actions = %v", actions)
+	}
+
+	// An impersonation token cannot change credentials.
+	req := authRequest(t, h, "PUT", "/api/me/password",
+		map[string]string{"current_password": "newpassword456", "new_password": "takeover123"}, token)
+	req = req.WithContext(context.WithValue(req.Context(), auth.ImpersonationKey, &auth.Impersonation{SessionID: "s"}))
+	rec = httptest.NewRecorder()
+	h.authH.HandleChangePassword(rec, req)
+	if rec.Code != 403 {
+		t.Errorf("impersonated password change status = %d, want 403", rec.Code)
+	}
+}
+
 func TestLogin_NonexistentUser(t *testing.T) {
 	h := newTestHandlers(t)
 
@@ -349,6 +1436,121 @@ func TestLogin_NonexistentUser(t *testing.T) {
 	}
 }
 
+func TestSessions_ListRevokeAndSignOutEverywhere(t *testing.T) {
+	h := newTestHandlers(t)
+	phone := registerUser(t, h, "sessuser", "password123")
+	login := func(userAgent string) string {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"sessuser","password":"password123"}`))
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		h.authH.HandleLogin(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("login status = %d", rec.Code)
+		}
+		return decodeJSON(t, rec)["token"].(string)
+	}
+	laptop := login("Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+	script := login("curl/8.5.0")
+
+	// Requests go through the middleware, which checks the session.
+	serve := func(handler http.HandlerFunc, method, url, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if id := strings.TrimPrefix(url, "/api/me/sessions/"); id != url {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", id)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		}
+		rec := httptest.NewRecorder()
+		h.authH.AuthMiddleware(handler).ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(token string) []map[string]interface{} {
+		t.Helper()
+		rec := serve(h.authH.HandleListSessions, "GET", "/api/me/sessions", token)
+		if rec.Code != 200 {
+			t.Fatalf("list status = %d; body: %s", rec.Code, rec.Body.String())
+		}
+		var out []map[string]interface{}
+		for _, s := range decodeJSON(t, rec)["sessions"].([]interface{}) {
+			out = append(out, s.(map[string]interface{}))
+		}
+		return out
+	}
+
+	sessions := list(laptop)
+	if len(sessions) != 3 {
+		t.Fatalf("sessions = %d, want 3", len(sessions))
+	}
+	devices := map[string]bool{}
+	var scriptID string
+	for _, s := range sessions {
+		devices[s["device"].(string)] = true
+		if s["current"] == true && s["device"] != "Firefox on Linux" {
+			t.Errorf("current session = %v, want the laptop's", s)
+		}
+		if s["device"] == "curl/8.5.0" {
+			scriptID = s["id"].(string)
+		}
+		if s["ip"] == "" {
+			t.Errorf("session %v has no ip", s["id"])
+		}
+	}
+	if !devices["Firefox on Linux"] || scriptID == "" {
+		t.Fatalf("devices = %v", devices)
+	}
+
+	// Revoking a session rejects its token; other users cannot revoke it.
+	other := registerUser(t, h, "sessother", "password123")
+	if rec := serve(h.authH.HandleRevokeSession, "DELETE", "/api/me/sessions/"+scriptID, other); rec.Code != 404 {
+		t.Errorf("foreign revoke status = %d, want 404", rec.Code)
+	}
+	if rec := serve(h.authH.HandleRevokeSession, "DELETE", "/api/me/sessions/"+scriptID, laptop); rec.Code != 200 {
+		t.Fatalf("revoke status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(h.authH.HandleListSessions, "GET", "/api/me/sessions", script); rec.Code != 401 {
+		t.Errorf("revoked token status = %d, want 401", rec.Code)
+	}
+	if len(list(laptop)) != 2 {
+		t.Error("revoked session still listed")
+	}
+
+	// Signing out everywhere else keeps the current session and rejects
+	// tokens issued before sessions were tracked.
+	legacy := auth.GenerateToken(decodeJSON(t, serve(func(w http.ResponseWriter, r *http.Request) {
+		uid, _ := auth.ExtractUserID(r)
+		httputil.WriteJSON(w, 200, map[string]string{"user_id": uid})
+	}, "GET", "/", laptop))["user_id"].(string), h.authH.JWTSecret)
+	if rec := serve(h.authH.HandleListSessions, "GET", "/api/me/sessions", legacy); rec.Code != 200 {
+		t.Fatalf("legacy token status = %d", rec.Code)
+	}
+	rec := serve(h.authH.HandleRevokeAllSessions, "DELETE", "/api/me/sessions?keep_current=true", laptop)
+	if rec.Code != 200 || decodeJSON(t, rec)["revoked"] != float64(1) {
+		t.Fatalf("revoke all status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	for name, token := range map[string]string{"phone": phone, "legacy": legacy} {
+		if rec := serve(h.authH.HandleListSessions, "GET", "/api/me/sessions", token); rec.Code != 401 {
+			t.Errorf("%s token after sign out everywhere status = %d, want 401", name, rec.Code)
+		}
+	}
+	if sessions := list(laptop); len(sessions) != 1 || sessions[0]["current"] != true {
+		t.Errorf("sessions after sign out everywhere = %v", sessions)
+	}
+
+	// Without keep_current, the current session is signed out too.
+	if rec := serve(h.authH.HandleRevokeAllSessions, "DELETE", "/api/me/sessions", laptop); rec.Code != 200 {
+		t.Fatalf("revoke all status = %d", rec.Code)
+	}
+	if rec := serve(h.authH.HandleListSessions, "GET", "/api/me/sessions", laptop); rec.Code != 401 {
+		t.Errorf("current token after sign out everywhere status = %d, want 401", rec.Code)
+	}
+	if n, err := h.authH.PruneSessions(context.Background()); err != nil || n != 0 {
+		t.Errorf("PruneSessions = %d, %v; want nothing expired", n, err)
+	}
+}
+
 // --- JWT / Middleware ---
 
 func TestGenerateToken_And_ExtractUserID(t *testing.T) {
@@ -364,6 +1566,67 @@ func TestGenerateToken_And_ExtractUserID(t *testing.T) {
 	}
 }
 
+func TestJWTKeyRotation_OldTokensVerifyUntilRetired(t *testing.T) {
+	h := newTestHandlers(t)
+	ring := &auth.KeyRing{DB: h.db, LegacySecret: h.authH.JWTSecret, EncryptionSecret: "test-cookie-secret"}
+	authH := &auth.Handler{DB: h.db, JWTSecret: h.authH.JWTSecret, Keys: ring}
+	ctx := context.Background()
+	userOf := func(a *auth.Handler, token string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.UserIDFromRequest(req)
+	}
+
+	// Before any rotation, tokens carry no kid and use JWT_SECRET.
+	legacy, err := ring.Sign(ctx, "user-1")
+	if err != nil || userOf(authH, legacy) != "user-1" || userOf(authH, auth.GenerateToken("user-0", h.authH.JWTSecret)) != "user-0" {
+		t.Fatalf("legacy token not accepted: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	ring.HandleRotateKey(rec, httptest.NewRequest("POST", "/api/admin/auth/keys/rotate", nil))
+	if rec.Code != 201 {
+		t.Fatalf("rotate: status = %d, want 201; body: %s", rec.Code, rec.Body.String())
+	}
+	kid := decodeJSON(t, rec)["kid"].(string)
+	current, _ := ring.Sign(ctx, "user-2")
+	parsed, _, _ := jwt.NewParser().ParseUnverified(current, jwt.MapClaims{})
+	if parsed.Header["kid"] != kid {
+		t.Errorf("new token kid = %v, want %s", parsed.Header["kid"], kid)
+	}
+	if userOf(authH, legacy) != "user-1" || userOf(authH, current) != "user-2" {
+		t.Error("tokens signed before and after rotation should both verify")
+	}
+	if userOf(&auth.Handler{JWTSecret: h.authH.JWTSecret}, current) != "" {
+		t.Error("rotated-key token verified against JWT_SECRET alone")
+	}
+
+	rec = httptest.NewRecorder()
+	ring.HandleListKeys(rec, httptest.NewRequest("GET", "/api/admin/auth/keys", nil))
+	keys := decodeJSON(t, rec)["keys"].([]interface{})
+	if len(keys) != 2 || keys[0].(map[string]interface{})["kid"] != kid || keys[0].(map[string]interface{})["current"] != true {
+		t.Errorf("keys = %v, want current %s then legacy", keys, kid)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("key listing exposes secrets: %s", rec.Body.String())
+	}
+
+	// Once the legacy key has been rotated out for longer than tokens live,
+	// a fresh instance rejects its tokens but still accepts the current key.
+	h.db.Exec(`UPDATE jwt_keys SET rotated_at = '2000-01-01T00:00:00Z' WHERE kid = 'legacy'`)
+	fresh := &auth.Handler{DB: h.db, JWTSecret: h.authH.JWTSecret,
+		Keys: &auth.KeyRing{DB: h.db, LegacySecret: h.authH.JWTSecret, EncryptionSecret: "test-cookie-secret"}}
+	if userOf(fresh, legacy) != "" {
+		t.Error("token signed with a retired key still verifies")
+	}
+	if userOf(fresh, current) != "user-2" {
+		t.Error("current-key token rejected by another instance")
+	}
+	if n, err := ring.Retire(ctx); err != nil || n != 1 {
+		t.Errorf("retire = %d, %v; want 1 key deleted", n, err)
+	}
+}
+
 func TestExtractUserID_NoHeader(t *testing.T) {
 	h := newTestHandlers(t)
 	req := httptest.NewRequest("GET", "/", nil)
@@ -474,6 +1737,73 @@ func TestHandleInteraction_InvalidAction(t *testing.T) {
 	}
 }
 
+func TestClipRetention_SegmentsBuildCurveForSubmitterAndAdmin(t *testing.T) {
+	h := newTestHandlers(t)
+	h.clipsH.IsAdmin = h.adminH.IsAdminToken
+	owner := registerUser(t, h, "retowner", "password123")
+	viewer := registerUser(t, h, "retviewer", "password123")
+	var ownerID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'retowner'`).Scan(&ownerID)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by) VALUES ('src-ret', 'http://x.com', 'direct', ?)`, ownerID)
+	h.db.Exec(`INSERT INTO clips (id, source_id, duration_seconds, storage_key, status) VALUES ('clip-ret', 'src-ret', 50.0, 'k', 'ready')`)
+
+	interact := func(token string, segments interface{}) int {
+		body := map[string]interface{}{"action": "view", "watch_percentage": 0.5, "segments": segments}
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleInteraction(rec, withChiParam(authRequest(t, h, "POST", "/api/clips/clip-ret/interact", body, token), "id", "clip-ret"))
+		return rec.Code
+	}
+	if code := interact(viewer, []map[string]float64{{"start": 10, "end": 5}}); code != 400 {
+		t.Errorf("backwards segment status = %d, want 400", code)
+	}
+	// One viewer watches it all, the other leaves at 20s.
+	if code := interact(owner, []map[string]float64{{"start": 0, "end": 50}}); code != 200 {
+		t.Fatalf("interact status = %d", code)
+	}
+	if code := interact(viewer, []map[string]float64{{"start": 0, "end": 20}}); code != 200 {
+		t.Fatalf("interact status = %d", code)
+	}
+
+	get := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.authH.OptionalAuth(h.clipsH.HandleRetention)(rec, withChiParam(r, "id", "clip-ret"))
+		return rec
+	}
+	if rec := get(httptest.NewRequest("GET", "/api/clips/clip-ret/retention", nil)); rec.Code != 401 {
+		t.Errorf("anonymous status = %d, want 401", rec.Code)
+	}
+	if rec := get(authRequest(t, h, "GET", "/api/clips/clip-ret/retention", nil, viewer)); rec.Code != 403 {
+		t.Errorf("non-submitter status = %d, want 403", rec.Code)
+	}
+
+	rec := get(authRequest(t, h, "GET", "/api/clips/clip-ret/retention", nil, owner))
+	if rec.Code != 200 {
+		t.Fatalf("submitter status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	buckets := resp["buckets"].([]interface{})
+	if resp["sessions"] != 2.0 || len(buckets) != clips.RetentionBuckets {
+		t.Fatalf("sessions = %v, buckets = %d", resp["sessions"], len(buckets))
+	}
+	first := buckets[0].(map[string]interface{})
+	last := buckets[len(buckets)-1].(map[string]interface{})
+	if first["retention"] != 1.0 || last["retention"] != 0.5 {
+		t.Errorf("retention first = %v, last = %v, want 1 and 0.5", first["retention"], last["retention"])
+	}
+	drop := resp["steepest_drop"].(map[string]interface{})
+	if drop["start"] != 20.0 || drop["drop"] != 0.5 {
+		t.Errorf("steepest_drop = %v, want a 0.5 drop at 20s", drop)
+	}
+
+	login := httptest.NewRecorder()
+	h.adminH.HandleAdminLogin(login, httptest.NewRequest("POST", "/api/admin/login", strings.NewReader(`{"username":"admin","password":"admin-pw"}`)))
+	req := httptest.NewRequest("GET", "/api/clips/clip-ret/retention", nil)
+	req.Header.Set("Authorization", "Bearer "+decodeJSON(t, login)["token"].(string))
+	if rec := get(req); rec.Code != 200 {
+		t.Errorf("admin status = %d, want 200", rec.Code)
+	}
+}
+
 // --- Feed ---
 
 func TestHandleFeed_Anonymous(t *testing.T) {
@@ -556,6 +1886,125 @@ func TestHandleFeed_DedupeSeenToggle(t *testing.T) {
 	}
 }
 
+func TestHandleFeed_TimelineModes(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "timelineuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'timelineuser'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-a', 'http://a.com', 'youtube', 'Alpha')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-b', 'http://b.com', 'youtube', 'Beta')`)
+	for _, c := range []struct{ id, source, topics, createdAt string }{
+		{"t1", "src-a", `["cooking"]`, "2024-01-01T00:00:00Z"},
+		{"t2", "src-b", `["music"]`, "2024-01-02T00:00:00Z"},
+		{"t3", "src-a", `["music"]`, "2024-01-03T00:00:00Z"},
+		{"t4", "src-b", `["cooking"]`, "2024-01-04T00:00:00Z"},
+		{"t5", "src-a", `["cooking"]`, "2024-01-05T00:00:00Z"},
+	} {
+		if _, err := h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, topics, created_at) VALUES (?, ?, ?, 30.0, 'k', 'ready', 0.5, ?, ?)`,
+			c.id, c.source, c.id, c.topics, c.createdAt); err != nil {
+			t.Fatalf("insert clip: %v", err)
+		}
+	}
+	// t5 was seen, so the deduped timelines skip it.
+	h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES ('i-t5', ?, 't5', 'view', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`, userID)
+
+	get := func(url string) (int, []string, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, authRequest(t, h, "GET", url, nil, token))
+		if rec.Code != 200 {
+			return rec.Code, nil, nil
+		}
+		resp := decodeJSON(t, rec)
+		var ids []string
+		for _, c := range resp["clips"].([]interface{}) {
+			ids = append(ids, c.(map[string]interface{})["id"].(string))
+		}
+		return rec.Code, ids, resp
+	}
+
+	_, ids, resp := get("/api/feed?mode=latest&limit=2")
+	if strings.Join(ids, ",") != "t4,t3" || resp["next_before"] != "t3" || resp["mode"] != "latest" {
+		t.Fatalf("latest page 1 = %v (next_before %v), want t4,t3", ids, resp["next_before"])
+	}
+	// Watching a clip on page 1 does not shift page 2.
+	h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES ('i-t4', ?, 't4', 'view', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`, userID)
+	_, ids, resp = get("/api/feed?mode=latest&limit=2&before=t3")
+	if strings.Join(ids, ",") != "t2,t1" || resp["next_before"] != nil {
+		t.Errorf("latest page 2 = %v (next_before %v), want t2,t1 and no next page", ids, resp["next_before"])
+	}
+
+	if _, ids, resp = get("/api/feed?mode=channel&channel=alpha"); strings.Join(ids, ",") != "t3,t1" || resp["channel"] != "alpha" {
+		t.Errorf("channel timeline = %v, want t3,t1", ids)
+	}
+	if _, ids, _ = get("/api/feed?mode=topic&topic=music"); strings.Join(ids, ",") != "t3,t2" {
+		t.Errorf("topic timeline = %v, want t3,t2", ids)
+	}
+
+	// A saved filter narrows timelines like it narrows the ranked feed.
+	h.db.Exec(`INSERT INTO saved_filters (id, user_id, name, query, is_default) VALUES ('f-beta', ?, 'Beta only', '{"channels":["Beta"]}', 1)`, userID)
+	if _, ids, resp = get("/api/feed?mode=topic&topic=music"); strings.Join(ids, ",") != "t2" || resp["filter_id"] != "f-beta" {
+		t.Errorf("filtered topic timeline = %v (filter_id %v), want t2", ids, resp["filter_id"])
+	}
+
+	for _, url := range []string{"/api/feed?mode=channel", "/api/feed?mode=sideways", "/api/feed?mode=latest&before=nope"} {
+		if code, _, _ := get(url); code != 400 {
+			t.Errorf("GET %s status = %d, want 400", url, code)
+		}
+	}
+}
+
+func TestHandleFeed_ServesPrecomputedCandidates(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "precompuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'precompuser'`).Scan(&userID)
+	h.db.Exec(`UPDATE user_preferences SET exploration_rate = 0 WHERE user_id = ?`, userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-pre', 'http://x.com', 'direct')`)
+	for i := 0; i < 25; i++ {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, 'src-pre', 'Pre', 30.0, 'k', 'ready', 0.5)`,
+			fmt.Sprintf("pre-%02d", i))
+	}
+	if err := h.feedH.PrecomputeCandidates(context.Background(), userID); err != nil {
+		t.Fatalf("precompute: %v", err)
+	}
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES ('late', 'src-pre', 'Late', 30.0, 'k', 'ready', 0.99)`)
+	h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES ('i-pre', ?, 'pre-00', 'view', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`, userID)
+
+	fetch := func() (bool, map[string]bool) {
+		rec := httptest.NewRecorder()
+		h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		resp := decodeJSON(t, rec)
+		ids := map[string]bool{}
+		for _, c := range resp["clips"].([]interface{}) {
+			ids[c.(map[string]interface{})["id"].(string)] = true
+		}
+		return resp["precomputed"].(bool), ids
+	}
+
+	precomputed, ids := fetch()
+	if !precomputed {
+		t.Fatal("expected feed to be served from precomputed candidates")
+	}
+	if ids["late"] {
+		t.Error("clip added after precomputation should not appear until refresh")
+	}
+	if ids["pre-00"] {
+		t.Error("clip seen after precomputation should be filtered at serve time")
+	}
+
+	h.db.Exec(`UPDATE feed_candidates SET expires_at = '2000-01-01T00:00:00Z' WHERE user_id = ?`, userID)
+	precomputed, ids = fetch()
+	if precomputed || !ids["late"] {
+		t.Errorf("expired candidates: precomputed = %v, late present = %v; want live feed", precomputed, ids["late"])
+	}
+}
+
 func TestHandleFeed_FiltersProcessingClips(t *testing.T) {
 	h := newTestHandlers(t)
 
@@ -578,25 +2027,439 @@ func TestHandleFeed_FiltersProcessingClips(t *testing.T) {
 	}
 }
 
-// --- GetClip ---
-
-func TestHandleGetClip_Found(t *testing.T) {
+func TestHandleFeed_DefaultFilterStacksOnRanking(t *testing.T) {
 	h := newTestHandlers(t)
-	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src6', 'http://x.com', 'direct')`)
-	h.db.Exec(`INSERT INTO clips (id, source_id, title, description, duration_seconds, storage_key, thumbnail_key, status) VALUES ('c4', 'src6', 'My Clip', '', 42.0, 'key', '', 'ready')`)
-
-	req := httptest.NewRequest("GET", "/api/clips/c4", nil)
-	req = withChiParam(req, "id", "c4")
-	rec := httptest.NewRecorder()
+	token := registerUser(t, h, "filterer", "password123")
 
-	h.clipsH.HandleGetClip(rec, req)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-a', 'http://x.com', 'direct', 'Alpha')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-b', 'http://x.com', 'direct', 'Beta')`)
+	for i, c := range [][2]string{{"fa1", "src-a"}, {"fa2", "src-a"}, {"fb1", "src-b"}, {"fb2", "src-b"}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, ?, 'Clip', 30.0, 'k', 'ready', ?)`,
+			c[0], c[1], 0.1*float64(i+1))
+	}
 
-	if rec.Code != 200 {
-		t.Fatalf("status = %d, want 200", rec.Code)
+	createFilter := func(name, channel string, isDefault bool) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleCreateFilter(rec, authRequest(t, h, "POST", "/api/filters", map[string]interface{}{
+			"name": name, "query": map[string]interface{}{"channels": []string{channel}}, "is_default": isDefault,
+		}, token))
+		if rec.Code != 201 {
+			t.Fatalf("create filter: %d %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)["id"].(string)
 	}
-	resp := decodeJSON(t, rec)
-	if resp["title"] != "My Clip" {
-		t.Errorf("title = %v, want %q", resp["title"], "My Clip")
+	feed := func(query string) (map[string]interface{}, []string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed"+query, nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("feed%s: %d %s", query, rec.Code, rec.Body.String())
+		}
+		body := decodeJSON(t, rec)
+		var ids []string
+		for _, c := range body["clips"].([]interface{}) {
+			ids = append(ids, c.(map[string]interface{})["id"].(string))
+		}
+		sort.Strings(ids)
+		return body, ids
+	}
+
+	alpha := createFilter("alpha", "Alpha", true)
+	body, ids := feed("")
+	if strings.Join(ids, ",") != "fa1,fa2" || body["filter_id"] != alpha || body["ranked"] != true {
+		t.Errorf("default filter feed = %v (filter_id %v, ranked %v), want ranked Alpha clips", ids, body["filter_id"], body["ranked"])
+	}
+	if body, ids := feed("?filter=none"); len(ids) != 4 || body["filter_id"] != nil {
+		t.Errorf("filter=none feed = %v, want every clip unfiltered", ids)
+	}
+
+	// Marking another filter default replaces the first.
+	beta := createFilter("beta", "Beta", true)
+	var defaults int
+	h.db.QueryRow(`SELECT COUNT(*) FROM saved_filters WHERE is_default = 1`).Scan(&defaults)
+	if defaults != 1 {
+		t.Errorf("%d default filters, want 1", defaults)
+	}
+	if body, ids := feed(""); strings.Join(ids, ",") != "fb1,fb2" || body["filter_id"] != beta {
+		t.Errorf("new default filter feed = %v, want Beta clips", ids)
+	}
+
+	// An explicit filter lists its matches by score unless ranked=true.
+	rec := httptest.NewRecorder()
+	h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed?filter="+alpha, nil, token))
+	body = decodeJSON(t, rec)
+	clips := body["clips"].([]interface{})
+	if body["ranked"] != false || len(clips) != 2 || clips[0].(map[string]interface{})["id"] != "fa2" {
+		t.Errorf("unranked filter feed = %v (ranked %v), want fa2 then fa1", clips, body["ranked"])
+	}
+	if body, ids := feed("?filter=" + alpha + "&ranked=true"); strings.Join(ids, ",") != "fa1,fa2" || body["ranked"] != true {
+		t.Errorf("ranked explicit filter feed = %v (ranked %v), want ranked Alpha clips", ids, body["ranked"])
+	}
+}
+
+func TestHandleAsk_TurnsRequestIntoValidatedFilter(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "asker", "password123")
+
+	for _, q := range []string{
+		`INSERT INTO topics (id, name, slug, clip_count) VALUES ('t-wood', 'Woodworking', 'woodworking', 2), ('t-music', 'Music', 'music', 1)`,
+		`INSERT INTO topics (id, name, slug, parent_id, clip_count) VALUES ('t-join', 'Joinery', 'joinery', 't-wood', 1)`,
+		`INSERT INTO sources (id, url, platform) VALUES ('ask-src', 'http://x.com', 'direct')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, content_score, status) VALUES
+			('dovetail', 'ask-src', 'Dovetails', 90, 'k1', 0.9, 'ready'),
+			('bench', 'ask-src', 'Workbench', 600, 'k2', 0.8, 'ready'),
+			('song', 'ask-src', 'Song', 60, 'k3', 0.7, 'ready')`,
+		`INSERT INTO clip_topics (clip_id, topic_id) VALUES ('dovetail', 't-join'), ('bench', 't-wood'), ('song', 't-music')`,
+	} {
+		if _, err := h.db.Exec(q); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	h.feedH.RefreshTopicGraph()
+
+	ask := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleAsk(rec, authRequest(t, h, "POST", "/api/feed/ask", map[string]string{"query": query}, token))
+		return rec
+	}
+	if rec := ask("woodworking"); rec.Code != 503 {
+		t.Errorf("without a model: status = %d, want 503", rec.Code)
+	}
+
+	var prompt string
+	reply := "Here you go:\n```json\n" + `{"topics": {"include": ["woodworking", "relaxing"]}, "duration": {"min": 0, "max": 120}, "recency_days": 7}` + "\n```"
+	h.feedH.LLM = func(_ context.Context, p string) (string, string, error) {
+		prompt = p
+		return reply, "test-model", nil
+	}
+	rec := ask("show me relaxing woodworking videos under 2 minutes from this week")
+	if rec.Code != 200 {
+		t.Fatalf("ask: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(prompt, "woodworking") || !strings.Contains(prompt, "under 2 minutes") {
+		t.Errorf("prompt lacks the topic list or request: %s", prompt)
+	}
+	resp := decodeJSON(t, rec)
+	// Subtopics count; the unknown topic is dropped with a note.
+	clipsList := resp["clips"].([]interface{})
+	if len(clipsList) != 1 || clipsList[0].(map[string]interface{})["id"] != "dovetail" {
+		t.Errorf("clips = %v, want only dovetail", clipsList)
+	}
+	filter := resp["filter"].(map[string]interface{})
+	topics := filter["topics"].(map[string]interface{})
+	if len(topics["include"].([]interface{})) != 1 || topics["mode"] != "descendants" || filter["recency_days"] != float64(7) {
+		t.Errorf("echoed filter = %v", filter)
+	}
+	if notes := resp["notes"].([]interface{}); len(notes) != 1 || !strings.Contains(notes[0].(string), "relaxing") {
+		t.Errorf("notes = %v", notes)
+	}
+
+	reply = `{"topics": {"include": ["knitting"]}}`
+	if rec := ask("knitting please"); rec.Code != 422 {
+		t.Errorf("nothing usable: status = %d, want 422", rec.Code)
+	}
+	reply = "I can't help with that."
+	if rec := ask("hello"); rec.Code != 422 {
+		t.Errorf("no JSON: status = %d, want 422", rec.Code)
+	}
+	var logged int
+	h.db.QueryRow(`SELECT COUNT(*) FROM llm_logs WHERE system = 'feed_ask'`).Scan(&logged)
+	if logged != 3 {
+		t.Errorf("llm_logs rows = %d, want 3", logged)
+	}
+}
+
+func TestTimeout_RouteBudgetsAndStructured504(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "patient", "password123")
+
+	r := chi.NewRouter()
+	r.Use(httputil.Timeout(50*time.Millisecond, []httputil.RouteBudget{
+		{Pattern: "/api/media"},
+		{Pattern: "/api/feed/ask", Budget: 200 * time.Millisecond},
+	}))
+	// /slow fails once its context is done, as a cancelled query does.
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to fetch feed"})
+	})
+	r.Get("/silent", func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() })
+	r.Get("/fast", func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "real failure"})
+	})
+	var mediaDeadline bool
+	r.Get("/api/media", func(w http.ResponseWriter, r *http.Request) {
+		_, mediaDeadline = r.Context().Deadline()
+		w.WriteHeader(200)
+	})
+	r.Post("/api/feed/ask", h.feedH.HandleAsk)
+
+	serve := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, authRequest(t, h, method, path, body, token))
+		return rec
+	}
+	for _, path := range []string{"/slow", "/silent"} {
+		rec := serve("GET", path, nil)
+		if rec.Code != 504 {
+			t.Fatalf("%s: status = %d, want 504; body: %s", path, rec.Code, rec.Body.String())
+		}
+		resp := decodeJSON(t, rec)
+		if resp["code"] != "timeout" || resp["timeout_seconds"] != 0.05 {
+			t.Errorf("%s: body = %v, want a structured timeout", path, resp)
+		}
+	}
+	if rec := serve("GET", "/fast", nil); rec.Code != 500 || !strings.Contains(rec.Body.String(), "real failure") {
+		t.Errorf("error within budget = %d %s, want it passed through", rec.Code, rec.Body.String())
+	}
+	if serve("GET", "/api/media", nil); mediaDeadline {
+		t.Error("unbounded route has a deadline")
+	}
+
+	// The LLM call sees the route's longer budget and is cancelled with the
+	// request; the call is still logged.
+	var budget time.Duration
+	h.feedH.LLM = func(ctx context.Context, _ string) (string, string, error) {
+		deadline, _ := ctx.Deadline()
+		budget = time.Until(deadline)
+		<-ctx.Done()
+		return "", "test-model", ctx.Err()
+	}
+	rec := serve("POST", "/api/feed/ask", map[string]string{"query": "woodworking"})
+	if rec.Code != 504 {
+		t.Fatalf("ask past its budget: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if budget <= 50*time.Millisecond {
+		t.Errorf("LLM saw a %v budget, want the ask route's 200ms", budget)
+	}
+	var logged int
+	h.db.QueryRow(`SELECT COUNT(*) FROM llm_logs WHERE system = 'feed_ask'`).Scan(&logged)
+	if logged != 1 {
+		t.Errorf("llm_logs rows = %d, want the timed-out call logged", logged)
+	}
+}
+
+func TestHandleSearch_ScopedToCollectionAndChannel(t *testing.T) {
+	h := newTestHandlers(t)
+	ownerToken := registerUser(t, h, "baker", "password123")
+	strangerToken := registerUser(t, h, "stranger", "password123")
+	ownerID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, ownerToken), h.authH.JWTSecret)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-bake', 'http://x.com', 'direct', 'Bakery')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-other', 'http://x.com', 'direct', 'Other')`)
+	for _, c := range [][3]string{
+		{"sd1", "src-bake", "Sourdough basics"},
+		{"sd2", "src-bake", "Sourdough starter"},
+		{"sd3", "src-other", "Sourdough scoring"},
+	} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES (?, ?, ?, 30.0, 'k', 'ready')`, c[0], c[1], c[2])
+		h.db.Exec(`INSERT INTO clips_fts (clip_id, title, transcript, platform, channel_name) VALUES (?, ?, '', 'direct', '')`, c[0], c[2])
+	}
+	h.db.Exec(`INSERT INTO collections (id, user_id, title, is_public) VALUES ('bread', ?, 'Bread', 0)`, ownerID)
+	h.db.Exec(`INSERT INTO collection_clips (collection_id, clip_id) VALUES ('bread', 'sd1')`)
+
+	search := func(query, token string) (int, []string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleSearch(rec, authRequest(t, h, "GET", "/api/search?collapse=false&q=sourdough"+query, nil, token))
+		if rec.Code != 200 {
+			return rec.Code, nil
+		}
+		var ids []string
+		hits, _ := decodeJSON(t, rec)["hits"].([]interface{})
+		for _, hit := range hits {
+			ids = append(ids, hit.(map[string]interface{})["id"].(string))
+		}
+		sort.Strings(ids)
+		return rec.Code, ids
+	}
+
+	for _, tc := range []struct {
+		query, token string
+		want         string
+	}{
+		{"", "", "sd1,sd2,sd3"},
+		{"&collection_id=bread", ownerToken, "sd1"},
+		{"&channel=Bakery", "", "sd1,sd2"},
+		{"&channel=Other&collection_id=bread", ownerToken, ""},
+	} {
+		if code, ids := search(tc.query, tc.token); code != 200 || strings.Join(ids, ",") != tc.want {
+			t.Errorf("search%s: status %d, hits %v; want %s", tc.query, code, ids, tc.want)
+		}
+	}
+
+	// Private collections are only searchable by their owner.
+	if code, _ := search("&collection_id=bread", strangerToken); code != 404 {
+		t.Errorf("stranger searching private collection: status = %d, want 404", code)
+	}
+	if code, _ := search("&collection_id=bread", ""); code != 404 {
+		t.Errorf("anonymous search of private collection: status = %d, want 404", code)
+	}
+	h.db.Exec(`UPDATE collections SET is_public = 1 WHERE id = 'bread'`)
+	if code, ids := search("&collection_id=bread", strangerToken); code != 200 || strings.Join(ids, ",") != "sd1" {
+		t.Errorf("stranger searching public collection: status %d, hits %v", code, ids)
+	}
+}
+
+func TestHandleSearch_StructuredQuery(t *testing.T) {
+	h := newTestHandlers(t)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-bab', 'http://x.com', 'youtube', 'Babish')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-tik', 'http://y.com', 'tiktok', 'QuickEats')`)
+	for _, c := range []struct {
+		id, source, title, transcript, topics, created string
+		dur                                             float64
+	}{
+		{"sq1", "src-bab", "Pasta carbonara", "eggs and guanciale", `["cooking"]`, "2024-07-10T00:00:00Z", 45},
+		{"sq2", "src-bab", "Vegan pasta", "no eggs at all", `["cooking"]`, "2024-07-11T00:00:00Z", 50},
+		{"sq3", "src-bab", "Pasta history", "the story of pasta", `["history"]`, "2024-07-12T00:00:00Z", 40},
+		{"sq4", "src-bab", "Pasta masterclass", "long form pasta", `["cooking"]`, "2024-07-13T00:00:00Z", 300},
+		{"sq5", "src-bab", "Old pasta", "pasta from before", `["cooking"]`, "2024-01-01T00:00:00Z", 30},
+		{"sq6", "src-tik", "Pasta hack", "quick pasta", `["cooking"]`, "2024-07-14T00:00:00Z", 20},
+	} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, topics, created_at) VALUES (?, ?, ?, ?, 'k', 'ready', ?, ?)`,
+			c.id, c.source, c.title, c.dur, c.topics, c.created)
+		h.db.Exec(`INSERT INTO clips_fts (clip_id, title, transcript, platform, channel_name) VALUES (?, ?, ?, '', '')`, c.id, c.title, c.transcript)
+	}
+
+	search := func(q string) (int, map[string]interface{}, []string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleSearch(rec, httptest.NewRequest("GET", "/api/search?"+url.Values{"q": {q}, "debug": {"true"}, "collapse": {"false"}}.Encode(), nil))
+		body := decodeJSON(t, rec)
+		var ids []string
+		hits, _ := body["hits"].([]interface{})
+		for _, hit := range hits {
+			ids = append(ids, hit.(map[string]interface{})["id"].(string))
+		}
+		sort.Strings(ids)
+		return rec.Code, body, ids
+	}
+
+	for _, tc := range []struct{ q, want string }{
+		{`topic:cooking channel:"babish" dur:<60 after:2024-06-01 pasta -vegan`, "sq1"},
+		{`pasta -"no eggs" topic:cooking dur:<60`, "sq1,sq5,sq6"},
+		{`platform:tiktok`, "sq6"},
+		{`-topic:cooking pasta`, "sq3"},
+		{`pasta dur:200..400`, "sq4"},
+		{`"the story" before:2024-07-13`, "sq3"},
+	} {
+		if code, _, ids := search(tc.q); code != 200 || strings.Join(ids, ",") != tc.want {
+			t.Errorf("search %q: status %d, hits %v; want %s", tc.q, code, ids, tc.want)
+		}
+	}
+
+	code, body, _ := search(`pasta dur:<1m`)
+	ast, _ := body["query_ast"].([]interface{})
+	if code != 200 || len(ast) != 2 || ast[1].(map[string]interface{})["seconds"] != 60.0 {
+		t.Errorf("debug query_ast = %v, want the term and a 60s duration filter", body["query_ast"])
+	}
+
+	code, body, _ = search(`pasta chanel:Babish`)
+	if code != 400 || body["position"] != 7.0 || !strings.Contains(body["error"].(string), `did you mean "channel"`) {
+		t.Errorf("misspelled field: status %d, body %v; want 400 at column 7 suggesting channel", code, body)
+	}
+}
+
+func TestHandleSearch_CollapsesChannelsAndDiversifiesTopics(t *testing.T) {
+	h := newTestHandlers(t)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-big', 'http://x.com', 'youtube', 'BigChannel')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-a', 'http://a.com', 'youtube', 'ChannelA')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-b', 'http://b.com', 'youtube', 'ChannelB')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-c', 'http://c.com', 'youtube', 'ChannelC')`)
+	insert := func(id, source, title, topics string, score float64) {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, topics, content_score) VALUES (?, ?, ?, 30.0, 'k', 'ready', ?, ?)`,
+			id, source, title, topics, score)
+		h.db.Exec(`INSERT INTO clips_fts (clip_id, title, transcript, platform, channel_name) VALUES (?, ?, '', '', '')`, id, title)
+	}
+	// Filters carry no full-text terms, so hits rank by content score.
+	for i := 0; i < 25; i++ {
+		insert(fmt.Sprintf("big%02d", i), "src-big", "Guitar lesson", `["guitar"]`, 0.99-float64(i)*0.001)
+	}
+	insert("a1", "src-a", "Guitar tone", `["guitar"]`, 0.90)
+	insert("b1", "src-b", "Guitar history", `["history"]`, 0.89)
+	insert("c1", "src-c", "Guitar gear", `["guitar"]`, 0.88)
+
+	search := func(query string) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleSearch(rec, httptest.NewRequest("GET", "/api/search?q=platform:youtube"+query, nil))
+		if rec.Code != 200 {
+			t.Fatalf("search%s: status = %d; body: %s", query, rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+	ids := func(body map[string]interface{}) []string {
+		var out []string
+		for _, hit := range body["hits"].([]interface{}) {
+			out = append(out, hit.(map[string]interface{})["id"].(string))
+		}
+		return out
+	}
+
+	// One hit per channel, the top one noting the rest; the history clip
+	// moves up past the second guitar clip.
+	body := search("")
+	if got := strings.Join(ids(body), ","); got != "big00,b1,a1,c1" || body["collapsed"] != true {
+		t.Errorf("collapsed hits = %s (collapsed %v), want big00,b1,a1,c1", got, body["collapsed"])
+	}
+	if top := body["hits"].([]interface{})[0].(map[string]interface{}); top["more_from_channel"] != float64(24) {
+		t.Errorf("more_from_channel = %v, want 24", top["more_from_channel"])
+	}
+
+	// Expanding a channel lists its hits without collapsing.
+	if body := search("&channel=BigChannel"); len(ids(body)) != 20 || body["collapsed"] != false {
+		t.Errorf("channel expansion = %d hits (collapsed %v), want 20", len(ids(body)), body["collapsed"])
+	}
+
+	// collapse=false returns plain relevance order.
+	if got := ids(search("&collapse=false")); len(got) != 20 || got[19] != "big19" {
+		t.Errorf("uncollapsed hits = %v, want the 20 best BigChannel clips", got)
+	}
+}
+
+// --- GetClip ---
+
+func TestHandleGetClip_Found(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src6', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, description, duration_seconds, storage_key, thumbnail_key, status) VALUES ('c4', 'src6', 'My Clip', '', 42.0, 'key', '', 'ready')`)
+
+	req := httptest.NewRequest("GET", "/api/clips/c4", nil)
+	req = withChiParam(req, "id", "c4")
+	rec := httptest.NewRecorder()
+
+	h.clipsH.HandleGetClip(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	resp := decodeJSON(t, rec)
+	if resp["title"] != "My Clip" {
+		t.Errorf("title = %v, want %q", resp["title"], "My Clip")
+	}
+	if resp["duration_bucket"] != "medium" {
+		t.Errorf("duration_bucket = %v, want medium for 42s", resp["duration_bucket"])
+	}
+}
+
+func TestHandleGetClip_Attribution(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-attr', 'https://www.youtube.com/watch?v=abc', 'youtube')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, description, duration_seconds, start_time, end_time, storage_key, thumbnail_key, status) VALUES ('c-attr', 'src-attr', 'Attr', '', 30.0, 61.5, 91.5, 'key', '', 'ready')`)
+
+	req := withChiParam(httptest.NewRequest("GET", "/api/clips/c-attr", nil), "id", "c-attr")
+	rec := httptest.NewRecorder()
+	h.clipsH.HandleGetClip(rec, req)
+
+	attr, ok := decodeJSON(t, rec)["attribution"].(map[string]interface{})
+	if !ok {
+		t.Fatal("expected attribution object")
+	}
+	if attr["deep_link"] != "https://www.youtube.com/watch?t=61s&v=abc" || attr["start_seconds"] != 61.5 {
+		t.Errorf("unexpected attribution: %v", attr)
 	}
 }
 
@@ -655,6 +2518,193 @@ func TestSaveClip_NotFound(t *testing.T) {
 	}
 }
 
+func TestIntegrations_SyncSavedClips(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "syncer", "password123")
+
+	type received struct {
+		path   string
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	var got []received
+	hookStatus, readwiseStatus := 200, 401
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, received{r.URL.Path, r.Header, body})
+		switch r.URL.Path {
+		case "/hook":
+			w.WriteHeader(hookStatus)
+		case "/readwise":
+			w.WriteHeader(readwiseStatus)
+		default:
+			w.Write([]byte(`{"status": "STATUS_SUCCEEDED"}`))
+		}
+	}))
+	defer srv.Close()
+	ih := &integrations.Handler{DB: h.db, CookieSecret: "test-cookie-secret", AllowPrivateURLs: true,
+		ReadwiseURL: srv.URL + "/readwise", YouTubeURL: srv.URL}
+
+	create := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ih.HandleCreate(rec, authRequest(t, h, "POST", "/api/me/integrations", body, token))
+		return rec
+	}
+	rec := create(map[string]interface{}{"kind": "webhook", "url": srv.URL + "/hook"})
+	if rec.Code != 201 {
+		t.Fatalf("create webhook: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	hook := decodeJSON(t, rec)
+	secret, _ := hook["secret"].(string)
+	if secret == "" {
+		t.Fatal("webhook secret not returned")
+	}
+	if rec := create(map[string]interface{}{"kind": "webhook", "url": srv.URL + "/other"}); rec.Code != 409 {
+		t.Errorf("second webhook: status = %d, want 409", rec.Code)
+	}
+	if rec := create(map[string]interface{}{"kind": "webhook", "url": "ftp://example.com"}); rec.Code != 400 {
+		t.Errorf("ftp webhook: status = %d, want 400", rec.Code)
+	}
+	if rec := create(map[string]interface{}{"kind": "readwise", "token": "rw-token"}); rec.Code != 201 {
+		t.Fatalf("create readwise: status = %d", rec.Code)
+	}
+	playlist := map[string]interface{}{"kind": "youtube_playlist", "playlist_id": "PLabcdefghij123"}
+	if rec := create(playlist); rec.Code != 400 {
+		t.Errorf("playlist without cookie: status = %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleSetCookie(rec, withChiParam(authRequest(t, h, "PUT", "/api/me/cookies/youtube",
+		map[string]string{"cookie_str": "SID=s1; SAPISID=sap1"}, token), "platform", "youtube"))
+	if rec.Code != 200 {
+		t.Fatalf("set cookie: status = %d", rec.Code)
+	}
+	if rec := create(playlist); rec.Code != 201 {
+		t.Fatalf("create playlist: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, external_id, channel_name) VALUES ('yt', 'https://www.youtube.com/watch?v=vid12345678', 'youtube', 'vid12345678', 'Chan')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('web', 'http://x.com/v.mp4', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, start_time, storage_key, status) VALUES ('ytclip', 'yt', 'From YouTube', 30.0, 65.0, 'k1', 'ready')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('webclip', 'web', 'From the web', 30.0, 'k2', 'ready')`)
+	save := func(clipID string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.savedH.HandleSaveClip(rec, withChiParam(authRequest(t, h, "POST", "/api/clips/"+clipID+"/save", nil, token), "id", clipID))
+		if rec.Code != 200 {
+			t.Fatalf("save %s: status = %d", clipID, rec.Code)
+		}
+	}
+	save("ytclip")
+	save("webclip")
+
+	sent, err := ih.SyncPending(context.Background())
+	if err != nil || sent != 3 {
+		t.Fatalf("sync: sent %d, err %v; want 2 webhooks and 1 playlist add", sent, err)
+	}
+	mu.Lock()
+	var hooks, adds int
+	for _, r := range got {
+		switch r.path {
+		case "/hook":
+			hooks++
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(r.body)
+			if r.header.Get(integrations.SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+				t.Errorf("webhook signature %q does not match its body", r.header.Get(integrations.SignatureHeader))
+			}
+		case "/youtubei/v1/browse/edit_playlist":
+			adds++
+			if !strings.Contains(string(r.body), `"addedVideoId":"vid12345678"`) || !strings.Contains(r.header.Get("Cookie"), "SAPISID=sap1") ||
+				!strings.HasPrefix(r.header.Get("Authorization"), "SAPISIDHASH ") {
+				t.Errorf("playlist add: body %s, headers %v", r.body, r.header)
+			}
+		case "/readwise":
+			if r.header.Get("Authorization") != "Token rw-token" {
+				t.Errorf("readwise authorization = %q", r.header.Get("Authorization"))
+			}
+		}
+	}
+	mu.Unlock()
+	if hooks != 2 || adds != 1 {
+		t.Errorf("webhooks %d, playlist adds %d; want 2 and 1", hooks, adds)
+	}
+
+	list := func() map[string]map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ih.HandleList(rec, authRequest(t, h, "GET", "/api/me/integrations", nil, token))
+		byKind := map[string]map[string]interface{}{}
+		for _, i := range decodeJSON(t, rec)["integrations"].([]interface{}) {
+			m := i.(map[string]interface{})
+			byKind[m["kind"].(string)] = m
+		}
+		return byKind
+	}
+	deliveries := func(i map[string]interface{}) map[string]interface{} {
+		return i["deliveries"].(map[string]interface{})
+	}
+	byKind := list()
+	if i := byKind["webhook"]; i["status"] != "ok" || deliveries(i)["sent"] != 2.0 {
+		t.Errorf("webhook = %v, want ok with 2 sent", i)
+	}
+	// Readwise rejected the token: no retries until the user asks.
+	if i := byKind["readwise"]; i["status"] != "failing" || deliveries(i)["failed"] != 2.0 || i["last_error"] == nil {
+		t.Errorf("readwise = %v, want failing with 2 failed", i)
+	}
+	if i := byKind["youtube_playlist"]; deliveries(i)["sent"] != 1.0 || deliveries(i)["skipped"] != 1.0 {
+		t.Errorf("playlist = %v, want 1 sent and the non-YouTube clip skipped", i)
+	}
+
+	readwiseStatus = 200
+	rec = httptest.NewRecorder()
+	ih.HandleRetry(rec, withChiParam(authRequest(t, h, "POST", "/api/me/integrations/x/retry", nil, token), "id", byKind["readwise"]["id"].(string)))
+	if rec.Code != 200 || decodeJSON(t, rec)["retried"] != 2.0 {
+		t.Fatalf("retry: status = %d", rec.Code)
+	}
+	if sent, _ := ih.SyncPending(context.Background()); sent != 2 {
+		t.Errorf("after retry: sent %d, want 2", sent)
+	}
+	if i := list()["readwise"]; i["status"] != "ok" || deliveries(i)["sent"] != 2.0 {
+		t.Errorf("readwise after retry = %v, want ok with 2 sent", i)
+	}
+
+	// A server error is retried later, not straight away.
+	hookStatus = 503
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('third', 'web', 'Third', 30.0, 'k3', 'ready')`)
+	save("third")
+	ih.SyncPending(context.Background())
+	var status string
+	var attempts int
+	var next *string
+	h.db.QueryRow(`SELECT d.status, d.attempts, d.next_attempt_at FROM integration_deliveries d
+		JOIN user_integrations i ON i.id = d.integration_id WHERE i.kind = 'webhook' AND d.clip_id = 'third'`).Scan(&status, &attempts, &next)
+	if status != "pending" || attempts != 1 || next == nil {
+		t.Errorf("failed webhook delivery: status %s, attempts %d, next %v; want pending retry", status, attempts, next)
+	}
+	mu.Lock()
+	before := len(got)
+	mu.Unlock()
+	ih.SyncPending(context.Background())
+	mu.Lock()
+	if len(got) != before {
+		t.Errorf("delivery retried before its backoff elapsed")
+	}
+	mu.Unlock()
+
+	// Saving a clip again does not send it again.
+	rec = httptest.NewRecorder()
+	h.savedH.HandleUnsaveClip(rec, withChiParam(authRequest(t, h, "DELETE", "/api/clips/ytclip/save", nil, token), "id", "ytclip"))
+	save("ytclip")
+	var queued int
+	h.db.QueryRow(`SELECT COUNT(*) FROM integration_deliveries WHERE clip_id = 'ytclip' AND status = 'pending'`).Scan(&queued)
+	if queued != 0 {
+		t.Errorf("re-saved clip queued %d deliveries, want 0", queued)
+	}
+}
+
 // --- Ingest ---
 
 func TestHandleIngest_ValidURL(t *testing.T) {
@@ -675,274 +2725,2518 @@ func TestHandleIngest_ValidURL(t *testing.T) {
 	}
 }
 
-func TestHandleIngest_InvalidURL(t *testing.T) {
+func TestHandleIngest_ClipStrategies(t *testing.T) {
 	h := newTestHandlers(t)
-	token := registerUser(t, h, "badingest", "password123")
-
-	body := map[string]string{"url": "not-a-url"}
-	req := authRequest(t, h, "POST", "/api/ingest", body, token)
-	rec := httptest.NewRecorder()
-	h.ingestH.HandleIngest(rec, req)
+	token := registerUser(t, h, "strategist", "password123")
 
-	if rec.Code != 400 {
-		t.Errorf("status = %d, want 400", rec.Code)
+	ingest := func(url, strategy string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ingestH.HandleIngest(rec, authRequest(t, h, "POST", "/api/ingest",
+			map[string]string{"url": url, "strategy": strategy}, token))
+		return rec
+	}
+	recorded := func(rec *httptest.ResponseRecorder) (string, string) {
+		t.Helper()
+		if rec.Code != 202 {
+			t.Fatalf("ingest: status = %d, want 202; body: %s", rec.Code, rec.Body.String())
+		}
+		sourceID := decodeJSON(t, rec)["source_id"].(string)
+		var onSource, payload string
+		h.db.QueryRow(`SELECT clip_strategy FROM sources WHERE id = ?`, sourceID).Scan(&onSource)
+		h.db.QueryRow(`SELECT payload FROM jobs WHERE source_id = ?`, sourceID).Scan(&payload)
+		var p jobs.DownloadPayload
+		json.Unmarshal([]byte(payload), &p)
+		return onSource, p.Strategy
 	}
-}
-
-// --- Jobs ---
 
-func TestHandleListJobs_Empty(t *testing.T) {
-	h := newTestHandlers(t)
-	token := registerUser(t, h, "jobuser", "password123")
+	if rec := ingest("https://vimeo.com/1", "sideways"); rec.Code != 400 {
+		t.Errorf("unknown strategy: status = %d, want 400", rec.Code)
+	}
+	if src, job := recorded(ingest("https://vimeo.com/2", "")); src != jobs.DefaultClipStrategy || job != jobs.DefaultClipStrategy {
+		t.Errorf("no strategy: source %q, job %q; want the built-in default", src, job)
+	}
 
-	req := authRequest(t, h, "GET", "/api/jobs", nil, token)
 	rec := httptest.NewRecorder()
-	h.jobsH.HandleListJobs(rec, req)
+	h.adminH.HandleSetClipStrategy(rec, withChiParam(httptest.NewRequest("PUT", "/api/admin/clip-strategies/tiktok",
+		strings.NewReader(`{"strategy": "full"}`)), "platform", "tiktok"))
+	if rec.Code != 200 {
+		t.Fatalf("set default: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if src, job := recorded(ingest("https://www.tiktok.com/@a/video/1", "")); src != "full" || job != "full" {
+		t.Errorf("platform default: source %q, job %q; want full", src, job)
+	}
+	if src, job := recorded(ingest("https://www.tiktok.com/@a/video/2", "chapter-based")); src != "chapter-based" || job != "chapter-based" {
+		t.Errorf("explicit strategy: source %q, job %q; want chapter-based", src, job)
+	}
 
+	// Jobs queued without a strategy get the platform default when claimed.
+	h.db.Exec(`DELETE FROM jobs`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('scouted', 'https://www.tiktok.com/@a/video/3', 'tiktok')`)
+	h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload) VALUES ('j-scouted', 'scouted', 'download', '{"url":"https://www.tiktok.com/@a/video/3","source_id":"scouted","platform":"tiktok"}')`)
+	rec = httptest.NewRecorder()
+	h.workerH.HandleClaimJob(rec, httptest.NewRequest("POST", "/api/internal/jobs/claim", nil))
 	if rec.Code != 200 {
-		t.Fatalf("status = %d, want 200", rec.Code)
+		t.Fatalf("claim: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if got := decodeJSON(t, rec)["payload"].(map[string]interface{})["strategy"]; got != "full" {
+		t.Errorf("claimed strategy = %v, want full", got)
+	}
+	var onSource string
+	h.db.QueryRow(`SELECT clip_strategy FROM sources WHERE id = 'scouted'`).Scan(&onSource)
+	if onSource != "full" {
+		t.Errorf("scouted source strategy = %q, want full", onSource)
 	}
 }
 
-// --- Profile ---
-
-func TestHandleGetProfile(t *testing.T) {
+func TestHandleIngest_AdmissionControl(t *testing.T) {
 	h := newTestHandlers(t)
-	token := registerUser(t, h, "profuser", "password123")
+	h.ingestH.BacklogCritical = 3
+	token := registerUser(t, h, "bulkloader", "password123")
+
+	// Two downloads finished in the last hour and two are queued.
+	finished := time.Now().UTC().Add(-10 * time.Minute).Format("2006-01-02T15:04:05Z")
+	for _, q := range []string{
+		`INSERT INTO jobs (id, job_type, status, completed_at) VALUES ('done-1', 'download', 'complete', '` + finished + `')`,
+		`INSERT INTO jobs (id, job_type, status, completed_at) VALUES ('done-2', 'download', 'failed', '` + finished + `')`,
+		`INSERT INTO jobs (id, job_type, status) VALUES ('queued-1', 'download', 'queued')`,
+		`INSERT INTO jobs (id, job_type, status) VALUES ('queued-2', 'download', 'queued')`,
+		`INSERT INTO jobs (id, job_type, status) VALUES ('transcode-1', 'transcode', 'running')`,
+	} {
+		if _, err := h.db.Exec(q); err != nil {
+			t.Fatalf("seed jobs: %v", err)
+		}
+	}
+	ingest := func(url, priority string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ingestH.HandleIngest(rec, authRequest(t, h, "POST", "/api/ingest",
+			map[string]string{"url": url, "priority": priority}, token))
+		return rec
+	}
 
-	req := authRequest(t, h, "GET", "/api/me", nil, token)
-	rec := httptest.NewRecorder()
-	h.profileH.HandleGetProfile(rec, req)
+	if rec := ingest("https://vimeo.com/1", "urgent"); rec.Code != 400 {
+		t.Errorf("unknown priority: status = %d, want 400", rec.Code)
+	}
 
-	if rec.Code != 200 {
-		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	rec := ingest("https://vimeo.com/2", "bulk")
+	if rec.Code != 202 {
+		t.Fatalf("bulk below threshold: status = %d; body: %s", rec.Code, rec.Body.String())
 	}
 	resp := decodeJSON(t, rec)
-	if resp["username"] != "profuser" {
-		t.Errorf("username = %v, want %q", resp["username"], "profuser")
+	if resp["queue_position"] != float64(3) || resp["estimated_wait_seconds"] != float64(3600) || resp["backlog"] != "ok" {
+		t.Errorf("bulk admission = %v", resp)
+	}
+	var jobPriority int
+	h.db.QueryRow(`SELECT priority FROM jobs WHERE id = ?`, resp["job_id"]).Scan(&jobPriority)
+	if jobPriority != 1 {
+		t.Errorf("bulk job priority = %d, want 1", jobPriority)
 	}
-}
-
-func TestHandleUpdatePreferences(t *testing.T) {
-	h := newTestHandlers(t)
-	token := registerUser(t, h, "prefuser", "password123")
 
-	body := map[string]interface{}{"exploration_rate": 0.5, "autoplay": true}
-	req := authRequest(t, h, "PUT", "/api/me/preferences", body, token)
-	rec := httptest.NewRecorder()
-	h.profileH.HandleUpdatePreferences(rec, req)
+	// Three downloads are queued now; normal ingests still go ahead of the
+	// bulk one, and bulk ingests are refused.
+	rec = ingest("https://vimeo.com/3", "")
+	if rec.Code != 202 {
+		t.Fatalf("normal at threshold: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	resp = decodeJSON(t, rec)
+	if resp["queue_position"] != float64(3) || resp["priority"] != "normal" || resp["backlog"] != "critical" {
+		t.Errorf("normal admission = %v", resp)
+	}
+	rec = ingest("https://vimeo.com/4", "bulk")
+	if rec.Code != 503 {
+		t.Fatalf("bulk at threshold: status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q, want 3600", got)
+	}
 
+	rec = httptest.NewRecorder()
+	h.ingestH.HandleQueue(rec, authRequest(t, h, "GET", "/api/ingest/queue", nil, token))
 	if rec.Code != 200 {
-		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+		t.Fatalf("queue: status = %d", rec.Code)
+	}
+	resp = decodeJSON(t, rec)
+	types := resp["job_types"].([]interface{})
+	if len(types) != 2 {
+		t.Fatalf("job_types = %v, want download and transcode", types)
+	}
+	download := types[0].(map[string]interface{})
+	if download["queued"] != float64(4) || download["finished_last_hour"] != float64(2) || download["estimated_drain_seconds"] != float64(7200) {
+		t.Errorf("download depth = %v", download)
+	}
+	if transcode := types[1].(map[string]interface{}); transcode["running"] != float64(1) || transcode["estimated_drain_seconds"] != float64(0) {
+		t.Errorf("transcode depth = %v", transcode)
+	}
+	waits := resp["estimated_wait_seconds"].(map[string]interface{})
+	if waits["normal"] != float64(5400) || waits["bulk"] != float64(7200) {
+		t.Errorf("estimated waits = %v", waits)
+	}
+	if backlog := resp["backlog"].(map[string]interface{}); backlog["level"] != "critical" || backlog["queued_downloads"] != float64(4) {
+		t.Errorf("backlog = %v", backlog)
 	}
 }
 
-func TestHandleUpdatePreferences_InvalidRange(t *testing.T) {
+func TestHandleIngest_InvalidURL(t *testing.T) {
 	h := newTestHandlers(t)
-	token := registerUser(t, h, "badpref", "password123")
+	token := registerUser(t, h, "badingest", "password123")
 
-	body := map[string]interface{}{"exploration_rate": 5.0}
-	req := authRequest(t, h, "PUT", "/api/me/preferences", body, token)
+	body := map[string]string{"url": "not-a-url"}
+	req := authRequest(t, h, "POST", "/api/ingest", body, token)
 	rec := httptest.NewRecorder()
-	h.profileH.HandleUpdatePreferences(rec, req)
+	h.ingestH.HandleIngest(rec, req)
 
 	if rec.Code != 400 {
 		t.Errorf("status = %d, want 400", rec.Code)
 	}
 }
 
-// --- Collections ---
-
-func TestCollectionsCRUD(t *testing.T) {
+func TestLicensing_ConsentReportAndTakedown(t *testing.T) {
 	h := newTestHandlers(t)
-	token := registerUser(t, h, "collector", "password123")
+	lic := &licensing.Handler{DB: h.db}
+	token := registerUser(t, h, "rightsful", "password123")
+	const url = "https://www.youtube.com/watch?v=owned"
 
-	// Create
-	body := map[string]string{"title": "My Collection", "description": "Test desc"}
-	req := authRequest(t, h, "POST", "/api/collections", body, token)
 	rec := httptest.NewRecorder()
-	h.collectionsH.HandleCreateCollection(rec, req)
-	if rec.Code != 201 {
-		t.Fatalf("create: status = %d, want 201; body: %s", rec.Code, rec.Body.String())
+	h.ingestH.HandleIngest(rec, authRequest(t, h, "POST", "/api/ingest", map[string]interface{}{
+		"url": url, "consent": map[string]bool{"has_permission": true},
+	}, token))
+	if rec.Code != 202 {
+		t.Fatalf("ingest: status = %d, body: %s", rec.Code, rec.Body.String())
 	}
-	resp := decodeJSON(t, rec)
-	colID := resp["id"].(string)
+	sourceID := decodeJSON(t, rec)["source_id"].(string)
 
-	// List
-	req = authRequest(t, h, "GET", "/api/collections", nil, token)
 	rec = httptest.NewRecorder()
-	h.collectionsH.HandleListCollections(rec, req)
+	h.workerH.HandleUpdateSource(rec, withChiParam(httptest.NewRequest("PUT", "/api/internal/sources/"+sourceID,
+		strings.NewReader(`{"uploader": "Owner", "license": " Creative Commons Attribution license (reuse allowed) "}`)), "id", sourceID))
 	if rec.Code != 200 {
-		t.Fatalf("list: status = %d, want 200", rec.Code)
+		t.Fatalf("update source: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s-other', 'https://vimeo.com/9', 'vimeo')`)
+	for _, c := range [][2]string{{"lc1", sourceID}, {"lc2", sourceID}, {"lc3", "s-other"}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES (?, ?, 'T', 30, 'k', 'ready')`, c[0], c[1])
 	}
 
-	// Delete
-	req = authRequest(t, h, "DELETE", "/api/collections/"+colID, nil, token)
-	req = withChiParam(req, "id", colID)
 	rec = httptest.NewRecorder()
-	h.collectionsH.HandleDeleteCollection(rec, req)
-	if rec.Code != 200 {
-		t.Fatalf("delete: status = %d, want 200", rec.Code)
+	lic.HandleLicenseReport(rec, httptest.NewRequest("GET", "/api/admin/licenses", nil))
+	report := decodeJSON(t, rec)
+	licenses := report["licenses"].([]interface{})
+	first := licenses[0].(map[string]interface{})
+	if len(licenses) != 2 || first["license"] != "Creative Commons Attribution license (reuse allowed)" || first["clips"] != float64(2) {
+		t.Errorf("licenses = %v, want the CC license with 2 clips first", licenses)
+	}
+	if consent := report["consent"].(map[string]interface{}); consent["permission"] != float64(1) || consent["none"] != float64(1) {
+		t.Errorf("consent = %v, want one with permission and one with none", consent)
 	}
-}
 
-// --- LTR Model ---
+	rec = httptest.NewRecorder()
+	lic.HandleCreateTakedown(rec, httptest.NewRequest("POST", "/api/admin/takedowns",
+		strings.NewReader(`{"source_url": "`+url+`", "claimant_name": "Label Co", "claimant_email": "legal@label.test", "work": "Song"}`)))
+	if rec.Code != 201 {
+		t.Fatalf("create takedown: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	created := decodeJSON(t, rec)
+	if created["source_id"] != sourceID {
+		t.Errorf("takedown source = %v, want %s", created["source_id"], sourceID)
+	}
+	takedownID := created["id"].(string)
 
-func TestLTRModelScore_SumsLeafValues(t *testing.T) {
-	model := &feed.LTRModel{
-		Trees: [][]feed.LTRTree{
-			{
-				{FeatureIndex: 0, Threshold: 0.5, LeftChild: 1, RightChild: 2},
-				{LeafValue: 1.25, IsLeaf: true},
-				{LeafValue: -0.75, IsLeaf: true},
-			},
-			{
-				{FeatureIndex: 1, Threshold: 10, LeftChild: 1, RightChild: 2},
-				{LeafValue: 0.5, IsLeaf: true},
-				{LeafValue: 0.2, IsLeaf: true},
-			},
-		},
+	resolve := func(action string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		lic.HandleResolveTakedown(rec, withChiParam(httptest.NewRequest("PUT", "/api/admin/takedowns/"+takedownID,
+			strings.NewReader(`{"action": "`+action+`", "note": "verified"}`)), "id", takedownID))
+		return rec
+	}
+	rec = resolve("take_down")
+	if rec.Code != 200 || decodeJSON(t, rec)["clips_removed"] != float64(2) {
+		t.Fatalf("take down: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var removed, generation int
+	h.db.QueryRow(`SELECT COUNT(*), MIN(stream_generation) FROM clips WHERE source_id = ? AND status = 'removed'`, sourceID).Scan(&removed, &generation)
+	if removed != 2 || generation != 1 {
+		t.Errorf("removed clips = %d (generation %d), want 2 with revoked streams", removed, generation)
+	}
+	if rec := resolve("reject"); rec.Code != 409 {
+		t.Errorf("re-resolve: status = %d, want 409", rec.Code)
 	}
 
-	score := model.Score([]float64{0.2, 20})
-	want := 1.25 + 0.2
-	if score != want {
-		t.Fatalf("score = %f, want %f", score, want)
+	rec = httptest.NewRecorder()
+	h.ingestH.HandleIngest(rec, authRequest(t, h, "POST", "/api/ingest", map[string]string{"url": url}, token))
+	if rec.Code != 451 {
+		t.Errorf("re-ingest: status = %d, want 451", rec.Code)
 	}
 }
 
-func TestHandleFeed_UsesLTRRanking(t *testing.T) {
+// --- Jobs ---
+
+func TestHandleListJobs_Empty(t *testing.T) {
 	h := newTestHandlers(t)
-	token := registerUser(t, h, "l2r-user", "password123")
+	token := registerUser(t, h, "jobuser", "password123")
 
-	h.db.Exec(`UPDATE user_preferences SET exploration_rate = 0 WHERE user_id = (SELECT id FROM users WHERE username = 'l2r-user')`)
-	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-l2r', 'http://x.com', 'direct')`)
-	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, transcript, file_size_bytes) VALUES ('l2r-a', 'src-l2r', 'Long High Score', 40.0, 'k1', 'ready', 0.9, '', 100)`)
-	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, transcript, file_size_bytes) VALUES ('l2r-b', 'src-l2r', 'Short Lower Score', 8.0, 'k2', 'ready', 0.4, '', 100)`)
+	req := authRequest(t, h, "GET", "/api/jobs", nil, token)
+	rec := httptest.NewRecorder()
+	h.jobsH.HandleListJobs(rec, req)
 
-	h.feedH.SetLTRModel(&feed.LTRModel{
-		NumFeatures: 13,
-		Trees: [][]feed.LTRTree{
-			{
-				{FeatureIndex: 0, Threshold: 0.5, LeftChild: 1, RightChild: 2},
-				{LeafValue: 2.0, IsLeaf: true},
-				{LeafValue: -1.0, IsLeaf: true},
-			},
-		},
-	})
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestSources_ListBrowseAndDelete(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "srcowner", "password123")
+	otherToken := registerUser(t, h, "srcother", "password123")
+	var userID, otherID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'srcowner'`).Scan(&userID)
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'srcother'`).Scan(&otherID)
+	sh := &sources.Handler{DB: h.db, MinioBucket: "test-bucket"}
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by, status, created_at) VALUES ('s1', 'http://x.com/1', 'direct', ?, 'complete', '2026-01-01T00:00:00Z')`, userID)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by, status, created_at) VALUES ('s2', 'http://x.com/2', 'direct', ?, 'complete', '2026-01-02T00:00:00Z')`, userID)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by, status) VALUES ('s3', 'http://x.com/3', 'direct', ?, 'complete')`, otherID)
+	for _, c := range []struct {
+		id, source, status string
+		size               int
+	}{{"c1", "s1", "ready", 100}, {"c2", "s1", "ready", 200}, {"c3", "s1", "expired", 400}, {"c4", "s2", "ready", 50}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, file_size_bytes, status) VALUES (?, ?, ?, 30.0, ?, ?, ?, ?)`,
+			c.id, c.source, c.id, "clips/"+c.id+".mp4", "thumbs/"+c.id+".jpg", c.size, c.status)
+	}
+	h.db.Exec(`INSERT INTO clips_fts (clip_id, title, transcript, platform, channel_name) VALUES ('c1', 'c1', '', 'direct', '')`)
+	h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, status) VALUES ('j1', 's1', 'download', 'complete')`)
+	h.db.Exec(`UPDATE clips SET is_protected = 1 WHERE id = 'c2'`)
 
-	req := authRequest(t, h, "GET", "/api/feed", nil, token)
 	rec := httptest.NewRecorder()
-	h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, req)
+	sh.HandleListMySources(rec, authRequest(t, h, "GET", "/api/me/sources", nil, token))
+	if rec.Code != 200 {
+		t.Fatalf("list: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	list := resp["sources"].([]interface{})
+	if len(list) != 2 || resp["total_storage_bytes"] != float64(350) {
+		t.Fatalf("list = %v, want s2 and s1 using 350 bytes", resp)
+	}
+	s1 := list[1].(map[string]interface{})
+	if s1["id"] != "s1" || s1["clip_count"] != float64(3) || s1["ready_clips"] != float64(2) || s1["storage_bytes"] != float64(300) {
+		t.Errorf("s1 = %v, want 3 clips, 2 ready, 300 bytes", s1)
+	}
 
+	rec = httptest.NewRecorder()
+	sh.HandleListSourceClips(rec, withChiParam(authRequest(t, h, "GET", "/api/sources/s1/clips", nil, token), "id", "s1"))
 	if rec.Code != 200 {
-		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+		t.Fatalf("clips: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	if clips := decodeJSON(t, rec)["clips"].([]interface{}); len(clips) != 3 {
+		t.Errorf("source clips = %d, want 3", len(clips))
+	}
+	rec = httptest.NewRecorder()
+	sh.HandleListSourceClips(rec, withChiParam(authRequest(t, h, "GET", "/api/sources/s1/clips", nil, otherToken), "id", "s1"))
+	if rec.Code != 404 {
+		t.Errorf("clips of another user's source: status = %d, want 404", rec.Code)
 	}
 
-	resp := decodeJSON(t, rec)
-	clipsList := resp["clips"].([]interface{})
-	if len(clipsList) < 2 {
-		t.Fatalf("got %d clips, want at least 2", len(clipsList))
+	// Queued work blocks deletion.
+	h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, status) VALUES ('j2', 's1', 'download', 'queued')`)
+	rec = httptest.NewRecorder()
+	sh.HandleDeleteSource(rec, withChiParam(authRequest(t, h, "DELETE", "/api/sources/s1", nil, token), "id", "s1"))
+	if rec.Code != 409 {
+		t.Errorf("delete with queued job: status = %d, want 409", rec.Code)
 	}
-	first := clipsList[0].(map[string]interface{})
-	if first["title"] != "Short Lower Score" {
-		t.Fatalf("first clip = %v, want Short Lower Score", first["title"])
+	h.db.Exec(`UPDATE jobs SET status = 'cancelled' WHERE id = 'j2'`)
+
+	rec = httptest.NewRecorder()
+	sh.HandleDeleteSource(rec, withChiParam(authRequest(t, h, "DELETE", "/api/sources/s1", nil, token), "id", "s1"))
+	if rec.Code != 200 {
+		t.Fatalf("delete: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
 	}
-	if _, ok := first["_source_id"]; ok {
-		t.Fatal("internal L2R fields should not be exposed in feed response")
+	del := decodeJSON(t, rec)
+	if del["deleted_clips"] != float64(2) || del["protected_clips"] != float64(1) || del["source_deleted"] != false || del["freed_bytes"] != float64(100) {
+		t.Errorf("delete = %v, want 2 deleted, 1 protected, source kept, 100 bytes freed", del)
+	}
+	var clips, fts, jobs int
+	h.db.QueryRow(`SELECT COUNT(*) FROM clips WHERE source_id = 's1'`).Scan(&clips)
+	h.db.QueryRow(`SELECT COUNT(*) FROM clips_fts WHERE clip_id = 'c1'`).Scan(&fts)
+	h.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE source_id = 's1'`).Scan(&jobs)
+	if clips != 1 || fts != 0 || jobs != 0 {
+		t.Errorf("after delete: %d clips, %d search rows, %d jobs; want 1, 0, 0", clips, fts, jobs)
 	}
-}
 
-// --- Worker API ---
+	// Without protected clips the source goes too.
+	rec = httptest.NewRecorder()
+	sh.HandleDeleteSource(rec, withChiParam(authRequest(t, h, "DELETE", "/api/sources/s2", nil, token), "id", "s2"))
+	if rec.Code != 200 || decodeJSON(t, rec)["source_deleted"] != true {
+		t.Errorf("delete s2: status = %d, want 200 with source deleted", rec.Code)
+	}
+	var n int
+	h.db.QueryRow(`SELECT COUNT(*) FROM sources WHERE id = 's2'`).Scan(&n)
+	if n != 0 {
+		t.Errorf("s2 still exists")
+	}
+	rec = httptest.NewRecorder()
+	sh.HandleDeleteSource(rec, withChiParam(authRequest(t, h, "DELETE", "/api/sources/s3", nil, token), "id", "s3"))
+	if rec.Code != 404 {
+		t.Errorf("delete another user's source: status = %d, want 404", rec.Code)
+	}
+}
 
-func TestWorkerAuth_ValidSecret(t *testing.T) {
+func TestSources_StorageQuotaSuggestionsAndBulkDelete(t *testing.T) {
 	h := newTestHandlers(t)
-	called := false
-	handler := h.workerH.WorkerAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		called = true
-		httputil.WriteJSON(w, 200, map[string]string{"ok": "true"})
-	}))
+	token := registerUser(t, h, "quotauser", "password123")
+	otherToken := registerUser(t, h, "quotaother", "password123")
+	var userID, otherID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'quotauser'`).Scan(&userID)
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'quotaother'`).Scan(&otherID)
+	sh := &sources.Handler{DB: h.db, MinioBucket: "test-bucket"}
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by, status) VALUES ('qs1', 'http://x.com/q1', 'direct', ?, 'complete')`, userID)
+	old := time.Now().UTC().AddDate(0, 0, -60).Format("2006-01-02T15:04:05Z")
+	recent := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	for _, c := range []struct {
+		id      string
+		size    int
+		score   float64
+		created string
+	}{
+		{"q-watched", 300, 0.2, old}, // viewed, so after the unwatched ones
+		{"q-low", 200, 0.3, old},
+		{"q-high", 100, 0.9, old},
+		{"q-saved", 100, 0.1, old},
+		{"q-collected", 100, 0.1, old},
+		{"q-protected", 100, 0.1, old},
+		{"q-new", 100, 0.1, recent},
+	} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, file_size_bytes, content_score, status, created_at) VALUES (?, 'qs1', ?, 30.0, ?, '', ?, ?, 'ready', ?)`,
+			c.id, c.id, "clips/"+c.id+".mp4", c.size, c.score, c.created)
+	}
+	h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action) VALUES ('qi1', ?, 'q-watched', 'view')`, otherID)
+	h.db.Exec(`INSERT INTO saved_clips (user_id, clip_id) VALUES (?, 'q-saved')`, otherID)
+	h.db.Exec(`INSERT INTO collections (id, user_id, title) VALUES ('qcol', ?, 'Keep')`, userID)
+	h.db.Exec(`INSERT INTO collection_clips (collection_id, clip_id) VALUES ('qcol', 'q-collected')`)
+	h.db.Exec(`UPDATE clips SET is_protected = 1 WHERE id = 'q-protected'`)
+
+	storage := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		sh.HandleGetStorage(rec, authRequest(t, h, "GET", "/api/me/storage", nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("storage status = %d; body: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+	if s := storage(); s["used_bytes"] != float64(1000) || s["quota_bytes"] != nil || s["state"] != "ok" {
+		t.Errorf("storage without quota = %v", s)
+	}
 
-	req := httptest.NewRequest("POST", "/api/internal/jobs/claim", nil)
-	req.Header.Set("Authorization", "Bearer test-worker-secret")
+	// The admin sets a quota the user is nearing.
 	rec := httptest.NewRecorder()
-	handler.ServeHTTP(rec, req)
+	h.adminH.HandleSetStorageQuota(rec, withChiParam(httptest.NewRequest("PUT", "/", strings.NewReader(`{"storage_quota_bytes":1100}`)), "id", userID))
+	if rec.Code != 200 {
+		t.Fatalf("set quota status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if s := storage(); s["quota_bytes"] != float64(1100) || s["state"] != "warning" {
+		t.Errorf("storage near quota = %v", s)
+	}
 
+	// Suggestions skip recent, saved, collected, and protected clips, and
+	// preselect the fewest that bring usage under 80% of the quota (880).
+	rec = httptest.NewRecorder()
+	sh.HandleCleanupSuggestions(rec, authRequest(t, h, "GET", "/api/me/storage/suggestions", nil, token))
 	if rec.Code != 200 {
-		t.Errorf("status = %d, want 200", rec.Code)
+		t.Fatalf("suggestions status = %d; body: %s", rec.Code, rec.Body.String())
 	}
-	if !called {
-		t.Error("inner handler not called")
+	resp := decodeJSON(t, rec)
+	var order []string
+	for _, s := range resp["suggestions"].([]interface{}) {
+		order = append(order, s.(map[string]interface{})["id"].(string))
+	}
+	if strings.Join(order, ",") != "q-low,q-high,q-watched" {
+		t.Errorf("suggestions = %v, want unwatched by score, then watched", order)
+	}
+	first := resp["suggestions"].([]interface{})[0].(map[string]interface{})
+	if fmt.Sprint(first["reasons"]) != "[old unwatched low_score]" {
+		t.Errorf("reasons = %v", first["reasons"])
+	}
+	if resp["needed_bytes"] != float64(120) || fmt.Sprint(resp["clip_ids"]) != "[q-low]" {
+		t.Errorf("needed = %v, clip_ids = %v; want 120 and [q-low]", resp["needed_bytes"], resp["clip_ids"])
+	}
+
+	// Bulk delete removes the user's unprotected clips and reports usage
+	// afterwards; other clips are skipped with a reason.
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by, status) VALUES ('qs2', 'http://x.com/q2', 'direct', ?, 'complete')`, otherID)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, file_size_bytes, status) VALUES ('q-foreign', 'qs2', 'f', 30.0, 'clips/f.mp4', 100, 'ready')`)
+	bulk := func(body map[string]interface{}, tok string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		sh.HandleBulkClips(rec, authRequest(t, h, "POST", "/api/me/clips/bulk", body, tok))
+		return rec
+	}
+	if rec := bulk(map[string]interface{}{"action": "archive", "clip_ids": []string{"q-low"}}, token); rec.Code != 400 {
+		t.Errorf("unknown action status = %d, want 400", rec.Code)
+	}
+	rec = bulk(map[string]interface{}{"action": "delete", "clip_ids": []string{"q-low", "q-high", "q-protected", "q-foreign"}}, token)
+	if rec.Code != 200 {
+		t.Fatalf("bulk delete status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	resp = decodeJSON(t, rec)
+	if resp["deleted"] != float64(2) || resp["freed_bytes"] != float64(300) || len(resp["skipped"].([]interface{})) != 2 {
+		t.Errorf("bulk delete = %v", resp)
+	}
+	if s := resp["storage"].(map[string]interface{}); s["used_bytes"] != float64(700) || s["state"] != "ok" {
+		t.Errorf("storage after delete = %v", s)
+	}
+	var tombstones, foreign int
+	h.db.QueryRow(`SELECT COUNT(*) FROM clip_tombstones WHERE clip_id IN ('q-low', 'q-high')`).Scan(&tombstones)
+	h.db.QueryRow(`SELECT COUNT(*) FROM clips WHERE id = 'q-foreign'`).Scan(&foreign)
+	if tombstones != 2 || foreign != 1 {
+		t.Errorf("tombstones = %d, foreign clip rows = %d; want 2, 1", tombstones, foreign)
+	}
+	if rec := bulk(map[string]interface{}{"action": "delete", "clip_ids": []string{"q-watched"}}, otherToken); rec.Code != 200 || decodeJSON(t, rec)["deleted"] != float64(0) {
+		t.Errorf("deleting another user's clip: status = %d, want nothing deleted", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.adminH.HandleDeleteStorageQuota(rec, withChiParam(httptest.NewRequest("DELETE", "/", nil), "id", userID))
+	if rec.Code != 200 || storage()["quota_bytes"] != nil {
+		t.Errorf("remove quota status = %d", rec.Code)
 	}
 }
 
-func TestWorkerAuth_InvalidSecret(t *testing.T) {
+func TestSources_RejectionReasons(t *testing.T) {
 	h := newTestHandlers(t)
-	handler := h.workerH.WorkerAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t.Error("should not be called")
-	}))
+	token := registerUser(t, h, "rejected", "password123")
+	sh := &sources.Handler{DB: h.db, MinioBucket: "test-bucket"}
+
+	ingest := func(url string) (string, string) {
+		rec := httptest.NewRecorder()
+		h.ingestH.HandleIngest(rec, authRequest(t, h, "POST", "/api/ingest", map[string]string{"url": url}, token))
+		resp := decodeJSON(t, rec)
+		return resp["source_id"].(string), resp["job_id"].(string)
+	}
+	reject := func(jobID, body string) int {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleUpdateJob(rec, withChiParam(httptest.NewRequest("PUT", "/api/internal/jobs/"+jobID,
+			strings.NewReader(body)), "id", jobID))
+		return rec.Code
+	}
+
+	silentSource, silentJob := ingest("https://vimeo.com/101")
+	if code := reject(silentJob, `{"status":"rejected","error":"too quiet","result":{"rejection":{"code":"quiet"}}}`); code != 400 {
+		t.Errorf("unknown code: status = %d, want 400", code)
+	}
+	if code := reject(silentJob, `{"status":"rejected","error":"No speech or sound (97% silent)","result":{"rejection":{"code":"no_speech","metrics":{"silence_ratio":0.97}}}}`); code != 200 {
+		t.Fatalf("reject: status = %d", code)
+	}
+	h.db.Exec(`UPDATE sources SET status = 'rejected' WHERE id = ?`, silentSource)
+	legacySource, legacyJob := ingest("https://vimeo.com/102")
+	reject(legacyJob, `{"status":"rejected","error":"Video too long"}`)
+	h.db.Exec(`UPDATE sources SET status = 'rejected' WHERE id = ?`, legacySource)
 
-	req := httptest.NewRequest("POST", "/api/internal/jobs/claim", nil)
-	req.Header.Set("Authorization", "Bearer wrong-secret")
 	rec := httptest.NewRecorder()
-	handler.ServeHTTP(rec, req)
+	sh.HandleListMySources(rec, authRequest(t, h, "GET", "/api/me/sources", nil, token))
+	byID := map[string]map[string]interface{}{}
+	for _, s := range decodeJSON(t, rec)["sources"].([]interface{}) {
+		src := s.(map[string]interface{})
+		rejection, _ := src["rejection"].(map[string]interface{})
+		byID[src["id"].(string)] = rejection
+	}
+	if r := byID[silentSource]; r["code"] != "no_speech" || r["message"] != "No speech or sound (97% silent)" ||
+		r["metrics"].(map[string]interface{})["silence_ratio"] != 0.97 {
+		t.Errorf("structured rejection = %v", r)
+	}
+	if r := byID[legacySource]; r["code"] != "other" || r["message"] != "Video too long" {
+		t.Errorf("legacy rejection = %v, want code other with the error", r)
+	}
 
-	if rec.Code != 401 {
-		t.Errorf("status = %d, want 401", rec.Code)
+	rec = httptest.NewRecorder()
+	sh.HandleListSourceClips(rec, withChiParam(authRequest(t, h, "GET", "/api/me/sources/"+silentSource+"/clips", nil, token), "id", silentSource))
+	if r := decodeJSON(t, rec)["source"].(map[string]interface{})["rejection"].(map[string]interface{}); r["code"] != "no_speech" {
+		t.Errorf("source clips rejection = %v", r)
 	}
 }
 
-// --- Scout ---
+// --- Profile ---
 
-func TestScoutSourceCRUD(t *testing.T) {
+func TestHandleGetProfile(t *testing.T) {
 	h := newTestHandlers(t)
-	token := registerUser(t, h, "scoutuser", "password123")
+	token := registerUser(t, h, "profuser", "password123")
 
-	// Create source
-	body := map[string]interface{}{"source_type": "channel", "platform": "youtube", "identifier": "@test"}
-	req := authRequest(t, h, "POST", "/api/scout/sources", body, token)
+	req := authRequest(t, h, "GET", "/api/me", nil, token)
 	rec := httptest.NewRecorder()
-	h.scoutH.HandleCreateScoutSource(rec, req)
-	if rec.Code != 201 {
-		t.Fatalf("create: status = %d, want 201; body: %s", rec.Code, rec.Body.String())
-	}
-	resp := decodeJSON(t, rec)
-	sourceID := resp["id"].(string)
+	h.profileH.HandleGetProfile(rec, req)
 
-	// List sources
-	req = authRequest(t, h, "GET", "/api/scout/sources", nil, token)
-	rec = httptest.NewRecorder()
-	h.scoutH.HandleListScoutSources(rec, req)
 	if rec.Code != 200 {
-		t.Fatalf("list: status = %d, want 200", rec.Code)
+		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	if resp["username"] != "profuser" {
+		t.Errorf("username = %v, want %q", resp["username"], "profuser")
 	}
+}
+
+func TestHandleUpdatePreferences(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "prefuser", "password123")
+
+	body := map[string]interface{}{"exploration_rate": 0.5, "autoplay": true}
+	req := authRequest(t, h, "PUT", "/api/me/preferences", body, token)
+	rec := httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, req)
 
-	// Delete source
-	req = authRequest(t, h, "DELETE", "/api/scout/sources/"+sourceID, nil, token)
-	req = withChiParam(req, "id", sourceID)
-	rec = httptest.NewRecorder()
-	h.scoutH.HandleDeleteScoutSource(rec, req)
 	if rec.Code != 200 {
-		t.Fatalf("delete: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
 	}
 }
 
-// --- Slugify / Truncate ---
+func TestHandleUpdatePreferences_InvalidRange(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "badpref", "password123")
 
-func TestSlugify(t *testing.T) {
-	tests := []struct {
-		input string
-		want  string
-	}{
-		{"Machine Learning", "machine-learning"},
-		{"  AI & ML  ", "ai-ml"},
-		{"hello", "hello"},
-	}
-	for _, tc := range tests {
-		got := worker.Slugify(tc.input)
-		if got != tc.want {
-			t.Errorf("Slugify(%q) = %q, want %q", tc.input, got, tc.want)
-		}
+	body := map[string]interface{}{"exploration_rate": 5.0}
+	req := authRequest(t, h, "PUT", "/api/me/preferences", body, token)
+	rec := httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, req)
+
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestSettingsBundle_ExportMergeReplaceAndValidation(t *testing.T) {
+	h := newTestHandlers(t)
+	tokenA := registerUser(t, h, "bundlea", "password123")
+	tokenB := registerUser(t, h, "bundleb", "password123")
+	var userA, userB string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'bundlea'`).Scan(&userA)
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'bundleb'`).Scan(&userB)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-cook', 'Cooking', 'cooking'), ('t-game', 'Gaming', 'gaming')`)
+
+	rec := httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences",
+		map[string]interface{}{"exploration_rate": 0.7, "data_saver": true}, tokenA))
+	if rec.Code != 200 {
+		t.Fatalf("update preferences: status = %d", rec.Code)
+	}
+	expires := time.Now().UTC().AddDate(0, 0, 3).Format("2006-01-02T15:04:05Z")
+	for _, q := range []string{
+		`INSERT INTO user_topic_affinities (user_id, topic_id, weight, source) VALUES ('` + userA + `', 't-cook', 1.8, 'explicit')`,
+		`INSERT INTO user_feedback_weights (user_id, dimension, target, weight) VALUES ('` + userA + `', 'channel', 'Loud Channel', 0.25)`,
+		`INSERT INTO user_snoozes (id, user_id, target_type, target_id, label, expires_at) VALUES ('sn-a', '` + userA + `', 'topic', 't-game', 'Gaming', '` + expires + `')`,
+		`INSERT INTO saved_filters (id, user_id, name, query, is_default) VALUES ('sf-a', '` + userA + `', 'Short', '{"duration":{"min":0,"max":30}}', 1)`,
+		`INSERT INTO user_topic_affinities (user_id, topic_id, weight, source) VALUES ('` + userB + `', 't-game', 1.2, 'explicit')`,
+		`INSERT INTO saved_filters (id, user_id, name, query, is_default) VALUES ('sf-b', '` + userB + `', 'Mine', '{}', 0)`,
+	} {
+		if _, err := h.db.Exec(q); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	if _, err := contentfilter.Create(context.Background(), h.db, &contentfilter.Filter{
+		UserID: userA, Name: "spoilers", Kind: contentfilter.KindKeyword, Pattern: "spoiler", Threshold: 1,
+	}); err != nil {
+		t.Fatalf("create filter: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleExportSettings(rec, authRequest(t, h, "GET", "/api/me/settings-bundle", nil, tokenA))
+	if rec.Code != 200 {
+		t.Fatalf("export: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var bundle map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &bundle)
+	if bundle["version"] != float64(1) || bundle["preferences"].(map[string]interface{})["exploration_rate"] != 0.7 {
+		t.Fatalf("bundle = %v, want version 1 with exploration_rate 0.7", bundle)
+	}
+	if a := bundle["topic_affinities"].([]interface{}); len(a) != 1 || a[0].(map[string]interface{})["topic"] != "cooking" {
+		t.Errorf("exported affinities = %v, want cooking by slug", a)
+	}
+	if s := bundle["snoozes"].([]interface{}); len(s) != 1 || s[0].(map[string]interface{})["target"] != "gaming" {
+		t.Errorf("exported snoozes = %v, want gaming by slug", s)
+	}
+
+	importAs := func(query string, body interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.profileH.HandleImportSettings(rec, authRequest(t, h, "PUT", "/api/me/settings-bundle"+query, body, tokenB))
+		return rec
+	}
+	affinities := func() string {
+		rows, _ := h.db.Query(`SELECT topic_id FROM user_topic_affinities WHERE user_id = ? ORDER BY topic_id`, userB)
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// Rejected bundles change nothing.
+	for name, c := range map[string]struct {
+		query string
+		body  map[string]interface{}
+	}{
+		"newer version":      {"", map[string]interface{}{"version": 2}},
+		"unknown preference": {"", map[string]interface{}{"version": 1, "preferences": map[string]interface{}{"volume": 11}}},
+		"bad regex": {"", map[string]interface{}{"version": 1,
+			"topic_affinities": []map[string]interface{}{{"topic": "cooking", "weight": 1}},
+			"content_filters":  []map[string]interface{}{{"name": "x", "kind": "regex", "pattern": "(", "threshold": 1}}}},
+		"unknown mode": {"?mode=overwrite", map[string]interface{}{"version": 1}},
+	} {
+		if rec := importAs(c.query, c.body); rec.Code != 400 {
+			t.Errorf("%s: status = %d, want 400", name, rec.Code)
+		}
+	}
+	if got := affinities(); got != "t-game" {
+		t.Fatalf("affinities after rejected imports = %q, want t-game", got)
+	}
+
+	bundle["topic_affinities"] = append(bundle["topic_affinities"].([]interface{}),
+		map[string]interface{}{"topic": "knitting", "weight": 1.5})
+	if rec := importAs("?dry_run=true", bundle); rec.Code != 200 || affinities() != "t-game" {
+		t.Fatalf("dry run: status = %d, affinities %q; want 200 and nothing saved", rec.Code, affinities())
+	}
+
+	rec = importAs("", bundle)
+	if rec.Code != 200 {
+		t.Fatalf("merge: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	skipped := resp["skipped"].([]interface{})
+	if len(skipped) != 1 || skipped[0].(map[string]interface{})["reason"] != "unknown_topic" {
+		t.Errorf("merge skipped = %v, want the unknown knitting topic", skipped)
+	}
+	if got := affinities(); got != "t-cook,t-game" {
+		t.Errorf("affinities after merge = %q, want t-cook,t-game", got)
+	}
+	var rate float64
+	var dataSaver int
+	h.db.QueryRow(`SELECT exploration_rate, data_saver FROM user_preferences WHERE user_id = ?`, userB).Scan(&rate, &dataSaver)
+	if rate != 0.7 || dataSaver != 1 {
+		t.Errorf("preferences after merge: exploration_rate %v, data_saver %d; want 0.7 and 1", rate, dataSaver)
+	}
+	var filters, savedFilters, snoozes int
+	h.db.QueryRow(`SELECT COUNT(*) FROM content_filters WHERE user_id = ?`, userB).Scan(&filters)
+	h.db.QueryRow(`SELECT COUNT(*) FROM saved_filters WHERE user_id = ?`, userB).Scan(&savedFilters)
+	h.db.QueryRow(`SELECT COUNT(*) FROM user_snoozes WHERE user_id = ? AND target_id = 't-game'`, userB).Scan(&snoozes)
+	if filters != 1 || savedFilters != 2 || snoozes != 1 {
+		t.Errorf("after merge: %d content filters, %d saved filters, %d snoozes; want 1, 2, 1", filters, savedFilters, snoozes)
+	}
+
+	// Replacing drops the entries of each section the bundle does not have.
+	if rec := importAs("?mode=replace", bundle); rec.Code != 200 {
+		t.Fatalf("replace: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if got := affinities(); got != "t-cook" {
+		t.Errorf("affinities after replace = %q, want t-cook", got)
+	}
+	h.db.QueryRow(`SELECT COUNT(*) FROM content_filters WHERE user_id = ?`, userB).Scan(&filters)
+	h.db.QueryRow(`SELECT COUNT(*) FROM saved_filters WHERE user_id = ?`, userB).Scan(&savedFilters)
+	if filters != 1 || savedFilters != 1 {
+		t.Errorf("after replace: %d content filters, %d saved filters; want 1 and 1", filters, savedFilters)
+	}
+}
+
+func TestPreviewAffinities_ReRanksWithoutSaving(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "tuner", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'tuner'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src', 'https://example.com/s', 'youtube', 'Mixed')`)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-cook', 'Cooking', 'cooking'), ('t-game', 'Gaming', 'gaming')`)
+	for i, c := range []struct{ id, topic string }{
+		{"cook1", "t-cook"}, {"cook2", "t-cook"}, {"cook3", "t-cook"},
+		{"game1", "t-game"}, {"game2", "t-game"}, {"game3", "t-game"},
+	} {
+		if _, err := h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, content_score, status)
+			VALUES (?, 'src', ?, 30, ?, ?, ?, 'ready')`, c.id, c.id, "k-"+c.id, "tk-"+c.id, 0.9-0.05*float64(i)); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+		h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES (?, ?)`, c.id, c.topic)
+	}
+	h.db.Exec(`INSERT INTO user_topic_affinities (user_id, topic_id, weight, source) VALUES (?, 't-cook', 2.0, 'explicit')`, userID)
+	h.db.Exec(`INSERT INTO user_preferences (user_id, diversity_mix, trending_boost, exploration_rate) VALUES (?, 0, 0, 0)`, userID)
+	h.feedH.RefreshTopicGraph()
+
+	preview := func(body interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.feedH.HandlePreviewAffinities(rec, authRequest(t, h, "POST", "/api/me/affinities/preview", body, token))
+		return rec
+	}
+	rec := preview(map[string]interface{}{
+		"changes": []map[string]interface{}{{"topic": "gaming", "weight": 5}, {"topic": "Cooking", "weight": 0.5}},
+		"limit":   3,
+	})
+	if rec.Code != 200 {
+		t.Fatalf("preview: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	changes := resp["changes"].([]interface{})
+	if c := changes[0].(map[string]interface{}); c["topic_id"] != "t-game" || c["before"] != 1.0 {
+		t.Errorf("gaming change = %v, want resolved by slug from the neutral weight", c)
+	}
+	if c := changes[1].(map[string]interface{}); c["topic_id"] != "t-cook" || c["before"] != 2.0 {
+		t.Errorf("cooking change = %v, want the stored weight as before", c)
+	}
+
+	// The top 3 of both orders are listed, in the new order.
+	moves := map[string][2]float64{}
+	var order []string
+	for _, c := range resp["clips"].([]interface{}) {
+		clip := c.(map[string]interface{})
+		order = append(order, clip["id"].(string))
+		moves[clip["id"].(string)] = [2]float64{clip["before"].(float64), clip["after"].(float64)}
+	}
+	if len(order) != 6 || order[0] != "game1" || order[3] != "cook1" {
+		t.Fatalf("previewed order = %v, want gaming ahead of cooking", order)
+	}
+	if moves["game1"] != [2]float64{4, 1} || moves["cook1"] != [2]float64{1, 4} {
+		t.Errorf("moves = %v, want game1 4→1 and cook1 1→4", moves)
+	}
+
+	var weight float64
+	h.db.QueryRow(`SELECT weight FROM user_topic_affinities WHERE user_id = ? AND topic_id = 't-cook'`, userID).Scan(&weight)
+	var n int
+	h.db.QueryRow(`SELECT COUNT(*) FROM user_topic_affinities WHERE user_id = ?`, userID).Scan(&n)
+	if weight != 2.0 || n != 1 {
+		t.Errorf("stored affinities changed: cooking %v, %d rows", weight, n)
+	}
+
+	for _, body := range []interface{}{
+		map[string]interface{}{"changes": []map[string]interface{}{}},
+		map[string]interface{}{"changes": []map[string]interface{}{{"topic": "knitting", "weight": 2}}},
+		map[string]interface{}{"changes": []map[string]interface{}{{"topic": "gaming", "weight": 11}}},
+		map[string]interface{}{"changes": []map[string]interface{}{{"topic": "gaming", "weight": 2}, {"topic": "t-game", "weight": 3}}},
+	} {
+		if rec := preview(body); rec.Code != 400 {
+			t.Errorf("preview %v: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestRankingPresets_PerRequestAndSavedDefault(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "presetuser", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-preset', 'http://x.com', 'direct')`)
+	for i, c := range []struct {
+		id, createdAt string
+		score         float64
+	}{
+		{"old-best", "2024-01-01T00:00:00Z", 0.95},
+		{"newest", "2024-03-01T00:00:00Z", 0.10},
+		{"middle", "2024-02-01T00:00:00Z", 0.50},
+	} {
+		if _, err := h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, created_at) VALUES (?, 'src-preset', ?, 30.0, 'k', 'ready', ?, ?)`,
+			c.id, fmt.Sprintf("Clip %d", i), c.score, c.createdAt); err != nil {
+			t.Fatalf("insert clip: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.authH.OptionalAuth(h.feedH.HandleListPresets)(rec, authRequest(t, h, "GET", "/api/feed/presets", nil, token))
+	resp := decodeJSON(t, rec)
+	presets := resp["presets"].([]interface{})
+	if len(presets) != 4 || resp["default"] != nil {
+		t.Fatalf("presets response = %v, want 4 presets and no default", resp)
+	}
+	if first := presets[0].(map[string]interface{}); first["name"] != "balanced" || first["diversity_mix"] != 0.5 {
+		t.Errorf("first preset = %v, want balanced with diversity_mix 0.5", first)
+	}
+
+	feedIDs := func(url string) ([]string, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, authRequest(t, h, "GET", url, nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("GET %s status = %d; body: %s", url, rec.Code, rec.Body.String())
+		}
+		resp := decodeJSON(t, rec)
+		var ids []string
+		for _, c := range resp["clips"].([]interface{}) {
+			ids = append(ids, c.(map[string]interface{})["id"].(string))
+		}
+		return ids, resp
+	}
+
+	rec = httptest.NewRecorder()
+	h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, authRequest(t, h, "GET", "/api/feed?preset=loud", nil, token))
+	if rec.Code != 400 {
+		t.Errorf("unknown preset status = %d, want 400", rec.Code)
+	}
+
+	ids, resp := feedIDs("/api/feed?preset=chronological")
+	if strings.Join(ids, ",") != "newest,middle,old-best" || resp["preset"] != "chronological" || resp["rank_level"] != "chronological" {
+		t.Errorf("chronological feed = %v (preset %v, rank_level %v), want newest first", ids, resp["preset"], resp["rank_level"])
+	}
+	if _, resp := feedIDs("/api/feed"); resp["preset"] != nil {
+		t.Errorf("feed without preset reports preset %v", resp["preset"])
+	}
+
+	// Save chronological as the default.
+	rec = httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences", map[string]interface{}{"ranking_preset": "nope"}, token))
+	if rec.Code != 400 {
+		t.Errorf("unknown ranking_preset status = %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences", map[string]interface{}{"ranking_preset": "chronological"}, token))
+	if rec.Code != 200 {
+		t.Fatalf("save preset status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if ids, resp := feedIDs("/api/feed"); ids[0] != "newest" || resp["preset"] != "chronological" {
+		t.Errorf("feed with saved preset = %v (preset %v), want chronological", ids, resp["preset"])
+	}
+	rec = httptest.NewRecorder()
+	h.authH.OptionalAuth(h.feedH.HandleListPresets)(rec, authRequest(t, h, "GET", "/api/feed/presets", nil, token))
+	if resp := decodeJSON(t, rec); resp["default"] != "chronological" {
+		t.Errorf("presets default = %v, want chronological", resp["default"])
+	}
+
+	// Tuning one ranking preference by hand leaves the preset.
+	rec = httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences", map[string]interface{}{"diversity_mix": 0.2}, token))
+	if rec.Code != 200 {
+		t.Fatalf("update diversity_mix status = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetProfile(rec, authRequest(t, h, "GET", "/api/me", nil, token))
+	prefs := decodeJSON(t, rec)["preferences"].(map[string]interface{})
+	if prefs["ranking_preset"] != nil || prefs["diversity_mix"] != 0.2 {
+		t.Errorf("preferences after tuning = %v, want no ranking_preset and diversity_mix 0.2", prefs)
+	}
+}
+
+type memAvatarStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memAvatarStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memAvatarStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memAvatarStore) Remove(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func avatarUpload(t *testing.T, h *testHandlers, token string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("avatar", "avatar")
+	fw.Write(data)
+	mw.Close()
+	req := authRequest(t, h, "POST", "/api/me/avatar", nil, token)
+	req.Body = io.NopCloser(&body)
+	req.ContentLength = int64(body.Len())
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.profileH.HandleUploadAvatar(rec, req)
+	return rec
+}
+
+func TestAvatar_UploadResizeServeAndDelete(t *testing.T) {
+	h := newTestHandlers(t)
+	store := &memAvatarStore{objects: map[string][]byte{}}
+	h.profileH.Avatars = store
+	token := registerUser(t, h, "avataruser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'avataruser'`).Scan(&userID)
+
+	src := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 600; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var png1 bytes.Buffer
+	png.Encode(&png1, src)
+
+	if rec := avatarUpload(t, h, token, []byte("definitely not an image")); rec.Code != 415 {
+		t.Errorf("text upload status = %d, want 415", rec.Code)
+	}
+	if rec := avatarUpload(t, h, token, bytes.Repeat([]byte{0xff}, profile.MaxAvatarBytes+1)); rec.Code != 413 {
+		t.Errorf("oversize upload status = %d, want 413", rec.Code)
+	}
+
+	rec := avatarUpload(t, h, token, png1.Bytes())
+	if rec.Code != 200 {
+		t.Fatalf("upload status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	firstURL, _ := decodeJSON(t, rec)["avatar_url"].(string)
+	if !strings.HasPrefix(firstURL, "/api/users/"+userID+"/avatar?v=") {
+		t.Fatalf("avatar_url = %q", firstURL)
+	}
+	if len(store.objects) != 1 {
+		t.Fatalf("stored objects = %d, want 1", len(store.objects))
+	}
+	var firstKey string
+	for k, data := range store.objects {
+		firstKey = k
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil || format != "jpeg" {
+			t.Fatalf("stored avatar format = %q, err = %v", format, err)
+		}
+		if b := img.Bounds(); b.Dx() != profile.AvatarSize || b.Dy() != profile.AvatarSize {
+			t.Errorf("stored avatar is %dx%d, want %dx%d", b.Dx(), b.Dy(), profile.AvatarSize, profile.AvatarSize)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetProfile(rec, authRequest(t, h, "GET", "/api/me", nil, token))
+	if got := decodeJSON(t, rec)["avatar_url"]; got != firstURL {
+		t.Errorf("profile avatar_url = %v, want %q", got, firstURL)
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetAvatar(rec, withChiParam(httptest.NewRequest("GET", firstURL, nil), "id", userID))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("get avatar status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("versioned avatar Cache-Control = %q, want immutable", rec.Header().Get("Cache-Control"))
+	}
+
+	// A new upload replaces the old object rather than accumulating.
+	if rec := avatarUpload(t, h, token, png1.Bytes()); rec.Code != 200 {
+		t.Fatalf("re-upload status = %d", rec.Code)
+	}
+	if _, ok := store.objects[firstKey]; ok || len(store.objects) != 1 {
+		t.Errorf("old avatar kept after re-upload; objects = %d", len(store.objects))
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleDeleteAvatar(rec, authRequest(t, h, "DELETE", "/api/me/avatar", nil, token))
+	if rec.Code != 200 {
+		t.Fatalf("delete status = %d", rec.Code)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("objects after delete = %d, want 0", len(store.objects))
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetProfile(rec, authRequest(t, h, "GET", "/api/me", nil, token))
+	if got := decodeJSON(t, rec)["avatar_url"]; got != nil {
+		t.Errorf("profile avatar_url after delete = %v, want nil", got)
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetAvatar(rec, withChiParam(httptest.NewRequest("GET", "/api/users/"+userID+"/avatar", nil), "id", userID))
+	if rec.Code != 404 {
+		t.Errorf("get avatar after delete status = %d, want 404", rec.Code)
+	}
+}
+
+// --- Collections ---
+
+func TestCollectionsCRUD(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "collector", "password123")
+
+	// Create
+	body := map[string]string{"title": "My Collection", "description": "Test desc"}
+	req := authRequest(t, h, "POST", "/api/collections", body, token)
+	rec := httptest.NewRecorder()
+	h.collectionsH.HandleCreateCollection(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("create: status = %d, want 201; body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	colID := resp["id"].(string)
+
+	// List
+	req = authRequest(t, h, "GET", "/api/collections", nil, token)
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleListCollections(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("list: status = %d, want 200", rec.Code)
+	}
+
+	// Delete
+	req = authRequest(t, h, "DELETE", "/api/collections/"+colID, nil, token)
+	req = withChiParam(req, "id", colID)
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleDeleteCollection(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("delete: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestSmartCollections_FilterMembershipAndRefresh(t *testing.T) {
+	h := newTestHandlers(t)
+	h.collectionsH.Match = h.feedH.MatchClips
+	token := registerUser(t, h, "curator", "password123")
+
+	for _, q := range []string{
+		`INSERT INTO sources (id, url, platform, channel_name) VALUES ('bab', 'https://example.com/b', 'youtube', 'Babish')`,
+		`INSERT INTO sources (id, url, platform, channel_name) VALUES ('oth', 'https://example.com/o', 'youtube', 'Other')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, content_score, status)
+		 VALUES ('pasta', 'bab', 'Pasta', 30, 'k1', 'tk1', '["cooking"]', 0.9, 'ready')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, content_score, status)
+		 VALUES ('soup', 'oth', 'Soup', 40, 'k2', 'tk2', '["cooking"]', 0.8, 'ready')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, content_score, status)
+		 VALUES ('roast', 'oth', 'Roast', 120, 'k3', 'tk3', '["cooking"]', 0.7, 'ready')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, content_score, status)
+		 VALUES ('song', 'oth', 'Song', 20, 'k4', 'tk4', '["music"]', 0.6, 'ready')`,
+	} {
+		if _, err := h.db.Exec(q); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	clipsOf := func(id string) (map[string]interface{}, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.collectionsH.HandleGetCollectionClips(rec, withChiParam(authRequest(t, h, "GET", "/api/collections/"+id+"/clips", nil, token), "id", id))
+		if rec.Code != 200 {
+			t.Fatalf("get clips: status = %d; body: %s", rec.Code, rec.Body.String())
+		}
+		resp := decodeJSON(t, rec)
+		matched := map[string]interface{}{}
+		for _, c := range resp["clips"].([]interface{}) {
+			clip := c.(map[string]interface{})
+			matched[clip["id"].(string)] = clip["matched_filter"]
+		}
+		return resp["collection"].(map[string]interface{}), matched
+	}
+
+	rec := httptest.NewRecorder()
+	h.collectionsH.HandleCreateCollection(rec, authRequest(t, h, "POST", "/api/collections", map[string]interface{}{
+		"title": "Cooking", "filters": []string{"channel:Babish", "tpic:cooking"},
+	}, token))
+	if rec.Code != 400 {
+		t.Fatalf("bad filter: status = %d, want 400", rec.Code)
+	}
+	if resp := decodeJSON(t, rec); resp["filter"] != float64(1) || resp["position"] != float64(1) {
+		t.Errorf("bad filter error = %v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleCreateCollection(rec, authRequest(t, h, "POST", "/api/collections", map[string]interface{}{
+		"title": "Cooking", "filters": []string{"channel:Babish", "topic:cooking dur:<60"},
+	}, token))
+	if rec.Code != 201 {
+		t.Fatalf("create: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	id := decodeJSON(t, rec)["id"].(string)
+
+	col, matched := clipsOf(id)
+	if col["smart"] != true || len(matched) != 2 || matched["pasta"] != "channel:Babish" || matched["soup"] != "topic:cooking dur:<60" {
+		t.Errorf("smart collection = %v with clips %v", col, matched)
+	}
+
+	// Membership comes from the filters alone.
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleAddToCollection(rec, withChiParam(authRequest(t, h, "POST", "/api/collections/"+id+"/clips",
+		map[string]string{"clip_id": "song"}, token), "id", id))
+	if rec.Code != 409 {
+		t.Errorf("add to smart collection: status = %d, want 409", rec.Code)
+	}
+
+	// A new matching clip appears once the collection is due a refresh.
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, content_score, status)
+		VALUES ('stew', 'oth', 'Stew', 20, 'k5', 'tk5', '["cooking"]', 0.5, 'ready')`)
+	if _, matched = clipsOf(id); len(matched) != 2 {
+		t.Errorf("fresh collection refreshed early: %v", matched)
+	}
+	h.db.Exec(`UPDATE collections SET refreshed_at = '2000-01-01T00:00:00Z' WHERE id = ?`, id)
+	if _, matched = clipsOf(id); len(matched) != 3 || matched["stew"] != "topic:cooking dur:<60" {
+		t.Errorf("after refresh = %v", matched)
+	}
+
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleSetFilters(rec, withChiParam(authRequest(t, h, "PUT", "/api/collections/"+id+"/filters",
+		map[string]interface{}{"filters": []string{"dur:>100"}}, token), "id", id))
+	if rec.Code != 200 {
+		t.Fatalf("set filters: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if col, matched = clipsOf(id); len(matched) != 1 || matched["roast"] != "dur:>100" {
+		t.Errorf("after new filters = %v", matched)
+	}
+	if filters := col["filters"].([]interface{}); len(filters) != 1 || filters[0] != "dur:>100" {
+		t.Errorf("filters = %v", filters)
+	}
+
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleListCollections(rec, authRequest(t, h, "GET", "/api/collections", nil, token))
+	listed := decodeJSON(t, rec)["collections"].([]interface{})[0].(map[string]interface{})
+	if listed["smart"] != true || listed["clip_count"] != float64(1) {
+		t.Errorf("listed collection = %v", listed)
+	}
+}
+
+func TestCollectionSuggestions_GroupAcceptAndDismiss(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "espresso", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'espresso'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src', 'https://example.com/s', 'youtube')`)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-esp', 'Home Espresso', 'home-espresso')`)
+	seed := func(id, title string, emb []float32, topic bool) {
+		t.Helper()
+		if _, err := h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status)
+			VALUES (?, 'src', ?, 30, ?, ?, 'ready')`, id, title, "k-"+id, "tk-"+id); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+		h.db.Exec(`INSERT INTO clip_embeddings (clip_id, text_embedding) VALUES (?, ?)`, id, feed.Float32ToBlob(emb))
+		if topic {
+			h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES (?, 't-esp')`, id)
+		}
+		h.db.Exec(`INSERT INTO saved_clips (user_id, clip_id) VALUES (?, ?)`, userID, id)
+	}
+	for i := 0; i < 6; i++ {
+		seed(fmt.Sprintf("esp%d", i), fmt.Sprintf("Espresso %d", i), []float32{1, 0.1 * float32(i), 0}, i != 5)
+	}
+	seed("gtr0", "Guitar basics", []float32{0, 1, 0}, false)
+	for i := 1; i < 5; i++ {
+		seed(fmt.Sprintf("gtr%d", i), fmt.Sprintf("Guitar %d", i), []float32{0, 1, 0.1 * float32(i)}, false)
+	}
+	seed("odd", "Odd one out", []float32{0, 0, 1}, false)
+
+	list := func() []interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.collectionsH.HandleListSuggestions(rec, authRequest(t, h, "GET", "/api/collections/suggestions", nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("list: status = %d; body: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)["suggestions"].([]interface{})
+	}
+	suggestions := list()
+	if len(suggestions) != 2 {
+		t.Fatalf("suggestions = %v, want espresso and guitar", suggestions)
+	}
+	esp := suggestions[0].(map[string]interface{})
+	gtr := suggestions[1].(map[string]interface{})
+	if esp["title"] != "Home Espresso" || esp["clip_count"] != float64(6) || len(esp["clips"].([]interface{})) != 4 {
+		t.Errorf("espresso suggestion = %v", esp)
+	}
+	if gtr["clip_count"] != float64(5) || !strings.HasPrefix(gtr["title"].(string), "Guitar") {
+		t.Errorf("guitar suggestion = %v, want it named after a guitar clip", gtr)
+	}
+
+	// Accepting seeds a collection with the grouped clips.
+	espID := esp["id"].(string)
+	rec := httptest.NewRecorder()
+	h.collectionsH.HandleAcceptSuggestion(rec, withChiParam(authRequest(t, h, "POST",
+		"/api/collections/suggestions/"+espID+"/accept", nil, token), "id", espID))
+	if rec.Code != 201 {
+		t.Fatalf("accept: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	created := decodeJSON(t, rec)
+	var count int
+	h.db.QueryRow(`SELECT COUNT(*) FROM collection_clips WHERE collection_id = ?`, created["id"]).Scan(&count)
+	if created["title"] != "Home Espresso" || count != 6 {
+		t.Errorf("created = %v with %d clips, want the 6 espresso clips", created, count)
+	}
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleAcceptSuggestion(rec, withChiParam(authRequest(t, h, "POST",
+		"/api/collections/suggestions/"+espID+"/accept", nil, token), "id", espID))
+	if rec.Code != 404 {
+		t.Errorf("accept twice: status = %d, want 404", rec.Code)
+	}
+
+	gtrID := gtr["id"].(string)
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleDismissSuggestion(rec, withChiParam(authRequest(t, h, "DELETE",
+		"/api/collections/suggestions/"+gtrID, nil, token), "id", gtrID))
+	if rec.Code != 200 {
+		t.Fatalf("dismiss: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+
+	// Regrouping skips clips now in a collection and the dismissed group.
+	h.db.Exec(`UPDATE collection_suggestion_runs SET ran_at = '2000-01-01T00:00:00Z'`)
+	if n, err := h.collectionsH.SuggestDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("SuggestDue = %d, %v; want the user regrouped", n, err)
+	}
+	if suggestions = list(); len(suggestions) != 0 {
+		t.Errorf("suggestions after accept and dismiss = %v, want none", suggestions)
+	}
+}
+
+func coverUpload(t *testing.T, h *testHandlers, token, id string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("cover", "cover")
+	fw.Write(data)
+	mw.Close()
+	req := authRequest(t, h, "PUT", "/api/collections/"+id+"/cover", nil, token)
+	req.Body = io.NopCloser(&body)
+	req.ContentLength = int64(body.Len())
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.collectionsH.HandleUploadCover(rec, withChiParam(req, "id", id))
+	return rec
+}
+
+func TestCollectionCovers_MosaicUploadAndServe(t *testing.T) {
+	h := newTestHandlers(t)
+	store := &memAvatarStore{objects: map[string][]byte{}}
+	h.collectionsH.Covers = store
+	token := registerUser(t, h, "coverer", "password123")
+
+	solid := func(c color.RGBA) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 320, 180))
+		for y := 0; y < 180; y++ {
+			for x := 0; x < 320; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		return buf.Bytes()
+	}
+	red, blue, green := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}, color.RGBA{G: 255, A: 255}
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-cover', 'http://x.com', 'direct')`)
+	for _, c := range []struct {
+		id  string
+		img []byte
+	}{{"c-red", solid(red)}, {"c-blue", solid(blue)}} {
+		key := "clips/" + c.id + "/thumbnail.jpg"
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status) VALUES (?, 'src-cover', ?, 30.0, 'k', ?, 'ready')`, c.id, c.id, key)
+		store.Put(context.Background(), key, c.img, "image/png")
+	}
+
+	rec := httptest.NewRecorder()
+	h.collectionsH.HandleCreateCollection(rec, authRequest(t, h, "POST", "/api/collections", map[string]string{"title": "Colors"}, token))
+	id := decodeJSON(t, rec)["id"].(string)
+
+	listed := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.collectionsH.HandleListCollections(rec, authRequest(t, h, "GET", "/api/collections", nil, token))
+		return decodeJSON(t, rec)["collections"].([]interface{})[0].(map[string]interface{})
+	}
+	cover := func() image.Image {
+		t.Helper()
+		var key string
+		h.db.QueryRow(`SELECT cover_key FROM collections WHERE id = ?`, id).Scan(&key)
+		img, format, err := image.Decode(bytes.NewReader(store.objects[key]))
+		if err != nil || format != "jpeg" {
+			t.Fatalf("cover %q format = %q, err = %v", key, format, err)
+		}
+		if b := img.Bounds(); b.Dx() != collections.CoverSize || b.Dy() != collections.CoverSize {
+			t.Errorf("cover is %dx%d, want %d square", b.Dx(), b.Dy(), collections.CoverSize)
+		}
+		return img
+	}
+	near := func(img image.Image, x, y int, want color.RGBA) bool {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return math.Abs(float64(r>>8)-float64(want.R)) < 40 &&
+			math.Abs(float64(g>>8)-float64(want.G)) < 40 &&
+			math.Abs(float64(b>>8)-float64(want.B)) < 40
+	}
+	addClip := func(clipID string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.collectionsH.HandleAddToCollection(rec, withChiParam(authRequest(t, h, "POST", "/api/collections/"+id+"/clips",
+			map[string]string{"clip_id": clipID}, token), "id", id))
+		if rec.Code != 200 {
+			t.Fatalf("add %s: status = %d", clipID, rec.Code)
+		}
+	}
+	objects := func() int {
+		n := 0
+		for k := range store.objects {
+			if strings.HasPrefix(k, "covers/") {
+				n++
+			}
+		}
+		return n
+	}
+
+	if col := listed(); col["cover_url"] != nil {
+		t.Errorf("empty collection cover = %v, want none", col["cover_url"])
+	}
+
+	// Each clip added rebuilds the mosaic from the member thumbnails.
+	addClip("c-red")
+	first := listed()
+	if first["cover_source"] != "mosaic" || first["cover_url"] == nil {
+		t.Fatalf("cover after one clip = %v", first)
+	}
+	if img := cover(); !near(img, 256, 256, red) {
+		t.Errorf("one-clip mosaic center = %v, want red", img.At(256, 256))
+	}
+	addClip("c-blue")
+	second := listed()
+	if second["cover_url"] == first["cover_url"] {
+		t.Error("cover_url unchanged after the mosaic was rebuilt")
+	}
+	if img := cover(); !near(img, 128, 256, red) || !near(img, 384, 256, blue) {
+		t.Errorf("two-clip mosaic = %v | %v, want red | blue", img.At(128, 256), img.At(384, 256))
+	}
+	if n := objects(); n != 1 {
+		t.Errorf("cover objects = %d after a rebuild, want the old one removed", n)
+	}
+
+	// The cover is served at its versioned URL only.
+	coverURL := second["cover_url"].(string)
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleGetCover(rec, withChiParam(httptest.NewRequest("GET", coverURL, nil), "id", id))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("get cover = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleGetCover(rec, withChiParam(httptest.NewRequest("GET", "/api/collections/"+id+"/cover", nil), "id", id))
+	if rec.Code != 404 {
+		t.Errorf("get cover without its version = %d, want 404", rec.Code)
+	}
+
+	// An uploaded cover replaces the mosaic and survives clip changes.
+	if rec := coverUpload(t, h, token, id, []byte("not an image")); rec.Code != 415 {
+		t.Errorf("text cover upload = %d, want 415", rec.Code)
+	}
+	other := registerUser(t, h, "notowner", "password123")
+	if rec := coverUpload(t, h, other, id, solid(green)); rec.Code != 404 {
+		t.Errorf("cover upload by another user = %d, want 404", rec.Code)
+	}
+	rec = coverUpload(t, h, token, id, solid(green))
+	if rec.Code != 200 || decodeJSON(t, rec)["cover_source"] != "upload" {
+		t.Fatalf("cover upload = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req := authRequest(t, h, "DELETE", "/api/collections/"+id+"/clips/c-blue", nil, token)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	rctx.URLParams.Add("clipId", "c-blue")
+	h.collectionsH.HandleRemoveFromCollection(rec, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+	if rec.Code != 200 {
+		t.Fatalf("remove clip: status = %d", rec.Code)
+	}
+	if col := listed(); col["cover_source"] != "upload" || !near(cover(), 256, 256, green) {
+		t.Errorf("uploaded cover after removing a clip = %v", col)
+	}
+	if n := objects(); n != 1 {
+		t.Errorf("cover objects = %d after upload, want 1", n)
+	}
+
+	// Deleting the upload goes back to a mosaic of the remaining clips.
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleDeleteCover(rec, withChiParam(authRequest(t, h, "DELETE", "/api/collections/"+id+"/cover", nil, token), "id", id))
+	if resp := decodeJSON(t, rec); rec.Code != 200 || resp["cover_source"] != "mosaic" {
+		t.Fatalf("delete cover = %d %v", rec.Code, resp)
+	}
+	if img := cover(); !near(img, 384, 256, red) {
+		t.Errorf("mosaic after deleting the upload = %v, want red", img.At(384, 256))
+	}
+
+	rec = httptest.NewRecorder()
+	h.collectionsH.HandleDeleteCollection(rec, withChiParam(authRequest(t, h, "DELETE", "/api/collections/"+id, nil, token), "id", id))
+	if n := objects(); rec.Code != 200 || n != 0 {
+		t.Errorf("delete collection = %d with %d cover objects left", rec.Code, n)
+	}
+}
+
+// --- Playlists ---
+
+func TestPlaylists_TokenAuthAndM3UOutput(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "vlcfan", "password123")
+	registerUser(t, h, "stranger", "password123")
+	var userID, strangerID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'vlcfan'`).Scan(&userID)
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'stranger'`).Scan(&strangerID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('psrc', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('pc1', 'psrc', 'First, with comma', 12.4, 'clips/pc1.mp4', 'ready')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('pc2', 'psrc', 'Gone', 30.0, 'clips/pc2.mp4', 'expired')`)
+	h.db.Exec(`INSERT INTO saved_clips (user_id, clip_id) VALUES (?, 'pc1'), (?, 'pc2')`, userID, userID)
+	h.db.Exec(`INSERT INTO collections (id, user_id, title) VALUES ('pcol', ?, 'Road trip')`, userID)
+	h.db.Exec(`INSERT INTO collection_clips (collection_id, clip_id, position) VALUES ('pcol', 'pc1', 0)`)
+	h.db.Exec(`INSERT INTO collections (id, user_id, title) VALUES ('scol', ?, 'Private')`, strangerID)
+
+	ph := &playlist.Handler{DB: h.db, Auth: h.authH,
+		PresignStream: func(ctx context.Context, key string, expiry time.Duration) (string, error) {
+			return "/storage/test-bucket/" + key + "?sig=x", nil
+		}}
+
+	rec := httptest.NewRecorder()
+	ph.HandleCreateToken(rec, authRequest(t, h, "POST", "/api/me/playlist-token", nil, token))
+	if rec.Code != 201 {
+		t.Fatalf("create token: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	playlistToken := decodeJSON(t, rec)["token"].(string)
+
+	fetch := func(handler http.HandlerFunc, url, collectionID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Host = "clips.example"
+		if collectionID != "" {
+			req = withChiParam(req, "id", collectionID)
+		}
+		rec := httptest.NewRecorder()
+		ph.TokenAuth(handler)(rec, req)
+		return rec
+	}
+
+	rec = fetch(ph.HandleSavedPlaylist, "/api/me/saved/playlist.m3u?token="+playlistToken, "")
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "audio/x-mpegurl") {
+		t.Fatalf("saved playlist: status = %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	want := "#EXTM3U\n#PLAYLIST:ClipFeed Saved\n#EXTINF:12,First, with comma\nhttp://clips.example/storage/test-bucket/clips/pc1.mp4?sig=x\n"
+	if rec.Body.String() != want {
+		t.Errorf("saved playlist =\n%s\nwant\n%s", rec.Body.String(), want)
+	}
+
+	rec = fetch(ph.HandleCollectionPlaylist, "/api/collections/pcol/playlist.m3u8?token="+playlistToken, "pcol")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "#PLAYLIST:Road trip\n#EXTINF:12,") {
+		t.Errorf("collection playlist: status = %d, body:\n%s", rec.Code, rec.Body.String())
+	}
+	if rec := fetch(ph.HandleCollectionPlaylist, "/api/collections/scol/playlist.m3u8?token="+playlistToken, "scol"); rec.Code != 404 {
+		t.Errorf("another user's private collection: status = %d, want 404", rec.Code)
+	}
+
+	// Playlist tokens are not session tokens.
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+playlistToken)
+	if uid := auth.ExtractUserIDFromToken(req, h.authH.JWTSecret); uid != "" {
+		t.Errorf("playlist token authenticated as %q outside playlists", uid)
+	}
+
+	rec = httptest.NewRecorder()
+	ph.HandleRevokeToken(rec, authRequest(t, h, "DELETE", "/api/me/playlist-token", nil, token))
+	if rec.Code != 200 {
+		t.Fatalf("revoke token: status = %d", rec.Code)
+	}
+	if rec := fetch(ph.HandleSavedPlaylist, "/api/me/saved/playlist.m3u?token="+playlistToken, ""); rec.Code != 401 {
+		t.Errorf("revoked token: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestSharePages_OEmbedEmbedAndSitemap(t *testing.T) {
+	h := newTestHandlers(t)
+	shareH := &share.Handler{
+		DB: h.db, MinioBucket: "test-bucket", BaseURL: "https://clips.example.com", Secret: "test-secret",
+		PresignStream: func(_ context.Context, key string, _ time.Duration) (string, error) {
+			return "/storage/test-bucket/" + key + "?signed", nil
+		},
+	}
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-share', 'https://example.com/v', 'direct', 'Shop <Talk>')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, description, duration_seconds, storage_key, thumbnail_key, width, height, status) VALUES
+		('c-share', 'src-share', 'Dovetails & "joints"', 'Hand-cut', 30.0, 'clips/c-share/clip.mp4', 'clips/c-share/thumbnail.jpg', 1080, 1920, 'ready'),
+		('c-wide', 'src-share', 'Wide', '', 30.0, 'clips/c-wide/clip.mp4', '', 1920, 1080, 'ready'),
+		('c-pending', 'src-share', 'Pending', '', 30.0, 'k', '', NULL, NULL, 'processing')`)
+	registerUser(t, h, "sharer", "password123")
+	var ownerID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'sharer'`).Scan(&ownerID)
+	h.db.Exec(`INSERT INTO collections (id, user_id, title, is_public) VALUES ('pub', ?, 'Joinery', 1), ('priv', ?, 'Secret', 0)`, ownerID, ownerID)
+	h.db.Exec(`INSERT INTO collection_clips (collection_id, clip_id, position) VALUES ('pub', 'c-share', 0)`)
+
+	page := func(handler http.HandlerFunc, path, id string) (*httptest.ResponseRecorder, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, withChiParam(httptest.NewRequest("GET", path, nil), "id", id))
+		return rec, rec.Body.String()
+	}
+	oembed := func(target string, extra string) (int, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		shareH.HandleOEmbed(rec, httptest.NewRequest("GET", "/api/oembed?url="+url.QueryEscape(target)+extra, nil))
+		return rec.Code, decodeJSON(t, rec)
+	}
+
+	// The clip page unfurls with escaped metadata, a player, and oEmbed
+	// discovery, and plays the clip itself.
+	rec, body := page(shareH.HandleClipPage, "/clips/c-share", "c-share")
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("clip page = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="Dovetails &amp; &#34;joints&#34;">`,
+		`<meta property="og:image" content="https://clips.example.com/storage/test-bucket/clips/c-share/thumbnail.jpg">`,
+		`<meta name="twitter:card" content="player">`,
+		`application/json+oembed" href="https://clips.example.com/api/oembed?format=json&amp;url=https%3A%2F%2Fclips.example.com%2Fclips%2Fc-share"`,
+		`<video src="/storage/test-bucket/clips/c-share/clip.mp4?signed"`,
+		`From <a href="https://example.com/v" rel="noopener nofollow">Shop &lt;Talk&gt;</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("clip page lacks %s", want)
+		}
+	}
+	if rec, _ := page(shareH.HandleClipPage, "/clips/c-pending", "c-pending"); rec.Code != 404 {
+		t.Errorf("page of a clip still processing = %d, want 404", rec.Code)
+	}
+
+	// oEmbed describes the clip as a video with a signed player iframe.
+	code, resp := oembed("https://clips.example.com/clips/c-share", "&maxwidth=180")
+	if code != 200 || resp["type"] != "video" || resp["title"] != `Dovetails & "joints"` || resp["author_name"] != "Shop <Talk>" {
+		t.Fatalf("oembed = %d %v", code, resp)
+	}
+	if resp["width"] != float64(180) || resp["height"] != float64(320) {
+		t.Errorf("player size = %vx%v, want 180x320 within maxwidth", resp["width"], resp["height"])
+	}
+	if resp["thumbnail_width"] != float64(480) || resp["thumbnail_height"] != float64(853) {
+		t.Errorf("thumbnail size = %vx%v, want 480x853", resp["thumbnail_width"], resp["thumbnail_height"])
+	}
+	iframe := resp["html"].(string)
+	start := strings.Index(iframe, `src="`) + len(`src="`)
+	embed, _ := url.Parse(html.UnescapeString(iframe[start : start+strings.Index(iframe[start:], `"`)]))
+	if embed.Path != "/embed/c-share" || embed.Query().Get("sig") == "" {
+		t.Fatalf("iframe src = %v", embed)
+	}
+	if code, resp := oembed("https://clips.example.com/clips/c-wide", ""); code != 200 || resp["width"] != float64(640) || resp["height"] != float64(360) || resp["thumbnail_url"] != nil {
+		t.Errorf("oembed of a wide clip without a thumbnail = %d %v", code, resp)
+	}
+	for _, bad := range []struct {
+		target, extra string
+		want          int
+	}{
+		{"https://elsewhere.example.com/clips/c-share", "", 404},
+		{"https://clips.example.com/clips/c-pending", "", 404},
+		{"https://clips.example.com/collections/priv", "", 404},
+		{"https://clips.example.com/clips/c-share", "&format=xml", 501},
+		{"not a url", "", 400},
+	} {
+		if code, _ := oembed(bad.target, bad.extra); code != bad.want {
+			t.Errorf("oembed %s%s = %d, want %d", bad.target, bad.extra, code, bad.want)
+		}
+	}
+	if code, resp := oembed("https://clips.example.com/collections/pub", ""); code != 200 || resp["type"] != "link" || resp["title"] != "Joinery" {
+		t.Errorf("oembed of a public collection = %d %v", code, resp)
+	}
+
+	// The embed player may be framed, but only at its signed URL, and
+	// revoking the clip's streams breaks it.
+	rec, body = page(shareH.HandleEmbed, embed.RequestURI(), "c-share")
+	if rec.Code != 200 || !strings.Contains(body, `<video src="/storage/test-bucket/clips/c-share/clip.mp4?signed"`) {
+		t.Fatalf("embed = %d", rec.Code)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors *") {
+		t.Errorf("embed CSP = %q, want framing allowed", csp)
+	}
+	if rec, _ := page(shareH.HandleEmbed, "/embed/c-share?sig=forged", "c-share"); rec.Code != 404 {
+		t.Errorf("embed with a forged signature = %d, want 404", rec.Code)
+	}
+	if rec, _ := page(shareH.HandleClipPage, "/clips/c-share", "c-share"); strings.Contains(rec.Header().Get("Content-Security-Policy"), "frame-ancestors *") {
+		t.Error("clip page may be framed")
+	}
+	h.db.Exec(`UPDATE clips SET stream_generation = stream_generation + 1 WHERE id = 'c-share'`)
+	if rec, _ := page(shareH.HandleEmbed, embed.RequestURI(), "c-share"); rec.Code != 404 {
+		t.Errorf("embed after revoking streams = %d, want 404", rec.Code)
+	}
+
+	// Collection pages are served for public collections only.
+	if rec, body := page(shareH.HandleCollectionPage, "/collections/pub", "pub"); rec.Code != 200 || !strings.Contains(body, `<a href="/clips/c-share">`) {
+		t.Errorf("public collection page = %d", rec.Code)
+	}
+	if rec, _ := page(shareH.HandleCollectionPage, "/collections/priv", "priv"); rec.Code != 404 {
+		t.Errorf("private collection page = %d, want 404", rec.Code)
+	}
+
+	// A taken-down clip is gone from its page and the sitemap.
+	if err := clips.Bury(context.Background(), h.db, "deleted", "", "c-wide"); err != nil {
+		t.Fatalf("bury: %v", err)
+	}
+	if rec, _ := page(shareH.HandleClipPage, "/clips/c-wide", "c-wide"); rec.Code != 410 {
+		t.Errorf("page of a deleted clip = %d, want 410", rec.Code)
+	}
+	rec, body = page(shareH.HandleSitemap, "/sitemap.xml", "")
+	var sitemap struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &sitemap); err != nil {
+		t.Fatalf("sitemap: %v\n%s", err, body)
+	}
+	var locs []string
+	for _, u := range sitemap.URLs {
+		locs = append(locs, u.Loc)
+	}
+	want := "https://clips.example.com/ https://clips.example.com/collections/pub https://clips.example.com/clips/c-share"
+	if got := strings.Join(locs, " "); got != want {
+		t.Errorf("sitemap = %s, want %s", got, want)
+	}
+
+	// Links never follow the Host a request claims, and the own-host check
+	// of oEmbed does not either.
+	req := withChiParam(httptest.NewRequest("GET", "/clips/c-share", nil), "id", "c-share")
+	req.Host = "evil.example.net"
+	req.Header.Set("X-Forwarded-Proto", "http")
+	rec = httptest.NewRecorder()
+	shareH.HandleClipPage(rec, req)
+	if body := rec.Body.String(); strings.Contains(body, "evil.example.net") || !strings.Contains(body, `<link rel="canonical" href="https://clips.example.com/clips/c-share">`) {
+		t.Errorf("clip page with a spoofed Host uses it for links:\n%s", body)
+	}
+	req = httptest.NewRequest("GET", "/api/oembed?url="+url.QueryEscape("https://evil.example.net/clips/c-share"), nil)
+	req.Host = "evil.example.net"
+	rec = httptest.NewRecorder()
+	shareH.HandleOEmbed(rec, req)
+	if rec.Code != 404 {
+		t.Errorf("oEmbed for the spoofed host: status = %d, want 404", rec.Code)
+	}
+	for base, ok := range map[string]bool{
+		"https://clips.example.com": true, "http://localhost:8080/": true,
+		"": false, "clips.example.com": false, "ftp://clips.example.com": false, "https://clips.example.com/?x=1": false,
+	} {
+		if share.ValidBaseURL(base) != ok {
+			t.Errorf("ValidBaseURL(%q) = %v, want %v", base, !ok, ok)
+		}
+	}
+}
+
+// --- LTR Model ---
+
+func TestLTRModelScore_SumsLeafValues(t *testing.T) {
+	model := &feed.LTRModel{
+		Trees: [][]feed.LTRTree{
+			{
+				{FeatureIndex: 0, Threshold: 0.5, LeftChild: 1, RightChild: 2},
+				{LeafValue: 1.25, IsLeaf: true},
+				{LeafValue: -0.75, IsLeaf: true},
+			},
+			{
+				{FeatureIndex: 1, Threshold: 10, LeftChild: 1, RightChild: 2},
+				{LeafValue: 0.5, IsLeaf: true},
+				{LeafValue: 0.2, IsLeaf: true},
+			},
+		},
+	}
+
+	score := model.Score([]float64{0.2, 20})
+	want := 1.25 + 0.2
+	if score != want {
+		t.Fatalf("score = %f, want %f", score, want)
+	}
+}
+
+func TestHandleFeed_UsesLTRRanking(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "l2r-user", "password123")
+
+	h.db.Exec(`UPDATE user_preferences SET exploration_rate = 0 WHERE user_id = (SELECT id FROM users WHERE username = 'l2r-user')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-l2r', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, transcript, file_size_bytes) VALUES ('l2r-a', 'src-l2r', 'Long High Score', 40.0, 'k1', 'ready', 0.9, '', 100)`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, transcript, file_size_bytes) VALUES ('l2r-b', 'src-l2r', 'Short Lower Score', 8.0, 'k2', 'ready', 0.4, '', 100)`)
+
+	h.feedH.SetLTRModel(&feed.LTRModel{
+		NumFeatures: 13,
+		Trees: [][]feed.LTRTree{
+			{
+				{FeatureIndex: 0, Threshold: 0.5, LeftChild: 1, RightChild: 2},
+				{LeafValue: 2.0, IsLeaf: true},
+				{LeafValue: -1.0, IsLeaf: true},
+			},
+		},
+	})
+
+	req := authRequest(t, h, "GET", "/api/feed", nil, token)
+	rec := httptest.NewRecorder()
+	h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+
+	resp := decodeJSON(t, rec)
+	clipsList := resp["clips"].([]interface{})
+	if len(clipsList) < 2 {
+		t.Fatalf("got %d clips, want at least 2", len(clipsList))
+	}
+	first := clipsList[0].(map[string]interface{})
+	if first["title"] != "Short Lower Score" {
+		t.Fatalf("first clip = %v, want Short Lower Score", first["title"])
+	}
+	if _, ok := first["_source_id"]; ok {
+		t.Fatal("internal L2R fields should not be exposed in feed response")
+	}
+}
+
+func TestAdminStatus_ReportsRankingHealth(t *testing.T) {
+	h := newTestHandlers(t)
+	h.adminH.RankingStatus = h.feedH.RankingStatus
+
+	modelPath := filepath.Join(t.TempDir(), "l2r_model.json")
+	if err := os.WriteFile(modelPath, []byte(`{"trees": [[{"is_leaf": true, "leaf_value": 1}], [{"is_leaf": true, "leaf_value": 2}]],
+		"num_features": 13, "ndcg_at_10": 0.71, "trained_at": "2026-01-02T03:04:05.123456+00:00"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.feedH.LTRModelPath = modelPath
+	h.feedH.SetLTRModel(h.feedH.LoadLTRModel())
+
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-cook', 'Cooking', 'cooking'), ('t-cooks', 'Cookings', 'cookings'), ('t-bake', 'Baking', 'baking')`)
+	h.db.Exec(`INSERT INTO topic_edges (source_id, target_id) VALUES ('t-cook', 't-bake')`)
+	h.feedH.RefreshTopicGraph()
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-rh', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('rh-a', 'src-rh', 'A', 10, 'k1', 'ready'), ('rh-b', 'src-rh', 'B', 10, 'k2', 'ready')`)
+	h.db.Exec(`INSERT INTO clip_embeddings (clip_id, text_embedding) VALUES ('rh-a', ?)`, feed.Float32ToBlob([]float32{1, 0}))
+
+	rec := httptest.NewRecorder()
+	h.adminH.HandleAdminStatus(rec, httptest.NewRequest("GET", "/api/admin/status", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	ranking := decodeJSON(t, rec)["ranking"].(map[string]interface{})
+
+	graph := ranking["topic_graph"].(map[string]interface{})
+	if graph["nodes"] != 3.0 || graph["edges"] != 1.0 || graph["canonical_merges"] != 1.0 {
+		t.Errorf("topic_graph = %v, want 3 nodes, 1 edge, 1 merge", graph)
+	}
+	if graph["refreshed_at"] == "" {
+		t.Error("topic_graph.refreshed_at is empty")
+	}
+
+	ltr := ranking["ltr_model"].(map[string]interface{})
+	if ltr["loaded"] != true || ltr["trees"] != 2.0 || ltr["trained_at"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("ltr_model = %v, want 2 trees trained 2026-01-02T03:04:05Z", ltr)
+	}
+	if v, _ := ltr["version"].(string); len(v) != 12 {
+		t.Errorf("ltr_model.version = %q, want a 12-character hash", v)
+	}
+
+	emb := ranking["embeddings"].(map[string]interface{})
+	if emb["ready_clips"] != 2.0 || emb["embedded"] != 1.0 || emb["coverage_pct"] != 50.0 {
+		t.Errorf("embeddings = %v, want 1 of 2 ready clips (50%%)", emb)
+	}
+	index := ranking["ann_index"].(map[string]interface{})
+	if index["latest_embedding_at"] == nil || index["missing"] != 1.0 {
+		t.Errorf("ann_index = %v, want a latest embedding and 1 missing", index)
+	}
+}
+
+func TestHandleDiscover_SectionsAndPaging(t *testing.T) {
+	h := newTestHandlers(t)
+	registerUser(t, h, "discoverer", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'discoverer'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('dsrc', 'http://x.com', 'youtube', 'Fresh Channel')`)
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("dc%d", i)
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, description, thumbnail_key, duration_seconds, storage_key, status, content_score) VALUES (?, 'dsrc', 'Disc', '', '', 30.0, 'k', 'ready', 0.5)`, id)
+		for j := 0; j <= i; j++ {
+			h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES (?, ?, ?, 'view', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`,
+				fmt.Sprintf("di%d-%d", i, j), userID, id)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest("PUT", "/api/admin/staff-picks/dc0", strings.NewReader(`{"note": "Editor favourite"}`)), "clipId", "dc0")
+	h.adminH.HandleSetStaffPick(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("set staff pick: status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleDiscover(rec, httptest.NewRequest("GET", "/api/discover?limit=2", nil))
+	if rec.Code != 200 {
+		t.Fatalf("discover: status = %d", rec.Code)
+	}
+	sections := decodeJSON(t, rec)["sections"].(map[string]interface{})
+	trending := sections["trending"].(map[string]interface{})
+	items := trending["items"].([]interface{})
+	if len(items) != 2 || items[0].(map[string]interface{})["id"] != "dc2" {
+		t.Fatalf("trending items = %v, want dc2 first and 2 items", items)
+	}
+	if trending["next_offset"].(float64) != 2 {
+		t.Errorf("trending next_offset = %v, want 2", trending["next_offset"])
+	}
+	picks := sections["staff_picks"].(map[string]interface{})["items"].([]interface{})
+	if len(picks) != 1 || picks[0].(map[string]interface{})["staff_note"] != "Editor favourite" {
+		t.Errorf("staff picks = %v", picks)
+	}
+	channels := sections["channels"].(map[string]interface{})["items"].([]interface{})
+	if len(channels) != 1 || channels[0].(map[string]interface{})["channel_name"] != "Fresh Channel" {
+		t.Errorf("channels = %v", channels)
+	}
+
+	rec = httptest.NewRecorder()
+	req = withChiParam(httptest.NewRequest("GET", "/api/discover/trending?limit=2&offset=2", nil), "section", "trending")
+	h.feedH.HandleDiscoverSection(rec, req)
+	page := decodeJSON(t, rec)
+	items = page["items"].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["id"] != "dc0" || page["next_offset"] != nil {
+		t.Errorf("second trending page = %v", page)
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleDiscoverSection(rec, withChiParam(httptest.NewRequest("GET", "/api/discover/bogus", nil), "section", "bogus"))
+	if rec.Code != 404 {
+		t.Errorf("unknown section: status = %d, want 404", rec.Code)
+	}
+}
+
+// --- Worker API ---
+
+func TestWorkerAuth_ValidSecret(t *testing.T) {
+	h := newTestHandlers(t)
+	called := false
+	handler := h.workerH.WorkerAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		httputil.WriteJSON(w, 200, map[string]string{"ok": "true"})
+	}))
+
+	req := httptest.NewRequest("POST", "/api/internal/jobs/claim", nil)
+	req.Header.Set("Authorization", "Bearer test-worker-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != 200 {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+	if !called {
+		t.Error("inner handler not called")
+	}
+}
+
+func TestWorkerAuth_InvalidSecret(t *testing.T) {
+	h := newTestHandlers(t)
+	handler := h.workerH.WorkerAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("should not be called")
+	}))
+
+	req := httptest.NewRequest("POST", "/api/internal/jobs/claim", nil)
+	req.Header.Set("Authorization", "Bearer wrong-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != 401 {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestHandleClaimJob_PlatformConcurrencyCaps(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "capuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'capuser'`).Scan(&userID)
+
+	for i, platform := range []string{"youtube", "youtube", "tiktok", "tiktok"} {
+		id := fmt.Sprintf("cap%d", i)
+		h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by) VALUES (?, ?, ?, ?)`,
+			id, "http://x.com/"+id, platform, userID)
+		h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload, created_at) VALUES (?, ?, 'download', ?, ?)`,
+			"job-"+id, id, fmt.Sprintf(`{"url":"http://x.com/%s","source_id":%q}`, id, id), fmt.Sprintf("2026-01-01T00:00:0%dZ", i))
+	}
+
+	rec := httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest("PUT", "/api/admin/platform-limits/youtube",
+		strings.NewReader(`{"max_concurrent": 1}`)), "platform", "youtube")
+	h.adminH.HandleSetPlatformLimit(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("set limit: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req = withChiParam(authRequest(t, h, "PUT", "/api/me/cookies/tiktok",
+		map[string]interface{}{"cookie_str": "sid=abc", "max_concurrent": 1}, token), "platform", "tiktok")
+	h.profileH.HandleSetCookie(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("set cookie: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	claim := func() (int, string) {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleClaimJob(rec, httptest.NewRequest("POST", "/api/internal/jobs/claim", nil))
+		if rec.Code != 200 {
+			return rec.Code, ""
+		}
+		return rec.Code, decodeJSON(t, rec)["id"].(string)
+	}
+
+	var claimed []string
+	for {
+		code, id := claim()
+		if code != 200 {
+			break
+		}
+		claimed = append(claimed, id)
+	}
+	if len(claimed) != 2 || claimed[0] != "job-cap0" || claimed[1] != "job-cap2" {
+		t.Fatalf("claimed = %v, want [job-cap0 job-cap2]", claimed)
+	}
+
+	h.db.Exec(`UPDATE jobs SET status = 'complete' WHERE id = 'job-cap0'`)
+	if code, id := claim(); code != 200 || id != "job-cap1" {
+		t.Fatalf("after youtube slot freed: code = %d id = %q, want job-cap1", code, id)
+	}
+
+	rec = httptest.NewRecorder()
+	h.adminH.HandleListPlatformLimits(rec, httptest.NewRequest("GET", "/api/admin/platform-limits", nil))
+	limits := decodeJSON(t, rec)["limits"].([]interface{})
+	found := false
+	for _, l := range limits {
+		m := l.(map[string]interface{})
+		if m["platform"] == "tiktok" {
+			found = true
+			if m["running"].(float64) != 1 || m["queued"].(float64) != 1 || m["max_concurrent"] != nil {
+				t.Errorf("tiktok limits = %v, want running 1, queued 1, no instance cap", m)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("tiktok missing from platform limits: %v", limits)
+	}
+}
+
+func TestJobDependencies_ClaimOrderCascadeAndPipeline(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "pipeuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'pipeuser'`).Scan(&userID)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by) VALUES ('psrc', 'http://x.com/p', 'direct', ?)`, userID)
+	h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload) VALUES ('dl', 'psrc', 'download', '{"url": "http://x.com/p", "source_id": "psrc"}')`)
+
+	create := func(jobType string, deps ...string) (int, string) {
+		b, _ := json.Marshal(map[string]interface{}{"source_id": "psrc", "job_type": jobType, "depends_on": deps, "priority": 9})
+		rec := httptest.NewRecorder()
+		h.workerH.HandleCreateJob(rec, httptest.NewRequest("POST", "/api/internal/jobs", bytes.NewReader(b)))
+		if rec.Code != 201 {
+			return rec.Code, ""
+		}
+		return rec.Code, decodeJSON(t, rec)["id"].(string)
+	}
+	claim := func() string {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleClaimJob(rec, httptest.NewRequest("POST", "/api/internal/jobs/claim", nil))
+		if rec.Code != 200 {
+			return ""
+		}
+		return decodeJSON(t, rec)["id"].(string)
+	}
+	update := func(id, status string) {
+		rec := httptest.NewRecorder()
+		req := withChiParam(httptest.NewRequest("PUT", "/api/internal/jobs/"+id,
+			strings.NewReader(`{"status": "`+status+`"}`)), "id", id)
+		h.workerH.HandleUpdateJob(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("update %s: status = %d", id, rec.Code)
+		}
+	}
+
+	_, transcode := create("transcode", "dl")
+	_, transcribe := create("transcribe", transcode)
+	_, embed := create("embed", transcribe)
+	if code, _ := create("embed", "missing-job"); code != 409 {
+		t.Errorf("unknown dependency: status = %d, want 409", code)
+	}
+
+	// Dependents outrank the download but must wait for it.
+	if got := claim(); got != "dl" {
+		t.Fatalf("first claim = %q, want dl", got)
+	}
+	if got := claim(); got != "" {
+		t.Fatalf("claimed %q while its dependency is still running", got)
+	}
+	update("dl", "complete")
+	if got := claim(); got != transcode {
+		t.Fatalf("claim after download = %q, want transcode job", got)
+	}
+	update(transcode, "failed")
+
+	var transcribeStatus, embedStatus string
+	h.db.QueryRow(`SELECT status FROM jobs WHERE id = ?`, transcribe).Scan(&transcribeStatus)
+	h.db.QueryRow(`SELECT status FROM jobs WHERE id = ?`, embed).Scan(&embedStatus)
+	if transcribeStatus != "failed" || embedStatus != "failed" {
+		t.Fatalf("downstream statuses = %s, %s; want failed, failed", transcribeStatus, embedStatus)
+	}
+
+	rec := httptest.NewRecorder()
+	h.jobsH.HandleRetryJob(rec, withChiParam(authRequest(t, h, "POST", "/api/jobs/"+transcode+"/retry", nil, token), "id", transcode))
+	if rec.Code != 200 {
+		t.Fatalf("retry: status = %d", rec.Code)
+	}
+	h.db.QueryRow(`SELECT status FROM jobs WHERE id = ?`, embed).Scan(&embedStatus)
+	if embedStatus != "queued" {
+		t.Errorf("embed status after retry = %s, want queued", embedStatus)
+	}
+
+	rec = httptest.NewRecorder()
+	h.jobsH.HandleGetJob(rec, withChiParam(authRequest(t, h, "GET", "/api/jobs/"+embed, nil, token), "id", embed))
+	if rec.Code != 200 {
+		t.Fatalf("get job: status = %d", rec.Code)
+	}
+	resp := decodeJSON(t, rec)
+	if deps := resp["depends_on"].([]interface{}); len(deps) != 1 || deps[0] != transcribe {
+		t.Errorf("depends_on = %v, want [%s]", deps, transcribe)
+	}
+	pipeline := resp["pipeline"].([]interface{})
+	var order []string
+	for _, n := range pipeline {
+		order = append(order, n.(map[string]interface{})["job_type"].(string))
+	}
+	if strings.Join(order, ",") != "download,transcode,transcribe,embed" {
+		t.Errorf("pipeline order = %v", order)
+	}
+}
+
+func TestIngestAnalytics_OutcomesByPlatformAndWorker(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('yt', 'https://youtube.com/watch?v=a', 'youtube')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('vm', 'https://vimeo.com/1', 'vimeo')`)
+	for _, id := range []string{"yt-1", "yt-2", "vm-1"} {
+		src := id[:2]
+		h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload) VALUES (?, ?, 'download', ?)`,
+			id, src, `{"url": "https://x.com/`+id+`", "source_id": "`+src+`"}`)
+	}
+	// Youtube downloads succeeded all morning.
+	earlier := time.Now().UTC().Add(-12 * time.Hour).Format("2006-01-02T15:04:05Z")
+	for i := 0; i < 8; i++ {
+		h.db.Exec(`INSERT INTO job_outcomes (job_id, job_type, platform, worker_id, status, duration_seconds, finished_at)
+			VALUES (?, 'download', 'youtube', 'w-old', 'complete', ?, ?)`, fmt.Sprintf("old-%d", i), 10+i, earlier)
+	}
+	for i := 0; i < 4; i++ {
+		h.db.Exec(`INSERT INTO job_outcomes (job_id, job_type, platform, worker_id, status, error_code)
+			VALUES (?, 'download', 'youtube', 'w-old', 'failed', 'http_403')`, fmt.Sprintf("recent-%d", i))
+	}
+
+	claim := func() string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/internal/jobs/claim", nil)
+		req.Header.Set("Authorization", "Bearer test-worker-secret")
+		req.Header.Set("X-Worker-ID", "worker-a")
+		h.workerH.WorkerAuthMiddleware(http.HandlerFunc(h.workerH.HandleClaimJob)).ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("claim: status = %d", rec.Code)
+		}
+		return decodeJSON(t, rec)["id"].(string)
+	}
+	update := func(id, body string) {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleUpdateJob(rec, withChiParam(httptest.NewRequest("PUT", "/api/internal/jobs/"+id,
+			strings.NewReader(body)), "id", id))
+		if rec.Code != 200 {
+			t.Fatalf("update %s: status = %d, body: %s", id, rec.Code, rec.Body.String())
+		}
+	}
+	for i := 0; i < 3; i++ {
+		switch id := claim(); id {
+		case "vm-1":
+			update(id, `{"status": "rejected", "error": "too long", "result": {"rejection": {"code": "too_long"}}}`)
+		case "yt-1":
+			update(id, `{"status": "failed", "error": "ERROR: [youtube] a: HTTP Error 403: Forbidden"}`)
+		default:
+			update(id, `{"status": "complete"}`)
+		}
+	}
+	var workerID string
+	h.db.QueryRow(`SELECT worker_id FROM jobs WHERE id = 'yt-1'`).Scan(&workerID)
+	if workerID != "worker-a" {
+		t.Errorf("claimed job worker = %q, want worker-a", workerID)
+	}
+
+	get := func(query string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleIngestAnalytics(rec, httptest.NewRequest("GET", "/api/admin/ingest/analytics"+query, nil))
+		if rec.Code != 200 {
+			return rec.Code, nil
+		}
+		return rec.Code, decodeJSON(t, rec)
+	}
+	if code, _ := get("?hours=0"); code != 400 {
+		t.Errorf("hours=0: status = %d, want 400", code)
+	}
+	if code, _ := get("?bucket=week"); code != 400 {
+		t.Errorf("bucket=week: status = %d, want 400", code)
+	}
+
+	_, resp := get("")
+	platforms := resp["platforms"].([]interface{})
+	if len(platforms) != 2 {
+		t.Fatalf("platforms = %v, want youtube and vimeo", platforms)
+	}
+	yt := platforms[0].(map[string]interface{})
+	if yt["platform"] != "youtube" || yt["degraded"] != true {
+		t.Fatalf("first platform = %v, want degraded youtube", yt)
+	}
+	if yt["complete"] != float64(9) || yt["failed"] != float64(5) {
+		t.Errorf("youtube complete %v, failed %v; want 9 and 5", yt["complete"], yt["failed"])
+	}
+	if rate := yt["recent_success_rate"].(float64); rate != 1.0/6 {
+		t.Errorf("youtube recent success rate = %v, want 1/6", rate)
+	}
+	if codes := yt["error_codes"].([]interface{}); len(codes) != 1 || codes[0].(map[string]interface{})["code"] != "http_403" ||
+		codes[0].(map[string]interface{})["count"] != float64(5) {
+		t.Errorf("youtube error codes = %v, want 5 x http_403", codes)
+	}
+	if median := yt["median_duration_seconds"]; median == nil || median.(float64) < 13 || median.(float64) > 14 {
+		t.Errorf("youtube median duration = %v, want about 13.5", median)
+	}
+	if series := yt["series"].([]interface{}); len(series) != 2 {
+		t.Errorf("youtube series = %v, want two hourly buckets", series)
+	}
+	vm := platforms[1].(map[string]interface{})
+	if vm["rejected"] != float64(1) || vm["success_rate"] != nil || vm["degraded"] != false {
+		t.Errorf("vimeo = %v, want one rejection and no success rate", vm)
+	}
+	if codes := vm["rejection_codes"].([]interface{}); len(codes) != 1 || codes[0].(map[string]interface{})["code"] != "too_long" {
+		t.Errorf("vimeo rejection codes = %v, want too_long", codes)
+	}
+
+	workers := map[string]map[string]interface{}{}
+	for _, w := range resp["workers"].([]interface{}) {
+		m := w.(map[string]interface{})
+		workers[m["worker_id"].(string)] = m
+	}
+	if a := workers["worker-a"]; a == nil || a["total"] != float64(3) {
+		t.Errorf("worker-a = %v, want 3 jobs", a)
+	}
+
+	_, resp = get("?platform=vimeo&bucket=day")
+	if platforms := resp["platforms"].([]interface{}); len(platforms) != 1 || resp["bucket"] != "day" {
+		t.Errorf("platform filter = %v (bucket %v), want vimeo alone by day", platforms, resp["bucket"])
+	}
+}
+
+func TestWorkerRuns_RecoverClaimsOfRestartedAndUnknownRuns(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src', 'https://x.com/a', 'direct')`)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload, created_at) VALUES (?, 'src', 'download', ?, ?)`,
+			id, `{"url": "https://x.com/`+id+`", "source_id": "src"}`, fmt.Sprintf("2026-01-01T00:00:0%dZ", i))
+	}
+	call := func(handler http.HandlerFunc, method, url, workerID, runID string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer test-worker-secret")
+		req.Header.Set("X-Worker-ID", workerID)
+		if runID != "" {
+			req.Header.Set("X-Worker-Run-ID", runID)
+		}
+		h.workerH.WorkerAuthMiddleware(handler).ServeHTTP(rec, req)
+		return rec
+	}
+	claim := func(workerID, runID string) string {
+		t.Helper()
+		rec := call(h.workerH.HandleClaimJob, "POST", "/api/internal/jobs/claim", workerID, runID)
+		if rec.Code != 200 {
+			t.Fatalf("claim: status = %d", rec.Code)
+		}
+		return decodeJSON(t, rec)["id"].(string)
+	}
+	status := func(id string) (status, errMsg string) {
+		h.db.QueryRow(`SELECT status, COALESCE(error, '') FROM jobs WHERE id = ?`, id).Scan(&status, &errMsg)
+		return
+	}
+
+	a1, a2 := claim("worker-a", "a-run-1"), claim("worker-a", "a-run-1")
+	b := claim("worker-b", "b-run-1")
+	legacy := claim("worker-c", "")
+	h.db.Exec(`UPDATE jobs SET attempts = max_attempts WHERE id = ?`, a2)
+
+	// worker-a comes back as a new run: the jobs its old run held are
+	// recovered at once, and nobody else's.
+	if rec := call(h.workerH.HandleRegisterRun, "POST", "/api/internal/workers/register", "worker-a", ""); rec.Code != 400 {
+		t.Errorf("register without a run ID: status = %d, want 400", rec.Code)
+	}
+	rec := call(h.workerH.HandleRegisterRun, "POST", "/api/internal/workers/register", "worker-a", "a-run-2")
+	if resp := decodeJSON(t, rec); rec.Code != 200 || resp["requeued"] != float64(1) || resp["failed"] != float64(1) {
+		t.Fatalf("register: status = %d, body = %v", rec.Code, resp)
+	}
+	if s, msg := status(a1); s != "queued" || !strings.Contains(msg, "worker worker-a restarted") {
+		t.Errorf("job of the old run: status %q error %q, want requeued", s, msg)
+	}
+	if s, _ := status(a2); s != "failed" {
+		t.Errorf("job of the old run out of attempts: status %q, want failed", s)
+	}
+	if s, _ := status(b); s != "running" {
+		t.Errorf("another worker's job: status %q, want running", s)
+	}
+
+	snapshot := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.workerH.HandleQueueSnapshot(rec, httptest.NewRequest("GET", "/api/admin/jobs/snapshot", nil))
+		return decodeJSON(t, rec)
+	}
+	snap := snapshot()
+	runs := snap["runs"].([]interface{})
+	if snap["running"] != float64(2) || len(runs) != 2 {
+		t.Fatalf("snapshot = %v, want 2 running jobs under 2 runs", snap)
+	}
+	for _, r := range runs {
+		run := r.(map[string]interface{})
+		if run["run_id"] == "b-run-1" && (run["worker_id"] != "worker-b" || run["last_seen_at"] == nil) {
+			t.Errorf("run b-run-1 = %v, want worker-b, last seen", run)
+		}
+		if run["run_id"] == "" && (run["worker_id"] != "worker-c" || run["last_seen_at"] != nil) {
+			t.Errorf("jobs without a run = %v, want worker-c, never seen", run)
+		}
+	}
+
+	// After an API restart, runs that do not check in lose their claims;
+	// runs that heartbeat keep them, and jobs claimed without a run ID are
+	// left to the stale watchdog.
+	started := time.Now().Add(-time.Minute)
+	h.db.Exec(`UPDATE worker_runs SET last_seen_at = '2026-01-01T00:00:00Z'`)
+	live := claim("worker-d", "d-run-1")
+	h.db.Exec(`UPDATE worker_runs SET last_seen_at = '2026-01-01T00:00:00Z' WHERE run_id = 'd-run-1'`)
+	rec = httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest("POST", "/api/internal/jobs/"+live+"/heartbeat", nil), "id", live)
+	req.Header.Set("Authorization", "Bearer test-worker-secret")
+	req.Header.Set("X-Worker-Run-ID", "d-run-1")
+	h.workerH.WorkerAuthMiddleware(http.HandlerFunc(h.workerH.HandleHeartbeat)).ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("heartbeat: status = %d", rec.Code)
+	}
+	requeued, failed, err := h.workerH.RecoverUnknownRuns(context.Background(), started)
+	if err != nil || requeued != 1 || failed != 0 {
+		t.Fatalf("RecoverUnknownRuns = %d, %d, %v; want 1 requeued", requeued, failed, err)
+	}
+	if s, msg := status(b); s != "queued" || !strings.Contains(msg, "API restart") {
+		t.Errorf("job of a run gone quiet: status %q error %q, want requeued", s, msg)
+	}
+	for _, id := range []string{live, legacy} {
+		if s, _ := status(id); s != "running" {
+			t.Errorf("job %s: status %q, want running", id, s)
+		}
+	}
+}
+
+// --- Scout ---
+
+func TestScoutSourceCRUD(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "scoutuser", "password123")
+
+	// Create source
+	body := map[string]interface{}{"source_type": "channel", "platform": "youtube", "identifier": "@test"}
+	req := authRequest(t, h, "POST", "/api/scout/sources", body, token)
+	rec := httptest.NewRecorder()
+	h.scoutH.HandleCreateScoutSource(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("create: status = %d, want 201; body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	sourceID := resp["id"].(string)
+
+	// List sources
+	req = authRequest(t, h, "GET", "/api/scout/sources", nil, token)
+	rec = httptest.NewRecorder()
+	h.scoutH.HandleListScoutSources(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("list: status = %d, want 200", rec.Code)
+	}
+
+	// Delete source
+	req = authRequest(t, h, "DELETE", "/api/scout/sources/"+sourceID, nil, token)
+	req = withChiParam(req, "id", sourceID)
+	rec = httptest.NewRecorder()
+	h.scoutH.HandleDeleteScoutSource(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("delete: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestScoutCandidates_ExposeDuplicateLink(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "scoutdup", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'scoutdup'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('clip1', 'src1', 'Funny cats', 30.0, 'k', 'ready')`)
+	h.db.Exec(`INSERT INTO scout_sources (id, user_id, source_type, platform, identifier) VALUES ('ss1', ?, 'channel', 'youtube', '@cats')`, userID)
+	h.db.Exec(`INSERT INTO scout_candidates (id, scout_source_id, url, platform, external_id, title, duplicate_clip_id, duplicate_similarity)
+		VALUES ('dup', 'ss1', 'https://yt.test/a', 'youtube', 'a', 'Funny cats again', 'clip1', 0.93)`)
+	h.db.Exec(`INSERT INTO scout_candidates (id, scout_source_id, url, platform, external_id, title, duplicate_similarity)
+		VALUES ('new', 'ss1', 'https://yt.test/b', 'youtube', 'b', 'Deep sea fish', 0.2)`)
+
+	req := authRequest(t, h, "GET", "/api/scout/candidates", nil, token)
+	rec := httptest.NewRecorder()
+	h.scoutH.HandleListScoutCandidates(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("list: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	byID := make(map[string]map[string]interface{})
+	for _, c := range decodeJSON(t, rec)["candidates"].([]interface{}) {
+		m := c.(map[string]interface{})
+		byID[m["id"].(string)] = m
+	}
+
+	dup, ok := byID["dup"]["duplicate_of"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("dup candidate has no duplicate_of: %v", byID["dup"])
+	}
+	if dup["clip_id"] != "clip1" || dup["title"] != "Funny cats" || dup["similarity"] != 0.93 {
+		t.Errorf("duplicate_of = %v, want clip1 / Funny cats / 0.93", dup)
+	}
+	if byID["new"]["duplicate_of"] != nil || byID["new"]["duplicate_similarity"] != 0.2 {
+		t.Errorf("new candidate = %v, want no duplicate_of and similarity 0.2", byID["new"])
+	}
+
+	// Deleting the clip unlinks the candidate.
+	h.db.Exec(`DELETE FROM clips WHERE id = 'clip1'`)
+	var linked *string
+	h.db.QueryRow(`SELECT duplicate_clip_id FROM scout_candidates WHERE id = 'dup'`).Scan(&linked)
+	if linked != nil {
+		t.Errorf("duplicate_clip_id after clip delete = %q, want NULL", *linked)
+	}
+}
+
+func TestScoutCandidates_ExpiredCountsAndResurface(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "scoutexp", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'scoutexp'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO scout_sources (id, user_id, source_type, platform, identifier) VALUES ('ss1', ?, 'channel', 'youtube', '@old')`, userID)
+	for _, id := range []string{"a", "b"} {
+		h.db.Exec(`INSERT INTO scout_candidates (id, scout_source_id, url, platform, external_id, status, expired_at)
+			VALUES (?, 'ss1', ?, 'youtube', ?, 'expired', '2026-01-01T00:00:00Z')`, id, "https://yt.test/"+id, id)
+	}
+	h.db.Exec(`INSERT INTO scout_candidates (id, scout_source_id, url, platform, external_id) VALUES ('c', 'ss1', 'https://yt.test/c', 'youtube', 'c')`)
+
+	rec := httptest.NewRecorder()
+	h.scoutH.HandleListScoutSources(rec, authRequest(t, h, "GET", "/api/scout/sources", nil, token))
+	counts := decodeJSON(t, rec)["sources"].([]interface{})[0].(map[string]interface{})["candidates"].(map[string]interface{})
+	if counts["expired"] != 2.0 || counts["pending"] != 1.0 {
+		t.Errorf("candidate counts = %v, want 2 expired and 1 pending", counts)
+	}
+
+	updatePrefs := func(prefs map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences", prefs, token))
+		if rec.Code != 200 {
+			t.Fatalf("update preferences: status = %d, body: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+	expired := func() int {
+		var n int
+		h.db.QueryRow(`SELECT COUNT(*) FROM scout_candidates WHERE status = 'expired'`).Scan(&n)
+		return n
+	}
+
+	// Without the option, raising the threshold leaves them expired.
+	updatePrefs(map[string]interface{}{"scout_threshold": 7.0})
+	if n := expired(); n != 2 {
+		t.Fatalf("expired without option = %d, want 2", n)
+	}
+	// With it, lowering the threshold does nothing and raising it resurfaces.
+	updatePrefs(map[string]interface{}{"scout_resurface_expired": true, "scout_threshold": 5.0})
+	if n := expired(); n != 2 {
+		t.Fatalf("expired after lowering threshold = %d, want 2", n)
+	}
+	resp := updatePrefs(map[string]interface{}{"scout_threshold": 8.0})
+	if resp["resurfaced_candidates"] != 2.0 || expired() != 0 {
+		t.Errorf("resurface: response %v, %d still expired; want 2 resurfaced", resp, expired())
+	}
+	var resurfacedAt *string
+	h.db.QueryRow(`SELECT resurfaced_at FROM scout_candidates WHERE id = 'a'`).Scan(&resurfacedAt)
+	if resurfacedAt == nil {
+		t.Error("resurfaced candidate has no resurfaced_at")
+	}
+
+	// Expired candidates can still be approved by hand.
+	h.db.Exec(`UPDATE scout_candidates SET status = 'expired' WHERE id = 'b'`)
+	rec = httptest.NewRecorder()
+	h.scoutH.HandleApproveCandidate(rec, withChiParam(authRequest(t, h, "POST", "/api/scout/candidates/b/approve", nil, token), "id", "b"))
+	if rec.Code != 200 {
+		t.Errorf("approve expired: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleGetProfile(rec, authRequest(t, h, "GET", "/api/me", nil, token))
+	if prefs := decodeJSON(t, rec)["preferences"].(map[string]interface{}); prefs["scout_resurface_expired"] != true {
+		t.Errorf("scout_resurface_expired = %v, want true", prefs["scout_resurface_expired"])
+	}
+}
+
+func TestScoutSourceHealth_FailuresPauseAndReactivate(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "scouthealth", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'scouthealth'`).Scan(&userID)
+	h.db.Exec(`INSERT INTO scout_sources (id, user_id, source_type, platform, identifier, check_interval_hours)
+		VALUES ('ss1', ?, 'channel', 'youtube', '@gone', 24)`, userID)
+
+	report := func(body map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := withChiParam(httptest.NewRequest("POST", "/api/internal/scout/sources/ss1/check", bytes.NewReader(raw)), "id", "ss1")
+		rec := httptest.NewRecorder()
+		h.scoutH.HandleReportCheck(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("report: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+	health := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.scoutH.HandleListScoutSources(rec, authRequest(t, h, "GET", "/api/scout/sources", nil, token))
+		src := decodeJSON(t, rec)["sources"].([]interface{})[0].(map[string]interface{})
+		return src["health"].(map[string]interface{})
+	}
+
+	if got := health()["status"]; got != "unchecked" {
+		t.Errorf("new source status = %v, want unchecked", got)
+	}
+
+	first := report(map[string]interface{}{"ok": false, "error": "ERROR: channel does not exist"})
+	second := report(map[string]interface{}{"ok": false, "error": "ERROR: channel does not exist"})
+	if first["next_check_at"].(string) >= second["next_check_at"].(string) {
+		t.Errorf("retry not backed off: %v then %v", first["next_check_at"], second["next_check_at"])
+	}
+	hl := health()
+	if hl["status"] != "failing" || hl["consecutive_failures"] != float64(2) || hl["last_error"] != "ERROR: channel does not exist" {
+		t.Errorf("health after 2 failures = %v", hl)
+	}
+
+	var last map[string]interface{}
+	for i := 2; i < scout.MaxConsecutiveFailures; i++ {
+		last = report(map[string]interface{}{"ok": false, "error": "ERROR: channel does not exist"})
+	}
+	if last["paused"] != true {
+		t.Fatalf("report after %d failures = %v, want paused", scout.MaxConsecutiveFailures, last)
+	}
+	var isActive int
+	h.db.QueryRow(`SELECT is_active FROM scout_sources WHERE id = 'ss1'`).Scan(&isActive)
+	if isActive != 0 {
+		t.Errorf("is_active = %d, want 0 once paused", isActive)
+	}
+	if hl := health(); hl["status"] != "paused" || hl["paused_reason"] == nil {
+		t.Errorf("paused health = %v", hl)
+	}
+
+	// Reactivating clears the failure history.
+	req := withChiParam(authRequest(t, h, "PATCH", "/api/scout/sources/ss1", map[string]interface{}{"is_active": true}, token), "id", "ss1")
+	rec := httptest.NewRecorder()
+	h.scoutH.HandleUpdateScoutSource(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("reactivate: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if hl := health(); hl["consecutive_failures"] != float64(0) || hl["paused_reason"] != nil || hl["next_check_at"] != nil {
+		t.Errorf("health after reactivation = %v", hl)
+	}
+
+	report(map[string]interface{}{"ok": true})
+	if hl := health(); hl["status"] != "ok" || hl["last_error"] != nil || hl["last_success_at"] == nil {
+		t.Errorf("health after success = %v", hl)
+	}
+}
+
+// --- Slugify / Truncate ---
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Machine Learning", "machine-learning"},
+		{"  AI & ML  ", "ai-ml"},
+		{"hello", "hello"},
+	}
+	for _, tc := range tests {
+		got := worker.Slugify(tc.input)
+		if got != tc.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tc.input, got, tc.want)
+		}
 	}
 }
 
@@ -951,8 +5245,2427 @@ func TestTruncate(t *testing.T) {
 	if got != "hello" {
 		t.Errorf("Truncate = %q, want %q", got, "hello")
 	}
-	got = worker.Truncate("hi", 10)
-	if got != "hi" {
-		t.Errorf("Truncate = %q, want %q", got, "hi")
+	got = worker.Truncate("hi", 10)
+	if got != "hi" {
+		t.Errorf("Truncate = %q, want %q", got, "hi")
+	}
+}
+
+// --- Federation ---
+
+func TestFederationPeerAuth(t *testing.T) {
+	h := newTestHandlers(t)
+
+	req := httptest.NewRequest("POST", "/api/admin/federation/peers", bytes.NewReader([]byte(`{"name":"remote-a"}`)))
+	rec := httptest.NewRecorder()
+	h.federationH.HandleCreatePeer(rec, req)
+	if rec.Code != 201 {
+		t.Fatalf("create peer: expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	peer := decodeJSON(t, rec)
+	peerID := peer["id"].(string)
+	secret := peer["secret"].(string)
+
+	reached := false
+	protected := h.federationH.PeerAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		h.federationH.HandlePeerCatalog(w, r)
+	}))
+
+	req = httptest.NewRequest("GET", "/api/federation/v1/catalog", nil)
+	federation.SignRequest(req, peerID, "wrong-secret")
+	rec = httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	if rec.Code != 401 || reached {
+		t.Fatalf("bad signature: expected 401, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/federation/v1/catalog", nil)
+	federation.SignRequest(req, peerID, secret)
+	rec = httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	if rec.Code != 200 || !reached {
+		t.Fatalf("valid signature: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	if _, ok := resp["collections"]; !ok {
+		t.Fatal("catalog missing collections")
+	}
+}
+
+func TestFederationPeerClips_PrivateCollectionHidden(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "fedowner", "password123")
+	uid := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+
+	h.db.Exec(`INSERT INTO collections (id, user_id, title, is_public) VALUES ('priv', ?, 'Private', 0)`, uid)
+
+	req := httptest.NewRequest("GET", "/api/federation/v1/clips?collection=priv", nil)
+	rec := httptest.NewRecorder()
+	h.federationH.HandlePeerClips(rec, req)
+	if rec.Code != 404 {
+		t.Fatalf("expected 404 for private collection, got %d", rec.Code)
+	}
+}
+
+func TestFederationPeerClips_PagesPastClipsFromTheSameSecond(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "fedpager", "password123")
+	uid := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+	h.db.Exec(`INSERT INTO collections (id, user_id, title, is_public) VALUES ('pub', ?, 'Public', 1)`, uid)
+	for _, id := range []string{"fa", "fb", "fc"} {
+		h.db.Exec(`INSERT INTO clips (id, title, duration_seconds, storage_key, status, created_at) VALUES (?, 'Same second', 30.0, 'k', 'ready', '2026-01-01T00:00:00Z')`, id)
+		h.db.Exec(`INSERT INTO collection_clips (collection_id, clip_id) VALUES ('pub', ?)`, id)
+	}
+
+	rec := httptest.NewRecorder()
+	h.federationH.HandlePeerClips(rec, httptest.NewRequest("GET", "/api/federation/v1/clips?collection=pub&since=2026-01-01T00:00:00Z&since_id=fa", nil))
+	var ids []string
+	for _, c := range decodeJSON(t, rec)["clips"].([]interface{}) {
+		ids = append(ids, c.(map[string]interface{})["id"].(string))
+	}
+	if strings.Join(ids, ",") != "fb,fc" {
+		t.Errorf("clips after fa = %v, want fb and fc", ids)
+	}
+}
+
+// --- Real-time score deltas ---
+
+func TestHandleInteraction_NudgesContentScore(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "nudger", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-nudge', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES ('c-nudge', 'src-nudge', 'N', 30.0, 'key', 'ready', 0.99)`)
+
+	score := func() float64 {
+		var s float64
+		h.db.QueryRow(`SELECT content_score FROM clips WHERE id = 'c-nudge'`).Scan(&s)
+		return s
+	}
+	like := func() {
+		req := authRequest(t, h, "POST", "/api/clips/c-nudge/interact", map[string]string{"action": "like"}, token)
+		h.clipsH.HandleInteraction(httptest.NewRecorder(), withChiParam(req, "id", "c-nudge"))
+	}
+
+	like()
+	if got := score(); got != 1.0 {
+		t.Fatalf("score after like = %v, want clamped to 1.0", got)
+	}
+
+	h.db.Exec(`UPDATE clips SET content_score = 0.5 WHERE id = 'c-nudge'`)
+	like()
+	if got := score(); got != 0.5 {
+		t.Fatalf("repeat like moved score to %v, want unchanged 0.5", got)
+	}
+}
+
+func TestStarRatings_AggregateFilterAndNudge(t *testing.T) {
+	h := newTestHandlers(t)
+	alice := registerUser(t, h, "rater1", "password123")
+	bob := registerUser(t, h, "rater2", "password123")
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-rate', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, description, duration_seconds, storage_key, thumbnail_key, status, content_score) VALUES
+		('c-rated', 'src-rate', 'Rated', '', 30.0, 'k1', '', 'ready', 0.5),
+		('c-unrated', 'src-rate', 'Unrated', '', 30.0, 'k2', '', 'ready', 0.9)`)
+
+	rate := func(token string, stars interface{}) (int, map[string]interface{}) {
+		t.Helper()
+		req := authRequest(t, h, "POST", "/api/clips/c-rated/interact", map[string]interface{}{"action": "rate", "rating": stars}, token)
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleInteraction(rec, withChiParam(req, "id", "c-rated"))
+		return rec.Code, decodeJSON(t, rec)
+	}
+	for _, stars := range []interface{}{0, 6, 4.5} {
+		if code, _ := rate(alice, stars); code != 400 {
+			t.Errorf("rating %v = %d, want 400", stars, code)
+		}
+	}
+
+	score := func() float64 {
+		var s float64
+		h.db.QueryRow(`SELECT content_score FROM clips WHERE id = 'c-rated'`).Scan(&s)
+		return s
+	}
+	// Five stars nudge the score like a like, two like a dislike.
+	if code, resp := rate(alice, 5); code != 200 || resp["rating"].(map[string]interface{})["count"] != 1.0 {
+		t.Fatalf("first rating = %d %v", code, resp)
+	}
+	if got := score(); math.Abs(got-0.52) > 1e-9 {
+		t.Errorf("score after five stars = %v, want 0.52", got)
+	}
+	rate(bob, 2)
+	if got := score(); math.Abs(got-0.50) > 1e-9 {
+		t.Errorf("score after two stars = %v, want 0.50", got)
+	}
+	// Rating again replaces the earlier rating but is logged as well.
+	_, resp := rate(alice, 4)
+	if r := resp["rating"].(map[string]interface{}); r["average"] != 3.0 || r["count"] != 2.0 {
+		t.Errorf("aggregate after re-rating = %v, want average 3 of 2", r)
+	}
+	var logged int
+	h.db.QueryRow(`SELECT COUNT(*) FROM interactions WHERE clip_id = 'c-rated' AND action = 'rate' AND rating IS NOT NULL`).Scan(&logged)
+	if logged != 3 {
+		t.Errorf("logged %d rate interactions, want 3", logged)
+	}
+
+	getClip := func(token string) map[string]interface{} {
+		req := authRequest(t, h, "GET", "/api/clips/c-rated", nil, token)
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleGetClip(rec, withChiParam(req, "id", "c-rated"))
+		return decodeJSON(t, rec)
+	}
+	if c := getClip(bob); c["my_rating"] != 2.0 || c["rating"].(map[string]interface{})["average"] != 3.0 {
+		t.Errorf("clip for a rater = %v", c)
+	}
+	if c := getClip(""); c["my_rating"] != nil {
+		t.Errorf("anonymous my_rating = %v, want null", c["my_rating"])
+	}
+
+	// min_rating keeps rated clips at or above it and never unrated ones.
+	for minRating, want := range map[float64]int{3: 1, 3.5: 0} {
+		clips, err := h.feedH.ApplyFilterToFeed(context.Background(), &feed.FilterQuery{MinRating: minRating}, "", false)
+		if err != nil {
+			t.Fatalf("apply filter: %v", err)
+		}
+		if len(clips) != want || (want == 1 && clips[0]["id"] != "c-rated") {
+			t.Errorf("min_rating %v matched %v, want %d clip(s)", minRating, clips, want)
+		}
+	}
+}
+
+// --- Interaction integrity ---
+
+func TestInteractionIntegrity_DedupCapsAndFlags(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "steady", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'steady'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-gam', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES ('c-gam', 'src-gam', 'G', 30.0, 'key', 'ready', 0.5)`)
+
+	interact := func(tok, action string) string {
+		req := authRequest(t, h, "POST", "/api/clips/c-gam/interact", map[string]interface{}{"action": action, "watch_percentage": 0.9}, tok)
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleInteraction(rec, withChiParam(req, "id", "c-gam"))
+		if rec.Code != 200 {
+			t.Fatalf("interact %s: %d %s", action, rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)["status"].(string)
+	}
+	countViews := func(uid string) int {
+		var n int
+		h.db.QueryRow(`SELECT COUNT(*) FROM interactions WHERE user_id = ? AND clip_id = 'c-gam' AND action = 'view'`, uid).Scan(&n)
+		return n
+	}
+
+	// A replayed view inside the dedup window is dropped; once the window
+	// has passed the next view is recorded again.
+	if got := interact(token, "view"); got != "recorded" {
+		t.Fatalf("first view status = %q, want recorded", got)
+	}
+	if got := interact(token, "view"); got != "deduplicated" {
+		t.Fatalf("replayed view status = %q, want deduplicated", got)
+	}
+	if n := countViews(userID); n != 1 {
+		t.Fatalf("stored views = %d, want 1", n)
+	}
+	h.db.Exec(`UPDATE interactions SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-1 minutes') WHERE user_id = ?`, userID)
+	if got := interact(token, "view"); got != "recorded" {
+		t.Fatalf("view after window status = %q, want recorded", got)
+	}
+
+	// A burst of interactions flags the user, and a flagged user no longer
+	// nudges scores in real time.
+	botToken := registerUser(t, h, "clicker", "password123")
+	var botID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'clicker'`).Scan(&botID)
+	for i := 0; i < 30; i++ {
+		h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, watch_percentage, created_at) VALUES (?, ?, 'c-gam', 'view', 0.01, strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-90 seconds'))`,
+			fmt.Sprintf("i-bot-%d", i), botID)
+	}
+	for i := 0; i < 30; i++ {
+		h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES (?, ?, 'c-gam', 'share', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`,
+			fmt.Sprintf("i-bot-share-%d", i), botID)
+	}
+	h.db.Exec(`UPDATE clips SET content_score = 0.5 WHERE id = 'c-gam'`)
+	interact(botToken, "like")
+	var score float64
+	h.db.QueryRow(`SELECT content_score FROM clips WHERE id = 'c-gam'`).Scan(&score)
+	if score != 0.5 {
+		t.Errorf("flagged user's like moved score to %v, want unchanged 0.5", score)
+	}
+
+	rec := httptest.NewRecorder()
+	h.adminH.HandleListInteractionFlags(rec, httptest.NewRequest("GET", "/api/admin/interaction-flags", nil))
+	if rec.Code != 200 {
+		t.Fatalf("list flags: %d %s", rec.Code, rec.Body.String())
+	}
+	flags := decodeJSON(t, rec)["flags"].([]interface{})
+	reasons := map[string]string{}
+	for _, f := range flags {
+		flag := f.(map[string]interface{})
+		if flag["user_id"] != botID {
+			t.Errorf("unexpected flag for user %v", flag["user_id"])
+		}
+		reasons[flag["reason"].(string)] = flag["id"].(string)
+	}
+	if reasons["burst_rate"] == "" || reasons["low_watch_views"] == "" {
+		t.Fatalf("flag reasons = %v, want burst_rate and low_watch_views", reasons)
+	}
+
+	// Each user counts once per action in the batch recompute, so the bot's
+	// 30 views plus one real viewer stay under the five-viewer minimum.
+	scoreRec := httptest.NewRecorder()
+	h.workerH.HandleScoreUpdate(scoreRec, httptest.NewRequest("POST", "/api/internal/scores/update", nil))
+	if updated := decodeJSON(t, scoreRec)["updated"].(float64); updated != 0 {
+		t.Errorf("score update touched %v clips, want 0 with only two distinct viewers", updated)
+	}
+
+	// Confirming a flag moves it out of the open queue.
+	for _, status := range []string{"bogus", "confirmed"} {
+		req := withChiParam(httptest.NewRequest("PUT", "/api/admin/interaction-flags/x", strings.NewReader(`{"status":"`+status+`"}`)), "id", reasons["burst_rate"])
+		rec = httptest.NewRecorder()
+		h.adminH.HandleResolveInteractionFlag(rec, req)
+		if want := map[string]int{"bogus": 400, "confirmed": 200}[status]; rec.Code != want {
+			t.Fatalf("resolve %s: status = %d, want %d", status, rec.Code, want)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.adminH.HandleListInteractionFlags(rec, httptest.NewRequest("GET", "/api/admin/interaction-flags?status=confirmed", nil))
+	if confirmed := decodeJSON(t, rec)["flags"].([]interface{}); len(confirmed) != 1 || confirmed[0].(map[string]interface{})["resolved_at"] == nil {
+		t.Errorf("confirmed flags = %v, want the resolved burst_rate flag", confirmed)
+	}
+}
+
+// --- Bandit exploration ---
+
+func TestBanditArms_UpdatedByInteractions(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "bandit", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-bandit', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('c-bandit', 'src-bandit', 'B', 30.0, 'key', 'ready')`)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-bandit', 'Cooking', 'cooking')`)
+	h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES ('c-bandit', 't-bandit')`)
+
+	for _, action := range []string{"like", "save", "skip"} {
+		req := authRequest(t, h, "POST", "/api/clips/c-bandit/interact", map[string]string{"action": action}, token)
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleInteraction(rec, withChiParam(req, "id", "c-bandit"))
+		if rec.Code != 200 {
+			t.Fatalf("interact %s: %d", action, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleFeedExplain(rec, authRequest(t, h, "GET", "/api/feed/explain", nil, token))
+	if rec.Code != 200 {
+		t.Fatalf("explain: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	exploration := decodeJSON(t, rec)["exploration"].(map[string]interface{})
+	arms := exploration["arms"].([]interface{})
+	if len(arms) != 1 {
+		t.Fatalf("expected 1 arm, got %d", len(arms))
+	}
+	arm := arms[0].(map[string]interface{})
+	if arm["alpha"] != 3.0 || arm["beta"] != 2.0 {
+		t.Errorf("arm posterior = Beta(%v, %v), want Beta(3, 2)", arm["alpha"], arm["beta"])
+	}
+}
+
+func TestWhyClip_ExplainsFromRankingSignals(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "whyuser", "password123")
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+
+	for _, src := range [][2]string{{"src-lab", "Coffee Lab"}, {"src-hub", "Espresso Hub"}} {
+		h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES (?, 'http://x.com', 'direct', ?)`, src[0], src[1])
+	}
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-espresso', 'espresso', 'espresso')`)
+	for _, c := range [][2]string{{"c-lab1", "src-lab"}, {"c-lab2", "src-lab"}, {"c-hub", "src-hub"}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES (?, ?, 'Shot', 30.0, 'k', 'ready')`, c[0], c[1])
+		h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES (?, 't-espresso')`, c[0])
+	}
+	for _, id := range []string{"c-lab1", "c-lab2"} {
+		h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES (?, ?, ?, 'like', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`,
+			"i-"+id, userID, id)
+	}
+
+	why := func(token string) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleWhyClip(rec, withChiParam(authRequest(t, h, "GET", "/api/clips/c-hub/why", nil, token), "id", "c-hub"))
+		if rec.Code != 200 {
+			t.Fatalf("why: expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+
+	got := why(token)
+	want := "Because you liked 2 clips about espresso last week and Espresso Hub is similar to Coffee Lab, which you watch."
+	if got["summary"] != want {
+		t.Errorf("summary = %q, want %q", got["summary"], want)
+	}
+	if reasons := got["reasons"].([]interface{}); reasons[0].(map[string]interface{})["kind"] != "liked_topic" {
+		t.Errorf("reasons = %v, want liked_topic first", reasons)
+	}
+
+	if got := why(""); got["summary"] != "Because it was added in the last day." {
+		t.Errorf("anonymous summary = %q", got["summary"])
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleWhyClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/nope/why", nil), "id", "nope"))
+	if rec.Code != 404 {
+		t.Errorf("missing clip: status = %d, want 404", rec.Code)
+	}
+}
+
+// --- Duplicate review ---
+
+func TestClipCompareAndMerge_PreservesSavesAndInteractions(t *testing.T) {
+	h := newTestHandlers(t)
+	aliceToken := registerUser(t, h, "alice", "password123")
+	bobToken := registerUser(t, h, "bob", "password123")
+	alice := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, aliceToken), h.authH.JWTSecret)
+	bob := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, bobToken), h.authH.JWTSecret)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src-dup', 'http://x.com', 'direct', 'Kitchen')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('keep', 'src-dup', 'Knife skills', 30.0, 'k1', 'ready')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('dup', 'src-dup', 'Knife skills (reupload)', 32.0, 'k2', 'ready')`)
+	transcripts.Save(context.Background(), h.db, "keep", "hold the knife firmly and slice")
+	transcripts.Save(context.Background(), h.db, "dup", "hold the knife gently and slice")
+	emb := feed.Float32ToBlob([]float32{0.1, 0.2, 0.3})
+	h.db.Exec(`INSERT INTO clip_embeddings (clip_id, text_embedding) VALUES ('keep', ?), ('dup', ?)`, emb, emb)
+
+	h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action) VALUES ('i1', ?, 'dup', 'like'), ('i2', ?, 'keep', 'view')`, alice, bob)
+	h.db.Exec(`INSERT INTO saved_clips (user_id, clip_id, note) VALUES (?, 'dup', 'grip tip')`, alice)
+	h.db.Exec(`INSERT INTO saved_clip_tags (user_id, clip_id, tag) VALUES (?, 'dup', 'cooking')`, alice)
+	h.db.Exec(`INSERT INTO saved_clips (user_id, clip_id, note) VALUES (?, 'keep', 'mine'), (?, 'dup', 'theirs')`, bob, bob)
+	h.db.Exec(`INSERT INTO collections (id, user_id, title) VALUES ('knives', ?, 'Knives')`, alice)
+	h.db.Exec(`INSERT INTO collection_clips (collection_id, clip_id) VALUES ('knives', 'dup')`)
+
+	rec := httptest.NewRecorder()
+	h.clipsH.HandleCompareClips(rec, httptest.NewRequest("GET", "/api/clips/compare?a=keep&b=dup", nil))
+	if rec.Code != 200 {
+		t.Fatalf("compare: %d %s", rec.Code, rec.Body.String())
+	}
+	cmp := decodeJSON(t, rec)
+	if cmp["same_source"] != true || cmp["duration_delta_seconds"] != 2.0 {
+		t.Errorf("compare same_source = %v, duration delta = %v", cmp["same_source"], cmp["duration_delta_seconds"])
+	}
+	if sim := cmp["similarity"].(map[string]interface{}); sim["text"].(float64) < 0.999 || sim["visual"] != nil {
+		t.Errorf("similarity = %v, want text 1 and no visual", sim)
+	}
+	diff := cmp["transcript"].(map[string]interface{})
+	if diff["shared_words"] != 5.0 || fmt.Sprint(diff["only_in_a"]) != "[firmly]" || fmt.Sprint(diff["only_in_b"]) != "[gently]" {
+		t.Errorf("transcript diff = %v", diff)
+	}
+	stats := cmp["b"].(map[string]interface{})["stats"].(map[string]interface{})
+	if stats["saves"] != 2.0 || stats["collections"] != 1.0 || stats["interactions"].(map[string]interface{})["like"] != 1.0 {
+		t.Errorf("duplicate stats = %v", stats)
+	}
+
+	merge := func(body map[string]string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		b, _ := json.Marshal(body)
+		h.clipsH.HandleMergeClips(rec, httptest.NewRequest("POST", "/api/clips/merge", bytes.NewReader(b)))
+		return rec
+	}
+	if rec := merge(map[string]string{"keep": "keep", "duplicate": "missing"}); rec.Code != 404 {
+		t.Errorf("merge missing clip: status = %d, want 404", rec.Code)
+	}
+	if rec := merge(map[string]string{"keep": "keep", "duplicate": "dup"}); rec.Code != 200 {
+		t.Fatalf("merge: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var n int
+	h.db.QueryRow(`SELECT COUNT(*) FROM clips WHERE id = 'dup'`).Scan(&n)
+	if n != 0 {
+		t.Error("duplicate clip still exists after merge")
+	}
+	h.db.QueryRow(`SELECT COUNT(*) FROM interactions WHERE clip_id = 'keep'`).Scan(&n)
+	if n != 2 {
+		t.Errorf("kept clip has %d interactions, want 2", n)
+	}
+	var note string
+	h.db.QueryRow(`SELECT note FROM saved_clips WHERE user_id = ? AND clip_id = 'keep'`, alice).Scan(&note)
+	h.db.QueryRow(`SELECT COUNT(*) FROM saved_clip_tags WHERE user_id = ? AND clip_id = 'keep' AND tag = 'cooking'`, alice).Scan(&n)
+	if note != "grip tip" || n != 1 {
+		t.Errorf("alice's save moved with note %q and %d tags, want note and tag kept", note, n)
+	}
+	h.db.QueryRow(`SELECT note FROM saved_clips WHERE user_id = ? AND clip_id = 'keep'`, bob).Scan(&note)
+	if note != "mine" {
+		t.Errorf("bob's existing save note = %q, want it kept", note)
+	}
+	h.db.QueryRow(`SELECT COUNT(*) FROM collection_clips WHERE collection_id = 'knives' AND clip_id = 'keep'`).Scan(&n)
+	if n != 1 {
+		t.Error("collection membership did not move to the kept clip")
+	}
+}
+
+func TestClipTombstones_GoneWithReasonAndSuggestions(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "tombs", "password123")
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+
+	h.db.Exec(`INSERT INTO topics (id, name, slug, path, depth) VALUES ('t-cook', 'Cooking', 'cooking', 'cooking', 0)`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-t', 'http://x.com', 'direct')`)
+	for _, c := range []struct{ id, status string }{{"keep", "ready"}, {"dup", "ready"}, {"sauce", "ready"}, {"old", "expired"}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, status, content_score)
+			VALUES (?, 'src-t', ?, 30.0, 'k', 't.jpg', '["Cooking"]', ?, 0.5)`, c.id, "Clip "+c.id, c.status)
+		h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES (?, 't-cook')`, c.id)
+	}
+	h.db.Exec(`UPDATE clips SET content_score = 0.9 WHERE id = 'sauce'`)
+	// The lifecycle manager tombstones the clips it expires.
+	h.db.Exec(`INSERT INTO clip_tombstones (clip_id, title, topics, reason) VALUES ('old', 'Clip old', '["Cooking"]', 'expired')`)
+	h.db.Exec(`INSERT INTO saved_clips (user_id, clip_id) VALUES (?, 'old'), (?, 'keep')`, userID, userID)
+
+	b, _ := json.Marshal(map[string]string{"keep": "keep", "duplicate": "dup"})
+	rec := httptest.NewRecorder()
+	h.clipsH.HandleMergeClips(rec, httptest.NewRequest("POST", "/api/clips/merge", bytes.NewReader(b)))
+	if rec.Code != 200 {
+		t.Fatalf("merge: %d %s", rec.Code, rec.Body.String())
+	}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.clipsH.HandleGetClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/"+id, nil), "id", id))
+		return rec
+	}
+	similarIDs := func(body map[string]interface{}) []string {
+		var ids []string
+		for _, s := range body["similar"].([]interface{}) {
+			ids = append(ids, s.(map[string]interface{})["id"].(string))
+		}
+		return ids
+	}
+
+	rec = get("dup")
+	if rec.Code != 410 {
+		t.Fatalf("merged clip: status = %d, want 410; body: %s", rec.Code, rec.Body.String())
+	}
+	gone := decodeJSON(t, rec)
+	if gone["reason"] != "merged" || gone["replaced_by"] != "keep" || gone["title"] != "Clip dup" {
+		t.Errorf("merged tombstone = %v", gone)
+	}
+	if ids := similarIDs(gone); len(ids) != 2 || ids[0] != "keep" || ids[1] != "sauce" {
+		t.Errorf("merged clip suggestions = %v, want [keep sauce]", ids)
+	}
+
+	rec = get("old")
+	if rec.Code != 410 {
+		t.Fatalf("expired clip: status = %d, want 410", rec.Code)
+	}
+	gone = decodeJSON(t, rec)
+	if gone["reason"] != "expired" || gone["replaced_by"] != nil {
+		t.Errorf("expired tombstone = %v", gone)
+	}
+	if ids := similarIDs(gone); len(ids) != 2 || ids[0] != "sauce" {
+		t.Errorf("expired clip suggestions = %v, want sauce first", ids)
+	}
+	rec = httptest.NewRecorder()
+	h.clipsH.HandleStreamClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/old/stream", nil), "id", "old"))
+	if rec.Code != 410 {
+		t.Errorf("stream of expired clip: status = %d, want 410", rec.Code)
+	}
+
+	if rec := get("never-existed"); rec.Code != 404 {
+		t.Errorf("unknown clip: status = %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.savedH.HandleListSaved(rec, authRequest(t, h, "GET", "/api/me/saved", nil, token))
+	saved := decodeJSON(t, rec)["clips"].([]interface{})
+	if len(saved) != 1 || saved[0].(map[string]interface{})["id"] != "keep" {
+		t.Errorf("saved list = %v, want only the live clip", saved)
+	}
+}
+
+func TestImpersonation_ScopedAuditedAndVisibleToUser(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "supported", "password123")
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+
+	start := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/admin/users/"+userID+"/impersonate", bytes.NewReader(raw))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, "admin-1"))
+		rec := httptest.NewRecorder()
+		h.authH.HandleStartImpersonation(rec, withChiParam(req, "id", userID))
+		return rec
+	}
+	if rec := start(map[string]interface{}{}); rec.Code != 400 {
+		t.Errorf("no reason: status = %d, want 400", rec.Code)
+	}
+	if rec := start(map[string]interface{}{"reason": "x", "minutes": 120}); rec.Code != 400 {
+		t.Errorf("two hours: status = %d, want 400", rec.Code)
+	}
+	rec := start(map[string]interface{}{"reason": "ticket 42: feed shows only cats"})
+	if rec.Code != 201 {
+		t.Fatalf("start: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	started := decodeJSON(t, rec)
+	impToken := started["token"].(string)
+	if started["read_only"] != true || !strings.HasPrefix(impToken, auth.ImpersonationTokenPrefix) {
+		t.Errorf("start = %v, want a read-only imp_ token", started)
+	}
+
+	// The token acts as the user for reads and is refused for writes.
+	var seenUser string
+	var seenImp *auth.Impersonation
+	echo := h.authH.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenUser, _ = auth.ExtractUserID(r)
+		seenImp = auth.ImpersonationFromContext(r.Context())
+		w.WriteHeader(204)
+	}))
+	call := func(method, tok string) int {
+		req := httptest.NewRequest(method, "/api/me/preferences", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := call("GET", impToken); code != 204 || seenUser != userID || seenImp == nil || !seenImp.ReadOnly {
+		t.Fatalf("read: status = %d, user = %q, impersonation = %+v", code, seenUser, seenImp)
+	}
+	if code := call("PUT", impToken); code != 403 {
+		t.Errorf("write with read-only token: status = %d, want 403", code)
+	}
+	if code := call("GET", "imp_forged"); code != 401 {
+		t.Errorf("unknown token: status = %d, want 401", code)
+	}
+	rec = httptest.NewRecorder()
+	h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, func() *http.Request {
+		req := httptest.NewRequest("GET", "/api/feed", nil)
+		req.Header.Set("Authorization", "Bearer "+impToken)
+		return req
+	}())
+	if rec.Code != 200 {
+		t.Errorf("feed as user: status = %d", rec.Code)
+	}
+
+	// A writable session audits each write.
+	rec = start(map[string]interface{}{"reason": "reproduce save bug", "read_only": false, "minutes": 5})
+	writable := decodeJSON(t, rec)
+	if code := call("PUT", writable["token"].(string)); code != 204 || seenImp.ReadOnly {
+		t.Errorf("write with writable token: status = %d", code)
+	}
+
+	req := withChiParam(httptest.NewRequest("DELETE", "/api/admin/impersonations/x", nil), "id", started["session_id"].(string))
+	rec = httptest.NewRecorder()
+	h.authH.HandleEndImpersonation(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("end: status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	if code := call("GET", impToken); code != 401 {
+		t.Errorf("ended token: status = %d, want 401", code)
+	}
+	h.db.Exec(`UPDATE impersonation_sessions SET expires_at = '2000-01-01T00:00:00Z' WHERE id = ?`, writable["session_id"])
+	if code := call("GET", writable["token"].(string)); code != 401 {
+		t.Errorf("expired token: status = %d, want 401", code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.authH.HandleListImpersonations(rec, httptest.NewRequest("GET", "/api/admin/impersonations?user_id="+userID, nil))
+	sessions := decodeJSON(t, rec)["impersonations"].([]interface{})
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %v, want 2", sessions)
+	}
+	for _, s := range sessions {
+		if m := s.(map[string]interface{}); m["active"] != false || m["request_count"].(float64) < 1 || m["actor"] != "admin-1" {
+			t.Errorf("session = %v, want inactive and used", m)
+		}
+	}
+
+	// The user sees every step in their audit log.
+	rec = httptest.NewRecorder()
+	h.authH.HandleMyAuditLog(rec, authRequest(t, h, "GET", "/api/me/audit-log", nil, token))
+	counts := map[string]int{}
+	for _, e := range decodeJSON(t, rec)["entries"].([]interface{}) {
+		m := e.(map[string]interface{})
+		counts[m["action"].(string)]++
+		// Writes are attributed to the admin who started the session.
+		if (m["action"] == "impersonation.start" || m["action"] == "impersonation.write") && m["actor"] != "admin-1" {
+			t.Errorf("%v entry actor = %v", m["action"], m["actor"])
+		}
+	}
+	if counts["impersonation.start"] != 2 || counts["impersonation.write"] != 1 || counts["impersonation.end"] != 1 {
+		t.Errorf("audit actions = %v", counts)
+	}
+}
+
+// --- Feed pacing ---
+
+func TestFeedPacing_BreaksDailyLimitAndQuietHours(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "pacer", "password123")
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-pace', 'http://x.com', 'direct')`)
+	for _, id := range []string{"c-p1", "c-p2", "c-p3"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES (?, 'src-pace', 'Pace', 30.0, 'k', 'ready')`, id)
+	}
+
+	setPacing := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleUpdatePacing(rec, authRequest(t, h, "PUT", "/api/me/pacing", body, token))
+		return rec
+	}
+	for _, bad := range []map[string]interface{}{
+		{"max_clips_per_day": 0},
+		{"break_after_clips": 1000},
+		{"quiet_hours_start": "22:00"},
+		{"quiet_hours_start": "25:00", "quiet_hours_end": "07:00"},
+		{"timezone": "Mars/Olympus"},
+	} {
+		if rec := setPacing(bad); rec.Code != 400 {
+			t.Errorf("pacing %v: status = %d, want 400", bad, rec.Code)
+		}
+	}
+	if rec := setPacing(map[string]interface{}{"max_clips_per_day": 3, "break_after_clips": 2, "break_minutes": 10, "timezone": "Europe/Berlin"}); rec.Code != 200 {
+		t.Fatalf("set pacing: %d %s", rec.Code, rec.Body.String())
+	}
+
+	view := func(clipID string) {
+		req := authRequest(t, h, "POST", "/api/clips/"+clipID+"/interact", map[string]interface{}{"action": "view"}, token)
+		h.clipsH.HandleInteraction(httptest.NewRecorder(), withChiParam(req, "id", clipID))
+	}
+	feedPacing := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+		body := decodeJSON(t, rec)
+		pacing, _ := body["pacing"].(map[string]interface{})
+		if pacing != nil && body["count"].(float64) != 0 {
+			t.Errorf("paused feed served %v clips", body["count"])
+		}
+		return pacing
+	}
+
+	view("c-p1")
+	if p := feedPacing(); p != nil {
+		t.Fatalf("feed paused after one view: %v", p)
+	}
+	view("c-p2")
+	p := feedPacing()
+	if p == nil || p["state"] != "break" {
+		t.Fatalf("after 2 consecutive views pacing = %v, want break", p)
+	}
+
+	h.db.Exec(`UPDATE user_pacing_state SET break_until = NULL WHERE user_id = ?`, userID)
+	view("c-p3")
+	if p := feedPacing(); p == nil || p["state"] != "daily_limit" {
+		t.Fatalf("after 3 views pacing = %v, want daily_limit", p)
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleGetPacing(rec, authRequest(t, h, "GET", "/api/me/pacing", nil, token))
+	if usage := decodeJSON(t, rec)["usage"].(map[string]interface{}); usage["clips_today"] != 3.0 || usage["streak"] != 1.0 {
+		t.Errorf("usage = %v, want 3 clips today with a streak of 1", usage)
+	}
+
+	// Quiet hours spanning the current UTC time, wrapping past midnight.
+	now := time.Now().UTC()
+	if rec := setPacing(map[string]interface{}{
+		"quiet_hours_start": now.Add(-time.Hour).Format("15:04"),
+		"quiet_hours_end":   now.Add(time.Hour).Format("15:04"),
+	}); rec.Code != 200 {
+		t.Fatalf("set quiet hours: %d %s", rec.Code, rec.Body.String())
+	}
+	p = feedPacing()
+	if p == nil || p["state"] != "quiet_hours" {
+		t.Fatalf("pacing = %v, want quiet_hours", p)
+	}
+	resume, _ := time.Parse("2006-01-02T15:04:05Z", p["resume_at"].(string))
+	if d := resume.Sub(now); d < 58*time.Minute || d > 61*time.Minute {
+		t.Errorf("resume_at = %v, want about an hour from now", p["resume_at"])
+	}
+
+	if rec := setPacing(map[string]interface{}{}); rec.Code != 200 {
+		t.Fatalf("clear pacing: %d", rec.Code)
+	}
+	if p := feedPacing(); p != nil {
+		t.Errorf("feed paused with no limits set: %v", p)
+	}
+}
+
+// --- Thumbnail selection ---
+
+func TestThumbnailVariants_SampledClickedAndConverged(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('tsrc', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status, content_score) VALUES ('tc1', 'tsrc', 'Thumbs', 30.0, 'k', 'clips/tc1/thumbnail.jpg', 'ready', 0.5)`)
+
+	setThumbs := func(keys []string) int {
+		rec := httptest.NewRecorder()
+		b, _ := json.Marshal(map[string]interface{}{"thumbnail_keys": keys})
+		req := withChiParam(httptest.NewRequest("PUT", "/api/internal/clips/tc1/thumbnails", bytes.NewReader(b)), "id", "tc1")
+		h.workerH.HandleSetThumbnails(rec, req)
+		return rec.Code
+	}
+	if code := setThumbs(nil); code != 400 {
+		t.Errorf("empty thumbnail_keys: status = %d, want 400", code)
+	}
+	if code := setThumbs([]string{"clips/tc1/thumbnail.jpg", "clips/tc1/thumbnail_1.jpg", "clips/tc1/thumbnail_2.jpg"}); code != 200 {
+		t.Fatalf("set thumbnails: status = %d", code)
+	}
+
+	token := registerUser(t, h, "thumbviewer", "password123")
+	keys := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, authRequest(t, h, "GET", "/api/feed?lightweight=true", nil, token))
+		clip := decodeJSON(t, rec)["clips"].([]interface{})[0].(map[string]interface{})
+		variant, ok := clip["thumbnail_variant"].(float64)
+		if !ok {
+			t.Fatalf("feed clip has no thumbnail_variant: %v", clip)
+		}
+		want := "/storage/test-bucket/clips/tc1/thumbnail.jpg"
+		if variant > 0 {
+			want = fmt.Sprintf("/storage/test-bucket/clips/tc1/thumbnail_%d.jpg", int(variant))
+		}
+		if clip["thumbnail_url"] != want {
+			t.Errorf("variant %v served %v, want %s", variant, clip["thumbnail_url"], want)
+		}
+		keys[clip["thumbnail_url"].(string)] = true
+	}
+	// Each variant the user saw counts one impression, however often.
+	var impressions int
+	h.db.QueryRow(`SELECT SUM(impressions) FROM clip_thumbnails WHERE clip_id = 'tc1'`).Scan(&impressions)
+	if impressions != len(keys) {
+		t.Errorf("impressions = %d, want %d", impressions, len(keys))
+	}
+
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+	h.db.Exec(`INSERT INTO thumbnail_views (user_id, clip_id, variant) VALUES (?, 'tc1', 2) ON CONFLICT DO NOTHING`, userID)
+	click := func(variant int) int {
+		rec := httptest.NewRecorder()
+		req := withChiParam(authRequest(t, h, "POST", "/api/clips/tc1/thumbnail-click", map[string]int{"variant": variant}, token), "id", "tc1")
+		h.feedH.HandleThumbnailClick(rec, req)
+		return rec.Code
+	}
+	if code := click(7); code != 404 {
+		t.Errorf("unknown variant click: status = %d, want 404", code)
+	}
+
+	// Once the variants have enough impressions, the clearly better one
+	// becomes the default thumbnail.
+	h.db.Exec(`UPDATE clip_thumbnails SET impressions = 300, clicks = 3 WHERE clip_id = 'tc1' AND variant = 0`)
+	h.db.Exec(`UPDATE clip_thumbnails SET impressions = 300, clicks = 40 WHERE clip_id = 'tc1' AND variant = 2`)
+	if code := click(2); code != 200 {
+		t.Fatalf("click: status = %d", code)
+	}
+	var current string
+	h.db.QueryRow(`SELECT thumbnail_key FROM clips WHERE id = 'tc1'`).Scan(&current)
+	if current != "clips/tc1/thumbnail_2.jpg" {
+		t.Errorf("converged thumbnail = %q, want variant 2", current)
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleThumbnailStats(rec, httptest.NewRequest("GET", "/api/admin/thumbnails", nil))
+	stats := decodeJSON(t, rec)["clips"].([]interface{})
+	if len(stats) != 1 {
+		t.Fatalf("thumbnail stats clips = %d, want 1", len(stats))
+	}
+	variants := stats[0].(map[string]interface{})["variants"].([]interface{})
+	if len(variants) != 3 || variants[2].(map[string]interface{})["current"] != true || variants[2].(map[string]interface{})["clicks"] != 41.0 {
+		t.Errorf("variant stats = %v", variants)
+	}
+}
+
+func TestThumbnailClick_CountsOnceForShownVariant(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('tsrc', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status, content_score) VALUES ('tc1', 'tsrc', 'Thumbs', 30.0, 'k', 'clips/tc1/thumbnail.jpg', 'ready', 0.5)`)
+	// Variant 0 leads; a handful of clicks on variant 1 would overtake it.
+	h.db.Exec(`INSERT INTO clip_thumbnails (clip_id, variant, thumbnail_key, impressions, clicks) VALUES
+		('tc1', 0, 'clips/tc1/thumbnail.jpg', 300, 15), ('tc1', 1, 'clips/tc1/thumbnail_1.jpg', 300, 14)`)
+	token := registerUser(t, h, "clicker", "password123")
+	userID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, token), h.authH.JWTSecret)
+
+	r := chi.NewRouter()
+	r.With(h.authH.AuthMiddleware).Post("/api/clips/{id}/thumbnail-click", h.feedH.HandleThumbnailClick)
+	click := func(variant int, token string) int {
+		req := httptest.NewRequest("POST", "/api/clips/tc1/thumbnail-click", strings.NewReader(fmt.Sprintf(`{"variant": %d}`, variant)))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := click(1, ""); code != 401 {
+		t.Errorf("anonymous click: status = %d, want 401", code)
+	}
+	if code := click(1, token); code != 404 {
+		t.Errorf("click on a variant never shown: status = %d, want 404", code)
+	}
+	h.db.Exec(`INSERT INTO thumbnail_views (user_id, clip_id, variant) VALUES (?, 'tc1', 1)`, userID)
+	for i := 0; i < 5; i++ {
+		if code := click(1, token); code != 200 {
+			t.Fatalf("click %d: status = %d", i, code)
+		}
+	}
+
+	var clicks int
+	var current string
+	h.db.QueryRow(`SELECT clicks FROM clip_thumbnails WHERE clip_id = 'tc1' AND variant = 1`).Scan(&clicks)
+	h.db.QueryRow(`SELECT thumbnail_key FROM clips WHERE id = 'tc1'`).Scan(&current)
+	if clicks != 15 || current != "clips/tc1/thumbnail.jpg" {
+		t.Errorf("after repeated clicks: variant 1 clicks = %d, thumbnail = %q; want 15 and variant 0", clicks, current)
+	}
+}
+
+// --- Topic suggestions ---
+
+func TestTopicSuggestions_RankAndAccept(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src1', 'http://x.com', 'direct')`)
+	for _, tp := range [][2]string{{"t-cats", "cats"}, {"t-cars", "cars"}, {"t-sea", "deep sea"}} {
+		h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES (?, ?, ?)`, tp[0], tp[1], tp[0])
+	}
+	for _, c := range []struct {
+		id, title, transcript, topic string
+		vec                          []float32
+	}{
+		{"c1", "Cat nap", "", "t-cats", []float32{1, 0, 0}},
+		{"c2", "Race day", "", "t-cars", []float32{0, 1, 0}},
+		// Mis-tagged with cars; its embedding sits with the cat clips and
+		// its transcript mentions the deep sea.
+		{"c0", "Kitten compilation", "then we visited the Deep Sea aquarium", "t-cars", []float32{0.9, 0.1, 0}},
+	} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES (?, 'src1', ?, 30.0, 'k', 'ready')`,
+			c.id, c.title)
+		transcripts.Save(context.Background(), h.db, c.id, c.transcript)
+		h.db.Exec(`INSERT INTO clip_embeddings (clip_id, text_embedding) VALUES (?, ?)`, c.id, feed.Float32ToBlob(c.vec))
+		h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES (?, ?)`, c.id, c.topic)
+	}
+	h.feedH.RefreshTopicGraph()
+
+	suggest := func() []map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/clips/c0/topic-suggestions", nil)
+		req = withChiParam(req, "id", "c0")
+		rec := httptest.NewRecorder()
+		h.feedH.HandleTopicSuggestions(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("suggestions: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+		}
+		var out []map[string]interface{}
+		for _, s := range decodeJSON(t, rec)["suggestions"].([]interface{}) {
+			out = append(out, s.(map[string]interface{}))
+		}
+		return out
+	}
+
+	got := suggest()
+	if len(got) != 2 || got[0]["id"] != "t-cats" || got[1]["id"] != "t-sea" {
+		t.Fatalf("suggestions = %v, want cats (embedding) then deep sea (keyword)", got)
+	}
+	if got[1]["keyword_match"] != 1.0 || got[1]["similarity"] != 0.0 {
+		t.Errorf("deep sea suggestion = %v, want keyword_match 1 and similarity 0", got[1])
+	}
+
+	accept := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/clips/c0/topic-suggestions", bytes.NewReader(b))
+		req = withChiParam(req, "id", "c0")
+		rec := httptest.NewRecorder()
+		h.feedH.HandleAcceptTopicSuggestions(rec, req)
+		return rec
+	}
+	if rec := accept(map[string]interface{}{"topic_ids": []string{"t-nope"}}); rec.Code != 400 {
+		t.Errorf("unknown topic: status = %d, want 400", rec.Code)
+	}
+	rec := accept(map[string]interface{}{"topic_ids": []string{"t-cats"}, "remove_topic_ids": []string{"t-cars"}})
+	if rec.Code != 200 {
+		t.Fatalf("accept: status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+	}
+
+	var source, topicsJSON string
+	h.db.QueryRow(`SELECT source FROM clip_topics WHERE clip_id = 'c0' AND topic_id = 't-cats'`).Scan(&source)
+	h.db.QueryRow(`SELECT topics FROM clips WHERE id = 'c0'`).Scan(&topicsJSON)
+	if source != "curator" || topicsJSON != `["cats"]` {
+		t.Errorf("after accept: source = %q, clips.topics = %s; want curator and [\"cats\"]", source, topicsJSON)
+	}
+
+	// The removed auto-tag is now a (weak) suggestion; the accepted one is not.
+	got = suggest()
+	ids := make([]string, len(got))
+	for i, s := range got {
+		ids[i] = s["id"].(string)
+	}
+	if strings.Join(ids, ",") != "t-sea,t-cars" {
+		t.Errorf("suggestions after accept = %v, want t-sea,t-cars", ids)
+	}
+}
+
+// --- Daily mixes ---
+
+func TestHandleDailyMix_StableAcrossRefreshes(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "mixer", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-mix', 'http://x.com', 'direct')`)
+	for i := 0; i < 25; i++ {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, 'src-mix', 'Mix Clip', 30.0, 'key', 'ready', ?)`,
+			fmt.Sprintf("mix-%02d", i), float64(i)/25)
+	}
+
+	fetch := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleDailyMix(rec, authRequest(t, h, "GET", "/api/mixes/daily", nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("daily mix: expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+
+	first := fetch()
+	clipsList := first["clips"].([]interface{})
+	if len(clipsList) < 20 || len(clipsList) > 30 {
+		t.Fatalf("mix has %d clips, want 20-30", len(clipsList))
+	}
+	second := fetch()
+	if first["id"] != second["id"] {
+		t.Fatal("expected the same mix on refresh")
+	}
+	for i, c := range second["clips"].([]interface{}) {
+		if c.(map[string]interface{})["id"] != clipsList[i].(map[string]interface{})["id"] {
+			t.Fatalf("clip order changed at position %d", i)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleListMixes(rec, authRequest(t, h, "GET", "/api/mixes", nil, token))
+	if mixes := decodeJSON(t, rec)["mixes"].([]interface{}); len(mixes) != 1 {
+		t.Fatalf("expected 1 mix in history, got %d", len(mixes))
+	}
+}
+
+// --- Focus sessions ---
+
+func TestFocusSession_FitsBudgetAndReportsWatchTime(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "focuser", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-focus', 'http://x.com', 'direct')`)
+	for i := 0; i < 10; i++ {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, 'src-focus', 'Focus Clip', 45.0, 'key', 'ready', 0.5)`,
+			fmt.Sprintf("focus-%d", i))
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleStartFocus(rec, authRequest(t, h, "POST", "/api/feed/focus", map[string]float64{"budget_minutes": 0.5}, token))
+	if rec.Code != 400 {
+		t.Errorf("half-minute budget: expected 400, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleStartFocus(rec, authRequest(t, h, "POST", "/api/feed/focus", map[string]float64{"budget_minutes": 2}, token))
+	if rec.Code != 201 {
+		t.Fatalf("start focus: expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	session := decodeJSON(t, rec)
+	if planned := session["planned_seconds"].(float64); planned != 90 {
+		t.Fatalf("planned_seconds = %v, want 90 within a 120s budget", planned)
+	}
+	sessionID := session["session_id"].(string)
+	firstClip := session["clips"].([]interface{})[0].(map[string]interface{})["id"].(string)
+
+	rec = httptest.NewRecorder()
+	req := authRequest(t, h, "POST", "/api/clips/"+firstClip+"/interact", map[string]interface{}{"action": "view", "watch_duration_seconds": 40.0}, token)
+	h.clipsH.HandleInteraction(rec, withChiParam(req, "id", firstClip))
+
+	rec = httptest.NewRecorder()
+	req = authRequest(t, h, "POST", "/api/feed/focus/"+sessionID+"/end", nil, token)
+	h.feedH.HandleEndFocus(rec, withChiParam(req, "id", sessionID))
+	if rec.Code != 200 {
+		t.Fatalf("end focus: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	report := decodeJSON(t, rec)
+	if report["watched_seconds"].(float64) != 40 || report["clips_watched"].(float64) != 1 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+}
+
+// --- Notifications ---
+
+func TestNotifyJobFinished_RecordsIngestSummary(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "notified", "password123")
+
+	req := authRequest(t, h, "POST", "/api/ingest", map[string]string{"url": "https://www.youtube.com/watch?v=notify1"}, token)
+	rec := httptest.NewRecorder()
+	h.ingestH.HandleIngest(rec, req)
+	jobID := decodeJSON(t, rec)["job_id"].(string)
+
+	complete := func() {
+		req := httptest.NewRequest("PUT", "/api/internal/jobs/"+jobID,
+			bytes.NewReader([]byte(`{"status":"complete","result":{"clip_ids":["a","b"],"clip_count":2,"failed_count":1}}`)))
+		rec := httptest.NewRecorder()
+		h.workerH.HandleUpdateJob(rec, withChiParam(req, "id", jobID))
+		if rec.Code != 200 {
+			t.Fatalf("update job: expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	listNotifications := func() []interface{} {
+		rec := httptest.NewRecorder()
+		h.notifyH.HandleListNotifications(rec, authRequest(t, h, "GET", "/api/me/notifications", nil, token))
+		return decodeJSON(t, rec)["notifications"].([]interface{})
+	}
+
+	// Not opted in: nothing is recorded.
+	complete()
+	if n := len(listNotifications()); n != 0 {
+		t.Fatalf("expected no notifications before opting in, got %d", n)
+	}
+
+	rec = httptest.NewRecorder()
+	h.notifyH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/notifications/preferences", map[string]bool{"push_enabled": true}, token))
+	if rec.Code != 200 {
+		t.Fatalf("update preferences: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	complete()
+	list := listNotifications()
+	if len(list) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(list))
+	}
+	body := list[0].(map[string]interface{})["body"].(string)
+	if !strings.Contains(body, "2 clips created, 1 failed") {
+		t.Errorf("unexpected notification body: %q", body)
+	}
+}
+
+func TestNotificationSubscriptions_QueueOnClipCreateAndDigest(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "subscriber", "password123")
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('tcook', 'Cooking', 'cooking')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('chefsrc', 'http://x.com/chef', 'youtube', 'Chef')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('othersrc', 'http://x.com/other', 'youtube', 'Other')`)
+
+	subscribe := func(kind, id string) int {
+		rec := httptest.NewRecorder()
+		h.notifyH.HandleCreateSubscription(rec, authRequest(t, h, "POST", "/api/me/notifications/subscriptions",
+			map[string]string{"type": kind, "id": id}, token))
+		return rec.Code
+	}
+	if code := subscribe("topic", "cooking"); code != 201 {
+		t.Fatalf("subscribe to topic: status = %d", code)
+	}
+	if code := subscribe("channel", "Chef"); code != 201 {
+		t.Fatalf("subscribe to channel: status = %d", code)
+	}
+	if code := subscribe("channel", "Nobody"); code != 404 {
+		t.Errorf("unknown channel: status = %d, want 404", code)
+	}
+
+	createClip := func(id, sourceID string, topics []string) {
+		b, _ := json.Marshal(map[string]interface{}{
+			"id": id, "source_id": sourceID, "title": "Clip " + id, "duration_seconds": 30,
+			"storage_key": "clips/" + id + ".mp4", "topics": topics,
+		})
+		rec := httptest.NewRecorder()
+		h.workerH.HandleCreateClip(rec, httptest.NewRequest("POST", "/api/internal/clips", bytes.NewReader(b)))
+		if rec.Code != 201 && rec.Code != 200 {
+			t.Fatalf("create clip %s: status = %d, body: %s", id, rec.Code, rec.Body.String())
+		}
+	}
+	createClip("sub1", "chefsrc", []string{"Cooking"}) // matches both subscriptions
+	createClip("sub2", "othersrc", []string{"Cooking"})
+	createClip("sub3", "othersrc", []string{"Gardening"})
+
+	rec := httptest.NewRecorder()
+	h.notifyH.HandleListSubscriptions(rec, authRequest(t, h, "GET", "/api/me/notifications/subscriptions", nil, token))
+	pending := 0
+	for _, s := range decodeJSON(t, rec)["subscriptions"].([]interface{}) {
+		pending += int(s.(map[string]interface{})["pending_clips"].(float64))
+	}
+	if pending != 3 {
+		t.Errorf("pending matches = %d, want 3 (two for the topic, one for the channel)", pending)
+	}
+
+	n, err := h.notifyH.SendDigests(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("SendDigests = %d, %v; want one digest", n, err)
+	}
+	rec = httptest.NewRecorder()
+	h.notifyH.HandleListNotifications(rec, authRequest(t, h, "GET", "/api/me/notifications", nil, token))
+	list := decodeJSON(t, rec)["notifications"].([]interface{})
+	if len(list) != 1 {
+		t.Fatalf("notifications = %v, want one digest", list)
+	}
+	digest := list[0].(map[string]interface{})
+	if digest["kind"] != "subscription_digest" || digest["title"] != "2 new clips from Cooking and Chef" {
+		t.Errorf("digest = %v", digest)
+	}
+	if ids := digest["payload"].(map[string]interface{})["clip_ids"].([]interface{}); len(ids) != 2 {
+		t.Errorf("digest clip_ids = %v, want sub1 and sub2", ids)
+	}
+
+	if n, _ := h.notifyH.SendDigests(context.Background()); n != 0 {
+		t.Errorf("second SendDigests = %d, want 0 once the queue is drained", n)
+	}
+}
+
+func TestSyncSocket_PushesBatchedHighAffinityClips(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "liveuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'liveuser'`).Scan(&userID)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('tcook', 'Cooking', 'cooking'), ('tgame', 'Gaming', 'gaming')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('livesrc', 'http://x.com/live', 'youtube', 'Live')`)
+	h.db.Exec(`INSERT INTO user_topic_affinities (user_id, topic_id, weight) VALUES (?, 'tcook', 2.0), (?, 'tgame', 0.5)`, userID, userID)
+
+	liveH := &live.Handler{DB: h.db, Auth: h.authH, BatchWindow: 300 * time.Millisecond, MinInterval: time.Second}
+	h.workerH.OnClipCreated = liveH.ClipCreated
+	srv := httptest.NewServer(http.HandlerFunc(liveH.HandleSync))
+	defer srv.Close()
+
+	dial := func(token string) (*websocket.Conn, live.Message) {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/me/sync", "", "http://localhost")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if err := websocket.JSON.Send(ws, live.Message{Type: "auth", Token: token}); err != nil {
+			t.Fatalf("send auth: %v", err)
+		}
+		var msg live.Message
+		ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		return ws, msg
+	}
+	if ws, msg := dial("not-a-token"); msg.Type != "error" {
+		t.Errorf("bad token: got %+v, want an error", msg)
+	} else {
+		ws.Close()
+	}
+	ws, msg := dial(token)
+	defer ws.Close()
+	if msg.Type != "ready" {
+		t.Fatalf("first message = %+v, want ready", msg)
+	}
+
+	createClip := func(id, topic string) {
+		b, _ := json.Marshal(map[string]interface{}{
+			"id": id, "source_id": "livesrc", "title": "Clip " + id, "duration_seconds": 30,
+			"storage_key": "clips/" + id + ".mp4", "topics": []string{topic},
+		})
+		rec := httptest.NewRecorder()
+		h.workerH.HandleCreateClip(rec, httptest.NewRequest("POST", "/api/internal/clips", bytes.NewReader(b)))
+		if rec.Code != 201 && rec.Code != 200 {
+			t.Fatalf("create clip %s: status = %d, body: %s", id, rec.Code, rec.Body.String())
+		}
+	}
+	receive := func() live.Message {
+		t.Helper()
+		var msg live.Message
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		return msg
+	}
+
+	// Only the clips above the user's threshold are pushed, together.
+	createClip("lowclip", "Gaming")
+	createClip("likedclip1", "Cooking")
+	createClip("likedclip2", "Cooking")
+	first := receive()
+	sentAt := time.Now()
+	sort.Strings(first.ClipIDs)
+	if first.Type != "new_clips" || first.Count != 2 || strings.Join(first.ClipIDs, ",") != "likedclip1,likedclip2" {
+		t.Fatalf("push = %+v, want the two cooking clips", first)
+	}
+	if first.Message != "2 new clips you'll like" {
+		t.Errorf("message = %q", first.Message)
+	}
+
+	// The next push waits out the minimum interval.
+	createClip("likedclip3", "Cooking")
+	second := receive()
+	if second.Count != 1 || len(second.ClipIDs) != 1 || second.ClipIDs[0] != "likedclip3" {
+		t.Fatalf("second push = %+v, want likedclip3", second)
+	}
+	if waited := time.Since(sentAt); waited < 900*time.Millisecond {
+		t.Errorf("second push came %v after the first, want at least the 1s interval", waited)
+	}
+}
+
+func TestSeries_ClusterGetAndFeedNextPart(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "bingewatcher", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'bingewatcher'`).Scan(&userID)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('ssrc', 'http://x.com', 'youtube', 'Shed Channel')`)
+	for i, title := range []string{"Shed build part 1", "Shed build part 2", "Shed build part 3", "Unrelated clip"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, description, thumbnail_key, duration_seconds, storage_key, status, content_score) VALUES (?, 'ssrc', ?, '', '', 30.0, 'k', 'ready', 0.1)`,
+			fmt.Sprintf("sc%d", i+1), title)
+	}
+
+	n, err := h.feedH.ClusterSeries(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("ClusterSeries = %d, %v; want 1 series", n, err)
+	}
+	var seriesID string
+	h.db.QueryRow(`SELECT series_id FROM series_clips WHERE clip_id = 'sc1'`).Scan(&seriesID)
+	if n, _ := h.feedH.ClusterSeries(context.Background()); n != 1 {
+		t.Fatalf("second run = %d series", n)
+	}
+	var again string
+	h.db.QueryRow(`SELECT series_id FROM series_clips WHERE clip_id = 'sc1'`).Scan(&again)
+	if again != seriesID {
+		t.Errorf("series ID changed across runs: %s -> %s", seriesID, again)
+	}
+
+	h.db.Exec(`INSERT INTO interactions (id, user_id, clip_id, action) VALUES ('si1', ?, 'sc1', 'watch_full')`, userID)
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleGetSeries(rec, withChiParam(authRequest(t, h, "GET", "/api/series/"+seriesID, nil, token), "id", seriesID))
+	if rec.Code != 200 {
+		t.Fatalf("get series: status = %d", rec.Code)
+	}
+	body := decodeJSON(t, rec)
+	clips := body["clips"].([]interface{})
+	if body["title"] != "Shed build" || len(clips) != 3 || body["next_clip_id"] != "sc2" {
+		t.Fatalf("series = %v", body)
+	}
+	if first := clips[0].(map[string]interface{}); first["part_number"].(float64) != 1 || first["watched"] != true {
+		t.Errorf("first part = %v", first)
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+	feedClips := decodeJSON(t, rec)["clips"].([]interface{})
+	if len(feedClips) == 0 {
+		t.Fatal("empty feed")
+	}
+	top := feedClips[0].(map[string]interface{})
+	if top["id"] != "sc2" || top["series_next"] != true || top["series_part"].(float64) != 2 {
+		t.Errorf("feed top = %v, want next part sc2", top)
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleGetSeries(rec, withChiParam(httptest.NewRequest("GET", "/api/series/missing", nil), "id", "missing"))
+	if rec.Code != 404 {
+		t.Errorf("missing series: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSnooze_TopicAndChannelExcludedUntilCancelled(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "newsweary", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('nsrc', 'http://x.com/n', 'youtube', 'Cable News')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('csrc', 'http://x.com/c', 'youtube', 'Cook Along')`)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-pol', 'Politics', 'politics')`)
+	h.db.Exec(`INSERT INTO topics (id, name, slug, parent_id) VALUES ('t-elec', 'Elections', 'elections', 't-pol')`)
+	for _, c := range []struct{ id, src string }{{"news1", "nsrc"}, {"cook1", "csrc"}, {"cook2", "csrc"}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, description, thumbnail_key, duration_seconds, storage_key, status, content_score) VALUES (?, ?, 'Clip', '', '', 30.0, 'k', 'ready', 0.5)`, c.id, c.src)
+	}
+	h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES ('cook2', 't-elec')`)
+
+	feedIDs := func() map[string]bool {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+		ids := map[string]bool{}
+		for _, c := range decodeJSON(t, rec)["clips"].([]interface{}) {
+			ids[c.(map[string]interface{})["id"].(string)] = true
+		}
+		return ids
+	}
+	if ids := feedIDs(); !ids["news1"] || !ids["cook2"] {
+		t.Fatalf("feed before snooze = %v", ids)
+	}
+
+	rec := httptest.NewRecorder()
+	h.profileH.HandleSnooze(rec, authRequest(t, h, "POST", "/api/me/snooze", map[string]interface{}{"type": "channel", "id": "Cable News", "days": 3}, token))
+	if rec.Code != 201 {
+		t.Fatalf("snooze channel: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	channelSnooze := decodeJSON(t, rec)["id"].(string)
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleSnooze(rec, authRequest(t, h, "POST", "/api/me/snooze", map[string]interface{}{"type": "topic", "id": "politics"}, token))
+	if rec.Code != 201 || decodeJSON(t, rec)["target_id"] != "t-pol" {
+		t.Fatalf("snooze topic: status = %d", rec.Code)
+	}
+
+	if ids := feedIDs(); ids["news1"] || ids["cook2"] || !ids["cook1"] {
+		t.Errorf("feed during snooze = %v, want only cook1", ids)
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleListSnoozes(rec, authRequest(t, h, "GET", "/api/me/snoozes", nil, token))
+	if snoozes := decodeJSON(t, rec)["snoozes"].([]interface{}); len(snoozes) != 2 {
+		t.Fatalf("snoozes = %v, want 2", snoozes)
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleCancelSnooze(rec, withChiParam(authRequest(t, h, "DELETE", "/api/me/snoozes/"+channelSnooze, nil, token), "id", channelSnooze))
+	if rec.Code != 200 {
+		t.Fatalf("cancel: status = %d", rec.Code)
+	}
+	if ids := feedIDs(); !ids["news1"] || ids["cook2"] {
+		t.Errorf("feed after cancelling channel snooze = %v", ids)
+	}
+
+	rec = httptest.NewRecorder()
+	h.profileH.HandleSnooze(rec, authRequest(t, h, "POST", "/api/me/snooze", map[string]interface{}{"type": "channel", "id": "Cable News", "days": 365}, token))
+	if rec.Code != 400 {
+		t.Errorf("over-long snooze: status = %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.profileH.HandleSnooze(rec, authRequest(t, h, "POST", "/api/me/snooze", map[string]interface{}{"type": "channel", "id": "Nobody"}, token))
+	if rec.Code != 404 {
+		t.Errorf("unknown channel: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleFeed_FieldSelectionAndLightweight(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('fsrc', 'http://x.com', 'youtube', 'Slim Channel')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, description, thumbnail_key, duration_seconds, storage_key, status, content_score, tags) VALUES ('fc1', 'fsrc', 'Slim', 'A long description', 'thumbs/fc1.jpg', 30.0, 'k', 'ready', 0.5, '["a","b"]')`)
+
+	firstClip := func(url string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, httptest.NewRequest("GET", url, nil))
+		clips := decodeJSON(t, rec)["clips"].([]interface{})
+		if len(clips) != 1 {
+			t.Fatalf("%s: got %d clips", url, len(clips))
+		}
+		return clips[0].(map[string]interface{})
+	}
+
+	full := firstClip("/api/feed")
+	if full["description"] != "A long description" || full["tags"] == nil {
+		t.Errorf("full payload missing fields: %v", full)
+	}
+
+	light := firstClip("/api/feed?lightweight=true")
+	if len(light) != len(httputil.CardFields) {
+		t.Errorf("lightweight keys = %v, want %v", light, httputil.CardFields)
+	}
+	if light["stream_path"] != "/api/clips/fc1/stream" || light["thumbnail_url"] != "/storage/test-bucket/thumbs/fc1.jpg" || light["channel_name"] != "Slim Channel" {
+		t.Errorf("lightweight clip = %v", light)
+	}
+	if _, ok := light["description"]; ok {
+		t.Error("lightweight clip should not include description")
+	}
+
+	picked := firstClip("/api/feed?fields=title,tags&lightweight=true")
+	if len(picked) != 3 || picked["id"] != "fc1" || picked["title"] != "Slim" || picked["tags"] == nil {
+		t.Errorf("fields selection = %v, want id, title, tags", picked)
+	}
+}
+
+func TestHandleFeed_IncludeStreamPresignsFirstClips(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('wsrc', 'http://x.com', 'direct')`)
+	for i := 0; i < 8; i++ {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, 'wsrc', 'Warm', 30.0, ?, 'ready', 0.5)`,
+			fmt.Sprintf("wc%d", i), fmt.Sprintf("clips/wc%d.mp4", i))
+	}
+	var expiries []time.Duration
+	h.feedH.PresignStream = func(ctx context.Context, key string, expiry time.Duration) (string, error) {
+		expiries = append(expiries, expiry)
+		return "/storage/test-bucket/" + key + "?sig=x", nil
+	}
+
+	withStream := func(url string) int {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, httptest.NewRequest("GET", url, nil))
+		clips := decodeJSON(t, rec)["clips"].([]interface{})
+		n := 0
+		for i, c := range clips {
+			clip := c.(map[string]interface{})
+			streamURL, ok := clip["stream_url"].(string)
+			if !ok {
+				continue
+			}
+			n++
+			if i >= n || streamURL != "/storage/test-bucket/clips/"+clip["id"].(string)+".mp4?sig=x" || clip["stream_expires_at"] == nil {
+				t.Errorf("%s: clip %d = %v, want a stream URL only on the leading clips", url, i, clip)
+			}
+		}
+		return n
+	}
+
+	if n := withStream("/api/feed"); n != 0 {
+		t.Errorf("default feed presigned %d clips, want 0", n)
+	}
+	if n := withStream("/api/feed?include_stream=true"); n != 3 {
+		t.Errorf("include_stream presigned %d clips, want 3", n)
+	}
+	if n := withStream("/api/feed?include_stream=true&stream_count=50&lightweight=true"); n != 5 {
+		t.Errorf("stream_count=50 presigned %d clips, want the cap of 5", n)
+	}
+	for _, e := range expiries {
+		if e > 15*time.Minute {
+			t.Errorf("warmup URL expiry = %v, want a short expiry", e)
+		}
+	}
+}
+
+func TestHandleTVFeed_LeanPagesFromSession(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('tvsrc', 'http://x.com', 'direct')`)
+	for i := 0; i < 90; i++ {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, description, duration_seconds, storage_key, thumbnail_key, topics, tags, status, content_score)
+			VALUES (?, 'tvsrc', 'TV', 'long text', 30.0, ?, ?, '["tech"]', '["tag"]', 'ready', 0.5)`,
+			fmt.Sprintf("tv%02d", i), fmt.Sprintf("clips/tv%02d.mp4", i), fmt.Sprintf("clips/tv%02d/thumbnail.jpg", i))
+	}
+	h.db.Exec(`UPDATE clips SET large_thumbnail_key = 'clips/' || id || '/thumbnail_large.jpg' WHERE id < 'tv45'`)
+	h.feedH.PresignStream = func(ctx context.Context, key string, expiry time.Duration) (string, error) {
+		return "/storage/test-bucket/" + key + "?sig=x", nil
+	}
+
+	page := func(token, authToken string) (int, map[string]interface{}) {
+		t.Helper()
+		url := "/api/feed/tv"
+		if token != "" {
+			url += "?page=" + token
+		}
+		req := httptest.NewRequest("GET", url, nil)
+		if authToken != "" {
+			req = authRequest(t, h, "GET", url, nil, authToken)
+		}
+		rec := httptest.NewRecorder()
+		h.authH.OptionalAuth(h.feedH.HandleTVFeed)(rec, req)
+		return rec.Code, decodeJSON(t, rec)
+	}
+	ids := func(resp map[string]interface{}) []string {
+		var out []string
+		for _, c := range resp["clips"].([]interface{}) {
+			out = append(out, c.(map[string]interface{})["id"].(string))
+		}
+		return out
+	}
+
+	code, first := page("", "")
+	if code != 200 || first["count"].(float64) != feed.TVFeedLimit || first["prev_page_token"] != nil {
+		t.Fatalf("first page: status = %d, body = %v", code, first)
+	}
+	for i, c := range first["clips"].([]interface{}) {
+		clip := c.(map[string]interface{})
+		id := clip["id"].(string)
+		if _, ok := clip["description"]; ok || clip["tags"] != nil || clip["topics"] != nil {
+			t.Errorf("clip %s = %v, want no description, tags, or topics", id, clip)
+		}
+		if _, ok := clip["stream_url"]; ok != (i < 3) {
+			t.Errorf("clip %d has stream_url = %v, want one on the first 3 only", i, ok)
+		}
+		want := "/storage/test-bucket/clips/" + id + "/thumbnail.jpg"
+		if id < "tv45" {
+			want = "/storage/test-bucket/clips/" + id + "/thumbnail_large.jpg"
+		}
+		if clip["thumbnail_url"] != want {
+			t.Errorf("clip %s thumbnail_url = %v, want %s", id, clip["thumbnail_url"], want)
+		}
+	}
+
+	// Tokens are short and read back in any case.
+	next := first["next_page_token"].(string)
+	if len(next) > 12 {
+		t.Errorf("next_page_token = %q, want a short token", next)
+	}
+	code, second := page(strings.ToLower(next), "")
+	if code != 200 || second["count"].(float64) != feed.TVFeedLimit || second["page"].(float64) != 2 {
+		t.Fatalf("second page: status = %d, body = %v", code, second)
+	}
+	seen := make(map[string]bool)
+	for _, id := range append(ids(first), ids(second)...) {
+		if seen[id] {
+			t.Errorf("clip %s served twice in one session", id)
+		}
+		seen[id] = true
+	}
+
+	// Going back serves the first page again, less clips that have gone.
+	h.db.Exec(`UPDATE clips SET status = 'deleted' WHERE id = ?`, ids(first)[5])
+	_, back := page(second["prev_page_token"].(string), "")
+	want := append(append([]string{}, ids(first)[:5]...), ids(first)[6:]...)
+	if got := ids(back); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("page 1 again = %v, want %v", got, want)
+	}
+
+	_, third := page(second["next_page_token"].(string), "")
+	if third["count"].(float64) != 10 || third["next_page_token"] == nil {
+		t.Errorf("third page = %v, want the last 10 clips and a token", third)
+	}
+	_, fourth := page(third["next_page_token"].(string), "")
+	if fourth["count"].(float64) != 0 || fourth["next_page_token"] != nil {
+		t.Errorf("fourth page = %v, want empty and the end", fourth)
+	}
+
+	session, _, _ := strings.Cut(next, "-")
+	token := registerUser(t, h, "couch", "password123")
+	for _, bad := range []struct{ token, auth string }{
+		{"nonsense", ""},
+		{session + "-9", ""},
+		{"ZZZZZZZZ-1", ""},
+		{next, token},
+	} {
+		if code, _ := page(bad.token, bad.auth); code != 400 {
+			t.Errorf("page %q: status = %d, want 400", bad.token, code)
+		}
+	}
+}
+
+func TestPlaybackQueue_PersistsPositionAndNeverRepeats(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "binger", "password123")
+	other := registerUser(t, h, "otherbinger", "password123")
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('qsrc', 'http://x.com', 'direct')`)
+	for i := 0; i < 60; i++ {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, topics, status, content_score)
+			VALUES (?, 'qsrc', 'Q', 30.0, ?, ?, '["tech"]', 'ready', 0.5)`,
+			fmt.Sprintf("q%02d", i), fmt.Sprintf("clips/q%02d.mp4", i), fmt.Sprintf("clips/q%02d/thumbnail.jpg", i))
+	}
+	h.feedH.PresignStream = func(ctx context.Context, key string, expiry time.Duration) (string, error) {
+		return "/storage/test-bucket/" + key + "?sig=x", nil
+	}
+
+	get := func(query, authToken string) (int, map[string]interface{}) {
+		t.Helper()
+		req := authRequest(t, h, "GET", "/api/feed/queue"+query, nil, authToken)
+		rec := httptest.NewRecorder()
+		h.authH.AuthMiddleware(http.HandlerFunc(h.feedH.HandleGetQueue)).ServeHTTP(rec, req)
+		return rec.Code, decodeJSON(t, rec)
+	}
+	advance := func(body map[string]interface{}, authToken string) (int, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.authH.AuthMiddleware(http.HandlerFunc(h.feedH.HandleAdvanceQueue)).ServeHTTP(rec, authRequest(t, h, "POST", "/api/queue/advance", body, authToken))
+		return rec.Code, decodeJSON(t, rec)
+	}
+	ids := func(resp map[string]interface{}) []string {
+		var out []string
+		for _, c := range resp["clips"].([]interface{}) {
+			out = append(out, c.(map[string]interface{})["id"].(string))
+		}
+		return out
+	}
+
+	code, first := get("?include_stream=true&stream_count=2", token)
+	if code != 200 || first["count"] != float64(feed.QueueAhead) || first["position"] != float64(0) {
+		t.Fatalf("first read: status = %d, body = %v", code, first)
+	}
+	queueID := first["queue_id"].(string)
+	for i, c := range first["clips"].([]interface{}) {
+		if _, ok := c.(map[string]interface{})["stream_url"]; ok != (i < 2) {
+			t.Errorf("clip %d has stream_url = %v, want one on the first 2 only", i, ok)
+		}
+	}
+	firstIDs := ids(first)
+
+	// Finishing the first clip moves past it.
+	code, next := advance(map[string]interface{}{"queue_id": queueID, "clip_id": firstIDs[0]}, token)
+	if code != 200 || next["position"] != float64(1) || next["count"] != float64(feed.QueueAhead) {
+		t.Fatalf("advance: status = %d, body = %v", code, next)
+	}
+	if nextIDs := ids(next); nextIDs[0] != firstIDs[1] {
+		t.Errorf("after advance the queue starts at %s, want %s", nextIDs[0], firstIDs[1])
+	}
+
+	// A retried advance does not move the queue again; steps does.
+	if _, resp := advance(map[string]interface{}{"queue_id": queueID, "clip_id": firstIDs[0]}, token); resp["position"] != float64(1) {
+		t.Errorf("retried advance: position = %v, want 1", resp["position"])
+	}
+	if _, resp := advance(map[string]interface{}{"queue_id": queueID, "steps": 5}, token); resp["position"] != float64(6) {
+		t.Errorf("advance 5: position = %v, want 6", resp["position"])
+	}
+	if code, _ := advance(map[string]interface{}{"queue_id": queueID, "clip_id": "nope"}, token); code != 409 {
+		t.Errorf("advance past a clip not queued: status = %d, want 409", code)
+	}
+
+	// Skipping ahead tops the queue up with clips it does not hold yet.
+	if _, resp := advance(map[string]interface{}{"queue_id": queueID, "steps": feed.QueueAhead}, token); resp["count"] != float64(feed.QueueAhead) {
+		t.Errorf("after skipping ahead count = %v, want %d", resp["count"], feed.QueueAhead)
+	}
+	var held string
+	var position int
+	h.db.QueryRow(`SELECT clip_ids, position FROM playback_queues WHERE id = ?`, queueID).Scan(&held, &position)
+	var all []string
+	json.Unmarshal([]byte(held), &all)
+	if len(all)-position < feed.QueueAhead {
+		t.Errorf("queue holds %d clips at position %d, want %d ahead", len(all), position, feed.QueueAhead)
+	}
+	seen := map[string]bool{}
+	for _, id := range all {
+		if seen[id] {
+			t.Fatalf("queue holds %s twice: %v", id, all)
+		}
+		seen[id] = true
+	}
+
+	// The position persists: a new read resumes the queue.
+	if _, resumed := get("", token); resumed["queue_id"] != queueID || resumed["position"] != float64(position) || ids(resumed)[0] != all[position] {
+		t.Errorf("resumed = %v, want queue %s at position %d", resumed, queueID, position)
+	}
+	if _, fresh := get("?fresh=true", token); fresh["queue_id"] == queueID || fresh["position"] != float64(0) {
+		t.Errorf("fresh queue = %v, want a new one", fresh)
+	}
+	if code, _ := get("?queue="+queueID, other); code != 404 {
+		t.Errorf("another user's queue: status = %d, want 404", code)
+	}
+	if code, _ := advance(map[string]interface{}{"queue_id": queueID}, other); code != 404 {
+		t.Errorf("advance another user's queue: status = %d, want 404", code)
+	}
+
+	// Queues need an account, and each user keeps a bounded number.
+	if code, _ := get("", ""); code != 401 {
+		t.Errorf("anonymous queue: status = %d, want 401", code)
+	}
+	for i := 0; i < 15; i++ {
+		get("?fresh=true", token)
+	}
+	var queues int
+	h.db.QueryRow(`SELECT COUNT(*) FROM playback_queues`).Scan(&queues)
+	if queues != 10 {
+		t.Errorf("queues kept = %d, want 10", queues)
+	}
+}
+
+func TestMaintenanceReadOnly_PausesFeedWrites(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('msrc', 'http://x.com', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status, content_score) VALUES ('mc1', 'msrc', 'Paused', 30.0, 'k', 'clips/mc1/thumbnail.jpg', 'ready', 0.5)`)
+	h.db.Exec(`INSERT INTO clip_thumbnails (clip_id, variant, thumbnail_key) VALUES
+		('mc1', 0, 'clips/mc1/thumbnail.jpg'), ('mc1', 1, 'clips/mc1/thumbnail_1.jpg')`)
+	token := registerUser(t, h, "maintreader", "password123")
+
+	guard := &maintenance.Guard{DB: h.db}
+	guard.Load(context.Background())
+	rec := httptest.NewRecorder()
+	guard.HandleSet(rec, httptest.NewRequest("PUT", "/api/admin/maintenance", strings.NewReader(`{"mode":"read-only"}`)))
+	if rec.Code != 200 {
+		t.Fatalf("set read-only: status = %d", rec.Code)
+	}
+	h.feedH.Maintenance = guard
+
+	rec = httptest.NewRecorder()
+	h.authH.OptionalAuth(h.feedH.HandleFeed)(rec, authRequest(t, h, "GET", "/api/feed?lightweight=true", nil, token))
+	if rec.Code != 200 {
+		t.Fatalf("feed: status = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.authH.AuthMiddleware(http.HandlerFunc(h.feedH.HandleGetQueue)).ServeHTTP(rec, authRequest(t, h, "GET", "/api/feed/queue", nil, token))
+	if rec.Code != 503 {
+		t.Errorf("new queue in read-only mode: status = %d, want 503", rec.Code)
+	}
+
+	var views, impressions, queues int
+	h.db.QueryRow(`SELECT COUNT(*) FROM thumbnail_views`).Scan(&views)
+	h.db.QueryRow(`SELECT COALESCE(SUM(impressions), 0) FROM clip_thumbnails`).Scan(&impressions)
+	h.db.QueryRow(`SELECT COUNT(*) FROM playback_queues`).Scan(&queues)
+	if views != 0 || impressions != 0 || queues != 0 {
+		t.Errorf("read-only mode wrote views = %d, impressions = %d, queues = %d; want none", views, impressions, queues)
+	}
+}
+
+func TestWorkerGRPC_ProtocolMatchesHTTP(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "grpcuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'grpcuser'`).Scan(&userID)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, submitted_by) VALUES ('gsrc', 'http://x.com/g', 'tiktok', ?)`, userID)
+	h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload) VALUES ('gjob', 'gsrc', 'download', '{"url": "http://x.com/g", "source_id": "gsrc"}')`)
+
+	rec := httptest.NewRecorder()
+	req := withChiParam(authRequest(t, h, "PUT", "/api/me/cookies/tiktok",
+		map[string]interface{}{"cookie_str": "sid=grpc"}, token), "platform", "tiktok")
+	h.profileH.HandleSetCookie(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("set cookie: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := h.workerH.NewGRPCServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	client := workerpb.NewWorkerServiceClient(conn)
+
+	if _, err := client.ResolveTopic(context.Background(), &workerpb.ResolveTopicRequest{Name: "Cooking"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("no credentials: err = %v, want Unauthenticated", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer test-worker-secret")
+
+	job, err := client.ClaimJob(ctx, &workerpb.ClaimJobRequest{JobTypes: []string{"download"}})
+	if err != nil || job.Job == nil || job.Job.Id != "gjob" {
+		t.Fatalf("claim: job = %v err = %v, want gjob", job, err)
+	}
+	// The claim fills in the clip strategy the job was queued without.
+	if job.Job.PayloadJson != `{"schema_version":1,"source_id":"gsrc","strategy":"auto-highlight","url":"http://x.com/g"}` {
+		t.Errorf("payload = %q", job.Job.PayloadJson)
+	}
+	if empty, err := client.ClaimJob(ctx, &workerpb.ClaimJobRequest{}); err != nil || empty.Job != nil {
+		t.Errorf("second claim: job = %v err = %v, want none", empty, err)
+	}
+
+	cookie, err := client.GetCookie(ctx, &workerpb.GetCookieRequest{SourceId: "gsrc", Platform: "tiktok"})
+	if err != nil || cookie.Cookie == nil || *cookie.Cookie != "sid=grpc" {
+		t.Errorf("cookie = %v err = %v, want sid=grpc", cookie, err)
+	}
+	if none, _ := client.GetCookie(ctx, &workerpb.GetCookieRequest{SourceId: "gsrc", Platform: "youtube"}); none.Cookie != nil {
+		t.Errorf("youtube cookie = %q, want unset", *none.Cookie)
+	}
+
+	topic, err := client.ResolveTopic(ctx, &workerpb.ResolveTopicRequest{Name: "Cooking"})
+	if err != nil || !topic.Created {
+		t.Fatalf("resolve topic: %v err = %v", topic, err)
+	}
+	again, _ := client.ResolveTopic(ctx, &workerpb.ResolveTopicRequest{Name: "cooking"})
+	if again.Id != topic.Id || again.Created {
+		t.Errorf("re-resolve = %v, want existing %s", again, topic.Id)
+	}
+
+	if _, err := client.CreateClip(ctx, &workerpb.CreateClipRequest{
+		Id: "gclip", SourceId: "gsrc", Title: "gRPC pasta", DurationSeconds: 30,
+		StorageKey: "clips/gclip.mp4", Topics: []string{"Cooking"}, Platform: "tiktok",
+		TextEmbedding: []byte{0, 0, 128, 63}, ModelVersion: "test",
+	}); err != nil {
+		t.Fatalf("create clip: %v", err)
+	}
+	var clipTopic string
+	var emb []byte
+	h.db.QueryRow(`SELECT topic_id FROM clip_topics WHERE clip_id = 'gclip'`).Scan(&clipTopic)
+	h.db.QueryRow(`SELECT text_embedding FROM clip_embeddings WHERE clip_id = 'gclip'`).Scan(&emb)
+	if clipTopic != topic.Id || !bytes.Equal(emb, []byte{0, 0, 128, 63}) {
+		t.Errorf("clip topic = %q embedding = %v", clipTopic, emb)
+	}
+
+	if _, err := client.UpdateJob(ctx, &workerpb.UpdateJobRequest{JobId: "gjob", Status: "bogus"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bogus status: err = %v, want InvalidArgument", err)
+	}
+	result := `{"clips": 1}`
+	if _, err := client.UpdateJob(ctx, &workerpb.UpdateJobRequest{JobId: "gjob", Status: "complete", ResultJson: &result}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	var jobStatus, jobResult string
+	h.db.QueryRow(`SELECT status, result FROM jobs WHERE id = 'gjob'`).Scan(&jobStatus, &jobResult)
+	if jobStatus != "complete" || jobResult != result {
+		t.Errorf("job = %s %s, want complete %s", jobStatus, jobResult, result)
+	}
+}
+
+func TestJobPayloads_ValidatedAtEnqueueAndClaim(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('vsrc', 'http://x.com/v', 'direct')`)
+	h.db.Exec(`INSERT INTO jobs (id, source_id, job_type, payload, created_at) VALUES
+		('bad', 'vsrc', 'download', '{"source_id": "vsrc"}', '2026-01-01T00:00:00Z'),
+		('legacy', 'vsrc', 'download', '{"url": "http://x.com/v", "source_id": "vsrc", "platform": "direct"}', '2026-01-01T00:00:01Z')`)
+
+	rec := httptest.NewRecorder()
+	h.workerH.HandleClaimJob(rec, httptest.NewRequest("POST", "/api/internal/jobs/claim", nil))
+	if rec.Code != 200 {
+		t.Fatalf("claim: status = %d", rec.Code)
+	}
+	job := decodeJSON(t, rec)
+	if job["id"] != "legacy" {
+		t.Fatalf("claimed %v, want legacy", job["id"])
+	}
+	if v := job["payload"].(map[string]interface{})["schema_version"]; v != float64(jobs.PayloadVersion("download")) {
+		t.Errorf("schema_version = %v, want upgraded to %d", v, jobs.PayloadVersion("download"))
+	}
+
+	var status, errMsg string
+	h.db.QueryRow(`SELECT status, error FROM jobs WHERE id = 'bad'`).Scan(&status, &errMsg)
+	if status != "failed" || !strings.Contains(errMsg, "url") {
+		t.Errorf("bad job = %s %q, want failed with a url error", status, errMsg)
+	}
+
+	for payload, want := range map[string]int{
+		`{"url": "http://x.com/v", "source_id": "vsrc"}`:                       201,
+		`{"url": "http://x.com/v"}`:                                            400,
+		`{"schema_version": 99, "url": "http://x.com/v", "source_id": "vsrc"}`: 400,
+	} {
+		body := `{"source_id": "vsrc", "job_type": "download", "payload": ` + payload + `}`
+		rec := httptest.NewRecorder()
+		h.workerH.HandleCreateJob(rec, httptest.NewRequest("POST", "/api/internal/jobs", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("create with %s: status = %d, want %d (%s)", payload, rec.Code, want, rec.Body.String())
+		}
+	}
+}
+
+func TestRankSandbox_ComparesConfigurations(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "sandboxuser", "password123")
+	var userID string
+	h.db.QueryRow(`SELECT id FROM users WHERE username = 'sandboxuser'`).Scan(&userID)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('sbx', 'http://x.com/s', 'youtube', 'Chan')`)
+	for _, c := range []struct {
+		id, topic string
+		score     float64
+	}{{"sb-cook", "cooking", 0.5}, {"sb-chess1", "chess", 0.6}, {"sb-chess2", "chess", 0.7}} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score, topics)
+			VALUES (?, 'sbx', ?, 30.0, 'k', 'ready', ?, ?)`, c.id, c.id, c.score, `["`+c.topic+`"]`)
+	}
+
+	rec := httptest.NewRecorder()
+	h.profileH.HandleUpdatePreferences(rec, authRequest(t, h, "PUT", "/api/me/preferences",
+		map[string]interface{}{"topic_weights": map[string]float64{"cooking": 2.0}, "trending_boost": false}, token))
+	if rec.Code != 200 {
+		t.Fatalf("preferences: status = %d", rec.Code)
+	}
+
+	sandbox := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleRankSandbox(rec, httptest.NewRequest("POST", "/api/admin/rank/sandbox", strings.NewReader(body)))
+		return rec
+	}
+	order := func(ranking interface{}) []string {
+		var ids []string
+		for _, c := range ranking.(map[string]interface{})["clips"].([]interface{}) {
+			ids = append(ids, c.(map[string]interface{})["id"].(string))
+		}
+		return ids
+	}
+
+	rec = sandbox(`{"user_id": "` + userID + `", "clip_ids": ["sb-chess1", "sb-chess2", "sb-cook"]}`)
+	if rec.Code != 200 {
+		t.Fatalf("sandbox: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeJSON(t, rec)
+	rankings := resp["rankings"].([]interface{})
+	if len(rankings) != 3 || resp["baseline"] != "current" || resp["candidates"].(float64) != 3 {
+		t.Fatalf("response = %v, want 3 default rankings over 3 candidates", resp)
+	}
+	if got := order(rankings[1]); got[0] != "sb-cook" {
+		t.Errorf("topic_boost_only order = %v, want the boosted cooking clip first", got)
+	}
+	if cfg := rankings[2].(map[string]interface{}); cfg["diversity_mix"] != 1.0 || cfg["trending_boost"] != false {
+		t.Errorf("max_diversity config = %v, want diversity_mix 1 and the user's trending_boost", cfg)
+	}
+
+	rec = sandbox(`{"profile": {"topic_weights": {"chess": 3.0}}, "clip_ids": ["sb-cook", "sb-chess1"],
+		"configs": [{"name": "none", "ranker": "none", "diversity_mix": 0}, {"name": "boost", "ranker": "topic_boost", "diversity_mix": 0}]}`)
+	if rec.Code != 200 {
+		t.Fatalf("profile sandbox: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	rankings = decodeJSON(t, rec)["rankings"].([]interface{})
+	if got := order(rankings[0]); got[0] != "sb-cook" {
+		t.Errorf("ranker none order = %v, want the given order", got)
+	}
+	boosted := rankings[1].(map[string]interface{})["clips"].([]interface{})[0].(map[string]interface{})
+	if boosted["id"] != "sb-chess1" || boosted["rank_change"].(float64) != 1 {
+		t.Errorf("boosted top = %v, want sb-chess1 up one place from baseline", boosted)
+	}
+
+	for body, want := range map[string]int{
+		`{"configs": [{"ranker": "magic"}]}`:                    400,
+		`{"configs": [{"ranker": "ltr"}]}`:                      400,
+		`{"user_id": "nobody"}`:                                 404,
+		`{"user_id": "` + userID + `", "profile": {}}`:          400,
+		`{"configs": [{"ranker": "auto", "diversity_mix": 2}]}`: 400,
+	} {
+		if rec := sandbox(body); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestFeedSnapshots_DiffDetectsRankingChanges(t *testing.T) {
+	h := newTestHandlers(t)
+	ctx := context.Background()
+	opts := devdata.Options{Users: 4, Clips: 150, Topics: 8, InteractionsPerUser: 20, EmbeddingDim: 8, HistoryDays: 7, Seed: 3}
+	if _, err := devdata.Generate(ctx, h.db, opts); err != nil {
+		t.Fatalf("seed dataset: %v", err)
+	}
+	userIDs := []string{devdata.UserID(0), devdata.UserID(1), devdata.UserID(2), devdata.UserID(3)}
+
+	body, _ := json.Marshal(map[string]interface{}{"name": "baseline", "user_ids": userIDs, "k": 10})
+	rec := httptest.NewRecorder()
+	h.feedH.HandleCreateFeedSnapshot(rec, httptest.NewRequest("POST", "/api/admin/feed/snapshots", bytes.NewReader(body)))
+	if rec.Code != 201 {
+		t.Fatalf("create snapshot: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	snapshotID := decodeJSON(t, rec)["id"].(string)
+
+	diff := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleDiffFeedSnapshot(rec, withChiParam(
+			httptest.NewRequest("GET", "/api/admin/feed/snapshots/"+snapshotID+"/diff", nil), "id", snapshotID))
+		if rec.Code != 200 {
+			t.Fatalf("diff: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+	if got := diff(); got["passed"] != true {
+		t.Fatalf("unchanged ranking should pass: %v", got)
+	}
+
+	// Golden files in go tests go through the same comparison.
+	golden := filepath.Join(t.TempDir(), "feeds.json")
+	baseline, err := h.feedH.CaptureFeeds(ctx, userIDs, 10)
+	if err != nil {
+		t.Fatalf("capture feeds: %v", err)
+	}
+	feedtest.WriteGolden(t, golden, 10, baseline)
+	if cmp := feedtest.AssertGolden(t, golden, baseline, feedtest.DefaultThresholds); cmp.MinOverlapAtK != 1 {
+		t.Errorf("golden min overlap = %v, want 1", cmp.MinOverlapAtK)
+	}
+
+	// Steering one user to topics they never watched reshuffles their feed.
+	var topic string
+	h.db.QueryRow(`SELECT name FROM topics ORDER BY name DESC LIMIT 1`).Scan(&topic)
+	if _, err := h.db.Exec(`UPDATE user_preferences SET topic_weights = ?, diversity_mix = 0 WHERE user_id = ?`,
+		`{"`+topic+`": 5}`, userIDs[0]); err != nil {
+		t.Fatalf("change preferences: %v", err)
+	}
+	got := diff()
+	if got["passed"] != false {
+		t.Fatalf("changed ranking should regress: %v", got)
+	}
+	regressions := got["regressions"].([]interface{})
+	if len(regressions) != 1 || regressions[0].(map[string]interface{})["user_id"] != userIDs[0] {
+		t.Errorf("regressions = %v, want only %s", regressions, userIDs[0])
+	}
+	if cmp := got["comparison"].(map[string]interface{}); cmp["min_overlap_at_k"].(float64) >= 1 {
+		t.Errorf("min overlap = %v, want < 1", cmp["min_overlap_at_k"])
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleDeleteFeedSnapshot(rec, withChiParam(
+		httptest.NewRequest("DELETE", "/api/admin/feed/snapshots/"+snapshotID, nil), "id", snapshotID))
+	if rec.Code != 200 {
+		t.Fatalf("delete: status = %d", rec.Code)
+	}
+}
+
+func TestSavedClips_TagsNotesFilterAndExport(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "researcher", "password123")
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('rsrc', 'http://x.com/r', 'youtube', 'Lab')`)
+	for _, id := range []string{"r1", "r2"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status) VALUES (?, 'rsrc', ?, 30.0, 'k', 't', 'ready')`, id, "Clip "+id)
+		rec := httptest.NewRecorder()
+		h.savedH.HandleSaveClip(rec, withChiParam(authRequest(t, h, "POST", "/api/clips/"+id+"/save", nil, token), "id", id))
+	}
+
+	update := func(clipID string, body map[string]interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.savedH.HandleUpdateSaved(rec, withChiParam(authRequest(t, h, "PUT", "/api/me/saved/"+clipID, body, token), "clipId", clipID))
+		return rec
+	}
+
+	rec := update("r1", map[string]interface{}{"note": "cites the 2019 study", "tags": []string{" Physics ", "physics", "Sources"}})
+	if rec.Code != 200 {
+		t.Fatalf("update: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if tags := decodeJSON(t, rec)["tags"].([]interface{}); len(tags) != 2 || tags[0] != "physics" || tags[1] != "sources" {
+		t.Errorf("tags = %v, want [physics sources]", tags)
+	}
+	// A note-only update keeps the tags.
+	if resp := decodeJSON(t, update("r1", map[string]interface{}{"note": "cites the 2020 study"})); len(resp["tags"].([]interface{})) != 2 {
+		t.Errorf("note-only update = %v, want tags kept", resp)
+	}
+	if rec := update("unsaved", map[string]interface{}{"note": "x"}); rec.Code != 404 {
+		t.Errorf("unsaved clip: status = %d, want 404", rec.Code)
+	}
+	if rec := update("r2", map[string]interface{}{"tags": []string{strings.Repeat("x", 41)}}); rec.Code != 400 {
+		t.Errorf("long tag: status = %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.savedH.HandleListSaved(rec, authRequest(t, h, "GET", "/api/me/saved?tag=PHYSICS", nil, token))
+	clips := decodeJSON(t, rec)["clips"].([]interface{})
+	if len(clips) != 1 || clips[0].(map[string]interface{})["note"] != "cites the 2020 study" {
+		t.Fatalf("tag filter = %v, want only r1 with its note", clips)
+	}
+
+	rec = httptest.NewRecorder()
+	h.savedH.HandleExportSaved(rec, authRequest(t, h, "GET", "/api/me/saved/export?format=csv", nil, token))
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("csv export: status = %d, content-type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "physics;sources,cites the 2020 study") || strings.Count(body, "\n") != 3 {
+		t.Errorf("csv export = %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.savedH.HandleExportSaved(rec, authRequest(t, h, "GET", "/api/me/saved/export", nil, token))
+	if saved := decodeJSON(t, rec)["saved"].([]interface{}); len(saved) != 2 {
+		t.Errorf("json export = %v, want 2 saved clips", saved)
+	}
+
+	// Unsaving drops the clip's tags with it.
+	rec = httptest.NewRecorder()
+	h.savedH.HandleUnsaveClip(rec, withChiParam(authRequest(t, h, "DELETE", "/api/clips/r1/save", nil, token), "id", "r1"))
+	var n int
+	h.db.QueryRow(`SELECT COUNT(*) FROM saved_clip_tags WHERE clip_id = 'r1'`).Scan(&n)
+	if n != 0 {
+		t.Errorf("tags left after unsave = %d, want 0", n)
+	}
+}
+
+func TestHandleFeed_RankBudgetDegradesToContentScore(t *testing.T) {
+	h := newTestHandlers(t)
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s1', 'http://x.com', 'direct')`)
+	for i, id := range []string{"low", "mid", "high"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, 's1', 'Clip', 30.0, 'k', 'ready', ?)`,
+			id, 0.2*float64(i+1))
+	}
+
+	feed := func() map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, httptest.NewRequest("GET", "/api/feed", nil))
+		if rec.Code != 200 {
+			t.Fatalf("feed: %d %s", rec.Code, rec.Body.String())
+		}
+		return decodeJSON(t, rec)
+	}
+
+	// A budget that has run out before ranking starts serves content_score order.
+	h.feedH.RankBudget = time.Nanosecond
+	body := feed()
+	if body["rank_level"] != "content_score" {
+		t.Fatalf("rank_level = %v, want content_score", body["rank_level"])
+	}
+	var ids []string
+	for _, c := range body["clips"].([]interface{}) {
+		ids = append(ids, c.(map[string]interface{})["id"].(string))
+	}
+	if strings.Join(ids, ",") != "high,mid,low" {
+		t.Errorf("degraded order = %v, want content_score order", ids)
+	}
+
+	h.feedH.RankBudget = 0
+	if body := feed(); body["rank_level"] != "full" {
+		t.Errorf("rank_level without a budget = %v, want full", body["rank_level"])
+	}
+
+	rec := httptest.NewRecorder()
+	h.feedH.HandleRankDegradation(rec, httptest.NewRequest("GET", "/api/admin/feed/degradation", nil))
+	stats := decodeJSON(t, rec)
+	served := stats["served"].(map[string]interface{})
+	if served["content_score"] != 1.0 || served["full"] != 1.0 || stats["degraded_rate"] != 0.5 {
+		t.Errorf("degradation stats = %v", stats)
+	}
+}
+
+func TestClipFeedback_BoundedStepsReorderAndBlock(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "picky", "password123")
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('lsrc', 'http://x.com/l', 'youtube', 'Loud Channel')`)
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('csrc', 'http://x.com/c', 'youtube', 'Calm Channel')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES ('loud', 'lsrc', 'Loud', 30.0, 'k', 'ready', 0.6)`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES ('calm', 'csrc', 'Calm', 30.0, 'k', 'ready', 0.5)`)
+	h.db.Exec(`INSERT INTO topics (id, name, slug) VALUES ('t-gear', 'Gear', 'gear')`)
+	h.db.Exec(`INSERT INTO clip_topics (clip_id, topic_id) VALUES ('loud', 't-gear')`)
+
+	feedback := func(direction, dimension string) (int, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := authRequest(t, h, "POST", "/api/clips/loud/feedback", map[string]string{"direction": direction, "dimension": dimension}, token)
+		h.feedH.HandleClipFeedback(rec, withChiParam(req, "id", "loud"))
+		return rec.Code, decodeJSON(t, rec)
+	}
+	change := func(body map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		changes := body["changes"].([]interface{})
+		if len(changes) != 1 {
+			t.Fatalf("changes = %v, want one", changes)
+		}
+		return changes[0].(map[string]interface{})
+	}
+	feedIDs := func() []string {
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+		var ids []string
+		for _, c := range decodeJSON(t, rec)["clips"].([]interface{}) {
+			ids = append(ids, c.(map[string]interface{})["id"].(string))
+		}
+		return ids
+	}
+	if ids := feedIDs(); strings.Join(ids, ",") != "loud,calm" {
+		t.Fatalf("feed before feedback = %v", ids)
+	}
+
+	code, body := feedback("less", "channel")
+	if code != 200 {
+		t.Fatalf("less channel: %d %v", code, body)
+	}
+	if c := change(body); c["target"] != "Loud Channel" || c["before"] != 1.0 || c["after"] != 0.75 {
+		t.Errorf("channel change = %v, want 1 -> 0.75", c)
+	}
+	if ids := feedIDs(); strings.Join(ids, ",") != "calm,loud" {
+		t.Errorf("feed after less channel = %v, want calm first", ids)
+	}
+
+	_, body = feedback("more", "topic")
+	if c := change(body); c["target"] != "t-gear" || c["label"] != "Gear" || c["after"] != 1.25 {
+		t.Errorf("topic change = %v, want Gear -> 1.25", c)
+	}
+	_, body = feedback("less", "format")
+	if c := change(body); c["target"] != "medium" || c["after"] != 0.75 {
+		t.Errorf("format change = %v, want medium -> 0.75", c)
+	}
+
+	// Weights stop at the floor; asking for less there blocks the channel.
+	feedback("less", "channel")
+	_, body = feedback("less", "channel")
+	if c := change(body); c["after"] != 0.25 || c["blocked_until"] != nil {
+		t.Errorf("channel at floor = %v, want 0.25 and not yet blocked", c)
+	}
+	_, body = feedback("less", "channel")
+	if c := change(body); c["after"] != 0.25 || c["blocked_until"] == nil {
+		t.Errorf("channel below floor = %v, want a block", c)
+	}
+	if ids := feedIDs(); strings.Join(ids, ",") != "calm" {
+		t.Errorf("feed after block = %v, want only calm", ids)
+	}
+
+	if code, _ := feedback("sideways", "channel"); code != 400 {
+		t.Errorf("bad direction: status = %d, want 400", code)
+	}
+	if code, _ := feedback("more", "mood"); code != 400 {
+		t.Errorf("bad dimension: status = %d, want 400", code)
+	}
+}
+
+func TestHandleFeed_ExternalRankerExperimentWithFallback(t *testing.T) {
+	h := newTestHandlers(t)
+	token := registerUser(t, h, "researcher", "password123")
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('s1', 'http://x.com', 'youtube', 'Lab')`)
+	for i, id := range []string{"low", "mid", "high"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES (?, 's1', 'Clip', 30.0, 'k', 'ready', ?)`,
+			id, 0.2*float64(i+1))
+	}
+
+	var got feed.RankRequest
+	failing := false
+	ranker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "boom", 500)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		// Reverse the built-in preference: lowest content score first.
+		json.NewEncoder(w).Encode(map[string][]string{"clip_ids": {"low", "mid"}})
+	}))
+	defer ranker.Close()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/api/admin/rank/experiments", strings.NewReader(fmt.Sprintf(
+		`{"experiments": [{"name": "reverse", "url": %q, "bucket_from": 0, "bucket_to": 99, "timeout_ms": 500, "enabled": true}]}`, ranker.URL)))
+	h.feedH.HandleSetRankExperiments(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("set experiments: %d %s", rec.Code, rec.Body.String())
+	}
+
+	feedBody := func() (map[string]interface{}, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+		body := decodeJSON(t, rec)
+		var ids []string
+		for _, c := range body["clips"].([]interface{}) {
+			ids = append(ids, c.(map[string]interface{})["id"].(string))
+		}
+		return body, strings.Join(ids, ",")
+	}
+
+	body, ids := feedBody()
+	if body["rank_level"] != "external" || body["experiment"] != "reverse" {
+		t.Fatalf("feed = %v, want external ranking by the reverse experiment", body)
+	}
+	if !strings.HasPrefix(ids, "low,mid") || len(got.Candidates) != 3 || got.UserID == "" || got.Experiment != "reverse" {
+		t.Errorf("order = %s, request = %+v", ids, got)
+	}
+
+	failing = true
+	body, ids = feedBody()
+	if body["rank_level"] == "external" || !strings.HasPrefix(ids, "high") {
+		t.Errorf("fallback feed = %v (%s), want built-in ranking", body["rank_level"], ids)
+	}
+
+	rec = httptest.NewRecorder()
+	h.feedH.HandleGetRankExperiments(rec, httptest.NewRequest("GET", "/api/admin/rank/experiments", nil))
+	stats := decodeJSON(t, rec)["experiments"].([]interface{})[0].(map[string]interface{})["stats"].(map[string]interface{})
+	if stats["served"] != 1.0 || stats["fallbacks"] != 1.0 {
+		t.Errorf("stats = %v, want 1 served and 1 fallback", stats)
+	}
+}
+
+func TestContentFilters_RestrictedProfilesAndReviewQueue(t *testing.T) {
+	h := newTestHandlers(t)
+	ctx := context.Background()
+	cf := &contentfilter.Handler{DB: h.db}
+	parentToken := registerUser(t, h, "parent", "password123")
+	kidToken := registerUser(t, h, "kid", "password123")
+	kidID := auth.ExtractUserIDFromToken(authRequest(t, h, "GET", "/", nil, kidToken), h.authH.JWTSecret)
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform, channel_name) VALUES ('src', 'http://x.com/s', 'youtube', 'Chan')`)
+	for id, transcript := range map[string]string{"clean": "a lovely walk in the park", "mild": "oh darn, the cake fell"} {
+		h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status, content_score)
+			VALUES (?, 'src', ?, 30.0, 'k', 't', 'ready', 0.5)`, id, "Clip "+id)
+		if err := transcripts.Save(ctx, h.db, id, transcript); err != nil {
+			t.Fatalf("save transcript: %v", err)
+		}
+	}
+
+	// Two hits are needed to be sure; one is borderline and queued.
+	rec := httptest.NewRecorder()
+	cf.HandleCreateInstanceFilter(rec, httptest.NewRequest("POST", "/api/admin/content-filters",
+		strings.NewReader(`{"name": "mild language", "kind": "keyword", "pattern": "darn", "threshold": 2}`)))
+	if rec.Code != 201 {
+		t.Fatalf("create filter: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	created := decodeJSON(t, rec)
+	if created["matched_clips"].(float64) != 1 {
+		t.Errorf("matched_clips = %v, want 1", created["matched_clips"])
+	}
+	filterID := created["filter"].(map[string]interface{})["id"].(string)
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"id": "strong", "source_id": "src", "title": "Darn it", "duration_seconds": 30,
+		"storage_key": "k", "thumbnail_key": "t", "content_score": 0.5, "transcript": "darn darn darn",
+	})
+	rec = httptest.NewRecorder()
+	h.workerH.HandleCreateClip(rec, httptest.NewRequest("POST", "/api/internal/clips", bytes.NewReader(b)))
+	if rec.Code != 201 && rec.Code != 200 {
+		t.Fatalf("create clip: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	cf.HandleReviewQueue(rec, httptest.NewRequest("GET", "/api/admin/content-filters/review", nil))
+	queue := decodeJSON(t, rec)["matches"].([]interface{})
+	if len(queue) != 1 || queue[0].(map[string]interface{})["clip_id"] != "mild" {
+		t.Fatalf("review queue = %v, want only the borderline clip", queue)
+	}
+	if excerpt := queue[0].(map[string]interface{})["excerpt"].(string); !strings.Contains(excerpt, "darn") {
+		t.Errorf("excerpt = %q, want the matching text", excerpt)
+	}
+
+	h.db.Exec(`UPDATE clips SET description = '' WHERE id = 'strong'`)
+	rec = httptest.NewRecorder()
+	h.clipsH.HandleGetClip(rec, withChiParam(httptest.NewRequest("GET", "/api/clips/strong", nil), "id", "strong"))
+	if tags := decodeJSON(t, rec)["content_filters"].([]interface{}); len(tags) != 1 || tags[0] != "mild language" {
+		t.Errorf("content_filters = %v, want [mild language]", tags)
+	}
+
+	feedIDs := func(token string) map[string]bool {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.feedH.HandleFeed(rec, authRequest(t, h, "GET", "/api/feed", nil, token))
+		if rec.Code != 200 {
+			t.Fatalf("feed: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		ids := map[string]bool{}
+		for _, c := range decodeJSON(t, rec)["clips"].([]interface{}) {
+			ids[c.(map[string]interface{})["id"].(string)] = true
+		}
+		return ids
+	}
+	if ids := feedIDs(kidToken); !ids["strong"] || !ids["mild"] {
+		t.Fatalf("unrestricted kid feed = %v, want every clip", ids)
+	}
+
+	rec = httptest.NewRecorder()
+	cf.HandleSetRestricted(rec, withChiParam(httptest.NewRequest("PUT", "/api/admin/users/"+kidID+"/restricted",
+		strings.NewReader(`{"restricted": true}`)), "id", kidID))
+	if rec.Code != 200 {
+		t.Fatalf("restrict: status = %d", rec.Code)
+	}
+	if ids := feedIDs(kidToken); ids["strong"] || ids["mild"] || !ids["clean"] {
+		t.Errorf("restricted feed = %v, want only the clean clip", ids)
+	}
+	if ids := feedIDs(parentToken); !ids["strong"] || !ids["mild"] {
+		t.Errorf("parent feed = %v, want every clip", ids)
+	}
+
+	// Dismissing the borderline match shows the clip again.
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/api/admin/content-filters/review/mild/"+filterID, strings.NewReader(`{"status": "dismissed"}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("clipId", "mild")
+	rctx.URLParams.Add("filterId", filterID)
+	cf.HandleReviewMatch(rec, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+	if rec.Code != 200 {
+		t.Fatalf("dismiss: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if ids := feedIDs(kidToken); ids["strong"] || !ids["mild"] {
+		t.Errorf("restricted feed after dismissal = %v, want mild back", ids)
+	}
+
+	// A user's own filters apply to them whether or not they are restricted.
+	rec = httptest.NewRecorder()
+	cf.HandleCreateMyFilter(rec, authRequest(t, h, "POST", "/api/me/content-filters",
+		map[string]interface{}{"kind": "regex", "pattern": `\bpark\b`}, parentToken))
+	if rec.Code != 201 {
+		t.Fatalf("create own filter: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if ids := feedIDs(parentToken); ids["clean"] || !ids["strong"] {
+		t.Errorf("parent feed with own filter = %v, want clean hidden", ids)
+	}
+	rec = httptest.NewRecorder()
+	cf.HandleCreateMyFilter(rec, authRequest(t, h, "POST", "/api/me/content-filters",
+		map[string]interface{}{"kind": "regex", "pattern": "("}, parentToken))
+	if rec.Code != 400 {
+		t.Errorf("invalid regex: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestWorkerCreateClip_ReplacesBrokenClip(t *testing.T) {
+	h := newTestHandlers(t)
+	var checked []string
+	h.workerH.OnClipCreated = func(clipID string) { checked = append(checked, clipID) }
+
+	h.db.Exec(`INSERT INTO sources (id, url, platform) VALUES ('src-rep', 'https://example.com/v', 'direct')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, start_time, end_time, storage_key, status, broken_reason)
+		VALUES ('old', 'src-rep', 'Old cut', 30, 0, 30, 'k-old', 'broken', 'video object is empty')`)
+	h.db.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, start_time, end_time, storage_key, status, broken_reason)
+		VALUES ('other', 'src-rep', 'Other cut', 30, 30, 60, 'k-other', 'broken', 'video object missing')`)
+
+	media := &mediacheck.Handler{DB: h.db}
+	rec := httptest.NewRecorder()
+	media.HandleListBroken(rec, httptest.NewRequest("GET", "/api/admin/clips/broken", nil))
+	if broken := decodeJSON(t, rec)["clips"].([]interface{}); len(broken) != 2 {
+		t.Fatalf("broken clips = %v, want 2", broken)
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"id": "new", "source_id": "src-rep", "title": "New cut", "duration_seconds": 30,
+		"start_time": 0.2, "end_time": 30.1, "storage_key": "k-new", "thumbnail_key": "t-new",
+	})
+	rec = httptest.NewRecorder()
+	h.workerH.HandleCreateClip(rec, httptest.NewRequest("POST", "/api/internal/clips", bytes.NewReader(b)))
+	if rec.Code != 201 {
+		t.Fatalf("create clip: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if strings.Join(checked, ",") != "new" {
+		t.Errorf("OnClipCreated got %v, want [new]", checked)
+	}
+
+	var remaining int
+	h.db.QueryRow(`SELECT COUNT(*) FROM clips WHERE id = 'old'`).Scan(&remaining)
+	var reason, replacedBy string
+	h.db.QueryRow(`SELECT reason, replaced_by FROM clip_tombstones WHERE clip_id = 'old'`).Scan(&reason, &replacedBy)
+	if remaining != 0 || reason != "merged" || replacedBy != "new" {
+		t.Errorf("old clip: %d rows, tombstone %q -> %q; want replaced by new", remaining, reason, replacedBy)
+	}
+	var otherStatus string
+	h.db.QueryRow(`SELECT status FROM clips WHERE id = 'other'`).Scan(&otherStatus)
+	if otherStatus != "broken" {
+		t.Errorf("clip from another segment: status = %q, want it left broken", otherStatus)
 	}
 }
@@ -0,0 +1,268 @@
+// Package maintenance lets admins put the instance into maintenance mode.
+// In read-only mode requests other than GET, HEAD, and OPTIONS are refused
+// while reads keep working; in full mode every request is refused. Paths on
+// the allowlist, by default the admin and worker endpoints, are always
+// served. Read-only mode does not freeze the database: allowlisted paths
+// keep writing, and handlers that write while serving reads check Active to
+// skip what they can. The state is stored in instance_settings so it
+// survives restarts.
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+// Modes.
+const (
+	ModeOff      = "off"
+	ModeReadOnly = "read-only"
+	ModeFull     = "full"
+
+	// DefaultMessage is shown when an admin enables maintenance without one.
+	DefaultMessage = "ClipFeed is down for maintenance and will be back shortly."
+
+	settingsKey   = "maintenance"
+	maxMessageLen = 500
+	maxAllowPaths = 50
+)
+
+// Modes lists every accepted mode.
+var Modes = []string{ModeOff, ModeReadOnly, ModeFull}
+
+// DefaultAllow are the path prefixes served during maintenance unless an
+// admin changes the allowlist.
+var DefaultAllow = []string{"/api/admin/", "/api/internal/"}
+
+// alwaysAllowed are served whatever the allowlist says, so maintenance can
+// always be inspected and switched off.
+var alwaysAllowed = []string{"/health", "/api/maintenance", "/api/admin/login", "/api/admin/maintenance"}
+
+// State is the stored maintenance state.
+type State struct {
+	Mode    string `json:"mode"`
+	Message string `json:"message"`
+	// Allow lists path prefixes served in any mode.
+	Allow []string `json:"allow"`
+	// Since is when the current mode was switched on; empty while off.
+	Since string `json:"since,omitempty"`
+}
+
+func defaultState() State {
+	return State{Mode: ModeOff, Allow: append([]string(nil), DefaultAllow...)}
+}
+
+// Guard enforces the maintenance state. Call Load before serving requests.
+type Guard struct {
+	DB *db.CompatDB
+
+	state atomic.Pointer[State]
+}
+
+// Load reads the stored state; with none stored maintenance is off.
+func (g *Guard) Load(ctx context.Context) error {
+	s := defaultState()
+	var value string
+	err := g.DB.QueryRowContext(ctx, `SELECT value FROM instance_settings WHERE key = ?`, settingsKey).Scan(&value)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		g.state.Store(&s)
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal([]byte(value), &s); err != nil {
+			s = defaultState()
+			g.state.Store(&s)
+			return fmt.Errorf("decode maintenance state: %w", err)
+		}
+	}
+	g.state.Store(&s)
+	return nil
+}
+
+// State returns the current state.
+func (g *Guard) State() State {
+	if s := g.state.Load(); s != nil {
+		return *s
+	}
+	return defaultState()
+}
+
+// Active reports whether maintenance mode is on. Handlers and background
+// jobs use it to skip optional writes, such as impression counting, while
+// an admin works on the instance. A nil Guard is never active.
+func (g *Guard) Active() bool {
+	return g != nil && g.State().Mode != ModeOff
+}
+
+// update validates the fields in patch, applies them over the current state,
+// and stores the result. Fields missing from patch keep their value.
+func (g *Guard) update(ctx context.Context, patch []byte) (State, error) {
+	s := g.State()
+	prevMode := s.Mode
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.DisallowUnknownFields()
+	var in struct {
+		Mode    *string   `json:"mode"`
+		Message *string   `json:"message"`
+		Allow   *[]string `json:"allow"`
+	}
+	if err := dec.Decode(&in); err != nil {
+		return s, &validationError{"invalid request body"}
+	}
+	if in.Mode != nil {
+		s.Mode = *in.Mode
+	}
+	if in.Message != nil {
+		s.Message = strings.TrimSpace(*in.Message)
+	}
+	if in.Allow != nil {
+		s.Allow = make([]string, 0, len(*in.Allow))
+		for _, p := range *in.Allow {
+			if p = strings.TrimSpace(p); p != "" {
+				s.Allow = append(s.Allow, p)
+			}
+		}
+	}
+
+	valid := false
+	for _, m := range Modes {
+		valid = valid || s.Mode == m
+	}
+	if !valid {
+		return s, &validationError{"mode must be one of: " + strings.Join(Modes, ", ")}
+	}
+	if len(s.Message) > maxMessageLen {
+		return s, &validationError{fmt.Sprintf("message must not exceed %d characters", maxMessageLen)}
+	}
+	if len(s.Allow) > maxAllowPaths {
+		return s, &validationError{fmt.Sprintf("allow must have at most %d paths", maxAllowPaths)}
+	}
+	for _, p := range s.Allow {
+		if !strings.HasPrefix(p, "/") {
+			return s, &validationError{"allow paths must start with /"}
+		}
+	}
+
+	switch {
+	case s.Mode == ModeOff:
+		s.Since = ""
+	case s.Mode != prevMode:
+		s.Since = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	}
+
+	b, _ := json.Marshal(s)
+	if _, err := g.DB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO instance_settings (key, value, updated_at) VALUES (?, ?, %s)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, g.DB.NowUTC()), settingsKey, string(b)); err != nil {
+		return s, err
+	}
+	g.state.Store(&s)
+	return s, nil
+}
+
+type validationError struct{ msg string }
+
+func (e *validationError) Error() string { return e.msg }
+
+// allowed reports whether path is served in maintenance mode.
+func (s State) allowed(path string) bool {
+	for _, list := range [][]string{alwaysAllowed, s.Allow} {
+		for _, prefix := range list {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blocks reports whether s refuses r.
+func (s State) blocks(r *http.Request) bool {
+	if s.Mode == ModeOff {
+		return false
+	}
+	if s.Mode == ModeReadOnly {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return false
+		}
+	}
+	return !s.allowed(r.URL.Path)
+}
+
+// public is the part of the state shown to everyone.
+func (s State) public() map[string]interface{} {
+	msg := s.Message
+	if msg == "" && s.Mode != ModeOff {
+		msg = DefaultMessage
+	}
+	return map[string]interface{}{"mode": s.Mode, "message": msg, "since": s.Since}
+}
+
+// Middleware answers requests the current mode refuses with 503.
+func (g *Guard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := g.State()
+		if !s.blocks(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := s.public()
+		w.Header().Set("Retry-After", "300")
+		httputil.WriteJSON(w, 503, map[string]interface{}{
+			"error":       p["message"],
+			"maintenance": p,
+		})
+	})
+}
+
+// HandleStatus tells clients whether the instance is in maintenance, so they
+// can show a notice instead of failing requests one by one.
+func (g *Guard) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	httputil.WriteJSON(w, 200, g.State().public())
+}
+
+// HandleGet returns the full maintenance state, including the allowlist.
+func (g *Guard) HandleGet(w http.ResponseWriter, r *http.Request) {
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"maintenance":   g.State(),
+		"modes":         Modes,
+		"default_allow": DefaultAllow,
+	})
+}
+
+// HandleSet changes the mode, message, or allowlist given in the body.
+func (g *Guard) HandleSet(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(httputil.LimitedBodyReader(r))
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	s, err := g.update(r.Context(), body)
+	var invalid *validationError
+	if errors.As(err, &invalid) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("update maintenance: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save maintenance state"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"maintenance": s})
+}
@@ -0,0 +1,128 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func serve(g *Guard, method, path string) int {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+	rr := httptest.NewRecorder()
+	g.Middleware(ok).ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+	return rr.Code
+}
+
+func set(t *testing.T, g *Guard, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	g.HandleSet(rr, httptest.NewRequest("PUT", "/api/admin/maintenance", strings.NewReader(body)))
+	return rr
+}
+
+func TestGuard_ModesAllowlistAndPersistence(t *testing.T) {
+	cdb := newTestDB(t)
+	g := &Guard{DB: cdb}
+	if err := g.Load(context.Background()); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if code := serve(g, "POST", "/api/clips/c1/save"); code != 200 {
+		t.Fatalf("expected writes to pass while off, got %d", code)
+	}
+
+	if rr := set(t, g, `{"mode":"read-only","message":"Backing up"}`); rr.Code != 200 {
+		t.Fatalf("set read-only: %d %s", rr.Code, rr.Body.String())
+	}
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/feed", 200},
+		{"POST", "/api/clips/c1/save", 503},
+		{"DELETE", "/api/me/saved/c1", 503},
+		{"POST", "/api/admin/invites", 200},
+		{"POST", "/api/internal/jobs/claim", 200},
+	}
+	for _, c := range cases {
+		if code := serve(g, c.method, c.path); code != c.want {
+			t.Errorf("read-only %s %s: expected %d, got %d", c.method, c.path, c.want, code)
+		}
+	}
+
+	// A restarted server picks the stored state back up.
+	restarted := &Guard{DB: cdb}
+	if err := restarted.Load(context.Background()); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if s := restarted.State(); s.Mode != ModeReadOnly || s.Message != "Backing up" || s.Since == "" {
+		t.Fatalf("state not persisted: %+v", s)
+	}
+
+	// Full mode with the worker endpoints dropped from the allowlist.
+	if rr := set(t, restarted, `{"mode":"full","allow":["/api/admin/"]}`); rr.Code != 200 {
+		t.Fatalf("set full: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, c := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/feed", 503},
+		{"POST", "/api/internal/jobs/claim", 503},
+		{"GET", "/api/admin/status", 200},
+		{"GET", "/api/maintenance", 200},
+		{"GET", "/health", 200},
+	} {
+		if code := serve(restarted, c.method, c.path); code != c.want {
+			t.Errorf("full %s %s: expected %d, got %d", c.method, c.path, c.want, code)
+		}
+	}
+
+	if rr := set(t, restarted, `{"mode":"off"}`); rr.Code != 200 {
+		t.Fatalf("set off: %d", rr.Code)
+	}
+	if code := serve(restarted, "GET", "/api/feed"); code != 200 {
+		t.Fatalf("expected reads to pass once off, got %d", code)
+	}
+	if s := restarted.State(); s.Since != "" {
+		t.Fatalf("expected since to clear when off, got %q", s.Since)
+	}
+}
+
+func TestGuard_RejectsInvalidState(t *testing.T) {
+	g := &Guard{DB: newTestDB(t)}
+	g.Load(context.Background())
+	for _, body := range []string{
+		`{"mode":"sleeping"}`,
+		`{"allow":["api/admin"]}`,
+		`{"mode":"full","extra":true}`,
+		`{"message":"` + strings.Repeat("x", maxMessageLen+1) + `"}`,
+	} {
+		if rr := set(t, g, body); rr.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", body[:min(len(body), 40)], rr.Code)
+		}
+	}
+	if s := g.State(); s.Mode != ModeOff {
+		t.Fatalf("rejected update changed the state: %+v", s)
+	}
+}
@@ -0,0 +1,62 @@
+package mediacheck
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleListBroken lists broken clips, most recently checked first, with
+// why they failed and how many repairs have been tried.
+func (h *Handler) HandleListBroken(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT c.id, COALESCE(c.title, ''), c.source_id, COALESCE(c.broken_reason, ''),
+		       c.repair_attempts, c.media_checked_at, c.created_at,
+		       (SELECT j.status FROM jobs j WHERE j.source_id = c.source_id ORDER BY j.created_at DESC LIMIT 1)
+		FROM clips c
+		WHERE c.status = 'broken'
+		ORDER BY c.media_checked_at DESC, c.id
+		LIMIT 200
+	`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list broken clips"})
+		return
+	}
+	defer rows.Close()
+	clips := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, title, reason, createdAt string
+		var sourceID, checkedAt, jobStatus *string
+		var attempts int
+		if err := rows.Scan(&id, &title, &sourceID, &reason, &attempts, &checkedAt, &createdAt, &jobStatus); err != nil {
+			continue
+		}
+		clips = append(clips, map[string]interface{}{
+			"id": id, "title": title, "source_id": sourceID, "reason": reason,
+			"repair_attempts": attempts, "repairs_exhausted": attempts >= h.maxRepairAttempts(),
+			"checked_at": checkedAt, "created_at": createdAt, "last_job_status": jobStatus,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"clips": clips, "max_repair_attempts": h.maxRepairAttempts()})
+}
+
+// HandleRecheck checks one clip's media now, restoring a broken clip whose
+// media has since been fixed.
+func (h *Handler) HandleRecheck(w http.ResponseWriter, r *http.Request) {
+	res, err := h.Check(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("mediacheck: recheck: %v", err)
+		httputil.WriteJSON(w, 502, map[string]string{"error": "failed to check clip media"})
+		return
+	}
+	httputil.WriteJSON(w, 200, res)
+}
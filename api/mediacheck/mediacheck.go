@@ -0,0 +1,297 @@
+// Package mediacheck validates clips after the worker creates them. It
+// checks that a clip's video and thumbnail exist in object storage and are
+// not empty or truncated, and that its duration agrees with the segment it
+// was cut from. A clip that fails is set to status 'broken', which keeps it
+// out of feeds, and a download job that re-cuts just its segment is queued
+// to repair it, up to a few attempts.
+package mediacheck
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/jobs"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// checkInterval is how often the background loop checks clips the
+	// post-creation hook missed and queues repairs.
+	checkInterval = time.Minute
+	// checkBatch caps how many unchecked clips one pass checks.
+	checkBatch = 100
+	// defaultRepairAttempts is how many repair jobs a broken clip gets when
+	// Handler.MaxRepairAttempts is zero.
+	defaultRepairAttempts = 2
+	// durationTolerance is the share of the segment length a clip's duration
+	// may be off by, with a floor of minDurationSlack seconds.
+	durationTolerance = 0.05
+	minDurationSlack  = 1.0
+)
+
+// ErrMissing is returned by Store.Size for an object that does not exist.
+var ErrMissing = errors.New("object missing")
+
+// Store reads object sizes from storage.
+type Store interface {
+	Size(ctx context.Context, key string) (int64, error)
+}
+
+// MinioStore is a Store over a MinIO bucket.
+type MinioStore struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// Size HEADs the object at key.
+func (s MinioStore) Size(ctx context.Context, key string) (int64, error) {
+	info, err := s.Client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return 0, ErrMissing
+		}
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Handler checks clip media and repairs broken clips.
+type Handler struct {
+	DB    *db.CompatDB
+	Store Store
+	// MaxRepairAttempts caps the repair jobs queued per broken clip; zero
+	// uses defaultRepairAttempts.
+	MaxRepairAttempts int
+}
+
+// Result is the outcome of checking one clip.
+type Result struct {
+	ClipID   string   `json:"clip_id"`
+	Status   string   `json:"status"`
+	Problems []string `json:"problems"`
+}
+
+func (h *Handler) maxRepairAttempts() int {
+	if h.MaxRepairAttempts > 0 {
+		return h.MaxRepairAttempts
+	}
+	return defaultRepairAttempts
+}
+
+// Check validates clipID's media and sets it broken or, when a broken clip
+// now passes, ready again. A storage error other than a missing object is
+// returned without changing the clip, so an outage never breaks clips.
+func (h *Handler) Check(ctx context.Context, clipID string) (Result, error) {
+	res := Result{ClipID: clipID, Problems: make([]string, 0)}
+	var storageKey, status string
+	var thumbnailKey sql.NullString
+	var fileSize sql.NullInt64
+	var duration, start, end sql.NullFloat64
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT status, storage_key, thumbnail_key, file_size_bytes, duration_seconds, start_time, end_time
+		FROM clips WHERE id = ?
+	`, clipID).Scan(&status, &storageKey, &thumbnailKey, &fileSize, &duration, &start, &end); err != nil {
+		return res, err
+	}
+	res.Status = status
+	if status != "ready" && status != "broken" {
+		return res, nil
+	}
+
+	size, err := h.objectSize(ctx, storageKey)
+	switch {
+	case errors.Is(err, ErrMissing):
+		res.Problems = append(res.Problems, "video object missing")
+	case err != nil:
+		return res, fmt.Errorf("stat video: %w", err)
+	case size == 0:
+		res.Problems = append(res.Problems, "video object is empty")
+	case fileSize.Int64 > 0 && size < fileSize.Int64:
+		res.Problems = append(res.Problems, fmt.Sprintf("video object is %d bytes, %d expected", size, fileSize.Int64))
+	}
+
+	if thumbnailKey.String != "" {
+		size, err := h.objectSize(ctx, thumbnailKey.String)
+		switch {
+		case errors.Is(err, ErrMissing):
+			res.Problems = append(res.Problems, "thumbnail object missing")
+		case err != nil:
+			return res, fmt.Errorf("stat thumbnail: %w", err)
+		case size == 0:
+			res.Problems = append(res.Problems, "thumbnail object is empty")
+		}
+	}
+
+	if duration.Float64 <= 0 {
+		res.Problems = append(res.Problems, "duration is not positive")
+	} else if start.Valid && end.Valid && end.Float64 > start.Float64 {
+		span := end.Float64 - start.Float64
+		if math.Abs(duration.Float64-span) > math.Max(minDurationSlack, span*durationTolerance) {
+			res.Problems = append(res.Problems, fmt.Sprintf("duration %.1fs does not match its %.1fs segment", duration.Float64, span))
+		}
+	}
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	if len(res.Problems) == 0 {
+		res.Status = "ready"
+		_, err = h.DB.ExecContext(ctx, `
+			UPDATE clips SET status = 'ready', broken_reason = NULL, media_checked_at = ?
+			WHERE id = ? AND status IN ('ready', 'broken')
+		`, now, clipID)
+		return res, err
+	}
+	res.Status = "broken"
+	_, err = h.DB.ExecContext(ctx, `
+		UPDATE clips SET status = 'broken', broken_reason = ?, media_checked_at = ?
+		WHERE id = ? AND status IN ('ready', 'broken')
+	`, strings.Join(res.Problems, "; "), now, clipID)
+	return res, err
+}
+
+func (h *Handler) objectSize(ctx context.Context, key string) (int64, error) {
+	if key == "" {
+		return 0, ErrMissing
+	}
+	return h.Store.Size(ctx, key)
+}
+
+// Notify checks a newly created clip in the background. The worker handler
+// calls it once the clip's row is committed.
+func (h *Handler) Notify(clipID string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if res, err := h.Check(ctx, clipID); err != nil {
+			log.Printf("mediacheck: check %s: %v", clipID, err)
+		} else if res.Status == "broken" {
+			log.Printf("mediacheck: clip %s is broken: %s", clipID, strings.Join(res.Problems, "; "))
+		}
+	}()
+}
+
+// CheckPending checks ready clips that have not been checked yet, oldest
+// first, returning how many were checked and how many were broken.
+func (h *Handler) CheckPending(ctx context.Context) (int, int, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT id FROM clips WHERE status = 'ready' AND media_checked_at IS NULL
+		ORDER BY created_at LIMIT ?
+	`, checkBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	checked, broken := 0, 0
+	for _, id := range ids {
+		res, err := h.Check(ctx, id)
+		if err != nil {
+			return checked, broken, fmt.Errorf("check %s: %w", id, err)
+		}
+		checked++
+		if res.Status == "broken" {
+			broken++
+		}
+	}
+	return checked, broken, nil
+}
+
+// QueueRepairs queues one download job per source with broken clips that
+// have repair attempts left and no job already queued or running. The job
+// re-cuts only the broken clips' segments; the worker's new clips replace
+// them. It returns how many jobs were queued.
+func (h *Handler) QueueRepairs(ctx context.Context) (int, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT c.id, c.source_id, c.start_time, c.end_time, s.url, s.platform, COALESCE(s.clip_strategy, '')
+		FROM clips c JOIN sources s ON s.id = c.source_id
+		WHERE c.status = 'broken' AND c.repair_attempts < ?
+		  AND c.start_time IS NOT NULL AND c.end_time > c.start_time
+		  AND NOT EXISTS (
+		      SELECT 1 FROM jobs j WHERE j.source_id = c.source_id AND j.status IN ('queued', 'running'))
+		ORDER BY c.source_id, c.start_time
+	`, h.maxRepairAttempts())
+	if err != nil {
+		return 0, err
+	}
+	type repair struct {
+		payload jobs.DownloadPayload
+		clipIDs []interface{}
+	}
+	var repairs []*repair
+	for rows.Next() {
+		var clipID, sourceID, url, platform, strategy string
+		var seg jobs.Segment
+		if err := rows.Scan(&clipID, &sourceID, &seg.Start, &seg.End, &url, &platform, &strategy); err != nil {
+			continue
+		}
+		if n := len(repairs); n == 0 || repairs[n-1].payload.SourceID != sourceID {
+			repairs = append(repairs, &repair{payload: jobs.DownloadPayload{
+				URL: url, SourceID: sourceID, Platform: platform, Strategy: strategy,
+			}})
+		}
+		r := repairs[len(repairs)-1]
+		r.payload.RepairSegments = append(r.payload.RepairSegments, seg)
+		r.clipIDs = append(r.clipIDs, clipID)
+	}
+	rows.Close()
+
+	queued := 0
+	for _, r := range repairs {
+		payload, err := jobs.EncodePayload("download", &r.payload)
+		if err != nil {
+			log.Printf("mediacheck: cannot repair source %s: %v", r.payload.SourceID, err)
+			continue
+		}
+		in := "(?" + strings.Repeat(", ?", len(r.clipIDs)-1) + ")"
+		if err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO jobs (id, source_id, job_type, payload) VALUES (?, ?, 'download', ?)`,
+				uuid.New().String(), r.payload.SourceID, jobs.WithTraceparent(ctx, payload)); err != nil {
+				return fmt.Errorf("queue job: %w", err)
+			}
+			_, err := conn.ExecContext(ctx, `UPDATE clips SET repair_attempts = repair_attempts + 1 WHERE id IN `+in, r.clipIDs...)
+			return err
+		}); err != nil {
+			return queued, fmt.Errorf("repair source %s: %w", r.payload.SourceID, err)
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// CheckLoop periodically checks clips the post-creation hook missed, such
+// as those created while the API was restarting, and queues repairs.
+func (h *Handler) CheckLoop() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		checked, broken, err := h.CheckPending(ctx)
+		if err != nil {
+			log.Printf("mediacheck: %v", err)
+		}
+		if broken > 0 {
+			log.Printf("mediacheck: %d of %d checked clips are broken", broken, checked)
+		}
+		if n, err := h.QueueRepairs(ctx); err != nil {
+			log.Printf("mediacheck: %v", err)
+		} else if n > 0 {
+			log.Printf("mediacheck: queued %d repair jobs", n)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package mediacheck
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"clipfeed/db"
+	"clipfeed/jobs"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+// fakeStore serves object sizes from a map; keys not in it are missing.
+type fakeStore struct {
+	sizes map[string]int64
+	err   error
+}
+
+func (s *fakeStore) Size(_ context.Context, key string) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	size, ok := s.sizes[key]
+	if !ok {
+		return 0, ErrMissing
+	}
+	return size, nil
+}
+
+func addClip(t *testing.T, cdb *db.CompatDB, id, sourceID string, start, end, duration float64, size int64) {
+	t.Helper()
+	if _, err := cdb.Exec(`
+		INSERT INTO clips (id, source_id, title, duration_seconds, start_time, end_time, storage_key, thumbnail_key, file_size_bytes, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'ready')
+	`, id, sourceID, id, duration, start, end, "clips/"+id+"/clip.mp4", "clips/"+id+"/thumbnail.jpg", size); err != nil {
+		t.Fatalf("insert clip %s: %v", id, err)
+	}
+}
+
+func TestCheck_FlagsBrokenMedia(t *testing.T) {
+	cdb := newTestDB(t)
+	cdb.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s1', 'https://example.com/v', 'direct')`)
+	store := &fakeStore{sizes: map[string]int64{}}
+	h := &Handler{DB: cdb, Store: store}
+
+	cases := []struct {
+		id       string
+		video    int64 // -1 leaves the object out
+		thumb    int64
+		duration float64
+		want     string
+	}{
+		{"ok", 1000, 50, 30, ""},
+		{"missing", -1, 50, 30, "video object missing"},
+		{"empty", 0, 50, 30, "video object is empty"},
+		{"truncated", 400, 50, 30, "video object is 400 bytes, 1000 expected"},
+		{"nothumb", 1000, -1, 30, "thumbnail object missing"},
+		{"short", 1000, 50, 12, "duration 12.0s does not match its 30.0s segment"},
+	}
+	for _, c := range cases {
+		addClip(t, cdb, c.id, "s1", 10, 40, c.duration, 1000)
+		if c.video >= 0 {
+			store.sizes["clips/"+c.id+"/clip.mp4"] = c.video
+		}
+		if c.thumb >= 0 {
+			store.sizes["clips/"+c.id+"/thumbnail.jpg"] = c.thumb
+		}
+	}
+
+	checked, broken, err := h.CheckPending(context.Background())
+	if err != nil || checked != len(cases) || broken != len(cases)-1 {
+		t.Fatalf("CheckPending = %d checked, %d broken, %v; want %d, %d", checked, broken, err, len(cases), len(cases)-1)
+	}
+	for _, c := range cases {
+		var status, reason string
+		cdb.QueryRow(`SELECT status, COALESCE(broken_reason, '') FROM clips WHERE id = ?`, c.id).Scan(&status, &reason)
+		wantStatus := "broken"
+		if c.want == "" {
+			wantStatus = "ready"
+		}
+		if status != wantStatus || reason != c.want {
+			t.Errorf("clip %s: status %q reason %q; want %q %q", c.id, status, reason, wantStatus, c.want)
+		}
+	}
+
+	// Checked clips are not checked again.
+	if checked, _, _ := h.CheckPending(context.Background()); checked != 0 {
+		t.Errorf("second CheckPending checked %d clips, want 0", checked)
+	}
+
+	// A broken clip whose media is fixed passes a recheck.
+	store.sizes["clips/missing/clip.mp4"] = 1000
+	if res, err := h.Check(context.Background(), "missing"); err != nil || res.Status != "ready" {
+		t.Errorf("recheck after fix = %+v, %v; want ready", res, err)
+	}
+}
+
+func TestCheck_StorageErrorLeavesClipAlone(t *testing.T) {
+	cdb := newTestDB(t)
+	h := &Handler{DB: cdb, Store: &fakeStore{err: errors.New("connection refused")}}
+	addClip(t, cdb, "c1", "", 0, 30, 30, 1000)
+
+	if _, err := h.Check(context.Background(), "c1"); err == nil {
+		t.Fatal("Check succeeded despite the storage error")
+	}
+	var status string
+	var checkedAt *string
+	cdb.QueryRow(`SELECT status, media_checked_at FROM clips WHERE id = 'c1'`).Scan(&status, &checkedAt)
+	if status != "ready" || checkedAt != nil {
+		t.Errorf("clip after storage error: status %q, checked_at %v; want ready and unchecked", status, checkedAt)
+	}
+}
+
+func TestQueueRepairs_OneJobPerSourceUntilAttemptsRunOut(t *testing.T) {
+	cdb := newTestDB(t)
+	cdb.Exec(`INSERT INTO sources (id, url, platform, clip_strategy) VALUES ('s1', 'https://example.com/a', 'youtube', 'full')`)
+	cdb.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s2', 'https://example.com/b', 'direct')`)
+	h := &Handler{DB: cdb, Store: &fakeStore{}, MaxRepairAttempts: 1}
+	addClip(t, cdb, "a1", "s1", 0, 30, 30, 1000)
+	addClip(t, cdb, "a2", "s1", 30, 60, 30, 1000)
+	addClip(t, cdb, "b1", "s2", 0, 30, 30, 1000)
+	cdb.Exec(`UPDATE clips SET status = 'broken'`)
+	// s2 already has a job in flight.
+	cdb.Exec(`INSERT INTO jobs (id, source_id, job_type, status, payload) VALUES ('busy', 's2', 'download', 'running', '{}')`)
+
+	n, err := h.QueueRepairs(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("QueueRepairs = %d, %v; want 1 job", n, err)
+	}
+	var raw string
+	cdb.QueryRow(`SELECT payload FROM jobs WHERE source_id = 's1' AND status = 'queued'`).Scan(&raw)
+	var p jobs.DownloadPayload
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		t.Fatalf("decode payload %q: %v", raw, err)
+	}
+	if p.URL != "https://example.com/a" || p.Strategy != "full" || len(p.RepairSegments) != 2 ||
+		p.RepairSegments[0] != (jobs.Segment{Start: 0, End: 30}) || p.RepairSegments[1] != (jobs.Segment{Start: 30, End: 60}) {
+		t.Errorf("repair payload = %+v, want both s1 segments", p)
+	}
+
+	// The queued job blocks another for s1, and once it finishes the
+	// single attempt is used up.
+	if n, _ := h.QueueRepairs(context.Background()); n != 0 {
+		t.Errorf("QueueRepairs with job queued = %d, want 0", n)
+	}
+	cdb.Exec(`UPDATE jobs SET status = 'complete'`)
+	if n, _ := h.QueueRepairs(context.Background()); n != 1 {
+		t.Errorf("QueueRepairs after jobs finished = %d, want 1 (s2 only)", n)
+	}
+	var s1Jobs int
+	cdb.QueryRow(`SELECT COUNT(*) FROM jobs WHERE source_id = 's1'`).Scan(&s1Jobs)
+	if s1Jobs != 1 {
+		t.Errorf("s1 has %d jobs, want 1", s1Jobs)
+	}
+	if !strings.Contains(raw, `"schema_version"`) {
+		t.Errorf("payload %s lacks schema_version", raw)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/httputil"
+	"clipfeed/ingest"
+	"clipfeed/settings"
+)
+
+// aiEnabled reports whether an LLM provider is configured well enough to
+// generate summaries.
+func aiEnabled() bool {
+	provider := os.Getenv("LLM_PROVIDER")
+	return provider != "" && (provider == "ollama" || os.Getenv("LLM_API_KEY") != "")
+}
+
+// serverFeatures lists the optional capabilities of this instance, keyed by
+// the names clients and telemetry reports use. Features the server does not
+// implement are reported as false so clients can rely on every key existing.
+func serverFeatures(cfg Config) map[string]bool {
+	return map[string]bool{
+		"hls":                 cfg.HLS,
+		"semantic_search":     false,
+		"profiles":            false,
+		"federation":          cfg.Federation,
+		"public_pages":        cfg.PublicPages,
+		"ai":                  aiEnabled(),
+		"worker_grpc":         cfg.WorkerSecret != "",
+		"email_notifications": cfg.SMTPHost != "",
+		"push_notifications":  cfg.VAPIDPublic != "" && cfg.VAPIDPrivate != "",
+	}
+}
+
+// timestampFormat is the layout of every timestamp the API returns. Stored
+// timestamps are UTC regardless of the server's own timezone.
+const timestampFormat = "2006-01-02T15:04:05Z"
+
+// serverTime describes how the server reports times and durations: its own
+// timezone, the timestamp layout, and the duration bucket bounds behind
+// each clip's duration_bucket.
+func serverTime() map[string]interface{} {
+	zone, offset := time.Now().Zone()
+	return map[string]interface{}{
+		"timezone":           time.Local.String(),
+		"zone_abbreviation":  zone,
+		"utc_offset_seconds": offset,
+		"timestamp_format":   "ISO8601",
+		"timestamp_layout":   timestampFormat,
+		"timestamp_timezone": "UTC",
+		"duration_buckets": []map[string]interface{}{
+			{"name": "short", "max_seconds": httputil.ShortClipSeconds},
+			{"name": "medium", "min_seconds": httputil.ShortClipSeconds, "max_seconds": httputil.MediumClipSeconds},
+			{"name": "long", "min_seconds": httputil.MediumClipSeconds},
+		},
+	}
+}
+
+// handleMeta describes the instance's branding and registration mode along
+// with the server's version, features, limits, time conventions, and ingest
+// platforms, so clients can adapt without hardcoding them.
+func handleMeta(cfg Config, cdb *db.CompatDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		instance, err := settings.Load(r.Context(), cdb)
+		if err != nil {
+			log.Printf("meta: load settings: %v", err)
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		httputil.WriteJSON(w, 200, map[string]interface{}{
+			"instance": map[string]interface{}{
+				"name":         instance.InstanceName,
+				"description":  instance.Description,
+				"logo_url":     httputil.ThumbnailURL(cfg.MinioBucket, instance.LogoKey),
+				"registration": instance.Registration,
+			},
+			"version":  version,
+			"features": serverFeatures(cfg),
+			"limits": map[string]interface{}{
+				"max_upload_bytes":           int64(cfg.MaxDownloadMB) << 20,
+				"max_video_duration_seconds": cfg.MaxVideoSecs,
+				"max_request_body_bytes":     httputil.DefaultBodyLimit,
+				"feed_limit":                 feed.FeedLimit,
+			},
+			"time":      serverTime(),
+			"platforms": ingest.SupportedPlatforms,
+		})
+	}
+}
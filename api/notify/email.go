@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SendEmail emails to directly, outside the notification log, for account
+// messages such as address confirmations.
+func (h *Handler) SendEmail(ctx context.Context, to, subject, body string) error {
+	if !h.EmailEnabled() {
+		return errors.New("email is not configured")
+	}
+	return h.sendEmail(to, subject, body)
+}
+
+// sendEmail delivers a plain-text message through the configured SMTP relay.
+func (h *Handler) sendEmail(to, subject, body string) error {
+	addr := net.JoinHostPort(h.SMTPHost, h.SMTPPort)
+	var auth smtp.Auth
+	if h.SMTPUser != "" {
+		auth = smtp.PlainAuth("", h.SMTPUser, h.SMTPPassword, h.SMTPHost)
+	}
+
+	// Strip CR/LF so user-controlled titles can't inject headers.
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		h.SMTPFrom, to, subject, time.Now().UTC().Format(time.RFC1123Z), body)
+	return smtp.SendMail(addr, auth, h.SMTPFrom, []string{to}, []byte(msg))
+}
@@ -0,0 +1,405 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Handler holds dependencies for notification preferences and delivery.
+type Handler struct {
+	DB              *db.CompatDB
+	SMTPHost        string
+	SMTPPort        string
+	SMTPUser        string
+	SMTPPassword    string
+	SMTPFrom        string
+	VAPIDPublicKey  string
+	VAPIDPrivateKey string
+	VAPIDSubject    string
+	// DigestInterval is how often subscription digests are sent; zero
+	// means DefaultDigestInterval.
+	DigestInterval time.Duration
+}
+
+// EmailEnabled reports whether an SMTP relay is configured.
+func (h *Handler) EmailEnabled() bool {
+	return h.SMTPHost != "" && h.SMTPFrom != ""
+}
+
+// PushEnabled reports whether VAPID keys are configured for web push.
+func (h *Handler) PushEnabled() bool {
+	return h.VAPIDPublicKey != "" && h.VAPIDPrivateKey != ""
+}
+
+// HandleGetPreferences returns the user's notification preferences along with
+// which delivery channels this instance supports.
+func (h *Handler) HandleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+
+	var emailEnabled, pushEnabled int
+	var notifyOn string
+	err := h.DB.QueryRowContext(r.Context(), `
+		SELECT COALESCE(p.email_enabled, 0), COALESCE(p.push_enabled, 0), COALESCE(p.notify_on, 'all')
+		FROM users u
+		LEFT JOIN notification_preferences p ON u.id = p.user_id
+		WHERE u.id = ?
+	`, userID).Scan(&emailEnabled, &pushEnabled, &notifyOn)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+
+	var subscriptions int
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM push_subscriptions WHERE user_id = ?`, userID).Scan(&subscriptions)
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"email_enabled":      emailEnabled == 1,
+		"push_enabled":       pushEnabled == 1,
+		"notify_on":          notifyOn,
+		"push_subscriptions": subscriptions,
+		"email_available":    h.EmailEnabled(),
+		"push_available":     h.PushEnabled(),
+		"vapid_public_key":   h.VAPIDPublicKey,
+	})
+}
+
+// HandleUpdatePreferences upserts the user's notification preferences.
+func (h *Handler) HandleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+
+	var req struct {
+		EmailEnabled *bool   `json:"email_enabled"`
+		PushEnabled  *bool   `json:"push_enabled"`
+		NotifyOn     *string `json:"notify_on"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.NotifyOn != nil && *req.NotifyOn != "all" && *req.NotifyOn != "failures" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "notify_on must be 'all' or 'failures'"})
+		return
+	}
+
+	boolArg := func(b *bool) interface{} {
+		if b == nil {
+			return nil
+		}
+		if *b {
+			return 1
+		}
+		return 0
+	}
+
+	_, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO notification_preferences (user_id, email_enabled, push_enabled, notify_on)
+		VALUES (?, COALESCE(?, 0), COALESCE(?, 0), COALESCE(?, 'all'))
+		ON CONFLICT(user_id) DO UPDATE SET
+			email_enabled = COALESCE(?, notification_preferences.email_enabled),
+			push_enabled  = COALESCE(?, notification_preferences.push_enabled),
+			notify_on     = COALESCE(?, notification_preferences.notify_on),
+			updated_at    = %s
+	`, h.DB.NowUTC()), userID,
+		boolArg(req.EmailEnabled), boolArg(req.PushEnabled), req.NotifyOn,
+		boolArg(req.EmailEnabled), boolArg(req.PushEnabled), req.NotifyOn)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update notification preferences"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
+}
+
+// HandleCreatePushSubscription registers a browser PushSubscription.
+func (h *Handler) HandleCreatePushSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+
+	var req struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if !strings.HasPrefix(req.Endpoint, "https://") || req.Keys.P256dh == "" || req.Keys.Auth == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "endpoint (https) and keys.p256dh/keys.auth are required"})
+		return
+	}
+
+	// A browser re-subscribing reuses its endpoint; move it to this user.
+	id := uuid.New().String()
+	err := h.DB.QueryRowContext(r.Context(), `
+		INSERT INTO push_subscriptions (id, user_id, endpoint, p256dh, auth)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET
+			user_id = excluded.user_id, p256dh = excluded.p256dh, auth = excluded.auth
+		RETURNING id
+	`, id, userID, req.Endpoint, req.Keys.P256dh, req.Keys.Auth).Scan(&id)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save push subscription"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]string{"id": id})
+}
+
+// HandleDeletePushSubscription removes one of the user's push subscriptions.
+func (h *Handler) HandleDeletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	subID := chi.URLParam(r, "id")
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM push_subscriptions WHERE id = ? AND user_id = ?`, subID, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete push subscription"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "push subscription not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// HandleListNotifications returns the user's most recent notifications.
+func (h *Handler) HandleListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, kind, title, body, payload, email_status, push_status, created_at
+		FROM notifications WHERE user_id = ?
+		ORDER BY created_at DESC LIMIT 50
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list notifications"})
+		return
+	}
+	defer rows.Close()
+
+	list := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, kind, title, body, payload, createdAt string
+		var emailStatus, pushStatus *string
+		if err := rows.Scan(&id, &kind, &title, &body, &payload, &emailStatus, &pushStatus, &createdAt); err != nil {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"id": id, "kind": kind, "title": title, "body": body,
+			"payload": json.RawMessage(payload), "email_status": emailStatus,
+			"push_status": pushStatus, "created_at": createdAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"notifications": list})
+}
+
+// NotifyJobFinished records an ingest-finished notification for the user who
+// submitted the job and dispatches it over their enabled channels. It is a
+// no-op when the user has not opted in to any channel.
+func (h *Handler) NotifyJobFinished(ctx context.Context, jobID string) {
+	var userID, status, resultStr string
+	var errMsg, url, title, email *string
+	emailEnabled, pushEnabled, notifyOn := 0, 0, "all"
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT s.submitted_by, j.status, COALESCE(j.result, '{}'), j.error, s.url, s.title, u.email,
+		       p.email_enabled, p.push_enabled, p.notify_on
+		FROM jobs j
+		JOIN sources s ON j.source_id = s.id
+		JOIN users u ON s.submitted_by = u.id
+		JOIN notification_preferences p ON p.user_id = u.id
+		WHERE j.id = ?
+	`, jobID).Scan(&userID, &status, &resultStr, &errMsg, &url, &title, &email,
+		&emailEnabled, &pushEnabled, &notifyOn)
+	if err != nil {
+		// No submitter, or the submitter never configured notifications.
+		return
+	}
+	if emailEnabled == 0 && pushEnabled == 0 {
+		return
+	}
+	if status == "complete" && notifyOn == "failures" {
+		return
+	}
+
+	var result struct {
+		ClipCount   int             `json:"clip_count"`
+		FailedCount int             `json:"failed_count"`
+		Rejection   json.RawMessage `json:"rejection"`
+	}
+	json.Unmarshal([]byte(resultStr), &result)
+
+	name := "your video"
+	if title != nil && *title != "" {
+		name = *title
+	} else if url != nil && *url != "" {
+		name = *url
+	}
+
+	var subject, body string
+	switch status {
+	case "complete":
+		subject = "Your ingest finished"
+		body = fmt.Sprintf("Your ingest of %s finished: %d clips created, %d failed.", name, result.ClipCount, result.FailedCount)
+	case "rejected":
+		subject = "Your ingest was rejected"
+		body = fmt.Sprintf("Your ingest of %s was rejected.", name)
+	default:
+		subject = "Your ingest failed"
+		body = fmt.Sprintf("Your ingest of %s failed.", name)
+	}
+	if status != "complete" && errMsg != nil && *errMsg != "" {
+		body += " " + *errMsg
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"job_id": jobID, "status": status,
+		"clip_count": result.ClipCount, "failed_count": result.FailedCount,
+		"rejection": result.Rejection,
+	})
+
+	var emailStatus, pushStatus interface{}
+	if emailEnabled == 1 {
+		emailStatus = "pending"
+	}
+	if pushEnabled == 1 {
+		pushStatus = "pending"
+	}
+
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(ctx, `
+		INSERT INTO notifications (id, user_id, kind, title, body, payload, email_status, push_status)
+		VALUES (?, ?, 'ingest_finished', ?, ?, ?, ?, ?)
+	`, id, userID, subject, body, string(payload), emailStatus, pushStatus); err != nil {
+		log.Printf("notify: record notification for job %s: %v", jobID, err)
+		return
+	}
+
+	var to string
+	if emailEnabled == 1 && email != nil {
+		to = *email
+	}
+	go h.deliver(id, userID, to, pushEnabled == 1, subject, body, payload)
+}
+
+// Send records a notification of kind for userID and delivers it over the
+// channels they enabled. It is listed in-app even when none are.
+func (h *Handler) Send(ctx context.Context, userID, kind, subject, body string, payload []byte) error {
+	to, push := h.recipient(ctx, userID)
+	emailStatus, pushStatus := pendingStatuses(to, push)
+	id := uuid.New().String()
+	if _, err := h.DB.ExecContext(ctx, `
+		INSERT INTO notifications (id, user_id, kind, title, body, payload, email_status, push_status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, userID, kind, subject, body, string(payload), emailStatus, pushStatus); err != nil {
+		return fmt.Errorf("record notification: %w", err)
+	}
+	h.deliver(id, userID, to, push, subject, body, payload)
+	return nil
+}
+
+// recipient returns the address to email userID at, empty unless they
+// enabled email, and whether they enabled push.
+func (h *Handler) recipient(ctx context.Context, userID string) (string, bool) {
+	var email *string
+	emailEnabled, pushEnabled := 0, 0
+	h.DB.QueryRowContext(ctx, `
+		SELECT u.email, COALESCE(p.email_enabled, 0), COALESCE(p.push_enabled, 0)
+		FROM users u
+		LEFT JOIN notification_preferences p ON p.user_id = u.id
+		WHERE u.id = ?
+	`, userID).Scan(&email, &emailEnabled, &pushEnabled)
+	var to string
+	if emailEnabled == 1 && email != nil {
+		to = *email
+	}
+	return to, pushEnabled == 1
+}
+
+// pendingStatuses are the initial per-channel statuses of a notification,
+// nil for channels it will not go out on.
+func pendingStatuses(to string, push bool) (interface{}, interface{}) {
+	var emailStatus, pushStatus interface{}
+	if to != "" {
+		emailStatus = "pending"
+	}
+	if push {
+		pushStatus = "pending"
+	}
+	return emailStatus, pushStatus
+}
+
+// deliver sends a recorded notification and stores the per-channel outcome.
+func (h *Handler) deliver(id, userID, email string, push bool, subject, body string, payload []byte) {
+	ctx := context.Background()
+
+	if email != "" {
+		status := "sent"
+		if !h.EmailEnabled() {
+			status = "unavailable"
+		} else if err := h.sendEmail(email, subject, body); err != nil {
+			log.Printf("notify: email to user %s: %v", userID, err)
+			status = "failed"
+		}
+		h.DB.ExecContext(ctx, `UPDATE notifications SET email_status = ? WHERE id = ?`, status, id)
+	}
+
+	if push {
+		status := h.deliverPush(ctx, userID, subject, body, payload)
+		h.DB.ExecContext(ctx, `UPDATE notifications SET push_status = ? WHERE id = ?`, status, id)
+	}
+}
+
+func (h *Handler) deliverPush(ctx context.Context, userID, subject, body string, payload []byte) string {
+	if !h.PushEnabled() {
+		return "unavailable"
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = ?`, userID)
+	if err != nil {
+		return "failed"
+	}
+	var subs []pushSubscription
+	for rows.Next() {
+		var s pushSubscription
+		if err := rows.Scan(&s.ID, &s.Endpoint, &s.P256dh, &s.Auth); err == nil {
+			subs = append(subs, s)
+		}
+	}
+	rows.Close()
+	if len(subs) == 0 {
+		return "no_subscriptions"
+	}
+
+	message, _ := json.Marshal(map[string]interface{}{
+		"title": subject, "body": body, "data": json.RawMessage(payload),
+	})
+	sent := 0
+	for _, s := range subs {
+		err := h.sendPush(ctx, s, message)
+		switch {
+		case err == nil:
+			sent++
+		case err == errSubscriptionGone:
+			h.DB.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE id = ?`, s.ID)
+		default:
+			log.Printf("notify: push to user %s: %v", userID, err)
+		}
+	}
+	if sent == 0 {
+		return "failed"
+	}
+	return "sent"
+}
@@ -0,0 +1,318 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultDigestInterval is how often queued subscription matches are
+	// sent when DigestInterval is unset.
+	DefaultDigestInterval = time.Hour
+
+	// maxSubscriptions caps how many topics and channels one user follows.
+	maxSubscriptions = 100
+
+	// maxDigestTitles caps how many clip titles a digest body lists.
+	maxDigestTitles = 5
+)
+
+// HandleListSubscriptions returns the user's topic and channel subscriptions
+// with how many new clips each has queued for the next digest.
+func (h *Handler) HandleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT ns.id, ns.target_type, ns.target_id, ns.label, ns.created_at,
+		       (SELECT COUNT(*) FROM notification_subscription_matches m WHERE m.subscription_id = ns.id)
+		FROM notification_subscriptions ns
+		WHERE ns.user_id = ?
+		ORDER BY ns.created_at DESC
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list subscriptions"})
+		return
+	}
+	defer rows.Close()
+
+	list := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, targetType, targetID, label, createdAt string
+		var pending int
+		if err := rows.Scan(&id, &targetType, &targetID, &label, &createdAt, &pending); err != nil {
+			continue
+		}
+		list = append(list, map[string]interface{}{
+			"id": id, "type": targetType, "target_id": targetID, "label": label,
+			"pending_clips": pending, "created_at": createdAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"subscriptions":           list,
+		"digest_interval_minutes": int(h.digestInterval() / time.Minute),
+	})
+}
+
+// HandleCreateSubscription subscribes the user to new clips in a topic,
+// given by ID, slug, or name, or from a channel, given by channel name.
+// Subscribing twice to the same target is a no-op.
+func (h *Handler) HandleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+
+	var req struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil || strings.TrimSpace(req.ID) == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "type and id required"})
+		return
+	}
+
+	targetID, label := req.ID, req.ID
+	switch req.Type {
+	case "topic":
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT id, name FROM topics WHERE id = ? OR slug = ? OR LOWER(name) = LOWER(?)`, req.ID, req.ID, req.ID,
+		).Scan(&targetID, &label); err != nil {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "topic not found"})
+			return
+		}
+	case "channel":
+		var n int
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM sources WHERE channel_name = ?`, req.ID,
+		).Scan(&n); err != nil || n == 0 {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "channel not found"})
+			return
+		}
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "type must be topic or channel"})
+		return
+	}
+
+	var count int
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM notification_subscriptions WHERE user_id = ?`, userID).Scan(&count)
+	if count >= maxSubscriptions {
+		httputil.WriteJSON(w, 409, map[string]string{"error": fmt.Sprintf("at most %d subscriptions allowed", maxSubscriptions)})
+		return
+	}
+
+	id := uuid.New().String()
+	err := h.DB.QueryRowContext(r.Context(), `
+		INSERT INTO notification_subscriptions (id, user_id, target_type, target_id, label)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET label = excluded.label
+		RETURNING id
+	`, id, userID, req.Type, targetID, label).Scan(&id)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save subscription"})
+		return
+	}
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"id": id, "type": req.Type, "target_id": targetID, "label": label,
+	})
+}
+
+// HandleDeleteSubscription removes one of the user's subscriptions along
+// with its queued matches.
+func (h *Handler) HandleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	subID := chi.URLParam(r, "id")
+	res, err := h.DB.ExecContext(r.Context(),
+		`DELETE FROM notification_subscriptions WHERE id = ? AND user_id = ?`, subID, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete subscription"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "subscription not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "deleted"})
+}
+
+// QueueSubscriptionMatches queues clipID for every subscription to one of
+// its topics or to its source's channel. The worker calls it from the
+// transaction that creates the clip, after the clip's topics are written;
+// SendDigests delivers the queue.
+func QueueSubscriptionMatches(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, clipID string) error {
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO notification_subscription_matches (subscription_id, clip_id)
+		SELECT ns.id, c.id
+		FROM clips c
+		LEFT JOIN sources s ON s.id = c.source_id
+		JOIN notification_subscriptions ns ON
+			(ns.target_type = 'topic' AND ns.target_id IN (SELECT topic_id FROM clip_topics WHERE clip_id = c.id))
+			OR (ns.target_type = 'channel' AND ns.target_id = s.channel_name)
+		WHERE c.id = ?
+		ON CONFLICT DO NOTHING
+	`, clipID)
+	return err
+}
+
+// digestMatch is one queued clip for one subscription.
+type digestMatch struct {
+	subscriptionID string
+	label          string
+	clipID         string
+	title          string
+}
+
+// SendDigests sends each user with queued subscription matches a single
+// notification summarising the new clips, then clears the queue. Digests
+// are always listed in-app and go out over the channels the user enabled.
+// It returns how many digests were sent.
+func (h *Handler) SendDigests(ctx context.Context) (int, error) {
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT ns.user_id, ns.id, ns.label, c.id, COALESCE(c.title, '')
+		FROM notification_subscription_matches m
+		JOIN notification_subscriptions ns ON ns.id = m.subscription_id
+		JOIN clips c ON c.id = m.clip_id
+		WHERE c.status = 'ready'
+		ORDER BY ns.user_id, m.created_at, c.id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("load subscription matches: %w", err)
+	}
+	byUser := make(map[string][]digestMatch)
+	var users []string
+	for rows.Next() {
+		var userID string
+		var m digestMatch
+		if err := rows.Scan(&userID, &m.subscriptionID, &m.label, &m.clipID, &m.title); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if _, ok := byUser[userID]; !ok {
+			users = append(users, userID)
+		}
+		byUser[userID] = append(byUser[userID], m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, userID := range users {
+		if err := h.sendDigest(ctx, userID, byUser[userID]); err != nil {
+			log.Printf("notify: digest for user %s: %v", userID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// sendDigest records and delivers one user's digest and dequeues its
+// matches in the same transaction, so a clip is never announced twice.
+func (h *Handler) sendDigest(ctx context.Context, userID string, matches []digestMatch) error {
+	var clipIDs, titles, labels []string
+	seenClip := make(map[string]bool)
+	seenLabel := make(map[string]bool)
+	for _, m := range matches {
+		if !seenClip[m.clipID] {
+			seenClip[m.clipID] = true
+			clipIDs = append(clipIDs, m.clipID)
+			if m.title != "" && len(titles) < maxDigestTitles {
+				titles = append(titles, m.title)
+			}
+		}
+		if !seenLabel[m.label] {
+			seenLabel[m.label] = true
+			labels = append(labels, m.label)
+		}
+	}
+
+	noun := "clips"
+	if len(clipIDs) == 1 {
+		noun = "clip"
+	}
+	subject := fmt.Sprintf("%d new %s from %s", len(clipIDs), noun, joinLabels(labels))
+	body := subject + "."
+	if len(titles) > 0 {
+		body += " " + strings.Join(titles, "; ")
+		if more := len(clipIDs) - len(titles); more > 0 {
+			body += fmt.Sprintf(" and %d more", more)
+		}
+		body += "."
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"clip_ids": clipIDs, "subscriptions": labels})
+
+	to, push := h.recipient(ctx, userID)
+	emailStatus, pushStatus := pendingStatuses(to, push)
+
+	id := uuid.New().String()
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO notifications (id, user_id, kind, title, body, payload, email_status, push_status)
+			VALUES (?, ?, 'subscription_digest', ?, ?, ?, ?, ?)
+		`, id, userID, subject, body, string(payload), emailStatus, pushStatus); err != nil {
+			return fmt.Errorf("record digest: %w", err)
+		}
+		for _, m := range matches {
+			if _, err := conn.ExecContext(ctx,
+				`DELETE FROM notification_subscription_matches WHERE subscription_id = ? AND clip_id = ?`,
+				m.subscriptionID, m.clipID); err != nil {
+				return fmt.Errorf("dequeue match: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.deliver(id, userID, to, push, subject, body, payload)
+	return nil
+}
+
+// joinLabels lists subscription labels for a digest subject, naming at most
+// two before summarising the rest.
+func joinLabels(labels []string) string {
+	switch len(labels) {
+	case 1:
+		return labels[0]
+	case 2:
+		return labels[0] + " and " + labels[1]
+	default:
+		return fmt.Sprintf("%s, %s and %d more", labels[0], labels[1], len(labels)-2)
+	}
+}
+
+func (h *Handler) digestInterval() time.Duration {
+	if h.DigestInterval > 0 {
+		return h.DigestInterval
+	}
+	return DefaultDigestInterval
+}
+
+// DigestLoop sends subscription digests every DigestInterval, so a burst of
+// new clips reaches each subscriber as one notification.
+func (h *Handler) DigestLoop() {
+	ticker := time.NewTicker(h.digestInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := h.SendDigests(context.Background())
+		if err != nil {
+			log.Printf("notify: subscription digests: %v", err)
+		}
+		if n > 0 {
+			log.Printf("notify: sent %d subscription digests", n)
+		}
+	}
+}
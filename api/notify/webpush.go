@@ -0,0 +1,205 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// pushRecordSize is the aes128gcm record size advertised in the header.
+// Payloads are always sent as a single record.
+const pushRecordSize = 4096
+
+// errSubscriptionGone is returned when the push service reports that a
+// subscription no longer exists and should be forgotten.
+var errSubscriptionGone = errors.New("push subscription gone")
+
+var pushClient = &http.Client{Timeout: 15 * time.Second}
+
+type pushSubscription struct {
+	ID       string
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// decodeB64 accepts both padded and unpadded URL-safe base64, since browsers
+// and key generators disagree on padding.
+func decodeB64(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// vapidKey parses a base64url-encoded raw P-256 private scalar, as produced
+// by common VAPID key generators.
+func vapidKey(privateB64 string) (*ecdsa.PrivateKey, error) {
+	raw, err := decodeB64(privateB64)
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	priv, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	pub := priv.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:65]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}, nil
+}
+
+// encryptPushPayload encrypts plaintext for a subscription using the
+// aes128gcm content encoding from RFC 8291.
+func encryptPushPayload(plaintext []byte, p256dhB64, authB64 string) ([]byte, error) {
+	uaPublicRaw, err := decodeB64(p256dhB64)
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	authSecret, err := decodeB64(authB64)
+	if err != nil {
+		return nil, fmt.Errorf("decode auth: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublic...)
+	prkKey, err := hkdf.Extract(sha256.New, sharedSecret, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prkKey, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 marks the final (and only) record.
+	padded := append(append([]byte{}, plaintext...), 0x02)
+	if len(padded)+gcm.Overhead() > pushRecordSize {
+		return nil, errors.New("push payload too large")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(salt)
+	binary.Write(&buf, binary.BigEndian, uint32(pushRecordSize))
+	buf.WriteByte(byte(len(asPublic)))
+	buf.Write(asPublic)
+	buf.Write(gcm.Seal(nil, nonce, padded, nil))
+	return buf.Bytes(), nil
+}
+
+// vapidAuthorization builds the VAPID Authorization header for endpoint.
+func (h *Handler) vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	key, err := vapidKey(h.VAPIDPrivateKey)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": h.VAPIDSubject,
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", signed, h.VAPIDPublicKey), nil
+}
+
+// sendPush delivers an encrypted payload to a single subscription.
+func (h *Handler) sendPush(ctx context.Context, sub pushSubscription, payload []byte) error {
+	body, err := encryptPushPayload(payload, sub.P256dh, sub.Auth)
+	if err != nil {
+		return err
+	}
+	authz, err := h.vapidAuthorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Authorization", authz)
+
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == 404 || resp.StatusCode == 410:
+		return errSubscriptionGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service returned %d", resp.StatusCode)
+	}
+	return nil
+}
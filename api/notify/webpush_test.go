@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncryptPushPayload_Decrypts(t *testing.T) {
+	uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := uaPrivate.PublicKey().Bytes()
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	plaintext := []byte(`{"title":"Your ingest finished"}`)
+	body, err := encryptPushPayload(plaintext,
+		base64.RawURLEncoding.EncodeToString(uaPublic),
+		base64.RawURLEncoding.EncodeToString(authSecret))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	// Decrypt as the user agent would (RFC 8291 section 3.4).
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != pushRecordSize {
+		t.Fatalf("record size = %d", rs)
+	}
+	idLen := int(body[20])
+	asPublicRaw := body[21 : 21+idLen]
+	ciphertext := body[21+idLen:]
+
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, _ := uaPrivate.ECDH(asPublic)
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublicRaw...)
+	prkKey, _ := hkdf.Extract(sha256.New, shared, authSecret)
+	ikm, _ := hkdf.Expand(sha256.New, prkKey, string(keyInfo), 32)
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	padded, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !bytes.Equal(padded, append(plaintext, 0x02)) {
+		t.Fatalf("plaintext mismatch: %q", padded)
+	}
+}
+
+func TestVapidKey_DerivesPublicKey(t *testing.T) {
+	priv, _ := ecdh.P256().GenerateKey(rand.Reader)
+	key, err := vapidKey(base64.RawURLEncoding.EncodeToString(priv.Bytes()))
+	if err != nil {
+		t.Fatalf("vapidKey: %v", err)
+	}
+	pub, _ := key.PublicKey.ECDH()
+	if !bytes.Equal(pub.Bytes(), priv.PublicKey().Bytes()) {
+		t.Fatal("derived public key does not match")
+	}
+}
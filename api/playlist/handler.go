@@ -0,0 +1,220 @@
+// Package playlist serves saved clips and collections as M3U playlists
+// that media players such as VLC and Kodi can open directly.
+package playlist
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// streamURLExpiry is how long the presigned URLs in a playlist stay
+	// valid. Players refetch the playlist each time it is opened.
+	streamURLExpiry = 12 * time.Hour
+	maxEntries      = 500
+)
+
+// Handler serves playlists and the tokens non-browser clients fetch them
+// with.
+type Handler struct {
+	DB   *db.CompatDB
+	Auth *auth.Handler
+	// PresignStream issues a playable URL for a clip's storage key.
+	PresignStream func(ctx context.Context, storageKey string, expiry time.Duration) (string, error)
+}
+
+type entry struct {
+	id, title, storageKey string
+	duration              float64
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenAuth authenticates playlist requests with either the usual Bearer
+// JWT or a playlist token in ?token=, since players cannot send headers.
+// Playlist tokens are accepted nowhere else.
+func (h *Handler) TokenAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := h.Auth.UserIDFromRequest(r)
+		if token := r.URL.Query().Get("token"); userID == "" && token != "" {
+			hash := hashToken(token)
+			if err := h.DB.QueryRowContext(r.Context(),
+				`SELECT user_id FROM playlist_tokens WHERE token_hash = ?`, hash,
+			).Scan(&userID); err == nil {
+				h.DB.ExecContext(r.Context(), fmt.Sprintf(
+					`UPDATE playlist_tokens SET last_used_at = %s WHERE token_hash = ?`, h.DB.NowUTC()), hash)
+			}
+		}
+		if userID == "" {
+			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), auth.UserIDKey, userID)))
+	}
+}
+
+// baseURL is the scheme and host the client reached the API on, so
+// playlist entries are absolute.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// HandleCreateToken issues a new playlist token, replacing any previous
+// one, and returns it with ready-to-use playlist URLs. The token is only
+// shown once.
+func (h *Handler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create playlist token"})
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO playlist_tokens (user_id, token_hash, created_at) VALUES (?, ?, %[1]s)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = %[1]s, last_used_at = NULL
+	`, h.DB.NowUTC()), userID, hashToken(token)); err != nil {
+		log.Printf("create playlist token: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create playlist token"})
+		return
+	}
+	base := baseURL(r)
+	httputil.WriteJSON(w, 201, map[string]string{
+		"token":                   token,
+		"saved_playlist_url":      base + "/api/me/saved/playlist.m3u?token=" + token,
+		"collection_playlist_url": base + "/api/collections/{id}/playlist.m3u8?token=" + token,
+	})
+}
+
+// HandleRevokeToken deletes the user's playlist token, breaking every
+// playlist URL issued with it.
+func (h *Handler) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	if _, err := h.DB.ExecContext(r.Context(), `DELETE FROM playlist_tokens WHERE user_id = ?`, userID); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to revoke playlist token"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "revoked"})
+}
+
+// HandleSavedPlaylist serves the user's saved clips, newest first.
+func (h *Handler) HandleSavedPlaylist(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	entries, err := h.loadEntries(r.Context(), `
+		SELECT c.id, COALESCE(c.title, ''), c.storage_key, COALESCE(c.duration_seconds, 0)
+		FROM saved_clips sc
+		JOIN clips c ON sc.clip_id = c.id
+		WHERE sc.user_id = ? AND c.status = 'ready'
+		ORDER BY sc.created_at DESC
+		LIMIT ?
+	`, userID, maxEntries)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build playlist"})
+		return
+	}
+	h.writePlaylist(w, r, "ClipFeed Saved", entries)
+}
+
+// HandleCollectionPlaylist serves a collection in its display order. Only
+// the owner can fetch a private collection.
+func (h *Handler) HandleCollectionPlaylist(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	collectionID := chi.URLParam(r, "id")
+
+	var title string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT title FROM collections WHERE id = ? AND (user_id = ? OR is_public = 1)`, collectionID, userID,
+	).Scan(&title); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+		return
+	}
+	entries, err := h.loadEntries(r.Context(), `
+		SELECT c.id, COALESCE(c.title, ''), c.storage_key, COALESCE(c.duration_seconds, 0)
+		FROM collection_clips cc
+		JOIN clips c ON cc.clip_id = c.id
+		WHERE cc.collection_id = ? AND c.status = 'ready'
+		ORDER BY cc.position ASC, cc.added_at DESC
+		LIMIT ?
+	`, collectionID, maxEntries)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build playlist"})
+		return
+	}
+	h.writePlaylist(w, r, title, entries)
+}
+
+func (h *Handler) loadEntries(ctx context.Context, query string, args ...interface{}) ([]entry, error) {
+	rows, err := h.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		var storageKey *string
+		if err := rows.Scan(&e.id, &e.title, &storageKey, &e.duration); err != nil || storageKey == nil || *storageKey == "" {
+			continue
+		}
+		e.storageKey = *storageKey
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// m3uText keeps titles on one line; everything after the first comma of an
+// #EXTINF line is the title, so commas need no escaping.
+func m3uText(s string) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(strings.TrimSpace(s))
+	if s == "" {
+		return "Untitled"
+	}
+	return s
+}
+
+func (h *Handler) writePlaylist(w http.ResponseWriter, r *http.Request, title string, entries []entry) {
+	base := baseURL(r)
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#PLAYLIST:%s\n", m3uText(title))
+	for _, e := range entries {
+		streamURL, err := h.PresignStream(r.Context(), e.storageKey, streamURLExpiry)
+		if err != nil {
+			log.Printf("playlist: presign %s: %v", e.id, err)
+			continue
+		}
+		if strings.HasPrefix(streamURL, "/") {
+			streamURL = base + streamURL
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n%s\n", int(e.duration+0.5), m3uText(e.title), streamURL)
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,166 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+	"clipfeed/images"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxAvatarBytes caps the size of an uploaded avatar image.
+	MaxAvatarBytes = 5 << 20
+	// AvatarSize is the width and height avatars are stored at.
+	AvatarSize = 256
+	// maxAvatarPixels caps an upload's dimensions before it is decoded.
+	maxAvatarPixels = 4096 * 4096
+
+	avatarPrefix = "avatars/"
+)
+
+// avatarTypes are the image types accepted for upload.
+var avatarTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// avatarURL is where the avatar stored at key is served. The key is part of
+// the URL so a new upload is never served from a stale cache.
+func avatarURL(userID, key string) string {
+	version := strings.TrimSuffix(key[strings.LastIndex(key, "/")+1:], ".jpg")
+	return "/api/users/" + userID + "/avatar?v=" + version
+}
+
+// HandleUploadAvatar replaces the user's avatar with the image in the
+// "avatar" field of a multipart form. The image is cropped to a square and
+// stored as a JPEG of at most AvatarSize pixels a side.
+func (h *Handler) HandleUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	if h.Avatars == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "avatar storage unavailable"})
+		return
+	}
+
+	httputil.MaxBody(r, MaxAvatarBytes+64<<10)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httputil.WriteJSON(w, 413, map[string]string{"error": fmt.Sprintf("avatar must be at most %d MB", MaxAvatarBytes>>20)})
+			return
+		}
+		httputil.WriteJSON(w, 400, map[string]string{"error": "avatar file required"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, MaxAvatarBytes+1))
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "failed to read avatar"})
+		return
+	}
+	if len(data) > MaxAvatarBytes {
+		httputil.WriteJSON(w, 413, map[string]string{"error": fmt.Sprintf("avatar must be at most %d MB", MaxAvatarBytes>>20)})
+		return
+	}
+	if !avatarTypes[http.DetectContentType(data)] {
+		httputil.WriteJSON(w, 415, map[string]string{"error": "avatar must be a JPEG, PNG, or GIF image"})
+		return
+	}
+
+	resized, err := resizeAvatar(data)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	key := avatarPrefix + userID + "/" + uuid.New().String() + ".jpg"
+	if err := h.Avatars.Put(r.Context(), key, resized, "image/jpeg"); err != nil {
+		log.Printf("store avatar for %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store avatar"})
+		return
+	}
+	var oldKey *string
+	h.DB.QueryRowContext(r.Context(), `SELECT avatar_key FROM users WHERE id = ?`, userID).Scan(&oldKey)
+	url := avatarURL(userID, key)
+	if _, err := h.DB.ExecContext(r.Context(),
+		`UPDATE users SET avatar_key = ?, avatar_url = ? WHERE id = ?`, key, url, userID); err != nil {
+		h.Avatars.Remove(r.Context(), key)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save avatar"})
+		return
+	}
+	if oldKey != nil && *oldKey != "" {
+		if err := h.Avatars.Remove(r.Context(), *oldKey); err != nil {
+			log.Printf("remove old avatar %s: %v", *oldKey, err)
+		}
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"avatar_url": url})
+}
+
+// HandleDeleteAvatar removes the user's avatar.
+func (h *Handler) HandleDeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	var key *string
+	h.DB.QueryRowContext(r.Context(), `SELECT avatar_key FROM users WHERE id = ?`, userID).Scan(&key)
+	if _, err := h.DB.ExecContext(r.Context(),
+		`UPDATE users SET avatar_key = NULL, avatar_url = NULL WHERE id = ?`, userID); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to remove avatar"})
+		return
+	}
+	if key != nil && *key != "" && h.Avatars != nil {
+		if err := h.Avatars.Remove(r.Context(), *key); err != nil {
+			log.Printf("remove avatar %s: %v", *key, err)
+		}
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "removed"})
+}
+
+// HandleGetAvatar serves a user's uploaded avatar.
+func (h *Handler) HandleGetAvatar(w http.ResponseWriter, r *http.Request) {
+	var key *string
+	h.DB.QueryRowContext(r.Context(), `SELECT avatar_key FROM users WHERE id = ?`, chi.URLParam(r, "id")).Scan(&key)
+	if key == nil || *key == "" {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "no avatar"})
+		return
+	}
+	if h.Avatars == nil {
+		httputil.WriteJSON(w, 503, map[string]string{"error": "avatar storage unavailable"})
+		return
+	}
+	obj, err := h.Avatars.Get(r.Context(), *key)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "no avatar"})
+		return
+	}
+	defer obj.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	// Avatar URLs change with every upload, so a versioned one never goes stale.
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	io.Copy(w, obj)
+}
+
+// resizeAvatar decodes an uploaded image, crops it to its central square,
+// scales that down to at most AvatarSize a side, and encodes it as JPEG.
+func resizeAvatar(data []byte) ([]byte, error) {
+	src, err := images.Decode(data, maxAvatarPixels)
+	if errors.Is(err, images.ErrTooLarge) {
+		return nil, errors.New("avatar dimensions are too large")
+	}
+	if err != nil {
+		return nil, errors.New("avatar is not a readable image")
+	}
+	size := min(src.Bounds().Dx(), src.Bounds().Dy(), AvatarSize)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	images.Fill(dst, dst.Bounds(), src)
+	return images.EncodeJPEG(dst)
+}
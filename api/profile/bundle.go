@@ -0,0 +1,613 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/audit"
+	"clipfeed/auth"
+	"clipfeed/contentfilter"
+	"clipfeed/db"
+	"clipfeed/feed"
+	"clipfeed/httputil"
+	"clipfeed/settings"
+
+	"github.com/google/uuid"
+)
+
+// settingsBundleVersion is the settings bundle format export writes.
+// Import reads it and every earlier version.
+const settingsBundleVersion = 1
+
+const (
+	maxSettingsBundleBytes = 1 << 20
+	maxBundleItems         = 1000
+	maxAffinityWeight      = 10
+	maxSourceLen           = 32
+)
+
+// Settings bundle import modes.
+const (
+	// bundleMerge adds the bundle's entries to the user's, the bundle's
+	// winning where both have one for the same target.
+	bundleMerge = "merge"
+	// bundleReplace drops the user's entries of every section the bundle
+	// has before importing it. Sections the bundle leaves out are kept.
+	bundleReplace = "replace"
+)
+
+// settingsBundle carries a user's feed settings between accounts or
+// instances. Topics are named by slug so bundles work across instances. A
+// section left out or null, as opposed to an empty list, is not touched on
+// import.
+type settingsBundle struct {
+	Version    int    `json:"version"`
+	ExportedAt string `json:"exported_at,omitempty"`
+	// Preferences holds the preferences GET /api/me reports, less the
+	// read-only topic_affinities_decayed_at.
+	Preferences     map[string]interface{} `json:"preferences"`
+	Pacing          *feed.PacingPrefs      `json:"pacing"`
+	TopicAffinities []bundleAffinity       `json:"topic_affinities"`
+	FeedbackWeights []bundleFeedbackWeight `json:"feedback_weights"`
+	Snoozes         []bundleSnooze         `json:"snoozes"`
+	ContentFilters  []bundleContentFilter  `json:"content_filters"`
+	SavedFilters    []bundleSavedFilter    `json:"saved_filters"`
+}
+
+type bundleAffinity struct {
+	Topic  string  `json:"topic"`
+	Name   string  `json:"name,omitempty"`
+	Weight float64 `json:"weight"`
+	Source string  `json:"source,omitempty"`
+}
+
+// bundleFeedbackWeight is a "more/less like this" weight on a channel or
+// format.
+type bundleFeedbackWeight struct {
+	Dimension string  `json:"dimension"`
+	Target    string  `json:"target"`
+	Weight    float64 `json:"weight"`
+}
+
+// bundleSnooze is a snoozed topic, by slug, or channel, by name.
+type bundleSnooze struct {
+	Type      string `json:"type"`
+	Target    string `json:"target"`
+	Label     string `json:"label,omitempty"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type bundleContentFilter struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Pattern   string `json:"pattern"`
+	Threshold int    `json:"threshold"`
+}
+
+// bundleSavedFilter is a saved feed filter; the default one is pinned to
+// the feed.
+type bundleSavedFilter struct {
+	Name      string          `json:"name"`
+	Query     json.RawMessage `json:"query"`
+	IsDefault bool            `json:"is_default"`
+}
+
+// bundleSkip is an entry import left out, and why.
+type bundleSkip struct {
+	Section string `json:"section"`
+	Target  string `json:"target"`
+	Reason  string `json:"reason"`
+}
+
+// bundleError is a problem with the bundle itself, shown to the user.
+type bundleError struct{ msg string }
+
+func (e *bundleError) Error() string { return e.msg }
+
+func bundleErrorf(format string, args ...interface{}) error {
+	return &bundleError{msg: fmt.Sprintf(format, args...)}
+}
+
+// errDryRun rolls back a dry run's import.
+var errDryRun = errors.New("dry run")
+
+// HandleExportSettings downloads the user's preferences, pacing, topic
+// affinities, feedback weights, active snoozes, content filters and saved
+// filters as a versioned settings bundle.
+func (h *Handler) HandleExportSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	b, err := h.exportSettings(r.Context(), userID)
+	if err != nil {
+		log.Printf("export settings of %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to export settings"})
+		return
+	}
+	filename := "clipfeed-settings-" + time.Now().UTC().Format("20060102")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	httputil.WriteJSON(w, 200, b)
+}
+
+func (h *Handler) exportSettings(ctx context.Context, userID string) (*settingsBundle, error) {
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	b := &settingsBundle{
+		Version: settingsBundleVersion, ExportedAt: now,
+		TopicAffinities: []bundleAffinity{}, FeedbackWeights: []bundleFeedbackWeight{},
+		Snoozes: []bundleSnooze{}, ContentFilters: []bundleContentFilter{}, SavedFilters: []bundleSavedFilter{},
+	}
+	var err error
+	if b.Preferences, err = h.preferences(ctx, userID); err != nil {
+		return nil, fmt.Errorf("load preferences: %w", err)
+	}
+	delete(b.Preferences, "topic_affinities_decayed_at")
+	pacing := feed.LoadPacingPrefs(ctx, h.DB, userID)
+	b.Pacing = &pacing
+
+	// each runs query and scans every row with scan.
+	each := func(query string, scan func(scan func(...interface{}) error) error, args ...interface{}) error {
+		rows, err := h.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := scan(rows.Scan); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+	if err := each(`
+		SELECT t.slug, t.name, a.weight, a.source FROM user_topic_affinities a
+		JOIN topics t ON t.id = a.topic_id
+		WHERE a.user_id = ? ORDER BY t.slug
+	`, func(scan func(...interface{}) error) error {
+		var a bundleAffinity
+		err := scan(&a.Topic, &a.Name, &a.Weight, &a.Source)
+		b.TopicAffinities = append(b.TopicAffinities, a)
+		return err
+	}, userID); err != nil {
+		return nil, fmt.Errorf("load topic affinities: %w", err)
+	}
+	if err := each(`
+		SELECT dimension, target, weight FROM user_feedback_weights WHERE user_id = ? ORDER BY dimension, target
+	`, func(scan func(...interface{}) error) error {
+		var fw bundleFeedbackWeight
+		err := scan(&fw.Dimension, &fw.Target, &fw.Weight)
+		b.FeedbackWeights = append(b.FeedbackWeights, fw)
+		return err
+	}, userID); err != nil {
+		return nil, fmt.Errorf("load feedback weights: %w", err)
+	}
+	if err := each(`
+		SELECT s.target_type, COALESCE(t.slug, s.target_id), s.label, s.expires_at FROM user_snoozes s
+		LEFT JOIN topics t ON s.target_type = 'topic' AND t.id = s.target_id
+		WHERE s.user_id = ? AND s.expires_at > ? ORDER BY s.expires_at
+	`, func(scan func(...interface{}) error) error {
+		var s bundleSnooze
+		err := scan(&s.Type, &s.Target, &s.Label, &s.ExpiresAt)
+		b.Snoozes = append(b.Snoozes, s)
+		return err
+	}, userID, now); err != nil {
+		return nil, fmt.Errorf("load snoozes: %w", err)
+	}
+	filters, err := contentfilter.Load(ctx, h.DB, userID, false)
+	if err != nil {
+		return nil, fmt.Errorf("load content filters: %w", err)
+	}
+	for _, f := range filters {
+		b.ContentFilters = append(b.ContentFilters, bundleContentFilter{
+			Name: f.Name, Kind: f.Kind, Pattern: f.Pattern, Threshold: f.Threshold,
+		})
+	}
+	if err := each(`
+		SELECT name, query, is_default FROM saved_filters WHERE user_id = ? ORDER BY created_at, id
+	`, func(scan func(...interface{}) error) error {
+		var f bundleSavedFilter
+		var query string
+		var isDefault int
+		err := scan(&f.Name, &query, &isDefault)
+		f.Query, f.IsDefault = json.RawMessage(query), isDefault == 1
+		b.SavedFilters = append(b.SavedFilters, f)
+		return err
+	}, userID); err != nil {
+		return nil, fmt.Errorf("load saved filters: %w", err)
+	}
+	return b, nil
+}
+
+// HandleImportSettings applies a settings bundle to the user's account in
+// one transaction: either all of it is imported or, on any error, none.
+// ?mode=merge (the default) adds to the user's settings and ?mode=replace
+// replaces each section the bundle has. Entries that cannot apply here,
+// such as affinities for topics this instance lacks or expired snoozes,
+// are skipped and listed. ?dry_run=true reports what would be imported
+// without saving it.
+func (h *Handler) HandleImportSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = bundleMerge
+	}
+	if mode != bundleMerge && mode != bundleReplace {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "mode must be merge or replace"})
+		return
+	}
+	dryRun := q.Get("dry_run") == "true" || q.Get("dry_run") == "1"
+
+	var b settingsBundle
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsBundleBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid bundle: " + err.Error()})
+		return
+	}
+	if b.Version < 1 || b.Version > settingsBundleVersion {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf(
+			"unsupported bundle version %d; this server reads versions 1-%d", b.Version, settingsBundleVersion)})
+		return
+	}
+	prefs, err := validateBundle(&b)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var imported map[string]int
+	var skipped []bundleSkip
+	err = db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var err error
+		if imported, skipped, err = h.importSettings(r.Context(), conn, userID, mode, &b, prefs); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return audit.Record(r.Context(), conn, userID, "account.settings_import", userID,
+			map[string]interface{}{"mode": mode, "version": b.Version, "imported": imported, "skipped": len(skipped)})
+	})
+	var be *bundleError
+	switch {
+	case errors.As(err, &be):
+		httputil.WriteJSON(w, 400, map[string]string{"error": be.Error()})
+		return
+	case err != nil && !errors.Is(err, errDryRun):
+		log.Printf("import settings of %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to import settings"})
+		return
+	}
+	if !dryRun {
+		// Precomputed feed candidates were generated under the old settings.
+		h.DB.ExecContext(r.Context(), `DELETE FROM feed_candidates WHERE user_id = ?`, userID)
+	}
+	if skipped == nil {
+		skipped = []bundleSkip{}
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"mode": mode, "dry_run": dryRun, "version": b.Version, "imported": imported, "skipped": skipped,
+	})
+}
+
+// validateBundle checks every entry of b, normalising them in place, and
+// converts its preferences to column values. The error is shown to the
+// user.
+func validateBundle(b *settingsBundle) (map[string]interface{}, error) {
+	prefs := make(map[string]interface{}, len(b.Preferences))
+	for key, v := range b.Preferences {
+		if value, ok, err := settings.UserPreferenceValue(key, v); ok {
+			if err != nil {
+				return nil, fmt.Errorf("preferences: %s %v", key, err)
+			}
+			prefs[key] = value
+			continue
+		}
+		switch key {
+		case "data_saver", "scout_resurface_expired":
+			on, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("preferences: %s must be true or false", key)
+			}
+			prefs[key] = 0
+			if on {
+				prefs[key] = 1
+			}
+		case "ranking_preset":
+			name, _ := v.(string)
+			if _, known := feed.LookupPreset(name); v != nil && name != "" && !known {
+				return nil, fmt.Errorf("preferences: ranking_preset must be one of: %s", feed.PresetNames())
+			}
+			prefs[key] = nil
+			if name != "" {
+				prefs[key] = name
+			}
+		case "topic_weights":
+			weights, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("preferences: topic_weights must be an object")
+			}
+			encoded, _ := json.Marshal(weights)
+			prefs[key] = string(encoded)
+		default:
+			return nil, fmt.Errorf("preferences: unknown preference %q", key)
+		}
+	}
+	if b.Pacing != nil {
+		if err := b.Pacing.Validate(); err != nil {
+			return nil, fmt.Errorf("pacing: %v", err)
+		}
+	}
+
+	for name, n := range map[string]int{
+		"topic_affinities": len(b.TopicAffinities), "feedback_weights": len(b.FeedbackWeights),
+		"snoozes": len(b.Snoozes), "saved_filters": len(b.SavedFilters),
+	} {
+		if n > maxBundleItems {
+			return nil, fmt.Errorf("%s: at most %d entries", name, maxBundleItems)
+		}
+	}
+	if len(b.ContentFilters) > contentfilter.MaxUserFilters {
+		return nil, fmt.Errorf("content_filters: at most %d filters", contentfilter.MaxUserFilters)
+	}
+	for i := range b.TopicAffinities {
+		a := &b.TopicAffinities[i]
+		if a.Topic == "" {
+			return nil, fmt.Errorf("topic_affinities[%d]: topic is required", i)
+		}
+		if a.Weight < 0 || a.Weight > maxAffinityWeight {
+			return nil, fmt.Errorf("topic_affinities[%d]: weight must be between 0 and %d", i, maxAffinityWeight)
+		}
+		if a.Source == "" || len(a.Source) > maxSourceLen {
+			a.Source = "explicit"
+		}
+	}
+	for i, fw := range b.FeedbackWeights {
+		if fw.Dimension != "channel" && fw.Dimension != "format" {
+			return nil, fmt.Errorf("feedback_weights[%d]: dimension must be channel or format", i)
+		}
+		if fw.Target == "" || fw.Weight <= 0 || fw.Weight > maxAffinityWeight {
+			return nil, fmt.Errorf("feedback_weights[%d]: target is required and weight must be above 0 and at most %d", i, maxAffinityWeight)
+		}
+	}
+	for i, s := range b.Snoozes {
+		if s.Type != "topic" && s.Type != "channel" {
+			return nil, fmt.Errorf("snoozes[%d]: type must be topic or channel", i)
+		}
+		if s.Target == "" {
+			return nil, fmt.Errorf("snoozes[%d]: target is required", i)
+		}
+		if _, err := time.Parse("2006-01-02T15:04:05Z", s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("snoozes[%d]: expires_at must be a UTC timestamp like 2006-01-02T15:04:05Z", i)
+		}
+	}
+	for i, cf := range b.ContentFilters {
+		f := contentfilter.Filter{Name: cf.Name, Kind: cf.Kind, Pattern: cf.Pattern, Threshold: cf.Threshold}
+		if err := f.Compile(); err != nil {
+			return nil, fmt.Errorf("content_filters[%d]: %v", i, err)
+		}
+	}
+	defaults := 0
+	for i, sf := range b.SavedFilters {
+		var fq feed.FilterQuery
+		if strings.TrimSpace(sf.Name) == "" || json.Unmarshal(sf.Query, &fq) != nil {
+			return nil, fmt.Errorf("saved_filters[%d]: name and a valid query are required", i)
+		}
+		if sf.IsDefault {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return nil, errors.New("saved_filters: at most one filter can be the default")
+	}
+	return prefs, nil
+}
+
+// importSettings applies a validated bundle in conn's transaction,
+// returning how many entries of each section were imported and which were
+// skipped.
+func (h *Handler) importSettings(ctx context.Context, conn *db.CompatConn, userID, mode string,
+	b *settingsBundle, prefs map[string]interface{}) (map[string]int, []bundleSkip, error) {
+	imported := map[string]int{}
+	var skipped []bundleSkip
+	replace := mode == bundleReplace
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	// clearSection empties a section before a replace.
+	clearSection := func(query string) error {
+		if !replace {
+			return nil
+		}
+		_, err := conn.ExecContext(ctx, query, userID)
+		return err
+	}
+
+	if b.Preferences != nil {
+		if replace {
+			// Back to this instance's defaults, then the bundle's values.
+			s, err := settings.Load(ctx, conn)
+			if err != nil {
+				return nil, nil, fmt.Errorf("load settings: %w", err)
+			}
+			if _, err := conn.ExecContext(ctx, `DELETE FROM user_preferences WHERE user_id = ?`, userID); err != nil {
+				return nil, nil, err
+			}
+			if err := settings.InsertUserPreferences(ctx, conn, userID, s.DefaultPreferences); err != nil {
+				return nil, nil, err
+			}
+		}
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO user_preferences (user_id) VALUES (?) ON CONFLICT(user_id) DO NOTHING`, userID); err != nil {
+			return nil, nil, err
+		}
+		for key, value := range prefs {
+			// Keys were checked against the known columns in validateBundle.
+			if _, err := conn.ExecContext(ctx,
+				`UPDATE user_preferences SET `+key+` = ?, updated_at = ? WHERE user_id = ?`, value, now, userID); err != nil {
+				return nil, nil, fmt.Errorf("set %s: %w", key, err)
+			}
+		}
+		imported["preferences"] = len(prefs)
+	}
+	if b.Pacing != nil {
+		if err := feed.SavePacingPrefs(ctx, conn, userID, *b.Pacing); err != nil {
+			return nil, nil, fmt.Errorf("save pacing: %w", err)
+		}
+		imported["pacing"] = 1
+	}
+
+	// topicID resolves a bundle topic by slug or name.
+	topicID := func(topic string) (string, string, bool) {
+		var id, name string
+		err := conn.QueryRowContext(ctx,
+			`SELECT id, name FROM topics WHERE slug = ? OR LOWER(name) = LOWER(?) ORDER BY slug = ? DESC LIMIT 1`,
+			topic, topic, topic).Scan(&id, &name)
+		return id, name, err == nil
+	}
+
+	if b.TopicAffinities != nil {
+		if err := clearSection(`DELETE FROM user_topic_affinities WHERE user_id = ?`); err != nil {
+			return nil, nil, err
+		}
+		for _, a := range b.TopicAffinities {
+			id, _, ok := topicID(a.Topic)
+			if !ok {
+				skipped = append(skipped, bundleSkip{"topic_affinities", a.Topic, "unknown_topic"})
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, `
+				INSERT INTO user_topic_affinities (user_id, topic_id, weight, source, updated_at)
+				VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(user_id, topic_id) DO UPDATE SET
+					weight = excluded.weight, source = excluded.source, updated_at = excluded.updated_at
+			`, userID, id, a.Weight, a.Source, now); err != nil {
+				return nil, nil, fmt.Errorf("import topic affinity: %w", err)
+			}
+			imported["topic_affinities"]++
+		}
+	}
+
+	if b.FeedbackWeights != nil {
+		if err := clearSection(`DELETE FROM user_feedback_weights WHERE user_id = ?`); err != nil {
+			return nil, nil, err
+		}
+		for _, fw := range b.FeedbackWeights {
+			if _, err := conn.ExecContext(ctx, `
+				INSERT INTO user_feedback_weights (user_id, dimension, target, weight, updated_at)
+				VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(user_id, dimension, target) DO UPDATE SET weight = excluded.weight, updated_at = excluded.updated_at
+			`, userID, fw.Dimension, fw.Target, fw.Weight, now); err != nil {
+				return nil, nil, fmt.Errorf("import feedback weight: %w", err)
+			}
+			imported["feedback_weights"]++
+		}
+	}
+
+	if b.Snoozes != nil {
+		if err := clearSection(`DELETE FROM user_snoozes WHERE user_id = ?`); err != nil {
+			return nil, nil, err
+		}
+		latest := time.Now().UTC().AddDate(0, 0, maxSnoozeDays).Format("2006-01-02T15:04:05Z")
+		for _, s := range b.Snoozes {
+			if s.ExpiresAt <= now {
+				skipped = append(skipped, bundleSkip{"snoozes", s.Target, "expired"})
+				continue
+			}
+			targetID, label := s.Target, s.Label
+			if s.Type == "topic" {
+				var ok bool
+				if targetID, label, ok = topicID(s.Target); !ok {
+					skipped = append(skipped, bundleSkip{"snoozes", s.Target, "unknown_topic"})
+					continue
+				}
+			}
+			if label == "" {
+				label = targetID
+			}
+			expiresAt := min(s.ExpiresAt, latest)
+			if _, err := conn.ExecContext(ctx, `
+				INSERT INTO user_snoozes (id, user_id, target_type, target_id, label, expires_at, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET
+					label = excluded.label, expires_at = excluded.expires_at, created_at = excluded.created_at
+			`, uuid.New().String(), userID, s.Type, targetID, label, expiresAt, now); err != nil {
+				return nil, nil, fmt.Errorf("import snooze: %w", err)
+			}
+			imported["snoozes"]++
+		}
+	}
+
+	if b.ContentFilters != nil {
+		if replace {
+			// Matches are deleted explicitly for databases that do not
+			// enforce the cascade.
+			if _, err := conn.ExecContext(ctx, `
+				DELETE FROM clip_filter_matches WHERE filter_id IN (SELECT id FROM content_filters WHERE user_id = ?)
+			`, userID); err != nil {
+				return nil, nil, err
+			}
+			if err := clearSection(`DELETE FROM content_filters WHERE user_id = ?`); err != nil {
+				return nil, nil, err
+			}
+		}
+		existing, err := contentfilter.Load(ctx, conn, userID, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load content filters: %w", err)
+		}
+		have := make(map[string]bool, len(existing))
+		for _, f := range existing {
+			have[f.Kind+"\x00"+f.Pattern] = true
+		}
+		count := len(existing)
+		for _, cf := range b.ContentFilters {
+			if have[cf.Kind+"\x00"+cf.Pattern] {
+				skipped = append(skipped, bundleSkip{"content_filters", cf.Pattern, "duplicate"})
+				continue
+			}
+			if count >= contentfilter.MaxUserFilters {
+				return nil, nil, bundleErrorf("content_filters: at most %d filters; delete some or import with mode=replace", contentfilter.MaxUserFilters)
+			}
+			f := contentfilter.Filter{UserID: userID, Name: cf.Name, Kind: cf.Kind, Pattern: cf.Pattern, Threshold: cf.Threshold}
+			if _, err := contentfilter.Create(ctx, conn, &f); err != nil {
+				return nil, nil, fmt.Errorf("import content filter: %w", err)
+			}
+			have[cf.Kind+"\x00"+cf.Pattern] = true
+			count++
+			imported["content_filters"]++
+		}
+	}
+
+	if b.SavedFilters != nil {
+		if err := clearSection(`DELETE FROM saved_filters WHERE user_id = ?`); err != nil {
+			return nil, nil, err
+		}
+		for _, sf := range b.SavedFilters {
+			isDefault := 0
+			if sf.IsDefault {
+				isDefault = 1
+				if _, err := conn.ExecContext(ctx,
+					`UPDATE saved_filters SET is_default = 0 WHERE user_id = ? AND is_default = 1`, userID); err != nil {
+					return nil, nil, err
+				}
+			}
+			// A filter of the same name is updated rather than duplicated.
+			res, err := conn.ExecContext(ctx, `UPDATE saved_filters SET query = ?, is_default = ? WHERE user_id = ? AND name = ?`,
+				string(sf.Query), isDefault, userID, sf.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("import saved filter: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				if _, err := conn.ExecContext(ctx,
+					`INSERT INTO saved_filters (id, user_id, name, query, is_default) VALUES (?, ?, ?, ?, ?)`,
+					uuid.New().String(), userID, sf.Name, string(sf.Query), isDefault); err != nil {
+					return nil, nil, fmt.Errorf("import saved filter: %w", err)
+				}
+			}
+			imported["saved_filters"]++
+		}
+	}
+	return imported, skipped, nil
+}
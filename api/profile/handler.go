@@ -1,6 +1,7 @@
 package profile
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,7 +10,10 @@ import (
 	"clipfeed/auth"
 	"clipfeed/crypto"
 	"clipfeed/db"
+	"clipfeed/feed"
 	"clipfeed/httputil"
+	"clipfeed/images"
+	"clipfeed/scout"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -19,6 +23,8 @@ import (
 type Handler struct {
 	DB           *db.CompatDB
 	CookieSecret string
+	// Avatars stores uploaded avatars; uploads are unavailable without it.
+	Avatars images.Store
 }
 
 // HandleGetProfile returns the authenticated user's profile and preferences.
@@ -26,15 +32,39 @@ func (h *Handler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 
 	var username, email, displayName, createdAt string
-	var avatarURL *string
+	var avatarURL, pendingEmail *string
+	err := h.DB.QueryRowContext(r.Context(), `
+		SELECT username, email, pending_email, display_name, avatar_url, created_at FROM users WHERE id = ?
+	`, userID).Scan(&username, &email, &pendingEmail, &displayName, &avatarURL, &createdAt)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+	prefs, err := h.preferences(r.Context(), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
+		return
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"id": userID, "username": username, "email": email, "pending_email": pendingEmail,
+		"display_name": displayName, "avatar_url": avatarURL,
+		"created_at":  createdAt,
+		"preferences": prefs,
+	})
+}
+
+// preferences returns the user's feed and scout preferences as the profile
+// reports them, with defaults for those never set.
+func (h *Handler) preferences(ctx context.Context, userID string) (map[string]interface{}, error) {
 	var explorationRate, scoutThreshold, diversityMix, freshnessBias float64
 	var topicWeightsJSON string
 	var minClip, maxClip int
-	var autoplay, dedupeSeen24h, trendingBoost, scoutAutoIngest int
+	var autoplay, dedupeSeen24h, trendingBoost, scoutAutoIngest, lockAffinities, dataSaver, resurfaceExpired int
+	var affinitiesDecayedAt, rankingPreset string
 
-	err := h.DB.QueryRowContext(r.Context(), `
-		SELECT u.username, u.email, u.display_name, u.avatar_url, u.created_at,
-		       COALESCE(p.exploration_rate, 0.3),
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(p.exploration_rate, 0.3),
 		       COALESCE(p.topic_weights, '{}'),
 		       COALESCE(p.dedupe_seen_24h, 1),
 		       COALESCE(p.min_clip_seconds, 5),
@@ -44,17 +74,20 @@ func (h *Handler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
 		       COALESCE(p.scout_auto_ingest, 1),
 		       COALESCE(p.diversity_mix, 0.5),
 		       COALESCE(p.trending_boost, 1),
-		       COALESCE(p.freshness_bias, 0.5)
+		       COALESCE(p.freshness_bias, 0.5),
+		       COALESCE(p.lock_topic_affinities, 0),
+		       COALESCE(p.ranking_preset, ''),
+		       COALESCE(p.data_saver, 0),
+		       COALESCE(p.scout_resurface_expired, 0),
+		       COALESCE((SELECT MAX(decayed_at) FROM user_topic_affinities WHERE user_id = u.id), '')
 		FROM users u
 		LEFT JOIN user_preferences p ON u.id = p.user_id
 		WHERE u.id = ?
-	`, userID).Scan(&username, &email, &displayName, &avatarURL, &createdAt,
-		&explorationRate, &topicWeightsJSON, &dedupeSeen24h, &minClip, &maxClip, &autoplay, &scoutThreshold,
-		&scoutAutoIngest, &diversityMix, &trendingBoost, &freshnessBias)
-
+	`, userID).Scan(&explorationRate, &topicWeightsJSON, &dedupeSeen24h, &minClip, &maxClip, &autoplay, &scoutThreshold,
+		&scoutAutoIngest, &diversityMix, &trendingBoost, &freshnessBias, &lockAffinities, &rankingPreset, &dataSaver, &resurfaceExpired,
+		&affinitiesDecayedAt)
 	if err != nil {
-		httputil.WriteJSON(w, 404, map[string]string{"error": "user not found"})
-		return
+		return nil, err
 	}
 
 	var topicWeights map[string]interface{}
@@ -63,24 +96,35 @@ func (h *Handler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
 		topicWeights = make(map[string]interface{})
 	}
 
-	httputil.WriteJSON(w, 200, map[string]interface{}{
-		"id": userID, "username": username, "email": email,
-		"display_name": displayName, "avatar_url": avatarURL,
-		"created_at": createdAt,
-		"preferences": map[string]interface{}{
-			"exploration_rate":  explorationRate,
-			"topic_weights":     topicWeights,
-			"dedupe_seen_24h":   dedupeSeen24h == 1,
-			"min_clip_seconds":  minClip,
-			"max_clip_seconds":  maxClip,
-			"autoplay":          autoplay == 1,
-			"scout_threshold":   scoutThreshold,
-			"scout_auto_ingest": scoutAutoIngest == 1,
-			"diversity_mix":     diversityMix,
-			"trending_boost":    trendingBoost == 1,
-			"freshness_bias":    freshnessBias,
-		},
-	})
+	var preset interface{}
+	if rankingPreset != "" {
+		preset = rankingPreset
+	}
+
+	return map[string]interface{}{
+		"exploration_rate":  explorationRate,
+		"topic_weights":     topicWeights,
+		"dedupe_seen_24h":   dedupeSeen24h == 1,
+		"min_clip_seconds":  minClip,
+		"max_clip_seconds":  maxClip,
+		"autoplay":          autoplay == 1,
+		"scout_threshold":   scoutThreshold,
+		"scout_auto_ingest": scoutAutoIngest == 1,
+		"diversity_mix":     diversityMix,
+		"trending_boost":    trendingBoost == 1,
+		"freshness_bias":    freshnessBias,
+		// A saved ranking preset overrides the four ranking preferences.
+		"ranking_preset": preset,
+		// Learned topic affinities stop decaying while locked.
+		"lock_topic_affinities":       lockAffinities == 1,
+		"topic_affinities_decayed_at": affinitiesDecayedAt,
+		// Low-bitrate streams and small feed thumbnails; the
+		// X-Data-Saver header overrides it per request.
+		"data_saver": dataSaver == 1,
+		// Raising scout_threshold puts expired scout candidates
+		// back in the queue.
+		"scout_resurface_expired": resurfaceExpired == 1,
+	}, nil
 }
 
 // HandleUpdatePreferences updates the user's feed/scout preferences.
@@ -118,6 +162,56 @@ func (h *Handler) HandleUpdatePreferences(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	lockAffinities, hasLock := prefs["lock_topic_affinities"]
+	if hasLock && lockAffinities != nil {
+		if _, ok := lockAffinities.(bool); !ok {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "lock_topic_affinities must be true or false"})
+			return
+		}
+	}
+	dataSaver, hasDataSaver := prefs["data_saver"]
+	if hasDataSaver && dataSaver != nil {
+		if _, ok := dataSaver.(bool); !ok {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "data_saver must be true or false"})
+			return
+		}
+	}
+	resurface, hasResurface := prefs["scout_resurface_expired"]
+	if hasResurface && resurface != nil {
+		if _, ok := resurface.(bool); !ok {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "scout_resurface_expired must be true or false"})
+			return
+		}
+	}
+
+	// Expired scout candidates come back when the threshold goes up, so
+	// note the threshold and option as they were before this update.
+	oldThreshold, resurfaceOn := 6.0, 0
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT COALESCE(scout_threshold, 6.0), COALESCE(scout_resurface_expired, 0) FROM user_preferences WHERE user_id = ?`,
+		userID).Scan(&oldThreshold, &resurfaceOn)
+
+	// A saved ranking preset overrides the individual ranking preferences,
+	// so setting one of them without naming a preset clears it.
+	rankingPreset, hasPreset := prefs["ranking_preset"]
+	if hasPreset && rankingPreset != nil {
+		name, ok := rankingPreset.(string)
+		if _, known := feed.LookupPreset(name); !ok || (name != "" && !known) {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "ranking_preset must be one of: " + feed.PresetNames()})
+			return
+		}
+		if name == "" {
+			rankingPreset = nil
+		}
+	}
+	if !hasPreset {
+		for _, key := range []string{"exploration_rate", "diversity_mix", "freshness_bias", "trending_boost"} {
+			if v, ok := prefs[key]; ok && v != nil {
+				hasPreset = true
+			}
+		}
+	}
+
 	topicWeights, _ := json.Marshal(prefs["topic_weights"])
 
 	_, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
@@ -149,11 +243,50 @@ func (h *Handler) HandleUpdatePreferences(w http.ResponseWriter, r *http.Request
 		prefs["trending_boost"],
 		prefs["freshness_bias"],
 	)
+	if err == nil && hasLock && lockAffinities != nil {
+		locked := 0
+		if lockAffinities.(bool) {
+			locked = 1
+		}
+		_, err = h.DB.ExecContext(r.Context(),
+			`UPDATE user_preferences SET lock_topic_affinities = ? WHERE user_id = ?`, locked, userID)
+	}
+	if err == nil && hasDataSaver && dataSaver != nil {
+		on := 0
+		if dataSaver.(bool) {
+			on = 1
+		}
+		_, err = h.DB.ExecContext(r.Context(),
+			`UPDATE user_preferences SET data_saver = ? WHERE user_id = ?`, on, userID)
+	}
+	if err == nil && hasResurface && resurface != nil {
+		resurfaceOn = 0
+		if resurface.(bool) {
+			resurfaceOn = 1
+		}
+		_, err = h.DB.ExecContext(r.Context(),
+			`UPDATE user_preferences SET scout_resurface_expired = ? WHERE user_id = ?`, resurfaceOn, userID)
+	}
+	if err == nil && hasPreset {
+		_, err = h.DB.ExecContext(r.Context(),
+			`UPDATE user_preferences SET ranking_preset = ? WHERE user_id = ?`, rankingPreset, userID)
+	}
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update preferences"})
 		return
 	}
-	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
+	// Precomputed feed candidates were generated under the old preferences.
+	h.DB.ExecContext(r.Context(), `DELETE FROM feed_candidates WHERE user_id = ?`, userID)
+
+	resp := map[string]interface{}{"status": "updated"}
+	if threshold, ok := prefs["scout_threshold"].(float64); ok && threshold > oldThreshold && resurfaceOn == 1 {
+		n, err := scout.ResurfaceExpired(r.Context(), h.DB, userID)
+		if err != nil {
+			log.Printf("resurface expired scout candidates for %s: %v", userID, err)
+		}
+		resp["resurfaced_candidates"] = n
+	}
+	httputil.WriteJSON(w, 200, resp)
 }
 
 // ValidPlatforms lists supported cookie platforms.
@@ -161,7 +294,8 @@ var ValidPlatforms = map[string]bool{
 	"youtube": true, "tiktok": true, "instagram": true, "twitter": true,
 }
 
-// HandleSetCookie stores an encrypted platform cookie.
+// HandleSetCookie stores an encrypted platform cookie and the optional cap on
+// concurrent ingest jobs that may run under it.
 func (h *Handler) HandleSetCookie(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	platform := chi.URLParam(r, "platform")
@@ -172,12 +306,17 @@ func (h *Handler) HandleSetCookie(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		CookieStr string `json:"cookie_str"`
+		CookieStr     string `json:"cookie_str"`
+		MaxConcurrent *int   `json:"max_concurrent"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CookieStr == "" {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "cookie_str required"})
 		return
 	}
+	if req.MaxConcurrent != nil && *req.MaxConcurrent < 1 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "max_concurrent must be a positive integer"})
+		return
+	}
 
 	encrypted, err := crypto.EncryptCookie(req.CookieStr, h.CookieSecret)
 	if err != nil {
@@ -188,18 +327,19 @@ func (h *Handler) HandleSetCookie(w http.ResponseWriter, r *http.Request) {
 
 	cookieID := uuid.New().String()
 	_, err = h.DB.ExecContext(r.Context(), fmt.Sprintf(`
-		INSERT INTO platform_cookies (id, user_id, platform, cookie_str, is_active, updated_at)
-		VALUES (?, ?, ?, ?, 1, %s)
+		INSERT INTO platform_cookies (id, user_id, platform, cookie_str, max_concurrent, is_active, updated_at)
+		VALUES (?, ?, ?, ?, ?, 1, %s)
 		ON CONFLICT(user_id, platform) DO UPDATE SET
-			cookie_str = excluded.cookie_str,
-			is_active  = 1,
-			updated_at = %s
-	`, h.DB.NowUTC(), h.DB.NowUTC()), cookieID, userID, platform, encrypted)
+			cookie_str     = excluded.cookie_str,
+			max_concurrent = excluded.max_concurrent,
+			is_active      = 1,
+			updated_at     = %s
+	`, h.DB.NowUTC(), h.DB.NowUTC()), cookieID, userID, platform, encrypted, req.MaxConcurrent)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save cookie"})
 		return
 	}
-	httputil.WriteJSON(w, 200, map[string]string{"status": "saved", "platform": platform})
+	httputil.WriteJSON(w, 200, map[string]interface{}{"status": "saved", "platform": platform, "max_concurrent": req.MaxConcurrent})
 }
 
 // HandleDeleteCookie deactivates a platform cookie.
@@ -229,13 +369,14 @@ func (h *Handler) HandleListCookieStatus(w http.ResponseWriter, r *http.Request)
 	statuses := map[string]map[string]interface{}{}
 	for platform := range ValidPlatforms {
 		statuses[platform] = map[string]interface{}{
-			"saved":      false,
-			"updated_at": nil,
+			"saved":          false,
+			"updated_at":     nil,
+			"max_concurrent": nil,
 		}
 	}
 
 	rows, err := h.DB.QueryContext(r.Context(),
-		`SELECT platform, updated_at FROM platform_cookies WHERE user_id = ? AND is_active = 1`,
+		`SELECT platform, updated_at, max_concurrent FROM platform_cookies WHERE user_id = ? AND is_active = 1`,
 		userID,
 	)
 	if err != nil {
@@ -246,13 +387,15 @@ func (h *Handler) HandleListCookieStatus(w http.ResponseWriter, r *http.Request)
 
 	for rows.Next() {
 		var platform, updatedAt string
-		if rows.Scan(&platform, &updatedAt) != nil {
+		var maxConcurrent *int
+		if rows.Scan(&platform, &updatedAt, &maxConcurrent) != nil {
 			continue
 		}
 		if _, ok := statuses[platform]; ok {
 			statuses[platform] = map[string]interface{}{
-				"saved":      true,
-				"updated_at": updatedAt,
+				"saved":          true,
+				"updated_at":     updatedAt,
+				"max_concurrent": maxConcurrent,
 			}
 		}
 	}
@@ -0,0 +1,141 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSnoozeDays = 7
+	maxSnoozeDays     = 90
+)
+
+// HandleSnooze hides a topic or channel from the user's feed for a number of
+// days. Snoozing an already snoozed target replaces its expiry.
+func (h *Handler) HandleSnooze(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+
+	var req struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Days int    `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "type and id required"})
+		return
+	}
+	if req.Days == 0 {
+		req.Days = defaultSnoozeDays
+	}
+	if req.Days < 1 || req.Days > maxSnoozeDays {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("days must be between 1 and %d", maxSnoozeDays)})
+		return
+	}
+
+	// Topics may be given by ID, slug, or name; channels by channel name.
+	targetID, label := req.ID, req.ID
+	switch req.Type {
+	case "topic":
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT id, name FROM topics WHERE id = ? OR slug = ? OR LOWER(name) = LOWER(?)`, req.ID, req.ID, req.ID,
+		).Scan(&targetID, &label); err != nil {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "topic not found"})
+			return
+		}
+	case "channel":
+		var n int
+		if err := h.DB.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM sources WHERE channel_name = ?`, req.ID,
+		).Scan(&n); err != nil || n == 0 {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "channel not found"})
+			return
+		}
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": "type must be topic or channel"})
+		return
+	}
+
+	expiresAt := time.Now().UTC().AddDate(0, 0, req.Days).Format("2006-01-02T15:04:05Z")
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO user_snoozes (id, user_id, target_type, target_id, label, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, %s)
+		ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET
+			label = excluded.label, expires_at = excluded.expires_at, created_at = excluded.created_at
+	`, h.DB.NowUTC()), uuid.New().String(), userID, req.Type, targetID, label, expiresAt); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save snooze"})
+		return
+	}
+
+	var snoozeID string
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT id FROM user_snoozes WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+		userID, req.Type, targetID,
+	).Scan(&snoozeID)
+
+	// Precomputed candidate lists bake in the snoozes active when they were
+	// built, so rebuild after any change.
+	h.DB.ExecContext(r.Context(), `DELETE FROM feed_candidates WHERE user_id = ?`, userID)
+
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"id": snoozeID, "type": req.Type, "target_id": targetID, "label": label, "expires_at": expiresAt,
+	})
+}
+
+// HandleListSnoozes returns the user's active snoozes, soonest to expire
+// first, pruning expired ones.
+func (h *Handler) HandleListSnoozes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	now := h.DB.NowUTC()
+
+	h.DB.ExecContext(r.Context(), fmt.Sprintf(`DELETE FROM user_snoozes WHERE user_id = ? AND expires_at <= %s`, now), userID)
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, target_type, target_id, label, expires_at, created_at
+		FROM user_snoozes WHERE user_id = ?
+		ORDER BY expires_at ASC
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list snoozes"})
+		return
+	}
+	defer rows.Close()
+
+	snoozes := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, targetType, targetID, label, expiresAt, createdAt string
+		if rows.Scan(&id, &targetType, &targetID, &label, &expiresAt, &createdAt) != nil {
+			continue
+		}
+		snoozes = append(snoozes, map[string]interface{}{
+			"id": id, "type": targetType, "target_id": targetID, "label": label,
+			"expires_at": expiresAt, "created_at": createdAt,
+		})
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"snoozes": snoozes})
+}
+
+// HandleCancelSnooze ends a snooze early.
+func (h *Handler) HandleCancelSnooze(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	snoozeID := chi.URLParam(r, "id")
+
+	res, err := h.DB.ExecContext(r.Context(), `DELETE FROM user_snoozes WHERE id = ? AND user_id = ?`, snoozeID, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to cancel snooze"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "snooze not found"})
+		return
+	}
+	h.DB.ExecContext(r.Context(), `DELETE FROM feed_candidates WHERE user_id = ?`, userID)
+	httputil.WriteJSON(w, 200, map[string]string{"status": "cancelled"})
+}
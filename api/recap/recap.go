@@ -0,0 +1,337 @@
+// Package recap summarises a user's week: how long they watched, their top
+// topics, the clip they rewatched most, channels they watched for the first
+// time, and how many clips they saved. Recaps are computed from interactions
+// and cached in user_recaps, and once a week each user who opted in to
+// notifications is sent the recap of the week just ended.
+package recap
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+const (
+	// PeriodWeek is the only recap period: Monday 00:00 UTC to the next.
+	PeriodWeek = "week"
+
+	// openTTL is how long the recap of a week still in progress is cached.
+	openTTL = time.Hour
+	// topTopics caps the topics a recap lists.
+	topTopics = 5
+	// sendInterval is how often the background loop looks for users due
+	// the recap of the week just ended.
+	sendInterval = time.Hour
+
+	timeLayout = "2006-01-02T15:04:05Z"
+	dateLayout = "2006-01-02"
+)
+
+// Handler serves and sends recaps.
+type Handler struct {
+	DB *db.CompatDB
+	// Notify records and delivers a notification; the weekly recap is not
+	// sent when it is nil.
+	Notify func(ctx context.Context, userID, kind, subject, body string, payload []byte) error
+}
+
+// Recap summarises one user's activity over a period.
+type Recap struct {
+	Period        string         `json:"period"`
+	Start         string         `json:"start"`
+	End           string         `json:"end"`
+	Complete      bool           `json:"complete"`
+	WatchSeconds  float64        `json:"watch_seconds"`
+	ClipsWatched  int            `json:"clips_watched"`
+	TopTopics     []TopicCount   `json:"top_topics"`
+	MostRewatched *RewatchedClip `json:"most_rewatched"`
+	NewChannels   []string       `json:"new_channels"`
+	SavesAdded    int            `json:"saves_added"`
+	ComputedAt    string         `json:"computed_at"`
+}
+
+// TopicCount is how many of the clips watched were tagged with a topic.
+type TopicCount struct {
+	Topic string `json:"topic"`
+	Clips int    `json:"clips"`
+}
+
+// RewatchedClip is the clip viewed most often, if any was viewed twice.
+type RewatchedClip struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Views int    `json:"views"`
+}
+
+// WeekStart returns the start of the week containing t.
+func WeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// Get returns userID's recap of the week starting at start, from the cache
+// when it is still valid.
+func (h *Handler) Get(ctx context.Context, userID string, start, now time.Time) (Recap, error) {
+	startKey := start.Format(dateLayout)
+	var data, computedAt string
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT data, computed_at FROM user_recaps WHERE user_id = ? AND period = ? AND period_start = ?
+	`, userID, PeriodWeek, startKey).Scan(&data, &computedAt)
+	if err == nil {
+		var rc Recap
+		if json.Unmarshal([]byte(data), &rc) == nil {
+			computed, _ := time.Parse(timeLayout, computedAt)
+			if rc.Complete || now.Sub(computed) < openTTL {
+				return rc, nil
+			}
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Recap{}, err
+	}
+
+	rc, err := h.compute(ctx, userID, start, now)
+	if err != nil {
+		return Recap{}, err
+	}
+	encoded, _ := json.Marshal(rc)
+	if _, err := h.DB.ExecContext(ctx, `
+		INSERT INTO user_recaps (user_id, period, period_start, data, computed_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, period, period_start) DO UPDATE SET
+			data = excluded.data, computed_at = excluded.computed_at
+	`, userID, PeriodWeek, startKey, string(encoded), rc.ComputedAt); err != nil {
+		log.Printf("recap: cache recap for user %s: %v", userID, err)
+	}
+	return rc, nil
+}
+
+// compute builds the recap of the week starting at start from interactions
+// and saves.
+func (h *Handler) compute(ctx context.Context, userID string, start, now time.Time) (Recap, error) {
+	end := start.AddDate(0, 0, 7)
+	from, to := start.Format(timeLayout), end.Format(timeLayout)
+	rc := Recap{
+		Period: PeriodWeek, Start: start.Format(dateLayout), End: end.Format(dateLayout),
+		Complete: !now.Before(end), TopTopics: make([]TopicCount, 0), NewChannels: make([]string, 0),
+		ComputedAt: now.UTC().Format(timeLayout),
+	}
+
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(watch_duration_seconds), 0), COUNT(DISTINCT clip_id)
+		FROM interactions
+		WHERE user_id = ? AND action = 'view' AND created_at >= ? AND created_at < ?
+	`, userID, from, to).Scan(&rc.WatchSeconds, &rc.ClipsWatched); err != nil {
+		return rc, fmt.Errorf("watch time: %w", err)
+	}
+
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT COALESCE(c.topics, '[]') FROM clips c
+		WHERE c.id IN (
+			SELECT clip_id FROM interactions
+			WHERE user_id = ? AND action = 'view' AND created_at >= ? AND created_at < ?)
+	`, userID, from, to)
+	if err != nil {
+		return rc, fmt.Errorf("topics: %w", err)
+	}
+	counts := make(map[string]int)
+	for rows.Next() {
+		var raw string
+		var topics []string
+		if rows.Scan(&raw) == nil && json.Unmarshal([]byte(raw), &topics) == nil {
+			for _, t := range topics {
+				counts[t]++
+			}
+		}
+	}
+	rows.Close()
+	for t, n := range counts {
+		rc.TopTopics = append(rc.TopTopics, TopicCount{Topic: t, Clips: n})
+	}
+	sort.Slice(rc.TopTopics, func(i, j int) bool {
+		if rc.TopTopics[i].Clips != rc.TopTopics[j].Clips {
+			return rc.TopTopics[i].Clips > rc.TopTopics[j].Clips
+		}
+		return rc.TopTopics[i].Topic < rc.TopTopics[j].Topic
+	})
+	if len(rc.TopTopics) > topTopics {
+		rc.TopTopics = rc.TopTopics[:topTopics]
+	}
+
+	var clip RewatchedClip
+	err = h.DB.QueryRowContext(ctx, `
+		SELECT i.clip_id, COALESCE(c.title, ''), COUNT(*)
+		FROM interactions i JOIN clips c ON c.id = i.clip_id
+		WHERE i.user_id = ? AND i.action = 'view' AND i.created_at >= ? AND i.created_at < ?
+		GROUP BY i.clip_id, c.title
+		HAVING COUNT(*) > 1
+		ORDER BY COUNT(*) DESC, MAX(i.created_at) DESC
+		LIMIT 1
+	`, userID, from, to).Scan(&clip.ID, &clip.Title, &clip.Views)
+	switch {
+	case err == nil:
+		rc.MostRewatched = &clip
+	case !errors.Is(err, sql.ErrNoRows):
+		return rc, fmt.Errorf("most rewatched: %w", err)
+	}
+
+	// A channel is new when none of its clips were viewed before the week.
+	rows, err = h.DB.QueryContext(ctx, `
+		SELECT DISTINCT s.channel_name
+		FROM interactions i
+		JOIN clips c ON c.id = i.clip_id
+		JOIN sources s ON s.id = c.source_id
+		WHERE i.user_id = ? AND i.action = 'view' AND i.created_at >= ? AND i.created_at < ?
+		  AND COALESCE(s.channel_name, '') != ''
+		  AND NOT EXISTS (
+		      SELECT 1 FROM interactions pi
+		      JOIN clips pc ON pc.id = pi.clip_id
+		      JOIN sources ps ON ps.id = pc.source_id
+		      WHERE pi.user_id = i.user_id AND pi.action = 'view' AND pi.created_at < ?
+		        AND ps.channel_name = s.channel_name)
+		ORDER BY s.channel_name
+	`, userID, from, to, from)
+	if err != nil {
+		return rc, fmt.Errorf("new channels: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			rc.NewChannels = append(rc.NewChannels, name)
+		}
+	}
+	rows.Close()
+
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM saved_clips WHERE user_id = ? AND created_at >= ? AND created_at < ?
+	`, userID, from, to).Scan(&rc.SavesAdded); err != nil {
+		return rc, fmt.Errorf("saves: %w", err)
+	}
+	return rc, nil
+}
+
+// Summary renders rc as a notification subject and plain-text body.
+func Summary(rc Recap) (string, string) {
+	subject := "Your week in clips"
+	minutes := int(rc.WatchSeconds/60 + 0.5)
+	lines := []string{fmt.Sprintf("Week of %s: you watched %d clips for %d minutes.", rc.Start, rc.ClipsWatched, minutes)}
+	if len(rc.TopTopics) > 0 {
+		names := make([]string, len(rc.TopTopics))
+		for i, t := range rc.TopTopics {
+			names[i] = t.Topic
+		}
+		lines = append(lines, "Top topics: "+strings.Join(names, ", ")+".")
+	}
+	if rc.MostRewatched != nil {
+		lines = append(lines, fmt.Sprintf("Most rewatched: %s (%d views).", rc.MostRewatched.Title, rc.MostRewatched.Views))
+	}
+	if n := len(rc.NewChannels); n > 0 {
+		lines = append(lines, fmt.Sprintf("New channels: %d, including %s.", n, rc.NewChannels[0]))
+	}
+	if rc.SavesAdded > 0 {
+		lines = append(lines, fmt.Sprintf("Clips saved: %d.", rc.SavesAdded))
+	}
+	return subject, strings.Join(lines, "\n")
+}
+
+// HandleGetRecap returns the user's recap. period must be week (the
+// default); start=YYYY-MM-DD picks the week containing that day, and
+// without it the last complete week is returned.
+func (h *Handler) HandleGetRecap(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	if p := r.URL.Query().Get("period"); p != "" && p != PeriodWeek {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "period must be week"})
+		return
+	}
+	now := time.Now().UTC()
+	start := WeekStart(now).AddDate(0, 0, -7)
+	if s := r.URL.Query().Get("start"); s != "" {
+		day, err := time.Parse(dateLayout, s)
+		if err != nil || day.After(now) {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "start must be a past date in YYYY-MM-DD form"})
+			return
+		}
+		start = WeekStart(day)
+	}
+	rc, err := h.Get(r.Context(), userID, start, now)
+	if err != nil {
+		log.Printf("recap: user %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build recap"})
+		return
+	}
+	httputil.WriteJSON(w, 200, rc)
+}
+
+// SendWeekly sends the recap of the week before now's to every user who
+// watched something that week, enabled email or push notifications for
+// everything, and has not been sent it yet. It returns how many were sent.
+func (h *Handler) SendWeekly(ctx context.Context, now time.Time) (int, error) {
+	if h.Notify == nil {
+		return 0, nil
+	}
+	end := WeekStart(now)
+	start := end.AddDate(0, 0, -7)
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT p.user_id FROM notification_preferences p
+		WHERE (p.email_enabled = 1 OR p.push_enabled = 1) AND COALESCE(p.notify_on, 'all') = 'all'
+		  AND EXISTS (
+		      SELECT 1 FROM interactions i
+		      WHERE i.user_id = p.user_id AND i.action = 'view' AND i.created_at >= ? AND i.created_at < ?)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM notifications n
+		      WHERE n.user_id = p.user_id AND n.kind = 'weekly_recap' AND n.created_at >= ?)
+	`, start.Format(timeLayout), end.Format(timeLayout), end.Format(timeLayout))
+	if err != nil {
+		return 0, err
+	}
+	var users []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			users = append(users, id)
+		}
+	}
+	rows.Close()
+
+	sent := 0
+	for _, userID := range users {
+		rc, err := h.Get(ctx, userID, start, now)
+		if err != nil {
+			log.Printf("recap: user %s: %v", userID, err)
+			continue
+		}
+		subject, body := Summary(rc)
+		payload, _ := json.Marshal(map[string]interface{}{"period": rc.Period, "start": rc.Start})
+		if err := h.Notify(ctx, userID, "weekly_recap", subject, body, payload); err != nil {
+			log.Printf("recap: send to user %s: %v", userID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// SendLoop sends weekly recaps as each week ends.
+func (h *Handler) SendLoop() {
+	ticker := time.NewTicker(sendInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := h.SendWeekly(context.Background(), time.Now().UTC())
+		if err != nil {
+			log.Printf("recap: weekly recaps: %v", err)
+		}
+		if n > 0 {
+			log.Printf("recap: sent %d weekly recaps", n)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package recap
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+// seed gives user u1 a week (starting Monday 2024-06-03) of viewing on top
+// of one earlier view of channel Old.
+func seed(t *testing.T, cdb *db.CompatDB) {
+	t.Helper()
+	for _, q := range []string{
+		`INSERT INTO users (id, username, email, password_hash) VALUES ('u1', 'u1', 'u1@test.com', 'x')`,
+		`INSERT INTO sources (id, url, platform, channel_name) VALUES ('s-old', 'http://o', 'youtube', 'Old')`,
+		`INSERT INTO sources (id, url, platform, channel_name) VALUES ('s-new', 'http://n', 'youtube', 'New')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, topics) VALUES ('c1', 's-old', 'Knife skills', 60, 'k', 'ready', '["cooking","knives"]')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, topics) VALUES ('c2', 's-new', 'Sourdough', 60, 'k', 'ready', '["cooking","baking"]')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, topics) VALUES ('c3', 's-new', 'Synths', 60, 'k', 'ready', '["music"]')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i0', 'u1', 'c1', 'view', 60, '2024-05-20T10:00:00Z')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i1', 'u1', 'c1', 'view', 60, '2024-06-03T10:00:00Z')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i2', 'u1', 'c2', 'view', 50, '2024-06-04T10:00:00Z')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i3', 'u1', 'c2', 'view', 60, '2024-06-05T10:00:00Z')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i4', 'u1', 'c2', 'view', 30, '2024-06-09T23:00:00Z')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES ('i5', 'u1', 'c3', 'like', '2024-06-05T10:00:00Z')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i6', 'u1', 'c3', 'view', 40, '2024-06-10T00:00:00Z')`,
+		`INSERT INTO saved_clips (user_id, clip_id, created_at) VALUES ('u1', 'c2', '2024-06-04T11:00:00Z')`,
+		`INSERT INTO saved_clips (user_id, clip_id, created_at) VALUES ('u1', 'c1', '2024-05-01T11:00:00Z')`,
+	} {
+		if _, err := cdb.Exec(q); err != nil {
+			t.Fatalf("seed %q: %v", q, err)
+		}
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	for in, want := range map[string]string{
+		"2024-06-03T00:00:00Z": "2024-06-03", // Monday
+		"2024-06-09T23:59:59Z": "2024-06-03", // Sunday
+		"2024-06-10T00:00:00Z": "2024-06-10",
+	} {
+		tm, _ := time.Parse(timeLayout, in)
+		if got := WeekStart(tm).Format(dateLayout); got != want {
+			t.Errorf("WeekStart(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestGet_SummarisesTheWeekAndCaches(t *testing.T) {
+	cdb := newTestDB(t)
+	seed(t, cdb)
+	h := &Handler{DB: cdb}
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 6, 12, 0, 0, 0, 0, time.UTC)
+
+	rc, err := h.Get(context.Background(), "u1", start, now)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if rc.WatchSeconds != 200 || rc.ClipsWatched != 2 || rc.SavesAdded != 1 || !rc.Complete || rc.End != "2024-06-10" {
+		t.Errorf("recap = %+v, want 200s over 2 clips, 1 save, complete", rc)
+	}
+	if len(rc.TopTopics) != 3 || rc.TopTopics[0] != (TopicCount{"cooking", 2}) || rc.TopTopics[1].Topic != "baking" {
+		t.Errorf("top topics = %+v, want cooking (2) first, then baking", rc.TopTopics)
+	}
+	if rc.MostRewatched == nil || rc.MostRewatched.ID != "c2" || rc.MostRewatched.Views != 3 {
+		t.Errorf("most rewatched = %+v, want c2 with 3 views", rc.MostRewatched)
+	}
+	if strings.Join(rc.NewChannels, ",") != "New" {
+		t.Errorf("new channels = %v, want [New]", rc.NewChannels)
+	}
+
+	// A complete week is served from the cache.
+	cdb.Exec(`INSERT INTO saved_clips (user_id, clip_id, created_at) VALUES ('u1', 'c3', '2024-06-05T11:00:00Z')`)
+	if again, _ := h.Get(context.Background(), "u1", start, now.Add(48*time.Hour)); again.SavesAdded != 1 {
+		t.Errorf("cached recap has %d saves, want 1", again.SavesAdded)
+	}
+
+	// The week in progress is recomputed once its cache is stale.
+	open := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	first, _ := h.Get(context.Background(), "u1", open, now)
+	cdb.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('i7', 'u1', 'c1', 'view', 60, '2024-06-11T00:00:00Z')`)
+	if rc, _ := h.Get(context.Background(), "u1", open, now.Add(time.Minute)); rc.ClipsWatched != first.ClipsWatched || rc.Complete {
+		t.Errorf("fresh open recap recomputed: %+v", rc)
+	}
+	if rc, _ := h.Get(context.Background(), "u1", open, now.Add(2*time.Hour)); rc.ClipsWatched != 2 {
+		t.Errorf("stale open recap watched %d clips, want 2", rc.ClipsWatched)
+	}
+}
+
+func TestSendWeekly_OncePerOptedInUser(t *testing.T) {
+	cdb := newTestDB(t)
+	seed(t, cdb)
+	cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES ('u2', 'u2', 'u2@test.com', 'x')`)
+	cdb.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, watch_duration_seconds, created_at) VALUES ('j1', 'u2', 'c1', 'view', 60, '2024-06-04T10:00:00Z')`)
+	cdb.Exec(`INSERT INTO notification_preferences (user_id, email_enabled, push_enabled, notify_on) VALUES ('u1', 1, 0, 'all')`)
+	cdb.Exec(`INSERT INTO notification_preferences (user_id, email_enabled, push_enabled, notify_on) VALUES ('u2', 1, 0, 'failures')`)
+
+	var sent []string
+	h := &Handler{DB: cdb, Notify: func(ctx context.Context, userID, kind, subject, body string, payload []byte) error {
+		sent = append(sent, userID)
+		cdb.Exec(`INSERT INTO notifications (id, user_id, kind, title, body, payload) VALUES (?, ?, ?, ?, ?, ?)`,
+			"n-"+userID, userID, kind, subject, body, string(payload))
+		if !strings.Contains(body, "you watched 2 clips for 3 minutes") || !strings.Contains(body, "Most rewatched: Sourdough (3 views)") {
+			t.Errorf("recap body = %q", body)
+		}
+		return nil
+	}}
+	now := time.Now().UTC()
+	// Move the seeded week to the one just ended.
+	shift := WeekStart(now).AddDate(0, 0, -7).Sub(time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC))
+	rows, _ := cdb.Query(`SELECT id, created_at FROM interactions`)
+	type ts struct{ id, at string }
+	var all []ts
+	for rows.Next() {
+		var r ts
+		rows.Scan(&r.id, &r.at)
+		all = append(all, r)
+	}
+	rows.Close()
+	for _, r := range all {
+		at, _ := time.Parse(timeLayout, r.at)
+		cdb.Exec(`UPDATE interactions SET created_at = ? WHERE id = ?`, at.Add(shift).Format(timeLayout), r.id)
+	}
+
+	if n, err := h.SendWeekly(context.Background(), now); err != nil || n != 1 || strings.Join(sent, ",") != "u1" {
+		t.Fatalf("SendWeekly = %d, %v (sent to %v); want only u1", n, err, sent)
+	}
+	if n, _ := h.SendWeekly(context.Background(), now); n != 0 {
+		t.Errorf("second SendWeekly sent %d, want 0", n)
+	}
+}
@@ -0,0 +1,221 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/httputil"
+	"clipfeed/settings"
+)
+
+const (
+	timeLayout = "2006-01-02T15:04:05Z"
+
+	// deleteBatch bounds the rows one delete removes, so a large backlog
+	// never holds a long write lock.
+	deleteBatch = 1000
+)
+
+// Policy is how long one content type is kept.
+type Policy struct {
+	Type string `json:"type"`
+	// Days is the retention period; 0 keeps the type forever.
+	Days int `json:"days"`
+	// Source is "settings" when an admin set the period and "default"
+	// otherwise.
+	Source string `json:"source"`
+}
+
+// TypeResult reports what one run removed of one content type.
+type TypeResult struct {
+	Type    string `json:"type"`
+	Days    int    `json:"days"`
+	Cutoff  string `json:"cutoff,omitempty"`
+	Removed int64  `json:"removed"`
+}
+
+// Policies returns the effective policy of every type in
+// settings.RetentionTypes. Interactions default to h.Retention; every other
+// type is kept forever until an admin sets a period.
+func (h *Handler) Policies(ctx context.Context) ([]Policy, error) {
+	s, err := settings.Load(ctx, h.DB)
+	if err != nil {
+		return nil, fmt.Errorf("load settings: %w", err)
+	}
+	policies := make([]Policy, 0, len(settings.RetentionTypes))
+	for _, typ := range settings.RetentionTypes {
+		p := Policy{Type: typ, Source: "default"}
+		if days, ok := s.Retention[typ]; ok {
+			p.Days, p.Source = days, "settings"
+		} else if typ == settings.RetainInteractions {
+			p.Days = int(h.Retention.Hours() / 24)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func (h *Handler) policy(ctx context.Context, typ string) (Policy, error) {
+	policies, err := h.Policies(ctx)
+	if err != nil {
+		return Policy{}, err
+	}
+	for _, p := range policies {
+		if p.Type == typ {
+			return p, nil
+		}
+	}
+	return Policy{}, fmt.Errorf("unknown retention type %q", typ)
+}
+
+// cutoff returns the time before which data of p is removed.
+func (p Policy) cutoff(now time.Time) string {
+	if p.Type == settings.RetainInteractions {
+		return interactionCutoff(now, p.Days)
+	}
+	return now.UTC().AddDate(0, 0, -p.Days).Format(timeLayout)
+}
+
+// terminalJobs are the job statuses that never change again; only jobs in
+// one of them are removed.
+const terminalJobs = `('complete', 'failed', 'rejected', 'cancelled')`
+
+// pendingQuery counts and dates the rows of a type older than a cutoff.
+var pendingQuery = map[string]string{
+	settings.RetainImpressions: `SELECT COUNT(*), MIN(created_at) FROM bandit_impressions WHERE created_at < ?`,
+	settings.RetainJobs: `SELECT COUNT(*), MIN(COALESCE(completed_at, created_at)) FROM jobs
+		WHERE COALESCE(completed_at, created_at) < ? AND status IN ` + terminalJobs,
+	settings.RetainAuditLog: `SELECT COUNT(*), MIN(created_at) FROM audit_log WHERE created_at < ?`,
+	settings.RetainClips: `SELECT COUNT(*), MIN(created_at) FROM clips
+		WHERE created_at < ? AND status = 'ready' AND is_protected = 0`,
+	settings.RetainInteractions: `SELECT COUNT(*), MIN(created_at) FROM interactions WHERE created_at < ?`,
+}
+
+// deleteQuery removes up to deleteBatch rows of a type older than a cutoff.
+// Jobs other jobs still wait on are kept.
+var deleteQuery = map[string]string{
+	settings.RetainImpressions: `DELETE FROM bandit_impressions WHERE (user_id, clip_id) IN (
+		SELECT user_id, clip_id FROM bandit_impressions WHERE created_at < ? LIMIT ` + fmt.Sprint(deleteBatch) + `)`,
+	settings.RetainJobs: `DELETE FROM jobs WHERE id IN (
+		SELECT id FROM jobs j
+		WHERE COALESCE(j.completed_at, j.created_at) < ? AND j.status IN ` + terminalJobs + `
+		  AND NOT EXISTS (
+			SELECT 1 FROM job_dependencies d JOIN jobs c ON c.id = d.job_id
+			WHERE d.depends_on_job_id = j.id AND c.status NOT IN ` + terminalJobs + `)
+		LIMIT ` + fmt.Sprint(deleteBatch) + `)`,
+	settings.RetainAuditLog: `DELETE FROM audit_log WHERE id IN (
+		SELECT id FROM audit_log WHERE created_at < ? LIMIT ` + fmt.Sprint(deleteBatch) + `)`,
+}
+
+// Run applies every policy once. Interactions are rolled up before they
+// are deleted, and old clips are expired rather than deleted so lifecycle
+// removes their media with them. A failing type does not stop the others;
+// the first error is returned with the results of the rest.
+func (h *Handler) Run(ctx context.Context) ([]TypeResult, error) {
+	policies, err := h.Policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var results []TypeResult
+	var firstErr error
+	for _, p := range policies {
+		if p.Days <= 0 {
+			continue
+		}
+		res := TypeResult{Type: p.Type, Days: p.Days, Cutoff: p.cutoff(now)}
+		switch p.Type {
+		case settings.RetainInteractions:
+			var pr Result
+			pr, err = h.pruneInteractions(ctx, p.Days)
+			res.Removed = pr.Interactions
+		case settings.RetainClips:
+			res.Removed, err = h.expireClips(ctx, res.Cutoff)
+		default:
+			res.Removed, err = h.deleteBefore(ctx, deleteQuery[p.Type], res.Cutoff)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", p.Type, err)
+			log.Printf("retention: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		results = append(results, res)
+	}
+	return results, firstErr
+}
+
+// deleteBefore runs query, a batched delete, until it removes nothing.
+func (h *Handler) deleteBefore(ctx context.Context, query, cutoff string) (int64, error) {
+	var total int64
+	for {
+		res, err := h.DB.ExecContext(ctx, query, cutoff)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < deleteBatch {
+			return total, nil
+		}
+	}
+}
+
+// expireClips sets clips created before cutoff to expire now, leaving the
+// purge of their rows and objects to lifecycle. Protected clips are kept.
+func (h *Handler) expireClips(ctx context.Context, cutoff string) (int64, error) {
+	res, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE clips SET expires_at = %[1]s
+		WHERE created_at < ? AND status = 'ready' AND is_protected = 0
+		  AND (expires_at IS NULL OR expires_at > %[1]s)
+	`, h.DB.NowUTC()), cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// HandleReport lists every retention policy with what the next run would
+// remove: how many rows are past the cutoff and the oldest of them.
+func (h *Handler) HandleReport(w http.ResponseWriter, r *http.Request) {
+	policies, err := h.Policies(r.Context())
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load retention policies"})
+		return
+	}
+	now := time.Now()
+	report := make([]map[string]interface{}, 0, len(policies))
+	for _, p := range policies {
+		entry := map[string]interface{}{
+			"type":    p.Type,
+			"days":    p.Days,
+			"source":  p.Source,
+			"pending": 0,
+		}
+		if p.Days > 0 {
+			cutoff := p.cutoff(now)
+			var pending int64
+			var oldest sql.NullString
+			if err := h.DB.QueryRowContext(r.Context(), pendingQuery[p.Type], cutoff).Scan(&pending, &oldest); err != nil {
+				httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build retention report"})
+				return
+			}
+			entry["cutoff"] = cutoff
+			entry["pending"] = pending
+			entry["oldest_at"] = oldest.String
+		}
+		report = append(report, entry)
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"policies":       report,
+		"interval_hours": int(pruneInterval.Hours()),
+	})
+}
@@ -0,0 +1,193 @@
+// Package retention removes data once it ages past the retention an admin
+// set for its content type. Raw interactions are folded into daily
+// per-clip rollups first so lifetime counts are preserved.
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/settings"
+)
+
+// pruneInterval is how often the background loop prunes.
+const pruneInterval = 6 * time.Hour
+
+// Handler applies the retention policies. Retention is the interactions
+// policy until an admin sets one; zero keeps raw interactions forever.
+type Handler struct {
+	DB        *db.CompatDB
+	Retention time.Duration
+}
+
+// Result reports what one prune pass did.
+type Result struct {
+	Cutoff       string `json:"cutoff"`
+	Days         int    `json:"days"`
+	Interactions int64  `json:"interactions"`
+}
+
+// interactionCutoff returns the start of the UTC day days ago. Pruning
+// whole days means each day is rolled up exactly once, so per-day user
+// counts are exact.
+func interactionCutoff(now time.Time, days int) string {
+	day := now.UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	return day.Format(timeLayout)
+}
+
+// Prune rolls up and deletes interactions older than the interactions
+// policy allows.
+func (h *Handler) Prune(ctx context.Context) (Result, error) {
+	p, err := h.policy(ctx, settings.RetainInteractions)
+	if err != nil {
+		return Result{}, err
+	}
+	return h.pruneInteractions(ctx, p.Days)
+}
+
+// pruneInteractions rolls up and deletes interactions older than days, one
+// day per transaction so a large backlog never holds a long write lock.
+func (h *Handler) pruneInteractions(ctx context.Context, days int) (Result, error) {
+	res := Result{}
+	if days <= 0 {
+		return res, nil
+	}
+	res.Cutoff = interactionCutoff(time.Now(), days)
+
+	for {
+		var oldest sql.NullString
+		if err := h.DB.QueryRowContext(ctx,
+			`SELECT MIN(created_at) FROM interactions WHERE created_at < ?`, res.Cutoff,
+		).Scan(&oldest); err != nil {
+			return res, fmt.Errorf("find oldest interaction: %w", err)
+		}
+		if !oldest.Valid || len(oldest.String) < 10 {
+			return res, nil
+		}
+		day := oldest.String[:10]
+		start, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return res, fmt.Errorf("parse interaction date %q: %w", oldest.String, err)
+		}
+		// The cutoff is day-aligned, so the next day never passes it.
+		end := start.AddDate(0, 0, 1).Format(timeLayout)
+
+		n, err := h.pruneDay(ctx, day, end)
+		if err != nil {
+			return res, fmt.Errorf("prune %s: %w", day, err)
+		}
+		res.Days++
+		res.Interactions += n
+	}
+}
+
+// pruneDay folds the interactions of one day (those before end) into
+// interaction_rollups and deletes them.
+func (h *Handler) pruneDay(ctx context.Context, day, end string) (int64, error) {
+	var deleted int64
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO interaction_rollups (day, clip_id, action, events, users, watch_percentage_sum)
+			SELECT CAST(? AS TEXT), clip_id, action, COUNT(*), COUNT(DISTINCT user_id), COALESCE(SUM(watch_percentage), 0)
+			FROM interactions
+			WHERE created_at < ?
+			GROUP BY clip_id, action
+			ON CONFLICT (day, clip_id, action) DO UPDATE SET
+				events = interaction_rollups.events + excluded.events,
+				users = interaction_rollups.users + excluded.users,
+				watch_percentage_sum = interaction_rollups.watch_percentage_sum + excluded.watch_percentage_sum
+		`, day, end); err != nil {
+			return fmt.Errorf("roll up: %w", err)
+		}
+		res, err := conn.ExecContext(ctx, `DELETE FROM interactions WHERE created_at < ?`, end)
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// PruneLoop applies every retention policy shortly after startup and then
+// every pruneInterval.
+func (h *Handler) PruneLoop() {
+	time.Sleep(2 * time.Minute)
+	h.runAndLog()
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.runAndLog()
+	}
+}
+
+func (h *Handler) runAndLog() {
+	results, err := h.Run(context.Background())
+	if err != nil {
+		log.Printf("retention: %v", err)
+	}
+	for _, res := range results {
+		if res.Removed > 0 {
+			log.Printf("retention: removed %d %s from before %s", res.Removed, res.Type, res.Cutoff)
+		}
+	}
+}
+
+// HandleStatus reports the interactions policy, how much raw and rolled-up
+// history is held, and the oldest raw interaction.
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	p, err := h.policy(r.Context(), settings.RetainInteractions)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load retention status"})
+		return
+	}
+	var raw, rolledUp int64
+	var oldest, oldestRollup sql.NullString
+	if err := h.DB.QueryRowContext(r.Context(), `
+		SELECT
+			(SELECT COUNT(*) FROM interactions),
+			(SELECT MIN(created_at) FROM interactions),
+			(SELECT COALESCE(SUM(events), 0) FROM interaction_rollups),
+			(SELECT MIN(day) FROM interaction_rollups)
+	`).Scan(&raw, &oldest, &rolledUp, &oldestRollup); err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load retention status"})
+		return
+	}
+	status := map[string]interface{}{
+		"retention_days":         p.Days,
+		"raw_interactions":       raw,
+		"oldest_interaction_at":  oldest.String,
+		"rolled_up_interactions": rolledUp,
+		"oldest_rollup_day":      oldestRollup.String,
+	}
+	if p.Days > 0 {
+		status["cutoff"] = interactionCutoff(time.Now(), p.Days)
+	}
+	httputil.WriteJSON(w, 200, status)
+}
+
+// HandlePrune runs a prune pass immediately.
+func (h *Handler) HandlePrune(w http.ResponseWriter, r *http.Request) {
+	p, err := h.policy(r.Context(), settings.RetainInteractions)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load retention policy"})
+		return
+	}
+	if p.Days <= 0 {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "interaction retention is disabled"})
+		return
+	}
+	res, err := h.pruneInteractions(r.Context(), p.Days)
+	if err != nil {
+		log.Printf("retention: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to prune interactions"})
+		return
+	}
+	httputil.WriteJSON(w, 200, res)
+}
@@ -0,0 +1,213 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/settings"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestPrune_RollsUpExpiredDaysAndKeepsRecent(t *testing.T) {
+	cdb := newTestDB(t)
+	for _, id := range []string{"u1", "u2"} {
+		cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES (?, ?, ?, 'x')`, id, id, id+"@test.com")
+	}
+	cdb.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s1', 'http://x.com', 'direct')`)
+	cdb.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('c1', 's1', 'Clip', 30.0, 'k', 'ready')`)
+
+	ts := func(daysAgo int, hour int) string {
+		d := time.Now().UTC().AddDate(0, 0, -daysAgo).Truncate(24 * time.Hour).Add(time.Duration(hour) * time.Hour)
+		return d.Format("2006-01-02T15:04:05Z")
+	}
+	insert := func(id, user, action string, watch float64, at string) {
+		if _, err := cdb.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, watch_percentage, created_at) VALUES (?, ?, 'c1', ?, ?, ?)`,
+			id, user, action, watch, at); err != nil {
+			t.Fatalf("insert interaction: %v", err)
+		}
+	}
+	// Two expired days, then interactions inside the window.
+	insert("i1", "u1", "view", 0.5, ts(40, 1))
+	insert("i2", "u2", "view", 0.25, ts(40, 20))
+	insert("i3", "u1", "view", 1.0, ts(40, 21))
+	insert("i4", "u1", "like", 0, ts(35, 3))
+	insert("i5", "u2", "view", 0.75, ts(5, 3))
+	insert("i6", "u1", "view", 0.5, time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+
+	h := &Handler{DB: cdb, Retention: 30 * 24 * time.Hour}
+	res, err := h.Prune(context.Background())
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if res.Days != 2 || res.Interactions != 4 {
+		t.Errorf("prune result = %+v, want 2 days and 4 interactions", res)
+	}
+
+	var remaining int
+	cdb.QueryRow(`SELECT COUNT(*) FROM interactions`).Scan(&remaining)
+	if remaining != 2 {
+		t.Errorf("remaining interactions = %d, want 2", remaining)
+	}
+
+	var events, users int
+	var watchSum float64
+	if err := cdb.QueryRow(`SELECT events, users, watch_percentage_sum FROM interaction_rollups WHERE day = ? AND action = 'view'`,
+		ts(40, 0)[:10]).Scan(&events, &users, &watchSum); err != nil {
+		t.Fatalf("load view rollup: %v", err)
+	}
+	if events != 3 || users != 2 || watchSum != 1.75 {
+		t.Errorf("view rollup = %d events, %d users, %v watch sum; want 3, 2, 1.75", events, users, watchSum)
+	}
+	var total int
+	cdb.QueryRow(`SELECT SUM(events) FROM interaction_rollups`).Scan(&total)
+	if total != 4 {
+		t.Errorf("rolled up events = %d, want 4", total)
+	}
+
+	// A second pass has nothing left to prune and leaves the rollups alone.
+	res, err = h.Prune(context.Background())
+	if err != nil || res.Interactions != 0 {
+		t.Errorf("second prune = %+v, %v; want nothing pruned", res, err)
+	}
+	cdb.QueryRow(`SELECT SUM(events) FROM interaction_rollups`).Scan(&total)
+	if total != 4 {
+		t.Errorf("rolled up events after second prune = %d, want 4", total)
+	}
+}
+
+func TestPrune_DisabledKeepsEverything(t *testing.T) {
+	cdb := newTestDB(t)
+	cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES ('u1', 'u1', 'u1@test.com', 'x')`)
+	cdb.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s1', 'http://x.com', 'direct')`)
+	cdb.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('c1', 's1', 'Clip', 30.0, 'k', 'ready')`)
+	cdb.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES ('i1', 'u1', 'c1', 'view', '2020-01-01T00:00:00Z')`)
+
+	h := &Handler{DB: cdb}
+	if res, err := h.Prune(context.Background()); err != nil || res.Interactions != 0 {
+		t.Errorf("disabled prune = %+v, %v; want no-op", res, err)
+	}
+	var n int
+	cdb.QueryRow(`SELECT COUNT(*) FROM interactions`).Scan(&n)
+	if n != 1 {
+		t.Errorf("interactions = %d, want 1", n)
+	}
+}
+
+func TestRun_AppliesAdminPoliciesPerType(t *testing.T) {
+	cdb := newTestDB(t)
+	old := time.Now().UTC().AddDate(0, 0, -40).Format("2006-01-02T15:04:05Z")
+	recent := time.Now().UTC().AddDate(0, 0, -2).Format("2006-01-02T15:04:05Z")
+	for _, q := range []string{
+		`INSERT INTO users (id, username, email, password_hash) VALUES ('u1', 'u1', 'u1@test.com', 'x')`,
+		`INSERT INTO sources (id, url, platform) VALUES ('s1', 'http://x.com', 'direct')`,
+		`INSERT INTO topics (id, name, slug) VALUES ('t1', 'Cooking', 'cooking')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, created_at) VALUES
+			('c-old', 's1', 'Old', 30.0, 'k1', 'ready', '` + old + `'),
+			('c-kept', 's1', 'Kept', 30.0, 'k2', 'ready', '` + old + `'),
+			('c-new', 's1', 'New', 30.0, 'k3', 'ready', '` + recent + `')`,
+		`UPDATE clips SET is_protected = 1 WHERE id = 'c-kept'`,
+		`INSERT INTO bandit_impressions (user_id, clip_id, topic_id, created_at) VALUES
+			('u1', 'c-old', 't1', '` + old + `'), ('u1', 'c-new', 't1', '` + recent + `')`,
+		`INSERT INTO jobs (id, job_type, status, completed_at, created_at) VALUES
+			('j-done', 'download', 'complete', '` + old + `', '` + old + `'),
+			('j-parent', 'download', 'complete', '` + old + `', '` + old + `'),
+			('j-child', 'download', 'queued', NULL, '` + old + `'),
+			('j-new', 'download', 'failed', '` + recent + `', '` + old + `')`,
+		`INSERT INTO job_dependencies (job_id, depends_on_job_id) VALUES ('j-child', 'j-parent')`,
+		`INSERT INTO audit_log (id, actor, action, created_at) VALUES
+			('a-old', 'admin', 'impersonate', '` + old + `'), ('a-new', 'admin', 'impersonate', '` + recent + `')`,
+		`INSERT INTO interactions (id, user_id, clip_id, action, created_at) VALUES ('i1', 'u1', 'c-new', 'view', '` + old + `')`,
+	} {
+		if _, err := cdb.Exec(q); err != nil {
+			t.Fatalf("seed: %v\n%s", err, q)
+		}
+	}
+	if _, err := settings.Update(context.Background(), cdb, []byte(`{"retention": {
+		"impressions": 30, "jobs": 30, "audit_log": 30, "clips": 30, "interactions": 0
+	}}`)); err != nil {
+		t.Fatalf("save retention settings: %v", err)
+	}
+	h := &Handler{DB: cdb, Retention: 7 * 24 * time.Hour}
+
+	// The report previews the run without removing anything.
+	rec := httptest.NewRecorder()
+	h.HandleReport(rec, httptest.NewRequest("GET", "/api/admin/retention", nil))
+	var report struct {
+		Policies []struct {
+			Type    string `json:"type"`
+			Days    int    `json:"days"`
+			Source  string `json:"source"`
+			Pending int64  `json:"pending"`
+		} `json:"policies"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || rec.Code != 200 {
+		t.Fatalf("report: %d %v", rec.Code, err)
+	}
+	pending := map[string]int64{}
+	for _, p := range report.Policies {
+		if p.Source != "settings" {
+			t.Errorf("%s policy source = %q, want settings", p.Type, p.Source)
+		}
+		pending[p.Type] = p.Pending
+	}
+	want := map[string]int64{"impressions": 1, "jobs": 2, "audit_log": 1, "clips": 1, "interactions": 0}
+	for typ, n := range want {
+		if pending[typ] != n {
+			t.Errorf("report pending %s = %d, want %d", typ, pending[typ], n)
+		}
+	}
+
+	results, err := h.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	removed := map[string]int64{}
+	for _, res := range results {
+		removed[res.Type] = res.Removed
+	}
+	// The parent a queued job still waits on is kept, and interactions are
+	// kept forever because the admin setting overrides the default.
+	want["jobs"] = 1
+	for typ, n := range want {
+		if removed[typ] != n {
+			t.Errorf("removed %s = %d, want %d", typ, removed[typ], n)
+		}
+	}
+	var jobs, audit, impressions, interactions int
+	cdb.QueryRow(`SELECT COUNT(*) FROM jobs`).Scan(&jobs)
+	cdb.QueryRow(`SELECT COUNT(*) FROM audit_log`).Scan(&audit)
+	cdb.QueryRow(`SELECT COUNT(*) FROM bandit_impressions`).Scan(&impressions)
+	cdb.QueryRow(`SELECT COUNT(*) FROM interactions`).Scan(&interactions)
+	if jobs != 3 || audit != 1 || impressions != 1 || interactions != 1 {
+		t.Errorf("left %d jobs, %d audit entries, %d impressions, %d interactions; want 3, 1, 1, 1", jobs, audit, impressions, interactions)
+	}
+	// Old clips are handed to lifecycle by expiring them; protected clips
+	// and new ones are left alone.
+	var expiring int
+	cdb.QueryRow(`SELECT COUNT(*) FROM clips WHERE expires_at IS NOT NULL`).Scan(&expiring)
+	var oldExpires sql.NullString
+	cdb.QueryRow(`SELECT expires_at FROM clips WHERE id = 'c-old'`).Scan(&oldExpires)
+	if expiring != 1 || !oldExpires.Valid {
+		t.Errorf("%d clips expiring (c-old %v), want only c-old", expiring, oldExpires)
+	}
+}
@@ -2,11 +2,13 @@ package saved
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"clipfeed/auth"
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/integrations"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -28,13 +30,18 @@ func (h *Handler) HandleSaveClip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := h.DB.ExecContext(r.Context(),
+	res, err := h.DB.ExecContext(r.Context(),
 		`INSERT INTO saved_clips (user_id, clip_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
 		userID, clipID)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to save clip"})
 		return
 	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if err := integrations.QueueSaved(r.Context(), h.DB, userID, clipID); err != nil {
+			log.Printf("queue saved clip %s for integrations: %v", clipID, err)
+		}
+	}
 	httputil.WriteJSON(w, 200, map[string]string{"status": "saved"})
 }
 
@@ -52,20 +59,30 @@ func (h *Handler) HandleUnsaveClip(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]string{"status": "removed"})
 }
 
-// HandleListSaved lists the user's saved clips.
+// HandleListSaved lists the user's saved clips with their tags and notes,
+// optionally only those carrying ?tag=.
 func (h *Handler) HandleListSaved(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 
+	tagFilter := ""
+	args := []interface{}{userID}
+	if tag := normalizeTag(r.URL.Query().Get("tag")); tag != "" {
+		tagFilter = `AND EXISTS (SELECT 1 FROM saved_clip_tags t WHERE t.user_id = sc.user_id AND t.clip_id = sc.clip_id AND t.tag = ?)`
+		args = append(args, tag)
+	}
+
+	tags := h.savedTags(r.Context(), userID)
 	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key,
-		       c.topics, c.created_at, s.platform, s.channel_name, s.url
+		       c.topics, c.created_at, c.start_time, c.end_time,
+		       s.platform, s.channel_name, s.url, sc.note
 		FROM saved_clips sc
 		JOIN clips c ON sc.clip_id = c.id
 		LEFT JOIN sources s ON c.source_id = s.id
-		WHERE sc.user_id = ?
+		WHERE sc.user_id = ? AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id) `+tagFilter+`
 		ORDER BY sc.created_at DESC
 		LIMIT 200
-	`, userID)
+	`, args...)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list saved clips"})
 		return
@@ -74,21 +91,29 @@ func (h *Handler) HandleListSaved(w http.ResponseWriter, r *http.Request) {
 
 	var clips []map[string]interface{}
 	for rows.Next() {
-		var id, title, thumbnailKey, topicsJSON, createdAt string
+		var id, title, thumbnailKey, topicsJSON, createdAt, note string
 		var duration float64
 		var platform, channelName, sourceURL *string
+		var startTime, endTime *float64
 		if err := rows.Scan(&id, &title, &duration, &thumbnailKey, &topicsJSON, &createdAt,
-			&platform, &channelName, &sourceURL); err != nil {
+			&startTime, &endTime, &platform, &channelName, &sourceURL, &note); err != nil {
 			continue
 		}
 		var topics []string
 		json.Unmarshal([]byte(topicsJSON), &topics)
+		clipTags := tags[id]
+		if clipTags == nil {
+			clipTags = []string{}
+		}
 		clips = append(clips, map[string]interface{}{
 			"id": id, "title": title, "duration_seconds": duration,
+			"duration_bucket": httputil.DurationBucket(duration),
 			"thumbnail_key": thumbnailKey,
 			"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumbnailKey),
 			"topics": topics, "created_at": createdAt,
 			"platform": platform, "channel_name": channelName, "source_url": sourceURL,
+			"attribution": httputil.Attribution(platform, sourceURL, channelName, startTime, endTime),
+			"note":        note, "tags": clipTags,
 		})
 	}
 	if clips == nil {
@@ -109,7 +134,7 @@ func (h *Handler) HandleListHistory(w http.ResponseWriter, r *http.Request) {
 			FROM interactions WHERE user_id = ?
 		) i
 		JOIN clips c ON i.clip_id = c.id
-		WHERE i.rn = 1
+		WHERE i.rn = 1 AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id)
 		ORDER BY i.created_at DESC
 		LIMIT 100
 	`, userID)
@@ -129,6 +154,7 @@ func (h *Handler) HandleListHistory(w http.ResponseWriter, r *http.Request) {
 		}
 		history = append(history, map[string]interface{}{
 			"id": id, "title": title, "duration_seconds": duration,
+			"duration_bucket": httputil.DurationBucket(duration),
 			"thumbnail_key": thumbnailKey,
 			"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumbnailKey),
 			"last_action": action, "at": at,
@@ -0,0 +1,222 @@
+package saved
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxNoteLength = 5000
+	maxTags       = 20
+	maxTagLength  = 40
+)
+
+// normalizeTag trims and lower-cases a tag so filters match regardless of
+// how it was typed.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// savedTags returns the user's tags keyed by clip ID, each list sorted.
+func (h *Handler) savedTags(ctx context.Context, userID string) map[string][]string {
+	tags := make(map[string][]string)
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT clip_id, tag FROM saved_clip_tags WHERE user_id = ? ORDER BY clip_id, tag`, userID)
+	if err != nil {
+		return tags
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var clipID, tag string
+		if rows.Scan(&clipID, &tag) == nil {
+			tags[clipID] = append(tags[clipID], tag)
+		}
+	}
+	return tags
+}
+
+// HandleUpdateSaved sets the private note and/or tags on a saved clip.
+// Omitted fields are left unchanged; tags replace the existing set.
+func (h *Handler) HandleUpdateSaved(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	clipID := chi.URLParam(r, "clipId")
+
+	var req struct {
+		Note *string  `json:"note"`
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Note != nil && len(*req.Note) > maxNoteLength {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("note must be at most %d characters", maxNoteLength)})
+		return
+	}
+	var tags []string
+	if req.Tags != nil {
+		seen := make(map[string]bool)
+		for _, t := range req.Tags {
+			t = normalizeTag(t)
+			if t == "" || seen[t] {
+				continue
+			}
+			if len(t) > maxTagLength {
+				httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("tags must be at most %d characters", maxTagLength)})
+				return
+			}
+			seen[t] = true
+			tags = append(tags, t)
+		}
+		if len(tags) > maxTags {
+			httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d tags per clip", maxTags)})
+			return
+		}
+		sort.Strings(tags)
+	}
+
+	var note string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT note FROM saved_clips WHERE user_id = ? AND clip_id = ?`, userID, clipID,
+	).Scan(&note); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not saved"})
+		return
+	}
+
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if req.Note != nil {
+			note = *req.Note
+		}
+		if _, err := conn.ExecContext(r.Context(), fmt.Sprintf(
+			`UPDATE saved_clips SET note = ?, updated_at = %s WHERE user_id = ? AND clip_id = ?`, h.DB.NowUTC()),
+			note, userID, clipID); err != nil {
+			return err
+		}
+		if req.Tags == nil {
+			return nil
+		}
+		if _, err := conn.ExecContext(r.Context(),
+			`DELETE FROM saved_clip_tags WHERE user_id = ? AND clip_id = ?`, userID, clipID); err != nil {
+			return err
+		}
+		for _, t := range tags {
+			if _, err := conn.ExecContext(r.Context(),
+				`INSERT INTO saved_clip_tags (user_id, clip_id, tag) VALUES (?, ?, ?)`, userID, clipID, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Printf("update saved clip %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update saved clip"})
+		return
+	}
+
+	if req.Tags == nil {
+		tags = h.savedTags(r.Context(), userID)[clipID]
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"clip_id": clipID, "note": note, "tags": tags})
+}
+
+// savedExport is one saved clip in the personal export.
+type savedExport struct {
+	ClipID      string   `json:"clip_id"`
+	Title       string   `json:"title"`
+	SourceURL   string   `json:"source_url"`
+	ChannelName string   `json:"channel_name"`
+	Platform    string   `json:"platform"`
+	StartTime   *float64 `json:"start_time"`
+	EndTime     *float64 `json:"end_time"`
+	SavedAt     string   `json:"saved_at"`
+	Tags        []string `json:"tags"`
+	Note        string   `json:"note"`
+}
+
+// HandleExportSaved downloads the user's saved clips with their tags and
+// notes as JSON (default) or CSV (?format=csv).
+func (h *Handler) HandleExportSaved(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "format must be json or csv"})
+		return
+	}
+
+	tags := h.savedTags(r.Context(), userID)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT c.id, COALESCE(c.title, ''), COALESCE(s.url, ''), COALESCE(s.channel_name, ''),
+		       COALESCE(s.platform, ''), c.start_time, c.end_time, COALESCE(sc.created_at, ''), sc.note
+		FROM saved_clips sc
+		JOIN clips c ON sc.clip_id = c.id
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE sc.user_id = ?
+		ORDER BY sc.created_at DESC
+	`, userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to export saved clips"})
+		return
+	}
+	defer rows.Close()
+
+	entries := make([]savedExport, 0)
+	for rows.Next() {
+		var e savedExport
+		if err := rows.Scan(&e.ClipID, &e.Title, &e.SourceURL, &e.ChannelName, &e.Platform,
+			&e.StartTime, &e.EndTime, &e.SavedAt, &e.Note); err != nil {
+			continue
+		}
+		e.Tags = tags[e.ClipID]
+		if e.Tags == nil {
+			e.Tags = []string{}
+		}
+		entries = append(entries, e)
+	}
+
+	filename := "clipfeed-saved-" + time.Now().UTC().Format("20060102")
+	if format == "json" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		httputil.WriteJSON(w, 200, map[string]interface{}{
+			"exported_at": time.Now().UTC().Format(time.RFC3339), "saved": entries,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"clip_id", "title", "source_url", "channel_name", "platform", "start_time", "end_time", "saved_at", "tags", "note"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.ClipID, e.Title, e.SourceURL, e.ChannelName, e.Platform,
+			formatSeconds(e.StartTime), formatSeconds(e.EndTime),
+			e.SavedAt, strings.Join(e.Tags, ";"), e.Note,
+		})
+	}
+	cw.Flush()
+}
+
+func formatSeconds(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%g", *v)
+}
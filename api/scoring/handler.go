@@ -0,0 +1,176 @@
+package scoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+const (
+	maxHistory       = 50
+	maxPreviewClips  = 100
+	defaultPreviewN  = 20
+	maxWeightNoteLen = 500
+)
+
+// Handler serves the admin endpoints for scoring weights.
+type Handler struct {
+	DB *db.CompatDB
+}
+
+// weightsRequest is the body of a weights change or preview. It is decoded
+// over the current weights, so omitted weights keep their current value.
+type weightsRequest struct {
+	Weights
+	Note    string   `json:"note"`
+	ClipIDs []string `json:"clip_ids"`
+	Limit   int      `json:"limit"`
+}
+
+func (h *Handler) decodeWeightsRequest(w http.ResponseWriter, r *http.Request) (WeightsVersion, weightsRequest, bool) {
+	current, err := Current(r.Context(), h.DB)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load scoring weights"})
+		return current, weightsRequest{}, false
+	}
+	req := weightsRequest{Weights: current.Weights}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return current, req, false
+	}
+	if err := req.Weights.Validate(); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return current, req, false
+	}
+	return current, req, true
+}
+
+// HandleGetWeights returns the weights in effect and the change history.
+func (h *Handler) HandleGetWeights(w http.ResponseWriter, r *http.Request) {
+	current, err := Current(r.Context(), h.DB)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load scoring weights"})
+		return
+	}
+	history, err := History(r.Context(), h.DB, maxHistory)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load scoring weights"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"current": current, "history": history})
+}
+
+// HandleSetWeights validates and stores a new weights version. The change
+// takes effect at the next batch score update.
+func (h *Handler) HandleSetWeights(w http.ResponseWriter, r *http.Request) {
+	current, req, ok := h.decodeWeightsRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Note) > maxWeightNoteLen {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("note must be at most %d characters", maxWeightNoteLen)})
+		return
+	}
+	if req.Weights == current.Weights {
+		httputil.WriteJSON(w, 200, current)
+		return
+	}
+
+	next := WeightsVersion{Version: current.Version + 1, Weights: req.Weights, Note: req.Note}
+	if _, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		INSERT INTO scoring_weights (version, watch_weight, like_weight, save_weight, watch_full_weight, rating_weight, skip_penalty, dislike_penalty, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, %s)
+	`, h.DB.NowUTC()), next.Version, next.Weights.Watch, next.Weights.Like, next.Weights.Save,
+		next.Weights.WatchFull, next.Weights.Rating, next.Weights.Skip, next.Weights.Dislike, next.Note); err != nil {
+		// A concurrent change took this version number.
+		log.Printf("store scoring weights v%d: %v", next.Version, err)
+		httputil.WriteJSON(w, 409, map[string]string{"error": "scoring weights changed concurrently; reload and retry"})
+		return
+	}
+	stored, err := Current(r.Context(), h.DB)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load scoring weights"})
+		return
+	}
+	httputil.WriteJSON(w, 201, stored)
+}
+
+// HandlePreviewWeights rescores sample clips under the proposed weights
+// without storing them. Samples are the given clip_ids, or the most viewed
+// clips that the batch update would rescore.
+func (h *Handler) HandlePreviewWeights(w http.ResponseWriter, r *http.Request) {
+	current, req, ok := h.decodeWeightsRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(req.ClipIDs) > maxPreviewClips {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("at most %d clip_ids", maxPreviewClips)})
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxPreviewClips {
+		req.Limit = defaultPreviewN
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.id, COALESCE(c.title, ''), COALESCE(c.content_score, 0.5), %s, %s, %s
+		FROM clips c
+		JOIN %s ON interactions.clip_id = c.id
+		WHERE c.status = 'ready'`,
+		scoreExpr(h.DB, current.Weights, "c.id"), scoreExpr(h.DB, req.Weights, "c.id"), viewCount, scoredInteractions)
+	var args []interface{}
+	if len(req.ClipIDs) > 0 {
+		query += ` AND c.id IN (?` + strings.Repeat(", ?", len(req.ClipIDs)-1) + `)`
+		for _, id := range req.ClipIDs {
+			args = append(args, id)
+		}
+	}
+	query += fmt.Sprintf(`
+		GROUP BY c.id, c.title, c.content_score
+		HAVING %s >= %d
+		ORDER BY %s DESC, c.id
+		LIMIT ?`, viewCount, MinViewers, viewCount)
+	args = append(args, req.Limit)
+
+	rows, err := h.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		log.Printf("preview scoring weights: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to preview scoring weights"})
+		return
+	}
+	defer rows.Close()
+
+	clips := make([]map[string]interface{}, 0)
+	var totalShift float64
+	for rows.Next() {
+		var id, title string
+		var stored, currentScore, proposed float64
+		var viewers int
+		if err := rows.Scan(&id, &title, &stored, &currentScore, &proposed, &viewers); err != nil {
+			continue
+		}
+		totalShift += math.Abs(proposed - currentScore)
+		clips = append(clips, map[string]interface{}{
+			"id": id, "title": title, "viewers": viewers,
+			"stored_score": stored, "current_score": currentScore,
+			"proposed_score": proposed, "delta": proposed - currentScore,
+		})
+	}
+
+	meanShift := 0.0
+	if len(clips) > 0 {
+		meanShift = totalShift / float64(len(clips))
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"current_version": current.Version,
+		"current":         current.Weights,
+		"proposed":        req.Weights,
+		"mean_abs_delta":  meanShift,
+		"clips":           clips,
+	})
+}
@@ -0,0 +1,187 @@
+// Package scoring owns the batch content score formula and the versioned
+// weights it uses.
+package scoring
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"clipfeed/db"
+)
+
+// Weights are the coefficients of the batch content score. Watch is applied
+// to the mean watch percentage and Rating to the mean star rating scaled to
+// [0, 1]; the others to the per-view rate of each action. Skip and Dislike
+// are penalties and are subtracted.
+type Weights struct {
+	Watch     float64 `json:"watch"`
+	Like      float64 `json:"like"`
+	Save      float64 `json:"save"`
+	WatchFull float64 `json:"watch_full"`
+	Rating    float64 `json:"rating"`
+	Skip      float64 `json:"skip"`
+	Dislike   float64 `json:"dislike"`
+}
+
+// DefaultWeights are used when no weights have been stored.
+var DefaultWeights = Weights{Watch: 0.30, Like: 0.25, Save: 0.20, WatchFull: 0.15, Rating: 0.10, Skip: 0.30, Dislike: 0.15}
+
+// MinViewers is how many distinct viewers a clip needs before the batch
+// update replaces its score.
+const MinViewers = 5
+
+// Validate checks each weight is within [0, 1], that the positive weights
+// sum to at most 1 (so a perfect clip scores at most 1) and are not all
+// zero, and that the penalties sum to at most 1.
+func (w Weights) Validate() error {
+	for name, v := range map[string]float64{
+		"watch": w.Watch, "like": w.Like, "save": w.Save,
+		"watch_full": w.WatchFull, "rating": w.Rating, "skip": w.Skip, "dislike": w.Dislike,
+	} {
+		if math.IsNaN(v) || v < 0 || v > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	positive := w.Watch + w.Like + w.Save + w.WatchFull + w.Rating
+	if positive <= 0 {
+		return errors.New("watch, like, save, watch_full, and rating cannot all be 0")
+	}
+	// Allow for float rounding in weights that add up to exactly 1.
+	if positive > 1+1e-9 {
+		return fmt.Errorf("watch + like + save + watch_full + rating must be at most 1 (got %.3f)", positive)
+	}
+	if penalties := w.Skip + w.Dislike; penalties > 1+1e-9 {
+		return fmt.Errorf("skip + dislike must be at most 1 (got %.3f)", penalties)
+	}
+	return nil
+}
+
+// WeightsVersion is one entry in the weights history.
+type WeightsVersion struct {
+	Version   int     `json:"version"`
+	Weights   Weights `json:"weights"`
+	Note      string  `json:"note"`
+	CreatedAt *string `json:"created_at"`
+}
+
+const weightsColumns = `version, watch_weight, like_weight, save_weight, watch_full_weight, rating_weight, skip_penalty, dislike_penalty, note, created_at`
+
+func scanWeightsVersion(scan func(...interface{}) error) (WeightsVersion, error) {
+	var v WeightsVersion
+	err := scan(&v.Version, &v.Weights.Watch, &v.Weights.Like, &v.Weights.Save,
+		&v.Weights.WatchFull, &v.Weights.Rating, &v.Weights.Skip, &v.Weights.Dislike, &v.Note, &v.CreatedAt)
+	return v, err
+}
+
+// Current returns the weights in effect, or DefaultWeights as version 0 if
+// none are stored.
+func Current(ctx context.Context, cdb *db.CompatDB) (WeightsVersion, error) {
+	v, err := scanWeightsVersion(cdb.QueryRowContext(ctx,
+		`SELECT `+weightsColumns+` FROM scoring_weights ORDER BY version DESC LIMIT 1`).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WeightsVersion{Weights: DefaultWeights}, nil
+	}
+	return v, err
+}
+
+// History returns stored weight versions, newest first.
+func History(ctx context.Context, cdb *db.CompatDB, limit int) ([]WeightsVersion, error) {
+	rows, err := cdb.QueryContext(ctx,
+		`SELECT `+weightsColumns+` FROM scoring_weights ORDER BY version DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make([]WeightsVersion, 0)
+	for rows.Next() {
+		v, err := scanWeightsVersion(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, v)
+	}
+	return history, rows.Err()
+}
+
+// scoredInteractions caps each user's influence on a clip's score: every
+// (user, clip, action) counts once, with repeated views averaged, and users
+// an admin has confirmed as automated are left out entirely.
+const scoredInteractions = `(
+	SELECT user_id, clip_id, action, AVG(watch_percentage) AS watch_percentage
+	FROM interactions
+	WHERE user_id NOT IN (SELECT user_id FROM interaction_flags WHERE status = 'confirmed')
+	GROUP BY user_id, clip_id, action
+) interactions`
+
+const viewCount = `SUM(CASE WHEN action='view' THEN 1 ELSE 0 END)`
+
+// ratingScore is the mean star rating of the clip whose id is in clipCol,
+// scaled from 1-5 to [0, 1], or a neutral 0.5 for an unrated clip. Like
+// scoredInteractions it leaves out users confirmed as automated.
+func ratingScore(clipCol string) string {
+	return fmt.Sprintf(`COALESCE((
+		SELECT (AVG(rating) - 1) / 4.0 FROM clip_ratings
+		WHERE clip_ratings.clip_id = %s
+		  AND clip_ratings.user_id NOT IN (SELECT user_id FROM interaction_flags WHERE status = 'confirmed')
+	), 0.5)`, clipCol)
+}
+
+// scoreExpr returns the aggregate score expression over scoredInteractions,
+// grouped by the clip id in clipCol, for one set of weights, clamped to
+// [0, 1]. Weights are validated floats, so formatting them into the SQL is
+// safe.
+func scoreExpr(cdb *db.CompatDB, w Weights, clipCol string) string {
+	rate := func(action string) string {
+		return fmt.Sprintf(`COALESCE(CAST(SUM(CASE WHEN action='%s' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(%s, 0), 0)`, action, viewCount)
+	}
+	return cdb.ClampExpr(fmt.Sprintf(`
+		COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * %g
+		+ %s * %g
+		+ %s * %g
+		+ %s * %g
+		+ %s * %g
+		- %s * %g
+		- %s * %g`,
+		w.Watch, rate("like"), w.Like, rate("save"), w.Save, rate("watch_full"), w.WatchFull,
+		ratingScore(clipCol), w.Rating, rate("skip"), w.Skip, rate("dislike"), w.Dislike), 0, 1)
+}
+
+// Recompute rescores every ready clip with at least MinViewers viewers using
+// the current weights and returns how many clips were updated.
+func Recompute(ctx context.Context, cdb *db.CompatDB) (int64, error) {
+	current, err := Current(ctx, cdb)
+	if err != nil {
+		return 0, err
+	}
+	expr := scoreExpr(cdb, current.Weights, "interactions.clip_id")
+
+	var res sql.Result
+	if cdb.IsPostgres() {
+		res, err = cdb.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE clips SET content_score = sub.new_score
+			FROM (
+				SELECT clip_id, %s AS new_score
+				FROM %s GROUP BY clip_id
+				HAVING %s >= %d
+			) sub
+			WHERE clips.id = sub.clip_id AND clips.status = 'ready'
+		`, expr, scoredInteractions, viewCount, MinViewers))
+	} else {
+		res, err = cdb.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE clips SET content_score = (
+				SELECT %[1]s
+				FROM %[2]s WHERE interactions.clip_id = clips.id
+				HAVING %[3]s >= %[4]d
+			)
+			WHERE status = 'ready'
+			  AND id IN (SELECT clip_id FROM %[2]s GROUP BY clip_id HAVING %[3]s >= %[4]d)
+		`, expr, scoredInteractions, viewCount, MinViewers))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
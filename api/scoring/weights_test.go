@@ -0,0 +1,137 @@
+package scoring
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestWeightsValidate(t *testing.T) {
+	if err := DefaultWeights.Validate(); err != nil {
+		t.Fatalf("default weights invalid: %v", err)
+	}
+	for name, w := range map[string]Weights{
+		"negative":     {Watch: 0.5, Like: -0.1},
+		"above one":    {Watch: 1.2},
+		"positive sum": {Watch: 0.5, Like: 0.3, Save: 0.3},
+		"all zero":     {Skip: 0.5},
+		"penalty sum":  {Watch: 0.5, Skip: 0.6, Dislike: 0.6},
+	} {
+		if err := w.Validate(); err == nil {
+			t.Errorf("%s: expected validation error for %+v", name, w)
+		}
+	}
+	if err := (Weights{Watch: 0.4, Like: 0.3, Save: 0.2, WatchFull: 0.1}).Validate(); err != nil {
+		t.Errorf("weights summing to exactly 1 rejected: %v", err)
+	}
+}
+
+func TestWeights_VersionedPreviewAndRecompute(t *testing.T) {
+	cdb := newTestDB(t)
+	ctx := context.Background()
+	h := &Handler{DB: cdb}
+
+	cdb.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s1', 'http://x.com', 'direct')`)
+	cdb.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status, content_score) VALUES ('c1', 's1', 'Liked', 30.0, 'k', 'ready', 0.5)`)
+	for i := 0; i < MinViewers; i++ {
+		uid := fmt.Sprintf("u%d", i)
+		cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES (?, ?, ?, 'x')`, uid, uid, uid+"@test.com")
+		cdb.Exec(`INSERT INTO interactions (id, user_id, clip_id, action, watch_percentage) VALUES (?, ?, 'c1', 'view', 0.5)`, "v"+uid, uid)
+		cdb.Exec(`INSERT INTO interactions (id, user_id, clip_id, action) VALUES (?, ?, 'c1', 'like')`, "l"+uid, uid)
+		cdb.Exec(`INSERT INTO clip_ratings (user_id, clip_id, rating) VALUES (?, 'c1', 5)`, uid)
+	}
+
+	call := func(handler func(w *httptest.ResponseRecorder), wantCode int) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec)
+		if rec.Code != wantCode {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, wantCode, rec.Body.String())
+		}
+		var m map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&m)
+		return m
+	}
+	// The migrations seed the previously hardcoded weights as version 1 and
+	// move them to the defaults with a rating term as version 2.
+	got := call(func(w *httptest.ResponseRecorder) {
+		h.HandleGetWeights(w, httptest.NewRequest("GET", "/api/admin/scoring/weights", nil))
+	}, 200)
+	if v := got["current"].(map[string]interface{})["version"]; v != 2.0 {
+		t.Fatalf("seeded version = %v, want 2", v)
+	}
+	if cur, _ := Current(ctx, cdb); cur.Weights != DefaultWeights {
+		t.Errorf("seeded weights = %+v, want the defaults %+v", cur.Weights, DefaultWeights)
+	}
+
+	// Preview: current weights give 0.3*0.5 + 0.25*1 + 0.1*1 (five stars)
+	// = 0.5; proposed weights (omitted ones unchanged) give 0.2*0.5 + 0.6*1
+	// + 0.1*1 = 0.8.
+	proposal := `{"watch": 0.2, "like": 0.6, "save": 0.05, "watch_full": 0.05}`
+	preview := call(func(w *httptest.ResponseRecorder) {
+		h.HandlePreviewWeights(w, httptest.NewRequest("POST", "/api/admin/scoring/weights/preview", strings.NewReader(proposal)))
+	}, 200)
+	clips := preview["clips"].([]interface{})
+	if len(clips) != 1 {
+		t.Fatalf("preview clips = %v, want c1", clips)
+	}
+	c := clips[0].(map[string]interface{})
+	if cur, prop := c["current_score"].(float64), c["proposed_score"].(float64); fmt.Sprintf("%.3f/%.3f", cur, prop) != "0.500/0.800" {
+		t.Errorf("preview scores = %.3f -> %.3f, want 0.500 -> 0.800", cur, prop)
+	}
+	if cur, _ := Current(ctx, cdb); cur.Version != 2 {
+		t.Errorf("preview stored weights: version = %d", cur.Version)
+	}
+
+	// Invalid weights are rejected; valid ones become version 3.
+	call(func(w *httptest.ResponseRecorder) {
+		h.HandleSetWeights(w, httptest.NewRequest("PUT", "/api/admin/scoring/weights", strings.NewReader(`{"like": 0.9}`)))
+	}, 400)
+	call(func(w *httptest.ResponseRecorder) {
+		h.HandleSetWeights(w, httptest.NewRequest("PUT", "/api/admin/scoring/weights",
+			strings.NewReader(`{"watch": 0.2, "like": 0.6, "save": 0.05, "watch_full": 0.05, "note": "favor likes"}`)))
+	}, 201)
+	history, _ := History(ctx, cdb, 10)
+	if len(history) != 3 || history[0].Version != 3 || history[0].Note != "favor likes" || history[1].Weights != DefaultWeights {
+		t.Fatalf("history = %+v", history)
+	}
+
+	if n, err := Recompute(ctx, cdb); err != nil || n != 1 {
+		t.Fatalf("Recompute = %d, %v; want 1 clip", n, err)
+	}
+	var score float64
+	cdb.QueryRow(`SELECT content_score FROM clips WHERE id = 'c1'`).Scan(&score)
+	if fmt.Sprintf("%.3f", score) != "0.800" {
+		t.Errorf("recomputed score = %.3f, want 0.800", score)
+	}
+
+	// One-star ratings pull the score down: 0.2*0.5 + 0.6*1 + 0.1*0 = 0.7.
+	cdb.Exec(`UPDATE clip_ratings SET rating = 1 WHERE clip_id = 'c1'`)
+	Recompute(ctx, cdb)
+	cdb.QueryRow(`SELECT content_score FROM clips WHERE id = 'c1'`).Scan(&score)
+	if fmt.Sprintf("%.3f", score) != "0.700" {
+		t.Errorf("score after one-star ratings = %.3f, want 0.700", score)
+	}
+}
@@ -1,6 +1,7 @@
 package scout
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"clipfeed/auth"
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/jobs"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -64,10 +66,12 @@ func (h *Handler) HandleListScoutSources(w http.ResponseWriter, r *http.Request)
 	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT s.id, s.source_type, s.platform, s.identifier, s.is_active,
 		       s.last_checked, s.check_interval_hours, s.force_check, s.created_at,
+		       s.last_error, s.consecutive_failures, s.last_success_at, s.next_check_at, s.paused_reason,
 		       COALESCE(SUM(CASE WHEN c.status = 'pending'  THEN 1 ELSE 0 END), 0) AS cnt_pending,
 		       COALESCE(SUM(CASE WHEN c.status = 'approved' THEN 1 ELSE 0 END), 0) AS cnt_approved,
 		       COALESCE(SUM(CASE WHEN c.status = 'rejected' THEN 1 ELSE 0 END), 0) AS cnt_rejected,
-		       COALESCE(SUM(CASE WHEN c.status = 'ingested' THEN 1 ELSE 0 END), 0) AS cnt_ingested
+		       COALESCE(SUM(CASE WHEN c.status = 'ingested' THEN 1 ELSE 0 END), 0) AS cnt_ingested,
+		       COALESCE(SUM(CASE WHEN c.status = 'expired'  THEN 1 ELSE 0 END), 0) AS cnt_expired
 		FROM scout_sources s
 		LEFT JOIN scout_candidates c ON c.scout_source_id = s.id
 		WHERE s.user_id = ?
@@ -84,10 +88,13 @@ func (h *Handler) HandleListScoutSources(w http.ResponseWriter, r *http.Request)
 		var id, srcType, platform, identifier, createdAt string
 		var isActive, interval, forceCheck int
 		var lastChecked *string
-		var cntPending, cntApproved, cntRejected, cntIngested int
+		var lastError, lastSuccess, nextCheck, pausedReason *string
+		var failures int
+		var cntPending, cntApproved, cntRejected, cntIngested, cntExpired int
 		if err := rows.Scan(&id, &srcType, &platform, &identifier, &isActive,
 			&lastChecked, &interval, &forceCheck, &createdAt,
-			&cntPending, &cntApproved, &cntRejected, &cntIngested); err != nil {
+			&lastError, &failures, &lastSuccess, &nextCheck, &pausedReason,
+			&cntPending, &cntApproved, &cntRejected, &cntIngested, &cntExpired); err != nil {
 			continue
 		}
 		sources = append(sources, map[string]interface{}{
@@ -98,6 +105,15 @@ func (h *Handler) HandleListScoutSources(w http.ResponseWriter, r *http.Request)
 			"candidates": map[string]int{
 				"pending": cntPending, "approved": cntApproved,
 				"rejected": cntRejected, "ingested": cntIngested,
+				"expired": cntExpired,
+			},
+			"health": map[string]interface{}{
+				"status":               healthStatus(isActive == 1, failures, pausedReason, lastChecked),
+				"last_error":           lastError,
+				"consecutive_failures": failures,
+				"last_success_at":      lastSuccess,
+				"next_check_at":        nextCheck,
+				"paused_reason":        pausedReason,
 			},
 		})
 	}
@@ -117,9 +133,11 @@ func (h *Handler) HandleListScoutCandidates(w http.ResponseWriter, r *http.Reque
 
 	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT sc.id, sc.url, sc.platform, sc.external_id, sc.title,
-		       sc.channel_name, sc.duration_seconds, sc.llm_score, sc.status, sc.created_at
+		       sc.channel_name, sc.duration_seconds, sc.llm_score, sc.status, sc.created_at,
+		       sc.duplicate_similarity, dc.id, dc.title
 		FROM scout_candidates sc
 		JOIN scout_sources ss ON sc.scout_source_id = ss.id
+		LEFT JOIN clips dc ON dc.id = sc.duplicate_clip_id
 		WHERE ss.user_id = ? AND sc.status = ?
 		ORDER BY sc.created_at DESC LIMIT 50
 	`, userID, statusFilter)
@@ -132,16 +150,26 @@ func (h *Handler) HandleListScoutCandidates(w http.ResponseWriter, r *http.Reque
 	var candidates []map[string]interface{}
 	for rows.Next() {
 		var id, urlStr, platform, extID, status, createdAt string
-		var title, channelName *string
-		var duration, llmScore *float64
-		if err := rows.Scan(&id, &urlStr, &platform, &extID, &title, &channelName, &duration, &llmScore, &status, &createdAt); err != nil {
+		var title, channelName, dupClipID, dupTitle *string
+		var duration, llmScore, dupSimilarity *float64
+		if err := rows.Scan(&id, &urlStr, &platform, &extID, &title, &channelName, &duration, &llmScore, &status, &createdAt,
+			&dupSimilarity, &dupClipID, &dupTitle); err != nil {
 			continue
 		}
+		// duplicate_of links the library clip the scout found most similar,
+		// when it was similar enough to count as a likely duplicate.
+		var duplicateOf interface{}
+		if dupClipID != nil {
+			duplicateOf = map[string]interface{}{
+				"clip_id": *dupClipID, "title": dupTitle, "similarity": dupSimilarity,
+			}
+		}
 		candidates = append(candidates, map[string]interface{}{
 			"id": id, "url": urlStr, "platform": platform, "external_id": extID,
 			"title": title, "channel_name": channelName,
 			"duration_seconds": duration, "llm_score": llmScore,
 			"status": status, "created_at": createdAt,
+			"duplicate_similarity": dupSimilarity, "duplicate_of": duplicateOf,
 		})
 	}
 	if candidates == nil {
@@ -151,6 +179,8 @@ func (h *Handler) HandleListScoutCandidates(w http.ResponseWriter, r *http.Reque
 }
 
 // HandleUpdateScoutSource updates is_active or check_interval_hours.
+// Reactivating a source clears its failure history so it is checked on the
+// worker's next pass.
 func (h *Handler) HandleUpdateScoutSource(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	sourceID := chi.URLParam(r, "id")
@@ -169,9 +199,13 @@ func (h *Handler) HandleUpdateScoutSource(w http.ResponseWriter, r *http.Request
 		if *req.IsActive {
 			active = 1
 		}
-		if _, err := h.DB.ExecContext(r.Context(),
-			`UPDATE scout_sources SET is_active = ? WHERE id = ? AND user_id = ?`,
-			active, sourceID, userID); err != nil {
+		query := `UPDATE scout_sources SET is_active = ? WHERE id = ? AND user_id = ?`
+		if active == 1 {
+			query = `UPDATE scout_sources SET is_active = ?, consecutive_failures = 0,
+				paused_reason = NULL, next_check_at = NULL
+				WHERE id = ? AND user_id = ? AND is_active = 0`
+		}
+		if _, err := h.DB.ExecContext(r.Context(), query, active, sourceID, userID); err != nil {
 			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update source"})
 			return
 		}
@@ -332,7 +366,7 @@ func (h *Handler) HandleTriggerScoutSource(w http.ResponseWriter, r *http.Reques
 	sourceID := chi.URLParam(r, "id")
 
 	res, err := h.DB.ExecContext(r.Context(),
-		`UPDATE scout_sources SET force_check = 1, last_checked = NULL
+		`UPDATE scout_sources SET force_check = 1, last_checked = NULL, next_check_at = NULL
 		 WHERE id = ? AND user_id = ?`, sourceID, userID)
 	if err != nil {
 		httputil.WriteJSON(w, 500, map[string]string{"error": "trigger failed"})
@@ -347,6 +381,7 @@ func (h *Handler) HandleTriggerScoutSource(w http.ResponseWriter, r *http.Reques
 }
 
 // HandleApproveCandidate approves a scout candidate and queues ingestion.
+// Expired candidates were never evaluated, so they can still be approved.
 func (h *Handler) HandleApproveCandidate(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserIDKey).(string)
 	candidateID := chi.URLParam(r, "id")
@@ -355,7 +390,7 @@ func (h *Handler) HandleApproveCandidate(w http.ResponseWriter, r *http.Request)
 	err := h.DB.QueryRowContext(r.Context(), `
 		SELECT sc.url, sc.platform FROM scout_candidates sc
 		JOIN scout_sources ss ON sc.scout_source_id = ss.id
-		WHERE sc.id = ? AND ss.user_id = ? AND sc.status = 'pending'
+		WHERE sc.id = ? AND ss.user_id = ? AND sc.status IN ('pending', 'expired')
 	`, candidateID, userID).Scan(&urlStr, &platform)
 	if err != nil {
 		httputil.WriteJSON(w, 404, map[string]string{"error": "candidate not found or already processed"})
@@ -364,17 +399,22 @@ func (h *Handler) HandleApproveCandidate(w http.ResponseWriter, r *http.Request)
 
 	sourceID := uuid.New().String()
 	jobID := uuid.New().String()
-	payload := fmt.Sprintf(`{"url":%q,"source_id":%q,"platform":%q}`, urlStr, sourceID, platform)
+	strategy := jobs.ResolveClipStrategy(r.Context(), h.DB, platform, "")
+	payload, err := jobs.EncodePayload("download", &jobs.DownloadPayload{URL: urlStr, SourceID: sourceID, Platform: platform, Strategy: strategy})
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
 
 	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
 		if _, err := conn.ExecContext(r.Context(),
-			`INSERT INTO sources (id, url, platform, submitted_by, status) VALUES (?, ?, ?, ?, 'pending')`,
-			sourceID, urlStr, platform, userID); err != nil {
+			`INSERT INTO sources (id, url, platform, submitted_by, status, clip_strategy) VALUES (?, ?, ?, ?, 'pending', ?)`,
+			sourceID, urlStr, platform, userID, strategy); err != nil {
 			return fmt.Errorf("create source: %w", err)
 		}
 		if _, err := conn.ExecContext(r.Context(),
 			`INSERT INTO jobs (id, source_id, job_type, payload) VALUES (?, ?, 'download', ?)`,
-			jobID, sourceID, payload); err != nil {
+			jobID, sourceID, jobs.WithTraceparent(r.Context(), payload)); err != nil {
 			return fmt.Errorf("queue job: %w", err)
 		}
 		if _, err := conn.ExecContext(r.Context(),
@@ -392,3 +432,18 @@ func (h *Handler) HandleApproveCandidate(w http.ResponseWriter, r *http.Request)
 		"status": "approved", "source_id": sourceID, "job_id": jobID,
 	})
 }
+
+// ResurfaceExpired puts the user's expired candidates back in the pending
+// queue, where the scout evaluates them against the user's current
+// threshold. Their age restarts so they are not expired again straight
+// away.
+func ResurfaceExpired(ctx context.Context, q *db.CompatDB, userID string) (int64, error) {
+	res, err := q.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE scout_candidates SET status = 'pending', expired_at = NULL, resurfaced_at = %s
+		WHERE status = 'expired'
+		  AND scout_source_id IN (SELECT id FROM scout_sources WHERE user_id = ?)`, q.NowUTC()), userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,139 @@
+package scout
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// MaxConsecutiveFailures is how many checks in a row may fail before a
+	// source is paused.
+	MaxConsecutiveFailures = 5
+
+	// failureRetryBase is the delay before retrying a source whose check
+	// failed once. It doubles with each further failure, up to the source's
+	// check interval.
+	failureRetryBase = 15 * time.Minute
+
+	// maxCheckErrorLen caps the stored error of a failed check.
+	maxCheckErrorLen = 500
+)
+
+// failureRetryDelay is how long to wait before rechecking a source after
+// its failures-th consecutive failure.
+func failureRetryDelay(failures, intervalHours int) time.Duration {
+	interval := time.Duration(intervalHours) * time.Hour
+	delay := failureRetryBase
+	for i := 1; i < failures && delay < interval; i++ {
+		delay *= 2
+	}
+	if delay > interval {
+		return interval
+	}
+	return delay
+}
+
+// healthStatus summarises a source's check results for the listing.
+func healthStatus(isActive bool, failures int, pausedReason, lastChecked *string) string {
+	switch {
+	case !isActive && pausedReason != nil:
+		return "paused"
+	case failures > 0:
+		return "failing"
+	case lastChecked == nil:
+		return "unchecked"
+	default:
+		return "ok"
+	}
+}
+
+// HandleReportCheck records the outcome of a scout worker's check of a
+// source. A success clears the failure count and schedules the next check
+// one interval out; a failure stores the error and retries with backoff,
+// pausing the source after MaxConsecutiveFailures.
+func (h *Handler) HandleReportCheck(w http.ResponseWriter, r *http.Request) {
+	sourceID := chi.URLParam(r, "id")
+	var req struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	var failures, interval int
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT consecutive_failures, COALESCE(check_interval_hours, 24) FROM scout_sources WHERE id = ?`,
+		sourceID).Scan(&failures, &interval)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "source not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load source"})
+		return
+	}
+	if interval <= 0 {
+		interval = 24
+	}
+
+	now := time.Now().UTC()
+	const layout = "2006-01-02T15:04:05Z"
+	paused := false
+	var nextCheck string
+	if req.OK {
+		failures = 0
+		nextCheck = now.Add(time.Duration(interval) * time.Hour).Format(layout)
+		_, err = h.DB.ExecContext(r.Context(), `
+			UPDATE scout_sources SET last_checked = ?, last_success_at = ?, next_check_at = ?,
+				consecutive_failures = 0, last_error = NULL
+			WHERE id = ?
+		`, now.Format(layout), now.Format(layout), nextCheck, sourceID)
+	} else {
+		failures++
+		msg := strings.TrimSpace(req.Error)
+		if msg == "" {
+			msg = "check failed"
+		}
+		if len(msg) > maxCheckErrorLen {
+			msg = msg[:maxCheckErrorLen]
+		}
+		nextCheck = now.Add(failureRetryDelay(failures, interval)).Format(layout)
+		_, err = h.DB.ExecContext(r.Context(), `
+			UPDATE scout_sources SET last_checked = ?, next_check_at = ?,
+				consecutive_failures = ?, last_error = ?
+			WHERE id = ?
+		`, now.Format(layout), nextCheck, failures, msg, sourceID)
+		if err == nil && failures >= MaxConsecutiveFailures {
+			paused = true
+			_, err = h.DB.ExecContext(r.Context(),
+				`UPDATE scout_sources SET is_active = 0, paused_reason = ? WHERE id = ?`,
+				fmt.Sprintf("paused after %d consecutive failed checks", failures), sourceID)
+		}
+	}
+	if err != nil {
+		log.Printf("scout check report %s: %v", sourceID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to record check"})
+		return
+	}
+	if paused {
+		log.Printf("scout: paused source %s after %d consecutive failed checks", sourceID, failures)
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"consecutive_failures": failures,
+		"next_check_at":        nextCheck,
+		"paused":               paused,
+	})
+}
@@ -0,0 +1,295 @@
+// Package settings stores the instance-wide settings admins edit: branding
+// shown to every visitor, how new accounts are admitted, the preferences
+// new users start with, and how long each kind of data is kept.
+package settings
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"clipfeed/db"
+)
+
+// Registration modes.
+const (
+	RegistrationOpen       = "open"
+	RegistrationClosed     = "closed"
+	RegistrationInviteOnly = "invite-only"
+
+	DefaultInstanceName = "ClipFeed"
+
+	maxNameLen        = 100
+	maxDescriptionLen = 1000
+	maxLogoKeyLen     = 512
+)
+
+// RegistrationModes lists every accepted registration mode.
+var RegistrationModes = []string{RegistrationOpen, RegistrationClosed, RegistrationInviteOnly}
+
+// Content types with a retention period.
+const (
+	RetainInteractions = "interactions"
+	RetainImpressions  = "impressions"
+	RetainJobs         = "jobs"
+	RetainAuditLog     = "audit_log"
+	RetainClips        = "clips"
+
+	maxRetentionDays = 36500
+)
+
+// RetentionTypes lists the content types an admin can set a retention
+// period for.
+var RetentionTypes = []string{RetainInteractions, RetainImpressions, RetainJobs, RetainAuditLog, RetainClips}
+
+// Settings are the instance settings. Each field is stored as its own row in
+// instance_settings under its JSON name, so adding a field needs no
+// migration.
+type Settings struct {
+	InstanceName string `json:"instance_name"`
+	Description  string `json:"description"`
+	// LogoKey is the object storage key of the instance logo, if any.
+	LogoKey      string `json:"logo_key"`
+	Registration string `json:"registration"`
+	// DefaultPreferences seeds the preferences of newly registered users.
+	// Keys are user_preferences columns; see preferenceKinds.
+	DefaultPreferences map[string]interface{} `json:"default_preferences"`
+	// Retention is how many days each content type in RetentionTypes is
+	// kept; 0 keeps it forever. Types not listed use the built-in default.
+	Retention map[string]int `json:"retention"`
+}
+
+// Defaults are the settings of an instance no admin has configured.
+func Defaults() Settings {
+	return Settings{
+		InstanceName:       DefaultInstanceName,
+		Registration:       RegistrationOpen,
+		DefaultPreferences: map[string]interface{}{},
+		Retention:          map[string]int{},
+	}
+}
+
+type preferenceKind int
+
+const (
+	prefFraction preferenceKind = iota // a number between 0 and 1
+	prefSeconds                        // a non-negative whole number
+	prefBool
+	prefScoutThreshold // a number between 0 and 10
+)
+
+// preferenceKinds lists the user_preferences columns an admin can set a
+// default for.
+var preferenceKinds = map[string]preferenceKind{
+	"exploration_rate":      prefFraction,
+	"diversity_mix":         prefFraction,
+	"freshness_bias":        prefFraction,
+	"min_clip_seconds":      prefSeconds,
+	"max_clip_seconds":      prefSeconds,
+	"autoplay":              prefBool,
+	"trending_boost":        prefBool,
+	"dedupe_seen_24h":       prefBool,
+	"scout_auto_ingest":     prefBool,
+	"scout_threshold":       prefScoutThreshold,
+	"lock_topic_affinities": prefBool,
+}
+
+// Validate checks s, returning an error that can be shown to the admin.
+func (s Settings) Validate() error {
+	if strings.TrimSpace(s.InstanceName) == "" || len(s.InstanceName) > maxNameLen {
+		return fmt.Errorf("instance_name must be 1-%d characters", maxNameLen)
+	}
+	if len(s.Description) > maxDescriptionLen {
+		return fmt.Errorf("description must not exceed %d characters", maxDescriptionLen)
+	}
+	if len(s.LogoKey) > maxLogoKeyLen || strings.Contains(s.LogoKey, "..") || strings.HasPrefix(s.LogoKey, "/") {
+		return errors.New("logo_key must be a relative storage key")
+	}
+	valid := false
+	for _, m := range RegistrationModes {
+		valid = valid || s.Registration == m
+	}
+	if !valid {
+		return fmt.Errorf("registration must be one of: %s", strings.Join(RegistrationModes, ", "))
+	}
+	for typ, days := range s.Retention {
+		if !slices.Contains(RetentionTypes, typ) {
+			return fmt.Errorf("retention: unknown content type %q; use one of: %s", typ, strings.Join(RetentionTypes, ", "))
+		}
+		if days < 0 || days > maxRetentionDays {
+			return fmt.Errorf("retention: %s must be 0-%d days", typ, maxRetentionDays)
+		}
+	}
+	for key, v := range s.DefaultPreferences {
+		kind, ok := preferenceKinds[key]
+		if !ok {
+			return fmt.Errorf("default_preferences: unknown preference %q", key)
+		}
+		if _, err := preferenceValue(kind, v); err != nil {
+			return fmt.Errorf("default_preferences: %s %v", key, err)
+		}
+	}
+	return nil
+}
+
+// UserPreferenceValue validates a value for one of the user_preferences
+// columns an instance default can be set for, converting it to its column
+// value. ok is false for other keys.
+func UserPreferenceValue(key string, v interface{}) (value interface{}, ok bool, err error) {
+	kind, ok := preferenceKinds[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value, err = preferenceValue(kind, v)
+	return value, true, err
+}
+
+// preferenceValue converts a default preference to its column value.
+func preferenceValue(kind preferenceKind, v interface{}) (interface{}, error) {
+	if kind == prefBool {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("must be true or false")
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, errors.New("must be a number")
+	}
+	switch kind {
+	case prefFraction:
+		if f < 0 || f > 1 {
+			return nil, errors.New("must be between 0 and 1")
+		}
+	case prefSeconds:
+		if f < 0 || f != float64(int(f)) {
+			return nil, errors.New("must be a non-negative whole number")
+		}
+		return int(f), nil
+	case prefScoutThreshold:
+		if f < 0 || f > 10 {
+			return nil, errors.New("must be between 0 and 10")
+		}
+	}
+	return f, nil
+}
+
+// Load reads the settings, filling unset fields from Defaults.
+func Load(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}) (Settings, error) {
+	s := Defaults()
+	rows, err := q.QueryContext(ctx, `SELECT key, value FROM instance_settings`)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	stored := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return s, err
+		}
+		stored[key] = json.RawMessage(value)
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+	// Round-tripping through an object applies every stored key at once and
+	// ignores keys this version no longer knows.
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return Defaults(), fmt.Errorf("decode settings: %w", err)
+	}
+	if s.DefaultPreferences == nil {
+		s.DefaultPreferences = map[string]interface{}{}
+	}
+	if s.Retention == nil {
+		s.Retention = map[string]int{}
+	}
+	return s, nil
+}
+
+// Update applies the JSON object patch to the stored settings and saves the
+// result. Fields missing from patch keep their current value; unknown
+// fields are rejected. Validation errors are returned as-is for display.
+func Update(ctx context.Context, cdb *db.CompatDB, patch []byte) (Settings, error) {
+	var s Settings
+	err := db.WithTx(ctx, cdb, func(conn *db.CompatConn) error {
+		var err error
+		if s, err = Load(ctx, conn); err != nil {
+			return fmt.Errorf("load settings: %w", err)
+		}
+		// Decoding into a map adds to it; retention is replaced as a whole
+		// so a type can be dropped back to its default.
+		var keys map[string]json.RawMessage
+		if json.Unmarshal(patch, &keys) == nil && keys["retention"] != nil {
+			s.Retention = map[string]int{}
+		}
+		dec := json.NewDecoder(bytes.NewReader(patch))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			return &ValidationError{msg: "invalid settings: " + err.Error()}
+		}
+		s.InstanceName = strings.TrimSpace(s.InstanceName)
+		if err := s.Validate(); err != nil {
+			return &ValidationError{msg: err.Error()}
+		}
+
+		var fields map[string]json.RawMessage
+		b, _ := json.Marshal(s)
+		json.Unmarshal(b, &fields)
+		for key, value := range fields {
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+				INSERT INTO instance_settings (key, value, updated_at) VALUES (?, ?, %s)
+				ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+			`, cdb.NowUTC()), key, string(value)); err != nil {
+				return fmt.Errorf("save %s: %w", key, err)
+			}
+		}
+		return nil
+	})
+	return s, err
+}
+
+// ValidationError reports settings an admin submitted that were rejected.
+type ValidationError struct{ msg string }
+
+func (e *ValidationError) Error() string { return e.msg }
+
+// InsertUserPreferences creates userID's preferences row from the instance
+// default preferences; columns without a default keep the schema default.
+func InsertUserPreferences(ctx context.Context, ex interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, userID string, defaults map[string]interface{}) error {
+	cols := []string{"user_id"}
+	args := []interface{}{userID}
+	for key, v := range defaults {
+		kind, ok := preferenceKinds[key]
+		if !ok {
+			continue
+		}
+		value, err := preferenceValue(kind, v)
+		if err != nil {
+			continue
+		}
+		cols = append(cols, key)
+		args = append(args, value)
+	}
+	_, err := ex.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO user_preferences (%s) VALUES (?%s) ON CONFLICT DO NOTHING`,
+		strings.Join(cols, ", "), strings.Repeat(", ?", len(cols)-1)), args...)
+	return err
+}
@@ -0,0 +1,94 @@
+package share
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"clipfeed/collections"
+	"clipfeed/httputil"
+)
+
+// oembedCacheAge is how long consumers may cache a response, in seconds.
+// Embed URLs outlive it; only revoking a clip's streams breaks them.
+const oembedCacheAge = 3600
+
+// HandleOEmbed answers oEmbed requests for the public pages of this
+// instance: a clip page is a "video" with the embed player as its html, and
+// a public collection page a "link". Only the JSON format is served.
+func (h *Handler) HandleOEmbed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if format := q.Get("format"); format != "" && format != "json" {
+		httputil.WriteJSON(w, 501, map[string]string{"error": "only the json format is supported"})
+		return
+	}
+	target, err := url.Parse(q.Get("url"))
+	if err != nil || target.Host == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "url must be the absolute URL of a public page"})
+		return
+	}
+	base := h.baseURL()
+	if own, err := url.Parse(base); err != nil || !strings.EqualFold(target.Host, own.Host) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "url is not a page of this instance"})
+		return
+	}
+	maxWidth, _ := strconv.Atoi(q.Get("maxwidth"))
+	maxHeight, _ := strconv.Atoi(q.Get("maxheight"))
+
+	resp := map[string]interface{}{
+		"version":       "1.0",
+		"provider_name": h.instanceName(r.Context()),
+		"provider_url":  base + "/",
+		"cache_age":     oembedCacheAge,
+	}
+	path := strings.TrimSuffix(target.Path, "/")
+	switch {
+	case strings.HasPrefix(path, ClipPath("")):
+		c, err := h.loadClip(r.Context(), strings.TrimPrefix(path, ClipPath("")))
+		if err != nil {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+			return
+		}
+		width, height := c.playerSize(maxWidth, maxHeight)
+		resp["type"] = "video"
+		resp["title"] = c.Title
+		resp["width"], resp["height"] = width, height
+		resp["html"] = fmt.Sprintf(
+			`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="autoplay; fullscreen; picture-in-picture" allowfullscreen title="%s"></iframe>`,
+			html.EscapeString(h.embedURL(base, c.ID, c.Generation)), width, height, html.EscapeString(c.Title))
+		if c.ChannelName != nil && *c.ChannelName != "" {
+			resp["author_name"] = *c.ChannelName
+			if c.SourceURL != nil {
+				resp["author_url"] = *c.SourceURL
+			}
+		}
+		if thumb := c.thumbnailURL(base, h.MinioBucket); thumb != "" {
+			resp["thumbnail_url"] = thumb
+			resp["thumbnail_width"], resp["thumbnail_height"] = c.thumbnailSize()
+		}
+
+	case strings.HasPrefix(path, CollectionPath("")):
+		id := strings.TrimPrefix(path, CollectionPath(""))
+		title, _, coverKey, err := h.publicCollection(r.Context(), id)
+		if err != nil {
+			httputil.WriteJSON(w, 404, map[string]string{"error": "collection not found"})
+			return
+		}
+		resp["type"] = "link"
+		resp["title"] = title
+		if coverKey != "" {
+			resp["thumbnail_url"] = base + collections.CoverURL(id, coverKey)
+			resp["thumbnail_width"], resp["thumbnail_height"] = collections.CoverSize, collections.CoverSize
+		}
+
+	default:
+		httputil.WriteJSON(w, 404, map[string]string{"error": "url is not a public clip or collection page"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", oembedCacheAge))
+	httputil.WriteJSON(w, 200, resp)
+}
@@ -0,0 +1,311 @@
+package share
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"clipfeed/collections"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxCollectionPageClips caps the clips a public collection page lists.
+const maxCollectionPageClips = 200
+
+// Content security policies of the pages. Media and images come from this
+// instance only; the embed player alone may be framed by other sites.
+const (
+	pagePolicy  = "default-src 'none'; img-src 'self'; media-src 'self' blob:; style-src 'unsafe-inline'; frame-ancestors 'none'"
+	embedPolicy = "default-src 'none'; img-src 'self'; media-src 'self' blob:; style-src 'unsafe-inline'; frame-ancestors *"
+)
+
+// pageMeta is what a page tells link unfurlers about itself.
+type pageMeta struct {
+	Site, Title, Description string
+	URL, Image               string
+	Type                     string
+	// Player and OEmbed are set for clips.
+	Player             string
+	PlayerW, PlayerH   int
+	OEmbed             string
+	ImageW, ImageH     int
+	Stream, Poster     string
+	Channel, SourceURL string
+	Clips              []pageClip
+	NotFound           bool
+}
+
+// pageClip is a clip listed on a collection page.
+type pageClip struct {
+	Path, Title, Thumbnail string
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · {{.Site}}</title>
+{{- if .URL}}
+<link rel="canonical" href="{{.URL}}">
+<meta property="og:url" content="{{.URL}}">
+{{- end}}
+<meta property="og:site_name" content="{{.Site}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:type" content="{{.Type}}">
+{{- if .Description}}
+<meta name="description" content="{{.Description}}">
+<meta property="og:description" content="{{.Description}}">
+{{- end}}
+{{- if .Image}}
+<meta property="og:image" content="{{.Image}}">
+<meta property="og:image:width" content="{{.ImageW}}">
+<meta property="og:image:height" content="{{.ImageH}}">
+{{- end}}
+{{- if .Player}}
+<meta property="og:video" content="{{.Player}}">
+<meta property="og:video:type" content="text/html">
+<meta property="og:video:width" content="{{.PlayerW}}">
+<meta property="og:video:height" content="{{.PlayerH}}">
+<meta name="twitter:card" content="player">
+<meta name="twitter:player" content="{{.Player}}">
+<meta name="twitter:player:width" content="{{.PlayerW}}">
+<meta name="twitter:player:height" content="{{.PlayerH}}">
+{{- else}}
+<meta name="twitter:card" content="summary">
+{{- end}}
+{{- if .OEmbed}}
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbed}}" title="{{.Title}}">
+{{- end}}
+<style>
+body { margin: 0; background: #0b0b0f; color: #eee; font: 16px/1.5 system-ui, sans-serif; }
+main { max-width: 640px; margin: 0 auto; padding: 16px; }
+video { display: block; width: 100%; max-height: 80vh; background: #000; border-radius: 8px; }
+a { color: #8ab4ff; }
+ul { list-style: none; padding: 0; display: grid; grid-template-columns: repeat(auto-fill, minmax(140px, 1fr)); gap: 12px; }
+li img { width: 100%; aspect-ratio: 9 / 16; object-fit: cover; border-radius: 6px; background: #222; }
+.muted { color: #999; font-size: 14px; }
+</style>
+</head>
+<body>
+<main>
+{{- if .NotFound}}
+<h1>{{.Title}}</h1>
+{{- else}}
+{{- if .Stream}}
+<video src="{{.Stream}}" poster="{{.Poster}}" controls playsinline preload="metadata"></video>
+{{- end}}
+<h1>{{.Title}}</h1>
+{{- if .Description}}
+<p>{{.Description}}</p>
+{{- end}}
+{{- if .Channel}}
+<p class="muted">From {{if .SourceURL}}<a href="{{.SourceURL}}" rel="noopener nofollow">{{.Channel}}</a>{{else}}{{.Channel}}{{end}}</p>
+{{- end}}
+{{- if .Clips}}
+<ul>
+{{- range .Clips}}
+<li><a href="{{.Path}}">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="" loading="lazy">{{end}}<div>{{.Title}}</div></a></li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+<p><a href="/">Open {{.Site}}</a></p>
+</main>
+</body>
+</html>
+`))
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} · {{.Site}}</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; overflow: hidden; font: 14px system-ui, sans-serif; }
+video { width: 100%; height: 100%; object-fit: contain; }
+a { position: absolute; top: 8px; left: 8px; right: 8px; color: #fff; text-decoration: none; text-shadow: 0 1px 3px #000; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+</style>
+</head>
+<body>
+<video src="{{.Stream}}" poster="{{.Poster}}" controls playsinline preload="metadata"></video>
+<a href="{{.URL}}" target="_blank" rel="noopener">{{.Title}}</a>
+</body>
+</html>
+`))
+
+// writePage renders a page with its security headers.
+func writePage(w http.ResponseWriter, tmpl *template.Template, status int, policy string, meta pageMeta) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, meta); err != nil {
+		log.Printf("share: render %s: %v", tmpl.Name(), err)
+		http.Error(w, "failed to render page", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", policy)
+	if policy == embedPolicy {
+		w.Header().Del("X-Frame-Options")
+	}
+	if status == 200 {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// writeMissing answers a page request for a clip or collection that is not
+// public: 410 when a clip was taken down, 404 otherwise.
+func (h *Handler) writeMissing(w http.ResponseWriter, r *http.Request, clipID string) {
+	meta := pageMeta{Site: h.instanceName(r.Context()), Title: "Not found", Type: "website", NotFound: true}
+	status := 404
+	if clipID != "" {
+		var reason string
+		if h.DB.QueryRowContext(r.Context(),
+			`SELECT reason FROM clip_tombstones WHERE clip_id = ?`, clipID).Scan(&reason) == nil {
+			meta.Title, status = "This clip is no longer available", 410
+		}
+	}
+	writePage(w, pageTemplate, status, pagePolicy, meta)
+}
+
+// clipMeta describes a clip for link unfurlers. stream is the URL the page
+// plays, relative to this instance.
+func (h *Handler) clipMeta(r *http.Request, c *publicClip, stream string) pageMeta {
+	base := h.baseURL()
+	page := base + ClipPath(c.ID)
+	meta := pageMeta{
+		Site: h.instanceName(r.Context()), Title: c.Title, Description: c.Description,
+		URL: page, Type: "video.other",
+		Player: h.embedURL(base, c.ID, c.Generation),
+		OEmbed: base + "/api/oembed?format=json&url=" + url.QueryEscape(page),
+		Stream: stream, Poster: httputil.ThumbnailURL(h.MinioBucket, c.ThumbnailKey),
+	}
+	meta.PlayerW, meta.PlayerH = c.playerSize(0, 0)
+	if meta.Image = c.thumbnailURL(base, h.MinioBucket); meta.Image != "" {
+		meta.ImageW, meta.ImageH = c.thumbnailSize()
+	}
+	if c.ChannelName != nil {
+		meta.Channel = *c.ChannelName
+	}
+	if c.SourceURL != nil {
+		meta.SourceURL = *c.SourceURL
+	}
+	return meta
+}
+
+// streamURL issues the URL a page plays the clip from, or "" when none can
+// be issued; the page still unfurls without it.
+func (h *Handler) streamURL(ctx context.Context, c *publicClip) string {
+	if h.PresignStream == nil {
+		return ""
+	}
+	u, err := h.PresignStream(ctx, c.StorageKey, embedStreamExpiry)
+	if err != nil {
+		log.Printf("share: stream URL for %s: %v", c.ID, err)
+		return ""
+	}
+	return u
+}
+
+// HandleClipPage serves the public page of a ready clip, with the Open
+// Graph, Twitter card, and oEmbed discovery tags that let links to it
+// unfurl.
+func (h *Handler) HandleClipPage(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	c, err := h.loadClip(r.Context(), id)
+	if err != nil {
+		h.writeMissing(w, r, id)
+		return
+	}
+	writePage(w, pageTemplate, 200, pagePolicy, h.clipMeta(r, c, h.streamURL(r.Context(), c)))
+}
+
+// HandleEmbed serves the player other sites frame. Its URL must carry the
+// signature oEmbed and the clip page issue.
+func (h *Handler) HandleEmbed(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	c, err := h.loadClip(r.Context(), id)
+	if err != nil {
+		h.writeMissing(w, r, id)
+		return
+	}
+	want := embedSignature(h.Secret, c.ID, c.Generation)
+	if !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(want)) {
+		h.writeMissing(w, r, "")
+		return
+	}
+	writePage(w, embedTemplate, 200, embedPolicy, h.clipMeta(r, c, h.streamURL(r.Context(), c)))
+}
+
+// publicCollection loads a public collection with its cover key.
+func (h *Handler) publicCollection(ctx context.Context, id string) (title, description, coverKey string, err error) {
+	var desc, cover *string
+	err = h.DB.QueryRowContext(ctx,
+		`SELECT title, description, cover_key FROM collections WHERE id = ? AND is_public = 1`, id,
+	).Scan(&title, &desc, &cover)
+	if desc != nil {
+		description = *desc
+	}
+	if cover != nil {
+		coverKey = *cover
+	}
+	return title, description, coverKey, err
+}
+
+// HandleCollectionPage serves the public page of a public collection,
+// listing its ready clips.
+func (h *Handler) HandleCollectionPage(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	title, description, coverKey, err := h.publicCollection(r.Context(), id)
+	if err != nil {
+		h.writeMissing(w, r, "")
+		return
+	}
+
+	base := h.baseURL()
+	meta := pageMeta{
+		Site: h.instanceName(r.Context()), Title: title, Description: description,
+		URL: base + CollectionPath(id), Type: "website",
+	}
+	if coverKey != "" {
+		meta.Image = base + collections.CoverURL(id, coverKey)
+		meta.ImageW, meta.ImageH = collections.CoverSize, collections.CoverSize
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT c.id, c.title, COALESCE(c.thumbnail_key, '')
+		FROM collection_clips cc
+		JOIN clips c ON cc.clip_id = c.id
+		WHERE cc.collection_id = ? AND c.status = 'ready'
+		  AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id)
+		ORDER BY cc.position ASC, cc.added_at DESC
+		LIMIT ?
+	`, id, maxCollectionPageClips)
+	if err != nil {
+		http.Error(w, "failed to load collection", 500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var clipID, clipTitle, thumbKey string
+		if rows.Scan(&clipID, &clipTitle, &thumbKey) != nil {
+			continue
+		}
+		meta.Clips = append(meta.Clips, pageClip{
+			Path: ClipPath(clipID), Title: clipTitle, Thumbnail: httputil.ThumbnailURL(h.MinioBucket, thumbKey),
+		})
+	}
+	if meta.Image == "" && len(meta.Clips) > 0 && meta.Clips[0].Thumbnail != "" {
+		meta.Image = base + meta.Clips[0].Thumbnail
+		meta.ImageW, meta.ImageH = thumbnailWidth, thumbnailWidth*16/9
+	}
+	writePage(w, pageTemplate, 200, pagePolicy, meta)
+}
@@ -0,0 +1,157 @@
+// Package share publishes an instance's public content outside the app: a
+// page per clip and public collection that unfurls when linked, an
+// embeddable player, oEmbed for the sites links are pasted into, and a
+// sitemap for search engines.
+package share
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/settings"
+)
+
+const (
+	// embedStreamExpiry is how long the stream URL an embed page plays
+	// stays valid. Reloading the page issues a new one.
+	embedStreamExpiry = 2 * time.Hour
+
+	// thumbnailWidth is the width the worker renders clip thumbnails at;
+	// their height follows the clip's aspect ratio.
+	thumbnailWidth = 480
+
+	// Player size when neither the clip nor the consumer gives one; clips
+	// are usually vertical.
+	defaultPlayerWidth  = 360
+	defaultPlayerHeight = 640
+)
+
+// Handler serves public pages, the embed player, oEmbed, and the sitemap.
+type Handler struct {
+	DB          *db.CompatDB
+	MinioBucket string
+	// BaseURL is the scheme and host public links use, e.g.
+	// "https://clips.example.com". It is required: links are never built
+	// from the Host and X-Forwarded-Proto headers, which clients control
+	// and which would end up in publicly cached pages.
+	BaseURL string
+	// Secret signs embed player URLs.
+	Secret string
+	// PresignStream issues a playable URL for a clip's storage key.
+	PresignStream func(ctx context.Context, storageKey string, expiry time.Duration) (string, error)
+}
+
+// baseURL is the scheme and host public links start with.
+func (h *Handler) baseURL() string {
+	return strings.TrimRight(h.BaseURL, "/")
+}
+
+// ValidBaseURL reports whether s can be a Handler's BaseURL: an absolute
+// http or https URL with a host and no query or fragment.
+func ValidBaseURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.RawQuery == "" && u.Fragment == ""
+}
+
+// ClipPath and CollectionPath are the paths of public pages.
+func ClipPath(id string) string       { return "/clips/" + id }
+func CollectionPath(id string) string { return "/collections/" + id }
+
+// embedSignature signs an embed URL for clipID. Revoking a clip's streams
+// bumps its stream generation, which breaks the embed URLs issued before.
+func embedSignature(secret, clipID string, generation int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "embed\n%s\n%d", clipID, generation)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// embedURL is the signed player URL of a clip.
+func (h *Handler) embedURL(base, clipID string, generation int64) string {
+	return base + "/embed/" + clipID + "?sig=" + embedSignature(h.Secret, clipID, generation)
+}
+
+// publicClip is a clip as public pages show it.
+type publicClip struct {
+	ID, Title, Description string
+	ThumbnailKey           string
+	StorageKey             string
+	Duration               float64
+	Width, Height          *int64
+	Generation             int64
+	CreatedAt              string
+	ChannelName, SourceURL *string
+}
+
+// loadClip loads a ready clip that has not been taken down.
+func (h *Handler) loadClip(ctx context.Context, id string) (*publicClip, error) {
+	c := &publicClip{}
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT c.id, c.title, COALESCE(c.description, ''), COALESCE(c.thumbnail_key, ''), c.storage_key,
+		       c.duration_seconds, c.width, c.height, c.stream_generation, c.created_at,
+		       s.channel_name, s.url
+		FROM clips c
+		LEFT JOIN sources s ON c.source_id = s.id
+		WHERE c.id = ? AND c.status = 'ready'
+		  AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id)
+	`, id).Scan(&c.ID, &c.Title, &c.Description, &c.ThumbnailKey, &c.StorageKey,
+		&c.Duration, &c.Width, &c.Height, &c.Generation, &c.CreatedAt,
+		&c.ChannelName, &c.SourceURL)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// thumbnailURL is the absolute URL of the clip's thumbnail, or "" without
+// one.
+func (c *publicClip) thumbnailURL(base, bucket string) string {
+	if c.ThumbnailKey == "" {
+		return ""
+	}
+	return base + httputil.ThumbnailURL(bucket, c.ThumbnailKey)
+}
+
+// thumbnailSize is the size of the clip's thumbnail.
+func (c *publicClip) thumbnailSize() (int, int) {
+	w, h := c.playerSize(0, 0)
+	return thumbnailWidth, thumbnailWidth * h / w
+}
+
+// playerSize is the size of an embedded player for the clip, scaled down to
+// fit maxWidth and maxHeight when they are positive.
+func (c *publicClip) playerSize(maxWidth, maxHeight int) (int, int) {
+	w, h := defaultPlayerWidth, defaultPlayerHeight
+	if c.Width != nil && c.Height != nil && *c.Width > 0 && *c.Height > 0 {
+		// Keep the clip's aspect ratio at the default player's larger side.
+		if *c.Width >= *c.Height {
+			w, h = defaultPlayerHeight, int(int64(defaultPlayerHeight)**c.Height / *c.Width)
+		} else {
+			w, h = int(int64(defaultPlayerHeight)**c.Width / *c.Height), defaultPlayerHeight
+		}
+	}
+	if maxWidth > 0 && w > maxWidth {
+		w, h = maxWidth, h*maxWidth/w
+	}
+	if maxHeight > 0 && h > maxHeight {
+		w, h = w*maxHeight/h, maxHeight
+	}
+	return max(w, 1), max(h, 1)
+}
+
+// instanceName is the name public pages are published under.
+func (h *Handler) instanceName(ctx context.Context) string {
+	s, err := settings.Load(ctx, h.DB)
+	if err != nil || s.InstanceName == "" {
+		return settings.DefaultInstanceName
+	}
+	return s.InstanceName
+}
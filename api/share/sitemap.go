@@ -0,0 +1,68 @@
+package share
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+)
+
+// maxSitemapURLs is the most URLs one sitemap may list. Past it, the
+// newest clips are listed.
+const maxSitemapURLs = 50000
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// HandleSitemap lists the public pages of the instance for search engines:
+// the home page, every public collection, and ready clips, newest first.
+func (h *Handler) HandleSitemap(w http.ResponseWriter, r *http.Request) {
+	base := h.baseURL()
+	set := urlSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	set.URLs = append(set.URLs, sitemapURL{Loc: base + "/"})
+
+	add := func(query string, path func(string) string) bool {
+		rows, err := h.DB.QueryContext(r.Context(), query, maxSitemapURLs-len(set.URLs))
+		if err != nil {
+			log.Printf("share: sitemap: %v", err)
+			return false
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, lastMod string
+			if rows.Scan(&id, &lastMod) == nil {
+				set.URLs = append(set.URLs, sitemapURL{Loc: base + path(id), LastMod: lastMod})
+			}
+		}
+		return rows.Err() == nil
+	}
+	ok := add(`
+		SELECT id, COALESCE(refreshed_at, created_at) FROM collections
+		WHERE is_public = 1 ORDER BY created_at DESC LIMIT ?
+	`, CollectionPath) && add(`
+		SELECT c.id, c.created_at FROM clips c
+		WHERE c.status = 'ready' AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = c.id)
+		ORDER BY c.created_at DESC LIMIT ?
+	`, ClipPath)
+	if !ok {
+		http.Error(w, "failed to build sitemap", 500)
+		return
+	}
+
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		http.Error(w, "failed to build sitemap", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
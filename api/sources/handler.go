@@ -0,0 +1,367 @@
+// Package sources lets users review what they have ingested: each submitted
+// source with the clips it produced, and deleting a source along with them.
+package sources
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"clipfeed/auth"
+	"clipfeed/clips"
+	"clipfeed/db"
+	"clipfeed/httputil"
+	"clipfeed/jobs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	defaultSourcesLimit = 50
+	maxSourcesLimit     = 200
+)
+
+// Handler holds dependencies for the ingest history endpoints.
+type Handler struct {
+	DB          *db.CompatDB
+	Minio       *minio.Client
+	MinioBucket string
+}
+
+// HandleListMySources lists the sources the user submitted, newest first,
+// with how many clips each produced and the storage its ready clips use.
+// The totals come with the user's storage quota, if any. Rejected sources
+// carry the worker's rejection: a reason code, the measurements behind it,
+// and for duplicates the clip they duplicate.
+// Supports limit (default 50, max 200) and offset.
+func (h *Handler) HandleListMySources(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	limit := defaultSourcesLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= maxSourcesLimit {
+		limit = n
+	}
+	offset := 0
+	if n, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT s.id, s.url, s.platform, s.title, s.channel_name, s.thumbnail_url,
+		       COALESCE(s.status, ''), COALESCE(s.clip_strategy, ''), s.created_at,
+		       COUNT(c.id),
+		       COALESCE(SUM(CASE WHEN c.status = 'ready' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN c.is_protected = 1 THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN c.status = 'ready' THEN c.file_size_bytes ELSE 0 END), 0),
+		       (SELECT j.result FROM jobs j WHERE j.source_id = s.id AND j.status = 'rejected'
+		        ORDER BY j.completed_at DESC LIMIT 1),
+		       (SELECT j.error FROM jobs j WHERE j.source_id = s.id AND j.status = 'rejected'
+		        ORDER BY j.completed_at DESC LIMIT 1)
+		FROM sources s
+		LEFT JOIN clips c ON c.source_id = s.id
+		WHERE s.submitted_by = ?
+		GROUP BY s.id
+		ORDER BY s.created_at DESC, s.id
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list sources"})
+		return
+	}
+	defer rows.Close()
+
+	sources := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, url, platform, status, strategy, createdAt string
+		var title, channelName, thumbnailURL *string
+		var clipCount, readyCount, protectedCount int
+		var storageBytes int64
+		var rejectResult, rejectError *string
+		if err := rows.Scan(&id, &url, &platform, &title, &channelName, &thumbnailURL,
+			&status, &strategy, &createdAt, &clipCount, &readyCount, &protectedCount, &storageBytes,
+			&rejectResult, &rejectError); err != nil {
+			continue
+		}
+		var rejection *jobs.Rejection
+		if status == "rejected" {
+			rejection = jobs.RejectionFromJob(deref(rejectResult), deref(rejectError))
+		}
+		sources = append(sources, map[string]interface{}{
+			"id": id, "url": url, "platform": platform, "title": title,
+			"channel_name": channelName, "thumbnail_url": thumbnailURL,
+			"status": status, "clip_strategy": strategy, "created_at": createdAt,
+			"clip_count":      clipCount,
+			"ready_clips":     readyCount,
+			"protected_clips": protectedCount,
+			"storage_bytes":   storageBytes,
+			"rejection":       rejection,
+		})
+	}
+
+	var total int
+	var totalBytes int64
+	h.DB.QueryRowContext(r.Context(), `
+		SELECT COUNT(DISTINCT s.id), COALESCE(SUM(CASE WHEN c.status = 'ready' THEN c.file_size_bytes ELSE 0 END), 0)
+		FROM sources s
+		LEFT JOIN clips c ON c.source_id = s.id
+		WHERE s.submitted_by = ?
+	`, userID).Scan(&total, &totalBytes)
+
+	var quota *int64
+	h.DB.QueryRowContext(r.Context(), `SELECT storage_quota_bytes FROM users WHERE id = ?`, userID).Scan(&quota)
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"sources":             sources,
+		"total":               total,
+		"total_storage_bytes": totalBytes,
+		"storage_quota_bytes": quota,
+	})
+}
+
+// HandleListSourceClips lists every clip produced from one of the user's
+// sources, whatever its status, in source order.
+func (h *Handler) HandleListSourceClips(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	sourceID := chi.URLParam(r, "id")
+
+	var url, platform, status, strategy string
+	var title *string
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT url, platform, title, COALESCE(status, ''), COALESCE(clip_strategy, '') FROM sources WHERE id = ? AND submitted_by = ?`,
+		sourceID, userID).Scan(&url, &platform, &title, &status, &strategy); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "source not found"})
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, title, duration_seconds, thumbnail_key, COALESCE(status, ''),
+		       COALESCE(is_protected, 0), file_size_bytes, start_time, end_time, created_at
+		FROM clips
+		WHERE source_id = ?
+		  AND NOT EXISTS (SELECT 1 FROM clip_tombstones tomb WHERE tomb.clip_id = clips.id)
+		ORDER BY COALESCE(start_time, 0), created_at
+	`, sourceID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list clips"})
+		return
+	}
+	defer rows.Close()
+
+	clips := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, clipStatus, createdAt string
+		var clipTitle, thumbnailKey *string
+		var duration float64
+		var protected int
+		var fileSize *int64
+		var startTime, endTime *float64
+		if err := rows.Scan(&id, &clipTitle, &duration, &thumbnailKey, &clipStatus,
+			&protected, &fileSize, &startTime, &endTime, &createdAt); err != nil {
+			continue
+		}
+		thumb := ""
+		if thumbnailKey != nil {
+			thumb = *thumbnailKey
+		}
+		clips = append(clips, map[string]interface{}{
+			"id": id, "title": clipTitle, "duration_seconds": duration,
+			"duration_bucket": httputil.DurationBucket(duration),
+			"thumbnail_url": httputil.ThumbnailURL(h.MinioBucket, thumb),
+			"status":        clipStatus, "is_protected": protected == 1,
+			"file_size_bytes": fileSize,
+			"start_time":      startTime, "end_time": endTime,
+			"created_at": createdAt,
+		})
+	}
+
+	var rejection *jobs.Rejection
+	if status == "rejected" {
+		var result, errMsg *string
+		h.DB.QueryRowContext(r.Context(), `
+			SELECT result, error FROM jobs WHERE source_id = ? AND status = 'rejected'
+			ORDER BY completed_at DESC LIMIT 1
+		`, sourceID).Scan(&result, &errMsg)
+		rejection = jobs.RejectionFromJob(deref(result), deref(errMsg))
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"source": map[string]interface{}{
+			"id": sourceID, "url": url, "platform": platform, "title": title, "status": status,
+			"clip_strategy": strategy, "rejection": rejection,
+		},
+		"clips": clips,
+	})
+}
+
+// HandleDeleteSource deletes one of the user's sources with its clips, their
+// stored media, and its finished jobs. Protected clips (saved by someone)
+// are kept, and so is the source while any remain, so their attribution
+// survives. Sources with queued or running jobs must be cancelled first.
+func (h *Handler) HandleDeleteSource(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	sourceID := chi.URLParam(r, "id")
+
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(),
+		`SELECT 1 FROM sources WHERE id = ? AND submitted_by = ?`, sourceID, userID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "source not found"})
+		return
+	}
+	var active int
+	h.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM jobs WHERE source_id = ? AND status IN ('queued', 'running')`, sourceID).Scan(&active)
+	if active > 0 {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "source is still processing; cancel its jobs first"})
+		return
+	}
+
+	var objectKeys []string
+	var deleted, protected int
+	var freedBytes int64
+	sourceDeleted := false
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		rows, err := conn.QueryContext(r.Context(),
+			`SELECT id FROM clips WHERE source_id = ? AND COALESCE(is_protected, 0) = 0`, sourceID)
+		if err != nil {
+			return fmt.Errorf("load clips: %w", err)
+		}
+		var clipIDs []interface{}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan clip: %w", err)
+			}
+			clipIDs = append(clipIDs, id)
+		}
+		rows.Close()
+
+		if objectKeys, freedBytes, err = deleteClips(r.Context(), conn, clipIDs); err != nil {
+			return err
+		}
+		deleted = len(clipIDs)
+
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM jobs WHERE source_id = ?`, sourceID); err != nil {
+			return fmt.Errorf("delete jobs: %w", err)
+		}
+		if err := conn.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM clips WHERE source_id = ?`, sourceID).Scan(&protected); err != nil {
+			return fmt.Errorf("count kept clips: %w", err)
+		}
+		if protected == 0 {
+			if _, err := conn.ExecContext(r.Context(), `DELETE FROM sources WHERE id = ?`, sourceID); err != nil {
+				return fmt.Errorf("delete source: %w", err)
+			}
+			sourceDeleted = true
+		}
+		return nil
+	}); err != nil {
+		log.Printf("delete source %s: %v", sourceID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete source"})
+		return
+	}
+
+	// Media goes after the rows, so a failure leaves orphaned objects rather
+	// than clips pointing at missing files.
+	h.removeObjects(r.Context(), objectKeys)
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"status":          "deleted",
+		"deleted_clips":   deleted,
+		"protected_clips": protected,
+		"source_deleted":  sourceDeleted,
+		"freed_bytes":     freedBytes,
+	})
+}
+
+// deleteClips deletes clips with their search entries, leaving tombstones,
+// and returns the media objects left to remove and the bytes their ready
+// clips used. It runs in the caller's transaction.
+func deleteClips(ctx context.Context, conn *db.CompatConn, clipIDs []interface{}) ([]string, int64, error) {
+	if len(clipIDs) == 0 {
+		return nil, 0, nil
+	}
+	in := "(?" + strings.Repeat(", ?", len(clipIDs)-1) + ")"
+	var objectKeys []string
+	var freedBytes int64
+	rows, err := conn.QueryContext(ctx, `
+		SELECT storage_key, COALESCE(thumbnail_key, ''), COALESCE(file_size_bytes, 0), COALESCE(status, ''),
+		       COALESCE(low_storage_key, ''), COALESCE(small_thumbnail_key, ''), COALESCE(large_thumbnail_key, ''),
+		       COALESCE(hls_key, '')
+		FROM clips WHERE id IN `+in, clipIDs...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("load clips: %w", err)
+	}
+	for rows.Next() {
+		var storageKey, thumbnailKey, status, lowKey, smallThumbKey, largeThumbKey, hlsKey string
+		var size int64
+		if err := rows.Scan(&storageKey, &thumbnailKey, &size, &status, &lowKey, &smallThumbKey, &largeThumbKey, &hlsKey); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("scan clip: %w", err)
+		}
+		objectKeys = append(objectKeys, storageKey, thumbnailKey, lowKey, smallThumbKey, largeThumbKey, hlsKey)
+		if status == "ready" {
+			freedBytes += size
+		}
+	}
+	rows.Close()
+
+	thumbs, err := conn.QueryContext(ctx, `
+		SELECT thumbnail_key FROM clip_thumbnails WHERE clip_id IN `+in+`
+		UNION ALL
+		SELECT sprite_key FROM clip_storyboard_sprites WHERE clip_id IN `+in+`
+		UNION ALL
+		SELECT storage_key FROM clip_previews WHERE storage_key IS NOT NULL AND clip_id IN `+in+`
+		UNION ALL
+		SELECT playlist_key FROM clip_hls_variants WHERE clip_id IN `+in+`
+		UNION ALL
+		SELECT segment_key FROM clip_hls_segments WHERE clip_id IN `+in,
+		append(append(append(append(append([]interface{}{}, clipIDs...), clipIDs...), clipIDs...), clipIDs...), clipIDs...)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("load thumbnails, sprites, previews, and HLS renditions: %w", err)
+	}
+	for thumbs.Next() {
+		var key string
+		if thumbs.Scan(&key) == nil {
+			objectKeys = append(objectKeys, key)
+		}
+	}
+	thumbs.Close()
+
+	if err := clips.Bury(ctx, conn, clips.TombstoneDeleted, "", clipIDs...); err != nil {
+		return nil, 0, fmt.Errorf("record tombstones: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM clips_fts WHERE clip_id IN `+in, clipIDs...); err != nil {
+		return nil, 0, fmt.Errorf("delete search entries: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM clips WHERE id IN `+in, clipIDs...); err != nil {
+		return nil, 0, fmt.Errorf("delete clips: %w", err)
+	}
+	return objectKeys, freedBytes, nil
+}
+
+func (h *Handler) removeObjects(ctx context.Context, keys []string) {
+	if h.Minio == nil {
+		return
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := h.Minio.RemoveObject(ctx, h.MinioBucket, key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("sources: remove %s: %v", key, err)
+		}
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,260 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"clipfeed/auth"
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+const (
+	// quotaWarnRatio is the share of a storage quota past which the user
+	// is warned and cleanup suggestions aim to bring usage back under.
+	quotaWarnRatio = 0.8
+
+	defaultSuggestionAgeDays = 30
+	defaultSuggestionsLimit  = 50
+	maxSuggestionsLimit      = 200
+	maxBulkClips             = 200
+
+	// lowScore is the content score under which a suggested clip is
+	// called out as low scoring.
+	lowScore = 0.4
+)
+
+// Storage is a user's storage use against their soft quota.
+type Storage struct {
+	UsedBytes  int64  `json:"used_bytes"`
+	QuotaBytes *int64 `json:"quota_bytes"`
+	// State is "ok", "warning" past quotaWarnRatio of the quota, or
+	// "over" past the quota itself.
+	State string `json:"state"`
+}
+
+// warnBytes is the usage past which the user is warned, or -1 without a
+// quota.
+func (s Storage) warnBytes() int64 {
+	if s.QuotaBytes == nil {
+		return -1
+	}
+	return int64(float64(*s.QuotaBytes) * quotaWarnRatio)
+}
+
+// storage computes the user's storage use: the ready clips of the sources
+// they submitted.
+func (h *Handler) storage(ctx context.Context, userID string) (Storage, error) {
+	var s Storage
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(c.file_size_bytes), 0) FROM clips c
+		JOIN sources src ON c.source_id = src.id
+		WHERE src.submitted_by = ? AND c.status = 'ready'
+	`, userID).Scan(&s.UsedBytes); err != nil {
+		return s, err
+	}
+	if err := h.DB.QueryRowContext(ctx,
+		`SELECT storage_quota_bytes FROM users WHERE id = ?`, userID).Scan(&s.QuotaBytes); err != nil {
+		return s, err
+	}
+	switch {
+	case s.QuotaBytes != nil && s.UsedBytes > *s.QuotaBytes:
+		s.State = "over"
+	case s.QuotaBytes != nil && s.UsedBytes > s.warnBytes():
+		s.State = "warning"
+	default:
+		s.State = "ok"
+	}
+	return s, nil
+}
+
+// HandleGetStorage reports the user's storage use against their quota.
+func (h *Handler) HandleGetStorage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	s, err := h.storage(r.Context(), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load storage"})
+		return
+	}
+	httputil.WriteJSON(w, 200, s)
+}
+
+// HandleCleanupSuggestions lists clips of the user's sources worth
+// deleting to free storage: ready clips older than min_age_days (default
+// 30) that nobody saved or added to a collection, unwatched ones first and
+// then the lowest scoring. clip_ids holds the fewest of them, in order,
+// that bring usage back under the warning threshold; it is empty while
+// usage is under it. Supports limit (default 50, max 200).
+func (h *Handler) HandleCleanupSuggestions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	q := r.URL.Query()
+	minAgeDays := defaultSuggestionAgeDays
+	if v := q.Get("min_age_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "min_age_days must be a non-negative integer"})
+			return
+		}
+		minAgeDays = n
+	}
+	limit := defaultSuggestionsLimit
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n <= maxSuggestionsLimit {
+		limit = n
+	}
+
+	s, err := h.storage(r.Context(), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load storage"})
+		return
+	}
+	var neededBytes int64
+	if warn := s.warnBytes(); warn >= 0 && s.UsedBytes > warn {
+		neededBytes = s.UsedBytes - warn
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -minAgeDays).Format("2006-01-02T15:04:05Z")
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, title, duration_seconds, thumbnail_key, size, score, created_at, source_id, source_title, views
+		FROM (
+			SELECT c.id, c.title, c.duration_seconds, c.thumbnail_key, COALESCE(c.file_size_bytes, 0) AS size,
+			       COALESCE(c.content_score, 0) AS score, c.created_at, src.id AS source_id, src.title AS source_title,
+			       (SELECT COUNT(*) FROM interactions i WHERE i.clip_id = c.id AND i.action = 'view')
+			         + COALESCE((SELECT cr.sessions FROM clip_retention cr WHERE cr.clip_id = c.id), 0) AS views
+			FROM clips c
+			JOIN sources src ON c.source_id = src.id
+			WHERE src.submitted_by = ? AND c.status = 'ready' AND COALESCE(c.is_protected, 0) = 0
+			  AND c.created_at < ?
+			  AND NOT EXISTS (SELECT 1 FROM saved_clips sc WHERE sc.clip_id = c.id)
+			  AND NOT EXISTS (SELECT 1 FROM collection_clips cc WHERE cc.clip_id = c.id)
+		) candidates
+		ORDER BY CASE WHEN views = 0 THEN 0 ELSE 1 END, score, created_at, id
+		LIMIT ?
+	`, userID, cutoff, limit)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load suggestions"})
+		return
+	}
+	defer rows.Close()
+
+	suggestions := make([]map[string]interface{}, 0)
+	clipIDs := make([]string, 0)
+	var suggestedBytes, selectedBytes int64
+	for rows.Next() {
+		var id, createdAt, sourceID string
+		var title, thumbnailKey, sourceTitle *string
+		var duration, score float64
+		var size int64
+		var views int
+		if err := rows.Scan(&id, &title, &duration, &thumbnailKey, &size,
+			&score, &createdAt, &sourceID, &sourceTitle, &views); err != nil {
+			continue
+		}
+		reasons := []string{"old"}
+		if views == 0 {
+			reasons = append(reasons, "unwatched")
+		}
+		if score < lowScore {
+			reasons = append(reasons, "low_score")
+		}
+		suggestions = append(suggestions, map[string]interface{}{
+			"id": id, "title": title, "duration_seconds": duration,
+			"thumbnail_url":   httputil.ThumbnailURL(h.MinioBucket, deref(thumbnailKey)),
+			"file_size_bytes": size, "content_score": score, "views": views,
+			"created_at": createdAt, "source_id": sourceID, "source_title": sourceTitle,
+			"reasons": reasons,
+		})
+		suggestedBytes += size
+		if selectedBytes < neededBytes {
+			clipIDs = append(clipIDs, id)
+			selectedBytes += size
+		}
+	}
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"storage":         s,
+		"needed_bytes":    neededBytes,
+		"suggestions":     suggestions,
+		"suggested_bytes": suggestedBytes,
+		"clip_ids":        clipIDs,
+	})
+}
+
+// HandleBulkClips applies an action to many clips of the user's sources at
+// once. The only action is "delete", which deletes the clips with their
+// media like deleting a source does; protected clips are skipped. The
+// response carries the user's storage recalculated afterwards.
+func (h *Handler) HandleBulkClips(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserIDKey).(string)
+	var req struct {
+		Action  string   `json:"action"`
+		ClipIDs []string `json:"clip_ids"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Action != "delete" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": `action must be "delete"`})
+		return
+	}
+	if len(req.ClipIDs) == 0 || len(req.ClipIDs) > maxBulkClips {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("clip_ids must list 1-%d clips", maxBulkClips)})
+		return
+	}
+
+	var objectKeys []string
+	var freedBytes int64
+	var deleted int
+	skipped := make([]map[string]string, 0)
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var clipIDs []interface{}
+		seen := make(map[string]bool, len(req.ClipIDs))
+		for _, id := range req.ClipIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			var protected int
+			err := conn.QueryRowContext(r.Context(), `
+				SELECT COALESCE(c.is_protected, 0) FROM clips c
+				JOIN sources src ON c.source_id = src.id
+				WHERE c.id = ? AND src.submitted_by = ?
+			`, id, userID).Scan(&protected)
+			switch {
+			case err != nil:
+				skipped = append(skipped, map[string]string{"clip_id": id, "reason": "not_found"})
+			case protected == 1:
+				skipped = append(skipped, map[string]string{"clip_id": id, "reason": "protected"})
+			default:
+				clipIDs = append(clipIDs, id)
+			}
+		}
+		var err error
+		objectKeys, freedBytes, err = deleteClips(r.Context(), conn, clipIDs)
+		deleted = len(clipIDs)
+		return err
+	}); err != nil {
+		log.Printf("bulk delete clips for %s: %v", userID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to delete clips"})
+		return
+	}
+	h.removeObjects(r.Context(), objectKeys)
+
+	s, err := h.storage(r.Context(), userID)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load storage"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"action":      req.Action,
+		"deleted":     deleted,
+		"skipped":     skipped,
+		"freed_bytes": freedBytes,
+		"storage":     s,
+	})
+}
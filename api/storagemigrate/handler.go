@@ -0,0 +1,312 @@
+package storagemigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"clipfeed/crypto"
+	"clipfeed/db"
+	"clipfeed/httputil"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxProblems caps the failed and missing objects a migration's details
+// list.
+const maxProblems = 50
+
+var bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// HandleStart starts a migration to the target in the request body. Keys
+// starting with prefix_from are stored under prefix_to instead, and
+// rewritten once every object is copied.
+func (h *Handler) HandleStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target struct {
+			Endpoint  string `json:"endpoint"`
+			Bucket    string `json:"bucket"`
+			AccessKey string `json:"access_key"`
+			SecretKey string `json:"secret_key"`
+			UseSSL    bool   `json:"use_ssl"`
+			Region    string `json:"region"`
+		} `json:"target"`
+		PrefixFrom string `json:"prefix_from"`
+		PrefixTo   string `json:"prefix_to"`
+		DryRun     bool   `json:"dry_run"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	t := Target{
+		Endpoint: strings.TrimSpace(req.Target.Endpoint), Bucket: strings.TrimSpace(req.Target.Bucket),
+		AccessKey: req.Target.AccessKey, SecretKey: req.Target.SecretKey,
+		UseSSL: req.Target.UseSSL, Region: strings.TrimSpace(req.Target.Region),
+	}
+	if !bucketPattern.MatchString(t.Bucket) {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "target.bucket must be a valid bucket name"})
+		return
+	}
+	if t.Endpoint != "" && (t.AccessKey == "" || t.SecretKey == "") {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "target.access_key and target.secret_key are required with an endpoint"})
+		return
+	}
+	if strings.HasPrefix(req.PrefixFrom, "/") || strings.HasPrefix(req.PrefixTo, "/") {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "prefixes must be relative"})
+		return
+	}
+	if t.Endpoint == "" && t.Bucket == h.SourceBucket && req.PrefixFrom == req.PrefixTo {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "target is the current storage"})
+		return
+	}
+
+	if !req.DryRun {
+		target, err := h.OpenTarget(t)
+		if err == nil {
+			// A key that cannot exist tells a reachable, writable-looking
+			// bucket from a wrong endpoint, bucket, or credentials.
+			_, err = target.Stat(r.Context(), ".clipfeed-migration-probe/"+uuid.New().String())
+			if errors.Is(err, ErrMissing) {
+				err = nil
+			}
+		}
+		if err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "target is not reachable: " + err.Error()})
+			return
+		}
+	}
+
+	var secret interface{}
+	if t.SecretKey != "" {
+		enc, err := crypto.EncryptCookie(t.SecretKey, h.CookieSecret)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to start migration"})
+			return
+		}
+		secret = enc
+	}
+	targetJSON, _ := json.Marshal(t)
+	dryRun := 0
+	if req.DryRun {
+		dryRun = 1
+	}
+
+	id := uuid.New().String()
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var running int
+		if err := conn.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM storage_migrations WHERE status = 'running'`).Scan(&running); err != nil {
+			return err
+		}
+		if running > 0 {
+			return errAlreadyRunning
+		}
+		_, err := conn.ExecContext(r.Context(), `
+			INSERT INTO storage_migrations (id, dry_run, target, target_secret, prefix_from, prefix_to)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, id, dryRun, string(targetJSON), secret, req.PrefixFrom, req.PrefixTo)
+		return err
+	})
+	if errors.Is(err, errAlreadyRunning) {
+		httputil.WriteJSON(w, 409, map[string]string{"error": errAlreadyRunning.Error()})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to start migration"})
+		return
+	}
+	h.start(id)
+	httputil.WriteJSON(w, 202, map[string]interface{}{"id": id, "status": "running", "dry_run": req.DryRun})
+}
+
+var errAlreadyRunning = errors.New("another storage migration is running")
+
+// summary loads migration id with its progress.
+func (h *Handler) summary(ctx context.Context, id string) (map[string]interface{}, error) {
+	var status, target, prefixFrom, prefixTo, createdAt, updatedAt string
+	var lastError, finishedAt *string
+	var dryRun int
+	var rowsRewritten int64
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT status, dry_run, target, prefix_from, prefix_to, rows_rewritten, last_error,
+		       created_at, updated_at, finished_at
+		FROM storage_migrations WHERE id = ?
+	`, id).Scan(&status, &dryRun, &target, &prefixFrom, &prefixTo, &rowsRewritten, &lastError,
+		&createdAt, &updatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{"pending": 0, "copied": 0, "planned": 0, "missing": 0, "failed": 0}
+	var total, bytes, renamed int64
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT status, COUNT(*), COALESCE(SUM(size_bytes), 0),
+		       COALESCE(SUM(CASE WHEN new_key != key THEN 1 ELSE 0 END), 0)
+		FROM storage_migration_items WHERE migration_id = ?
+		GROUP BY status
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		var n, size, moved int64
+		if err := rows.Scan(&s, &n, &size, &moved); err != nil {
+			return nil, err
+		}
+		counts[s] = n
+		total += n
+		renamed += moved
+		if s == "copied" || s == "planned" {
+			bytes += size
+		}
+	}
+
+	var t Target
+	json.Unmarshal([]byte(target), &t)
+	return map[string]interface{}{
+		"id": id, "status": status, "dry_run": dryRun == 1, "target": t,
+		"prefix_from": prefixFrom, "prefix_to": prefixTo,
+		"objects": total, "items": counts, "bytes": bytes,
+		// Keys that change prefix, and the rows pointed at them once done.
+		"keys_renamed": renamed, "rows_rewritten": rowsRewritten,
+		"last_error": lastError, "created_at": createdAt, "updated_at": updatedAt, "finished_at": finishedAt,
+	}, nil
+}
+
+// HandleList lists storage migrations, newest first, with their progress.
+func (h *Handler) HandleList(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id FROM storage_migrations ORDER BY created_at DESC, id LIMIT 50`)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list migrations"})
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	migrations := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		m, err := h.summary(r.Context(), id)
+		if err != nil {
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to list migrations"})
+			return
+		}
+		migrations = append(migrations, m)
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"migrations": migrations})
+}
+
+// HandleGet returns a migration's progress and the objects that failed to
+// copy or were missing from the source.
+func (h *Handler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	m, err := h.summary(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "migration not found"})
+		return
+	}
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load migration"})
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT key, new_key, status, attempts, error FROM storage_migration_items
+		WHERE migration_id = ? AND status IN ('failed', 'missing')
+		ORDER BY status, key LIMIT ?
+	`, id, maxProblems)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load migration"})
+		return
+	}
+	defer rows.Close()
+	problems := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var key, newKey, status string
+		var attempts int
+		var errMsg *string
+		if err := rows.Scan(&key, &newKey, &status, &attempts, &errMsg); err != nil {
+			continue
+		}
+		problems = append(problems, map[string]interface{}{
+			"key": key, "new_key": newKey, "status": status, "attempts": attempts, "error": errMsg,
+		})
+	}
+	m["problems"] = problems
+	httputil.WriteJSON(w, 200, m)
+}
+
+// HandlePause stops a running migration after its current batch.
+func (h *Handler) HandlePause(w http.ResponseWriter, r *http.Request) {
+	res, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		UPDATE storage_migrations SET status = 'paused', updated_at = %s
+		WHERE id = ? AND status = 'running'
+	`, h.DB.NowUTC()), chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to pause migration"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 409, map[string]string{"error": "migration is not running"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "paused"})
+}
+
+// HandleResume restarts a paused or failed migration. Failed objects are
+// tried again, and objects uploaded since it stopped are added.
+func (h *Handler) HandleResume(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		var running int
+		if err := conn.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM storage_migrations WHERE status = 'running'`).Scan(&running); err != nil {
+			return err
+		}
+		if running > 0 {
+			return errAlreadyRunning
+		}
+		res, err := conn.ExecContext(r.Context(), fmt.Sprintf(`
+			UPDATE storage_migrations SET status = 'running', last_error = NULL, updated_at = %s
+			WHERE id = ? AND status IN ('paused', 'failed')
+		`, h.DB.NowUTC()), id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		_, err = conn.ExecContext(r.Context(), `
+			UPDATE storage_migration_items SET status = 'pending', attempts = 0, error = NULL
+			WHERE migration_id = ? AND status = 'failed'
+		`, id)
+		return err
+	})
+	switch {
+	case errors.Is(err, errAlreadyRunning):
+		httputil.WriteJSON(w, 409, map[string]string{"error": errAlreadyRunning.Error()})
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		httputil.WriteJSON(w, 409, map[string]string{"error": "only paused or failed migrations can be resumed"})
+		return
+	case err != nil:
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to resume migration"})
+		return
+	}
+	h.start(id)
+	httputil.WriteJSON(w, 200, map[string]string{"status": "running"})
+}
@@ -0,0 +1,549 @@
+// Package storagemigrate moves media to a new object storage backend, as
+// when an instance switches from MinIO to S3 or renames its bucket. A
+// migration lists every object key the database references, copies each
+// object to the target and checks the copy's size, and once all of them are
+// across rewrites the keys in the database if their prefix changes. Progress
+// is kept per object, so a paused or interrupted migration resumes where it
+// stopped. A dry run only checks the source objects and reports what would
+// be moved.
+package storagemigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"clipfeed/crypto"
+	"clipfeed/db"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// batchSize is how many items are claimed from the queue at a time.
+	batchSize = 50
+	// maxAttempts is how often copying an object is tried before the item
+	// fails.
+	maxAttempts = 3
+)
+
+// ErrMissing is returned by Store.Stat for an object that does not exist.
+var ErrMissing = errors.New("object missing")
+
+// keyColumns are the columns that hold object keys.
+var keyColumns = []struct{ Table, Column string }{
+	{"clips", "storage_key"},
+	{"clips", "thumbnail_key"},
+	{"clips", "hls_key"},
+	{"clips", "low_storage_key"},
+	{"clips", "small_thumbnail_key"},
+	{"clips", "large_thumbnail_key"},
+	{"clip_thumbnails", "thumbnail_key"},
+	{"clip_storyboard_sprites", "sprite_key"},
+	{"clip_hls_variants", "playlist_key"},
+	{"clip_hls_segments", "segment_key"},
+	{"clip_previews", "storage_key"},
+	{"federated_clips", "storage_key"},
+	{"collections", "cover_key"},
+	{"users", "avatar_key"},
+}
+
+// Object describes a stored object.
+type Object struct {
+	Size        int64
+	ContentType string
+}
+
+// Store is one object storage backend.
+type Store interface {
+	Stat(ctx context.Context, key string) (Object, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	Put(ctx context.Context, key string, r io.Reader, obj Object) error
+}
+
+// MinioStore is a Store over a bucket of a MinIO or S3 server.
+type MinioStore struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// Stat HEADs the object at key.
+func (s MinioStore) Stat(ctx context.Context, key string) (Object, error) {
+	info, err := s.Client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return Object{}, ErrMissing
+		}
+		return Object{}, err
+	}
+	return Object{Size: info.Size, ContentType: info.ContentType}, nil
+}
+
+// Get opens the object at key.
+func (s MinioStore) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Object{}, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, Object{}, ErrMissing
+		}
+		return nil, Object{}, err
+	}
+	return obj, Object{Size: info.Size, ContentType: info.ContentType}, nil
+}
+
+// Put uploads r under key.
+func (s MinioStore) Put(ctx context.Context, key string, r io.Reader, obj Object) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, r, obj.Size,
+		minio.PutObjectOptions{ContentType: obj.ContentType})
+	return err
+}
+
+// Target is the backend a migration copies to.
+type Target struct {
+	// Endpoint is the target server's host[:port]; empty means the source
+	// server, for moving to another bucket on it.
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"-"`
+	UseSSL    bool   `json:"use_ssl"`
+	Region    string `json:"region"`
+}
+
+// MinioOpener returns an opener for Handler.OpenTarget that connects to a
+// target's server, or reuses source for targets on the same server.
+func MinioOpener(source *minio.Client) func(Target) (Store, error) {
+	return func(t Target) (Store, error) {
+		if t.Endpoint == "" {
+			return MinioStore{Client: source, Bucket: t.Bucket}, nil
+		}
+		client, err := minio.New(t.Endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(t.AccessKey, t.SecretKey, ""),
+			Secure: t.UseSSL,
+			Region: t.Region,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return MinioStore{Client: client, Bucket: t.Bucket}, nil
+	}
+}
+
+// migration is a stored migration's settings.
+type migration struct {
+	ID         string
+	DryRun     bool
+	Target     Target
+	PrefixFrom string
+	PrefixTo   string
+}
+
+// newKey is where key is stored on the target.
+func (m *migration) newKey(key string) string {
+	if m.PrefixFrom == m.PrefixTo || !strings.HasPrefix(key, m.PrefixFrom) {
+		return key
+	}
+	return m.PrefixTo + strings.TrimPrefix(key, m.PrefixFrom)
+}
+
+// Handler runs storage migrations and serves their admin endpoints.
+type Handler struct {
+	DB           *db.CompatDB
+	CookieSecret string
+	// Source is the storage the instance uses now, SourceBucket its bucket.
+	Source       Store
+	SourceBucket string
+	// OpenTarget connects to a migration's target.
+	OpenTarget func(Target) (Store, error)
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// ResumeRunning restarts the migrations that were running when the server
+// last stopped.
+func (h *Handler) ResumeRunning() {
+	rows, err := h.DB.QueryContext(context.Background(),
+		`SELECT id FROM storage_migrations WHERE status = 'running'`)
+	if err != nil {
+		log.Printf("storage migration: resume: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		h.start(id)
+	}
+}
+
+// start runs migration id in the background unless it already is.
+func (h *Handler) start(id string) {
+	h.mu.Lock()
+	if h.running == nil {
+		h.running = make(map[string]bool)
+	}
+	if h.running[id] {
+		h.mu.Unlock()
+		return
+	}
+	h.running[id] = true
+	h.mu.Unlock()
+
+	go func() {
+		ctx := context.Background()
+		if err := h.Run(ctx, id); err != nil {
+			log.Printf("storage migration %s: %v", id, err)
+			h.DB.ExecContext(ctx, fmt.Sprintf(`
+				UPDATE storage_migrations SET status = 'failed', last_error = ?, updated_at = %s
+				WHERE id = ? AND status = 'running'
+			`, h.DB.NowUTC()), err.Error(), id)
+		}
+		h.mu.Lock()
+		delete(h.running, id)
+		h.mu.Unlock()
+		// A resume that came in while this run was stopping found it still
+		// running and left it to carry on.
+		var status string
+		if h.DB.QueryRowContext(ctx, `SELECT status FROM storage_migrations WHERE id = ?`, id).Scan(&status) == nil &&
+			status == "running" {
+			h.start(id)
+		}
+	}()
+}
+
+// load reads migration id's settings.
+func (h *Handler) load(ctx context.Context, id string) (*migration, error) {
+	var m migration
+	var target string
+	var secret *string
+	var dryRun int
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT id, dry_run, target, target_secret, prefix_from, prefix_to
+		FROM storage_migrations WHERE id = ?
+	`, id).Scan(&m.ID, &dryRun, &target, &secret, &m.PrefixFrom, &m.PrefixTo); err != nil {
+		return nil, err
+	}
+	m.DryRun = dryRun == 1
+	if err := json.Unmarshal([]byte(target), &m.Target); err != nil {
+		return nil, fmt.Errorf("decode target: %w", err)
+	}
+	if secret != nil {
+		plain, err := crypto.DecryptCookie(*secret, h.CookieSecret)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt target secret: %w", err)
+		}
+		m.Target.SecretKey = plain
+	}
+	return &m, nil
+}
+
+// scan adds the keys the database references that migration m does not
+// list yet, returning how many were added.
+func (h *Handler) scan(ctx context.Context, m *migration) (int, error) {
+	selects := make([]string, len(keyColumns))
+	for i, c := range keyColumns {
+		selects[i] = fmt.Sprintf(`SELECT %s AS k FROM %s WHERE %s IS NOT NULL AND %s != ''`,
+			c.Column, c.Table, c.Column, c.Column)
+	}
+	rows, err := h.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT DISTINCT k FROM (%s) refs
+		WHERE k NOT IN (SELECT key FROM storage_migration_items WHERE migration_id = ?)
+	`, strings.Join(selects, " UNION ALL ")), m.ID)
+	if err != nil {
+		return 0, fmt.Errorf("list keys: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var k string
+		if rows.Scan(&k) == nil {
+			keys = append(keys, k)
+		}
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	err = db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		for _, k := range keys {
+			if _, err := conn.ExecContext(ctx, `
+				INSERT INTO storage_migration_items (migration_id, key, new_key) VALUES (?, ?, ?)
+				ON CONFLICT DO NOTHING
+			`, m.ID, k, m.newKey(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("queue keys: %w", err)
+	}
+	return len(keys), nil
+}
+
+// Run works through migration id until its queue is empty or it is
+// paused, then finishes it: a dry run completes, a migration with failed
+// items fails, and any other has its keys rewritten and completes.
+func (h *Handler) Run(ctx context.Context, id string) error {
+	m, err := h.load(ctx, id)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	var target Store
+	if !m.DryRun {
+		if target, err = h.OpenTarget(m.Target); err != nil {
+			return fmt.Errorf("open target: %w", err)
+		}
+	}
+	if _, err := h.scan(ctx, m); err != nil {
+		return err
+	}
+
+	for {
+		var status string
+		if err := h.DB.QueryRowContext(ctx,
+			`SELECT status FROM storage_migrations WHERE id = ?`, id).Scan(&status); err != nil {
+			return fmt.Errorf("load status: %w", err)
+		}
+		if status != "running" {
+			return nil
+		}
+
+		rows, err := h.DB.QueryContext(ctx, `
+			SELECT key, new_key, attempts FROM storage_migration_items
+			WHERE migration_id = ? AND status = 'pending'
+			ORDER BY key LIMIT ?
+		`, id, batchSize)
+		if err != nil {
+			return fmt.Errorf("load items: %w", err)
+		}
+		type item struct {
+			key, newKey string
+			attempts    int
+		}
+		var batch []item
+		for rows.Next() {
+			var it item
+			if rows.Scan(&it.key, &it.newKey, &it.attempts) == nil {
+				batch = append(batch, it)
+			}
+		}
+		rows.Close()
+
+		if len(batch) == 0 {
+			// Objects uploaded since the scan need copying too.
+			added, err := h.scan(ctx, m)
+			if err != nil {
+				return err
+			}
+			if added == 0 {
+				return h.finish(ctx, m)
+			}
+			continue
+		}
+
+		for _, it := range batch {
+			status, size, err := h.moveObject(ctx, m, target, it.key, it.newKey)
+			attempts := it.attempts + 1
+			var errMsg interface{}
+			if err != nil {
+				errMsg = err.Error()
+				status = "failed"
+				if attempts < maxAttempts {
+					status = "pending"
+				}
+			}
+			var sizeArg interface{}
+			if size >= 0 {
+				sizeArg = size
+			}
+			if _, err := h.DB.ExecContext(ctx, `
+				UPDATE storage_migration_items SET status = ?, size_bytes = ?, attempts = ?, error = ?
+				WHERE migration_id = ? AND key = ?
+			`, status, sizeArg, attempts, errMsg, id, it.key); err != nil {
+				return fmt.Errorf("record item: %w", err)
+			}
+		}
+		h.DB.ExecContext(ctx, fmt.Sprintf(
+			`UPDATE storage_migrations SET updated_at = %s WHERE id = ?`, h.DB.NowUTC()), id)
+	}
+}
+
+// moveObject copies key to newKey on target and checks that the copy is
+// complete, returning the item's new status and the object's size (-1 when
+// unknown). An object already on the target at the right size is not
+// copied again. In a dry run the source object is only checked.
+func (h *Handler) moveObject(ctx context.Context, m *migration, target Store, key, newKey string) (string, int64, error) {
+	src, err := h.Source.Stat(ctx, key)
+	if errors.Is(err, ErrMissing) {
+		return "missing", -1, nil
+	}
+	if err != nil {
+		return "", -1, fmt.Errorf("stat source: %w", err)
+	}
+	if m.DryRun {
+		return "planned", src.Size, nil
+	}
+
+	if dst, err := target.Stat(ctx, newKey); err == nil && dst.Size == src.Size {
+		return "copied", src.Size, nil
+	}
+	body, obj, err := h.Source.Get(ctx, key)
+	if errors.Is(err, ErrMissing) {
+		return "missing", -1, nil
+	}
+	if err != nil {
+		return "", src.Size, fmt.Errorf("read source: %w", err)
+	}
+	err = target.Put(ctx, newKey, body, obj)
+	body.Close()
+	if err != nil {
+		return "", src.Size, fmt.Errorf("write target: %w", err)
+	}
+	dst, err := target.Stat(ctx, newKey)
+	if err != nil {
+		return "", src.Size, fmt.Errorf("verify copy: %w", err)
+	}
+	if dst.Size != obj.Size {
+		return "", src.Size, fmt.Errorf("copy is %d bytes, source is %d", dst.Size, obj.Size)
+	}
+	return "copied", obj.Size, nil
+}
+
+// finish completes migration m once its queue is empty.
+func (h *Handler) finish(ctx context.Context, m *migration) error {
+	var failed int
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM storage_migration_items WHERE migration_id = ? AND status = 'failed'
+	`, m.ID).Scan(&failed); err != nil {
+		return err
+	}
+	if failed > 0 {
+		_, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE storage_migrations SET status = 'failed', last_error = ?, updated_at = %s
+			WHERE id = ? AND status = 'running'
+		`, h.DB.NowUTC()), fmt.Sprintf("%d objects could not be copied; keys were not rewritten", failed), m.ID)
+		return err
+	}
+
+	return db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		var rewritten int64
+		if !m.DryRun && m.PrefixFrom != m.PrefixTo {
+			n, err := rewriteKeys(ctx, conn, m.ID)
+			if err != nil {
+				return fmt.Errorf("rewrite keys: %w", err)
+			}
+			rewritten = n
+		}
+		_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE storage_migrations
+			SET status = 'completed', rows_rewritten = ?, last_error = NULL,
+			    updated_at = %s, finished_at = %s
+			WHERE id = ? AND status = 'running'
+		`, h.DB.NowUTC(), h.DB.NowUTC()), rewritten, m.ID)
+		return err
+	})
+}
+
+// rewriteKeys points every key column at the new keys of migration id's
+// moved objects and returns how many rows changed. Keys the migration did
+// not move are left alone.
+func rewriteKeys(ctx context.Context, conn *db.CompatConn, id string) (int64, error) {
+	moved := `SELECT key FROM storage_migration_items
+		WHERE migration_id = ? AND status IN ('copied', 'missing') AND new_key != key`
+
+	// Storyboard cues may name a sprite sheet by its full key.
+	rows, err := conn.QueryContext(ctx, `
+		SELECT s.clip_id, i.key, i.new_key
+		FROM clip_storyboard_sprites s
+		JOIN storage_migration_items i ON i.key = s.sprite_key
+		WHERE i.migration_id = ? AND i.status IN ('copied', 'missing') AND i.new_key != i.key
+	`, id)
+	if err != nil {
+		return 0, fmt.Errorf("storyboards: %w", err)
+	}
+	renames := map[string][]string{}
+	for rows.Next() {
+		var clipID, key, newKey string
+		if rows.Scan(&clipID, &key, &newKey) == nil {
+			renames[clipID] = append(renames[clipID], key, newKey)
+		}
+	}
+	rows.Close()
+	for clipID, pairs := range renames {
+		var vtt string
+		if err := conn.QueryRowContext(ctx,
+			`SELECT vtt FROM clip_storyboards WHERE clip_id = ?`, clipID).Scan(&vtt); err != nil {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, `UPDATE clip_storyboards SET vtt = ? WHERE clip_id = ?`,
+			strings.NewReplacer(pairs...).Replace(vtt), clipID); err != nil {
+			return 0, fmt.Errorf("storyboard of %s: %w", clipID, err)
+		}
+	}
+
+	// So may HLS media playlists name their segments.
+	rows, err = conn.QueryContext(ctx, `
+		SELECT s.clip_id, s.variant, i.key, i.new_key
+		FROM clip_hls_segments s
+		JOIN storage_migration_items i ON i.key = s.segment_key
+		WHERE i.migration_id = ? AND i.status IN ('copied', 'missing') AND i.new_key != i.key
+	`, id)
+	if err != nil {
+		return 0, fmt.Errorf("hls playlists: %w", err)
+	}
+	type variantRef struct{ clipID, name string }
+	playlistRenames := map[variantRef][]string{}
+	for rows.Next() {
+		var v variantRef
+		var key, newKey string
+		if rows.Scan(&v.clipID, &v.name, &key, &newKey) == nil {
+			playlistRenames[v] = append(playlistRenames[v], key, newKey)
+		}
+	}
+	rows.Close()
+	for v, pairs := range playlistRenames {
+		var playlist string
+		if err := conn.QueryRowContext(ctx,
+			`SELECT playlist FROM clip_hls_variants WHERE clip_id = ? AND name = ?`, v.clipID, v.name).Scan(&playlist); err != nil {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, `UPDATE clip_hls_variants SET playlist = ? WHERE clip_id = ? AND name = ?`,
+			strings.NewReplacer(pairs...).Replace(playlist), v.clipID, v.name); err != nil {
+			return 0, fmt.Errorf("hls playlist %s of %s: %w", v.name, v.clipID, err)
+		}
+	}
+
+	var total int64
+	for _, c := range keyColumns {
+		res, err := conn.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s SET %s = (
+				SELECT new_key FROM storage_migration_items
+				WHERE migration_id = ? AND key = %s.%s
+			)
+			WHERE %s IN (%s)
+		`, c.Table, c.Column, c.Table, c.Column, c.Column, moved), id, id)
+		if err != nil {
+			return 0, fmt.Errorf("%s.%s: %w", c.Table, c.Column, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
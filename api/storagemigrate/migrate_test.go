@@ -0,0 +1,313 @@
+package storagemigrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"clipfeed/db"
+
+	"github.com/go-chi/chi/v5"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+// memStore keeps objects in memory. Writes to keys in truncate lose their
+// last byte.
+type memStore struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	truncate map[string]bool
+	puts     int
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: map[string][]byte{}, truncate: map[string]bool{}}
+}
+
+func (s *memStore) Stat(_ context.Context, key string) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return Object{}, ErrMissing
+	}
+	return Object{Size: int64(len(data)), ContentType: "video/mp4"}, nil
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	obj, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, obj, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(s.objects[key])), obj, nil
+}
+
+func (s *memStore) Put(_ context.Context, key string, r io.Reader, _ Object) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if s.truncate[key] && len(data) > 0 {
+		data = data[:len(data)-1]
+	}
+	s.objects[key] = data
+	return nil
+}
+
+// seed adds two clips, one with a storyboard, and stores their objects in
+// source except clip b's thumbnail.
+func seed(t *testing.T, cdb *db.CompatDB, source *memStore) {
+	t.Helper()
+	for _, q := range []string{
+		`INSERT INTO sources (id, url, platform) VALUES ('s1', 'https://example.com/v', 'direct')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status)
+		 VALUES ('a', 's1', 'A', 30, 'clips/a/clip.mp4', 'clips/a/thumb.jpg', 'ready')`,
+		`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, thumbnail_key, status)
+		 VALUES ('b', 's1', 'B', 30, 'clips/b/clip.mp4', 'clips/b/thumb.jpg', 'ready')`,
+		`INSERT INTO clip_storyboards (clip_id, vtt) VALUES ('a', 'WEBVTT
+
+00:00.000 --> 00:05.000
+clips/a/sprite.jpg#xywh=0,0,160,90')`,
+		`INSERT INTO clip_storyboard_sprites (clip_id, sprite_index, sprite_key) VALUES ('a', 0, 'clips/a/sprite.jpg')`,
+	} {
+		if _, err := cdb.Exec(q); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	for _, key := range []string{"clips/a/clip.mp4", "clips/a/thumb.jpg", "clips/a/sprite.jpg", "clips/b/clip.mp4"} {
+		source.objects[key] = []byte("data of " + key)
+	}
+}
+
+func newMigration(t *testing.T, cdb *db.CompatDB, dryRun bool, prefixFrom, prefixTo string) string {
+	t.Helper()
+	dry := 0
+	if dryRun {
+		dry = 1
+	}
+	id := "m-" + prefixTo
+	if _, err := cdb.Exec(`
+		INSERT INTO storage_migrations (id, dry_run, target, prefix_from, prefix_to)
+		VALUES (?, ?, '{"bucket":"new-bucket"}', ?, ?)
+	`, id, dry, prefixFrom, prefixTo); err != nil {
+		t.Fatalf("insert migration: %v", err)
+	}
+	return id
+}
+
+// waitStopped waits for migration id to stop running in the background.
+func waitStopped(t *testing.T, cdb *db.CompatDB, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var status string
+		cdb.QueryRow(`SELECT status FROM storage_migrations WHERE id = ?`, id).Scan(&status)
+		if status != "running" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("migration %s still running", id)
+}
+
+func TestRun_DryRunReportsWithoutCopying(t *testing.T) {
+	cdb := newTestDB(t)
+	source, target := newMemStore(), newMemStore()
+	seed(t, cdb, source)
+	h := &Handler{DB: cdb, Source: source, OpenTarget: func(Target) (Store, error) { return target, nil }}
+
+	id := newMigration(t, cdb, true, "clips/", "media/clips/")
+	if err := h.Run(context.Background(), id); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	m, err := h.summary(context.Background(), id)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	items := m["items"].(map[string]int64)
+	if m["status"] != "completed" || items["planned"] != 4 || items["missing"] != 1 || m["keys_renamed"] != int64(5) {
+		t.Errorf("dry run summary = %v", m)
+	}
+	if target.puts != 0 {
+		t.Errorf("dry run wrote %d objects", target.puts)
+	}
+	var key string
+	cdb.QueryRow(`SELECT storage_key FROM clips WHERE id = 'a'`).Scan(&key)
+	if key != "clips/a/clip.mp4" {
+		t.Errorf("dry run rewrote storage_key to %q", key)
+	}
+}
+
+func TestRun_CopiesVerifiesAndRewritesKeys(t *testing.T) {
+	cdb := newTestDB(t)
+	source, target := newMemStore(), newMemStore()
+	seed(t, cdb, source)
+	target.truncate["media/clips/a/clip.mp4"] = true
+	h := &Handler{DB: cdb, Source: source, OpenTarget: func(Target) (Store, error) { return target, nil }}
+
+	// A copy that comes out short fails verification, and no keys move.
+	id := newMigration(t, cdb, false, "clips/", "media/clips/")
+	if err := h.Run(context.Background(), id); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	m, _ := h.summary(context.Background(), id)
+	if m["status"] != "failed" || m["items"].(map[string]int64)["failed"] != 1 {
+		t.Fatalf("summary with a bad copy = %v", m)
+	}
+	var key string
+	cdb.QueryRow(`SELECT storage_key FROM clips WHERE id = 'b'`).Scan(&key)
+	if key != "clips/b/clip.mp4" {
+		t.Errorf("keys rewritten despite a failed copy: %q", key)
+	}
+
+	// Resuming retries the failed object; objects already copied are not
+	// copied again.
+	delete(target.truncate, "media/clips/a/clip.mp4")
+	puts := target.puts
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req := httptest.NewRequest("POST", "/api/admin/storage/migrations/"+id+"/resume", nil)
+	rec := httptest.NewRecorder()
+	h.HandleResume(rec, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+	if rec.Code != 200 {
+		t.Fatalf("resume status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	waitStopped(t, cdb, id)
+	if target.puts != puts+1 {
+		t.Errorf("resume wrote %d objects, want 1", target.puts-puts)
+	}
+	m, _ = h.summary(context.Background(), id)
+	if m["status"] != "completed" || m["items"].(map[string]int64)["copied"] != 4 {
+		t.Fatalf("summary after resume = %v", m)
+	}
+	for key, data := range source.objects {
+		moved := "media/" + key
+		if !bytes.Equal(target.objects[moved], data) {
+			t.Errorf("target %s = %q, want %q", moved, target.objects[moved], data)
+		}
+	}
+
+	var storage, thumb, sprite, vtt string
+	cdb.QueryRow(`SELECT storage_key, thumbnail_key FROM clips WHERE id = 'a'`).Scan(&storage, &thumb)
+	cdb.QueryRow(`SELECT sprite_key FROM clip_storyboard_sprites WHERE clip_id = 'a'`).Scan(&sprite)
+	cdb.QueryRow(`SELECT vtt FROM clip_storyboards WHERE clip_id = 'a'`).Scan(&vtt)
+	if storage != "media/clips/a/clip.mp4" || thumb != "media/clips/a/thumb.jpg" || sprite != "media/clips/a/sprite.jpg" {
+		t.Errorf("keys = %q, %q, %q, want them under media/", storage, thumb, sprite)
+	}
+	if !strings.Contains(vtt, "\nmedia/clips/a/sprite.jpg#xywh") {
+		t.Errorf("storyboard cues not rewritten: %q", vtt)
+	}
+	// b's thumbnail never existed; its key still follows the prefix.
+	cdb.QueryRow(`SELECT thumbnail_key FROM clips WHERE id = 'b'`).Scan(&thumb)
+	if thumb != "media/clips/b/thumb.jpg" {
+		t.Errorf("missing object's key = %q", thumb)
+	}
+	if m["rows_rewritten"] != int64(5) {
+		t.Errorf("rows_rewritten = %v, want 5", m["rows_rewritten"])
+	}
+}
+
+func TestRun_MovesCollectionCovers(t *testing.T) {
+	cdb := newTestDB(t)
+	source, target := newMemStore(), newMemStore()
+	for _, q := range []string{
+		`INSERT INTO users (id, username, email, password_hash) VALUES ('u1', 'u1', 'u1@example.com', 'x')`,
+		`INSERT INTO collections (id, user_id, title, cover_key) VALUES ('c1', 'u1', 'Mine', 'covers/c1.jpg')`,
+	} {
+		if _, err := cdb.Exec(q); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	source.objects["covers/c1.jpg"] = []byte("cover")
+	h := &Handler{DB: cdb, Source: source, OpenTarget: func(Target) (Store, error) { return target, nil }}
+
+	id := newMigration(t, cdb, false, "covers/", "media/covers/")
+	if err := h.Run(context.Background(), id); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if m, _ := h.summary(context.Background(), id); m["status"] != "completed" {
+		t.Fatalf("summary = %v", m)
+	}
+	if !bytes.Equal(target.objects["media/covers/c1.jpg"], []byte("cover")) {
+		t.Errorf("cover not copied: target has %v", target.objects)
+	}
+	var key string
+	cdb.QueryRow(`SELECT cover_key FROM collections WHERE id = 'c1'`).Scan(&key)
+	if key != "media/covers/c1.jpg" {
+		t.Errorf("cover_key = %q, want it under media/", key)
+	}
+}
+
+func TestHandleStart_ValidatesTargetAndAllowsOneAtATime(t *testing.T) {
+	cdb := newTestDB(t)
+	source := newMemStore()
+	h := &Handler{DB: cdb, Source: source, SourceBucket: "clipfeed", CookieSecret: "secret",
+		OpenTarget: func(Target) (Store, error) { return newMemStore(), nil }}
+	start := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		h.HandleStart(rec, httptest.NewRequest("POST", "/api/admin/storage/migrations", bytes.NewReader(b)))
+		return rec
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"target": map[string]interface{}{"bucket": "X"}},
+		{"target": map[string]interface{}{"bucket": "clipfeed"}},
+		{"target": map[string]interface{}{"bucket": "s3-media", "endpoint": "s3.amazonaws.com"}},
+	} {
+		if rec := start(body); rec.Code != 400 {
+			t.Errorf("start %v = %d, want 400", body, rec.Code)
+		}
+	}
+
+	// Hold the first migration so the second finds it running.
+	cdb.Exec(`INSERT INTO storage_migrations (id, target) VALUES ('held', '{}')`)
+	rec := start(map[string]interface{}{"target": map[string]interface{}{
+		"bucket": "s3-media", "endpoint": "s3.amazonaws.com", "access_key": "AK", "secret_key": "SK",
+	}})
+	if rec.Code != 409 {
+		t.Errorf("second migration status = %d, want 409", rec.Code)
+	}
+	cdb.Exec(`UPDATE storage_migrations SET status = 'paused' WHERE id = 'held'`)
+	rec = start(map[string]interface{}{"target": map[string]interface{}{
+		"bucket": "s3-media", "endpoint": "s3.amazonaws.com", "access_key": "AK", "secret_key": "SK",
+	}, "dry_run": true})
+	if rec.Code != 202 {
+		t.Fatalf("start status = %d; body: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	waitStopped(t, cdb, resp["id"].(string))
+	var stored, secret string
+	cdb.QueryRow(`SELECT target, target_secret FROM storage_migrations WHERE dry_run = 1`).Scan(&stored, &secret)
+	if strings.Contains(stored, "SK") || secret == "" || secret == "SK" {
+		t.Errorf("target secret stored as %q / %q, want encrypted", stored, secret)
+	}
+}
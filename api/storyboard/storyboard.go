@@ -0,0 +1,117 @@
+// Package storyboard checks and rewrites the WebVTT seek-preview indexes
+// workers upload with a clip's sprite sheets. Each cue's payload names a
+// sprite sheet and the tile to show, as in "storyboard_0.jpg#xywh=0,0,160,90".
+package storyboard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MaxSprites caps how many sprite sheets a clip's storyboard can use.
+const MaxSprites = 20
+
+// spriteIndex maps the names a cue may use for a sprite sheet, its full key
+// or its file name, to the key.
+func spriteIndex(spriteKeys []string) map[string]string {
+	index := make(map[string]string, len(spriteKeys)*2)
+	for _, key := range spriteKeys {
+		index[key] = key
+		index[path.Base(key)] = key
+	}
+	return index
+}
+
+// isCueTiming reports whether line is a cue's "start --> end" line.
+func isCueTiming(line string) bool {
+	return strings.Contains(line, "-->")
+}
+
+// Validate checks that vtt is a WebVTT file whose cues each point at a tile
+// of one of spriteKeys.
+func Validate(vtt string, spriteKeys []string) error {
+	if len(spriteKeys) == 0 || len(spriteKeys) > MaxSprites {
+		return fmt.Errorf("sprite_keys must have 1 to %d entries", MaxSprites)
+	}
+	for _, key := range spriteKeys {
+		if strings.TrimSpace(key) == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "..") {
+			return errors.New("sprite_keys must be relative storage keys")
+		}
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(vtt, "\ufeff"), "WEBVTT") {
+		return errors.New("vtt must start with WEBVTT")
+	}
+
+	index := spriteIndex(spriteKeys)
+	cues := 0
+	sc := bufio.NewScanner(strings.NewReader(vtt))
+	inCue := false
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			inCue = false
+		case isCueTiming(line):
+			inCue = true
+			cues++
+		case inCue:
+			name, fragment, ok := strings.Cut(line, "#")
+			if !ok || !strings.HasPrefix(fragment, "xywh=") {
+				return fmt.Errorf("cue %d: payload must be <sprite>#xywh=x,y,w,h", cues)
+			}
+			if _, known := index[name]; !known {
+				return fmt.Errorf("cue %d: unknown sprite %q", cues, name)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read vtt: %w", err)
+	}
+	if cues == 0 {
+		return errors.New("vtt has no cues")
+	}
+	return nil
+}
+
+// Rewrite replaces each cue's sprite name with the URL urlFor returns for
+// its key. urlFor is called once per sprite sheet.
+func Rewrite(vtt string, spriteKeys []string, urlFor func(key string) (string, error)) (string, error) {
+	index := spriteIndex(spriteKeys)
+	urls := make(map[string]string, len(spriteKeys))
+	var out strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(vtt))
+	inCue := false
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			inCue = false
+		case isCueTiming(trimmed):
+			inCue = true
+		case inCue:
+			if name, fragment, ok := strings.Cut(trimmed, "#"); ok {
+				if key, known := index[name]; known {
+					u, done := urls[key]
+					if !done {
+						var err error
+						if u, err = urlFor(key); err != nil {
+							return "", fmt.Errorf("sprite %s: %w", key, err)
+						}
+						urls[key] = u
+					}
+					line = u + "#" + fragment
+				}
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("read vtt: %w", err)
+	}
+	return out.String(), nil
+}
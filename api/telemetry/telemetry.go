@@ -0,0 +1,193 @@
+// Package telemetry sends opt-in, anonymous instance-level aggregates to an
+// endpoint the operator configures. Reports never contain user, clip, or
+// source identifiers: only counts (users bucketed), the server version, and
+// which optional features are switched on.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"clipfeed/db"
+	"clipfeed/httputil"
+)
+
+// reportSchemaVersion is bumped whenever fields are added to or removed
+// from Report, so collectors can tell old and new reports apart.
+const reportSchemaVersion = 1
+
+var telemetryClient = &http.Client{Timeout: 15 * time.Second}
+
+// Report is the complete payload sent to the telemetry endpoint.
+type Report struct {
+	SchemaVersion   int             `json:"schema_version"`
+	Version         string          `json:"version"`
+	Database        string          `json:"database"`
+	ClipCount       int             `json:"clip_count"`
+	UserCountBucket string          `json:"user_count_bucket"`
+	Features        map[string]bool `json:"features"`
+	GeneratedAt     string          `json:"generated_at"`
+}
+
+// Handler builds and sends telemetry reports. Nothing is sent unless
+// Enabled is true and Endpoint is set.
+type Handler struct {
+	DB       *db.CompatDB
+	Version  string
+	Enabled  bool
+	Endpoint string
+	Interval time.Duration
+	// Features lists the optional features this instance has switched on,
+	// keyed by a stable name.
+	Features map[string]bool
+
+	mu       sync.Mutex
+	lastSent string
+	lastErr  string
+}
+
+// userCountBucket coarsens the user count so reports cannot be used to
+// track an instance's exact growth.
+func userCountBucket(n int) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n <= 10:
+		return "1-10"
+	case n <= 100:
+		return "11-100"
+	case n <= 1000:
+		return "101-1000"
+	case n <= 10000:
+		return "1001-10000"
+	default:
+		return "10000+"
+	}
+}
+
+// BuildReport gathers the aggregates for one report.
+func (h *Handler) BuildReport(ctx context.Context) (Report, error) {
+	var clipCount, userCount int
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM clips WHERE status = 'ready'),
+			(SELECT COUNT(*) FROM users)
+	`).Scan(&clipCount, &userCount); err != nil {
+		return Report{}, err
+	}
+
+	features := make(map[string]bool, len(h.Features))
+	for k, v := range h.Features {
+		features[k] = v
+	}
+	database := "sqlite"
+	if h.DB.IsPostgres() {
+		database = "postgres"
+	}
+	return Report{
+		SchemaVersion:   reportSchemaVersion,
+		Version:         h.Version,
+		Database:        database,
+		ClipCount:       clipCount,
+		UserCountBucket: userCountBucket(userCount),
+		Features:        features,
+		GeneratedAt:     time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}, nil
+}
+
+// Send builds a report and POSTs it to the configured endpoint.
+func (h *Handler) Send(ctx context.Context) error {
+	if !h.Enabled || h.Endpoint == "" {
+		return nil
+	}
+	report, err := h.BuildReport(ctx)
+	if err == nil {
+		err = h.post(ctx, report)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastErr = err.Error()
+		return err
+	}
+	h.lastSent, h.lastErr = report.GeneratedAt, ""
+	return nil
+}
+
+func (h *Handler) post(ctx context.Context, report Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ClipFeed-Telemetry/"+h.Version)
+	resp, err := telemetryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("telemetry endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ReportLoop sends a report shortly after startup and then every Interval
+// (default 24 hours).
+func (h *Handler) ReportLoop() {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	time.Sleep(time.Minute)
+	h.sendAndLog()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.sendAndLog()
+	}
+}
+
+func (h *Handler) sendAndLog() {
+	if err := h.Send(context.Background()); err != nil {
+		log.Printf("telemetry: send report: %v", err)
+	}
+}
+
+// HandlePreview shows exactly the report that would be sent, along with
+// whether telemetry is enabled and where reports go.
+func (h *Handler) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	report, err := h.BuildReport(r.Context())
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to build telemetry report"})
+		return
+	}
+	interval := h.Interval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	h.mu.Lock()
+	lastSent, lastErr := h.lastSent, h.lastErr
+	h.mu.Unlock()
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"enabled":          h.Enabled && h.Endpoint != "",
+		"endpoint":         h.Endpoint,
+		"interval_seconds": int(interval.Seconds()),
+		"last_sent_at":     lastSent,
+		"last_error":       lastErr,
+		"report":           report,
+	})
+}
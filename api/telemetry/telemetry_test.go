@@ -0,0 +1,97 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestUserCountBucket(t *testing.T) {
+	for n, want := range map[int]string{0: "0", 1: "1-10", 10: "1-10", 11: "11-100", 500: "101-1000", 10000: "1001-10000", 10001: "10000+"} {
+		if got := userCountBucket(n); got != want {
+			t.Errorf("userCountBucket(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestSend_PostsPreviewedReportOnlyWhenEnabled(t *testing.T) {
+	cdb := newTestDB(t)
+	for _, id := range []string{"u1", "u2", "u3"} {
+		cdb.Exec(`INSERT INTO users (id, username, email, password_hash) VALUES (?, ?, ?, 'x')`, id, id, id+"@test.com")
+	}
+	cdb.Exec(`INSERT INTO sources (id, url, platform) VALUES ('s1', 'http://x.com/private-video', 'direct')`)
+	cdb.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('c1', 's1', 'Secret title', 30.0, 'k', 'ready')`)
+	cdb.Exec(`INSERT INTO clips (id, source_id, title, duration_seconds, storage_key, status) VALUES ('c2', 's1', 'Pending', 30.0, 'k', 'processing')`)
+
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	h := &Handler{DB: cdb, Version: "1.2.3", Endpoint: srv.URL, Features: map[string]bool{"federation": true}}
+	if err := h.Send(context.Background()); err != nil || received != nil {
+		t.Fatalf("disabled telemetry sent %q (err %v)", received, err)
+	}
+
+	h.Enabled = true
+	if err := h.Send(context.Background()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var sent map[string]interface{}
+	if err := json.Unmarshal(received, &sent); err != nil {
+		t.Fatalf("decode sent report: %v", err)
+	}
+	if sent["clip_count"] != 1.0 || sent["user_count_bucket"] != "1-10" || sent["database"] != "sqlite" || sent["version"] != "1.2.3" {
+		t.Errorf("sent report = %v", sent)
+	}
+	if f, _ := sent["features"].(map[string]interface{}); f["federation"] != true {
+		t.Errorf("features = %v, want federation enabled", sent["features"])
+	}
+
+	// The preview carries the same report fields and the last send.
+	rec := httptest.NewRecorder()
+	h.HandlePreview(rec, httptest.NewRequest("GET", "/api/admin/telemetry/preview", nil))
+	var preview struct {
+		Enabled    bool                   `json:"enabled"`
+		LastSentAt string                 `json:"last_sent_at"`
+		Report     map[string]interface{} `json:"report"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&preview); err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	if !preview.Enabled || preview.LastSentAt != sent["generated_at"] {
+		t.Errorf("preview enabled=%v last_sent_at=%q, want true and %v", preview.Enabled, preview.LastSentAt, sent["generated_at"])
+	}
+	for k := range sent {
+		if _, ok := preview.Report[k]; !ok {
+			t.Errorf("preview report missing %q", k)
+		}
+	}
+	if len(preview.Report) != len(sent) {
+		t.Errorf("preview report has %d fields, sent report %d", len(preview.Report), len(sent))
+	}
+}
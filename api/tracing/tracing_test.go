@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clipfeed/db"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	incomingTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incomingSpan  = "00f067aa0ba902b7"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in
+// memory for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prev)
+	})
+	return exporter
+}
+
+func spanNamed(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func attr(s *tracetest.SpanStub, key string) attribute.Value {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware_SpansRequestQueriesAndChildren(t *testing.T) {
+	exporter := recordSpans(t)
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawDB.Close()
+	cdb := db.NewCompatDB(rawDB, db.DialectSQLite)
+
+	var traceparent string
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/clips/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		cdb.QueryRowContext(r.Context(), `SELECT 1 WHERE 'secret' = ?`, "secret").Scan(&n)
+		traceparent = Traceparent(r.Context())
+		_, span := Start(r.Context(), "minio.presign")
+		End(span, errors.New("no such key"))
+		w.WriteHeader(502)
+	})
+
+	req := httptest.NewRequest("GET", "/api/clips/c1", nil)
+	req.Header.Set("traceparent", "00-"+incomingTrace+"-"+incomingSpan+"-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	server := spanNamed(spans, "GET /api/clips/{id}")
+	if server == nil {
+		t.Fatalf("spans = %v, want one named after the route", spans)
+	}
+	if server.SpanContext.TraceID().String() != incomingTrace || server.Parent.SpanID().String() != incomingSpan {
+		t.Errorf("server span trace %s parent %s, want the incoming traceparent", server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+	if attr(server, "http.response.status_code").AsInt64() != 502 || server.Status.Code != codes.Error {
+		t.Errorf("server span attributes %v status %v, want 502 and an error", server.Attributes, server.Status)
+	}
+	if !strings.HasPrefix(traceparent, "00-"+incomingTrace+"-") || strings.Contains(traceparent, incomingSpan) {
+		t.Errorf("traceparent in handler = %q, want the request's span", traceparent)
+	}
+
+	query := spanNamed(spans, "db SELECT")
+	if query == nil || query.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatalf("spans = %v, want a query span under the request", spans)
+	}
+	if stmt := attr(query, "db.statement").AsString(); stmt != `SELECT 1 WHERE 'secret' = ?` || attr(query, "db.system").AsString() != "sqlite" {
+		t.Errorf("query span attributes = %v, want the statement without its arguments", query.Attributes)
+	}
+	if presign := spanNamed(spans, "minio.presign"); presign == nil || presign.Status.Code != codes.Error || len(presign.Events) == 0 {
+		t.Errorf("presign span = %+v, want its error recorded", presign)
+	}
+}
+
+func TestUnaryServerInterceptor_ContinuesTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("traceparent", "00-"+incomingTrace+"-"+incomingSpan+"-01"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/clipfeed.worker.v1.WorkerService/ClaimJob"}
+	UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != info.FullMethod || spans[0].SpanContext.TraceID().String() != incomingTrace {
+		t.Errorf("spans = %v, want one for the call in the incoming trace", spans)
+	}
+}
+
+func TestSetup_OffWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if Enabled() {
+		t.Fatal("Enabled without an endpoint")
+	}
+	shutdown, err := Setup(context.Background(), "test")
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Errorf("Setup = %v, want a no-op", err)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	if Enabled() {
+		t.Error("Enabled with OTEL_SDK_DISABLED=true")
+	}
+}
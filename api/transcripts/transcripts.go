@@ -0,0 +1,118 @@
+// Package transcripts stores clip transcripts outside the clips row, split
+// into ordered chunks. Feed and ranking queries only need a transcript's
+// length, kept in clips.transcript_length; the text itself is loaded on
+// demand by the summary, transcript, merge, and topic suggestion paths.
+package transcripts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkSize is the most bytes stored in one chunk.
+const ChunkSize = 4096
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ErrNoChunk is returned by LoadChunk for a chunk the transcript lacks.
+var ErrNoChunk = errors.New("transcript chunk not found")
+
+// Chunk splits text into pieces of at most ChunkSize bytes, breaking after
+// whitespace where possible and never inside a UTF-8 sequence. Joining the
+// pieces gives back text.
+func Chunk(text string) []string {
+	var chunks []string
+	for len(text) > ChunkSize {
+		cut := ChunkSize
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if i := strings.LastIndexAny(text[:cut], " \n\t"); i > ChunkSize/2 {
+			cut = i + 1
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// Save replaces clipID's transcript and records its length on the clip.
+// Run it in the transaction that writes the clip.
+func Save(ctx context.Context, ex execer, clipID, text string) error {
+	if _, err := ex.ExecContext(ctx, `DELETE FROM clip_transcripts WHERE clip_id = ?`, clipID); err != nil {
+		return fmt.Errorf("clear transcript: %w", err)
+	}
+	for i, chunk := range Chunk(text) {
+		if _, err := ex.ExecContext(ctx,
+			`INSERT INTO clip_transcripts (clip_id, chunk, text) VALUES (?, ?, ?)`, clipID, i, chunk); err != nil {
+			return fmt.Errorf("insert transcript chunk: %w", err)
+		}
+	}
+	if _, err := ex.ExecContext(ctx,
+		`UPDATE clips SET transcript_length = ? WHERE id = ?`, utf8.RuneCountInString(text), clipID); err != nil {
+		return fmt.Errorf("update transcript length: %w", err)
+	}
+	return nil
+}
+
+// Load returns clipID's whole transcript, or "" if it has none.
+func Load(ctx context.Context, q querier, clipID string) (string, error) {
+	return LoadPrefix(ctx, q, clipID, 0)
+}
+
+// LoadPrefix returns at least the first maxRunes runes of clipID's
+// transcript, reading no more chunks than that needs. A maxRunes of 0 reads
+// the whole transcript.
+func LoadPrefix(ctx context.Context, q querier, clipID string, maxRunes int) (string, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT text FROM clip_transcripts WHERE clip_id = ? ORDER BY chunk`, clipID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var b strings.Builder
+	for rows.Next() {
+		var chunk string
+		if err := rows.Scan(&chunk); err != nil {
+			return "", err
+		}
+		b.WriteString(chunk)
+		// A rune takes at most utf8.UTFMax bytes, so this many bytes hold
+		// at least maxRunes runes.
+		if maxRunes > 0 && b.Len() >= maxRunes*utf8.UTFMax {
+			break
+		}
+	}
+	return b.String(), rows.Err()
+}
+
+// LoadChunk returns chunk n of clipID's transcript and how many chunks the
+// transcript has.
+func LoadChunk(ctx context.Context, q querier, clipID string, n int) (string, int, error) {
+	var count int
+	if err := q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM clip_transcripts WHERE clip_id = ?`, clipID).Scan(&count); err != nil {
+		return "", 0, err
+	}
+	if n < 0 || n >= count {
+		return "", count, ErrNoChunk
+	}
+	var text string
+	err := q.QueryRowContext(ctx,
+		`SELECT text FROM clip_transcripts WHERE clip_id = ? AND chunk = ?`, clipID, n).Scan(&text)
+	return text, count, err
+}
@@ -0,0 +1,93 @@
+package transcripts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"clipfeed/db"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *db.CompatDB {
+	t.Helper()
+	rawDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	rawDB.SetMaxOpenConns(1)
+	if err := db.RunMigrations(rawDB, db.DialectSQLite); err != nil {
+		t.Fatalf("schema migration: %v", err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	return db.NewCompatDB(rawDB, db.DialectSQLite)
+}
+
+func TestChunk_SplitsOnWhitespaceAndRuneBoundaries(t *testing.T) {
+	text := strings.Repeat("héllo wörld ", 1000)
+	chunks := Chunk(text)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	for i, c := range chunks {
+		if len(c) > ChunkSize || !utf8.ValidString(c) {
+			t.Errorf("chunk %d: %d bytes, valid UTF-8 %v", i, len(c), utf8.ValidString(c))
+		}
+		if i < len(chunks)-1 && !strings.HasSuffix(c, " ") {
+			t.Errorf("chunk %d does not end at a space", i)
+		}
+	}
+	if strings.Join(chunks, "") != text {
+		t.Error("joined chunks differ from the text")
+	}
+
+	// Text without spaces still splits, just not on a word boundary.
+	if chunks := Chunk(strings.Repeat("é", ChunkSize)); len(chunks) != 2 || !utf8.ValidString(chunks[0]) {
+		t.Errorf("unbroken text: %d chunks", len(chunks))
+	}
+	if len(Chunk("")) != 0 {
+		t.Error("empty text should have no chunks")
+	}
+}
+
+func TestSaveLoadAndLoadChunk(t *testing.T) {
+	cdb := newTestDB(t)
+	ctx := context.Background()
+	cdb.Exec(`INSERT INTO clips (id, title, duration_seconds, storage_key, status) VALUES ('c1', 'Clip', 30.0, 'k', 'ready')`)
+
+	text := strings.Repeat("word ", 2000)
+	if err := Save(ctx, cdb, "c1", text); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	var length int
+	cdb.QueryRow(`SELECT transcript_length FROM clips WHERE id = 'c1'`).Scan(&length)
+	if length != len(text) {
+		t.Errorf("transcript_length = %d, want %d", length, len(text))
+	}
+	if got, err := Load(ctx, cdb, "c1"); err != nil || got != text {
+		t.Errorf("load: %d bytes, err %v", len(got), err)
+	}
+	if got, _ := LoadPrefix(ctx, cdb, "c1", 10); got != Chunk(text)[0] {
+		t.Errorf("prefix read %d bytes, want only the first chunk", len(got))
+	}
+
+	first, chunks, err := LoadChunk(ctx, cdb, "c1", 0)
+	if err != nil || chunks != len(Chunk(text)) || !strings.HasPrefix(text, first) {
+		t.Errorf("chunk 0: %d chunks, err %v", chunks, err)
+	}
+	if _, _, err := LoadChunk(ctx, cdb, "c1", chunks); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("chunk past the end: err = %v, want ErrNoChunk", err)
+	}
+
+	// Saving again replaces the old chunks.
+	if err := Save(ctx, cdb, "c1", "short"); err != nil {
+		t.Fatalf("resave: %v", err)
+	}
+	if got, _ := Load(ctx, cdb, "c1"); got != "short" {
+		t.Errorf("after resave = %q, want short", got)
+	}
+}
@@ -0,0 +1,413 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clipfeed/httputil"
+	"clipfeed/jobs"
+)
+
+type contextKey string
+
+// workerIDKey is the context key holding the name a worker gave itself
+// with X-Worker-ID, or x-worker-id metadata over gRPC.
+const workerIDKey contextKey = "worker_id"
+
+const (
+	maxWorkerIDLen = 128
+
+	defaultAnalyticsHours = 24
+	maxAnalyticsHours     = 30 * 24
+	// recentWindow is the stretch at the end of the analysed window
+	// compared against the rest of it to flag a degraded segment.
+	recentWindow = 6 * time.Hour
+	// degradedMinJobs is how many jobs must have finished in recentWindow
+	// before a segment can be flagged degraded.
+	degradedMinJobs = 5
+	// degradedRatio flags a segment whose recent success rate fell under
+	// this share of its earlier rate.
+	degradedRatio = 0.5
+	topErrorCodes = 5
+
+	outcomeRetention     = 90 * 24 * time.Hour
+	outcomePruneInterval = 24 * time.Hour
+)
+
+func withWorkerID(ctx context.Context, workerID string) context.Context {
+	workerID = strings.TrimSpace(workerID)
+	if len(workerID) > maxWorkerIDLen {
+		workerID = workerID[:maxWorkerIDLen]
+	}
+	return context.WithValue(ctx, workerIDKey, workerID)
+}
+
+func workerIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(workerIDKey).(string)
+	return id
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// errorPatterns map substrings of a failed job's error, lowercased, to an
+// error code, first match winning. They follow the messages of yt-dlp and
+// ffmpeg, whose breakages the analytics are meant to surface.
+var errorPatterns = []struct {
+	code    string
+	matches []string
+}{
+	{"http_403", []string{"http error 403", "403: forbidden", "403 forbidden"}},
+	{"rate_limited", []string{"http error 429", "too many requests", "rate limit"}},
+	{"auth_required", []string{"sign in to confirm", "login required", "log in", "cookies"}},
+	{"unavailable", []string{"video unavailable", "private video", "has been removed", "not available", "http error 404"}},
+	{"unsupported_url", []string{"unsupported url"}},
+	{"timeout", []string{"timed out", "timeout"}},
+	{"extractor_error", []string{"unable to extract", "extractor", "unable to download"}},
+	{"ffmpeg_error", []string{"ffmpeg", "ffprobe"}},
+	{"storage_error", []string{"minio", "s3", "upload"}},
+}
+
+// errorCode classifies a failed job's error message.
+func errorCode(msg string) string {
+	msg = strings.ToLower(msg)
+	if strings.TrimSpace(msg) == "" {
+		return "unknown"
+	}
+	for _, p := range errorPatterns {
+		for _, m := range p.matches {
+			if strings.Contains(msg, m) {
+				return p.code
+			}
+		}
+	}
+	return "other"
+}
+
+// recordOutcome notes how a job the worker finished ended, for the ingest
+// analytics: complete, failed with its classified error, or rejected with
+// the rejection code. Failing to record is logged, not returned, so that it
+// never fails the job update itself.
+func (h *Handler) recordOutcome(ctx context.Context, jobID, status, errMsg, result string) {
+	var jobType, platform string
+	var workerID, startedAt, completedAt *string
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT j.job_type, COALESCE(s.platform, ''), j.worker_id, j.started_at, j.completed_at
+		FROM jobs j LEFT JOIN sources s ON j.source_id = s.id
+		WHERE j.id = ?
+	`, jobID).Scan(&jobType, &platform, &workerID, &startedAt, &completedAt); err != nil {
+		log.Printf("record outcome of job %s: %v", jobID, err)
+		return
+	}
+	code := ""
+	switch status {
+	case "failed":
+		code = errorCode(errMsg)
+	case "rejected":
+		code = jobs.RejectionFromJob(result, errMsg).Code
+	}
+	var duration interface{}
+	if startedAt != nil && completedAt != nil {
+		start, err1 := time.Parse("2006-01-02T15:04:05Z", *startedAt)
+		end, err2 := time.Parse("2006-01-02T15:04:05Z", *completedAt)
+		if err1 == nil && err2 == nil && !end.Before(start) {
+			duration = end.Sub(start).Seconds()
+		}
+	}
+	finishedAt := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	if completedAt != nil {
+		finishedAt = *completedAt
+	}
+	if _, err := h.DB.ExecContext(ctx, `
+		INSERT INTO job_outcomes (job_id, job_type, platform, worker_id, status, error_code, duration_seconds, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, jobID, jobType, platform, deref(workerID), status, code, duration, finishedAt); err != nil {
+		log.Printf("record outcome of job %s: %v", jobID, err)
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// outcomeStats aggregates the outcomes of one platform or worker, or of
+// every job.
+type outcomeStats struct {
+	Platform string `json:"platform,omitempty"`
+	WorkerID string `json:"worker_id,omitempty"`
+	Total    int    `json:"total"`
+	Complete int    `json:"complete"`
+	Failed   int    `json:"failed"`
+	Rejected int    `json:"rejected"`
+	// SuccessRate is complete over complete and failed jobs; rejections
+	// are decisions about content, not breakage. It is nil without either.
+	SuccessRate           *float64       `json:"success_rate"`
+	MedianDurationSeconds *float64       `json:"median_duration_seconds"`
+	ErrorCodes            []codeCount    `json:"error_codes"`
+	RejectionCodes        []codeCount    `json:"rejection_codes"`
+	Series                []bucketStats  `json:"series"`
+	RecentSuccessRate     *float64       `json:"recent_success_rate"`
+	Degraded              bool           `json:"degraded"`
+	durations             []float64      // of complete jobs
+	errors, rejections    map[string]int // by code
+	buckets               map[string]*bucketStats
+	recent, earlier       [2]int // complete and failed jobs
+}
+
+type codeCount struct {
+	Code  string `json:"code"`
+	Count int    `json:"count"`
+}
+
+type bucketStats struct {
+	Start       string   `json:"start"`
+	Complete    int      `json:"complete"`
+	Failed      int      `json:"failed"`
+	Rejected    int      `json:"rejected"`
+	SuccessRate *float64 `json:"success_rate"`
+}
+
+func successRate(complete, failed int) *float64 {
+	if complete+failed == 0 {
+		return nil
+	}
+	rate := float64(complete) / float64(complete+failed)
+	return &rate
+}
+
+func (s *outcomeStats) add(bucket, status, code string, duration *float64, recent bool) {
+	if s.buckets == nil {
+		s.errors, s.rejections = map[string]int{}, map[string]int{}
+		s.buckets = map[string]*bucketStats{}
+	}
+	b := s.buckets[bucket]
+	if b == nil {
+		b = &bucketStats{Start: bucket}
+		s.buckets[bucket] = b
+	}
+	window := &s.earlier
+	if recent {
+		window = &s.recent
+	}
+	s.Total++
+	switch status {
+	case "complete":
+		s.Complete++
+		b.Complete++
+		window[0]++
+		if duration != nil {
+			s.durations = append(s.durations, *duration)
+		}
+	case "failed":
+		s.Failed++
+		b.Failed++
+		window[1]++
+		s.errors[code]++
+	case "rejected":
+		s.Rejected++
+		b.Rejected++
+		s.rejections[code]++
+	}
+}
+
+// finish computes the summary fields once every outcome is added. A
+// segment is degraded when enough jobs finished recently and their success
+// rate fell under degradedRatio of the rate before, or of 100% without
+// earlier jobs.
+func (s *outcomeStats) finish() {
+	s.SuccessRate = successRate(s.Complete, s.Failed)
+	if n := len(s.durations); n > 0 {
+		sort.Float64s(s.durations)
+		median := s.durations[n/2]
+		if n%2 == 0 {
+			median = (s.durations[n/2-1] + s.durations[n/2]) / 2
+		}
+		s.MedianDurationSeconds = &median
+	}
+	s.ErrorCodes, s.RejectionCodes = topCodes(s.errors), topCodes(s.rejections)
+	s.Series = make([]bucketStats, 0, len(s.buckets))
+	for _, b := range s.buckets {
+		b.SuccessRate = successRate(b.Complete, b.Failed)
+		s.Series = append(s.Series, *b)
+	}
+	sort.Slice(s.Series, func(i, j int) bool { return s.Series[i].Start < s.Series[j].Start })
+
+	s.RecentSuccessRate = successRate(s.recent[0], s.recent[1])
+	baseline := 1.0
+	if rate := successRate(s.earlier[0], s.earlier[1]); rate != nil {
+		baseline = *rate
+	}
+	s.Degraded = s.recent[0]+s.recent[1] >= degradedMinJobs && *s.RecentSuccessRate < baseline*degradedRatio
+}
+
+// topCodes lists the most frequent codes, most frequent first.
+func topCodes(counts map[string]int) []codeCount {
+	out := make([]codeCount, 0, len(counts))
+	for code, n := range counts {
+		out = append(out, codeCount{Code: code, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Code < out[j].Code
+	})
+	if len(out) > topErrorCodes {
+		out = out[:topErrorCodes]
+	}
+	return out
+}
+
+// HandleIngestAnalytics reports how finished jobs fared over the last hours
+// (default 24, max 720), per platform and per worker: success rate, median
+// duration of complete jobs, the most common error and rejection codes, and
+// a time series in hour or day buckets (default hour up to three days).
+// Segments whose success rate dropped sharply in the last six hours are
+// flagged degraded and listed first. job_type, platform and worker narrow
+// the jobs considered.
+func (h *Handler) HandleIngestAnalytics(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	hours := defaultAnalyticsHours
+	if v := q.Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAnalyticsHours {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "hours must be between 1 and 720"})
+			return
+		}
+		hours = n
+	}
+	bucket := q.Get("bucket")
+	switch bucket {
+	case "":
+		bucket = "hour"
+		if hours > 72 {
+			bucket = "day"
+		}
+	case "hour", "day":
+	default:
+		httputil.WriteJSON(w, 400, map[string]string{"error": `bucket must be "hour" or "day"`})
+		return
+	}
+
+	now := time.Now().UTC()
+	since := now.Add(-time.Duration(hours) * time.Hour).Format("2006-01-02T15:04:05Z")
+	recentSince := now.Add(-recentWindow).Format("2006-01-02T15:04:05Z")
+	query := `
+		SELECT platform, worker_id, status, error_code, duration_seconds, finished_at
+		FROM job_outcomes WHERE finished_at >= ?`
+	args := []interface{}{since}
+	for param, column := range map[string]string{"job_type": "job_type", "platform": "platform", "worker": "worker_id"} {
+		if v := q.Get(param); v != "" {
+			query += " AND " + column + " = ?"
+			args = append(args, v)
+		}
+	}
+	rows, err := h.DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load job outcomes"})
+		return
+	}
+	defer rows.Close()
+
+	platforms := map[string]*outcomeStats{}
+	workers := map[string]*outcomeStats{}
+	var overall outcomeStats
+	for rows.Next() {
+		var platform, workerID, status, code, finishedAt string
+		var duration *float64
+		if rows.Scan(&platform, &workerID, &status, &code, &duration, &finishedAt) != nil {
+			continue
+		}
+		start := finishedAt[:min(len(finishedAt), 10)]
+		if bucket == "hour" && len(finishedAt) >= 13 {
+			start = finishedAt[:13] + ":00:00Z"
+		}
+		recent := finishedAt >= recentSince
+		if platform == "" {
+			platform = "unknown"
+		}
+		if workerID == "" {
+			workerID = "unknown"
+		}
+		if platforms[platform] == nil {
+			platforms[platform] = &outcomeStats{Platform: platform}
+		}
+		if workers[workerID] == nil {
+			workers[workerID] = &outcomeStats{WorkerID: workerID}
+		}
+		platforms[platform].add(start, status, code, duration, recent)
+		workers[workerID].add(start, status, code, duration, recent)
+		overall.add(start, status, code, duration, recent)
+	}
+	if rows.Err() != nil {
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load job outcomes"})
+		return
+	}
+	overall.finish()
+
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"since":     since,
+		"hours":     hours,
+		"bucket":    bucket,
+		"overall":   overall,
+		"platforms": segmentList(platforms),
+		"workers":   segmentList(workers),
+	})
+}
+
+// segmentList finishes each segment and lists them degraded first, then by
+// most jobs.
+func segmentList(segments map[string]*outcomeStats) []*outcomeStats {
+	out := make([]*outcomeStats, 0, len(segments))
+	for _, s := range segments {
+		s.finish()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Degraded != b.Degraded {
+			return a.Degraded
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Platform+a.WorkerID < b.Platform+b.WorkerID
+	})
+	return out
+}
+
+// PruneOutcomes deletes job outcomes older than the longest window the
+// analytics look back on, with room to spare.
+func (h *Handler) PruneOutcomes(ctx context.Context) (int64, error) {
+	res, err := h.DB.ExecContext(ctx, `DELETE FROM job_outcomes WHERE finished_at < ?`,
+		time.Now().UTC().Add(-outcomeRetention).Format("2006-01-02T15:04:05Z"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// OutcomePruneLoop prunes old job outcomes daily.
+func (h *Handler) OutcomePruneLoop() {
+	ticker := time.NewTicker(outcomePruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := h.PruneOutcomes(context.Background()); err != nil {
+			log.Printf("worker: prune job outcomes: %v", err)
+		} else if n > 0 {
+			log.Printf("worker: pruned %d job outcomes", n)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+
+	"clipfeed/jobs"
+	"clipfeed/tracing"
+	"clipfeed/workerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements workerpb.WorkerServiceServer on top of the same
+// handler methods the HTTP worker API uses.
+type grpcServer struct {
+	workerpb.UnimplementedWorkerServiceServer
+	h *Handler
+}
+
+// NewGRPCServer returns a gRPC server exposing the worker protocol. Calls
+// must carry "authorization: Bearer <WORKER_SECRET>" metadata.
+func (h *Handler) NewGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, h.grpcAuthInterceptor),
+		grpc.MaxRecvMsgSize(10<<20),
+	)
+	workerpb.RegisterWorkerServiceServer(srv, &grpcServer{h: h})
+	return srv
+}
+
+// grpcAuthInterceptor is the gRPC counterpart of WorkerAuthMiddleware.
+func (h *Handler) grpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if h.WorkerSecret == "" {
+		return nil, status.Error(codes.Unavailable, "worker API not configured (WORKER_SECRET not set)")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var authHeader string
+	if v := md.Get("authorization"); len(v) > 0 {
+		authHeader = v[0]
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader || subtle.ConstantTimeCompare([]byte(token), []byte(h.WorkerSecret)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	if v := md.Get("x-worker-id"); len(v) > 0 {
+		ctx = withWorkerID(ctx, v[0])
+	}
+	if v := md.Get("x-worker-run-id"); len(v) > 0 {
+		ctx = withWorkerRunID(ctx, v[0])
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) ClaimJob(ctx context.Context, req *workerpb.ClaimJobRequest) (*workerpb.ClaimJobResponse, error) {
+	job, err := s.h.claimValidJob(ctx, req.JobTypes)
+	if err != nil {
+		log.Printf("claim job: %v", err)
+	}
+	if job == nil {
+		return &workerpb.ClaimJobResponse{}, nil
+	}
+	return &workerpb.ClaimJobResponse{Job: &workerpb.Job{
+		Id: job.ID, JobType: job.JobType, PayloadJson: job.Payload,
+	}}, nil
+}
+
+func (s *grpcServer) UpdateJob(ctx context.Context, req *workerpb.UpdateJobRequest) (*workerpb.UpdateJobResponse, error) {
+	if req.JobId == "" {
+		return nil, status.Error(codes.InvalidArgument, "job_id required")
+	}
+	err := s.h.updateJob(ctx, req.JobId, jobUpdate{
+		Status: req.Status, Error: req.Error, Result: req.ResultJson, RunAfter: req.RunAfter,
+	})
+	if errors.Is(err, errInvalidStatus) {
+		return nil, status.Error(codes.InvalidArgument, "invalid status")
+	}
+	if errors.Is(err, jobs.ErrInvalidRejection) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to update job")
+	}
+	return &workerpb.UpdateJobResponse{}, nil
+}
+
+func (s *grpcServer) CreateClip(ctx context.Context, req *workerpb.CreateClipRequest) (*workerpb.CreateClipResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id required")
+	}
+	err := s.h.createClip(ctx, clipInput{
+		ID: req.Id, SourceID: req.SourceId, Title: req.Title,
+		DurationSeconds: req.DurationSeconds, StartTime: req.StartTime, EndTime: req.EndTime,
+		StorageKey: req.StorageKey, ThumbnailKey: req.ThumbnailKey,
+		Width: int(req.Width), Height: int(req.Height), FileSizeBytes: req.FileSizeBytes,
+		Transcript: req.Transcript, Topics: req.Topics, ContentScore: req.ContentScore,
+		ExpiresAt: req.ExpiresAt, Platform: req.Platform, ChannelName: req.ChannelName,
+		TextEmbedding: req.TextEmbedding, VisualEmbedding: req.VisualEmbedding,
+		ModelVersion: req.ModelVersion,
+	})
+	if err != nil {
+		log.Printf("worker create clip failed: %v", err)
+		return nil, status.Error(codes.Internal, "failed to create clip")
+	}
+	return &workerpb.CreateClipResponse{Id: req.Id}, nil
+}
+
+func (s *grpcServer) ResolveTopic(ctx context.Context, req *workerpb.ResolveTopicRequest) (*workerpb.ResolveTopicResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name required")
+	}
+	id, created := s.h.resolveTopic(ctx, req.Name)
+	return &workerpb.ResolveTopicResponse{Id: id, Created: created}, nil
+}
+
+func (s *grpcServer) GetCookie(ctx context.Context, req *workerpb.GetCookieRequest) (*workerpb.GetCookieResponse, error) {
+	cookie, ok := s.h.platformCookie(ctx, req.SourceId, req.Platform)
+	if !ok {
+		return &workerpb.GetCookieResponse{}, nil
+	}
+	return &workerpb.GetCookieResponse{Cookie: &cookie}, nil
+}
@@ -3,26 +3,49 @@ package worker
 import (
 	"context"
 	"crypto/subtle"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
+	"clipfeed/clips"
+	"clipfeed/contentfilter"
 	"clipfeed/crypto"
 	"clipfeed/db"
 	"clipfeed/httputil"
+	"clipfeed/jobs"
+	"clipfeed/notify"
+	"clipfeed/scoring"
+	"clipfeed/storyboard"
+	"clipfeed/transcripts"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+var (
+	errUnknownDependency = errors.New("unknown dependency")
+	errDeadDependency    = errors.New("dependency will never complete")
+	errInvalidStatus     = errors.New("invalid status")
+)
+
 // Handler holds dependencies for the internal worker API.
 type Handler struct {
 	DB           *db.CompatDB
 	WorkerSecret string
 	CookieSecret string
+	Notifier     *notify.Handler
+	// OnClipCreated, when set, is called with each clip the worker creates,
+	// after it is committed, to validate its media and push it to users it
+	// suits.
+	OnClipCreated func(clipID string)
+	// HLS, when set, queues a transcode_hls job for each clip the worker
+	// creates.
+	HLS bool
 }
 
 // WorkerAuthMiddleware validates requests from the ingestion worker.
@@ -38,98 +61,300 @@ func (h *Handler) WorkerAuthMiddleware(next http.Handler) http.Handler {
 			httputil.WriteJSON(w, 401, map[string]string{"error": "unauthorized"})
 			return
 		}
-		next.ServeHTTP(w, r)
+		ctx := withWorkerID(r.Context(), r.Header.Get("X-Worker-ID"))
+		next.ServeHTTP(w, r.WithContext(withWorkerRunID(ctx, r.Header.Get("X-Worker-Run-ID"))))
 	})
 }
 
-// HandleClaimJob atomically claims the next queued job.
-func (h *Handler) HandleClaimJob(w http.ResponseWriter, r *http.Request) {
-	nowExpr := h.DB.NowUTC()
+// claimedJob is a job handed to a worker.
+type claimedJob struct {
+	ID      string
+	JobType string
+	Payload string
+}
 
-	var id, payload string
-	var err error
+// claimJob atomically claims the next queued job whose dependencies have
+// completed and whose platform is below its concurrency caps, optionally
+// limited to jobTypes. It returns nil when no job is runnable.
+func (h *Handler) claimJob(ctx context.Context, jobTypes []string) (*claimedJob, error) {
+	query, args := claimQuery(h.DB, workerIDFromContext(ctx), workerRunIDFromContext(ctx), jobTypes)
 
+	var job claimedJob
+	var err error
 	if h.DB.IsPostgres() {
-		err = h.DB.QueryRowContext(r.Context(), fmt.Sprintf(`
-			UPDATE jobs SET status = 'running', started_at = %s, attempts = attempts + 1
-			WHERE id = (
-				SELECT id FROM jobs WHERE status = 'queued' AND (run_after IS NULL OR run_after <= %s)
-				ORDER BY priority DESC, created_at ASC LIMIT 1 FOR UPDATE SKIP LOCKED
-			) RETURNING id, payload
-		`, nowExpr, nowExpr)).Scan(&id, &payload)
+		// Serialise claims so concurrent workers cannot both observe a
+		// platform just under its cap and overshoot it.
+		err = db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+			if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_xact_lock(?)`, claimLockKey); err != nil {
+				return err
+			}
+			return conn.QueryRowContext(ctx, query, args...).Scan(&job.ID, &job.JobType, &job.Payload)
+		})
 	} else {
-		err = h.DB.QueryRowContext(r.Context(), fmt.Sprintf(`
-			UPDATE jobs SET status = 'running', started_at = %s, attempts = attempts + 1
-			WHERE id = (
-				SELECT id FROM jobs WHERE status = 'queued' AND (run_after IS NULL OR run_after <= %s)
-				ORDER BY priority DESC, created_at ASC LIMIT 1
-			) RETURNING id, payload
-		`, nowExpr, nowExpr)).Scan(&id, &payload)
+		err = h.DB.QueryRowContext(ctx, query, args...).Scan(&job.ID, &job.JobType, &job.Payload)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	if err := h.touchRun(ctx); err != nil {
+		log.Printf("claim job %s: record worker run: %v", job.ID, err)
+	}
+	return &job, nil
+}
 
+// claimValidJob claims jobs until one has a payload that parses under its
+// job type's schema, upgrading old payloads to the current version. Jobs
+// with invalid payloads are failed so they are not retried.
+func (h *Handler) claimValidJob(ctx context.Context, jobTypes []string) (*claimedJob, error) {
+	for {
+		job, err := h.claimJob(ctx, jobTypes)
+		if job == nil || err != nil {
+			return job, err
+		}
+		payload, err := jobs.NormalizePayload(job.JobType, job.Payload)
+		if err == nil {
+			if job.JobType == "download" {
+				payload = h.withClipStrategy(ctx, payload)
+			}
+			job.Payload = payload
+			return job, nil
+		}
+		log.Printf("claim job %s: %v", job.ID, err)
+		if _, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE jobs SET status = 'failed', error = ?, completed_at = %s WHERE id = ?
+		`, h.DB.NowUTC()), err.Error(), job.ID); err != nil {
+			return nil, err
+		}
+		if _, err := jobs.CascadeJobStatus(ctx, h.DB, job.ID, "failed"); err != nil {
+			log.Printf("claim job %s: cascade failed to dependents: %v", job.ID, err)
+		}
+	}
+}
+
+// withClipStrategy fills in the strategy of a download payload queued
+// without one, such as by the scout's auto-approval: the source's recorded
+// strategy, else the platform default, which is then recorded on the source.
+func (h *Handler) withClipStrategy(ctx context.Context, payload string) string {
+	var p jobs.DownloadPayload
+	if err := json.Unmarshal([]byte(payload), &p); err != nil || p.Strategy != "" {
+		return payload
+	}
+	var recorded *string
+	h.DB.QueryRowContext(ctx, `SELECT clip_strategy FROM sources WHERE id = ?`, p.SourceID).Scan(&recorded)
+	var strategy string
+	if recorded != nil && jobs.ValidClipStrategy(*recorded) {
+		strategy = *recorded
+	} else {
+		strategy = jobs.ResolveClipStrategy(ctx, h.DB, p.Platform, "")
+		h.DB.ExecContext(ctx, `UPDATE sources SET clip_strategy = ? WHERE id = ? AND clip_strategy IS NULL`, strategy, p.SourceID)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return payload
+	}
+	fields["strategy"], _ = json.Marshal(strategy)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+	return string(out)
+}
+
+// HandleClaimJob claims the next runnable job. Workers may pass
+// {"job_types": [...]} to claim only job types they can run.
+func (h *Handler) HandleClaimJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		JobTypes []string `json:"job_types"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	job, err := h.claimValidJob(r.Context(), req.JobTypes)
 	if err != nil {
+		log.Printf("claim job: %v", err)
+	}
+	if job == nil {
 		httputil.WriteJSON(w, 204, nil)
 		return
 	}
 
 	httputil.WriteJSON(w, 200, map[string]interface{}{
-		"id": id, "payload": json.RawMessage(payload),
+		"id": job.ID, "job_type": job.JobType, "payload": json.RawMessage(job.Payload),
 	})
 }
 
-// HandleUpdateJob updates a job's status, error, and result.
-func (h *Handler) HandleUpdateJob(w http.ResponseWriter, r *http.Request) {
-	jobID := chi.URLParam(r, "id")
-	nowExpr := h.DB.NowUTC()
-
+// HandleCreateJob queues a follow-up job for a source that runs only after
+// the jobs it depends on have completed.
+func (h *Handler) HandleCreateJob(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Status   string           `json:"status"`
-		Error    *string          `json:"error,omitempty"`
-		Result   *json.RawMessage `json:"result,omitempty"`
-		RunAfter *string          `json:"run_after,omitempty"`
+		SourceID  string           `json:"source_id"`
+		JobType   string           `json:"job_type"`
+		Payload   *json.RawMessage `json:"payload"`
+		Priority  *int             `json:"priority"`
+		DependsOn []string         `json:"depends_on"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JobType == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "job_type is required"})
 		return
 	}
+	payload := "{}"
+	if req.Payload != nil {
+		payload = string(*req.Payload)
+	}
+	payload, err := jobs.NormalizePayload(req.JobType, payload)
+	if err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	priority := 5
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	var sourceID interface{}
+	if req.SourceID != "" {
+		sourceID = req.SourceID
+	}
 
-	switch req.Status {
+	jobID := uuid.New().String()
+	err = db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		for _, dep := range req.DependsOn {
+			var status string
+			if err := conn.QueryRowContext(r.Context(), `SELECT status FROM jobs WHERE id = ?`, dep).Scan(&status); err != nil {
+				return fmt.Errorf("%w: %s", errUnknownDependency, dep)
+			}
+			if status == "failed" || status == "rejected" || status == "cancelled" {
+				return fmt.Errorf("%w: %s is %s", errDeadDependency, dep, status)
+			}
+		}
+		if _, err := conn.ExecContext(r.Context(),
+			`INSERT INTO jobs (id, source_id, job_type, priority, payload) VALUES (?, ?, ?, ?, ?)`,
+			jobID, sourceID, req.JobType, priority, jobs.WithTraceparent(r.Context(), payload)); err != nil {
+			return err
+		}
+		for _, dep := range req.DependsOn {
+			if _, err := conn.ExecContext(r.Context(), `
+				INSERT INTO job_dependencies (job_id, depends_on_job_id) VALUES (?, ?)
+				ON CONFLICT DO NOTHING
+			`, jobID, dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errUnknownDependency) || errors.Is(err, errDeadDependency) {
+		httputil.WriteJSON(w, 409, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("create job: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create job"})
+		return
+	}
+
+	httputil.WriteJSON(w, 201, map[string]interface{}{
+		"id": jobID, "job_type": req.JobType, "status": "queued", "depends_on": req.DependsOn,
+	})
+}
+
+// jobUpdate is a worker's report on a job it claimed.
+type jobUpdate struct {
+	Status   string
+	Error    *string
+	Result   *string
+	RunAfter *string
+}
+
+// updateJob applies a status change. Terminal failures and cancellations
+// cascade to queued dependents; terminal statuses other than cancelled
+// notify the submitter. It returns errInvalidStatus for unknown statuses
+// and jobs.ErrInvalidRejection for a rejection that fails validation.
+func (h *Handler) updateJob(ctx context.Context, jobID string, u jobUpdate) error {
+	errStr := ""
+	if u.Error != nil {
+		errStr = *u.Error
+	}
+
+	switch u.Status {
 	case "complete", "failed", "rejected", "cancelled":
 		resultStr := "{}"
-		if req.Result != nil {
-			resultStr = string(*req.Result)
+		if u.Result != nil {
+			resultStr = *u.Result
 		}
-		errStr := ""
-		if req.Error != nil {
-			errStr = *req.Error
+		if u.Status == "rejected" {
+			if err := jobs.ValidateRejectionResult(resultStr); err != nil {
+				return err
+			}
 		}
-		_, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		if _, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
 			UPDATE jobs SET status = ?, error = ?, result = ?, completed_at = %s WHERE id = ?
-		`, nowExpr), req.Status, errStr, resultStr, jobID)
-		if err != nil {
-			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update job"})
-			return
+		`, h.DB.NowUTC()), u.Status, errStr, resultStr, jobID); err != nil {
+			return err
+		}
+		if u.Status != "cancelled" {
+			h.recordOutcome(ctx, jobID, u.Status, errStr, resultStr)
+		}
+		cascade := "failed"
+		if u.Status == "cancelled" {
+			cascade = "cancelled"
+		}
+		if u.Status != "complete" {
+			if _, err := jobs.CascadeJobStatus(ctx, h.DB, jobID, cascade); err != nil {
+				log.Printf("update job %s: cascade %s to dependents: %v", jobID, cascade, err)
+			}
+		}
+		if h.Notifier != nil && u.Status != "cancelled" {
+			h.Notifier.NotifyJobFinished(ctx, jobID)
 		}
+		return nil
 
 	case "queued":
 		runAfter := ""
-		if req.RunAfter != nil {
-			runAfter = *req.RunAfter
-		}
-		errStr := ""
-		if req.Error != nil {
-			errStr = *req.Error
+		if u.RunAfter != nil {
+			runAfter = *u.RunAfter
 		}
-		_, err := h.DB.ExecContext(r.Context(),
+		_, err := h.DB.ExecContext(ctx,
 			`UPDATE jobs SET status = 'queued', error = ?, run_after = ? WHERE id = ?`,
 			errStr, runAfter, jobID)
-		if err != nil {
-			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to re-queue job"})
-			return
-		}
+		return err
 
 	default:
-		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid status"})
+		return errInvalidStatus
+	}
+}
+
+// HandleUpdateJob updates a job's status, error, and result.
+func (h *Handler) HandleUpdateJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "id")
+
+	var req struct {
+		Status   string           `json:"status"`
+		Error    *string          `json:"error,omitempty"`
+		Result   *json.RawMessage `json:"result,omitempty"`
+		RunAfter *string          `json:"run_after,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	u := jobUpdate{Status: req.Status, Error: req.Error, RunAfter: req.RunAfter}
+	if req.Result != nil {
+		result := string(*req.Result)
+		u.Result = &result
+	}
+	if err := h.updateJob(r.Context(), jobID, u); err != nil {
+		switch {
+		case errors.Is(err, errInvalidStatus):
+			httputil.WriteJSON(w, 400, map[string]string{"error": "invalid status"})
+		case errors.Is(err, jobs.ErrInvalidRejection):
+			httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		case req.Status == "queued":
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to re-queue job"})
+		default:
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to update job"})
+		}
 		return
 	}
 
@@ -153,6 +378,9 @@ func (h *Handler) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteJSON(w, 404, map[string]string{"error": "job not found or not running"})
 		return
 	}
+	if err := h.touchRun(r.Context()); err != nil {
+		log.Printf("heartbeat job %s: record worker run: %v", jobID, err)
+	}
 	httputil.WriteJSON(w, 200, map[string]string{"status": "ok"})
 }
 
@@ -234,6 +462,12 @@ func (h *Handler) HandleReclaimStale(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if failedCount > 0 {
+		if _, err := jobs.FailBlockedJobs(r.Context(), h.DB); err != nil {
+			log.Printf("reclaim stale: cascade failure to dependents: %v", err)
+		}
+	}
+
 	httputil.WriteJSON(w, 200, map[string]interface{}{
 		"requeued": requeuedCount, "failed": failedCount,
 	})
@@ -251,6 +485,9 @@ func (h *Handler) HandleUpdateSource(w http.ResponseWriter, r *http.Request) {
 		ThumbnailURL    *string  `json:"thumbnail_url,omitempty"`
 		DurationSeconds *float64 `json:"duration_seconds,omitempty"`
 		Metadata        *string  `json:"metadata,omitempty"`
+		Uploader        *string  `json:"uploader,omitempty"`
+		UploaderURL     *string  `json:"uploader_url,omitempty"`
+		License         *string  `json:"license,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
@@ -285,6 +522,15 @@ func (h *Handler) HandleUpdateSource(w http.ResponseWriter, r *http.Request) {
 	if req.Metadata != nil {
 		addSet("metadata", *req.Metadata)
 	}
+	if req.Uploader != nil {
+		addSet("uploader", *req.Uploader)
+	}
+	if req.UploaderURL != nil {
+		addSet("uploader_url", *req.UploaderURL)
+	}
+	if req.License != nil {
+		addSet("license", strings.TrimSpace(*req.License))
+	}
 
 	if len(sets) == 0 {
 		httputil.WriteJSON(w, 400, map[string]string{"error": "no fields to update"})
@@ -296,7 +542,22 @@ func (h *Handler) HandleUpdateSource(w http.ResponseWriter, r *http.Request) {
 	if _, err := h.DB.ExecContext(r.Context(), query, args...); err != nil {
 		errMsg := err.Error()
 		if strings.Contains(errMsg, "UNIQUE constraint") || strings.Contains(errMsg, "duplicate key") {
-			httputil.WriteJSON(w, 409, map[string]string{"error": "duplicate source: a source with the same platform and external_id already exists"})
+			resp := map[string]interface{}{"error": "duplicate source: a source with the same platform and external_id already exists"}
+			if req.ExternalID != nil {
+				// Name the existing source, and a clip of it, so the worker
+				// can reject the job as a duplicate of what is already there.
+				var existingID string
+				var clipID *string
+				if h.DB.QueryRowContext(r.Context(), `
+					SELECT o.id, (SELECT c.id FROM clips c WHERE c.source_id = o.id AND c.status = 'ready'
+					              ORDER BY COALESCE(c.start_time, 0), c.created_at LIMIT 1)
+					FROM sources o JOIN sources s ON s.platform = o.platform
+					WHERE s.id = ? AND o.external_id = ? AND o.id != s.id
+				`, sourceID, *req.ExternalID).Scan(&existingID, &clipID) == nil {
+					resp["duplicate_of_source"], resp["duplicate_of_clip"] = existingID, clipID
+				}
+			}
+			httputil.WriteJSON(w, 409, resp)
 			return
 		}
 		log.Printf("worker update source %s failed: %v", sourceID, err)
@@ -307,29 +568,168 @@ func (h *Handler) HandleUpdateSource(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
 }
 
-// HandleGetCookie returns a decrypted platform cookie for a source.
-func (h *Handler) HandleGetCookie(w http.ResponseWriter, r *http.Request) {
-	sourceID := chi.URLParam(r, "id")
-	platform := r.URL.Query().Get("platform")
-
+// platformCookie returns the decrypted cookie the source's submitter saved
+// for platform, if any.
+func (h *Handler) platformCookie(ctx context.Context, sourceID, platform string) (string, bool) {
 	var encrypted string
-	err := h.DB.QueryRowContext(r.Context(), `
+	err := h.DB.QueryRowContext(ctx, `
 		SELECT pc.cookie_str FROM platform_cookies pc
 		JOIN sources s ON pc.user_id = s.submitted_by
 		WHERE s.id = ? AND pc.platform = ? AND pc.is_active = 1
 	`, sourceID, platform).Scan(&encrypted)
 	if err != nil {
-		httputil.WriteJSON(w, 200, map[string]interface{}{"cookie": nil})
-		return
+		return "", false
 	}
 
 	decrypted, err := crypto.DecryptCookie(encrypted, h.CookieSecret)
 	if err != nil {
+		return "", false
+	}
+	return decrypted, true
+}
+
+// HandleGetCookie returns a decrypted platform cookie for a source.
+func (h *Handler) HandleGetCookie(w http.ResponseWriter, r *http.Request) {
+	cookie, ok := h.platformCookie(r.Context(), chi.URLParam(r, "id"), r.URL.Query().Get("platform"))
+	if !ok {
 		httputil.WriteJSON(w, 200, map[string]interface{}{"cookie": nil})
 		return
 	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"cookie": cookie})
+}
 
-	httputil.WriteJSON(w, 200, map[string]interface{}{"cookie": decrypted})
+// clipInput is a finished clip reported by a worker. Embeddings are raw
+// little-endian float32 vectors.
+type clipInput struct {
+	ID              string
+	SourceID        string
+	Title           string
+	DurationSeconds float64
+	StartTime       float64
+	EndTime         float64
+	StorageKey      string
+	ThumbnailKey    string
+	Width           int
+	Height          int
+	FileSizeBytes   int64
+	Transcript      string
+	Topics          []string
+	ContentScore    float64
+	ExpiresAt       string
+	Platform        string
+	ChannelName     string
+	TextEmbedding   []byte
+	VisualEmbedding []byte
+	ModelVersion    string
+}
+
+// createClip inserts a clip with its topics, FTS row, and embeddings in one
+// transaction, tagging it with the content filters its transcript matches,
+// then hands it to OnClipCreated.
+func (h *Handler) createClip(ctx context.Context, c clipInput) error {
+	err := db.WithTx(ctx, h.DB, func(conn *db.CompatConn) error {
+		topicsJSON, _ := json.Marshal(c.Topics)
+
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO clips (
+				id, source_id, title, duration_seconds, start_time, end_time,
+				storage_key, thumbnail_key, width, height, file_size_bytes,
+				topics, content_score, expires_at, status
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'ready')
+		`, c.ID, c.SourceID, c.Title, c.DurationSeconds, c.StartTime, c.EndTime,
+			c.StorageKey, c.ThumbnailKey, c.Width, c.Height, c.FileSizeBytes,
+			string(topicsJSON), c.ContentScore, c.ExpiresAt,
+		); err != nil {
+			return fmt.Errorf("insert clip: %w", err)
+		}
+		if err := replaceBrokenClips(ctx, conn, c); err != nil {
+			return err
+		}
+		if err := transcripts.Save(ctx, conn, c.ID, c.Transcript); err != nil {
+			return err
+		}
+		if err := contentfilter.ScanClip(ctx, conn, c.ID, c.Title, c.Transcript); err != nil {
+			return err
+		}
+
+		for _, topicName := range c.Topics {
+			topicID := ResolveOrCreateTopicTx(ctx, conn, topicName)
+			if topicID != "" {
+				if _, err := conn.ExecContext(ctx,
+					`INSERT INTO clip_topics (clip_id, topic_id, confidence, source) VALUES (?, ?, 1.0, 'keybert') ON CONFLICT DO NOTHING`,
+					c.ID, topicID); err != nil {
+					return fmt.Errorf("insert clip_topics: %w", err)
+				}
+			}
+		}
+
+		if err := notify.QueueSubscriptionMatches(ctx, conn, c.ID); err != nil {
+			return fmt.Errorf("queue subscription matches: %w", err)
+		}
+
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO clips_fts(clip_id, title, transcript, platform, channel_name) VALUES (?, ?, ?, ?, ?)`,
+			c.ID, c.Title, Truncate(c.Transcript, 2000), c.Platform, c.ChannelName); err != nil {
+			return fmt.Errorf("insert clips_fts: %w", err)
+		}
+
+		if len(c.TextEmbedding) > 0 || len(c.VisualEmbedding) > 0 {
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO clip_embeddings (clip_id, text_embedding, visual_embedding, model_version) VALUES (?, ?, ?, ?)
+				 ON CONFLICT(clip_id) DO UPDATE SET text_embedding = EXCLUDED.text_embedding, visual_embedding = EXCLUDED.visual_embedding, model_version = EXCLUDED.model_version`,
+				c.ID, c.TextEmbedding, c.VisualEmbedding, c.ModelVersion); err != nil {
+				return fmt.Errorf("insert clip_embeddings: %w", err)
+			}
+		}
+
+		if h.HLS {
+			if err := queueHLSJob(ctx, conn, c.ID, c.StorageKey); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err == nil && h.OnClipCreated != nil {
+		h.OnClipCreated(c.ID)
+	}
+	return err
+}
+
+// replaceBrokenClips retires broken clips cut from the same segment of the
+// same source as c, which a repair job re-cut to replace them. Their
+// tombstones point at c.
+func replaceBrokenClips(ctx context.Context, conn *db.CompatConn, c clipInput) error {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id FROM clips
+		WHERE source_id = ? AND status = 'broken' AND id <> ?
+		  AND ABS(start_time - ?) < 0.5 AND ABS(end_time - ?) < 0.5
+	`, c.SourceID, c.ID, c.StartTime, c.EndTime)
+	if err != nil {
+		return fmt.Errorf("find broken clips: %w", err)
+	}
+	var ids []interface{}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := clips.Bury(ctx, conn, clips.TombstoneMerged, c.ID, ids...); err != nil {
+		return fmt.Errorf("bury broken clips: %w", err)
+	}
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	if _, err := conn.ExecContext(ctx, `DELETE FROM clips_fts WHERE clip_id IN `+in, ids...); err != nil {
+		return fmt.Errorf("delete broken clips' search entries: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM clips WHERE id IN `+in, ids...); err != nil {
+		return fmt.Errorf("delete broken clips: %w", err)
+	}
+	return nil
 }
 
 // HandleCreateClip creates a clip with associated topics, embeddings, and FTS.
@@ -362,57 +762,23 @@ func (h *Handler) HandleCreateClip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
-		topicsJSON, _ := json.Marshal(req.Topics)
-
-		if _, err := conn.ExecContext(r.Context(), `
-			INSERT INTO clips (
-				id, source_id, title, duration_seconds, start_time, end_time,
-				storage_key, thumbnail_key, width, height, file_size_bytes,
-				transcript, topics, content_score, expires_at, status
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'ready')
-		`, req.ID, req.SourceID, req.Title, req.DurationSeconds, req.StartTime, req.EndTime,
-			req.StorageKey, req.ThumbnailKey, req.Width, req.Height, req.FileSizeBytes,
-			req.Transcript, string(topicsJSON), req.ContentScore, req.ExpiresAt,
-		); err != nil {
-			return fmt.Errorf("insert clip: %w", err)
-		}
-
-		for _, topicName := range req.Topics {
-			topicID := ResolveOrCreateTopicTx(r.Context(), conn, topicName)
-			if topicID != "" {
-				if _, err := conn.ExecContext(r.Context(),
-					`INSERT INTO clip_topics (clip_id, topic_id, confidence, source) VALUES (?, ?, 1.0, 'keybert') ON CONFLICT DO NOTHING`,
-					req.ID, topicID); err != nil {
-					return fmt.Errorf("insert clip_topics: %w", err)
-				}
-			}
-		}
-
-		if _, err := conn.ExecContext(r.Context(),
-			`INSERT INTO clips_fts(clip_id, title, transcript, platform, channel_name) VALUES (?, ?, ?, ?, ?)`,
-			req.ID, req.Title, Truncate(req.Transcript, 2000), req.Platform, req.ChannelName); err != nil {
-			return fmt.Errorf("insert clips_fts: %w", err)
-		}
-
-		if req.TextEmbedding != "" || req.VisualEmbedding != "" {
-			var textEmb, visEmb []byte
-			if req.TextEmbedding != "" {
-				textEmb, _ = base64.StdEncoding.DecodeString(req.TextEmbedding)
-			}
-			if req.VisualEmbedding != "" {
-				visEmb, _ = base64.StdEncoding.DecodeString(req.VisualEmbedding)
-			}
-			if _, err := conn.ExecContext(r.Context(),
-				`INSERT INTO clip_embeddings (clip_id, text_embedding, visual_embedding, model_version) VALUES (?, ?, ?, ?)
-				 ON CONFLICT(clip_id) DO UPDATE SET text_embedding = EXCLUDED.text_embedding, visual_embedding = EXCLUDED.visual_embedding, model_version = EXCLUDED.model_version`,
-				req.ID, textEmb, visEmb, req.ModelVersion); err != nil {
-				return fmt.Errorf("insert clip_embeddings: %w", err)
-			}
-		}
+	c := clipInput{
+		ID: req.ID, SourceID: req.SourceID, Title: req.Title,
+		DurationSeconds: req.DurationSeconds, StartTime: req.StartTime, EndTime: req.EndTime,
+		StorageKey: req.StorageKey, ThumbnailKey: req.ThumbnailKey,
+		Width: req.Width, Height: req.Height, FileSizeBytes: req.FileSizeBytes,
+		Transcript: req.Transcript, Topics: req.Topics, ContentScore: req.ContentScore,
+		ExpiresAt: req.ExpiresAt, Platform: req.Platform, ChannelName: req.ChannelName,
+		ModelVersion: req.ModelVersion,
+	}
+	if req.TextEmbedding != "" {
+		c.TextEmbedding, _ = base64.StdEncoding.DecodeString(req.TextEmbedding)
+	}
+	if req.VisualEmbedding != "" {
+		c.VisualEmbedding, _ = base64.StdEncoding.DecodeString(req.VisualEmbedding)
+	}
 
-		return nil
-	}); err != nil {
+	if err := h.createClip(r.Context(), c); err != nil {
 		log.Printf("worker create clip failed: %v", err)
 		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to create clip"})
 		return
@@ -440,6 +806,211 @@ func ResolveOrCreateTopicTx(ctx context.Context, conn *db.CompatConn, name strin
 	return id
 }
 
+// resolveTopic finds a topic by slug or name, creating it if needed, and
+// reports whether it was created.
+func (h *Handler) resolveTopic(ctx context.Context, name string) (string, bool) {
+	slug := Slugify(name)
+	var id string
+	err := h.DB.QueryRowContext(ctx,
+		"SELECT id FROM topics WHERE slug = ? OR LOWER(name) = LOWER(?)", slug, name,
+	).Scan(&id)
+	if err == nil {
+		return id, false
+	}
+
+	id = uuid.New().String()
+	h.DB.ExecContext(ctx,
+		"INSERT INTO topics (id, name, slug, path, depth) VALUES (?, ?, ?, ?, 0) ON CONFLICT DO NOTHING",
+		id, name, slug, slug)
+	h.DB.QueryRowContext(ctx, "SELECT id FROM topics WHERE slug = ?", slug).Scan(&id)
+	return id, true
+}
+
+// MaxThumbnailVariants caps how many candidate thumbnails a clip can have.
+const MaxThumbnailVariants = 5
+
+// HandleSetThumbnails replaces a clip's candidate thumbnails. The feed picks
+// among them by click-through; the first should be the clip's current
+// thumbnail.
+func (h *Handler) HandleSetThumbnails(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		ThumbnailKeys []string `json:"thumbnail_keys"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if len(req.ThumbnailKeys) == 0 || len(req.ThumbnailKeys) > MaxThumbnailVariants {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("thumbnail_keys must have 1 to %d entries", MaxThumbnailVariants)})
+		return
+	}
+	for _, key := range req.ThumbnailKeys {
+		if strings.TrimSpace(key) == "" {
+			httputil.WriteJSON(w, 400, map[string]string{"error": "thumbnail_keys cannot be empty"})
+			return
+		}
+	}
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM clip_thumbnails WHERE clip_id = ?`, clipID); err != nil {
+			return fmt.Errorf("clear thumbnails: %w", err)
+		}
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM thumbnail_views WHERE clip_id = ?`, clipID); err != nil {
+			return fmt.Errorf("clear thumbnail views: %w", err)
+		}
+		for i, key := range req.ThumbnailKeys {
+			if _, err := conn.ExecContext(r.Context(),
+				`INSERT INTO clip_thumbnails (clip_id, variant, thumbnail_key) VALUES (?, ?, ?)`, clipID, i, key); err != nil {
+				return fmt.Errorf("insert thumbnail %d: %w", i, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Printf("set thumbnails for clip %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store thumbnails"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"id": clipID, "variants": len(req.ThumbnailKeys)})
+}
+
+// HandleSetRenditions records the extra renditions the worker made for a
+// clip, all already uploaded: the data saver mode's lower-bitrate video
+// and smaller thumbnail, and the TV feed's larger thumbnail. Any may be
+// omitted.
+func (h *Handler) HandleSetRenditions(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		LowStorageKey     *string `json:"low_storage_key"`
+		SmallThumbnailKey *string `json:"small_thumbnail_key"`
+		LargeThumbnailKey *string `json:"large_thumbnail_key"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	var sets []string
+	var args []interface{}
+	for _, f := range []struct {
+		col string
+		key *string
+	}{
+		{"low_storage_key", req.LowStorageKey},
+		{"small_thumbnail_key", req.SmallThumbnailKey},
+		{"large_thumbnail_key", req.LargeThumbnailKey},
+	} {
+		if f.key == nil {
+			continue
+		}
+		if strings.TrimSpace(*f.key) == "" {
+			httputil.WriteJSON(w, 400, map[string]string{"error": f.col + " cannot be empty"})
+			return
+		}
+		sets = append(sets, f.col+" = ?")
+		args = append(args, *f.key)
+	}
+	if len(sets) == 0 {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "low_storage_key, small_thumbnail_key, or large_thumbnail_key is required"})
+		return
+	}
+	res, err := h.DB.ExecContext(r.Context(), `UPDATE clips SET `+strings.Join(sets, ", ")+` WHERE id = ?`, append(args, clipID)...)
+	if err != nil {
+		log.Printf("set renditions for clip %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store renditions"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
+}
+
+// HandleSetPreview records the GIF or WebM a preview job rendered for a
+// clip, which the worker has already uploaded to object storage.
+func (h *Handler) HandleSetPreview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		StorageKey    string `json:"storage_key"`
+		FileSizeBytes int64  `json:"file_size_bytes"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if strings.TrimSpace(req.StorageKey) == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "storage_key is required"})
+		return
+	}
+	previewID, clipID := chi.URLParam(r, "previewId"), chi.URLParam(r, "id")
+	res, err := h.DB.ExecContext(r.Context(), fmt.Sprintf(`
+		UPDATE clip_previews SET storage_key = ?, file_size_bytes = ?, completed_at = %s
+		WHERE id = ? AND clip_id = ?
+	`, h.DB.NowUTC()), req.StorageKey, req.FileSizeBytes, previewID, clipID)
+	if err != nil {
+		log.Printf("set preview %s for clip %s: %v", previewID, clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store preview"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "preview not found"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]string{"status": "updated"})
+}
+
+// HandleSetStoryboard replaces a clip's seek-preview storyboard: the WebVTT
+// index and the sprite sheets its cues point at, which the worker has
+// already uploaded to object storage.
+func (h *Handler) HandleSetStoryboard(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		VTT        string   `json:"vtt"`
+		SpriteKeys []string `json:"sprite_keys"`
+	}
+	if err := json.NewDecoder(httputil.LimitedBodyReader(r)).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if err := storyboard.Validate(req.VTT, req.SpriteKeys); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		if _, err := conn.ExecContext(r.Context(), fmt.Sprintf(`
+			INSERT INTO clip_storyboards (clip_id, vtt, created_at) VALUES (?, ?, %s)
+			ON CONFLICT(clip_id) DO UPDATE SET vtt = excluded.vtt, created_at = excluded.created_at
+		`, h.DB.NowUTC()), clipID, req.VTT); err != nil {
+			return fmt.Errorf("store vtt: %w", err)
+		}
+		if _, err := conn.ExecContext(r.Context(), `DELETE FROM clip_storyboard_sprites WHERE clip_id = ?`, clipID); err != nil {
+			return fmt.Errorf("clear sprites: %w", err)
+		}
+		for i, key := range req.SpriteKeys {
+			if _, err := conn.ExecContext(r.Context(),
+				`INSERT INTO clip_storyboard_sprites (clip_id, sprite_index, sprite_key) VALUES (?, ?, ?)`, clipID, i, key); err != nil {
+				return fmt.Errorf("insert sprite %d: %w", i, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Printf("set storyboard for clip %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store storyboard"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"id": clipID, "sprites": len(req.SpriteKeys)})
+}
+
 // HandleResolveTopic resolves or creates a topic by name.
 func (h *Handler) HandleResolveTopic(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -450,71 +1021,21 @@ func (h *Handler) HandleResolveTopic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slug := Slugify(req.Name)
-	var id string
-	err := h.DB.QueryRowContext(r.Context(),
-		"SELECT id FROM topics WHERE slug = ? OR LOWER(name) = LOWER(?)", slug, req.Name,
-	).Scan(&id)
-	if err == nil {
+	id, created := h.resolveTopic(r.Context(), req.Name)
+	if !created {
 		httputil.WriteJSON(w, 200, map[string]interface{}{"id": id, "created": false})
 		return
 	}
-
-	id = uuid.New().String()
-	h.DB.ExecContext(r.Context(),
-		"INSERT INTO topics (id, name, slug, path, depth) VALUES (?, ?, ?, ?, 0) ON CONFLICT DO NOTHING",
-		id, req.Name, slug, slug)
-	h.DB.QueryRowContext(r.Context(), "SELECT id FROM topics WHERE slug = ?", slug).Scan(&id)
-
 	httputil.WriteJSON(w, 201, map[string]interface{}{"id": id, "created": true})
 }
 
-// HandleScoreUpdate recalculates content scores from interaction signals.
+// HandleScoreUpdate recalculates content scores from interaction signals
+// using the current scoring weights.
 func (h *Handler) HandleScoreUpdate(w http.ResponseWriter, r *http.Request) {
-	var count int64
-	if h.DB.IsPostgres() {
-		res, err := h.DB.ExecContext(r.Context(), `
-			UPDATE clips SET content_score = sub.new_score
-			FROM (
-				SELECT clip_id,
-					MAX(0.0, LEAST(1.0,
-						COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * 0.35
-						+ COALESCE(CAST(SUM(CASE WHEN action='like' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.25
-						+ COALESCE(CAST(SUM(CASE WHEN action='save' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.20
-						+ COALESCE(CAST(SUM(CASE WHEN action='watch_full' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.15
-						- COALESCE(CAST(SUM(CASE WHEN action='skip' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.30
-						- COALESCE(CAST(SUM(CASE WHEN action='dislike' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.15
-					)) AS new_score
-				FROM interactions GROUP BY clip_id
-				HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5
-			) sub
-			WHERE clips.id = sub.clip_id AND clips.status = 'ready'
-		`)
-		if err == nil {
-			count, _ = res.RowsAffected()
-		}
-	} else {
-		res, err := h.DB.ExecContext(r.Context(), `
-			UPDATE clips SET content_score = (
-				SELECT MAX(0.0, MIN(1.0,
-					COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * 0.35
-					+ COALESCE(CAST(SUM(CASE WHEN action='like' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.25
-					+ COALESCE(CAST(SUM(CASE WHEN action='save' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.20
-					+ COALESCE(CAST(SUM(CASE WHEN action='watch_full' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.15
-					- COALESCE(CAST(SUM(CASE WHEN action='skip' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.30
-					- COALESCE(CAST(SUM(CASE WHEN action='dislike' THEN 1.0 ELSE 0 END) AS REAL) / NULLIF(SUM(CASE WHEN action='view' THEN 1 ELSE 0 END), 0), 0) * 0.15
-				))
-				FROM interactions WHERE interactions.clip_id = clips.id
-				HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5
-			)
-			WHERE status = 'ready'
-			  AND id IN (SELECT clip_id FROM interactions GROUP BY clip_id HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5)
-		`)
-		if err == nil {
-			count, _ = res.RowsAffected()
-		}
+	count, err := scoring.Recompute(r.Context(), h.DB)
+	if err != nil {
+		log.Printf("score update failed: %v", err)
 	}
-
 	httputil.WriteJSON(w, 200, map[string]interface{}{"updated": count})
 }
 
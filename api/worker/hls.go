@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"clipfeed/db"
+	"clipfeed/hls"
+	"clipfeed/httputil"
+	"clipfeed/jobs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// hlsJobPriority runs HLS transcodes after downloads, so new sources are
+// clipped first; clips stream as MP4 until their HLS renditions are ready.
+const hlsJobPriority = 3
+
+// queueHLSJob queues a transcode_hls job for a clip in the caller's
+// transaction.
+func queueHLSJob(ctx context.Context, conn *db.CompatConn, clipID, storageKey string) error {
+	payload, err := jobs.EncodePayload("transcode_hls", &jobs.TranscodeHLSPayload{
+		ClipID: clipID, StorageKey: storageKey, OutputPrefix: fmt.Sprintf("clips/%s/hls", clipID),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx,
+		`INSERT INTO jobs (id, job_type, priority, payload) VALUES (?, 'transcode_hls', ?, ?)`,
+		uuid.New().String(), hlsJobPriority, jobs.WithTraceparent(ctx, payload)); err != nil {
+		return fmt.Errorf("queue transcode_hls job: %w", err)
+	}
+	return nil
+}
+
+// hlsVariantInput is one bitrate rung a transcode_hls job reports.
+type hlsVariantInput struct {
+	Name        string   `json:"name"`
+	Bandwidth   int      `json:"bandwidth"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Codecs      string   `json:"codecs"`
+	PlaylistKey string   `json:"playlist_key"`
+	Playlist    string   `json:"playlist"`
+	SegmentKeys []string `json:"segment_keys"`
+}
+
+// validate checks a variant's fields and that its playlist names only its
+// segments.
+func (v *hlsVariantInput) validate() error {
+	switch {
+	case !hls.ValidName(v.Name):
+		return fmt.Errorf("variant name %q must be up to 32 lowercase letters, digits, dashes, and underscores", v.Name)
+	case v.Bandwidth <= 0:
+		return fmt.Errorf("variant %s: bandwidth must be positive", v.Name)
+	case v.Width < 0 || v.Height < 0:
+		return fmt.Errorf("variant %s: width and height cannot be negative", v.Name)
+	case strings.ContainsAny(v.Codecs, "\"\r\n"):
+		return fmt.Errorf("variant %s: invalid codecs", v.Name)
+	case strings.TrimSpace(v.PlaylistKey) == "" || strings.HasPrefix(v.PlaylistKey, "/") || strings.Contains(v.PlaylistKey, ".."):
+		return fmt.Errorf("variant %s: playlist_key must be a relative storage key", v.Name)
+	}
+	if err := hls.Validate(v.Playlist, v.SegmentKeys); err != nil {
+		return fmt.Errorf("variant %s: %w", v.Name, err)
+	}
+	return nil
+}
+
+// HandleSetHLS replaces a clip's HLS renditions: the master playlist the
+// worker uploaded, and for each bitrate rung its media playlist and the
+// segments it names, all already in object storage.
+func (h *Handler) HandleSetHLS(w http.ResponseWriter, r *http.Request) {
+	clipID := chi.URLParam(r, "id")
+	var req struct {
+		MasterKey string            `json:"master_key"`
+		Variants  []hlsVariantInput `json:"variants"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if len(req.Variants) == 0 || len(req.Variants) > hls.MaxVariants {
+		httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("variants must have 1 to %d entries", hls.MaxVariants)})
+		return
+	}
+	if strings.TrimSpace(req.MasterKey) == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "master_key is required"})
+		return
+	}
+	seen := make(map[string]bool, len(req.Variants))
+	for i := range req.Variants {
+		v := &req.Variants[i]
+		if err := v.validate(); err != nil {
+			httputil.WriteJSON(w, 400, map[string]string{"error": err.Error()})
+			return
+		}
+		if seen[v.Name] {
+			httputil.WriteJSON(w, 400, map[string]string{"error": fmt.Sprintf("variant %s is listed twice", v.Name)})
+			return
+		}
+		seen[v.Name] = true
+	}
+	var exists int
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT 1 FROM clips WHERE id = ?`, clipID).Scan(&exists); err != nil {
+		httputil.WriteJSON(w, 404, map[string]string{"error": "clip not found"})
+		return
+	}
+
+	if err := db.WithTx(r.Context(), h.DB, func(conn *db.CompatConn) error {
+		for _, table := range []string{"clip_hls_variants", "clip_hls_segments"} {
+			if _, err := conn.ExecContext(r.Context(), `DELETE FROM `+table+` WHERE clip_id = ?`, clipID); err != nil {
+				return fmt.Errorf("clear %s: %w", table, err)
+			}
+		}
+		for _, v := range req.Variants {
+			if _, err := conn.ExecContext(r.Context(), `
+				INSERT INTO clip_hls_variants (clip_id, name, bandwidth, width, height, codecs, playlist_key, playlist)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, clipID, v.Name, v.Bandwidth, v.Width, v.Height, v.Codecs, v.PlaylistKey, v.Playlist); err != nil {
+				return fmt.Errorf("insert variant %s: %w", v.Name, err)
+			}
+			for i, key := range v.SegmentKeys {
+				if _, err := conn.ExecContext(r.Context(),
+					`INSERT INTO clip_hls_segments (clip_id, variant, segment_index, segment_key) VALUES (?, ?, ?, ?)`,
+					clipID, v.Name, i, key); err != nil {
+					return fmt.Errorf("insert variant %s segment %d: %w", v.Name, i, err)
+				}
+			}
+		}
+		_, err := conn.ExecContext(r.Context(), `UPDATE clips SET hls_key = ? WHERE id = ?`, req.MasterKey, clipID)
+		return err
+	}); err != nil {
+		log.Printf("set HLS renditions for clip %s: %v", clipID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to store HLS renditions"})
+		return
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{"id": clipID, "variants": len(req.Variants)})
+}
@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"clipfeed/db"
+)
+
+// claimLockKey is the Postgres advisory lock taken while claiming a job.
+const claimLockKey = 0x636c6970 // "clip"
+
+// ParsePlatformLimits parses a "platform=N,platform=N" spec into caps.
+// Malformed or non-positive entries are skipped.
+func ParsePlatformLimits(spec string) map[string]int {
+	limits := map[string]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		platform, n, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || max <= 0 {
+			continue
+		}
+		limits[strings.ToLower(strings.TrimSpace(platform))] = max
+	}
+	return limits
+}
+
+// SeedPlatformLimits inserts default caps for platforms that have no
+// admin-configured limit yet. Existing rows are left untouched.
+func SeedPlatformLimits(ctx context.Context, cdb *db.CompatDB, limits map[string]int) {
+	for platform, max := range limits {
+		if _, err := cdb.ExecContext(ctx, `
+			INSERT INTO platform_limits (platform, max_concurrent) VALUES (?, ?)
+			ON CONFLICT(platform) DO NOTHING
+		`, platform, max); err != nil {
+			log.Printf("seed platform limit %s: %v", platform, err)
+		}
+	}
+}
+
+// claimableJobFilter restricts queued jobs to those whose platform, and the
+// submitting user's cookie for that platform, are below their concurrency
+// caps. Jobs without a source (or with no cap configured) are unrestricted.
+const claimableJobFilter = `
+	AND (pl.max_concurrent IS NULL OR (
+		SELECT COUNT(*) FROM jobs rj JOIN sources rs ON rj.source_id = rs.id
+		WHERE rj.status = 'running' AND rs.platform = s.platform
+	) < pl.max_concurrent)
+	AND (pc.max_concurrent IS NULL OR (
+		SELECT COUNT(*) FROM jobs rj JOIN sources rs ON rj.source_id = rs.id
+		WHERE rj.status = 'running' AND rs.platform = s.platform AND rs.submitted_by = s.submitted_by
+	) < pc.max_concurrent)`
+
+// claimQuery builds the UPDATE that claims the next eligible job for the
+// worker run, optionally restricted to the given job types.
+func claimQuery(cdb *db.CompatDB, workerID, runID string, jobTypes []string) (string, []interface{}) {
+	nowExpr := cdb.NowUTC()
+	lock := ""
+	if cdb.IsPostgres() {
+		lock = "FOR UPDATE OF j SKIP LOCKED"
+	}
+	typeFilter := ""
+	args := []interface{}{nullIfEmpty(workerID), nullIfEmpty(runID)}
+	if len(jobTypes) > 0 {
+		typeFilter = "AND j.job_type IN (?" + strings.Repeat(", ?", len(jobTypes)-1) + ")"
+		for _, t := range jobTypes {
+			args = append(args, t)
+		}
+	}
+	return fmt.Sprintf(`
+		UPDATE jobs SET status = 'running', started_at = %s, attempts = attempts + 1,
+			worker_id = ?, worker_run_id = ?
+		WHERE id = (
+			SELECT j.id FROM jobs j
+			LEFT JOIN sources s ON j.source_id = s.id
+			LEFT JOIN platform_limits pl ON pl.platform = s.platform
+			LEFT JOIN platform_cookies pc ON pc.user_id = s.submitted_by
+				AND pc.platform = s.platform AND pc.is_active = 1
+			WHERE j.status = 'queued' AND (j.run_after IS NULL OR j.run_after <= %s)
+			%s
+			AND NOT EXISTS (
+				SELECT 1 FROM job_dependencies d JOIN jobs dj ON d.depends_on_job_id = dj.id
+				WHERE d.job_id = j.id AND dj.status != 'complete'
+			)
+			%s
+			ORDER BY j.priority DESC, j.created_at ASC LIMIT 1 %s
+		) RETURNING id, job_type, payload
+	`, nowExpr, nowExpr, typeFilter, claimableJobFilter, lock), args
+}
@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clipfeed/httputil"
+	"clipfeed/jobs"
+)
+
+// workerRunIDKey is the context key holding the ID a worker process picked
+// for its run with X-Worker-Run-ID, or x-worker-run-id metadata over gRPC.
+const workerRunIDKey contextKey = "worker_run_id"
+
+const (
+	// DefaultRunGrace is how long after startup the API waits for live
+	// worker runs to check in before it requeues jobs claimed by the rest.
+	// Workers heartbeat running jobs every 30 seconds.
+	DefaultRunGrace = 2 * time.Minute
+	// runRetention is how long runs that stopped checking in are kept.
+	runRetention = 7 * 24 * time.Hour
+)
+
+func withWorkerRunID(ctx context.Context, runID string) context.Context {
+	runID = strings.TrimSpace(runID)
+	if len(runID) > maxWorkerIDLen {
+		runID = runID[:maxWorkerIDLen]
+	}
+	return context.WithValue(ctx, workerRunIDKey, runID)
+}
+
+func workerRunIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(workerRunIDKey).(string)
+	return id
+}
+
+// touchRun records that the calling worker run is alive, adding it when
+// the API has not heard from it before. Calls without a run ID are ignored.
+func (h *Handler) touchRun(ctx context.Context) error {
+	runID := workerRunIDFromContext(ctx)
+	if runID == "" {
+		return nil
+	}
+	_, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO worker_runs (run_id, worker_id) VALUES (?, ?)
+		ON CONFLICT(run_id) DO UPDATE SET last_seen_at = %s
+	`, h.DB.NowUTC()), runID, workerIDFromContext(ctx))
+	return err
+}
+
+// recoverJobs hands running jobs matching filter back to the queue, or
+// fails those out of attempts, noting msg in their error. Failures cascade
+// to dependents.
+func (h *Handler) recoverJobs(ctx context.Context, filter string, args []interface{}, msg string) (requeued, failed int, err error) {
+	nowExpr := h.DB.NowUTC()
+	errorExpr := "error = CASE WHEN error IS NULL OR error = '' THEN ? ELSE error || ' | ' || ? END"
+	for _, step := range []struct {
+		set, attempts string
+		n             *int
+	}{
+		{"status = 'queued', run_after = " + nowExpr, "attempts < max_attempts", &requeued},
+		{"status = 'failed', completed_at = " + nowExpr, "attempts >= max_attempts", &failed},
+	} {
+		res, err := h.DB.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE jobs SET %s, %s WHERE status = 'running' AND %s AND %s
+		`, step.set, errorExpr, step.attempts, filter), append([]interface{}{msg, msg}, args...)...)
+		if err != nil {
+			return requeued, failed, err
+		}
+		n, _ := res.RowsAffected()
+		*step.n = int(n)
+	}
+	if failed > 0 {
+		if _, err := jobs.FailBlockedJobs(ctx, h.DB); err != nil {
+			log.Printf("recover jobs: cascade failure to dependents: %v", err)
+		}
+	}
+	return requeued, failed, nil
+}
+
+// HandleRegisterRun is called by a worker process as it starts. It records
+// the run named by X-Worker-Run-ID and at once recovers the jobs that
+// earlier runs of the same worker, by X-Worker-ID, still hold: that worker
+// restarted, so they will never finish.
+func (h *Handler) HandleRegisterRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	runID, workerID := workerRunIDFromContext(ctx), workerIDFromContext(ctx)
+	if runID == "" {
+		httputil.WriteJSON(w, 400, map[string]string{"error": "X-Worker-Run-ID header is required"})
+		return
+	}
+	if err := h.touchRun(ctx); err != nil {
+		log.Printf("register worker run %s: %v", runID, err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to register run"})
+		return
+	}
+	var requeued, failed int
+	if workerID != "" {
+		var err error
+		requeued, failed, err = h.recoverJobs(ctx, "worker_id = ? AND worker_run_id IS NOT NULL AND worker_run_id != ?",
+			[]interface{}{workerID, runID}, fmt.Sprintf("worker %s restarted: recovered job from its previous run", workerID))
+		if err != nil {
+			log.Printf("register worker run %s: recover jobs: %v", runID, err)
+			httputil.WriteJSON(w, 500, map[string]string{"error": "failed to recover jobs"})
+			return
+		}
+		if requeued+failed > 0 {
+			log.Printf("worker %s restarted: requeued %d and failed %d jobs from its previous run", workerID, requeued, failed)
+		}
+	}
+	httputil.WriteJSON(w, 200, map[string]interface{}{
+		"run_id": runID, "requeued": requeued, "failed": failed,
+	})
+}
+
+// RecoverUnknownRuns recovers running jobs claimed by runs the API has not
+// heard from since since: no claim, heartbeat, or registration. Jobs
+// claimed without a run ID are left to the stale watchdog.
+func (h *Handler) RecoverUnknownRuns(ctx context.Context, since time.Time) (requeued, failed int, err error) {
+	return h.recoverJobs(ctx, `worker_run_id IS NOT NULL AND worker_run_id NOT IN (
+		SELECT run_id FROM worker_runs WHERE last_seen_at >= ?
+	)`, []interface{}{since.UTC().Format("2006-01-02T15:04:05Z")}, "worker run gone after API restart: recovered job")
+}
+
+// StartupRunSweep runs once as the API starts: it logs the job queue it
+// inherited, then after grace recovers the jobs held by worker runs that
+// have not checked in since, and forgets runs long gone.
+func (h *Handler) StartupRunSweep(grace time.Duration) {
+	ctx := context.Background()
+	started := time.Now()
+	if snap, err := h.QueueSnapshot(ctx); err != nil {
+		log.Printf("worker runs: job queue snapshot: %v", err)
+	} else {
+		log.Printf("worker runs: job queue at startup: %v, %d running under %d runs",
+			snap.Statuses, snap.Running, len(snap.Runs))
+	}
+	time.Sleep(grace)
+	if requeued, failed, err := h.RecoverUnknownRuns(ctx, started); err != nil {
+		log.Printf("worker runs: recover jobs of unknown runs: %v", err)
+	} else if requeued+failed > 0 {
+		log.Printf("worker runs: requeued %d and failed %d jobs held by runs that did not check in", requeued, failed)
+	}
+	if _, err := h.DB.ExecContext(ctx, `DELETE FROM worker_runs WHERE last_seen_at < ?`,
+		time.Now().UTC().Add(-runRetention).Format("2006-01-02T15:04:05Z")); err != nil {
+		log.Printf("worker runs: prune: %v", err)
+	}
+}
+
+// runSnapshot is one worker run holding running jobs.
+type runSnapshot struct {
+	RunID      string  `json:"run_id"`
+	WorkerID   string  `json:"worker_id"`
+	StartedAt  *string `json:"started_at"`
+	LastSeenAt *string `json:"last_seen_at"`
+	Running    int     `json:"running"`
+}
+
+// queueSnapshot is the state of the job queue at one moment.
+type queueSnapshot struct {
+	TakenAt  string         `json:"taken_at"`
+	Statuses map[string]int `json:"statuses"`
+	Running  int            `json:"running"`
+	// Runs lists the runs holding running jobs, most jobs first. Jobs
+	// claimed without a run ID are grouped under an empty run_id, and runs
+	// the API never heard from have no started_at or last_seen_at.
+	Runs []runSnapshot `json:"runs"`
+}
+
+// QueueSnapshot counts jobs by status and running jobs by the worker run
+// holding them.
+func (h *Handler) QueueSnapshot(ctx context.Context) (*queueSnapshot, error) {
+	snap := &queueSnapshot{
+		TakenAt:  time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Statuses: map[string]int{},
+		Runs:     []runSnapshot{},
+	}
+	rows, err := h.DB.QueryContext(ctx, `SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snap.Statuses[status] = n
+	}
+	rows.Close()
+	snap.Running = snap.Statuses["running"]
+
+	rows, err = h.DB.QueryContext(ctx, `
+		SELECT COALESCE(j.worker_run_id, ''), COALESCE(MAX(j.worker_id), ''), r.started_at, r.last_seen_at, COUNT(*)
+		FROM jobs j LEFT JOIN worker_runs r ON r.run_id = j.worker_run_id
+		WHERE j.status = 'running'
+		GROUP BY j.worker_run_id, r.started_at, r.last_seen_at
+		ORDER BY COUNT(*) DESC, COALESCE(j.worker_run_id, '')
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var run runSnapshot
+		if err := rows.Scan(&run.RunID, &run.WorkerID, &run.StartedAt, &run.LastSeenAt, &run.Running); err != nil {
+			return nil, err
+		}
+		snap.Runs = append(snap.Runs, run)
+	}
+	return snap, rows.Err()
+}
+
+// HandleQueueSnapshot reports the job queue: jobs by status and the worker
+// runs holding running jobs, with when each was last heard from.
+func (h *Handler) HandleQueueSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := h.QueueSnapshot(r.Context())
+	if err != nil {
+		log.Printf("job queue snapshot: %v", err)
+		httputil.WriteJSON(w, 500, map[string]string{"error": "failed to load job queue"})
+		return
+	}
+	httputil.WriteJSON(w, 200, snap)
+}
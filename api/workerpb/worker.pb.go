@@ -0,0 +1,872 @@
+// Worker protocol between the Go API and the ingestion worker.
+//
+// This is the schema for the internal worker API. The HTTP+JSON endpoints
+// under /api/internal remain as a compatibility layer and share the same
+// server-side logic. Regenerate the Go and Python stubs with `make proto`.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: workerpb/worker.proto
+
+package workerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ClaimJobRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only claim jobs of these types; empty means any type.
+	JobTypes      []string `protobuf:"bytes,1,rep,name=job_types,json=jobTypes,proto3" json:"job_types,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClaimJobRequest) Reset() {
+	*x = ClaimJobRequest{}
+	mi := &file_workerpb_worker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClaimJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClaimJobRequest) ProtoMessage() {}
+
+func (x *ClaimJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClaimJobRequest.ProtoReflect.Descriptor instead.
+func (*ClaimJobRequest) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ClaimJobRequest) GetJobTypes() []string {
+	if x != nil {
+		return x.JobTypes
+	}
+	return nil
+}
+
+type Job struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	JobType string                 `protobuf:"bytes,2,opt,name=job_type,json=jobType,proto3" json:"job_type,omitempty"`
+	// Job payload as a JSON object.
+	PayloadJson   string `protobuf:"bytes,3,opt,name=payload_json,json=payloadJson,proto3" json:"payload_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Job) Reset() {
+	*x = Job{}
+	mi := &file_workerpb_worker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Job) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Job) ProtoMessage() {}
+
+func (x *Job) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Job.ProtoReflect.Descriptor instead.
+func (*Job) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Job) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Job) GetJobType() string {
+	if x != nil {
+		return x.JobType
+	}
+	return ""
+}
+
+func (x *Job) GetPayloadJson() string {
+	if x != nil {
+		return x.PayloadJson
+	}
+	return ""
+}
+
+type ClaimJobResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Unset when no job is runnable.
+	Job           *Job `protobuf:"bytes,1,opt,name=job,proto3" json:"job,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClaimJobResponse) Reset() {
+	*x = ClaimJobResponse{}
+	mi := &file_workerpb_worker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClaimJobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClaimJobResponse) ProtoMessage() {}
+
+func (x *ClaimJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClaimJobResponse.ProtoReflect.Descriptor instead.
+func (*ClaimJobResponse) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ClaimJobResponse) GetJob() *Job {
+	if x != nil {
+		return x.Job
+	}
+	return nil
+}
+
+type UpdateJobRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// One of complete, failed, rejected, cancelled, or queued.
+	Status string  `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Error  *string `protobuf:"bytes,3,opt,name=error,proto3,oneof" json:"error,omitempty"`
+	// Job result as a JSON object; terminal statuses only.
+	ResultJson *string `protobuf:"bytes,4,opt,name=result_json,json=resultJson,proto3,oneof" json:"result_json,omitempty"`
+	// Earliest time a re-queued job may run (ISO 8601); queued only.
+	RunAfter      *string `protobuf:"bytes,5,opt,name=run_after,json=runAfter,proto3,oneof" json:"run_after,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateJobRequest) Reset() {
+	*x = UpdateJobRequest{}
+	mi := &file_workerpb_worker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateJobRequest) ProtoMessage() {}
+
+func (x *UpdateJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateJobRequest.ProtoReflect.Descriptor instead.
+func (*UpdateJobRequest) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UpdateJobRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *UpdateJobRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *UpdateJobRequest) GetError() string {
+	if x != nil && x.Error != nil {
+		return *x.Error
+	}
+	return ""
+}
+
+func (x *UpdateJobRequest) GetResultJson() string {
+	if x != nil && x.ResultJson != nil {
+		return *x.ResultJson
+	}
+	return ""
+}
+
+func (x *UpdateJobRequest) GetRunAfter() string {
+	if x != nil && x.RunAfter != nil {
+		return *x.RunAfter
+	}
+	return ""
+}
+
+type UpdateJobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateJobResponse) Reset() {
+	*x = UpdateJobResponse{}
+	mi := &file_workerpb_worker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateJobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateJobResponse) ProtoMessage() {}
+
+func (x *UpdateJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateJobResponse.ProtoReflect.Descriptor instead.
+func (*UpdateJobResponse) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{4}
+}
+
+type CreateClipRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	SourceId        string                 `protobuf:"bytes,2,opt,name=source_id,json=sourceId,proto3" json:"source_id,omitempty"`
+	Title           string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`
+	DurationSeconds float64                `protobuf:"fixed64,4,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+	StartTime       float64                `protobuf:"fixed64,5,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	EndTime         float64                `protobuf:"fixed64,6,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	StorageKey      string                 `protobuf:"bytes,7,opt,name=storage_key,json=storageKey,proto3" json:"storage_key,omitempty"`
+	ThumbnailKey    string                 `protobuf:"bytes,8,opt,name=thumbnail_key,json=thumbnailKey,proto3" json:"thumbnail_key,omitempty"`
+	Width           int32                  `protobuf:"varint,9,opt,name=width,proto3" json:"width,omitempty"`
+	Height          int32                  `protobuf:"varint,10,opt,name=height,proto3" json:"height,omitempty"`
+	FileSizeBytes   int64                  `protobuf:"varint,11,opt,name=file_size_bytes,json=fileSizeBytes,proto3" json:"file_size_bytes,omitempty"`
+	Transcript      string                 `protobuf:"bytes,12,opt,name=transcript,proto3" json:"transcript,omitempty"`
+	Topics          []string               `protobuf:"bytes,13,rep,name=topics,proto3" json:"topics,omitempty"`
+	ContentScore    float64                `protobuf:"fixed64,14,opt,name=content_score,json=contentScore,proto3" json:"content_score,omitempty"`
+	ExpiresAt       string                 `protobuf:"bytes,15,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Platform        string                 `protobuf:"bytes,16,opt,name=platform,proto3" json:"platform,omitempty"`
+	ChannelName     string                 `protobuf:"bytes,17,opt,name=channel_name,json=channelName,proto3" json:"channel_name,omitempty"`
+	// Little-endian float32 vectors.
+	TextEmbedding   []byte `protobuf:"bytes,18,opt,name=text_embedding,json=textEmbedding,proto3" json:"text_embedding,omitempty"`
+	VisualEmbedding []byte `protobuf:"bytes,19,opt,name=visual_embedding,json=visualEmbedding,proto3" json:"visual_embedding,omitempty"`
+	ModelVersion    string `protobuf:"bytes,20,opt,name=model_version,json=modelVersion,proto3" json:"model_version,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CreateClipRequest) Reset() {
+	*x = CreateClipRequest{}
+	mi := &file_workerpb_worker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateClipRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateClipRequest) ProtoMessage() {}
+
+func (x *CreateClipRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateClipRequest.ProtoReflect.Descriptor instead.
+func (*CreateClipRequest) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CreateClipRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetSourceId() string {
+	if x != nil {
+		return x.SourceId
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetDurationSeconds() float64 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetStartTime() float64 {
+	if x != nil {
+		return x.StartTime
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetEndTime() float64 {
+	if x != nil {
+		return x.EndTime
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetStorageKey() string {
+	if x != nil {
+		return x.StorageKey
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetThumbnailKey() string {
+	if x != nil {
+		return x.ThumbnailKey
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetFileSizeBytes() int64 {
+	if x != nil {
+		return x.FileSizeBytes
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetTranscript() string {
+	if x != nil {
+		return x.Transcript
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+func (x *CreateClipRequest) GetContentScore() float64 {
+	if x != nil {
+		return x.ContentScore
+	}
+	return 0
+}
+
+func (x *CreateClipRequest) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetChannelName() string {
+	if x != nil {
+		return x.ChannelName
+	}
+	return ""
+}
+
+func (x *CreateClipRequest) GetTextEmbedding() []byte {
+	if x != nil {
+		return x.TextEmbedding
+	}
+	return nil
+}
+
+func (x *CreateClipRequest) GetVisualEmbedding() []byte {
+	if x != nil {
+		return x.VisualEmbedding
+	}
+	return nil
+}
+
+func (x *CreateClipRequest) GetModelVersion() string {
+	if x != nil {
+		return x.ModelVersion
+	}
+	return ""
+}
+
+type CreateClipResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateClipResponse) Reset() {
+	*x = CreateClipResponse{}
+	mi := &file_workerpb_worker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateClipResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateClipResponse) ProtoMessage() {}
+
+func (x *CreateClipResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateClipResponse.ProtoReflect.Descriptor instead.
+func (*CreateClipResponse) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CreateClipResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ResolveTopicRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveTopicRequest) Reset() {
+	*x = ResolveTopicRequest{}
+	mi := &file_workerpb_worker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveTopicRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveTopicRequest) ProtoMessage() {}
+
+func (x *ResolveTopicRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveTopicRequest.ProtoReflect.Descriptor instead.
+func (*ResolveTopicRequest) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ResolveTopicRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type ResolveTopicResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Created       bool                   `protobuf:"varint,2,opt,name=created,proto3" json:"created,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveTopicResponse) Reset() {
+	*x = ResolveTopicResponse{}
+	mi := &file_workerpb_worker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveTopicResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveTopicResponse) ProtoMessage() {}
+
+func (x *ResolveTopicResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveTopicResponse.ProtoReflect.Descriptor instead.
+func (*ResolveTopicResponse) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ResolveTopicResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ResolveTopicResponse) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+type GetCookieRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SourceId      string                 `protobuf:"bytes,1,opt,name=source_id,json=sourceId,proto3" json:"source_id,omitempty"`
+	Platform      string                 `protobuf:"bytes,2,opt,name=platform,proto3" json:"platform,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCookieRequest) Reset() {
+	*x = GetCookieRequest{}
+	mi := &file_workerpb_worker_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCookieRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCookieRequest) ProtoMessage() {}
+
+func (x *GetCookieRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCookieRequest.ProtoReflect.Descriptor instead.
+func (*GetCookieRequest) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetCookieRequest) GetSourceId() string {
+	if x != nil {
+		return x.SourceId
+	}
+	return ""
+}
+
+func (x *GetCookieRequest) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+type GetCookieResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Unset when the submitter has no active cookie for the platform.
+	Cookie        *string `protobuf:"bytes,1,opt,name=cookie,proto3,oneof" json:"cookie,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCookieResponse) Reset() {
+	*x = GetCookieResponse{}
+	mi := &file_workerpb_worker_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCookieResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCookieResponse) ProtoMessage() {}
+
+func (x *GetCookieResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_workerpb_worker_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCookieResponse.ProtoReflect.Descriptor instead.
+func (*GetCookieResponse) Descriptor() ([]byte, []int) {
+	return file_workerpb_worker_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetCookieResponse) GetCookie() string {
+	if x != nil && x.Cookie != nil {
+		return *x.Cookie
+	}
+	return ""
+}
+
+var File_workerpb_worker_proto protoreflect.FileDescriptor
+
+const file_workerpb_worker_proto_rawDesc = "" +
+	"\n" +
+	"\x15workerpb/worker.proto\x12\x12clipfeed.worker.v1\".\n" +
+	"\x0fClaimJobRequest\x12\x1b\n" +
+	"\tjob_types\x18\x01 \x03(\tR\bjobTypes\"S\n" +
+	"\x03Job\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
+	"\bjob_type\x18\x02 \x01(\tR\ajobType\x12!\n" +
+	"\fpayload_json\x18\x03 \x01(\tR\vpayloadJson\"=\n" +
+	"\x10ClaimJobResponse\x12)\n" +
+	"\x03job\x18\x01 \x01(\v2\x17.clipfeed.worker.v1.JobR\x03job\"\xcc\x01\n" +
+	"\x10UpdateJobRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x19\n" +
+	"\x05error\x18\x03 \x01(\tH\x00R\x05error\x88\x01\x01\x12$\n" +
+	"\vresult_json\x18\x04 \x01(\tH\x01R\n" +
+	"resultJson\x88\x01\x01\x12 \n" +
+	"\trun_after\x18\x05 \x01(\tH\x02R\brunAfter\x88\x01\x01B\b\n" +
+	"\x06_errorB\x0e\n" +
+	"\f_result_jsonB\f\n" +
+	"\n" +
+	"_run_after\"\x13\n" +
+	"\x11UpdateJobResponse\"\x89\x05\n" +
+	"\x11CreateClipRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tsource_id\x18\x02 \x01(\tR\bsourceId\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12)\n" +
+	"\x10duration_seconds\x18\x04 \x01(\x01R\x0fdurationSeconds\x12\x1d\n" +
+	"\n" +
+	"start_time\x18\x05 \x01(\x01R\tstartTime\x12\x19\n" +
+	"\bend_time\x18\x06 \x01(\x01R\aendTime\x12\x1f\n" +
+	"\vstorage_key\x18\a \x01(\tR\n" +
+	"storageKey\x12#\n" +
+	"\rthumbnail_key\x18\b \x01(\tR\fthumbnailKey\x12\x14\n" +
+	"\x05width\x18\t \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\n" +
+	" \x01(\x05R\x06height\x12&\n" +
+	"\x0ffile_size_bytes\x18\v \x01(\x03R\rfileSizeBytes\x12\x1e\n" +
+	"\n" +
+	"transcript\x18\f \x01(\tR\n" +
+	"transcript\x12\x16\n" +
+	"\x06topics\x18\r \x03(\tR\x06topics\x12#\n" +
+	"\rcontent_score\x18\x0e \x01(\x01R\fcontentScore\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x0f \x01(\tR\texpiresAt\x12\x1a\n" +
+	"\bplatform\x18\x10 \x01(\tR\bplatform\x12!\n" +
+	"\fchannel_name\x18\x11 \x01(\tR\vchannelName\x12%\n" +
+	"\x0etext_embedding\x18\x12 \x01(\fR\rtextEmbedding\x12)\n" +
+	"\x10visual_embedding\x18\x13 \x01(\fR\x0fvisualEmbedding\x12#\n" +
+	"\rmodel_version\x18\x14 \x01(\tR\fmodelVersion\"$\n" +
+	"\x12CreateClipResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\")\n" +
+	"\x13ResolveTopicRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\"@\n" +
+	"\x14ResolveTopicResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\acreated\x18\x02 \x01(\bR\acreated\"K\n" +
+	"\x10GetCookieRequest\x12\x1b\n" +
+	"\tsource_id\x18\x01 \x01(\tR\bsourceId\x12\x1a\n" +
+	"\bplatform\x18\x02 \x01(\tR\bplatform\";\n" +
+	"\x11GetCookieResponse\x12\x1b\n" +
+	"\x06cookie\x18\x01 \x01(\tH\x00R\x06cookie\x88\x01\x01B\t\n" +
+	"\a_cookie2\xda\x03\n" +
+	"\rWorkerService\x12U\n" +
+	"\bClaimJob\x12#.clipfeed.worker.v1.ClaimJobRequest\x1a$.clipfeed.worker.v1.ClaimJobResponse\x12X\n" +
+	"\tUpdateJob\x12$.clipfeed.worker.v1.UpdateJobRequest\x1a%.clipfeed.worker.v1.UpdateJobResponse\x12[\n" +
+	"\n" +
+	"CreateClip\x12%.clipfeed.worker.v1.CreateClipRequest\x1a&.clipfeed.worker.v1.CreateClipResponse\x12a\n" +
+	"\fResolveTopic\x12'.clipfeed.worker.v1.ResolveTopicRequest\x1a(.clipfeed.worker.v1.ResolveTopicResponse\x12X\n" +
+	"\tGetCookie\x12$.clipfeed.worker.v1.GetCookieRequest\x1a%.clipfeed.worker.v1.GetCookieResponseB\x13Z\x11clipfeed/workerpbb\x06proto3"
+
+var (
+	file_workerpb_worker_proto_rawDescOnce sync.Once
+	file_workerpb_worker_proto_rawDescData []byte
+)
+
+func file_workerpb_worker_proto_rawDescGZIP() []byte {
+	file_workerpb_worker_proto_rawDescOnce.Do(func() {
+		file_workerpb_worker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_workerpb_worker_proto_rawDesc), len(file_workerpb_worker_proto_rawDesc)))
+	})
+	return file_workerpb_worker_proto_rawDescData
+}
+
+var file_workerpb_worker_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_workerpb_worker_proto_goTypes = []any{
+	(*ClaimJobRequest)(nil),      // 0: clipfeed.worker.v1.ClaimJobRequest
+	(*Job)(nil),                  // 1: clipfeed.worker.v1.Job
+	(*ClaimJobResponse)(nil),     // 2: clipfeed.worker.v1.ClaimJobResponse
+	(*UpdateJobRequest)(nil),     // 3: clipfeed.worker.v1.UpdateJobRequest
+	(*UpdateJobResponse)(nil),    // 4: clipfeed.worker.v1.UpdateJobResponse
+	(*CreateClipRequest)(nil),    // 5: clipfeed.worker.v1.CreateClipRequest
+	(*CreateClipResponse)(nil),   // 6: clipfeed.worker.v1.CreateClipResponse
+	(*ResolveTopicRequest)(nil),  // 7: clipfeed.worker.v1.ResolveTopicRequest
+	(*ResolveTopicResponse)(nil), // 8: clipfeed.worker.v1.ResolveTopicResponse
+	(*GetCookieRequest)(nil),     // 9: clipfeed.worker.v1.GetCookieRequest
+	(*GetCookieResponse)(nil),    // 10: clipfeed.worker.v1.GetCookieResponse
+}
+var file_workerpb_worker_proto_depIdxs = []int32{
+	1,  // 0: clipfeed.worker.v1.ClaimJobResponse.job:type_name -> clipfeed.worker.v1.Job
+	0,  // 1: clipfeed.worker.v1.WorkerService.ClaimJob:input_type -> clipfeed.worker.v1.ClaimJobRequest
+	3,  // 2: clipfeed.worker.v1.WorkerService.UpdateJob:input_type -> clipfeed.worker.v1.UpdateJobRequest
+	5,  // 3: clipfeed.worker.v1.WorkerService.CreateClip:input_type -> clipfeed.worker.v1.CreateClipRequest
+	7,  // 4: clipfeed.worker.v1.WorkerService.ResolveTopic:input_type -> clipfeed.worker.v1.ResolveTopicRequest
+	9,  // 5: clipfeed.worker.v1.WorkerService.GetCookie:input_type -> clipfeed.worker.v1.GetCookieRequest
+	2,  // 6: clipfeed.worker.v1.WorkerService.ClaimJob:output_type -> clipfeed.worker.v1.ClaimJobResponse
+	4,  // 7: clipfeed.worker.v1.WorkerService.UpdateJob:output_type -> clipfeed.worker.v1.UpdateJobResponse
+	6,  // 8: clipfeed.worker.v1.WorkerService.CreateClip:output_type -> clipfeed.worker.v1.CreateClipResponse
+	8,  // 9: clipfeed.worker.v1.WorkerService.ResolveTopic:output_type -> clipfeed.worker.v1.ResolveTopicResponse
+	10, // 10: clipfeed.worker.v1.WorkerService.GetCookie:output_type -> clipfeed.worker.v1.GetCookieResponse
+	6,  // [6:11] is the sub-list for method output_type
+	1,  // [1:6] is the sub-list for method input_type
+	1,  // [1:1] is the sub-list for extension type_name
+	1,  // [1:1] is the sub-list for extension extendee
+	0,  // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_workerpb_worker_proto_init() }
+func file_workerpb_worker_proto_init() {
+	if File_workerpb_worker_proto != nil {
+		return
+	}
+	file_workerpb_worker_proto_msgTypes[3].OneofWrappers = []any{}
+	file_workerpb_worker_proto_msgTypes[10].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_workerpb_worker_proto_rawDesc), len(file_workerpb_worker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_workerpb_worker_proto_goTypes,
+		DependencyIndexes: file_workerpb_worker_proto_depIdxs,
+		MessageInfos:      file_workerpb_worker_proto_msgTypes,
+	}.Build()
+	File_workerpb_worker_proto = out.File
+	file_workerpb_worker_proto_goTypes = nil
+	file_workerpb_worker_proto_depIdxs = nil
+}
@@ -18,16 +18,23 @@ log = logging.getLogger("worker.api_client")
 
 
 class DuplicateSourceError(Exception):
-    """Raised when a source update conflicts with an existing source (same platform + external_id)."""
-    pass
+    """Raised when a source update conflicts with an existing source (same platform + external_id).
+    duplicate_of_clip names a ready clip of the existing source, when it has one."""
+
+    def __init__(self, message, duplicate_of_source=None, duplicate_of_clip=None):
+        super().__init__(message)
+        self.duplicate_of_source = duplicate_of_source
+        self.duplicate_of_clip = duplicate_of_clip
 
 
 class WorkerAPIClient:
     """HTTP client for the ClipFeed internal worker API."""
 
-    def __init__(self, api_url: str, worker_secret: str, timeout: int = 30):
+    def __init__(self, api_url: str, worker_secret: str, timeout: int = 30, worker_id: str = "", run_id: str = ""):
         self.api_url = api_url.rstrip("/")
         self._worker_secret = worker_secret
+        self.worker_id = worker_id
+        self.run_id = run_id
         self.timeout = timeout
         self._local = threading.local()
 
@@ -39,26 +46,46 @@ class WorkerAPIClient:
                 "Authorization": f"Bearer {self._worker_secret}",
                 "Content-Type": "application/json",
             })
+            if self.worker_id:
+                s.headers["X-Worker-ID"] = self.worker_id
+            if self.run_id:
+                s.headers["X-Worker-Run-ID"] = self.run_id
             self._local.session = s
         return self._local.session
 
     def _url(self, path: str) -> str:
         return f"{self.api_url}/api/internal{path}"
 
+    def set_traceparent(self, traceparent: str | None):
+        """Send traceparent with this thread's calls, so the API's spans for
+        them join the trace of the request that queued the job. None stops."""
+        self._local.traceparent = traceparent or None
+
+    def _traceparent(self) -> str | None:
+        return getattr(self._local, "traceparent", None)
+
+    def _headers(self) -> dict:
+        tp = self._traceparent()
+        return {"traceparent": tp} if tp else {}
+
     def _get(self, path: str, **kwargs) -> requests.Response:
-        return self._session().get(self._url(path), timeout=self.timeout, **kwargs)
+        return self._session().get(self._url(path), timeout=self.timeout, headers=self._headers(), **kwargs)
 
     def _post(self, path: str, data=None, **kwargs) -> requests.Response:
-        return self._session().post(self._url(path), json=data, timeout=self.timeout, **kwargs)
+        return self._session().post(self._url(path), json=data, timeout=self.timeout, headers=self._headers(), **kwargs)
 
     def _put(self, path: str, data=None, **kwargs) -> requests.Response:
-        return self._session().put(self._url(path), json=data, timeout=self.timeout, **kwargs)
+        return self._session().put(self._url(path), json=data, timeout=self.timeout, headers=self._headers(), **kwargs)
 
     # --- Job operations ---
 
-    def claim_job(self) -> dict | None:
-        """Atomically claim the next queued job. Returns {id, payload} or None."""
-        resp = self._post("/jobs/claim")
+    def claim_job(self, job_types: list[str] | None = None) -> dict | None:
+        """Atomically claim the next queued job whose dependencies have completed.
+
+        Only jobs of the given types are considered when job_types is set.
+        Returns {id, job_type, payload} or None.
+        """
+        resp = self._post("/jobs/claim", data={"job_types": job_types} if job_types else None)
         if resp.status_code == 204:
             return None
         resp.raise_for_status()
@@ -109,13 +136,27 @@ class WorkerAPIClient:
         data = resp.json()
         return data.get("requeued", 0), data.get("failed", 0)
 
+    def register_run(self) -> tuple[int, int]:
+        """Register this process run with the API, which recovers the jobs
+        earlier runs of this worker left running. Returns (requeued, failed);
+        (0, 0) from APIs that predate run registration."""
+        resp = self._post("/workers/register")
+        if resp.status_code == 404:
+            return 0, 0
+        resp.raise_for_status()
+        data = resp.json()
+        return data.get("requeued", 0), data.get("failed", 0)
+
     # --- Source operations ---
 
     def update_source(self, source_id: str, **fields):
         """Update source fields: status, title, channel_name, metadata, etc."""
         resp = self._put(f"/sources/{source_id}", data=fields)
         if resp.status_code == 409:
-            raise DuplicateSourceError(resp.json().get("error", "duplicate source"))
+            body = resp.json()
+            raise DuplicateSourceError(body.get("error", "duplicate source"),
+                                       duplicate_of_source=body.get("duplicate_of_source"),
+                                       duplicate_of_clip=body.get("duplicate_of_clip"))
         resp.raise_for_status()
 
     def get_cookie(self, source_id: str, platform: str) -> str | None:
@@ -179,6 +220,37 @@ class WorkerAPIClient:
         resp.raise_for_status()
         return resp.json().get("id", clip_id)
 
+    def set_thumbnails(self, clip_id: str, thumbnail_keys: list[str]):
+        """Register candidate thumbnails; the feed picks among them by click-through."""
+        resp = self._put(f"/clips/{clip_id}/thumbnails", data={"thumbnail_keys": thumbnail_keys})
+        resp.raise_for_status()
+
+    def set_renditions(self, clip_id: str, low_storage_key: str = None, small_thumbnail_key: str = None,
+                       large_thumbnail_key: str = None):
+        """Register a clip's extra renditions: the data saver low-bitrate video
+        and small thumbnail, and the TV feed's large thumbnail."""
+        data = {}
+        if low_storage_key:
+            data["low_storage_key"] = low_storage_key
+        if small_thumbnail_key:
+            data["small_thumbnail_key"] = small_thumbnail_key
+        if large_thumbnail_key:
+            data["large_thumbnail_key"] = large_thumbnail_key
+        resp = self._put(f"/clips/{clip_id}/renditions", data=data)
+        resp.raise_for_status()
+
+    def set_preview(self, clip_id: str, preview_id: str, storage_key: str, file_size_bytes: int):
+        """Register a rendered GIF or WebM preview of a range of a clip."""
+        resp = self._put(f"/clips/{clip_id}/previews/{preview_id}",
+                         data={"storage_key": storage_key, "file_size_bytes": file_size_bytes})
+        resp.raise_for_status()
+
+    def set_hls(self, clip_id: str, master_key: str, variants: list[dict]):
+        """Register a clip's HLS renditions: its master playlist and, for each
+        bitrate rung, its media playlist and segments."""
+        resp = self._put(f"/clips/{clip_id}/hls", data={"master_key": master_key, "variants": variants})
+        resp.raise_for_status()
+
     # --- Topic operations ---
 
     def resolve_topic(self, name: str) -> str:
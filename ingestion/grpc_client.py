@@ -0,0 +1,141 @@
+"""
+ClipFeed Worker gRPC Client
+gRPC transport for the internal worker API.
+
+Claim, update, create clip, resolve topic, and get cookie go over the
+WorkerService defined in proto/workerpb/worker.proto; everything else falls
+back to the HTTP endpoints. Enabled by setting WORKER_GRPC_ADDR.
+
+The workerpb stubs are generated into the image at build time, so grpc is
+imported lazily to keep the HTTP client usable without them.
+"""
+
+import json
+import logging
+
+from api_client import WorkerAPIClient
+
+log = logging.getLogger("worker.grpc_client")
+
+
+class GRPCWorkerAPIClient(WorkerAPIClient):
+    """Worker API client that uses gRPC for the hot-path job and clip calls."""
+
+    def __init__(self, api_url: str, grpc_addr: str, worker_secret: str, timeout: int = 30,
+                 worker_id: str = "", run_id: str = ""):
+        super().__init__(api_url, worker_secret, timeout, worker_id, run_id)
+        import grpc
+        from workerpb import worker_pb2, worker_pb2_grpc
+
+        self._pb = worker_pb2
+        self._channel = grpc.insecure_channel(
+            grpc_addr,
+            options=[("grpc.max_send_message_length", 10 << 20)],
+        )
+        self._stub = worker_pb2_grpc.WorkerServiceStub(self._channel)
+        self._metadata = (("authorization", f"Bearer {worker_secret}"),)
+        if worker_id:
+            self._metadata += (("x-worker-id", worker_id),)
+        if run_id:
+            self._metadata += (("x-worker-run-id", run_id),)
+        log.info("Using worker gRPC API at %s", grpc_addr)
+
+    def _call(self, method, request):
+        metadata = self._metadata
+        tp = self._traceparent()
+        if tp:
+            metadata += (("traceparent", tp),)
+        return method(request, metadata=metadata, timeout=self.timeout)
+
+    # --- Job operations ---
+
+    def claim_job(self, job_types: list[str] | None = None) -> dict | None:
+        resp = self._call(self._stub.ClaimJob, self._pb.ClaimJobRequest(job_types=job_types or []))
+        if not resp.HasField("job"):
+            return None
+        return {
+            "id": resp.job.id,
+            "job_type": resp.job.job_type,
+            "payload": json.loads(resp.job.payload_json or "{}"),
+        }
+
+    def update_job(
+        self,
+        job_id: str,
+        status: str,
+        error: str = None,
+        result: dict = None,
+        run_after: str = None,
+    ):
+        req = self._pb.UpdateJobRequest(job_id=job_id, status=status)
+        if error is not None:
+            req.error = error
+        if result is not None:
+            req.result_json = json.dumps(result)
+        if run_after is not None:
+            req.run_after = run_after
+        self._call(self._stub.UpdateJob, req)
+
+    # --- Source operations ---
+
+    def get_cookie(self, source_id: str, platform: str) -> str | None:
+        resp = self._call(
+            self._stub.GetCookie,
+            self._pb.GetCookieRequest(source_id=source_id, platform=platform),
+        )
+        return resp.cookie if resp.HasField("cookie") else None
+
+    # --- Clip operations ---
+
+    def create_clip(
+        self,
+        clip_id: str,
+        source_id: str,
+        title: str,
+        duration_seconds: float,
+        start_time: float,
+        end_time: float,
+        storage_key: str,
+        thumbnail_key: str,
+        width: int,
+        height: int,
+        file_size_bytes: int,
+        transcript: str,
+        topics: list[str],
+        content_score: float,
+        expires_at: str,
+        platform: str = "",
+        channel_name: str = "",
+        text_embedding: bytes = None,
+        visual_embedding: bytes = None,
+        model_version: str = "",
+    ) -> str:
+        resp = self._call(self._stub.CreateClip, self._pb.CreateClipRequest(
+            id=clip_id,
+            source_id=source_id,
+            title=title,
+            duration_seconds=duration_seconds,
+            start_time=start_time,
+            end_time=end_time,
+            storage_key=storage_key,
+            thumbnail_key=thumbnail_key,
+            width=width,
+            height=height,
+            file_size_bytes=file_size_bytes,
+            transcript=transcript,
+            topics=topics or [],
+            content_score=content_score,
+            expires_at=expires_at,
+            platform=platform,
+            channel_name=channel_name,
+            text_embedding=text_embedding or b"",
+            visual_embedding=visual_embedding or b"",
+            model_version=model_version,
+        ))
+        return resp.id or clip_id
+
+    # --- Topic operations ---
+
+    def resolve_topic(self, name: str) -> str:
+        resp = self._call(self._stub.ResolveTopic, self._pb.ResolveTopicRequest(name=name))
+        return resp.id
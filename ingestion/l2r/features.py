@@ -26,6 +26,8 @@ FEATURE_NAMES = [
     "user_like_rate",
     "user_save_rate",
     "hours_since_last_session",
+    "clip_avg_rating",
+    "clip_rating_count",
 ]
 
 
@@ -61,8 +63,9 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
     clips, clip_topics, clip_embeddings, and user data. For each (user_id, clip_id)
     interaction, extracts the feature vector and assigns a label.
 
-    Labels: 1.0 (like/save/watch_full), 0.0 (skip/dislike), 0.5 (view with
-    watch_percentage < 0.3). Groups are by user_id for LambdaRank.
+    Labels: 1.0 (like/save/watch_full, or a rating of 4-5 stars), 0.0
+    (skip/dislike, or 1-2 stars), 0.5 (view with watch_percentage < 0.3, or
+    3 stars). Groups are by user_id for LambdaRank.
 
     Returns:
         features_array: (n_samples, n_features) float array
@@ -85,16 +88,18 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
 
         # Check for optional tables
         cursor = conn.execute(
-            "SELECT name FROM sqlite_master WHERE type='table' AND name IN ('clip_topics', 'user_topic_affinities', 'sources')"
+            "SELECT name FROM sqlite_master WHERE type='table' AND name IN ('clip_topics', 'user_topic_affinities', 'sources', 'clip_ratings')"
         )
         optional_tables = {r[0] for r in cursor.fetchall()}
         has_clip_topics = "clip_topics" in optional_tables
         has_user_affinities = "user_topic_affinities" in optional_tables
         has_sources = "sources" in optional_tables
+        has_ratings = "clip_ratings" in optional_tables
+        rating_column = "i.rating" if has_ratings else "NULL"
 
         # Base query: interactions with clip data
         if has_sources:
-            rows = conn.execute("""
+            rows = conn.execute(f"""
                 SELECT
                     i.id AS interaction_id,
                     i.user_id,
@@ -102,10 +107,11 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
                     i.action,
                     i.watch_percentage,
                     i.watch_duration_seconds,
+                    {rating_column} AS rating,
                     i.created_at AS interaction_created_at,
                     c.content_score,
                     c.duration_seconds,
-                    c.transcript,
+                    c.transcript_length,
                     c.file_size_bytes,
                     c.created_at AS clip_created_at,
                     c.source_id
@@ -115,7 +121,7 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
                 ORDER BY i.user_id, i.created_at
             """).fetchall()
         else:
-            rows = conn.execute("""
+            rows = conn.execute(f"""
                 SELECT
                     i.id AS interaction_id,
                     i.user_id,
@@ -123,10 +129,11 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
                     i.action,
                     i.watch_percentage,
                     i.watch_duration_seconds,
+                    {rating_column} AS rating,
                     i.created_at AS interaction_created_at,
                     c.content_score,
                     c.duration_seconds,
-                    c.transcript,
+                    c.transcript_length,
                     c.file_size_bytes,
                     c.created_at AS clip_created_at,
                     c.source_id
@@ -165,6 +172,18 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
             """).fetchall():
                 source_channel[r[0]] = r[1] or ""
 
+        # Ratings per clip, to average those other users gave before each
+        # interaction.
+        clip_ratings: dict[str, list[tuple[str, int, datetime | None]]] = defaultdict(list)
+        if has_ratings:
+            for r in conn.execute("""
+                SELECT clip_id, user_id, rating, updated_at FROM clip_ratings
+            """).fetchall():
+                rated_at = _parse_ts(r[3])
+                if rated_at and rated_at.tzinfo is None:
+                    rated_at = rated_at.replace(tzinfo=timezone.utc)
+                clip_ratings[r[0]].append((r[1], int(r[2]), rated_at))
+
         # User past stats (point-in-time): for each interaction, compute from
         # interactions before this one for the same user.
         # Also channel_affinity: past views from same channel.
@@ -198,14 +217,16 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
                 label = 0.0
             elif action == "view":
                 label = 0.5 if watch_pct < 0.3 else 1.0
+            elif action == "rate" and row["rating"] is not None:
+                rating = int(row["rating"])
+                label = 1.0 if rating >= 4 else 0.0 if rating <= 2 else 0.5
             else:
                 label = 0.5
 
             # Content features
             content_score = float(row["content_score"] or 0.5)
             duration_seconds = float(row["duration_seconds"] or 0.0)
-            transcript = row["transcript"] or ""
-            transcript_length = len(transcript)
+            transcript_length = int(row["transcript_length"] or 0)
             file_size_bytes = int(row["file_size_bytes"] or 0)
             source_id = row["source_id"]
             channel_key = source_channel.get(source_id, "")
@@ -241,6 +262,16 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
 
             channel_affinity = user_channel_views.get((user_id, channel_key), 0)
 
+            rated_before = interaction_ts
+            if rated_before and rated_before.tzinfo is None:
+                rated_before = rated_before.replace(tzinfo=timezone.utc)
+            past_ratings = [
+                rating for rater, rating, rated_at in clip_ratings.get(clip_id, [])
+                if rater != user_id and rated_at and rated_before and rated_at <= rated_before
+            ]
+            clip_rating_count = len(past_ratings)
+            clip_avg_rating = sum(past_ratings) / clip_rating_count if past_ratings else 0.0
+
             last_ts = user_last_ts.get(user_id)
             if last_ts and interaction_ts:
                 if interaction_ts.tzinfo is None:
@@ -263,6 +294,8 @@ def extract_features(db_path: str) -> tuple[np.ndarray, np.ndarray, np.ndarray]:
                 user_like_rate,
                 user_save_rate,
                 hours_since,
+                clip_avg_rating,
+                float(clip_rating_count),
             ]
 
             samples.append((features, label))
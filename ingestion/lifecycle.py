@@ -21,6 +21,37 @@ MINIO_SSL = os.getenv("MINIO_USE_SSL", "false") == "true"
 STORAGE_LIMIT_GB = float(os.getenv("STORAGE_LIMIT_GB", "50"))
 
 
+def bury_clip(db, clip_id, reason):
+    """Record a tombstone so requests for the clip get 410 Gone."""
+    db.execute(
+        """
+        INSERT INTO clip_tombstones (clip_id, title, topics, reason)
+        SELECT id, COALESCE(title, ''), COALESCE(topics, '[]'), ?
+        FROM clips WHERE id = ?
+        ON CONFLICT (clip_id) DO UPDATE SET reason = excluded.reason
+        """,
+        (reason, clip_id),
+    )
+
+
+def remove_clip_objects(db, minio_client, clip):
+    """Delete a clip's video, thumbnail, candidate thumbnails, data saver
+    renditions, large TV thumbnail, and storyboard sprites."""
+    if clip["storage_key"]:
+        minio_client.remove_object(MINIO_BUCKET, clip["storage_key"])
+    keys = {clip["thumbnail_key"]} if clip["thumbnail_key"] else set()
+    keys.update(row[0] for row in db.execute(
+        "SELECT thumbnail_key FROM clip_thumbnails WHERE clip_id = ?"
+        " UNION ALL SELECT sprite_key FROM clip_storyboard_sprites WHERE clip_id = ?"
+        " UNION ALL SELECT low_storage_key FROM clips WHERE id = ? AND low_storage_key IS NOT NULL"
+        " UNION ALL SELECT small_thumbnail_key FROM clips WHERE id = ? AND small_thumbnail_key IS NOT NULL"
+        " UNION ALL SELECT large_thumbnail_key FROM clips WHERE id = ? AND large_thumbnail_key IS NOT NULL",
+        (clip["id"], clip["id"], clip["id"], clip["id"], clip["id"]),
+    ))
+    for key in sorted(keys):
+        minio_client.remove_object(MINIO_BUCKET, key)
+
+
 def main():
     db = sqlite3.connect(DB_PATH)
     try:
@@ -50,12 +81,10 @@ def main():
             try:
                 # Mark as expired in DB first, so a crash won't leave a "ready" clip with no storage
                 db.execute("UPDATE clips SET status = 'expired' WHERE id = ?", (clip["id"],))
+                bury_clip(db, clip["id"], "expired")
                 db.commit()
 
-                if clip["storage_key"]:
-                    minio_client.remove_object(MINIO_BUCKET, clip["storage_key"])
-                if clip["thumbnail_key"]:
-                    minio_client.remove_object(MINIO_BUCKET, clip["thumbnail_key"])
+                remove_clip_objects(db, minio_client, clip)
 
                 deleted_count += 1
                 freed_bytes += clip["file_size_bytes"] or 0
@@ -88,12 +117,10 @@ def main():
                 try:
                     # Mark as evicted in DB first to avoid orphaned "ready" clips
                     db.execute("UPDATE clips SET status = 'evicted' WHERE id = ?", (clip["id"],))
+                    bury_clip(db, clip["id"], "evicted")
                     db.commit()
 
-                    if clip["storage_key"]:
-                        minio_client.remove_object(MINIO_BUCKET, clip["storage_key"])
-                    if clip["thumbnail_key"]:
-                        minio_client.remove_object(MINIO_BUCKET, clip["thumbnail_key"])
+                    remove_clip_objects(db, minio_client, clip)
 
                     overage_bytes -= clip["file_size_bytes"] or 0
                     evicted += 1
@@ -7,9 +7,10 @@ import json
 import logging
 import os
 import re
+import threading
 import time
 
-from litellm import completion
+from litellm import completion, embedding
 import requests
 
 logger = logging.getLogger("llm_client")
@@ -21,6 +22,17 @@ LLM_BASE_URL = os.getenv("LLM_BASE_URL", "").rstrip("/")
 LLM_MODEL = os.getenv("LLM_MODEL", "").strip() or OLLAMA_MODEL
 LLM_API_KEY = os.getenv("LLM_API_KEY", "").strip()
 ANTHROPIC_VERSION = os.getenv("ANTHROPIC_VERSION", "2023-06-01").strip() or "2023-06-01"
+# Ollama's all-minilm is the all-MiniLM-L6-v2 model the ingestion worker
+# embeds clips with, so its vectors compare directly with clip_embeddings.
+LLM_EMBED_MODEL = os.getenv("LLM_EMBED_MODEL", "").strip() or (
+    "all-minilm" if LLM_PROVIDER == "ollama" else ""
+)
+# Scout scores candidates with LLM_SCORING_MODEL, defaulting to LLM_MODEL.
+LLM_SCORING_MODEL = os.getenv("LLM_SCORING_MODEL", "").strip() or LLM_MODEL
+# Ollama endpoints to spread requests over, comma-separated, each optionally
+# followed by "=" and the "+"-separated tasks it serves (summary, scoring,
+# embedding). Defaults to the single LLM_BASE_URL or LLM_URL.
+LLM_ENDPOINTS = os.getenv("LLM_ENDPOINTS", "").strip()
 
 # LiteLLM reads provider-specific env vars (GEMINI_API_KEY, ANTHROPIC_API_KEY,
 # OPENAI_API_KEY) rather than the generic api_key kwarg for auth validation.
@@ -49,6 +61,17 @@ AVAILABILITY_TIMEOUT = 3
 GENERATE_TIMEOUT = 30
 PULL_TIMEOUT = int(os.getenv("LLM_PULL_TIMEOUT", "900"))
 
+# Endpoint pool: endpoints are re-checked at most every HEALTH_INTERVAL
+# seconds; CIRCUIT_FAILURES failures in a row skip an endpoint for
+# CIRCUIT_COOLDOWN seconds, doubling up to CIRCUIT_MAX_COOLDOWN while its
+# trial requests keep failing.
+HEALTH_INTERVAL = 30
+CIRCUIT_FAILURES = 3
+CIRCUIT_COOLDOWN = 30
+CIRCUIT_MAX_COOLDOWN = 600
+
+TASKS = ("summary", "scoring", "embedding")
+
 # Log configuration at import time
 logger.info(
     "[LLM] Config loaded: ai_enabled=%s provider=%s model=%s base_url=%s",
@@ -75,6 +98,175 @@ def _base_url() -> str:
     return (LLM_BASE_URL or "https://api.openai.com/v1").rstrip("/")
 
 
+def _normalize_model(name: str) -> str:
+    """Drop Ollama's implicit ":latest" tag so "all-minilm" matches "all-minilm:latest"."""
+    name = (name or "").strip()
+    return name[: -len(":latest")] if name.endswith(":latest") else name
+
+
+def parse_endpoints(spec: str) -> list[tuple[str, tuple[str, ...]]]:
+    """Parse LLM_ENDPOINTS into (url, tasks) pairs; empty tasks means all.
+    Raises ValueError on a malformed entry.
+    """
+    endpoints = []
+    for item in spec.split(","):
+        item = item.strip()
+        if not item:
+            continue
+        url, _, task_list = item.partition("=")
+        url = url.strip().rstrip("/")
+        if not url.startswith(("http://", "https://")):
+            raise ValueError(f"endpoint {item!r}: url must start with http:// or https://")
+        tasks = tuple(t.strip() for t in task_list.split("+") if t.strip())
+        for task in tasks:
+            if task not in TASKS:
+                raise ValueError(f"endpoint {item!r}: unknown task {task!r} (want summary, scoring, or embedding)")
+        endpoints.append((url, tasks))
+    if not endpoints:
+        raise ValueError("no endpoints given")
+    return endpoints
+
+
+class NoEndpointError(Exception):
+    """No endpoint in the pool can take a task's request."""
+
+
+class _Endpoint:
+    def __init__(self, url: str, tasks: tuple[str, ...]):
+        self.url = url
+        self.tasks = tasks
+        self.healthy = None  # unknown until the first check
+        self.models: set[str] = set()
+        self.checked_at = 0.0
+        self.failures = 0
+        self.cooldown = 0.0
+        self.open_until = 0.0
+        self.trial = False
+        self.in_flight = 0
+
+    def serves(self, task: str) -> bool:
+        return not self.tasks or task in self.tasks
+
+    def has_model(self, model: str) -> bool:
+        return self.healthy is None or not model or _normalize_model(model) in self.models
+
+
+class EndpointPool:
+    """Spreads Ollama requests over several endpoints.
+
+    Endpoints are health checked lazily with /api/tags, which also lists
+    the models each has pulled. A request for a task goes to the least busy
+    healthy endpoint that serves the task and has its model, and fails over
+    to the next one. An endpoint that fails CIRCUIT_FAILURES times in a row
+    is skipped for a cooldown, then trusted with a single trial request.
+    """
+
+    def __init__(self, endpoints: list[tuple[str, tuple[str, ...]]], clock=time.monotonic):
+        self.endpoints = [_Endpoint(url, tasks) for url, tasks in endpoints]
+        self._clock = clock
+        self._lock = threading.Lock()
+        self._next = 0
+
+    def check(self, force: bool = False) -> None:
+        """Health check endpoints not checked in the last HEALTH_INTERVAL seconds."""
+        now = self._clock()
+        for ep in self.endpoints:
+            if not force and ep.healthy is not None and now - ep.checked_at < HEALTH_INTERVAL:
+                continue
+            try:
+                r = requests.get(f"{ep.url}/api/tags", timeout=AVAILABILITY_TIMEOUT)
+                r.raise_for_status()
+                data = r.json()
+                models = data.get("models", []) if isinstance(data, dict) else []
+                names = {_normalize_model(str(m.get("name") or "")) for m in models if isinstance(m, dict)}
+                with self._lock:
+                    ep.healthy, ep.models = True, names
+            except (requests.RequestException, ValueError) as e:
+                if ep.healthy:
+                    logger.warning("[LLM] Endpoint %s unhealthy: %s", ep.url, e)
+                with self._lock:
+                    ep.healthy = False
+            ep.checked_at = now
+
+    def _acquire(self, task: str, model: str, tried: set) -> tuple[_Endpoint | None, bool]:
+        now = self._clock()
+        with self._lock:
+            start = self._next
+            self._next += 1
+            best = None
+            for i in range(len(self.endpoints)):
+                ep = self.endpoints[(start + i) % len(self.endpoints)]
+                if ep in tried or not ep.serves(task) or ep.healthy is False or not ep.has_model(model):
+                    continue
+                if ep.open_until and (now < ep.open_until or ep.trial):
+                    continue
+                if best is None or ep.in_flight < best.in_flight:
+                    best = ep
+            if best is None:
+                return None, False
+            trial = bool(best.open_until)
+            best.trial = best.trial or trial
+            best.in_flight += 1
+            return best, trial
+
+    def _release(self, ep: _Endpoint, trial: bool, error: Exception | None) -> None:
+        with self._lock:
+            ep.in_flight -= 1
+            if trial:
+                ep.trial = False
+            if error is None:
+                ep.failures, ep.cooldown, ep.open_until = 0, 0.0, 0.0
+                return
+            ep.failures += 1
+            if trial or ep.failures >= CIRCUIT_FAILURES:
+                if not ep.cooldown:
+                    ep.cooldown = CIRCUIT_COOLDOWN
+                elif trial:
+                    ep.cooldown = min(2 * ep.cooldown, CIRCUIT_MAX_COOLDOWN)
+                ep.open_until = self._clock() + ep.cooldown
+                logger.warning("[LLM] Endpoint %s circuit open for %.0fs after %d failures",
+                               ep.url, ep.cooldown, ep.failures)
+
+    def run(self, task: str, model: str, fn):
+        """Call fn(base_url) on endpoints that can take the task until one
+        succeeds, and return its result. Raises the last error, or
+        NoEndpointError when no endpoint could be tried.
+        """
+        self.check()
+        tried = set()
+        last_error = None
+        while True:
+            ep, trial = self._acquire(task, model, tried)
+            if ep is None:
+                raise last_error or NoEndpointError(f"no LLM endpoint available for {task}")
+            tried.add(ep)
+            try:
+                result = fn(ep.url)
+            except Exception as e:
+                self._release(ep, trial, e)
+                logger.warning("[LLM] Endpoint %s failed %s request: %s", ep.url, task, e)
+                last_error = e
+                continue
+            self._release(ep, trial, None)
+            return result
+
+    def serving(self, task: str) -> list[_Endpoint]:
+        """Healthy endpoints that serve the task."""
+        self.check()
+        return [ep for ep in self.endpoints if ep.serves(task) and ep.healthy]
+
+
+_pool = None
+
+
+def _get_pool() -> EndpointPool:
+    """The Ollama endpoint pool, built from LLM_ENDPOINTS on first use."""
+    global _pool
+    if _pool is None:
+        _pool = EndpointPool(parse_endpoints(LLM_ENDPOINTS or _base_url()))
+    return _pool
+
+
 def _anthropic_headers() -> dict:
     key = (LLM_API_KEY or "").strip()
     if not key:
@@ -210,12 +402,19 @@ def is_available() -> bool:
         logger.info("[LLM] Provider %s: skipping HTTP check (managed endpoint), key present", provider)
         return True
 
+    if provider == "ollama":
+        pool = _get_pool()
+        pool.check(force=True)
+        healthy = [ep.url for ep in pool.endpoints if ep.healthy]
+        if not healthy:
+            logger.warning("[LLM] Provider unavailable: no Ollama endpoint answered (%s)",
+                           ", ".join(ep.url for ep in pool.endpoints))
+            return False
+        logger.info("[LLM] Provider available: provider=ollama endpoints=%s", ", ".join(healthy))
+        return True
+
     try:
-        if provider == "ollama":
-            url = f"{base}/api/tags"
-            logger.debug("[LLM] GET %s (timeout=%ds)", url, AVAILABILITY_TIMEOUT)
-            r = requests.get(url, timeout=AVAILABILITY_TIMEOUT)
-        elif provider == "anthropic":
+        if provider == "anthropic":
             headers = _anthropic_headers()
             if not headers.get("x-api-key"):
                 logger.warning("[LLM] API key missing for provider=%s -- cannot check availability", provider)
@@ -245,8 +444,9 @@ def is_available() -> bool:
         return False
 
 
-def model_exists(model: str | None = None) -> bool:
-    """Check if the configured model is available for the active provider."""
+def model_exists(model: str | None = None, task: str = "summary") -> bool:
+    """Check if the model is available for the active provider; for Ollama,
+    on some healthy endpoint that serves the task."""
     provider = _provider()
     model = _model(model)
     if not model:
@@ -257,34 +457,16 @@ def model_exists(model: str | None = None) -> bool:
             return False
         return True
 
-    try:
-        r = requests.get(
-            f"{_base_url()}/api/tags",
-            timeout=AVAILABILITY_TIMEOUT,
-        )
-        r.raise_for_status()
-        data = r.json()
-        models = data.get("models", []) if isinstance(data, dict) else []
-        for item in models:
-            if not isinstance(item, dict):
-                continue
-            name = str(item.get("name") or "").strip()
-            if name == model:
-                return True
-        return False
-    except requests.RequestException as e:
-        logger.warning("LLM model check failed: %s", e)
-        return False
-    except (json.JSONDecodeError, KeyError, TypeError) as e:
-        logger.warning("LLM /api/tags parse error: %s", e)
-        return False
+    wanted = _normalize_model(model)
+    return any(wanted in ep.models for ep in _get_pool().serving(task))
 
 
-def ensure_model(model: str | None = None, auto_pull: bool = True) -> bool:
-    """Ensure model exists; Ollama can auto-pull, API providers validate key/model."""
+def ensure_model(model: str | None = None, auto_pull: bool = True, task: str = "summary") -> bool:
+    """Ensure model exists; Ollama endpoints serving the task can auto-pull
+    it, API providers validate key/model."""
     provider = _provider()
     model = _model(model)
-    logger.info("[LLM] Ensuring model: provider=%s model=%s auto_pull=%s", provider, model, auto_pull)
+    logger.info("[LLM] Ensuring model: provider=%s model=%s task=%s auto_pull=%s", provider, model, task, auto_pull)
     if not model:
         logger.warning("[LLM] No model configured -- cannot proceed")
         return False
@@ -296,32 +478,35 @@ def ensure_model(model: str | None = None, auto_pull: bool = True) -> bool:
         logger.info("[LLM] API-based provider=%s with model=%s -- assuming available", provider, model)
         return True
 
-    if model_exists(model):
-        logger.info("[LLM] Ollama model '%s' already present", model)
+    pool = _get_pool()
+    endpoints = pool.serving(task)
+    missing = [ep for ep in endpoints if _normalize_model(model) not in ep.models]
+    if endpoints and not missing:
+        logger.info("[LLM] Ollama model '%s' already present on %d endpoint(s)", model, len(endpoints))
         return True
 
     if not auto_pull:
+        if len(missing) < len(endpoints):
+            return True
         logger.warning("[LLM] Ollama model '%s' not found and auto_pull disabled", model)
         return False
 
-    logger.info("[LLM] Ollama model '%s' not found -- pulling now (timeout=%ds)...", model, PULL_TIMEOUT)
-    start = time.time()
-    try:
-        pull_url = f"{_base_url()}/api/pull"
-        logger.info("[LLM] POST %s body={name: %s}", pull_url, model)
-        r = requests.post(
-            pull_url,
-            json={"name": model, "stream": False},
-            timeout=PULL_TIMEOUT,
-        )
-        r.raise_for_status()
-        elapsed = time.time() - start
-        logger.info("[LLM] Ollama model '%s' pull complete in %.1fs", model, elapsed)
-        return model_exists(model)
-    except requests.RequestException as e:
-        elapsed = time.time() - start
-        logger.error("[LLM] Ollama model pull FAILED for '%s' after %.1fs: %s", model, elapsed, e)
-        return False
+    for ep in missing:
+        logger.info("[LLM] Ollama model '%s' not found on %s -- pulling now (timeout=%ds)...", model, ep.url, PULL_TIMEOUT)
+        start = time.time()
+        try:
+            r = requests.post(
+                f"{ep.url}/api/pull",
+                json={"name": model, "stream": False},
+                timeout=PULL_TIMEOUT,
+            )
+            r.raise_for_status()
+            logger.info("[LLM] Ollama model '%s' pull on %s complete in %.1fs", model, ep.url, time.time() - start)
+        except requests.RequestException as e:
+            logger.error("[LLM] Ollama model pull FAILED for '%s' on %s after %.1fs: %s",
+                         model, ep.url, time.time() - start, e)
+    pool.check(force=True)
+    return model_exists(model, task)
 
 
 _api_client = None
@@ -336,7 +521,6 @@ def set_api_client(client):
     global _api_client
     _api_client = client
 
-import threading
 _tl = threading.local()
 
 def _log_to_db(provider: str, model: str, prompt: str, response: str, error: str, duration_ms: int):
@@ -366,12 +550,22 @@ def _log_to_db(provider: str, model: str, prompt: str, response: str, error: str
     except Exception as e:
         logger.warning("Failed to log LLM call to SQLite: %s", e)
 
+def _with_endpoint(task: str, model: str, params: dict, call):
+    """Run call(params), over the endpoint pool for Ollama with each
+    endpoint's URL as api_base in turn."""
+    if _provider() != "ollama":
+        return call(params)
+    return _get_pool().run(task, model, lambda base: call({**params, "api_base": base}))
+
+
 def generate(
     prompt: str,
     model: str | None = None,
     max_tokens: int = 256,
+    task: str = "summary",
 ) -> str:
-    """Generate text using configured provider. Returns empty string on failure."""
+    """Generate text using configured provider, routing Ollama requests by
+    task. Returns empty string on failure."""
     model = _model(model)
     provider = _provider()
     prompt_preview = (prompt[:120] + "...") if len(prompt) > 120 else prompt
@@ -389,11 +583,11 @@ def generate(
         params = _litellm_params(model, max_tokens)
         logger.debug("[LLM] LiteLLM params: model=%s api_base=%s", params.get("model"), params.get("api_base"))
 
-        response = completion(
+        response = _with_endpoint(task, model, params, lambda p: completion(
             messages=[{"role": "user", "content": prompt}],
             timeout=GENERATE_TIMEOUT,
-            **params,
-        )
+            **p,
+        ))
         elapsed = time.time() - start
 
         result = _extract_completion_text(response)
@@ -411,6 +605,39 @@ def generate(
         return ""
 
 
+def embed(text: str, model: str | None = None) -> list[float] | None:
+    """Embed text with the configured embedding model (LLM_EMBED_MODEL).
+    Returns None when no embedding model is configured or the request fails.
+    """
+    model = (model or LLM_EMBED_MODEL).strip()
+    if not model or not _ai_enabled() or not text.strip():
+        return None
+
+    params = {"model": _litellm_model(model)}
+    if LLM_API_KEY:
+        params["api_key"] = LLM_API_KEY
+    base = _base_url()
+    if base:
+        params["api_base"] = base
+
+    try:
+        response = _with_endpoint("embedding", model, params, lambda p: embedding(
+            input=[text[:2000]], timeout=GENERATE_TIMEOUT, **p,
+        ))
+        data = getattr(response, "data", None)
+        if data is None and isinstance(response, dict):
+            data = response.get("data")
+        first = data[0] if data else None
+        vector = first.get("embedding") if isinstance(first, dict) else getattr(first, "embedding", None)
+        if not vector:
+            logger.warning("[LLM] Embedding response had no vector: model=%s", model)
+            return None
+        return [float(x) for x in vector]
+    except Exception as e:
+        logger.warning("[LLM] Embedding FAILED: provider=%s model=%s error=%s", _provider(), model, e)
+        return None
+
+
 def _build_metadata_context(video_metadata: dict | None) -> str:
     """Build a compact context string from video metadata for LLM prompts."""
     if not video_metadata:
@@ -588,7 +815,7 @@ def evaluate_candidate(
             "Reply with just the number."
         )
 
-    result = generate(prompt)
+    result = generate(prompt, model=LLM_SCORING_MODEL, task="scoring")
     if not result:
         logger.warning("[LLM] Candidate evaluation returned empty for title=%r", title[:80] if title else "")
         return None
@@ -657,7 +884,7 @@ def generate_search_queries(
         "Reply with only the JSON array."
     )
 
-    result = generate(prompt, max_tokens=512)
+    result = generate(prompt, model=LLM_SCORING_MODEL, max_tokens=512, task="scoring")
     if not result:
         logger.warning("[LLM] Search query generation returned empty for %r -- using fallbacks", identifier)
         return fallbacks[:count]
@@ -34,41 +34,77 @@ def open_db():
     return db
 
 
+# Each (user, clip, action) counts once, with repeated views averaged, so a
+# single user cannot dominate a clip's score. Users an admin confirmed as
+# automated are excluded. Mirrors scoredInteractions in api/scoring.
+SCORED_INTERACTIONS = """(
+    SELECT user_id, clip_id, action, AVG(watch_percentage) AS watch_percentage
+    FROM interactions
+    WHERE user_id NOT IN (SELECT user_id FROM interaction_flags WHERE status = 'confirmed')
+    GROUP BY user_id, clip_id, action
+) interactions"""
+
+
+# Used when the scoring_weights table is empty; matches DefaultWeights in
+# api/scoring.
+DEFAULT_WEIGHTS = {
+    "watch": 0.30, "like": 0.25, "save": 0.20, "watch_full": 0.15,
+    "rating": 0.10, "skip": 0.30, "dislike": 0.15,
+}
+
+
+def load_scoring_weights(db):
+    """Return the latest weights configured via /api/admin/scoring/weights."""
+    row = db.execute("""
+        SELECT watch_weight, like_weight, save_weight, watch_full_weight, rating_weight, skip_penalty, dislike_penalty
+        FROM scoring_weights ORDER BY version DESC LIMIT 1
+    """).fetchone()
+    if row is None:
+        return dict(DEFAULT_WEIGHTS)
+    return dict(zip(("watch", "like", "save", "watch_full", "rating", "skip", "dislike"), tuple(row)))
+
+
 def update_content_scores(db):
     """Update clip content_score from aggregate interaction signals."""
+    weights = load_scoring_weights(db)
     db.execute("BEGIN IMMEDIATE")
-    db.execute("""
+    db.execute(f"""
         UPDATE clips
         SET content_score = (
             SELECT MAX(0.0, MIN(1.0,
-                COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * 0.35
+                COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * :watch
                 + COALESCE(
                     CAST(SUM(CASE WHEN action='like'       THEN 1.0 ELSE 0 END) AS REAL)
-                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.25
+                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :like
                 + COALESCE(
                     CAST(SUM(CASE WHEN action='save'       THEN 1.0 ELSE 0 END) AS REAL)
-                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.20
+                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :save
                 + COALESCE(
                     CAST(SUM(CASE WHEN action='watch_full' THEN 1.0 ELSE 0 END) AS REAL)
-                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.15
+                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :watch_full
+                + COALESCE((
+                    SELECT (AVG(rating) - 1) / 4.0 FROM clip_ratings
+                    WHERE clip_ratings.clip_id = clips.id
+                      AND clip_ratings.user_id NOT IN (SELECT user_id FROM interaction_flags WHERE status = 'confirmed')
+                  ), 0.5) * :rating
                 - COALESCE(
                     CAST(SUM(CASE WHEN action='skip'       THEN 1.0 ELSE 0 END) AS REAL)
-                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.30
+                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :skip
                 - COALESCE(
                     CAST(SUM(CASE WHEN action='dislike'    THEN 1.0 ELSE 0 END) AS REAL)
-                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.15
+                    / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :dislike
             ))
-            FROM interactions
+            FROM {SCORED_INTERACTIONS}
             WHERE interactions.clip_id = clips.id
             HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5
         )
         WHERE status = 'ready'
           AND id IN (
-            SELECT clip_id FROM interactions
+            SELECT clip_id FROM {SCORED_INTERACTIONS}
             GROUP BY clip_id
             HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5
           )
-    """)
+    """, weights)
     count = db.execute("SELECT changes()").fetchone()[0]
     db.execute("COMMIT")
     log.info(f"Updated scores for {count} clips")
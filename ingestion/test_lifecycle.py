@@ -41,9 +41,13 @@ CREATE TABLE clips (
     id TEXT PRIMARY KEY,
     source_id TEXT REFERENCES sources(id),
     title TEXT,
+    topics TEXT DEFAULT '[]',
     duration_seconds REAL NOT NULL,
     storage_key TEXT NOT NULL,
     thumbnail_key TEXT,
+    low_storage_key TEXT,
+    small_thumbnail_key TEXT,
+    large_thumbnail_key TEXT,
     file_size_bytes INTEGER,
     is_protected INTEGER DEFAULT 0,
     status TEXT DEFAULT 'processing',
@@ -51,6 +55,31 @@ CREATE TABLE clips (
     created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
 );
 
+CREATE TABLE clip_tombstones (
+    clip_id TEXT PRIMARY KEY,
+    title TEXT NOT NULL DEFAULT '',
+    topics TEXT NOT NULL DEFAULT '[]',
+    reason TEXT NOT NULL,
+    replaced_by TEXT,
+    deleted_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE TABLE clip_thumbnails (
+    clip_id TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    variant INTEGER NOT NULL,
+    thumbnail_key TEXT NOT NULL,
+    impressions INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (clip_id, variant)
+);
+
+CREATE TABLE clip_storyboard_sprites (
+    clip_id TEXT NOT NULL REFERENCES clips(id) ON DELETE CASCADE,
+    sprite_index INTEGER NOT NULL,
+    sprite_key TEXT NOT NULL,
+    PRIMARY KEY (clip_id, sprite_index)
+);
+
 CREATE TABLE saved_clips (
     user_id TEXT NOT NULL REFERENCES users(id),
     clip_id TEXT NOT NULL REFERENCES clips(id),
@@ -125,6 +154,12 @@ class LifecycleTestBase(unittest.TestCase):
         db.close()
         return row[0] if row else None
 
+    def get_tombstone_reason(self, clip_id):
+        db = self._db()
+        row = db.execute("SELECT reason FROM clip_tombstones WHERE clip_id = ?", (clip_id,)).fetchone()
+        db.close()
+        return row[0] if row else None
+
 
 class TestLifecycleExpiredClips(LifecycleTestBase):
     """Phase 1: Delete expired, unprotected clips."""
@@ -136,8 +171,39 @@ class TestLifecycleExpiredClips(LifecycleTestBase):
         self.run_lifecycle()
 
         self.assertEqual(self.get_status("c1"), "expired")
+        self.assertEqual(self.get_tombstone_reason("c1"), "expired")
         self.mock_minio.remove_object.assert_called()
 
+    def test_candidate_thumbnails_and_sprites_removed_with_clip(self):
+        past = (datetime.utcnow() - timedelta(days=1)).strftime("%Y-%m-%dT%H:%M:%SZ")
+        self.insert_clip("c4", storage_key="clips/c4/clip.mp4", thumbnail_key="clips/c4/thumbnail.jpg",
+                         expires_at=past)
+        db = self._db()
+        db.executemany(
+            "INSERT INTO clip_thumbnails (clip_id, variant, thumbnail_key) VALUES ('c4', ?, ?)",
+            [(0, "clips/c4/thumbnail.jpg"), (1, "clips/c4/thumbnail_1.jpg"), (2, "clips/c4/thumbnail_2.jpg")],
+        )
+        db.execute(
+            "INSERT INTO clip_storyboard_sprites (clip_id, sprite_index, sprite_key)"
+            " VALUES ('c4', 0, 'clips/c4/storyboard_0.jpg')"
+        )
+        db.execute(
+            "UPDATE clips SET low_storage_key = 'clips/c4/low.mp4',"
+            " small_thumbnail_key = 'clips/c4/thumbnail_small.jpg',"
+            " large_thumbnail_key = 'clips/c4/thumbnail_large.jpg' WHERE id = 'c4'"
+        )
+        db.commit()
+        db.close()
+
+        self.run_lifecycle()
+
+        removed = sorted(c.args[1] for c in self.mock_minio.remove_object.call_args_list)
+        self.assertEqual(removed, [
+            "clips/c4/clip.mp4", "clips/c4/low.mp4", "clips/c4/storyboard_0.jpg", "clips/c4/thumbnail.jpg",
+            "clips/c4/thumbnail_1.jpg", "clips/c4/thumbnail_2.jpg", "clips/c4/thumbnail_large.jpg",
+            "clips/c4/thumbnail_small.jpg",
+        ])
+
     def test_protected_clips_not_deleted(self):
         past = (datetime.utcnow() - timedelta(days=1)).strftime("%Y-%m-%dT%H:%M:%SZ")
         self.insert_clip("c2", expires_at=past, is_protected=1)
@@ -168,6 +234,7 @@ class TestLifecycleStorageEviction(LifecycleTestBase):
         self.run_lifecycle(storage_limit_gb=0.0001)
 
         self.assertEqual(self.get_status("old"), "evicted")
+        self.assertEqual(self.get_tombstone_reason("old"), "evicted")
 
     def test_no_eviction_under_limit(self):
         future = (datetime.utcnow() + timedelta(days=30)).strftime("%Y-%m-%dT%H:%M:%SZ")
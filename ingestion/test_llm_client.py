@@ -0,0 +1,125 @@
+"""Unit tests for the LLM client's Ollama endpoint pool."""
+
+import sys
+import types
+import unittest
+from unittest.mock import MagicMock, patch
+
+# Stub litellm and requests so the module loads without them installed.
+sys.modules.setdefault("litellm", MagicMock())
+if "requests" not in sys.modules:
+    _requests = types.ModuleType("requests")
+
+    class RequestException(Exception):
+        pass
+
+    _requests.RequestException = RequestException
+    _requests.get = MagicMock()
+    _requests.post = MagicMock()
+    sys.modules["requests"] = _requests
+
+import llm_client
+
+
+def _tags(models):
+    resp = MagicMock()
+    resp.json.return_value = {"models": [{"name": m} for m in models]}
+    return resp
+
+
+class FakeClock:
+    def __init__(self):
+        self.now = 1000.0
+
+    def __call__(self):
+        return self.now
+
+
+class TestParseEndpoints(unittest.TestCase):
+    def test_urls_and_tasks(self):
+        endpoints = llm_client.parse_endpoints(" http://gpu:11434/=summary+scoring , http://cpu:11434 ")
+        self.assertEqual(endpoints, [
+            ("http://gpu:11434", ("summary", "scoring")),
+            ("http://cpu:11434", ()),
+        ])
+
+    def test_rejects_malformed(self):
+        for spec in ("", "gpu:11434", "http://gpu:11434=chat"):
+            with self.assertRaises(ValueError):
+                llm_client.parse_endpoints(spec)
+
+
+class TestEndpointPool(unittest.TestCase):
+    def setUp(self):
+        self.clock = FakeClock()
+        self.tags = {
+            "http://gpu": ["llama3.2:3b"],
+            "http://cpu": ["all-minilm:latest"],
+        }
+
+        def get(url, timeout=None):
+            base = url.rsplit("/api/tags", 1)[0]
+            if base not in self.tags:
+                raise llm_client.requests.RequestException("connection refused")
+            return _tags(self.tags[base])
+
+        patcher = patch.object(llm_client.requests, "get", side_effect=get)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+
+    def pool(self, spec):
+        return llm_client.EndpointPool(llm_client.parse_endpoints(spec), clock=self.clock)
+
+    def test_routes_by_task_and_model(self):
+        pool = self.pool("http://gpu,http://cpu=embedding,http://down")
+        self.assertEqual(pool.run("summary", "llama3.2:3b", lambda base: base), "http://gpu")
+        self.assertEqual(pool.run("embedding", "all-minilm", lambda base: base), "http://cpu")
+        self.assertFalse(pool.endpoints[2].healthy)
+        with self.assertRaises(llm_client.NoEndpointError):
+            pool.run("scoring", "mistral", lambda base: base)
+
+    def test_fails_over_and_breaks_circuit(self):
+        self.tags["http://flaky"] = ["m"]
+        pool = self.pool("http://flaky,http://gpu")
+        self.tags["http://gpu"] = ["m"]
+        pool.check(force=True)
+        calls = []
+
+        def call(base):
+            calls.append(base)
+            if base == "http://flaky":
+                raise RuntimeError("500")
+            return "ok"
+
+        for _ in range(8):
+            self.assertEqual(pool.run("summary", "m", call), "ok")
+        self.assertEqual(calls.count("http://flaky"), llm_client.CIRCUIT_FAILURES)
+
+        # After the cooldown a single trial goes through; failing doubles it.
+        self.clock.now += llm_client.CIRCUIT_COOLDOWN
+        calls.clear()
+        for _ in range(3):
+            pool.run("summary", "m", call)
+        self.assertEqual(calls.count("http://flaky"), 1)
+        self.assertEqual(pool.endpoints[0].cooldown, 2 * llm_client.CIRCUIT_COOLDOWN)
+
+        # A successful trial closes the circuit.
+        self.clock.now += 2 * llm_client.CIRCUIT_COOLDOWN
+        calls.clear()
+        for _ in range(2):
+            pool.run("summary", "m", lambda base: calls.append(base) or "ok")
+        self.assertIn("http://flaky", calls)
+        self.assertEqual(pool.endpoints[0].open_until, 0.0)
+
+    def test_raises_last_error_when_all_fail(self):
+        pool = self.pool("http://gpu")
+
+        def call(base):
+            raise RuntimeError("boom")
+
+        with self.assertRaisesRegex(RuntimeError, "boom"):
+            pool.run("summary", "", call)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -49,42 +49,74 @@ CREATE TABLE interactions (
     watch_percentage REAL,
     created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
 );
+
+CREATE TABLE clip_ratings (
+    user_id TEXT NOT NULL REFERENCES users(id),
+    clip_id TEXT NOT NULL REFERENCES clips(id),
+    rating INTEGER NOT NULL,
+    PRIMARY KEY (user_id, clip_id)
+);
+
+CREATE TABLE interaction_flags (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open'
+);
 """
 
-SCORE_UPDATE_SQL = """
+SCORED_INTERACTIONS = """(
+    SELECT user_id, clip_id, action, AVG(watch_percentage) AS watch_percentage
+    FROM interactions
+    WHERE user_id NOT IN (SELECT user_id FROM interaction_flags WHERE status = 'confirmed')
+    GROUP BY user_id, clip_id, action
+) interactions"""
+
+SCORE_UPDATE_SQL = f"""
 UPDATE clips
 SET content_score = (
     SELECT MAX(0.0, MIN(1.0,
-        COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * 0.35
+        COALESCE(AVG(CASE WHEN action='view' THEN watch_percentage END), 0.5) * :watch
         + COALESCE(
             CAST(SUM(CASE WHEN action='like'       THEN 1.0 ELSE 0 END) AS REAL)
-            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.25
+            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :like
         + COALESCE(
             CAST(SUM(CASE WHEN action='save'       THEN 1.0 ELSE 0 END) AS REAL)
-            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.20
+            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :save
         + COALESCE(
             CAST(SUM(CASE WHEN action='watch_full' THEN 1.0 ELSE 0 END) AS REAL)
-            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.15
+            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :watch_full
+        + COALESCE((
+            SELECT (AVG(rating) - 1) / 4.0 FROM clip_ratings
+            WHERE clip_ratings.clip_id = clips.id
+              AND clip_ratings.user_id NOT IN (SELECT user_id FROM interaction_flags WHERE status = 'confirmed')
+          ), 0.5) * :rating
         - COALESCE(
             CAST(SUM(CASE WHEN action='skip'       THEN 1.0 ELSE 0 END) AS REAL)
-            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.30
+            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :skip
         - COALESCE(
             CAST(SUM(CASE WHEN action='dislike'    THEN 1.0 ELSE 0 END) AS REAL)
-            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * 0.15
+            / NULLIF(SUM(CASE WHEN action='view'   THEN 1   ELSE 0 END), 0), 0) * :dislike
     ))
-    FROM interactions
+    FROM {SCORED_INTERACTIONS}
     WHERE interactions.clip_id = clips.id
     HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5
 )
 WHERE status = 'ready'
   AND id IN (
-    SELECT clip_id FROM interactions
+    SELECT clip_id FROM {SCORED_INTERACTIONS}
     GROUP BY clip_id
     HAVING SUM(CASE WHEN action='view' THEN 1 ELSE 0 END) >= 5
   )
 """
 
 
+DEFAULT_WEIGHTS = {
+    "watch": 0.30, "like": 0.25, "save": 0.20, "watch_full": 0.15,
+    "rating": 0.10, "skip": 0.30, "dislike": 0.15,
+}
+
+
 def make_db():
     db = sqlite3.connect(":memory:", isolation_level=None)
     db.execute("PRAGMA journal_mode=WAL")
@@ -100,6 +132,13 @@ def seed_user(db, user_id="u1"):
     )
 
 
+def seed_users(db, n):
+    user_ids = [f"u{i}" for i in range(n)]
+    for user_id in user_ids:
+        seed_user(db, user_id)
+    return user_ids
+
+
 def seed_clip(db, clip_id="clip1", score=0.5):
     db.execute(
         "INSERT OR IGNORE INTO sources (id, url, platform) VALUES (?, 'http://x.com', 'direct')",
@@ -120,9 +159,9 @@ def add_interaction(db, clip_id, user_id, action, watch_pct=None, interaction_id
     )
 
 
-def run_score_update(db):
+def run_score_update(db, weights=None):
     db.execute("BEGIN IMMEDIATE")
-    db.execute(SCORE_UPDATE_SQL)
+    db.execute(SCORE_UPDATE_SQL, weights or DEFAULT_WEIGHTS)
     count = db.execute("SELECT changes()").fetchone()[0]
     db.execute("COMMIT")
     return count
@@ -135,12 +174,12 @@ def get_score(db, clip_id):
 class TestScoreUpdater(unittest.TestCase):
 
     def test_needs_minimum_5_views(self):
-        """Clips with fewer than 5 views are not updated."""
+        """Clips viewed by fewer than 5 users are not updated."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 4)
         seed_clip(db, "c1", score=0.5)
-        for i in range(4):
-            add_interaction(db, "c1", "u1", "view", watch_pct=1.0, interaction_id=f"v{i}")
+        for u in users:
+            add_interaction(db, "c1", u, "view", watch_pct=1.0, interaction_id=f"v-{u}")
 
         count = run_score_update(db)
         self.assertEqual(count, 0)
@@ -149,66 +188,66 @@ class TestScoreUpdater(unittest.TestCase):
     def test_all_positive_engagement(self):
         """High engagement: 100% watch, all likes, saves, watch_full → high score."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 5)
         seed_clip(db, "c2", score=0.5)
 
-        for i in range(5):
-            add_interaction(db, "c2", "u1", "view", watch_pct=1.0, interaction_id=f"v{i}")
-            add_interaction(db, "c2", "u1", "like", interaction_id=f"l{i}")
-            add_interaction(db, "c2", "u1", "save", interaction_id=f"s{i}")
-            add_interaction(db, "c2", "u1", "watch_full", interaction_id=f"w{i}")
+        for u in users:
+            add_interaction(db, "c2", u, "view", watch_pct=1.0, interaction_id=f"v-{u}")
+            add_interaction(db, "c2", u, "like", interaction_id=f"l-{u}")
+            add_interaction(db, "c2", u, "save", interaction_id=f"s-{u}")
+            add_interaction(db, "c2", u, "watch_full", interaction_id=f"w-{u}")
 
         run_score_update(db)
         score = get_score(db, "c2")
-        # 0.35*1.0 + 0.25*1.0 + 0.20*1.0 + 0.15*1.0 = 0.95
+        # 0.30*1.0 + 0.25*1.0 + 0.20*1.0 + 0.15*1.0 + 0.10*0.5 (unrated) = 0.95
         self.assertAlmostEqual(score, 0.95, places=2)
 
     def test_all_negative_engagement(self):
         """Low engagement: 0% watch, all skips and dislikes → score clamped to 0."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 5)
         seed_clip(db, "c3", score=0.5)
 
-        for i in range(5):
-            add_interaction(db, "c3", "u1", "view", watch_pct=0.0, interaction_id=f"v{i}")
-            add_interaction(db, "c3", "u1", "skip", interaction_id=f"sk{i}")
-            add_interaction(db, "c3", "u1", "dislike", interaction_id=f"d{i}")
+        for u in users:
+            add_interaction(db, "c3", u, "view", watch_pct=0.0, interaction_id=f"v-{u}")
+            add_interaction(db, "c3", u, "skip", interaction_id=f"sk-{u}")
+            add_interaction(db, "c3", u, "dislike", interaction_id=f"d-{u}")
 
         run_score_update(db)
         score = get_score(db, "c3")
-        # 0.35*0.0 + 0 + 0 + 0 - 0.30*1.0 - 0.15*1.0 = -0.45, clamped to 0
+        # 0.30*0.0 + 0 + 0 + 0 + 0.10*0.5 - 0.30*1.0 - 0.15*1.0 = -0.40, clamped to 0
         self.assertAlmostEqual(score, 0.0, places=2)
 
     def test_mixed_engagement(self):
         """Mixed signals: partial watch, some likes, some skips."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 5)
         seed_clip(db, "c4", score=0.5)
 
-        for i in range(5):
-            add_interaction(db, "c4", "u1", "view", watch_pct=0.6, interaction_id=f"v{i}")
+        for u in users:
+            add_interaction(db, "c4", u, "view", watch_pct=0.6, interaction_id=f"v-{u}")
 
         # 2 out of 5 liked, 1 skipped
-        add_interaction(db, "c4", "u1", "like", interaction_id="l0")
+        add_interaction(db, "c4", "u0", "like", interaction_id="l0")
         add_interaction(db, "c4", "u1", "like", interaction_id="l1")
-        add_interaction(db, "c4", "u1", "skip", interaction_id="sk0")
+        add_interaction(db, "c4", "u2", "skip", interaction_id="sk0")
 
         run_score_update(db)
         score = get_score(db, "c4")
-        # 0.35*0.6 + 0.25*(2/5) + 0.20*0 + 0.15*0 - 0.30*(1/5) - 0.15*0
-        # = 0.21 + 0.10 + 0 + 0 - 0.06 - 0 = 0.25
-        self.assertAlmostEqual(score, 0.25, places=2)
+        # 0.30*0.6 + 0.25*(2/5) + 0.20*0 + 0.15*0 + 0.10*0.5 - 0.30*(1/5) - 0.15*0
+        # = 0.18 + 0.10 + 0 + 0 + 0.05 - 0.06 - 0 = 0.27
+        self.assertAlmostEqual(score, 0.27, places=2)
 
     def test_only_ready_clips_updated(self):
         """Clips not in 'ready' status are skipped."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 5)
         seed_clip(db, "c5", score=0.5)
         db.execute("UPDATE clips SET status = 'processing' WHERE id = 'c5'")
 
-        for i in range(5):
-            add_interaction(db, "c5", "u1", "view", watch_pct=1.0, interaction_id=f"v{i}")
-            add_interaction(db, "c5", "u1", "like", interaction_id=f"l{i}")
+        for u in users:
+            add_interaction(db, "c5", u, "view", watch_pct=1.0, interaction_id=f"v-{u}")
+            add_interaction(db, "c5", u, "like", interaction_id=f"l-{u}")
 
         count = run_score_update(db)
         self.assertEqual(count, 0)
@@ -217,14 +256,14 @@ class TestScoreUpdater(unittest.TestCase):
     def test_score_clamped_to_one(self):
         """Score cannot exceed 1.0."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 10)
         seed_clip(db, "c6", score=0.5)
 
-        for i in range(10):
-            add_interaction(db, "c6", "u1", "view", watch_pct=1.0, interaction_id=f"v{i}")
-            add_interaction(db, "c6", "u1", "like", interaction_id=f"l{i}")
-            add_interaction(db, "c6", "u1", "save", interaction_id=f"s{i}")
-            add_interaction(db, "c6", "u1", "watch_full", interaction_id=f"w{i}")
+        for u in users:
+            add_interaction(db, "c6", u, "view", watch_pct=1.0, interaction_id=f"v-{u}")
+            add_interaction(db, "c6", u, "like", interaction_id=f"l-{u}")
+            add_interaction(db, "c6", u, "save", interaction_id=f"s-{u}")
+            add_interaction(db, "c6", u, "watch_full", interaction_id=f"w-{u}")
 
         run_score_update(db)
         score = get_score(db, "c6")
@@ -233,21 +272,92 @@ class TestScoreUpdater(unittest.TestCase):
     def test_multiple_clips_updated_independently(self):
         """Each clip's score is calculated from its own interactions."""
         db = make_db()
-        seed_user(db)
+        users = seed_users(db, 5)
         seed_clip(db, "good")
         seed_clip(db, "bad")
 
-        for i in range(5):
-            add_interaction(db, "good", "u1", "view", watch_pct=1.0, interaction_id=f"gv{i}")
-            add_interaction(db, "good", "u1", "like", interaction_id=f"gl{i}")
-            add_interaction(db, "bad", "u1", "view", watch_pct=0.0, interaction_id=f"bv{i}")
-            add_interaction(db, "bad", "u1", "skip", interaction_id=f"bs{i}")
+        for u in users:
+            add_interaction(db, "good", u, "view", watch_pct=1.0, interaction_id=f"gv-{u}")
+            add_interaction(db, "good", u, "like", interaction_id=f"gl-{u}")
+            add_interaction(db, "bad", u, "view", watch_pct=0.0, interaction_id=f"bv-{u}")
+            add_interaction(db, "bad", u, "skip", interaction_id=f"bs-{u}")
 
         run_score_update(db)
         good_score = get_score(db, "good")
         bad_score = get_score(db, "bad")
         self.assertGreater(good_score, bad_score)
 
+    def test_single_user_counts_once_per_action(self):
+        """Repeated interactions from one user neither reach the view minimum nor stack."""
+        db = make_db()
+        seed_user(db)
+        seed_clip(db, "solo", score=0.5)
+        for i in range(20):
+            add_interaction(db, "solo", "u1", "view", watch_pct=1.0, interaction_id=f"v{i}")
+            add_interaction(db, "solo", "u1", "like", interaction_id=f"l{i}")
+
+        self.assertEqual(run_score_update(db), 0)
+        self.assertAlmostEqual(get_score(db, "solo"), 0.5)
+
+        users = seed_users(db, 5)
+        seed_clip(db, "c7", score=0.5)
+        for u in users:
+            add_interaction(db, "c7", u, "view", watch_pct=0.2, interaction_id=f"c7v-{u}")
+        for i in range(50):
+            add_interaction(db, "c7", "u0", "like", interaction_id=f"c7l{i}")
+
+        run_score_update(db)
+        # 0.30*0.2 + 0.25*(1/5) + 0.10*0.5 = 0.16
+        self.assertAlmostEqual(get_score(db, "c7"), 0.16, places=2)
+
+    def test_confirmed_flagged_users_excluded(self):
+        """Interactions from users with a confirmed anomaly flag are ignored."""
+        db = make_db()
+        users = seed_users(db, 6)
+        seed_clip(db, "c8", score=0.5)
+        for u in users[:5]:
+            add_interaction(db, "c8", u, "view", watch_pct=0.4, interaction_id=f"v-{u}")
+        add_interaction(db, "c8", "u5", "view", watch_pct=1.0, interaction_id="v-bot")
+        add_interaction(db, "c8", "u5", "like", interaction_id="l-bot")
+        db.execute(
+            "INSERT INTO interaction_flags (id, user_id, reason, status) VALUES ('f1', 'u5', 'burst_rate', 'confirmed')"
+        )
+
+        run_score_update(db)
+        # 0.30*0.4 + 0.10*0.5, with the flagged user's view and like left out
+        self.assertAlmostEqual(get_score(db, "c8"), 0.17, places=2)
+
+    def test_custom_weights(self):
+        """Configured weights replace the defaults."""
+        db = make_db()
+        users = seed_users(db, 5)
+        seed_clip(db, "c9", score=0.5)
+        for u in users:
+            add_interaction(db, "c9", u, "view", watch_pct=0.5, interaction_id=f"v-{u}")
+            add_interaction(db, "c9", u, "like", interaction_id=f"l-{u}")
+
+        weights = dict(DEFAULT_WEIGHTS, watch=0.2, like=0.8, rating=0.0)
+        run_score_update(db, weights)
+        # 0.2*0.5 + 0.8*1.0 = 0.9
+        self.assertAlmostEqual(get_score(db, "c9"), 0.9, places=2)
+
+    def test_star_ratings(self):
+        """The mean rating, scaled to [0, 1], replaces the neutral 0.5."""
+        db = make_db()
+        users = seed_users(db, 6)
+        seed_clip(db, "c10", score=0.5)
+        for u in users[:5]:
+            add_interaction(db, "c10", u, "view", watch_pct=0.5, interaction_id=f"v-{u}")
+        for u, rating in zip(users, (5, 5, 4, 4, 2, 1)):
+            db.execute("INSERT INTO clip_ratings (user_id, clip_id, rating) VALUES (?, 'c10', ?)", (u, rating))
+        db.execute(
+            "INSERT INTO interaction_flags (id, user_id, reason, status) VALUES ('f1', 'u5', 'burst_rate', 'confirmed')"
+        )
+
+        run_score_update(db)
+        # 0.30*0.5 + 0.10*((4 - 1) / 4), with the flagged user's 1 star left out
+        self.assertAlmostEqual(get_score(db, "c10"), 0.225, places=3)
+
 
 if __name__ == "__main__":
     unittest.main()
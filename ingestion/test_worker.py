@@ -2,7 +2,7 @@
 
 import sys
 import unittest
-from unittest.mock import patch, MagicMock
+from unittest.mock import call, patch, MagicMock
 
 # Mock heavy third-party dependencies before importing worker so the module
 # loads without needing minio, faster_whisper, or keybert installed.
@@ -23,6 +23,13 @@ class WorkerStub:
     _fixed_split = worker.Worker._fixed_split
     _generate_clip_title = worker.Worker._generate_clip_title
     detect_scenes = worker.Worker.detect_scenes
+    silence_ratio = worker.Worker.silence_ratio
+    _upload_data_saver_renditions = worker.Worker._upload_data_saver_renditions
+    _upload_large_thumbnail = worker.Worker._upload_large_thumbnail
+    plan_segments = worker.Worker.plan_segments
+    _chapter_segments = worker.Worker._chapter_segments
+    _pick_highlights = worker.Worker._pick_highlights
+    _loudness_profile = worker.Worker._loudness_profile
 
 
 def make_stub():
@@ -223,6 +230,153 @@ class TestDetectScenes(unittest.TestCase):
         self.assertTrue(len(segments) >= 1)
 
 
+class TestSilenceRatio(unittest.TestCase):
+    def setUp(self):
+        self.w = make_stub()
+
+    @patch("worker.subprocess.run")
+    def test_sums_silences_including_trailing(self, mock_run):
+        from pathlib import Path
+        stderr = (
+            "[silencedetect @ 0x1234] silence_start: 0\n"
+            "[silencedetect @ 0x1234] silence_end: 30 | silence_duration: 30\n"
+            "[silencedetect @ 0x1234] silence_start: 80\n"
+        )
+        mock_run.return_value = MagicMock(returncode=0, stderr=stderr)
+        self.assertAlmostEqual(self.w.silence_ratio(Path("/fake/video.mp4"), 100.0), 0.5)
+
+    @patch("worker.subprocess.run")
+    def test_unmeasurable_returns_none(self, mock_run):
+        from pathlib import Path
+        mock_run.return_value = MagicMock(returncode=1, stderr="Stream specifier ':a' matches no streams\n")
+        self.assertIsNone(self.w.silence_ratio(Path("/fake/video.mp4"), 100.0))
+        self.assertIsNone(self.w.silence_ratio(Path("/fake/video.mp4"), 0))
+
+
+class TestDataSaverRenditions(unittest.TestCase):
+    def setUp(self):
+        self.w = make_stub()
+        self.w.minio = MagicMock()
+
+    @patch("worker.subprocess.run")
+    def test_uploads_what_ffmpeg_produced(self, mock_run):
+        import tempfile
+        from pathlib import Path
+        work = Path(tempfile.mkdtemp())
+        thumb = work / "thumb_0000.jpg"
+        thumb.write_bytes(b"jpg")
+
+        def ffmpeg(cmd, **kwargs):
+            # Only the small thumbnail comes out; the low rendition fails.
+            out = Path(cmd[-1])
+            if out.suffix == ".jpg":
+                out.write_bytes(b"small")
+                return MagicMock(returncode=0, stderr="")
+            return MagicMock(returncode=1, stderr="encoder missing")
+        mock_run.side_effect = ffmpeg
+
+        keys = self.w._upload_data_saver_renditions("c1", work / "clip_0000.mp4", thumb, work, 0)
+        self.assertEqual(keys, {"small_thumbnail_key": "clips/c1/thumbnail_small.jpg"})
+        self.w.minio.fput_object.assert_called_once()
+
+    @patch("worker.subprocess.run")
+    def test_large_thumbnail_only_when_made(self, mock_run):
+        import tempfile
+        from pathlib import Path
+        work = Path(tempfile.mkdtemp())
+        mock_run.return_value = MagicMock(returncode=1, stderr="")
+
+        self.assertEqual(self.w._upload_large_thumbnail("c1", work / "clip_0000.mp4", work, 0), {})
+        self.w.minio.fput_object.assert_not_called()
+
+        mock_run.side_effect = lambda cmd, **kwargs: Path(cmd[-1]).write_bytes(b"large")
+        keys = self.w._upload_large_thumbnail("c1", work / "clip_0000.mp4", work, 0)
+        self.assertEqual(keys, {"large_thumbnail_key": "clips/c1/thumbnail_large.jpg"})
+        self.assertIn("thumbnail,scale='min(1280,iw)':-2", mock_run.call_args.args[0])
+
+
+# ---------------------------------------------------------------------------
+# plan_segments – clip extraction strategies
+# ---------------------------------------------------------------------------
+
+class TestPlanSegments(unittest.TestCase):
+    def setUp(self):
+        self.w = make_stub()
+        from pathlib import Path
+        self.path = Path("/fake/video.mp4")
+
+    def test_full_keeps_whole_source(self):
+        self.assertEqual(self.w.plan_segments("full", self.path, 600.0), [{"start": 0, "end": 600.0}])
+
+    def test_repair_segments_replace_planning(self):
+        repair = [{"start": 30, "end": 60}, {"start": 590, "end": 620}, {"start": 700, "end": 720}]
+        segments = self.w.plan_segments("full", self.path, 600.0, repair_segments=repair)
+        # Segments past the end are clamped, and dropped when nothing is left.
+        self.assertEqual(segments, [{"start": 30.0, "end": 60.0}, {"start": 590.0, "end": 600.0}])
+
+    def test_fixed_interval(self):
+        segments = self.w.plan_segments("fixed-interval", self.path, 100.0)
+        self.assertEqual(segments, self.w._fixed_split(100.0))
+        # Sources shorter than the minimum still yield one clip.
+        self.assertEqual(self.w.plan_segments("fixed-interval", self.path, 10.0), [{"start": 0, "end": 10.0}])
+
+    def test_chapters_split_long_and_drop_short(self):
+        chapters = [
+            {"start_time": 0, "end_time": 5, "title": "Intro"},
+            {"start_time": 5, "end_time": 65, "title": "Setup"},
+            {"start_time": 65, "end_time": 200, "title": "Main event"},
+        ]
+        segments = self.w.plan_segments("chapter-based", self.path, 200.0, {"chapters": chapters})
+        self.assertEqual(segments[0], {"start": 5.0, "end": 65.0, "chapter": "Setup"})
+        rest = segments[1:]
+        self.assertTrue(all(seg["chapter"] == "Main event" for seg in rest))
+        self.assertEqual(rest[0]["start"], 65.0)
+        self.assertEqual(rest[-1]["end"], 200.0)
+        self.assertTrue(all(seg["end"] - seg["start"] <= worker.MAX_CLIP_SECONDS for seg in rest))
+
+    @patch("worker.subprocess.run")
+    def test_chapters_fall_back_to_auto_highlight(self, mock_run):
+        mock_run.return_value = MagicMock(returncode=0, stderr="")
+        segments = self.w.plan_segments("chapter-based", self.path, 30.0, {"chapters": []})
+        self.assertEqual(segments, [{"start": 0, "end": 30.0}])
+
+    @patch("worker.HIGHLIGHT_MAX_CLIPS", 2)
+    @patch("worker.subprocess.run")
+    def test_auto_highlight_keeps_loudest_in_order(self, mock_run):
+        segments = [{"start": 0, "end": 45}, {"start": 45, "end": 90}, {"start": 90, "end": 135}]
+        stderr = "\n".join([
+            "[Parsed_ebur128_0 @ 0x1] t: 10.0   TARGET:-23 LUFS    M: -40.0 S: -40.0",
+            "[Parsed_ebur128_0 @ 0x1] t: 50.0   TARGET:-23 LUFS    M: -18.0 S: -20.0",
+            "[Parsed_ebur128_0 @ 0x1] t: 100.0  TARGET:-23 LUFS    M: -25.0 S: -25.0",
+        ])
+        mock_run.return_value = MagicMock(returncode=0, stderr=stderr)
+        picked = self.w._pick_highlights(self.path, segments)
+        self.assertEqual(picked, [{"start": 45, "end": 90}, {"start": 90, "end": 135}])
+
+    @patch("worker.HIGHLIGHT_MAX_CLIPS", 2)
+    @patch("worker.subprocess.run")
+    def test_auto_highlight_keeps_all_without_loudness(self, mock_run):
+        segments = [{"start": 0, "end": 45}, {"start": 45, "end": 90}, {"start": 90, "end": 135}]
+        mock_run.side_effect = Exception("ffmpeg crashed")
+        self.assertEqual(self.w._pick_highlights(self.path, segments), segments)
+
+
+class TestLicensingFields(unittest.TestCase):
+    def test_reports_uploader_and_license(self):
+        fields = worker.licensing_fields({
+            "uploader": "Chef", "uploader_url": "https://youtube.com/@chef",
+            "license": "Creative Commons Attribution license (reuse allowed)",
+        })
+        self.assertEqual(fields, {
+            "uploader": "Chef", "uploader_url": "https://youtube.com/@chef",
+            "license": "Creative Commons Attribution license (reuse allowed)",
+        })
+
+    def test_falls_back_to_channel_and_omits_missing(self):
+        fields = worker.licensing_fields({"channel": "Chef", "channel_url": "https://x/c", "license": None})
+        self.assertEqual(fields, {"uploader": "Chef", "uploader_url": "https://x/c"})
+
+
 # ---------------------------------------------------------------------------
 # Module-level constants sanity check
 # ---------------------------------------------------------------------------
@@ -320,8 +474,165 @@ class TestPopJob(unittest.TestCase):
         }
         row = w._pop_job()
         self.assertEqual(row["id"], "j1")
+        self.assertEqual(row["job_type"], "download")
         self.assertIn("source_id", row["payload"])
 
+    def test_claims_previews_too(self):
+        w = _make_api_worker()
+        w.api.claim_job.return_value = {"id": "j2", "job_type": "preview", "payload": {"clip_id": "c1"}}
+        row = w._pop_job()
+        self.assertEqual(row["job_type"], "preview")
+        w.api.claim_job.assert_called_once_with(job_types=["download", "preview", "transcode_hls"])
+
+
+class TestTracedJob(unittest.TestCase):
+    """Jobs pass their payload's traceparent on to the API calls they make."""
+
+    def test_sets_and_clears_traceparent(self):
+        w = _make_api_worker()
+        tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+        seen = []
+        w._run_traced(lambda job_id, payload: seen.append(job_id), "j1", {"traceparent": tp})
+        self.assertEqual(seen, ["j1"])
+        self.assertEqual(w.api.set_traceparent.call_args_list, [call(tp), call(None)])
+
+    def test_client_sends_traceparent_header(self):
+        with patch.dict(sys.modules, {"requests": MagicMock()}):
+            from api_client import WorkerAPIClient
+            client = WorkerAPIClient("http://api", "secret")
+        session = MagicMock()
+        client._local.session = session
+
+        client.set_traceparent("00-abc-def-01")
+        client._get("/jobs/j1")
+        self.assertEqual(session.get.call_args.kwargs["headers"], {"traceparent": "00-abc-def-01"})
+        client.set_traceparent(None)
+        client._post("/jobs/j1/heartbeat")
+        self.assertEqual(session.post.call_args.kwargs["headers"], {})
+
+
+class TestRegisterRun(unittest.TestCase):
+    """Workers register each run so the API recovers their previous run's jobs."""
+
+    def test_delegates_and_tolerates_failure(self):
+        w = _make_api_worker()
+        w.api.register_run.return_value = (3, 0)
+        w._register_run()
+        w.api.register_run.assert_called_once_with()
+
+        w.api.register_run.side_effect = RuntimeError("connection refused")
+        w._register_run()  # does not raise
+
+    def test_client_registers_run(self):
+        with patch.dict(sys.modules, {"requests": MagicMock()}):
+            from api_client import WorkerAPIClient
+            client = WorkerAPIClient("http://api", "secret", worker_id="w1", run_id="r1")
+        session = MagicMock()
+        client._local.session = session
+
+        session.post.return_value = MagicMock(status_code=200, json=lambda: {"requeued": 2, "failed": 1})
+        self.assertEqual(client.register_run(), (2, 1))
+        self.assertEqual(session.post.call_args.args[0], "http://api/api/internal/workers/register")
+        session.post.return_value = MagicMock(status_code=404)
+        self.assertEqual(client.register_run(), (0, 0))
+
+
+class TestPreviewJob(unittest.TestCase):
+    """process_preview_job renders the range, uploads it, and registers it."""
+
+    PAYLOAD = {
+        "preview_id": "p1", "clip_id": "c1", "storage_key": "clips/c1/clip.mp4",
+        "start": 2.0, "end": 5.5, "format": "gif", "output_key": "clips/c1/previews/p1.gif",
+    }
+
+    def _worker(self):
+        w = _make_api_worker()
+        w.minio = MagicMock()
+        return w
+
+    @patch("worker.subprocess.run")
+    def test_uploads_and_completes(self, mock_run):
+        from pathlib import Path
+
+        def ffmpeg(cmd, **kwargs):
+            if cmd[0] == "ffmpeg":
+                Path(cmd[-1]).write_bytes(b"GIF89a")
+            return MagicMock(returncode=0, stderr="")
+        mock_run.side_effect = ffmpeg
+
+        w = self._worker()
+        w.process_preview_job("j1", self.PAYLOAD)
+
+        cmd = next(c.args[0] for c in mock_run.call_args_list if c.args[0][0] == "ffmpeg")
+        self.assertEqual(cmd[cmd.index("-ss") + 1], "2.000")
+        self.assertEqual(cmd[cmd.index("-t") + 1], "3.500")
+        w.minio.fput_object.assert_called_once()
+        self.assertEqual(w.minio.fput_object.call_args.kwargs["content_type"], "image/gif")
+        w.api.set_preview.assert_called_once_with("c1", "p1", "clips/c1/previews/p1.gif", 6)
+        w.api.update_job.assert_called_once_with("j1", "complete", result={"storage_key": "clips/c1/previews/p1.gif"})
+
+    @patch("worker.subprocess.run")
+    def test_render_failure_fails_job(self, mock_run):
+        mock_run.return_value = MagicMock(returncode=1, stderr="bad input")
+        w = self._worker()
+        w.process_preview_job("j1", self.PAYLOAD)
+        w.minio.fput_object.assert_not_called()
+        w.api.set_preview.assert_not_called()
+        status = w.api.update_job.call_args.args[1]
+        self.assertEqual(status, "failed")
+
+
+class TestHLSJob(unittest.TestCase):
+    """process_hls_job transcodes the rungs the clip is tall enough for,
+    uploads every segment and playlist, and registers them."""
+
+    PAYLOAD = {"clip_id": "c1", "storage_key": "clips/c1/clip.mp4", "output_prefix": "clips/c1/hls"}
+
+    def _worker(self, height):
+        w = _make_api_worker()
+        w.minio = MagicMock()
+        w.extract_metadata = MagicMock(return_value={"width": height * 16 // 9, "height": height})
+        return w
+
+    @patch("worker.subprocess.run")
+    def test_uploads_ladder_and_registers(self, mock_run):
+        from pathlib import Path
+
+        def ffmpeg(cmd, **kwargs):
+            if cmd[0] == "ffmpeg":
+                out = Path(cmd[-1])
+                for i in range(2):
+                    (out.parent / f"segment_{i:03d}.ts").write_bytes(b"ts")
+                out.write_text("#EXTM3U\n#EXTINF:4.0,\nsegment_000.ts\n#EXTINF:1.0,\nsegment_001.ts\n#EXT-X-ENDLIST\n")
+            return MagicMock(returncode=0, stderr="")
+        mock_run.side_effect = ffmpeg
+
+        w = self._worker(400)
+        with patch.object(worker, "HLS_LADDER", [240, 360, 480, 720]):
+            w.process_hls_job("j1", self.PAYLOAD)
+
+        master_key, variants = w.api.set_hls.call_args.args[1:]
+        self.assertEqual(master_key, "clips/c1/hls/master.m3u8")
+        self.assertEqual([v["name"] for v in variants], ["240p", "360p"])
+        self.assertEqual(variants[1]["width"], 640)
+        self.assertEqual(variants[1]["playlist_key"], "clips/c1/hls/360p/index.m3u8")
+        self.assertEqual(variants[1]["segment_keys"],
+                         ["clips/c1/hls/360p/segment_000.ts", "clips/c1/hls/360p/segment_001.ts"])
+        self.assertLess(variants[0]["bandwidth"], variants[1]["bandwidth"])
+        # Two segments and a playlist per rung, and the master playlist.
+        self.assertEqual(w.minio.fput_object.call_count, 7)
+        w.api.update_job.assert_called_once_with(
+            "j1", "complete", result={"master_key": master_key, "variants": ["240p", "360p"]})
+
+    @patch("worker.subprocess.run")
+    def test_transcode_failure_fails_job(self, mock_run):
+        mock_run.return_value = MagicMock(returncode=1, stderr="bad input")
+        w = self._worker(1080)
+        w.process_hls_job("j1", self.PAYLOAD)
+        w.minio.fput_object.assert_not_called()
+        w.api.set_hls.assert_not_called()
+        self.assertEqual(w.api.update_job.call_args.args[1], "failed")
+
 
 class TestReclaimStaleRunningJobs(unittest.TestCase):
     """_reclaim_stale_running_jobs delegates to API client."""
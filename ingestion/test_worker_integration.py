@@ -197,6 +197,38 @@ class TestProcessJobRetry(unittest.TestCase):
         self.assertIn("Too short", call_args[1]["error"])
 
         w.api.update_source.assert_any_call("s1", status="rejected")
+        self.assertEqual(call_args[1]["result"], {"rejection": {"code": "other", "message": "Too short"}})
+
+    def test_too_long_rejection_reports_code_and_metrics(self):
+        w = _make_worker()
+        w.api.get_cookie.return_value = None
+
+        with patch.object(w, "fetch_source_metadata", return_value={"duration": worker.MAX_VIDEO_DURATION + 1}):
+            w.process_job("j1", {"source_id": "s1", "url": "http://youtube.com/watch?v=abc", "platform": "youtube"})
+
+        call_args = w.api.update_job.call_args
+        self.assertEqual(call_args[0][1], "rejected")
+        rejection = call_args[1]["result"]["rejection"]
+        self.assertEqual(rejection["code"], "too_long")
+        self.assertEqual(rejection["metrics"]["duration_seconds"], worker.MAX_VIDEO_DURATION + 1)
+
+    def test_duplicate_of_clipped_source_is_rejected(self):
+        w = _make_worker()
+        w.api.get_cookie.return_value = None
+
+        def update_source(source_id, **fields):
+            # Stands in for api_client.DuplicateSourceError.
+            if "external_id" in fields:
+                err = Exception("duplicate source")
+                err.duplicate_of_clip = "c0"
+                raise err
+        w.api.update_source.side_effect = update_source
+
+        with patch.object(w, "fetch_source_metadata", return_value={"id": "abc", "duration": 60}):
+            w.process_job("j1", {"source_id": "s1", "url": "http://youtube.com/watch?v=abc", "platform": "youtube"})
+
+        rejection = w.api.update_job.call_args[1]["result"]["rejection"]
+        self.assertEqual((rejection["code"], rejection["duplicate_of"]), ("duplicate", "c0"))
 
     def test_max_attempts_exhausted(self):
         """At max attempts, a transient error should permanently fail the job."""
@@ -10,6 +10,7 @@ import json
 import time
 import uuid
 import signal
+import socket
 import logging
 import subprocess
 import hashlib
@@ -53,6 +54,12 @@ WORK_DIR = Path(os.getenv("WORK_DIR", "/tmp/clipfeed"))
 
 WORKER_API_URL = os.getenv("WORKER_API_URL", "http://api:8080")
 WORKER_SECRET = os.getenv("WORKER_SECRET", "")
+WORKER_GRPC_ADDR = os.getenv("WORKER_GRPC_ADDR", "")
+# Names this worker in the API's ingest analytics.
+WORKER_ID = os.getenv("WORKER_ID", "") or socket.gethostname()
+# New on every start, so the API can tell this run's claims from those a
+# previous run of this worker left behind.
+WORKER_RUN_ID = uuid.uuid4().hex
 
 # Clip splitting parameters
 MIN_CLIP_SECONDS = int(os.getenv("MIN_CLIP_SECONDS", "15"))
@@ -61,8 +68,35 @@ TARGET_CLIP_SECONDS = int(os.getenv("TARGET_CLIP_SECONDS", "45"))
 MAX_VIDEO_DURATION = int(os.getenv("MAX_VIDEO_DURATION", "3600"))
 MAX_DOWNLOAD_SIZE_MB = int(os.getenv("MAX_DOWNLOAD_SIZE_MB", "2048"))
 PROCESSING_MODE = os.getenv("PROCESSING_MODE", "transcode")
+# Candidate thumbnails per clip, including the default one; 1 disables
+# thumbnail selection. The API accepts at most 5.
+THUMBNAIL_CANDIDATES = max(1, min(5, int(os.getenv("THUMBNAIL_CANDIDATES", "3"))))
+# Also make a low-bitrate rendition and a small thumbnail of every clip for
+# clients in data saver mode.
+DATA_SAVER_RENDITIONS = os.getenv("DATA_SAVER_RENDITIONS", "true") == "true"
+# Also make a large thumbnail of every clip for the TV feed.
+TV_THUMBNAILS = os.getenv("TV_THUMBNAILS", "true") == "true"
+# Frame rate of on-demand GIF previews; WebM previews get twice as many.
+PREVIEW_FPS = 12
+# Heights of the HLS renditions transcode_hls jobs make, lowest first. The
+# API queues those jobs when HLS_TRANSCODING is on. Rungs taller than a clip
+# are skipped; a clip shorter than every rung gets the lowest.
+HLS_LADDER = sorted({int(h) for h in os.getenv("HLS_LADDER", "240,360,480,720").split(",") if h.strip()})[:6]
+HLS_SEGMENT_SECONDS = 4
+HLS_AUDIO_KBPS = 96
 SILENCE_NOISE_DB = -30
 SILENCE_MIN_DURATION = 0.5
+# Sources whose audio is silent for more than this share of their length are
+# rejected as no_speech. 0 disables the check.
+MAX_SILENCE_RATIO = float(os.getenv("MAX_SILENCE_RATIO", "0.95"))
+
+# Clip extraction strategies; the API validates the one each job carries.
+DEFAULT_CLIP_STRATEGY = "auto-highlight"
+# auto-highlight keeps at most this many segments of a long source, picked
+# by loudness. 0 keeps every segment.
+HIGHLIGHT_MAX_CLIPS = int(os.getenv("HIGHLIGHT_MAX_CLIPS", "12"))
+LOUDNESS_FLOOR = -70.0
+EBUR128_LINE = re.compile(r"\bt:\s*([\d.]+)\s.*?\bM:\s*(-?[\d.]+)")
 
 # Retry parameters
 RETRY_BASE_DELAY = 30  # seconds; doubles each attempt (30s, 60s, 120s, …)
@@ -76,26 +110,37 @@ def validate_url(url: str) -> None:
     """Reject URLs targeting internal/private networks (SSRF protection)."""
     parsed = urlparse(url)
     if parsed.scheme not in ("http", "https", ""):
-        raise VideoRejected(f"Blocked URL scheme: {parsed.scheme}")
+        raise VideoRejected(f"Blocked URL scheme: {parsed.scheme}", code="blocked_url")
 
     hostname = parsed.hostname
     if not hostname:
-        raise VideoRejected(f"No hostname in URL: {url}")
+        raise VideoRejected(f"No hostname in URL: {url}", code="blocked_url")
 
     # Block obvious internal hostnames
     _blocked = {"localhost", "minio", "api", "worker", "llm", "scout", "nginx", "proxy", "web"}
     if hostname.lower() in _blocked:
-        raise VideoRejected(f"Blocked internal hostname: {hostname}")
+        raise VideoRejected(f"Blocked internal hostname: {hostname}", code="blocked_url")
 
     # Resolve and block private/reserved IP ranges
     try:
         addr = ipaddress.ip_address(hostname)
         if addr.is_private or addr.is_loopback or addr.is_link_local or addr.is_reserved:
-            raise VideoRejected(f"Blocked private/reserved IP: {hostname}")
+            raise VideoRejected(f"Blocked private/reserved IP: {hostname}", code="blocked_url")
     except ValueError:
         # Not a bare IP -- check for suspicious patterns
         if hostname.endswith(".internal") or hostname.endswith(".local"):
-            raise VideoRejected(f"Blocked internal hostname: {hostname}")
+            raise VideoRejected(f"Blocked internal hostname: {hostname}", code="blocked_url")
+
+
+def licensing_fields(metadata: dict) -> dict:
+    """Source update fields for the uploader and license yt-dlp reports.
+    Fields the platform does not expose are left out rather than blanked."""
+    fields = {
+        "uploader": metadata.get("uploader") or metadata.get("channel"),
+        "uploader_url": metadata.get("uploader_url") or metadata.get("channel_url"),
+        "license": metadata.get("license"),
+    }
+    return {k: v for k, v in fields.items() if v}
 
 
 class JobCancelled(Exception):
@@ -104,8 +149,27 @@ class JobCancelled(Exception):
 
 
 class VideoRejected(Exception):
-    """Raised for validation rejections (not transient errors) -- skips retries."""
-    pass
+    """Raised for validation rejections (not transient errors) -- skips retries.
+
+    code is one of the API's rejection reason codes (too_long, no_speech,
+    duplicate, blocked_url, other); metrics holds the measurements behind
+    the decision and duplicate_of the clip a duplicate repeats.
+    """
+
+    def __init__(self, message, code="other", metrics=None, duplicate_of=None):
+        super().__init__(message)
+        self.code = code
+        self.metrics = metrics or {}
+        self.duplicate_of = duplicate_of
+
+    def rejection(self) -> dict:
+        """The structured rejection reported in the job result."""
+        out = {"code": self.code, "message": str(self)}
+        if self.metrics:
+            out["metrics"] = self.metrics
+        if self.duplicate_of:
+            out["duplicate_of"] = self.duplicate_of
+        return out
 
 
 def signal_handler(sig, frame):
@@ -160,9 +224,15 @@ class Worker:
         from api_client import WorkerAPIClient
         if not WORKER_SECRET:
             raise ValueError("WORKER_SECRET is required")
-        self.api = WorkerAPIClient(WORKER_API_URL, WORKER_SECRET)
+        if WORKER_GRPC_ADDR:
+            from grpc_client import GRPCWorkerAPIClient
+            self.api = GRPCWorkerAPIClient(WORKER_API_URL, WORKER_GRPC_ADDR, WORKER_SECRET,
+                                           worker_id=WORKER_ID, run_id=WORKER_RUN_ID)
+        else:
+            self.api = WorkerAPIClient(WORKER_API_URL, WORKER_SECRET, worker_id=WORKER_ID, run_id=WORKER_RUN_ID)
         log.info("Worker connecting to API at %s", WORKER_API_URL)
         self.api.wait_for_api()
+        self._register_run()
         import llm_client as _llm
         _llm.set_api_client(self.api)
 
@@ -199,10 +269,11 @@ class Worker:
 
     def _pop_job(self):
         """Atomically claim one pending job. Returns dict or None."""
-        job = self.api.claim_job()
+        job = self.api.claim_job(job_types=["download", "preview", "transcode_hls"])
         if job is None:
             return None
-        return {"id": job["id"], "payload": json.dumps(job["payload"]) if isinstance(job["payload"], dict) else job["payload"]}
+        return {"id": job["id"], "job_type": job.get("job_type") or "download",
+                "payload": json.dumps(job["payload"]) if isinstance(job["payload"], dict) else job["payload"]}
 
     def run(self):
         log.info(f"Worker started (max_concurrent={MAX_CONCURRENT})")
@@ -251,8 +322,12 @@ class Worker:
                         continue
                     job_id = row["id"]
                     payload = json.loads(row["payload"])
-                    log.info(f"Claimed job {job_id}")
-                    fut = pool.submit(self.process_job, job_id, payload)
+                    log.info(f"Claimed {row['job_type']} job {job_id}")
+                    process = {
+                        "preview": self.process_preview_job,
+                        "transcode_hls": self.process_hls_job,
+                    }.get(row["job_type"], self.process_job)
+                    fut = pool.submit(self._run_traced, process, job_id, payload)
                     inflight[fut] = job_id
                 except Exception as e:
                     log.error(f"Job pop failed: {e}")
@@ -260,6 +335,15 @@ class Worker:
 
         log.info("Worker shut down")
 
+    def _run_traced(self, process, job_id: str, payload: dict):
+        """Run process on a pool thread, passing the job's traceparent on to
+        the API calls it makes."""
+        self.api.set_traceparent(payload.get("traceparent"))
+        try:
+            return process(job_id, payload)
+        finally:
+            self.api.set_traceparent(None)
+
     def _reclaim_stale_running_jobs(self) -> tuple[int, int]:
         """
         Reclaim jobs stuck in 'running' beyond JOB_STALE_MINUTES.
@@ -267,6 +351,17 @@ class Worker:
         """
         return self.api.reclaim_stale_jobs(JOB_STALE_MINUTES)
 
+    def _register_run(self):
+        """Register this run so the API requeues jobs a previous run of this
+        worker was killed holding, instead of waiting for the stale watchdog."""
+        try:
+            requeued, failed = self.api.register_run()
+        except Exception as e:
+            log.warning(f"Could not register worker run {WORKER_RUN_ID}: {e}")
+            return
+        if requeued or failed:
+            log.warning(f"Recovered jobs from this worker's previous run: requeued={requeued}, failed={failed}")
+
     def _check_cancelled(self, job_id: str):
         """Check if a job has been cancelled by the user. Raises JobCancelled if so."""
         info = self.api.get_job(job_id)
@@ -279,6 +374,7 @@ class Worker:
             source_id = payload.get("source_id")
             platform = payload.get("platform", "")
             url = payload.get("url", "")
+            strategy = payload.get("strategy") or DEFAULT_CLIP_STRATEGY
 
             self._update_source(source_id, status="downloading")
 
@@ -299,7 +395,8 @@ class Worker:
                 if source_metadata:
                     duration = source_metadata.get("duration", 0)
                     if MAX_VIDEO_DURATION > 0 and duration > MAX_VIDEO_DURATION:
-                        raise VideoRejected(f"Video too long ({duration}s, max {MAX_VIDEO_DURATION}s)")
+                        raise VideoRejected(f"Video too long ({duration}s, max {MAX_VIDEO_DURATION}s)", code="too_long",
+                                            metrics={"duration_seconds": duration, "max_duration_seconds": MAX_VIDEO_DURATION})
 
                     try:
                         self._update_source(source_id,
@@ -309,11 +406,17 @@ class Worker:
                             thumbnail_url=source_metadata.get("thumbnail"),
                             duration_seconds=source_metadata.get("duration"),
                             metadata=json.dumps(source_metadata),
+                            **licensing_fields(source_metadata),
                         )
                     except Exception as e:
                         err_str = str(e).lower()
                         if "duplicate" in err_str or "unique constraint" in err_str:
-                            # Another source already has this external_id -- skip it and continue
+                            duplicate_of = getattr(e, "duplicate_of_clip", None)
+                            if duplicate_of:
+                                raise VideoRejected(
+                                    "Already clipped from another submission of this video",
+                                    code="duplicate", duplicate_of=duplicate_of)
+                            # Another source already has this external_id but no clips -- skip it and continue
                             log.warning("Job %s: external_id %s already exists for platform %s, skipping external_id update",
                                         job_id[:8], source_metadata.get("id"), platform)
                             self._update_source(source_id,
@@ -322,6 +425,7 @@ class Worker:
                                 thumbnail_url=source_metadata.get("thumbnail"),
                                 duration_seconds=source_metadata.get("duration"),
                                 metadata=json.dumps(source_metadata),
+                                **licensing_fields(source_metadata),
                             )
                         else:
                             raise
@@ -338,6 +442,11 @@ class Worker:
                 self._check_cancelled(job_id)
                 log.info("Job %s: [step 2/4] extracting media metadata", job_id[:8])
                 media_metadata = self.extract_metadata(source_file)
+                if MAX_SILENCE_RATIO > 0:
+                    ratio = self.silence_ratio(source_file, media_metadata.get("duration", 0))
+                    if ratio is not None and ratio > MAX_SILENCE_RATIO:
+                        raise VideoRejected(f"No speech or sound ({ratio:.0%} silent)", code="no_speech",
+                                            metrics={"silence_ratio": round(ratio, 3), "max_silence_ratio": MAX_SILENCE_RATIO})
                 merged_metadata = dict(source_metadata) if source_metadata else {}
                 if media_metadata:
                     merged_metadata["media_probe"] = media_metadata
@@ -349,8 +458,10 @@ class Worker:
 
                 # Step 3: Detect scenes and split
                 self._check_cancelled(job_id)
-                log.info("Job %s: [step 3/4] detecting scenes (duration=%.1fs)", job_id[:8], media_metadata.get("duration", 0))
-                segments = self.detect_scenes(source_file, media_metadata.get("duration", 0))
+                log.info("Job %s: [step 3/4] planning segments (strategy=%s, duration=%.1fs)",
+                         job_id[:8], strategy, media_metadata.get("duration", 0))
+                segments = self.plan_segments(strategy, source_file, media_metadata.get("duration", 0), source_metadata,
+                                              repair_segments=payload.get("repair_segments"))
                 log.info("Job %s: detected %d segments", job_id[:8], len(segments))
 
                 # Step 4: Process each segment
@@ -376,12 +487,12 @@ class Worker:
                 self._update_source(source_id, status="complete")
 
                 # Mark job complete
-                self._complete_job(job_id, clip_ids)
+                self._complete_job(job_id, clip_ids, len(segments))
                 log.info("Job %s complete: %d clips created from %s", job_id[:8], len(clip_ids), url[:80])
 
             except VideoRejected as e:
-                log.info("Job %s rejected: %s", job_id[:8], e)
-                self._fail_or_reject_job(job_id, source_id, str(e), rejected=True)
+                log.info("Job %s rejected (%s): %s", job_id[:8], e.code, e)
+                self._fail_or_reject_job(job_id, source_id, str(e), rejected=True, rejection=e.rejection())
 
             except JobCancelled:
                 log.info("Job %s cancelled by user", job_id[:8])
@@ -397,6 +508,161 @@ class Worker:
         except Exception as e:
             log.error(f"Fatal error processing job {job_id}: {e}")
 
+    def process_preview_job(self, job_id: str, payload: dict):
+        """Render a short GIF or WebM preview of a range of a clip, upload it,
+        and register it with the API. Failures are final; asking for the
+        preview again queues a new job."""
+        work_path = WORK_DIR / job_id
+        work_path.mkdir(parents=True, exist_ok=True)
+        try:
+            clip_id = payload["clip_id"]
+            clip_path = work_path / "clip.mp4"
+            self.minio.fget_object(MINIO_BUCKET, payload["storage_key"], str(clip_path))
+            fmt = payload.get("format", "gif")
+            out_path = work_path / f"preview.{fmt}"
+            self._render_preview(clip_path, out_path, payload["start"], payload["end"], fmt)
+
+            key = payload["output_key"]
+            content_type = "image/gif" if fmt == "gif" else "video/webm"
+            self.minio.fput_object(MINIO_BUCKET, key, str(out_path), content_type=content_type)
+            self.api.set_preview(clip_id, payload["preview_id"], key, out_path.stat().st_size)
+            self.api.update_job(job_id, "complete", result={"storage_key": key})
+            log.info("Job %s: preview of clip %s ready (%s)", job_id[:8], clip_id, key)
+        except Exception as e:
+            log.error(f"Preview job {job_id} failed: {e}")
+            try:
+                self.api.update_job(job_id, "failed", error=str(e))
+            except Exception as update_err:
+                log.error(f"Failed to mark preview job {job_id} failed: {update_err}")
+        finally:
+            subprocess.run(["rm", "-rf", str(work_path)], check=False)
+
+    def _render_preview(self, clip_path: Path, out_path: Path, start: float, end: float, fmt: str):
+        """Cut start-end out of a clip as a silent, 480px wide GIF or WebM."""
+        if fmt == "gif":
+            # A palette made from the range itself keeps GIF colours faithful.
+            video_args = [
+                "-vf", f"fps={PREVIEW_FPS},scale=480:-1:flags=lanczos,split[a][b];[a]palettegen[p];[b][p]paletteuse",
+                "-loop", "0",
+            ]
+        else:
+            video_args = [
+                "-vf", f"fps={PREVIEW_FPS * 2},scale=480:-2",
+                "-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "35",
+            ]
+        result = subprocess.run([
+            "ffmpeg", "-y",
+            "-threads", FFMPEG_THREADS,
+            "-ss", f"{start:.3f}",
+            "-t", f"{end - start:.3f}",
+            "-i", str(clip_path),
+            *video_args,
+            "-an",
+            str(out_path),
+        ], capture_output=True, text=True, timeout=120)
+        if result.returncode != 0 or not out_path.exists():
+            raise RuntimeError(f"Preview render failed: {result.stderr[-500:]}")
+
+    def process_hls_job(self, job_id: str, payload: dict):
+        """Transcode a clip into HLS renditions at each HLS_LADDER rung it is
+        tall enough for, upload their segments and playlists under the job's
+        output prefix, and register them with the API. Failures are final;
+        the clip keeps streaming as MP4."""
+        work_path = WORK_DIR / job_id
+        work_path.mkdir(parents=True, exist_ok=True)
+        try:
+            clip_id = payload["clip_id"]
+            prefix = payload["output_prefix"].rstrip("/")
+            clip_path = work_path / "clip.mp4"
+            self.minio.fget_object(MINIO_BUCKET, payload["storage_key"], str(clip_path))
+            meta = self.extract_metadata(clip_path)
+            src_width, src_height = meta.get("width", 0), meta.get("height", 0)
+            rungs = [h for h in HLS_LADDER if h <= src_height] or HLS_LADDER[:1]
+
+            variants = [self._transcode_hls_variant(clip_path, work_path, prefix, height, src_width, src_height)
+                        for height in rungs]
+            master_path = work_path / "master.m3u8"
+            master = ["#EXTM3U", "#EXT-X-VERSION:3"]
+            for v in variants:
+                master.append(f"#EXT-X-STREAM-INF:BANDWIDTH={v['bandwidth']},CODECS=\"{v['codecs']}\"")
+                master.append(f"{v['name']}/index.m3u8")
+            master_path.write_text("\n".join(master) + "\n")
+            master_key = f"{prefix}/master.m3u8"
+            self.minio.fput_object(MINIO_BUCKET, master_key, str(master_path),
+                                   content_type="application/vnd.apple.mpegurl")
+
+            self.api.set_hls(clip_id, master_key, variants)
+            self.api.update_job(job_id, "complete",
+                                result={"master_key": master_key, "variants": [v["name"] for v in variants]})
+            log.info("Job %s: HLS renditions of clip %s ready (%s)", job_id[:8], clip_id,
+                     ", ".join(v["name"] for v in variants))
+        except Exception as e:
+            log.error(f"HLS job {job_id} failed: {e}")
+            try:
+                self.api.update_job(job_id, "failed", error=str(e))
+            except Exception as update_err:
+                log.error(f"Failed to mark HLS job {job_id} failed: {update_err}")
+        finally:
+            subprocess.run(["rm", "-rf", str(work_path)], check=False)
+
+    def _transcode_hls_variant(self, clip_path: Path, work_path: Path, prefix: str, height: int,
+                               src_width: int, src_height: int) -> dict:
+        """Transcode one HLS rendition of a clip, upload it, and describe it
+        for the API."""
+        name = f"{height}p"
+        out_dir = work_path / name
+        out_dir.mkdir(exist_ok=True)
+        playlist_path = out_dir / "index.m3u8"
+        # Roughly 2.8 Mbit/s at 720p, scaling with the pixel count.
+        video_kbps = max(200, height * height // 185)
+        result = subprocess.run([
+            "ffmpeg", "-y",
+            "-threads", FFMPEG_THREADS,
+            "-i", str(clip_path),
+            "-vf", f"scale=-2:{height}",
+            "-c:v", "libx264",
+            "-preset", "fast",
+            "-profile:v", "main",
+            "-level", "4.0",
+            "-b:v", f"{video_kbps}k",
+            "-maxrate", f"{video_kbps}k",
+            "-bufsize", f"{video_kbps * 2}k",
+            # A keyframe at every segment boundary lets players switch rungs
+            # between any two segments.
+            "-force_key_frames", f"expr:gte(t,n_forced*{HLS_SEGMENT_SECONDS})",
+            "-c:a", "aac",
+            "-b:a", f"{HLS_AUDIO_KBPS}k",
+            "-ac", "2",
+            "-f", "hls",
+            "-hls_time", str(HLS_SEGMENT_SECONDS),
+            "-hls_playlist_type", "vod",
+            "-hls_segment_filename", str(out_dir / "segment_%03d.ts"),
+            str(playlist_path),
+        ], capture_output=True, text=True, timeout=600)
+        segments = sorted(out_dir.glob("segment_*.ts"))
+        if result.returncode != 0 or not playlist_path.exists() or not segments:
+            raise RuntimeError(f"HLS transcode to {name} failed: {result.stderr[-500:]}")
+
+        segment_keys = []
+        for seg in segments:
+            key = f"{prefix}/{name}/{seg.name}"
+            self.minio.fput_object(MINIO_BUCKET, key, str(seg), content_type="video/mp2t")
+            segment_keys.append(key)
+        playlist_key = f"{prefix}/{name}/index.m3u8"
+        self.minio.fput_object(MINIO_BUCKET, playlist_key, str(playlist_path),
+                               content_type="application/vnd.apple.mpegurl")
+        width = round(src_width * height / src_height / 2) * 2 if src_width and src_height else 0
+        return {
+            "name": name,
+            "bandwidth": (video_kbps + HLS_AUDIO_KBPS) * 1000,
+            "width": width,
+            "height": height,
+            "codecs": "avc1.4d4028,mp4a.40.2",
+            "playlist_key": playlist_key,
+            "playlist": playlist_path.read_text(),
+            "segment_keys": segment_keys,
+        }
+
     # --- API helpers ---
 
     def _update_source(self, source_id, **fields):
@@ -407,15 +673,18 @@ class Worker:
         """Get decrypted platform cookie."""
         return self.api.get_cookie(source_id, platform)
 
-    def _complete_job(self, job_id, clip_ids):
+    def _complete_job(self, job_id, clip_ids, segment_count):
         """Mark a job as complete."""
         self.api.update_job(job_id, "complete",
-            result={"clip_ids": clip_ids, "clip_count": len(clip_ids)})
+            result={"clip_ids": clip_ids, "clip_count": len(clip_ids),
+                    "failed_count": max(segment_count - len(clip_ids), 0)})
 
-    def _fail_or_reject_job(self, job_id, source_id, error_msg, rejected=False):
-        """Mark a job as rejected or failed (terminal)."""
+    def _fail_or_reject_job(self, job_id, source_id, error_msg, rejected=False, rejection=None):
+        """Mark a job as rejected or failed (terminal). A rejection's
+        structured reason is stored on the job result."""
         status = "rejected" if rejected else "failed"
-        self.api.update_job(job_id, status, error=error_msg)
+        result = {"rejection": rejection} if rejection else None
+        self.api.update_job(job_id, status, error=error_msg, result=result)
         self.api.update_source(source_id, status=status)
 
     def _handle_job_error(self, job_id, source_id, error):
@@ -547,6 +816,137 @@ class Worker:
             "bitrate": int(fmt.get("bit_rate", 0)),
         }
 
+    def plan_segments(self, strategy: str, video_path: Path, total_duration: float,
+                      source_metadata: dict | None = None, repair_segments: list | None = None) -> list:
+        """Cut a source into segments with the job's clip extraction strategy.
+
+        A repair job names the segments of broken clips to re-cut instead;
+        they are kept as given, clamped to the source's length.
+        """
+        if repair_segments:
+            segments = []
+            for seg in repair_segments:
+                end = min(float(seg["end"]), total_duration) if total_duration > 0 else float(seg["end"])
+                if end > float(seg["start"]):
+                    segments.append({"start": float(seg["start"]), "end": end})
+            return segments
+        if strategy == "full":
+            return [{"start": 0, "end": round(total_duration, 2)}] if total_duration > 0 else []
+        if strategy == "fixed-interval":
+            return self._fixed_split(total_duration) or [{"start": 0, "end": total_duration}]
+        if strategy == "chapter-based":
+            segments = self._chapter_segments((source_metadata or {}).get("chapters"), total_duration)
+            if segments:
+                return segments
+            log.info("No usable chapters, falling back to auto-highlight")
+        segments = self.detect_scenes(video_path, total_duration)
+        return self._pick_highlights(video_path, segments)
+
+    def _chapter_segments(self, chapters, total_duration: float) -> list:
+        """
+        Turn yt-dlp chapter markers into segments. Chapters longer than the
+        maximum are split at the target length; ones shorter than the
+        minimum are dropped. Each segment carries its chapter title.
+        """
+        segments = []
+        for chapter in chapters or []:
+            try:
+                start = float(chapter.get("start_time") or 0)
+                end = min(float(chapter.get("end_time") or total_duration), total_duration)
+            except (AttributeError, TypeError, ValueError):
+                continue
+            if end - start < MIN_CLIP_SECONDS:
+                continue
+            if end - start <= MAX_CLIP_SECONDS:
+                pieces = [{"start": round(start, 2), "end": round(end, 2)}]
+            else:
+                pieces = [
+                    {"start": round(start + p["start"], 2), "end": round(start + p["end"], 2)}
+                    for p in self._fixed_split(end - start)
+                ]
+            title = (chapter.get("title") or "").strip()
+            for piece in pieces:
+                if title:
+                    piece["chapter"] = title
+                segments.append(piece)
+        return segments
+
+    def _pick_highlights(self, video_path: Path, segments: list) -> list:
+        """
+        Keep the HIGHLIGHT_MAX_CLIPS liveliest segments of a long source, in
+        their original order, ranked by mean momentary loudness. Every
+        segment is kept when loudness cannot be measured.
+        """
+        if HIGHLIGHT_MAX_CLIPS <= 0 or len(segments) <= HIGHLIGHT_MAX_CLIPS:
+            return segments
+        profile = self._loudness_profile(video_path)
+        if not profile:
+            return segments
+
+        def loudness(seg):
+            values = [m for t, m in profile if seg["start"] <= t < seg["end"]]
+            return sum(values) / len(values) if values else LOUDNESS_FLOOR
+
+        picked = sorted(segments, key=loudness, reverse=True)[:HIGHLIGHT_MAX_CLIPS]
+        log.info("Picked %d of %d segments as highlights", len(picked), len(segments))
+        return sorted(picked, key=lambda seg: seg["start"])
+
+    def _loudness_profile(self, video_path: Path) -> list:
+        """Momentary loudness over time as (seconds, LUFS) pairs, from one ebur128 pass."""
+        cmd = [
+            "ffmpeg", "-threads", FFMPEG_THREADS, "-nostats",
+            "-i", str(video_path),
+            "-vn", "-af", "ebur128",
+            "-f", "null", "-",
+        ]
+        try:
+            result = subprocess.run(cmd, capture_output=True, text=True, timeout=300)
+        except Exception as e:
+            log.warning(f"Loudness analysis failed, keeping every segment: {e}")
+            return []
+        profile = []
+        for line in result.stderr.split("\n"):
+            match = EBUR128_LINE.search(line)
+            if match:
+                profile.append((float(match.group(1)), max(float(match.group(2)), LOUDNESS_FLOOR)))
+        return profile
+
+    def silence_ratio(self, video_path: Path, total_duration: float) -> float | None:
+        """Share of the source's length its audio is silent, or None when it
+        can't be measured (no audio stream, no duration, ffmpeg failure)."""
+        if total_duration <= 0:
+            return None
+        try:
+            result = subprocess.run([
+                "ffmpeg", "-threads", FFMPEG_THREADS,
+                "-i", str(video_path), "-vn",
+                "-af", f"silencedetect=noise={SILENCE_NOISE_DB}dB:d={SILENCE_MIN_DURATION}",
+                "-f", "null", "-",
+            ], capture_output=True, text=True, timeout=300)
+        except Exception as e:
+            log.warning(f"Silence measurement failed: {e}")
+            return None
+        if result.returncode != 0:
+            return None
+        silent = 0.0
+        silence_start = None
+        for line in result.stderr.split("\n"):
+            if "silence_start:" in line:
+                try:
+                    silence_start = float(line.split("silence_start:")[1].strip().split()[0])
+                except (ValueError, IndexError):
+                    silence_start = None
+            elif "silence_end:" in line and silence_start is not None:
+                try:
+                    silent += float(line.split("silence_end:")[1].strip().split()[0]) - silence_start
+                except (ValueError, IndexError):
+                    pass
+                silence_start = None
+        if silence_start is not None:
+            # Silent through to the end.
+            silent += total_duration - silence_start
+        return min(max(silent / total_duration, 0.0), 1.0)
+
     def detect_scenes(self, video_path: Path, total_duration: float) -> list:
         """
         Find natural split points using audio silence detection.
@@ -751,8 +1151,9 @@ class Worker:
             log.info("Segment %d: transcoding %.1fs-%.1fs (%.1fs)", index, start, end, duration)
             self._transcode_clip(source_file, clip_path, start, duration, metadata)
 
-            # Generate thumbnail
+            # Generate thumbnail, plus alternative frames to test against it
             self._generate_thumbnail(clip_path, thumb_path)
+            candidate_paths = self._generate_thumbnail_candidates(clip_path, work_path, index, duration)
 
             # Transcribe audio
             log.info("Segment %d: transcribing audio", index)
@@ -760,7 +1161,7 @@ class Worker:
             log.info("Segment %d: transcript length=%d words", index, len(transcript.split()) if transcript else 0)
 
             # Generate a title from the transcript or source (reused for embedding context below)
-            title = self._generate_clip_title(transcript, metadata.get("title", ""), index, metadata)
+            title = self._generate_clip_title(transcript, segment.get("chapter") or metadata.get("title", ""), index, metadata)
 
             # Extract topics
             log.info("Segment %d: extracting topics via KeyBERT", index)
@@ -783,6 +1184,18 @@ class Worker:
             if thumb_path.exists():
                 self.minio.fput_object(MINIO_BUCKET, thumb_key, str(thumb_path), content_type="image/jpeg")
 
+            candidate_keys = []
+            for n, path in enumerate(candidate_paths, start=1):
+                key = f"clips/{clip_id}/thumbnail_{n}.jpg"
+                self.minio.fput_object(MINIO_BUCKET, key, str(path), content_type="image/jpeg")
+                candidate_keys.append(key)
+
+            renditions = {}
+            if DATA_SAVER_RENDITIONS:
+                renditions = self._upload_data_saver_renditions(clip_id, clip_path, thumb_path, work_path, index)
+            if TV_THUMBNAILS:
+                renditions.update(self._upload_large_thumbnail(clip_id, clip_path, work_path, index))
+
             # Probe the output clip for dimensions
             clip_meta = self.extract_metadata(clip_path)
 
@@ -817,6 +1230,18 @@ class Worker:
                 model_version="minilm-v2+clip-vit-b32",
             )
 
+            if thumb_path.exists() and candidate_keys:
+                try:
+                    self.api.set_thumbnails(clip_id, [thumb_key] + candidate_keys)
+                except Exception as e:
+                    log.warning(f"Failed to register thumbnail candidates for {clip_id}: {e}")
+
+            if renditions:
+                try:
+                    self.api.set_renditions(clip_id, **renditions)
+                except Exception as e:
+                    log.warning(f"Failed to register renditions for {clip_id}: {e}")
+
             log.info(f"Clip {clip_id} created ({duration:.1f}s, topics={topics})")
             return clip_id
 
@@ -865,6 +1290,71 @@ class Worker:
         if result.returncode != 0:
             raise RuntimeError(f"Transcode failed: {result.stderr[-500:]}")
 
+    def _upload_data_saver_renditions(
+        self, clip_id: str, clip_path: Path, thumb_path: Path, work_path: Path, index: int
+    ) -> dict:
+        """Make and upload the low-bitrate rendition and small thumbnail data
+        saver mode serves. Returns the keys of those that were made; a clip
+        without them just streams its standard rendition."""
+        keys = {}
+        low_path = work_path / f"clip_{index:04d}_low.mp4"
+        result = subprocess.run([
+            "ffmpeg", "-y",
+            "-threads", FFMPEG_THREADS,
+            "-i", str(clip_path),
+            "-vf", "scale='min(360,iw)':'min(640,ih)':force_original_aspect_ratio=decrease,pad=ceil(iw/2)*2:ceil(ih/2)*2",
+            "-c:v", "libx264",
+            "-preset", "fast",
+            "-crf", "30",
+            "-maxrate", "500k",
+            "-bufsize", "1000k",
+            "-c:a", "aac",
+            "-b:a", "64k",
+            "-movflags", "+faststart",
+            str(low_path),
+        ], capture_output=True, text=True, timeout=300)
+        if result.returncode == 0 and low_path.exists():
+            key = f"clips/{clip_id}/low.mp4"
+            self.minio.fput_object(MINIO_BUCKET, key, str(low_path), content_type="video/mp4")
+            keys["low_storage_key"] = key
+        else:
+            log.warning(f"Low-bitrate rendition failed for {clip_id}: {result.stderr[-300:]}")
+
+        if thumb_path.exists():
+            small_path = work_path / f"thumb_{index:04d}_small.jpg"
+            subprocess.run([
+                "ffmpeg", "-y",
+                "-i", str(thumb_path),
+                "-vf", "scale=160:-1",
+                "-q:v", "5",
+                str(small_path),
+            ], capture_output=True, timeout=60)
+            if small_path.exists():
+                key = f"clips/{clip_id}/thumbnail_small.jpg"
+                self.minio.fput_object(MINIO_BUCKET, key, str(small_path), content_type="image/jpeg")
+                keys["small_thumbnail_key"] = key
+        return keys
+
+    def _upload_large_thumbnail(self, clip_id: str, clip_path: Path, work_path: Path, index: int) -> dict:
+        """Make and upload the large thumbnail the TV feed shows, grabbed from
+        the clip like the default one but up to 1280 wide. Returns its key,
+        or nothing if it was not made; the TV feed then shows the default."""
+        large_path = work_path / f"thumb_{index:04d}_large.jpg"
+        subprocess.run([
+            "ffmpeg", "-y",
+            "-threads", FFMPEG_THREADS,
+            "-i", str(clip_path),
+            "-vf", "thumbnail,scale='min(1280,iw)':-2",
+            "-frames:v", "1",
+            "-q:v", "3",
+            str(large_path),
+        ], capture_output=True, timeout=60)
+        if not large_path.exists():
+            return {}
+        key = f"clips/{clip_id}/thumbnail_large.jpg"
+        self.minio.fput_object(MINIO_BUCKET, key, str(large_path), content_type="image/jpeg")
+        return {"large_thumbnail_key": key}
+
     def _generate_thumbnail(self, clip_path: Path, thumb_path: Path):
         """Generate a thumbnail from the middle of the clip."""
         cmd = [
@@ -877,6 +1367,28 @@ class Worker:
         ]
         subprocess.run(cmd, capture_output=True, timeout=60)
 
+    def _generate_thumbnail_candidates(
+        self, clip_path: Path, work_path: Path, index: int, duration: float
+    ) -> list[Path]:
+        """Grab evenly spaced frames as alternatives to the default thumbnail."""
+        extra = THUMBNAIL_CANDIDATES - 1
+        paths = []
+        for n in range(1, extra + 1):
+            path = work_path / f"thumb_{index:04d}_{n}.jpg"
+            cmd = [
+                "ffmpeg", "-y",
+                "-threads", FFMPEG_THREADS,
+                "-ss", f"{duration * n / (extra + 1):.2f}",
+                "-i", str(clip_path),
+                "-vf", "scale=480:-1",
+                "-frames:v", "1",
+                str(path),
+            ]
+            subprocess.run(cmd, capture_output=True, timeout=60)
+            if path.exists():
+                paths.append(path)
+        return paths
+
     def _transcribe(self, clip_path: Path) -> str:
         """Transcribe audio using faster-whisper."""
         try:
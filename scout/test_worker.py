@@ -6,7 +6,9 @@ Functions tested
 * _duration_fit
 * _heuristic_rank_score
 * _pick_with_caps
+* flag_duplicates
 * auto_approve
+* check_sources (source health recording)
 """
 
 import json
@@ -14,9 +16,11 @@ import sqlite3
 import sys
 import types
 import unittest
+from array import array
 from collections import defaultdict
+from datetime import datetime, timedelta, timezone
 from pathlib import Path
-from unittest.mock import MagicMock
+from unittest.mock import MagicMock, patch
 
 # worker.py does ``import llm_client`` at module level, but llm_client.py lives
 # in ingestion/ and is copied into the Docker image at build time.  For unit
@@ -27,6 +31,14 @@ if "llm_client" not in sys.modules:
     _stub.generate_summary = MagicMock(return_value=("", "stub-model", None))
     sys.modules["llm_client"] = _stub
 
+# requests is only needed to report to the API, which these tests never do.
+try:
+    import requests  # noqa: F401
+except ImportError:
+    _requests_stub = types.ModuleType("requests")
+    _requests_stub.RequestException = type("RequestException", (Exception,), {})
+    sys.modules["requests"] = _requests_stub
+
 # Allow ``import worker`` regardless of how the test is invoked.
 sys.path.insert(0, str(Path(__file__).parent))
 
@@ -36,6 +48,10 @@ from worker import (
     _pick_with_caps,
     _tokenize,
     auto_approve,
+    check_sources,
+    expire_candidates,
+    flag_duplicates,
+    MAX_CONSECUTIVE_FAILURES,
     SCOUT_MAX_LLM_PER_SOURCE,
     SCOUT_MAX_LLM_PER_CHANNEL,
 )
@@ -97,6 +113,46 @@ CREATE TABLE IF NOT EXISTS user_preferences (
 """
 
 
+# Extra tables and columns read by flag_duplicates.
+_DUPLICATE_SCHEMA = """
+ALTER TABLE scout_candidates ADD COLUMN description TEXT;
+ALTER TABLE scout_candidates ADD COLUMN duplicate_clip_id TEXT;
+ALTER TABLE scout_candidates ADD COLUMN duplicate_similarity REAL;
+
+CREATE TABLE IF NOT EXISTS clips (
+    id TEXT PRIMARY KEY,
+    status TEXT DEFAULT 'ready'
+);
+
+CREATE TABLE IF NOT EXISTS clip_embeddings (
+    clip_id TEXT PRIMARY KEY REFERENCES clips(id),
+    text_embedding BLOB
+);
+"""
+
+
+# Columns written when checking a source and recording the result.
+_HEALTH_SCHEMA = """
+ALTER TABLE scout_candidates ADD COLUMN description TEXT;
+ALTER TABLE scout_candidates ADD COLUMN view_count INTEGER;
+ALTER TABLE scout_candidates ADD COLUMN upload_date TEXT;
+ALTER TABLE scout_sources ADD COLUMN last_checked TEXT;
+ALTER TABLE scout_sources ADD COLUMN last_error TEXT;
+ALTER TABLE scout_sources ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scout_sources ADD COLUMN last_success_at TEXT;
+ALTER TABLE scout_sources ADD COLUMN next_check_at TEXT;
+ALTER TABLE scout_sources ADD COLUMN paused_reason TEXT;
+"""
+
+
+# Columns read and written when expiring candidates.
+_EXPIRY_SCHEMA = """
+ALTER TABLE scout_candidates ADD COLUMN created_at TEXT;
+ALTER TABLE scout_candidates ADD COLUMN expired_at TEXT;
+ALTER TABLE scout_candidates ADD COLUMN resurfaced_at TEXT;
+"""
+
+
 def _make_db() -> sqlite3.Connection:
     db = sqlite3.connect(":memory:", isolation_level=None)
     db.execute("PRAGMA foreign_keys=ON")
@@ -320,6 +376,134 @@ class TestPickWithCaps(unittest.TestCase):
         self.assertEqual(picked, [])
 
 
+# ---------------------------------------------------------------------------
+# flag_duplicates
+# ---------------------------------------------------------------------------
+
+class TestFlagDuplicates(unittest.TestCase):
+
+    def setUp(self):
+        self.db = _make_db()
+        self.db.executescript(_DUPLICATE_SCHEMA)
+        _seed_scout_source(self.db)
+        for clip_id, vec in (("clip-cats", [1.0, 0.0, 0.0]), ("clip-cars", [0.0, 1.0, 0.0])):
+            self.db.execute("INSERT INTO clips (id) VALUES (?)", (clip_id,))
+            self.db.execute(
+                "INSERT INTO clip_embeddings (clip_id, text_embedding) VALUES (?, ?)",
+                (clip_id, array("f", vec).tobytes()),
+            )
+        self.vectors = {
+            "Funny cats": [0.95, 0.1, 0.0],
+            "Deep sea fish": [0.0, 0.1, 1.0],
+        }
+
+    def _run(self, embed=None):
+        embed = embed or (lambda text: self.vectors.get(text))
+        with patch.multiple(
+            "worker.llm_client", create=True,
+            LLM_EMBED_MODEL="all-minilm",
+            is_available=MagicMock(return_value=True),
+            ensure_model=MagicMock(return_value=True),
+            embed=MagicMock(side_effect=embed),
+        ):
+            flag_duplicates(self.db)
+
+    def _candidate(self, cand_id):
+        return self.db.execute(
+            "SELECT duplicate_clip_id, duplicate_similarity FROM scout_candidates WHERE id = ?",
+            (cand_id,),
+        ).fetchone()
+
+    def test_similar_candidate_flagged_with_clip(self):
+        _seed_candidate(self.db, "dup", status="pending", title="Funny cats")
+        self._run()
+        row = self._candidate("dup")
+        self.assertEqual(row["duplicate_clip_id"], "clip-cats")
+        self.assertGreater(row["duplicate_similarity"], 0.9)
+
+    def test_dissimilar_candidate_checked_but_not_flagged(self):
+        _seed_candidate(self.db, "new", status="pending", title="Deep sea fish")
+        self._run()
+        row = self._candidate("new")
+        self.assertIsNone(row["duplicate_clip_id"])
+        self.assertIsNotNone(row["duplicate_similarity"])
+        self.assertLess(row["duplicate_similarity"], 0.5)
+
+    def test_failed_embedding_left_unchecked(self):
+        _seed_candidate(self.db, "c1", status="pending", title="Funny cats")
+        self._run(embed=lambda text: None)
+        self.assertIsNone(self._candidate("c1")["duplicate_similarity"])
+
+    def test_dimension_mismatch_leaves_candidates_unchecked(self):
+        _seed_candidate(self.db, "c1", status="pending", title="Funny cats")
+        self._run(embed=lambda text: [1.0, 0.0])
+        self.assertIsNone(self._candidate("c1")["duplicate_similarity"])
+
+    def test_checked_and_non_pending_candidates_skipped(self):
+        _seed_candidate(self.db, "done", status="pending", title="Funny cats")
+        self.db.execute("UPDATE scout_candidates SET duplicate_similarity = 0.1 WHERE id = 'done'")
+        _seed_candidate(self.db, "old", status="rejected", title="Funny cats")
+        embed = MagicMock(return_value=[1.0, 0.0, 0.0])
+        self._run(embed=embed)
+        embed.assert_not_called()
+
+
+# ---------------------------------------------------------------------------
+# expire_candidates
+# ---------------------------------------------------------------------------
+
+class TestExpireCandidates(unittest.TestCase):
+
+    def setUp(self):
+        self.db = _make_db()
+        self.db.executescript(_EXPIRY_SCHEMA)
+        _seed_scout_source(self.db)
+
+    def _seed(self, cand_id, days_old, status="pending", resurfaced_days_ago=None):
+        _seed_candidate(self.db, cand_id, status=status)
+        now = datetime.now(timezone.utc)
+        resurfaced = None
+        if resurfaced_days_ago is not None:
+            resurfaced = (now - timedelta(days=resurfaced_days_ago)).strftime("%Y-%m-%dT%H:%M:%SZ")
+        self.db.execute(
+            "UPDATE scout_candidates SET created_at = ?, resurfaced_at = ? WHERE id = ?",
+            ((now - timedelta(days=days_old)).strftime("%Y-%m-%dT%H:%M:%SZ"), resurfaced, cand_id),
+        )
+
+    def _status(self, cand_id):
+        return self.db.execute(
+            "SELECT status, expired_at FROM scout_candidates WHERE id = ?", (cand_id,)
+        ).fetchone()
+
+    def test_old_pending_candidate_expires(self):
+        self._seed("old", days_old=30)
+        self._seed("new", days_old=1)
+        with patch("worker.SCOUT_CANDIDATE_EXPIRY_DAYS", 14):
+            self.assertEqual(expire_candidates(self.db), 1)
+        self.assertEqual(self._status("old")["status"], "expired")
+        self.assertIsNotNone(self._status("old")["expired_at"])
+        self.assertEqual(self._status("new")["status"], "pending")
+
+    def test_only_pending_candidates_expire(self):
+        self._seed("approved", days_old=30, status="approved")
+        self._seed("rejected", days_old=30, status="rejected")
+        with patch("worker.SCOUT_CANDIDATE_EXPIRY_DAYS", 14):
+            self.assertEqual(expire_candidates(self.db), 0)
+        self.assertEqual(self._status("approved")["status"], "approved")
+
+    def test_resurfaced_candidate_ages_from_resurfacing(self):
+        self._seed("back", days_old=60, resurfaced_days_ago=2)
+        with patch("worker.SCOUT_CANDIDATE_EXPIRY_DAYS", 14):
+            expire_candidates(self.db)
+        self.assertEqual(self._status("back")["status"], "pending")
+
+    def test_zero_disables_expiry(self):
+        self._seed("old", days_old=365)
+        with patch("worker.SCOUT_CANDIDATE_EXPIRY_DAYS", 0):
+            self.assertEqual(expire_candidates(self.db), 0)
+        self.assertEqual(self._status("old")["status"], "pending")
+
+
 # ---------------------------------------------------------------------------
 # auto_approve
 # ---------------------------------------------------------------------------
@@ -434,5 +618,61 @@ class TestAutoApprove(unittest.TestCase):
         self.assertEqual(db.execute("SELECT COUNT(*) FROM jobs").fetchone()[0], 5)
 
 
+# ---------------------------------------------------------------------------
+# check_sources health
+# ---------------------------------------------------------------------------
+
+class TestCheckSourcesHealth(unittest.TestCase):
+
+    def setUp(self):
+        self.db = _make_db()
+        self.db.executescript(_HEALTH_SCHEMA)
+        self.db.execute(
+            "INSERT INTO scout_sources (id, source_type, platform, identifier) "
+            "VALUES ('ss1', 'channel', 'youtube', 'https://yt.test/@chan')"
+        )
+
+    def _source(self):
+        return self.db.execute("SELECT * FROM scout_sources WHERE id = 'ss1'").fetchone()
+
+    def _check(self, returncode=0, stdout="", stderr=""):
+        result = MagicMock(returncode=returncode, stdout=stdout, stderr=stderr)
+        with patch("worker.WORKER_SECRET", ""), patch("worker.subprocess.run", return_value=result):
+            check_sources(self.db, ["ss1"])
+
+    def test_failure_records_error_and_backs_off(self):
+        self._check(returncode=1, stderr="ERROR: channel does not exist")
+
+        row = self._source()
+        self.assertEqual(row["consecutive_failures"], 1)
+        self.assertIn("channel does not exist", row["last_error"])
+        self.assertIsNotNone(row["next_check_at"])
+        self.assertRegex(row["next_check_at"], r"^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$")
+        self.assertIsNone(row["last_success_at"])
+        self.assertEqual(row["is_active"], 1)
+
+    def test_repeated_failures_pause_source(self):
+        for _ in range(MAX_CONSECUTIVE_FAILURES):
+            self._check(returncode=1, stderr="HTTP Error 403")
+
+        row = self._source()
+        self.assertEqual(row["is_active"], 0)
+        self.assertEqual(row["consecutive_failures"], MAX_CONSECUTIVE_FAILURES)
+        self.assertIn("consecutive failed checks", row["paused_reason"])
+
+    def test_success_resets_failures(self):
+        self._check(returncode=1, stderr="boom")
+        self._check(stdout=json.dumps({"entries": [
+            {"id": "v1", "title": "One", "url": "https://yt.test/v1"},
+        ]}))
+
+        row = self._source()
+        self.assertEqual(row["consecutive_failures"], 0)
+        self.assertIsNone(row["last_error"])
+        self.assertIsNotNone(row["last_success_at"])
+        self.assertEqual(
+            self.db.execute("SELECT COUNT(*) FROM scout_candidates").fetchone()[0], 1)
+
+
 if __name__ == "__main__":
     unittest.main()
@@ -6,6 +6,7 @@ Discovers, monitors, and evaluates video content for ingestion.
 
 import json
 import logging
+import math
 import os
 import random
 import re
@@ -14,8 +15,12 @@ import sqlite3
 import subprocess
 import time
 import uuid
+from array import array
 from pathlib import Path
 from collections import defaultdict
+from datetime import datetime, timedelta, timezone
+
+import requests
 
 import llm_client
 
@@ -36,6 +41,24 @@ SCOUT_MAX_LLM_PER_CYCLE = int(os.getenv("SCOUT_MAX_LLM_PER_CYCLE", "40"))
 SCOUT_MAX_LLM_PER_SOURCE = int(os.getenv("SCOUT_MAX_LLM_PER_SOURCE", "5"))
 SCOUT_MAX_LLM_PER_CHANNEL = int(os.getenv("SCOUT_MAX_LLM_PER_CHANNEL", "3"))
 SCOUT_EXPLORATION_RATIO = float(os.getenv("SCOUT_EXPLORATION_RATIO", "0.2"))
+# Candidates whose title/description embedding is at least this similar
+# (cosine) to a library clip are flagged as likely duplicates of it.
+SCOUT_DUPLICATE_THRESHOLD = float(os.getenv("SCOUT_DUPLICATE_THRESHOLD", "0.85"))
+# Points taken off a likely duplicate's LLM score.
+SCOUT_DUPLICATE_PENALTY = float(os.getenv("SCOUT_DUPLICATE_PENALTY", "3"))
+# Pending candidates still unevaluated after this many days are marked
+# expired (0 keeps them pending forever).
+SCOUT_CANDIDATE_EXPIRY_DAYS = int(os.getenv("SCOUT_CANDIDATE_EXPIRY_DAYS", "14"))
+
+# Check results are reported to the API, which tracks source health and
+# pauses failing sources. Without a secret they are recorded directly.
+WORKER_API_URL = os.getenv("WORKER_API_URL", "http://api:8080")
+WORKER_SECRET = os.getenv("WORKER_SECRET", "")
+
+# Mirrors the API's handling of failed checks (api/scout/health.go).
+MAX_CONSECUTIVE_FAILURES = 5
+FAILURE_RETRY_BASE = timedelta(minutes=15)
+MAX_CHECK_ERROR_LEN = 500
 
 shutdown = False
 
@@ -117,6 +140,169 @@ def _pick_with_caps(
     return picked
 
 
+def _unit_vector(values) -> list[float] | None:
+    norm = math.sqrt(sum(v * v for v in values))
+    if norm == 0:
+        return None
+    return [v / norm for v in values]
+
+
+def _load_clip_embeddings(db: sqlite3.Connection) -> list[tuple[str, list[float]]]:
+    """Load normalized text embeddings (raw float32 blobs) of ready clips."""
+    rows = db.execute(
+        """
+        SELECT e.clip_id, e.text_embedding
+        FROM clip_embeddings e
+        JOIN clips c ON c.id = e.clip_id
+        WHERE c.status = 'ready' AND e.text_embedding IS NOT NULL
+        """
+    ).fetchall()
+    library = []
+    for row in rows:
+        blob = row["text_embedding"]
+        if not blob or len(blob) % 4:
+            continue
+        vec = _unit_vector(array("f", bytes(blob)))
+        if vec:
+            library.append((row["clip_id"], vec))
+    return library
+
+
+def _closest_clip(vec: list[float], library: list[tuple[str, list[float]]]) -> tuple[str | None, float]:
+    """Return the library clip with the highest cosine similarity to vec."""
+    best_id, best_sim = None, -1.0
+    for clip_id, clip_vec in library:
+        if len(clip_vec) != len(vec):
+            continue
+        sim = sum(a * b for a, b in zip(vec, clip_vec))
+        if sim > best_sim:
+            best_id, best_sim = clip_id, sim
+    return best_id, best_sim
+
+
+def flag_duplicates(db: sqlite3.Connection) -> None:
+    """Embed unchecked pending candidates via the LLM service and compare them
+    with library clip embeddings. Every checked candidate records its best
+    similarity; those at or above SCOUT_DUPLICATE_THRESHOLD also record the
+    clip they likely duplicate. Candidates that cannot be embedded stay
+    unchecked and are retried next cycle.
+    """
+    candidates = db.execute(
+        """
+        SELECT id, title, description
+        FROM scout_candidates
+        WHERE status = 'pending' AND duplicate_similarity IS NULL
+        """
+    ).fetchall()
+    if not candidates:
+        return
+
+    library = _load_clip_embeddings(db)
+    if not library:
+        return
+    if not llm_client.LLM_EMBED_MODEL or not llm_client.is_available():
+        log.info("[Scout] No embedding model available -- skipping duplicate check")
+        return
+    if not llm_client.ensure_model(llm_client.LLM_EMBED_MODEL, auto_pull=SCOUT_LLM_AUTO_PULL, task="embedding"):
+        log.info("[Scout] Embedding model %s unavailable -- skipping duplicate check", llm_client.LLM_EMBED_MODEL)
+        return
+
+    checked = flagged = 0
+    for row in candidates:
+        if shutdown:
+            return
+        text = " ".join(p for p in (row["title"], (row["description"] or "")[:500]) if p)
+        raw = llm_client.embed(text) if text else None
+        if raw is None:
+            continue
+        vec = _unit_vector(raw)
+        if vec is None:
+            continue
+        clip_id, similarity = _closest_clip(vec, library)
+        if clip_id is None:
+            log.warning("[Scout] Candidate embeddings (%d dims) do not match clip embeddings -- "
+                        "set LLM_EMBED_MODEL to the model clips are embedded with", len(vec))
+            return
+
+        duplicate = similarity >= SCOUT_DUPLICATE_THRESHOLD
+        db.execute(
+            "UPDATE scout_candidates SET duplicate_similarity = ?, duplicate_clip_id = ? WHERE id = ?",
+            (round(similarity, 4), clip_id if duplicate else None, row["id"]),
+        )
+        checked += 1
+        if duplicate:
+            flagged += 1
+            log.info("[Scout] Candidate %s likely duplicates clip %s (similarity=%.3f): %r",
+                     row["id"][:8], clip_id[:8], similarity, (row["title"] or "")[:80])
+
+    if checked:
+        log.info("[Scout] Duplicate check: checked=%d flagged=%d library=%d",
+                 checked, flagged, len(library))
+
+
+def _iso(t: datetime) -> str:
+    return t.strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _record_check_locally(db: sqlite3.Connection, source_id: str, ok: bool, error: str) -> None:
+    """Record a check result the way the API's report endpoint would."""
+    row = db.execute(
+        "SELECT consecutive_failures, check_interval_hours FROM scout_sources WHERE id = ?",
+        (source_id,),
+    ).fetchone()
+    if row is None:
+        return
+    now = datetime.now(timezone.utc)
+    interval = timedelta(hours=row["check_interval_hours"] or 24)
+    if ok:
+        db.execute(
+            """
+            UPDATE scout_sources SET last_checked = ?, last_success_at = ?, next_check_at = ?,
+                consecutive_failures = 0, last_error = NULL
+            WHERE id = ?
+            """,
+            (_iso(now), _iso(now), _iso(now + interval), source_id),
+        )
+        return
+
+    failures = (row["consecutive_failures"] or 0) + 1
+    delay = min(FAILURE_RETRY_BASE * (2 ** min(failures - 1, 16)), interval)
+    paused_reason = None
+    if failures >= MAX_CONSECUTIVE_FAILURES:
+        paused_reason = f"paused after {failures} consecutive failed checks"
+        log.warning("Scout source %s: %s", source_id[:8], paused_reason)
+    db.execute(
+        """
+        UPDATE scout_sources SET last_checked = ?, next_check_at = ?,
+            consecutive_failures = ?, last_error = ?,
+            is_active = CASE WHEN ? IS NULL THEN is_active ELSE 0 END,
+            paused_reason = COALESCE(?, paused_reason)
+        WHERE id = ?
+        """,
+        (_iso(now), _iso(now + delay), failures, (error or "check failed")[:MAX_CHECK_ERROR_LEN],
+         paused_reason, paused_reason, source_id),
+    )
+
+
+def report_check(db: sqlite3.Connection, source_id: str, ok: bool, error: str = "") -> None:
+    """Report a source check result to the API, falling back to the DB."""
+    if WORKER_SECRET:
+        try:
+            resp = requests.post(
+                f"{WORKER_API_URL}/api/internal/scout/sources/{source_id}/check",
+                json={"ok": ok, "error": error},
+                headers={"Authorization": f"Bearer {WORKER_SECRET}"},
+                timeout=10,
+            )
+            resp.raise_for_status()
+            if resp.json().get("paused"):
+                log.warning("Scout source %s paused after repeated failures", source_id[:8])
+            return
+        except (requests.RequestException, ValueError) as e:
+            log.warning("Scout check report for %s failed, recording locally: %s", source_id[:8], e)
+    _record_check_locally(db, source_id, ok, error)
+
+
 def check_sources(db: sqlite3.Connection, source_ids: list[str] | None = None) -> None:
     """Query active scout sources, run yt-dlp, insert new candidates.
     If source_ids is provided, only check those sources (bypass interval check).
@@ -136,8 +322,13 @@ def check_sources(db: sqlite3.Connection, source_ids: list[str] | None = None) -
             SELECT id, user_id, source_type, platform, identifier, check_interval_hours
             FROM scout_sources
             WHERE is_active = 1
-              AND (last_checked IS NULL
-                   OR last_checked < datetime('now', '-' || check_interval_hours || ' hours'))
+              AND CASE
+                    WHEN next_check_at IS NOT NULL
+                      THEN next_check_at <= strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+                    ELSE last_checked IS NULL
+                      OR last_checked < strftime('%Y-%m-%dT%H:%M:%SZ', 'now',
+                                                 '-' || check_interval_hours || ' hours')
+                  END
         """)
     sources = cur.fetchall()
 
@@ -216,6 +407,9 @@ def check_sources(db: sqlite3.Connection, source_ids: list[str] | None = None) -
 
         total_inserted = 0
         seen_external_ids: set[str] = set()
+        # A check fails only when none of its commands produced results.
+        succeeded = 0
+        last_error = ""
 
         for cmd in cmds:
             if shutdown:
@@ -231,6 +425,8 @@ def check_sources(db: sqlite3.Connection, source_ids: list[str] | None = None) -
                 if result.returncode != 0:
                     log.warning("yt-dlp failed for %s (cmd=%s): %s",
                                 source_id, cmd[-1][:50], result.stderr[:300])
+                    last_error = result.stderr.strip()[-MAX_CHECK_ERROR_LEN:] or (
+                        f"yt-dlp exited with status {result.returncode}")
                     continue
 
                 data = json.loads(result.stdout)
@@ -240,10 +436,13 @@ def check_sources(db: sqlite3.Connection, source_ids: list[str] | None = None) -
 
             except subprocess.TimeoutExpired:
                 log.warning("yt-dlp timed out for source %s (cmd=%s)", source_id, cmd[-1][:50])
+                last_error = "yt-dlp timed out"
                 continue
             except json.JSONDecodeError as e:
                 log.warning("yt-dlp output parse error for %s: %s", source_id, e)
+                last_error = f"yt-dlp output parse error: {e}"
                 continue
+            succeeded += 1
 
             for entry in entries:
                 if shutdown:
@@ -321,10 +520,13 @@ def check_sources(db: sqlite3.Connection, source_ids: list[str] | None = None) -
                 except sqlite3.IntegrityError:
                     continue
 
-        db.execute(
-            "UPDATE scout_sources SET last_checked = datetime('now') WHERE id = ?",
-            (source_id,),
-        )
+        if cmds and succeeded == 0:
+            report_check(db, source_id, False, last_error)
+            log.warning("Scout source %s (%s/%s): check failed: %s",
+                        source_id[:8], source_type, identifier[:40] if identifier else "",
+                        last_error[:200])
+            continue
+        report_check(db, source_id, True)
         log.info("Scout source %s (%s/%s): discovered %d new candidates across %d queries",
                  source_id[:8], source_type, identifier[:40] if identifier else "",
                  total_inserted, len(cmds))
@@ -458,12 +660,36 @@ def _build_user_profile(db: sqlite3.Connection, user_id: str) -> dict:
     }
 
 
+def expire_candidates(db: sqlite3.Connection) -> int:
+    """Mark pending candidates older than SCOUT_CANDIDATE_EXPIRY_DAYS expired.
+
+    A candidate put back in the queue ages from when it was resurfaced, not
+    from when it was discovered. Expired rows are kept so the same video is
+    not rediscovered as new.
+    """
+    if SCOUT_CANDIDATE_EXPIRY_DAYS <= 0:
+        return 0
+    now = datetime.now(timezone.utc)
+    cutoff = _iso(now - timedelta(days=SCOUT_CANDIDATE_EXPIRY_DAYS))
+    cur = db.execute(
+        """
+        UPDATE scout_candidates SET status = 'expired', expired_at = ?
+        WHERE status = 'pending' AND COALESCE(resurfaced_at, created_at) < ?
+        """,
+        (_iso(now), cutoff),
+    )
+    if cur.rowcount:
+        log.info("[Scout] Expired %d candidate(s) pending for over %d days",
+                 cur.rowcount, SCOUT_CANDIDATE_EXPIRY_DAYS)
+    return cur.rowcount
+
+
 def evaluate_candidates(db: sqlite3.Connection) -> None:
     """Score pending candidates via LLM with personalized user profiles and diversity caps."""
     if not llm_client.is_available():
         log.info("[LLM] Provider unavailable -- skipping candidate evaluation")
         return
-    if not llm_client.ensure_model(auto_pull=SCOUT_LLM_AUTO_PULL):
+    if not llm_client.ensure_model(llm_client.LLM_SCORING_MODEL, auto_pull=SCOUT_LLM_AUTO_PULL, task="scoring"):
         log.info("[LLM] Model/config unavailable -- skipping candidate evaluation")
         return
 
@@ -473,7 +699,7 @@ def evaluate_candidates(db: sqlite3.Connection) -> None:
         SELECT sc.id, sc.scout_source_id, sc.url, sc.platform, sc.external_id,
                sc.title, sc.channel_name, sc.duration_seconds,
                sc.description, sc.view_count, sc.upload_date, sc.created_at,
-               ss.user_id
+               sc.duplicate_clip_id, ss.user_id
         FROM scout_candidates sc
         JOIN scout_sources ss ON sc.scout_source_id = ss.id
         WHERE sc.status = 'pending'
@@ -536,10 +762,11 @@ def evaluate_candidates(db: sqlite3.Connection) -> None:
                  user_id[:8] if user_id != "__global__" else "global",
                  profile["profile_summary"][:120], user_threshold, len(user_cands))
 
-        # Rank and select candidates
+        # Rank and select candidates; likely duplicates rank at half weight
         ranked = sorted(
             user_cands,
-            key=lambda row: _heuristic_rank_score(row, topic_tokens, channel_seen.get(row["channel_name"] or "", 0)),
+            key=lambda row: _heuristic_rank_score(row, topic_tokens, channel_seen.get(row["channel_name"] or "", 0))
+            * (0.5 if row["duplicate_clip_id"] else 1.0),
             reverse=True,
         )
 
@@ -603,6 +830,12 @@ def evaluate_candidates(db: sqlite3.Connection) -> None:
                 total_failed += 1
                 continue
 
+            if row["duplicate_clip_id"]:
+                penalized = max(0.0, score - SCOUT_DUPLICATE_PENALTY)
+                log.info("[LLM] Candidate %s likely duplicates clip %s -- score %.1f -> %.1f",
+                         cand_id[:8], row["duplicate_clip_id"][:8], score, penalized)
+                score = penalized
+
             total_evaluated += 1
             db.execute(
                 "UPDATE scout_candidates SET llm_score = ? WHERE id = ?",
@@ -700,7 +933,7 @@ def auto_approve(db: sqlite3.Connection) -> None:
                 (
                     job_id,
                     source_id,
-                    json.dumps({"url": url, "source_id": source_id, "platform": platform}),
+                    json.dumps({"schema_version": 1, "url": url, "source_id": source_id, "platform": platform}),
                 ),
             )
             db.execute(
@@ -735,6 +968,8 @@ def process_triggers(db: sqlite3.Connection) -> bool:
              ", ".join(t[:8] for t in triggered))
 
     check_sources(db, source_ids=triggered)
+    if not shutdown:
+        flag_duplicates(db)
     if not shutdown:
         evaluate_candidates(db)
     if not shutdown:
@@ -749,7 +984,8 @@ def process_triggers(db: sqlite3.Connection) -> bool:
 def main():
     log.info(
         "Scout worker started -- interval=%ds threshold=%.1f trigger_poll=%ds "
-        "max_llm_per_cycle=%d max_per_source=%d max_per_channel=%d exploration=%.0f%% auto_pull=%s",
+        "max_llm_per_cycle=%d max_per_source=%d max_per_channel=%d exploration=%.0f%% auto_pull=%s "
+        "duplicate_threshold=%.2f candidate_expiry=%dd",
         SCOUT_INTERVAL,
         LLM_THRESHOLD,
         TRIGGER_POLL_INTERVAL,
@@ -758,6 +994,8 @@ def main():
         SCOUT_MAX_LLM_PER_CHANNEL,
         SCOUT_EXPLORATION_RATIO * 100,
         SCOUT_LLM_AUTO_PULL,
+        SCOUT_DUPLICATE_THRESHOLD,
+        SCOUT_CANDIDATE_EXPIRY_DAYS,
     )
 
     db = open_db()
@@ -777,6 +1015,10 @@ def main():
                 try:
                     log.info("Starting full scout cycle")
                     check_sources(db)
+                    if shutdown:
+                        break
+                    expire_candidates(db)
+                    flag_duplicates(db)
                     if shutdown:
                         break
                     evaluate_candidates(db)
@@ -24,6 +24,16 @@ export default function App() {
   // loaded data, and sub-tab state survive tab switches.
   const [visited, setVisited] = useState(() => new Set(['feed']));
 
+  // Maintenance mode: "full" replaces the app with a notice, "read-only"
+  // shows a banner while browsing keeps working.
+  const [maintenance, setMaintenance] = useState(null);
+
+  useEffect(() => {
+    api.getMaintenance()
+      .then((m) => setMaintenance(m.mode === 'off' ? null : m))
+      .catch(() => {});
+  }, []);
+
   // Very basic routing for hidden admin page
   const [route, setRoute] = useState(window.location.pathname);
 
@@ -57,6 +67,15 @@ export default function App() {
     );
   }
 
+  if (maintenance?.mode === 'full') {
+    return (
+      <div className="empty-state">
+        <h2>Down for maintenance</h2>
+        <p>{maintenance.message}</p>
+      </div>
+    );
+  }
+
   function handleAuth() {
     setAuthed(true);
   }
@@ -91,6 +110,9 @@ export default function App() {
 
   return (
     <>
+      {maintenance?.mode === 'read-only' && (
+        <div className="maintenance-banner" role="status">{maintenance.message}</div>
+      )}
       <Suspense fallback={null}>
         {visited.has('feed') && (
           <div style={{ display: tab !== 'feed' ? 'none' : 'block', height: '100%' }}>
@@ -371,6 +371,7 @@ export function AdminScreen({ onBack }) {
           <StatRow label="Failed" value={fmt(stats.content?.failed)} statusClass={stats.content?.failed > 0 ? 'failed' : ''} />
           <StatRow label="Expired" value={fmt(stats.content?.expired)} />
           <StatRow label="Evicted" value={fmt(stats.content?.evicted)} />
+          <StatRow label="Broken" value={fmt(stats.content?.broken)} statusClass={stats.content?.broken > 0 ? 'failed' : ''} sub="bad media" />
           <StatRow label="Storage" value={`${(stats.content?.storage_gb || 0).toFixed(2)} GB`} />
         </div>
 
@@ -378,6 +379,7 @@ export function AdminScreen({ onBack }) {
           <h3><AdminIcons.Database /> Database</h3>
           <StatRow label="Users" value={fmt(stats.database?.total_users)} />
           <StatRow label="Interactions" value={fmt(stats.database?.total_interactions)} />
+          <StatRow label="Open Interaction Flags" value={fmt(stats.database?.open_flags)} />
           <StatRow label="DB Size" value={`${(stats.database?.size_mb || 0).toFixed(2)} MB`} />
         </div>
 
@@ -397,6 +399,23 @@ export function AdminScreen({ onBack }) {
           <StatRow label="Summaries" value={fmt(stats.ai?.clip_summaries)} />
           <div className="admin-card-hint">View LLM logs &rarr;</div>
         </div>
+
+        {stats.ranking && (
+          <div className="admin-card accent-dim">
+            <h3><AdminIcons.Brain /> Ranking</h3>
+            <StatRow label="Topic Graph" value={`${fmt(stats.ranking.topic_graph?.nodes)} / ${fmt(stats.ranking.topic_graph?.edges)}`} sub="nodes / edges" />
+            <StatRow label="Canonical Merges" value={fmt(stats.ranking.topic_graph?.canonical_merges)} />
+            <StatRow
+              label="LTR Model"
+              value={stats.ranking.ltr_model?.loaded ? stats.ranking.ltr_model.version : 'none'}
+              sub={stats.ranking.ltr_model?.loaded ? `${fmt(stats.ranking.ltr_model.trees)} trees` : undefined}
+            />
+            {stats.ranking.ltr_model?.age_hours != null && (
+              <StatRow label="Model Age" value={`${stats.ranking.ltr_model.age_hours.toFixed(1)} h`} />
+            )}
+            <StatRow label="Embedded" value={`${(stats.ranking.embeddings?.coverage_pct || 0).toFixed(1)}%`} sub={`${fmt(stats.ranking.ann_index?.missing)} missing`} />
+          </div>
+        )}
       </div>
 
       {/* ── charts ── */}
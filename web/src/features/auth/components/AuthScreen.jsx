@@ -1,4 +1,4 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { api } from '../../../shared/api/clipfeedApi';
 
 export function AuthScreen({ onAuth, onSkip }) {
@@ -6,16 +6,24 @@ export function AuthScreen({ onAuth, onSkip }) {
   const [username, setUsername] = useState('');
   const [email, setEmail] = useState('');
   const [password, setPassword] = useState('');
+  const [inviteCode, setInviteCode] = useState('');
+  const [instance, setInstance] = useState(null);
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
 
+  useEffect(() => {
+    api.getMeta().then((meta) => setInstance(meta.instance || null)).catch(() => {});
+  }, []);
+
+  const registration = instance?.registration || 'open';
+
   async function handleSubmit(e) {
     e.preventDefault();
     setError('');
     setLoading(true);
     try {
       const data = mode === 'register'
-        ? await api.register(username, email, password)
+        ? await api.register(username, email, password, inviteCode)
         : await api.login(username, password);
       api.setToken(data.token);
       onAuth(data);
@@ -28,14 +36,20 @@ export function AuthScreen({ onAuth, onSkip }) {
 
   return (
     <div className="auth-screen">
-      <div className="auth-logo">Clip<span>Feed</span></div>
-      <div className="auth-subtitle">Your feed, your rules</div>
+      {instance?.logo_url && <img className="auth-logo-image" src={instance.logo_url} alt="" />}
+      {instance && instance.name !== 'ClipFeed'
+        ? <div className="auth-logo">{instance.name}</div>
+        : <div className="auth-logo">Clip<span>Feed</span></div>}
+      <div className="auth-subtitle">{instance?.description || 'Your feed, your rules'}</div>
       <form className="auth-form" onSubmit={handleSubmit}>
         <input className="auth-input" placeholder="Username" value={username} onChange={(e) => setUsername(e.target.value)} autoCapitalize="none" />
         {mode === 'register' && (
           <input className="auth-input" type="email" placeholder="Email" value={email} onChange={(e) => setEmail(e.target.value)} />
         )}
         <input className="auth-input" type="password" placeholder="Password" value={password} onChange={(e) => setPassword(e.target.value)} />
+        {mode === 'register' && registration !== 'open' && (
+          <input className="auth-input" placeholder="Invite code" value={inviteCode} onChange={(e) => setInviteCode(e.target.value.trim())} autoCapitalize="none" />
+        )}
         {error && <div className="auth-error">{error}</div>}
         <button className="auth-submit" type="submit" disabled={loading}>
           {loading ? 'Loading...' : mode === 'login' ? 'Sign In' : 'Create Account'}
@@ -45,8 +45,10 @@ export const ClipCard = React.memo(React.forwardRef(function ClipCard({
   const [streamUrl, setStreamUrl] = useState(null);
   const [showInfo, setShowInfo] = useState(false);
   const [showCollections, setShowCollections] = useState(false);
+  const [why, setWhy] = useState(null);
   const startTimeRef = useRef(null);
   const viewFiredRef = useRef(null);
+  const refreshedUrlRef = useRef(null);
 
   // Fetch stream URL -- use cached blob instantly, otherwise stream directly
   useEffect(() => {
@@ -59,7 +61,7 @@ export const ClipCard = React.memo(React.forwardRef(function ClipCard({
       return;
     }
 
-    api.getStreamUrl(clip.id)
+    api.getClipStreamUrl(clip)
       .then((data) => {
         if (cancelled || !data.url) return;
         setStreamUrl(data.url);
@@ -124,6 +126,23 @@ export const ClipCard = React.memo(React.forwardRef(function ClipCard({
     }
   }, [isActive, streamUrl, clip, onInteract]); // Do not add isMuted here!
 
+  // A stream URL that expired or was revoked mid-playback fails on the next
+  // range request. Fetch a fresh one once and resume where playback stopped.
+  const handleVideoError = useCallback(() => {
+    const video = videoRef.current;
+    if (!video || !clip || !streamUrl || streamUrl.startsWith('blob:')) return;
+    if (refreshedUrlRef.current === streamUrl) return;
+    refreshedUrlRef.current = streamUrl;
+    const resumeAt = video.currentTime;
+    api.getStreamUrl(clip.id)
+      .then((data) => {
+        if (!data?.url) return;
+        video.addEventListener('loadedmetadata', () => { video.currentTime = resumeAt; }, { once: true });
+        setStreamUrl(data.url);
+      })
+      .catch(() => {});
+  }, [clip, streamUrl]);
+
   // Dedicated effect to handle live volume toggling while playing
   useEffect(() => {
     if (videoRef.current) {
@@ -135,6 +154,11 @@ export const ClipCard = React.memo(React.forwardRef(function ClipCard({
     if (!isActive) setShowInfo(false);
   }, [isActive]);
 
+  useEffect(() => {
+    if (!showInfo || why || !clip?.id) return;
+    api.getWhy(clip.id).then((data) => setWhy(data.summary)).catch(() => {});
+  }, [showInfo, why, clip?.id]);
+
   useEffect(() => {
     const video = videoRef.current;
     if (!video) return;
@@ -206,6 +230,7 @@ export const ClipCard = React.memo(React.forwardRef(function ClipCard({
           loop 
           muted
           poster={clip.thumbnail_url || undefined}
+          onError={handleVideoError}
         />
       ) : (
         <div className="video-placeholder" />
@@ -272,6 +297,8 @@ export const ClipCard = React.memo(React.forwardRef(function ClipCard({
               <p className="clip-info-panel-desc">{clip.description}</p>
             )}
 
+            {why && <p className="clip-info-panel-why">{why}</p>}
+
             <div className="clip-info-panel-meta">
               {clip.channel_name && (
                 <div className="meta-row">
@@ -10,6 +10,7 @@ export function FeedScreen() {
   const [isGlobalMuted, setIsGlobalMuted] = useState(true);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState(null);
+  const [pacing, setPacing] = useState(null);
   const viewportRef = useRef(null);
   const cardRefs = useRef(new Map());
 
@@ -18,6 +19,7 @@ export function FeedScreen() {
     setError(null);
     api.getFeed().then((data) => {
       const loaded = data.clips || [];
+      setPacing(data.pacing || null);
       setClips(loaded);
       if (loaded.length > 0) setActiveId(String(loaded[0].id));
     }).catch((err) => {
@@ -66,17 +68,30 @@ export function FeedScreen() {
   // Preload upcoming videos into blob cache
   useEffect(() => {
     if (clips.length === 0 || activeIndex === -1) return;
+    const stale = [];
     for (let i = 1; i <= 2; i++) {
       const next = clips[activeIndex + i];
       if (next) {
         // Skip the API call entirely if already cached or fetching
         if (videoCache.getCachedUrl(next.id)) continue;
 
-        api.getStreamUrl(next.id)
-          .then(data => { if (data?.url) videoCache.preload(next.id, data.url); })
-          .catch(() => {});
+        if (next.stream_url && Date.parse(next.stream_expires_at) > Date.now() + 30000) {
+          videoCache.preload(next.id, next.stream_url);
+        } else {
+          stale.push(next.id);
+        }
       }
     }
+    // One request renews every URL the feed did not presign or that expired
+    if (stale.length > 0) {
+      api.refreshStreams(stale)
+        .then(data => {
+          for (const [id, stream] of Object.entries(data?.streams || {})) {
+            videoCache.preload(id, stream.url);
+          }
+        })
+        .catch(() => {});
+    }
   }, [activeIndex, clips]);
 
   if (loading) {
@@ -97,6 +112,20 @@ export function FeedScreen() {
     );
   }
 
+  if (pacing) {
+    const resumeAt = new Date(pacing.resume_at);
+    return (
+      <div className="empty-state">
+        <h2>Come back later</h2>
+        <p>{pacing.message}</p>
+        <p className="feed-pacing-resume">
+          Back at {resumeAt.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' })}
+        </p>
+        <button className="feed-retry-btn" onClick={loadFeed}>Check again</button>
+      </div>
+    );
+  }
+
   if (!clips.length) {
     return (
       <div className="empty-state">
@@ -189,7 +189,11 @@ function CollectionsList({ onSelect }) {
       <div className="collections-grid">
         {collections.map((col) => (
           <button key={col.id} className="collection-card" onClick={() => onSelect(col)}>
-            <div className="collection-card-icon"><Icons.Folder /></div>
+            {col.cover_url ? (
+              <img className="collection-card-cover" src={col.cover_url} alt="" loading="lazy" />
+            ) : (
+              <div className="collection-card-icon"><Icons.Folder /></div>
+            )}
             <div className="collection-card-info">
               <div className="collection-card-title">{col.title}</div>
               <div className="collection-card-count">
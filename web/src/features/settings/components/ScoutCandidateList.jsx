@@ -4,11 +4,12 @@ import { showToast } from '../../../shared/ui/toast';
 import { Tabs } from '../../../shared/ui/Tabs';
 import { timeAgo, truncate } from '../../../shared/utils/formatters';
 
-const TABS = ['pending', 'ingested', 'rejected'];
+const TABS = ['pending', 'ingested', 'rejected', 'expired'];
 const EMPTY_MSG = {
   pending: 'No pending candidates. Trigger a source check to discover new ones.',
   ingested: 'No ingested candidates yet.',
   rejected: 'No rejected candidates.',
+  expired: 'No expired candidates.',
 };
 
 export function ScoutCandidateList() {
@@ -115,12 +116,18 @@ export function ScoutCandidateList() {
                   )}
                   <span>{timeAgo(c.created_at)}</span>
                 </div>
+                {c.duplicate_of && (
+                  <div className="scout-candidate-duplicate">
+                    Likely duplicate of {truncate(c.duplicate_of.title || 'a library clip', 40)}
+                    {c.duplicate_of.similarity != null && ` (${Math.round(c.duplicate_of.similarity * 100)}% similar)`}
+                  </div>
+                )}
               </div>
               <div className="scout-candidate-right">
                 <span className={`scout-score-badge ${scoreBadgeClass(c.status)}`}>
                   {c.llm_score != null ? Number(c.llm_score).toFixed(1) : '–'}
                 </span>
-                {(tab === 'rejected' || tab === 'pending') && c.status !== 'ingested' && (
+                {(tab === 'rejected' || tab === 'pending' || tab === 'expired') && c.status !== 'ingested' && (
                   <button
                     className="scout-ingest-btn"
                     onClick={(e) => { e.stopPropagation(); handleApprove(c.id); }}
@@ -6,7 +6,9 @@ import { ScoutSourceCard } from './ScoutSourceCard';
 import { AddScoutSourceForm } from './AddScoutSourceForm';
 import { ScoutCandidateList } from './ScoutCandidateList';
 
-export function ScoutScreen({ onBack, threshold, onThresholdChange, autoIngest, onAutoIngestChange }) {
+export function ScoutScreen({
+  onBack, threshold, onThresholdChange, autoIngest, onAutoIngestChange, resurfaceExpired, onResurfaceExpiredChange,
+}) {
   const [activeTab, setActiveTab] = useState('config');
   const [sources, setSources] = useState([]);
   const [loading, setLoading] = useState(true);
@@ -156,6 +158,21 @@ export function ScoutScreen({ onBack, threshold, onThresholdChange, autoIngest,
                 <span>Only the best</span>
               </div>
             </div>
+
+            <div className="setting-row">
+              <div className="setting-label-group">
+                <span className="setting-label">Revisit expired candidates</span>
+                <span className="setting-sublabel">
+                  Raising the threshold re-scores candidates that expired unreviewed
+                </span>
+              </div>
+              <button
+                className={`toggle-switch ${resurfaceExpired ? 'on' : ''}`}
+                onClick={() => onResurfaceExpiredChange(!resurfaceExpired)}
+              >
+                <div className="toggle-knob" />
+              </button>
+            </div>
           </div>
 
           <div className="scout-section">
@@ -29,11 +29,12 @@ export function ScoutSourceCard({ source, onUpdate, onDelete, onTrigger }) {
         <span className="scout-source-id" title={source.identifier}>{source.identifier}</span>
       </div>
 
-      {(counts.pending > 0 || counts.ingested > 0 || counts.rejected > 0) && (
+      {(counts.pending > 0 || counts.ingested > 0 || counts.rejected > 0 || counts.expired > 0) && (
         <div className="scout-source-stats">
           {counts.pending > 0 && <span className="scout-stat pending">{counts.pending} pending</span>}
           {counts.ingested > 0 && <span className="scout-stat ingested">{counts.ingested} ingested</span>}
           {counts.rejected > 0 && <span className="scout-stat rejected">{counts.rejected} rejected</span>}
+          {counts.expired > 0 && <span className="scout-stat expired">{counts.expired} expired</span>}
         </div>
       )}
 
@@ -7,10 +7,14 @@ import { TopicWeights } from './TopicWeights';
 import { ScoutScreen } from './ScoutScreen';
 import '../scout.css';
 
+// Preferences a ranking preset sets; changing one by hand leaves the preset.
+const PRESET_KEYS = ['exploration_rate', 'diversity_mix', 'freshness_bias', 'trending_boost'];
+
 export function SettingsScreen({ onLogout }) {
   const { canInstall, showIOSGuide, installed, promptInstall } = useInstallPrompt();
   const [subscreen, setSubscreen] = useState(null);
   const [aiEnabled, setAiEnabled] = useState(false);
+  const [presets, setPresets] = useState([]);
 
   const debouncedSave = useRef(null);
 
@@ -23,9 +27,13 @@ export function SettingsScreen({ onLogout }) {
     topic_weights: {},
     scout_threshold: 6.0,
     scout_auto_ingest: true,
+    scout_resurface_expired: false,
     diversity_mix: 0.5,
     trending_boost: true,
     freshness_bias: 0.5,
+    lock_topic_affinities: false,
+    ranking_preset: null,
+    data_saver: false,
   });
 
   useEffect(() => {
@@ -39,10 +47,26 @@ export function SettingsScreen({ onLogout }) {
     api.getConfig()
       .then((data) => setAiEnabled(!!data.ai_enabled))
       .catch(() => { /* non-critical */ });
+    api.getRankingPresets()
+      .then((data) => setPresets(data.presets || []))
+      .catch(() => { /* non-critical */ });
   }, []);
 
   function handleChange(key, value) {
     const updated = { ...prefs, [key]: value };
+    if (PRESET_KEYS.includes(key)) {
+      updated.ranking_preset = null;
+    }
+    savePrefs(updated);
+  }
+
+  function applyPreset(preset) {
+    const updated = { ...prefs, ranking_preset: preset.name };
+    PRESET_KEYS.forEach((key) => { updated[key] = preset[key]; });
+    savePrefs(updated);
+  }
+
+  function savePrefs(updated) {
     setPrefs(updated);
     // Debounce the API write -- sliders fire onChange on every pixel of drag,
     // so we wait until the user pauses before persisting.
@@ -60,6 +84,8 @@ export function SettingsScreen({ onLogout }) {
         onThresholdChange={(v) => handleChange('scout_threshold', v)}
         autoIngest={prefs.scout_auto_ingest}
         onAutoIngestChange={(v) => handleChange('scout_auto_ingest', v)}
+        resurfaceExpired={prefs.scout_resurface_expired}
+        onResurfaceExpiredChange={(v) => handleChange('scout_resurface_expired', v)}
       />
     );
   }
@@ -134,6 +160,33 @@ export function SettingsScreen({ onLogout }) {
       <div className="settings-section">
         <h3>Algorithm</h3>
 
+        {presets.length > 0 && (
+          <div className="slider-row">
+            <div className="slider-header">
+              <span className="slider-label">Preset</span>
+              <span className="slider-value">
+                {presets.find((p) => p.name === prefs.ranking_preset)?.label || 'Custom'}
+              </span>
+            </div>
+            <div className="preset-options">
+              {presets.map((p) => (
+                <button
+                  key={p.name}
+                  className={`preset-option ${prefs.ranking_preset === p.name ? 'active' : ''}`}
+                  onClick={() => applyPreset(p)}
+                  title={p.description}
+                >
+                  {p.label}
+                </button>
+              ))}
+            </div>
+            <div className="slider-description">
+              {presets.find((p) => p.name === prefs.ranking_preset)?.description
+                || 'Your own mix of the settings below'}
+            </div>
+          </div>
+        )}
+
         <div className="slider-row">
           <div className="slider-header">
             <span className="slider-label">Feed Diversity</span>
@@ -196,6 +249,25 @@ export function SettingsScreen({ onLogout }) {
           currentWeights={prefs.topic_weights}
           onWeightsChange={(tw) => setPrefs((prev) => ({ ...prev, topic_weights: tw }))}
         />
+
+        <div className="setting-row">
+          <div className="setting-label-group">
+            <span className="setting-label">Lock my preferences</span>
+            <span className="setting-sublabel">
+              {prefs.lock_topic_affinities
+                ? 'Learned interests are kept as they are'
+                : prefs.topic_affinities_decayed_at
+                  ? `Older interests fade over time (last ${new Date(prefs.topic_affinities_decayed_at).toLocaleDateString()})`
+                  : 'Older interests fade over time'}
+            </span>
+          </div>
+          <button
+            className={`toggle-switch ${prefs.lock_topic_affinities ? 'on' : ''}`}
+            onClick={() => handleChange('lock_topic_affinities', !prefs.lock_topic_affinities)}
+          >
+            <div className="toggle-knob" />
+          </button>
+        </div>
       </div>
 
       {aiEnabled && (
@@ -223,6 +295,18 @@ export function SettingsScreen({ onLogout }) {
             <div className="toggle-knob" />
           </button>
         </div>
+        <div className="setting-row">
+          <div className="setting-label-group">
+            <span className="setting-label">Data saver</span>
+            <span className="setting-sublabel">Lower-quality video and smaller thumbnails</span>
+          </div>
+          <button
+            className={`toggle-switch ${prefs.data_saver ? 'on' : ''}`}
+            onClick={() => handleChange('data_saver', !prefs.data_saver)}
+          >
+            <div className="toggle-knob" />
+          </button>
+        </div>
       </div>
 
       <div className="settings-section">
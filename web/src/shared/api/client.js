@@ -52,10 +52,29 @@ export function clearToken() {
   }
 }
 
+// Stable per-browser ID the API uses to tell devices apart when an account
+// has a concurrent stream limit.
+const DEVICE_ID_KEY = 'clipfeed_device_id';
+
+function getDeviceId() {
+  try {
+    let id = localStorage.getItem(DEVICE_ID_KEY);
+    if (!id) {
+      id = crypto.randomUUID();
+      localStorage.setItem(DEVICE_ID_KEY, id);
+    }
+    return id;
+  } catch {
+    return null;
+  }
+}
+
 export async function request(method, path, body = null, { token: overrideToken } = {}) {
   const headers = { 'Content-Type': 'application/json' };
   const token = overrideToken || getToken();
   if (token) headers.Authorization = `Bearer ${token}`;
+  const deviceId = getDeviceId();
+  if (deviceId) headers['X-Device-ID'] = deviceId;
 
   const controller = new AbortController();
   const timeoutId = setTimeout(() => controller.abort(), 30000);
@@ -84,3 +103,24 @@ export async function request(method, path, body = null, { token: overrideToken
     clearTimeout(timeoutId);
   }
 }
+
+// Opens the sync socket, which pushes feed updates such as new clips the
+// user will like. The token goes in the first message rather than the URL
+// so it stays out of server logs. Returns the WebSocket, or null when
+// signed out.
+export function openSyncSocket(onMessage) {
+  const token = getToken();
+  if (!token) return null;
+  const url = new URL(`${API_BASE}/me/sync`, window.location.href);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(url);
+  ws.addEventListener('open', () => ws.send(JSON.stringify({ type: 'auth', token })));
+  ws.addEventListener('message', (event) => {
+    try {
+      onMessage(JSON.parse(event.data));
+    } catch {
+      // Ignore malformed messages.
+    }
+  });
+  return ws;
+}
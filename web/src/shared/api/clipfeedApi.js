@@ -1,21 +1,49 @@
-import { clearToken, getToken, request, setToken } from './client';
+import { clearToken, getToken, openSyncSocket, request, setToken } from './client';
 
 export const api = {
   getToken,
   setToken,
   clearToken,
 
-  register: (username, email, password) =>
-    request('POST', '/auth/register', { username, email, password }),
+  register: (username, email, password, inviteCode) =>
+    request('POST', '/auth/register', { username, email, password, invite_code: inviteCode || undefined }),
 
   login: (username, password) =>
     request('POST', '/auth/login', { username, password }),
 
-  getFeed: () => request('GET', '/feed'),
+  getFeed: () => request('GET', '/feed?include_stream=true'),
+  getTVFeed: (page) => request('GET', `/feed/tv${page ? `?page=${encodeURIComponent(page)}` : ''}`),
+  getQueue: (queueId, { fresh = false } = {}) => {
+    const params = new URLSearchParams();
+    if (queueId) params.set('queue', queueId);
+    if (fresh) params.set('fresh', 'true');
+    const qs = params.toString();
+    return request('GET', `/feed/queue${qs ? `?${qs}` : ''}`);
+  },
+  advanceQueue: (queueId, clipId) => request('POST', '/queue/advance', { queue_id: queueId, clip_id: clipId }),
+  getRankingPresets: () => request('GET', '/feed/presets'),
 
   getClip: (id) => request('GET', `/clips/${id}`),
+  createClipPreview: (id, start, end, format = 'gif') =>
+    request('POST', `/clips/${id}/gif?${new URLSearchParams({ start, end, format })}`),
+  getClipPreview: (id, previewId) => request('GET', `/clips/${id}/gif/${previewId}`),
+  getTranscript: (id) => request('GET', `/clips/${id}/transcript`),
 
   getStreamUrl: (id) => request('GET', `/clips/${id}/stream`),
+  getWhy: (id) => request('GET', `/clips/${id}/why`),
+
+  // Uses the stream URL the feed presigned for a leading clip while it is
+  // still fresh, otherwise asks the stream endpoint.
+  getClipStreamUrl: (clip) => {
+    if (clip.stream_url && Date.parse(clip.stream_expires_at) > Date.now() + 30000) {
+      return Promise.resolve({ url: clip.stream_url });
+    }
+    return request('GET', `/clips/${clip.id}/stream`);
+  },
+
+  // Reissues stream URLs for several clips in one request. Returns
+  // { streams: { [clipId]: { url, expires_at, refresh_after } } }.
+  refreshStreams: (clipIds) => request('POST', '/streams/refresh', { clip_ids: clipIds }),
 
   interact: (clipId, action, watchDuration = 0, watchPercentage = 0) =>
     request('POST', `/clips/${clipId}/interact`, {
@@ -24,6 +52,11 @@ export const api = {
       watch_percentage: watchPercentage,
     }),
 
+  // direction is 'more' or 'less'; dimension is 'topic', 'channel', or
+  // 'format'. Returns the weights that changed.
+  clipFeedback: (clipId, direction, dimension) =>
+    request('POST', `/clips/${clipId}/feedback`, { direction, dimension }),
+
   saveClip: (id) => request('POST', `/clips/${id}/save`),
   unsaveClip: (id) => request('DELETE', `/clips/${id}/save`),
 
@@ -32,6 +65,16 @@ export const api = {
   getProfile: () => request('GET', '/me'),
   getTopics: () => request('GET', '/topics'),
   updatePreferences: (prefs) => request('PUT', '/me/preferences', prefs),
+  getPacing: () => request('GET', '/me/pacing'),
+  updatePacing: (pacing) => request('PUT', '/me/pacing', pacing),
+  previewAffinities: (changes, limit) => request('POST', '/me/affinities/preview', { changes, limit }),
+  exportSettings: () => request('GET', '/me/settings-bundle'),
+  importSettings: (bundle, { mode = 'merge', dryRun = false } = {}) => {
+    const params = new URLSearchParams({ mode });
+    if (dryRun) params.set('dry_run', 'true');
+    return request('PUT', `/me/settings-bundle?${params}`, bundle);
+  },
+  openSync: (onMessage) => openSyncSocket(onMessage),
   getSaved: () => request('GET', '/me/saved'),
   getHistory: () => request('GET', '/me/history'),
 
@@ -40,7 +83,13 @@ export const api = {
   retryJob: (id) => request('POST', `/jobs/${id}/retry`),
   dismissJob: (id) => request('DELETE', `/jobs/${id}`),
 
-  search: (q) => request('GET', `/search?q=${encodeURIComponent(q)}`),
+  search: (q, { collectionId, channel, collapse } = {}) => {
+    const params = new URLSearchParams({ q });
+    if (collectionId) params.set('collection_id', collectionId);
+    if (channel) params.set('channel', channel);
+    if (collapse === false) params.set('collapse', 'false');
+    return request('GET', `/search?${params}`);
+  },
 
   setCookie: (platform, cookieStr) =>
     request('PUT', `/me/cookies/${platform}`, { cookie_str: cookieStr }),
@@ -52,6 +101,8 @@ export const api = {
     request('DELETE', `/me/cookies/${platform}`),
 
   getConfig: () => request('GET', '/config'),
+  getMeta: () => request('GET', '/meta'),
+  getMaintenance: () => request('GET', '/maintenance'),
 
   // Scout
   getScoutSources: () => request('GET', '/scout/sources'),
@@ -74,10 +125,15 @@ export const api = {
     request('POST', `/collections/${collectionId}/clips`, { clip_id: clipId }),
   removeFromCollection: (collectionId, clipId) =>
     request('DELETE', `/collections/${collectionId}/clips/${clipId}`),
+  getCollectionSuggestions: () => request('GET', '/collections/suggestions'),
+  acceptCollectionSuggestion: (id, title) =>
+    request('POST', `/collections/suggestions/${id}/accept`, title ? { title } : null),
+  dismissCollectionSuggestion: (id) => request('DELETE', `/collections/suggestions/${id}`),
 
   // Admin
   adminLogin: (username, password) => request('POST', '/admin/login', { username, password }),
   getAdminStatus: (token) => request('GET', '/admin/status', null, { token }),
+  getAdminMe: (token) => request('GET', '/admin/me', null, { token }),
   getAdminLLMLogs: (token) => request('GET', '/admin/llm_logs', null, { token }),
   clearFailedJobs: (token) => request('POST', '/admin/clear-failed', null, { token }),
 };